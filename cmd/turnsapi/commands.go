@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/api"
+	"turnsapi/internal/logger"
+	"turnsapi/internal/logging"
+	"turnsapi/internal/proxykey"
+)
+
+// command 管理子命令，直接读写配置文件和数据库，不经过管理接口
+// 服务运行时修改的代理密钥需重启后生效，导入的分组可通过 POST /admin/groups/reload 热加载
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"keys list", "列出代理密钥", runKeysList},
+	{"keys add", "创建代理密钥并输出明文密钥", runKeysAdd},
+	{"groups import", "从分组配置包（YAML或JSON）导入分组", runGroupsImport},
+	{"logs export", "导出请求日志（CSV或NDJSON）", runLogsExport},
+	{"validate-config", "校验配置文件", runValidateConfig},
+}
+
+// runCommand 执行命令行参数指定的子命令，没有子命令时返回 false 继续启动服务
+func runCommand(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == cmd.name {
+			// 子命令只输出警告和错误日志，结果写到标准输出
+			logging.Setup(logging.Options{Level: "warn"}, os.Stderr)
+			return true, cmd.run(args[len(words):])
+		}
+	}
+	return true, fmt.Errorf("unknown command %q\n\n%s", strings.Join(args, " "), commandUsage())
+}
+
+// commandUsage 返回子命令列表
+func commandUsage() string {
+	var b strings.Builder
+	b.WriteString("子命令:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "  %-16s %s\n", cmd.name, cmd.summary)
+	}
+	b.WriteString("\n使用 turnsapi <子命令> -h 查看子命令的选项")
+	return b.String()
+}
+
+// newCommandFlags 创建子命令的选项，-config 和 -db 与启动服务时的含义相同，也可以写在子命令之前
+func newCommandFlags(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet("turnsapi "+name, flag.ContinueOnError)
+	fs.StringVar(configPath, "config", *configPath, "配置文件路径")
+	fs.StringVar(dbPath, "db", *dbPath, "数据库文件路径")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: turnsapi %s [选项] %s\n\n选项:\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// openRequestLogger 打开配置中的日志和代理密钥存储
+func openRequestLogger() (*logger.RequestLogger, error) {
+	config, err := internal.LoadConfig(*configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	return logger.NewRequestLoggerWithDriver(config.LogStorage())
+}
+
+// splitList 解析逗号分隔的列表，忽略空项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseCommandTime 解析命令行中的时间，支持 RFC3339、2006-01-02 15:04:05 和 2006-01-02
+func parseCommandTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid time %q, expected RFC3339 or 2006-01-02", value)
+}
+
+// runKeysList 列出代理密钥，table 格式不显示密钥本身，json 和 csv 与管理接口的导出格式相同
+func runKeysList(args []string) error {
+	fs := newCommandFlags("keys list", "")
+	format := fs.String("format", "table", "输出格式：table、json、csv")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	requestLogger, err := openRequestLogger()
+	if err != nil {
+		return err
+	}
+	defer requestLogger.Close()
+	manager := proxykey.NewManagerWithDB(requestLogger)
+	defer manager.Close()
+
+	keys := manager.GetAllKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(keys)
+	case "csv":
+		return proxykey.WriteKeysCSV(os.Stdout, keys)
+	case "table":
+	default:
+		return fmt.Errorf("unsupported format %q, expected table, json or csv", *format)
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTATUS\tGROUPS\tUSAGE\tEXPIRES\tCREATED")
+	for _, key := range keys {
+		status := key.InactiveReason(now)
+		if status == "" {
+			status = "active"
+		}
+		groups := strings.Join(key.AllowedGroups, ",")
+		if groups == "" {
+			groups = "*"
+		}
+		usage := fmt.Sprintf("%d", key.UsageCount)
+		if key.MaxUsageCount > 0 {
+			usage = fmt.Sprintf("%d/%d", key.UsageCount, key.MaxUsageCount)
+		}
+		expires := "-"
+		if key.ExpiresAt != nil {
+			expires = key.ExpiresAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, status, groups, usage, expires,
+			key.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return w.Flush()
+}
+
+// runKeysAdd 创建代理密钥，明文密钥只在此时输出一次
+func runKeysAdd(args []string) error {
+	fs := newCommandFlags("keys add", "")
+	name := fs.String("name", "", "密钥名称（必填）")
+	description := fs.String("description", "", "描述")
+	groups := fs.String("groups", "", "允许访问的分组ID，逗号分隔，为空表示全部分组")
+	models := fs.String("models", "", "允许请求的模型，逗号分隔，支持通配符，为空表示不限制")
+	expires := fs.String("expires", "", "过期时间（RFC3339 或 2006-01-02）")
+	maxUsage := fs.Int64("max-usage", 0, "最大使用次数，0表示不限制")
+	sealed := fs.Bool("sealed", false, "只保存密钥哈希，之后无法再查看明文")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" {
+		fs.Usage()
+		return fmt.Errorf("-name is required")
+	}
+	expiresAt, err := parseCommandTime(*expires)
+	if err != nil {
+		return err
+	}
+
+	configManager, err := internal.NewConfigManager(*configPath, *dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	defer configManager.Close()
+	allowedGroups := splitList(*groups)
+	for _, groupID := range allowedGroups {
+		if _, exists := configManager.GetGroup(groupID); !exists {
+			return fmt.Errorf("group not found: %s", groupID)
+		}
+	}
+
+	requestLogger, err := logger.NewRequestLoggerWithDriver(configManager.GetConfig().LogStorage())
+	if err != nil {
+		return err
+	}
+	defer requestLogger.Close()
+	manager := proxykey.NewManagerWithConfig(requestLogger, enabledGroupsProvider{configManager})
+	defer manager.Close()
+
+	results, err := manager.ImportKeys([]proxykey.KeySpec{{
+		Name:          *name,
+		Description:   *description,
+		AllowedGroups: allowedGroups,
+		AllowedModels: splitList(*models),
+		ExpiresAt:     expiresAt,
+		MaxUsageCount: *maxUsage,
+	}}, *sealed)
+	if len(results) == 1 && results[0].Error != "" {
+		return fmt.Errorf("%s", results[0].Error)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "已创建代理密钥 %s（%s），服务运行中时需重启后生效\n", results[0].Name, results[0].ID)
+	fmt.Println(results[0].Key)
+	return nil
+}
+
+// enabledGroupsProvider 以配置管理器中启用的分组校验分组选择配置
+type enabledGroupsProvider struct {
+	configManager *internal.ConfigManager
+}
+
+func (p enabledGroupsProvider) GetEnabledGroups() map[string]interface{} {
+	groups := make(map[string]interface{})
+	for groupID := range p.configManager.GetEnabledGroups() {
+		groups[groupID] = struct{}{}
+	}
+	return groups
+}
+
+// runGroupsImport 从分组配置包导入分组，校验规则与管理接口相同，任一分组无效时整包拒绝
+func runGroupsImport(args []string) error {
+	fs := newCommandFlags("groups import", "<bundle.yaml|bundle.json|->")
+	dryRun := fs.Bool("dry-run", false, "只校验并输出处理计划，不修改数据库")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("exactly one bundle file is required")
+	}
+
+	var data []byte
+	var err error
+	if fs.Arg(0) == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(fs.Arg(0))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+
+	configManager, err := internal.NewConfigManager(*configPath, *dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	defer configManager.Close()
+
+	plan, importErr := api.ImportGroupBundle(configManager, data, *dryRun)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, item := range plan {
+		if item.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\terror: %s\n", item.GroupID, item.Action, item.Error)
+		} else {
+			fmt.Fprintf(w, "%s\t%s\n", item.GroupID, item.Action)
+		}
+	}
+	w.Flush()
+	if importErr != nil {
+		return importErr
+	}
+	if *dryRun {
+		fmt.Fprintln(os.Stderr, "dry run：未修改数据库")
+	} else {
+		fmt.Fprintln(os.Stderr, "导入完成，服务运行中时可调用 POST /admin/groups/reload 加载导入的分组")
+	}
+	return nil
+}
+
+// runLogsExport 流式导出请求日志，格式与管理接口的 /admin/logs/export 相同
+func runLogsExport(args []string) error {
+	fs := newCommandFlags("logs export", "")
+	format := fs.String("format", "ndjson", "导出格式：csv（摘要字段）或 ndjson（完整日志）")
+	output := fs.String("o", "-", "输出文件，- 表示标准输出")
+	filter := &logger.LogFilter{}
+	fs.StringVar(&filter.ProxyKeyName, "key", "", "代理密钥名称")
+	fs.StringVar(&filter.ProviderGroup, "group", "", "提供商分组")
+	fs.StringVar(&filter.Model, "model", "", "模型")
+	fs.StringVar(&filter.Status, "status", "", "状态：200 或 error")
+	fs.IntVar(&filter.Limit, "limit", 0, "最多导出的条数，0表示不限制")
+	start := fs.String("start", "", "开始时间（RFC3339 或 2006-01-02）")
+	end := fs.String("end", "", "结束时间（RFC3339 或 2006-01-02）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "csv" && *format != "ndjson" {
+		return fmt.Errorf("unsupported format %q, expected csv or ndjson", *format)
+	}
+	var err error
+	if filter.StartTime, err = parseCommandTime(*start); err != nil {
+		return err
+	}
+	if filter.EndTime, err = parseCommandTime(*end); err != nil {
+		return err
+	}
+
+	requestLogger, err := openRequestLogger()
+	if err != nil {
+		return err
+	}
+	defer requestLogger.Close()
+
+	out := os.Stdout
+	if *output != "-" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	var csvw *csv.Writer
+	var encoder *json.Encoder
+	if *format == "csv" {
+		csvw = csv.NewWriter(out)
+		if err := csvw.Write(logger.ExportCSVHeader); err != nil {
+			return err
+		}
+	} else {
+		encoder = json.NewEncoder(out)
+		encoder.SetEscapeHTML(false)
+	}
+
+	count := 0
+	err = requestLogger.StreamRequestLogsForExport(filter, func(l *logger.RequestLog) error {
+		count++
+		if csvw != nil {
+			return csvw.Write(logger.ExportCSVRecord(l))
+		}
+		return encoder.Encode(l)
+	})
+	if csvw != nil {
+		csvw.Flush()
+		if err == nil {
+			err = csvw.Error()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("export interrupted after %d logs: %w", count, err)
+	}
+	fmt.Fprintf(os.Stderr, "已导出 %d 条日志\n", count)
+	return nil
+}
+
+// runValidateConfig 校验配置文件并列出全部问题，不读取数据库中的分组
+func runValidateConfig(args []string) error {
+	fs := newCommandFlags("validate-config", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := internal.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("配置文件 %s:\n", *configPath)
+	return reportValidation(config)
+}
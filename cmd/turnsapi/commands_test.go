@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"turnsapi/internal/proxykey"
+)
+
+// newCommandTestConfig 在临时目录写入配置文件，返回指定配置和数据库路径的子命令参数
+func newCommandTestConfig(t *testing.T) []string {
+	t.Helper()
+	oldConfigPath, oldDBPath := *configPath, *dbPath
+	t.Cleanup(func() { *configPath, *dbPath = oldConfigPath, oldDBPath })
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := "server:\n  port: \"8080\"\ndatabase:\n  path: " + filepath.Join(dir, "logs.db") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+	return []string{"-config", path, "-db", filepath.Join(dir, "turnsapi.db")}
+}
+
+// captureStdout 执行子命令并返回其标准输出
+func captureStdout(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("创建管道失败: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	_, runErr := runCommand(args)
+	w.Close()
+	return <-output, runErr
+}
+
+// TestRunCommand 测试没有子命令时继续启动服务，未知子命令返回错误并列出可用的子命令
+func TestRunCommand(t *testing.T) {
+	if handled, err := runCommand(nil); handled || err != nil {
+		t.Errorf("没有子命令时应继续启动服务，handled=%t err=%v", handled, err)
+	}
+	handled, err := runCommand([]string{"keys", "remove"})
+	if !handled || err == nil || !strings.Contains(err.Error(), "keys add") {
+		t.Errorf("未知子命令应返回包含子命令列表的错误，handled=%t err=%v", handled, err)
+	}
+}
+
+// TestKeysAddAndList 测试创建代理密钥后输出明文密钥，列表中可以看到新建的密钥
+func TestKeysAddAndList(t *testing.T) {
+	flags := newCommandTestConfig(t)
+
+	if _, err := captureStdout(t, append([]string{"keys", "add"}, flags...)...); err == nil {
+		t.Error("缺少 -name 时应返回错误")
+	}
+	if _, err := captureStdout(t, append([]string{"keys", "add"}, append(flags, "-name", "ci", "-groups", "missing")...)...); err == nil {
+		t.Error("引用不存在的分组时应返回错误")
+	}
+
+	output, err := captureStdout(t, append([]string{"keys", "add"}, append(flags, "-name", "ci", "-expires", "2030-01-01")...)...)
+	if err != nil {
+		t.Fatalf("创建代理密钥失败: %v", err)
+	}
+	key := strings.TrimSpace(output)
+	if key == "" || strings.Contains(key, "\n") {
+		t.Fatalf("标准输出应只包含明文密钥，得到 %q", output)
+	}
+
+	output, err = captureStdout(t, append([]string{"keys", "list"}, append(flags, "-format", "json")...)...)
+	if err != nil {
+		t.Fatalf("列出代理密钥失败: %v", err)
+	}
+	var keys []proxykey.ProxyKey
+	if err := json.Unmarshal([]byte(output), &keys); err != nil {
+		t.Fatalf("解析密钥列表失败: %v\n%s", err, output)
+	}
+	if len(keys) != 1 || keys[0].Name != "ci" || keys[0].ExpiresAt == nil {
+		t.Errorf("应列出新建的密钥 ci，得到 %+v", keys)
+	}
+
+	output, err = captureStdout(t, append([]string{"keys", "list"}, flags...)...)
+	if err != nil || !strings.Contains(output, "ci") || strings.Contains(output, key) {
+		t.Errorf("表格格式应列出密钥但不显示明文，err=%v\n%s", err, output)
+	}
+	if _, err := captureStdout(t, append([]string{"keys", "list"}, append(flags, "-format", "xml")...)...); err == nil {
+		t.Error("不支持的输出格式应返回错误")
+	}
+}
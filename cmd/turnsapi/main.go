@@ -4,28 +4,57 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"turnsapi/internal"
 	"turnsapi/internal/api"
+	"turnsapi/internal/backup"
+	"turnsapi/internal/budgets"
 	"turnsapi/internal/database"
 	"turnsapi/internal/keymanager"
 	"turnsapi/internal/logger"
+	"turnsapi/internal/logging"
+	"turnsapi/internal/providers"
+	"turnsapi/internal/sqlitedriver"
 )
 
 var (
 	configPath = flag.String("config", "config/config.yaml", "配置文件路径")
 	dbPath     = flag.String("db", "data/turnsapi.db", "数据库文件路径")
+	backfill   = flag.Bool("backfill-logs", false, "按当前计算逻辑回填历史请求日志的派生字段后退出")
+	verifyLogs = flag.Bool("verify-logs", false, "校验请求日志的哈希链，报告第一处不一致后退出")
+	restore    = flag.String("restore-backup", "", "用指定的备份文件替换SQLite数据库后退出，需在服务停止时执行")
+	validate   = flag.Bool("validate-only", false, "校验配置（含数据库中的分组）并列出全部问题后退出，有问题时以非零状态码退出")
 	version    = "2.0.0"
 )
 
 func main() {
+	flag.Usage = showUsage
 	flag.Parse()
 
+	// 管理子命令直接操作配置和数据库，执行完成后退出
+	if handled, err := runCommand(flag.Args()); handled {
+		if err != nil && err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 恢复需要在打开数据库之前进行
+	if *restore != "" {
+		if err := runRestore(*restore); err != nil {
+			log.Fatalf("恢复数据库失败: %v", err)
+		}
+		return
+	}
+
 	log.Printf("TurnsAPI Multi-Provider v%s 快速启动中...", version)
 
 	// 创建配置管理器
@@ -38,6 +67,53 @@ func main() {
 	// 获取配置
 	config := configManager.GetConfig()
 
+	// 配置了日志文件时同时写入标准错误和文件，收到 SIGHUP 时重新打开文件
+	var logOutput io.Writer = os.Stderr
+	var logFile *logging.File
+	if config.Logging.File != "" {
+		if logFile, err = logging.OpenFile(config.Logging.File); err != nil {
+			log.Fatalf("日志文件打开失败: %v", err)
+		}
+		defer logFile.Close()
+		logOutput = io.MultiWriter(os.Stderr, logFile)
+	}
+
+	// 按配置切换为结构化日志，标准库log的输出同样按级别过滤
+	if err := logging.Setup(logging.Options{Level: config.Logging.Level, Format: config.Logging.Format}, logOutput); err != nil {
+		log.Fatalf("日志配置无效: %v", err)
+	}
+
+	if *backfill {
+		if err := runLogBackfill(config); err != nil {
+			log.Fatalf("历史日志回填失败: %v", err)
+		}
+		return
+	}
+
+	if *verifyLogs {
+		ok, err := runLogVerify(config)
+		if err != nil {
+			log.Fatalf("请求日志哈希链校验失败: %v", err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *validate {
+		if err := reportValidation(config); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 配置问题默认只输出警告，开启 server.strict_config 时拒绝启动
+	if err := checkConfiguration(config); err != nil {
+		log.Fatalf("拒绝启动: %v", err)
+	}
+
 	// 基本配置验证（最小化验证，提高启动速度）
 	if len(config.UserGroups) == 0 {
 		log.Fatal("配置文件中未找到任何用户分组")
@@ -50,14 +126,13 @@ func main() {
 
 	log.Printf("加载了 %d 个分组，其中 %d 个已启用", len(config.UserGroups), len(enabledGroups))
 
-	// 延迟创建日志目录（异步）
-	go func() {
-		if config.Logging.File != "" {
-			if err := os.MkdirAll("logs", 0755); err != nil {
-				log.Printf("创建日志目录失败: %v", err)
-			}
-		}
-	}()
+	// 设置上游请求的User-Agent和归属请求头
+	setUpstreamIdentity(config)
+
+	// 设置上游连接池的全局默认值，需在创建提供商实例前设置
+	if config.GlobalSettings != nil {
+		providers.SetTransportDefaults(config.GlobalSettings.UpstreamTransport.ProviderOptions())
+	}
 
 	// 创建多分组密钥管理器（快速初始化，无网络检查）
 	// 初始化数据库连接用于密钥管理器
@@ -65,9 +140,9 @@ func main() {
 	var keyManager *keymanager.MultiGroupKeyManager
 	if err != nil {
 		log.Printf("警告: 无法初始化数据库连接用于密钥管理器: %v", err)
-		keyManager = keymanager.NewMultiGroupKeyManager(config)
+		keyManager = keymanager.NewMultiGroupKeyManager(configManager)
 	} else {
-		keyManager = keymanager.NewMultiGroupKeyManagerWithDB(config, groupsDB)
+		keyManager = keymanager.NewMultiGroupKeyManagerWithDB(configManager, groupsDB)
 		defer groupsDB.Close()
 	}
 	defer keyManager.Close()
@@ -77,7 +152,7 @@ func main() {
 	// 延迟启动日志清理任务（5分钟后启动）
 	go func() {
 		time.Sleep(5 * time.Minute)
-		startLogCleanupTask(config)
+		startLogCleanupTask(configManager)
 	}()
 
 	// 创建多提供商HTTP服务器
@@ -85,7 +160,6 @@ func main() {
 
 	// 启动服务器
 	go func() {
-		log.Printf("HTTP服务器启动在 %s", config.GetAddress())
 		if err := server.Start(); err != nil {
 			log.Fatalf("服务器启动失败: %v", err)
 		}
@@ -98,10 +172,19 @@ func main() {
 		validateAPIKeysInBackground(enabledGroups)
 	}()
 
-	// 等待中断信号
+	// 等待中断信号，SIGHUP 时重新加载配置后继续运行
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	for running := true; running; {
+		select {
+		case <-reload:
+			reloadConfiguration(server, configManager, groupsDB, logFile, logOutput)
+		case <-quit:
+			running = false
+		}
+	}
 
 	log.Println("正在关闭服务器...")
 
@@ -116,43 +199,209 @@ func main() {
 	}
 }
 
-// startLogCleanupTask 启动日志清理任务
-func startLogCleanupTask(config *internal.Config) {
+// startLogCleanupTask 启动日志清理任务，每次清理时读取最新配置，重新加载后的保留策略在下次清理时生效
+func startLogCleanupTask(configManager *internal.ConfigManager) {
 	log.Printf("启动日志清理任务")
-	// 每天凌晨2点执行清理任务
-	ticker := time.NewTicker(24 * time.Hour)
+	// 每小时检查一次：配置了数据库大小上限时每次都清理，避免长时间超过上限；否则每天清理一次
+	ticker := time.NewTicker(time.Hour)
 	defer ticker.Stop()
 
 	// 延迟首次清理，避免启动时执行
 	time.Sleep(1 * time.Hour)
+	config := configManager.Snapshot()
 	performLogCleanup(config)
+	lastCleanup := time.Now()
 
 	for {
 		select {
 		case <-ticker.C:
-			performLogCleanup(config)
+			config = configManager.Snapshot()
+			if config.Database.MaxSizeMB > 0 || time.Since(lastCleanup) >= 24*time.Hour-time.Minute {
+				performLogCleanup(config)
+				lastCleanup = time.Now()
+			}
+		}
+	}
+}
+
+// reloadConfiguration 处理 SIGHUP：重新打开日志文件和数据库连接，重新加载配置文件和数据库中的分组
+// 新配置无法加载或（开启 server.strict_config 时）校验失败时保留当前配置继续运行
+func reloadConfiguration(server *api.MultiProviderServer, configManager *internal.ConfigManager, groupsDB *database.GroupsDB,
+	logFile *logging.File, logOutput io.Writer) {
+	log.Println("收到 SIGHUP，重新加载配置...")
+	if logFile != nil {
+		if err := logFile.Reopen(); err != nil {
+			log.Printf("警告: 重新打开日志文件失败，继续写入原文件: %v", err)
+		}
+	}
+
+	result, err := server.Reload(checkConfiguration)
+	if err != nil {
+		log.Printf("重新加载配置失败，继续使用当前配置: %v", err)
+		return
+	}
+	if groupsDB != nil {
+		if err := groupsDB.ResetConnections(); err != nil {
+			log.Printf("警告: 密钥管理器重新连接数据库失败: %v", err)
 		}
 	}
+
+	config := configManager.Snapshot()
+	if err := logging.Setup(logging.Options{Level: config.Logging.Level, Format: config.Logging.Format}, logOutput); err != nil {
+		log.Printf("警告: 日志配置无效，保留当前日志设置: %v", err)
+	}
+	setUpstreamIdentity(config)
+
+	log.Printf("配置重新加载完成: %d 个分组，其中 %d 个有变化", result.Groups, result.UpdatedGroups)
+	if len(result.RestartRequired) > 0 {
+		log.Printf("警告: 以下设置已变更，需重启服务后生效: %s", strings.Join(result.RestartRequired, ", "))
+	}
+}
+
+// logRetentionPolicy 根据配置生成请求日志保留策略
+func logRetentionPolicy(config *internal.Config) logger.RetentionPolicy {
+	return logger.RetentionPolicy{
+		Days:         config.Database.RetentionDays,
+		GroupDays:    config.Database.GroupRetention,
+		ProxyKeyDays: config.Database.KeyRetention,
+		MaxSizeBytes: int64(config.Database.MaxSizeMB) << 20,
+	}
+}
+
+// usagePeriodStart 有启用的预算或设置了月度限额的租户时，返回当前预算周期和自然月中较早的开始时间，否则返回零值
+// 预算和租户限额的本期用量从请求日志统计，清理这之后的日志会降低用量而解除超额限制
+func usagePeriodStart(config *internal.Config, requestLogger *logger.RequestLogger, now time.Time) time.Time {
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	var since time.Time
+
+	budgetList, err := requestLogger.GetAllBudgets()
+	if err != nil {
+		log.Printf("Failed to load budgets for log cleanup, keeping logs of this month: %v", err)
+		since = monthStart
+	}
+	for _, budget := range budgetList {
+		if budget.Enabled {
+			var settings *internal.BudgetSettings
+			if config.GlobalSettings != nil {
+				settings = config.GlobalSettings.Budgets
+			}
+			since, _ = budgets.CurrentPeriod(settings, now)
+			break
+		}
+	}
+
+	tenants, err := requestLogger.GetAllTenants()
+	if err != nil {
+		log.Printf("Failed to load tenants for log cleanup, keeping logs of this month: %v", err)
+	}
+	limited := err != nil
+	for _, tenant := range tenants {
+		if tenant.MonthlyRequestLimit > 0 || tenant.MonthlyTokenLimit > 0 {
+			limited = true
+			break
+		}
+	}
+	if limited && (since.IsZero() || monthStart.Before(since)) {
+		since = monthStart
+	}
+	return since
 }
 
 // performLogCleanup 执行日志清理
 func performLogCleanup(config *internal.Config) {
-	if config.Database.RetentionDays <= 0 {
-		return // 如果保留天数为0或负数，不执行清理
+	policy := logRetentionPolicy(config)
+	if policy.Days <= 0 && len(policy.GroupDays) == 0 && len(policy.ProxyKeyDays) == 0 && policy.MaxSizeBytes <= 0 {
+		return // 没有配置任何保留策略，不执行清理
 	}
 
-	requestLogger, err := logger.NewRequestLogger(config.Database.Path)
+	requestLogger, err := logger.NewRequestLoggerWithDriver(config.LogStorage())
 	if err != nil {
 		log.Printf("Failed to create request logger for cleanup: %v", err)
 		return
 	}
 	defer requestLogger.Close()
 
-	if err := requestLogger.CleanupOldLogs(config.Database.RetentionDays); err != nil {
+	policy.KeepSince = usagePeriodStart(config, requestLogger, time.Now())
+	result, err := requestLogger.ApplyRetention(policy)
+	if err != nil {
 		log.Printf("Failed to cleanup old logs: %v", err)
-	} else {
-		log.Printf("Log cleanup completed successfully")
+		return
+	}
+	log.Printf("Log cleanup completed: %d expired, %d evicted for size limit, database size %d bytes",
+		result.Expired, result.Evicted, result.SizeBytes)
+}
+
+// runRestore 校验备份文件并替换配置中的SQLite数据库，原数据库保留在同一目录
+func runRestore(backupPath string) error {
+	config, err := internal.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	driver, dbPath := config.LogStorage()
+	if driver != "sqlite3" {
+		return fmt.Errorf("restore is only supported with the sqlite3 driver")
+	}
+
+	previous, err := backup.Restore(backupPath, dbPath)
+	if err != nil {
+		return err
+	}
+	if previous != "" {
+		log.Printf("原数据库已保留为 %s", previous)
+	}
+	log.Printf("已从 %s 恢复数据库 %s", backupPath, dbPath)
+	return nil
+}
+
+// runLogBackfill 回填历史请求日志的派生字段（token使用量、工具调用信息、费用、错误分类和首字耗时）
+func runLogBackfill(config *internal.Config) error {
+	requestLogger, err := logger.NewRequestLoggerWithDriver(config.LogStorage())
+	if err != nil {
+		return fmt.Errorf("failed to create request logger: %w", err)
+	}
+	defer requestLogger.Close()
+	requestLogger.SetCostFunc(config.EstimateCost)
+
+	result, err := requestLogger.BackfillDerivedFields(logger.BackfillOptions{})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("回填完成: 扫描 %d 条，更新 %d 条，耗时 %s", result.Scanned, result.Updated, result.Duration)
+	for field, count := range result.FieldsUpdated {
+		log.Printf("  - %s: %d", field, count)
+	}
+	return nil
+}
+
+// runLogVerify 校验请求日志的哈希链，返回哈希链是否完整
+func runLogVerify(config *internal.Config) (bool, error) {
+	requestLogger, err := logger.NewRequestLoggerWithDriver(config.LogStorage())
+	if err != nil {
+		return false, fmt.Errorf("failed to create request logger: %w", err)
 	}
+	defer requestLogger.Close()
+
+	report, err := requestLogger.VerifyHashChain()
+	if err != nil {
+		return false, err
+	}
+
+	if report.Unhashed > 0 {
+		log.Printf("跳过 %d 条启用哈希链之前的历史日志", report.Unhashed)
+	}
+	if report.Gaps > 0 {
+		log.Printf("经 request_log_gaps 中的记录跳过 %d 段已删除的日志", report.Gaps)
+	}
+	if !report.OK() {
+		log.Printf("哈希链在日志 #%d 处不一致: %s", report.Break.ID, report.Break.Reason)
+		log.Printf("  期望: %s", report.Break.Expected)
+		log.Printf("  实际: %s", report.Break.Actual)
+		log.Printf("已校验 %d 条日志（从 #%d 开始）", report.Checked, report.FirstID)
+		return false, nil
+	}
+	log.Printf("哈希链完整: 校验 %d 条日志（从 #%d 开始），链末尾哈希 %s", report.Checked, report.FirstID, report.Head)
+	return true, nil
 }
 
 // validateAPIKeysInBackground 后台验证API密钥
@@ -186,6 +435,7 @@ func printStartupInfo(config *internal.Config) {
 	log.Println("=== TurnsAPI Multi-Provider 启动信息 ===")
 	log.Printf("版本: %s", version)
 	log.Printf("监听地址: %s", config.GetAddress())
+	log.Printf("SQLite驱动: %s", sqlitedriver.Implementation)
 	log.Printf("配置的分组数量: %d", len(config.UserGroups))
 
 	enabledGroups := config.GetEnabledGroups()
@@ -209,60 +459,19 @@ func printStartupInfo(config *internal.Config) {
 	log.Println("=====================================")
 }
 
-// validateConfiguration 验证配置
-func validateConfiguration(config *internal.Config) error {
-	// 验证基本配置
-	if config.Server.Port == "" {
-		return fmt.Errorf("server port is required")
-	}
-
-	// 验证分组配置
-	for groupID, group := range config.UserGroups {
-		if group.Name == "" {
-			return fmt.Errorf("group %s: name is required", groupID)
-		}
-
-		if group.ProviderType == "" {
-			return fmt.Errorf("group %s: provider_type is required", groupID)
-		}
-
-		if group.BaseURL == "" {
-			return fmt.Errorf("group %s: base_url is required", groupID)
-		}
-
-		if group.Enabled && len(group.APIKeys) == 0 {
-			return fmt.Errorf("group %s: enabled group must have at least one API key", groupID)
-		}
-
-		// 验证提供商类型
-		supportedTypes := []string{"openai", "gemini", "anthropic", "azure_openai", "openrouter"}
-		supported := false
-		for _, supportedType := range supportedTypes {
-			if group.ProviderType == supportedType {
-				supported = true
-				break
-			}
-		}
-
-		if !supported {
-			return fmt.Errorf("group %s: unsupported provider_type '%s', supported types: %v",
-				groupID, group.ProviderType, supportedTypes)
-		}
-	}
-
-	return nil
-}
-
 // showUsage 显示使用说明
 func showUsage() {
 	log.Println("TurnsAPI Multi-Provider - 多提供商API代理服务")
 	log.Println("")
 	log.Println("使用方法:")
 	log.Printf("  %s [选项]", os.Args[0])
+	log.Printf("  %s [选项] <子命令> [子命令选项]", os.Args[0])
 	log.Println("")
 	log.Println("选项:")
 	flag.PrintDefaults()
 	log.Println("")
+	log.Println(commandUsage())
+	log.Println("")
 	log.Println("支持的提供商类型:")
 	log.Println("  - openai: OpenAI API 和兼容服务")
 	log.Println("  - openrouter: OpenRouter API (兼容 OpenAI 格式)")
@@ -272,3 +481,18 @@ func showUsage() {
 	log.Println("")
 	log.Println("配置文件示例: config/config.example.yaml")
 }
+
+// setUpstreamIdentity 按全局设置配置上游请求的身份标识，未配置User-Agent时使用 TurnsAPI/<版本号>
+func setUpstreamIdentity(config *internal.Config) {
+	userAgent := "TurnsAPI/" + version
+	var headers map[string]string
+	if config.GlobalSettings != nil && config.GlobalSettings.UpstreamIdentity != nil {
+		identity := config.GlobalSettings.UpstreamIdentity
+		if identity.UserAgent != "" {
+			userAgent = identity.UserAgent
+		}
+		headers = identity.Headers
+	}
+	providers.SetUpstreamIdentity(userAgent, headers)
+	log.Printf("上游请求User-Agent: %s", userAgent)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"turnsapi/internal"
+	"turnsapi/internal/providers"
+)
+
+// validRotationStrategies 分组支持的密钥轮换策略
+var validRotationStrategies = []string{"round_robin", "random", "least_used"}
+
+// validateConfiguration 校验配置，返回发现的全部问题，按分组ID排序
+func validateConfiguration(config *internal.Config) []error {
+	var errs []error
+	addf := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if config.Server.Port == "" {
+		addf("server.port is required")
+	}
+	if config.Server.TLS != nil && config.Server.UnixSocketOnly {
+		addf("server.tls has no effect when server.unix_socket_only is enabled, the unix socket always serves plain HTTP")
+	}
+	if config.GlobalSettings != nil && config.GlobalSettings.DefaultRotationStrategy != "" &&
+		!containsString(validRotationStrategies, config.GlobalSettings.DefaultRotationStrategy) {
+		addf("global_settings.default_rotation_strategy: unsupported strategy %q, expected one of %v",
+			config.GlobalSettings.DefaultRotationStrategy, validRotationStrategies)
+	}
+
+	groupIDs := make([]string, 0, len(config.UserGroups))
+	for groupID := range config.UserGroups {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Strings(groupIDs)
+
+	supportedTypes := providers.NewDefaultProviderFactory().GetSupportedTypes()
+	keyOwners := make(map[string][]string) // API密钥 -> 使用该密钥的分组
+	for _, groupID := range groupIDs {
+		group := config.UserGroups[groupID]
+		if group == nil {
+			addf("group %s: group is empty", groupID)
+			continue
+		}
+
+		if group.Name == "" {
+			addf("group %s: name is required", groupID)
+		}
+		if group.ProviderType == "" {
+			addf("group %s: provider_type is required", groupID)
+		} else if !containsString(supportedTypes, group.ProviderType) {
+			addf("group %s: unsupported provider_type %q, supported types: %v", groupID, group.ProviderType, supportedTypes)
+		}
+		if group.BaseURL == "" {
+			addf("group %s: base_url is required", groupID)
+		}
+		if group.Enabled && len(group.APIKeys) == 0 && !group.IsKeyless() {
+			addf("group %s: enabled group must have at least one API key", groupID)
+		}
+		for _, ref := range group.UnresolvedAPIKeys {
+			addf("group %s: API key reference %s cannot be resolved", groupID, ref)
+		}
+		if group.RotationStrategy != "" && !containsString(validRotationStrategies, group.RotationStrategy) {
+			addf("group %s: unsupported rotation_strategy %q, expected one of %v", groupID, group.RotationStrategy, validRotationStrategies)
+		}
+		if group.RPMLimit < 0 {
+			addf("group %s: rpm_limit must not be negative", groupID)
+		}
+		if err := internal.ValidateRPMBurst(group.RPMLimit, group.RPMBurst); err != nil {
+			addf("group %s: %v", groupID, err)
+		}
+		if err := internal.ValidateDefaultMaxTokens(group.DefaultMaxTokens); err != nil {
+			addf("group %s: %v", groupID, err)
+		}
+		if err := internal.ValidateModelLimits(group.ModelLimits); err != nil {
+			addf("group %s: %v", groupID, err)
+		}
+		if err := internal.ValidateHooks(group.Hooks); err != nil {
+			addf("group %s: %v", groupID, err)
+		}
+		if err := internal.ValidateScript(group.Script); err != nil {
+			addf("group %s: %v", groupID, err)
+		}
+		if err := internal.ValidateVertexAI(group.ProviderType, group.VertexAI); err != nil {
+			addf("group %s: %v", groupID, err)
+		}
+		if group.MaxConcurrent < 0 || group.MaxConcurrentPerKey < 0 {
+			addf("group %s: max_concurrent and max_concurrent_per_key must not be negative", groupID)
+		}
+
+		// 分组声明了模型列表时，别名必须映射到列表中的模型，否则请求会转发一个上游不支持的模型
+		if len(group.Models) > 0 {
+			aliases := make([]string, 0, len(group.ModelMappings))
+			for alias := range group.ModelMappings {
+				aliases = append(aliases, alias)
+			}
+			sort.Strings(aliases)
+			for _, alias := range aliases {
+				if target := group.ModelMappings[alias]; !containsString(group.Models, target) {
+					addf("group %s: model_mappings %s -> %s references a model that is not in models", groupID, alias, target)
+				}
+			}
+		}
+
+		seen := make(map[string]bool, len(group.APIKeys))
+		for _, key := range group.APIKeys {
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			keyOwners[key] = append(keyOwners[key], groupID)
+		}
+	}
+
+	// 同一个密钥出现在多个分组中时，各分组分别统计限流和失败状态，通常是复制配置时的遗漏
+	var duplicates []string
+	for key, owners := range keyOwners {
+		if len(owners) > 1 {
+			duplicates = append(duplicates, fmt.Sprintf("API key %s is used by multiple groups: %s", maskConfigKey(key), strings.Join(owners, ", ")))
+		}
+	}
+	sort.Strings(duplicates)
+	for _, duplicate := range duplicates {
+		addf("%s", duplicate)
+	}
+
+	return errs
+}
+
+// reportValidation 输出配置校验发现的全部问题，有问题时返回错误
+func reportValidation(config *internal.Config) error {
+	errs := validateConfiguration(config)
+	for _, err := range errs {
+		fmt.Printf("  - %v\n", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("configuration has %d problem(s)", len(errs))
+	}
+	fmt.Printf("配置有效: %d 个分组，其中 %d 个已启用\n", len(config.UserGroups), len(config.GetEnabledGroups()))
+	return nil
+}
+
+// checkConfiguration 将配置问题输出为警告，开启 server.strict_config 时有问题即返回错误
+// 启动和 SIGHUP 重新加载时使用，重新加载时返回错误会保留当前配置
+func checkConfiguration(config *internal.Config) error {
+	errs := validateConfiguration(config)
+	for _, err := range errs {
+		log.Printf("警告: 配置问题: %v", err)
+	}
+	if len(errs) > 0 && config.Server.StrictConfig {
+		return fmt.Errorf("configuration has %d problem(s) and server.strict_config is enabled", len(errs))
+	}
+	return nil
+}
+
+// maskConfigKey 只保留密钥末尾4位，避免在校验输出中泄露密钥
+func maskConfigKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"turnsapi/internal"
+)
+
+// TestValidateConfiguration 测试一次列出全部问题并按分组ID排序，重复的密钥只显示末尾4位
+func TestValidateConfiguration(t *testing.T) {
+	config := &internal.Config{}
+	config.Server.Port = "8080"
+	config.UserGroups = map[string]*internal.UserGroup{
+		"group_b": {
+			Name:             "B",
+			ProviderType:     "openai",
+			BaseURL:          "https://api.openai.com/v1",
+			Enabled:          true,
+			APIKeys:          []string{"sk-shared-key-1234"},
+			RotationStrategy: "weighted",
+		},
+		"group_a": {
+			ProviderType:  "unknown",
+			BaseURL:       "https://example.com",
+			Enabled:       true,
+			Models:        []string{"gpt-4o"},
+			ModelMappings: map[string]string{"fast": "gpt-4o-mini"},
+			APIKeys:       []string{"sk-shared-key-1234"},
+		},
+		"group_c": {
+			Name:         "C",
+			ProviderType: "openai",
+			BaseURL:      "https://api.openai.com/v1",
+			Enabled:      true,
+		},
+	}
+
+	errs := validateConfiguration(config)
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	want := []string{
+		"group group_a: name is required",
+		"group group_a: unsupported provider_type \"unknown\"",
+		"group group_a: model_mappings fast -> gpt-4o-mini",
+		"group group_b: unsupported rotation_strategy \"weighted\"",
+		"group group_c: enabled group must have at least one API key",
+		"API key ****1234 is used by multiple groups: group_a, group_b",
+	}
+	if len(messages) != len(want) {
+		t.Fatalf("应发现 %d 个问题，得到 %d 个:\n%s", len(want), len(messages), strings.Join(messages, "\n"))
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(messages[i], prefix) {
+			t.Errorf("第 %d 个问题应以 %q 开头，得到 %q", i+1, prefix, messages[i])
+		}
+	}
+	if strings.Contains(strings.Join(messages, "\n"), "sk-shared") {
+		t.Error("校验结果不应包含完整的密钥")
+	}
+
+	config.UserGroups = map[string]*internal.UserGroup{"group_b": config.UserGroups["group_b"]}
+	config.UserGroups["group_b"].RotationStrategy = "round_robin"
+	if errs := validateConfiguration(config); len(errs) != 0 {
+		t.Errorf("有效配置不应报告问题，得到 %v", errs)
+	}
+}
+
+// TestCheckConfiguration 测试默认只输出警告，开启 strict_config 时有问题即返回错误
+func TestCheckConfiguration(t *testing.T) {
+	config := &internal.Config{}
+	if err := checkConfiguration(config); err != nil {
+		t.Errorf("未开启 strict_config 时不应返回错误，得到 %v", err)
+	}
+	config.Server.StrictConfig = true
+	if err := checkConfiguration(config); err == nil {
+		t.Error("开启 strict_config 且缺少 server.port 时应返回错误")
+	}
+	config.Server.Port = "8080"
+	if err := checkConfiguration(config); err != nil {
+		t.Errorf("配置有效时不应返回错误，得到 %v", err)
+	}
+}
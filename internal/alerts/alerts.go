@@ -0,0 +1,490 @@
+package alerts
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/logger"
+	"turnsapi/internal/metrics"
+	"turnsapi/internal/notify"
+)
+
+const (
+	defaultEvaluationInterval = 30 * time.Second
+	defaultWindowSeconds      = 300
+	maxWindowSeconds          = 24 * 60 * 60 // 规则窗口上限
+	maxSamples                = 200000       // 内存中保留的日志样本上限
+	maxSampleErrorLength      = 512          // 样本中保留的错误信息长度
+)
+
+// 告警触发状态指标
+var alertFiring = metrics.Default.NewGaugeVec(
+	"turnsapi_alert_firing",
+	"Whether a log alert rule is currently firing (1) or not (0)",
+	"rule_id", "rule")
+
+// RuleStore 告警规则的持久化存储
+type RuleStore interface {
+	InsertAlertRule(rule *logger.AlertRule) error
+	UpdateAlertRule(rule *logger.AlertRule) error
+	GetAllAlertRules() ([]*logger.AlertRule, error)
+	DeleteAlertRule(id string) error
+}
+
+// RuleStatus 告警规则及其最近一次评估结果
+type RuleStatus struct {
+	*logger.AlertRule
+	Firing       bool       `json:"firing"`
+	Value        float64    `json:"value"`    // 最近一次评估的指标值：百分比、秒或请求数
+	Requests     int        `json:"requests"` // 最近一次评估时窗口内匹配分组的请求数
+	EvaluatedAt  *time.Time `json:"evaluated_at,omitempty"`
+	FiringSince  *time.Time `json:"firing_since,omitempty"`
+	LastNotified *time.Time `json:"last_notified,omitempty"`
+}
+
+// sample 用于评估的请求日志样本
+type sample struct {
+	at       time.Time
+	group    string
+	failed   bool
+	duration int64 // 毫秒
+	err      string
+}
+
+// ruleState 规则的运行状态
+type ruleState struct {
+	rule    *logger.AlertRule
+	pattern *regexp.Regexp
+	status  RuleStatus
+}
+
+// Engine 告警规则引擎，收集请求日志样本并按间隔评估规则，状态变化时发送通知
+type Engine struct {
+	config   internal.ConfigSource
+	store    RuleStore
+	notifier *notify.Notifier
+
+	mu      sync.Mutex
+	rules   []*ruleState
+	samples []sample
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewEngine 创建告警规则引擎并从存储加载规则
+func NewEngine(config internal.ConfigSource, store RuleStore, notifier *notify.Notifier) (*Engine, error) {
+	e := &Engine{
+		config:   config,
+		store:    store,
+		notifier: notifier,
+		stopCh:   make(chan struct{}),
+	}
+
+	rules, err := store.GetAllAlertRules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load alert rules: %w", err)
+	}
+	for _, rule := range rules {
+		state, err := newRuleState(rule)
+		if err != nil {
+			log.Printf("警告: 告警规则 %s 无效，已跳过: %v", rule.ID, err)
+			continue
+		}
+		e.rules = append(e.rules, state)
+	}
+	return e, nil
+}
+
+// ValidateRule 校验告警规则并补全默认值
+func ValidateRule(rule *logger.AlertRule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch rule.Type {
+	case logger.AlertRuleErrorRate:
+		if rule.Threshold <= 0 || rule.Threshold > 100 {
+			return fmt.Errorf("threshold must be a percentage between 0 and 100")
+		}
+	case logger.AlertRuleLatencyP95:
+		if rule.Threshold <= 0 {
+			return fmt.Errorf("threshold must be a positive number of seconds")
+		}
+	case logger.AlertRuleErrorMatch:
+		if rule.Pattern == "" {
+			return fmt.Errorf("pattern is required for error_match rules")
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		if rule.Threshold <= 0 {
+			rule.Threshold = 1
+		}
+	default:
+		return fmt.Errorf("unsupported rule type: %s", rule.Type)
+	}
+
+	if rule.WindowSeconds == 0 {
+		rule.WindowSeconds = defaultWindowSeconds
+	}
+	if rule.WindowSeconds < 0 || rule.WindowSeconds > maxWindowSeconds {
+		return fmt.Errorf("window_seconds must be between 1 and %d", maxWindowSeconds)
+	}
+	if rule.MinRequests < 0 || rule.CooldownSeconds < 0 {
+		return fmt.Errorf("min_requests and cooldown_seconds must not be negative")
+	}
+	return nil
+}
+
+// newRuleState 创建规则的运行状态
+func newRuleState(rule *logger.AlertRule) (*ruleState, error) {
+	if err := ValidateRule(rule); err != nil {
+		return nil, err
+	}
+	state := &ruleState{rule: rule, status: RuleStatus{AlertRule: rule}}
+	if rule.Type == logger.AlertRuleErrorMatch {
+		state.pattern = regexp.MustCompile(rule.Pattern)
+	}
+	return state, nil
+}
+
+// Start 启动后台评估
+func (e *Engine) Start() {
+	go e.run()
+}
+
+// Close 停止后台评估
+func (e *Engine) Close() {
+	e.stopOnce.Do(func() { close(e.stopCh) })
+}
+
+// run 按配置的间隔评估规则
+func (e *Engine) run() {
+	for {
+		settings := e.settings()
+		interval := defaultEvaluationInterval
+		if settings != nil && settings.EvaluationInterval > 0 {
+			interval = settings.EvaluationInterval
+		}
+
+		select {
+		case <-e.stopCh:
+			return
+		case <-time.After(interval):
+		}
+
+		if settings := e.settings(); settings == nil || !settings.Disabled {
+			e.Evaluate(time.Now())
+		}
+	}
+}
+
+// settings 读取告警评估设置
+func (e *Engine) settings() *internal.AlertSettings {
+	config := e.config.Snapshot()
+	if config == nil || config.GlobalSettings == nil {
+		return nil
+	}
+	return config.GlobalSettings.Alerts
+}
+
+// Observe 记录一条请求日志样本，作为 RequestLogger 的观察者调用
+func (e *Engine) Observe(entry logger.RequestLog) {
+	if settings := e.settings(); settings != nil && settings.Disabled {
+		return
+	}
+	if len(entry.Error) > maxSampleErrorLength {
+		entry.Error = entry.Error[:maxSampleErrorLength]
+	}
+	s := sample{
+		at:       entry.CreatedAt,
+		group:    entry.ProviderGroup,
+		failed:   entry.StatusCode != 200 || entry.Error != "",
+		duration: entry.Duration,
+		err:      entry.Error,
+	}
+	if s.at.IsZero() {
+		s.at = time.Now()
+	}
+
+	e.mu.Lock()
+	e.samples = append(e.samples, s)
+	if len(e.samples) > maxSamples {
+		e.samples = append(e.samples[:0:0], e.samples[len(e.samples)-maxSamples:]...)
+	}
+	e.mu.Unlock()
+}
+
+// Evaluate 评估所有启用的规则，触发或恢复时发送通知
+func (e *Engine) Evaluate(now time.Time) {
+	var notifications []notify.Notification
+
+	e.mu.Lock()
+	e.pruneLocked(now)
+	for _, state := range e.rules {
+		if !state.rule.Enabled {
+			continue
+		}
+		if n := e.evaluateLocked(state, now); n != nil {
+			notifications = append(notifications, *n)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, n := range notifications {
+		e.notifier.Send(n)
+	}
+}
+
+// pruneLocked 丢弃超出所有规则窗口的样本，调用方需持有锁
+func (e *Engine) pruneLocked(now time.Time) {
+	window := 0
+	for _, state := range e.rules {
+		if state.rule.Enabled && state.rule.WindowSeconds > window {
+			window = state.rule.WindowSeconds
+		}
+	}
+	cutoff := now.Add(-time.Duration(window) * time.Second)
+	i := sort.Search(len(e.samples), func(i int) bool { return !e.samples[i].at.Before(cutoff) })
+	if i > 0 {
+		e.samples = append(e.samples[:0:0], e.samples[i:]...)
+	}
+}
+
+// evaluateLocked 评估单条规则并更新状态，状态变化需要通知时返回通知内容，调用方需持有锁
+func (e *Engine) evaluateLocked(state *ruleState, now time.Time) *notify.Notification {
+	rule := state.rule
+	cutoff := now.Add(-time.Duration(rule.WindowSeconds) * time.Second)
+
+	var requests, failed, matched int
+	var durations []int64
+	for _, s := range e.samples {
+		if s.at.Before(cutoff) || (rule.ProviderGroup != "" && s.group != rule.ProviderGroup) {
+			continue
+		}
+		requests++
+		switch rule.Type {
+		case logger.AlertRuleErrorRate:
+			if s.failed {
+				failed++
+			}
+		case logger.AlertRuleLatencyP95:
+			durations = append(durations, s.duration)
+		case logger.AlertRuleErrorMatch:
+			if s.err != "" && state.pattern.MatchString(s.err) {
+				matched++
+			}
+		}
+	}
+
+	var value float64
+	var firing bool
+	switch rule.Type {
+	case logger.AlertRuleErrorRate:
+		if requests > 0 {
+			value = float64(failed) / float64(requests) * 100
+		}
+		firing = requests > 0 && requests >= rule.MinRequests && value > rule.Threshold
+	case logger.AlertRuleLatencyP95:
+		value = percentile(durations, 0.95) / 1000
+		firing = requests > 0 && requests >= rule.MinRequests && value > rule.Threshold
+	case logger.AlertRuleErrorMatch:
+		value = float64(matched)
+		firing = value >= rule.Threshold
+	}
+
+	status := &state.status
+	evaluatedAt := now
+	status.EvaluatedAt = &evaluatedAt
+	status.Value = value
+	status.Requests = requests
+	wasFiring := status.Firing
+	status.Firing = firing
+	if firing {
+		alertFiring.Set(1, rule.ID, rule.Name)
+	} else {
+		alertFiring.Set(0, rule.ID, rule.Name)
+	}
+
+	switch {
+	case firing && !wasFiring:
+		status.FiringSince = &evaluatedAt
+		cooldown := time.Duration(rule.CooldownSeconds) * time.Second
+		if status.LastNotified != nil && now.Sub(*status.LastNotified) < cooldown {
+			return nil
+		}
+		status.LastNotified = &evaluatedAt
+		return &notify.Notification{
+			Source:   "alert",
+			Event:    "firing",
+			Severity: notify.SeverityCritical,
+			Title:    "告警触发: " + rule.Name,
+			Message:  describeRule(rule, value, requests),
+			Labels:   ruleLabels(rule),
+			Time:     now,
+		}
+	case !firing && wasFiring:
+		status.FiringSince = nil
+		return &notify.Notification{
+			Source:   "alert",
+			Event:    "resolved",
+			Severity: notify.SeverityInfo,
+			Title:    "告警恢复: " + rule.Name,
+			Message:  describeRule(rule, value, requests),
+			Labels:   ruleLabels(rule),
+			Time:     now,
+		}
+	}
+	return nil
+}
+
+// describeRule 生成告警通知的说明
+func describeRule(rule *logger.AlertRule, value float64, requests int) string {
+	scope := "所有分组"
+	if rule.ProviderGroup != "" {
+		scope = "分组 " + rule.ProviderGroup
+	}
+	window := time.Duration(rule.WindowSeconds) * time.Second
+	switch rule.Type {
+	case logger.AlertRuleErrorRate:
+		return fmt.Sprintf("%s 最近 %s 错误率 %.1f%%（%d 个请求），阈值 %.1f%%", scope, window, value, requests, rule.Threshold)
+	case logger.AlertRuleLatencyP95:
+		return fmt.Sprintf("%s 最近 %s 请求耗时P95 %.2fs（%d 个请求），阈值 %.2fs", scope, window, value, requests, rule.Threshold)
+	default:
+		return fmt.Sprintf("%s 最近 %s 有 %.0f 个请求的错误信息匹配 %q，阈值 %.0f", scope, window, value, rule.Pattern, rule.Threshold)
+	}
+}
+
+// ruleLabels 告警通知的标签
+func ruleLabels(rule *logger.AlertRule) map[string]string {
+	labels := map[string]string{
+		"rule_id": rule.ID,
+		"rule":    rule.Name,
+		"type":    rule.Type,
+	}
+	if rule.ProviderGroup != "" {
+		labels["group"] = rule.ProviderGroup
+	}
+	return labels
+}
+
+// percentile 计算耗时的分位数（最近秩法）
+func percentile(values []int64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return float64(sorted[rank])
+}
+
+// Rules 获取所有规则及其状态
+func (e *Engine) Rules() []RuleStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	statuses := make([]RuleStatus, 0, len(e.rules))
+	for _, state := range e.rules {
+		status := state.status
+		rule := *state.rule
+		status.AlertRule = &rule
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Rule 获取单条规则
+func (e *Engine) Rule(id string) (*logger.AlertRule, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, state := range e.rules {
+		if state.rule.ID == id {
+			rule := *state.rule
+			return &rule, true
+		}
+	}
+	return nil, false
+}
+
+// CreateRule 校验并保存新规则，未指定ID时自动生成
+func (e *Engine) CreateRule(rule *logger.AlertRule) error {
+	if rule.ID == "" {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return fmt.Errorf("failed to generate rule id: %w", err)
+		}
+		rule.ID = hex.EncodeToString(id)
+	}
+	state, err := newRuleState(rule)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	if err := e.store.InsertAlertRule(rule); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.rules = append(e.rules, state)
+	e.mu.Unlock()
+	return nil
+}
+
+// UpdateRule 校验并更新规则，规则条件变化后重新开始评估
+func (e *Engine) UpdateRule(rule *logger.AlertRule) error {
+	state, err := newRuleState(rule)
+	if err != nil {
+		return err
+	}
+	rule.UpdatedAt = time.Now()
+	if err := e.store.UpdateAlertRule(rule); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	for i, existing := range e.rules {
+		if existing.rule.ID == rule.ID {
+			rule.CreatedAt = existing.rule.CreatedAt
+			if existing.rule.Name != rule.Name {
+				alertFiring.Delete(existing.rule.ID, existing.rule.Name)
+			}
+			e.rules[i] = state
+			break
+		}
+	}
+	e.mu.Unlock()
+	return nil
+}
+
+// DeleteRule 删除规则
+func (e *Engine) DeleteRule(id string) error {
+	if err := e.store.DeleteAlertRule(id); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	for i, state := range e.rules {
+		if state.rule.ID == id {
+			alertFiring.Delete(state.rule.ID, state.rule.Name)
+			e.rules = append(e.rules[:i], e.rules[i+1:]...)
+			break
+		}
+	}
+	e.mu.Unlock()
+	return nil
+}
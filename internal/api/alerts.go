@@ -0,0 +1,190 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"turnsapi/internal/logger"
+	"turnsapi/internal/notify"
+
+	"github.com/gin-gonic/gin"
+)
+
+// alertRuleRequest 创建或更新告警规则的请求
+type alertRuleRequest struct {
+	Name            string  `json:"name" binding:"required"`
+	Type            string  `json:"type" binding:"required"`
+	ProviderGroup   string  `json:"provider_group"`
+	Threshold       float64 `json:"threshold"`
+	Pattern         string  `json:"pattern"`
+	WindowSeconds   int     `json:"window_seconds"`
+	MinRequests     int     `json:"min_requests"`
+	CooldownSeconds int     `json:"cooldown_seconds"`
+	Enabled         *bool   `json:"enabled"` // 默认启用
+}
+
+// toRule 转换为告警规则
+func (req *alertRuleRequest) toRule(id string) *logger.AlertRule {
+	rule := &logger.AlertRule{
+		ID:              id,
+		Name:            strings.TrimSpace(req.Name),
+		Type:            req.Type,
+		ProviderGroup:   strings.TrimSpace(req.ProviderGroup),
+		Threshold:       req.Threshold,
+		Pattern:         req.Pattern,
+		WindowSeconds:   req.WindowSeconds,
+		MinRequests:     req.MinRequests,
+		CooldownSeconds: req.CooldownSeconds,
+		Enabled:         true,
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	return rule
+}
+
+// requireAlertEngine 检查告警规则引擎是否可用
+func (s *MultiProviderServer) requireAlertEngine(c *gin.Context) bool {
+	if s.alertEngine == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Alert engine is not available",
+		})
+		return false
+	}
+	return true
+}
+
+// handleAlerts 获取告警规则、当前状态和最近发送的通知
+func (s *MultiProviderServer) handleAlerts(c *gin.Context) {
+	if !s.requireAlertEngine(c) {
+		return
+	}
+
+	rules := s.alertEngine.Rules()
+	firing := 0
+	for _, rule := range rules {
+		if rule.Firing {
+			firing++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"rules":         rules,
+		"firing":        firing,
+		"notifications": s.notifier.Recent(),
+	})
+}
+
+// handleCreateAlertRule 创建告警规则
+func (s *MultiProviderServer) handleCreateAlertRule(c *gin.Context) {
+	if !s.requireAlertEngine(c) {
+		return
+	}
+
+	var req alertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	rule := req.toRule("")
+	if err := s.alertEngine.CreateRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	log.Printf("创建告警规则: %s (%s)", rule.Name, rule.Type)
+	s.recordAudit(c, "alert_rule.create", rule.ID, nil, rule)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"rule":    rule,
+	})
+}
+
+// handleUpdateAlertRule 更新告警规则
+func (s *MultiProviderServer) handleUpdateAlertRule(c *gin.Context) {
+	if !s.requireAlertEngine(c) {
+		return
+	}
+
+	id := c.Param("id")
+	before, exists := s.alertEngine.Rule(id)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Alert rule not found",
+		})
+		return
+	}
+
+	var req alertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	rule := req.toRule(id)
+	if err := s.alertEngine.UpdateRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	log.Printf("更新告警规则: %s (%s)", rule.Name, rule.Type)
+	s.recordAudit(c, "alert_rule.update", id, before, rule)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"rule":    rule,
+	})
+}
+
+// handleDeleteAlertRule 删除告警规则
+func (s *MultiProviderServer) handleDeleteAlertRule(c *gin.Context) {
+	if !s.requireAlertEngine(c) {
+		return
+	}
+
+	id := c.Param("id")
+	before, _ := s.alertEngine.Rule(id)
+	if err := s.alertEngine.DeleteRule(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Alert rule not found",
+		})
+		return
+	}
+
+	log.Printf("删除告警规则: %s", id)
+	s.recordAudit(c, "alert_rule.delete", id, before, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// handleTestNotification 向所有通知渠道发送一条测试通知
+func (s *MultiProviderServer) handleTestNotification(c *gin.Context) {
+	s.notifier.Send(notify.Notification{
+		Source:   "test",
+		Severity: notify.SeverityInfo,
+		Title:    "TurnsAPI 测试通知",
+		Message:  "通知渠道配置正常",
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Test notification sent",
+	})
+}
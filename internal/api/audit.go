@@ -0,0 +1,321 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordAudit 记录管理操作审计日志
+// before/after 为变更前后的对象快照，两者都存在时只保留发生变化的字段
+func (s *MultiProviderServer) recordAudit(c *gin.Context, action, target string, before, after interface{}) {
+	if s.requestLogger == nil {
+		return
+	}
+
+	beforeMap := toAuditMap(before)
+	afterMap := toAuditMap(after)
+	if beforeMap != nil && afterMap != nil {
+		beforeMap, afterMap = diffAuditMaps(beforeMap, afterMap)
+	}
+
+	entry := &logger.AuditLog{
+		Actor:     c.GetString("user"),
+		Action:    action,
+		Target:    target,
+		ClientIP:  c.ClientIP(),
+		Before:    marshalAuditMap(beforeMap),
+		After:     marshalAuditMap(afterMap),
+		CreatedAt: time.Now(),
+	}
+	if err := s.requestLogger.InsertAuditLog(entry); err != nil {
+		log.Printf("Failed to record audit log for %s %s: %v", action, target, err)
+	}
+}
+
+// toAuditMap 将快照转换为字段映射，便于比较和存储
+func toAuditMap(v interface{}) map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	return m
+}
+
+// diffAuditMaps 只保留变更前后值不同的字段
+func diffAuditMaps(before, after map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	changedBefore := make(map[string]interface{})
+	changedAfter := make(map[string]interface{})
+	for key, oldValue := range before {
+		newValue, exists := after[key]
+		if !exists || !reflect.DeepEqual(oldValue, newValue) {
+			changedBefore[key] = oldValue
+			if exists {
+				changedAfter[key] = newValue
+			}
+		}
+	}
+	for key, newValue := range after {
+		if _, exists := before[key]; !exists {
+			changedAfter[key] = newValue
+		}
+	}
+	return changedBefore, changedAfter
+}
+
+// marshalAuditMap 序列化字段映射，空映射存储为空字符串
+func marshalAuditMap(m map[string]interface{}) string {
+	if len(m) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// auditGroupSnapshot 生成分组配置快照，API密钥和请求头只记录脱敏后的值
+func (s *MultiProviderServer) auditGroupSnapshot(group *internal.UserGroup) map[string]interface{} {
+	if group == nil {
+		return nil
+	}
+
+	maskedKeys := make([]string, len(group.APIKeys))
+	for i, key := range group.APIKeys {
+		maskedKeys[i] = s.maskKey(key)
+	}
+	maskedHeaders := make(map[string]string, len(group.Headers))
+	for name, value := range group.Headers {
+		maskedHeaders[name] = s.maskKey(value)
+	}
+
+	// 立即序列化，避免后续修改分组时影响快照
+	return toAuditMap(map[string]interface{}{
+		"name":                   group.Name,
+		"provider_type":          group.ProviderType,
+		"base_url":               group.BaseURL,
+		"enabled":                group.Enabled,
+		"timeout_seconds":        group.Timeout.Seconds(),
+		"max_retries":            group.MaxRetries,
+		"rotation_strategy":      group.RotationStrategy,
+		"api_keys":               maskedKeys,
+		"models":                 group.Models,
+		"headers":                maskedHeaders,
+		"request_params":         group.RequestParams,
+		"model_mappings":         group.ModelMappings,
+		"model_rewrites":         group.ModelRewrites,
+		"use_native_response":    group.UseNativeResponse,
+		"rpm_limit":              group.RPMLimit,
+		"rpm_burst":              group.RPMBurst,
+		"model_limits":           group.ModelLimits,
+		"hooks":                  group.Hooks,
+		"script":                 group.Script,
+		"chat_completions_path":  group.ChatCompletionsPath,
+		"models_path":            group.ModelsPath,
+		"max_concurrent":         group.MaxConcurrent,
+		"max_concurrent_per_key": group.MaxConcurrentPerKey,
+		"retry_policy":           group.RetryPolicy,
+		"health_check_model":     group.HealthCheckModel,
+		"skip_health_check":      group.SkipHealthCheck,
+		"include_reasoning":      group.IncludeReasoning,
+		"default_max_tokens":     group.DefaultMaxTokens,
+		"system_prompt":          group.SystemPrompt,
+		"shadow":                 group.Shadow,
+		"timeouts":               group.Timeouts,
+		"health_probe":           group.HealthProbe,
+		"proxy_url":              group.RedactedProxyURL(),
+		"tls":                    group.TLS,
+		"vertex_ai":              group.VertexAI,
+		"transport":              group.Transport,
+	})
+}
+
+// auditProxyKeySnapshot 生成代理密钥快照，不记录密钥明文
+func (s *MultiProviderServer) auditProxyKeySnapshot(id string) map[string]interface{} {
+	for _, key := range s.proxyKeyManager.GetAllKeys() {
+		if key.ID == id {
+			return toAuditMap(map[string]interface{}{
+				"name":                   key.Name,
+				"description":            key.Description,
+				"key":                    s.maskKey(key.Key),
+				"is_active":              key.IsActive,
+				"allowed_groups":         key.AllowedGroups,
+				"group_selection_config": key.GroupSelectionConfig,
+				"expires_at":             key.ExpiresAt,
+				"max_usage_count":        key.MaxUsageCount,
+				"allowed_models":         key.AllowedModels,
+				"denied_models":          key.DeniedModels,
+				"allowed_ips":            key.AllowedIPs,
+				"denied_ips":             key.DeniedIPs,
+				"system_prompt":          key.SystemPrompt,
+				"tenant_id":              key.TenantID,
+			})
+		}
+	}
+	return nil
+}
+
+// parseAuditFilter 从查询参数解析审计日志筛选条件
+func parseAuditFilter(c *gin.Context) (*logger.AuditFilter, error) {
+	filter := &logger.AuditFilter{
+		Actor:  c.Query("actor"),
+		Action: c.Query("action"),
+		Target: c.Query("target"),
+	}
+
+	for param, dest := range map[string]**time.Time{
+		"start_time": &filter.StartTime,
+		"end_time":   &filter.EndTime,
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s, expected RFC3339 format", param)
+		}
+		*dest = &t
+	}
+	return filter, nil
+}
+
+// handleAuditLogs 处理审计日志查询
+func (s *MultiProviderServer) handleAuditLogs(c *gin.Context) {
+	if s.requestLogger == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Request logger not available",
+		})
+		return
+	}
+
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// 解析分页参数
+	filter.Limit = 50
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			filter.Limit = l
+		}
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			filter.Offset = o
+		}
+	}
+
+	entries, err := s.requestLogger.GetAuditLogs(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to get audit logs: " + err.Error(),
+		})
+		return
+	}
+
+	totalCount, err := s.requestLogger.GetAuditLogCount(filter)
+	if err != nil {
+		log.Printf("Failed to get audit logs count: %v", err)
+		totalCount = 0
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"logs":        entries,
+		"total_count": totalCount,
+	})
+}
+
+// handleExportAuditLogs 处理导出审计日志，支持csv和json格式
+func (s *MultiProviderServer) handleExportAuditLogs(c *gin.Context) {
+	if s.requestLogger == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Request logger not available",
+		})
+		return
+	}
+
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	entries, err := s.requestLogger.GetAuditLogs(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to export audit logs: " + err.Error(),
+		})
+		return
+	}
+
+	timestamp := time.Now().Format("20060102_150405")
+	if c.DefaultQuery("format", "csv") != "csv" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=audit_logs_%s.json", timestamp))
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"logs":    entries,
+			"count":   len(entries),
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"ID", "时间", "操作者", "操作", "对象", "客户端IP", "变更前", "变更后"})
+	for _, entry := range entries {
+		writer.Write([]string{
+			strconv.FormatInt(entry.ID, 10),
+			entry.CreatedAt.Format("2006-01-02 15:04:05"),
+			entry.Actor,
+			entry.Action,
+			entry.Target,
+			entry.ClientIP,
+			entry.Before,
+			entry.After,
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to write CSV: " + err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=audit_logs_%s.csv", timestamp))
+	c.Data(http.StatusOK, "text/csv", buf.Bytes())
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"turnsapi/internal/backup"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleDownloadBackup 在线备份SQLite数据库并作为附件下载，备份期间不影响请求处理和日志写入
+// 备份包含代理密钥、管理用户和请求日志等全部数据，只允许管理员下载
+func (s *MultiProviderServer) handleDownloadBackup(c *gin.Context) {
+	driver, dbPath := s.configManager.Snapshot().LogStorage()
+	if driver != "sqlite3" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Online backup is only supported with the sqlite3 driver, use the database's own backup tools instead",
+		})
+		return
+	}
+
+	// 临时文件放在数据库所在目录，与数据库在同一磁盘，避免临时目录空间不足
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dbPath), ".backup-")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to create temporary directory: " + err.Error(),
+		})
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	filename := backup.FileName(time.Now())
+	path := filepath.Join(tmpDir, filename)
+	if err := backup.Snapshot(dbPath, path); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to backup database: " + err.Error(),
+		})
+		return
+	}
+
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+	s.recordAudit(c, "backup.download", filename, nil, gin.H{"size": size})
+	c.FileAttachment(path, filename)
+}
+
+// handleCreateBackup 立即执行一次备份到本地备份目录，配置了S3时同时上传
+func (s *MultiProviderServer) handleCreateBackup(c *gin.Context) {
+	result, err := s.backupScheduler.Run(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to backup database: " + err.Error(),
+		})
+		return
+	}
+	s.recordAudit(c, "backup.create", filepath.Base(result.Path), nil, result)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": result.Error == "",
+		"backup":  result,
+	})
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"turnsapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// budgetRequest 创建或更新预算的请求
+type budgetRequest struct {
+	Scope       string  `json:"scope" binding:"required"`  // tenant、proxy_key 或 group
+	Target      string  `json:"target" binding:"required"` // 租户ID、代理密钥ID或分组ID
+	LimitUSD    float64 `json:"limit_usd"`
+	LimitTokens int64   `json:"limit_tokens"`
+	Enabled     *bool   `json:"enabled"` // 默认启用
+}
+
+// toBudget 转换为预算
+func (req *budgetRequest) toBudget(id string) *logger.Budget {
+	budget := &logger.Budget{
+		ID:          id,
+		Scope:       req.Scope,
+		Target:      strings.TrimSpace(req.Target),
+		LimitUSD:    req.LimitUSD,
+		LimitTokens: req.LimitTokens,
+		Enabled:     true,
+	}
+	if req.Enabled != nil {
+		budget.Enabled = *req.Enabled
+	}
+	return budget
+}
+
+// requireBudgetEngine 检查预算引擎是否可用
+func (s *MultiProviderServer) requireBudgetEngine(c *gin.Context) bool {
+	if s.budgetEngine == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Budget engine is not available",
+		})
+		return false
+	}
+	return true
+}
+
+// checkBudgetTarget 检查预算对象是否存在
+func (s *MultiProviderServer) checkBudgetTarget(budget *logger.Budget) error {
+	switch budget.Scope {
+	case logger.BudgetScopeTenant:
+		if !s.proxyKeyManager.TenantExists(budget.Target) {
+			return fmt.Errorf("tenant %s not found", budget.Target)
+		}
+	case logger.BudgetScopeProxyKey:
+		for _, key := range s.proxyKeyManager.GetAllKeys() {
+			if key.ID == budget.Target {
+				return nil
+			}
+		}
+		return fmt.Errorf("proxy key %s not found", budget.Target)
+	case logger.BudgetScopeGroup:
+		if _, exists := s.configManager.Snapshot().UserGroups[budget.Target]; !exists {
+			return fmt.Errorf("group %s not found", budget.Target)
+		}
+	}
+	return nil
+}
+
+// handleBudgets 获取所有预算及其本计费周期的用量
+func (s *MultiProviderServer) handleBudgets(c *gin.Context) {
+	if !s.requireBudgetEngine(c) {
+		return
+	}
+
+	statuses := s.budgetEngine.Statuses()
+	exceeded := 0
+	for _, status := range statuses {
+		if status.Exceeded {
+			exceeded++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"budgets":  statuses,
+		"exceeded": exceeded,
+	})
+}
+
+// handleCreateBudget 创建预算
+func (s *MultiProviderServer) handleCreateBudget(c *gin.Context) {
+	if !s.requireBudgetEngine(c) {
+		return
+	}
+
+	var req budgetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	budget := req.toBudget("")
+	if err := s.checkBudgetTarget(budget); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	status, err := s.budgetEngine.CreateBudget(budget)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	log.Printf("创建预算: %s %s (%s)", budget.Scope, budget.Target, budget.ID)
+	s.recordAudit(c, "budget.create", budget.ID, nil, budget)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"budget":  status,
+	})
+}
+
+// handleUpdateBudget 更新预算
+func (s *MultiProviderServer) handleUpdateBudget(c *gin.Context) {
+	if !s.requireBudgetEngine(c) {
+		return
+	}
+
+	id := c.Param("id")
+	before, exists := s.budgetEngine.Budget(id)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Budget not found",
+		})
+		return
+	}
+
+	var req budgetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	budget := req.toBudget(id)
+	if err := s.checkBudgetTarget(budget); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	status, err := s.budgetEngine.UpdateBudget(budget)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	log.Printf("更新预算: %s %s (%s)", budget.Scope, budget.Target, id)
+	s.recordAudit(c, "budget.update", id, before, budget)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"budget":  status,
+	})
+}
+
+// handleDeleteBudget 删除预算
+func (s *MultiProviderServer) handleDeleteBudget(c *gin.Context) {
+	if !s.requireBudgetEngine(c) {
+		return
+	}
+
+	id := c.Param("id")
+	before, _ := s.budgetEngine.Budget(id)
+	if err := s.budgetEngine.DeleteBudget(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Budget not found",
+		})
+		return
+	}
+
+	log.Printf("删除预算: %s", id)
+	s.recordAudit(c, "budget.delete", id, before, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"turnsapi/internal/providers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fallbackModelsRequest 替换备用模型列表的请求
+type fallbackModelsRequest struct {
+	Models []string `json:"models"`
+}
+
+// handleFallbackModels 获取各提供商类型的备用模型目录
+func (s *MultiProviderServer) handleFallbackModels(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"catalog": s.configManager.GetFallbackCatalog(),
+	})
+}
+
+// handleUpdateFallbackModels 替换提供商类型的备用模型列表，空列表表示删除
+func (s *MultiProviderServer) handleUpdateFallbackModels(c *gin.Context) {
+	providerType := c.Param("providerType")
+	if !slices.Contains(providers.NewDefaultProviderFactory().GetSupportedTypes(), providerType) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   fmt.Sprintf("Unsupported provider type: %s", providerType),
+		})
+		return
+	}
+
+	var req fallbackModelsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	// 去除空白和重复的模型名称，保持原有顺序
+	var models []string
+	for _, model := range req.Models {
+		model = strings.TrimSpace(model)
+		if model != "" && !slices.Contains(models, model) {
+			models = append(models, model)
+		}
+	}
+
+	before := s.configManager.GetFallbackModels(providerType)
+	if err := s.configManager.SetFallbackModels(providerType, models); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to save fallback models: " + err.Error(),
+		})
+		return
+	}
+	s.recordAudit(c, "fallback_models.update", providerType,
+		map[string]interface{}{"models": before}, map[string]interface{}{"models": models})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"provider_type": providerType,
+		"models":        models,
+	})
+}
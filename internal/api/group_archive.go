@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"turnsapi/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleArchivedGroups 列出已归档（删除）的分组
+func (s *MultiProviderServer) handleArchivedGroups(c *gin.Context) {
+	groups, err := s.configManager.ListArchivedGroups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to list archived groups: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"groups":  groups,
+	})
+}
+
+// handlePurgeGroup 永久删除已归档的分组，删除后历史日志只显示分组ID
+func (s *MultiProviderServer) handlePurgeGroup(c *gin.Context) {
+	groupID := c.Param("groupId")
+
+	if err := s.configManager.PurgeGroup(groupID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, database.ErrArchivedGroupNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": "Failed to purge group: " + err.Error(),
+		})
+		return
+	}
+
+	s.recordAudit(c, "group.purge", groupID, nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Group purged successfully",
+	})
+}
+
+// handleGroupNames 获取所有分组（包括已归档的分组）ID到名称的映射，供日志和统计页面显示分组名称
+func (s *MultiProviderServer) handleGroupNames(c *gin.Context) {
+	names, err := s.configManager.GetGroupNames()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get group names: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"names":   names,
+	})
+}
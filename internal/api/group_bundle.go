@@ -0,0 +1,437 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"turnsapi/internal"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// groupBundleVersion 分组配置包的格式版本
+const groupBundleVersion = 1
+
+// maxGroupBundleSize 导入分组配置包的请求体上限
+const maxGroupBundleSize = 10 << 20
+
+// supportedProviderTypes 分组支持的提供商类型
+var supportedProviderTypes = []string{"openai", "gemini", "anthropic", "azure_openai", "openrouter", "openai_compatible"}
+
+// groupBundle 分组配置包：全部（或选定）分组及其API密钥，用于环境迁移（如预发布到生产）和备份
+// 字段名与配置文件的 user_groups 一致，JSON格式使用相同的字段名
+type groupBundle struct {
+	Version      int                            `yaml:"version"`
+	ExportedAt   string                         `yaml:"exported_at,omitempty"`
+	KeysRedacted bool                           `yaml:"keys_redacted,omitempty"` // 为true时API密钥已脱敏，导入时保留目标环境中已有的密钥
+	UserGroups   map[string]*internal.UserGroup `yaml:"user_groups"`
+}
+
+// GroupBundlePlanItem 导入分组配置包时单个分组的处理计划
+type GroupBundlePlanItem struct {
+	GroupID string `json:"group_id"`
+	Action  string `json:"action"` // create、update 或 unchanged
+	Error   string `json:"error,omitempty"`
+}
+
+// handleExportGroupBundle 导出分组配置包
+// 支持 format=yaml|json、redact_keys=true 脱敏API密钥、group_ids=a,b 只导出部分分组
+func (s *MultiProviderServer) handleExportGroupBundle(c *gin.Context) {
+	format := strings.ToLower(c.DefaultQuery("format", "yaml"))
+	if format != "yaml" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "format must be yaml or json",
+		})
+		return
+	}
+	redact := c.Query("redact_keys") == "true"
+
+	groups := s.configManager.GetAllGroups()
+	if ids := strings.TrimSpace(c.Query("group_ids")); ids != "" {
+		selected := make(map[string]*internal.UserGroup)
+		for _, groupID := range strings.Split(ids, ",") {
+			groupID = strings.TrimSpace(groupID)
+			group, exists := groups[groupID]
+			if !exists {
+				c.JSON(http.StatusNotFound, gin.H{
+					"success": false,
+					"error":   fmt.Sprintf("Group not found: %s", groupID),
+				})
+				return
+			}
+			selected[groupID] = group
+		}
+		groups = selected
+	}
+
+	bundle := groupBundle{
+		Version:      groupBundleVersion,
+		ExportedAt:   time.Now().Format(time.RFC3339),
+		KeysRedacted: redact,
+		UserGroups:   make(map[string]*internal.UserGroup, len(groups)),
+	}
+	for groupID, group := range groups {
+		exported := group.Clone()
+		exported.APIKeys = group.ConfiguredAPIKeys() // 引用的密钥导出引用本身
+		if redact {
+			for i, key := range exported.APIKeys {
+				exported.APIKeys[i] = s.maskKey(key)
+			}
+		}
+		bundle.UserGroups[groupID] = exported
+	}
+
+	data, err := yaml.Marshal(bundle)
+	if err == nil && format == "json" {
+		data, err = yamlToJSON(data)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to generate bundle: " + err.Error(),
+		})
+		return
+	}
+
+	contentType := "application/x-yaml"
+	if format == "json" {
+		contentType = "application/json"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=groups_bundle_%s.%s",
+		time.Now().Format("2006-01-02"), format))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// yamlToJSON 将YAML文档转换为JSON，字段名与YAML保持一致
+func yamlToJSON(data []byte) ([]byte, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(document, "", "  ")
+}
+
+// handleImportGroupBundle 导入分组配置包（YAML或JSON），校验全部通过后在一个事务中创建或更新分组
+// dry_run=true 时只返回处理计划和校验结果，不做任何修改；包中没有的分组保持不变
+func (s *MultiProviderServer) handleImportGroupBundle(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxGroupBundleSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Failed to read request body: " + err.Error(),
+		})
+		return
+	}
+
+	bundle, err := parseGroupBundle(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	plan, groups, err := planGroupBundle(s.configManager.GetConfig(), bundle, s.secretRefPolicy(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+			"dry_run": dryRun,
+			"plan":    plan,
+		})
+		return
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"dry_run": true,
+			"plan":    plan,
+		})
+		return
+	}
+
+	existing := s.configManager.GetAllGroups()
+	if len(groups) > 0 {
+		if err := s.configManager.SaveGroups(groups); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"error":   "Failed to import bundle: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	for groupID, group := range groups {
+		if err := s.keyManager.UpdateGroupConfig(groupID, group); err != nil {
+			log.Printf("警告: 导入分组 %s 时更新密钥管理器失败: %v", groupID, err)
+		}
+		s.proxy.UpdateRPMLimit(groupID, group.RPMLimit)
+		s.recordAudit(c, "group.import", groupID, s.auditGroupSnapshot(existing[groupID]), s.auditGroupSnapshot(group))
+	}
+	log.Printf("导入分组配置包: %d 个分组，其中 %d 个有变更", len(plan), len(groups))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"dry_run": false,
+		"plan":    plan,
+	})
+}
+
+// parseGroupBundle 解析YAML或JSON格式的分组配置包
+func parseGroupBundle(data []byte) (*groupBundle, error) {
+	// JSON是YAML的子集，两种格式使用同一套字段名解析
+	var bundle groupBundle
+	if err := yaml.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("Failed to parse bundle: %w", err)
+	}
+	if bundle.Version > groupBundleVersion {
+		return nil, fmt.Errorf("Unsupported bundle version %d, this server supports up to %d", bundle.Version, groupBundleVersion)
+	}
+	if len(bundle.UserGroups) == 0 {
+		return nil, fmt.Errorf("No user_groups found in bundle")
+	}
+	return &bundle, nil
+}
+
+// ImportGroupBundle 直接导入分组配置包到配置数据库，供命令行在不经过管理接口时使用
+// dry_run 时只返回处理计划；运行中的服务需调用 POST /admin/groups/reload 或重启后才会使用导入的分组
+// 命令行是本地操作，API密钥引用不受 secret_refs 的限制
+func ImportGroupBundle(configManager *internal.ConfigManager, data []byte, dryRun bool) ([]GroupBundlePlanItem, error) {
+	bundle, err := parseGroupBundle(data)
+	if err != nil {
+		return nil, err
+	}
+	plan, groups, err := planGroupBundle(configManager.GetConfig(), bundle, nil)
+	if err != nil || dryRun || len(groups) == 0 {
+		return plan, err
+	}
+	if err := configManager.SaveGroups(groups); err != nil {
+		return plan, fmt.Errorf("failed to import bundle: %w", err)
+	}
+	return plan, nil
+}
+
+// planGroupBundle 校验配置包中的分组并生成处理计划，返回需要保存的分组（不含未变化的分组）
+// 任一分组校验失败时返回错误，整包拒绝；refs 限制新增的API密钥引用，为 nil 时不限制
+func planGroupBundle(config *internal.Config, bundle *groupBundle, refs *internal.SecretRefPolicy) ([]GroupBundlePlanItem, map[string]*internal.UserGroup, error) {
+	existing := config.UserGroups
+
+	groupIDs := make([]string, 0, len(bundle.UserGroups))
+	for groupID := range bundle.UserGroups {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Strings(groupIDs)
+
+	plan := make([]GroupBundlePlanItem, 0, len(groupIDs))
+	changed := make(map[string]*internal.UserGroup)
+	failed := 0
+	for _, groupID := range groupIDs {
+		group := bundle.UserGroups[groupID]
+		current, exists := existing[groupID]
+		item := GroupBundlePlanItem{GroupID: groupID, Action: "create"}
+		if exists {
+			item.Action = "update"
+		}
+
+		if group != nil {
+			group = group.Clone()
+			normalizeBundleGroup(group, config.GlobalSettings)
+			// 脱敏的密钥无法使用，保留目标环境中已有的密钥
+			if bundle.KeysRedacted && exists {
+				group.APIKeys = append([]string(nil), current.ConfiguredAPIKeys()...)
+			}
+		}
+		if err := validateBundleGroup(groupID, group, bundle, existing, refs); err != nil {
+			item.Error = err.Error()
+			failed++
+		} else if exists && groupsEqual(current, group) {
+			item.Action = "unchanged"
+		} else {
+			changed[groupID] = group
+		}
+		plan = append(plan, item)
+	}
+
+	if failed > 0 {
+		return plan, nil, fmt.Errorf("%d of %d groups failed validation", failed, len(groupIDs))
+	}
+
+	// 与禁用分组的限制一致，导入后至少保留一个启用的分组
+	enabled := false
+	for groupID, group := range existing {
+		if imported, ok := changed[groupID]; ok {
+			group = imported
+		}
+		enabled = enabled || group.Enabled
+	}
+	for groupID, group := range changed {
+		if _, ok := existing[groupID]; !ok {
+			enabled = enabled || group.Enabled
+		}
+	}
+	if !enabled {
+		return plan, nil, fmt.Errorf("importing the bundle would leave no enabled group")
+	}
+
+	return plan, changed, nil
+}
+
+// normalizeBundleGroup 按配置文件加载时的规则为导入的分组补齐默认值
+func normalizeBundleGroup(group *internal.UserGroup, settings *internal.GlobalSettings) {
+	if settings != nil {
+		if group.Timeout == 0 {
+			group.Timeout = settings.DefaultTimeout
+		}
+		if group.MaxRetries == 0 {
+			group.MaxRetries = settings.DefaultMaxRetries
+		}
+		if group.RotationStrategy == "" {
+			group.RotationStrategy = settings.DefaultRotationStrategy
+		}
+	}
+	if group.Headers == nil {
+		group.Headers = make(map[string]string)
+	}
+	if group.Headers["Content-Type"] == "" {
+		group.Headers["Content-Type"] = "application/json"
+	}
+	group.ChatCompletionsPath = strings.TrimSpace(group.ChatCompletionsPath)
+	group.ModelsPath = strings.TrimSpace(group.ModelsPath)
+	group.HealthCheckModel = strings.TrimSpace(group.HealthCheckModel)
+	group.ProxyURL = strings.TrimSpace(group.ProxyURL)
+	if group.Timeouts.IsZero() {
+		group.Timeouts = nil
+	}
+	if group.TLS.IsZero() {
+		group.TLS = nil
+	}
+	if group.VertexAI.IsZero() {
+		group.VertexAI = nil
+	}
+	if group.Transport.IsZero() {
+		group.Transport = nil
+	}
+	if group.Script.IsZero() {
+		group.Script = nil
+	}
+}
+
+// validateBundleGroup 校验配置包中的单个分组，规则与创建分组接口一致
+func validateBundleGroup(groupID string, group *internal.UserGroup, bundle *groupBundle, existing map[string]*internal.UserGroup, refs *internal.SecretRefPolicy) error {
+	if strings.TrimSpace(groupID) == "" {
+		return fmt.Errorf("group id must not be empty")
+	}
+	if group == nil {
+		return fmt.Errorf("group is empty")
+	}
+	if bundle.KeysRedacted {
+		if _, exists := existing[groupID]; !exists {
+			return fmt.Errorf("API keys are redacted and the group does not exist yet")
+		}
+	} else if len(group.APIKeys) == 0 && !group.IsKeyless() {
+		return fmt.Errorf("no API keys")
+	}
+	if err := validateGroupSettings(groupID, group); err != nil {
+		return err
+	}
+	// 分组中已有的引用可以原样保留
+	var previous []string
+	if current, exists := existing[groupID]; exists {
+		previous = current.ConfiguredAPIKeys()
+	}
+	if err := refs.Check(group.APIKeys, previous); err != nil {
+		return err
+	}
+	if group.Shadow != nil {
+		_, inBundle := bundle.UserGroups[group.Shadow.TargetGroup]
+		_, inConfig := existing[group.Shadow.TargetGroup]
+		if !inBundle && !inConfig {
+			return fmt.Errorf("shadow.target_group %s does not exist", group.Shadow.TargetGroup)
+		}
+	}
+	return nil
+}
+
+// validateGroupSettings 校验分组的配置（不含API密钥数量），规则与创建分组接口一致
+func validateGroupSettings(groupID string, group *internal.UserGroup) error {
+	if group.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	supported := false
+	for _, providerType := range supportedProviderTypes {
+		if group.ProviderType == providerType {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return fmt.Errorf("unsupported provider type: %s", group.ProviderType)
+	}
+	if group.BaseURL == "" {
+		return fmt.Errorf("base_url is required")
+	}
+	if err := internal.ValidateModelRewrites(group.ModelRewrites); err != nil {
+		return err
+	}
+	if err := internal.ValidateShadow(groupID, group.Shadow); err != nil {
+		return err
+	}
+	if err := internal.ValidateTimeouts(group.Timeouts); err != nil {
+		return err
+	}
+	if err := internal.ValidateRPMBurst(group.RPMLimit, group.RPMBurst); err != nil {
+		return err
+	}
+	if err := internal.ValidateDefaultMaxTokens(group.DefaultMaxTokens); err != nil {
+		return err
+	}
+	if err := internal.ValidateModelLimits(group.ModelLimits); err != nil {
+		return err
+	}
+	if err := internal.ValidateHooks(group.Hooks); err != nil {
+		return err
+	}
+	if err := internal.ValidateScript(group.Script); err != nil {
+		return err
+	}
+	if err := internal.ValidateHealthProbe(group.HealthProbe); err != nil {
+		return err
+	}
+	if err := internal.ValidateProxyURL(group.ProxyURL); err != nil {
+		return err
+	}
+	if err := internal.ValidateTLSSettings(group.TLS); err != nil {
+		return err
+	}
+	if err := internal.ValidateVertexAI(group.ProviderType, group.VertexAI); err != nil {
+		return err
+	}
+	return internal.ValidateTransportSettings(group.Transport)
+}
+
+// groupsEqual 按导出格式比较两个分组配置是否相同，引用的密钥按引用比较
+func groupsEqual(a, b *internal.UserGroup) bool {
+	a, b = a.Clone(), b.Clone()
+	a.APIKeys, b.APIKeys = a.ConfiguredAPIKeys(), b.ConfiguredAPIKeys()
+	left, err := yaml.Marshal(a)
+	if err != nil {
+		return false
+	}
+	right, err := yaml.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(left, right)
+}
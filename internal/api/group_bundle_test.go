@@ -0,0 +1,157 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+)
+
+// newBundleTestConfigManager 创建包含一个启用分组 existing 的配置管理器
+func newBundleTestConfigManager(t *testing.T) *internal.ConfigManager {
+	t.Helper()
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte("server:\n  port: \"8080\"\n"), 0644); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+	cm, err := internal.NewConfigManager(configPath, filepath.Join(dir, "turnsapi.db"))
+	if err != nil {
+		t.Fatalf("创建配置管理器失败: %v", err)
+	}
+	t.Cleanup(func() { cm.Close() })
+
+	if err := cm.SaveGroup("existing", &internal.UserGroup{
+		Name:         "Existing",
+		ProviderType: "openai",
+		BaseURL:      "https://api.openai.com/v1",
+		Enabled:      true,
+		Timeout:      30 * time.Second,
+		APIKeys:      []string{"sk-existing-key-0001"},
+		Headers:      map[string]string{"Content-Type": "application/json"},
+	}); err != nil {
+		t.Fatalf("保存分组失败: %v", err)
+	}
+	return cm
+}
+
+// planActions 将处理计划转换为分组ID到处理方式的映射
+func planActions(plan []GroupBundlePlanItem) map[string]string {
+	actions := make(map[string]string, len(plan))
+	for _, item := range plan {
+		actions[item.GroupID] = item.Action
+	}
+	return actions
+}
+
+const testGroupBundle = `
+version: 1
+user_groups:
+  existing:
+    name: Existing Renamed
+    provider_type: openai
+    base_url: https://api.openai.com/v1
+    enabled: true
+    timeout: 30s
+    api_keys:
+      - sk-existing-key-0001
+  imported:
+    name: Imported
+    provider_type: anthropic
+    base_url: https://api.anthropic.com
+    enabled: true
+    timeout: 30s
+    api_keys:
+      - sk-ant-imported-0001
+`
+
+// TestImportGroupBundle 测试试运行不做修改，导入后创建和更新分组，再次导入相同的配置包时没有变更
+func TestImportGroupBundle(t *testing.T) {
+	cm := newBundleTestConfigManager(t)
+
+	plan, err := ImportGroupBundle(cm, []byte(testGroupBundle), true)
+	if err != nil {
+		t.Fatalf("试运行失败: %v", err)
+	}
+	if actions := planActions(plan); actions["existing"] != "update" || actions["imported"] != "create" {
+		t.Errorf("处理计划不一致: %v", actions)
+	}
+	if _, exists := cm.GetGroup("imported"); exists {
+		t.Fatal("试运行不应创建分组")
+	}
+
+	if _, err := ImportGroupBundle(cm, []byte(testGroupBundle), false); err != nil {
+		t.Fatalf("导入失败: %v", err)
+	}
+	imported, exists := cm.GetGroup("imported")
+	if !exists || imported.ProviderType != "anthropic" || len(imported.APIKeys) != 1 {
+		t.Fatalf("应创建 imported 分组，得到 %+v", imported)
+	}
+	if existing, _ := cm.GetGroup("existing"); existing.Name != "Existing Renamed" {
+		t.Errorf("应更新 existing 分组的名称，得到 %q", existing.Name)
+	}
+
+	plan, err = ImportGroupBundle(cm, []byte(testGroupBundle), false)
+	if err != nil {
+		t.Fatalf("重复导入失败: %v", err)
+	}
+	if actions := planActions(plan); actions["existing"] != "unchanged" || actions["imported"] != "unchanged" {
+		t.Errorf("重复导入相同的配置包不应有变更，得到 %v", actions)
+	}
+}
+
+// TestImportGroupBundleRejects 测试任一分组校验失败时整包拒绝，脱敏的配置包保留已有的密钥
+func TestImportGroupBundleRejects(t *testing.T) {
+	cm := newBundleTestConfigManager(t)
+
+	invalid := `{"version": 1, "user_groups": {
+		"good": {"name": "Good", "provider_type": "openai", "base_url": "https://api.openai.com/v1", "enabled": true, "api_keys": ["sk-good"]},
+		"bad": {"name": "Bad", "provider_type": "unknown", "base_url": "https://example.com", "enabled": true, "api_keys": ["sk-bad"]}
+	}}`
+	plan, err := ImportGroupBundle(cm, []byte(invalid), false)
+	if err == nil {
+		t.Fatal("包含无效分组的配置包应被拒绝")
+	}
+	for _, item := range plan {
+		if (item.GroupID == "bad") != (item.Error != "") {
+			t.Errorf("只有 bad 分组应报告错误，得到 %+v", item)
+		}
+	}
+	if _, exists := cm.GetGroup("good"); exists {
+		t.Error("整包拒绝时不应创建任何分组")
+	}
+
+	if _, err := ImportGroupBundle(cm, []byte(`{"version": 2, "user_groups": {}}`), true); err == nil {
+		t.Error("不支持的配置包版本应被拒绝")
+	}
+
+	redacted := `
+version: 1
+keys_redacted: true
+user_groups:
+  existing:
+    name: Existing Redacted
+    provider_type: openai
+    base_url: https://api.openai.com/v1
+    enabled: true
+    timeout: 30s
+    api_keys:
+      - sk-e****0001
+`
+	if _, err := ImportGroupBundle(cm, []byte(redacted), false); err != nil {
+		t.Fatalf("导入脱敏的配置包失败: %v", err)
+	}
+	existing, _ := cm.GetGroup("existing")
+	if existing.Name != "Existing Redacted" || len(existing.APIKeys) != 1 || existing.APIKeys[0] != "sk-existing-key-0001" {
+		t.Errorf("脱敏的密钥应保留已有的密钥，得到 name=%q keys=%v", existing.Name, existing.APIKeys)
+	}
+
+	fresh := `{"version": 1, "keys_redacted": true, "user_groups": {
+		"fresh": {"name": "Fresh", "provider_type": "openai", "base_url": "https://api.openai.com/v1", "enabled": true, "api_keys": ["sk-f****0001"]}
+	}}`
+	if _, err := ImportGroupBundle(cm, []byte(fresh), true); err == nil {
+		t.Error("脱敏的配置包不能创建新分组")
+	}
+}
@@ -0,0 +1,384 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"turnsapi/internal"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// maxGroupTemplateSize 保存分组模板的请求体上限
+const maxGroupTemplateSize = 1 << 20
+
+// cloneGroupRequest 克隆分组的请求，默认不复制API密钥
+type cloneGroupRequest struct {
+	GroupID     string `json:"group_id" binding:"required"`
+	Name        string `json:"name"`         // 为空时使用原分组名称加“（副本）”
+	IncludeKeys bool   `json:"include_keys"` // 复制全部API密钥
+	KeyIndexes  []int  `json:"key_indexes"`  // 只复制指定位置（从0开始）的API密钥
+	Enabled     *bool  `json:"enabled"`      // 为空时有密钥（或不需要密钥）则与原分组一致，否则禁用
+}
+
+// createGroupFromTemplateRequest 从模板创建分组的请求
+type createGroupFromTemplateRequest struct {
+	GroupID string   `json:"group_id" binding:"required"`
+	Name    string   `json:"name"` // 为空时使用模板中的分组名称
+	APIKeys []string `json:"api_keys"`
+	Enabled *bool    `json:"enabled"` // 为空时有密钥（或不需要密钥）则启用，否则禁用
+}
+
+// saveGroupTemplateRequest 保存分组模板的请求（JSON或YAML），from_group 和 group 二选一
+type saveGroupTemplateRequest struct {
+	ID          string              `yaml:"id"`
+	Name        string              `yaml:"name"`
+	Description string              `yaml:"description"`
+	FromGroup   string              `yaml:"from_group"` // 以现有分组的配置（不含API密钥）作为模板
+	Group       *internal.UserGroup `yaml:"group"`      // 字段名与配置文件的 user_groups 一致
+}
+
+// handleCloneGroup 以新的分组ID复制分组配置，可选择复制全部或部分API密钥
+func (s *MultiProviderServer) handleCloneGroup(c *gin.Context) {
+	sourceID := c.Param("groupId")
+	source, exists := s.configManager.GetGroup(sourceID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Group not found",
+		})
+		return
+	}
+
+	var req cloneGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+	if req.IncludeKeys && len(req.KeyIndexes) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "include_keys and key_indexes cannot be used together",
+		})
+		return
+	}
+
+	configuredKeys := source.ConfiguredAPIKeys()
+	var keys []string
+	switch {
+	case req.IncludeKeys:
+		keys = append(keys, configuredKeys...)
+	case len(req.KeyIndexes) > 0:
+		selected := make(map[int]bool, len(req.KeyIndexes))
+		for _, index := range req.KeyIndexes {
+			if index < 0 || index >= len(configuredKeys) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"message": fmt.Sprintf("key index %d out of range, the group has %d keys", index, len(configuredKeys)),
+				})
+				return
+			}
+			if !selected[index] {
+				selected[index] = true
+				keys = append(keys, configuredKeys[index])
+			}
+		}
+	}
+
+	group := source.Clone()
+	group.APIKeys = keys
+	group.APIKeyRefs = nil
+	group.UnresolvedAPIKeys = nil
+	group.Name = strings.TrimSpace(req.Name)
+	if group.Name == "" {
+		group.Name = source.Name + "（副本）"
+	}
+	enabled := req.Enabled
+	if enabled == nil {
+		value := source.Enabled && (len(keys) > 0 || group.IsKeyless())
+		enabled = &value
+	}
+
+	s.createDerivedGroup(c, strings.TrimSpace(req.GroupID), group, configuredKeys, *enabled, "group.clone", sourceID)
+}
+
+// handleGroupTemplates 列出内置和保存的分组模板
+func (s *MultiProviderServer) handleGroupTemplates(c *gin.Context) {
+	templates, err := s.configManager.ListGroupTemplates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to list group templates: " + err.Error(),
+		})
+		return
+	}
+
+	data, err := groupTemplatesJSON(templates)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to encode group templates: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"templates": data,
+	})
+}
+
+// handleSaveGroupTemplate 保存分组模板，同一ID的模板已存在时替换
+func (s *MultiProviderServer) handleSaveGroupTemplate(c *gin.Context) {
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxGroupTemplateSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to read request body: " + err.Error(),
+		})
+		return
+	}
+
+	// JSON是YAML的子集，两种格式使用同一套字段名解析
+	var req saveGroupTemplateRequest
+	if err := yaml.Unmarshal(data, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	group := req.Group
+	switch {
+	case req.FromGroup != "" && group != nil:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "from_group and group cannot be used together",
+		})
+		return
+	case req.FromGroup != "":
+		source, exists := s.configManager.GetGroup(req.FromGroup)
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Group not found: " + req.FromGroup,
+			})
+			return
+		}
+		group = source
+	case group == nil:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "from_group or group is required",
+		})
+		return
+	}
+
+	group = group.Clone()
+	group.APIKeys = nil
+	normalizeBundleGroup(group, s.configManager.Snapshot().GlobalSettings)
+	if err := validateGroupSettings("", group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid template group: " + err.Error(),
+		})
+		return
+	}
+
+	template := &internal.GroupTemplate{
+		ID:          req.ID,
+		Name:        req.Name,
+		Description: req.Description,
+		Group:       group,
+	}
+	if err := s.configManager.SaveGroupTemplate(template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to save group template: " + err.Error(),
+		})
+		return
+	}
+
+	s.recordAudit(c, "group_template.save", template.ID, nil, gin.H{
+		"name":       template.Name,
+		"from_group": req.FromGroup,
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"message":     "Group template saved successfully",
+		"template_id": template.ID,
+	})
+}
+
+// handleDeleteGroupTemplate 删除保存的分组模板，内置模板不能删除
+func (s *MultiProviderServer) handleDeleteGroupTemplate(c *gin.Context) {
+	templateID := c.Param("templateId")
+	if err := s.configManager.DeleteGroupTemplate(templateID); err != nil {
+		status := http.StatusBadRequest
+		if strings.Contains(err.Error(), "not found") {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": "Failed to delete group template: " + err.Error(),
+		})
+		return
+	}
+
+	s.recordAudit(c, "group_template.delete", templateID, nil, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Group template deleted successfully",
+	})
+}
+
+// handleCreateGroupFromTemplate 以模板的配置创建分组，只需提供分组ID和API密钥
+func (s *MultiProviderServer) handleCreateGroupFromTemplate(c *gin.Context) {
+	templateID := c.Param("templateId")
+	template, err := s.configManager.GetGroupTemplate(templateID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load group template: " + err.Error(),
+		})
+		return
+	}
+	if template == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Group template not found",
+		})
+		return
+	}
+
+	var req createGroupFromTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	group := template.Group.Clone()
+	group.APIKeys = req.APIKeys
+	if name := strings.TrimSpace(req.Name); name != "" {
+		group.Name = name
+	}
+	enabled := req.Enabled
+	if enabled == nil {
+		value := len(req.APIKeys) > 0 || group.IsKeyless()
+		enabled = &value
+	}
+
+	s.createDerivedGroup(c, strings.TrimSpace(req.GroupID), group, nil, *enabled, "group.create_from_template", templateID)
+}
+
+// createDerivedGroup 保存克隆或从模板生成的分组，校验规则与创建分组接口一致
+// sourceKeys 为源分组中配置的密钥，其中的API密钥引用可以沿用
+func (s *MultiProviderServer) createDerivedGroup(c *gin.Context, groupID string, group *internal.UserGroup, sourceKeys []string, enabled bool, action, source string) {
+	if !s.checkNewGroupID(c, groupID) {
+		return
+	}
+
+	group.Enabled = enabled
+	normalizeBundleGroup(group, s.configManager.Snapshot().GlobalSettings)
+	if err := validateGroupSettings(groupID, group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := s.secretRefPolicy(c).Check(group.APIKeys, sourceKeys); err != nil {
+		c.JSON(secretRefErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if group.Shadow != nil {
+		if _, exists := s.configManager.GetGroup(group.Shadow.TargetGroup); !exists {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("shadow.target_group %s does not exist", group.Shadow.TargetGroup),
+			})
+			return
+		}
+	}
+
+	if err := s.configManager.SaveGroup(groupID, group); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to save group: " + err.Error(),
+		})
+		return
+	}
+	if err := s.keyManager.UpdateGroupConfig(groupID, group); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to update key manager: " + err.Error(),
+		})
+		return
+	}
+	s.proxy.UpdateRPMLimit(groupID, group.RPMLimit)
+
+	after := s.auditGroupSnapshot(group)
+	after["source"] = source
+	s.recordAudit(c, action, groupID, nil, after)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  "Group created successfully",
+		"group_id": groupID,
+		"enabled":  group.Enabled,
+	})
+}
+
+// checkNewGroupID 检查新分组的ID：不能为空，不能与现有分组或已归档的分组相同，不可用时返回错误响应
+func (s *MultiProviderServer) checkNewGroupID(c *gin.Context, groupID string) bool {
+	if groupID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "group_id is required",
+		})
+		return false
+	}
+	if _, exists := s.configManager.GetGroup(groupID); exists {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "Group ID already exists",
+		})
+		return false
+	}
+	if archived, err := s.configManager.IsGroupArchived(groupID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to check archived groups: " + err.Error(),
+		})
+		return false
+	} else if archived {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "Group ID belongs to an archived group, purge it before reusing the ID",
+		})
+		return false
+	}
+	return true
+}
+
+// groupTemplatesJSON 将模板转换为JSON，分组字段名与配置文件的 user_groups 一致
+func groupTemplatesJSON(templates []*internal.GroupTemplate) (json.RawMessage, error) {
+	data, err := yaml.Marshal(templates)
+	if err != nil {
+		return nil, err
+	}
+	return yamlToJSON(data)
+}
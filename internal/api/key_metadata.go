@@ -0,0 +1,102 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"turnsapi/internal"
+	"turnsapi/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleUpdateKeyMetadata 设置分组中上游密钥的标签、负责人、过期时间和备注
+// 请求体整体替换密钥原有的信息，所有字段为空时清除
+func (s *MultiProviderServer) handleUpdateKeyMetadata(c *gin.Context) {
+	groupID := c.Param("groupId")
+
+	var req struct {
+		APIKey    string `json:"api_key" binding:"required"`
+		Label     string `json:"label"`
+		Owner     string `json:"owner"`
+		ExpiresAt string `json:"expires_at"` // YYYY-MM-DD 或 RFC3339，为空表示不过期
+		Note      string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	group, exists := s.configManager.GetGroup(groupID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Group not found",
+		})
+		return
+	}
+	keyExists := false
+	for _, key := range group.APIKeys {
+		if key == req.APIKey {
+			keyExists = true
+			break
+		}
+	}
+	if !keyExists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "API key not found in this group",
+		})
+		return
+	}
+
+	expiresAt, err := internal.ParseAPIKeyExpiry(req.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	meta := database.APIKeyMetadata{
+		Label:     strings.TrimSpace(req.Label),
+		Owner:     strings.TrimSpace(req.Owner),
+		ExpiresAt: expiresAt,
+		Note:      strings.TrimSpace(req.Note),
+	}
+	if err := internal.ValidateAPIKeyMetadata(meta); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	before := group.KeyMetadata(req.APIKey)
+	if _, err := s.configManager.SetAPIKeyMetadata(groupID, req.APIKey, meta); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to update key metadata: " + err.Error(),
+		})
+		return
+	}
+	if s.keyManager != nil {
+		s.keyManager.SetKeyMetadata(groupID, req.APIKey, meta)
+	}
+
+	log.Printf("更新分组 %s 密钥 %s 的信息（标签: %s）", groupID, s.maskKey(req.APIKey), meta.Label)
+	s.recordAudit(c, "keys.metadata", groupID,
+		gin.H{"api_key": s.maskKey(req.APIKey), "metadata": before},
+		gin.H{"api_key": s.maskKey(req.APIKey), "metadata": meta})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  "Key metadata updated successfully",
+		"api_key":  s.maskKey(req.APIKey),
+		"metadata": meta,
+	})
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"turnsapi/internal"
+)
+
+// certificateCheckInterval 检查证书文件是否更新的最小间隔
+const certificateCheckInterval = 10 * time.Second
+
+// certificateReloader 提供服务端证书，证书或私钥文件更新后在之后的握手中使用新证书
+// 续期工具先后写入两个文件时可能短暂不匹配，加载失败时继续使用当前证书并在下次检查时重试
+type certificateReloader struct {
+	certFile  string
+	keyFile   string
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time // 已加载证书对应的文件修改时间（两个文件中较晚的一个）
+	checkedAt time.Time
+}
+
+// newCertificateReloader 加载证书，证书无效时返回错误，避免启动后所有握手失败
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	r := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime 返回证书和私钥文件中较晚的修改时间
+func (r *certificateReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load 加载证书和私钥（调用方需持有锁或尚未并发使用）
+func (r *certificateReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// GetCertificate 实现 tls.Config.GetCertificate，最多每 certificateCheckInterval 检查一次文件是否更新
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := time.Now(); now.Sub(r.checkedAt) >= certificateCheckInterval {
+		r.checkedAt = now
+		if modTime, err := r.latestModTime(); err != nil {
+			log.Printf("警告: 检查TLS证书文件失败，继续使用当前证书: %v", err)
+		} else if !modTime.Equal(r.modTime) {
+			if err := r.load(modTime); err != nil {
+				log.Printf("警告: 重新加载TLS证书失败，继续使用当前证书: %v", err)
+			} else {
+				log.Printf("已重新加载TLS证书 %s", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// serverTLSConfig 根据配置创建服务端TLS设置
+func serverTLSConfig(settings *internal.ServerTLSSettings) (*tls.Config, error) {
+	reloader, err := newCertificateReloader(settings.CertFile, settings.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	minVersion := uint16(tls.VersionTLS12)
+	if settings.MinVersion == "1.3" {
+		minVersion = tls.VersionTLS13
+	}
+	return &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     minVersion,
+	}, nil
+}
+
+// listen 按配置创建监听，返回在各监听上处理请求的函数
+func (s *MultiProviderServer) listen() ([]func() error, error) {
+	settings := s.config.Server
+
+	// 先加载证书再监听，证书无效时不占用端口
+	if settings.TLS != nil && !settings.UnixSocketOnly {
+		tlsConfig, err := serverTLSConfig(settings.TLS)
+		if err != nil {
+			return nil, err
+		}
+		s.httpServer.TLSConfig = tlsConfig
+	}
+
+	var serves []func() error
+	var unixSocket net.Listener
+	if settings.UnixSocket != "" {
+		listener, err := listenUnix(settings.UnixSocket, settings.UnixSocketMode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on unix socket %s: %w", settings.UnixSocket, err)
+		}
+		unixSocket = listener
+		log.Printf("Starting multi-provider server on unix:%s", settings.UnixSocket)
+		serves = append(serves, func() error { return s.httpServer.Serve(listener) })
+	}
+	if settings.UnixSocketOnly {
+		return serves, nil
+	}
+
+	listener, err := net.Listen("tcp", s.config.GetAddress())
+	if err != nil {
+		if unixSocket != nil {
+			unixSocket.Close()
+		}
+		return nil, err
+	}
+	if s.httpServer.TLSConfig != nil {
+		log.Printf("Starting multi-provider server on https://%s", s.config.GetAddress())
+		serves = append(serves, func() error { return s.httpServer.ServeTLS(listener, "", "") })
+	} else {
+		log.Printf("Starting multi-provider server on %s", s.config.GetAddress())
+		serves = append(serves, func() error { return s.httpServer.Serve(listener) })
+	}
+	return serves, nil
+}
+
+// listenUnix 监听Unix域套接字，清理上次未正常退出时残留的套接字文件
+func listenUnix(path, mode string) (net.Listener, error) {
+	perm := os.FileMode(0660)
+	if mode != "" {
+		parsed, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unix socket mode %q: %w", mode, err)
+		}
+		perm = os.FileMode(parsed)
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket mode: %w", err)
+	}
+	return unixListener{listener}, nil
+}
+
+// unixListener 将Unix域套接字连接的对端地址报告为 127.0.0.1
+// 套接字只能在本机访问，按本机连接处理使 trusted_proxies 可以信任同一主机上反向代理转发的 X-Forwarded-For
+type unixListener struct {
+	net.Listener
+}
+
+func (l unixListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return unixConn{conn}, nil
+}
+
+type unixConn struct {
+	net.Conn
+}
+
+func (unixConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
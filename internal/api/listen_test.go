@@ -0,0 +1,152 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate 生成指定通用名称的自签名证书，写入证书和私钥文件
+func writeTestCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成私钥失败: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("生成证书失败: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("编码私钥失败: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("写入证书失败: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("写入私钥失败: %v", err)
+	}
+}
+
+// certificateCommonName 返回证书的通用名称
+func certificateCommonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("解析证书失败: %v", err)
+	}
+	return parsed.Subject.CommonName
+}
+
+// TestCertificateReloader 测试证书文件更新后使用新证书，新证书无效时继续使用当前证书
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+
+	if _, err := newCertificateReloader(certFile, keyFile); err == nil {
+		t.Fatal("证书文件不存在时应返回错误")
+	}
+
+	writeTestCertificate(t, certFile, keyFile, "first")
+	r, err := newCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("加载证书失败: %v", err)
+	}
+	cert, _ := r.GetCertificate(nil)
+	if name := certificateCommonName(t, cert); name != "first" {
+		t.Fatalf("应使用初始证书，得到 %s", name)
+	}
+
+	// 只写入证书时与私钥不匹配，继续使用当前证书
+	writeTestCertificate(t, filepath.Join(dir, "next.crt"), filepath.Join(dir, "next.key"), "second")
+	data, _ := os.ReadFile(filepath.Join(dir, "next.crt"))
+	os.WriteFile(certFile, data, 0600)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	r.checkedAt = time.Time{}
+	cert, _ = r.GetCertificate(nil)
+	if name := certificateCommonName(t, cert); name != "first" {
+		t.Fatalf("证书与私钥不匹配时应继续使用当前证书，得到 %s", name)
+	}
+
+	// 私钥随后写入，下次检查时加载新证书
+	data, _ = os.ReadFile(filepath.Join(dir, "next.key"))
+	os.WriteFile(keyFile, data, 0600)
+	future = future.Add(time.Minute)
+	os.Chtimes(keyFile, future, future)
+	cert, _ = r.GetCertificate(nil)
+	if name := certificateCommonName(t, cert); name != "first" {
+		t.Fatalf("检查间隔内不应重新加载证书，得到 %s", name)
+	}
+	r.checkedAt = time.Time{}
+	cert, _ = r.GetCertificate(nil)
+	if name := certificateCommonName(t, cert); name != "second" {
+		t.Errorf("证书和私钥都更新后应使用新证书，得到 %s", name)
+	}
+}
+
+// TestListenUnix 测试设置套接字权限、清理残留的套接字文件、拒绝覆盖普通文件，对端地址报告为本机
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "turnsapi.sock")
+
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("创建残留套接字失败: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := listenUnix(path, "0600")
+	if err != nil {
+		t.Fatalf("残留的套接字文件应被清理，得到 %v", err)
+	}
+	defer listener.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("套接字文件不存在: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("套接字权限应为0600，得到 %v", info.Mode().Perm())
+	}
+
+	go func() {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("接受连接失败: %v", err)
+	}
+	conn.Close()
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || !addr.IP.IsLoopback() {
+		t.Errorf("对端地址应报告为本机，得到 %v", conn.RemoteAddr())
+	}
+
+	regular := filepath.Join(dir, "regular")
+	os.WriteFile(regular, nil, 0644)
+	if _, err := listenUnix(regular, ""); err == nil {
+		t.Error("路径是普通文件时应拒绝监听")
+	}
+	if _, err := listenUnix(filepath.Join(dir, "other.sock"), "rw"); err == nil {
+		t.Error("无效的权限应返回错误")
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"sort"
@@ -16,13 +19,20 @@ import (
 	"time"
 
 	"turnsapi/internal"
+	"turnsapi/internal/alerts"
 	"turnsapi/internal/auth"
+	"turnsapi/internal/backup"
+	"turnsapi/internal/budgets"
 	"turnsapi/internal/health"
 	"turnsapi/internal/keymanager"
 	"turnsapi/internal/logger"
+	"turnsapi/internal/metrics"
+	"turnsapi/internal/notify"
 	"turnsapi/internal/providers"
 	"turnsapi/internal/proxy"
 	"turnsapi/internal/proxykey"
+	"turnsapi/internal/redisstore"
+	"turnsapi/internal/reports"
 
 	"github.com/gin-gonic/gin"
 	"gopkg.in/yaml.v3"
@@ -31,13 +41,21 @@ import (
 // MultiProviderServer 多提供商HTTP服务器
 type MultiProviderServer struct {
 	configManager   *internal.ConfigManager
-	config          *internal.Config
+	config          *internal.Config // 启动时的配置快照，仅用于监听地址等静态设置，重新加载时不替换，请求处理应读取 configManager.Snapshot()
 	keyManager      *keymanager.MultiGroupKeyManager
 	proxy           *proxy.MultiProviderProxy
 	authManager     *auth.AuthManager
 	proxyKeyManager *proxykey.Manager
 	requestLogger   *logger.RequestLogger
 	healthChecker   *health.MultiProviderHealthChecker
+	sharedState     *redisstore.Store // 多实例共享状态，未启用Redis时为空
+	notifier        *notify.Notifier
+	alertEngine     *alerts.Engine              // 日志告警规则引擎，加载规则失败时为空
+	budgetEngine    *budgets.Engine             // 预算引擎，加载预算失败时为空
+	reportGenerator *reports.Generator          // 用量汇总报告生成器
+	backupScheduler *backup.Scheduler           // SQLite数据库定时备份
+	rateLimiter     *serverRateLimiter          // 服务器级限流，未配置时为空
+	tracingShutdown func(context.Context) error // 链路追踪导出器的关闭函数，未启用时为空
 	router          *gin.Engine
 	httpServer      *http.Server
 	startTime       time.Time
@@ -50,7 +68,7 @@ type configManagerAdapter struct {
 
 // GetEnabledGroups 实现ConfigProvider接口
 func (cma *configManagerAdapter) GetEnabledGroups() map[string]interface{} {
-	enabledGroups := cma.configManager.GetEnabledGroups()
+	enabledGroups := cma.configManager.Snapshot().GetEnabledGroups()
 	result := make(map[string]interface{})
 	for groupID := range enabledGroups {
 		result[groupID] = struct{}{}
@@ -82,14 +100,42 @@ func NewMultiProviderServer(configManager *internal.ConfigManager, keyManager *k
 	gin.SetMode(ginMode)
 
 	// 创建请求日志记录器
-	requestLogger, err := logger.NewRequestLogger(config.Database.Path)
+	requestLogger, err := logger.NewRequestLoggerWithDriver(config.LogStorage())
 	if err != nil {
 		log.Fatalf("Failed to create request logger: %v", err)
 	}
+	if settings := config.Database.Redaction; settings != nil {
+		opts := logger.RedactionOptions{Presets: settings.Presets}
+		for _, p := range settings.Patterns {
+			opts.Patterns = append(opts.Patterns, logger.RedactionPattern{Name: p.Name, Regex: p.Regex})
+		}
+		redactor, err := logger.NewRedactor(opts)
+		if err != nil {
+			log.Fatalf("Invalid database.redaction settings: %v", err)
+		}
+		requestLogger.SetRedactor(redactor)
+	}
+	// 日志中记录上游密钥的标签而不是掩码后的密钥
+	requestLogger.SetKeyLabeler(func(key string) string {
+		return configManager.Snapshot().APIKeyLabel(key)
+	})
+	requestLogger.SetCostFunc(func(model string, tokens int) float64 {
+		return configManager.Snapshot().EstimateCost(model, tokens)
+	})
+	// 异步批量写入请求日志，避免日志写入阻塞响应
+	if config.Database.LogQueueSize >= 0 {
+		requestLogger.StartAsyncWriter(logger.AsyncWriterOptions{
+			QueueSize:     config.Database.LogQueueSize,
+			BatchSize:     config.Database.LogBatchSize,
+			FlushInterval: config.Database.LogFlushInterval,
+		})
+	}
 
 	// 创建代理密钥管理器
 	configProvider := &configManagerAdapter{configManager: configManager}
 	proxyKeyManager := proxykey.NewManagerWithConfig(requestLogger, configProvider)
+	// 定期禁用过期或使用次数已用完的代理密钥
+	proxyKeyManager.StartExpirationCheck(time.Minute)
 
 	server := &MultiProviderServer{
 		configManager:   configManager,
@@ -98,12 +144,50 @@ func NewMultiProviderServer(configManager *internal.ConfigManager, keyManager *k
 		authManager:     auth.NewAuthManager(config),
 		proxyKeyManager: proxyKeyManager,
 		requestLogger:   requestLogger,
+		rateLimiter:     newServerRateLimiter(config.Server.RateLimit),
 		router:          gin.New(),
 		startTime:       time.Now(),
 	}
 
+	// 链路追踪需在创建上游客户端之前启用
+	if tracingSettings := config.Monitoring.Tracing; tracingSettings != nil && tracingSettings.Enabled {
+		server.enableTracing(tracingSettings)
+	}
+
 	// 创建多提供商代理
-	server.proxy = proxy.NewMultiProviderProxyWithProxyKey(config, keyManager, proxyKeyManager, requestLogger)
+	server.proxy = proxy.NewMultiProviderProxyWithProxyKey(configManager, keyManager, proxyKeyManager, requestLogger)
+
+	// 多实例部署时通过Redis共享密钥轮询、RPM限流和分组失败状态
+	if config.Redis != nil && config.Redis.Enabled {
+		server.enableSharedState(config.Redis)
+	}
+
+	// 按告警规则持续评估请求日志，触发时通过通知渠道发送
+	server.notifier = notify.NewNotifier(configManager)
+	if engine, err := alerts.NewEngine(configManager, requestLogger, server.notifier); err != nil {
+		log.Printf("警告: 初始化告警规则引擎失败: %v", err)
+	} else {
+		server.alertEngine = engine
+		requestLogger.SetObserver(engine.Observe)
+		engine.Start()
+	}
+
+	// 按计费周期汇总代理密钥、租户和分组的用量，超出预算时拒绝请求并发送通知
+	if engine, err := budgets.NewEngine(configManager, requestLogger, server.notifier); err != nil {
+		log.Printf("警告: 初始化预算引擎失败: %v", err)
+	} else {
+		server.budgetEngine = engine
+		server.proxy.SetBudgetEngine(engine)
+		engine.Start()
+	}
+
+	// 按配置定时生成用量日报和周报
+	server.reportGenerator = reports.NewGenerator(configManager, requestLogger, server.notifier)
+	server.reportGenerator.Start()
+
+	// 按配置定时备份SQLite数据库
+	server.backupScheduler = backup.NewScheduler(configManager, server.notifier)
+	server.backupScheduler.Start()
 
 	// 延迟初始化健康检查器（异步创建，避免启动时网络检查）
 	go func() {
@@ -111,11 +195,20 @@ func NewMultiProviderServer(configManager *internal.ConfigManager, keyManager *k
 		log.Printf("开始异步初始化健康检查器...")
 		factory := providers.NewDefaultProviderFactory()
 		providerManager := providers.NewProviderManager(factory)
-		server.healthChecker = health.NewMultiProviderHealthChecker(config, keyManager, providerManager, server.proxy.GetProviderRouter())
+		server.healthChecker = health.NewMultiProviderHealthChecker(configManager, keyManager, providerManager, server.proxy.GetProviderRouter())
+		// 配置了 health_probe 的分组定期主动探测，连续失败的分组暂停参与路由
+		server.healthChecker.StartActiveProbing()
 	}()
 
 	// 设置代理密钥管理器到认证管理器
 	server.authManager.SetProxyKeyManager(server.proxyKeyManager)
+	server.authManager.SetAdminTokenStore(requestLogger)
+	if err := server.authManager.SetUserStore(requestLogger); err != nil {
+		log.Printf("警告: 初始化管理用户失败: %v", err)
+	}
+	if err := server.authManager.SetSessionStore(requestLogger); err != nil {
+		log.Printf("警告: 加载登录会话失败，会话只保存在内存中: %v", err)
+	}
 
 	// 设置中间件
 	server.setupMiddleware()
@@ -129,15 +222,22 @@ func NewMultiProviderServer(configManager *internal.ConfigManager, keyManager *k
 
 // setupMiddleware 设置中间件
 func (s *MultiProviderServer) setupMiddleware() {
-	// 日志中间件
-	s.router.Use(gin.Logger())
+	// 只信任配置的反向代理转发的 X-Forwarded-For，未配置时客户端地址为TCP对端地址
+	if err := s.router.SetTrustedProxies(s.config.Server.TrustedProxies); err != nil {
+		log.Printf("警告: server.trusted_proxies 设置无效: %v", err)
+	}
+
+	// 请求ID和结构化访问日志
+	s.router.Use(s.requestIDMiddleware())
+	s.router.Use(s.accessLogMiddleware())
 	s.router.Use(gin.Recovery())
 
 	// CORS中间件
 	s.router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Provider-Group")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Provider-Group, X-Request-ID, traceparent")
+		c.Header("Access-Control-Expose-Headers", "*") // 允许浏览器客户端读取 X-TurnsAPI-* 上游响应头
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -152,10 +252,22 @@ func (s *MultiProviderServer) setupMiddleware() {
 func (s *MultiProviderServer) setupRoutes() {
 	// API路由（需要API密钥认证）
 	api := s.router.Group("/v1")
-	api.Use(s.authManager.APIKeyAuthMiddleware())
+	api.Use(s.proxyMiddlewares(s.authManager.APIKeyAuthMiddleware())...)
 	{
 		api.POST("/chat/completions", s.handleChatCompletions)
 		api.GET("/models", s.handleModels)
+		api.POST("/debug/echo", s.handleDebugEcho)
+
+		// OpenAI Batches和Files接口透传，查询和取消路由到创建对象时的分组和密钥
+		api.POST("/files", s.proxy.HandleFileUpload)
+		api.GET("/files", s.proxy.HandleFileList)
+		api.GET("/files/:id", s.proxy.HandleBatchObject)
+		api.DELETE("/files/:id", s.proxy.HandleBatchObject)
+		api.GET("/files/:id/content", s.proxy.HandleBatchObject)
+		api.POST("/batches", s.proxy.HandleBatchCreate)
+		api.GET("/batches", s.proxy.HandleBatchList)
+		api.GET("/batches/:id", s.proxy.HandleBatchObject)
+		api.POST("/batches/:id/cancel", s.proxy.HandleBatchObject)
 
 		// 测试路由
 		api.GET("/test", func(c *gin.Context) {
@@ -171,7 +283,7 @@ func (s *MultiProviderServer) setupRoutes() {
 
 		// 需要认证的端点
 		v1betaAuthenticated := v1betaGroup.Group("/")
-		v1betaAuthenticated.Use(s.geminiAPIKeyAuthMiddleware())
+		v1betaAuthenticated.Use(s.proxyMiddlewares(s.geminiAPIKeyAuthMiddleware())...)
 		{
 			v1betaAuthenticated.GET("/models", s.handleGeminiNativeModels)
 			// 支持Gemini原生格式 /models/model:method 使用通配符匹配（必须放在具体路由之前）
@@ -180,12 +292,12 @@ func (s *MultiProviderServer) setupRoutes() {
 	}
 
 	// 兼容OpenAI API路径
-	s.router.POST("/chat/completions", s.authManager.APIKeyAuthMiddleware(), s.handleChatCompletions)
-	s.router.GET("/models", s.authManager.APIKeyAuthMiddleware(), s.handleModels)
+	s.router.POST("/chat/completions", append(s.proxyMiddlewares(s.authManager.APIKeyAuthMiddleware()), s.handleChatCompletions)...)
+	s.router.GET("/models", append(s.proxyMiddlewares(s.authManager.APIKeyAuthMiddleware()), s.handleModels)...)
 
 	// 管理API（需要HTTP Basic认证）
 	admin := s.router.Group("/admin")
-	admin.Use(s.authManager.AuthMiddleware())
+	admin.Use(s.authManager.AdminIPFilterMiddleware(), s.authManager.AuthMiddleware())
 	{
 		// 系统状态
 		admin.GET("/status", s.handleStatus)
@@ -194,6 +306,25 @@ func (s *MultiProviderServer) setupRoutes() {
 		admin.GET("/health/system", s.handleSystemHealth)
 		admin.GET("/health/providers", s.handleProvidersHealth)
 		admin.GET("/health/providers/:groupId", s.handleProviderHealth)
+		admin.GET("/health/router-failures", s.handleRouterFailures)
+		admin.DELETE("/health/router-failures/:groupId", s.handleResetRouterFailures)
+
+		// 限流统计
+		admin.GET("/ratelimit", s.handleRateLimitStats)
+		admin.POST("/ratelimit/:groupId/reset", s.handleResetRateLimit)
+
+		// 提供商额度预警
+		admin.GET("/quota", s.handleQuotaUsage)
+
+		// 会话粘滞绑定
+		admin.GET("/sticky-sessions", s.handleStickySessions)
+		admin.DELETE("/sticky-sessions", s.handleClearStickySessions)
+
+		// 自动模型别名的候选排序
+		admin.GET("/auto-model", s.handleAutoModelStatus)
+
+		// A/B分流配置和按分支的请求统计
+		admin.GET("/traffic-splits", s.handleTrafficSplits)
 
 		// 密钥管理
 		admin.GET("/groups", s.handleGroupsStatus)
@@ -202,7 +333,10 @@ func (s *MultiProviderServer) setupRoutes() {
 		// 模型管理
 		admin.GET("/models", s.handleAllModels)
 		admin.GET("/models/:groupId", s.handleGroupModels)
+		admin.GET("/models/fallback", s.handleFallbackModels)
+		admin.PUT("/models/fallback/:providerType", s.handleUpdateFallbackModels)
 		admin.POST("/models/test", s.handleTestModels)
+		admin.POST("/models/rewrite/dry-run", s.handleModelRewriteDryRun)
 		admin.GET("/models/available/:groupId", s.handleAvailableModels)
 		admin.POST("/models/available/by-type", s.handleAvailableModelsByType)
 		admin.POST("/keys/validate/:groupId", s.handleValidateKeys)
@@ -212,10 +346,17 @@ func (s *MultiProviderServer) setupRoutes() {
 
 		// 日志管理
 		admin.GET("/logs", s.handleLogs)
+		admin.GET("/logs/search", s.handleSearchLogs)
 		admin.GET("/logs/:id", s.handleLogDetail)
+		admin.GET("/logs/request/:requestId", s.handleLogsByRequestID)
+		admin.GET("/logs/:id/transcript", s.handleLogTranscript)
+		admin.GET("/logs/diff", s.handleLogDiff)
+		admin.GET("/shadow/comparisons", s.handleShadowComparisons)
 		admin.DELETE("/logs/batch", s.handleDeleteLogs)
 		admin.DELETE("/logs/clear", s.handleClearAllLogs)
 		admin.DELETE("/logs/clear-errors", s.handleClearErrorLogs)
+		admin.POST("/logs/backfill", s.handleBackfillLogs)
+		admin.GET("/logs/backfill", s.handleBackfillStatus)
 		admin.GET("/logs/export", s.handleExportLogs)
 		admin.GET("/logs/stats/api-keys", s.handleAPIKeyStats)
 		admin.GET("/logs/stats/models", s.handleModelStats)
@@ -225,13 +366,74 @@ func (s *MultiProviderServer) setupRoutes() {
 		admin.GET("/logs/stats/tokens-timeline", s.handleTokensTimeline)
 		admin.GET("/logs/stats/group-tokens", s.handleGroupTokens)
 
+		// 日志告警规则和通知
+		admin.GET("/alerts", s.handleAlerts)
+		admin.POST("/alerts/rules", s.handleCreateAlertRule)
+		admin.PUT("/alerts/rules/:id", s.handleUpdateAlertRule)
+		admin.DELETE("/alerts/rules/:id", s.handleDeleteAlertRule)
+		admin.POST("/alerts/test-notification", s.handleTestNotification)
+
+		// 预算
+		admin.GET("/budgets", s.handleBudgets)
+		admin.POST("/budgets", s.handleCreateBudget)
+		admin.PUT("/budgets/:id", s.handleUpdateBudget)
+		admin.DELETE("/budgets/:id", s.handleDeleteBudget)
+
+		// 用量汇总报告
+		admin.GET("/reports", s.handleUsageReports)
+		admin.GET("/reports/:id", s.handleUsageReport)
+		admin.POST("/reports/generate", s.handleGenerateUsageReport)
+
+		// 审计日志
+		admin.GET("/audit", s.handleAuditLogs)
+		admin.GET("/audit/export", s.handleExportAuditLogs)
+
+		// 数据库备份（只允许管理员）
+		admin.GET("/backup", s.handleDownloadBackup)
+		admin.POST("/backup", s.handleCreateBackup)
+
 		// 代理密钥管理
 		admin.GET("/proxy-keys", s.handleProxyKeys)
 		admin.POST("/proxy-keys", s.handleGenerateProxyKey)
+		admin.POST("/proxy-keys/quick-create", s.handleQuickCreateProxyKey)
+		admin.GET("/proxy-keys/export", s.handleExportProxyKeys)
+		admin.POST("/proxy-keys/import", s.handleImportProxyKeys)
 		admin.PUT("/proxy-keys/:id", s.handleUpdateProxyKey)
+		admin.PATCH("/proxy-keys/:id", s.handlePatchProxyKey)
 		admin.DELETE("/proxy-keys/:id", s.handleDeleteProxyKey)
 		admin.GET("/proxy-keys/:id/group-stats", s.handleProxyKeyGroupStats)
 
+		// 租户管理和按租户统计
+		admin.GET("/tenants", s.handleTenants)
+		admin.POST("/tenants", s.handleCreateTenant)
+		admin.PUT("/tenants/:id", s.handleUpdateTenant)
+		admin.DELETE("/tenants/:id", s.handleDeleteTenant)
+		admin.GET("/tenants/:id/stats", s.handleTenantStats)
+		admin.GET("/tenants/:id/logs", s.handleTenantLogs)
+
+		// 管理API令牌（只允许登录会话管理）
+		admin.GET("/api-tokens", s.handleAdminTokens)
+		admin.POST("/api-tokens", s.handleCreateAdminToken)
+		admin.DELETE("/api-tokens/:id", s.handleRevokeAdminToken)
+
+		// 管理用户和当前账号（只允许登录会话访问）
+		admin.GET("/users", s.handleAdminUsers)
+		admin.POST("/users", s.handleCreateAdminUser)
+		admin.PUT("/users/:id", s.handleUpdateAdminUser)
+		admin.DELETE("/users/:id", s.handleDeleteAdminUser)
+		admin.DELETE("/users/:id/sessions", s.handleRevokeAdminUserSessions)
+		admin.GET("/account", s.handleAccount)
+		admin.POST("/account/password", s.handleChangePassword)
+		admin.POST("/account/2fa/setup", s.handleTwoFactorSetup)
+		admin.POST("/account/2fa/enable", s.handleTwoFactorEnable)
+		admin.POST("/account/2fa/disable", s.handleTwoFactorDisable)
+		admin.POST("/account/2fa/recovery-codes", s.handleRegenerateRecoveryCodes)
+		admin.DELETE("/users/:id/2fa", s.handleResetUserTwoFactor)
+
+		// 登录会话
+		admin.GET("/sessions", s.handleAdminSessions)
+		admin.DELETE("/sessions/:id", s.handleRevokeAdminSession)
+
 		// 健康检查手动刷新
 		admin.POST("/health/refresh", s.handleRefreshHealth)
 		admin.POST("/health/refresh/:groupId", s.handleRefreshGroupHealth)
@@ -242,18 +444,35 @@ func (s *MultiProviderServer) setupRoutes() {
 		admin.PUT("/groups/:groupId", s.handleUpdateGroup)
 		admin.DELETE("/groups/:groupId", s.handleDeleteGroup)
 		admin.POST("/groups/:groupId/toggle", s.handleToggleGroup)
+		admin.POST("/groups/batch/status", s.handleBatchToggleGroups)
+		admin.POST("/groups/batch/delete", s.handleBatchDeleteGroups)
+		admin.GET("/groups/archived", s.handleArchivedGroups)
+		admin.DELETE("/groups/archived/:groupId", s.handlePurgeGroup)
+		admin.GET("/groups/names", s.handleGroupNames)
+		admin.POST("/groups/:groupId/clone", s.handleCloneGroup)
+		admin.GET("/group-templates", s.handleGroupTemplates)
+		admin.POST("/group-templates", s.handleSaveGroupTemplate)
+		admin.DELETE("/group-templates/:templateId", s.handleDeleteGroupTemplate)
+		admin.POST("/group-templates/:templateId/groups", s.handleCreateGroupFromTemplate)
 		admin.POST("/groups/export", s.handleExportGroups)
 		admin.POST("/groups/import", s.handleImportGroups)
-		
+		admin.GET("/groups/bundle", s.handleExportGroupBundle)
+		admin.POST("/groups/bundle", s.handleImportGroupBundle)
+		admin.POST("/groups/reload", s.handleReloadGroups)
+
 		// 密钥管理新功能
 		admin.POST("/groups/:groupId/keys/force-status", s.handleForceKeyStatus)
+		admin.PUT("/groups/:groupId/keys/metadata", s.handleUpdateKeyMetadata)
 		admin.DELETE("/groups/:groupId/keys/invalid", s.handleDeleteInvalidKeys)
 	}
 
 	// Web认证
-	s.router.GET("/auth/login", s.authManager.HandleLoginPage)
-	s.router.POST("/auth/login", s.authManager.HandleLogin)
-	s.router.POST("/auth/logout", s.authManager.HandleLogout)
+	adminIPFilter := s.authManager.AdminIPFilterMiddleware()
+	s.router.GET("/auth/login", adminIPFilter, s.authManager.HandleLoginPage)
+	s.router.POST("/auth/login", adminIPFilter, s.authManager.HandleLogin)
+	s.router.POST("/auth/logout", adminIPFilter, s.authManager.HandleLogout)
+	s.router.GET("/auth/oidc/login", adminIPFilter, s.authManager.HandleOIDCLogin)
+	s.router.GET("/auth/oidc/callback", adminIPFilter, s.authManager.HandleOIDCCallback)
 
 	// 静态文件
 	s.router.Static("/static", "./web/static")
@@ -264,13 +483,34 @@ func (s *MultiProviderServer) setupRoutes() {
 	s.router.StaticFile("/favicon.svg", "./web/templates/favicon.svg")
 
 	// Web界面（需要Web认证）
-	s.router.GET("/", s.authManager.WebAuthMiddleware(), s.handleIndex)
-	s.router.GET("/dashboard", s.authManager.WebAuthMiddleware(), s.handleMultiProviderDashboard)
-	s.router.GET("/logs", s.authManager.WebAuthMiddleware(), s.handleLogsPage)
-	s.router.GET("/groups", s.authManager.WebAuthMiddleware(), s.handleGroupsManagePage)
+	s.router.GET("/", adminIPFilter, s.authManager.WebAuthMiddleware(), s.handleIndex)
+	s.router.GET("/dashboard", adminIPFilter, s.authManager.WebAuthMiddleware(), s.handleMultiProviderDashboard)
+	s.router.GET("/logs", adminIPFilter, s.authManager.WebAuthMiddleware(), s.handleLogsPage)
+	s.router.GET("/groups", adminIPFilter, s.authManager.WebAuthMiddleware(), s.handleGroupsManagePage)
+	s.router.GET("/auth/2fa", adminIPFilter, s.authManager.WebAuthMiddleware(), s.authManager.HandleTwoFactorPage)
+
+	// 代理密钥一次性分享链接（不需要认证，凭链接令牌访问）
+	s.router.GET("/share/proxy-key/:token", s.handleProxyKeySharePage)
+	s.router.POST("/share/proxy-key/:token", s.handleRedeemProxyKeyShare)
 
 	// 健康检查（不需要认证）
 	s.router.GET("/health", s.handleHealth)
+
+	// Prometheus指标（与管理API相同的认证，可使用只读管理API令牌抓取）
+	metricsEndpoint := "/metrics"
+	if s.config.Monitoring != nil && s.config.Monitoring.MetricsEndpoint != "" {
+		metricsEndpoint = s.config.Monitoring.MetricsEndpoint
+	}
+	s.router.GET(metricsEndpoint, adminIPFilter, s.authManager.AuthMiddleware(), s.handleMetrics)
+}
+
+// handleMetrics 按Prometheus文本格式输出指标
+func (s *MultiProviderServer) handleMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := metrics.Default.WriteText(c.Writer); err != nil {
+		log.Printf("Failed to write metrics: %v", err)
+	}
 }
 
 // handleChatCompletions 处理聊天完成请求
@@ -280,6 +520,21 @@ func (s *MultiProviderServer) handleChatCompletions(c *gin.Context) {
 	s.proxy.HandleChatCompletion(c)
 }
 
+// handleDebugEcho 处理请求回显（调试用，默认关闭）
+func (s *MultiProviderServer) handleDebugEcho(c *gin.Context) {
+	if debug := s.configManager.Snapshot().Debug; debug == nil || !debug.EchoEnabled {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"message": "Debug echo endpoint is disabled",
+				"type":    "invalid_request_error",
+				"code":    "debug_disabled",
+			},
+		})
+		return
+	}
+	s.proxy.HandleDebugEcho(c)
+}
+
 // handleModels 处理模型列表请求
 func (s *MultiProviderServer) handleModels(c *gin.Context) {
 	// 获取代理密钥信息
@@ -384,9 +639,12 @@ func (s *MultiProviderServer) handleOpenAIModels(c *gin.Context, proxyKey *logge
 
 	for currentGroupID, group := range accessibleGroups {
 		models := s.getModelsForGroup(currentGroupID, group)
-		// 将模型添加到map中，以id为key进行去重
+		// 将模型添加到map中，以id为key进行去重，并过滤代理密钥不允许请求的模型
 		for _, model := range models {
 			if id, ok := model["id"].(string); ok {
+				if !proxykey.ModelAllowed(proxyKey.AllowedModels, proxyKey.DeniedModels, id) {
+					continue
+				}
 				modelMap[id] = model
 			}
 		}
@@ -405,7 +663,6 @@ func (s *MultiProviderServer) handleOpenAIModels(c *gin.Context, proxyKey *logge
 	})
 }
 
-
 // getModelsForGroup 获取指定分组的模型列表
 func (s *MultiProviderServer) getModelsForGroup(groupID string, group *internal.UserGroup) []map[string]interface{} {
 	var models []map[string]interface{}
@@ -434,8 +691,12 @@ func (s *MultiProviderServer) getModelsForGroup(groupID string, group *internal.
 	dynamicModels := s.getDynamicModelsForGroup(groupID, group)
 	if len(dynamicModels) > 0 {
 		models = append(models, dynamicModels...)
+	} else if fallbackModels := s.getFallbackModels(group.ProviderType); len(fallbackModels) > 0 {
+		// 动态获取失败时使用该提供商类型的备用模型目录
+		log.Printf("分组 %s 动态获取模型失败，使用 %d 个备用模型", groupID, len(fallbackModels))
+		models = append(models, fallbackModels...)
 	} else {
-		// 如果动态获取失败，返回一个通用占位符，表示支持所有模型
+		// 没有备用模型目录时返回一个通用占位符，表示支持所有模型
 		log.Printf("分组 %s 动态获取模型失败，返回通用占位符", groupID)
 		models = append(models, map[string]interface{}{
 			"id":       "all-models-supported",
@@ -539,173 +800,18 @@ func (s *MultiProviderServer) getOwnerByModelID(modelID string) string {
 	return "openai"
 }
 
-// getOpenAIModels 获取OpenAI模型列表
-func (s *MultiProviderServer) getOpenAIModels() []map[string]interface{} {
-	return []map[string]interface{}{
-		{
-			"id":       "gpt-3.5-turbo",
-			"object":   "model",
-			"created":  1640995200, // 2022-01-01
-			"owned_by": "openai",
-		},
-		{
-			"id":       "gpt-3.5-turbo-16k",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "openai",
-		},
-		{
-			"id":       "gpt-4",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "openai",
-		},
-		{
-			"id":       "gpt-4-turbo",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "openai",
-		},
-		{
-			"id":       "gpt-4o",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "openai",
-		},
-		{
-			"id":       "gpt-4o-mini",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "openai",
-		},
-	}
-}
-
-// getOpenRouterModels 获取OpenRouter模型列表
-func (s *MultiProviderServer) getOpenRouterModels() []map[string]interface{} {
-	// OpenRouter支持大量模型，这里返回一些常用的
-	return []map[string]interface{}{
-		{
-			"id":       "openai/gpt-3.5-turbo",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "openai",
-		},
-		{
-			"id":       "openai/gpt-4",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "openai",
-		},
-		{
-			"id":       "openai/gpt-4-turbo",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "openai",
-		},
-		{
-			"id":       "anthropic/claude-3-sonnet",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "anthropic",
-		},
-		{
-			"id":       "anthropic/claude-3-opus",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "anthropic",
-		},
-		{
-			"id":       "google/gemini-pro",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "google",
-		},
-	}
-}
-
-// getAnthropicModels 获取Anthropic模型列表
-func (s *MultiProviderServer) getAnthropicModels() []map[string]interface{} {
-	return []map[string]interface{}{
-		{
-			"id":       "claude-3-sonnet-20240229",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "anthropic",
-		},
-		{
-			"id":       "claude-3-opus-20240229",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "anthropic",
-		},
-		{
-			"id":       "claude-3-haiku-20240307",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "anthropic",
-		},
-		{
-			"id":       "claude-3-5-sonnet-20241022",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "anthropic",
-		},
-		{
-			"id":       "claude-2.1",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "anthropic",
-		},
-		{
-			"id":       "claude-2.0",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "anthropic",
-		},
-	}
-}
-
-// getGeminiModels 获取Gemini模型列表
-func (s *MultiProviderServer) getGeminiModels() []map[string]interface{} {
-	return []map[string]interface{}{
-		{
-			"id":       "gemini-2.5-flash",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "google",
-		},
-		{
-			"id":       "gemini-2.5-pro",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "google",
-		},
-		{
-			"id":       "gemini-1.5-flash",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "google",
-		},
-		{
-			"id":       "gemini-1.5-pro",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "google",
-		},
-		{
-			"id":       "gemini-pro",
-			"object":   "model",
-			"created":  1640995200,
-			"owned_by": "google",
-		},
-		{
-			"id":       "gemini-pro-vision",
+// getFallbackModels 获取提供商类型的备用模型目录，转换为OpenAI模型格式
+func (s *MultiProviderServer) getFallbackModels(providerType string) []map[string]interface{} {
+	var models []map[string]interface{}
+	for _, modelID := range s.configManager.GetFallbackModels(providerType) {
+		models = append(models, map[string]interface{}{
+			"id":       modelID,
 			"object":   "model",
 			"created":  1640995200,
-			"owned_by": "google",
-		},
+			"owned_by": s.getOwnerByModelID(modelID),
+		})
 	}
+	return models
 }
 
 // hasGroupAccess 检查代理密钥是否有访问指定分组的权限
@@ -815,109 +921,376 @@ func (s *MultiProviderServer) handleProviderHealth(c *gin.Context) {
 	c.JSON(http.StatusOK, health)
 }
 
-// handleStatus 处理状态查询
-func (s *MultiProviderServer) handleStatus(c *gin.Context) {
-	systemHealth := s.healthChecker.GetSystemHealth()
+// handleRouterFailures 查询各分组的路由失败计数和屏蔽状态
+func (s *MultiProviderServer) handleRouterFailures(c *gin.Context) {
+	states := s.proxy.GetProviderRouter().GetFailureStates()
+
+	blocked := 0
+	for _, state := range states {
+		if state.Blocked {
+			blocked++
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"status":          systemHealth.Status,
-		"timestamp":       time.Now(),
-		"uptime":          systemHealth.Uptime,
-		"total_groups":    systemHealth.TotalGroups,
-		"enabled_groups":  systemHealth.EnabledGroups,
-		"disabled_groups": systemHealth.DisabledGroups,
-		"total_keys":      systemHealth.TotalKeys,
-		"active_keys":     systemHealth.ActiveKeys,
+		"success":       true,
+		"groups":        states,
+		"blocked_count": blocked,
 	})
 }
 
-// handleGroupsStatus 处理分组状态查询
-func (s *MultiProviderServer) handleGroupsStatus(c *gin.Context) {
-	// 从数据库获取分组信息（包含创建时间，按创建时间倒序）
-	groupsWithMetadata, err := s.configManager.GetGroupsWithMetadata()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to load groups: " + err.Error(),
-		})
-		return
-	}
+// handleResetRouterFailures 清除分组的路由失败状态
+func (s *MultiProviderServer) handleResetRouterFailures(c *gin.Context) {
+	groupID := c.Param("groupId")
+	s.proxy.GetProviderRouter().ResetGroupFailures(groupID)
+	s.recordAudit(c, "router_failures.reset", groupID, nil, nil)
 
-	groups := make(map[string]interface{})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Router failure state cleared for group " + groupID,
+	})
+}
 
-	for groupID, groupInfo := range groupsWithMetadata {
-		// 添加总密钥数
-		if apiKeys, ok := groupInfo["api_keys"].([]string); ok {
-			groupInfo["total_keys"] = len(apiKeys)
-		} else {
-			groupInfo["total_keys"] = 0
-		}
+// handleQuotaUsage 获取各密钥和分组的额度使用比例、预警阈值及最近的预警事件
+func (s *MultiProviderServer) handleQuotaUsage(c *gin.Context) {
+	warningPercent, criticalPercent := proxy.QuotaThresholds(s.configManager.Snapshot())
+	usage, events := s.proxy.GetQuotaUsage()
 
-		// 获取健康状态，如果没有健康检查记录则默认为健康
-		if healthStatus, exists := s.healthChecker.GetProviderHealth(groupID); exists {
-			groupInfo["healthy"] = healthStatus.Healthy
-			groupInfo["last_error"] = healthStatus.LastError
-		} else {
-			// 新分组默认为健康状态
-			groupInfo["healthy"] = true
-			groupInfo["last_check"] = nil
-			groupInfo["response_time"] = 0
-			groupInfo["last_error"] = ""
+	warnings := make([]proxy.QuotaStatus, 0)
+	for _, status := range usage {
+		if status.Level != proxy.QuotaLevelOK {
+			warnings = append(warnings, status)
 		}
-
-		groups[groupID] = groupInfo
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"groups": groups,
+		"success": true,
+		"thresholds": gin.H{
+			"warning_percent":  warningPercent,
+			"critical_percent": criticalPercent,
+		},
+		"usage":    usage,
+		"warnings": warnings,
+		"events":   events,
 	})
 }
 
-// handleGroupKeysStatus 处理特定分组的密钥状态查询
-func (s *MultiProviderServer) handleGroupKeysStatus(c *gin.Context) {
-	groupID := c.Param("groupId")
-
-	groupStatus, exists := s.keyManager.GetGroupStatus(groupID)
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "Group not found",
+// handleAutoModelStatus 获取自动模型别名各质量等级的候选模型排序，包括价格和测量的延迟
+func (s *MultiProviderServer) handleAutoModelStatus(c *gin.Context) {
+	status := s.proxy.GetAutoModelStatus()
+	if status == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"enabled": false,
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, groupStatus)
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"enabled":      true,
+		"alias":        status["alias"],
+		"strategy":     status["strategy"],
+		"default_tier": status["default_tier"],
+		"tiers":        status["tiers"],
+	})
 }
 
-// handleAllModels 处理所有模型列表请求 - 返回分组配置中选择的模型
-func (s *MultiProviderServer) handleAllModels(c *gin.Context) {
-	allGroups := s.configManager.GetAllGroups()
-	allModels := make(map[string]interface{})
-
-	for groupID, group := range allGroups {
-		if !group.Enabled {
-			continue // 跳过禁用的分组
+// handleStickySessions 获取当前实例的会话粘滞绑定
+func (s *MultiProviderServer) handleStickySessions(c *gin.Context) {
+	sessions := s.proxy.GetStickySessions()
+	if groupID := c.Query("group"); groupID != "" {
+		filtered := make([]proxy.StickySession, 0, len(sessions))
+		for _, session := range sessions {
+			if session.GroupID == groupID {
+				filtered = append(filtered, session)
+			}
 		}
+		sessions = filtered
+	}
 
-		// 构建模型列表 - 使用分组配置中的模型
-		var modelList []map[string]interface{}
+	settings := s.configManager.Snapshot().GlobalSettings
+	enabled := settings != nil && settings.StickySessions != nil && settings.StickySessions.Enabled
 
-		if len(group.Models) > 0 {
-			// 如果分组配置了特定模型，使用配置的模型
-			for _, modelID := range group.Models {
-				modelList = append(modelList, map[string]interface{}{
-					"id":       modelID,
-					"object":   "model",
-					"owned_by": s.getProviderOwner(group.ProviderType),
-				})
-			}
-		} else {
-			// 如果没有配置特定模型，表示支持所有模型，返回一个通用提示
-			modelList = append(modelList, map[string]interface{}{
-				"id":       "all-models-supported",
-				"object":   "model",
-				"owned_by": s.getProviderOwner(group.ProviderType),
-				"note":     "This provider supports all available models",
-			})
-		}
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"enabled":  enabled,
+		"total":    len(sessions),
+		"sessions": sessions,
+	})
+}
+
+// handleClearStickySessions 删除会话粘滞绑定，可通过 group 参数只删除绑定到指定分组的会话
+func (s *MultiProviderServer) handleClearStickySessions(c *gin.Context) {
+	groupID := c.Query("group")
+	removed := s.proxy.ClearStickySessions(groupID)
+
+	s.recordAudit(c, "sticky_sessions.clear", groupID, nil, map[string]interface{}{"removed": removed})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": fmt.Sprintf("Cleared %d sticky sessions", removed),
+		"removed": removed,
+	})
+}
+
+// handleRateLimitStats 获取各分组当前的限流窗口使用情况
+func (s *MultiProviderServer) handleRateLimitStats(c *gin.Context) {
+	config := s.configManager.Snapshot()
+	rpmStats := s.proxy.GetRPMStats()
+
+	groupIDs := make([]string, 0, len(config.UserGroups))
+	for groupID := range config.UserGroups {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Strings(groupIDs)
+
+	groups := make([]gin.H, 0, len(groupIDs))
+	for _, groupID := range groupIDs {
+		group := config.UserGroups[groupID]
+
+		rpmCurrent := 0
+		rpmRemaining := -1 // -1表示不限制
+		if group.RPMLimit > 0 {
+			rpmRemaining = group.RPMLimit
+		}
+		if stats, exists := rpmStats[groupID]; exists {
+			rpmCurrent = stats["current"]
+			if group.RPMLimit > 0 {
+				// 设置了突发容量时还受可用令牌数限制
+				rpmRemaining = stats["remaining"]
+			}
+		}
+
+		currentConcurrency, keyConcurrency := s.proxy.GetConcurrencyStats(groupID, group.APIKeys)
+		keys := make([]gin.H, 0, len(group.APIKeys))
+		for _, apiKey := range group.APIKeys {
+			keys = append(keys, gin.H{
+				"key":        s.maskKey(apiKey),
+				"concurrent": keyConcurrency[apiKey],
+			})
+		}
+
+		// 按模型的限制和当前窗口用量
+		var models []gin.H
+		if len(group.ModelLimits) > 0 {
+			usage := s.proxy.GetModelLimitStats(groupID)
+			modelNames := make([]string, 0, len(group.ModelLimits))
+			for model := range group.ModelLimits {
+				modelNames = append(modelNames, model)
+			}
+			sort.Strings(modelNames)
+			for _, model := range modelNames {
+				limit := group.ModelLimits[model]
+				models = append(models, gin.H{
+					"model":     model,
+					"rpm_limit": limit.RPM,
+					"tpm_limit": limit.TPM,
+					"requests":  usage[model].Requests,
+					"tokens":    usage[model].Tokens,
+				})
+			}
+		}
+
+		groups = append(groups, gin.H{
+			"group_id":   groupID,
+			"group_name": group.Name,
+			"enabled":    group.Enabled,
+			"models":     models,
+			"rpm": gin.H{
+				"limit":     group.RPMLimit,
+				"burst":     group.RPMBurst,
+				"current":   rpmCurrent,
+				"remaining": rpmRemaining,
+			},
+			"concurrency": gin.H{
+				"limit":         group.MaxConcurrent,
+				"per_key_limit": group.MaxConcurrentPerKey,
+				"current":       currentConcurrency,
+			},
+			"keys": keys,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"window":        "1m",
+		"groups":        groups,
+		"server_limits": s.serverRateLimitStats(),
+		"checked_at":    time.Now(),
+	})
+}
+
+// handleResetRateLimit 清空分组当前的RPM窗口，用于事故处理时解除误限流
+func (s *MultiProviderServer) handleResetRateLimit(c *gin.Context) {
+	groupID := c.Param("groupId")
+	if _, exists := s.configManager.GetGroup(groupID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Group not found",
+		})
+		return
+	}
+
+	reset := s.proxy.ResetRPMWindow(groupID)
+	s.recordAudit(c, "ratelimit.reset", groupID, nil, nil)
+
+	message := "Rate limit window reset for group " + groupID
+	if !reset {
+		message = "Group " + groupID + " has no active rate limit window"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": message,
+	})
+}
+
+// handleStatus 处理状态查询
+func (s *MultiProviderServer) handleStatus(c *gin.Context) {
+	systemHealth := s.healthChecker.GetSystemHealth()
+
+	var logQueue logger.LogQueueStats
+	database := s.configManager.Snapshot().Database
+	storage := gin.H{
+		"max_size_bytes":           int64(database.MaxSizeMB) << 20,
+		"retention_days":           database.RetentionDays,
+		"group_retention_days":     database.GroupRetention,
+		"proxy_key_retention_days": database.KeyRetention,
+	}
+	if s.requestLogger != nil {
+		logQueue = s.requestLogger.QueueStats()
+		if stats, err := s.requestLogger.StorageStats(); err != nil {
+			storage["error"] = err.Error()
+		} else {
+			storage["size_bytes"] = stats.SizeBytes
+			storage["allocated_bytes"] = stats.AllocatedBytes
+			storage["rows"] = stats.Rows
+			storage["checked_at"] = stats.CheckedAt
+		}
+	}
+	if s.backupScheduler != nil {
+		storage["last_backup"] = s.backupScheduler.Last()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":          systemHealth.Status,
+		"timestamp":       time.Now(),
+		"uptime":          systemHealth.Uptime,
+		"total_groups":    systemHealth.TotalGroups,
+		"enabled_groups":  systemHealth.EnabledGroups,
+		"disabled_groups": systemHealth.DisabledGroups,
+		"total_keys":      systemHealth.TotalKeys,
+		"active_keys":     systemHealth.ActiveKeys,
+		"log_queue":       logQueue,
+		"storage":         storage,
+		"shared_state":    s.sharedState != nil,
+	})
+}
+
+// handleGroupsStatus 处理分组状态查询
+func (s *MultiProviderServer) handleGroupsStatus(c *gin.Context) {
+	// 从数据库获取分组信息（包含创建时间，按创建时间倒序）
+	groupsWithMetadata, err := s.configManager.GetGroupsWithMetadata()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load groups: " + err.Error(),
+		})
+		return
+	}
+
+	groups := make(map[string]interface{})
+
+	for groupID, groupInfo := range groupsWithMetadata {
+		// 添加总密钥数
+		apiKeys, _ := groupInfo["api_keys"].([]string)
+		groupInfo["total_keys"] = len(apiKeys)
+
+		// 添加当前并发数
+		currentConcurrency, _ := s.proxy.GetConcurrencyStats(groupID, nil)
+		groupInfo["current_concurrency"] = currentConcurrency
+
+		// 获取健康状态，如果没有健康检查记录则默认为健康
+		if healthStatus, exists := s.healthChecker.GetProviderHealth(groupID); exists {
+			groupInfo["healthy"] = healthStatus.Healthy
+			groupInfo["last_error"] = healthStatus.LastError
+		} else {
+			// 新分组默认为健康状态
+			groupInfo["healthy"] = true
+			groupInfo["last_check"] = nil
+			groupInfo["response_time"] = 0
+			groupInfo["last_error"] = ""
+		}
+
+		groups[groupID] = groupInfo
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"groups": groups,
+	})
+}
+
+// handleGroupKeysStatus 处理特定分组的密钥状态查询
+func (s *MultiProviderServer) handleGroupKeysStatus(c *gin.Context) {
+	groupID := c.Param("groupId")
+
+	groupStatus, exists := s.keyManager.GetGroupStatus(groupID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Group not found",
+		})
+		return
+	}
+
+	// 附加分组及各密钥的当前并发数
+	if groupInfo, ok := groupStatus.(map[string]interface{}); ok {
+		var apiKeys []string
+		if group, exists := s.configManager.GetGroup(groupID); exists {
+			apiKeys = group.APIKeys
+			groupInfo["max_concurrent"] = group.MaxConcurrent
+			groupInfo["max_concurrent_per_key"] = group.MaxConcurrentPerKey
+		}
+		currentConcurrency, keyConcurrency := s.proxy.GetConcurrencyStats(groupID, apiKeys)
+		groupInfo["current_concurrency"] = currentConcurrency
+		groupInfo["key_concurrency"] = keyConcurrency
+	}
+
+	c.JSON(http.StatusOK, groupStatus)
+}
+
+// handleAllModels 处理所有模型列表请求 - 返回分组配置中选择的模型
+func (s *MultiProviderServer) handleAllModels(c *gin.Context) {
+	allGroups := s.configManager.GetAllGroups()
+	allModels := make(map[string]interface{})
+
+	for groupID, group := range allGroups {
+		if !group.Enabled {
+			continue // 跳过禁用的分组
+		}
+
+		// 构建模型列表 - 使用分组配置中的模型
+		var modelList []map[string]interface{}
+
+		if len(group.Models) > 0 {
+			// 如果分组配置了特定模型，使用配置的模型
+			for _, modelID := range group.Models {
+				modelList = append(modelList, map[string]interface{}{
+					"id":       modelID,
+					"object":   "model",
+					"owned_by": s.getProviderOwner(group.ProviderType),
+				})
+			}
+		} else if fallbackModels := s.getFallbackModels(group.ProviderType); len(fallbackModels) > 0 {
+			// 没有配置特定模型时使用该提供商类型的备用模型目录
+			modelList = fallbackModels
+		} else {
+			// 如果没有配置特定模型，表示支持所有模型，返回一个通用提示
+			modelList = append(modelList, map[string]interface{}{
+				"id":       "all-models-supported",
+				"object":   "model",
+				"owned_by": s.getProviderOwner(group.ProviderType),
+				"note":     "This provider supports all available models",
+			})
+		}
 
 		// 添加到结果中
 		allModels[groupID] = map[string]interface{}{
@@ -965,6 +1338,9 @@ func (s *MultiProviderServer) handleGroupModels(c *gin.Context) {
 				"owned_by": s.getProviderOwner(group.ProviderType),
 			})
 		}
+	} else if fallbackModels := s.getFallbackModels(group.ProviderType); len(fallbackModels) > 0 {
+		// 没有配置特定模型时使用该提供商类型的备用模型目录
+		modelList = fallbackModels
 	} else {
 		// 如果没有配置特定模型，表示支持所有模型，返回一个通用提示
 		modelList = append(modelList, map[string]interface{}{
@@ -1000,6 +1376,8 @@ func (s *MultiProviderServer) getProviderOwner(providerType string) string {
 		return "openai"
 	case "azure_openai":
 		return "openai"
+	case "openai_compatible":
+		return "local"
 	case "anthropic":
 		return "anthropic"
 	case "gemini":
@@ -1041,7 +1419,7 @@ func (s *MultiProviderServer) handleAvailableModels(c *gin.Context) {
 	}
 
 	// 检查是否有API密钥
-	if len(group.APIKeys) == 0 {
+	if len(group.APIKeys) == 0 && !group.IsKeyless() {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
 				"message": "No API keys configured for this group",
@@ -1054,12 +1432,18 @@ func (s *MultiProviderServer) handleAvailableModels(c *gin.Context) {
 
 	// 创建提供商配置
 	providerConfig := &providers.ProviderConfig{
-		BaseURL:      group.BaseURL,
-		APIKey:       group.APIKeys[0], // 使用第一个API密钥
-		Timeout:      group.Timeout,
-		MaxRetries:   group.MaxRetries,
-		Headers:      group.Headers,
-		ProviderType: group.ProviderType,
+		BaseURL:             group.BaseURL,
+		APIKey:              group.FirstAPIKey(), // 使用第一个API密钥
+		Timeout:             group.Timeout,
+		MaxRetries:          group.MaxRetries,
+		Headers:             group.Headers,
+		ProviderType:        group.ProviderType,
+		ChatCompletionsPath: group.ChatCompletionsPath,
+		ModelsPath:          group.ModelsPath,
+		ProxyURL:            group.ProxyURL,
+		TLS:                 group.TLS.ProviderOptions(),
+		VertexAI:            group.VertexAI.ProviderOptions(),
+		Transport:           group.Transport.ProviderOptions(),
 	}
 
 	// 创建提供商实例
@@ -1109,12 +1493,17 @@ func (s *MultiProviderServer) handleAvailableModels(c *gin.Context) {
 // handleAvailableModelsByType 根据提供商类型和配置获取可用模型（用于新建分组时的模型选择）
 func (s *MultiProviderServer) handleAvailableModelsByType(c *gin.Context) {
 	var req struct {
-		ProviderType string            `json:"provider_type" binding:"required"`
-		BaseURL      string            `json:"base_url" binding:"required"`
-		APIKeys      []string          `json:"api_keys" binding:"required"`
-		MaxRetries   int               `json:"max_retries"`
-		Timeout      int               `json:"timeout_seconds"`
-		Headers      map[string]string `json:"headers"`
+		ProviderType string                      `json:"provider_type" binding:"required"`
+		BaseURL      string                      `json:"base_url" binding:"required"`
+		APIKeys      []string                    `json:"api_keys" binding:"required"`
+		MaxRetries   int                         `json:"max_retries"`
+		Timeout      int                         `json:"timeout_seconds"`
+		Headers      map[string]string           `json:"headers"`
+		ModelsPath   string                      `json:"models_path"`
+		ProxyURL     string                      `json:"proxy_url"`
+		TLS          *internal.TLSSettings       `json:"tls"`
+		VertexAI     *internal.VertexAISettings  `json:"vertex_ai"`
+		Transport    *internal.TransportSettings `json:"transport"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -1132,7 +1521,7 @@ func (s *MultiProviderServer) handleAvailableModelsByType(c *gin.Context) {
 		}
 	}
 
-	if len(validKeys) == 0 {
+	if len(validKeys) == 0 && providers.RequiresAPIKey(req.ProviderType) {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "At least one valid API key is required",
 		})
@@ -1157,17 +1546,27 @@ func (s *MultiProviderServer) handleAvailableModelsByType(c *gin.Context) {
 		Timeout:      time.Duration(req.Timeout) * time.Second,
 		MaxRetries:   req.MaxRetries,
 		Headers:      req.Headers,
+		ModelsPath:   req.ModelsPath,
+		ProxyURL:     strings.TrimSpace(req.ProxyURL),
+		TLS:          req.TLS,
+		VertexAI:     req.VertexAI,
+		Transport:    req.Transport,
 	}
 
 	// 创建临时提供商实例
 	factory := providers.NewDefaultProviderFactory()
 	config := &providers.ProviderConfig{
 		BaseURL:      tempGroup.BaseURL,
-		APIKey:       tempGroup.APIKeys[0], // 使用第一个API密钥进行测试
+		APIKey:       tempGroup.FirstAPIKey(), // 使用第一个API密钥进行测试
 		Timeout:      tempGroup.Timeout,
 		MaxRetries:   tempGroup.MaxRetries,
 		Headers:      tempGroup.Headers,
 		ProviderType: tempGroup.ProviderType,
+		ModelsPath:   tempGroup.ModelsPath,
+		ProxyURL:     tempGroup.ProxyURL,
+		TLS:          tempGroup.TLS.ProviderOptions(),
+		VertexAI:     tempGroup.VertexAI.ProviderOptions(),
+		Transport:    tempGroup.Transport.ProviderOptions(),
 	}
 
 	provider, err := factory.CreateProvider(config)
@@ -1232,36 +1631,21 @@ func (s *MultiProviderServer) handleValidateKeys(c *gin.Context) {
 		return
 	}
 
-	// 选择用于测试的模型（优先使用配置的第一个模型，否则使用默认模型）
-	var testModel string
-	if len(group.Models) > 0 {
-		testModel = group.Models[0]
-	} else {
-		// 根据提供商类型选择默认测试模型
-		switch group.ProviderType {
-		case "openai", "azure_openai":
-			testModel = "gpt-3.5-turbo"
-		case "anthropic":
-			testModel = "claude-3-haiku-20240307"
-		case "gemini":
-			testModel = "gemini-2.5-flash"
-		default:
-			testModel = "gpt-3.5-turbo" // 默认模型
-		}
-	}
+	// 选择用于测试的模型（优先使用健康检查模型和配置的第一个模型，否则使用默认模型）
+	testModel := group.TestModel()
 
-	log.Printf("🔍 开始批量验证密钥: 分组=%s, 提供商=%s, 密钥数量=%d, 测试模型=%s",
-		groupID, group.ProviderType, len(req.APIKeys), testModel)
+	slog.InfoContext(c.Request.Context(), "开始批量验证密钥",
+		"group", groupID, "provider", group.ProviderType, "keys", len(req.APIKeys), "test_model", testModel)
 
 	// 使用批量验证模式，提高效率
 	results := make([]map[string]interface{}, len(req.APIKeys))
-	log.Printf("⚙️ 采用批量验证模式，批次大小=8，无固定延迟，提高验证效率")
+	slog.DebugContext(c.Request.Context(), "采用批量验证模式", "batch_size", 8)
 
 	// 批量验证API密钥
 	s.validateKeysInBatches(groupID, req.APIKeys, testModel, group, results)
 
 	// 所有验证已完成（顺序执行）
-	log.Printf("✅ 所有密钥验证已完成")
+	slog.DebugContext(c.Request.Context(), "所有密钥验证已完成", "group", groupID)
 
 	// 统计结果
 	validCount := 0
@@ -1274,9 +1658,15 @@ func (s *MultiProviderServer) handleValidateKeys(c *gin.Context) {
 		}
 	}
 
-	log.Printf("📊 验证结果统计: 总计=%d, 有效=%d, 无效=%d, 成功率=%.1f%%",
-		len(req.APIKeys), validCount, invalidCount,
-		float64(validCount)/float64(len(req.APIKeys))*100)
+	slog.InfoContext(c.Request.Context(), "密钥验证完成", "group", groupID,
+		"total", len(req.APIKeys), "valid", validCount, "invalid", invalidCount)
+
+	s.recordAudit(c, "keys.validate", groupID, nil, gin.H{
+		"test_model":   testModel,
+		"total_keys":   len(req.APIKeys),
+		"valid_keys":   validCount,
+		"invalid_keys": invalidCount,
+	})
 
 	c.JSON(http.StatusOK, gin.H{
 		"success":      true,
@@ -1293,45 +1683,44 @@ func (s *MultiProviderServer) validateKeyWithRetry(groupID, apiKey, testModel st
 	var lastErr error
 	maskedKey := s.maskKey(apiKey)
 
-	log.Printf("🔑 开始验证密钥: %s (分组: %s, 提供商: %s, 模型: %s)", maskedKey, groupID, group.ProviderType, testModel)
+	slog.Debug("开始验证密钥", "key", maskedKey, "group", groupID, "provider", group.ProviderType, "model", testModel)
 
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		log.Printf("🔄 密钥验证尝试 %d/%d: %s", attempt, maxRetries, maskedKey)
+		slog.Debug("密钥验证尝试", "key", maskedKey, "attempt", attempt, "max_retries", maxRetries)
 
 		// 创建提供商配置，强制使用300秒超时进行验证
 		providerConfig := &providers.ProviderConfig{
-			BaseURL:      group.BaseURL,
-			APIKey:       apiKey,
-			Timeout:      time.Duration(300) * time.Second, // 强制300秒超时，忽略分组配置
-			MaxRetries:   1,
-			Headers:      group.Headers,
-			ProviderType: group.ProviderType,
-		}
-
-		log.Printf("📋 提供商配置: BaseURL=%s, ProviderType=%s, Timeout=300s (强制设置)",
-			func() string {
-				if group.BaseURL != "" {
-					return group.BaseURL
-				}
-				return "默认"
-			}(), group.ProviderType)
-		log.Printf("📝 注意: 分组原始超时=%v, 验证时强制使用300s", group.Timeout)
+			BaseURL:             group.BaseURL,
+			APIKey:              apiKey,
+			Timeout:             time.Duration(300) * time.Second, // 强制300秒超时，忽略分组配置
+			MaxRetries:          1,
+			Headers:             group.Headers,
+			ProviderType:        group.ProviderType,
+			ChatCompletionsPath: group.ChatCompletionsPath,
+			ModelsPath:          group.ModelsPath,
+			ProxyURL:            group.ProxyURL,
+			TLS:                 group.TLS.ProviderOptions(),
+			VertexAI:            group.VertexAI.ProviderOptions(),
+			Transport:           group.Transport.ProviderOptions(),
+		}
+
+		// 验证时强制使用300s超时，忽略分组配置的超时
+		slog.Debug("验证使用的提供商配置", "base_url", group.BaseURL, "provider", group.ProviderType,
+			"timeout", "300s", "group_timeout", group.Timeout)
 
 		// 获取提供商实例
 		providerID := fmt.Sprintf("%s_validate_%s_%d", groupID, apiKey[:min(8, len(apiKey))], attempt)
-		log.Printf("🏭 创建提供商实例: %s", providerID)
+		slog.Debug("创建提供商实例", "provider_id", providerID)
 
 		provider, err := s.proxy.GetProviderManager().GetProvider(providerID, providerConfig)
 		if err != nil {
 			lastErr = fmt.Errorf("failed to create provider (attempt %d/%d): %w", attempt, maxRetries, err)
-			log.Printf("❌ 创建提供商失败 (尝试 %d/%d): %v", attempt, maxRetries, err)
+			slog.Error("创建提供商失败", "attempt", attempt, "max_retries", maxRetries, "error", err)
 			continue
 		}
 
-		log.Printf("✅ 提供商实例创建成功")
-
 		// 验证密钥
-		log.Printf("🚀 发送测试请求到 %s 模型...", testModel)
+		slog.Debug("发送测试请求", "model", testModel)
 		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
 
 		startTime := time.Now()
@@ -1345,26 +1734,28 @@ func (s *MultiProviderServer) validateKeyWithRetry(groupID, apiKey, testModel st
 
 		if err == nil {
 			// 验证成功
-			log.Printf("✅ 密钥验证成功: %s (耗时: %v)", maskedKey, duration)
+			attrs := []any{"key", maskedKey, "duration_ms", duration.Milliseconds()}
 			if response != nil && len(response.Choices) > 0 {
-				log.Printf("📝 响应内容长度: %d 字符", len(response.Choices[0].Message.Content))
+				attrs = append(attrs, "response_length", len(response.Choices[0].Message.Content))
 			}
+			slog.Debug("密钥验证成功", attrs...)
 			return true, nil
 		}
 
 		lastErr = fmt.Errorf("validation failed (attempt %d/%d): %w", attempt, maxRetries, err)
-		log.Printf("❌ 密钥验证失败 (尝试 %d/%d, 耗时: %v): %v", attempt, maxRetries, duration, err)
+		slog.Warn("密钥验证失败", "key", maskedKey, "attempt", attempt, "max_retries", maxRetries,
+			"duration_ms", duration.Milliseconds(), "error", err)
 
 		// 如果不是最后一次尝试，等待一小段时间再重试
 		if attempt < maxRetries {
 			waitTime := time.Duration(attempt) * 500 * time.Millisecond
-			log.Printf("⏳ 等待 %v 后重试...", waitTime)
+			slog.Debug("等待后重试", "wait", waitTime)
 			time.Sleep(waitTime) // 递增等待时间
 		}
 	}
 
 	// 所有重试都失败
-	log.Printf("💥 密钥验证最终失败: %s (已尝试 %d 次)", maskedKey, maxRetries)
+	slog.Warn("密钥验证最终失败", "key", maskedKey, "attempts", maxRetries, "error", lastErr)
 	return false, lastErr
 }
 
@@ -1380,12 +1771,12 @@ func (s *MultiProviderServer) validateKeysInBatches(groupID string, apiKeys []st
 		}
 
 		currentBatch := apiKeys[batchStart:batchEnd]
-		log.Printf("🔄 开始处理批次 %d-%d/%d", batchStart+1, batchEnd, len(apiKeys))
+		slog.Debug("开始处理验证批次", "group", groupID, "from", batchStart+1, "to", batchEnd, "total", len(apiKeys))
 
 		// 并发验证当前批次的密钥
 		s.validateBatchConcurrently(groupID, currentBatch, batchStart, testModel, group, results)
 
-		log.Printf("✅ 批次 %d-%d/%d 验证完成", batchStart+1, batchEnd, len(apiKeys))
+		slog.Debug("验证批次完成", "group", groupID, "from", batchStart+1, "to", batchEnd, "total", len(apiKeys))
 	}
 }
 
@@ -1403,7 +1794,7 @@ func (s *MultiProviderServer) validateBatchConcurrently(groupID string, batch []
 
 			// 检查空密钥
 			if strings.TrimSpace(key) == "" {
-				log.Printf("⚠️ 跳过空密钥 (索引: %d)", actualIndex)
+				slog.Warn("跳过空密钥", "index", actualIndex)
 				results[actualIndex] = map[string]interface{}{
 					"index":   actualIndex,
 					"api_key": key,
@@ -1413,7 +1804,7 @@ func (s *MultiProviderServer) validateBatchConcurrently(groupID string, batch []
 				return
 			}
 
-			log.Printf("🎯 开始验证密钥 %d/%d: %s", actualIndex+1, len(results), s.maskKey(key))
+			slog.Debug("开始验证密钥", "index", actualIndex+1, "total", len(results), "key", s.maskKey(key))
 
 			// 验证密钥，最多重试3次
 			valid, err := s.validateKeyWithRetry(groupID, key, testModel, group, 3)
@@ -1426,18 +1817,19 @@ func (s *MultiProviderServer) validateBatchConcurrently(groupID string, batch []
 
 			// 记录验证结果
 			if valid {
-				log.Printf("✅ 密钥验证成功 %d/%d: %s", actualIndex+1, len(results), s.maskKey(key))
+				slog.Info("密钥有效", "group", groupID, "index", actualIndex+1, "total", len(results), "key", s.maskKey(key))
 			} else {
-				log.Printf("❌ 密钥验证失败 %d/%d: %s - %s", actualIndex+1, len(results), s.maskKey(key), validationError)
+				slog.Warn("密钥无效", "group", groupID, "index", actualIndex+1, "total", len(results), "key", s.maskKey(key),
+					"error", validationError)
 			}
 
 			// 异步更新数据库，避免阻塞验证流程
 			if groupID != "temp" { // 只有非临时分组才更新数据库
 				go func(gID, apiKey string, isValid bool, errMsg string) {
 					if updateErr := s.configManager.UpdateAPIKeyValidation(gID, apiKey, isValid, errMsg); updateErr != nil {
-						log.Printf("❌ 更新数据库验证状态失败 %s: %v", s.maskKey(apiKey), updateErr)
+						slog.Error("更新数据库验证状态失败", "group", groupID, "key", s.maskKey(apiKey), "error", updateErr)
 					} else {
-						log.Printf("💾 数据库验证状态已更新: %s (有效: %v)", s.maskKey(apiKey), isValid)
+						slog.Debug("数据库验证状态已更新", "group", groupID, "key", s.maskKey(apiKey), "valid", isValid)
 					}
 				}(groupID, key, valid, validationError)
 			}
@@ -1482,22 +1874,7 @@ func (s *MultiProviderServer) handleKeysStatus(c *gin.Context) {
 		}
 
 		// 选择用于测试的模型
-		var testModel string
-		if len(group.Models) > 0 {
-			testModel = group.Models[0]
-		} else {
-			// 根据提供商类型选择默认测试模型
-			switch group.ProviderType {
-			case "openai", "azure_openai":
-				testModel = "gpt-3.5-turbo"
-			case "anthropic":
-				testModel = "claude-3-haiku-20240307"
-			case "gemini":
-				testModel = "gemini-2.5-flash"
-			default:
-				testModel = "gpt-3.5-turbo"
-			}
-		}
+		testModel := group.TestModel()
 
 		// 验证每个密钥
 		validCount := 0
@@ -1517,12 +1894,18 @@ func (s *MultiProviderServer) handleKeysStatus(c *gin.Context) {
 
 			// 创建提供商配置
 			providerConfig := &providers.ProviderConfig{
-				BaseURL:      group.BaseURL,
-				APIKey:       apiKey,
-				Timeout:      10 * time.Minute, // 使用10分钟超时
-				MaxRetries:   1,
-				Headers:      group.Headers,
-				ProviderType: group.ProviderType,
+				BaseURL:             group.BaseURL,
+				APIKey:              apiKey,
+				Timeout:             10 * time.Minute, // 使用10分钟超时
+				MaxRetries:          1,
+				Headers:             group.Headers,
+				ProviderType:        group.ProviderType,
+				ChatCompletionsPath: group.ChatCompletionsPath,
+				ModelsPath:          group.ModelsPath,
+				ProxyURL:            group.ProxyURL,
+				TLS:                 group.TLS.ProviderOptions(),
+				VertexAI:            group.VertexAI.ProviderOptions(),
+				Transport:           group.Transport.ProviderOptions(),
 			}
 
 			// 获取提供商实例
@@ -1563,6 +1946,13 @@ func (s *MultiProviderServer) handleKeysStatus(c *gin.Context) {
 			}
 		}
 
+		// 设置了标签的密钥附带标签，便于识别
+		for _, result := range keyResults {
+			if label := group.APIKeyLabel(group.APIKeys[result["index"].(int)]); label != "" {
+				result["label"] = label
+			}
+		}
+
 		groupsStatus[groupID] = map[string]interface{}{
 			"group_name":    group.Name,
 			"provider_type": group.ProviderType,
@@ -1581,17 +1971,83 @@ func (s *MultiProviderServer) handleKeysStatus(c *gin.Context) {
 	})
 }
 
+// handleModelRewriteDryRun 试运行模型名称重写和路由，展示请求的模型在各分组中会被改写成什么以及最终路由到哪个分组
+// 传入 group_id 和 model_rewrites 时使用这组规则替换该分组已保存的规则，便于保存前预览
+func (s *MultiProviderServer) handleModelRewriteDryRun(c *gin.Context) {
+	var req struct {
+		Model         string                       `json:"model" binding:"required"`
+		AllowedGroups []string                     `json:"allowed_groups"`
+		GroupID       string                       `json:"group_id"`
+		ModelRewrites *[]internal.ModelRewriteRule `json:"model_rewrites"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	config := s.configManager.Snapshot()
+	if req.ModelRewrites != nil {
+		if req.GroupID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "group_id is required when model_rewrites is provided",
+			})
+			return
+		}
+		group, exists := config.UserGroups[req.GroupID]
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Group not found",
+			})
+			return
+		}
+		if err := internal.ValidateModelRewrites(*req.ModelRewrites); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+
+		// 快照不可修改，复制分组列表后替换该分组的规则
+		override := *config
+		override.UserGroups = make(map[string]*internal.UserGroup, len(config.UserGroups))
+		for groupID, g := range config.UserGroups {
+			override.UserGroups[groupID] = g
+		}
+		draft := group.Clone()
+		draft.ModelRewrites = *req.ModelRewrites
+		override.UserGroups[req.GroupID] = draft
+		config = &override
+	}
+
+	preview := s.proxy.GetProviderRouter().PreviewModelRoute(config, strings.TrimSpace(req.Model), req.AllowedGroups)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"preview": preview,
+	})
+}
+
 // handleTestModels 处理测试模型加载请求
 func (s *MultiProviderServer) handleTestModels(c *gin.Context) {
 	var testGroup struct {
-		Name             string   `json:"name"`
-		ProviderType     string   `json:"provider_type"`
-		BaseURL          string   `json:"base_url"`
-		Enabled          bool     `json:"enabled"`
-		Timeout          int      `json:"timeout"`
-		MaxRetries       int      `json:"max_retries"`
-		RotationStrategy string   `json:"rotation_strategy"`
-		APIKeys          []string `json:"api_keys"`
+		Name             string                      `json:"name"`
+		ProviderType     string                      `json:"provider_type"`
+		BaseURL          string                      `json:"base_url"`
+		Enabled          bool                        `json:"enabled"`
+		Timeout          int                         `json:"timeout"`
+		MaxRetries       int                         `json:"max_retries"`
+		RotationStrategy string                      `json:"rotation_strategy"`
+		APIKeys          []string                    `json:"api_keys"`
+		ModelsPath       string                      `json:"models_path"`
+		ProxyURL         string                      `json:"proxy_url"`
+		TLS              *internal.TLSSettings       `json:"tls"`
+		VertexAI         *internal.VertexAISettings  `json:"vertex_ai"`
+		Transport        *internal.TransportSettings `json:"transport"`
 	}
 
 	if err := c.ShouldBindJSON(&testGroup); err != nil {
@@ -1603,7 +2059,7 @@ func (s *MultiProviderServer) handleTestModels(c *gin.Context) {
 	}
 
 	// 验证必需字段
-	if testGroup.ProviderType == "" || testGroup.BaseURL == "" || len(testGroup.APIKeys) == 0 {
+	if testGroup.ProviderType == "" || testGroup.BaseURL == "" || (len(testGroup.APIKeys) == 0 && providers.RequiresAPIKey(testGroup.ProviderType)) {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"message": "Provider type, base URL, and at least one API key are required",
@@ -1621,10 +2077,15 @@ func (s *MultiProviderServer) handleTestModels(c *gin.Context) {
 		MaxRetries:       testGroup.MaxRetries,
 		RotationStrategy: testGroup.RotationStrategy,
 		APIKeys:          testGroup.APIKeys,
+		ModelsPath:       testGroup.ModelsPath,
+		ProxyURL:         strings.TrimSpace(testGroup.ProxyURL),
+		TLS:              testGroup.TLS,
+		VertexAI:         testGroup.VertexAI,
+		Transport:        testGroup.Transport,
 	}
 
 	// 使用第一个API密钥来测试模型加载
-	if len(testGroup.APIKeys) == 0 {
+	if len(testGroup.APIKeys) == 0 && !tempGroup.IsKeyless() {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"message": "No API keys provided",
@@ -1635,10 +2096,15 @@ func (s *MultiProviderServer) handleTestModels(c *gin.Context) {
 	// 创建提供商配置
 	providerConfig := &providers.ProviderConfig{
 		BaseURL:      tempGroup.BaseURL,
-		APIKey:       testGroup.APIKeys[0], // 使用第一个密钥进行测试
+		APIKey:       tempGroup.FirstAPIKey(), // 使用第一个密钥进行测试
 		Timeout:      tempGroup.Timeout,
 		MaxRetries:   tempGroup.MaxRetries,
 		ProviderType: tempGroup.ProviderType,
+		ModelsPath:   tempGroup.ModelsPath,
+		ProxyURL:     tempGroup.ProxyURL,
+		TLS:          tempGroup.TLS.ProviderOptions(),
+		VertexAI:     tempGroup.VertexAI.ProviderOptions(),
+		Transport:    tempGroup.Transport.ProviderOptions(),
 	}
 
 	// 获取提供商实例
@@ -1701,15 +2167,29 @@ func (s *MultiProviderServer) handleHealth(c *gin.Context) {
 	})
 }
 
-// Start 启动服务器
+// Start 启动服务器，按配置监听TCP端口（HTTP或HTTPS）和Unix域套接字，直到出错或 Stop 后返回
 func (s *MultiProviderServer) Start() error {
 	s.httpServer = &http.Server{
 		Addr:    s.config.GetAddress(),
 		Handler: s.router,
 	}
 
-	log.Printf("Starting multi-provider server on %s", s.config.GetAddress())
-	return s.httpServer.ListenAndServe()
+	serves, err := s.listen()
+	if err != nil {
+		return err
+	}
+	errCh := make(chan error, len(serves))
+	for _, serve := range serves {
+		go func(serve func() error) {
+			errCh <- serve()
+		}(serve)
+	}
+
+	// 任一监听出错时返回；Stop 关闭服务器后所有监听都返回 http.ErrServerClosed，不作为错误
+	if err := <-errCh; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
 
 // Stop 停止服务器
@@ -1724,17 +2204,67 @@ func (s *MultiProviderServer) Stop(ctx context.Context) error {
 		s.keyManager.Close()
 	}
 
-	// 关闭请求日志记录器
+	// 停止代理密钥过期检查
+	if s.proxyKeyManager != nil {
+		s.proxyKeyManager.Close()
+	}
+
+	// 停止告警规则评估
+	if s.alertEngine != nil {
+		s.alertEngine.Close()
+	}
+
+	// 停止预算用量刷新
+	if s.budgetEngine != nil {
+		s.budgetEngine.Close()
+	}
+
+	// 停止用量报告定时生成
+	if s.reportGenerator != nil {
+		s.reportGenerator.Close()
+	}
+
+	// 停止定时备份
+	if s.backupScheduler != nil {
+		s.backupScheduler.Close()
+	}
+
+	// 先停止接收请求，再关闭请求日志记录器，确保队列中的日志全部写入
+	var shutdownErr error
+	if s.httpServer != nil {
+		shutdownErr = s.httpServer.Shutdown(ctx)
+	}
+
 	if s.requestLogger != nil {
 		if err := s.requestLogger.Close(); err != nil {
 			log.Printf("Failed to close request logger: %v", err)
 		}
 	}
+	if s.sharedState != nil {
+		s.sharedState.Close()
+	}
+	s.shutdownTracing(ctx)
+	return shutdownErr
+}
 
-	if s.httpServer != nil {
-		return s.httpServer.Shutdown(ctx)
+// enableSharedState 连接Redis并启用共享状态，连接失败时各实例继续使用本地状态
+func (s *MultiProviderServer) enableSharedState(settings *internal.RedisSettings) {
+	client := redisstore.NewClient(settings.Addr, settings.Password, settings.DB, settings.DialTimeout)
+	store := redisstore.NewStore(client, settings.KeyPrefix)
+	if err := store.Ping(); err != nil {
+		log.Printf("警告: 无法连接Redis %s，使用本地状态: %v", settings.Addr, err)
+		store.Close()
+		return
 	}
-	return nil
+
+	if err := s.proxy.EnableSharedState(store, store, store, settings.SyncInterval); err != nil {
+		log.Printf("警告: 启用Redis共享状态失败，使用本地状态: %v", err)
+		store.Close()
+		return
+	}
+	s.keyManager.SetRotationCursor(store)
+	s.sharedState = store
+	log.Printf("已启用Redis共享状态: %s", settings.Addr)
 }
 
 // handleLogs 处理日志查询
@@ -1757,6 +2287,7 @@ func (s *MultiProviderServer) handleLogs(c *gin.Context) {
 		Limit:         50,
 		Offset:        0,
 	}
+	s.applyTenantFilter(c, filter)
 
 	// 解析分页参数
 	if limitStr := c.Query("limit"); limitStr != "" {
@@ -1771,10 +2302,39 @@ func (s *MultiProviderServer) handleLogs(c *gin.Context) {
 		}
 	}
 
-	// 获取日志列表
-	logs, err := s.requestLogger.GetRequestLogsWithFilter(filter)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+	// 解析时间范围参数（RFC3339，闭区间）
+	for _, param := range []struct {
+		name   string
+		target **time.Time
+	}{{"start_time", &filter.StartTime}, {"end_time", &filter.EndTime}} {
+		value := strings.TrimSpace(c.Query(param.name))
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   fmt.Sprintf("Invalid %s, expected RFC3339 format such as 2024-01-02T15:04:05+08:00", param.name),
+			})
+			return
+		}
+		// 日志按本地时间存储，统一转换为本地时区后比较
+		t = t.Local()
+		*param.target = &t
+	}
+	if filter.StartTime != nil && filter.EndTime != nil && filter.EndTime.Before(*filter.StartTime) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "end_time must not be earlier than start_time",
+		})
+		return
+	}
+
+	// 获取日志列表
+	logs, err := s.requestLogger.GetRequestLogsWithFilter(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
 			"error":   "Failed to get logs: " + err.Error(),
 		})
@@ -1795,6 +2355,66 @@ func (s *MultiProviderServer) handleLogs(c *gin.Context) {
 	})
 }
 
+// handleSearchLogs 在请求体、响应体和错误信息中搜索包含指定内容的日志，返回带高亮片段的结果
+func (s *MultiProviderServer) handleSearchLogs(c *gin.Context) {
+	if s.requestLogger == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Request logger not available",
+		})
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Query parameter q is required",
+		})
+		return
+	}
+
+	// 支持日志列表的筛选参数和时间范围
+	filter := s.parseLogFilterWithRange(c)
+	filter.Limit = 20
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 200 {
+			filter.Limit = l
+		}
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			filter.Offset = o
+		}
+	}
+
+	// 多取一条判断是否还有更多结果，避免对大表做COUNT
+	pageSize := filter.Limit
+	filter.Limit++
+	results, err := s.requestLogger.SearchRequestLogs(query, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to search logs: " + err.Error(),
+		})
+		return
+	}
+	hasMore := len(results) > pageSize
+	if hasMore {
+		results = results[:pageSize]
+	}
+	if results == nil {
+		results = []*logger.LogSearchResult{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"results":   results,
+		"has_more":  hasMore,
+		"full_text": s.requestLogger.FullTextSearchEnabled(),
+	})
+}
+
 // handleLogDetail 处理日志详情查询
 func (s *MultiProviderServer) handleLogDetail(c *gin.Context) {
 	if s.requestLogger == nil {
@@ -1830,6 +2450,204 @@ func (s *MultiProviderServer) handleLogDetail(c *gin.Context) {
 	})
 }
 
+// handleLogsByRequestID 按请求ID（响应头 X-Request-ID）查找请求日志
+func (s *MultiProviderServer) handleLogsByRequestID(c *gin.Context) {
+	if s.requestLogger == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Request logger not available",
+		})
+		return
+	}
+
+	requestID := c.Param("requestId")
+	logs, err := s.requestLogger.GetRequestLogsByRequestID(requestID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to get logs: " + err.Error(),
+		})
+		return
+	}
+	if len(logs) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "No logs found for request ID " + requestID,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"request_id": requestID,
+		"logs":       logs,
+	})
+}
+
+// handleLogTranscript 从流式请求日志中保存的SSE数据重组完整的助手消息，format=text 时返回纯文本
+func (s *MultiProviderServer) handleLogTranscript(c *gin.Context) {
+	if s.requestLogger == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Request logger not available",
+		})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid log ID",
+		})
+		return
+	}
+
+	logDetail, err := s.requestLogger.GetRequestLogDetail(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Log not found: " + err.Error(),
+		})
+		return
+	}
+	if !logDetail.IsStream {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Log is not a streamed request",
+		})
+		return
+	}
+
+	transcript := logger.ReconstructStream(logDetail.ResponseBody)
+	if c.Query("format") == "text" {
+		c.String(http.StatusOK, transcript.Text())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"log_id":     logDetail.ID,
+		"transcript": transcript,
+	})
+}
+
+// handleLogDiff 比较两条请求日志的请求参数和响应内容，参数 a、b 为日志ID
+func (s *MultiProviderServer) handleLogDiff(c *gin.Context) {
+	if s.requestLogger == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Request logger not available",
+		})
+		return
+	}
+
+	ids := make([]int64, 0, 2)
+	for _, param := range []string{"a", "b"} {
+		id, err := strconv.ParseInt(c.Query(param), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "Invalid log ID for parameter " + param,
+			})
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	logs := make([]*logger.RequestLog, 0, 2)
+	for _, id := range ids {
+		logDetail, err := s.requestLogger.GetRequestLogDetail(id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"error":   fmt.Sprintf("Log %d not found: %v", id, err),
+			})
+			return
+		}
+		logs = append(logs, logDetail)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"diff":    logger.DiffRequestLogs(logs[0], logs[1]),
+	})
+}
+
+// handleTrafficSplits 获取A/B分流配置和按分支统计的请求，可通过 name 参数只查看指定分流，hours 参数限制统计时间范围（默认24小时，0为不限制）
+func (s *MultiProviderServer) handleTrafficSplits(c *gin.Context) {
+	splits := s.proxy.GetTrafficSplits()
+	if s.requestLogger == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"splits":  splits,
+			"stats":   []interface{}{},
+		})
+		return
+	}
+
+	hours := 24
+	if hoursStr := c.Query("hours"); hoursStr != "" {
+		if h, err := strconv.Atoi(hoursStr); err == nil && h >= 0 {
+			hours = h
+		}
+	}
+	var since time.Time
+	if hours > 0 {
+		since = time.Now().Add(-time.Duration(hours) * time.Hour)
+	}
+
+	stats, err := s.requestLogger.GetTrafficSplitStats(c.Query("name"), since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to get traffic split stats: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"splits":  splits,
+		"hours":   hours,
+		"stats":   stats,
+	})
+}
+
+// handleShadowComparisons 获取影子请求与原请求的对比，可通过 group 参数只查看指定原分组的影子流量
+// 单条对比的请求和响应差异可使用 /admin/logs/diff?a=<原请求日志ID>&b=<影子请求日志ID> 查看
+func (s *MultiProviderServer) handleShadowComparisons(c *gin.Context) {
+	if s.requestLogger == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Request logger not available",
+		})
+		return
+	}
+
+	limit := 50
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+	}
+
+	comparisons, err := s.requestLogger.GetShadowComparisons(c.Query("group"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to get shadow comparisons: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"summary":     logger.SummarizeShadowComparisons(comparisons),
+		"comparisons": comparisons,
+	})
+}
+
 // handleAPIKeyStats 处理API密钥统计
 func (s *MultiProviderServer) handleAPIKeyStats(c *gin.Context) {
 	if s.requestLogger == nil {
@@ -1840,7 +2658,13 @@ func (s *MultiProviderServer) handleAPIKeyStats(c *gin.Context) {
 		return
 	}
 
-	stats, err := s.requestLogger.GetProxyKeyStats()
+	var stats []*logger.ProxyKeyStats
+	var err error
+	if c.Query("tenant_id") != "" {
+		stats, err = s.requestLogger.GetProxyKeyStatsWithFilter(s.parseLogFilterWithRange(c))
+	} else {
+		stats, err = s.requestLogger.GetProxyKeyStats()
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -1954,7 +2778,13 @@ func (s *MultiProviderServer) handleTotalTokensStats(c *gin.Context) {
 		return
 	}
 
-	stats, err := s.requestLogger.GetTotalTokensStats()
+	var stats *logger.TotalTokensStats
+	var err error
+	if c.Query("tenant_id") != "" {
+		stats, err = s.requestLogger.GetTotalTokensStatsWithFilter(s.parseLogFilterWithRange(c))
+	} else {
+		stats, err = s.requestLogger.GetTotalTokensStats()
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -2008,6 +2838,8 @@ func (s *MultiProviderServer) handleDeleteLogs(c *gin.Context) {
 		return
 	}
 
+	s.recordAudit(c, "logs.delete", "", nil, gin.H{"ids": req.IDs, "deleted_count": deletedCount})
+
 	c.JSON(http.StatusOK, gin.H{
 		"success":       true,
 		"deleted_count": deletedCount,
@@ -2034,6 +2866,8 @@ func (s *MultiProviderServer) handleClearAllLogs(c *gin.Context) {
 		return
 	}
 
+	s.recordAudit(c, "logs.clear", "", nil, gin.H{"deleted_count": deletedCount})
+
 	c.JSON(http.StatusOK, gin.H{
 		"success":       true,
 		"deleted_count": deletedCount,
@@ -2060,6 +2894,8 @@ func (s *MultiProviderServer) handleClearErrorLogs(c *gin.Context) {
 		return
 	}
 
+	s.recordAudit(c, "logs.clear_errors", "", nil, gin.H{"deleted_count": deletedCount})
+
 	c.JSON(http.StatusOK, gin.H{
 		"success":       true,
 		"deleted_count": deletedCount,
@@ -2067,6 +2903,67 @@ func (s *MultiProviderServer) handleClearErrorLogs(c *gin.Context) {
 	})
 }
 
+// handleBackfillLogs 处理按当前计算逻辑回填历史日志的派生字段
+func (s *MultiProviderServer) handleBackfillLogs(c *gin.Context) {
+	if s.requestLogger == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Request logger not available",
+		})
+		return
+	}
+
+	// 请求体可选，为空时回填全部日志
+	var opts logger.BackfillOptions
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&opts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "Invalid request format: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	status, err := s.requestLogger.StartBackfill(opts)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, logger.ErrBackfillRunning) {
+			code = http.StatusConflict
+		}
+		c.JSON(code, gin.H{
+			"success": false,
+			"error":   "Failed to start log backfill: " + err.Error(),
+		})
+		return
+	}
+
+	if !opts.DryRun {
+		s.recordAudit(c, "logs.backfill", "", nil, gin.H{"total": status.Total, "start_time": opts.StartTime, "end_time": opts.EndTime})
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"status":  status,
+	})
+}
+
+// handleBackfillStatus 处理获取后台回填任务的进度
+func (s *MultiProviderServer) handleBackfillStatus(c *gin.Context) {
+	if s.requestLogger == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Request logger not available",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"status":  s.requestLogger.BackfillStatus(),
+	})
+}
+
 // handleExportLogs 处理导出日志
 func (s *MultiProviderServer) handleExportLogs(c *gin.Context) {
 	if s.requestLogger == nil {
@@ -2077,15 +2974,19 @@ func (s *MultiProviderServer) handleExportLogs(c *gin.Context) {
 		return
 	}
 
-	// 构建筛选条件
-	filter := &logger.LogFilter{
-		ProxyKeyName:  c.Query("proxy_key_name"),
-		ProviderGroup: c.Query("provider_group"),
-		Model:         c.Query("model"),
-		Status:        c.Query("status"),
-		Stream:        c.Query("stream"),
+	// 构建筛选条件，支持与日志列表相同的筛选参数和时间范围
+	filter := s.parseLogFilterWithRange(c)
+	format := c.DefaultQuery("format", "csv") // 支持csv、ndjson、json和finetune格式
+	if format == "csv" || format == "ndjson" {
+		// 逐批读取并写出，避免大数据量时一次性加载到内存
+		filter.Limit, _ = strconv.Atoi(c.Query("limit"))
+		s.streamExportLogs(c, filter, format)
+		return
+	}
+	if format == "finetune" {
+		// 微调数据只使用成功的请求
+		filter.Status = "200"
 	}
-	format := c.DefaultQuery("format", "csv") // 支持csv和json格式
 
 	// 获取所有日志数据
 	logs, err := s.requestLogger.GetAllRequestLogsForExportWithFilter(filter)
@@ -2097,74 +2998,137 @@ func (s *MultiProviderServer) handleExportLogs(c *gin.Context) {
 		return
 	}
 
-	if format == "csv" {
-		// 导出为CSV格式
-		var buf bytes.Buffer
-		writer := csv.NewWriter(&buf)
+	if format == "finetune" {
+		// 导出为OpenAI对话微调格式的JSONL
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		result := logger.BuildFineTuneExamples(logs, logger.FineTuneExportOptions{
+			StripSystem: c.Query("strip_system") == "true",
+			Dedupe:      c.Query("dedupe") == "true",
+			Limit:       limit,
+		})
 
-		// 写入CSV头部
-		headers := []string{
-			"ID", "代理密钥名称", "代理密钥ID", "提供商分组", "OpenRouter密钥", "模型",
-			"状态码", "是否流式", "响应时间(ms)", "Token使用量", "错误信息", "创建时间",
-		}
-		if err := writer.Write(headers); err != nil {
+		var buf bytes.Buffer
+		if err := logger.WriteFineTuneJSONL(&buf, result.Examples); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"success": false,
-				"error":   "Failed to write CSV headers: " + err.Error(),
+				"error":   "Failed to write fine-tuning JSONL: " + err.Error(),
 			})
 			return
 		}
 
-		// 写入数据行
-		for _, log := range logs {
-			record := []string{
-				fmt.Sprintf("%d", log.ID),
-				log.ProxyKeyName,
-				log.ProxyKeyID,
-				log.ProviderGroup,
-				log.OpenRouterKey,
-				log.Model,
-				fmt.Sprintf("%d", log.StatusCode),
-				fmt.Sprintf("%t", log.IsStream),
-				fmt.Sprintf("%d", log.Duration),
-				fmt.Sprintf("%d", log.TokensUsed),
-				log.Error,
-				log.CreatedAt.Format("2006-01-02 15:04:05"),
-			}
-			if err := writer.Write(record); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"success": false,
-					"error":   "Failed to write CSV record: " + err.Error(),
-				})
-				return
-			}
-		}
-
-		writer.Flush()
-		if err := writer.Error(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
+		filename := fmt.Sprintf("finetune_%s.jsonl", time.Now().Format("20060102_150405"))
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+		c.Header("X-TurnsAPI-Export-Count", strconv.Itoa(result.Exported))
+		c.Header("X-TurnsAPI-Export-Skipped", strconv.Itoa(result.Skipped))
+		c.Header("X-TurnsAPI-Export-Duplicates", strconv.Itoa(result.Duplicates))
+		c.Data(http.StatusOK, "application/jsonl", buf.Bytes())
+		return
+	}
+
+	// 导出为JSON格式
+	filename := fmt.Sprintf("request_logs_%s.json", time.Now().Format("20060102_150405"))
+	c.Header("Content-Type", "application/json")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"logs":    logs,
+		"count":   len(logs),
+	})
+}
+
+// exportFlushInterval 流式导出时每写出多少条日志刷新一次响应
+const exportFlushInterval = 200
+
+// streamExportLogs 以CSV或NDJSON格式流式导出日志，按批读取数据库并分块写出响应
+// 写出第一条数据之前出错时返回JSON错误，之后出错只能记录日志并中断响应
+func (s *MultiProviderServer) streamExportLogs(c *gin.Context, filter *logger.LogFilter, format string) {
+	timestamp := time.Now().Format("20060102_150405")
+	var (
+		started bool
+		count   int
+		csvw    *csv.Writer
+		encoder *json.Encoder
+	)
+
+	start := func() error {
+		started = true
+		if format == "csv" {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=request_logs_%s.csv", timestamp))
+		} else {
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=request_logs_%s.ndjson", timestamp))
+		}
+		c.Status(http.StatusOK)
+
+		if format == "csv" {
+			csvw = csv.NewWriter(c.Writer)
+			// 写入CSV头部
+			return csvw.Write(logger.ExportCSVHeader)
+		}
+		encoder = json.NewEncoder(c.Writer)
+		encoder.SetEscapeHTML(false)
+		return nil
+	}
+
+	flush := func() error {
+		if csvw != nil {
+			csvw.Flush()
+			if err := csvw.Error(); err != nil {
+				return err
+			}
+		}
+		c.Writer.Flush()
+		return nil
+	}
+
+	err := s.requestLogger.StreamRequestLogsForExport(filter, func(l *logger.RequestLog) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		if format == "csv" {
+			if err := csvw.Write(logger.ExportCSVRecord(l)); err != nil {
+				return err
+			}
+		} else if err := encoder.Encode(l); err != nil {
+			return err
+		}
+
+		count++
+		if count%exportFlushInterval == 0 {
+			return flush()
+		}
+		return nil
+	})
+
+	if err != nil {
+		if !started {
+			c.JSON(http.StatusInternalServerError, gin.H{
 				"success": false,
-				"error":   "Failed to flush CSV writer: " + err.Error(),
+				"error":   "Failed to export logs: " + err.Error(),
 			})
 			return
 		}
+		// 响应已经开始写出，无法再返回错误状态，客户端会收到不完整的文件
+		log.Printf("流式导出日志在第 %d 条后中断: %v", count, err)
+		return
+	}
 
-		// 设置响应头
-		filename := fmt.Sprintf("request_logs_%s.csv", time.Now().Format("20060102_150405"))
-		c.Header("Content-Type", "text/csv")
-		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-		c.Data(http.StatusOK, "text/csv", buf.Bytes())
-	} else {
-		// 导出为JSON格式
-		filename := fmt.Sprintf("request_logs_%s.json", time.Now().Format("20060102_150405"))
-		c.Header("Content-Type", "application/json")
-		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"logs":    logs,
-			"count":   len(logs),
-		})
+	if !started {
+		// 没有匹配的日志时仍输出CSV头部或空的NDJSON
+		if err := start(); err != nil {
+			log.Printf("流式导出日志写出失败: %v", err)
+			return
+		}
 	}
+	if err := flush(); err != nil {
+		log.Printf("流式导出日志写出失败: %v", err)
+		return
+	}
+	log.Printf("流式导出日志完成: 格式 %s, 共 %d 条", format, count)
 }
 
 // sortProxyKeys 对代理密钥列表进行排序
@@ -2266,12 +3230,13 @@ func (s *MultiProviderServer) handleProxyKeys(c *gin.Context) {
 		end = total
 	}
 
-	// 获取当前页的数据
-	var pageKeys []*proxykey.ProxyKey
-	if start < end {
-		pageKeys = filteredKeys[start:end]
-	} else {
-		pageKeys = []*proxykey.ProxyKey{}
+	// 获取当前页的数据，附带剩余有效期和剩余次数
+	now := time.Now()
+	pageKeys := make([]proxyKeyView, 0, end-start)
+	for _, key := range filteredKeys[start:end] {
+		view := s.proxyKeyView(key, now)
+		view.EffectiveStrategy = s.proxyKeyManager.EffectiveStrategy(key.ID)
+		pageKeys = append(pageKeys, view)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -2292,12 +3257,7 @@ func (s *MultiProviderServer) handleProxyKeys(c *gin.Context) {
 
 // handleGenerateProxyKey 处理生成代理密钥
 func (s *MultiProviderServer) handleGenerateProxyKey(c *gin.Context) {
-	var req struct {
-		Name                 string                         `json:"name" binding:"required"`
-		Description          string                         `json:"description"`
-		AllowedGroups        []string                       `json:"allowedGroups"`        // 允许访问的分组ID列表
-		GroupSelectionConfig *proxykey.GroupSelectionConfig `json:"groupSelectionConfig"` // 分组选择配置
-	}
+	var req generateProxyKeyRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -2306,11 +3266,8 @@ func (s *MultiProviderServer) handleGenerateProxyKey(c *gin.Context) {
 		return
 	}
 
-	key, err := s.proxyKeyManager.GenerateKeyWithConfig(req.Name, req.Description, req.AllowedGroups, req.GroupSelectionConfig)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to generate key",
-		})
+	key, _, ok := s.createProxyKey(c, &req, false)
+	if !ok {
 		return
 	}
 
@@ -2320,6 +3277,131 @@ func (s *MultiProviderServer) handleGenerateProxyKey(c *gin.Context) {
 	})
 }
 
+// generateProxyKeyRequest 生成代理密钥的请求参数
+type generateProxyKeyRequest struct {
+	Name                 string                         `json:"name" binding:"required"`
+	Description          string                         `json:"description"`
+	AllowedGroups        []string                       `json:"allowedGroups"`        // 允许访问的分组ID列表
+	GroupSelectionConfig *proxykey.GroupSelectionConfig `json:"groupSelectionConfig"` // 分组选择配置，权重只能引用允许的分组
+	ExpiresAt            *time.Time                     `json:"expires_at"`           // 过期时间（RFC3339），为空表示永不过期
+	MaxUsageCount        int64                          `json:"max_usage_count"`      // 最大使用次数，0表示不限制
+	AllowedModels        []string                       `json:"allowed_models"`       // 允许请求的模型，支持通配符
+	DeniedModels         []string                       `json:"denied_models"`        // 禁止请求的模型，支持通配符
+	AllowedIPs           []string                       `json:"allowed_ips"`          // 允许的来源地址（IP或CIDR）
+	DeniedIPs            []string                       `json:"denied_ips"`           // 禁止的来源地址（IP或CIDR）
+	SystemPrompt         string                         `json:"system_prompt"`        // 注入到每个聊天请求的系统提示词
+	TenantID             string                         `json:"tenant_id"`            // 所属租户ID，为空表示不属于任何租户
+}
+
+// createProxyKey 校验参数并生成代理密钥，设置有效期、次数和模型限制并记录审计日志
+// sealed为true时只保存密钥哈希并返回明文；失败时已写入错误响应并返回false
+func (s *MultiProviderServer) createProxyKey(c *gin.Context, req *generateProxyKeyRequest, sealed bool) (*proxykey.ProxyKey, string, bool) {
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "expires_at must be in the future",
+		})
+		return nil, "", false
+	}
+	if req.MaxUsageCount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "max_usage_count must not be negative",
+		})
+		return nil, "", false
+	}
+	ipRules := proxykey.IPRules{AllowedIPs: req.AllowedIPs, DeniedIPs: req.DeniedIPs}
+	if err := proxykey.ValidateIPRules(ipRules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return nil, "", false
+	}
+	if err := s.proxyKeyManager.ValidateGroupSelection(req.GroupSelectionConfig, req.AllowedGroups); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return nil, "", false
+	}
+	req.TenantID = strings.TrimSpace(req.TenantID)
+	if req.TenantID != "" && !s.proxyKeyManager.TenantExists(req.TenantID) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("tenant %s not found", req.TenantID),
+		})
+		return nil, "", false
+	}
+
+	// 限制和附加设置与密钥一起保存，失败时不会留下没有限制的密钥
+	settings := proxykey.KeySettings{
+		Limits:       proxykey.KeyLimits{ExpiresAt: req.ExpiresAt, MaxUsageCount: req.MaxUsageCount},
+		ModelRules:   proxykey.ModelRules{AllowedModels: req.AllowedModels, DeniedModels: req.DeniedModels},
+		IPRules:      ipRules,
+		SystemPrompt: req.SystemPrompt,
+		TenantID:     req.TenantID,
+	}
+	var key *proxykey.ProxyKey
+	var plaintext string
+	var err error
+	if sealed {
+		key, plaintext, err = s.proxyKeyManager.GenerateSealedKey(req.Name, req.Description, req.AllowedGroups, req.GroupSelectionConfig, settings)
+	} else {
+		key, err = s.proxyKeyManager.GenerateKeyWithConfig(req.Name, req.Description, req.AllowedGroups, req.GroupSelectionConfig, settings)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate key: " + err.Error(),
+		})
+		return nil, "", false
+	}
+
+	s.recordAudit(c, "proxy_key.create", key.ID, nil, s.auditProxyKeySnapshot(key.ID))
+	return key, plaintext, true
+}
+
+// proxyKeyView 代理密钥列表项，附带剩余有效期和使用次数
+type proxyKeyView struct {
+	*proxykey.ProxyKey
+	Status            string `json:"status"`                       // active、expired、exhausted、disabled、tenant_disabled、tenant_quota_exceeded
+	RemainingSeconds  *int64 `json:"remaining_seconds,omitempty"`  // 距过期的秒数，未设置过期时间时为空
+	RemainingUses     *int64 `json:"remaining_uses,omitempty"`     // 剩余可用次数，未限制次数时为空
+	Sealed            bool   `json:"sealed"`                       // 只保存了哈希，明文无法再次获取
+	EffectiveStrategy string `json:"effective_strategy,omitempty"` // 路由时实际使用的分组选择策略，只有一个可用分组时为 single
+}
+
+// newProxyKeyView 计算代理密钥的剩余有效期和使用次数
+func newProxyKeyView(key *proxykey.ProxyKey, now time.Time) proxyKeyView {
+	view := proxyKeyView{ProxyKey: key, Status: key.InactiveReason(now), Sealed: key.Sealed()}
+	if view.Status == "" {
+		view.Status = "active"
+	}
+	if key.ExpiresAt != nil {
+		remaining := int64(key.ExpiresAt.Sub(now).Seconds())
+		if remaining < 0 {
+			remaining = 0
+		}
+		view.RemainingSeconds = &remaining
+	}
+	if key.MaxUsageCount > 0 {
+		remaining := key.MaxUsageCount - key.UsageCount
+		if remaining < 0 {
+			remaining = 0
+		}
+		view.RemainingUses = &remaining
+	}
+	return view
+}
+
+// proxyKeyView 生成代理密钥列表项，密钥本身可用但所属租户停用或额度用完时返回租户的原因
+func (s *MultiProviderServer) proxyKeyView(key *proxykey.ProxyKey, now time.Time) proxyKeyView {
+	view := newProxyKeyView(key, now)
+	if view.Status == "active" {
+		if tenant, exists := s.proxyKeyManager.GetTenant(key.TenantID); exists {
+			if reason := tenant.InactiveReason(); reason != "" {
+				view.Status = reason
+			}
+		}
+	}
+	return view
+}
+
 // handleUpdateProxyKey 处理更新代理密钥
 func (s *MultiProviderServer) handleUpdateProxyKey(c *gin.Context) {
 	keyID := c.Param("id")
@@ -2327,9 +3409,17 @@ func (s *MultiProviderServer) handleUpdateProxyKey(c *gin.Context) {
 	var req struct {
 		Name                 string                         `json:"name" binding:"required"`
 		Description          string                         `json:"description"`
-		IsActive             *bool                          `json:"is_active"`
+		IsActive             *bool                          `json:"is_active"`            // 未提供时保持不变，也可以用 PATCH 单独启用或禁用
 		AllowedGroups        []string                       `json:"allowedGroups"`        // 保持与生成时一致的字段名
-		GroupSelectionConfig *proxykey.GroupSelectionConfig `json:"groupSelectionConfig"` // 分组选择配置
+		GroupSelectionConfig *proxykey.GroupSelectionConfig `json:"groupSelectionConfig"` // 未提供时保持不变
+		ExpiresAt            *string                        `json:"expires_at"`           // 未提供时保持不变，空字符串表示取消过期时间
+		MaxUsageCount        *int64                         `json:"max_usage_count"`      // 未提供时保持不变，0表示不限制
+		AllowedModels        *[]string                      `json:"allowed_models"`       // 未提供时保持不变，空数组表示不限制
+		DeniedModels         *[]string                      `json:"denied_models"`        // 未提供时保持不变
+		AllowedIPs           *[]string                      `json:"allowed_ips"`          // 未提供时保持不变，空数组表示不限制
+		DeniedIPs            *[]string                      `json:"denied_ips"`           // 未提供时保持不变
+		SystemPrompt         *string                        `json:"system_prompt"`        // 未提供时保持不变，空字符串表示不注入
+		TenantID             *string                        `json:"tenant_id"`            // 未提供时保持不变，空字符串表示不属于任何租户
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -2339,10 +3429,17 @@ func (s *MultiProviderServer) handleUpdateProxyKey(c *gin.Context) {
 		return
 	}
 
-	// 如果没有提供 IsActive，默认为 true
+	// 如果没有提供 IsActive，保持当前状态
 	isActive := true
 	if req.IsActive != nil {
 		isActive = *req.IsActive
+	} else {
+		for _, key := range s.proxyKeyManager.GetAllKeys() {
+			if key.ID == keyID {
+				isActive = key.IsActive
+				break
+			}
+		}
 	}
 
 	// 如果没有提供 AllowedGroups，默认为空数组
@@ -2351,6 +3448,73 @@ func (s *MultiProviderServer) handleUpdateProxyKey(c *gin.Context) {
 		allowedGroups = []string{}
 	}
 
+	if err := s.proxyKeyManager.ValidateGroupSelection(req.GroupSelectionConfig, allowedGroups); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 解析有效期和使用次数限制
+	var limits *proxykey.KeyLimits
+	if req.ExpiresAt != nil || req.MaxUsageCount != nil {
+		limits = &proxykey.KeyLimits{}
+		for _, key := range s.proxyKeyManager.GetAllKeys() {
+			if key.ID == keyID {
+				limits.ExpiresAt, limits.MaxUsageCount = key.ExpiresAt, key.MaxUsageCount
+				break
+			}
+		}
+		if req.ExpiresAt != nil {
+			limits.ExpiresAt = nil
+			if *req.ExpiresAt != "" {
+				expiresAt, err := time.Parse(time.RFC3339, *req.ExpiresAt)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{
+						"error": "Invalid expires_at, expected RFC3339 format",
+					})
+					return
+				}
+				limits.ExpiresAt = &expiresAt
+			}
+		}
+		if req.MaxUsageCount != nil {
+			limits.MaxUsageCount = *req.MaxUsageCount
+		}
+	}
+
+	// 来源地址允许/禁止列表，未提供的列表保持不变
+	var ipRules *proxykey.IPRules
+	if req.AllowedIPs != nil || req.DeniedIPs != nil {
+		ipRules = &proxykey.IPRules{}
+		for _, key := range s.proxyKeyManager.GetAllKeys() {
+			if key.ID == keyID {
+				ipRules.AllowedIPs, ipRules.DeniedIPs = key.AllowedIPs, key.DeniedIPs
+				break
+			}
+		}
+		if req.AllowedIPs != nil {
+			ipRules.AllowedIPs = *req.AllowedIPs
+		}
+		if req.DeniedIPs != nil {
+			ipRules.DeniedIPs = *req.DeniedIPs
+		}
+		if err := proxykey.ValidateIPRules(*ipRules); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.TenantID != nil && strings.TrimSpace(*req.TenantID) != "" && !s.proxyKeyManager.TenantExists(strings.TrimSpace(*req.TenantID)) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("tenant %s not found", strings.TrimSpace(*req.TenantID)),
+		})
+		return
+	}
+
+	before := s.auditProxyKeySnapshot(keyID)
 	if err := s.proxyKeyManager.UpdateKeyWithConfig(keyID, req.Name, req.Description, isActive, allowedGroups, req.GroupSelectionConfig); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
@@ -2358,6 +3522,67 @@ func (s *MultiProviderServer) handleUpdateProxyKey(c *gin.Context) {
 		return
 	}
 
+	if limits != nil {
+		if err := s.proxyKeyManager.SetKeyLimits(keyID, *limits); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	// 更新模型允许/禁止列表，未提供的列表保持不变
+	if req.AllowedModels != nil || req.DeniedModels != nil {
+		var rules proxykey.ModelRules
+		for _, key := range s.proxyKeyManager.GetAllKeys() {
+			if key.ID == keyID {
+				rules.AllowedModels, rules.DeniedModels = key.AllowedModels, key.DeniedModels
+				break
+			}
+		}
+		if req.AllowedModels != nil {
+			rules.AllowedModels = *req.AllowedModels
+		}
+		if req.DeniedModels != nil {
+			rules.DeniedModels = *req.DeniedModels
+		}
+		if err := s.proxyKeyManager.SetKeyModelRules(keyID, rules); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	if ipRules != nil {
+		if err := s.proxyKeyManager.SetKeyIPRules(keyID, *ipRules); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.SystemPrompt != nil {
+		if err := s.proxyKeyManager.SetKeySystemPrompt(keyID, *req.SystemPrompt); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.TenantID != nil {
+		if err := s.proxyKeyManager.SetKeyTenant(keyID, *req.TenantID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	s.recordAudit(c, "proxy_key.update", keyID, before, s.auditProxyKeySnapshot(keyID))
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "代理密钥更新成功",
@@ -2368,6 +3593,7 @@ func (s *MultiProviderServer) handleUpdateProxyKey(c *gin.Context) {
 func (s *MultiProviderServer) handleDeleteProxyKey(c *gin.Context) {
 	id := c.Param("id")
 
+	before := s.auditProxyKeySnapshot(id)
 	err := s.proxyKeyManager.DeleteKey(id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
@@ -2376,6 +3602,77 @@ func (s *MultiProviderServer) handleDeleteProxyKey(c *gin.Context) {
 		return
 	}
 
+	s.recordAudit(c, "proxy_key.delete", id, before, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// handleAdminTokens 处理获取管理API令牌列表
+func (s *MultiProviderServer) handleAdminTokens(c *gin.Context) {
+	tokens, err := s.requestLogger.GetAllAdminTokens()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to get admin tokens: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"tokens":  tokens,
+	})
+}
+
+// handleCreateAdminToken 处理创建管理API令牌，明文令牌只在响应中返回一次
+func (s *MultiProviderServer) handleCreateAdminToken(c *gin.Context) {
+	var req struct {
+		Name   string   `json:"name" binding:"required"`
+		Scopes []string `json:"scopes"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format",
+		})
+		return
+	}
+
+	token, plaintext, err := s.authManager.CreateAdminToken(strings.TrimSpace(req.Name), req.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	log.Printf("创建管理API令牌: %s (权限: %s)", token.Name, strings.Join(token.Scopes, ","))
+	s.recordAudit(c, "admin_token.create", token.ID, nil, gin.H{"name": token.Name, "scopes": token.Scopes})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"token":   token,
+		"secret":  plaintext,
+	})
+}
+
+// handleRevokeAdminToken 处理吊销管理API令牌
+func (s *MultiProviderServer) handleRevokeAdminToken(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := s.requestLogger.DeleteAdminToken(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Token not found",
+		})
+		return
+	}
+
+	log.Printf("吊销管理API令牌: %s", id)
+	s.recordAudit(c, "admin_token.revoke", id, nil, nil)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 	})
@@ -2420,21 +3717,45 @@ func (s *MultiProviderServer) handleGroupsManage(c *gin.Context) {
 	allGroups := s.configManager.GetAllGroups()
 	for groupID, group := range allGroups {
 		groupInfo := map[string]interface{}{
-			"group_id":            groupID,
-			"group_name":          group.Name,
-			"provider_type":       group.ProviderType,
-			"base_url":            group.BaseURL,
-			"enabled":             group.Enabled,
-			"timeout":             group.Timeout.Seconds(),
-			"max_retries":         group.MaxRetries,
-			"rotation_strategy":   group.RotationStrategy,
-			"api_keys":            group.APIKeys,
-			"models":              group.Models,
-			"headers":             group.Headers,
-			"request_params":      group.RequestParams,
-			"model_mappings":      group.ModelMappings,
-			"use_native_response": group.UseNativeResponse,
-			"rpm_limit":           group.RPMLimit,
+			"group_id":                      groupID,
+			"group_name":                    group.Name,
+			"provider_type":                 group.ProviderType,
+			"base_url":                      group.BaseURL,
+			"enabled":                       group.Enabled,
+			"timeout":                       group.Timeout.Seconds(),
+			"max_retries":                   group.MaxRetries,
+			"rotation_strategy":             group.RotationStrategy,
+			"api_keys":                      group.ConfiguredAPIKeys(), // 引用的密钥显示引用本身
+			"models":                        group.Models,
+			"headers":                       group.Headers,
+			"request_params":                group.RequestParams,
+			"model_mappings":                group.ModelMappings,
+			"model_rewrites":                group.ModelRewrites,
+			"use_native_response":           group.UseNativeResponse,
+			"rpm_limit":                     group.RPMLimit,
+			"rpm_burst":                     group.RPMBurst,
+			"model_limits":                  group.ModelLimits,
+			"hooks":                         group.Hooks,
+			"script":                        group.Script,
+			"chat_completions_path":         group.ChatCompletionsPath,
+			"models_path":                   group.ModelsPath,
+			"default_chat_completions_path": providers.DefaultChatCompletionsPath(group.ProviderType),
+			"default_models_path":           providers.DefaultModelsPath(group.ProviderType),
+			"max_concurrent":                group.MaxConcurrent,
+			"max_concurrent_per_key":        group.MaxConcurrentPerKey,
+			"retry_policy":                  group.RetryPolicy,
+			"health_check_model":            group.HealthCheckModel,
+			"skip_health_check":             group.SkipHealthCheck,
+			"include_reasoning":             group.IncludeReasoning,
+			"default_max_tokens":            group.DefaultMaxTokens,
+			"system_prompt":                 group.SystemPrompt,
+			"shadow":                        group.Shadow,
+			"timeouts":                      group.Timeouts,
+			"health_probe":                  group.HealthProbe,
+			"proxy_url":                     group.ProxyURL,
+			"tls":                           group.TLS,
+			"vertex_ai":                     group.VertexAI,
+			"transport":                     group.Transport,
 		}
 
 		// 获取健康状态，如果没有健康检查记录则默认为健康
@@ -2458,24 +3779,59 @@ func (s *MultiProviderServer) handleGroupsManage(c *gin.Context) {
 	})
 }
 
+// secretRefPolicy 返回当前请求通过管理接口写入API密钥引用的权限
+func (s *MultiProviderServer) secretRefPolicy(c *gin.Context) *internal.SecretRefPolicy {
+	return &internal.SecretRefPolicy{Admin: auth.IsAdmin(c), Settings: s.configManager.Snapshot().SecretRefs}
+}
+
+// secretRefErrorStatus 非管理员写入API密钥引用时返回403，其他引用错误返回400
+func secretRefErrorStatus(err error) int {
+	if errors.Is(err, internal.ErrSecretRefForbidden) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
 // handleCreateGroup 处理创建分组
 func (s *MultiProviderServer) handleCreateGroup(c *gin.Context) {
 	var req struct {
-		GroupID           string                 `json:"group_id" binding:"required"`
-		Name              string                 `json:"name" binding:"required"`
-		ProviderType      string                 `json:"provider_type" binding:"required"`
-		BaseURL           string                 `json:"base_url" binding:"required"`
-		Enabled           bool                   `json:"enabled"`
-		Timeout           float64                `json:"timeout"`
-		MaxRetries        int                    `json:"max_retries"`
-		RotationStrategy  string                 `json:"rotation_strategy"`
-		APIKeys           []string               `json:"api_keys"`
-		Models            []string               `json:"models"`
-		Headers           map[string]string      `json:"headers"`
-		RequestParams     map[string]interface{} `json:"request_params"`
-		ModelMappings     map[string]string      `json:"model_mappings"`
-		UseNativeResponse bool                   `json:"use_native_response"`
-		RPMLimit          int                    `json:"rpm_limit"`
+		GroupID             string                         `json:"group_id" binding:"required"`
+		Name                string                         `json:"name" binding:"required"`
+		ProviderType        string                         `json:"provider_type" binding:"required"`
+		BaseURL             string                         `json:"base_url" binding:"required"`
+		Enabled             bool                           `json:"enabled"`
+		Timeout             float64                        `json:"timeout"`
+		MaxRetries          int                            `json:"max_retries"`
+		RotationStrategy    string                         `json:"rotation_strategy"`
+		APIKeys             []string                       `json:"api_keys"`
+		Models              []string                       `json:"models"`
+		Headers             map[string]string              `json:"headers"`
+		RequestParams       map[string]interface{}         `json:"request_params"`
+		ModelMappings       map[string]string              `json:"model_mappings"`
+		ModelRewrites       []internal.ModelRewriteRule    `json:"model_rewrites"`
+		UseNativeResponse   bool                           `json:"use_native_response"`
+		RPMLimit            int                            `json:"rpm_limit"`
+		RPMBurst            int                            `json:"rpm_burst"`
+		ModelLimits         map[string]internal.ModelLimit `json:"model_limits"`
+		Hooks               []string                       `json:"hooks"`
+		Script              *internal.ScriptSettings       `json:"script"`
+		ChatCompletionsPath string                         `json:"chat_completions_path"`
+		ModelsPath          string                         `json:"models_path"`
+		MaxConcurrent       int                            `json:"max_concurrent"`
+		MaxConcurrentPerKey int                            `json:"max_concurrent_per_key"`
+		RetryPolicy         *internal.RetryPolicy          `json:"retry_policy"`
+		HealthCheckModel    string                         `json:"health_check_model"`
+		SkipHealthCheck     bool                           `json:"skip_health_check"`
+		IncludeReasoning    bool                           `json:"include_reasoning"`
+		DefaultMaxTokens    int                            `json:"default_max_tokens"`
+		SystemPrompt        string                         `json:"system_prompt"`
+		Shadow              *internal.ShadowConfig         `json:"shadow"`
+		Timeouts            *internal.TimeoutPolicy        `json:"timeouts"`
+		HealthProbe         *internal.HealthProbe          `json:"health_probe"`
+		ProxyURL            string                         `json:"proxy_url"`
+		TLS                 *internal.TLSSettings          `json:"tls"`
+		VertexAI            *internal.VertexAISettings     `json:"vertex_ai"`
+		Transport           *internal.TransportSettings    `json:"transport"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -2486,19 +3842,14 @@ func (s *MultiProviderServer) handleCreateGroup(c *gin.Context) {
 		return
 	}
 
-	// 检查分组ID是否已存在
-	if _, exists := s.configManager.GetGroup(req.GroupID); exists {
-		c.JSON(http.StatusConflict, gin.H{
-			"success": false,
-			"message": "Group ID already exists",
-		})
+	// 检查分组ID是否已存在（包括已归档的分组）
+	if !s.checkNewGroupID(c, req.GroupID) {
 		return
 	}
 
 	// 验证提供商类型
-	supportedTypes := []string{"openai", "gemini", "anthropic", "azure_openai"}
 	supported := false
-	for _, supportedType := range supportedTypes {
+	for _, supportedType := range supportedProviderTypes {
 		if req.ProviderType == supportedType {
 			supported = true
 			break
@@ -2513,9 +3864,12 @@ func (s *MultiProviderServer) handleCreateGroup(c *gin.Context) {
 		return
 	}
 
-	// 设置默认值
+	// 设置默认值，超时与配置文件中的分组一样使用全局默认超时
 	if req.Timeout == 0 {
-		req.Timeout = 30
+		req.Timeout = internal.DefaultRequestTimeout.Seconds()
+		if settings := s.configManager.Snapshot().GlobalSettings; settings != nil && settings.DefaultTimeout > 0 {
+			req.Timeout = settings.DefaultTimeout.Seconds()
+		}
 	}
 	if req.MaxRetries == 0 {
 		req.MaxRetries = 3
@@ -2535,23 +3889,165 @@ func (s *MultiProviderServer) handleCreateGroup(c *gin.Context) {
 	if req.ModelMappings == nil {
 		req.ModelMappings = make(map[string]string)
 	}
+	if err := internal.ValidateModelRewrites(req.ModelRewrites); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := internal.ValidateShadow(req.GroupID, req.Shadow); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := internal.ValidateTimeouts(req.Timeouts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := internal.ValidateRPMBurst(req.RPMLimit, req.RPMBurst); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := internal.ValidateDefaultMaxTokens(req.DefaultMaxTokens); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := internal.ValidateModelLimits(req.ModelLimits); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(req.ModelLimits) == 0 {
+		req.ModelLimits = nil
+	}
+	if err := internal.ValidateHooks(req.Hooks); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(req.Hooks) == 0 {
+		req.Hooks = nil
+	}
+	if err := internal.ValidateScript(req.Script); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Script.IsZero() {
+		req.Script = nil
+	}
+	if req.Timeouts.IsZero() {
+		req.Timeouts = nil
+	}
+	if err := internal.ValidateHealthProbe(req.HealthProbe); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	req.ProxyURL = strings.TrimSpace(req.ProxyURL)
+	if err := internal.ValidateProxyURL(req.ProxyURL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := internal.ValidateTLSSettings(req.TLS); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.TLS.IsZero() {
+		req.TLS = nil
+	}
+	if err := internal.ValidateVertexAI(req.ProviderType, req.VertexAI); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.VertexAI.IsZero() {
+		req.VertexAI = nil
+	}
+	if err := internal.ValidateTransportSettings(req.Transport); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Transport.IsZero() {
+		req.Transport = nil
+	}
+	if err := s.secretRefPolicy(c).Check(req.APIKeys, nil); err != nil {
+		c.JSON(secretRefErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 
 	// 创建新的用户分组，直接使用提供的密钥（前端已去重）
 	newGroup := &internal.UserGroup{
-		Name:              req.Name,
-		ProviderType:      req.ProviderType,
-		BaseURL:           req.BaseURL,
-		Enabled:           req.Enabled,
-		Timeout:           time.Duration(req.Timeout) * time.Second,
-		MaxRetries:        req.MaxRetries,
-		RotationStrategy:  req.RotationStrategy,
-		APIKeys:           req.APIKeys, // 直接使用前端提供的密钥
-		Models:            req.Models,
-		Headers:           req.Headers,
-		RequestParams:     req.RequestParams,
-		ModelMappings:     req.ModelMappings,
-		UseNativeResponse: req.UseNativeResponse,
-		RPMLimit:          req.RPMLimit,
+		Name:                req.Name,
+		ProviderType:        req.ProviderType,
+		BaseURL:             req.BaseURL,
+		Enabled:             req.Enabled,
+		Timeout:             time.Duration(req.Timeout) * time.Second,
+		MaxRetries:          req.MaxRetries,
+		RotationStrategy:    req.RotationStrategy,
+		APIKeys:             req.APIKeys, // 直接使用前端提供的密钥
+		Models:              req.Models,
+		Headers:             req.Headers,
+		RequestParams:       req.RequestParams,
+		ModelMappings:       req.ModelMappings,
+		ModelRewrites:       req.ModelRewrites,
+		UseNativeResponse:   req.UseNativeResponse,
+		RPMLimit:            req.RPMLimit,
+		RPMBurst:            req.RPMBurst,
+		ModelLimits:         req.ModelLimits,
+		Hooks:               req.Hooks,
+		Script:              req.Script,
+		ChatCompletionsPath: strings.TrimSpace(req.ChatCompletionsPath),
+		ModelsPath:          strings.TrimSpace(req.ModelsPath),
+		MaxConcurrent:       req.MaxConcurrent,
+		MaxConcurrentPerKey: req.MaxConcurrentPerKey,
+		RetryPolicy:         req.RetryPolicy,
+		HealthCheckModel:    strings.TrimSpace(req.HealthCheckModel),
+		SkipHealthCheck:     req.SkipHealthCheck,
+		IncludeReasoning:    req.IncludeReasoning,
+		DefaultMaxTokens:    req.DefaultMaxTokens,
+		SystemPrompt:        req.SystemPrompt,
+		Shadow:              req.Shadow,
+		Timeouts:            req.Timeouts,
+		HealthProbe:         req.HealthProbe,
+		ProxyURL:            req.ProxyURL,
+		TLS:                 req.TLS,
+		VertexAI:            req.VertexAI,
+		Transport:           req.Transport,
 	}
 
 	// 保存到配置管理器（会同时更新数据库和内存）
@@ -2575,6 +4071,8 @@ func (s *MultiProviderServer) handleCreateGroup(c *gin.Context) {
 	// 更新RPM限制
 	s.proxy.UpdateRPMLimit(req.GroupID, req.RPMLimit)
 
+	s.recordAudit(c, "group.create", req.GroupID, nil, s.auditGroupSnapshot(newGroup))
+
 	c.JSON(http.StatusOK, gin.H{
 		"success":  true,
 		"message":  "Group created successfully",
@@ -2597,89 +4095,321 @@ func (s *MultiProviderServer) handleUpdateGroup(c *gin.Context) {
 	}
 
 	var req struct {
-		Name              string                 `json:"name"`
-		ProviderType      string                 `json:"provider_type"`
-		BaseURL           string                 `json:"base_url"`
-		Enabled           *bool                  `json:"enabled"`
-		Timeout           *float64               `json:"timeout"`
-		MaxRetries        *int                   `json:"max_retries"`
-		RotationStrategy  string                 `json:"rotation_strategy"`
-		APIKeys           []string               `json:"api_keys"`
-		Models            []string               `json:"models"`
-		Headers           map[string]string      `json:"headers"`
-		RequestParams     map[string]interface{} `json:"request_params"`
-		ModelMappings     map[string]string      `json:"model_mappings"`
-		UseNativeResponse *bool                  `json:"use_native_response"`
-		RPMLimit          *int                   `json:"rpm_limit"`
+		Name                string                          `json:"name"`
+		ProviderType        string                          `json:"provider_type"`
+		BaseURL             string                          `json:"base_url"`
+		Enabled             *bool                           `json:"enabled"`
+		Timeout             *float64                        `json:"timeout"`
+		MaxRetries          *int                            `json:"max_retries"`
+		RotationStrategy    string                          `json:"rotation_strategy"`
+		APIKeys             []string                        `json:"api_keys"`
+		Models              []string                        `json:"models"`
+		Headers             map[string]string               `json:"headers"`
+		RequestParams       map[string]interface{}          `json:"request_params"`
+		ModelMappings       map[string]string               `json:"model_mappings"`
+		ModelRewrites       *[]internal.ModelRewriteRule    `json:"model_rewrites"`
+		UseNativeResponse   *bool                           `json:"use_native_response"`
+		RPMLimit            *int                            `json:"rpm_limit"`
+		RPMBurst            *int                            `json:"rpm_burst"`
+		ModelLimits         *map[string]internal.ModelLimit `json:"model_limits"`
+		Hooks               *[]string                       `json:"hooks"`
+		Script              *internal.ScriptSettings        `json:"script"`
+		ChatCompletionsPath *string                         `json:"chat_completions_path"`
+		ModelsPath          *string                         `json:"models_path"`
+		MaxConcurrent       *int                            `json:"max_concurrent"`
+		MaxConcurrentPerKey *int                            `json:"max_concurrent_per_key"`
+		RetryPolicy         *internal.RetryPolicy           `json:"retry_policy"`
+		HealthCheckModel    *string                         `json:"health_check_model"`
+		SkipHealthCheck     *bool                           `json:"skip_health_check"`
+		IncludeReasoning    *bool                           `json:"include_reasoning"`
+		DefaultMaxTokens    *int                            `json:"default_max_tokens"`
+		SystemPrompt        *string                         `json:"system_prompt"` // 未提供时保持不变，空字符串表示不注入
+		Shadow              *internal.ShadowConfig          `json:"shadow"`
+		Timeouts            *internal.TimeoutPolicy         `json:"timeouts"`
+		HealthProbe         *internal.HealthProbe           `json:"health_probe"`
+		ProxyURL            *string                         `json:"proxy_url"`
+		TLS                 *internal.TLSSettings           `json:"tls"`
+		VertexAI            *internal.VertexAISettings      `json:"vertex_ai"`
+		Transport           *internal.TransportSettings     `json:"transport"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"message": "Invalid request format: " + err.Error(),
+			"message": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	before := s.auditGroupSnapshot(existingGroup)
+
+	// 更新字段（只更新提供的字段）
+	if req.Name != "" {
+		existingGroup.Name = req.Name
+	}
+	if req.ProviderType != "" {
+		// 验证提供商类型
+		supported := false
+		for _, supportedType := range supportedProviderTypes {
+			if req.ProviderType == supportedType {
+				supported = true
+				break
+			}
+		}
+
+		if !supported {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("Unsupported provider type: %s", req.ProviderType),
+			})
+			return
+		}
+		existingGroup.ProviderType = req.ProviderType
+	}
+	if req.BaseURL != "" {
+		existingGroup.BaseURL = req.BaseURL
+	}
+	if req.Enabled != nil {
+		existingGroup.Enabled = *req.Enabled
+	}
+	if req.Timeout != nil {
+		existingGroup.Timeout = time.Duration(*req.Timeout) * time.Second
+	}
+	if req.MaxRetries != nil {
+		existingGroup.MaxRetries = *req.MaxRetries
+	}
+	if req.RotationStrategy != "" {
+		existingGroup.RotationStrategy = req.RotationStrategy
+	}
+	if req.APIKeys != nil {
+		if err := s.secretRefPolicy(c).Check(req.APIKeys, existingGroup.ConfiguredAPIKeys()); err != nil {
+			c.JSON(secretRefErrorStatus(err), gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		existingGroup.APIKeys = req.APIKeys // 直接使用前端提供的密钥（前端已去重）
+		existingGroup.UnresolvedAPIKeys = nil
+	}
+	if req.Models != nil {
+		existingGroup.Models = req.Models
+	}
+	if req.Headers != nil {
+		existingGroup.Headers = req.Headers
+	}
+	if req.RequestParams != nil {
+		existingGroup.RequestParams = req.RequestParams
+	}
+	if req.ModelMappings != nil {
+		existingGroup.ModelMappings = req.ModelMappings
+	}
+	if req.ModelRewrites != nil {
+		if err := internal.ValidateModelRewrites(*req.ModelRewrites); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		existingGroup.ModelRewrites = *req.ModelRewrites
+	}
+	if req.UseNativeResponse != nil {
+		existingGroup.UseNativeResponse = *req.UseNativeResponse
+	}
+	if req.RPMLimit != nil {
+		existingGroup.RPMLimit = *req.RPMLimit
+	}
+	if req.RPMBurst != nil {
+		existingGroup.RPMBurst = *req.RPMBurst
+	}
+	if err := internal.ValidateRPMBurst(existingGroup.RPMLimit, existingGroup.RPMBurst); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.ModelLimits != nil {
+		// 传入空对象表示移除按模型的限制
+		if err := internal.ValidateModelLimits(*req.ModelLimits); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		existingGroup.ModelLimits = *req.ModelLimits
+		if len(existingGroup.ModelLimits) == 0 {
+			existingGroup.ModelLimits = nil
+		}
+	}
+	if req.Hooks != nil {
+		// 传入空数组表示停用所有钩子
+		if err := internal.ValidateHooks(*req.Hooks); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		existingGroup.Hooks = *req.Hooks
+		if len(existingGroup.Hooks) == 0 {
+			existingGroup.Hooks = nil
+		}
+	}
+	if req.Script != nil {
+		// 传入空对象表示停用脚本
+		if req.Script.IsZero() {
+			existingGroup.Script = nil
+		} else if err := internal.ValidateScript(req.Script); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		} else {
+			existingGroup.Script = req.Script
+		}
+	}
+	if req.ChatCompletionsPath != nil {
+		existingGroup.ChatCompletionsPath = strings.TrimSpace(*req.ChatCompletionsPath)
+	}
+	if req.ModelsPath != nil {
+		existingGroup.ModelsPath = strings.TrimSpace(*req.ModelsPath)
+	}
+	if req.MaxConcurrent != nil {
+		existingGroup.MaxConcurrent = *req.MaxConcurrent
+	}
+	if req.MaxConcurrentPerKey != nil {
+		existingGroup.MaxConcurrentPerKey = *req.MaxConcurrentPerKey
+	}
+	if req.RetryPolicy != nil {
+		// 传入空对象表示恢复默认重试策略
+		if req.RetryPolicy.IsZero() {
+			existingGroup.RetryPolicy = nil
+		} else {
+			existingGroup.RetryPolicy = req.RetryPolicy
+		}
+	}
+	if req.HealthCheckModel != nil {
+		existingGroup.HealthCheckModel = strings.TrimSpace(*req.HealthCheckModel)
+	}
+	if req.SkipHealthCheck != nil {
+		existingGroup.SkipHealthCheck = *req.SkipHealthCheck
+	}
+	if req.IncludeReasoning != nil {
+		existingGroup.IncludeReasoning = *req.IncludeReasoning
+	}
+	if req.DefaultMaxTokens != nil {
+		if err := internal.ValidateDefaultMaxTokens(*req.DefaultMaxTokens); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		existingGroup.DefaultMaxTokens = *req.DefaultMaxTokens
+	}
+	if req.SystemPrompt != nil {
+		existingGroup.SystemPrompt = *req.SystemPrompt
+	}
+	if req.Shadow != nil {
+		// 传入空对象表示关闭影子流量
+		if req.Shadow.TargetGroup == "" {
+			existingGroup.Shadow = nil
+		} else if err := internal.ValidateShadow(groupID, req.Shadow); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		} else {
+			existingGroup.Shadow = req.Shadow
+		}
+	}
+	if req.Timeouts != nil {
+		// 传入空对象表示恢复默认超时
+		if req.Timeouts.IsZero() {
+			existingGroup.Timeouts = nil
+		} else if err := internal.ValidateTimeouts(req.Timeouts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		} else {
+			existingGroup.Timeouts = req.Timeouts
+		}
+	}
+	if req.HealthProbe != nil {
+		if err := internal.ValidateHealthProbe(req.HealthProbe); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		existingGroup.HealthProbe = req.HealthProbe
+	}
+	if req.ProxyURL != nil {
+		proxyURL := strings.TrimSpace(*req.ProxyURL)
+		if err := internal.ValidateProxyURL(proxyURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		existingGroup.ProxyURL = proxyURL
+	}
+	if req.TLS != nil {
+		// 传入空对象表示清除TLS设置
+		if req.TLS.IsZero() {
+			existingGroup.TLS = nil
+		} else if err := internal.ValidateTLSSettings(req.TLS); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		} else {
+			existingGroup.TLS = req.TLS
+		}
+	}
+	if req.VertexAI != nil {
+		// 传入未启用的设置表示关闭Vertex AI认证模式
+		if req.VertexAI.IsZero() {
+			existingGroup.VertexAI = nil
+		} else if err := internal.ValidateVertexAI(existingGroup.ProviderType, req.VertexAI); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		} else {
+			existingGroup.VertexAI = req.VertexAI
+		}
+	} else if err := internal.ValidateVertexAI(existingGroup.ProviderType, existingGroup.VertexAI); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
 		})
 		return
 	}
-
-	// 更新字段（只更新提供的字段）
-	if req.Name != "" {
-		existingGroup.Name = req.Name
-	}
-	if req.ProviderType != "" {
-		// 验证提供商类型
-		supportedTypes := []string{"openai", "gemini", "anthropic", "azure_openai"}
-		supported := false
-		for _, supportedType := range supportedTypes {
-			if req.ProviderType == supportedType {
-				supported = true
-				break
-			}
-		}
-
-		if !supported {
+	if req.Transport != nil {
+		// 传入空对象表示清除连接池设置
+		if req.Transport.IsZero() {
+			existingGroup.Transport = nil
+		} else if err := internal.ValidateTransportSettings(req.Transport); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"success": false,
-				"message": fmt.Sprintf("Unsupported provider type: %s", req.ProviderType),
+				"message": err.Error(),
 			})
 			return
+		} else {
+			existingGroup.Transport = req.Transport
 		}
-		existingGroup.ProviderType = req.ProviderType
-	}
-	if req.BaseURL != "" {
-		existingGroup.BaseURL = req.BaseURL
-	}
-	if req.Enabled != nil {
-		existingGroup.Enabled = *req.Enabled
-	}
-	if req.Timeout != nil {
-		existingGroup.Timeout = time.Duration(*req.Timeout) * time.Second
-	}
-	if req.MaxRetries != nil {
-		existingGroup.MaxRetries = *req.MaxRetries
-	}
-	if req.RotationStrategy != "" {
-		existingGroup.RotationStrategy = req.RotationStrategy
-	}
-	if req.APIKeys != nil {
-		existingGroup.APIKeys = req.APIKeys // 直接使用前端提供的密钥（前端已去重）
-	}
-	if req.Models != nil {
-		existingGroup.Models = req.Models
-	}
-	if req.Headers != nil {
-		existingGroup.Headers = req.Headers
-	}
-	if req.RequestParams != nil {
-		existingGroup.RequestParams = req.RequestParams
-	}
-	if req.ModelMappings != nil {
-		existingGroup.ModelMappings = req.ModelMappings
-	}
-	if req.UseNativeResponse != nil {
-		existingGroup.UseNativeResponse = *req.UseNativeResponse
-	}
-	if req.RPMLimit != nil {
-		existingGroup.RPMLimit = *req.RPMLimit
 	}
 
 	// 保存到配置管理器
@@ -2703,6 +4433,11 @@ func (s *MultiProviderServer) handleUpdateGroup(c *gin.Context) {
 	// 更新RPM限制
 	s.proxy.UpdateRPMLimit(groupID, existingGroup.RPMLimit)
 
+	// 丢弃缓存的提供商实例，使BaseURL、接口路径等变更立即生效
+	s.proxy.ResetProvider(groupID)
+
+	s.recordAudit(c, "group.update", groupID, before, s.auditGroupSnapshot(existingGroup))
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "Group updated successfully",
@@ -2735,7 +4470,7 @@ func (s *MultiProviderServer) handleDeleteGroup(c *gin.Context) {
 		return
 	}
 
-	// 从配置管理器中删除（会同时删除数据库和内存中的数据）
+	// 从配置管理器中删除（在数据库中归档，历史日志仍可查到分组名称）
 	if err := s.configManager.DeleteGroup(groupID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -2755,9 +4490,12 @@ func (s *MultiProviderServer) handleDeleteGroup(c *gin.Context) {
 	// 从提供商管理器中移除分组
 	s.proxy.RemoveProvider(groupID)
 
+	s.recordAudit(c, "group.delete", groupID, s.auditGroupSnapshot(currentGroup), nil)
+
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Group deleted successfully",
+		"success":  true,
+		"message":  "Group archived successfully",
+		"archived": true,
 	})
 }
 
@@ -2787,6 +4525,7 @@ func (s *MultiProviderServer) handleExportGroups(c *gin.Context) {
 			})
 			return
 		}
+		group.APIKeys = group.ConfiguredAPIKeys() // 引用的密钥导出引用本身
 		exportConfig[groupID] = group
 	}
 
@@ -2822,7 +4561,7 @@ func (s *MultiProviderServer) handleImportGroups(c *gin.Context) {
 
 	// 检查文件类型
 	if !strings.HasSuffix(strings.ToLower(header.Filename), ".yaml") &&
-	   !strings.HasSuffix(strings.ToLower(header.Filename), ".yml") {
+		!strings.HasSuffix(strings.ToLower(header.Filename), ".yml") {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"error":   "Only YAML files are supported",
@@ -2855,6 +4594,58 @@ func (s *MultiProviderServer) handleImportGroups(c *gin.Context) {
 	errors := []string{}
 
 	for groupID, group := range importedGroups {
+		if err := internal.ValidateModelRewrites(group.ModelRewrites); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to import group %s: %v", groupID, err))
+			continue
+		}
+		if err := internal.ValidateShadow(groupID, group.Shadow); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to import group %s: %v", groupID, err))
+			continue
+		}
+		if err := internal.ValidateTimeouts(group.Timeouts); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to import group %s: %v", groupID, err))
+			continue
+		}
+		if err := internal.ValidateRPMBurst(group.RPMLimit, group.RPMBurst); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to import group %s: %v", groupID, err))
+			continue
+		}
+		if err := internal.ValidateDefaultMaxTokens(group.DefaultMaxTokens); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to import group %s: %v", groupID, err))
+			continue
+		}
+		if err := internal.ValidateModelLimits(group.ModelLimits); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to import group %s: %v", groupID, err))
+			continue
+		}
+		if err := internal.ValidateHooks(group.Hooks); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to import group %s: %v", groupID, err))
+			continue
+		}
+		if err := internal.ValidateScript(group.Script); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to import group %s: %v", groupID, err))
+			continue
+		}
+		if err := internal.ValidateHealthProbe(group.HealthProbe); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to import group %s: %v", groupID, err))
+			continue
+		}
+		if err := internal.ValidateProxyURL(group.ProxyURL); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to import group %s: %v", groupID, err))
+			continue
+		}
+		if err := internal.ValidateTLSSettings(group.TLS); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to import group %s: %v", groupID, err))
+			continue
+		}
+		if err := internal.ValidateVertexAI(group.ProviderType, group.VertexAI); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to import group %s: %v", groupID, err))
+			continue
+		}
+		if err := internal.ValidateTransportSettings(group.Transport); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to import group %s: %v", groupID, err))
+			continue
+		}
 		if err := s.configManager.SaveGroup(groupID, group); err != nil {
 			errors = append(errors, fmt.Sprintf("Failed to import group %s: %v", groupID, err))
 			continue
@@ -2868,6 +4659,7 @@ func (s *MultiProviderServer) handleImportGroups(c *gin.Context) {
 		}
 
 		importedCount++
+		s.recordAudit(c, "group.import", groupID, nil, s.auditGroupSnapshot(group))
 	}
 
 	response := gin.H{
@@ -2923,7 +4715,7 @@ func (s *MultiProviderServer) parseGroupsYAML(yamlData []byte) (map[string]*inte
 			return nil, fmt.Errorf("group %s has empty provider type", groupID)
 		}
 
-		if len(group.APIKeys) == 0 {
+		if len(group.APIKeys) == 0 && !group.IsKeyless() {
 			return nil, fmt.Errorf("group %s has no API keys", groupID)
 		}
 	}
@@ -2931,6 +4723,36 @@ func (s *MultiProviderServer) parseGroupsYAML(yamlData []byte) (map[string]*inte
 	return importConfig.UserGroups, nil
 }
 
+// handleReloadGroups 从数据库重新加载分组配置并重新解析API密钥引用（${ENV_VAR}、file:），用于轮换密钥后热更新
+// 只有密钥或启用状态变化的分组会重建密钥管理器，其余分组的密钥状态保持不变
+func (s *MultiProviderServer) handleReloadGroups(c *gin.Context) {
+	before := s.configManager.GetAllGroups()
+	if err := s.configManager.Reload(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to reload groups: " + err.Error(),
+		})
+		return
+	}
+	after := s.configManager.GetAllGroups()
+	updated := s.syncGroups(before, after)
+
+	unresolved := make(map[string][]string)
+	for groupID, group := range after {
+		if len(group.UnresolvedAPIKeys) > 0 {
+			unresolved[groupID] = group.UnresolvedAPIKeys
+		}
+	}
+
+	s.recordAudit(c, "group.reload", "", nil, gin.H{"updated_groups": updated})
+	c.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"message":         fmt.Sprintf("Reloaded %d groups, %d updated", len(after), updated),
+		"updated_groups":  updated,
+		"unresolved_keys": unresolved,
+	})
+}
+
 // handleToggleGroup 处理切换分组启用状态
 func (s *MultiProviderServer) handleToggleGroup(c *gin.Context) {
 	groupID := c.Param("groupId")
@@ -2964,6 +4786,8 @@ func (s *MultiProviderServer) handleToggleGroup(c *gin.Context) {
 		log.Printf("警告: 切换分组 %s 状态时更新密钥管理器失败: %v", groupID, err)
 	}
 
+	s.recordAudit(c, "group.toggle", groupID, gin.H{"enabled": !group.Enabled}, gin.H{"enabled": group.Enabled})
+
 	action := "enabled"
 	if !group.Enabled {
 		action = "disabled"
@@ -2976,19 +4800,164 @@ func (s *MultiProviderServer) handleToggleGroup(c *gin.Context) {
 	})
 }
 
+// handleBatchToggleGroups 处理批量启用/禁用分组
+func (s *MultiProviderServer) handleBatchToggleGroups(c *gin.Context) {
+	var req struct {
+		GroupIDs []string `json:"group_ids" binding:"required"`
+		Enabled  *bool    `json:"enabled" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	if len(req.GroupIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "No groups specified",
+		})
+		return
+	}
+
+	before := make(map[string]interface{}, len(req.GroupIDs))
+	for _, groupID := range req.GroupIDs {
+		if group, exists := s.configManager.GetGroup(groupID); exists {
+			before[groupID] = group.Enabled
+		}
+	}
+
+	if err := s.configManager.SetGroupsEnabled(req.GroupIDs, *req.Enabled); err != nil {
+		s.respondBatchGroupError(c, err, "Failed to update groups: ")
+		return
+	}
+
+	// 同步密钥管理器
+	for _, groupID := range req.GroupIDs {
+		group, _ := s.configManager.GetGroup(groupID)
+		if err := s.keyManager.UpdateGroupConfig(groupID, group); err != nil {
+			log.Printf("警告: 批量切换分组 %s 状态时更新密钥管理器失败: %v", groupID, err)
+		}
+	}
+
+	after := make(map[string]interface{}, len(req.GroupIDs))
+	for _, groupID := range req.GroupIDs {
+		after[groupID] = *req.Enabled
+	}
+	s.recordAudit(c, "group.batch_toggle", strings.Join(req.GroupIDs, ","), before, after)
+
+	action := "enabled"
+	if !*req.Enabled {
+		action = "disabled"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": fmt.Sprintf("%d groups %s successfully", len(req.GroupIDs), action),
+		"enabled": *req.Enabled,
+	})
+}
+
+// handleBatchDeleteGroups 处理批量删除分组
+func (s *MultiProviderServer) handleBatchDeleteGroups(c *gin.Context) {
+	var req struct {
+		GroupIDs []string `json:"group_ids" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	if len(req.GroupIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "No groups specified",
+		})
+		return
+	}
+
+	before := make(map[string]interface{}, len(req.GroupIDs))
+	for _, groupID := range req.GroupIDs {
+		if group, exists := s.configManager.GetGroup(groupID); exists {
+			before[groupID] = s.auditGroupSnapshot(group)
+		}
+	}
+
+	if err := s.configManager.DeleteGroups(req.GroupIDs); err != nil {
+		s.respondBatchGroupError(c, err, "Failed to delete groups: ")
+		return
+	}
+
+	// 清理密钥管理器、健康检查器和提供商实例
+	for _, groupID := range req.GroupIDs {
+		if err := s.keyManager.UpdateGroupConfig(groupID, nil); err != nil {
+			log.Printf("警告: 删除分组 %s 时更新密钥管理器失败: %v", groupID, err)
+		}
+		s.healthChecker.RemoveGroup(groupID)
+		s.proxy.RemoveProvider(groupID)
+	}
+
+	s.recordAudit(c, "group.batch_delete", strings.Join(req.GroupIDs, ","), before, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  fmt.Sprintf("%d groups archived successfully", len(req.GroupIDs)),
+		"archived": true,
+	})
+}
+
+// respondBatchGroupError 将批量分组操作的错误转换为对应的HTTP响应
+func (s *MultiProviderServer) respondBatchGroupError(c *gin.Context, err error, prefix string) {
+	message := err.Error()
+	switch {
+	case strings.HasPrefix(message, "group not found: "):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Group not found: " + strings.TrimPrefix(message, "group not found: "),
+		})
+	case message == "cannot disable the last enabled group":
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Cannot disable the last enabled group",
+		})
+	case message == "cannot delete the last enabled group":
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Cannot delete the last enabled group",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": prefix + message,
+		})
+	}
+}
+
 // handleValidateKeysWithoutGroup 处理不需要groupId的密钥验证请求（用于编辑分组时）
 func (s *MultiProviderServer) handleValidateKeysWithoutGroup(c *gin.Context) {
 	// 获取要验证的分组配置和密钥列表
 	var req struct {
-		Name             string            `json:"name"`
-		ProviderType     string            `json:"provider_type"`
-		BaseURL          string            `json:"base_url"`
-		Enabled          bool              `json:"enabled"`
-		Timeout          int               `json:"timeout"`
-		MaxRetries       int               `json:"max_retries"`
-		RotationStrategy string            `json:"rotation_strategy"`
-		APIKeys          []string          `json:"api_keys"`
-		Headers          map[string]string `json:"headers"`
+		Name             string                      `json:"name"`
+		ProviderType     string                      `json:"provider_type"`
+		BaseURL          string                      `json:"base_url"`
+		Enabled          bool                        `json:"enabled"`
+		Timeout          int                         `json:"timeout"`
+		MaxRetries       int                         `json:"max_retries"`
+		RotationStrategy string                      `json:"rotation_strategy"`
+		APIKeys          []string                    `json:"api_keys"`
+		Headers          map[string]string           `json:"headers"`
+		HealthCheckModel string                      `json:"health_check_model"`
+		ProxyURL         string                      `json:"proxy_url"`
+		TLS              *internal.TLSSettings       `json:"tls"`
+		VertexAI         *internal.VertexAISettings  `json:"vertex_ai"`
+		Transport        *internal.TransportSettings `json:"transport"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -3019,34 +4988,28 @@ func (s *MultiProviderServer) handleValidateKeysWithoutGroup(c *gin.Context) {
 		RotationStrategy: req.RotationStrategy,
 		APIKeys:          req.APIKeys,
 		Headers:          req.Headers,
+		HealthCheckModel: req.HealthCheckModel,
+		ProxyURL:         strings.TrimSpace(req.ProxyURL),
+		TLS:              req.TLS,
+		VertexAI:         req.VertexAI,
+		Transport:        req.Transport,
 	}
 
-	// 获取测试模型
-	var testModel string
-	// 根据提供商类型选择默认测试模型
-	switch req.ProviderType {
-	case "openai", "azure_openai":
-		testModel = "gpt-3.5-turbo"
-	case "anthropic":
-		testModel = "claude-3-haiku-20240307"
-	case "gemini":
-		testModel = "gemini-2.5-flash"
-	default:
-		testModel = "gpt-3.5-turbo" // 默认模型
-	}
+	// 优先使用指定的健康检查模型，否则根据提供商类型选择默认测试模型
+	testModel := tempGroup.TestModel()
 
-	log.Printf("🔍 开始临时分组密钥验证: 名称=%s, 提供商=%s, 密钥数量=%d, 测试模型=%s",
-		req.Name, req.ProviderType, len(req.APIKeys), testModel)
+	slog.InfoContext(c.Request.Context(), "开始临时分组密钥验证",
+		"name", req.Name, "provider", req.ProviderType, "keys", len(req.APIKeys), "test_model", testModel)
 
 	// 使用批量验证模式，提高效率
 	results := make([]map[string]interface{}, len(req.APIKeys))
-	log.Printf("⚙️ 采用批量验证模式，批次大小=8，无固定延迟，提高验证效率")
+	slog.DebugContext(c.Request.Context(), "采用批量验证模式", "batch_size", 8)
 
 	// 批量验证API密钥
 	s.validateKeysInBatches("temp", req.APIKeys, testModel, tempGroup, results)
 
 	// 所有验证已完成（顺序执行）
-	log.Printf("✅ 所有临时分组密钥验证已完成")
+	slog.DebugContext(c.Request.Context(), "所有临时分组密钥验证已完成", "name", req.Name)
 
 	// 统计结果
 	validCount := 0
@@ -3059,9 +5022,17 @@ func (s *MultiProviderServer) handleValidateKeysWithoutGroup(c *gin.Context) {
 		}
 	}
 
-	log.Printf("📊 临时分组验证结果统计: 总计=%d, 有效=%d, 无效=%d, 成功率=%.1f%%",
-		len(req.APIKeys), validCount, invalidCount,
-		float64(validCount)/float64(len(req.APIKeys))*100)
+	slog.InfoContext(c.Request.Context(), "临时分组密钥验证完成", "name", req.Name,
+		"total", len(req.APIKeys), "valid", validCount, "invalid", invalidCount)
+
+	s.recordAudit(c, "keys.validate", "", nil, gin.H{
+		"provider_type": req.ProviderType,
+		"base_url":      req.BaseURL,
+		"test_model":    testModel,
+		"total_keys":    len(req.APIKeys),
+		"valid_keys":    validCount,
+		"invalid_keys":  invalidCount,
+	})
 
 	c.JSON(http.StatusOK, gin.H{
 		"success":      true,
@@ -3244,7 +5215,7 @@ func (s *MultiProviderServer) handleGeminiNativeModels(c *gin.Context) {
 	var geminiModels []map[string]interface{}
 
 	for _, groupID := range allowedGroups {
-		group, exists := s.configManager.GetConfig().UserGroups[groupID]
+		group, exists := s.configManager.Snapshot().UserGroups[groupID]
 		if !exists || !group.Enabled {
 			continue
 		}
@@ -3254,8 +5225,11 @@ func (s *MultiProviderServer) handleGeminiNativeModels(c *gin.Context) {
 			continue
 		}
 
-		// 获取分组的模型列表
+		// 获取分组的模型列表，过滤代理密钥不允许请求的模型
 		for _, model := range group.Models {
+			if !proxykey.ModelAllowed(keyInfoStruct.AllowedModels, keyInfoStruct.DeniedModels, model) {
+				continue
+			}
 			geminiModel := map[string]interface{}{
 				"name":                       fmt.Sprintf("models/%s", model),
 				"baseModelId":                model,
@@ -3359,7 +5333,7 @@ func (s *MultiProviderServer) ensureProxyKeyInfoInContext(c *gin.Context) {
 			// 设置代理密钥信息到上下文中
 			c.Set("proxy_key_name", proxyKey.Name)
 			c.Set("proxy_key_id", proxyKey.ID)
-			
+
 			// 更新代理密钥使用次数
 			if s.proxyKeyManager != nil {
 				s.proxyKeyManager.UpdateUsage(proxyKey.Key)
@@ -3497,7 +5471,7 @@ func (s *MultiProviderServer) handleRefreshGroupHealth(c *gin.Context) {
 	groupID := c.Param("groupId")
 
 	// 检查分组是否存在
-	_, exists := s.config.GetGroupByID(groupID)
+	_, exists := s.configManager.Snapshot().GetGroupByID(groupID)
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
 		return
@@ -3579,11 +5553,21 @@ func (s *MultiProviderServer) parseLogFilterWithRange(c *gin.Context) *logger.Lo
 
 	f.StartTime = start
 	f.EndTime = end
+	s.applyTenantFilter(c, f)
 	return f
 }
- 
+
 func (s *MultiProviderServer) geminiAPIKeyAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// 前置网关注入的可信身份头部
+		if s.authManager != nil && s.authManager.TryTrustedHeaderAuth(c) {
+			c.Next()
+			return
+		}
+		if c.IsAborted() {
+			return
+		}
+
 		var apiKey string
 
 		// 首先尝试从x-goog-api-key头获取（Gemini原生API方式）
@@ -3648,14 +5632,10 @@ func (s *MultiProviderServer) geminiAPIKeyAuthMiddleware() gin.HandlerFunc {
 
 		keyInfo, valid := s.proxyKeyManager.ValidateKey(apiKey)
 		if !valid {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": gin.H{
-					"message": "Invalid API key",
-					"type":    "authentication_error",
-					"code":    "invalid_api_key",
-				},
-			})
-			c.Abort()
+			s.authManager.RejectInvalidProxyKey(c, apiKey)
+			return
+		}
+		if !s.authManager.CheckProxyKeySource(c, keyInfo) {
 			return
 		}
 
@@ -3668,13 +5648,13 @@ func (s *MultiProviderServer) geminiAPIKeyAuthMiddleware() gin.HandlerFunc {
 // handleForceKeyStatus 处理强行设置密钥有效状态
 func (s *MultiProviderServer) handleForceKeyStatus(c *gin.Context) {
 	groupID := c.Param("groupId")
-	
+
 	var req struct {
 		APIKey   string `json:"api_key" binding:"required"`
 		IsValid  bool   `json:"is_valid"`
 		ForceSet bool   `json:"force_set"` // 是否强制设置，忽略实际验证
 	}
-	
+
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
@@ -3682,7 +5662,7 @@ func (s *MultiProviderServer) handleForceKeyStatus(c *gin.Context) {
 		})
 		return
 	}
-	
+
 	// 检查分组是否存在
 	group, exists := s.configManager.GetGroup(groupID)
 	if !exists {
@@ -3692,7 +5672,7 @@ func (s *MultiProviderServer) handleForceKeyStatus(c *gin.Context) {
 		})
 		return
 	}
-	
+
 	// 检查API密钥是否属于该分组
 	keyExists := false
 	for _, key := range group.APIKeys {
@@ -3701,7 +5681,7 @@ func (s *MultiProviderServer) handleForceKeyStatus(c *gin.Context) {
 			break
 		}
 	}
-	
+
 	if !keyExists {
 		c.JSON(http.StatusNotFound, gin.H{
 			"success": false,
@@ -3709,7 +5689,7 @@ func (s *MultiProviderServer) handleForceKeyStatus(c *gin.Context) {
 		})
 		return
 	}
-	
+
 	// 更新数据库中的验证状态
 	validationError := ""
 	if !req.IsValid {
@@ -3719,7 +5699,7 @@ func (s *MultiProviderServer) handleForceKeyStatus(c *gin.Context) {
 			validationError = "Key validation failed"
 		}
 	}
-	
+
 	err := s.configManager.UpdateAPIKeyValidation(groupID, req.APIKey, req.IsValid, validationError)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -3728,24 +5708,25 @@ func (s *MultiProviderServer) handleForceKeyStatus(c *gin.Context) {
 		})
 		return
 	}
-	
+
 	// 更新密钥管理器中的状态
 	if s.keyManager != nil {
 		s.keyManager.UpdateKeyStatus(groupID, req.APIKey, req.IsValid, validationError)
 	}
-	
+
 	action := "valid"
 	if !req.IsValid {
 		action = "invalid"
 	}
-	
+
 	log.Printf("管理员强制设置密钥状态: 分组=%s, 密钥=%s, 状态=%s",
 		groupID, s.maskKey(req.APIKey), action)
-	
+	s.recordAudit(c, "keys.force_status", groupID, nil, gin.H{"api_key": s.maskKey(req.APIKey), "is_valid": req.IsValid})
+
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": fmt.Sprintf("API key status has been set to %s", action),
-		"api_key": s.maskKey(req.APIKey),
+		"success":  true,
+		"message":  fmt.Sprintf("API key status has been set to %s", action),
+		"api_key":  s.maskKey(req.APIKey),
 		"is_valid": req.IsValid,
 	})
 }
@@ -3753,7 +5734,7 @@ func (s *MultiProviderServer) handleForceKeyStatus(c *gin.Context) {
 // handleDeleteInvalidKeys 处理一键删除失效密钥
 func (s *MultiProviderServer) handleDeleteInvalidKeys(c *gin.Context) {
 	groupID := c.Param("groupId")
-	
+
 	// 检查分组是否存在
 	group, exists := s.configManager.GetGroup(groupID)
 	if !exists {
@@ -3763,7 +5744,7 @@ func (s *MultiProviderServer) handleDeleteInvalidKeys(c *gin.Context) {
 		})
 		return
 	}
-	
+
 	// 获取该分组的密钥验证状态
 	validationStatus, err := s.configManager.GetAPIKeyValidationStatus(groupID)
 	if err != nil {
@@ -3773,11 +5754,11 @@ func (s *MultiProviderServer) handleDeleteInvalidKeys(c *gin.Context) {
 		})
 		return
 	}
-	
+
 	// 找出所有无效的密钥
 	var invalidKeys []string
 	var validKeys []string
-	
+
 	for _, apiKey := range group.APIKeys {
 		if status, exists := validationStatus[apiKey]; exists {
 			if isValid, ok := status["is_valid"].(*bool); ok && isValid != nil && !*isValid {
@@ -3790,31 +5771,31 @@ func (s *MultiProviderServer) handleDeleteInvalidKeys(c *gin.Context) {
 			validKeys = append(validKeys, apiKey)
 		}
 	}
-	
+
 	if len(invalidKeys) == 0 {
 		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"message": "No invalid keys found to delete",
-			"deleted_count": 0,
+			"success":         true,
+			"message":         "No invalid keys found to delete",
+			"deleted_count":   0,
 			"remaining_count": len(validKeys),
 		})
 		return
 	}
-	
+
 	// 检查删除后是否还有有效密钥
 	if len(validKeys) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"message": "Cannot delete all keys. At least one valid key must remain in the group",
+			"success":       false,
+			"message":       "Cannot delete all keys. At least one valid key must remain in the group",
 			"invalid_count": len(invalidKeys),
 		})
 		return
 	}
-	
+
 	// 更新分组配置，移除无效密钥
 	updatedGroup := *group // 创建副本
 	updatedGroup.APIKeys = validKeys
-	
+
 	// 保存更新后的分组配置
 	err = s.configManager.UpdateGroup(groupID, &updatedGroup)
 	if err != nil {
@@ -3824,7 +5805,7 @@ func (s *MultiProviderServer) handleDeleteInvalidKeys(c *gin.Context) {
 		})
 		return
 	}
-	
+
 	// 更新密钥管理器
 	if s.keyManager != nil {
 		err = s.keyManager.UpdateGroupConfig(groupID, &updatedGroup)
@@ -3832,22 +5813,22 @@ func (s *MultiProviderServer) handleDeleteInvalidKeys(c *gin.Context) {
 			log.Printf("警告: 更新密钥管理器失败: %v", err)
 		}
 	}
-	
+
 	// 记录删除的密钥（用于日志）
 	maskedInvalidKeys := make([]string, len(invalidKeys))
 	for i, key := range invalidKeys {
 		maskedInvalidKeys[i] = s.maskKey(key)
 	}
-	
+
 	log.Printf("管理员删除失效密钥: 分组=%s, 删除数量=%d, 剩余数量=%d, 删除的密钥=%v",
 		groupID, len(invalidKeys), len(validKeys), maskedInvalidKeys)
-	
+	s.recordAudit(c, "keys.delete_invalid", groupID, s.auditGroupSnapshot(group), s.auditGroupSnapshot(&updatedGroup))
+
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": fmt.Sprintf("Successfully deleted %d invalid keys", len(invalidKeys)),
-		"deleted_count": len(invalidKeys),
+		"success":         true,
+		"message":         fmt.Sprintf("Successfully deleted %d invalid keys", len(invalidKeys)),
+		"deleted_count":   len(invalidKeys),
 		"remaining_count": len(validKeys),
-		"deleted_keys": maskedInvalidKeys,
+		"deleted_keys":    maskedInvalidKeys,
 	})
 }
-
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"turnsapi/internal/proxykey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxProxyKeyImportSize 批量导入代理密钥的请求体上限
+const maxProxyKeyImportSize = 10 << 20
+
+// handlePatchProxyKey 启用或禁用代理密钥：{"is_active": false}
+func (s *MultiProviderServer) handlePatchProxyKey(c *gin.Context) {
+	keyID := c.Param("id")
+
+	var req struct {
+		IsActive *bool `json:"is_active" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format, expected {\"is_active\": true|false}",
+		})
+		return
+	}
+
+	before := s.auditProxyKeySnapshot(keyID)
+	if err := s.proxyKeyManager.SetKeyActive(keyID, *req.IsActive); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	s.recordAudit(c, "proxy_key.update", keyID, before, s.auditProxyKeySnapshot(keyID))
+
+	// 返回密钥当前状态，已过期或用完的密钥启用后仍不可用
+	status := "active"
+	for _, key := range s.proxyKeyManager.GetAllKeys() {
+		if key.ID == keyID {
+			status = s.proxyKeyView(key, time.Now()).Status
+			break
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"status":  status,
+	})
+}
+
+// handleExportProxyKeys 导出全部代理密钥，支持 format=json|csv，导出文件可直接用于批量导入
+// 密封的密钥只导出哈希，导入后客户端仍可使用原来的明文密钥
+func (s *MultiProviderServer) handleExportProxyKeys(c *gin.Context) {
+	format := strings.ToLower(c.DefaultQuery("format", "json"))
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "format must be json or csv",
+		})
+		return
+	}
+
+	keys := s.proxyKeyManager.GetAllKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	s.recordAudit(c, "proxy_key.export", "", nil, gin.H{"format": format, "count": len(keys)})
+
+	filename := fmt.Sprintf("proxy_keys_%s.%s", time.Now().Format("20060102_150405"), format)
+	if format == "json" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"keys":    keys,
+			"count":   len(keys),
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := proxykey.WriteKeysCSV(&buf, keys); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to write CSV: " + err.Error(),
+		})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// handleImportProxyKeys 批量导入代理密钥（JSON或CSV），用于批量开通租户密钥或从其他环境迁移
+// format=csv 或 Content-Type 为 text/csv 时按CSV解析；sealed=true 时只保存密钥哈希；
+// dry_run=true 时只校验不导入。任何一行无效时不导入任何密钥
+func (s *MultiProviderServer) handleImportProxyKeys(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+	sealed := c.Query("sealed") == "true"
+
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxProxyKeyImportSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Failed to read request body: " + err.Error(),
+		})
+		return
+	}
+
+	var specs []proxykey.KeySpec
+	if c.Query("format") == "csv" || strings.HasPrefix(c.ContentType(), "text/csv") {
+		specs, err = proxykey.ParseKeysCSV(bytes.NewReader(data))
+	} else {
+		specs, err = proxykey.ParseKeysJSON(data)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Failed to parse import: " + err.Error(),
+		})
+		return
+	}
+	if len(specs) == 0 || len(specs) > proxykey.MaxImportKeys {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   fmt.Sprintf("Import must contain between 1 and %d keys", proxykey.MaxImportKeys),
+		})
+		return
+	}
+
+	if dryRun {
+		invalid := s.proxyKeyManager.ValidateImport(specs)
+		c.JSON(http.StatusOK, gin.H{
+			"success": len(invalid) == 0,
+			"dry_run": true,
+			"total":   len(specs),
+			"errors":  invalid,
+		})
+		return
+	}
+
+	results, err := s.proxyKeyManager.ImportKeys(specs, sealed)
+	if err != nil {
+		response := gin.H{
+			"success": false,
+			"error":   err.Error(),
+		}
+		if errors.Is(err, proxykey.ErrInvalidImport) {
+			response["errors"] = results
+		}
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	// 审计日志记录一条汇总，避免数百条逐个密钥的记录
+	ids := make([]string, 0, len(results))
+	for _, result := range results {
+		if result.Error == "" {
+			ids = append(ids, result.ID)
+		}
+	}
+	created := len(ids)
+	s.recordAudit(c, "proxy_key.import", "", nil, gin.H{"created": created, "failed": len(results) - created, "sealed": sealed, "ids": ids})
+	log.Printf("批量导入代理密钥: %d 个成功，%d 个失败", created, len(results)-created)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": created == len(results),
+		"created": created,
+		"failed":  len(results) - created,
+		"results": results,
+	})
+}
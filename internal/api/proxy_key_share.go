@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"turnsapi/internal/auth"
+	"turnsapi/internal/proxykey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// quickCreateProxyKeyRequest 快速创建代理密钥并生成一次性分享链接的请求
+type quickCreateProxyKeyRequest struct {
+	generateProxyKeyRequest
+	ShareTTLMinutes int `json:"share_ttl_minutes"` // 分享链接有效期（分钟），默认60，最长7天
+}
+
+// handleQuickCreateProxyKey 创建只保存哈希的代理密钥并返回一次性分享链接，明文密钥不会出现在响应中
+func (s *MultiProviderServer) handleQuickCreateProxyKey(c *gin.Context) {
+	var req quickCreateProxyKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format",
+		})
+		return
+	}
+
+	ttl := time.Duration(req.ShareTTLMinutes) * time.Minute
+	if req.ShareTTLMinutes < 0 || ttl > proxykey.MaxShareLinkTTL {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   fmt.Sprintf("share_ttl_minutes must be between 0 and %d", int(proxykey.MaxShareLinkTTL.Minutes())),
+		})
+		return
+	}
+
+	key, plaintext, ok := s.createProxyKey(c, &req.generateProxyKeyRequest, true)
+	if !ok {
+		return
+	}
+
+	token, expiresAt, err := s.proxyKeyManager.CreateShareLink(key.ID, plaintext, ttl)
+	if err != nil {
+		// 没有分享链接就再也无法取得明文，删除刚创建的密钥
+		if delErr := s.proxyKeyManager.DeleteKey(key.ID); delErr != nil {
+			log.Printf("Failed to delete proxy key %s after share link failure: %v", key.ID, delErr)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to create share link: " + err.Error(),
+		})
+		return
+	}
+
+	log.Printf("快速创建代理密钥 %s (%s)，分享链接有效期至 %s", key.Name, key.ID, expiresAt.Format(time.RFC3339))
+	c.JSON(http.StatusOK, gin.H{
+		"success":          true,
+		"key":              s.proxyKeyView(key, time.Now()),
+		"share_url":        shareLinkURL(c, token, s.config.Server.TrustedProxies),
+		"share_expires_at": expiresAt,
+	})
+}
+
+// shareLinkURL 根据当前请求的地址生成分享链接
+// 只有请求来自 server.trusted_proxies 中的反向代理时才使用 X-Forwarded-Proto 和 X-Forwarded-Host，避免客户端伪造链接地址
+func shareLinkURL(c *gin.Context, token string, trustedProxies []string) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	host := c.Request.Host
+	if auth.IsTrustedProxy(c.Request.RemoteAddr, trustedProxies) {
+		if proto := c.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwarded := c.GetHeader("X-Forwarded-Host"); forwarded != "" {
+			host = forwarded
+		}
+	}
+	return fmt.Sprintf("%s://%s/share/proxy-key/%s", scheme, host, token)
+}
+
+// setShareResponseHeaders 禁止缓存分享页面和密钥，并且不向外部链接泄露带令牌的地址
+func setShareResponseHeaders(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("X-Robots-Tag", "noindex")
+}
+
+// handleProxyKeySharePage 显示分享页面，需要点击按钮才会取出密钥，避免聊天工具的链接预览消耗一次性链接
+func (s *MultiProviderServer) handleProxyKeySharePage(c *gin.Context) {
+	setShareResponseHeaders(c)
+
+	key, expiresAt, err := s.proxyKeyManager.ShareLinkKey(c.Param("token"))
+	if err != nil {
+		if !errors.Is(err, proxykey.ErrShareLinkNotFound) {
+			log.Printf("Failed to load proxy key share: %v", err)
+		}
+		c.HTML(http.StatusNotFound, "key_share.html", gin.H{
+			"title": "分享链接已失效 - TurnsAPI",
+			"valid": false,
+		})
+		return
+	}
+
+	c.HTML(http.StatusOK, "key_share.html", gin.H{
+		"title":     "查看代理密钥 - TurnsAPI",
+		"valid":     true,
+		"name":      key.Name,
+		"expiresAt": expiresAt.Format("2006-01-02 15:04:05"),
+	})
+}
+
+// handleRedeemProxyKeyShare 取出分享链接中的明文密钥，链接随即失效
+func (s *MultiProviderServer) handleRedeemProxyKeyShare(c *gin.Context) {
+	setShareResponseHeaders(c)
+
+	key, plaintext, err := s.proxyKeyManager.RedeemShareLink(c.Param("token"))
+	if err != nil {
+		if errors.Is(err, proxykey.ErrShareLinkNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"error":   "Share link has expired or has already been viewed",
+			})
+			return
+		}
+		log.Printf("Failed to redeem proxy key share: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to redeem share link",
+		})
+		return
+	}
+
+	log.Printf("代理密钥 %s (%s) 的分享链接已被查看，来源 %s", key.Name, key.ID, c.ClientIP())
+	s.recordAudit(c, "proxy_key.share_redeem", key.ID, nil, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"name":    key.Name,
+		"key":     plaintext,
+	})
+}
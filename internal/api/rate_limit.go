@@ -0,0 +1,140 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/logger"
+	"turnsapi/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverRateLimiter 服务器级限流器，未配置的规则为空
+type serverRateLimiter struct {
+	global *ratelimit.TokenBucketLimiter
+	perIP  *ratelimit.TokenBucketLimiter
+	perKey *ratelimit.TokenBucketLimiter
+}
+
+// newServerRateLimiter 根据 server.rate_limit 创建限流器，未配置任何规则时返回空
+func newServerRateLimiter(settings *internal.ServerRateLimitSettings) *serverRateLimiter {
+	if settings == nil {
+		return nil
+	}
+	limiter := &serverRateLimiter{
+		global: newTokenBucketLimiter(settings.Global),
+		perIP:  newTokenBucketLimiter(settings.PerIP),
+		perKey: newTokenBucketLimiter(settings.PerKey),
+	}
+	if limiter.global == nil && limiter.perIP == nil && limiter.perKey == nil {
+		return nil
+	}
+	return limiter
+}
+
+// newTokenBucketLimiter 根据规则创建令牌桶，requests_per_minute 为0时返回空
+func newTokenBucketLimiter(rule internal.RateLimitRule) *ratelimit.TokenBucketLimiter {
+	if rule.RequestsPerMinute <= 0 {
+		return nil
+	}
+	return ratelimit.NewTokenBucketLimiter(rule.RequestsPerMinute, rule.Burst)
+}
+
+// clientRateLimitMiddleware 认证前按客户端IP和全局限流，无效密钥的暴力尝试同样受限
+func (s *MultiProviderServer) clientRateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.rateLimiter == nil {
+			c.Next()
+			return
+		}
+		if limiter := s.rateLimiter.perIP; limiter != nil {
+			if !applyRateLimit(c, limiter.Allow(c.ClientIP()), "Rate limit exceeded for this client address", "ip_rate_limit_exceeded") {
+				return
+			}
+		}
+		if limiter := s.rateLimiter.global; limiter != nil {
+			if !applyRateLimit(c, limiter.Allow(""), "Server is receiving too many requests", "global_rate_limit_exceeded") {
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// keyRateLimitMiddleware 认证后按代理密钥限流
+func (s *MultiProviderServer) keyRateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.rateLimiter == nil || s.rateLimiter.perKey == nil {
+			c.Next()
+			return
+		}
+		keyInfo, _ := c.Get("key_info")
+		if proxyKey, ok := keyInfo.(*logger.ProxyKey); ok {
+			if !applyRateLimit(c, s.rateLimiter.perKey.Allow(proxyKey.ID), "Rate limit exceeded for this API key", "key_rate_limit_exceeded") {
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// applyRateLimit 写入 RateLimit-* 响应头，超限时返回429并中止请求
+// 多个规则同时生效时响应头反映剩余额度最少的规则
+func applyRateLimit(c *gin.Context, result ratelimit.TokenBucketResult, message, code string) bool {
+	header := c.Writer.Header()
+	if current, err := strconv.Atoi(header.Get("RateLimit-Remaining")); err != nil || result.Remaining < current || !result.Allowed {
+		header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	}
+	if result.Allowed {
+		return true
+	}
+
+	header.Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.RetryAfter), 1)))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    "rate_limit_error",
+			"code":    code,
+		},
+	})
+	c.Abort()
+	return false
+}
+
+// ceilSeconds 向上取整到秒
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// serverRateLimitStats 服务器级限流的规则和当前跟踪的标识数量，未启用时返回空
+func (s *MultiProviderServer) serverRateLimitStats() gin.H {
+	if s.rateLimiter == nil {
+		return nil
+	}
+	settings := s.config.Server.RateLimit
+	stats := gin.H{}
+	for name, entry := range map[string]struct {
+		rule    internal.RateLimitRule
+		limiter *ratelimit.TokenBucketLimiter
+	}{
+		"global":  {settings.Global, s.rateLimiter.global},
+		"per_ip":  {settings.PerIP, s.rateLimiter.perIP},
+		"per_key": {settings.PerKey, s.rateLimiter.perKey},
+	} {
+		if entry.limiter == nil {
+			continue
+		}
+		stats[name] = gin.H{
+			"requests_per_minute": entry.rule.RequestsPerMinute,
+			"burst":               entry.limiter.Burst(),
+			"tracked":             entry.limiter.Size(),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"log"
+	"reflect"
+	"slices"
+
+	"turnsapi/internal"
+)
+
+// ReloadResult 重新加载配置的结果
+type ReloadResult struct {
+	Groups          int      // 重新加载后的分组数
+	UpdatedGroups   int      // 新增、变更或删除的分组数
+	RestartRequired []string // 已变更但需要重启才能生效的设置
+}
+
+// Reload 重新读取配置文件和数据库中的分组，不中断正在处理的请求
+// 变化的分组同步到密钥管理器、健康检查和限流，认证设置同步到认证管理器，数据库空闲连接重新建立；check 不为空时在替换配置前校验新配置
+// 监听地址、可信代理、服务器限流、指标路径等在启动时用于创建监听器、路由和中间件的设置不会替换，作为需要重启的设置返回
+func (s *MultiProviderServer) Reload(check func(*internal.Config) error) (*ReloadResult, error) {
+	before := s.configManager.GetAllGroups()
+	restartRequired, err := s.configManager.ReloadFile(check)
+	if err != nil {
+		return nil, err
+	}
+	after := s.configManager.GetAllGroups()
+	s.authManager.UpdateConfig(s.configManager.Snapshot())
+
+	if s.requestLogger != nil {
+		if err := s.requestLogger.ResetConnections(); err != nil {
+			log.Printf("警告: 重新连接日志数据库失败: %v", err)
+		}
+	}
+
+	return &ReloadResult{
+		Groups:          len(after),
+		UpdatedGroups:   s.syncGroups(before, after),
+		RestartRequired: restartRequired,
+	}, nil
+}
+
+// syncGroups 将分组变化同步到密钥管理器、健康检查和代理，返回变化的分组数
+// 只有密钥、轮换策略或启用状态变化时才重建密钥管理器，避免丢失其余分组的密钥失败和限流状态
+func (s *MultiProviderServer) syncGroups(before, after map[string]*internal.UserGroup) int {
+	updated := 0
+	for groupID, group := range after {
+		old, exists := before[groupID]
+		if exists && reflect.DeepEqual(old, group) {
+			continue
+		}
+		if !exists || old.Enabled != group.Enabled || old.RotationStrategy != group.RotationStrategy ||
+			!slices.Equal(old.APIKeys, group.APIKeys) {
+			if err := s.keyManager.UpdateGroupConfig(groupID, group); err != nil {
+				log.Printf("警告: 重新加载分组 %s 时更新密钥管理器失败: %v", groupID, err)
+			}
+		}
+		s.proxy.UpdateRPMLimit(groupID, group.RPMLimit)
+		s.proxy.ResetProvider(groupID)
+		if group.Enabled && s.healthChecker != nil {
+			go s.healthChecker.PerformInitialHealthCheck(groupID)
+		}
+		updated++
+	}
+	for groupID := range before {
+		if _, exists := after[groupID]; !exists {
+			if err := s.keyManager.UpdateGroupConfig(groupID, nil); err != nil {
+				log.Printf("警告: 重新加载时移除分组 %s 的密钥管理器失败: %v", groupID, err)
+			}
+			if s.healthChecker != nil {
+				s.healthChecker.RemoveGroup(groupID)
+			}
+			s.proxy.RemoveProvider(groupID)
+			updated++
+		}
+	}
+	return updated
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"turnsapi/internal/logger"
+	"turnsapi/internal/reports"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultUsageReportLimit = 30
+	maxUsageReportLimit     = 366
+)
+
+// generateUsageReportRequest 手动生成用量报告的请求
+type generateUsageReportRequest struct {
+	Period string `json:"period" binding:"required"` // daily 或 weekly
+	Date   string `json:"date"`                      // 报告覆盖的任意一天（YYYY-MM-DD），默认为上一个周期
+}
+
+// validUsageReportPeriod 检查报告周期是否有效
+func validUsageReportPeriod(period string) bool {
+	return period == logger.UsageReportDaily || period == logger.UsageReportWeekly
+}
+
+// handleUsageReports 按时间倒序获取历史用量报告，可按周期过滤
+func (s *MultiProviderServer) handleUsageReports(c *gin.Context) {
+	period := c.Query("period")
+	if period != "" && !validUsageReportPeriod(period) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "period must be daily or weekly",
+		})
+		return
+	}
+	limit := defaultUsageReportLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "limit must be a positive integer",
+			})
+			return
+		}
+		limit = min(parsed, maxUsageReportLimit)
+	}
+
+	reportList, err := s.requestLogger.GetUsageReports(period, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to get usage reports: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"reports": reportList,
+	})
+}
+
+// handleUsageReport 获取单份用量报告
+func (s *MultiProviderServer) handleUsageReport(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid report ID",
+		})
+		return
+	}
+
+	report, err := s.requestLogger.GetUsageReport(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"report":  report,
+	})
+}
+
+// handleGenerateUsageReport 立即生成（或重新生成）一份已结束周期的用量报告，不发送通知
+func (s *MultiProviderServer) handleGenerateUsageReport(c *gin.Context) {
+	var req generateUsageReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+	if !validUsageReportPeriod(req.Period) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "period must be daily or weekly",
+		})
+		return
+	}
+
+	var at time.Time
+	if req.Date != "" {
+		date, err := time.ParseInLocation("2006-01-02", req.Date, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "Invalid date, expected YYYY-MM-DD",
+			})
+			return
+		}
+		at = date
+	} else {
+		current, _, _ := reports.PeriodBounds(req.Period, time.Now())
+		at = current.Add(-time.Nanosecond)
+	}
+
+	report, err := s.reportGenerator.Generate(req.Period, at)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Failed to generate usage report: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"report":  report,
+	})
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"turnsapi/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestIDHeader 请求ID的请求头和响应头
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID 客户端或网关传入的请求ID只接受常见的ID字符，避免日志注入
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// requestIDMiddleware 为每个请求分配请求ID：沿用客户端传入的 X-Request-ID，否则生成新的ID
+// 请求ID写入响应头、gin上下文（request_id）和请求 context，结构化日志和请求日志都会记录
+func (s *MultiProviderServer) requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+
+		c.Set("request_id", requestID)
+		c.Header(requestIDHeader, requestID)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), requestID))
+		c.Next()
+	}
+}
+
+// newRequestID 生成随机请求ID
+func newRequestID() string {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(id)
+}
+
+// accessLogMiddleware 结构化访问日志，替代 gin 默认的文本访问日志
+// 5xx 记录为 error，4xx 记录为 warn，其余为 info
+func (s *MultiProviderServer) accessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", path),
+			slog.Int("status", status),
+			slog.Int64("latency_ms", time.Since(start).Milliseconds()),
+			slog.String("client_ip", c.ClientIP()),
+		}
+		if proxyKeyName := c.GetString("proxy_key_name"); proxyKeyName != "" {
+			attrs = append(attrs, slog.String("proxy_key", proxyKeyName))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", c.Errors.String()))
+		}
+		slog.LogAttrs(c.Request.Context(), level, "http request", attrs...)
+	}
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"turnsapi/internal/logger"
+	"turnsapi/internal/proxykey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tenantRequest 创建或更新租户的请求
+type tenantRequest struct {
+	Name                string `json:"name" binding:"required"`
+	Description         string `json:"description"`
+	IsActive            *bool  `json:"is_active"`             // 创建时默认启用，更新时未提供则保持不变
+	MonthlyRequestLimit int64  `json:"monthly_request_limit"` // 每自然月请求数上限，0表示不限制
+	MonthlyTokenLimit   int64  `json:"monthly_token_limit"`   // 每自然月token数上限，0表示不限制
+}
+
+// toSettings 转换为租户设置，未提供启用状态时使用 defaultActive
+func (req *tenantRequest) toSettings(defaultActive bool) proxykey.TenantSettings {
+	settings := proxykey.TenantSettings{
+		Name:                req.Name,
+		Description:         req.Description,
+		IsActive:            defaultActive,
+		MonthlyRequestLimit: req.MonthlyRequestLimit,
+		MonthlyTokenLimit:   req.MonthlyTokenLimit,
+	}
+	if req.IsActive != nil {
+		settings.IsActive = *req.IsActive
+	}
+	return settings
+}
+
+// applyTenantFilter 查询参数带有 tenant_id 时只统计和返回该租户代理密钥的日志
+func (s *MultiProviderServer) applyTenantFilter(c *gin.Context, filter *logger.LogFilter) {
+	if tenantID := strings.TrimSpace(c.Query("tenant_id")); tenantID != "" {
+		filter.ProxyKeyIDs = s.proxyKeyManager.TenantKeyIDs(tenantID)
+	}
+}
+
+// handleTenants 获取所有租户及其本月用量
+func (s *MultiProviderServer) handleTenants(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"tenants": s.proxyKeyManager.GetAllTenants(),
+	})
+}
+
+// handleCreateTenant 创建租户
+func (s *MultiProviderServer) handleCreateTenant(c *gin.Context) {
+	var req tenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	tenant, err := s.proxyKeyManager.CreateTenant(req.toSettings(true))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	log.Printf("创建租户: %s (%s)", tenant.Name, tenant.ID)
+	s.recordAudit(c, "tenant.create", tenant.ID, nil, tenant)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"tenant":  tenant,
+	})
+}
+
+// handleUpdateTenant 更新租户的名称、说明、启用状态和每月额度
+func (s *MultiProviderServer) handleUpdateTenant(c *gin.Context) {
+	id := c.Param("id")
+	before, exists := s.proxyKeyManager.GetTenant(id)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Tenant not found",
+		})
+		return
+	}
+
+	var req tenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	tenant, err := s.proxyKeyManager.UpdateTenant(id, req.toSettings(before.IsActive))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	log.Printf("更新租户: %s (%s)", tenant.Name, tenant.ID)
+	s.recordAudit(c, "tenant.update", id, before, tenant)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"tenant":  tenant,
+	})
+}
+
+// handleDeleteTenant 删除租户，租户下的代理密钥保留并不再属于任何租户
+func (s *MultiProviderServer) handleDeleteTenant(c *gin.Context) {
+	id := c.Param("id")
+	before, exists := s.proxyKeyManager.GetTenant(id)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Tenant not found",
+		})
+		return
+	}
+
+	if err := s.proxyKeyManager.DeleteTenant(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to delete tenant: " + err.Error(),
+		})
+		return
+	}
+
+	log.Printf("删除租户: %s (%s)", before.Name, id)
+	s.recordAudit(c, "tenant.delete", id, before, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// tenantLogFilter 解析按租户查询日志的筛选条件，租户不存在时已写入错误响应并返回nil
+func (s *MultiProviderServer) tenantLogFilter(c *gin.Context) (*proxykey.Tenant, *logger.LogFilter) {
+	if s.requestLogger == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "Request logger not available",
+		})
+		return nil, nil
+	}
+
+	tenant, exists := s.proxyKeyManager.GetTenant(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Tenant not found",
+		})
+		return nil, nil
+	}
+
+	filter := s.parseLogFilterWithRange(c)
+	filter.ProxyKeyIDs = s.proxyKeyManager.TenantKeyIDs(tenant.ID)
+	return tenant, filter
+}
+
+// handleTenantStats 获取租户的本月用量和额度，以及筛选范围内的总量、按代理密钥和按模型的统计
+func (s *MultiProviderServer) handleTenantStats(c *gin.Context) {
+	tenant, filter := s.tenantLogFilter(c)
+	if filter == nil {
+		return
+	}
+
+	totals, err := s.requestLogger.GetTotalTokensStatsWithFilter(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to get tenant stats: " + err.Error(),
+		})
+		return
+	}
+	keyStats, err := s.requestLogger.GetProxyKeyStatsWithFilter(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to get tenant key stats: " + err.Error(),
+		})
+		return
+	}
+	modelStats, err := s.requestLogger.GetModelStatsWithFilter(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to get tenant model stats: " + err.Error(),
+		})
+		return
+	}
+
+	status := tenant.InactiveReason()
+	if status == "" {
+		status = "active"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"tenant":      tenant,
+		"status":      status,
+		"totals":      totals,
+		"key_stats":   keyStats,
+		"model_stats": modelStats,
+	})
+}
+
+// handleTenantLogs 分页查询租户所有代理密钥的请求日志，支持与日志统计相同的筛选和时间范围参数
+func (s *MultiProviderServer) handleTenantLogs(c *gin.Context) {
+	_, filter := s.tenantLogFilter(c)
+	if filter == nil {
+		return
+	}
+
+	filter.Limit = 50
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		filter.Limit = l
+	}
+	if o, err := strconv.Atoi(c.Query("offset")); err == nil && o >= 0 {
+		filter.Offset = o
+	}
+
+	logs, err := s.requestLogger.GetRequestLogsWithFilter(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to get logs: " + err.Error(),
+		})
+		return
+	}
+	totalCount, err := s.requestLogger.GetRequestCountWithFilter(filter)
+	if err != nil {
+		log.Printf("Failed to get logs count: %v", err)
+		totalCount = 0
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"logs":        logs,
+		"total_count": totalCount,
+	})
+}
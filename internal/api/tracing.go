@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"turnsapi/internal"
+	"turnsapi/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authSpanContextKey 认证阶段span在gin上下文中的键
+const authSpanContextKey = "trace_auth_span"
+
+// enableTracing 按 monitoring.tracing 启用链路追踪
+func (s *MultiProviderServer) enableTracing(settings *internal.TracingSettings) {
+	s.tracingShutdown = tracing.Init(tracing.Config{
+		Endpoint:    settings.Endpoint,
+		Headers:     settings.Headers,
+		ServiceName: settings.ServiceName,
+		SampleRatio: settings.SampleRatio,
+	})
+	log.Printf("链路追踪已启用，导出到 %s", settings.Endpoint)
+}
+
+// proxyMiddlewares 代理路由的中间件链：请求span、客户端限流、认证（单独记录为span）、密钥限流
+func (s *MultiProviderServer) proxyMiddlewares(authMiddleware gin.HandlerFunc) []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		s.traceMiddleware(),
+		s.clientRateLimitMiddleware(),
+		startAuthSpan,
+		authMiddleware,
+		endAuthSpan,
+		s.keyRateLimitMiddleware(),
+	}
+}
+
+// traceMiddleware 为代理请求创建服务端span，沿用客户端 traceparent 请求头中的追踪上下文
+// 之后的路由、密钥选择、上游调用和日志记录都是它的子span
+func (s *MultiProviderServer) traceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tracing.Enabled() {
+			c.Next()
+			return
+		}
+
+		ctx := tracing.Extract(c.Request.Context(), c.Request.Header)
+		ctx, span := tracing.Start(ctx, fmt.Sprintf("%s %s", c.Request.Method, c.FullPath()), tracing.KindServer)
+		span.SetAttribute("http.request.method", c.Request.Method)
+		span.SetAttribute("http.route", c.FullPath())
+		span.SetAttribute("client.address", c.ClientIP())
+		span.SetAttribute("turnsapi.request_id", c.GetString("request_id"))
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttribute("http.response.status_code", status)
+		if proxyKeyName := c.GetString("proxy_key_name"); proxyKeyName != "" {
+			span.SetAttribute("turnsapi.proxy_key", proxyKeyName)
+		}
+		if status >= 500 {
+			span.SetError(fmt.Sprintf("HTTP %d", status))
+		}
+		// 认证失败时请求在认证中间件中止，认证span在这里结束
+		if value, exists := c.Get(authSpanContextKey); exists {
+			if authSpan, ok := value.(*tracing.Span); ok {
+				authSpan.SetError(fmt.Sprintf("HTTP %d", status))
+				authSpan.End()
+			}
+		}
+		span.End()
+	}
+}
+
+// startAuthSpan 开始认证阶段span
+func startAuthSpan(c *gin.Context) {
+	if _, span := tracing.Start(c.Request.Context(), "auth", tracing.KindInternal); span != nil {
+		c.Set(authSpanContextKey, span)
+	}
+	c.Next()
+}
+
+// endAuthSpan 认证通过后结束认证阶段span
+func endAuthSpan(c *gin.Context) {
+	if value, exists := c.Get(authSpanContextKey); exists {
+		if span, ok := value.(*tracing.Span); ok {
+			span.SetAttribute("turnsapi.proxy_key", c.GetString("proxy_key_name"))
+			span.End()
+		}
+		c.Set(authSpanContextKey, (*tracing.Span)(nil))
+	}
+	c.Next()
+}
+
+// shutdownTracing 导出剩余的span
+func (s *MultiProviderServer) shutdownTracing(ctx context.Context) {
+	if s.tracingShutdown == nil {
+		return
+	}
+	if err := s.tracingShutdown(ctx); err != nil {
+		log.Printf("导出剩余链路追踪数据失败: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"turnsapi/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// passwordSession 当前请求的密码登录会话，单点登录和未启用认证时返回错误响应
+func passwordSession(c *gin.Context) (*auth.Session, bool) {
+	value, _ := c.Get("session")
+	session, _ := value.(*auth.Session)
+	if session == nil || session.Method != "password" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Two-factor authentication is only available for password logins",
+		})
+		return nil, false
+	}
+	return session, true
+}
+
+// handleTwoFactorSetup 生成新的TOTP密钥，返回 otpauth:// 地址用于生成二维码
+func (s *MultiProviderServer) handleTwoFactorSetup(c *gin.Context) {
+	session, ok := passwordSession(c)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := s.authManager.BeginTwoFactorSetup(session.Username)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"secret":      secret,
+		"otpauth_url": uri,
+	})
+}
+
+// handleTwoFactorEnable 校验验证码并启用两步验证，返回只显示一次的恢复码
+func (s *MultiProviderServer) handleTwoFactorEnable(c *gin.Context) {
+	session, ok := passwordSession(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format",
+		})
+		return
+	}
+
+	codes, err := s.authManager.EnableTwoFactor(session.Username, req.Code, session.ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	log.Printf("管理用户 %s 已启用两步验证", session.Username)
+	s.recordAudit(c, "admin_user.enable_2fa", session.UserID, nil, gin.H{"username": session.Username})
+	c.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"recovery_codes": codes,
+	})
+}
+
+// handleTwoFactorDisable 关闭自己的两步验证，需要密码和验证码
+func (s *MultiProviderServer) handleTwoFactorDisable(c *gin.Context) {
+	session, ok := passwordSession(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format",
+		})
+		return
+	}
+
+	if err := s.authManager.DisableTwoFactor(session.Username, req.Password, req.Code, session.ID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	log.Printf("管理用户 %s 已关闭两步验证", session.Username)
+	s.recordAudit(c, "admin_user.disable_2fa", session.UserID, nil, gin.H{"username": session.Username})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// handleRegenerateRecoveryCodes 重新生成恢复码
+func (s *MultiProviderServer) handleRegenerateRecoveryCodes(c *gin.Context) {
+	session, ok := passwordSession(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format",
+		})
+		return
+	}
+
+	codes, err := s.authManager.RegenerateRecoveryCodes(session.Username, req.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	s.recordAudit(c, "admin_user.regenerate_recovery_codes", session.UserID, nil, gin.H{"username": session.Username})
+	c.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"recovery_codes": codes,
+	})
+}
+
+// handleResetUserTwoFactor 管理员为丢失身份验证器的用户关闭两步验证
+func (s *MultiProviderServer) handleResetUserTwoFactor(c *gin.Context) {
+	user, err := s.authManager.ResetTwoFactor(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	log.Printf("已重置管理用户 %s 的两步验证", user.Username)
+	s.recordAudit(c, "admin_user.reset_2fa", user.ID, nil, gin.H{"username": user.Username})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
@@ -0,0 +1,311 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"turnsapi/internal/auth"
+	"turnsapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminUserResponse 管理用户及其活跃会话数
+type adminUserResponse struct {
+	*logger.AdminUser
+	ActiveSessions int `json:"active_sessions"`
+}
+
+// auditAdminUser 管理用户的审计快照，不含密码哈希
+func auditAdminUser(user *logger.AdminUser) gin.H {
+	return gin.H{"username": user.Username, "role": user.Role, "disabled": user.Disabled}
+}
+
+// handleAdminUsers 获取管理用户列表
+func (s *MultiProviderServer) handleAdminUsers(c *gin.Context) {
+	users, err := s.requestLogger.GetAllAdminUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to get admin users: " + err.Error(),
+		})
+		return
+	}
+
+	sessions := s.authManager.UserSessionCounts()
+	result := make([]adminUserResponse, 0, len(users))
+	for _, user := range users {
+		result = append(result, adminUserResponse{AdminUser: user, ActiveSessions: sessions[user.ID]})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"users":   result,
+	})
+}
+
+// handleCreateAdminUser 创建管理用户
+func (s *MultiProviderServer) handleCreateAdminUser(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+		Role     string `json:"role"` // 默认 viewer
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format",
+		})
+		return
+	}
+	if req.Role == "" {
+		req.Role = auth.RoleViewer
+	}
+
+	user, err := s.authManager.CreateUser(req.Username, req.Password, req.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	log.Printf("创建管理用户: %s (角色: %s)", user.Username, user.Role)
+	s.recordAudit(c, "admin_user.create", user.ID, nil, auditAdminUser(user))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"user":    user,
+	})
+}
+
+// handleUpdateAdminUser 修改管理用户的角色、密码或禁用状态，修改后该用户需要重新登录
+func (s *MultiProviderServer) handleUpdateAdminUser(c *gin.Context) {
+	id := c.Param("id")
+	var req struct {
+		Role     *string `json:"role"`
+		Password *string `json:"password"`
+		Disabled *bool   `json:"disabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format",
+		})
+		return
+	}
+
+	before, err := s.requestLogger.GetAdminUser(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "User not found",
+		})
+		return
+	}
+
+	user, err := s.authManager.UpdateUser(id, auth.AdminUserUpdate{Role: req.Role, Password: req.Password, Disabled: req.Disabled})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	after := auditAdminUser(user)
+	if req.Password != nil {
+		after["password_changed"] = true
+	}
+	log.Printf("更新管理用户: %s", user.Username)
+	s.recordAudit(c, "admin_user.update", user.ID, auditAdminUser(before), after)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"user":    user,
+	})
+}
+
+// handleDeleteAdminUser 删除管理用户，不能删除当前登录的用户
+func (s *MultiProviderServer) handleDeleteAdminUser(c *gin.Context) {
+	id := c.Param("id")
+	if session, ok := c.Get("session"); ok {
+		if current, ok := session.(*auth.Session); ok && current.UserID == id {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "Cannot delete the currently logged-in user",
+			})
+			return
+		}
+	}
+
+	user, err := s.authManager.DeleteUser(id)
+	if err != nil {
+		status := http.StatusBadRequest
+		if strings.Contains(err.Error(), "not found") {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	log.Printf("删除管理用户: %s", user.Username)
+	s.recordAudit(c, "admin_user.delete", user.ID, auditAdminUser(user), nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// handleRevokeAdminUserSessions 使管理用户的所有会话失效（强制下线）
+func (s *MultiProviderServer) handleRevokeAdminUserSessions(c *gin.Context) {
+	user, err := s.requestLogger.GetAdminUser(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "User not found",
+		})
+		return
+	}
+
+	revoked := s.authManager.RevokeUserSessions(user.ID)
+	s.recordAudit(c, "admin_user.revoke_sessions", user.ID, nil, gin.H{"username": user.Username, "revoked": revoked})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"revoked": revoked,
+	})
+}
+
+// handleAccount 获取当前登录用户的信息
+func (s *MultiProviderServer) handleAccount(c *gin.Context) {
+	session, ok := c.Get("session")
+	current, _ := session.(*auth.Session)
+	if !ok || current == nil {
+		// 未启用认证时拥有全部权限
+		c.JSON(http.StatusOK, gin.H{
+			"success":      true,
+			"auth_enabled": false,
+			"role":         auth.RoleAdmin,
+		})
+		return
+	}
+
+	response := gin.H{
+		"success":             true,
+		"auth_enabled":        true,
+		"username":            current.Username,
+		"role":                current.Role,
+		"auth_method":         current.Method,
+		"expires_at":          current.ExpiresAt,
+		"two_factor_enabled":  false,
+		"two_factor_required": s.authManager.TwoFactorRequired() && current.Method == "password",
+	}
+	if current.Method == "password" {
+		if enabled, remaining, err := s.authManager.TwoFactorStatus(current.Username); err == nil {
+			response["two_factor_enabled"] = enabled
+			response["recovery_codes_remaining"] = remaining
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// handleChangePassword 当前登录用户修改自己的密码，其他会话随之失效
+func (s *MultiProviderServer) handleChangePassword(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request format",
+		})
+		return
+	}
+
+	session, _ := c.Get("session")
+	current, _ := session.(*auth.Session)
+	if current == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Authentication is disabled",
+		})
+		return
+	}
+
+	if current.Method == "oidc" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Single sign-on users manage their password at the identity provider",
+		})
+		return
+	}
+
+	if err := s.authManager.ChangePassword(current.Username, req.CurrentPassword, req.NewPassword, current.ID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	s.recordAudit(c, "admin_user.change_password", current.UserID, nil, gin.H{"username": current.Username})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// adminSessionResponse 登录会话及是否为当前请求的会话
+type adminSessionResponse struct {
+	*auth.Session
+	Current bool `json:"current"`
+}
+
+// handleAdminSessions 列出所有未过期的登录会话
+func (s *MultiProviderServer) handleAdminSessions(c *gin.Context) {
+	sessions, err := s.authManager.ListSessions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to list sessions: " + err.Error(),
+		})
+		return
+	}
+
+	currentID := ""
+	if value, ok := c.Get("session"); ok {
+		if current, ok := value.(*auth.Session); ok {
+			currentID = current.ID
+		}
+	}
+	username := c.Query("username")
+	result := make([]adminSessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		if username != "" && session.Username != username {
+			continue
+		}
+		result = append(result, adminSessionResponse{Session: session, Current: session.ID == currentID})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"sessions": result,
+	})
+}
+
+// handleRevokeAdminSession 撤销单个登录会话
+func (s *MultiProviderServer) handleRevokeAdminSession(c *gin.Context) {
+	id := c.Param("id")
+	if !s.authManager.RevokeSession(id) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Session not found",
+		})
+		return
+	}
+
+	s.recordAudit(c, "admin_session.revoke", id, nil, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
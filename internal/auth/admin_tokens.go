@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"turnsapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 管理API令牌权限范围
+const (
+	AdminScopeRead         = "read"          // 只读访问所有管理接口
+	AdminScopeManageGroups = "manage_groups" // 管理分组、模型和健康检查
+	AdminScopeManageKeys   = "manage_keys"   // 管理提供商密钥和代理密钥
+	AdminScopeAll          = "admin"         // 全部权限
+)
+
+// adminTokenPrefix 管理API令牌前缀，用于与会话令牌区分
+const adminTokenPrefix = "tadm_"
+
+// validAdminScopes 所有合法的权限范围
+var validAdminScopes = map[string]bool{
+	AdminScopeRead:         true,
+	AdminScopeManageGroups: true,
+	AdminScopeManageKeys:   true,
+	AdminScopeAll:          true,
+}
+
+// AdminTokenStore 管理API令牌存储接口
+type AdminTokenStore interface {
+	InsertAdminToken(token *logger.AdminToken) error
+	GetAdminTokenByHash(tokenHash string) (*logger.AdminToken, error)
+	UpdateAdminTokenLastUsed(id string) error
+}
+
+// SetAdminTokenStore 设置管理API令牌存储
+func (am *AuthManager) SetAdminTokenStore(store AdminTokenStore) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	am.adminTokenStore = store
+}
+
+// HashAdminToken 计算管理API令牌的哈希，数据库中只保存哈希值
+func HashAdminToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeAdminScopes 校验并去重权限范围
+func NormalizeAdminScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool, len(scopes))
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if !validAdminScopes[scope] {
+			return nil, fmt.Errorf("invalid scope: %q", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	return normalized, nil
+}
+
+// CreateAdminToken 创建管理API令牌，返回令牌记录和仅此一次可见的明文令牌
+func (am *AuthManager) CreateAdminToken(name string, scopes []string) (*logger.AdminToken, string, error) {
+	am.mutex.RLock()
+	store := am.adminTokenStore
+	am.mutex.RUnlock()
+	if store == nil {
+		return nil, "", fmt.Errorf("admin token store not configured")
+	}
+
+	if name == "" {
+		return nil, "", fmt.Errorf("token name is required")
+	}
+
+	normalized, err := NormalizeAdminScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	plaintext := adminTokenPrefix + am.generateToken()
+	token := &logger.AdminToken{
+		ID:          am.generateToken()[:16],
+		Name:        name,
+		TokenHash:   HashAdminToken(plaintext),
+		TokenPrefix: plaintext[:len(adminTokenPrefix)+6],
+		Scopes:      normalized,
+		CreatedAt:   time.Now(),
+	}
+	if err := store.InsertAdminToken(token); err != nil {
+		return nil, "", fmt.Errorf("failed to save admin token: %w", err)
+	}
+	return token, plaintext, nil
+}
+
+// RequiredAdminScope 根据请求方法和路径确定所需的权限范围
+// 返回空字符串表示该接口只允许交互式登录会话访问（如令牌管理本身）
+func RequiredAdminScope(method, path string) string {
+	path = strings.TrimPrefix(path, "/admin")
+
+	switch {
+	case strings.HasPrefix(path, "/api-tokens"), strings.HasPrefix(path, "/users"), strings.HasPrefix(path, "/account"),
+		strings.HasPrefix(path, "/sessions"):
+		return ""
+	case strings.HasPrefix(path, "/backup"):
+		// 备份包含全部密钥和用户数据，下载也需要全部权限
+		return AdminScopeAll
+	case method == http.MethodGet || method == http.MethodHead:
+		if scope := secretReadScope(path); scope != "" {
+			return scope
+		}
+		return AdminScopeRead
+	case strings.HasPrefix(path, "/proxy-keys") || strings.HasPrefix(path, "/tenants") || strings.HasPrefix(path, "/keys") ||
+		strings.Contains(path, "/keys/"):
+		return AdminScopeManageKeys
+	case strings.HasPrefix(path, "/groups") || strings.HasPrefix(path, "/group-templates") || strings.HasPrefix(path, "/models") ||
+		strings.HasPrefix(path, "/health") || strings.HasPrefix(path, "/ratelimit"):
+		return AdminScopeManageGroups
+	}
+	return AdminScopeAll
+}
+
+// secretReadScope 返回明文密钥的只读接口所需的管理权限，其余只读接口返回空字符串
+// 代理密钥列表和导出、分组密钥状态返回明文密钥，分组编辑列表和配置包导出可包含上游密钥
+func secretReadScope(path string) string {
+	switch {
+	case path == "/proxy-keys", path == "/proxy-keys/export", strings.HasPrefix(path, "/keys/validation/"),
+		strings.HasPrefix(path, "/groups/") && strings.HasSuffix(path, "/keys"):
+		return AdminScopeManageKeys
+	case path == "/groups/manage", path == "/groups/bundle":
+		return AdminScopeManageGroups
+	}
+	return ""
+}
+
+// adminTokenHasScope 判断令牌是否具备所需权限，管理类权限包含只读权限
+func adminTokenHasScope(token *logger.AdminToken, required string) bool {
+	for _, scope := range token.Scopes {
+		switch {
+		case scope == AdminScopeAll, scope == required:
+			return true
+		case required == AdminScopeRead && (scope == AdminScopeManageGroups || scope == AdminScopeManageKeys):
+			return true
+		}
+	}
+	return false
+}
+
+// adminTokenRole 返回与令牌权限对应的角色，用于接口内按角色的检查
+func adminTokenRole(token *logger.AdminToken) string {
+	role := RoleViewer
+	for _, scope := range token.Scopes {
+		switch scope {
+		case AdminScopeAll:
+			return RoleAdmin
+		case AdminScopeManageGroups, AdminScopeManageKeys:
+			role = RoleOperator
+		}
+	}
+	return role
+}
+
+// authenticateAdminToken 使用管理API令牌认证 /admin 请求
+func (am *AuthManager) authenticateAdminToken(c *gin.Context, plaintext string) {
+	am.mutex.RLock()
+	store := am.adminTokenStore
+	am.mutex.RUnlock()
+
+	var token *logger.AdminToken
+	if store != nil {
+		token, _ = store.GetAdminTokenByHash(HashAdminToken(plaintext))
+	}
+	if token == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid or revoked admin token",
+			"code":  "invalid_token",
+		})
+		c.Abort()
+		return
+	}
+
+	required := RequiredAdminScope(c.Request.Method, c.Request.URL.Path)
+	if required == "" {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "This endpoint requires an interactive login session",
+			"code":  "session_required",
+		})
+		c.Abort()
+		return
+	}
+	if !adminTokenHasScope(token, required) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("Admin token lacks required scope: %s", required),
+			"code":  "insufficient_scope",
+		})
+		c.Abort()
+		return
+	}
+
+	go func() {
+		if err := store.UpdateAdminTokenLastUsed(token.ID); err != nil {
+			log.Printf("Failed to update admin token last used: %v", err)
+		}
+	}()
+
+	c.Set("user", "token:"+token.Name)
+	c.Set("admin_token_id", token.ID)
+	c.Set("role", adminTokenRole(token))
+	c.Next()
+}
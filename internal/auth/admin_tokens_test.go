@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"testing"
+
+	"turnsapi/internal/logger"
+)
+
+// TestRequiredAdminScope 测试管理路由所需的令牌权限范围，返回明文密钥的只读接口需要管理权限
+func TestRequiredAdminScope(t *testing.T) {
+	cases := []struct {
+		method, path, scope string
+	}{
+		{"GET", "/admin/groups", AdminScopeRead},
+		{"GET", "/admin/logs", AdminScopeRead},
+		{"HEAD", "/admin/status", AdminScopeRead},
+		{"GET", "/admin/proxy-keys/abc/group-stats", AdminScopeRead},
+		{"GET", "/admin/groups/archived", AdminScopeRead},
+		{"GET", "/admin/proxy-keys", AdminScopeManageKeys},
+		{"GET", "/admin/proxy-keys/export", AdminScopeManageKeys},
+		{"GET", "/admin/groups/openai/keys", AdminScopeManageKeys},
+		{"GET", "/admin/keys/validation/openai", AdminScopeManageKeys},
+		{"GET", "/admin/groups/manage", AdminScopeManageGroups},
+		{"GET", "/admin/groups/bundle", AdminScopeManageGroups},
+		{"GET", "/admin/backup", AdminScopeAll},
+		{"POST", "/admin/proxy-keys", AdminScopeManageKeys},
+		{"POST", "/admin/groups/openai/keys/force-status", AdminScopeManageKeys},
+		{"PUT", "/admin/groups/openai", AdminScopeManageGroups},
+		{"POST", "/admin/settings", AdminScopeAll},
+		{"GET", "/admin/api-tokens", ""},
+		{"GET", "/admin/users", ""},
+	}
+	for _, tc := range cases {
+		if got := RequiredAdminScope(tc.method, tc.path); got != tc.scope {
+			t.Errorf("RequiredAdminScope(%s %s) = %q, want %q", tc.method, tc.path, got, tc.scope)
+		}
+	}
+
+	// 登录会话按权限范围映射角色，只读角色不能读取明文密钥
+	for _, path := range []string{"/admin/proxy-keys", "/admin/proxy-keys/export", "/admin/groups/openai/keys"} {
+		if role := RequiredAdminRole("GET", path); role != RoleOperator {
+			t.Errorf("RequiredAdminRole(GET %s) = %s, want %s", path, role, RoleOperator)
+		}
+	}
+}
+
+// TestAdminTokenRole 测试令牌按权限范围映射角色，只有全部权限的令牌视为管理员
+func TestAdminTokenRole(t *testing.T) {
+	cases := []struct {
+		scopes []string
+		role   string
+	}{
+		{[]string{AdminScopeRead}, RoleViewer},
+		{[]string{AdminScopeRead, AdminScopeManageGroups}, RoleOperator},
+		{[]string{AdminScopeManageKeys}, RoleOperator},
+		{[]string{AdminScopeManageGroups, AdminScopeAll}, RoleAdmin},
+	}
+	for _, tc := range cases {
+		if got := adminTokenRole(&logger.AdminToken{Scopes: tc.scopes}); got != tc.role {
+			t.Errorf("adminTokenRole(%v) = %s, want %s", tc.scopes, got, tc.role)
+		}
+	}
+}
@@ -3,9 +3,11 @@ package auth
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"log"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"turnsapi/internal"
@@ -16,10 +18,20 @@ import (
 
 // Session 会话信息
 type Session struct {
-	Token     string    `json:"token"`
-	Username  string    `json:"username"`
-	CreatedAt time.Time `json:"created_at"`
-	ExpiresAt time.Time `json:"expires_at"`
+	ID         string    `json:"id"` // 令牌的SHA-256哈希，用于列出和撤销会话
+	Token      string    `json:"-"`  // 仅在创建会话时可用，从会话存储恢复的会话为空
+	Username   string    `json:"username"`
+	UserID     string    `json:"user_id,omitempty"`
+	Role       string    `json:"role"`        // viewer、operator、admin
+	Method     string    `json:"auth_method"` // password 或 oidc
+	TwoFactor  bool      `json:"two_factor"`  // 登录时已通过两步验证
+	ClientIP   string    `json:"client_ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+
+	syncedAt time.Time // 上次与会话存储同步的时间
 }
 
 // ProxyKeyValidator 代理密钥验证器接口
@@ -30,17 +42,30 @@ type ProxyKeyValidator interface {
 
 // AuthManager 认证管理器
 type AuthManager struct {
-	config          *internal.Config
+	config          atomic.Pointer[internal.Config] // 重新加载配置后替换，单点登录设置在启动时已使用，需要重启才能生效
 	sessions        map[string]*Session
+	missingSessions map[string]time.Time // 会话存储中不存在的会话ID及其记录的过期时间
 	proxyKeyManager ProxyKeyValidator
+	adminTokenStore AdminTokenStore
+	userStore       UserStore                     // 管理用户存储，未设置时使用配置中的单个账号
+	sessionStore    SessionStore                  // 登录会话存储，未设置时会话只保存在内存中
+	oidc            *OIDCProvider                 // 单点登录，未启用时为nil
+	totpLastStep    map[string]int64              // 用户最近使用的TOTP时间步，防止验证码重放
+	totpFailures    map[string]*twoFactorFailures // 用户连续输错验证码的次数
 	mutex           sync.RWMutex
 }
 
 // NewAuthManager 创建认证管理器
 func NewAuthManager(config *internal.Config) *AuthManager {
 	am := &AuthManager{
-		config:   config,
-		sessions: make(map[string]*Session),
+		sessions:        make(map[string]*Session),
+		missingSessions: make(map[string]time.Time),
+		totpLastStep:    make(map[string]int64),
+		totpFailures:    make(map[string]*twoFactorFailures),
+	}
+	am.config.Store(config)
+	if oidc := config.Auth.OIDC; oidc != nil && oidc.Enabled {
+		am.oidc = NewOIDCProvider(oidc)
 	}
 
 	// 启动会话清理器
@@ -49,6 +74,16 @@ func NewAuthManager(config *internal.Config) *AuthManager {
 	return am
 }
 
+// currentConfig 获取当前使用的配置
+func (am *AuthManager) currentConfig() *internal.Config {
+	return am.config.Load()
+}
+
+// UpdateConfig 重新加载配置后使用新的认证设置（会话超时、管理IP限制、可信头部认证、两步验证等），之后的请求立即生效
+func (am *AuthManager) UpdateConfig(config *internal.Config) {
+	am.config.Store(config)
+}
+
 // generateToken 生成随机token
 func (am *AuthManager) generateToken() string {
 	bytes := make([]byte, 32)
@@ -58,47 +93,62 @@ func (am *AuthManager) generateToken() string {
 
 // Login 用户登录
 func (am *AuthManager) Login(username, password string) (*Session, error) {
-	if !am.config.Auth.Enabled {
+	return am.LoginWithCode(username, password, "")
+}
+
+// LoginWithCode 用户登录，启用了两步验证的用户需要提供验证码或恢复码
+func (am *AuthManager) LoginWithCode(username, password, code string) (*Session, error) {
+	return am.login(username, password, code, sessionClient{})
+}
+
+// login 校验凭据并创建会话，记录客户端信息
+func (am *AuthManager) login(username, password, code string, client sessionClient) (*Session, error) {
+	if !am.currentConfig().Auth.Enabled {
 		return nil, nil
 	}
 
-	if username != am.config.Auth.Username || password != am.config.Auth.Password {
-		return nil, gin.Error{Err: http.ErrNotSupported, Type: gin.ErrorTypePublic}
+	if am.passwordLoginDisabled() {
+		return nil, errPasswordLoginDisabled
 	}
 
-	token := am.generateToken()
-	session := &Session{
-		Token:     token,
-		Username:  username,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(am.config.Auth.SessionTimeout),
+	user, ok := am.authenticateUser(username, password)
+	if !ok {
+		return nil, gin.Error{Err: http.ErrNotSupported, Type: gin.ErrorTypePublic}
+	}
+	if user.TOTPEnabled {
+		if code == "" {
+			return nil, errTwoFactorRequired
+		}
+		if err := am.verifySecondFactor(user, code); err != nil {
+			return nil, err
+		}
 	}
 
-	am.mutex.Lock()
-	am.sessions[token] = session
-	am.mutex.Unlock()
+	am.recordLogin(user)
+	return am.createSession(user.Username, user.ID, user.Role, "password", user.TOTPEnabled, client), nil
+}
 
-	return session, nil
+// passwordLoginDisabled 启用单点登录且配置为只允许单点登录
+func (am *AuthManager) passwordLoginDisabled() bool {
+	return am.oidc != nil && am.oidc.settings.DisablePasswordLogin
 }
 
 // ValidateToken 验证token
 func (am *AuthManager) ValidateToken(token string) (*Session, bool) {
-	if !am.config.Auth.Enabled {
+	if !am.currentConfig().Auth.Enabled {
 		return nil, true // 如果认证未启用，直接通过
 	}
 
-	am.mutex.RLock()
-	session, exists := am.sessions[token]
-	am.mutex.RUnlock()
-
+	session, exists := am.lookupSession(token)
 	if !exists {
 		return nil, false
 	}
 
-	if time.Now().After(session.ExpiresAt) {
-		am.mutex.Lock()
-		delete(am.sessions, token)
-		am.mutex.Unlock()
+	am.mutex.RLock()
+	expired := time.Now().After(session.ExpiresAt)
+	am.mutex.RUnlock()
+	if expired {
+		am.removeSession(session.ID)
 		return nil, false
 	}
 
@@ -107,22 +157,22 @@ func (am *AuthManager) ValidateToken(token string) (*Session, bool) {
 
 // Logout 用户登出
 func (am *AuthManager) Logout(token string) {
-	am.mutex.Lock()
-	delete(am.sessions, token)
-	am.mutex.Unlock()
+	am.removeSession(hashSessionToken(token))
 }
 
-// RefreshSession 刷新会话
+// RefreshSession 刷新会话的最近活动时间，过期时间按空闲超时顺延但不超过最长有效期
 func (am *AuthManager) RefreshSession(token string) bool {
 	am.mutex.Lock()
 	defer am.mutex.Unlock()
 
-	session, exists := am.sessions[token]
+	session, exists := am.sessions[hashSessionToken(token)]
 	if !exists {
 		return false
 	}
 
-	session.ExpiresAt = time.Now().Add(am.config.Auth.SessionTimeout)
+	now := time.Now()
+	session.LastSeenAt = now
+	session.ExpiresAt = am.sessionExpiry(session, now)
 	return true
 }
 
@@ -139,12 +189,18 @@ func (am *AuthManager) startSessionCleaner() {
 // cleanExpiredSessions 清理过期会话
 func (am *AuthManager) cleanExpiredSessions() {
 	am.mutex.Lock()
-	defer am.mutex.Unlock()
-
 	now := time.Now()
-	for token, session := range am.sessions {
+	for id, session := range am.sessions {
 		if now.After(session.ExpiresAt) {
-			delete(am.sessions, token)
+			delete(am.sessions, id)
+		}
+	}
+	store := am.sessionStore
+	am.mutex.Unlock()
+
+	if store != nil {
+		if _, err := store.DeleteExpiredAdminSessions(); err != nil {
+			log.Printf("清理过期会话失败: %v", err)
 		}
 	}
 }
@@ -152,7 +208,8 @@ func (am *AuthManager) cleanExpiredSessions() {
 // AuthMiddleware 认证中间件
 func (am *AuthManager) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !am.config.Auth.Enabled {
+		if !am.currentConfig().Auth.Enabled {
+			c.Set("role", RoleAdmin)
 			c.Next()
 			return
 		}
@@ -179,6 +236,12 @@ func (am *AuthManager) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// 自动化脚本使用的长期管理API令牌
+		if strings.HasPrefix(token, adminTokenPrefix) {
+			am.authenticateAdminToken(c, token)
+			return
+		}
+
 		session, valid := am.ValidateToken(token)
 		if !valid {
 			c.JSON(http.StatusUnauthorized, gin.H{
@@ -189,11 +252,33 @@ func (am *AuthManager) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// 按角色限制可访问的管理接口
+		required := RequiredAdminRole(c.Request.Method, c.Request.URL.Path)
+		if !RoleAllows(session.Role, required) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "This endpoint requires the " + required + " role",
+				"code":  "insufficient_role",
+			})
+			c.Abort()
+			return
+		}
+
+		// 要求两步验证时，未启用的用户只能访问账号设置完成启用
+		if am.twoFactorPending(session) && !strings.HasPrefix(strings.TrimPrefix(c.Request.URL.Path, "/admin"), "/account") {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Two-factor authentication must be enabled for this account",
+				"code":  "two_factor_required",
+			})
+			c.Abort()
+			return
+		}
+
 		// 刷新会话
 		am.RefreshSession(token)
 
 		// 将用户信息存储到上下文
 		c.Set("user", session.Username)
+		c.Set("role", session.Role)
 		c.Set("session", session)
 
 		c.Next()
@@ -203,7 +288,7 @@ func (am *AuthManager) AuthMiddleware() gin.HandlerFunc {
 // WebAuthMiddleware Web界面认证中间件
 func (am *AuthManager) WebAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !am.config.Auth.Enabled {
+		if !am.currentConfig().Auth.Enabled {
 			c.Next()
 			return
 		}
@@ -216,13 +301,18 @@ func (am *AuthManager) WebAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		_, valid := am.ValidateToken(token)
+		session, valid := am.ValidateToken(token)
 		if !valid {
-			c.SetCookie("auth_token", "", -1, "/", "", false, true)
+			am.setSessionCookie(c, "", -1)
 			c.Redirect(http.StatusFound, "/auth/login")
 			c.Abort()
 			return
 		}
+		if am.twoFactorPending(session) && c.Request.URL.Path != twoFactorPagePath {
+			c.Redirect(http.StatusFound, twoFactorPagePath)
+			c.Abort()
+			return
+		}
 
 		// 刷新会话
 		am.RefreshSession(token)
@@ -257,6 +347,15 @@ func (am *AuthManager) SetProxyKeyManager(pkm ProxyKeyValidator) {
 // APIKeyAuthMiddleware API密钥认证中间件
 func (am *AuthManager) APIKeyAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// 前置网关注入的可信身份头部
+		if am.TryTrustedHeaderAuth(c) {
+			c.Next()
+			return
+		}
+		if c.IsAborted() {
+			return
+		}
+
 		// 从Authorization头获取API密钥
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -314,14 +413,10 @@ func (am *AuthManager) APIKeyAuthMiddleware() gin.HandlerFunc {
 
 		keyInfo, valid := am.proxyKeyManager.ValidateKey(apiKey)
 		if !valid {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": gin.H{
-					"message": "Invalid API key",
-					"type":    "authentication_error",
-					"code":    "invalid_api_key",
-				},
-			})
-			c.Abort()
+			am.RejectInvalidProxyKey(c, apiKey)
+			return
+		}
+		if !am.CheckProxyKeySource(c, keyInfo) {
 			return
 		}
 
@@ -329,26 +424,62 @@ func (am *AuthManager) APIKeyAuthMiddleware() gin.HandlerFunc {
 		am.proxyKeyManager.UpdateUsage(apiKey)
 
 		// 将密钥信息存储到上下文中
-		c.Set("api_key", apiKey)
-		c.Set("key_info", keyInfo)
-		
-		// 如果keyInfo是ProxyKey类型，提取名称和ID
-		if proxyKey, ok := keyInfo.(*logger.ProxyKey); ok {
-			c.Set("proxy_key_name", proxyKey.Name)
-			c.Set("proxy_key_id", proxyKey.ID)
-		} else {
-			// 兼容旧的代理密钥管理器
-			c.Set("proxy_key_name", "Unknown")
-			c.Set("proxy_key_id", "unknown")
-		}
+		am.setKeyContext(c, apiKey, keyInfo)
 
 		c.Next()
 	}
 }
 
+// ProxyKeyStatusReporter 能够说明密钥不可用原因的代理密钥管理器
+type ProxyKeyStatusReporter interface {
+	KeyInactiveReason(key string) string
+}
+
+// RejectInvalidProxyKey 返回代理密钥认证失败响应，过期、使用次数用完和租户不可用的密钥使用不同的错误码
+func (am *AuthManager) RejectInvalidProxyKey(c *gin.Context, apiKey string) {
+	status, message, code := http.StatusUnauthorized, "Invalid API key", "invalid_api_key"
+	if reporter, ok := am.proxyKeyManager.(ProxyKeyStatusReporter); ok {
+		switch reporter.KeyInactiveReason(apiKey) {
+		case "expired":
+			message, code = "API key has expired", "api_key_expired"
+		case "exhausted":
+			status, message, code = http.StatusForbidden, "API key usage limit reached", "api_key_usage_exhausted"
+		case "tenant_disabled":
+			status, message, code = http.StatusForbidden, "The tenant of this API key is disabled", "tenant_disabled"
+		case "tenant_quota_exceeded":
+			status, message, code = http.StatusForbidden, "The monthly quota of this API key's tenant has been reached", "tenant_quota_exceeded"
+		}
+	}
+
+	c.JSON(status, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    "authentication_error",
+			"code":    code,
+		},
+	})
+	c.Abort()
+}
+
+// setKeyContext 将已认证的代理密钥信息存储到上下文中
+func (am *AuthManager) setKeyContext(c *gin.Context, apiKey string, keyInfo interface{}) {
+	c.Set("api_key", apiKey)
+	c.Set("key_info", keyInfo)
+
+	// 如果keyInfo是ProxyKey类型，提取名称和ID
+	if proxyKey, ok := keyInfo.(*logger.ProxyKey); ok {
+		c.Set("proxy_key_name", proxyKey.Name)
+		c.Set("proxy_key_id", proxyKey.ID)
+	} else {
+		// 兼容旧的代理密钥管理器
+		c.Set("proxy_key_name", "Unknown")
+		c.Set("proxy_key_id", "unknown")
+	}
+}
+
 // HandleLogin 处理登录请求
 func (am *AuthManager) HandleLogin(c *gin.Context) {
-	if !am.config.Auth.Enabled {
+	if !am.currentConfig().Auth.Enabled {
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
 			"message": "Authentication disabled",
@@ -359,6 +490,7 @@ func (am *AuthManager) HandleLogin(c *gin.Context) {
 	var loginReq struct {
 		Username string `json:"username" binding:"required"`
 		Password string `json:"password" binding:"required"`
+		OTP      string `json:"otp"` // 两步验证码或恢复码
 	}
 
 	if err := c.ShouldBindJSON(&loginReq); err != nil {
@@ -370,7 +502,24 @@ func (am *AuthManager) HandleLogin(c *gin.Context) {
 	}
 
 	// 使用Login方法创建会话
-	session, err := am.Login(loginReq.Username, loginReq.Password)
+	session, err := am.login(loginReq.Username, loginReq.Password, loginReq.OTP,
+		sessionClient{ip: c.ClientIP(), userAgent: c.Request.UserAgent()})
+	if err == errPasswordLoginDisabled {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "Password login is disabled, use single sign-on",
+		})
+		return
+	}
+	if err == errTwoFactorRequired || err == errInvalidTwoFactorCode || err == errTwoFactorLocked {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success":      false,
+			"message":      err.Error(),
+			"code":         "otp_required",
+			"otp_required": true,
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"success": false,
@@ -382,12 +531,13 @@ func (am *AuthManager) HandleLogin(c *gin.Context) {
 	token := session.Token
 
 	// 设置cookie
-	c.SetCookie("auth_token", token, int(am.config.Auth.SessionTimeout.Seconds()), "/", "", false, true)
+	am.setSessionCookie(c, token, int(am.currentConfig().Auth.SessionTimeout.Seconds()))
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "Login successful",
 		"token":   token,
+		"role":    session.Role,
 	})
 }
 
@@ -397,13 +547,11 @@ func (am *AuthManager) HandleLogout(c *gin.Context) {
 	token, err := c.Cookie("auth_token")
 	if err == nil && token != "" {
 		// 删除会话
-		am.mutex.Lock()
-		delete(am.sessions, token)
-		am.mutex.Unlock()
+		am.Logout(token)
 	}
 
 	// 清除cookie
-	c.SetCookie("auth_token", "", -1, "/", "", false, true)
+	am.setSessionCookie(c, "", -1)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -411,9 +559,15 @@ func (am *AuthManager) HandleLogout(c *gin.Context) {
 	})
 }
 
+// setSessionCookie 设置会话Cookie：HttpOnly、SameSite=Strict，HTTPS请求时加上Secure
+func (am *AuthManager) setSessionCookie(c *gin.Context, token string, maxAge int) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie("auth_token", token, maxAge, "/", "", secureCookie(c.Request), true)
+}
+
 // HandleLoginPage 处理登录页面
 func (am *AuthManager) HandleLoginPage(c *gin.Context) {
-	if !am.config.Auth.Enabled {
+	if !am.currentConfig().Auth.Enabled {
 		c.Redirect(http.StatusFound, "/")
 		return
 	}
@@ -428,7 +582,13 @@ func (am *AuthManager) HandleLoginPage(c *gin.Context) {
 		}
 	}
 
-	c.HTML(http.StatusOK, "login.html", gin.H{
-		"title": "登录 - TurnsAPI",
-	})
+	data := gin.H{
+		"title":          "登录 - TurnsAPI",
+		"error":          c.Query("error"),
+		"password_login": !am.passwordLoginDisabled(),
+	}
+	if am.oidc != nil {
+		data["oidc_name"] = am.oidc.DisplayName()
+	}
+	c.HTML(http.StatusOK, "login.html", data)
 }
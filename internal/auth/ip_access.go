@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"turnsapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// networkContains 判断IP是否命中列表中的任一项（IP或CIDR）
+func networkContains(entries []string, ip net.IP) bool {
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if listed := net.ParseIP(entry); listed != nil && listed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAllowed 判断来源地址是否允许：命中禁止列表时拒绝，允许列表非空时必须命中其中一项
+func ipAllowed(allowed, denied []string, ip net.IP) bool {
+	if len(allowed) == 0 && len(denied) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	if networkContains(denied, ip) {
+		return false
+	}
+	return len(allowed) == 0 || networkContains(allowed, ip)
+}
+
+// clientIP 请求的来源地址
+// 只有经由 server.trusted_proxies 中的反向代理转发时才使用 X-Forwarded-For 中的客户端地址，否则为TCP对端地址
+func clientIP(c *gin.Context) net.IP {
+	return net.ParseIP(c.ClientIP())
+}
+
+// AdminIPFilterMiddleware 按 auth.admin_allowed_ips / admin_denied_ips 限制管理接口和管理界面的来源地址
+func (am *AuthManager) AdminIPFilterMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings := am.currentConfig().Auth
+		allowed, denied := settings.AdminAllowedIPs, settings.AdminDeniedIPs
+		if ip := clientIP(c); !ipAllowed(allowed, denied, ip) {
+			log.Printf("拒绝来自 %s 的管理请求 %s %s", c.ClientIP(), c.Request.Method, c.Request.URL.Path)
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Access from this address is not allowed",
+				"code":  "ip_not_allowed",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// CheckProxyKeySource 检查请求来源地址是否符合代理密钥的允许/禁止列表
+// 不允许时写入403响应并返回false
+func (am *AuthManager) CheckProxyKeySource(c *gin.Context, keyInfo interface{}) bool {
+	proxyKey, ok := keyInfo.(*logger.ProxyKey)
+	if !ok || ipAllowed(proxyKey.AllowedIPs, proxyKey.DeniedIPs, clientIP(c)) {
+		return true
+	}
+
+	log.Printf("代理密钥 %s 拒绝来自 %s 的请求", proxyKey.Name, c.ClientIP())
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": gin.H{
+			"message": "API key is not allowed from this address",
+			"type":    "permission_error",
+			"code":    "ip_not_allowed",
+		},
+	})
+	c.Abort()
+	return false
+}
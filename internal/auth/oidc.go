@@ -0,0 +1,614 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"turnsapi/internal"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	oidcStateCookie    = "oidc_state"
+	oidcStateTTL       = 10 * time.Minute
+	oidcMaxPending     = 1000            // 未完成的登录流程上限，超出后拒绝新的登录
+	oidcJWKSRefresh    = time.Minute     // 遇到未知kid时重新获取JWKS的最短间隔
+	oidcClockSkew      = 2 * time.Minute // 校验ID令牌时间时允许的时钟误差
+	oidcCallbackPath   = "/auth/oidc/callback"
+	oidcDefaultDisplay = "SSO"
+)
+
+// errPasswordLoginDisabled 启用单点登录并禁用密码登录时返回
+var errPasswordLoginDisabled = errors.New("password login is disabled, use single sign-on")
+
+// oidcDiscovery OIDC发现文档中用到的字段
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcPendingLogin 一次未完成的登录流程
+type oidcPendingLogin struct {
+	nonce       string
+	verifier    string // PKCE code_verifier
+	redirectURL string
+	expiresAt   time.Time
+}
+
+// oidcUsernamePrefix OIDC登录会话的用户名和用户ID前缀，与本地管理用户区分，避免同名用户互相撤销会话或修改密码
+const oidcUsernamePrefix = "oidc:"
+
+// OIDCIdentity 通过ID令牌验证的登录身份
+type OIDCIdentity struct {
+	Subject  string
+	Username string
+	Groups   []string
+	Role     string
+}
+
+// OIDCProvider OpenID Connect 授权码流程（带PKCE）和ID令牌校验
+type OIDCProvider struct {
+	settings *internal.OIDCSettings
+	client   *http.Client
+
+	mutex         sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+	pending       map[string]*oidcPendingLogin
+}
+
+// NewOIDCProvider 创建OIDC提供方，发现文档在首次登录时获取，启动时身份提供方不可用不影响服务
+func NewOIDCProvider(settings *internal.OIDCSettings) *OIDCProvider {
+	return &OIDCProvider{
+		settings: settings,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		pending:  make(map[string]*oidcPendingLogin),
+	}
+}
+
+// DisplayName 登录页按钮显示的名称
+func (p *OIDCProvider) DisplayName() string {
+	if p.settings.DisplayName != "" {
+		return p.settings.DisplayName
+	}
+	return oidcDefaultDisplay
+}
+
+// AuthCodeURL 开始一次登录流程，返回身份提供方的授权地址和state
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, redirectURL string) (string, string, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, nonce, verifier := randomURLToken(), randomURLToken(), randomURLToken()
+	now := time.Now()
+	p.mutex.Lock()
+	for key, login := range p.pending {
+		if now.After(login.expiresAt) {
+			delete(p.pending, key)
+		}
+	}
+	if len(p.pending) >= oidcMaxPending {
+		p.mutex.Unlock()
+		return "", "", fmt.Errorf("too many pending logins, try again later")
+	}
+	p.pending[state] = &oidcPendingLogin{nonce: nonce, verifier: verifier, redirectURL: redirectURL, expiresAt: now.Add(oidcStateTTL)}
+	p.mutex.Unlock()
+
+	scopes := p.settings.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.settings.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), state, nil
+}
+
+// Exchange 用授权码换取ID令牌，校验后解析用户名和角色
+func (p *OIDCProvider) Exchange(ctx context.Context, state, code string) (*OIDCIdentity, error) {
+	p.mutex.Lock()
+	login, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mutex.Unlock()
+	if !ok || time.Now().After(login.expiresAt) {
+		return nil, fmt.Errorf("login session expired or invalid state")
+	}
+
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {login.redirectURL},
+		"client_id":     {p.settings.ClientID},
+		"code_verifier": {login.verifier},
+	}
+	if p.settings.ClientSecret != "" {
+		form.Set("client_secret", p.settings.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("invalid token response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("token request rejected: %s %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || token.IDToken == "" {
+		return nil, fmt.Errorf("token response without id_token (HTTP %d)", resp.StatusCode)
+	}
+
+	claims, err := p.verifyIDToken(ctx, token.IDToken, login.nonce)
+	if err != nil {
+		return nil, err
+	}
+	return p.identityFromClaims(claims)
+}
+
+// identityFromClaims 从ID令牌声明中取用户名和组，按组映射角色
+func (p *OIDCProvider) identityFromClaims(claims map[string]interface{}) (*OIDCIdentity, error) {
+	identity := &OIDCIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("id token has no sub claim")
+	}
+
+	usernameClaims := []string{"email", "preferred_username", "sub"}
+	if p.settings.UsernameClaim != "" {
+		usernameClaims = []string{p.settings.UsernameClaim}
+	}
+	unverifiedEmail := false
+	for _, name := range usernameClaims {
+		value, ok := claimValue(claims, name).(string)
+		if !ok || value == "" {
+			continue
+		}
+		// 未验证的邮箱可能由用户自行填写，不能作为用户名
+		if name == "email" && !emailVerified(claims) {
+			unverifiedEmail = true
+			continue
+		}
+		identity.Username = value
+		break
+	}
+	if identity.Username == "" {
+		if unverifiedEmail {
+			return nil, fmt.Errorf("id token email is not verified")
+		}
+		return nil, fmt.Errorf("id token has no username claim")
+	}
+
+	groupsClaim := p.settings.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	identity.Groups = claimStrings(claimValue(claims, groupsClaim))
+
+	identity.Role = p.settings.DefaultRole
+	for _, group := range identity.Groups {
+		if role, ok := p.settings.RoleMapping[group]; ok && roleLevels[role] > roleLevels[identity.Role] {
+			identity.Role = role
+		}
+	}
+	if identity.Role == "" {
+		return nil, fmt.Errorf("user %s is not in any group mapped to a role", identity.Username)
+	}
+	return identity, nil
+}
+
+// emailVerified 判断ID令牌的 email_verified 声明是否为true，部分身份提供方以字符串返回
+func emailVerified(claims map[string]interface{}) bool {
+	switch value := claims["email_verified"].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+// verifyIDToken 校验ID令牌的签名、颁发者、受众、有效期和nonce，返回声明
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, raw, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed id token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed id token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed id token signature: %w", err)
+	}
+	key, err := p.publicKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed id token claims: %w", err)
+	}
+	if issuer, _ := claims["iss"].(string); strings.TrimSuffix(issuer, "/") != strings.TrimSuffix(p.settings.Issuer, "/") {
+		return nil, fmt.Errorf("unexpected id token issuer %q", issuer)
+	}
+	audienceOK := false
+	for _, audience := range claimStrings(claims["aud"]) {
+		if audience == p.settings.ClientID {
+			audienceOK = true
+		}
+	}
+	if !audienceOK {
+		return nil, fmt.Errorf("id token audience does not include client_id")
+	}
+	now := time.Now()
+	expiresAt, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(expiresAt), 0).Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("id token expired")
+	}
+	if notBefore, ok := claims["nbf"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(notBefore), 0)) {
+		return nil, fmt.Errorf("id token not yet valid")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, fmt.Errorf("id token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// getDiscovery 获取并缓存发现文档
+func (p *OIDCProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	p.mutex.Lock()
+	cached := p.discovery
+	p.mutex.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	discovery := &oidcDiscovery{}
+	endpoint := strings.TrimSuffix(p.settings.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, endpoint, discovery); err != nil {
+		return nil, fmt.Errorf("failed to load OIDC discovery document: %w", err)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document is missing required endpoints")
+	}
+
+	p.mutex.Lock()
+	p.discovery = discovery
+	p.mutex.Unlock()
+	return discovery, nil
+}
+
+// publicKey 按kid获取签名公钥，未知kid时重新获取JWKS（密钥轮换）
+func (p *OIDCProvider) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mutex.Lock()
+	key, ok := p.keys[kid]
+	stale := time.Since(p.keysFetchedAt) >= oidcJWKSRefresh
+	p.mutex.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown id token signing key %q", kid)
+	}
+
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := p.getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to load JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, raw := range jwks.Keys {
+		keyID, parsed, err := parseJWK(raw)
+		if err != nil {
+			log.Printf("忽略无法解析的OIDC签名密钥: %v", err)
+			continue
+		}
+		keys[keyID] = parsed
+	}
+
+	p.mutex.Lock()
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	p.mutex.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	// 身份提供方只有一个密钥且令牌未携带kid
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown id token signing key %q", kid)
+}
+
+// getJSON 请求并解码JSON
+func (p *OIDCProvider) getJSON(ctx context.Context, endpoint string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d from %s", resp.StatusCode, endpoint)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// parseJWK 解析RSA或EC公钥
+func parseJWK(raw json.RawMessage) (string, crypto.PublicKey, error) {
+	var jwk struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return "", nil, err
+	}
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return "", nil, fmt.Errorf("key %q is not a signing key", jwk.Kid)
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return "", nil, fmt.Errorf("invalid RSA key %q", jwk.Kid)
+		}
+		return jwk.Kid, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return "", nil, fmt.Errorf("unsupported EC curve %q", jwk.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if errX != nil || errY != nil {
+			return "", nil, fmt.Errorf("invalid EC key %q", jwk.Kid)
+		}
+		return jwk.Kid, &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return "", nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+// verifyJWTSignature 校验RS256/RS384/RS512/ES256/ES384签名
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported id token algorithm %q", alg)
+	}
+	hasher := hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") || rsa.VerifyPKCS1v15(pub, hash, digest, signature) != nil {
+			return fmt.Errorf("invalid id token signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			return fmt.Errorf("invalid id token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("invalid id token signature")
+		}
+	default:
+		return fmt.Errorf("unsupported signing key type")
+	}
+	return nil
+}
+
+// decodeJWTPart 解码JWT的头部或载荷
+func decodeJWTPart(part string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// claimValue 按点分路径取声明的值，如 realm_access.roles
+func claimValue(claims map[string]interface{}, path string) interface{} {
+	if value, ok := claims[path]; ok {
+		return value
+	}
+	var current interface{} = claims
+	for _, segment := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[segment]
+	}
+	return current
+}
+
+// claimStrings 将字符串或字符串数组声明转换为切片
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// randomURLToken 生成URL安全的随机字符串
+func randomURLToken() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// oidcRedirectURL 回调地址，未配置时根据请求推导
+func (am *AuthManager) oidcRedirectURL(c *gin.Context) string {
+	if am.oidc.settings.RedirectURL != "" {
+		return am.oidc.settings.RedirectURL
+	}
+	scheme := "http"
+	if secureCookie(c.Request) {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + oidcCallbackPath
+}
+
+// HandleOIDCLogin 跳转到身份提供方登录
+func (am *AuthManager) HandleOIDCLogin(c *gin.Context) {
+	if am.oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Single sign-on is not enabled"})
+		return
+	}
+
+	authURL, state, err := am.oidc.AuthCodeURL(c.Request.Context(), am.oidcRedirectURL(c))
+	if err != nil {
+		log.Printf("OIDC登录失败: %v", err)
+		c.Redirect(http.StatusFound, "/auth/login?error="+url.QueryEscape("单点登录暂不可用"))
+		return
+	}
+
+	// 身份提供方回调是跨站跳转，state Cookie 需要 SameSite=Lax 才会随回调发送
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oidcStateTTL.Seconds()), "/auth/oidc", "", secureCookie(c.Request), true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// HandleOIDCCallback 处理身份提供方回调，创建会话
+func (am *AuthManager) HandleOIDCCallback(c *gin.Context) {
+	if am.oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Single sign-on is not enabled"})
+		return
+	}
+
+	fail := func(reason string, err error) {
+		log.Printf("OIDC登录失败: %s: %v", reason, err)
+		c.Redirect(http.StatusFound, "/auth/login?error="+url.QueryEscape(reason))
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", secureCookie(c.Request), true)
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		fail("身份提供方拒绝了登录", fmt.Errorf("%s %s", providerErr, c.Query("error_description")))
+		return
+	}
+	state := c.Query("state")
+	if cookie, err := c.Cookie(oidcStateCookie); err != nil || state == "" || cookie != state {
+		fail("登录状态无效，请重试", fmt.Errorf("state mismatch"))
+		return
+	}
+
+	identity, err := am.oidc.Exchange(c.Request.Context(), state, c.Query("code"))
+	if err != nil {
+		fail("单点登录验证失败", err)
+		return
+	}
+
+	// 会话用户名和用户ID加上前缀，与同名的本地管理用户区分；用户ID使用不会变化的 sub
+	session := am.createSession(oidcUsernamePrefix+identity.Username, oidcUsernamePrefix+identity.Subject, identity.Role, "oidc", false,
+		sessionClient{ip: c.ClientIP(), userAgent: c.Request.UserAgent()})
+	log.Printf("OIDC登录成功: %s (角色: %s)", identity.Username, identity.Role)
+	am.setSessionCookie(c, session.Token, int(am.currentConfig().Auth.SessionTimeout.Seconds()))
+
+	// 回调来自跨站跳转，直接302时浏览器不会携带 SameSite=Strict 的会话Cookie，改由本站页面跳转
+	c.Data(http.StatusOK, "text/html; charset=utf-8",
+		[]byte(`<!DOCTYPE html><html><head><meta http-equiv="refresh" content="0;url=/dashboard"></head><body></body></html>`))
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+)
+
+// fakeIdentityProvider 测试用的身份提供方，签发RS256 ID令牌
+type fakeIdentityProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	nonce  string
+	claims map[string]interface{}
+}
+
+func newFakeIdentityProvider(t *testing.T) *fakeIdentityProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	idp := &fakeIdentityProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "test-key",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "valid-code" || r.Form.Get("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := map[string]interface{}{
+			"iss":            idp.server.URL,
+			"aud":            "turnsapi",
+			"sub":            "user-1",
+			"email":          "alice@example.com",
+			"email_verified": true,
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          idp.nonce,
+		}
+		for name, value := range idp.claims {
+			claims[name] = value
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, claims)})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *fakeIdentityProvider) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// login 走一遍授权码流程，返回登录身份
+func (idp *fakeIdentityProvider) login(t *testing.T, provider *OIDCProvider, code string) (*OIDCIdentity, error) {
+	authURL, state, err := provider.AuthCodeURL(context.Background(), "https://turnsapi.example.com/auth/oidc/callback")
+	if err != nil {
+		t.Fatalf("Failed to build auth URL: %v", err)
+	}
+	parsed, _ := url.Parse(authURL)
+	query := parsed.Query()
+	if !strings.HasPrefix(authURL, idp.server.URL+"/authorize?") || query.Get("state") != state ||
+		query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "turnsapi" {
+		t.Fatalf("Unexpected auth URL: %s", authURL)
+	}
+	idp.nonce = query.Get("nonce")
+	return provider.Exchange(context.Background(), state, code)
+}
+
+// TestOIDCLogin 测试授权码流程、ID令牌校验和组到角色的映射
+func TestOIDCLogin(t *testing.T) {
+	idp := newFakeIdentityProvider(t)
+	provider := NewOIDCProvider(&internal.OIDCSettings{
+		Enabled:     true,
+		Issuer:      idp.server.URL,
+		ClientID:    "turnsapi",
+		GroupsClaim: "realm_access.roles",
+		RoleMapping: map[string]string{"ops": RoleOperator, "platform-admins": RoleAdmin},
+	})
+
+	// 属于多个组时取最高角色
+	idp.claims = map[string]interface{}{"realm_access": map[string]interface{}{"roles": []string{"ops", "platform-admins"}}}
+	identity, err := idp.login(t, provider, "valid-code")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if identity.Username != "alice@example.com" || identity.Role != RoleAdmin {
+		t.Errorf("Expected alice@example.com as admin, got %+v", identity)
+	}
+
+	// 没有匹配组且未配置默认角色时拒绝登录
+	idp.claims = map[string]interface{}{"realm_access": map[string]interface{}{"roles": []string{"finance"}}}
+	if _, err := idp.login(t, provider, "valid-code"); err == nil {
+		t.Error("Expected user without a mapped group to be rejected")
+	}
+	provider.settings.DefaultRole = RoleViewer
+	if identity, err := idp.login(t, provider, "valid-code"); err != nil || identity.Role != RoleViewer {
+		t.Errorf("Expected default viewer role, got %+v (err: %v)", identity, err)
+	}
+
+	for name, claims := range map[string]map[string]interface{}{
+		"wrong audience": {"aud": "another-client"},
+		"wrong issuer":   {"iss": "https://evil.example.com"},
+		"expired":        {"exp": time.Now().Add(-time.Hour).Unix()},
+		"wrong nonce":    {"nonce": "replayed"},
+	} {
+		idp.claims = claims
+		if _, err := idp.login(t, provider, "valid-code"); err == nil {
+			t.Errorf("Expected token with %s to be rejected", name)
+		}
+	}
+
+	// 未验证的邮箱不作为用户名，改用其他声明；只能使用邮箱时拒绝登录
+	idp.claims = map[string]interface{}{"email_verified": false, "preferred_username": "alice"}
+	if identity, err := idp.login(t, provider, "valid-code"); err != nil || identity.Username != "alice" || identity.Subject != "user-1" {
+		t.Errorf("Expected preferred_username when email is unverified, got %+v (err: %v)", identity, err)
+	}
+	provider.settings.UsernameClaim = "email"
+	idp.claims = map[string]interface{}{"email_verified": "false"}
+	if _, err := idp.login(t, provider, "valid-code"); err == nil {
+		t.Error("Expected unverified email to be rejected as username")
+	}
+	idp.claims = map[string]interface{}{"email_verified": "true"}
+	if identity, err := idp.login(t, provider, "valid-code"); err != nil || identity.Username != "alice@example.com" {
+		t.Errorf("Expected verified email as username, got %+v (err: %v)", identity, err)
+	}
+	provider.settings.UsernameClaim = ""
+
+	idp.claims = map[string]interface{}{"sub": ""}
+	if _, err := idp.login(t, provider, "valid-code"); err == nil {
+		t.Error("Expected token without sub to be rejected")
+	}
+
+	idp.claims = nil
+	if _, err := idp.login(t, provider, "bad-code"); err == nil {
+		t.Error("Expected rejected authorization code to fail")
+	}
+	// state只能使用一次
+	if _, err := provider.Exchange(context.Background(), "unknown-state", "valid-code"); err == nil {
+		t.Error("Expected unknown state to be rejected")
+	}
+}
@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"turnsapi/internal/logger"
+)
+
+// sessionSyncInterval 内存中的会话与会话存储同步的最长间隔：期间最多写一次最近活动时间，
+// 其他实例撤销的会话最迟在这个间隔后失效
+const sessionSyncInterval = time.Minute
+
+// 会话存储中不存在的令牌在一段时间内不再查询存储，避免携带过期Cookie或伪造令牌的请求每次都查询数据库
+const (
+	missingSessionTTL  = time.Minute
+	maxMissingSessions = 10000
+)
+
+// SessionStore 登录会话存储接口，重启后会话不丢失，多个实例共享数据库时会话在实例间通用
+type SessionStore interface {
+	InsertAdminSession(session *logger.AdminSession) error
+	GetAdminSession(id string) (*logger.AdminSession, error)
+	GetAdminSessions() ([]*logger.AdminSession, error)
+	TouchAdminSession(session *logger.AdminSession) (bool, error)
+	DeleteAdminSession(id string) error
+	DeleteAdminSessionsByUserID(userID, exceptID string) (int64, error)
+	DeleteExpiredAdminSessions() (int64, error)
+}
+
+// sessionClient 创建会话的客户端信息
+type sessionClient struct {
+	ip        string
+	userAgent string
+}
+
+// hashSessionToken 会话ID为令牌的SHA-256哈希，存储和列表中不出现令牌本身
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetSessionStore 设置会话存储并加载未过期的会话
+func (am *AuthManager) SetSessionStore(store SessionStore) error {
+	if _, err := store.DeleteExpiredAdminSessions(); err != nil {
+		log.Printf("清理过期会话失败: %v", err)
+	}
+	stored, err := store.GetAdminSessions()
+	if err != nil {
+		return fmt.Errorf("failed to load admin sessions: %w", err)
+	}
+
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	am.sessionStore = store
+	now := time.Now()
+	for _, record := range stored {
+		session := sessionFromRecord(record)
+		session.syncedAt = now
+		am.sessions[session.ID] = session
+	}
+	if len(stored) > 0 {
+		log.Printf("已恢复 %d 个登录会话", len(stored))
+	}
+	return nil
+}
+
+// getSessionStore 获取会话存储，未设置时返回nil
+func (am *AuthManager) getSessionStore() SessionStore {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+	return am.sessionStore
+}
+
+// sessionExpiry 按空闲超时和最长有效期计算会话的过期时间
+func (am *AuthManager) sessionExpiry(session *Session, lastSeen time.Time) time.Time {
+	settings := am.currentConfig().Auth
+	expiresAt := lastSeen.Add(settings.SessionTimeout)
+	if maxLifetime := settings.SessionMaxLifetime; maxLifetime > 0 {
+		if deadline := session.CreatedAt.Add(maxLifetime); deadline.Before(expiresAt) {
+			expiresAt = deadline
+		}
+	}
+	return expiresAt
+}
+
+// createSession 为已验证的用户创建会话并写入会话存储
+func (am *AuthManager) createSession(username, userID, role, method string, twoFactor bool, client sessionClient) *Session {
+	token := am.generateToken()
+	now := time.Now()
+	session := &Session{
+		ID:         hashSessionToken(token),
+		Token:      token,
+		Username:   username,
+		UserID:     userID,
+		Role:       role,
+		Method:     method,
+		TwoFactor:  twoFactor,
+		ClientIP:   client.ip,
+		UserAgent:  client.userAgent,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		syncedAt:   now,
+	}
+	session.ExpiresAt = am.sessionExpiry(session, now)
+
+	if store := am.getSessionStore(); store != nil {
+		if err := store.InsertAdminSession(session.record()); err != nil {
+			log.Printf("保存登录会话失败，会话仅在本实例内有效: %v", err)
+		}
+	}
+
+	am.mutex.Lock()
+	am.sessions[session.ID] = session
+	am.mutex.Unlock()
+
+	return session
+}
+
+// lookupSession 按令牌查找会话：内存中没有时从会话存储加载（其他实例创建的会话），
+// 超过同步间隔时写回最近活动时间并确认会话未被撤销
+func (am *AuthManager) lookupSession(token string) (*Session, bool) {
+	id := hashSessionToken(token)
+	now := time.Now()
+
+	am.mutex.RLock()
+	session, exists := am.sessions[id]
+	store := am.sessionStore
+	var snapshot *logger.AdminSession
+	if exists && store != nil && now.Sub(session.syncedAt) >= sessionSyncInterval {
+		snapshot = session.record()
+	}
+	am.mutex.RUnlock()
+
+	if !exists {
+		if store == nil || am.sessionKnownMissing(id, now) {
+			return nil, false
+		}
+		record, err := store.GetAdminSession(id)
+		if err != nil {
+			// 存储暂时不可用时不缓存，避免其他实例的有效会话被误判
+			if errors.Is(err, logger.ErrAdminSessionNotFound) {
+				am.rememberMissingSession(id, now)
+			}
+			return nil, false
+		}
+		session = sessionFromRecord(record)
+		session.syncedAt = now
+		am.mutex.Lock()
+		am.sessions[id] = session
+		am.mutex.Unlock()
+		return session, true
+	}
+
+	if snapshot != nil {
+		found, err := store.TouchAdminSession(snapshot)
+		if err != nil {
+			// 存储暂时不可用时继续使用内存中的会话
+			log.Printf("同步登录会话失败: %v", err)
+		} else if !found {
+			am.mutex.Lock()
+			delete(am.sessions, id)
+			am.mutex.Unlock()
+			return nil, false
+		}
+		am.mutex.Lock()
+		session.syncedAt = now
+		am.mutex.Unlock()
+	}
+	return session, true
+}
+
+// sessionKnownMissing 令牌最近是否已确认在会话存储中不存在
+func (am *AuthManager) sessionKnownMissing(id string, now time.Time) bool {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+	expiresAt, exists := am.missingSessions[id]
+	return exists && now.Before(expiresAt)
+}
+
+// rememberMissingSession 记录会话存储中不存在的令牌，记录已满时先清理过期的记录，仍然已满时不再记录
+func (am *AuthManager) rememberMissingSession(id string, now time.Time) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	if len(am.missingSessions) >= maxMissingSessions {
+		for missingID, expiresAt := range am.missingSessions {
+			if !now.Before(expiresAt) {
+				delete(am.missingSessions, missingID)
+			}
+		}
+		if len(am.missingSessions) >= maxMissingSessions {
+			return
+		}
+	}
+	am.missingSessions[id] = now.Add(missingSessionTTL)
+}
+
+// removeSession 删除会话
+func (am *AuthManager) removeSession(id string) {
+	am.mutex.Lock()
+	delete(am.sessions, id)
+	store := am.sessionStore
+	am.mutex.Unlock()
+
+	if store != nil {
+		if err := store.DeleteAdminSession(id); err != nil {
+			log.Printf("删除登录会话失败: %v", err)
+		}
+	}
+}
+
+// removeUserSessions 按用户ID删除用户除 exceptID 外的所有会话，返回删除数量
+// 按用户ID而不是用户名匹配，同名的单点登录用户或删除后重建的同名用户不受影响
+func (am *AuthManager) removeUserSessions(userID, exceptID string) int {
+	if userID == "" {
+		return 0
+	}
+
+	am.mutex.Lock()
+	revoked := 0
+	for id, session := range am.sessions {
+		if session.UserID == userID && id != exceptID {
+			delete(am.sessions, id)
+			revoked++
+		}
+	}
+	store := am.sessionStore
+	am.mutex.Unlock()
+
+	if store != nil {
+		deleted, err := store.DeleteAdminSessionsByUserID(userID, exceptID)
+		if err != nil {
+			log.Printf("删除登录会话失败: %v", err)
+		} else if int(deleted) > revoked {
+			// 包括其他实例上的会话
+			revoked = int(deleted)
+		}
+	}
+	return revoked
+}
+
+// ListSessions 列出未过期的登录会话，按最近活动时间倒序；设置了会话存储时包括其他实例的会话
+func (am *AuthManager) ListSessions() ([]*Session, error) {
+	if store := am.getSessionStore(); store != nil {
+		records, err := store.GetAdminSessions()
+		if err != nil {
+			return nil, err
+		}
+		sessions := make([]*Session, 0, len(records))
+		am.mutex.RLock()
+		for _, record := range records {
+			// 本实例的最近活动时间可能尚未写回存储
+			if cached, ok := am.sessions[record.ID]; ok && cached.LastSeenAt.After(record.LastSeenAt) {
+				record.LastSeenAt = cached.LastSeenAt
+				record.ExpiresAt = cached.ExpiresAt
+			}
+			sessions = append(sessions, sessionFromRecord(record))
+		}
+		am.mutex.RUnlock()
+		return sessions, nil
+	}
+
+	am.mutex.RLock()
+	now := time.Now()
+	sessions := make([]*Session, 0, len(am.sessions))
+	for _, session := range am.sessions {
+		if now.Before(session.ExpiresAt) {
+			copied := *session
+			copied.Token = ""
+			sessions = append(sessions, &copied)
+		}
+	}
+	am.mutex.RUnlock()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions, nil
+}
+
+// RevokeSession 按ID撤销登录会话
+func (am *AuthManager) RevokeSession(id string) bool {
+	am.mutex.RLock()
+	_, exists := am.sessions[id]
+	store := am.sessionStore
+	am.mutex.RUnlock()
+
+	if !exists && store != nil {
+		_, err := store.GetAdminSession(id)
+		exists = err == nil
+	}
+	if exists {
+		am.removeSession(id)
+	}
+	return exists
+}
+
+// record 转换为会话存储的记录
+func (s *Session) record() *logger.AdminSession {
+	return &logger.AdminSession{
+		ID:         s.ID,
+		Username:   s.Username,
+		UserID:     s.UserID,
+		Role:       s.Role,
+		AuthMethod: s.Method,
+		TwoFactor:  s.TwoFactor,
+		ClientIP:   s.ClientIP,
+		UserAgent:  s.UserAgent,
+		CreatedAt:  s.CreatedAt,
+		LastSeenAt: s.LastSeenAt,
+		ExpiresAt:  s.ExpiresAt,
+	}
+}
+
+// sessionFromRecord 从会话存储的记录还原会话，令牌本身不保存，Token为空
+func sessionFromRecord(record *logger.AdminSession) *Session {
+	return &Session{
+		ID:         record.ID,
+		Username:   record.Username,
+		UserID:     record.UserID,
+		Role:       record.Role,
+		Method:     record.AuthMethod,
+		TwoFactor:  record.TwoFactor,
+		ClientIP:   record.ClientIP,
+		UserAgent:  record.UserAgent,
+		CreatedAt:  record.CreatedAt,
+		LastSeenAt: record.LastSeenAt,
+		ExpiresAt:  record.ExpiresAt,
+	}
+}
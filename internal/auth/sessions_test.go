@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestSessionPersistence 测试会话在重启（新的认证管理器）后保留、跨实例撤销和最长有效期
+func TestSessionPersistence(t *testing.T) {
+	requestLogger, err := logger.NewRequestLogger(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create request logger: %v", err)
+	}
+	defer requestLogger.Close()
+
+	config := &internal.Config{}
+	config.Auth.Enabled = true
+	config.Auth.Username = "admin"
+	config.Auth.Password = "bootstrap-password"
+	config.Auth.SessionTimeout = time.Hour
+	config.Auth.SessionMaxLifetime = 2 * time.Hour
+
+	first := NewAuthManager(config)
+	if err := first.SetUserStore(requestLogger); err != nil {
+		t.Fatalf("Failed to set user store: %v", err)
+	}
+	if err := first.SetSessionStore(requestLogger); err != nil {
+		t.Fatalf("Failed to set session store: %v", err)
+	}
+	session, err := first.login("admin", "bootstrap-password", "", sessionClient{ip: "10.0.0.1", userAgent: "curl/8"})
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	// 模拟重启：新的认证管理器从存储加载会话
+	second := NewAuthManager(config)
+	if err := second.SetSessionStore(requestLogger); err != nil {
+		t.Fatalf("Failed to set session store: %v", err)
+	}
+	restored, ok := second.ValidateToken(session.Token)
+	if !ok || restored.Username != "admin" || restored.Role != RoleAdmin || restored.ClientIP != "10.0.0.1" {
+		t.Fatalf("Expected session to survive restart, got %+v", restored)
+	}
+	if restored.Token != "" {
+		t.Error("Restored sessions must not carry the raw token")
+	}
+
+	sessions, err := second.ListSessions()
+	if err != nil || len(sessions) != 1 || sessions[0].ID != session.ID {
+		t.Fatalf("Expected one listed session, got %v (err: %v)", sessions, err)
+	}
+
+	// 在另一个实例撤销后，本实例在下次同步时发现会话已失效
+	if !second.RevokeSession(session.ID) {
+		t.Fatal("Expected session to be revoked")
+	}
+	first.mutex.Lock()
+	first.sessions[session.ID].syncedAt = time.Now().Add(-sessionSyncInterval)
+	first.mutex.Unlock()
+	if _, ok := first.ValidateToken(session.Token); ok {
+		t.Error("Expected session revoked on another instance to be rejected")
+	}
+
+	// 空闲超时顺延不超过最长有效期
+	capped := &Session{CreatedAt: time.Now().Add(-90 * time.Minute)}
+	if expiry := first.sessionExpiry(capped, time.Now()); !expiry.Equal(capped.CreatedAt.Add(2 * time.Hour)) {
+		t.Errorf("Expected expiry capped at max lifetime, got %s", expiry)
+	}
+}
+
+// countingSessionStore 统计按ID查询会话的次数
+type countingSessionStore struct {
+	SessionStore
+	lookups int
+}
+
+func (s *countingSessionStore) GetAdminSession(id string) (*logger.AdminSession, error) {
+	s.lookups++
+	return s.SessionStore.GetAdminSession(id)
+}
+
+// TestUnknownSessionNegativeCache 测试存储中不存在的令牌在缓存期内不再查询存储，其他实例创建的会话仍可加载
+func TestUnknownSessionNegativeCache(t *testing.T) {
+	requestLogger, err := logger.NewRequestLogger(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create request logger: %v", err)
+	}
+	defer requestLogger.Close()
+
+	config := &internal.Config{}
+	config.Auth.Enabled = true
+	config.Auth.Username = "admin"
+	config.Auth.Password = "bootstrap-password"
+	config.Auth.SessionTimeout = time.Hour
+
+	store := &countingSessionStore{SessionStore: requestLogger}
+	am := NewAuthManager(config)
+	if err := am.SetSessionStore(store); err != nil {
+		t.Fatalf("Failed to set session store: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, ok := am.ValidateToken("unknown-token"); ok {
+			t.Fatal("Expected unknown token to be rejected")
+		}
+	}
+	if store.lookups != 1 {
+		t.Errorf("Expected one store lookup for a repeated unknown token, got %d", store.lookups)
+	}
+
+	// 缓存过期后重新查询
+	id := hashSessionToken("unknown-token")
+	am.mutex.Lock()
+	am.missingSessions[id] = time.Now().Add(-time.Second)
+	am.mutex.Unlock()
+	am.ValidateToken("unknown-token")
+	if store.lookups != 2 {
+		t.Errorf("Expected expired negative entry to query the store again, got %d lookups", store.lookups)
+	}
+
+	// 其他实例创建的会话不受影响
+	other := NewAuthManager(config)
+	if err := other.SetSessionStore(requestLogger); err != nil {
+		t.Fatalf("Failed to set session store: %v", err)
+	}
+	session := other.createSession("admin", "user-1", RoleAdmin, "password", false, sessionClient{})
+	if _, ok := am.ValidateToken(session.Token); !ok {
+		t.Error("Expected session created on another instance to be loaded")
+	}
+}
+
+// TestUpdateConfig 测试重新加载配置后新的会话超时和管理IP限制立即生效
+func TestUpdateConfig(t *testing.T) {
+	config := &internal.Config{}
+	config.Auth.Enabled = true
+	config.Auth.SessionTimeout = time.Hour
+	am := NewAuthManager(config)
+
+	now := time.Now()
+	session := &Session{CreatedAt: now}
+	if expiry := am.sessionExpiry(session, now); !expiry.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected one hour expiry, got %s", expiry.Sub(now))
+	}
+
+	updated := *config
+	updated.Auth.SessionTimeout = 2 * time.Hour
+	updated.Auth.AdminAllowedIPs = []string{"10.0.0.0/8"}
+	am.UpdateConfig(&updated)
+	if expiry := am.sessionExpiry(session, now); !expiry.Equal(now.Add(2 * time.Hour)) {
+		t.Errorf("Expected reloaded two hour expiry, got %s", expiry.Sub(now))
+	}
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/admin/status", nil)
+	c.Request.RemoteAddr = "192.168.1.10:1234"
+	am.AdminIPFilterMiddleware()(c)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected reloaded admin_allowed_ips to reject the request, got status %d", w.Code)
+	}
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"turnsapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProxyKeyResolver 支持按ID或名称查找代理密钥的验证器，可信头部认证需要
+type ProxyKeyResolver interface {
+	ResolveKey(idOrName string) (interface{}, bool)
+}
+
+// TryTrustedHeaderAuth 尝试使用前置网关注入的身份头部完成认证
+// 返回true表示认证成功且已写入密钥上下文；返回false且请求已中止表示认证被拒绝；
+// 返回false且请求未中止表示未启用或请求未携带身份头部，调用方应继续走令牌认证
+func (am *AuthManager) TryTrustedHeaderAuth(c *gin.Context) bool {
+	settings := am.currentConfig().Auth.TrustedHeader
+	if settings == nil || !settings.Enabled {
+		return false
+	}
+
+	// 显式携带令牌的请求优先使用令牌认证
+	if c.GetHeader("Authorization") != "" || c.GetHeader("x-goog-api-key") != "" {
+		return false
+	}
+
+	identity := strings.TrimSpace(c.GetHeader(settings.Header))
+	if identity == "" {
+		return false
+	}
+
+	// 使用TCP对端地址而非X-Forwarded-For判断来源，后者可由客户端伪造
+	if !isTrustedSource(c.Request.RemoteAddr, settings.TrustedSources) {
+		log.Printf("拒绝来自非可信来源 %s 的身份头部 %s", c.Request.RemoteAddr, settings.Header)
+		abortTrustedHeaderAuth(c, http.StatusUnauthorized, "Identity header is not accepted from this source", "untrusted_identity_source")
+		return false
+	}
+
+	keyRef, ok := settings.Identities[identity]
+	if !ok {
+		keyRef = settings.DefaultProxyKey
+	}
+	if keyRef == "" {
+		log.Printf("身份 %s 未映射到任何代理密钥", identity)
+		abortTrustedHeaderAuth(c, http.StatusForbidden, "Identity is not mapped to a proxy key", "unknown_identity")
+		return false
+	}
+
+	resolver, ok := am.proxyKeyManager.(ProxyKeyResolver)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"message": "Proxy key manager does not support trusted header authentication",
+				"type":    "internal_error",
+				"code":    "proxy_key_manager_missing",
+			},
+		})
+		c.Abort()
+		return false
+	}
+
+	keyInfo, valid := resolver.ResolveKey(keyRef)
+	if !valid {
+		log.Printf("身份 %s 映射的代理密钥 %s 不存在或已禁用", identity, keyRef)
+		abortTrustedHeaderAuth(c, http.StatusForbidden, "Proxy key mapped to this identity is not available", "identity_key_inactive")
+		return false
+	}
+	if !am.CheckProxyKeySource(c, keyInfo) {
+		return false
+	}
+
+	apiKey := ""
+	if proxyKey, ok := keyInfo.(*logger.ProxyKey); ok {
+		apiKey = proxyKey.Key
+	}
+	am.proxyKeyManager.UpdateUsage(apiKey)
+	am.setKeyContext(c, apiKey, keyInfo)
+	c.Set("auth_identity", identity)
+	return true
+}
+
+// abortTrustedHeaderAuth 返回可信头部认证失败响应
+func abortTrustedHeaderAuth(c *gin.Context, status int, message, code string) {
+	c.JSON(status, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    "authentication_error",
+			"code":    code,
+		},
+	})
+	c.Abort()
+}
+
+// IsTrustedProxy 判断请求对端地址是否在可信反向代理列表（IP或CIDR）中，只有可信代理转发的 X-Forwarded-* 请求头可以使用
+func IsTrustedProxy(remoteAddr string, trustedProxies []string) bool {
+	return isTrustedSource(remoteAddr, trustedProxies)
+}
+
+// isTrustedSource 判断请求对端地址是否在可信来源列表（IP或CIDR）中
+func isTrustedSource(remoteAddr string, trustedSources []string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	return networkContains(trustedSources, ip)
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"turnsapi/internal"
+	"turnsapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeKeyResolver 按ID或名称解析代理密钥的内存实现
+type fakeKeyResolver struct {
+	keys  map[string]*logger.ProxyKey
+	usage []string
+}
+
+func (f *fakeKeyResolver) ValidateKey(key string) (interface{}, bool) {
+	for _, proxyKey := range f.keys {
+		if proxyKey.Key == key {
+			return proxyKey, true
+		}
+	}
+	return nil, false
+}
+
+func (f *fakeKeyResolver) UpdateUsage(key string) {
+	f.usage = append(f.usage, key)
+}
+
+func (f *fakeKeyResolver) ResolveKey(idOrName string) (interface{}, bool) {
+	proxyKey, ok := f.keys[idOrName]
+	if !ok || !proxyKey.IsActive {
+		return nil, false
+	}
+	return proxyKey, true
+}
+
+// TestIsTrustedSource 测试按IP和CIDR判断可信来源，无法解析的地址不可信
+func TestIsTrustedSource(t *testing.T) {
+	sources := []string{"10.0.0.0/8", "192.168.1.10", "fd00::/8"}
+	tests := []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{"10.1.2.3:4567", true},
+		{"192.168.1.10:80", true},
+		{"192.168.1.11:80", false},
+		{"[fd00::1]:443", true},
+		{"[2001:db8::1]:443", false},
+		{"10.1.2.3", true},
+		{"gateway.internal:80", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isTrustedSource(tt.remoteAddr, sources); got != tt.want {
+			t.Errorf("isTrustedSource(%q) = %t, want %t", tt.remoteAddr, got, tt.want)
+		}
+	}
+	if isTrustedSource("10.1.2.3:4567", nil) {
+		t.Error("Expected no source to be trusted without a trusted source list")
+	}
+}
+
+// TestTryTrustedHeaderAuth 测试身份映射、默认密钥、令牌优先和非可信来源的拒绝
+func TestTryTrustedHeaderAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	resolver := &fakeKeyResolver{keys: map[string]*logger.ProxyKey{
+		"team-a":   {ID: "key-a", Name: "team-a", Key: "tapi-a", IsActive: true},
+		"fallback": {ID: "key-f", Name: "fallback", Key: "tapi-f", IsActive: true},
+		"disabled": {ID: "key-d", Name: "disabled", Key: "tapi-d", IsActive: false},
+	}}
+	config := &internal.Config{}
+	config.Auth.TrustedHeader = &internal.TrustedHeaderAuth{
+		Enabled:        true,
+		Header:         "X-Forwarded-User",
+		TrustedSources: []string{"10.0.0.0/8"},
+		Identities: map[string]string{
+			"alice": "team-a",
+			"carol": "disabled",
+		},
+	}
+	am := NewAuthManager(config)
+	am.SetProxyKeyManager(resolver)
+
+	run := func(remoteAddr string, headers map[string]string) (*gin.Context, *httptest.ResponseRecorder, bool) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		c.Request.RemoteAddr = remoteAddr
+		for key, value := range headers {
+			c.Request.Header.Set(key, value)
+		}
+		return c, w, am.TryTrustedHeaderAuth(c)
+	}
+
+	// 映射的身份使用对应的代理密钥
+	c, _, ok := run("10.0.0.5:1234", map[string]string{"X-Forwarded-User": "alice"})
+	if !ok || c.IsAborted() {
+		t.Fatal("Expected mapped identity from a trusted source to authenticate")
+	}
+	if c.GetString("proxy_key_id") != "key-a" || c.GetString("auth_identity") != "alice" {
+		t.Errorf("Unexpected key context: proxy_key_id=%q auth_identity=%q", c.GetString("proxy_key_id"), c.GetString("auth_identity"))
+	}
+	if len(resolver.usage) != 1 || resolver.usage[0] != "tapi-a" {
+		t.Errorf("Expected usage to be recorded for the mapped key, got %v", resolver.usage)
+	}
+
+	// 携带令牌或没有身份头部时交给令牌认证
+	for _, headers := range []map[string]string{
+		{"X-Forwarded-User": "alice", "Authorization": "Bearer tapi-a"},
+		{},
+	} {
+		if c, _, ok := run("10.0.0.5:1234", headers); ok || c.IsAborted() {
+			t.Errorf("Expected fallthrough to token auth for headers %v", headers)
+		}
+	}
+
+	// 非可信来源注入的身份头部被拒绝
+	c, w, ok := run("203.0.113.7:1234", map[string]string{"X-Forwarded-User": "alice"})
+	if ok || !c.IsAborted() || w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected identity header from an untrusted source to be rejected, got ok=%t status=%d", ok, w.Code)
+	}
+
+	// 未映射的身份在没有默认密钥时被拒绝，映射到禁用密钥的身份也被拒绝
+	for _, identity := range []string{"bob", "carol"} {
+		c, w, ok := run("10.0.0.5:1234", map[string]string{"X-Forwarded-User": identity})
+		if ok || !c.IsAborted() || w.Code != http.StatusForbidden {
+			t.Errorf("Expected identity %s to be forbidden, got ok=%t status=%d", identity, ok, w.Code)
+		}
+	}
+
+	// 配置默认密钥后未映射的身份使用默认密钥
+	config.Auth.TrustedHeader.DefaultProxyKey = "fallback"
+	c, _, ok = run("10.0.0.5:1234", map[string]string{"X-Forwarded-User": "bob"})
+	if !ok || c.GetString("proxy_key_id") != "key-f" {
+		t.Errorf("Expected unmapped identity to use the default key, got ok=%t proxy_key_id=%q", ok, c.GetString("proxy_key_id"))
+	}
+
+	// 未启用时不处理身份头部
+	config.Auth.TrustedHeader.Enabled = false
+	if c, _, ok := run("10.0.0.5:1234", map[string]string{"X-Forwarded-User": "alice"}); ok || c.IsAborted() {
+		t.Error("Expected trusted header auth to be skipped when disabled")
+	}
+}
@@ -0,0 +1,359 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"turnsapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	twoFactorPagePath     = "/auth/2fa"
+	totpPeriod            = 30 // 秒
+	totpDigits            = 6
+	totpSkewSteps         = 1 // 允许前后各一个时间步的时钟误差
+	recoveryCodeCount     = 10
+	maxTwoFactorFailures  = 5               // 连续输错验证码的次数上限
+	twoFactorLockDuration = 5 * time.Minute // 超过上限后暂停验证的时长
+	defaultTOTPIssuer     = "TurnsAPI"
+)
+
+var (
+	errTwoFactorRequired    = errors.New("two-factor code required")
+	errInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	errTwoFactorLocked      = errors.New("too many invalid two-factor codes, try again later")
+)
+
+// twoFactorFailures 用户连续输错验证码的次数
+type twoFactorFailures struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// TwoFactorRequired 配置是否要求所有密码登录的用户启用两步验证
+func (am *AuthManager) TwoFactorRequired() bool {
+	settings := am.currentConfig().Auth.TwoFactor
+	return settings != nil && settings.Required
+}
+
+// twoFactorPending 会话是否需要先启用两步验证；单点登录的多因素认证由身份提供方负责
+func (am *AuthManager) twoFactorPending(session *Session) bool {
+	if session == nil || !am.TwoFactorRequired() || session.Method != "password" {
+		return false
+	}
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+	return !session.TwoFactor
+}
+
+// BeginTwoFactorSetup 为用户生成新的TOTP密钥，验证通过前不生效，返回密钥和 otpauth:// 地址
+func (am *AuthManager) BeginTwoFactorSetup(username string) (string, string, error) {
+	store, user, err := am.getUserByName(username)
+	if err != nil {
+		return "", "", err
+	}
+	if user.TOTPEnabled {
+		return "", "", fmt.Errorf("two-factor authentication is already enabled")
+	}
+
+	secretBytes := make([]byte, 20)
+	rand.Read(secretBytes)
+	user.TOTPSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secretBytes)
+	user.UpdatedAt = time.Now()
+	if err := store.UpdateAdminUserTOTP(user); err != nil {
+		return "", "", err
+	}
+
+	issuer := defaultTOTPIssuer
+	if settings := am.currentConfig().Auth.TwoFactor; settings != nil && settings.Issuer != "" {
+		issuer = settings.Issuer
+	}
+	query := url.Values{
+		"secret":    {user.TOTPSecret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	uri := "otpauth://totp/" + url.PathEscape(issuer+":"+user.Username) + "?" + query.Encode()
+	return user.TOTPSecret, uri, nil
+}
+
+// EnableTwoFactor 用身份验证器生成的验证码确认密钥并启用两步验证，返回只显示一次的恢复码
+func (am *AuthManager) EnableTwoFactor(username, code, currentSessionID string) ([]string, error) {
+	store, user, err := am.getUserByName(username)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, fmt.Errorf("two-factor authentication is already enabled")
+	}
+	if user.TOTPSecret == "" {
+		return nil, fmt.Errorf("two-factor setup has not been started")
+	}
+	if !am.checkTOTP(user.ID, user.TOTPSecret, code) {
+		return nil, errInvalidTwoFactorCode
+	}
+
+	codes, hashes := generateRecoveryCodes()
+	user.TOTPEnabled = true
+	user.RecoveryCodes = hashes
+	user.UpdatedAt = time.Now()
+	if err := store.UpdateAdminUserTOTP(user); err != nil {
+		return nil, err
+	}
+	am.markSessionTwoFactor(currentSessionID, true)
+	return codes, nil
+}
+
+// DisableTwoFactor 用户关闭自己的两步验证，需要提供密码和验证码（或恢复码）
+func (am *AuthManager) DisableTwoFactor(username, password, code, currentSessionID string) error {
+	store, user, err := am.getUserByName(username)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return fmt.Errorf("two-factor authentication is not enabled")
+	}
+	if am.TwoFactorRequired() {
+		return fmt.Errorf("two-factor authentication is required by the server configuration")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return fmt.Errorf("password is incorrect")
+	}
+	if err := am.verifySecondFactor(user, code); err != nil {
+		return err
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.RecoveryCodes = nil
+	user.UpdatedAt = time.Now()
+	if err := store.UpdateAdminUserTOTP(user); err != nil {
+		return err
+	}
+	am.markSessionTwoFactor(currentSessionID, false)
+	return nil
+}
+
+// RegenerateRecoveryCodes 生成新的恢复码，原有恢复码全部失效
+func (am *AuthManager) RegenerateRecoveryCodes(username, code string) ([]string, error) {
+	store, user, err := am.getUserByName(username)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, fmt.Errorf("two-factor authentication is not enabled")
+	}
+	if err := am.verifySecondFactor(user, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes := generateRecoveryCodes()
+	user.RecoveryCodes = hashes
+	user.UpdatedAt = time.Now()
+	if err := store.UpdateAdminUserTOTP(user); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// ResetTwoFactor 管理员为丢失身份验证器的用户关闭两步验证，并使其会话失效
+func (am *AuthManager) ResetTwoFactor(id string) (*logger.AdminUser, error) {
+	store, err := am.getUserStore()
+	if err != nil {
+		return nil, err
+	}
+	user, err := store.GetAdminUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.RecoveryCodes = nil
+	user.UpdatedAt = time.Now()
+	if err := store.UpdateAdminUserTOTP(user); err != nil {
+		return nil, err
+	}
+	am.RevokeUserSessions(user.ID)
+	return user, nil
+}
+
+// TwoFactorStatus 用户是否启用两步验证及剩余恢复码数量
+func (am *AuthManager) TwoFactorStatus(username string) (bool, int, error) {
+	_, user, err := am.getUserByName(username)
+	if err != nil {
+		return false, 0, err
+	}
+	return user.TOTPEnabled, len(user.RecoveryCodes), nil
+}
+
+// verifySecondFactor 校验TOTP验证码或恢复码，恢复码使用后作废；连续输错后暂时锁定
+func (am *AuthManager) verifySecondFactor(user *logger.AdminUser, code string) error {
+	am.mutex.Lock()
+	failures := am.totpFailures[user.ID]
+	if failures != nil && time.Now().Before(failures.lockedUntil) {
+		am.mutex.Unlock()
+		return errTwoFactorLocked
+	}
+	am.mutex.Unlock()
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	ok := false
+	if len(code) == totpDigits {
+		ok = am.checkTOTP(user.ID, user.TOTPSecret, code)
+	} else if ok = am.consumeRecoveryCode(user, code); ok {
+		store, err := am.getUserStore()
+		if err != nil {
+			return err
+		}
+		user.UpdatedAt = time.Now()
+		if err := store.UpdateAdminUserTOTP(user); err != nil {
+			return err
+		}
+	}
+
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	if ok {
+		delete(am.totpFailures, user.ID)
+		return nil
+	}
+	failures = am.totpFailures[user.ID]
+	if failures == nil {
+		failures = &twoFactorFailures{}
+		am.totpFailures[user.ID] = failures
+	}
+	failures.count++
+	if failures.count >= maxTwoFactorFailures {
+		failures.count = 0
+		failures.lockedUntil = time.Now().Add(twoFactorLockDuration)
+	}
+	return errInvalidTwoFactorCode
+}
+
+// checkTOTP 校验TOTP验证码，同一时间步的验证码只能使用一次
+func (am *AuthManager) checkTOTP(userID, secret, code string) bool {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return false
+	}
+
+	current := time.Now().Unix() / totpPeriod
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	for step := current - totpSkewSteps; step <= current+totpSkewSteps; step++ {
+		if step <= am.totpLastStep[userID] {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			am.totpLastStep[userID] = step
+			return true
+		}
+	}
+	return false
+}
+
+// consumeRecoveryCode 匹配并移除恢复码
+func (am *AuthManager) consumeRecoveryCode(user *logger.AdminUser, code string) bool {
+	hash := hashRecoveryCode(code)
+	for i, stored := range user.RecoveryCodes {
+		if hmac.Equal([]byte(stored), []byte(hash)) {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// markSessionTwoFactor 更新会话的两步验证状态
+func (am *AuthManager) markSessionTwoFactor(id string, verified bool) {
+	am.mutex.Lock()
+	session, ok := am.sessions[id]
+	if !ok {
+		am.mutex.Unlock()
+		return
+	}
+	session.TwoFactor = verified
+	record := session.record()
+	store := am.sessionStore
+	am.mutex.Unlock()
+
+	if store != nil {
+		if _, err := store.TouchAdminSession(record); err != nil {
+			log.Printf("更新登录会话失败: %v", err)
+		}
+	}
+}
+
+// getUserByName 获取用户存储和用户
+func (am *AuthManager) getUserByName(username string) (UserStore, *logger.AdminUser, error) {
+	store, err := am.getUserStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := store.GetAdminUserByUsername(username)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, user, nil
+}
+
+// totpCode 按 RFC 6238 计算时间步对应的验证码（HMAC-SHA1）
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// generateRecoveryCodes 生成恢复码，返回明文和用于存储的哈希
+func generateRecoveryCodes() ([]string, []string) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 7)
+		rand.Read(raw)
+		encoded := strings.ToLower(encoding.EncodeToString(raw))[:10]
+		codes[i] = encoded[:5] + "-" + encoded[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes
+}
+
+// hashRecoveryCode 恢复码的SHA-256哈希，忽略大小写和连字符
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// HandleTwoFactorPage 两步验证设置页面
+func (am *AuthManager) HandleTwoFactorPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "two_factor.html", gin.H{
+		"title":    "两步验证 - TurnsAPI",
+		"required": am.TwoFactorRequired(),
+	})
+}
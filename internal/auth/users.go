@@ -0,0 +1,346 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"turnsapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 管理用户角色
+const (
+	RoleViewer   = "viewer"   // 只读访问管理接口和管理界面
+	RoleOperator = "operator" // 在只读基础上管理分组、模型、健康检查、提供商密钥和代理密钥
+	RoleAdmin    = "admin"    // 全部权限，包括用户、管理API令牌和系统设置
+)
+
+// minPasswordLength 管理用户密码的最短长度
+const minPasswordLength = 8
+
+// roleLevels 角色的权限等级，高等级包含低等级的全部权限
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// dummyPasswordHash 用户不存在时也执行一次bcrypt比较，避免通过响应时间判断用户名是否存在
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("turnsapi-dummy-password"), bcrypt.DefaultCost)
+
+// UserStore 管理用户存储接口
+type UserStore interface {
+	InsertAdminUser(user *logger.AdminUser) error
+	GetAdminUserByUsername(username string) (*logger.AdminUser, error)
+	GetAdminUser(id string) (*logger.AdminUser, error)
+	GetAllAdminUsers() ([]*logger.AdminUser, error)
+	UpdateAdminUser(user *logger.AdminUser) error
+	UpdateAdminUserLastLogin(id string) error
+	UpdateAdminUserTOTP(user *logger.AdminUser) error
+	DeleteAdminUser(id string) error
+}
+
+// AdminUserUpdate 管理用户的可修改字段，为空的字段保持不变
+type AdminUserUpdate struct {
+	Role     *string
+	Password *string
+	Disabled *bool
+}
+
+// ValidRole 判断角色是否合法
+func ValidRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+// RoleAllows 判断角色是否具备所需角色的权限
+func RoleAllows(role, required string) bool {
+	return roleLevels[role] >= roleLevels[required] && roleLevels[role] > 0
+}
+
+// IsAdmin 判断当前管理请求是否具备管理员权限：未启用认证、管理员会话或具备全部权限的管理API令牌
+func IsAdmin(c *gin.Context) bool {
+	return c.GetString("role") == RoleAdmin
+}
+
+// RequiredAdminRole 根据请求方法和路径确定登录会话所需的角色
+func RequiredAdminRole(method, path string) string {
+	trimmed := strings.TrimPrefix(path, "/admin")
+	switch {
+	case strings.HasPrefix(trimmed, "/account"):
+		return RoleViewer
+	case strings.HasPrefix(trimmed, "/users"), strings.HasPrefix(trimmed, "/api-tokens"), strings.HasPrefix(trimmed, "/sessions"),
+		strings.HasPrefix(trimmed, "/backup"):
+		return RoleAdmin
+	}
+
+	switch RequiredAdminScope(method, path) {
+	case AdminScopeRead:
+		return RoleViewer
+	case AdminScopeManageGroups, AdminScopeManageKeys:
+		return RoleOperator
+	}
+	return RoleAdmin
+}
+
+// SetUserStore 设置管理用户存储，用户表为空时以配置中的 auth.username/password 创建初始管理员
+func (am *AuthManager) SetUserStore(store UserStore) error {
+	am.mutex.Lock()
+	am.userStore = store
+	am.mutex.Unlock()
+
+	users, err := store.GetAllAdminUsers()
+	if err != nil {
+		return fmt.Errorf("failed to load admin users: %w", err)
+	}
+	settings := am.currentConfig().Auth
+	if len(users) > 0 || !settings.Enabled {
+		return nil
+	}
+
+	user, err := am.CreateUser(settings.Username, settings.Password, RoleAdmin)
+	if err != nil {
+		return fmt.Errorf("failed to create initial admin user: %w", err)
+	}
+	log.Printf("已根据配置创建初始管理员用户: %s，之后通过 /admin/users 管理用户，配置中的密码不再生效", user.Username)
+	return nil
+}
+
+// getUserStore 获取管理用户存储
+func (am *AuthManager) getUserStore() (UserStore, error) {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+	if am.userStore == nil {
+		return nil, fmt.Errorf("admin user store not configured")
+	}
+	return am.userStore, nil
+}
+
+// hashPassword 校验密码长度并计算bcrypt哈希
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// CreateUser 创建管理用户
+func (am *AuthManager) CreateUser(username, password, role string) (*logger.AdminUser, error) {
+	store, err := am.getUserStore()
+	if err != nil {
+		return nil, err
+	}
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+	if strings.HasPrefix(username, oidcUsernamePrefix) {
+		return nil, fmt.Errorf("username must not start with %q", oidcUsernamePrefix)
+	}
+	if !ValidRole(role) {
+		return nil, fmt.Errorf("invalid role: %q", role)
+	}
+	if existing, _ := store.GetAdminUserByUsername(username); existing != nil {
+		return nil, fmt.Errorf("username %q already exists", username)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user := &logger.AdminUser{
+		ID:           am.generateToken()[:16],
+		Username:     username,
+		PasswordHash: hash,
+		Role:         role,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := store.InsertAdminUser(user); err != nil {
+		return nil, fmt.Errorf("failed to save admin user: %w", err)
+	}
+	return user, nil
+}
+
+// UpdateUser 修改管理用户的角色、密码或禁用状态，修改后该用户的所有会话失效
+// 不允许移除最后一个启用的管理员
+func (am *AuthManager) UpdateUser(id string, update AdminUserUpdate) (*logger.AdminUser, error) {
+	store, err := am.getUserStore()
+	if err != nil {
+		return nil, err
+	}
+	user, err := store.GetAdminUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	wasActiveAdmin := user.Role == RoleAdmin && !user.Disabled
+	if update.Role != nil {
+		if !ValidRole(*update.Role) {
+			return nil, fmt.Errorf("invalid role: %q", *update.Role)
+		}
+		user.Role = *update.Role
+	}
+	if update.Disabled != nil {
+		user.Disabled = *update.Disabled
+	}
+	if update.Password != nil {
+		hash, err := hashPassword(*update.Password)
+		if err != nil {
+			return nil, err
+		}
+		user.PasswordHash = hash
+	}
+	if wasActiveAdmin && (user.Role != RoleAdmin || user.Disabled) {
+		if err := am.ensureAnotherAdmin(store, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	user.UpdatedAt = time.Now()
+	if err := store.UpdateAdminUser(user); err != nil {
+		return nil, err
+	}
+	am.RevokeUserSessions(user.ID)
+	return user, nil
+}
+
+// DeleteUser 删除管理用户并使其会话失效，不允许删除最后一个启用的管理员
+func (am *AuthManager) DeleteUser(id string) (*logger.AdminUser, error) {
+	store, err := am.getUserStore()
+	if err != nil {
+		return nil, err
+	}
+	user, err := store.GetAdminUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == RoleAdmin && !user.Disabled {
+		if err := am.ensureAnotherAdmin(store, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := store.DeleteAdminUser(id); err != nil {
+		return nil, err
+	}
+	am.RevokeUserSessions(user.ID)
+	return user, nil
+}
+
+// ChangePassword 用户修改自己的密码，需要提供当前密码；修改后其他会话失效，当前会话保留
+func (am *AuthManager) ChangePassword(username, currentPassword, newPassword, currentSessionID string) error {
+	store, err := am.getUserStore()
+	if err != nil {
+		return err
+	}
+	user, err := store.GetAdminUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(currentPassword)) != nil {
+		return fmt.Errorf("current password is incorrect")
+	}
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	user.PasswordHash = hash
+	user.UpdatedAt = time.Now()
+	if err := store.UpdateAdminUser(user); err != nil {
+		return err
+	}
+
+	am.removeUserSessions(user.ID, currentSessionID)
+	return nil
+}
+
+// ensureAnotherAdmin 检查除指定用户外是否还有启用的管理员
+func (am *AuthManager) ensureAnotherAdmin(store UserStore, excludeID string) error {
+	users, err := store.GetAllAdminUsers()
+	if err != nil {
+		return err
+	}
+	for _, other := range users {
+		if other.ID != excludeID && other.Role == RoleAdmin && !other.Disabled {
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot remove the last active admin user")
+}
+
+// authenticateUser 校验用户名和密码，返回管理用户
+// 未设置用户存储时使用配置中的单个账号，角色为管理员
+func (am *AuthManager) authenticateUser(username, password string) (*logger.AdminUser, bool) {
+	am.mutex.RLock()
+	store := am.userStore
+	am.mutex.RUnlock()
+
+	if store == nil {
+		if settings := am.currentConfig().Auth; username == settings.Username && password == settings.Password {
+			return &logger.AdminUser{Username: username, Role: RoleAdmin}, true
+		}
+		return nil, false
+	}
+
+	user, err := store.GetAdminUserByUsername(username)
+	if err != nil || user.Disabled {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, false
+	}
+	return user, true
+}
+
+// recordLogin 异步更新管理用户最后登录时间
+func (am *AuthManager) recordLogin(user *logger.AdminUser) {
+	store, err := am.getUserStore()
+	if err != nil || user.ID == "" {
+		return
+	}
+	go func() {
+		if err := store.UpdateAdminUserLastLogin(user.ID); err != nil {
+			log.Printf("Failed to update admin user last login: %v", err)
+		}
+	}()
+}
+
+// RevokeUserSessions 按用户ID使用户的所有会话失效，返回失效的会话数
+func (am *AuthManager) RevokeUserSessions(userID string) int {
+	return am.removeUserSessions(userID, "")
+}
+
+// UserSessionCounts 各用户的活跃会话数，按用户ID统计
+func (am *AuthManager) UserSessionCounts() map[string]int {
+	counts := make(map[string]int)
+	sessions, err := am.ListSessions()
+	if err != nil {
+		log.Printf("Failed to list admin sessions: %v", err)
+		return counts
+	}
+	for _, session := range sessions {
+		if session.UserID != "" {
+			counts[session.UserID]++
+		}
+	}
+	return counts
+}
+
+// secureCookie 判断会话Cookie是否需要Secure属性：HTTPS直连或反向代理声明原始请求为HTTPS
+func secureCookie(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"encoding/base32"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/logger"
+)
+
+// TestAdminUsers 测试初始管理员创建、登录角色和最后一个管理员保护
+func TestAdminUsers(t *testing.T) {
+	requestLogger, err := logger.NewRequestLogger(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create request logger: %v", err)
+	}
+	defer requestLogger.Close()
+
+	config := &internal.Config{}
+	config.Auth.Enabled = true
+	config.Auth.Username = "admin"
+	config.Auth.Password = "bootstrap-password"
+	config.Auth.SessionTimeout = time.Hour
+	am := NewAuthManager(config)
+	if err := am.SetUserStore(requestLogger); err != nil {
+		t.Fatalf("Failed to set user store: %v", err)
+	}
+
+	admin, err := requestLogger.GetAdminUserByUsername("admin")
+	if err != nil || admin.Role != RoleAdmin || admin.PasswordHash == config.Auth.Password {
+		t.Fatalf("Expected hashed bootstrap admin, got %+v (err: %v)", admin, err)
+	}
+
+	viewer, err := am.CreateUser("alice", "viewer-password", RoleViewer)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if _, err := am.CreateUser("bob", "short", RoleViewer); err == nil {
+		t.Error("Expected short password to be rejected")
+	}
+	if _, err := am.CreateUser("alice", "viewer-password", RoleViewer); err == nil {
+		t.Error("Expected duplicate username to be rejected")
+	}
+	if _, err := am.CreateUser("oidc:alice", "viewer-password", RoleViewer); err == nil {
+		t.Error("Expected the OIDC username prefix to be rejected")
+	}
+
+	session, err := am.Login("alice", "viewer-password")
+	if err != nil || session.Role != RoleViewer || session.UserID != viewer.ID {
+		t.Fatalf("Expected viewer session, got %+v (err: %v)", session, err)
+	}
+	if _, err := am.Login("alice", "wrong-password"); err == nil {
+		t.Error("Expected wrong password to be rejected")
+	}
+
+	if _, ok := am.ValidateToken(session.Token); !ok {
+		t.Fatal("Expected viewer session to be valid")
+	}
+
+	// 同名的OIDC用户会话不受本地用户的影响
+	oidcSession := am.createSession(oidcUsernamePrefix+"alice", oidcUsernamePrefix+"user-1", RoleAdmin, "oidc", false, sessionClient{})
+
+	// 修改用户后其会话失效
+	operator := RoleOperator
+	if _, err := am.UpdateUser(viewer.ID, AdminUserUpdate{Role: &operator}); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	if _, ok := am.ValidateToken(session.Token); ok {
+		t.Error("Expected session to be revoked after role change")
+	}
+	if _, ok := am.ValidateToken(oidcSession.Token); !ok {
+		t.Error("Expected the OIDC session of a same-named user to stay valid")
+	}
+
+	viewerRole, disabled := RoleViewer, true
+	if _, err := am.UpdateUser(admin.ID, AdminUserUpdate{Role: &viewerRole}); err == nil {
+		t.Error("Expected demoting the last admin to be rejected")
+	}
+	if _, err := am.UpdateUser(admin.ID, AdminUserUpdate{Disabled: &disabled}); err == nil {
+		t.Error("Expected disabling the last admin to be rejected")
+	}
+	if _, err := am.DeleteUser(admin.ID); err == nil {
+		t.Error("Expected deleting the last admin to be rejected")
+	}
+}
+
+// TestRequiredAdminRole 测试管理路由所需的角色
+func TestRequiredAdminRole(t *testing.T) {
+	cases := []struct {
+		method, path, role string
+	}{
+		{"GET", "/admin/groups", RoleViewer},
+		{"GET", "/admin/account", RoleViewer},
+		{"POST", "/admin/account/password", RoleViewer},
+		{"GET", "/admin/users", RoleAdmin},
+		{"POST", "/admin/api-tokens", RoleAdmin},
+		{"GET", "/admin/backup", RoleAdmin},
+		{"POST", "/admin/groups/openai/clone", RoleOperator},
+		{"POST", "/admin/group-templates", RoleOperator},
+	}
+	for _, tc := range cases {
+		if got := RequiredAdminRole(tc.method, tc.path); got != tc.role {
+			t.Errorf("RequiredAdminRole(%s %s) = %s, want %s", tc.method, tc.path, got, tc.role)
+		}
+	}
+
+	if !RoleAllows(RoleAdmin, RoleOperator) || RoleAllows(RoleViewer, RoleOperator) || RoleAllows("", RoleViewer) {
+		t.Error("Unexpected role hierarchy")
+	}
+}
+
+// TestTwoFactor 测试TOTP启用、登录时的验证码和恢复码校验
+func TestTwoFactor(t *testing.T) {
+	// RFC 6238 测试向量：T=59 时8位验证码为 94287082
+	if code := totpCode([]byte("12345678901234567890"), 59/totpPeriod); code != "287082" {
+		t.Errorf("Expected RFC 6238 code 287082, got %s", code)
+	}
+
+	requestLogger, err := logger.NewRequestLogger(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create request logger: %v", err)
+	}
+	defer requestLogger.Close()
+
+	config := &internal.Config{}
+	config.Auth.Enabled = true
+	config.Auth.Username = "admin"
+	config.Auth.Password = "bootstrap-password"
+	config.Auth.SessionTimeout = time.Hour
+	config.Auth.TwoFactor = &internal.TwoFactorSettings{Required: true}
+	am := NewAuthManager(config)
+	if err := am.SetUserStore(requestLogger); err != nil {
+		t.Fatalf("Failed to set user store: %v", err)
+	}
+
+	session, err := am.Login("admin", "bootstrap-password")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if !am.twoFactorPending(session) {
+		t.Fatal("Expected session to require two-factor enrollment")
+	}
+
+	secret, uri, err := am.BeginTwoFactorSetup("admin")
+	if err != nil || !strings.HasPrefix(uri, "otpauth://totp/TurnsAPI:admin?") {
+		t.Fatalf("Unexpected setup result %q (err: %v)", uri, err)
+	}
+	key, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+	recoveryCodes, err := am.EnableTwoFactor("admin", code, session.ID)
+	if err != nil || len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("Failed to enable two-factor: %v", err)
+	}
+	if am.twoFactorPending(session) {
+		t.Error("Expected enrollment to satisfy the current session")
+	}
+
+	if _, err := am.LoginWithCode("admin", "bootstrap-password", ""); err != errTwoFactorRequired {
+		t.Errorf("Expected errTwoFactorRequired, got %v", err)
+	}
+	// 同一时间步的验证码不能重复使用
+	if _, err := am.LoginWithCode("admin", "bootstrap-password", code); err != errInvalidTwoFactorCode {
+		t.Errorf("Expected replayed code to be rejected, got %v", err)
+	}
+
+	// 恢复码只能使用一次
+	session, err = am.LoginWithCode("admin", "bootstrap-password", strings.ToUpper(recoveryCodes[0]))
+	if err != nil || !session.TwoFactor {
+		t.Fatalf("Expected recovery code login to succeed, got %+v (err: %v)", session, err)
+	}
+	if _, err := am.LoginWithCode("admin", "bootstrap-password", recoveryCodes[0]); err == nil {
+		t.Error("Expected used recovery code to be rejected")
+	}
+	if enabled, remaining, _ := am.TwoFactorStatus("admin"); !enabled || remaining != recoveryCodeCount-1 {
+		t.Errorf("Expected %d remaining recovery codes, got %d", recoveryCodeCount-1, remaining)
+	}
+
+	// 连续输错后锁定
+	for i := 0; i < maxTwoFactorFailures; i++ {
+		am.LoginWithCode("admin", "bootstrap-password", "000000")
+	}
+	if _, err := am.LoginWithCode("admin", "bootstrap-password", recoveryCodes[1]); err != errTwoFactorLocked {
+		t.Errorf("Expected errTwoFactorLocked, got %v", err)
+	}
+}
@@ -0,0 +1,369 @@
+// Package backup SQLite数据库的在线备份、恢复和定时备份
+//
+// 备份使用 VACUUM INTO 在读事务中生成一致的数据库副本，服务运行期间可以随时执行，
+// 不阻塞请求日志写入。恢复需要在服务停止时通过命令行执行。
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/notify"
+	"turnsapi/internal/sqlitedriver"
+)
+
+const (
+	checkInterval   = 5 * time.Minute // 检查定时备份是否到期的间隔
+	defaultInterval = 24 * time.Hour
+	defaultKeep     = 7
+
+	filePrefix = "turnsapi-"
+	fileSuffix = ".db"
+	timeLayout = "20060102-150405"
+)
+
+// Result 一次备份的结果
+type Result struct {
+	Path      string        `json:"path"`                 // 本地备份文件
+	Size      int64         `json:"size"`                 // 备份文件大小
+	ObjectKey string        `json:"object_key,omitempty"` // 上传到S3的对象键
+	Duration  time.Duration `json:"duration"`
+	CreatedAt time.Time     `json:"created_at"`
+	Error     string        `json:"error,omitempty"` // 上传或清理失败时的错误，本地备份仍然有效
+}
+
+// Snapshot 将SQLite数据库在线备份到 dest，dest 必须不存在
+// 先写入同目录的临时文件，完成后再重命名，避免留下不完整的备份文件
+// 备份包含密钥等敏感数据，目录和文件只允许当前用户访问
+func Snapshot(dbPath, dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("backup file %s already exists", dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	db, err := sqlitedriver.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tmp := dest + ".tmp"
+	os.Remove(tmp)
+	if _, err := db.Exec(`VACUUM INTO ?`, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to backup database: %w", err)
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to set backup file permissions: %w", err)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to move backup file: %w", err)
+	}
+	return nil
+}
+
+// Verify 以只读方式打开备份文件并执行完整性检查
+func Verify(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read backup file: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("backup file %s is a directory", path)
+	}
+
+	db, err := sqlitedriver.OpenReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return fmt.Errorf("%s is not a valid sqlite database: %w", path, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check of %s failed: %s", path, result)
+	}
+	return nil
+}
+
+// Restore 用备份文件替换数据库，必须在服务停止时执行
+// 原数据库及其WAL文件重命名为 *.before-restore-<时间> 保留，返回保留的原数据库路径，原数据库不存在时返回空字符串
+func Restore(backupPath, dbPath string) (string, error) {
+	if err := Verify(backupPath); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0700); err != nil {
+		return "", fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	// 先复制到临时文件，复制完成后再替换，复制失败时原数据库保持不变
+	tmp := dbPath + ".restore-tmp"
+	if err := copyFile(backupPath, tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	var previous string
+	if _, err := os.Stat(dbPath); err == nil {
+		previous = dbPath + ".before-restore-" + time.Now().Format(timeLayout)
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			if _, err := os.Stat(dbPath + suffix); err != nil {
+				continue
+			}
+			if err := os.Rename(dbPath+suffix, previous+suffix); err != nil {
+				os.Remove(tmp)
+				return "", fmt.Errorf("failed to move existing database aside: %w", err)
+			}
+		}
+	}
+	if err := os.Rename(tmp, dbPath); err != nil {
+		return previous, fmt.Errorf("failed to replace database: %w", err)
+	}
+	return previous, nil
+}
+
+// copyFile 复制文件并同步到磁盘
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to sync %s: %w", dst, err)
+	}
+	return out.Close()
+}
+
+// FileName 返回指定时间的备份文件名
+func FileName(t time.Time) string {
+	return filePrefix + t.Format(timeLayout) + fileSuffix
+}
+
+// listBackups 返回目录中的备份文件名，按时间从旧到新排序
+func listBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Scheduler 定时备份SQLite数据库到本地目录，可选上传到S3兼容存储
+// 每次检查时读取最新配置，修改备份设置无需重启
+type Scheduler struct {
+	config   internal.ConfigSource
+	notifier *notify.Notifier
+
+	mu       sync.Mutex // 避免定时备份和手动备份同时进行
+	last     *Result
+	lastTime time.Time // 最近一次备份的时间，启动时从备份目录中最新的文件推算
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewScheduler 创建定时备份任务
+func NewScheduler(config internal.ConfigSource, notifier *notify.Notifier) *Scheduler {
+	return &Scheduler{
+		config:   config,
+		notifier: notifier,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start 启动后台定时备份
+func (s *Scheduler) Start() {
+	go s.run()
+}
+
+// Close 停止后台定时备份
+func (s *Scheduler) Close() {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+}
+
+// run 定期检查并执行到期的备份
+func (s *Scheduler) run() {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.RunDue(time.Now())
+		}
+	}
+}
+
+// settings 读取备份设置和数据库路径，非SQLite存储返回nil
+func (s *Scheduler) settings() (*internal.BackupSettings, string) {
+	config := s.config.Snapshot()
+	if config == nil {
+		return nil, ""
+	}
+	driver, dbPath := config.LogStorage()
+	if driver != "sqlite3" {
+		return nil, ""
+	}
+	settings := config.Database.Backup
+	if settings == nil {
+		settings = &internal.BackupSettings{}
+	}
+	return settings, dbPath
+}
+
+// backupDir 返回本地备份目录，默认为数据库所在目录下的 backups
+func backupDir(settings *internal.BackupSettings, dbPath string) string {
+	if settings.Dir != "" {
+		return settings.Dir
+	}
+	return filepath.Join(filepath.Dir(dbPath), "backups")
+}
+
+// RunDue 定时备份开启且距上次备份超过间隔时执行备份
+func (s *Scheduler) RunDue(now time.Time) {
+	settings, dbPath := s.settings()
+	if settings == nil || !settings.Enabled {
+		return
+	}
+	interval := settings.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	s.mu.Lock()
+	if s.lastTime.IsZero() {
+		if names, err := listBackups(backupDir(settings, dbPath)); err == nil && len(names) > 0 {
+			latest := strings.TrimSuffix(strings.TrimPrefix(names[len(names)-1], filePrefix), fileSuffix)
+			s.lastTime, _ = time.ParseInLocation(timeLayout, latest, time.Local)
+		}
+	}
+	due := now.Sub(s.lastTime) >= interval
+	s.mu.Unlock()
+	if !due {
+		return
+	}
+
+	if _, err := s.Run(context.Background()); err != nil {
+		log.Printf("警告: 定时备份数据库失败: %v", err)
+		if s.notifier != nil {
+			s.notifier.Send(notify.Notification{
+				Source:   "backup",
+				Event:    "failed",
+				Severity: notify.SeverityWarning,
+				Title:    "数据库定时备份失败",
+				Message:  err.Error(),
+			})
+		}
+	}
+}
+
+// Run 立即备份数据库到本地备份目录，配置了S3时上传，并清理超出保留数量的本地备份
+// 上传或清理失败时本地备份仍然有效，错误记录在结果的 Error 中
+func (s *Scheduler) Run(ctx context.Context) (*Result, error) {
+	settings, dbPath := s.settings()
+	if settings == nil {
+		return nil, fmt.Errorf("backup is only supported with the sqlite3 driver")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := time.Now()
+	dir := backupDir(settings, dbPath)
+	path := filepath.Join(dir, FileName(start))
+	if err := Snapshot(dbPath, path); err != nil {
+		return nil, err
+	}
+	s.lastTime = start
+
+	result := &Result{Path: path, CreatedAt: start}
+	if info, err := os.Stat(path); err == nil {
+		result.Size = info.Size()
+	}
+
+	var errs []string
+	if settings.S3 != nil {
+		key := strings.TrimPrefix(settings.S3.Prefix+filepath.Base(path), "/")
+		if err := uploadS3(ctx, settings.S3, key, path); err != nil {
+			errs = append(errs, fmt.Sprintf("upload to s3 failed: %v", err))
+		} else {
+			result.ObjectKey = key
+		}
+	}
+	if err := prune(dir, settings.Keep); err != nil {
+		errs = append(errs, fmt.Sprintf("prune old backups failed: %v", err))
+	}
+	result.Error = strings.Join(errs, "; ")
+	result.Duration = time.Since(start)
+	s.last = result
+
+	if result.Error != "" {
+		log.Printf("警告: 数据库已备份到 %s，但 %s", path, result.Error)
+	} else {
+		log.Printf("数据库已备份到 %s（%d 字节，用时 %v）", path, result.Size, result.Duration.Round(time.Millisecond))
+	}
+	return result, nil
+}
+
+// Last 返回最近一次备份的结果，尚未备份时返回nil
+func (s *Scheduler) Last() *Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// prune 删除超出保留数量的最旧的本地备份
+func prune(dir string, keep int) error {
+	if keep <= 0 {
+		keep = defaultKeep
+	}
+	names, err := listBackups(dir)
+	if err != nil {
+		return err
+	}
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
@@ -0,0 +1,444 @@
+package budgets
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/logger"
+	"turnsapi/internal/notify"
+)
+
+// Store 预算的持久化存储和用量查询
+type Store interface {
+	InsertBudget(budget *logger.Budget) error
+	UpdateBudget(budget *logger.Budget) error
+	GetAllBudgets() ([]*logger.Budget, error)
+	DeleteBudget(id string) error
+	GetBudgetUsageSince(since time.Time) ([]*logger.BudgetUsage, error)
+}
+
+// Status 预算及其本计费周期的用量
+type Status struct {
+	*logger.Budget
+	UsedUSD     float64    `json:"used_usd"`    // 按模型价格估算的费用（美元），未配置价格的模型不计入
+	UsedTokens  int64      `json:"used_tokens"` // token数
+	Exceeded    bool       `json:"exceeded"`    // 已超出预算，该范围内的请求会被拒绝
+	PeriodStart time.Time  `json:"period_start"`
+	PeriodEnd   time.Time  `json:"period_end"`
+	RefreshedAt *time.Time `json:"refreshed_at,omitempty"` // 最近一次从请求日志刷新用量的时间
+}
+
+// budgetState 预算的运行状态
+type budgetState struct {
+	budget     *logger.Budget
+	usedUSD    float64
+	usedTokens int64
+	notified   bool // 本计费周期已发送超出通知
+}
+
+// exceeded 预算是否启用且已用完费用或token上限
+func (s *budgetState) exceeded() bool {
+	b := s.budget
+	if !b.Enabled {
+		return false
+	}
+	return (b.LimitUSD > 0 && s.usedUSD >= b.LimitUSD) || (b.LimitTokens > 0 && s.usedTokens >= b.LimitTokens)
+}
+
+// Engine 预算引擎，定期从请求日志汇总本计费周期的用量，超出预算时发送通知并拒绝对应范围的请求
+type Engine struct {
+	config   internal.ConfigSource
+	store    Store
+	notifier *notify.Notifier
+
+	mu          sync.Mutex
+	budgets     []*budgetState
+	usage       []*logger.BudgetUsage // 最近一次刷新得到的本计费周期用量
+	periodStart time.Time
+	periodEnd   time.Time
+	refreshedAt time.Time
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewEngine 创建预算引擎并从存储加载预算
+func NewEngine(config internal.ConfigSource, store Store, notifier *notify.Notifier) (*Engine, error) {
+	e := &Engine{
+		config:   config,
+		store:    store,
+		notifier: notifier,
+		stopCh:   make(chan struct{}),
+	}
+
+	budgets, err := store.GetAllBudgets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load budgets: %w", err)
+	}
+	for _, budget := range budgets {
+		if err := ValidateBudget(budget); err != nil {
+			log.Printf("警告: 预算 %s 无效，已跳过: %v", budget.ID, err)
+			continue
+		}
+		e.budgets = append(e.budgets, &budgetState{budget: budget})
+	}
+	return e, nil
+}
+
+// ValidateBudget 校验预算，至少需要设置费用或token上限中的一项
+func ValidateBudget(budget *logger.Budget) error {
+	budget.Target = strings.TrimSpace(budget.Target)
+	switch budget.Scope {
+	case logger.BudgetScopeTenant, logger.BudgetScopeProxyKey, logger.BudgetScopeGroup:
+	default:
+		return fmt.Errorf("unsupported budget scope: %s", budget.Scope)
+	}
+	if budget.Target == "" {
+		return fmt.Errorf("target is required")
+	}
+	if budget.LimitUSD < 0 || budget.LimitTokens < 0 {
+		return fmt.Errorf("limit_usd and limit_tokens must not be negative")
+	}
+	if budget.LimitUSD == 0 && budget.LimitTokens == 0 {
+		return fmt.Errorf("limit_usd or limit_tokens is required")
+	}
+	return nil
+}
+
+// Period 返回包含 now 的计费周期 [start, end)，周期从每月的结算日零点开始
+// 当月没有结算日（如31日）时使用当月最后一天
+func Period(now time.Time, anchorDay int) (start, end time.Time) {
+	start = anchorDate(now.Year(), now.Month(), anchorDay, now.Location())
+	if now.Before(start) {
+		start = anchorDate(now.Year(), now.Month()-1, anchorDay, now.Location())
+	}
+	end = anchorDate(start.Year(), start.Month()+1, anchorDay, now.Location())
+	return start, end
+}
+
+// CurrentPeriod 按预算设置的结算日返回包含 now 的计费周期，未设置时从每月1日开始
+func CurrentPeriod(settings *internal.BudgetSettings, now time.Time) (start, end time.Time) {
+	anchorDay := 1
+	if settings != nil && settings.BillingAnchorDay > 0 {
+		anchorDay = settings.BillingAnchorDay
+	}
+	return Period(now, anchorDay)
+}
+
+// anchorDate 返回指定月份的结算日零点
+func anchorDate(year int, month time.Month, day int, loc *time.Location) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	if day < 1 {
+		day = 1
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// Start 立即刷新一次用量并启动后台定期刷新
+func (e *Engine) Start() {
+	e.Refresh(time.Now())
+	go e.run()
+}
+
+// Close 停止后台刷新
+func (e *Engine) Close() {
+	e.stopOnce.Do(func() { close(e.stopCh) })
+}
+
+// run 按配置的间隔刷新用量
+func (e *Engine) run() {
+	for {
+		interval := internal.DefaultBudgetRefreshInterval
+		if settings := e.settings(); settings != nil && settings.RefreshInterval > 0 {
+			interval = settings.RefreshInterval
+		}
+
+		select {
+		case <-e.stopCh:
+			return
+		case <-time.After(interval):
+		}
+
+		e.Refresh(time.Now())
+	}
+}
+
+// settings 读取预算设置
+func (e *Engine) settings() *internal.BudgetSettings {
+	config := e.config.Snapshot()
+	if config == nil || config.GlobalSettings == nil {
+		return nil
+	}
+	return config.GlobalSettings.Budgets
+}
+
+// pricing 读取模型价格目录
+func (e *Engine) pricing() map[string]internal.ModelPrice {
+	config := e.config.Snapshot()
+	if config == nil || config.GlobalSettings == nil {
+		return nil
+	}
+	return config.GlobalSettings.ModelPricing
+}
+
+// Refresh 从请求日志重新汇总本计费周期的用量，预算刚超出时发送通知，进入新的计费周期时用量清零
+func (e *Engine) Refresh(now time.Time) {
+	start, end := CurrentPeriod(e.settings(), now)
+	usage, err := e.store.GetBudgetUsageSince(start)
+	if err != nil {
+		log.Printf("Failed to refresh budget usage: %v", err)
+		return
+	}
+	pricing := e.pricing()
+
+	var notifications []notify.Notification
+	e.mu.Lock()
+	if !start.Equal(e.periodStart) {
+		for _, state := range e.budgets {
+			state.notified = false
+		}
+	}
+	e.usage = usage
+	e.periodStart, e.periodEnd = start, end
+	e.refreshedAt = now
+	for _, state := range e.budgets {
+		e.computeLocked(state, pricing)
+		if state.exceeded() && !state.notified {
+			state.notified = true
+			notifications = append(notifications, e.exceededNotification(state, now))
+		}
+	}
+	e.mu.Unlock()
+
+	for _, n := range notifications {
+		e.notifier.Send(n)
+	}
+}
+
+// computeLocked 根据最近一次刷新的用量计算预算的已用费用和token数，调用方需持有锁
+func (e *Engine) computeLocked(state *budgetState, pricing map[string]internal.ModelPrice) {
+	state.usedUSD, state.usedTokens = 0, 0
+	for _, u := range e.usage {
+		if !matches(state.budget, u) {
+			continue
+		}
+		state.usedTokens += u.Tokens
+		// 请求日志只记录总token数，按输入输出各占一半估算费用，与用量报告一致
+		if price, ok := pricing[u.Model]; ok {
+			half := int(u.Tokens / 2)
+			state.usedUSD += price.Cost(half, int(u.Tokens)-half)
+		}
+	}
+}
+
+// matches 用量是否属于预算的范围
+func matches(budget *logger.Budget, u *logger.BudgetUsage) bool {
+	switch budget.Scope {
+	case logger.BudgetScopeTenant:
+		return u.TenantID == budget.Target
+	case logger.BudgetScopeProxyKey:
+		return u.ProxyKeyID == budget.Target
+	case logger.BudgetScopeGroup:
+		return u.ProviderGroup == budget.Target
+	}
+	return false
+}
+
+// exceededNotification 生成预算超出的通知
+func (e *Engine) exceededNotification(state *budgetState, now time.Time) notify.Notification {
+	b := state.budget
+	return notify.Notification{
+		Source:   "budget",
+		Event:    "exceeded",
+		Severity: notify.SeverityCritical,
+		Title:    fmt.Sprintf("预算超出: %s %s", b.Scope, b.Target),
+		Message: fmt.Sprintf("本计费周期（%s 起）已用 $%.4f、%d tokens，预算 %s，在 %s 之前该范围的请求将被拒绝",
+			e.periodStart.Format("2006-01-02"), state.usedUSD, state.usedTokens, describeLimits(b), e.periodEnd.Format("2006-01-02")),
+		Labels: map[string]string{
+			"budget_id": b.ID,
+			"scope":     b.Scope,
+			"target":    b.Target,
+		},
+		Time: now,
+	}
+}
+
+// describeLimits 描述预算的上限
+func describeLimits(b *logger.Budget) string {
+	var limits []string
+	if b.LimitUSD > 0 {
+		limits = append(limits, fmt.Sprintf("$%.4f", b.LimitUSD))
+	}
+	if b.LimitTokens > 0 {
+		limits = append(limits, fmt.Sprintf("%d tokens", b.LimitTokens))
+	}
+	return strings.Join(limits, "、")
+}
+
+// Exceeded 返回范围内已超出的预算，没有预算或未超出时返回false
+// 计费周期结束后在下一次刷新前不再拒绝请求
+func (e *Engine) Exceeded(scope, target string) (*logger.Budget, bool) {
+	if target == "" {
+		return nil, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !time.Now().Before(e.periodEnd) {
+		return nil, false
+	}
+	for _, state := range e.budgets {
+		if state.budget.Scope == scope && state.budget.Target == target && state.exceeded() {
+			budget := *state.budget
+			return &budget, true
+		}
+	}
+	return nil, false
+}
+
+// Statuses 获取所有预算及其本计费周期的用量
+func (e *Engine) Statuses() []Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	statuses := make([]Status, 0, len(e.budgets))
+	for _, state := range e.budgets {
+		statuses = append(statuses, e.statusLocked(state))
+	}
+	return statuses
+}
+
+// statusLocked 返回预算状态的副本，调用方需持有锁
+func (e *Engine) statusLocked(state *budgetState) Status {
+	budget := *state.budget
+	status := Status{
+		Budget:      &budget,
+		UsedUSD:     state.usedUSD,
+		UsedTokens:  state.usedTokens,
+		Exceeded:    state.exceeded(),
+		PeriodStart: e.periodStart,
+		PeriodEnd:   e.periodEnd,
+	}
+	if !e.refreshedAt.IsZero() {
+		refreshedAt := e.refreshedAt
+		status.RefreshedAt = &refreshedAt
+	}
+	return status
+}
+
+// Budget 获取单个预算
+func (e *Engine) Budget(id string) (*logger.Budget, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, state := range e.budgets {
+		if state.budget.ID == id {
+			budget := *state.budget
+			return &budget, true
+		}
+	}
+	return nil, false
+}
+
+// checkDuplicateLocked 同一范围和对象只能有一个预算，调用方需持有锁
+func (e *Engine) checkDuplicateLocked(budget *logger.Budget) error {
+	for _, state := range e.budgets {
+		if state.budget.ID != budget.ID && state.budget.Scope == budget.Scope && state.budget.Target == budget.Target {
+			return fmt.Errorf("budget for %s %s already exists", budget.Scope, budget.Target)
+		}
+	}
+	return nil
+}
+
+// CreateBudget 校验并保存新预算，未指定ID时自动生成，返回预算的当前状态
+func (e *Engine) CreateBudget(budget *logger.Budget) (*Status, error) {
+	if budget.ID == "" {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return nil, fmt.Errorf("failed to generate budget id: %w", err)
+		}
+		budget.ID = hex.EncodeToString(id)
+	}
+	if err := ValidateBudget(budget); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.checkDuplicateLocked(budget); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	budget.CreatedAt = now
+	budget.UpdatedAt = now
+	if err := e.store.InsertBudget(budget); err != nil {
+		return nil, err
+	}
+
+	state := &budgetState{budget: budget}
+	e.computeLocked(state, e.pricing())
+	e.budgets = append(e.budgets, state)
+	status := e.statusLocked(state)
+	return &status, nil
+}
+
+// UpdateBudget 校验并更新预算，返回预算的当前状态
+// 更新后仍处于超出状态时不重复发送通知
+func (e *Engine) UpdateBudget(budget *logger.Budget) (*Status, error) {
+	if err := ValidateBudget(budget); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var existing *budgetState
+	for _, state := range e.budgets {
+		if state.budget.ID == budget.ID {
+			existing = state
+			break
+		}
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("budget not found")
+	}
+	if err := e.checkDuplicateLocked(budget); err != nil {
+		return nil, err
+	}
+	budget.CreatedAt = existing.budget.CreatedAt
+	budget.UpdatedAt = time.Now()
+	if err := e.store.UpdateBudget(budget); err != nil {
+		return nil, err
+	}
+
+	existing.budget = budget
+	e.computeLocked(existing, e.pricing())
+	existing.notified = existing.notified && existing.exceeded()
+	status := e.statusLocked(existing)
+	return &status, nil
+}
+
+// DeleteBudget 删除预算
+func (e *Engine) DeleteBudget(id string) error {
+	if err := e.store.DeleteBudget(id); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	for i, state := range e.budgets {
+		if state.budget.ID == id {
+			e.budgets = append(e.budgets[:i], e.budgets[i+1:]...)
+			break
+		}
+	}
+	e.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,97 @@
+package budgets
+
+import (
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/logger"
+	"turnsapi/internal/notify"
+)
+
+type memoryStore struct {
+	budgets []*logger.Budget
+	usage   []*logger.BudgetUsage
+}
+
+func (s *memoryStore) InsertBudget(budget *logger.Budget) error { return nil }
+func (s *memoryStore) UpdateBudget(budget *logger.Budget) error { return nil }
+func (s *memoryStore) DeleteBudget(id string) error             { return nil }
+func (s *memoryStore) GetAllBudgets() ([]*logger.Budget, error) { return s.budgets, nil }
+func (s *memoryStore) GetBudgetUsageSince(since time.Time) ([]*logger.BudgetUsage, error) {
+	return s.usage, nil
+}
+
+// TestPeriod 测试计费周期按结算日划分，当月没有结算日时使用当月最后一天
+func TestPeriod(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		now        time.Time
+		anchor     int
+		start, end time.Time
+	}{
+		{date(2024, 3, 10), 1, date(2024, 3, 1), date(2024, 4, 1)},
+		{date(2024, 3, 10), 15, date(2024, 2, 15), date(2024, 3, 15)},
+		{date(2024, 3, 15), 15, date(2024, 3, 15), date(2024, 4, 15)},
+		{date(2024, 1, 5), 15, date(2023, 12, 15), date(2024, 1, 15)},
+		{date(2024, 2, 29), 31, date(2024, 2, 29), date(2024, 3, 31)},
+		{date(2024, 3, 30), 31, date(2024, 2, 29), date(2024, 3, 31)},
+	}
+	for _, tt := range tests {
+		start, end := Period(tt.now, tt.anchor)
+		if !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("Period(%s, %d) = [%s, %s), want [%s, %s)", tt.now.Format("2006-01-02"), tt.anchor,
+				start.Format("2006-01-02"), end.Format("2006-01-02"), tt.start.Format("2006-01-02"), tt.end.Format("2006-01-02"))
+		}
+	}
+}
+
+// TestEngine 测试按范围汇总用量、估算费用，超出预算后只拒绝对应范围并只通知一次
+func TestEngine(t *testing.T) {
+	config := &internal.Config{GlobalSettings: &internal.GlobalSettings{
+		ModelPricing: map[string]internal.ModelPrice{"gpt": {InputPer1M: 1, OutputPer1M: 3}},
+	}}
+	store := &memoryStore{
+		budgets: []*logger.Budget{
+			{ID: "tenant", Scope: logger.BudgetScopeTenant, Target: "t1", LimitUSD: 2, Enabled: true},
+			{ID: "group", Scope: logger.BudgetScopeGroup, Target: "g1", LimitTokens: 1000, Enabled: true},
+			{ID: "disabled", Scope: logger.BudgetScopeProxyKey, Target: "k2", LimitTokens: 1, Enabled: false},
+		},
+		usage: []*logger.BudgetUsage{
+			{ProxyKeyID: "k1", TenantID: "t1", ProviderGroup: "g2", Model: "gpt", Tokens: 1000000},
+			{ProxyKeyID: "k2", ProviderGroup: "g1", Model: "unpriced", Tokens: 500},
+		},
+	}
+	notifier := notify.NewNotifier(config)
+	engine, err := NewEngine(config, store, notifier)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	engine.Refresh(time.Now())
+	if _, exceeded := engine.Exceeded(logger.BudgetScopeTenant, "t1"); !exceeded {
+		t.Error("tenant budget should be exceeded: $2 of estimated $2 used")
+	}
+	if _, exceeded := engine.Exceeded(logger.BudgetScopeGroup, "g1"); exceeded {
+		t.Error("group budget should not be exceeded with 500 of 1000 tokens")
+	}
+	if _, exceeded := engine.Exceeded(logger.BudgetScopeProxyKey, "k2"); exceeded {
+		t.Error("disabled budget should not reject requests")
+	}
+
+	store.usage = append(store.usage, &logger.BudgetUsage{ProxyKeyID: "k3", ProviderGroup: "g1", Model: "gpt", Tokens: 500})
+	engine.Refresh(time.Now())
+	if _, exceeded := engine.Exceeded(logger.BudgetScopeGroup, "g1"); !exceeded {
+		t.Error("group budget should be exceeded after reaching 1000 tokens")
+	}
+	if n := len(notifier.Recent()); n != 2 {
+		t.Errorf("sent %d notifications, want one per exceeded budget", n)
+	}
+
+	if _, err := engine.CreateBudget(&logger.Budget{Scope: logger.BudgetScopeGroup, Target: "g1", LimitUSD: 5, Enabled: true}); err == nil {
+		t.Error("CreateBudget() accepted a second budget for the same group")
+	}
+	if _, err := engine.CreateBudget(&logger.Budget{Scope: logger.BudgetScopeGroup, Target: "g3", Enabled: true}); err == nil {
+		t.Error("CreateBudget() accepted a budget without limits")
+	}
+}
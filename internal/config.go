@@ -2,28 +2,513 @@ package internal
 
 import (
 	"fmt"
+	"log"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"turnsapi/internal/database"
+	"turnsapi/internal/hooks"
+	"turnsapi/internal/logging"
+	"turnsapi/internal/providers"
+	"turnsapi/internal/scripting"
+
 	"gopkg.in/yaml.v2"
 )
 
 // UserGroup 用户自定义分组配置
 type UserGroup struct {
-	Name              string                 `yaml:"name"`
-	ProviderType      string                 `yaml:"provider_type"`
-	BaseURL           string                 `yaml:"base_url"`
-	Enabled           bool                   `yaml:"enabled"`
-	Timeout           time.Duration          `yaml:"timeout"`
-	MaxRetries        int                    `yaml:"max_retries"`
-	RotationStrategy  string                 `yaml:"rotation_strategy"`
-	Models            []string               `yaml:"models"`
-	APIKeys           []string               `yaml:"api_keys"`
-	Headers           map[string]string      `yaml:"headers,omitempty"`
-	RequestParams     map[string]interface{} `yaml:"request_params,omitempty"`      // JSON请求参数覆盖
-	ModelMappings     map[string]string      `yaml:"model_mappings,omitempty"`      // 模型名称映射：别名 -> 原始模型名
-	UseNativeResponse bool                   `yaml:"use_native_response,omitempty"` // 是否使用原生接口响应格式
-	RPMLimit          int                    `yaml:"rpm_limit,omitempty"`           // 每分钟请求数限制
+	Name                string                 `yaml:"name"`
+	ProviderType        string                 `yaml:"provider_type"`
+	BaseURL             string                 `yaml:"base_url"`
+	Enabled             bool                   `yaml:"enabled"`
+	Timeout             time.Duration          `yaml:"timeout"`
+	MaxRetries          int                    `yaml:"max_retries"`
+	RotationStrategy    string                 `yaml:"rotation_strategy"`
+	Models              []string               `yaml:"models"`
+	APIKeys             []string               `yaml:"api_keys"`
+	Headers             map[string]string      `yaml:"headers,omitempty"`
+	RequestParams       map[string]interface{} `yaml:"request_params,omitempty"`         // JSON请求参数覆盖
+	ModelMappings       map[string]string      `yaml:"model_mappings,omitempty"`         // 模型名称映射：别名 -> 原始模型名
+	ModelRewrites       []ModelRewriteRule     `yaml:"model_rewrites,omitempty"`         // 模型名称正则重写规则，在别名映射未命中时按顺序匹配
+	UseNativeResponse   bool                   `yaml:"use_native_response,omitempty"`    // 是否使用原生接口响应格式
+	RPMLimit            int                    `yaml:"rpm_limit,omitempty"`              // 每分钟请求数限制
+	RPMBurst            int                    `yaml:"rpm_burst,omitempty"`              // RPM突发容量，设置后令牌按 rpm_limit/60 每秒匀速补充，0表示不平滑
+	ModelLimits         map[string]ModelLimit  `yaml:"model_limits,omitempty"`           // 按上游模型名设置的RPM/TPM限制，与分组的 rpm_limit 同时生效
+	ChatCompletionsPath string                 `yaml:"chat_completions_path,omitempty"`  // 聊天完成接口路径覆盖，为空时使用提供商默认路径
+	ModelsPath          string                 `yaml:"models_path,omitempty"`            // 模型列表接口路径覆盖，为空时使用提供商默认路径
+	MaxConcurrent       int                    `yaml:"max_concurrent,omitempty"`         // 分组最大并发请求数，0表示无限制
+	MaxConcurrentPerKey int                    `yaml:"max_concurrent_per_key,omitempty"` // 单个密钥最大并发请求数，0表示无限制
+	RetryPolicy         *RetryPolicy           `yaml:"retry_policy,omitempty"`           // 重试策略，为空时使用默认策略
+	HealthCheckModel    string                 `yaml:"health_check_model,omitempty"`     // 健康检查使用的模型，为空时使用分组的第一个模型
+	SkipHealthCheck     bool                   `yaml:"skip_health_check,omitempty"`      // 是否跳过健康检查，适用于请求成本较高的分组
+	Shadow              *ShadowConfig          `yaml:"shadow,omitempty"`                 // 影子流量，将抽样的请求复制到另一个分组用于对比，为空时不启用
+	Timeouts            *TimeoutPolicy         `yaml:"timeouts,omitempty"`               // 上游请求分阶段超时，为空时只按 timeout 限制总时长
+	HealthProbe         *HealthProbe           `yaml:"health_probe,omitempty"`           // 主动健康探测，为空时不探测
+	ProxyURL            string                 `yaml:"proxy_url,omitempty"`              // 出站代理（http://、https://、socks5://），为空时直连上游
+	TLS                 *TLSSettings           `yaml:"tls,omitempty"`                    // 上游TLS设置（私有CA、客户端证书），为空时使用系统默认
+	VertexAI            *VertexAISettings      `yaml:"vertex_ai,omitempty"`              // Gemini分组的Vertex AI认证模式，为空时使用Gemini API密钥
+	Transport           *TransportSettings     `yaml:"transport,omitempty"`              // 上游连接池设置，为空时使用全局设置
+	Hooks               []string               `yaml:"hooks,omitempty"`                  // 启用的请求/响应钩子名称，按顺序调用，钩子需要在代码中注册
+	Script              *ScriptSettings        `yaml:"script,omitempty"`                 // 转换请求和响应的Lua脚本，为空时不启用
+	IncludeReasoning    bool                   `yaml:"include_reasoning,omitempty"`      // 是否在响应中返回模型的思考内容（reasoning_content），默认去除
+	DefaultMaxTokens    int                    `yaml:"default_max_tokens,omitempty"`     // 请求未指定max_tokens时使用的默认值，用于要求必填的提供商（Anthropic），0表示使用4096
+	SystemPrompt        string                 `yaml:"system_prompt,omitempty"`          // 注入到每个聊天请求最前面的系统提示词，支持 {date}、{key_name}、{group}、{model} 变量
+
+	APIKeyRefs        map[string]string                  `yaml:"-"` // 解析后的密钥 -> 配置中的引用（${ENV_VAR} 或 file:/path），只保存在内存中
+	UnresolvedAPIKeys []string                           `yaml:"-"` // 无法解析的密钥引用，不参与轮询，保存时原样写回
+	APIKeyMetadata    map[string]database.APIKeyMetadata `yaml:"-"` // 配置中的密钥（引用密钥为引用） -> 标签等信息，从数据库加载
+}
+
+// TimeoutPolicy 分组上游请求的分阶段超时，未设置的阶段不单独限制
+// 连接和首字节超时用于快速发现上游不可达或无响应并故障转移，总超时允许长时间生成的请求完成
+type TimeoutPolicy struct {
+	ConnectMs   int `yaml:"connect_ms,omitempty" json:"connect_ms,omitempty"`       // 建立连接（含TLS握手）的超时（毫秒）
+	FirstByteMs int `yaml:"first_byte_ms,omitempty" json:"first_byte_ms,omitempty"` // 从发出请求到收到响应头的超时（毫秒），流式请求即首个数据到达前
+	TotalMs     int `yaml:"total_ms,omitempty" json:"total_ms,omitempty"`           // 整个请求含读取完整响应或流的超时（毫秒），默认300000
+}
+
+// IsZero 判断分阶段超时是否未设置任何字段
+func (p *TimeoutPolicy) IsZero() bool {
+	return p == nil || (p.ConnectMs == 0 && p.FirstByteMs == 0 && p.TotalMs == 0)
+}
+
+// ValidateTimeouts 校验分组的分阶段超时
+func ValidateTimeouts(policy *TimeoutPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.ConnectMs < 0 || policy.FirstByteMs < 0 || policy.TotalMs < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+	if policy.ConnectMs > 0 && policy.FirstByteMs > 0 && policy.ConnectMs > policy.FirstByteMs {
+		return fmt.Errorf("timeouts.connect_ms (%d) must not exceed timeouts.first_byte_ms (%d)", policy.ConnectMs, policy.FirstByteMs)
+	}
+	if policy.TotalMs > 0 {
+		if policy.ConnectMs > policy.TotalMs || policy.FirstByteMs > policy.TotalMs {
+			return fmt.Errorf("timeouts.connect_ms and timeouts.first_byte_ms must not exceed timeouts.total_ms (%d)", policy.TotalMs)
+		}
+	}
+	return nil
+}
+
+// ValidateDefaultMaxTokens 校验默认最大输出token数
+func ValidateDefaultMaxTokens(maxTokens int) error {
+	if maxTokens < 0 {
+		return fmt.Errorf("default_max_tokens must not be negative")
+	}
+	return nil
+}
+
+// ValidateRPMBurst 校验RPM突发容量，需要同时设置 rpm_limit；不小于 rpm_limit 时不起作用
+func ValidateRPMBurst(rpmLimit, rpmBurst int) error {
+	if rpmBurst < 0 {
+		return fmt.Errorf("rpm_burst must not be negative")
+	}
+	if rpmBurst > 0 && rpmLimit <= 0 {
+		return fmt.Errorf("rpm_burst requires rpm_limit")
+	}
+	return nil
+}
+
+// ModelLimit 分组内单个模型的速率限制，0表示不限制
+// 上游按模型分别限流时（如 gpt-4o 与 gpt-4o-mini 的限额不同）使用，模型名为映射后发送到上游的名称
+type ModelLimit struct {
+	RPM int `yaml:"rpm,omitempty" json:"rpm,omitempty"` // 每分钟请求数
+	TPM int `yaml:"tpm,omitempty" json:"tpm,omitempty"` // 每分钟token数（提示词和输出合计）
+}
+
+// ValidateModelLimits 校验按模型的速率限制
+func ValidateModelLimits(limits map[string]ModelLimit) error {
+	for model, limit := range limits {
+		if strings.TrimSpace(model) == "" {
+			return fmt.Errorf("model_limits: model name must not be empty")
+		}
+		if limit.RPM < 0 || limit.TPM < 0 {
+			return fmt.Errorf("model_limits.%s: rpm and tpm must not be negative", model)
+		}
+	}
+	return nil
+}
+
+// ValidateHooks 校验分组启用的钩子：名称不能为空或重复，且必须已注册
+func ValidateHooks(names []string) error {
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("hooks: hook name must not be empty")
+		}
+		if seen[name] {
+			return fmt.Errorf("hooks: duplicate hook %q", name)
+		}
+		seen[name] = true
+		if !hooks.Registered(name) {
+			return fmt.Errorf("hooks: unknown hook %q (registered: %s)", name, strings.Join(hooks.Names(), ", "))
+		}
+	}
+	return nil
+}
+
+// ScriptSettings 分组的Lua脚本设置，source 和 file 二选一
+// 脚本定义 on_request(req, ctx) 和/或 on_response(resp, ctx)，在沙箱中执行并受执行时间限制，详见 scripting 包
+type ScriptSettings struct {
+	Source    string `yaml:"source,omitempty" json:"source,omitempty"`         // 内联脚本
+	File      string `yaml:"file,omitempty" json:"file,omitempty"`             // 脚本文件路径，修改后自动重新加载
+	TimeoutMs int    `yaml:"timeout_ms,omitempty" json:"timeout_ms,omitempty"` // 单次调用的执行时间上限（毫秒），默认50
+}
+
+// IsZero 判断脚本设置是否未设置任何字段
+func (s *ScriptSettings) IsZero() bool {
+	return s == nil || *s == ScriptSettings{}
+}
+
+// Timeout 单次调用的执行时间上限，未设置时返回0（使用默认值）
+func (s *ScriptSettings) Timeout() time.Duration {
+	return time.Duration(s.TimeoutMs) * time.Millisecond
+}
+
+// LoadSource 获取脚本内容，配置了 file 时读取文件
+func (s *ScriptSettings) LoadSource() (string, error) {
+	if s.File == "" {
+		return s.Source, nil
+	}
+	data, err := os.ReadFile(s.File)
+	if err != nil {
+		return "", fmt.Errorf("failed to read script file: %w", err)
+	}
+	return string(data), nil
+}
+
+// ValidateScript 校验分组的Lua脚本设置，脚本必须可以编译并定义至少一个入口函数
+func ValidateScript(settings *ScriptSettings) error {
+	if settings.IsZero() {
+		return nil
+	}
+	if (settings.Source == "") == (settings.File == "") {
+		return fmt.Errorf("script: exactly one of source and file must be set")
+	}
+	if settings.TimeoutMs < 0 || settings.Timeout() > scripting.MaxTimeout {
+		return fmt.Errorf("script: timeout_ms must be between 0 and %d", scripting.MaxTimeout.Milliseconds())
+	}
+	source, err := settings.LoadSource()
+	if err != nil {
+		return fmt.Errorf("script: %w", err)
+	}
+	if _, err := scripting.Compile("script", source, settings.Timeout()); err != nil {
+		return fmt.Errorf("script: %w", err)
+	}
+	return nil
+}
+
+// HealthProbe 分组主动健康探测设置，未设置的字段使用默认值
+// 按间隔使用探测模型发送一个极短的请求，连续失败达到阈值后分组被标记为不健康并暂停参与路由，连续成功达到阈值后恢复
+type HealthProbe struct {
+	Enabled            bool   `yaml:"enabled" json:"enabled"`
+	IntervalSeconds    int    `yaml:"interval_seconds,omitempty" json:"interval_seconds,omitempty"`       // 探测间隔（秒），默认60
+	Model              string `yaml:"model,omitempty" json:"model,omitempty"`                             // 探测模型，为空时使用 health_check_model 或分组的第一个模型
+	Prompt             string `yaml:"prompt,omitempty" json:"prompt,omitempty"`                           // 探测提示词，默认 "ping"
+	MaxTokens          int    `yaml:"max_tokens,omitempty" json:"max_tokens,omitempty"`                   // 探测请求的 max_tokens，默认1
+	TimeoutSeconds     int    `yaml:"timeout_seconds,omitempty" json:"timeout_seconds,omitempty"`         // 单次探测超时（秒），默认10
+	UnhealthyThreshold int    `yaml:"unhealthy_threshold,omitempty" json:"unhealthy_threshold,omitempty"` // 连续失败多少次后标记为不健康，默认3
+	HealthyThreshold   int    `yaml:"healthy_threshold,omitempty" json:"healthy_threshold,omitempty"`     // 不健康后连续成功多少次恢复，默认1
+}
+
+// 主动健康探测默认值
+const (
+	DefaultHealthProbeInterval           = 60 * time.Second
+	DefaultHealthProbePrompt             = "ping"
+	DefaultHealthProbeTimeout            = 10 * time.Second
+	DefaultHealthProbeUnhealthyThreshold = 3
+)
+
+// Interval 探测间隔
+func (p *HealthProbe) Interval() time.Duration {
+	if p.IntervalSeconds > 0 {
+		return time.Duration(p.IntervalSeconds) * time.Second
+	}
+	return DefaultHealthProbeInterval
+}
+
+// Timeout 单次探测超时
+func (p *HealthProbe) Timeout() time.Duration {
+	if p.TimeoutSeconds > 0 {
+		return time.Duration(p.TimeoutSeconds) * time.Second
+	}
+	return DefaultHealthProbeTimeout
+}
+
+// ProbePrompt 探测提示词
+func (p *HealthProbe) ProbePrompt() string {
+	if p.Prompt != "" {
+		return p.Prompt
+	}
+	return DefaultHealthProbePrompt
+}
+
+// ProbeMaxTokens 探测请求的 max_tokens
+func (p *HealthProbe) ProbeMaxTokens() int {
+	if p.MaxTokens > 0 {
+		return p.MaxTokens
+	}
+	return 1
+}
+
+// Thresholds 标记为不健康和恢复所需的连续失败、成功次数
+func (p *HealthProbe) Thresholds() (unhealthy, healthy int) {
+	unhealthy, healthy = p.UnhealthyThreshold, p.HealthyThreshold
+	if unhealthy <= 0 {
+		unhealthy = DefaultHealthProbeUnhealthyThreshold
+	}
+	if healthy <= 0 {
+		healthy = 1
+	}
+	return unhealthy, healthy
+}
+
+// ValidateHealthProbe 校验分组的主动健康探测设置
+func ValidateHealthProbe(probe *HealthProbe) error {
+	if probe == nil {
+		return nil
+	}
+	if probe.IntervalSeconds < 0 || probe.TimeoutSeconds < 0 || probe.MaxTokens < 0 ||
+		probe.UnhealthyThreshold < 0 || probe.HealthyThreshold < 0 {
+		return fmt.Errorf("health_probe values must not be negative")
+	}
+	if probe.IntervalSeconds > 0 && probe.IntervalSeconds < 5 {
+		return fmt.Errorf("health_probe.interval_seconds must be at least 5, got %d", probe.IntervalSeconds)
+	}
+	if probe.Timeout() > probe.Interval() {
+		return fmt.Errorf("health_probe.timeout_seconds must not exceed health_probe.interval_seconds")
+	}
+	return nil
+}
+
+// ValidateProxyURL 校验分组的出站代理地址，支持 http、https、socks5 和 socks5h，为空表示直连
+func ValidateProxyURL(proxyURL string) error {
+	if proxyURL == "" {
+		return nil
+	}
+	_, err := providers.ParseProxyURL(proxyURL)
+	return err
+}
+
+// RedactedProxyURL 隐藏出站代理地址中的密码，用于日志和审计记录
+func (g *UserGroup) RedactedProxyURL() string {
+	if g.ProxyURL == "" {
+		return ""
+	}
+	u, err := url.Parse(g.ProxyURL)
+	if err != nil {
+		return "<invalid>"
+	}
+	return u.Redacted()
+}
+
+// TLSSettings 分组发往上游的TLS设置，用于私有CA签发证书或要求客户端证书（mTLS）的自建后端
+type TLSSettings struct {
+	CAFile             string `yaml:"ca_file,omitempty" json:"ca_file,omitempty"`                           // 额外信任的CA证书（PEM），与系统根证书一起使用
+	CertFile           string `yaml:"cert_file,omitempty" json:"cert_file,omitempty"`                       // 客户端证书（PEM）
+	KeyFile            string `yaml:"key_file,omitempty" json:"key_file,omitempty"`                         // 客户端证书私钥（PEM）
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty" json:"insecure_skip_verify,omitempty"` // 不校验上游证书，仅用于测试环境
+}
+
+// IsZero 判断TLS设置是否未设置任何字段
+func (t *TLSSettings) IsZero() bool {
+	return t == nil || *t == TLSSettings{}
+}
+
+// ProviderOptions 转换为提供商使用的TLS选项
+func (t *TLSSettings) ProviderOptions() *providers.TLSOptions {
+	if t.IsZero() {
+		return nil
+	}
+	return &providers.TLSOptions{
+		CAFile:             t.CAFile,
+		CertFile:           t.CertFile,
+		KeyFile:            t.KeyFile,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+}
+
+// ValidateTLSSettings 校验分组的TLS设置，证书文件必须存在且可以解析
+func ValidateTLSSettings(settings *TLSSettings) error {
+	_, err := providers.LoadTLSConfig(settings.ProviderOptions())
+	return err
+}
+
+// VertexAISettings Gemini分组的Vertex AI认证模式
+// 启用后 api_keys 中的每个密钥为服务账号JSON（建议使用 file:/path 引用）或Vertex AI API密钥，base_url 为公共Gemini API地址时使用区域默认地址
+type VertexAISettings struct {
+	Enabled  bool   `yaml:"enabled" json:"enabled"`
+	Project  string `yaml:"project,omitempty" json:"project,omitempty"`   // GCP项目ID，为空时使用服务账号中的 project_id
+	Location string `yaml:"location,omitempty" json:"location,omitempty"` // 区域，默认 us-central1
+}
+
+// IsZero 判断是否未启用Vertex AI认证模式
+func (v *VertexAISettings) IsZero() bool {
+	return v == nil || !v.Enabled
+}
+
+// ProviderOptions 转换为提供商使用的Vertex AI设置
+func (v *VertexAISettings) ProviderOptions() *providers.VertexAIOptions {
+	if v.IsZero() {
+		return nil
+	}
+	return &providers.VertexAIOptions{
+		Project:  v.Project,
+		Location: v.Location,
+	}
+}
+
+// ValidateVertexAI 校验Vertex AI设置，只适用于gemini分组，未设置区域时使用默认区域
+func ValidateVertexAI(providerType string, settings *VertexAISettings) error {
+	if settings.IsZero() {
+		return nil
+	}
+	if providerType != "gemini" {
+		return fmt.Errorf("vertex_ai is only supported for gemini groups")
+	}
+	settings.Project = strings.TrimSpace(settings.Project)
+	settings.Location = strings.TrimSpace(settings.Location)
+	if settings.Location == "" {
+		settings.Location = providers.DefaultVertexAILocation
+	}
+	return nil
+}
+
+// TransportSettings 上游连接池设置，可在 global_settings.upstream_transport 全局设置并按分组覆盖，未设置的字段使用全局设置或默认值
+type TransportSettings struct {
+	MaxIdleConns           int  `yaml:"max_idle_conns,omitempty" json:"max_idle_conns,omitempty"`                       // 所有上游主机共保留的空闲连接数，默认512
+	MaxIdleConnsPerHost    int  `yaml:"max_idle_conns_per_host,omitempty" json:"max_idle_conns_per_host,omitempty"`     // 每个上游主机保留的空闲连接数，默认64
+	MaxConnsPerHost        int  `yaml:"max_conns_per_host,omitempty" json:"max_conns_per_host,omitempty"`               // 每个上游主机的最大连接数，0表示不限制
+	IdleConnTimeoutSeconds int  `yaml:"idle_conn_timeout_seconds,omitempty" json:"idle_conn_timeout_seconds,omitempty"` // 空闲连接保留时间（秒），默认90
+	DisableHTTP2           bool `yaml:"disable_http2,omitempty" json:"disable_http2,omitempty"`                         // 只使用HTTP/1.1，默认对HTTPS上游协商HTTP/2
+	DNSCacheSeconds        int  `yaml:"dns_cache_seconds,omitempty" json:"dns_cache_seconds,omitempty"`                 // 上游域名解析结果的缓存时间（秒），0表示不缓存
+}
+
+// IsZero 判断连接池设置是否未设置任何字段
+func (t *TransportSettings) IsZero() bool {
+	return t == nil || *t == TransportSettings{}
+}
+
+// ProviderOptions 转换为提供商使用的连接池设置
+func (t *TransportSettings) ProviderOptions() *providers.TransportOptions {
+	if t.IsZero() {
+		return nil
+	}
+	return &providers.TransportOptions{
+		MaxIdleConns:        t.MaxIdleConns,
+		MaxIdleConnsPerHost: t.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     t.MaxConnsPerHost,
+		IdleConnTimeout:     time.Duration(t.IdleConnTimeoutSeconds) * time.Second,
+		DisableHTTP2:        t.DisableHTTP2,
+		DNSCacheTTL:         time.Duration(t.DNSCacheSeconds) * time.Second,
+	}
+}
+
+// ValidateTransportSettings 校验连接池设置
+func ValidateTransportSettings(settings *TransportSettings) error {
+	if settings == nil {
+		return nil
+	}
+	if settings.MaxIdleConns < 0 || settings.MaxIdleConnsPerHost < 0 || settings.MaxConnsPerHost < 0 ||
+		settings.IdleConnTimeoutSeconds < 0 || settings.DNSCacheSeconds < 0 {
+		return fmt.Errorf("connection pool settings must not be negative")
+	}
+	if settings.MaxConnsPerHost > 0 && settings.MaxIdleConnsPerHost > settings.MaxConnsPerHost {
+		return fmt.Errorf("max_idle_conns_per_host must not exceed max_conns_per_host")
+	}
+	return nil
+}
+
+// ShadowConfig 分组影子流量设置，该分组处理成功的请求按比例复制一份发送到目标分组
+// 影子请求的响应不返回给客户端，两边的请求日志使用相同的关联ID
+type ShadowConfig struct {
+	TargetGroup   string  `yaml:"target_group" json:"target_group"`       // 接收影子请求的分组
+	SamplePercent float64 `yaml:"sample_percent" json:"sample_percent"`   // 复制的请求比例（0-100）
+	Model         string  `yaml:"model,omitempty" json:"model,omitempty"` // 影子请求使用的模型，为空时使用原请求的模型
+}
+
+// ValidateShadow 校验分组的影子流量设置
+func ValidateShadow(groupID string, shadow *ShadowConfig) error {
+	if shadow == nil {
+		return nil
+	}
+	if shadow.TargetGroup == "" {
+		return fmt.Errorf("shadow.target_group is required")
+	}
+	if shadow.TargetGroup == groupID {
+		return fmt.Errorf("shadow.target_group cannot be the group itself")
+	}
+	if shadow.SamplePercent <= 0 || shadow.SamplePercent > 100 {
+		return fmt.Errorf("shadow.sample_percent must be greater than 0 and at most 100, got %v", shadow.SamplePercent)
+	}
+	return nil
+}
+
+// RetryPolicy 分组重试策略，未设置的字段使用默认值
+type RetryPolicy struct {
+	MaxAttempts          int   `yaml:"max_attempts,omitempty" json:"max_attempts,omitempty"`                     // 最大尝试次数（含首次请求），默认3
+	RetryableStatusCodes []int `yaml:"retryable_status_codes,omitempty" json:"retryable_status_codes,omitempty"` // 可重试的上游HTTP状态码，默认408、429和5xx
+	InitialBackoffMs     int   `yaml:"initial_backoff_ms,omitempty" json:"initial_backoff_ms,omitempty"`         // 首次重试前的退避时间（毫秒），默认200
+	MaxBackoffMs         int   `yaml:"max_backoff_ms,omitempty" json:"max_backoff_ms,omitempty"`                 // 单次退避时间上限（毫秒），默认5000
+	MaxTotalTimeMs       int   `yaml:"max_total_time_ms,omitempty" json:"max_total_time_ms,omitempty"`           // 请求重试的总时间预算（毫秒），默认30000
+	RespectRetryAfter    *bool `yaml:"respect_retry_after,omitempty" json:"respect_retry_after,omitempty"`       // 是否遵循上游Retry-After，默认true
+}
+
+// IsZero 判断重试策略是否未设置任何字段
+func (p *RetryPolicy) IsZero() bool {
+	return p == nil || (p.MaxAttempts == 0 && len(p.RetryableStatusCodes) == 0 &&
+		p.InitialBackoffMs == 0 && p.MaxBackoffMs == 0 && p.MaxTotalTimeMs == 0 && p.RespectRetryAfter == nil)
+}
+
+// DefaultTestModel 根据提供商类型返回默认的测试模型
+func DefaultTestModel(providerType string) string {
+	switch providerType {
+	case "anthropic":
+		return "claude-3-haiku-20240307"
+	case "gemini":
+		return "gemini-2.5-flash"
+	default:
+		return "gpt-3.5-turbo"
+	}
+}
+
+// TestModel 返回健康检查和密钥验证使用的模型
+// 优先使用配置的健康检查模型，其次是分组的第一个模型，最后使用提供商类型的默认模型
+func (g *UserGroup) TestModel() string {
+	if g.HealthCheckModel != "" {
+		return g.HealthCheckModel
+	}
+	if len(g.Models) > 0 {
+		return g.Models[0]
+	}
+	return DefaultTestModel(g.ProviderType)
+}
+
+// ProviderHealthCheckModel 返回传给提供商健康检查的模型
+// Gemini的健康检查总是发送生成请求，使用 TestModel；其他提供商只在显式配置了健康检查模型时才发送请求，否则检查模型列表接口
+func (g *UserGroup) ProviderHealthCheckModel() string {
+	if g.ProviderType == "gemini" {
+		return g.TestModel()
+	}
+	return g.HealthCheckModel
+}
+
+// IsKeyless 分组是否为无需密钥的本地后端（openai_compatible 类型且未配置密钥），请求不携带密钥
+func (g *UserGroup) IsKeyless() bool {
+	return !providers.RequiresAPIKey(g.ProviderType) && len(g.APIKeys) == 0 && len(g.UnresolvedAPIKeys) == 0
+}
+
+// FirstAPIKey 返回分组的第一个密钥，用于获取模型列表等不需要轮换的请求，无需密钥的分组返回空字符串
+func (g *UserGroup) FirstAPIKey() string {
+	if len(g.APIKeys) == 0 {
+		return ""
+	}
+	return g.APIKeys[0]
 }
 
 // GlobalSettings 全局设置
@@ -31,6 +516,532 @@ type GlobalSettings struct {
 	DefaultRotationStrategy string        `yaml:"default_rotation_strategy"`
 	DefaultTimeout          time.Duration `yaml:"default_timeout"`
 	DefaultMaxRetries       int           `yaml:"default_max_retries"`
+
+	// 客户端通过 X-Request-Timeout 请求头指定单次请求超时的上限，默认10分钟，设为负数时忽略该请求头
+	MaxRequestTimeout time.Duration `yaml:"max_request_timeout,omitempty"`
+
+	// 不支持 n 的提供商（Anthropic）为每个选择单独发送上游请求，n 超过此值的请求不路由到这些分组，默认4
+	MaxFanOutChoices int `yaml:"max_fanout_choices,omitempty"`
+
+	// 路由失败跟踪设置，为空时使用默认值且不持久化
+	RouterFailures *RouterFailureSettings `yaml:"router_failures,omitempty"`
+
+	// 提供商额度预警阈值，为空时使用默认值
+	QuotaWarnings *QuotaWarningSettings `yaml:"quota_warnings,omitempty"`
+
+	// 上游响应头透传设置，为空时透传默认的限流相关响应头
+	UpstreamHeaders *UpstreamHeaderSettings `yaml:"upstream_headers,omitempty"`
+
+	// 上游请求的 User-Agent 和归属请求头，为空时使用 TurnsAPI/<版本号>
+	UpstreamIdentity *UpstreamIdentitySettings `yaml:"upstream_identity,omitempty"`
+
+	// 上游连接池设置，对所有分组生效，分组的 transport 可以覆盖，为空时使用默认值
+	UpstreamTransport *TransportSettings `yaml:"upstream_transport,omitempty"`
+
+	// 通知渠道设置，为空时通知只写入日志
+	Notifications *NotificationSettings `yaml:"notifications,omitempty"`
+
+	// 日志告警规则评估设置，为空时使用默认值
+	Alerts *AlertSettings `yaml:"alerts,omitempty"`
+
+	// 定时用量汇总报告设置，为空时不自动生成，仍可通过管理接口手动生成
+	UsageReports *UsageReportSettings `yaml:"usage_reports,omitempty"`
+
+	// 预算计费周期和用量刷新设置，为空时使用默认值，预算本身通过管理接口维护
+	Budgets *BudgetSettings `yaml:"budgets,omitempty"`
+
+	// 分组并发数或RPM已满时的请求排队设置，为空或未启用时直接返回429
+	RequestQueue *RequestQueueSettings `yaml:"request_queue,omitempty"`
+
+	// 延迟敏感代理密钥的并行对冲设置，为空或未启用时不对冲
+	Hedging *HedgingSettings `yaml:"hedging,omitempty"`
+
+	// 会话粘滞路由设置，为空时不启用
+	StickySessions *StickySessionSettings `yaml:"sticky_sessions,omitempty"`
+
+	// 幂等请求设置，为空时不启用
+	Idempotency *IdempotencySettings `yaml:"idempotency,omitempty"`
+
+	// 结构化输出（response_format）设置，为空时只校验不修复
+	StructuredOutput *StructuredOutputSettings `yaml:"structured_output,omitempty"`
+
+	// 模型价格目录：模型名称 -> 每百万token价格（美元）
+	ModelPricing map[string]ModelPrice `yaml:"model_pricing,omitempty"`
+
+	// 自动模型别名设置，为空或没有候选模型时不启用
+	AutoModel *AutoModelSettings `yaml:"auto_model,omitempty"`
+
+	// 分组间按比例分流（A/B测试），为空时不启用
+	TrafficSplits []TrafficSplit `yaml:"traffic_splits,omitempty"`
+
+	// 上下文窗口校验设置，为空时不校验
+	ContextLimits *ContextLimitSettings `yaml:"context_limits,omitempty"`
+
+	// 流式响应心跳设置，为空时不发送心跳
+	StreamHeartbeat *StreamHeartbeatSettings `yaml:"stream_heartbeat,omitempty"`
+
+	// 备用模型目录：提供商类型 -> 模型列表，分组未配置模型列表且无法从上游获取时使用
+	// 仅在数据库中的目录为空时导入，之后通过管理API修改
+	FallbackModels map[string][]string `yaml:"fallback_models,omitempty"`
+}
+
+// ModelPrice 模型的每百万token价格（美元）
+type ModelPrice struct {
+	InputPer1M  float64 `yaml:"input_per_1m" json:"input_per_1m"`
+	OutputPer1M float64 `yaml:"output_per_1m" json:"output_per_1m"`
+}
+
+// Cost 按token用量计算费用（美元）
+func (p ModelPrice) Cost(promptTokens, completionTokens int) float64 {
+	return (float64(promptTokens)*p.InputPer1M + float64(completionTokens)*p.OutputPer1M) / 1e6
+}
+
+// EstimateCost 按模型定价估算请求费用，请求日志只记录总token数，按输入输出各占一半估算；未配置定价时返回0
+func (c *Config) EstimateCost(model string, tokens int) float64 {
+	if c == nil || c.GlobalSettings == nil {
+		return 0
+	}
+	price, ok := c.GlobalSettings.ModelPricing[model]
+	if !ok {
+		return 0
+	}
+	half := tokens / 2
+	return price.Cost(half, tokens-half)
+}
+
+// 自动模型的质量等级和选择策略
+const (
+	AutoModelTierHigh   = "high"
+	AutoModelTierMedium = "medium"
+	AutoModelTierLow    = "low"
+
+	AutoModelStrategyCheapest = "cheapest"
+	AutoModelStrategyFastest  = "fastest"
+)
+
+// AutoModelSettings 自动模型别名设置，请求该别名时代理从满足质量等级的候选模型中选择最便宜或最快的模型
+type AutoModelSettings struct {
+	Alias       string               `yaml:"alias"`        // 自动模型别名，默认 auto
+	Strategy    string               `yaml:"strategy"`     // cheapest 或 fastest，默认 cheapest
+	DefaultTier string               `yaml:"default_tier"` // 请求未声明质量等级时使用的等级，默认 medium
+	Candidates  []AutoModelCandidate `yaml:"candidates"`
+}
+
+// AutoModelCandidate 自动模型的候选模型，价格来自 model_pricing
+type AutoModelCandidate struct {
+	Model string `yaml:"model" json:"model"`
+	Tier  string `yaml:"tier" json:"tier"` // high、medium 或 low，高等级模型同样满足低等级的请求
+}
+
+// AutoModelTierRank 质量等级的高低顺序，未知等级返回0
+func AutoModelTierRank(tier string) int {
+	switch tier {
+	case AutoModelTierLow:
+		return 1
+	case AutoModelTierMedium:
+		return 2
+	case AutoModelTierHigh:
+		return 3
+	}
+	return 0
+}
+
+// ValidateAutoModel 校验自动模型设置并填充默认值
+func ValidateAutoModel(settings *AutoModelSettings) error {
+	if settings == nil {
+		return nil
+	}
+	if settings.Alias == "" {
+		settings.Alias = "auto"
+	}
+	if settings.Strategy == "" {
+		settings.Strategy = AutoModelStrategyCheapest
+	}
+	if settings.Strategy != AutoModelStrategyCheapest && settings.Strategy != AutoModelStrategyFastest {
+		return fmt.Errorf("auto_model.strategy must be %q or %q, got %q", AutoModelStrategyCheapest, AutoModelStrategyFastest, settings.Strategy)
+	}
+	if settings.DefaultTier == "" {
+		settings.DefaultTier = AutoModelTierMedium
+	}
+	if AutoModelTierRank(settings.DefaultTier) == 0 {
+		return fmt.Errorf("auto_model.default_tier must be high, medium or low, got %q", settings.DefaultTier)
+	}
+	for i, candidate := range settings.Candidates {
+		if candidate.Model == "" {
+			return fmt.Errorf("auto_model.candidates[%d]: model is required", i)
+		}
+		if AutoModelTierRank(candidate.Tier) == 0 {
+			return fmt.Errorf("auto_model.candidates[%d]: tier must be high, medium or low, got %q", i, candidate.Tier)
+		}
+	}
+	return nil
+}
+
+// TrafficSplit 分组间按权重分流的A/B测试，请求虚拟模型名或来自指定代理密钥时按权重分配到某个分支的分组
+// 同时配置 model 和 proxy_keys 时两者都满足才分流
+type TrafficSplit struct {
+	Name          string            `yaml:"name" json:"name"`                                   // 分流名称，记录在请求日志中用于按分支统计
+	Model         string            `yaml:"model,omitempty" json:"model,omitempty"`             // 虚拟模型名
+	ProxyKeys     []string          `yaml:"proxy_keys,omitempty" json:"proxy_keys,omitempty"`   // 代理密钥ID
+	Deterministic bool              `yaml:"deterministic" json:"deterministic"`                 // 按哈希分配分支，同一调用方或会话总是进入同一分支
+	HashHeader    string            `yaml:"hash_header,omitempty" json:"hash_header,omitempty"` // 确定性分流使用的请求头，默认 X-Conversation-ID，未提供时使用代理密钥ID
+	Strict        bool              `yaml:"strict" json:"strict"`                               // 只使用分配的分组，不故障转移到其他分组
+	Arms          []TrafficSplitArm `yaml:"arms" json:"arms"`
+}
+
+// TrafficSplitArm 分流的一个分支
+type TrafficSplitArm struct {
+	Group  string `yaml:"group" json:"group"`
+	Weight int    `yaml:"weight" json:"weight"`
+	Model  string `yaml:"model,omitempty" json:"model,omitempty"` // 该分支实际请求的模型，为空时使用请求的模型
+}
+
+// ValidateTrafficSplits 校验分流配置
+func ValidateTrafficSplits(splits []TrafficSplit) error {
+	names := make(map[string]bool, len(splits))
+	for i, split := range splits {
+		if split.Name == "" {
+			return fmt.Errorf("traffic_splits[%d]: name is required", i)
+		}
+		if names[split.Name] {
+			return fmt.Errorf("traffic_splits[%d]: duplicate name %q", i, split.Name)
+		}
+		names[split.Name] = true
+		if split.Model == "" && len(split.ProxyKeys) == 0 {
+			return fmt.Errorf("traffic split %q: model or proxy_keys is required", split.Name)
+		}
+		if len(split.Arms) < 2 {
+			return fmt.Errorf("traffic split %q: at least two arms are required", split.Name)
+		}
+		groups := make(map[string]bool, len(split.Arms))
+		for j, arm := range split.Arms {
+			if arm.Group == "" {
+				return fmt.Errorf("traffic split %q: arms[%d]: group is required", split.Name, j)
+			}
+			if groups[arm.Group] {
+				return fmt.Errorf("traffic split %q: duplicate arm group %q", split.Name, arm.Group)
+			}
+			groups[arm.Group] = true
+			if arm.Weight <= 0 {
+				return fmt.Errorf("traffic split %q: arms[%d]: weight must be positive", split.Name, j)
+			}
+		}
+	}
+	return nil
+}
+
+// 上下文超限处理策略
+const (
+	ContextPolicyReject   = "reject"   // 返回400 context_length_exceeded
+	ContextPolicyTruncate = "truncate" // 从最早的非system消息开始丢弃，直到放得下
+)
+
+// ContextLimitSettings 上下文窗口校验设置，发送到上游前估算提示词token数，超出模型的上下文窗口时拒绝或截断
+type ContextLimitSettings struct {
+	Policy        string         `yaml:"policy"`         // reject（默认）或 truncate
+	OutputReserve int            `yaml:"output_reserve"` // 请求未指定 max_tokens 时为输出预留的token数
+	Models        map[string]int `yaml:"models"`         // 模型名称或前缀 -> 上下文窗口token数
+}
+
+// ValidateContextLimits 校验上下文窗口设置并填充默认值
+func ValidateContextLimits(settings *ContextLimitSettings) error {
+	if settings == nil {
+		return nil
+	}
+	if settings.Policy == "" {
+		settings.Policy = ContextPolicyReject
+	}
+	if settings.Policy != ContextPolicyReject && settings.Policy != ContextPolicyTruncate {
+		return fmt.Errorf("context_limits.policy must be %q or %q, got %q", ContextPolicyReject, ContextPolicyTruncate, settings.Policy)
+	}
+	if settings.OutputReserve < 0 {
+		return fmt.Errorf("context_limits.output_reserve must not be negative")
+	}
+	for model, window := range settings.Models {
+		if window <= 0 {
+			return fmt.Errorf("context_limits.models.%s must be positive", model)
+		}
+	}
+	return nil
+}
+
+// 上游请求超时的默认值
+const (
+	DefaultRequestTimeout    = 300 * time.Second // 分组未设置 timeout 且未配置 global_settings.default_timeout 时单次上游请求的总超时
+	DefaultMaxRequestTimeout = 10 * time.Minute  // 客户端通过请求头指定超时的默认上限
+)
+
+// DefaultMaxFanOutChoices 不支持 n 的提供商默认最多为一个请求并行发送的上游请求数
+const DefaultMaxFanOutChoices = 4
+
+// StreamHeartbeatSettings 流式响应心跳设置，等待上游第一个数据块期间定期向客户端发送SSE注释行（": ping"），
+// 避免首字节较慢的模型（如推理模型）长时间没有输出时被中间代理按空闲连接断开
+type StreamHeartbeatSettings struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"` // 发送间隔，默认15秒
+}
+
+// DefaultStreamHeartbeatInterval 未配置间隔时的心跳发送间隔
+const DefaultStreamHeartbeatInterval = 15 * time.Second
+
+// ValidateStreamHeartbeat 校验流式响应心跳设置并填充默认值
+func ValidateStreamHeartbeat(settings *StreamHeartbeatSettings) error {
+	if settings == nil {
+		return nil
+	}
+	if settings.Interval == 0 {
+		settings.Interval = DefaultStreamHeartbeatInterval
+	}
+	if settings.Interval < time.Second {
+		return fmt.Errorf("stream_heartbeat.interval must be at least 1s")
+	}
+	return nil
+}
+
+// StructuredOutputSettings 结构化输出设置，要求JSON输出的非流式响应返回前校验JSON
+type StructuredOutputSettings struct {
+	RepairRetry bool `yaml:"repair_retry"` // JSON无效时附上校验错误要求模型修正并重试一次
+}
+
+// StickySessionSettings 会话粘滞路由设置，同一会话的请求在有效期内固定到同一分组和密钥，以命中提供商的提示词缓存
+type StickySessionSettings struct {
+	Enabled          bool          `yaml:"enabled"`
+	TTL              time.Duration `yaml:"ttl"`                // 会话绑定的有效期，每次请求成功后刷新，默认30分钟
+	Header           string        `yaml:"header"`             // 客户端提供会话ID的请求头，默认 X-Conversation-ID
+	HashFirstMessage *bool         `yaml:"hash_first_message"` // 未提供会话ID时是否以第一条用户消息的哈希作为会话标识，默认true
+}
+
+// IdempotencySettings 幂等请求设置，带有相同 Idempotency-Key 和请求体的重复请求在时间窗口内直接返回第一次的响应
+// 记录保存在数据库中，启用Redis共享状态时保存在Redis中，所有实例共用
+type IdempotencySettings struct {
+	Enabled      bool          `yaml:"enabled"`
+	TTL          time.Duration `yaml:"ttl"`            // 保存响应的时间窗口，默认1小时
+	MaxBodyBytes int           `yaml:"max_body_bytes"` // 保存的响应体大小上限，超出时不保存（重复请求重新发往上游），默认1MB
+}
+
+// NotificationSettings 通知渠道设置，告警等事件通过这些渠道发送
+type NotificationSettings struct {
+	Webhooks []WebhookTarget `yaml:"webhooks,omitempty"`
+}
+
+// WebhookTarget 接收通知的Webhook，以JSON POST发送，payload中的 text 字段兼容Slack等即时通讯机器人
+type WebhookTarget struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Sources []string          `yaml:"sources,omitempty"` // 只接收这些来源的通知（如 alert），为空表示全部
+	Timeout time.Duration     `yaml:"timeout,omitempty"` // 请求超时，默认10s
+}
+
+// AlertSettings 日志告警规则评估设置，规则本身通过管理接口维护
+type AlertSettings struct {
+	Disabled           bool          `yaml:"disabled"`            // 是否停止评估告警规则
+	EvaluationInterval time.Duration `yaml:"evaluation_interval"` // 评估间隔，默认30s
+}
+
+// BudgetSettings 预算设置，计费周期从每月的结算日零点（服务器本地时区）开始
+type BudgetSettings struct {
+	BillingAnchorDay int           `yaml:"billing_anchor_day"` // 每月结算日（1-31），默认1，当月没有该日期时使用当月最后一天
+	RefreshInterval  time.Duration `yaml:"refresh_interval"`   // 从请求日志刷新用量的间隔，默认1m
+}
+
+// DefaultBudgetRefreshInterval 未配置刷新间隔时从请求日志刷新预算用量的间隔
+const DefaultBudgetRefreshInterval = time.Minute
+
+// ValidateBudgetSettings 校验预算设置并填充默认值
+func ValidateBudgetSettings(settings *BudgetSettings) error {
+	if settings == nil {
+		return nil
+	}
+	if settings.BillingAnchorDay == 0 {
+		settings.BillingAnchorDay = 1
+	}
+	if settings.BillingAnchorDay < 1 || settings.BillingAnchorDay > 31 {
+		return fmt.Errorf("budgets.billing_anchor_day must be between 1 and 31")
+	}
+	if settings.RefreshInterval == 0 {
+		settings.RefreshInterval = DefaultBudgetRefreshInterval
+	}
+	if settings.RefreshInterval < time.Second {
+		return fmt.Errorf("budgets.refresh_interval must be at least 1s")
+	}
+	return nil
+}
+
+// RequestQueueSettings 请求排队设置，所有候选分组的并发数或RPM已满时请求排队等待，
+// 容量恢复时按代理密钥的优先级从高到低、同一优先级先到先得的顺序继续处理
+type RequestQueueSettings struct {
+	Enabled         bool           `yaml:"enabled"`
+	MaxWait         time.Duration  `yaml:"max_wait"`                 // 最长排队时间，超时后返回429，默认10s
+	MaxSize         int            `yaml:"max_size"`                 // 同时排队的请求数上限，队列已满时直接返回429，默认1000
+	DefaultPriority int            `yaml:"default_priority"`         // 未配置优先级的代理密钥的优先级，默认0
+	KeyPriorities   map[string]int `yaml:"key_priorities,omitempty"` // 代理密钥ID或名称 -> 优先级，数值越大越优先
+}
+
+// 请求排队的默认值
+const (
+	DefaultRequestQueueMaxWait = 10 * time.Second
+	DefaultRequestQueueMaxSize = 1000
+)
+
+// ValidateRequestQueue 校验请求排队设置并填充默认值
+func ValidateRequestQueue(settings *RequestQueueSettings) error {
+	if settings == nil {
+		return nil
+	}
+	if settings.MaxWait == 0 {
+		settings.MaxWait = DefaultRequestQueueMaxWait
+	}
+	if settings.MaxWait < 0 || settings.MaxWait > DefaultMaxRequestTimeout {
+		return fmt.Errorf("request_queue.max_wait must be between 0 and %s", DefaultMaxRequestTimeout)
+	}
+	if settings.MaxSize == 0 {
+		settings.MaxSize = DefaultRequestQueueMaxSize
+	}
+	if settings.MaxSize < 0 {
+		return fmt.Errorf("request_queue.max_size must not be negative")
+	}
+	return nil
+}
+
+// HedgingSettings 并行对冲设置：指定代理密钥的非流式请求同时发送到两个可用分组，
+// 先成功的响应返回给客户端并取消另一个请求，两个请求都记录到请求日志并计入用量和预算
+type HedgingSettings struct {
+	Enabled   bool     `yaml:"enabled"`
+	ProxyKeys []string `yaml:"proxy_keys"` // 使用对冲的代理密钥ID或名称
+	Models    []string `yaml:"models"`     // 允许对冲的模型，支持 * 和 ? 通配符
+}
+
+// ValidateHedging 校验并行对冲设置，启用时必须指定代理密钥和模型
+func ValidateHedging(settings *HedgingSettings) error {
+	if settings == nil || !settings.Enabled {
+		return nil
+	}
+	if len(settings.ProxyKeys) == 0 {
+		return fmt.Errorf("hedging.proxy_keys must not be empty when hedging is enabled")
+	}
+	if len(settings.Models) == 0 {
+		return fmt.Errorf("hedging.models must not be empty when hedging is enabled")
+	}
+	return nil
+}
+
+// UsageReportSettings 定时用量汇总报告设置，报告按服务器本地时区划分日期
+type UsageReportSettings struct {
+	Daily  bool `yaml:"daily"`  // 每天生成前一天的报告
+	Weekly bool `yaml:"weekly"` // 每周一生成上一周（周一至周日）的报告
+	Notify bool `yaml:"notify"` // 生成后通过通知渠道发送摘要，通知来源为 report
+	TopN   int  `yaml:"top_n"`  // 通知摘要中列出的分组和代理密钥数，默认5
+}
+
+// UpstreamHeaderSettings 上游响应头透传设置，选中的响应头以 X-TurnsAPI-* 头部返回给客户端并记录到请求日志
+type UpstreamHeaderSettings struct {
+	Passthrough *bool    `yaml:"passthrough,omitempty"` // 是否返回给客户端，默认true；关闭后仍记录到请求日志
+	Headers     []string `yaml:"headers,omitempty"`     // 选中的上游响应头，为空时使用默认的限流相关响应头
+}
+
+// UpstreamIdentitySettings 发往上游的身份标识，部分厂商要求调用方使用可识别的 User-Agent
+// 分组 headers 中配置的同名请求头（包括 User-Agent）优先于这里的全局设置
+type UpstreamIdentitySettings struct {
+	UserAgent string            `yaml:"user_agent,omitempty"` // 如 "TurnsAPI/2.0 (+https://example.com)"，为空时使用 TurnsAPI/<版本号>
+	Headers   map[string]string `yaml:"headers,omitempty"`    // 附加的归属请求头，如 HTTP-Referer、X-Title
+}
+
+// DefaultOpenRouterReferer OpenRouter分组未配置 HTTP-Referer 时使用的应用地址，OpenRouter据此归属调用方应用
+const DefaultOpenRouterReferer = "https://github.com/Rsv51/TurnsApi"
+
+// reservedIdentityHeaders 不能通过身份标识设置的请求头，由提供商按协议设置
+var reservedIdentityHeaders = map[string]bool{
+	"authorization":  true,
+	"x-api-key":      true,
+	"x-goog-api-key": true,
+	"content-type":   true,
+	"content-length": true,
+	"host":           true,
+}
+
+// ValidateUpstreamIdentity 校验上游身份标识设置
+func ValidateUpstreamIdentity(identity *UpstreamIdentitySettings) error {
+	if identity == nil {
+		return nil
+	}
+	if strings.ContainsAny(identity.UserAgent, "\r\n") {
+		return fmt.Errorf("upstream_identity.user_agent must not contain line breaks")
+	}
+	for name, value := range identity.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("upstream_identity.headers: invalid header name %q", name)
+		}
+		if reservedIdentityHeaders[strings.ToLower(name)] {
+			return fmt.Errorf("upstream_identity.headers: %s is set by the provider and cannot be overridden", name)
+		}
+		if strings.EqualFold(name, "User-Agent") {
+			return fmt.Errorf("upstream_identity.headers: use user_agent instead of a User-Agent header")
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("upstream_identity.headers.%s must not contain line breaks", name)
+		}
+	}
+	return nil
+}
+
+// IdentityHeader 获取全局归属请求头的值，请求头名称不区分大小写
+func (s *UpstreamIdentitySettings) IdentityHeader(name string) string {
+	if s == nil {
+		return ""
+	}
+	for key, value := range s.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// QuotaWarningSettings 提供商额度预警设置，按上游额度响应头和分组RPM计数计算已用比例
+type QuotaWarningSettings struct {
+	WarningPercent  float64 `yaml:"warning_percent"`  // 已用比例达到该值时发出警告，默认80
+	CriticalPercent float64 `yaml:"critical_percent"` // 已用比例达到该值时发出严重警告，默认95
+}
+
+// RouterFailureSettings 路由器分组失败跟踪设置
+type RouterFailureSettings struct {
+	Persist        bool          `yaml:"persist"`         // 是否持久化到数据库，重启后保留失败状态
+	HalfLife       time.Duration `yaml:"half_life"`       // 失败计数的衰减半衰期，默认10分钟
+	BlockThreshold float64       `yaml:"block_threshold"` // 衰减后的失败计数达到该值时屏蔽分组，默认3
+	BlockDuration  time.Duration `yaml:"block_duration"`  // 屏蔽时长，默认5分钟
+}
+
+// ServerRateLimitSettings 服务器级限流设置，按令牌桶限制整个服务、单个客户端IP和单个代理密钥的请求速率
+// 只作用于代理接口（/v1、/v1beta 等），超限时返回429和 RateLimit-* 响应头
+type ServerRateLimitSettings struct {
+	Global RateLimitRule `yaml:"global"`  // 所有请求共享
+	PerIP  RateLimitRule `yaml:"per_ip"`  // 每个客户端IP，在认证前检查
+	PerKey RateLimitRule `yaml:"per_key"` // 每个代理密钥
+}
+
+// ServerTLSSettings 服务端HTTPS设置，证书文件更新（如续期）后自动加载，无需重启
+type ServerTLSSettings struct {
+	CertFile   string `yaml:"cert_file"`             // 证书链（PEM），服务器证书在前
+	KeyFile    string `yaml:"key_file"`              // 私钥（PEM）
+	MinVersion string `yaml:"min_version,omitempty"` // 最低TLS版本：1.2（默认）或 1.3
+}
+
+// RateLimitRule 令牌桶限流规则，requests_per_minute 为0表示不限制
+type RateLimitRule struct {
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	Burst             int `yaml:"burst"` // 桶容量，允许的突发请求数，默认等于 requests_per_minute
+}
+
+// RedisSettings 多实例部署时共享密钥轮询游标、RPM窗口和分组失败状态的Redis设置
+type RedisSettings struct {
+	Enabled      bool          `yaml:"enabled"`
+	Addr         string        `yaml:"addr"` // Redis地址，默认 127.0.0.1:6379
+	Password     string        `yaml:"password"`
+	DB           int           `yaml:"db"`
+	KeyPrefix    string        `yaml:"key_prefix"`    // 键前缀，默认 turnsapi:
+	DialTimeout  time.Duration `yaml:"dial_timeout"`  // 连接超时，默认3s
+	SyncInterval time.Duration `yaml:"sync_interval"` // 从Redis同步分组失败状态的间隔，默认5s
 }
 
 // Monitoring 监控配置
@@ -38,21 +1049,140 @@ type Monitoring struct {
 	Enabled         bool   `yaml:"enabled"`
 	MetricsEndpoint string `yaml:"metrics_endpoint"`
 	HealthEndpoint  string `yaml:"health_endpoint"`
+
+	// 链路追踪，span通过 OTLP/HTTP 导出
+	Tracing *TracingSettings `yaml:"tracing,omitempty"`
+}
+
+// TracingSettings 链路追踪设置
+type TracingSettings struct {
+	Enabled     bool              `yaml:"enabled"`
+	Endpoint    string            `yaml:"endpoint"`               // OTLP/HTTP 接收地址，如 http://otel-collector:4318/v1/traces
+	Headers     map[string]string `yaml:"headers,omitempty"`      // 导出请求附带的请求头，如收集器认证令牌
+	ServiceName string            `yaml:"service_name,omitempty"` // 默认 turnsapi
+	SampleRatio float64           `yaml:"sample_ratio,omitempty"` // 新追踪的采样比例（0~1），默认1；上游传入的采样决定优先
+}
+
+// DebugSettings 调试设置
+type DebugSettings struct {
+	EchoEnabled bool `yaml:"echo_enabled"` // 是否启用 /v1/debug/echo 请求回显端点
+
+	// 允许通过 X-TurnsAPI-Debug 请求头开启请求级调试日志的代理密钥（ID或名称），"*" 表示所有密钥
+	RequestDebugKeys []string `yaml:"request_debug_keys,omitempty"`
+}
+
+// TrustedHeaderAuth 可信头部认证设置
+// 由前置网关（如 Istio、启用mTLS的nginx）校验调用方身份并注入身份头部，TurnsAPI将身份映射到代理密钥，无需Bearer令牌
+type TrustedHeaderAuth struct {
+	Enabled         bool              `yaml:"enabled"`
+	Header          string            `yaml:"header"`                      // 携带已验证身份的请求头，默认 X-Forwarded-User
+	TrustedSources  []string          `yaml:"trusted_sources"`             // 允许注入身份头部的来源地址（IP或CIDR），必填
+	Identities      map[string]string `yaml:"identities"`                  // 身份 -> 代理密钥ID或名称
+	DefaultProxyKey string            `yaml:"default_proxy_key,omitempty"` // 未映射身份使用的代理密钥，为空则拒绝
+}
+
+// OIDCSettings 管理界面和管理API的 OpenID Connect 单点登录设置（Google、Keycloak、Azure AD 等）
+type OIDCSettings struct {
+	Enabled      bool     `yaml:"enabled"`
+	DisplayName  string   `yaml:"display_name,omitempty"`  // 登录页按钮显示的名称，默认 SSO
+	Issuer       string   `yaml:"issuer"`                  // 颁发者地址，从 {issuer}/.well-known/openid-configuration 获取端点
+	ClientID     string   `yaml:"client_id"`               // 客户端ID
+	ClientSecret string   `yaml:"client_secret,omitempty"` // 客户端密钥，公共客户端可为空（使用PKCE）
+	RedirectURL  string   `yaml:"redirect_url,omitempty"`  // 回调地址，默认根据请求推导为 {scheme}://{host}/auth/oidc/callback
+	Scopes       []string `yaml:"scopes,omitempty"`        // 默认 openid profile email
+
+	UsernameClaim string            `yaml:"username_claim,omitempty"` // 作为用户名的声明，默认依次尝试 email、preferred_username、sub
+	GroupsClaim   string            `yaml:"groups_claim,omitempty"`   // 组声明，支持点分路径（如 realm_access.roles），默认 groups
+	RoleMapping   map[string]string `yaml:"role_mapping,omitempty"`   // 组 -> 角色（viewer、operator、admin），属于多个组时取最高角色
+	DefaultRole   string            `yaml:"default_role,omitempty"`   // 没有匹配组时的角色，为空则拒绝登录
+
+	DisablePasswordLogin bool `yaml:"disable_password_login,omitempty"` // 只允许单点登录
+}
+
+// TwoFactorSettings 管理用户两步验证（TOTP）设置
+type TwoFactorSettings struct {
+	Required bool   `yaml:"required"`         // 要求所有密码登录的用户启用两步验证，未启用的用户登录后只能访问账号设置
+	Issuer   string `yaml:"issuer,omitempty"` // 身份验证器应用中显示的名称，默认 TurnsAPI
+}
+
+// BackupSettings SQLite数据库定时备份设置
+type BackupSettings struct {
+	Enabled  bool              `yaml:"enabled"`
+	Interval time.Duration     `yaml:"interval,omitempty"` // 备份间隔，默认24h
+	Dir      string            `yaml:"dir,omitempty"`      // 本地备份目录，默认为数据库所在目录下的 backups
+	Keep     int               `yaml:"keep,omitempty"`     // 本地保留的备份数，默认7
+	S3       *S3BackupSettings `yaml:"s3,omitempty"`       // 备份完成后上传到S3兼容存储，为空时只保存在本地
+}
+
+// S3BackupSettings S3兼容对象存储（AWS S3、MinIO、Cloudflare R2等）设置
+type S3BackupSettings struct {
+	Endpoint  string `yaml:"endpoint"`             // 服务地址，如 https://s3.us-east-1.amazonaws.com
+	Region    string `yaml:"region,omitempty"`     // 签名使用的区域，默认 us-east-1
+	Bucket    string `yaml:"bucket"`               // 存储桶
+	Prefix    string `yaml:"prefix,omitempty"`     // 对象键前缀，如 turnsapi/
+	AccessKey string `yaml:"access_key"`           // 支持 ${ENV_VAR} 和 file:/path 引用
+	SecretKey string `yaml:"secret_key"`           // 支持 ${ENV_VAR} 和 file:/path 引用
+	PathStyle bool   `yaml:"path_style,omitempty"` // 使用路径风格地址（endpoint/bucket/key），MinIO等需要开启
+}
+
+// validateIPEntries 校验IP或CIDR列表的格式
+func validateIPEntries(entries []string) error {
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return fmt.Errorf("invalid CIDR %q", entry)
+			}
+		} else if net.ParseIP(entry) == nil {
+			return fmt.Errorf("invalid IP address %q", entry)
+		}
+	}
+	return nil
 }
 
 // Config 应用程序配置结构
 type Config struct {
 	Server struct {
-		Port string `yaml:"port"`
-		Host string `yaml:"host"`
-		Mode string `yaml:"mode"`
+		Port           string   `yaml:"port"`
+		Host           string   `yaml:"host"`
+		Mode           string   `yaml:"mode"`
+		TrustedProxies []string `yaml:"trusted_proxies"` // 可信反向代理（IP或CIDR），只信任它们转发的 X-Forwarded-For，为空时使用TCP对端地址
+
+		// 服务器级限流，对所有分组生效，为空时不启用
+		RateLimit *ServerRateLimitSettings `yaml:"rate_limit,omitempty"`
+
+		// 启动时配置校验发现问题（如无效的轮换策略、重复的API密钥）时拒绝启动，默认只输出警告
+		StrictConfig bool `yaml:"strict_config,omitempty"`
+
+		// 同时监听Unix域套接字，供同一主机上的反向代理或客户端连接，套接字上始终使用HTTP
+		UnixSocket     string `yaml:"unix_socket,omitempty"`
+		UnixSocketMode string `yaml:"unix_socket_mode,omitempty"` // 套接字文件权限（八进制），默认 0660
+		UnixSocketOnly bool   `yaml:"unix_socket_only,omitempty"` // 只监听Unix域套接字，不监听TCP端口
+
+		// TCP端口直接提供HTTPS，为空时使用HTTP
+		TLS *ServerTLSSettings `yaml:"tls,omitempty"`
 	} `yaml:"server"`
 
 	Auth struct {
 		Enabled        bool          `yaml:"enabled"`
 		Username       string        `yaml:"username"`
 		Password       string        `yaml:"password"`
-		SessionTimeout time.Duration `yaml:"session_timeout"`
+		SessionTimeout time.Duration `yaml:"session_timeout"` // 空闲超时，每次请求后顺延
+		// 会话最长有效期，从登录时开始计算，到期后必须重新登录；为0表示不限制
+		SessionMaxLifetime time.Duration `yaml:"session_max_lifetime,omitempty"`
+
+		// 管理接口和管理界面的来源地址限制（IP或CIDR），禁止列表优先，允许列表为空表示不限制
+		AdminAllowedIPs []string `yaml:"admin_allowed_ips,omitempty"`
+		AdminDeniedIPs  []string `yaml:"admin_denied_ips,omitempty"`
+
+		// 可信头部认证，仅用于 /v1 等代理接口
+		TrustedHeader *TrustedHeaderAuth `yaml:"trusted_header,omitempty"`
+
+		// OpenID Connect 单点登录，用于管理界面和管理API
+		OIDC *OIDCSettings `yaml:"oidc,omitempty"`
+
+		// 管理用户两步验证
+		TwoFactor *TwoFactorSettings `yaml:"two_factor,omitempty"`
 	} `yaml:"auth"`
 
 	// 新的用户分组配置
@@ -64,6 +1194,15 @@ type Config struct {
 	// 监控配置
 	Monitoring *Monitoring `yaml:"monitoring,omitempty"`
 
+	// 调试设置
+	Debug *DebugSettings `yaml:"debug,omitempty"`
+
+	// 多实例共享状态
+	Redis *RedisSettings `yaml:"redis,omitempty"`
+
+	// 通过管理接口写入API密钥引用时允许的密钥目录和环境变量
+	SecretRefs *SecretRefSettings `yaml:"secret_refs,omitempty"`
+
 	// 向后兼容的旧配置结构
 	OpenRouter struct {
 		BaseURL    string        `yaml:"base_url"`
@@ -77,7 +1216,8 @@ type Config struct {
 	} `yaml:"api_keys,omitempty"`
 
 	Logging struct {
-		Level      string `yaml:"level"`
+		Level      string `yaml:"level"`  // debug、info、warn、error
+		Format     string `yaml:"format"` // text 或 json，默认 text
 		File       string `yaml:"file"`
 		MaxSize    int    `yaml:"max_size"`
 		MaxBackups int    `yaml:"max_backups"`
@@ -85,9 +1225,36 @@ type Config struct {
 	} `yaml:"logging"`
 
 	Database struct {
-		Path          string `yaml:"path"`
-		RetentionDays int    `yaml:"retention_days"`
+		Path             string                `yaml:"path"`
+		Driver           string                `yaml:"driver"` // 日志和代理密钥存储驱动：sqlite3（默认）、postgres、mysql
+		DSN              string                `yaml:"dsn"`    // postgres/mysql 连接串，多实例部署时共享同一存储
+		RetentionDays    int                   `yaml:"retention_days"`
+		LogQueueSize     int                   `yaml:"log_queue_size"`     // 异步日志队列容量，默认10000，设为-1时同步写入
+		LogBatchSize     int                   `yaml:"log_batch_size"`     // 每个事务写入的最大日志条数，默认100
+		LogFlushInterval time.Duration         `yaml:"log_flush_interval"` // 未攒满一批时的最长等待时间，默认1s
+		Redaction        *LogRedactionSettings `yaml:"redaction"`          // 请求日志脱敏规则，API密钥和Authorization头部始终脱敏
+
+		GroupRetention map[string]int `yaml:"group_retention_days"`     // 按分组覆盖保留天数，0表示永久保留
+		KeyRetention   map[string]int `yaml:"proxy_key_retention_days"` // 按代理密钥ID或名称覆盖保留天数，优先于分组
+		MaxSizeMB      int            `yaml:"max_size_mb"`              // SQLite数据库大小上限，超过时删除最早的请求日志，0表示不限制
+
+		Backup *BackupSettings `yaml:"backup,omitempty"` // SQLite数据库定时备份，为空时只能通过管理接口手动备份
 	} `yaml:"database"`
+
+	// apiKeyLabels 密钥到标签的索引，发布快照时构建，避免每条日志都遍历所有分组
+	apiKeyLabels map[string]string
+}
+
+// LogRedactionSettings 请求日志写入数据库前的脱敏设置
+type LogRedactionSettings struct {
+	Presets  []string              `yaml:"presets"`  // 启用的内置规则：email、credit_card
+	Patterns []LogRedactionPattern `yaml:"patterns"` // 自定义正则，匹配内容只保留末尾4位
+}
+
+// LogRedactionPattern 自定义脱敏正则
+type LogRedactionPattern struct {
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
 }
 
 // LoadConfig 从文件加载配置
@@ -118,6 +1285,12 @@ func LoadConfig(configPath string) (*Config, error) {
 	if config.Logging.Level == "" {
 		config.Logging.Level = "info"
 	}
+	if _, err := logging.ParseLevel(config.Logging.Level); err != nil {
+		return nil, fmt.Errorf("logging.level: %w", err)
+	}
+	if err := logging.ValidateFormat(config.Logging.Format); err != nil {
+		return nil, fmt.Errorf("logging.format: %w", err)
+	}
 	if config.Auth.Username == "" {
 		config.Auth.Username = "admin"
 	}
@@ -127,12 +1300,112 @@ func LoadConfig(configPath string) (*Config, error) {
 	if config.Auth.SessionTimeout == 0 {
 		config.Auth.SessionTimeout = 24 * time.Hour
 	}
+	if trusted := config.Auth.TrustedHeader; trusted != nil && trusted.Enabled {
+		if trusted.Header == "" {
+			trusted.Header = "X-Forwarded-User"
+		}
+		// 不限制来源时任何客户端都能伪造身份头部
+		if len(trusted.TrustedSources) == 0 {
+			return nil, fmt.Errorf("auth.trusted_header.trusted_sources is required when trusted header auth is enabled")
+		}
+	}
+	if oidc := config.Auth.OIDC; oidc != nil && oidc.Enabled {
+		if oidc.Issuer == "" || oidc.ClientID == "" {
+			return nil, fmt.Errorf("auth.oidc.issuer and auth.oidc.client_id are required when OIDC is enabled")
+		}
+		validRoles := map[string]bool{"viewer": true, "operator": true, "admin": true}
+		if oidc.DefaultRole != "" && !validRoles[oidc.DefaultRole] {
+			return nil, fmt.Errorf("auth.oidc.default_role: invalid role %q", oidc.DefaultRole)
+		}
+		for group, role := range oidc.RoleMapping {
+			if !validRoles[role] {
+				return nil, fmt.Errorf("auth.oidc.role_mapping[%s]: invalid role %q", group, role)
+			}
+		}
+	}
+	for name, entries := range map[string][]string{
+		"server.trusted_proxies": config.Server.TrustedProxies,
+		"auth.admin_allowed_ips": config.Auth.AdminAllowedIPs,
+		"auth.admin_denied_ips":  config.Auth.AdminDeniedIPs,
+	} {
+		if err := validateIPEntries(entries); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if limits := config.Server.RateLimit; limits != nil {
+		for name, rule := range map[string]RateLimitRule{
+			"global":  limits.Global,
+			"per_ip":  limits.PerIP,
+			"per_key": limits.PerKey,
+		} {
+			if rule.RequestsPerMinute < 0 || rule.Burst < 0 {
+				return nil, fmt.Errorf("server.rate_limit.%s: requests_per_minute and burst must not be negative", name)
+			}
+		}
+	}
+	if config.Server.UnixSocketOnly && config.Server.UnixSocket == "" {
+		return nil, fmt.Errorf("server.unix_socket is required when server.unix_socket_only is enabled")
+	}
+	if mode := config.Server.UnixSocketMode; mode != "" {
+		if _, err := strconv.ParseUint(mode, 8, 32); err != nil {
+			return nil, fmt.Errorf("server.unix_socket_mode: invalid file mode %q, expected octal such as 0660", mode)
+		}
+	}
+	if tlsSettings := config.Server.TLS; tlsSettings != nil {
+		if tlsSettings.CertFile == "" || tlsSettings.KeyFile == "" {
+			return nil, fmt.Errorf("server.tls: cert_file and key_file are required")
+		}
+		if tlsSettings.MinVersion != "" && tlsSettings.MinVersion != "1.2" && tlsSettings.MinVersion != "1.3" {
+			return nil, fmt.Errorf("server.tls.min_version: unsupported version %q, expected 1.2 or 1.3", tlsSettings.MinVersion)
+		}
+	}
 	if config.Database.Path == "" {
 		config.Database.Path = "data/turnsapi.db"
 	}
 	if config.Database.RetentionDays == 0 {
 		config.Database.RetentionDays = 30
 	}
+	if redis := config.Redis; redis != nil && redis.Enabled {
+		if redis.Addr == "" {
+			redis.Addr = "127.0.0.1:6379"
+		}
+		if redis.SyncInterval <= 0 {
+			redis.SyncInterval = 5 * time.Second
+		}
+	}
+	if config.Database.Driver == "" {
+		config.Database.Driver = "sqlite3"
+	}
+	if config.Database.Driver != "sqlite3" && config.Database.DSN == "" {
+		return nil, fmt.Errorf("database.dsn is required when database.driver is %s", config.Database.Driver)
+	}
+	for name, overrides := range map[string]map[string]int{
+		"group_retention_days":     config.Database.GroupRetention,
+		"proxy_key_retention_days": config.Database.KeyRetention,
+	} {
+		for target, days := range overrides {
+			if days < 0 {
+				return nil, fmt.Errorf("database.%s.%s must not be negative", name, target)
+			}
+		}
+	}
+	if config.Database.MaxSizeMB < 0 {
+		return nil, fmt.Errorf("database.max_size_mb must not be negative")
+	}
+	if config.Database.MaxSizeMB > 0 && config.Database.Driver != "sqlite3" {
+		return nil, fmt.Errorf("database.max_size_mb is only supported with the sqlite3 driver")
+	}
+	if backup := config.Database.Backup; backup != nil && backup.Enabled {
+		if config.Database.Driver != "sqlite3" {
+			return nil, fmt.Errorf("database.backup is only supported with the sqlite3 driver, use the database's own backup tools instead")
+		}
+		if backup.Interval < 0 || backup.Keep < 0 {
+			return nil, fmt.Errorf("database.backup: interval and keep must not be negative")
+		}
+		if s3 := backup.S3; s3 != nil && (s3.Endpoint == "" || s3.Bucket == "" || s3.AccessKey == "" || s3.SecretKey == "") {
+			return nil, fmt.Errorf("database.backup.s3: endpoint, bucket, access_key and secret_key are required")
+		}
+	}
 
 	// 设置全局设置默认值
 	if config.GlobalSettings == nil {
@@ -142,7 +1415,19 @@ func LoadConfig(configPath string) (*Config, error) {
 		config.GlobalSettings.DefaultRotationStrategy = "round_robin"
 	}
 	if config.GlobalSettings.DefaultTimeout == 0 {
-		config.GlobalSettings.DefaultTimeout = 30 * time.Second
+		config.GlobalSettings.DefaultTimeout = DefaultRequestTimeout
+	}
+	if config.GlobalSettings.DefaultTimeout < 0 {
+		return nil, fmt.Errorf("global_settings.default_timeout must not be negative")
+	}
+	if config.GlobalSettings.MaxRequestTimeout == 0 {
+		config.GlobalSettings.MaxRequestTimeout = DefaultMaxRequestTimeout
+	}
+	if config.GlobalSettings.MaxFanOutChoices == 0 {
+		config.GlobalSettings.MaxFanOutChoices = DefaultMaxFanOutChoices
+	}
+	if config.GlobalSettings.MaxFanOutChoices < 0 {
+		return nil, fmt.Errorf("global_settings.max_fanout_choices must not be negative")
 	}
 	if config.GlobalSettings.DefaultMaxRetries == 0 {
 		config.GlobalSettings.DefaultMaxRetries = 3
@@ -158,6 +1443,20 @@ func LoadConfig(configPath string) (*Config, error) {
 	if config.Monitoring.HealthEndpoint == "" {
 		config.Monitoring.HealthEndpoint = "/health"
 	}
+	if tracing := config.Monitoring.Tracing; tracing != nil && tracing.Enabled {
+		endpoint, err := url.Parse(tracing.Endpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return nil, fmt.Errorf("monitoring.tracing.endpoint must be an http(s) URL: %q", tracing.Endpoint)
+		}
+		if tracing.SampleRatio < 0 || tracing.SampleRatio > 1 {
+			return nil, fmt.Errorf("monitoring.tracing.sample_ratio must be between 0 and 1")
+		}
+	}
+
+	// 调试设置默认全部关闭
+	if config.Debug == nil {
+		config.Debug = &DebugSettings{}
+	}
 
 	// 向后兼容处理：如果没有用户分组配置但有旧的OpenRouter配置，则创建默认分组
 	if len(config.UserGroups) == 0 && (len(config.APIKeys.Keys) > 0 || config.OpenRouter.BaseURL != "") {
@@ -185,7 +1484,7 @@ func LoadConfig(configPath string) (*Config, error) {
 		}
 
 		// 创建默认的OpenRouter分组
-		config.UserGroups["openrouter_default"] = &UserGroup{
+		config.UserGroups[LegacyGroupID] = &UserGroup{
 			Name:             "OpenRouter (默认)",
 			ProviderType:     "openai",
 			BaseURL:          baseURL,
@@ -211,6 +1510,48 @@ func LoadConfig(configPath string) (*Config, error) {
 		if group.RotationStrategy == "" {
 			group.RotationStrategy = config.GlobalSettings.DefaultRotationStrategy
 		}
+		if err := ValidateModelRewrites(group.ModelRewrites); err != nil {
+			return nil, fmt.Errorf("user_groups.%s: %w", groupID, err)
+		}
+		if err := ValidateShadow(groupID, group.Shadow); err != nil {
+			return nil, fmt.Errorf("user_groups.%s: %w", groupID, err)
+		}
+		if err := ValidateTimeouts(group.Timeouts); err != nil {
+			return nil, fmt.Errorf("user_groups.%s: %w", groupID, err)
+		}
+		if err := ValidateRPMBurst(group.RPMLimit, group.RPMBurst); err != nil {
+			return nil, fmt.Errorf("user_groups.%s: %w", groupID, err)
+		}
+		if err := ValidateDefaultMaxTokens(group.DefaultMaxTokens); err != nil {
+			return nil, fmt.Errorf("user_groups.%s: %w", groupID, err)
+		}
+		if err := ValidateModelLimits(group.ModelLimits); err != nil {
+			return nil, fmt.Errorf("user_groups.%s: %w", groupID, err)
+		}
+		if err := ValidateHooks(group.Hooks); err != nil {
+			return nil, fmt.Errorf("user_groups.%s: %w", groupID, err)
+		}
+		if err := ValidateScript(group.Script); err != nil {
+			return nil, fmt.Errorf("user_groups.%s: %w", groupID, err)
+		}
+		if err := ValidateHealthProbe(group.HealthProbe); err != nil {
+			return nil, fmt.Errorf("user_groups.%s: %w", groupID, err)
+		}
+		if err := ValidateProxyURL(group.ProxyURL); err != nil {
+			return nil, fmt.Errorf("user_groups.%s: %w", groupID, err)
+		}
+		if err := ValidateTLSSettings(group.TLS); err != nil {
+			return nil, fmt.Errorf("user_groups.%s: %w", groupID, err)
+		}
+		if err := ValidateVertexAI(group.ProviderType, group.VertexAI); err != nil {
+			return nil, fmt.Errorf("user_groups.%s: %w", groupID, err)
+		}
+		if err := ValidateTransportSettings(group.Transport); err != nil {
+			return nil, fmt.Errorf("user_groups.%s.transport: %w", groupID, err)
+		}
+		if err := group.ResolveAPIKeys(); err != nil {
+			log.Printf("警告: 分组 %s 的API密钥引用解析失败，已跳过这些密钥: %v", groupID, err)
+		}
 		if group.Headers == nil {
 			group.Headers = make(map[string]string)
 		}
@@ -225,11 +1566,48 @@ func LoadConfig(configPath string) (*Config, error) {
 			if group.Headers["anthropic-version"] == "" {
 				group.Headers["anthropic-version"] = "2023-06-01"
 			}
+		case "openrouter":
+			// OpenRouter通过X-Title/HTTP-Referer识别调用方应用
+			// 全局归属请求头已设置X-Title或HTTP-Referer时由其统一提供
+			if group.Headers["X-Title"] == "" && config.GlobalSettings.UpstreamIdentity.IdentityHeader("X-Title") == "" {
+				group.Headers["X-Title"] = "TurnsAPI"
+			}
+			if group.Headers["HTTP-Referer"] == "" && config.GlobalSettings.UpstreamIdentity.IdentityHeader("HTTP-Referer") == "" {
+				group.Headers["HTTP-Referer"] = DefaultOpenRouterReferer
+			}
 		}
 
 		config.UserGroups[groupID] = group
 	}
 
+	if err := ValidateAutoModel(config.GlobalSettings.AutoModel); err != nil {
+		return nil, fmt.Errorf("global_settings: %w", err)
+	}
+	if err := ValidateTrafficSplits(config.GlobalSettings.TrafficSplits); err != nil {
+		return nil, fmt.Errorf("global_settings: %w", err)
+	}
+	if err := ValidateUpstreamIdentity(config.GlobalSettings.UpstreamIdentity); err != nil {
+		return nil, fmt.Errorf("global_settings: %w", err)
+	}
+	if err := ValidateTransportSettings(config.GlobalSettings.UpstreamTransport); err != nil {
+		return nil, fmt.Errorf("global_settings.upstream_transport: %w", err)
+	}
+	if err := ValidateContextLimits(config.GlobalSettings.ContextLimits); err != nil {
+		return nil, fmt.Errorf("global_settings: %w", err)
+	}
+	if err := ValidateStreamHeartbeat(config.GlobalSettings.StreamHeartbeat); err != nil {
+		return nil, fmt.Errorf("global_settings: %w", err)
+	}
+	if err := ValidateBudgetSettings(config.GlobalSettings.Budgets); err != nil {
+		return nil, fmt.Errorf("global_settings: %w", err)
+	}
+	if err := ValidateRequestQueue(config.GlobalSettings.RequestQueue); err != nil {
+		return nil, fmt.Errorf("global_settings: %w", err)
+	}
+	if err := ValidateHedging(config.GlobalSettings.Hedging); err != nil {
+		return nil, fmt.Errorf("global_settings: %w", err)
+	}
+
 	return config, nil
 }
 
@@ -238,6 +1616,14 @@ func (c *Config) GetAddress() string {
 	return fmt.Sprintf("%s:%s", c.Server.Host, c.Server.Port)
 }
 
+// LogStorage 获取日志和代理密钥存储的驱动与连接串，SQLite使用数据库文件路径
+func (c *Config) LogStorage() (driver, dsn string) {
+	if c.Database.Driver == "" || c.Database.Driver == "sqlite3" {
+		return "sqlite3", c.Database.Path
+	}
+	return c.Database.Driver, c.Database.DSN
+}
+
 // GetEnabledGroups 获取所有启用的用户分组
 func (c *Config) GetEnabledGroups() map[string]*UserGroup {
 	enabled := make(map[string]*UserGroup)
@@ -289,5 +1675,5 @@ func (c *Config) GetGroupByBaseURL(baseURL string) (*UserGroup, string) {
 
 // IsLegacyConfig 检查是否为旧版配置
 func (c *Config) IsLegacyConfig() bool {
-	return len(c.UserGroups) == 1 && c.UserGroups["openrouter_default"] != nil
+	return len(c.UserGroups) == 1 && c.UserGroups[LegacyGroupID] != nil
 }
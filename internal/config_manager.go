@@ -1,9 +1,11 @@
 package internal
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 
 	"turnsapi/internal/database"
 )
@@ -11,52 +13,393 @@ import (
 // 转换函数：从internal.UserGroup转换为database.UserGroup
 func toDBUserGroup(group *UserGroup) *database.UserGroup {
 	return &database.UserGroup{
-		Name:              group.Name,
-		ProviderType:      group.ProviderType,
-		BaseURL:           group.BaseURL,
-		Enabled:           group.Enabled,
-		Timeout:           group.Timeout,
-		MaxRetries:        group.MaxRetries,
-		RotationStrategy:  group.RotationStrategy,
-		APIKeys:           group.APIKeys,
-		Models:            group.Models,
-		Headers:           group.Headers,
-		RequestParams:     group.RequestParams,
-		ModelMappings:     group.ModelMappings,
-		UseNativeResponse: group.UseNativeResponse,
-		RPMLimit:          group.RPMLimit,
+		Name:                group.Name,
+		ProviderType:        group.ProviderType,
+		BaseURL:             group.BaseURL,
+		Enabled:             group.Enabled,
+		Timeout:             group.Timeout,
+		MaxRetries:          group.MaxRetries,
+		RotationStrategy:    group.RotationStrategy,
+		APIKeys:             group.ConfiguredAPIKeys(), // 引用的密钥保存引用本身
+		Models:              group.Models,
+		Headers:             group.Headers,
+		RequestParams:       group.RequestParams,
+		ModelMappings:       group.ModelMappings,
+		ModelRewrites:       marshalModelRewrites(group.ModelRewrites),
+		UseNativeResponse:   group.UseNativeResponse,
+		RPMLimit:            group.RPMLimit,
+		RPMBurst:            group.RPMBurst,
+		ModelLimits:         marshalModelLimits(group.ModelLimits),
+		Hooks:               marshalHooks(group.Hooks),
+		Script:              marshalScriptSettings(group.Script),
+		ChatCompletionsPath: group.ChatCompletionsPath,
+		ModelsPath:          group.ModelsPath,
+		MaxConcurrent:       group.MaxConcurrent,
+		MaxConcurrentPerKey: group.MaxConcurrentPerKey,
+		RetryPolicy:         marshalRetryPolicy(group.RetryPolicy),
+		HealthCheckModel:    group.HealthCheckModel,
+		SkipHealthCheck:     group.SkipHealthCheck,
+		Shadow:              marshalShadow(group.Shadow),
+		Timeouts:            marshalTimeouts(group.Timeouts),
+		HealthProbe:         marshalHealthProbe(group.HealthProbe),
+		ProxyURL:            group.ProxyURL,
+		TLS:                 marshalTLSSettings(group.TLS),
+		Transport:           marshalTransportSettings(group.Transport),
+		IncludeReasoning:    group.IncludeReasoning,
+		DefaultMaxTokens:    group.DefaultMaxTokens,
+		SystemPrompt:        group.SystemPrompt,
+		VertexAI:            marshalVertexAISettings(group.VertexAI),
 	}
 }
 
 // 转换函数：从database.UserGroup转换为internal.UserGroup
 func fromDBUserGroup(dbGroup *database.UserGroup) *UserGroup {
 	return &UserGroup{
-		Name:              dbGroup.Name,
-		ProviderType:      dbGroup.ProviderType,
-		BaseURL:           dbGroup.BaseURL,
-		Enabled:           dbGroup.Enabled,
-		Timeout:           dbGroup.Timeout,
-		MaxRetries:        dbGroup.MaxRetries,
-		RotationStrategy:  dbGroup.RotationStrategy,
-		APIKeys:           dbGroup.APIKeys,
-		Models:            dbGroup.Models,
-		Headers:           dbGroup.Headers,
-		RequestParams:     dbGroup.RequestParams,
-		ModelMappings:     dbGroup.ModelMappings,
-		UseNativeResponse: dbGroup.UseNativeResponse,
-		RPMLimit:          dbGroup.RPMLimit,
+		Name:                dbGroup.Name,
+		ProviderType:        dbGroup.ProviderType,
+		BaseURL:             dbGroup.BaseURL,
+		Enabled:             dbGroup.Enabled,
+		Timeout:             dbGroup.Timeout,
+		MaxRetries:          dbGroup.MaxRetries,
+		RotationStrategy:    dbGroup.RotationStrategy,
+		APIKeys:             dbGroup.APIKeys,
+		Models:              dbGroup.Models,
+		Headers:             dbGroup.Headers,
+		RequestParams:       dbGroup.RequestParams,
+		ModelMappings:       dbGroup.ModelMappings,
+		ModelRewrites:       unmarshalModelRewrites(dbGroup.ModelRewrites),
+		UseNativeResponse:   dbGroup.UseNativeResponse,
+		RPMLimit:            dbGroup.RPMLimit,
+		RPMBurst:            dbGroup.RPMBurst,
+		ModelLimits:         unmarshalModelLimits(dbGroup.ModelLimits),
+		Hooks:               unmarshalHooks(dbGroup.Hooks),
+		Script:              unmarshalScriptSettings(dbGroup.Script),
+		ChatCompletionsPath: dbGroup.ChatCompletionsPath,
+		ModelsPath:          dbGroup.ModelsPath,
+		MaxConcurrent:       dbGroup.MaxConcurrent,
+		MaxConcurrentPerKey: dbGroup.MaxConcurrentPerKey,
+		RetryPolicy:         unmarshalRetryPolicy(dbGroup.RetryPolicy),
+		HealthCheckModel:    dbGroup.HealthCheckModel,
+		SkipHealthCheck:     dbGroup.SkipHealthCheck,
+		Shadow:              unmarshalShadow(dbGroup.Shadow),
+		Timeouts:            unmarshalTimeouts(dbGroup.Timeouts),
+		HealthProbe:         unmarshalHealthProbe(dbGroup.HealthProbe),
+		ProxyURL:            dbGroup.ProxyURL,
+		TLS:                 unmarshalTLSSettings(dbGroup.TLS),
+		Transport:           unmarshalTransportSettings(dbGroup.Transport),
+		IncludeReasoning:    dbGroup.IncludeReasoning,
+		DefaultMaxTokens:    dbGroup.DefaultMaxTokens,
+		SystemPrompt:        dbGroup.SystemPrompt,
+		VertexAI:            unmarshalVertexAISettings(dbGroup.VertexAI),
 	}
 }
 
+// marshalRetryPolicy 将重试策略序列化为数据库存储的JSON
+func marshalRetryPolicy(policy *RetryPolicy) json.RawMessage {
+	if policy.IsZero() {
+		return nil
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		log.Printf("警告: 重试策略序列化失败: %v", err)
+		return nil
+	}
+	return data
+}
+
+// unmarshalRetryPolicy 从数据库存储的JSON解析重试策略
+func unmarshalRetryPolicy(data json.RawMessage) *RetryPolicy {
+	if len(data) == 0 {
+		return nil
+	}
+	var policy RetryPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		log.Printf("警告: 重试策略反序列化失败: %v", err)
+		return nil
+	}
+	return &policy
+}
+
+// marshalShadow 将影子流量设置序列化为数据库存储的JSON
+func marshalShadow(shadow *ShadowConfig) json.RawMessage {
+	if shadow == nil {
+		return nil
+	}
+	data, err := json.Marshal(shadow)
+	if err != nil {
+		log.Printf("警告: 影子流量设置序列化失败: %v", err)
+		return nil
+	}
+	return data
+}
+
+// unmarshalShadow 从数据库存储的JSON解析影子流量设置
+func unmarshalShadow(data json.RawMessage) *ShadowConfig {
+	if len(data) == 0 {
+		return nil
+	}
+	var shadow ShadowConfig
+	if err := json.Unmarshal(data, &shadow); err != nil {
+		log.Printf("警告: 影子流量设置反序列化失败: %v", err)
+		return nil
+	}
+	return &shadow
+}
+
+// marshalTimeouts 将分阶段超时序列化为数据库存储的JSON
+func marshalTimeouts(policy *TimeoutPolicy) json.RawMessage {
+	if policy.IsZero() {
+		return nil
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		log.Printf("警告: 分阶段超时序列化失败: %v", err)
+		return nil
+	}
+	return data
+}
+
+// unmarshalTimeouts 从数据库存储的JSON解析分阶段超时
+func unmarshalTimeouts(data json.RawMessage) *TimeoutPolicy {
+	if len(data) == 0 {
+		return nil
+	}
+	var policy TimeoutPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		log.Printf("警告: 分阶段超时反序列化失败: %v", err)
+		return nil
+	}
+	return &policy
+}
+
+// marshalHealthProbe 将主动健康探测设置序列化为数据库存储的JSON
+func marshalHealthProbe(probe *HealthProbe) json.RawMessage {
+	if probe == nil {
+		return nil
+	}
+	data, err := json.Marshal(probe)
+	if err != nil {
+		log.Printf("警告: 主动健康探测设置序列化失败: %v", err)
+		return nil
+	}
+	return data
+}
+
+// unmarshalHealthProbe 从数据库存储的JSON解析主动健康探测设置
+func unmarshalHealthProbe(data json.RawMessage) *HealthProbe {
+	if len(data) == 0 {
+		return nil
+	}
+	var probe HealthProbe
+	if err := json.Unmarshal(data, &probe); err != nil {
+		log.Printf("警告: 主动健康探测设置反序列化失败: %v", err)
+		return nil
+	}
+	return &probe
+}
+
+// marshalTLSSettings 将TLS设置序列化为数据库存储的JSON
+func marshalTLSSettings(settings *TLSSettings) json.RawMessage {
+	if settings.IsZero() {
+		return nil
+	}
+	data, err := json.Marshal(settings)
+	if err != nil {
+		log.Printf("警告: TLS设置序列化失败: %v", err)
+		return nil
+	}
+	return data
+}
+
+// unmarshalTLSSettings 从数据库存储的JSON解析TLS设置
+func unmarshalTLSSettings(data json.RawMessage) *TLSSettings {
+	if len(data) == 0 {
+		return nil
+	}
+	var settings TLSSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		log.Printf("警告: TLS设置反序列化失败: %v", err)
+		return nil
+	}
+	return &settings
+}
+
+// marshalVertexAISettings 将Vertex AI设置序列化为数据库存储的JSON
+func marshalVertexAISettings(settings *VertexAISettings) json.RawMessage {
+	if settings.IsZero() {
+		return nil
+	}
+	data, err := json.Marshal(settings)
+	if err != nil {
+		log.Printf("警告: Vertex AI设置序列化失败: %v", err)
+		return nil
+	}
+	return data
+}
+
+// unmarshalVertexAISettings 从数据库存储的JSON解析Vertex AI设置
+func unmarshalVertexAISettings(data json.RawMessage) *VertexAISettings {
+	if len(data) == 0 {
+		return nil
+	}
+	var settings VertexAISettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		log.Printf("警告: Vertex AI设置反序列化失败: %v", err)
+		return nil
+	}
+	return &settings
+}
+
+// marshalTransportSettings 将连接池设置序列化为数据库存储的JSON
+func marshalTransportSettings(settings *TransportSettings) json.RawMessage {
+	if settings.IsZero() {
+		return nil
+	}
+	data, err := json.Marshal(settings)
+	if err != nil {
+		log.Printf("警告: 连接池设置序列化失败: %v", err)
+		return nil
+	}
+	return data
+}
+
+// unmarshalTransportSettings 从数据库存储的JSON解析连接池设置
+func unmarshalTransportSettings(data json.RawMessage) *TransportSettings {
+	if len(data) == 0 {
+		return nil
+	}
+	var settings TransportSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		log.Printf("警告: 连接池设置反序列化失败: %v", err)
+		return nil
+	}
+	return &settings
+}
+
+// marshalModelLimits 将按模型的速率限制序列化为数据库存储的JSON
+func marshalModelLimits(limits map[string]ModelLimit) json.RawMessage {
+	if len(limits) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(limits)
+	if err != nil {
+		log.Printf("警告: 模型限流设置序列化失败: %v", err)
+		return nil
+	}
+	return data
+}
+
+// unmarshalModelLimits 从数据库存储的JSON解析按模型的速率限制
+func unmarshalModelLimits(data json.RawMessage) map[string]ModelLimit {
+	if len(data) == 0 {
+		return nil
+	}
+	var limits map[string]ModelLimit
+	if err := json.Unmarshal(data, &limits); err != nil {
+		log.Printf("警告: 模型限流设置反序列化失败: %v", err)
+		return nil
+	}
+	return limits
+}
+
+// marshalHooks 将分组启用的钩子名称序列化为数据库存储的JSON
+func marshalHooks(names []string) json.RawMessage {
+	if len(names) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(names)
+	if err != nil {
+		log.Printf("警告: 钩子设置序列化失败: %v", err)
+		return nil
+	}
+	return data
+}
+
+// unmarshalHooks 从数据库存储的JSON解析分组启用的钩子名称
+func unmarshalHooks(data json.RawMessage) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		log.Printf("警告: 钩子设置反序列化失败: %v", err)
+		return nil
+	}
+	return names
+}
+
+// marshalScriptSettings 将Lua脚本设置序列化为数据库存储的JSON
+func marshalScriptSettings(settings *ScriptSettings) json.RawMessage {
+	if settings.IsZero() {
+		return nil
+	}
+	data, err := json.Marshal(settings)
+	if err != nil {
+		log.Printf("警告: 脚本设置序列化失败: %v", err)
+		return nil
+	}
+	return data
+}
+
+// unmarshalScriptSettings 从数据库存储的JSON解析Lua脚本设置
+func unmarshalScriptSettings(data json.RawMessage) *ScriptSettings {
+	if len(data) == 0 {
+		return nil
+	}
+	var settings ScriptSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		log.Printf("警告: 脚本设置反序列化失败: %v", err)
+		return nil
+	}
+	return &settings
+}
+
+// marshalModelRewrites 将模型重写规则序列化为数据库存储的JSON
+func marshalModelRewrites(rules []ModelRewriteRule) json.RawMessage {
+	if len(rules) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		log.Printf("警告: 模型重写规则序列化失败: %v", err)
+		return nil
+	}
+	return data
+}
+
+// unmarshalModelRewrites 从数据库存储的JSON解析模型重写规则
+func unmarshalModelRewrites(data json.RawMessage) []ModelRewriteRule {
+	if len(data) == 0 {
+		return nil
+	}
+	var rules []ModelRewriteRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		log.Printf("警告: 模型重写规则反序列化失败: %v", err)
+		return nil
+	}
+	return rules
+}
+
 // ConfigManager 配置管理器，整合YAML配置和数据库存储
+// config 为受 mutex 保护的可变配置，每次修改后复制发布为只读快照，请求处理只读取快照
 type ConfigManager struct {
-	config   *Config
-	groupsDB *database.GroupsDB
-	mutex    sync.RWMutex
+	configPath     string
+	config         *Config
+	snapshot       atomic.Pointer[Config]
+	groupsDB       *database.GroupsDB
+	fallbackModels map[string][]string // 备用模型目录，整体替换，不原地修改
+	mutex          sync.RWMutex
 }
 
 // NewConfigManager 创建新的配置管理器
 func NewConfigManager(configPath string, dbPath string) (*ConfigManager, error) {
+	// 单提供商旧版本的配置文件改写为分组格式
+	if migrated, err := MigrateLegacyConfigFile(configPath); err != nil {
+		log.Printf("警告: 旧版配置文件迁移失败，将在内存中兼容旧配置: %v", err)
+	} else if migrated {
+		log.Printf("已将旧版配置文件 %s 迁移为多提供商分组格式", configPath)
+	}
+
 	// 加载YAML配置
 	config, err := LoadConfig(configPath)
 	if err != nil {
@@ -70,8 +413,9 @@ func NewConfigManager(configPath string, dbPath string) (*ConfigManager, error)
 	}
 
 	cm := &ConfigManager{
-		config:   config,
-		groupsDB: groupsDB,
+		configPath: configPath,
+		config:     config,
+		groupsDB:   groupsDB,
 	}
 
 	// 初始化数据库数据
@@ -89,9 +433,14 @@ func (cm *ConfigManager) initializeDatabase() error {
 	if err != nil {
 		return fmt.Errorf("failed to get group count: %w", err)
 	}
+	// 已归档的分组也算已有数据，避免分组全部删除后重新从YAML导入
+	archivedCount, err := cm.groupsDB.GetArchivedGroupCount()
+	if err != nil {
+		return fmt.Errorf("failed to get archived group count: %w", err)
+	}
 
 	// 如果数据库为空，从YAML配置导入数据
-	if count == 0 {
+	if count == 0 && archivedCount == 0 {
 		log.Println("数据库为空，从YAML配置导入分组数据...")
 		for groupID, group := range cm.config.UserGroups {
 			dbGroup := toDBUserGroup(group)
@@ -106,57 +455,190 @@ func (cm *ConfigManager) initializeDatabase() error {
 		log.Printf("数据库中已有 %d 个分组，跳过导入", count)
 	}
 
+	if err := cm.loadFallbackModels(); err != nil {
+		return err
+	}
+
 	// 从数据库加载所有分组配置
 	return cm.reloadFromDatabase()
 }
 
+// loadFallbackModels 加载备用模型目录，数据库为空时从YAML配置导入
+func (cm *ConfigManager) loadFallbackModels() error {
+	catalog, err := cm.groupsDB.LoadFallbackModels()
+	if err != nil {
+		return fmt.Errorf("failed to load fallback models: %w", err)
+	}
+	if len(catalog) == 0 && cm.config.GlobalSettings != nil && len(cm.config.GlobalSettings.FallbackModels) > 0 {
+		for providerType, models := range cm.config.GlobalSettings.FallbackModels {
+			if err := cm.groupsDB.ReplaceFallbackModels(providerType, models); err != nil {
+				return fmt.Errorf("failed to import fallback models for %s: %w", providerType, err)
+			}
+		}
+		log.Printf("已从YAML配置导入 %d 个提供商类型的备用模型目录", len(cm.config.GlobalSettings.FallbackModels))
+		if catalog, err = cm.groupsDB.LoadFallbackModels(); err != nil {
+			return fmt.Errorf("failed to load fallback models: %w", err)
+		}
+	}
+
+	cm.mutex.Lock()
+	cm.fallbackModels = catalog
+	cm.mutex.Unlock()
+	return nil
+}
+
+// GetFallbackModels 获取提供商类型的备用模型列表，未配置时返回空
+func (cm *ConfigManager) GetFallbackModels(providerType string) []string {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	return cm.fallbackModels[providerType]
+}
+
+// GetFallbackCatalog 获取完整的备用模型目录
+func (cm *ConfigManager) GetFallbackCatalog() map[string][]string {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	catalog := make(map[string][]string, len(cm.fallbackModels))
+	for providerType, models := range cm.fallbackModels {
+		catalog[providerType] = models
+	}
+	return catalog
+}
+
+// SetFallbackModels 替换提供商类型的备用模型列表并保存到数据库，列表为空时删除该类型
+func (cm *ConfigManager) SetFallbackModels(providerType string, models []string) error {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if err := cm.groupsDB.ReplaceFallbackModels(providerType, models); err != nil {
+		return err
+	}
+
+	catalog := make(map[string][]string, len(cm.fallbackModels)+1)
+	for existingType, existingModels := range cm.fallbackModels {
+		catalog[existingType] = existingModels
+	}
+	if len(models) == 0 {
+		delete(catalog, providerType)
+	} else {
+		catalog[providerType] = append([]string(nil), models...)
+	}
+	cm.fallbackModels = catalog
+	return nil
+}
+
 // reloadFromDatabase 从数据库重新加载分组配置
 func (cm *ConfigManager) reloadFromDatabase() error {
 	cm.mutex.Lock()
 	defer cm.mutex.Unlock()
 
+	groups, err := cm.loadGroupsFromDatabase()
+	if err != nil {
+		return err
+	}
+
+	// 更新内存中的配置
+	cm.config.UserGroups = groups
+	cm.publishLocked()
+	log.Printf("从数据库加载了 %d 个分组配置", len(groups))
+
+	return nil
+}
+
+// loadGroupsFromDatabase 从数据库读取全部分组并解析API密钥引用
+func (cm *ConfigManager) loadGroupsFromDatabase() (map[string]*UserGroup, error) {
 	dbGroups, err := cm.groupsDB.LoadAllGroups()
 	if err != nil {
-		return fmt.Errorf("failed to load groups from database: %w", err)
+		return nil, fmt.Errorf("failed to load groups from database: %w", err)
+	}
+
+	metadata, err := cm.groupsDB.LoadAllAPIKeyMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API key metadata from database: %w", err)
 	}
 
 	// 转换数据库格式到内部格式
 	groups := make(map[string]*UserGroup)
 	for groupID, dbGroup := range dbGroups {
-		groups[groupID] = fromDBUserGroup(dbGroup)
+		group := fromDBUserGroup(dbGroup)
+		if err := group.ResolveAPIKeys(); err != nil {
+			log.Printf("警告: 分组 %s 的API密钥引用解析失败，已跳过这些密钥: %v", groupID, err)
+		}
+		group.APIKeyMetadata = metadata[groupID]
+		groups[groupID] = group
 	}
+	return groups, nil
+}
 
-	// 更新内存中的配置
-	cm.config.UserGroups = groups
-	log.Printf("从数据库加载了 %d 个分组配置", len(groups))
+// publishLocked 复制当前配置并原子替换快照（调用方需持有写锁）
+func (cm *ConfigManager) publishLocked() {
+	cm.snapshot.Store(cm.config.cloneForSnapshot())
+}
 
-	return nil
+// Snapshot 获取当前配置快照，快照只读，分组变更时整体替换
+func (cm *ConfigManager) Snapshot() *Config {
+	return cm.snapshot.Load()
 }
 
-// GetConfig 获取当前配置
+// GetConfig 获取当前配置快照
+// 长期持有的组件应保存 ConfigManager 并在每次请求时调用 Snapshot，避免读取过期配置
 func (cm *ConfigManager) GetConfig() *Config {
-	cm.mutex.RLock()
-	defer cm.mutex.RUnlock()
-	return cm.config
+	return cm.Snapshot()
 }
 
 // SaveGroup 保存分组配置到数据库
 func (cm *ConfigManager) SaveGroup(groupID string, group *UserGroup) error {
+	if err := group.ResolveAPIKeys(); err != nil {
+		return fmt.Errorf("failed to resolve API key references: %w", err)
+	}
+
 	// 转换为数据库格式并保存
 	dbGroup := toDBUserGroup(group)
 	if err := cm.groupsDB.SaveGroup(groupID, dbGroup); err != nil {
 		return fmt.Errorf("failed to save group to database: %w", err)
 	}
 
-	// 更新内存中的配置
+	// 更新内存中的配置，保留的密钥沿用数据库中的标签等信息
+	saved := group.Clone()
+	cm.loadAPIKeyMetadata(groupID, saved)
 	cm.mutex.Lock()
-	cm.config.UserGroups[groupID] = group
+	cm.config.UserGroups[groupID] = saved
+	cm.publishLocked()
 	cm.mutex.Unlock()
 
 	log.Printf("分组 %s 已保存", groupID)
 	return nil
 }
 
+// SaveGroups 在一个数据库事务中保存多个分组配置，全部成功后才更新内存中的配置
+func (cm *ConfigManager) SaveGroups(groups map[string]*UserGroup) error {
+	dbGroups := make(map[string]*database.UserGroup, len(groups))
+	for groupID, group := range groups {
+		if err := group.ResolveAPIKeys(); err != nil {
+			return fmt.Errorf("group %s: failed to resolve API key references: %w", groupID, err)
+		}
+		dbGroups[groupID] = toDBUserGroup(group)
+	}
+	if err := cm.groupsDB.SaveGroups(dbGroups); err != nil {
+		return fmt.Errorf("failed to save groups to database: %w", err)
+	}
+
+	saved := make(map[string]*UserGroup, len(groups))
+	for groupID, group := range groups {
+		saved[groupID] = group.Clone()
+		cm.loadAPIKeyMetadata(groupID, saved[groupID])
+	}
+	cm.mutex.Lock()
+	for groupID, group := range saved {
+		cm.config.UserGroups[groupID] = group
+	}
+	cm.publishLocked()
+	cm.mutex.Unlock()
+
+	log.Printf("%d 个分组已保存", len(groups))
+	return nil
+}
+
 // UpdateGroup 更新分组配置
 func (cm *ConfigManager) UpdateGroup(groupID string, group *UserGroup) error {
 	cm.mutex.Lock()
@@ -166,6 +648,9 @@ func (cm *ConfigManager) UpdateGroup(groupID string, group *UserGroup) error {
 	if _, exists := cm.config.UserGroups[groupID]; !exists {
 		return fmt.Errorf("group not found: %s", groupID)
 	}
+	if err := group.ResolveAPIKeys(); err != nil {
+		return fmt.Errorf("failed to resolve API key references: %w", err)
+	}
 
 	// 转换为数据库格式并保存
 	dbGroup := toDBUserGroup(group)
@@ -173,14 +658,18 @@ func (cm *ConfigManager) UpdateGroup(groupID string, group *UserGroup) error {
 		return fmt.Errorf("failed to update group in database: %w", err)
 	}
 
-	// 更新内存中的配置
-	cm.config.UserGroups[groupID] = group
+	// 更新内存中的配置，保留的密钥沿用数据库中的标签等信息
+	updated := group.Clone()
+	cm.loadAPIKeyMetadata(groupID, updated)
+	cm.config.UserGroups[groupID] = updated
+	cm.publishLocked()
 
 	log.Printf("分组 %s 已更新", groupID)
 	return nil
 }
 
-// DeleteGroup 删除分组配置
+// DeleteGroup 删除（归档）分组配置
+// 分组在数据库中禁用并标记为已归档，从配置中移除；永久删除需通过 PurgeGroup
 func (cm *ConfigManager) DeleteGroup(groupID string) error {
 	cm.mutex.Lock()
 	defer cm.mutex.Unlock()
@@ -190,36 +679,55 @@ func (cm *ConfigManager) DeleteGroup(groupID string) error {
 		return fmt.Errorf("group not found: %s", groupID)
 	}
 
-	// 从数据库删除
-	if err := cm.groupsDB.DeleteGroup(groupID); err != nil {
-		return fmt.Errorf("failed to delete group from database: %w", err)
+	// 在数据库中归档
+	if err := cm.groupsDB.ArchiveGroup(groupID); err != nil {
+		return fmt.Errorf("failed to archive group in database: %w", err)
 	}
 
 	// 从内存中删除
 	delete(cm.config.UserGroups, groupID)
+	cm.publishLocked()
 
-	log.Printf("分组 %s 已删除", groupID)
+	log.Printf("分组 %s 已删除（归档）", groupID)
 	return nil
 }
 
-// GetGroup 获取单个分组配置
-func (cm *ConfigManager) GetGroup(groupID string) (*UserGroup, bool) {
-	cm.mutex.RLock()
-	defer cm.mutex.RUnlock()
+// ListArchivedGroups 列出已归档的分组
+func (cm *ConfigManager) ListArchivedGroups() ([]database.ArchivedGroup, error) {
+	return cm.groupsDB.ListArchivedGroups()
+}
 
-	group, exists := cm.config.UserGroups[groupID]
-	return group, exists
+// IsGroupArchived 分组ID是否属于已归档的分组，已归档分组的ID在永久删除前不能用于创建新分组
+func (cm *ConfigManager) IsGroupArchived(groupID string) (bool, error) {
+	return cm.groupsDB.IsGroupArchived(groupID)
 }
 
-// GetAllGroups 获取所有分组配置
-func (cm *ConfigManager) GetAllGroups() map[string]*UserGroup {
-	cm.mutex.RLock()
-	defer cm.mutex.RUnlock()
+// GetGroupNames 获取所有分组（包括已归档的分组）ID到名称的映射
+func (cm *ConfigManager) GetGroupNames() (map[string]string, error) {
+	return cm.groupsDB.GetGroupNames()
+}
 
-	// 创建副本以避免并发修改
-	groups := make(map[string]*UserGroup)
-	for k, v := range cm.config.UserGroups {
-		groups[k] = v
+// PurgeGroup 永久删除已归档的分组
+func (cm *ConfigManager) PurgeGroup(groupID string) error {
+	if err := cm.groupsDB.PurgeGroup(groupID); err != nil {
+		return fmt.Errorf("failed to purge group from database: %w", err)
+	}
+	log.Printf("分组 %s 已永久删除", groupID)
+	return nil
+}
+
+// GetGroup 获取单个分组配置的副本，修改后需通过 UpdateGroup 保存
+func (cm *ConfigManager) GetGroup(groupID string) (*UserGroup, bool) {
+	group, exists := cm.Snapshot().UserGroups[groupID]
+	return group.Clone(), exists
+}
+
+// GetAllGroups 获取所有分组配置的副本，修改后需通过 UpdateGroup 保存
+func (cm *ConfigManager) GetAllGroups() map[string]*UserGroup {
+	snapshot := cm.Snapshot()
+	groups := make(map[string]*UserGroup, len(snapshot.UserGroups))
+	for k, v := range snapshot.UserGroups {
+		groups[k] = v.Clone()
 	}
 	return groups
 }
@@ -229,51 +737,34 @@ func (cm *ConfigManager) GetGroupsWithMetadata() (map[string]map[string]interfac
 	return cm.groupsDB.GetGroupsWithMetadata()
 }
 
-// GetEnabledGroups 获取启用的分组
+// GetEnabledGroups 获取启用的分组配置的副本
 func (cm *ConfigManager) GetEnabledGroups() map[string]*UserGroup {
-	cm.mutex.RLock()
-	defer cm.mutex.RUnlock()
-
-	enabledGroups := make(map[string]*UserGroup)
-	for groupID, group := range cm.config.UserGroups {
-		if group.Enabled {
-			enabledGroups[groupID] = group
-		}
+	groups := cm.Snapshot().GetEnabledGroups()
+	for k, v := range groups {
+		groups[k] = v.Clone()
 	}
-	return enabledGroups
+	return groups
 }
 
 // GetGroupByModel 根据模型名称获取分组
 func (cm *ConfigManager) GetGroupByModel(model string) (*UserGroup, string) {
-	cm.mutex.RLock()
-	defer cm.mutex.RUnlock()
-
-	return cm.config.GetGroupByModel(model)
+	return cm.Snapshot().GetGroupByModel(model)
 }
 
 // IsLegacyConfig 检查是否为旧版配置
 func (cm *ConfigManager) IsLegacyConfig() bool {
-	cm.mutex.RLock()
-	defer cm.mutex.RUnlock()
-
-	return cm.config.IsLegacyConfig()
+	return cm.Snapshot().IsLegacyConfig()
 }
 
 // GetGroupCount 获取分组总数
 func (cm *ConfigManager) GetGroupCount() int {
-	cm.mutex.RLock()
-	defer cm.mutex.RUnlock()
-
-	return len(cm.config.UserGroups)
+	return len(cm.Snapshot().UserGroups)
 }
 
 // GetEnabledGroupCount 获取启用的分组数量
 func (cm *ConfigManager) GetEnabledGroupCount() int {
-	cm.mutex.RLock()
-	defer cm.mutex.RUnlock()
-
 	count := 0
-	for _, group := range cm.config.UserGroups {
+	for _, group := range cm.Snapshot().UserGroups {
 		if group.Enabled {
 			count++
 		}
@@ -315,6 +806,7 @@ func (cm *ConfigManager) ToggleGroup(groupID string) error {
 		group.Enabled = !group.Enabled
 		return fmt.Errorf("failed to toggle group in database: %w", err)
 	}
+	cm.publishLocked()
 
 	action := "enabled"
 	if !group.Enabled {
@@ -325,6 +817,113 @@ func (cm *ConfigManager) ToggleGroup(groupID string) error {
 	return nil
 }
 
+// SetGroupsEnabled 批量启用或禁用分组
+// 所有分组都必须存在，且操作后至少保留一个启用的分组，否则整批拒绝
+func (cm *ConfigManager) SetGroupsEnabled(groupIDs []string, enabled bool) error {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	defer cm.publishLocked()
+
+	targets := make(map[string]*UserGroup, len(groupIDs))
+	for _, groupID := range groupIDs {
+		group, exists := cm.config.UserGroups[groupID]
+		if !exists {
+			return fmt.Errorf("group not found: %s", groupID)
+		}
+		targets[groupID] = group
+	}
+
+	if !enabled && cm.enabledCountExcluding(targets) == 0 {
+		return fmt.Errorf("cannot disable the last enabled group")
+	}
+
+	var changed []string
+	for groupID, group := range targets {
+		if group.Enabled == enabled {
+			continue
+		}
+
+		group.Enabled = enabled
+		if err := cm.groupsDB.SaveGroup(groupID, toDBUserGroup(group)); err != nil {
+			// 回滚本批次已修改的分组
+			group.Enabled = !enabled
+			for _, changedID := range changed {
+				rollback := cm.config.UserGroups[changedID]
+				rollback.Enabled = !enabled
+				if rollbackErr := cm.groupsDB.SaveGroup(changedID, toDBUserGroup(rollback)); rollbackErr != nil {
+					log.Printf("警告: 回滚分组 %s 状态失败: %v", changedID, rollbackErr)
+				}
+			}
+			return fmt.Errorf("failed to update group %s in database: %w", groupID, err)
+		}
+		changed = append(changed, groupID)
+	}
+
+	action := "enabled"
+	if !enabled {
+		action = "disabled"
+	}
+	log.Printf("分组 %v 已批量%s", changed, action)
+	return nil
+}
+
+// DeleteGroups 批量删除（归档）分组
+// 所有分组都必须存在，且删除后至少保留一个启用的分组，否则整批拒绝；数据库中在同一个事务内归档，失败时整批不变
+func (cm *ConfigManager) DeleteGroups(groupIDs []string) error {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	targets := make(map[string]*UserGroup, len(groupIDs))
+	for _, groupID := range groupIDs {
+		group, exists := cm.config.UserGroups[groupID]
+		if !exists {
+			return fmt.Errorf("group not found: %s", groupID)
+		}
+		targets[groupID] = group
+	}
+
+	// 与单个删除保持一致：仅当要删除的分组中包含启用分组时才需要检查
+	deletingEnabled := false
+	for _, group := range targets {
+		if group.Enabled {
+			deletingEnabled = true
+			break
+		}
+	}
+	if deletingEnabled && cm.enabledCountExcluding(targets) == 0 {
+		return fmt.Errorf("cannot delete the last enabled group")
+	}
+
+	archiveIDs := make([]string, 0, len(targets))
+	for groupID := range targets {
+		archiveIDs = append(archiveIDs, groupID)
+	}
+	if err := cm.groupsDB.ArchiveGroups(archiveIDs); err != nil {
+		return fmt.Errorf("failed to archive groups in database: %w", err)
+	}
+
+	for _, groupID := range archiveIDs {
+		delete(cm.config.UserGroups, groupID)
+	}
+	cm.publishLocked()
+	log.Printf("分组 %v 已删除（归档）", archiveIDs)
+	return nil
+}
+
+// enabledCountExcluding 统计不在指定集合中的启用分组数量（调用方需持有锁）
+func (cm *ConfigManager) enabledCountExcluding(excluded map[string]*UserGroup) int {
+	count := 0
+	for groupID, group := range cm.config.UserGroups {
+		if _, skip := excluded[groupID]; skip {
+			continue
+		}
+		if group.Enabled {
+			count++
+		}
+	}
+	return count
+}
+
 // Reload 重新加载配置
 func (cm *ConfigManager) Reload() error {
 	return cm.reloadFromDatabase()
@@ -360,21 +959,42 @@ func (cm *ConfigManager) GetDatabaseStats() (map[string]interface{}, error) {
 		return nil, err
 	}
 
+	archivedGroups, err := cm.groupsDB.GetArchivedGroupCount()
+	if err != nil {
+		return nil, err
+	}
+
 	stats := map[string]interface{}{
-		"total_groups":   totalGroups,
-		"enabled_groups": enabledGroups,
+		"total_groups":    totalGroups,
+		"enabled_groups":  enabledGroups,
 		"disabled_groups": totalGroups - enabledGroups,
+		"archived_groups": archivedGroups,
 	}
 
 	return stats, nil
 }
 
-// UpdateAPIKeyValidation 更新API密钥的验证状态
+// UpdateAPIKeyValidation 更新API密钥的验证状态，通过引用解析的密钥按引用保存
 func (cm *ConfigManager) UpdateAPIKeyValidation(groupID, apiKey string, isValid bool, validationError string) error {
+	if group, exists := cm.GetGroup(groupID); exists {
+		apiKey = group.ConfiguredAPIKey(apiKey)
+	}
 	return cm.groupsDB.UpdateAPIKeyValidation(groupID, apiKey, isValid, validationError)
 }
 
-// GetAPIKeyValidationStatus 获取API密钥的验证状态
+// GetAPIKeyValidationStatus 获取API密钥的验证状态，结果按解析后的密钥索引
 func (cm *ConfigManager) GetAPIKeyValidationStatus(groupID string) (map[string]map[string]interface{}, error) {
-	return cm.groupsDB.GetAPIKeyValidationStatus(groupID)
+	result, err := cm.groupsDB.GetAPIKeyValidationStatus(groupID)
+	if err != nil {
+		return nil, err
+	}
+	if group, exists := cm.GetGroup(groupID); exists {
+		for resolved, ref := range group.APIKeyRefs {
+			if status, ok := result[ref]; ok {
+				delete(result, ref)
+				result[resolved] = status
+			}
+		}
+	}
+	return result, nil
 }
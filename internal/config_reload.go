@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+)
+
+// ReloadFile 重新读取配置文件和数据库中的分组并整体替换当前配置，用于不重启进程更新配置
+// check 在替换前校验新配置（已包含数据库中的分组），返回错误时保留当前配置
+// 返回值为已变更但需要重启才能生效的设置，这些设置在启动时已被监听端口、存储连接等组件使用
+func (cm *ConfigManager) ReloadFile(check func(*Config) error) ([]string, error) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	config, err := LoadConfig(cm.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// 分组以数据库为准，配置文件中的分组只在数据库为空时导入
+	if err := cm.groupsDB.ResetConnections(); err != nil {
+		return nil, fmt.Errorf("failed to reconnect groups database: %w", err)
+	}
+	groups, err := cm.loadGroupsFromDatabase()
+	if err != nil {
+		return nil, err
+	}
+	config.UserGroups = groups
+
+	if check != nil {
+		if err := check(config); err != nil {
+			return nil, err
+		}
+	}
+
+	restartRequired := restartRequiredChanges(cm.config, config)
+	cm.config = config
+	cm.publishLocked()
+	log.Printf("已重新加载配置文件 %s 和 %d 个分组", cm.configPath, len(groups))
+
+	return restartRequired, nil
+}
+
+// restartRequiredChanges 比较新旧配置，返回已变更但需要重启才能生效的设置
+func restartRequiredChanges(old, new *Config) []string {
+	var changed []string
+	compare := func(name string, before, after interface{}) {
+		if !reflect.DeepEqual(before, after) {
+			changed = append(changed, name)
+		}
+	}
+
+	compare("server.host", old.Server.Host, new.Server.Host)
+	compare("server.port", old.Server.Port, new.Server.Port)
+	compare("server.mode", old.Server.Mode, new.Server.Mode)
+	compare("server.trusted_proxies", old.Server.TrustedProxies, new.Server.TrustedProxies)
+	compare("server.rate_limit", old.Server.RateLimit, new.Server.RateLimit)
+	compare("server.unix_socket", old.Server.UnixSocket, new.Server.UnixSocket)
+	compare("server.unix_socket_mode", old.Server.UnixSocketMode, new.Server.UnixSocketMode)
+	compare("server.unix_socket_only", old.Server.UnixSocketOnly, new.Server.UnixSocketOnly)
+	compare("server.tls", old.Server.TLS, new.Server.TLS)
+	// 其余认证设置重新加载后立即生效；启用状态和初始账号只在启动时用于创建管理员，单点登录在启动时初始化
+	compare("auth.enabled", old.Auth.Enabled, new.Auth.Enabled)
+	compare("auth.username", old.Auth.Username, new.Auth.Username)
+	compare("auth.password", old.Auth.Password, new.Auth.Password)
+	compare("auth.oidc", old.Auth.OIDC, new.Auth.OIDC)
+	compare("redis", old.Redis, new.Redis)
+	compare("logging.file", old.Logging.File, new.Logging.File)
+	compare("database.driver", old.Database.Driver, new.Database.Driver)
+	compare("database.path", old.Database.Path, new.Database.Path)
+	compare("database.dsn", old.Database.DSN, new.Database.DSN)
+	compare("database.log_queue_size", old.Database.LogQueueSize, new.Database.LogQueueSize)
+	compare("database.log_batch_size", old.Database.LogBatchSize, new.Database.LogBatchSize)
+	compare("database.log_flush_interval", old.Database.LogFlushInterval, new.Database.LogFlushInterval)
+	compare("database.redaction", old.Database.Redaction, new.Database.Redaction)
+
+	var oldTransport, newTransport *TransportSettings
+	if old.GlobalSettings != nil {
+		oldTransport = old.GlobalSettings.UpstreamTransport
+	}
+	if new.GlobalSettings != nil {
+		newTransport = new.GlobalSettings.UpstreamTransport
+	}
+	compare("global_settings.upstream_transport", oldTransport, newTransport)
+
+	var oldMonitoring, newMonitoring Monitoring
+	if old.Monitoring != nil {
+		oldMonitoring = *old.Monitoring
+	}
+	if new.Monitoring != nil {
+		newMonitoring = *new.Monitoring
+	}
+	compare("monitoring.metrics_endpoint", oldMonitoring.MetricsEndpoint, newMonitoring.MetricsEndpoint)
+	compare("monitoring.tracing", oldMonitoring.Tracing, newMonitoring.Tracing)
+
+	return changed
+}
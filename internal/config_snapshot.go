@@ -0,0 +1,119 @@
+package internal
+
+import "turnsapi/internal/database"
+
+// ConfigSource 配置快照来源
+// 请求处理时通过 Snapshot 获取当前配置，快照一经发布不再修改，调用方不得修改其中的内容
+type ConfigSource interface {
+	Snapshot() *Config
+}
+
+// Snapshot 静态配置本身即为快照，便于测试和不需要运行时修改的场景直接传入 *Config
+func (c *Config) Snapshot() *Config {
+	return c
+}
+
+// Clone 深拷贝分组配置
+func (g *UserGroup) Clone() *UserGroup {
+	if g == nil {
+		return nil
+	}
+
+	clone := *g
+	if g.Models != nil {
+		clone.Models = append([]string(nil), g.Models...)
+	}
+	if g.APIKeys != nil {
+		clone.APIKeys = append([]string(nil), g.APIKeys...)
+	}
+	if g.APIKeyRefs != nil {
+		clone.APIKeyRefs = make(map[string]string, len(g.APIKeyRefs))
+		for k, v := range g.APIKeyRefs {
+			clone.APIKeyRefs[k] = v
+		}
+	}
+	if g.UnresolvedAPIKeys != nil {
+		clone.UnresolvedAPIKeys = append([]string(nil), g.UnresolvedAPIKeys...)
+	}
+	if g.APIKeyMetadata != nil {
+		clone.APIKeyMetadata = make(map[string]database.APIKeyMetadata, len(g.APIKeyMetadata))
+		for k, v := range g.APIKeyMetadata {
+			clone.APIKeyMetadata[k] = v
+		}
+	}
+	if g.Headers != nil {
+		clone.Headers = make(map[string]string, len(g.Headers))
+		for k, v := range g.Headers {
+			clone.Headers[k] = v
+		}
+	}
+	if g.RequestParams != nil {
+		clone.RequestParams = make(map[string]interface{}, len(g.RequestParams))
+		for k, v := range g.RequestParams {
+			clone.RequestParams[k] = v
+		}
+	}
+	if g.ModelMappings != nil {
+		clone.ModelMappings = make(map[string]string, len(g.ModelMappings))
+		for k, v := range g.ModelMappings {
+			clone.ModelMappings[k] = v
+		}
+	}
+	if g.ModelLimits != nil {
+		clone.ModelLimits = make(map[string]ModelLimit, len(g.ModelLimits))
+		for k, v := range g.ModelLimits {
+			clone.ModelLimits[k] = v
+		}
+	}
+	if g.Hooks != nil {
+		clone.Hooks = append([]string(nil), g.Hooks...)
+	}
+	if g.ModelRewrites != nil {
+		clone.ModelRewrites = append([]ModelRewriteRule(nil), g.ModelRewrites...)
+	}
+	if g.RetryPolicy != nil {
+		policy := *g.RetryPolicy
+		policy.RetryableStatusCodes = append([]int(nil), g.RetryPolicy.RetryableStatusCodes...)
+		if g.RetryPolicy.RespectRetryAfter != nil {
+			respect := *g.RetryPolicy.RespectRetryAfter
+			policy.RespectRetryAfter = &respect
+		}
+		clone.RetryPolicy = &policy
+	}
+	if g.Shadow != nil {
+		shadow := *g.Shadow
+		clone.Shadow = &shadow
+	}
+	if g.Timeouts != nil {
+		timeouts := *g.Timeouts
+		clone.Timeouts = &timeouts
+	}
+	if g.HealthProbe != nil {
+		probe := *g.HealthProbe
+		clone.HealthProbe = &probe
+	}
+	if g.TLS != nil {
+		tlsSettings := *g.TLS
+		clone.TLS = &tlsSettings
+	}
+	if g.Transport != nil {
+		transport := *g.Transport
+		clone.Transport = &transport
+	}
+	if g.Script != nil {
+		script := *g.Script
+		clone.Script = &script
+	}
+	return &clone
+}
+
+// cloneForSnapshot 复制配置用于发布快照，分组配置深拷贝，其余设置在运行时不会修改，直接共享
+func (c *Config) cloneForSnapshot() *Config {
+	snapshot := *c
+	snapshot.UserGroups = make(map[string]*UserGroup, len(c.UserGroups))
+	for groupID, group := range c.UserGroups {
+		snapshot.UserGroups[groupID] = group.Clone()
+	}
+	snapshot.apiKeyLabels = buildAPIKeyLabels(snapshot.UserGroups)
+	return &snapshot
+}
@@ -1,9 +1,16 @@
 package internal
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"turnsapi/internal/database"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -359,15 +366,9 @@ func TestGetGroupByModel(t *testing.T) {
 }
 
 func TestGetAddress(t *testing.T) {
-	config := &Config{
-		Server: struct {
-			Port string `yaml:"port"`
-			Host string `yaml:"host"`
-		}{
-			Port: "8080",
-			Host: "localhost",
-		},
-	}
+	config := &Config{}
+	config.Server.Port = "8080"
+	config.Server.Host = "localhost"
 
 	address := config.GetAddress()
 	expected := "localhost:8080"
@@ -376,3 +377,635 @@ func TestGetAddress(t *testing.T) {
 		t.Errorf("Expected address %s, got %s", expected, address)
 	}
 }
+
+func TestResolveAPIKeys(t *testing.T) {
+	secretFile, err := os.CreateTemp("", "turnsapi_secret_*")
+	if err != nil {
+		t.Fatalf("Failed to create secret file: %v", err)
+	}
+	defer os.Remove(secretFile.Name())
+	secretFile.WriteString("sk-from-file\n")
+	secretFile.Close()
+
+	t.Setenv("TURNSAPI_TEST_KEY", "sk-from-env")
+
+	fileRef := "file:" + secretFile.Name()
+	group := &UserGroup{
+		APIKeys: []string{"${TURNSAPI_TEST_KEY}", fileRef, "sk-plain", "${TURNSAPI_TEST_MISSING}"},
+	}
+
+	if err := group.ResolveAPIKeys(); err == nil {
+		t.Error("Expected error for missing environment variable")
+	}
+
+	expectedKeys := []string{"sk-from-env", "sk-from-file", "sk-plain"}
+	if len(group.APIKeys) != len(expectedKeys) {
+		t.Fatalf("Expected %d resolved keys, got %d", len(expectedKeys), len(group.APIKeys))
+	}
+	for i, key := range expectedKeys {
+		if group.APIKeys[i] != key {
+			t.Errorf("Expected key %d to be %s, got %s", i, key, group.APIKeys[i])
+		}
+	}
+
+	// 保存时写回引用，明文密钥不落盘
+	expectedConfigured := []string{"${TURNSAPI_TEST_KEY}", fileRef, "sk-plain", "${TURNSAPI_TEST_MISSING}"}
+	configured := group.ConfiguredAPIKeys()
+	if len(configured) != len(expectedConfigured) {
+		t.Fatalf("Expected %d configured keys, got %d", len(expectedConfigured), len(configured))
+	}
+	for i, key := range expectedConfigured {
+		if configured[i] != key {
+			t.Errorf("Expected configured key %d to be %s, got %s", i, key, configured[i])
+		}
+	}
+
+	// 设置环境变量后重新解析
+	t.Setenv("TURNSAPI_TEST_MISSING", "sk-late")
+	if err := group.ResolveAPIKeys(); err != nil {
+		t.Fatalf("Expected all references to resolve, got %v", err)
+	}
+	if len(group.APIKeys) != 4 || group.APIKeys[3] != "sk-late" {
+		t.Errorf("Expected late key to be resolved, got %v", group.APIKeys)
+	}
+	if len(group.UnresolvedAPIKeys) != 0 {
+		t.Errorf("Expected no unresolved keys, got %v", group.UnresolvedAPIKeys)
+	}
+	if group.ConfiguredAPIKey("sk-from-env") != "${TURNSAPI_TEST_KEY}" {
+		t.Errorf("Expected reference for resolved key, got %s", group.ConfiguredAPIKey("sk-from-env"))
+	}
+}
+
+func TestSecretRefPolicy(t *testing.T) {
+	secretsDir := t.TempDir()
+	allowedFile := filepath.Join(secretsDir, "openai_key")
+	if err := os.WriteFile(allowedFile, []byte("sk-from-file\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret file: %v", err)
+	}
+	outsideFile := filepath.Join(t.TempDir(), "other_key")
+	if err := os.WriteFile(outsideFile, []byte("sk-outside"), 0600); err != nil {
+		t.Fatalf("Failed to write secret file: %v", err)
+	}
+	// 指向目录外文件的符号链接按实际路径判断
+	link := filepath.Join(secretsDir, "link")
+	if err := os.Symlink(outsideFile, link); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	t.Setenv("TURNSAPI_TEST_ALLOWED", "sk-from-env")
+	t.Setenv("TURNSAPI_TEST_OTHER", "sk-other")
+
+	settings := &SecretRefSettings{AllowedDirs: []string{secretsDir}, AllowedEnv: []string{"TURNSAPI_TEST_ALLOWED"}}
+	admin := &SecretRefPolicy{Admin: true, Settings: settings}
+	if err := admin.Check([]string{"sk-plain", "${TURNSAPI_TEST_ALLOWED}", "file:" + allowedFile}, nil); err != nil {
+		t.Errorf("Expected allowed references to pass, got %v", err)
+	}
+	for _, ref := range []string{"${TURNSAPI_TEST_OTHER}", "file:" + outsideFile, "file:" + link, "file:" + secretsDir + "/../other_key", "file:relative/key"} {
+		if err := admin.Check([]string{ref}, nil); err == nil {
+			t.Errorf("Expected %s to be rejected", ref)
+		}
+	}
+
+	// 错误信息不能区分文件是否存在
+	missing := admin.Check([]string{"file:/nonexistent/turnsapi_key"}, nil)
+	outside := admin.Check([]string{"file:" + outsideFile}, nil)
+	if missing == nil || outside == nil || strings.Contains(missing.Error(), "no such file") ||
+		strings.Replace(missing.Error(), "/nonexistent/turnsapi_key", outsideFile, 1) != outside.Error() {
+		t.Errorf("Expected identical errors for missing and existing files, got %v and %v", missing, outside)
+	}
+
+	// 非管理员只能保留分组中已有的引用
+	operator := &SecretRefPolicy{Settings: settings}
+	if err := operator.Check([]string{"${TURNSAPI_TEST_ALLOWED}"}, nil); !errors.Is(err, ErrSecretRefForbidden) {
+		t.Errorf("Expected ErrSecretRefForbidden for operator, got %v", err)
+	}
+	if err := operator.Check([]string{"${TURNSAPI_TEST_OTHER}", "sk-new"}, []string{"${TURNSAPI_TEST_OTHER}"}); err != nil {
+		t.Errorf("Expected existing reference to be kept, got %v", err)
+	}
+
+	// 未配置 secret_refs 时管理接口不接受新的引用，本地操作不受限制
+	if err := (&SecretRefPolicy{Admin: true}).Check([]string{"${TURNSAPI_TEST_ALLOWED}"}, nil); err == nil {
+		t.Error("Expected references to be rejected without secret_refs settings")
+	}
+	var local *SecretRefPolicy
+	if err := local.Check([]string{"file:" + outsideFile}, nil); err != nil {
+		t.Errorf("Expected local policy to allow resolvable references, got %v", err)
+	}
+}
+
+func TestFallbackModelsCatalog(t *testing.T) {
+	dir := t.TempDir()
+	configPath := dir + "/config.yaml"
+	configContent := `
+user_groups:
+  claude:
+    name: "Claude"
+    provider_type: "anthropic"
+    base_url: "https://api.anthropic.com"
+    enabled: true
+    api_keys: ["sk-ant-test"]
+global_settings:
+  fallback_models:
+    anthropic: ["claude-sonnet-4-20250514", "claude-3-5-haiku-20241022"]
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cm, err := NewConfigManager(configPath, dir+"/turnsapi.db")
+	if err != nil {
+		t.Fatalf("Failed to create config manager: %v", err)
+	}
+	models := cm.GetFallbackModels("anthropic")
+	if len(models) != 2 || models[0] != "claude-sonnet-4-20250514" {
+		t.Fatalf("Expected fallback models imported from YAML, got %v", models)
+	}
+
+	if err := cm.SetFallbackModels("gemini", []string{"gemini-2.5-pro"}); err != nil {
+		t.Fatalf("SetFallbackModels failed: %v", err)
+	}
+	if err := cm.SetFallbackModels("anthropic", nil); err != nil {
+		t.Fatalf("SetFallbackModels failed: %v", err)
+	}
+	cm.Close()
+
+	// 重启后使用数据库中的目录，不再从YAML导入
+	cm, err = NewConfigManager(configPath, dir+"/turnsapi.db")
+	if err != nil {
+		t.Fatalf("Failed to reopen config manager: %v", err)
+	}
+	defer cm.Close()
+	catalog := cm.GetFallbackCatalog()
+	if len(catalog) != 1 || len(catalog["gemini"]) != 1 || catalog["anthropic"] != nil {
+		t.Errorf("Expected only the gemini catalog to persist, got %v", catalog)
+	}
+}
+
+func TestConfigManagerReloadFile(t *testing.T) {
+	dir := t.TempDir()
+	configPath := dir + "/config.yaml"
+	configContent := `
+server:
+  port: "8080"
+auth:
+  session_timeout: 1h
+global_settings:
+  default_rotation_strategy: "round_robin"
+user_groups:
+  openai:
+    name: "OpenAI"
+    provider_type: "openai"
+    base_url: "https://api.openai.com/v1"
+    enabled: true
+    api_keys: ["sk-test"]
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cm, err := NewConfigManager(configPath, dir+"/turnsapi.db")
+	if err != nil {
+		t.Fatalf("Failed to create config manager: %v", err)
+	}
+	defer cm.Close()
+
+	// 配置文件中的分组只在数据库为空时导入，重新加载后仍以数据库为准
+	updated := strings.Replace(configContent, `port: "8080"`, `port: "9090"`, 1)
+	updated = strings.Replace(updated, `"round_robin"`, `"random"`, 1)
+	updated = strings.Replace(updated, `name: "OpenAI"`, `name: "Renamed"`, 1)
+	updated = strings.Replace(updated, `session_timeout: 1h`, `session_timeout: 2h`, 1)
+	if err := os.WriteFile(configPath, []byte(updated), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	// 校验失败时保留当前配置
+	if _, err := cm.ReloadFile(func(*Config) error { return errors.New("rejected") }); err == nil {
+		t.Fatal("Expected ReloadFile to return the check error")
+	}
+	if cm.Snapshot().GlobalSettings.DefaultRotationStrategy != "round_robin" {
+		t.Error("Expected rejected reload to keep the current config")
+	}
+
+	restartRequired, err := cm.ReloadFile(nil)
+	if err != nil {
+		t.Fatalf("ReloadFile failed: %v", err)
+	}
+	config := cm.Snapshot()
+	if config.GlobalSettings.DefaultRotationStrategy != "random" {
+		t.Errorf("Expected reloaded rotation strategy, got %s", config.GlobalSettings.DefaultRotationStrategy)
+	}
+	if group := config.UserGroups["openai"]; group == nil || group.Name != "OpenAI" {
+		t.Errorf("Expected groups to be loaded from the database, got %+v", group)
+	}
+	if len(restartRequired) != 1 || restartRequired[0] != "server.port" {
+		t.Errorf("Expected only server.port to require a restart, got %v", restartRequired)
+	}
+	if config.Auth.SessionTimeout != 2*time.Hour {
+		t.Errorf("Expected reloaded session timeout, got %s", config.Auth.SessionTimeout)
+	}
+
+	// 单点登录在启动时初始化，变更后需要重启
+	withOIDC := *config
+	withOIDC.Auth.OIDC = &OIDCSettings{Enabled: true, Issuer: "https://sso.example.com"}
+	if changed := restartRequiredChanges(config, &withOIDC); len(changed) != 1 || changed[0] != "auth.oidc" {
+		t.Errorf("Expected auth.oidc to require a restart, got %v", changed)
+	}
+}
+
+func TestGroupTemplates(t *testing.T) {
+	dir := t.TempDir()
+	configPath := dir + "/config.yaml"
+	if err := os.WriteFile(configPath, []byte("server:\n  port: \"8080\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cm, err := NewConfigManager(configPath, dir+"/turnsapi.db")
+	if err != nil {
+		t.Fatalf("Failed to create config manager: %v", err)
+	}
+	defer cm.Close()
+
+	group := &UserGroup{
+		Name:         "OpenRouter",
+		ProviderType: "openrouter",
+		BaseURL:      "https://openrouter.ai/api/v1",
+		Timeout:      45 * time.Second,
+		Models:       []string{"openai/gpt-4o", "anthropic/claude-3.5-sonnet"},
+		APIKeys:      []string{"sk-or-secret"},
+		Headers:      map[string]string{"HTTP-Referer": "https://example.com"},
+	}
+	if err := cm.SaveGroupTemplate(&GroupTemplate{ID: "openrouter", Group: group}); err == nil {
+		t.Error("Expected built-in template IDs to be rejected")
+	}
+	if err := cm.SaveGroupTemplate(&GroupTemplate{ID: "my-openrouter", Group: group}); err != nil {
+		t.Fatalf("SaveGroupTemplate failed: %v", err)
+	}
+
+	templates, err := cm.ListGroupTemplates()
+	if err != nil {
+		t.Fatalf("ListGroupTemplates failed: %v", err)
+	}
+	if len(templates) != len(builtinGroupTemplates)+1 || !templates[0].Builtin {
+		t.Fatalf("Expected built-in templates followed by the saved template, got %d templates", len(templates))
+	}
+
+	saved, err := cm.GetGroupTemplate("my-openrouter")
+	if err != nil || saved == nil {
+		t.Fatalf("GetGroupTemplate failed: %v", err)
+	}
+	if saved.Builtin || saved.Name != "OpenRouter" || len(saved.Group.APIKeys) != 0 {
+		t.Errorf("Expected the saved template without API keys, got %+v", saved)
+	}
+	if saved.Group.Timeout != 45*time.Second || len(saved.Group.Models) != 2 || saved.Group.Headers["HTTP-Referer"] != "https://example.com" {
+		t.Errorf("Expected the template group settings to round trip, got %+v", saved.Group)
+	}
+	if len(group.APIKeys) != 1 {
+		t.Error("Expected SaveGroupTemplate to leave the source group unchanged")
+	}
+
+	if err := cm.DeleteGroupTemplate("gemini"); err == nil {
+		t.Error("Expected built-in templates to be undeletable")
+	}
+	if err := cm.DeleteGroupTemplate("my-openrouter"); err != nil {
+		t.Fatalf("DeleteGroupTemplate failed: %v", err)
+	}
+	if template, err := cm.GetGroupTemplate("my-openrouter"); err != nil || template != nil {
+		t.Errorf("Expected the template to be deleted, got %+v (err: %v)", template, err)
+	}
+}
+
+func TestAPIKeyMetadata(t *testing.T) {
+	dir := t.TempDir()
+	configPath := dir + "/config.yaml"
+	if err := os.WriteFile(configPath, []byte("server:\n  port: \"8080\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cm, err := NewConfigManager(configPath, dir+"/turnsapi.db")
+	if err != nil {
+		t.Fatalf("Failed to create config manager: %v", err)
+	}
+	defer cm.Close()
+
+	group := &UserGroup{
+		Name:         "OpenAI",
+		ProviderType: "openai",
+		BaseURL:      "https://api.openai.com/v1",
+		Enabled:      true,
+		Timeout:      30 * time.Second,
+		APIKeys:      []string{"sk-key-one-0001", "sk-key-two-0002"},
+	}
+	if err := cm.SaveGroup("openai", group); err != nil {
+		t.Fatalf("SaveGroup failed: %v", err)
+	}
+
+	expiresAt, err := ParseAPIKeyExpiry("2030-01-31")
+	if err != nil || expiresAt == nil || expiresAt.Day() != 31 ||
+		expiresAt.Hour() != 23 || expiresAt.Minute() != 59 || expiresAt.Second() != 59 {
+		t.Fatalf("Expected date expiry at the end of the day, got %v (%v)", expiresAt, err)
+	}
+	if _, err := ParseAPIKeyExpiry("31/01/2030"); err == nil {
+		t.Error("Expected invalid expiry format to be rejected")
+	}
+
+	meta := database.APIKeyMetadata{Label: "team-a-prod", Owner: "team-a", ExpiresAt: expiresAt, Note: "billing account A"}
+	if _, err := cm.SetAPIKeyMetadata("openai", "sk-key-one-0001", meta); err != nil {
+		t.Fatalf("SetAPIKeyMetadata failed: %v", err)
+	}
+	if _, err := cm.SetAPIKeyMetadata("openai", "sk-unknown", meta); err == nil {
+		t.Error("Expected metadata for a key outside the group to be rejected")
+	}
+	if _, err := cm.SetAPIKeyMetadata("openai", "sk-key-two-0002", database.APIKeyMetadata{Label: strings.Repeat("x", 65)}); err == nil {
+		t.Error("Expected overlong label to be rejected")
+	}
+	if label := cm.Snapshot().APIKeyLabel("sk-key-one-0001"); label != "team-a-prod" {
+		t.Errorf("Expected label team-a-prod, got %q", label)
+	}
+	unpublished := &Config{UserGroups: cm.Snapshot().UserGroups}
+	if label := unpublished.APIKeyLabel("sk-key-one-0001"); label != "team-a-prod" {
+		t.Errorf("Expected label lookup without a published index, got %q", label)
+	}
+
+	// 重新保存分组时保留的密钥沿用原有信息
+	updated := group.Clone()
+	updated.APIKeyMetadata = nil
+	updated.APIKeys = []string{"sk-key-two-0002", "sk-key-one-0001", "sk-key-three-0003"}
+	if err := cm.UpdateGroup("openai", updated); err != nil {
+		t.Fatalf("UpdateGroup failed: %v", err)
+	}
+	if err := cm.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	reloaded, _ := cm.GetGroup("openai")
+	kept := reloaded.KeyMetadata("sk-key-one-0001")
+	if kept.Label != "team-a-prod" || kept.Owner != "team-a" || kept.Note != "billing account A" ||
+		kept.ExpiresAt == nil || !kept.ExpiresAt.Equal(*expiresAt) {
+		t.Errorf("Expected metadata to survive re-saving the group, got %+v", kept)
+	}
+	if !reloaded.KeyMetadata("sk-key-three-0003").IsZero() {
+		t.Error("Expected new key to have no metadata")
+	}
+
+	status, err := cm.GetAPIKeyValidationStatus("openai")
+	if err != nil {
+		t.Fatalf("GetAPIKeyValidationStatus failed: %v", err)
+	}
+	if status["sk-key-one-0001"]["label"] != "team-a-prod" {
+		t.Errorf("Expected label in key validation status, got %v", status["sk-key-one-0001"]["label"])
+	}
+
+	// 清除信息
+	if _, err := cm.SetAPIKeyMetadata("openai", "sk-key-one-0001", database.APIKeyMetadata{}); err != nil {
+		t.Fatalf("SetAPIKeyMetadata failed: %v", err)
+	}
+	if label := cm.Snapshot().APIKeyLabel("sk-key-one-0001"); label != "" {
+		t.Errorf("Expected label to be cleared, got %q", label)
+	}
+}
+
+// newBatchTestConfigManager 创建包含三个启用分组的配置管理器
+func newBatchTestConfigManager(t *testing.T) *ConfigManager {
+	t.Helper()
+	dir := t.TempDir()
+	configPath := dir + "/config.yaml"
+	if err := os.WriteFile(configPath, []byte("server:\n  port: \"8080\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cm, err := NewConfigManager(configPath, dir+"/turnsapi.db")
+	if err != nil {
+		t.Fatalf("Failed to create config manager: %v", err)
+	}
+	t.Cleanup(func() { cm.Close() })
+
+	for _, groupID := range []string{"a", "b", "c"} {
+		if err := cm.SaveGroup(groupID, &UserGroup{
+			Name:         groupID,
+			ProviderType: "openai",
+			BaseURL:      "https://api.openai.com/v1",
+			Enabled:      true,
+			Timeout:      30 * time.Second,
+			APIKeys:      []string{"sk-" + groupID},
+		}); err != nil {
+			t.Fatalf("SaveGroup failed: %v", err)
+		}
+	}
+	return cm
+}
+
+func TestSetGroupsEnabled(t *testing.T) {
+	cm := newBatchTestConfigManager(t)
+
+	if err := cm.SetGroupsEnabled([]string{"a", "missing"}, false); err == nil {
+		t.Fatal("Expected a batch with an unknown group to be rejected")
+	}
+	if group, _ := cm.GetGroup("a"); !group.Enabled {
+		t.Error("Expected a rejected batch to leave every group unchanged")
+	}
+
+	if err := cm.SetGroupsEnabled([]string{"a", "b", "c"}, false); err == nil {
+		t.Fatal("Expected disabling every group to be rejected")
+	}
+
+	if err := cm.SetGroupsEnabled([]string{"a", "b"}, false); err != nil {
+		t.Fatalf("SetGroupsEnabled failed: %v", err)
+	}
+	config := cm.Snapshot()
+	if config.UserGroups["a"].Enabled || config.UserGroups["b"].Enabled || !config.UserGroups["c"].Enabled {
+		t.Errorf("Expected only a and b to be disabled in the published snapshot")
+	}
+
+	groups, err := cm.groupsDB.LoadAllGroups()
+	if err != nil {
+		t.Fatalf("LoadAllGroups failed: %v", err)
+	}
+	if groups["a"].Enabled || groups["b"].Enabled {
+		t.Error("Expected the disabled state to be persisted")
+	}
+}
+
+func TestDeleteGroups(t *testing.T) {
+	cm := newBatchTestConfigManager(t)
+
+	if err := cm.DeleteGroups([]string{"a", "missing"}); err == nil {
+		t.Fatal("Expected a batch with an unknown group to be rejected")
+	}
+	if err := cm.DeleteGroups([]string{"a", "b", "c"}); err == nil {
+		t.Fatal("Expected deleting the last enabled group to be rejected")
+	}
+	for _, groupID := range []string{"a", "b", "c"} {
+		if archived, _ := cm.groupsDB.IsGroupArchived(groupID); archived {
+			t.Errorf("Expected rejected batches to leave %s unarchived", groupID)
+		}
+	}
+
+	if err := cm.DeleteGroups([]string{"a", "b"}); err != nil {
+		t.Fatalf("DeleteGroups failed: %v", err)
+	}
+	config := cm.Snapshot()
+	if _, exists := config.UserGroups["a"]; exists {
+		t.Error("Expected deleted groups to be removed from the published snapshot")
+	}
+	if _, exists := config.UserGroups["c"]; !exists {
+		t.Error("Expected groups outside the batch to remain")
+	}
+	for _, groupID := range []string{"a", "b"} {
+		if archived, err := cm.groupsDB.IsGroupArchived(groupID); err != nil || !archived {
+			t.Errorf("Expected %s to be archived (err: %v)", groupID, err)
+		}
+	}
+}
+
+// Run with -race: readers must never share group values with concurrent writers.
+func TestConcurrentSaveGroupAndSnapshot(t *testing.T) {
+	cm := newBatchTestConfigManager(t)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			if err := cm.SaveGroup("a", &UserGroup{
+				Name:         fmt.Sprintf("a-%d", i),
+				ProviderType: "openai",
+				BaseURL:      "https://api.openai.com/v1",
+				Enabled:      true,
+				Timeout:      30 * time.Second,
+				APIKeys:      []string{"sk-a"},
+				Models:       []string{fmt.Sprintf("model-%d", i)},
+			}); err != nil {
+				t.Errorf("SaveGroup failed: %v", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			for _, group := range cm.Snapshot().UserGroups {
+				_ = group.Name + strings.Join(group.Models, ",")
+			}
+			for _, group := range cm.GetAllGroups() {
+				group.Models = append(group.Models, "local")
+			}
+			if group, exists := cm.GetGroup("b"); exists {
+				group.APIKeys[0] = "sk-local"
+			}
+		}
+	}()
+	wg.Wait()
+
+	if group, _ := cm.GetGroup("a"); group.Name != "a-49" {
+		t.Errorf("Expected the last save to win, got %q", group.Name)
+	}
+	for groupID, group := range cm.Snapshot().UserGroups {
+		for _, model := range group.Models {
+			if model == "local" {
+				t.Errorf("Expected changes to returned groups not to leak into the snapshot of %s", groupID)
+			}
+		}
+	}
+	if group, _ := cm.GetGroup("b"); group.APIKeys[0] != "sk-b" {
+		t.Errorf("Expected changes to a returned group not to leak into the config, got %q", group.APIKeys[0])
+	}
+}
+
+func TestProviderHealthCheckModel(t *testing.T) {
+	tests := []struct {
+		group *UserGroup
+		want  string
+	}{
+		{&UserGroup{ProviderType: "gemini", Models: []string{"gemini-2.5-pro"}}, "gemini-2.5-pro"},
+		{&UserGroup{ProviderType: "gemini", HealthCheckModel: "gemini-2.5-flash", Models: []string{"gemini-2.5-pro"}}, "gemini-2.5-flash"},
+		{&UserGroup{ProviderType: "openai", Models: []string{"gpt-4o"}}, ""},
+		{&UserGroup{ProviderType: "anthropic", HealthCheckModel: "claude-3-5-haiku-latest"}, "claude-3-5-haiku-latest"},
+	}
+	for _, tt := range tests {
+		if got := tt.group.ProviderHealthCheckModel(); got != tt.want {
+			t.Errorf("ProviderHealthCheckModel() for %s group = %q, want %q", tt.group.ProviderType, got, tt.want)
+		}
+	}
+}
+
+func TestOpenRouterDefaultHeaders(t *testing.T) {
+	configPath := t.TempDir() + "/config.yaml"
+	configContent := `
+user_groups:
+  openrouter_a:
+    name: "OpenRouter A"
+    provider_type: "openrouter"
+    base_url: "https://openrouter.ai/api/v1"
+    enabled: true
+    api_keys: ["sk-or-test"]
+  openrouter_b:
+    name: "OpenRouter B"
+    provider_type: "openrouter"
+    base_url: "https://openrouter.ai/api/v1"
+    enabled: true
+    api_keys: ["sk-or-test"]
+    headers:
+      HTTP-Referer: "https://chat.example.com"
+  openai:
+    name: "OpenAI"
+    provider_type: "openai"
+    base_url: "https://api.openai.com/v1"
+    enabled: true
+    api_keys: ["sk-test"]
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if headers := config.UserGroups["openrouter_a"].Headers; headers["HTTP-Referer"] != DefaultOpenRouterReferer || headers["X-Title"] != "TurnsAPI" {
+		t.Errorf("Expected default OpenRouter attribution headers, got %v", headers)
+	}
+	if referer := config.UserGroups["openrouter_b"].Headers["HTTP-Referer"]; referer != "https://chat.example.com" {
+		t.Errorf("Expected configured HTTP-Referer to be kept, got %q", referer)
+	}
+	if headers := config.UserGroups["openai"].Headers; headers["HTTP-Referer"] != "" || headers["X-Title"] != "" {
+		t.Errorf("Expected no OpenRouter headers on other providers, got %v", headers)
+	}
+}
+
+func TestResolveModelRewrites(t *testing.T) {
+	rules := []ModelRewriteRule{
+		{Pattern: `^claude-3-5-sonnet-(\d+)$`, Replacement: "anthropic/claude-3.5-sonnet-$1"},
+		{Pattern: `^(?P<family>gpt-4o)(-.*)?$`, Replacement: "${family}"},
+		{Pattern: `^gpt-`, Replacement: "gpt-4o-mini"},
+	}
+	if err := ValidateModelRewrites(rules); err != nil {
+		t.Fatalf("Expected valid rules, got %v", err)
+	}
+	group := &UserGroup{
+		ModelMappings: map[string]string{"gpt-4o-latest": "gpt-4o-2024-11-20"},
+		ModelRewrites: rules,
+	}
+
+	tests := []struct {
+		model     string
+		want      string
+		source    string
+		ruleIndex int
+	}{
+		{"claude-3-5-sonnet-20241022", "anthropic/claude-3.5-sonnet-20241022", ModelSourceRewrite, 0},
+		{"gpt-4o-2024-08-06", "gpt-4o", ModelSourceRewrite, 1},
+		{"gpt-3.5-turbo", "gpt-4o-mini", ModelSourceRewrite, 2},
+		{"gpt-4o-latest", "gpt-4o-2024-11-20", ModelSourceMapping, -1},
+		{"gemini-pro", "gemini-pro", "", -1},
+	}
+	for _, tt := range tests {
+		got := group.ResolveModel(tt.model)
+		if got.Model != tt.want || got.Source != tt.source || got.RuleIndex != tt.ruleIndex {
+			t.Errorf("ResolveModel(%q) = %+v, want model=%q source=%q rule=%d", tt.model, got, tt.want, tt.source, tt.ruleIndex)
+		}
+	}
+
+	for _, invalid := range [][]ModelRewriteRule{
+		{{Pattern: "(", Replacement: "x"}},
+		{{Pattern: "", Replacement: "x"}},
+		{{Pattern: "^gpt-", Replacement: ""}},
+	} {
+		if err := ValidateModelRewrites(invalid); err == nil {
+			t.Errorf("Expected rules %+v to be rejected", invalid)
+		}
+	}
+}
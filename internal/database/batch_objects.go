@@ -0,0 +1,90 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// BatchObjectRecord 上游批处理任务或文件与创建它的分组、密钥的对应关系
+// 批处理和文件只能用创建时的密钥访问，后续查询、取消和下载结果需要路由到同一个分组和密钥
+type BatchObjectRecord struct {
+	ObjectID   string
+	ObjectType string // "batch" 或 "file"
+	GroupID    string
+	APIKey     string
+	ProxyKeyID string
+	CreatedAt  time.Time
+}
+
+// createBatchObjectsTable 创建批处理对象归属表
+func (gdb *GroupsDB) createBatchObjectsTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS batch_objects (
+		object_id TEXT PRIMARY KEY,
+		object_type TEXT NOT NULL,
+		group_id TEXT NOT NULL,
+		api_key TEXT NOT NULL DEFAULT '',
+		proxy_key_id TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);`
+
+	if _, err := gdb.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create batch_objects table: %w", err)
+	}
+	if _, err := gdb.db.Exec(`CREATE INDEX IF NOT EXISTS idx_batch_objects_proxy_key ON batch_objects(proxy_key_id, object_type, created_at)`); err != nil {
+		return fmt.Errorf("failed to create batch_objects index: %w", err)
+	}
+	return nil
+}
+
+// SaveBatchObject 记录批处理对象的归属，已存在时保持原有记录
+func (gdb *GroupsDB) SaveBatchObject(record BatchObjectRecord) error {
+	query := `
+	INSERT INTO batch_objects (object_id, object_type, group_id, api_key, proxy_key_id, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(object_id) DO NOTHING`
+
+	if _, err := gdb.db.Exec(query, record.ObjectID, record.ObjectType, record.GroupID,
+		record.APIKey, record.ProxyKeyID, record.CreatedAt); err != nil {
+		return fmt.Errorf("failed to save batch object: %w", err)
+	}
+	return nil
+}
+
+// GetBatchObject 获取批处理对象的归属，不存在时返回 nil
+func (gdb *GroupsDB) GetBatchObject(objectID string) (*BatchObjectRecord, error) {
+	var record BatchObjectRecord
+	err := gdb.db.QueryRow(`SELECT object_id, object_type, group_id, api_key, proxy_key_id, created_at FROM batch_objects WHERE object_id = ?`, objectID).
+		Scan(&record.ObjectID, &record.ObjectType, &record.GroupID, &record.APIKey, &record.ProxyKeyID, &record.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query batch object: %w", err)
+	}
+	return &record, nil
+}
+
+// LatestBatchObject 获取代理密钥最近创建的指定类型对象，不存在时返回 nil
+func (gdb *GroupsDB) LatestBatchObject(proxyKeyID, objectType string) (*BatchObjectRecord, error) {
+	var record BatchObjectRecord
+	err := gdb.db.QueryRow(`SELECT object_id, object_type, group_id, api_key, proxy_key_id, created_at FROM batch_objects
+		WHERE proxy_key_id = ? AND object_type = ? ORDER BY created_at DESC LIMIT 1`, proxyKeyID, objectType).
+		Scan(&record.ObjectID, &record.ObjectType, &record.GroupID, &record.APIKey, &record.ProxyKeyID, &record.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query batch object: %w", err)
+	}
+	return &record, nil
+}
+
+// DeleteBatchObject 删除批处理对象的归属记录
+func (gdb *GroupsDB) DeleteBatchObject(objectID string) error {
+	if _, err := gdb.db.Exec(`DELETE FROM batch_objects WHERE object_id = ?`, objectID); err != nil {
+		return fmt.Errorf("failed to delete batch object: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"fmt"
+)
+
+// createFallbackModelsTable 创建备用模型目录表
+// 分组未配置模型列表且无法从上游获取时，按提供商类型使用这里的模型列表
+func (gdb *GroupsDB) createFallbackModelsTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS fallback_models (
+		provider_type TEXT NOT NULL,
+		model_id TEXT NOT NULL,
+		position INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (provider_type, model_id)
+	);`
+
+	if _, err := gdb.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create fallback_models table: %w", err)
+	}
+	return nil
+}
+
+// LoadFallbackModels 加载备用模型目录：提供商类型 -> 模型列表
+func (gdb *GroupsDB) LoadFallbackModels() (map[string][]string, error) {
+	rows, err := gdb.db.Query(`SELECT provider_type, model_id FROM fallback_models ORDER BY provider_type, position`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fallback models: %w", err)
+	}
+	defer rows.Close()
+
+	catalog := make(map[string][]string)
+	for rows.Next() {
+		var providerType, modelID string
+		if err := rows.Scan(&providerType, &modelID); err != nil {
+			return nil, fmt.Errorf("failed to scan fallback model: %w", err)
+		}
+		catalog[providerType] = append(catalog[providerType], modelID)
+	}
+	return catalog, rows.Err()
+}
+
+// ReplaceFallbackModels 替换提供商类型的备用模型列表，列表为空时删除该类型
+func (gdb *GroupsDB) ReplaceFallbackModels(providerType string, models []string) error {
+	tx, err := gdb.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM fallback_models WHERE provider_type = ?`, providerType); err != nil {
+		return fmt.Errorf("failed to delete fallback models: %w", err)
+	}
+	for i, modelID := range models {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO fallback_models (provider_type, model_id, position) VALUES (?, ?, ?)`,
+			providerType, modelID, i); err != nil {
+			return fmt.Errorf("failed to insert fallback model: %w", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrArchivedGroupNotFound 分组不存在或未归档
+var ErrArchivedGroupNotFound = errors.New("archived group not found")
+
+// ArchivedGroup 已归档（软删除）的分组
+type ArchivedGroup struct {
+	GroupID      string    `json:"group_id"`
+	Name         string    `json:"name"`
+	ProviderType string    `json:"provider_type"`
+	ArchivedAt   time.Time `json:"archived_at"`
+}
+
+// ArchiveGroup 归档分组：禁用并标记为已归档，保留分组配置和密钥，历史日志和统计仍可按分组ID查到分组名称
+// 归档后的分组不再加载到配置中，重新保存同一ID的分组时取消归档
+func (gdb *GroupsDB) ArchiveGroup(groupID string) error {
+	return gdb.ArchiveGroups([]string{groupID})
+}
+
+// ArchiveGroups 在同一个事务中归档多个分组，任一分组不存在或归档失败时整批回滚
+func (gdb *GroupsDB) ArchiveGroups(groupIDs []string) error {
+	tx, err := gdb.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	archivedAt := time.Now()
+	for _, groupID := range groupIDs {
+		result, err := tx.Exec(`UPDATE provider_groups SET enabled = 0, archived_at = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE group_id = ? AND archived_at IS NULL`, archivedAt, groupID)
+		if err != nil {
+			return fmt.Errorf("failed to archive group %s: %w", groupID, err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if rowsAffected == 0 {
+			return fmt.Errorf("group not found: %s", groupID)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Printf("分组 %v 已归档", groupIDs)
+	return nil
+}
+
+// ListArchivedGroups 列出已归档的分组，最近归档的在前
+func (gdb *GroupsDB) ListArchivedGroups() ([]ArchivedGroup, error) {
+	rows, err := gdb.db.Query(`SELECT group_id, name, provider_type, archived_at FROM provider_groups
+	WHERE archived_at IS NOT NULL ORDER BY archived_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archived groups: %w", err)
+	}
+	defer rows.Close()
+
+	groups := []ArchivedGroup{}
+	for rows.Next() {
+		var group ArchivedGroup
+		if err := rows.Scan(&group.GroupID, &group.Name, &group.ProviderType, &group.ArchivedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan archived group: %w", err)
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// IsGroupArchived 分组ID是否属于已归档的分组
+func (gdb *GroupsDB) IsGroupArchived(groupID string) (bool, error) {
+	var count int
+	if err := gdb.db.QueryRow(`SELECT COUNT(*) FROM provider_groups WHERE group_id = ? AND archived_at IS NOT NULL`, groupID).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check archived group: %w", err)
+	}
+	return count > 0, nil
+}
+
+// GetArchivedGroupCount 获取已归档的分组数量
+func (gdb *GroupsDB) GetArchivedGroupCount() (int, error) {
+	var count int
+	if err := gdb.db.QueryRow(`SELECT COUNT(*) FROM provider_groups WHERE archived_at IS NOT NULL`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to get archived group count: %w", err)
+	}
+	return count, nil
+}
+
+// GetGroupNames 获取所有分组（包括已归档的分组）ID到名称的映射，用于日志和统计显示分组名称
+func (gdb *GroupsDB) GetGroupNames() (map[string]string, error) {
+	rows, err := gdb.db.Query(`SELECT group_id, name FROM provider_groups`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group names: %w", err)
+	}
+	defer rows.Close()
+
+	names := make(map[string]string)
+	for rows.Next() {
+		var groupID, name string
+		if err := rows.Scan(&groupID, &name); err != nil {
+			return nil, fmt.Errorf("failed to scan group name: %w", err)
+		}
+		names[groupID] = name
+	}
+	return names, rows.Err()
+}
+
+// PurgeGroup 永久删除已归档的分组及其API密钥，只能删除已归档的分组
+func (gdb *GroupsDB) PurgeGroup(groupID string) error {
+	tx, err := gdb.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM provider_groups WHERE group_id = ? AND archived_at IS NOT NULL`, groupID).Scan(&count); err != nil {
+		return fmt.Errorf("failed to check archived group: %w", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("%w: %s", ErrArchivedGroupNotFound, groupID)
+	}
+
+	if _, err = tx.Exec("DELETE FROM provider_api_keys WHERE group_id = ?", groupID); err != nil {
+		return fmt.Errorf("failed to delete API keys: %w", err)
+	}
+	if _, err = tx.Exec("DELETE FROM provider_groups WHERE group_id = ?", groupID); err != nil {
+		return fmt.Errorf("failed to delete group: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Printf("已归档的分组 %s 已从数据库中永久删除", groupID)
+	return nil
+}
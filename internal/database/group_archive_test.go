@@ -0,0 +1,139 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newArchiveTestGroup 创建带一个密钥的启用分组
+func newArchiveTestGroup(name string) *UserGroup {
+	return &UserGroup{
+		Name:         name,
+		ProviderType: "openai",
+		BaseURL:      "https://api.openai.com/v1",
+		Enabled:      true,
+		Timeout:      30 * time.Second,
+		APIKeys:      []string{"sk-" + name},
+	}
+}
+
+// TestArchiveGroup 测试归档后分组不再加载，但仍可查到名称，且不能重复归档
+func TestArchiveGroup(t *testing.T) {
+	db := newTestGroupsDB(t)
+	if err := db.SaveGroup("group_a", newArchiveTestGroup("Group A")); err != nil {
+		t.Fatalf("保存分组失败: %v", err)
+	}
+	if err := db.SaveGroup("group_b", newArchiveTestGroup("Group B")); err != nil {
+		t.Fatalf("保存分组失败: %v", err)
+	}
+
+	if err := db.ArchiveGroup("group_a"); err != nil {
+		t.Fatalf("归档分组失败: %v", err)
+	}
+	if err := db.ArchiveGroup("group_a"); err == nil {
+		t.Error("重复归档同一分组应返回错误")
+	}
+	if err := db.ArchiveGroup("missing"); err == nil {
+		t.Error("归档不存在的分组应返回错误")
+	}
+
+	groups, err := db.LoadAllGroups()
+	if err != nil {
+		t.Fatalf("加载分组失败: %v", err)
+	}
+	if _, exists := groups["group_a"]; exists || len(groups) != 1 {
+		t.Errorf("已归档的分组不应加载，得到 %d 个分组", len(groups))
+	}
+
+	archived, err := db.IsGroupArchived("group_a")
+	if err != nil || !archived {
+		t.Errorf("group_a 应已归档，archived=%t err=%v", archived, err)
+	}
+	if archived, _ := db.IsGroupArchived("group_b"); archived {
+		t.Error("group_b 不应被视为已归档")
+	}
+
+	list, err := db.ListArchivedGroups()
+	if err != nil {
+		t.Fatalf("列出已归档分组失败: %v", err)
+	}
+	if len(list) != 1 || list[0].GroupID != "group_a" || list[0].Name != "Group A" {
+		t.Errorf("已归档分组列表不一致: %+v", list)
+	}
+	if count, _ := db.GetArchivedGroupCount(); count != 1 {
+		t.Errorf("已归档分组数量应为1，得到 %d", count)
+	}
+	if count, _ := db.GetGroupCount(); count != 1 {
+		t.Errorf("分组数量不应包括已归档的分组，得到 %d", count)
+	}
+
+	names, err := db.GetGroupNames()
+	if err != nil {
+		t.Fatalf("获取分组名称失败: %v", err)
+	}
+	if names["group_a"] != "Group A" || names["group_b"] != "Group B" {
+		t.Errorf("分组名称映射应包括已归档的分组，得到 %v", names)
+	}
+}
+
+// TestSaveGroupUnarchives 测试重新保存（导入）同一ID的分组时取消归档
+func TestSaveGroupUnarchives(t *testing.T) {
+	db := newTestGroupsDB(t)
+	if err := db.SaveGroup("group_a", newArchiveTestGroup("Group A")); err != nil {
+		t.Fatalf("保存分组失败: %v", err)
+	}
+	if err := db.ArchiveGroup("group_a"); err != nil {
+		t.Fatalf("归档分组失败: %v", err)
+	}
+
+	if err := db.SaveGroups(map[string]*UserGroup{"group_a": newArchiveTestGroup("Imported A")}); err != nil {
+		t.Fatalf("导入分组失败: %v", err)
+	}
+	if archived, _ := db.IsGroupArchived("group_a"); archived {
+		t.Error("导入后分组应取消归档")
+	}
+	group, err := db.LoadGroup("group_a")
+	if err != nil {
+		t.Fatalf("加载分组失败: %v", err)
+	}
+	if group.Name != "Imported A" || !group.Enabled {
+		t.Errorf("应使用导入的配置，得到 name=%q enabled=%t", group.Name, group.Enabled)
+	}
+}
+
+// TestPurgeGroup 测试只能永久删除已归档的分组，删除后名称映射中不再包含该分组
+func TestPurgeGroup(t *testing.T) {
+	db := newTestGroupsDB(t)
+	if err := db.SaveGroup("group_a", newArchiveTestGroup("Group A")); err != nil {
+		t.Fatalf("保存分组失败: %v", err)
+	}
+
+	if err := db.PurgeGroup("group_a"); !errors.Is(err, ErrArchivedGroupNotFound) {
+		t.Errorf("未归档的分组不能永久删除，得到 %v", err)
+	}
+	if err := db.PurgeGroup("missing"); !errors.Is(err, ErrArchivedGroupNotFound) {
+		t.Errorf("不存在的分组应返回 ErrArchivedGroupNotFound，得到 %v", err)
+	}
+
+	if err := db.ArchiveGroup("group_a"); err != nil {
+		t.Fatalf("归档分组失败: %v", err)
+	}
+	if err := db.PurgeGroup("group_a"); err != nil {
+		t.Fatalf("永久删除分组失败: %v", err)
+	}
+
+	if archived, _ := db.IsGroupArchived("group_a"); archived {
+		t.Error("永久删除后分组不应仍为已归档")
+	}
+	if names, _ := db.GetGroupNames(); len(names) != 0 {
+		t.Errorf("永久删除后名称映射应为空，得到 %v", names)
+	}
+	var keyCount int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM provider_api_keys WHERE group_id = ?", "group_a").Scan(&keyCount); err != nil {
+		t.Fatalf("查询密钥失败: %v", err)
+	}
+	if keyCount != 0 {
+		t.Errorf("永久删除后分组的密钥也应删除，剩余 %d 个", keyCount)
+	}
+}
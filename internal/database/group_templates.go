@@ -0,0 +1,100 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GroupTemplateRecord 保存的分组模板，Config 为分组配置（YAML，不含API密钥）
+type GroupTemplateRecord struct {
+	ID          string
+	Name        string
+	Description string
+	Config      string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// createGroupTemplatesTable 创建分组模板表
+func (gdb *GroupsDB) createGroupTemplatesTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS group_templates (
+		template_id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		config TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err := gdb.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create group_templates table: %w", err)
+	}
+	return nil
+}
+
+// SaveGroupTemplate 保存分组模板，同一ID的模板已存在时替换
+func (gdb *GroupsDB) SaveGroupTemplate(record GroupTemplateRecord) error {
+	query := `
+	INSERT INTO group_templates (template_id, name, description, config, updated_at)
+	VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(template_id) DO UPDATE SET
+		name = excluded.name,
+		description = excluded.description,
+		config = excluded.config,
+		updated_at = CURRENT_TIMESTAMP`
+
+	if _, err := gdb.db.Exec(query, record.ID, record.Name, record.Description, record.Config); err != nil {
+		return fmt.Errorf("failed to save group template: %w", err)
+	}
+	return nil
+}
+
+// ListGroupTemplates 列出保存的分组模板，按ID排序
+func (gdb *GroupsDB) ListGroupTemplates() ([]GroupTemplateRecord, error) {
+	rows, err := gdb.db.Query(`SELECT template_id, name, description, config, created_at, updated_at
+	FROM group_templates ORDER BY template_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group templates: %w", err)
+	}
+	defer rows.Close()
+
+	var records []GroupTemplateRecord
+	for rows.Next() {
+		var record GroupTemplateRecord
+		if err := rows.Scan(&record.ID, &record.Name, &record.Description, &record.Config, &record.CreatedAt, &record.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan group template: %w", err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// LoadGroupTemplate 加载分组模板，不存在时返回nil
+func (gdb *GroupsDB) LoadGroupTemplate(templateID string) (*GroupTemplateRecord, error) {
+	var record GroupTemplateRecord
+	err := gdb.db.QueryRow(`SELECT template_id, name, description, config, created_at, updated_at
+	FROM group_templates WHERE template_id = ?`, templateID).
+		Scan(&record.ID, &record.Name, &record.Description, &record.Config, &record.CreatedAt, &record.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group template: %w", err)
+	}
+	return &record, nil
+}
+
+// DeleteGroupTemplate 删除分组模板
+func (gdb *GroupsDB) DeleteGroupTemplate(templateID string) error {
+	result, err := gdb.db.Exec(`DELETE FROM group_templates WHERE template_id = ?`, templateID)
+	if err != nil {
+		return fmt.Errorf("failed to delete group template: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("group template not found: %s", templateID)
+	}
+	return nil
+}
@@ -7,25 +7,47 @@ import (
 	"log"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"turnsapi/internal/sqlitedriver"
 )
 
 // UserGroup 用户分组配置（避免循环导入）
 type UserGroup struct {
-	Name              string                 `yaml:"name" json:"name"`
-	ProviderType      string                 `yaml:"provider_type" json:"provider_type"`
-	BaseURL           string                 `yaml:"base_url" json:"base_url"`
-	Enabled           bool                   `yaml:"enabled" json:"enabled"`
-	Timeout           time.Duration          `yaml:"timeout" json:"timeout"`
-	MaxRetries        int                    `yaml:"max_retries" json:"max_retries"`
-	RotationStrategy  string                 `yaml:"rotation_strategy" json:"rotation_strategy"`
-	APIKeys           []string               `yaml:"api_keys" json:"api_keys"`
-	Models            []string               `yaml:"models,omitempty" json:"models,omitempty"`
-	Headers           map[string]string      `yaml:"headers,omitempty" json:"headers,omitempty"`
-	RequestParams     map[string]interface{} `yaml:"request_params,omitempty" json:"request_params,omitempty"`           // JSON请求参数覆盖
-	ModelMappings     map[string]string      `yaml:"model_mappings,omitempty" json:"model_mappings,omitempty"`           // 模型名称映射：别名 -> 原始模型名
-	UseNativeResponse bool                   `yaml:"use_native_response,omitempty" json:"use_native_response,omitempty"` // 是否使用原生接口响应格式
-	RPMLimit          int                    `yaml:"rpm_limit,omitempty" json:"rpm_limit,omitempty"`                     // 每分钟请求数限制
+	Name                string                 `yaml:"name" json:"name"`
+	ProviderType        string                 `yaml:"provider_type" json:"provider_type"`
+	BaseURL             string                 `yaml:"base_url" json:"base_url"`
+	Enabled             bool                   `yaml:"enabled" json:"enabled"`
+	Timeout             time.Duration          `yaml:"timeout" json:"timeout"`
+	MaxRetries          int                    `yaml:"max_retries" json:"max_retries"`
+	RotationStrategy    string                 `yaml:"rotation_strategy" json:"rotation_strategy"`
+	APIKeys             []string               `yaml:"api_keys" json:"api_keys"`
+	Models              []string               `yaml:"models,omitempty" json:"models,omitempty"`
+	Headers             map[string]string      `yaml:"headers,omitempty" json:"headers,omitempty"`
+	RequestParams       map[string]interface{} `yaml:"request_params,omitempty" json:"request_params,omitempty"`                 // JSON请求参数覆盖
+	ModelMappings       map[string]string      `yaml:"model_mappings,omitempty" json:"model_mappings,omitempty"`                 // 模型名称映射：别名 -> 原始模型名
+	ModelRewrites       json.RawMessage        `yaml:"-" json:"model_rewrites,omitempty"`                                        // 模型名称正则重写规则（JSON）
+	UseNativeResponse   bool                   `yaml:"use_native_response,omitempty" json:"use_native_response,omitempty"`       // 是否使用原生接口响应格式
+	RPMLimit            int                    `yaml:"rpm_limit,omitempty" json:"rpm_limit,omitempty"`                           // 每分钟请求数限制
+	ChatCompletionsPath string                 `yaml:"chat_completions_path,omitempty" json:"chat_completions_path,omitempty"`   // 聊天完成接口路径覆盖，为空时使用提供商默认路径
+	ModelsPath          string                 `yaml:"models_path,omitempty" json:"models_path,omitempty"`                       // 模型列表接口路径覆盖，为空时使用提供商默认路径
+	MaxConcurrent       int                    `yaml:"max_concurrent,omitempty" json:"max_concurrent,omitempty"`                 // 分组最大并发请求数，0表示无限制
+	MaxConcurrentPerKey int                    `yaml:"max_concurrent_per_key,omitempty" json:"max_concurrent_per_key,omitempty"` // 单个密钥最大并发请求数，0表示无限制
+	RetryPolicy         json.RawMessage        `yaml:"-" json:"retry_policy,omitempty"`                                          // 重试策略（JSON）
+	HealthCheckModel    string                 `yaml:"health_check_model,omitempty" json:"health_check_model,omitempty"`         // 健康检查使用的模型
+	SkipHealthCheck     bool                   `yaml:"skip_health_check,omitempty" json:"skip_health_check,omitempty"`           // 是否跳过健康检查
+	Shadow              json.RawMessage        `yaml:"-" json:"shadow,omitempty"`                                                // 影子流量设置（JSON）
+	Timeouts            json.RawMessage        `yaml:"-" json:"timeouts,omitempty"`                                              // 分阶段超时（JSON）
+	HealthProbe         json.RawMessage        `yaml:"-" json:"health_probe,omitempty"`                                          // 主动健康探测设置（JSON）
+	ProxyURL            string                 `yaml:"proxy_url,omitempty" json:"proxy_url,omitempty"`                           // 出站代理地址
+	TLS                 json.RawMessage        `yaml:"-" json:"tls,omitempty"`                                                   // 上游TLS设置（JSON）
+	Transport           json.RawMessage        `yaml:"-" json:"transport,omitempty"`                                             // 上游连接池设置（JSON）
+	RPMBurst            int                    `yaml:"rpm_burst,omitempty" json:"rpm_burst,omitempty"`                           // RPM突发容量，0表示不平滑
+	ModelLimits         json.RawMessage        `yaml:"-" json:"model_limits,omitempty"`                                          // 按模型的RPM/TPM限制（JSON）
+	Hooks               json.RawMessage        `yaml:"-" json:"hooks,omitempty"`                                                 // 启用的钩子名称（JSON）
+	Script              json.RawMessage        `yaml:"-" json:"script,omitempty"`                                                // Lua脚本设置（JSON）
+	IncludeReasoning    bool                   `yaml:"include_reasoning,omitempty" json:"include_reasoning,omitempty"`           // 是否返回思考内容
+	VertexAI            json.RawMessage        `yaml:"-" json:"vertex_ai,omitempty"`                                             // Vertex AI认证设置（JSON）
+	DefaultMaxTokens    int                    `yaml:"default_max_tokens,omitempty" json:"default_max_tokens,omitempty"`         // 默认最大输出token数，0表示使用提供商默认值
+	SystemPrompt        string                 `yaml:"system_prompt,omitempty" json:"system_prompt,omitempty"`                   // 注入到聊天请求的系统提示词模板
 }
 
 // GroupsDB 分组数据库管理器
@@ -33,12 +55,17 @@ type GroupsDB struct {
 	db *sql.DB
 }
 
+// groupsMaxIdleConns 分组数据库连接池保留的空闲连接数，重新建立连接后恢复同样的数量
+const groupsMaxIdleConns = 2
+
 // NewGroupsDB 创建新的分组数据库管理器
 func NewGroupsDB(dbPath string) (*GroupsDB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	// 与请求日志共用同一个数据库文件，设置忙等待超时避免与日志写入冲突时直接失败
+	db, err := sqlitedriver.Open(dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	db.SetMaxIdleConns(groupsMaxIdleConns)
 
 	groupsDB := &GroupsDB{db: db}
 
@@ -69,6 +96,20 @@ func (gdb *GroupsDB) initTables() error {
 		model_mappings TEXT, -- JSON object of model name mappings: alias -> original
 		use_native_response BOOLEAN NOT NULL DEFAULT 0, -- 是否使用原生接口响应格式
 		rpm_limit INTEGER NOT NULL DEFAULT 0, -- 每分钟请求数限制，0表示无限制
+		chat_completions_path TEXT NOT NULL DEFAULT '', -- 聊天完成接口路径覆盖
+		models_path TEXT NOT NULL DEFAULT '', -- 模型列表接口路径覆盖
+		max_concurrent INTEGER NOT NULL DEFAULT 0, -- 分组最大并发请求数，0表示无限制
+		max_concurrent_per_key INTEGER NOT NULL DEFAULT 0, -- 单个密钥最大并发请求数，0表示无限制
+		retry_policy TEXT, -- JSON object of retry policy
+		health_check_model TEXT NOT NULL DEFAULT '', -- 健康检查使用的模型
+		skip_health_check BOOLEAN NOT NULL DEFAULT 0, -- 是否跳过健康检查
+		model_rewrites TEXT, -- JSON array of ordered regex model rewrite rules
+		shadow TEXT, -- JSON object of shadow traffic settings
+		timeouts TEXT, -- JSON object of connect/first byte/total timeouts
+		health_probe TEXT, -- JSON object of active health probe settings
+		proxy_url TEXT NOT NULL DEFAULT '', -- 出站代理地址
+		tls TEXT, -- JSON object of upstream TLS settings
+		transport TEXT, -- JSON object of upstream connection pool settings
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
@@ -83,6 +124,10 @@ func (gdb *GroupsDB) initTables() error {
 		is_valid BOOLEAN DEFAULT NULL,
 		last_validated_at DATETIME DEFAULT NULL,
 		validation_error TEXT DEFAULT NULL,
+		label TEXT NOT NULL DEFAULT '', -- 密钥标签，日志和状态中代替掩码密钥显示
+		owner TEXT NOT NULL DEFAULT '', -- 密钥负责人
+		expires_at DATETIME DEFAULT NULL, -- 密钥过期时间
+		note TEXT NOT NULL DEFAULT '', -- 备注
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY (group_id) REFERENCES provider_groups(group_id) ON DELETE CASCADE
 	);`
@@ -124,6 +169,134 @@ func (gdb *GroupsDB) initTables() error {
 		return fmt.Errorf("failed to migrate new fields: %w", err)
 	}
 
+	// 执行数据库迁移，为分组表添加接口路径覆盖字段
+	if err := gdb.migrateEndpointPathFields(); err != nil {
+		return fmt.Errorf("failed to migrate endpoint path fields: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加并发限制字段
+	if err := gdb.migrateConcurrencyFields(); err != nil {
+		return fmt.Errorf("failed to migrate concurrency fields: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加重试策略字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"retry_policy", "TEXT"}}); err != nil {
+		return fmt.Errorf("failed to migrate retry_policy field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加健康检查配置字段
+	if err := gdb.addMissingGroupColumns([][2]string{
+		{"health_check_model", "TEXT NOT NULL DEFAULT ''"},
+		{"skip_health_check", "BOOLEAN NOT NULL DEFAULT 0"},
+	}); err != nil {
+		return fmt.Errorf("failed to migrate health check fields: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加模型重写规则字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"model_rewrites", "TEXT"}}); err != nil {
+		return fmt.Errorf("failed to migrate model_rewrites field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加影子流量设置字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"shadow", "TEXT"}}); err != nil {
+		return fmt.Errorf("failed to migrate shadow field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加分阶段超时字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"timeouts", "TEXT"}}); err != nil {
+		return fmt.Errorf("failed to migrate timeouts field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加主动健康探测字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"health_probe", "TEXT"}}); err != nil {
+		return fmt.Errorf("failed to migrate health_probe field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加出站代理字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"proxy_url", "TEXT NOT NULL DEFAULT ''"}}); err != nil {
+		return fmt.Errorf("failed to migrate proxy_url field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加上游TLS设置字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"tls", "TEXT"}}); err != nil {
+		return fmt.Errorf("failed to migrate tls field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加上游连接池设置字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"transport", "TEXT"}}); err != nil {
+		return fmt.Errorf("failed to migrate transport field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加RPM突发容量字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"rpm_burst", "INTEGER NOT NULL DEFAULT 0"}}); err != nil {
+		return fmt.Errorf("failed to migrate rpm_burst field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加按模型限流字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"model_limits", "TEXT"}}); err != nil {
+		return fmt.Errorf("failed to migrate model_limits field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加钩子字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"hooks", "TEXT"}}); err != nil {
+		return fmt.Errorf("failed to migrate hooks field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加脚本字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"script", "TEXT"}}); err != nil {
+		return fmt.Errorf("failed to migrate script field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加思考内容开关字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"include_reasoning", "BOOLEAN NOT NULL DEFAULT 0"}}); err != nil {
+		return fmt.Errorf("failed to migrate include_reasoning field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加Vertex AI设置字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"vertex_ai", "TEXT"}}); err != nil {
+		return fmt.Errorf("failed to migrate vertex_ai field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加默认最大输出token数字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"default_max_tokens", "INTEGER NOT NULL DEFAULT 0"}}); err != nil {
+		return fmt.Errorf("failed to migrate default_max_tokens field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加系统提示词字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"system_prompt", "TEXT NOT NULL DEFAULT ''"}}); err != nil {
+		return fmt.Errorf("failed to migrate system_prompt field: %w", err)
+	}
+
+	// 执行数据库迁移，为分组表添加归档时间字段
+	if err := gdb.addMissingGroupColumns([][2]string{{"archived_at", "DATETIME"}}); err != nil {
+		return fmt.Errorf("failed to migrate archived_at field: %w", err)
+	}
+
+	// 创建路由失败状态表
+	if err := gdb.createRouterFailuresTable(); err != nil {
+		return err
+	}
+
+	// 创建幂等请求记录表
+	if err := gdb.createIdempotencyKeysTable(); err != nil {
+		return err
+	}
+
+	// 创建分组模板表
+	if err := gdb.createGroupTemplatesTable(); err != nil {
+		return err
+	}
+
+	// 创建批处理对象归属表
+	if err := gdb.createBatchObjectsTable(); err != nil {
+		return err
+	}
+
+	// 创建备用模型目录表
+	if err := gdb.createFallbackModelsTable(); err != nil {
+		return err
+	}
+
 	// 创建索引
 	for _, indexSQL := range createIndexes {
 		if _, err := gdb.db.Exec(indexSQL); err != nil {
@@ -135,7 +308,7 @@ func (gdb *GroupsDB) initTables() error {
 	return nil
 }
 
-// migrateAPIKeysTable 迁移API密钥表，添加验证相关字段
+// migrateAPIKeysTable 迁移API密钥表，添加验证和标签相关字段
 func (gdb *GroupsDB) migrateAPIKeysTable() error {
 	// 检查字段是否已存在
 	checkColumnSQL := `PRAGMA table_info(provider_api_keys);`
@@ -173,6 +346,22 @@ func (gdb *GroupsDB) migrateAPIKeysTable() error {
 		migrations = append(migrations, "ALTER TABLE provider_api_keys ADD COLUMN validation_error TEXT DEFAULT NULL;")
 	}
 
+	if !existingColumns["label"] {
+		migrations = append(migrations, "ALTER TABLE provider_api_keys ADD COLUMN label TEXT NOT NULL DEFAULT '';")
+	}
+
+	if !existingColumns["owner"] {
+		migrations = append(migrations, "ALTER TABLE provider_api_keys ADD COLUMN owner TEXT NOT NULL DEFAULT '';")
+	}
+
+	if !existingColumns["expires_at"] {
+		migrations = append(migrations, "ALTER TABLE provider_api_keys ADD COLUMN expires_at DATETIME DEFAULT NULL;")
+	}
+
+	if !existingColumns["note"] {
+		migrations = append(migrations, "ALTER TABLE provider_api_keys ADD COLUMN note TEXT NOT NULL DEFAULT '';")
+	}
+
 	// 执行迁移
 	for _, migration := range migrations {
 		if _, err := gdb.db.Exec(migration); err != nil {
@@ -328,35 +517,76 @@ func (gdb *GroupsDB) migrateNewFields() error {
 	return nil
 }
 
-// UpdateAPIKeyValidation 更新API密钥的验证状态
-func (gdb *GroupsDB) UpdateAPIKeyValidation(groupID, apiKey string, isValid bool, validationError string) error {
-	// 先检查记录是否存在，如果不存在则插入
-	checkSQL := `SELECT COUNT(*) FROM provider_api_keys WHERE group_id = ? AND api_key = ?`
-	var count int
-	err := gdb.db.QueryRow(checkSQL, groupID, apiKey).Scan(&count)
+// providerGroupColumns 获取分组表现有的字段集合
+func (gdb *GroupsDB) providerGroupColumns() (map[string]bool, error) {
+	rows, err := gdb.db.Query(`PRAGMA table_info(provider_groups);`)
 	if err != nil {
-		return fmt.Errorf("failed to check API key existence: %w", err)
+		return nil, fmt.Errorf("failed to check table info: %w", err)
 	}
+	defer rows.Close()
 
-	if count == 0 {
-		// 插入新记录
-		insertSQL := `
-			INSERT INTO provider_api_keys (group_id, api_key, is_valid, last_validated_at, validation_error, key_order)
-			VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?, 0)`
-		_, err = gdb.db.Exec(insertSQL, groupID, apiKey, isValid, validationError)
-		if err != nil {
-			return fmt.Errorf("failed to insert API key validation status: %w", err)
+	existingColumns := make(map[string]bool)
+	for rows.Next() {
+		var cid int
+		var name, dataType string
+		var notNull, pk int
+		var defaultValue interface{}
+
+		if err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, fmt.Errorf("failed to scan column info: %w", err)
 		}
-	} else {
-		// 更新现有记录
-		updateSQL := `
-			UPDATE provider_api_keys
-			SET is_valid = ?, last_validated_at = CURRENT_TIMESTAMP, validation_error = ?
-			WHERE group_id = ? AND api_key = ?`
-		_, err = gdb.db.Exec(updateSQL, isValid, validationError, groupID, apiKey)
-		if err != nil {
-			return fmt.Errorf("failed to update API key validation status: %w", err)
+		existingColumns[name] = true
+	}
+	return existingColumns, nil
+}
+
+// addMissingGroupColumns 为分组表添加缺失的字段，columns每项为 {字段名, 列定义}
+func (gdb *GroupsDB) addMissingGroupColumns(columns [][2]string) error {
+	existingColumns, err := gdb.providerGroupColumns()
+	if err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		if existingColumns[column[0]] {
+			continue
+		}
+		migration := fmt.Sprintf("ALTER TABLE provider_groups ADD COLUMN %s %s;", column[0], column[1])
+		if _, err := gdb.db.Exec(migration); err != nil {
+			return fmt.Errorf("failed to execute migration '%s': %w", migration, err)
 		}
+		log.Printf("Executed migration: %s", migration)
+	}
+
+	return nil
+}
+
+// migrateEndpointPathFields 迁移分组表，添加chat_completions_path和models_path字段
+func (gdb *GroupsDB) migrateEndpointPathFields() error {
+	return gdb.addMissingGroupColumns([][2]string{
+		{"chat_completions_path", "TEXT NOT NULL DEFAULT ''"},
+		{"models_path", "TEXT NOT NULL DEFAULT ''"},
+	})
+}
+
+// migrateConcurrencyFields 迁移分组表，添加max_concurrent和max_concurrent_per_key字段
+func (gdb *GroupsDB) migrateConcurrencyFields() error {
+	return gdb.addMissingGroupColumns([][2]string{
+		{"max_concurrent", "INTEGER NOT NULL DEFAULT 0"},
+		{"max_concurrent_per_key", "INTEGER NOT NULL DEFAULT 0"},
+	})
+}
+
+// UpdateAPIKeyValidation 更新API密钥的验证状态
+func (gdb *GroupsDB) UpdateAPIKeyValidation(groupID, apiKey string, isValid bool, validationError string) error {
+	// 只更新分组中已保存的密钥；通过引用（${ENV_VAR}、file:）配置的密钥在表中保存的是引用，
+	// 不能按明文插入新记录，否则明文密钥会落盘并在下次加载时成为分组的新密钥
+	updateSQL := `
+		UPDATE provider_api_keys
+		SET is_valid = ?, last_validated_at = CURRENT_TIMESTAMP, validation_error = ?
+		WHERE group_id = ? AND api_key = ?`
+	if _, err := gdb.db.Exec(updateSQL, isValid, validationError, groupID, apiKey); err != nil {
+		return fmt.Errorf("failed to update API key validation status: %w", err)
 	}
 
 	return nil
@@ -365,7 +595,7 @@ func (gdb *GroupsDB) UpdateAPIKeyValidation(groupID, apiKey string, isValid bool
 // GetAPIKeyValidationStatus 获取API密钥的验证状态
 func (gdb *GroupsDB) GetAPIKeyValidationStatus(groupID string) (map[string]map[string]interface{}, error) {
 	querySQL := `
-		SELECT api_key, is_valid, last_validated_at, validation_error
+		SELECT api_key, is_valid, last_validated_at, validation_error, label, owner, expires_at, note
 		FROM provider_api_keys
 		WHERE group_id = ?
 		ORDER BY key_order`
@@ -383,8 +613,10 @@ func (gdb *GroupsDB) GetAPIKeyValidationStatus(groupID string) (map[string]map[s
 		var isValid *bool
 		var lastValidatedAt *string
 		var validationError *string
+		var meta APIKeyMetadata
 
-		if err := rows.Scan(&apiKey, &isValid, &lastValidatedAt, &validationError); err != nil {
+		if err := rows.Scan(&apiKey, &isValid, &lastValidatedAt, &validationError,
+			&meta.Label, &meta.Owner, &meta.ExpiresAt, &meta.Note); err != nil {
 			return nil, fmt.Errorf("failed to scan validation status: %w", err)
 		}
 
@@ -392,6 +624,10 @@ func (gdb *GroupsDB) GetAPIKeyValidationStatus(groupID string) (map[string]map[s
 			"is_valid":          isValid,
 			"last_validated_at": lastValidatedAt,
 			"validation_error":  validationError,
+			"label":             meta.Label,
+			"owner":             meta.Owner,
+			"expires_at":        meta.ExpiresAt,
+			"note":              meta.Note,
 		}
 
 		result[apiKey] = status
@@ -408,6 +644,42 @@ func (gdb *GroupsDB) SaveGroup(groupID string, group *UserGroup) error {
 	}
 	defer tx.Rollback()
 
+	if err := saveGroupTx(tx, groupID, group); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Printf("分组 %s 已保存到数据库", groupID)
+	return nil
+}
+
+// SaveGroups 在一个事务中保存多个分组配置，任一分组保存失败时全部回滚
+func (gdb *GroupsDB) SaveGroups(groups map[string]*UserGroup) error {
+	tx, err := gdb.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for groupID, group := range groups {
+		if err := saveGroupTx(tx, groupID, group); err != nil {
+			return fmt.Errorf("group %s: %w", groupID, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Printf("%d 个分组已在同一事务中保存到数据库", len(groups))
+	return nil
+}
+
+// saveGroupTx 在事务中插入或更新分组信息，并替换其API密钥
+func saveGroupTx(tx *sql.Tx, groupID string, group *UserGroup) error {
 	// 序列化models、headers、request_params和model_mappings为JSON
 	modelsJSON, err := json.Marshal(group.Models)
 	if err != nil {
@@ -442,8 +714,9 @@ func (gdb *GroupsDB) SaveGroup(groupID string, group *UserGroup) error {
 	INSERT INTO provider_groups (
 		group_id, name, provider_type, base_url, enabled,
 		timeout_seconds, max_retries, rotation_strategy, models, headers, request_params, model_mappings,
-		use_native_response, rpm_limit, updated_at
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		use_native_response, rpm_limit, chat_completions_path, models_path,
+		max_concurrent, max_concurrent_per_key, retry_policy, health_check_model, skip_health_check, model_rewrites, shadow, timeouts, health_probe, proxy_url, tls, transport, rpm_burst, model_limits, hooks, script, include_reasoning, vertex_ai, default_max_tokens, system_prompt, updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 	ON CONFLICT(group_id) DO UPDATE SET
 		name = excluded.name,
 		provider_type = excluded.provider_type,
@@ -458,57 +731,95 @@ func (gdb *GroupsDB) SaveGroup(groupID string, group *UserGroup) error {
 		model_mappings = excluded.model_mappings,
 		use_native_response = excluded.use_native_response,
 		rpm_limit = excluded.rpm_limit,
+		chat_completions_path = excluded.chat_completions_path,
+		models_path = excluded.models_path,
+		max_concurrent = excluded.max_concurrent,
+		max_concurrent_per_key = excluded.max_concurrent_per_key,
+		retry_policy = excluded.retry_policy,
+		health_check_model = excluded.health_check_model,
+		skip_health_check = excluded.skip_health_check,
+		model_rewrites = excluded.model_rewrites,
+		shadow = excluded.shadow,
+		timeouts = excluded.timeouts,
+		health_probe = excluded.health_probe,
+		proxy_url = excluded.proxy_url,
+		tls = excluded.tls,
+		transport = excluded.transport,
+		rpm_burst = excluded.rpm_burst,
+		model_limits = excluded.model_limits,
+		hooks = excluded.hooks,
+		script = excluded.script,
+		include_reasoning = excluded.include_reasoning,
+		vertex_ai = excluded.vertex_ai,
+		default_max_tokens = excluded.default_max_tokens,
+		system_prompt = excluded.system_prompt,
+		archived_at = NULL,
 		updated_at = CURRENT_TIMESTAMP;`
 
 	_, err = tx.Exec(upsertGroupSQL,
 		groupID, group.Name, group.ProviderType, group.BaseURL,
 		group.Enabled, int(group.Timeout.Seconds()), group.MaxRetries,
 		group.RotationStrategy, string(modelsJSON), string(headersJSON), string(requestParamsJSON), string(modelMappingsJSON),
-		group.UseNativeResponse, group.RPMLimit)
+		group.UseNativeResponse, group.RPMLimit, group.ChatCompletionsPath, group.ModelsPath,
+		group.MaxConcurrent, group.MaxConcurrentPerKey, nullableJSON(group.RetryPolicy),
+		group.HealthCheckModel, group.SkipHealthCheck, nullableJSON(group.ModelRewrites), nullableJSON(group.Shadow), nullableJSON(group.Timeouts), nullableJSON(group.HealthProbe), group.ProxyURL, nullableJSON(group.TLS), nullableJSON(group.Transport), group.RPMBurst, nullableJSON(group.ModelLimits), nullableJSON(group.Hooks), nullableJSON(group.Script), group.IncludeReasoning, nullableJSON(group.VertexAI), group.DefaultMaxTokens, group.SystemPrompt)
 	if err != nil {
 		return fmt.Errorf("failed to save group: %w", err)
 	}
 
+	// 保留的密钥沿用原有的标签等信息
+	metadata, err := queryAPIKeyMetadata(tx, "WHERE group_id = ?", groupID)
+	if err != nil {
+		return err
+	}
+
 	// 删除现有的API密钥
 	if _, err = tx.Exec("DELETE FROM provider_api_keys WHERE group_id = ?", groupID); err != nil {
 		return fmt.Errorf("failed to delete existing API keys: %w", err)
 	}
 
 	// 插入新的API密钥
-	insertKeySQL := "INSERT INTO provider_api_keys (group_id, api_key, key_order) VALUES (?, ?, ?)"
+	insertKeySQL := "INSERT INTO provider_api_keys (group_id, api_key, key_order, label, owner, expires_at, note) VALUES (?, ?, ?, ?, ?, ?, ?)"
 	for i, apiKey := range group.APIKeys {
-		if _, err = tx.Exec(insertKeySQL, groupID, apiKey, i); err != nil {
+		meta := metadata[groupID][apiKey]
+		if _, err = tx.Exec(insertKeySQL, groupID, apiKey, i, meta.Label, meta.Owner, meta.ExpiresAt, meta.Note); err != nil {
 			return fmt.Errorf("failed to save API key: %w", err)
 		}
 	}
 
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
-	}
-
-	log.Printf("分组 %s 已保存到数据库", groupID)
 	return nil
 }
 
+// nullableJSON 将空的JSON内容转换为NULL
+func nullableJSON(data json.RawMessage) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
+
 // LoadGroup 加载单个分组配置
 func (gdb *GroupsDB) LoadGroup(groupID string) (*UserGroup, error) {
 	// 查询分组信息
 	groupSQL := `
 	SELECT name, provider_type, base_url, enabled,
 		   timeout_seconds, max_retries, rotation_strategy, models, headers, request_params, model_mappings,
-		   use_native_response, rpm_limit
-	FROM provider_groups WHERE group_id = ?`
+		   use_native_response, rpm_limit, chat_completions_path, models_path,
+		   max_concurrent, max_concurrent_per_key, retry_policy, health_check_model, skip_health_check, model_rewrites, shadow, timeouts, health_probe, proxy_url, tls, transport, rpm_burst, model_limits, hooks, script, include_reasoning, vertex_ai, default_max_tokens, system_prompt
+	FROM provider_groups WHERE group_id = ? AND archived_at IS NULL`
 
 	var group UserGroup
 	var modelsJSON, headersJSON string
-	var requestParamsJSON, modelMappingsJSON *string // 使用指针来处理NULL值
+	var requestParamsJSON, modelMappingsJSON, retryPolicyJSON, modelRewritesJSON, shadowJSON, timeoutsJSON, healthProbeJSON, tlsJSON, transportJSON, modelLimitsJSON, hooksJSON, scriptJSON, vertexAIJSON *string // 使用指针来处理NULL值
 	var timeoutSeconds int
 
 	err := gdb.db.QueryRow(groupSQL, groupID).Scan(
 		&group.Name, &group.ProviderType, &group.BaseURL,
 		&group.Enabled, &timeoutSeconds, &group.MaxRetries, &group.RotationStrategy,
 		&modelsJSON, &headersJSON, &requestParamsJSON, &modelMappingsJSON,
-		&group.UseNativeResponse, &group.RPMLimit)
+		&group.UseNativeResponse, &group.RPMLimit, &group.ChatCompletionsPath, &group.ModelsPath,
+		&group.MaxConcurrent, &group.MaxConcurrentPerKey, &retryPolicyJSON,
+		&group.HealthCheckModel, &group.SkipHealthCheck, &modelRewritesJSON, &shadowJSON, &timeoutsJSON, &healthProbeJSON, &group.ProxyURL, &tlsJSON, &transportJSON, &group.RPMBurst, &modelLimitsJSON, &hooksJSON, &scriptJSON, &group.IncludeReasoning, &vertexAIJSON, &group.DefaultMaxTokens, &group.SystemPrompt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("group not found: %s", groupID)
@@ -546,6 +857,61 @@ func (gdb *GroupsDB) LoadGroup(groupID string) (*UserGroup, error) {
 		group.ModelMappings = make(map[string]string)
 	}
 
+	// 处理retry_policy，可能为NULL
+	if retryPolicyJSON != nil && *retryPolicyJSON != "" && *retryPolicyJSON != "null" {
+		group.RetryPolicy = json.RawMessage(*retryPolicyJSON)
+	}
+
+	// 处理model_rewrites，可能为NULL
+	if modelRewritesJSON != nil && *modelRewritesJSON != "" && *modelRewritesJSON != "null" {
+		group.ModelRewrites = json.RawMessage(*modelRewritesJSON)
+	}
+
+	// 处理shadow，可能为NULL
+	if shadowJSON != nil && *shadowJSON != "" && *shadowJSON != "null" {
+		group.Shadow = json.RawMessage(*shadowJSON)
+	}
+
+	// 处理timeouts，可能为NULL
+	if timeoutsJSON != nil && *timeoutsJSON != "" && *timeoutsJSON != "null" {
+		group.Timeouts = json.RawMessage(*timeoutsJSON)
+	}
+
+	// 处理health_probe，可能为NULL
+	if healthProbeJSON != nil && *healthProbeJSON != "" && *healthProbeJSON != "null" {
+		group.HealthProbe = json.RawMessage(*healthProbeJSON)
+	}
+
+	// 处理tls，可能为NULL
+	if tlsJSON != nil && *tlsJSON != "" && *tlsJSON != "null" {
+		group.TLS = json.RawMessage(*tlsJSON)
+	}
+
+	// 处理transport，可能为NULL
+	if transportJSON != nil && *transportJSON != "" && *transportJSON != "null" {
+		group.Transport = json.RawMessage(*transportJSON)
+	}
+
+	// 处理model_limits，可能为NULL
+	if modelLimitsJSON != nil && *modelLimitsJSON != "" && *modelLimitsJSON != "null" {
+		group.ModelLimits = json.RawMessage(*modelLimitsJSON)
+	}
+
+	// 处理hooks，可能为NULL
+	if hooksJSON != nil && *hooksJSON != "" && *hooksJSON != "null" {
+		group.Hooks = json.RawMessage(*hooksJSON)
+	}
+
+	// 处理script，可能为NULL
+	if scriptJSON != nil && *scriptJSON != "" && *scriptJSON != "null" {
+		group.Script = json.RawMessage(*scriptJSON)
+	}
+
+	// 处理vertex_ai，可能为NULL
+	if vertexAIJSON != nil && *vertexAIJSON != "" && *vertexAIJSON != "null" {
+		group.VertexAI = json.RawMessage(*vertexAIJSON)
+	}
+
 	// 查询API密钥
 	keysSQL := "SELECT api_key FROM provider_api_keys WHERE group_id = ? ORDER BY key_order"
 	rows, err := gdb.db.Query(keysSQL, groupID)
@@ -573,8 +939,9 @@ func (gdb *GroupsDB) LoadAllGroups() (map[string]*UserGroup, error) {
 	groupsSQL := `
 	SELECT group_id, name, provider_type, base_url, enabled,
 		   timeout_seconds, max_retries, rotation_strategy, models, headers, request_params, model_mappings,
-		   use_native_response, rpm_limit
-	FROM provider_groups ORDER BY created_at DESC`
+		   use_native_response, rpm_limit, chat_completions_path, models_path,
+		   max_concurrent, max_concurrent_per_key, retry_policy, health_check_model, skip_health_check, model_rewrites, shadow, timeouts, health_probe, proxy_url, tls, transport, rpm_burst, model_limits, hooks, script, include_reasoning, vertex_ai, default_max_tokens, system_prompt
+	FROM provider_groups WHERE archived_at IS NULL ORDER BY created_at DESC`
 
 	rows, err := gdb.db.Query(groupsSQL)
 	if err != nil {
@@ -588,13 +955,15 @@ func (gdb *GroupsDB) LoadAllGroups() (map[string]*UserGroup, error) {
 		var groupID string
 		var group UserGroup
 		var modelsJSON, headersJSON string
-		var requestParamsJSON, modelMappingsJSON *string // 使用指针来处理NULL值
+		var requestParamsJSON, modelMappingsJSON, retryPolicyJSON, modelRewritesJSON, shadowJSON, timeoutsJSON, healthProbeJSON, tlsJSON, transportJSON, modelLimitsJSON, hooksJSON, scriptJSON, vertexAIJSON *string // 使用指针来处理NULL值
 		var timeoutSeconds int
 
 		err = rows.Scan(&groupID, &group.Name, &group.ProviderType, &group.BaseURL,
 			&group.Enabled, &timeoutSeconds, &group.MaxRetries,
 			&group.RotationStrategy, &modelsJSON, &headersJSON, &requestParamsJSON, &modelMappingsJSON,
-			&group.UseNativeResponse, &group.RPMLimit)
+			&group.UseNativeResponse, &group.RPMLimit, &group.ChatCompletionsPath, &group.ModelsPath,
+			&group.MaxConcurrent, &group.MaxConcurrentPerKey, &retryPolicyJSON,
+			&group.HealthCheckModel, &group.SkipHealthCheck, &modelRewritesJSON, &shadowJSON, &timeoutsJSON, &healthProbeJSON, &group.ProxyURL, &tlsJSON, &transportJSON, &group.RPMBurst, &modelLimitsJSON, &hooksJSON, &scriptJSON, &group.IncludeReasoning, &vertexAIJSON, &group.DefaultMaxTokens, &group.SystemPrompt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan group: %w", err)
 		}
@@ -633,6 +1002,61 @@ func (gdb *GroupsDB) LoadAllGroups() (map[string]*UserGroup, error) {
 			group.ModelMappings = make(map[string]string)
 		}
 
+		// 处理retry_policy，可能为NULL
+		if retryPolicyJSON != nil && *retryPolicyJSON != "" && *retryPolicyJSON != "null" {
+			group.RetryPolicy = json.RawMessage(*retryPolicyJSON)
+		}
+
+		// 处理model_rewrites，可能为NULL
+		if modelRewritesJSON != nil && *modelRewritesJSON != "" && *modelRewritesJSON != "null" {
+			group.ModelRewrites = json.RawMessage(*modelRewritesJSON)
+		}
+
+		// 处理shadow，可能为NULL
+		if shadowJSON != nil && *shadowJSON != "" && *shadowJSON != "null" {
+			group.Shadow = json.RawMessage(*shadowJSON)
+		}
+
+		// 处理timeouts，可能为NULL
+		if timeoutsJSON != nil && *timeoutsJSON != "" && *timeoutsJSON != "null" {
+			group.Timeouts = json.RawMessage(*timeoutsJSON)
+		}
+
+		// 处理health_probe，可能为NULL
+		if healthProbeJSON != nil && *healthProbeJSON != "" && *healthProbeJSON != "null" {
+			group.HealthProbe = json.RawMessage(*healthProbeJSON)
+		}
+
+		// 处理tls，可能为NULL
+		if tlsJSON != nil && *tlsJSON != "" && *tlsJSON != "null" {
+			group.TLS = json.RawMessage(*tlsJSON)
+		}
+
+		// 处理transport，可能为NULL
+		if transportJSON != nil && *transportJSON != "" && *transportJSON != "null" {
+			group.Transport = json.RawMessage(*transportJSON)
+		}
+
+		// 处理model_limits，可能为NULL
+		if modelLimitsJSON != nil && *modelLimitsJSON != "" && *modelLimitsJSON != "null" {
+			group.ModelLimits = json.RawMessage(*modelLimitsJSON)
+		}
+
+		// 处理hooks，可能为NULL
+		if hooksJSON != nil && *hooksJSON != "" && *hooksJSON != "null" {
+			group.Hooks = json.RawMessage(*hooksJSON)
+		}
+
+		// 处理script，可能为NULL
+		if scriptJSON != nil && *scriptJSON != "" && *scriptJSON != "null" {
+			group.Script = json.RawMessage(*scriptJSON)
+		}
+
+		// 处理vertex_ai，可能为NULL
+		if vertexAIJSON != nil && *vertexAIJSON != "" && *vertexAIJSON != "null" {
+			group.VertexAI = json.RawMessage(*vertexAIJSON)
+		}
+
 		groups[groupID] = &group
 	}
 
@@ -667,8 +1091,8 @@ func (gdb *GroupsDB) GetGroupsWithMetadata() (map[string]map[string]interface{},
 	groupsSQL := `
 	SELECT group_id, name, provider_type, base_url, enabled,
 		   timeout_seconds, max_retries, rotation_strategy, models, headers,
-		   use_native_response, rpm_limit, created_at, updated_at
-	FROM provider_groups ORDER BY created_at DESC`
+		   use_native_response, rpm_limit, max_concurrent, max_concurrent_per_key, created_at, updated_at
+	FROM provider_groups WHERE archived_at IS NULL ORDER BY created_at DESC`
 
 	rows, err := gdb.db.Query(groupsSQL)
 	if err != nil {
@@ -681,12 +1105,12 @@ func (gdb *GroupsDB) GetGroupsWithMetadata() (map[string]map[string]interface{},
 	for rows.Next() {
 		var groupID, name, providerType, baseURL, rotationStrategy, modelsJSON, headersJSON string
 		var enabled, useNativeResponse bool
-		var timeoutSeconds, maxRetries, rpmLimit int
+		var timeoutSeconds, maxRetries, rpmLimit, maxConcurrent, maxConcurrentPerKey int
 		var createdAt, updatedAt time.Time
 
 		err = rows.Scan(&groupID, &name, &providerType, &baseURL, &enabled,
 			&timeoutSeconds, &maxRetries, &rotationStrategy, &modelsJSON, &headersJSON,
-			&useNativeResponse, &rpmLimit, &createdAt, &updatedAt)
+			&useNativeResponse, &rpmLimit, &maxConcurrent, &maxConcurrentPerKey, &createdAt, &updatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan group: %w", err)
 		}
@@ -725,21 +1149,23 @@ func (gdb *GroupsDB) GetGroupsWithMetadata() (map[string]map[string]interface{},
 
 		// 构建分组信息
 		groupInfo := map[string]interface{}{
-			"group_id":            groupID,
-			"group_name":          name,
-			"provider_type":       providerType,
-			"base_url":            baseURL,
-			"enabled":             enabled,
-			"timeout":             time.Duration(timeoutSeconds) * time.Second,
-			"max_retries":         maxRetries,
-			"rotation_strategy":   rotationStrategy,
-			"api_keys":            apiKeys,
-			"models":              models,
-			"headers":             headers,
-			"use_native_response": useNativeResponse,
-			"rpm_limit":           rpmLimit,
-			"created_at":          createdAt,
-			"updated_at":          updatedAt,
+			"group_id":               groupID,
+			"group_name":             name,
+			"provider_type":          providerType,
+			"base_url":               baseURL,
+			"enabled":                enabled,
+			"timeout":                time.Duration(timeoutSeconds) * time.Second,
+			"max_retries":            maxRetries,
+			"rotation_strategy":      rotationStrategy,
+			"api_keys":               apiKeys,
+			"models":                 models,
+			"headers":                headers,
+			"use_native_response":    useNativeResponse,
+			"rpm_limit":              rpmLimit,
+			"max_concurrent":         maxConcurrent,
+			"max_concurrent_per_key": maxConcurrentPerKey,
+			"created_at":             createdAt,
+			"updated_at":             updatedAt,
 		}
 
 		groups[groupID] = groupInfo
@@ -748,46 +1174,10 @@ func (gdb *GroupsDB) GetGroupsWithMetadata() (map[string]map[string]interface{},
 	return groups, nil
 }
 
-// DeleteGroup 删除分组配置
-func (gdb *GroupsDB) DeleteGroup(groupID string) error {
-	tx, err := gdb.db.Begin()
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
-	}
-	defer tx.Rollback()
-
-	// 删除API密钥（由于外键约束，会自动删除）
-	if _, err = tx.Exec("DELETE FROM provider_api_keys WHERE group_id = ?", groupID); err != nil {
-		return fmt.Errorf("failed to delete API keys: %w", err)
-	}
-
-	// 删除分组
-	result, err := tx.Exec("DELETE FROM provider_groups WHERE group_id = ?", groupID)
-	if err != nil {
-		return fmt.Errorf("failed to delete group: %w", err)
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	}
-
-	if rowsAffected == 0 {
-		return fmt.Errorf("group not found: %s", groupID)
-	}
-
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
-	}
-
-	log.Printf("分组 %s 已从数据库中删除", groupID)
-	return nil
-}
-
-// GetGroupCount 获取分组总数
+// GetGroupCount 获取分组总数（不含已归档的分组）
 func (gdb *GroupsDB) GetGroupCount() (int, error) {
 	var count int
-	err := gdb.db.QueryRow("SELECT COUNT(*) FROM provider_groups").Scan(&count)
+	err := gdb.db.QueryRow("SELECT COUNT(*) FROM provider_groups WHERE archived_at IS NULL").Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get group count: %w", err)
 	}
@@ -797,7 +1187,7 @@ func (gdb *GroupsDB) GetGroupCount() (int, error) {
 // GetEnabledGroupCount 获取启用的分组数量
 func (gdb *GroupsDB) GetEnabledGroupCount() (int, error) {
 	var count int
-	err := gdb.db.QueryRow("SELECT COUNT(*) FROM provider_groups WHERE enabled = 1").Scan(&count)
+	err := gdb.db.QueryRow("SELECT COUNT(*) FROM provider_groups WHERE enabled = 1 AND archived_at IS NULL").Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get enabled group count: %w", err)
 	}
@@ -907,6 +1297,13 @@ func (gdb *GroupsDB) GetKeyHealthStatistics() (map[string]interface{}, error) {
 	return stats, nil
 }
 
+// ResetConnections 关闭连接池中的空闲连接并确认数据库可用，之后的查询使用新建立的连接
+func (gdb *GroupsDB) ResetConnections() error {
+	gdb.db.SetMaxIdleConns(0)
+	gdb.db.SetMaxIdleConns(groupsMaxIdleConns)
+	return gdb.db.Ping()
+}
+
 func (gdb *GroupsDB) Close() error {
 	if gdb.db != nil {
 		return gdb.db.Close()
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// IdempotencyRecord 幂等请求记录，Completed 为false时表示第一次请求仍在处理中
+type IdempotencyRecord struct {
+	Key         string // 代理密钥和 Idempotency-Key 的哈希
+	BodyHash    string // 请求体的哈希
+	Completed   bool
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// createIdempotencyKeysTable 创建幂等请求记录表
+func (gdb *GroupsDB) createIdempotencyKeysTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key TEXT PRIMARY KEY,
+		body_hash TEXT NOT NULL,
+		completed BOOLEAN NOT NULL DEFAULT 0,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		content_type TEXT NOT NULL DEFAULT '',
+		body BLOB,
+		expires_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);`
+
+	if _, err := gdb.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create idempotency_keys table: %w", err)
+	}
+	return nil
+}
+
+// ClaimIdempotencyKey 占用幂等键，记录在 lease 内表示请求处理中
+// 已存在未过期的记录时不占用并返回该记录，占用成功时返回nil
+func (gdb *GroupsDB) ClaimIdempotencyKey(key, bodyHash string, lease time.Duration) (*IdempotencyRecord, error) {
+	now := time.Now()
+	if _, err := gdb.db.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= ?`, now); err != nil {
+		return nil, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+
+	result, err := gdb.db.Exec(`INSERT INTO idempotency_keys (key, body_hash, expires_at) VALUES (?, ?, ?)
+	ON CONFLICT(key) DO NOTHING`, key, bodyHash, now.Add(lease))
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		return nil, nil
+	}
+
+	record := &IdempotencyRecord{Key: key}
+	var body []byte
+	err = gdb.db.QueryRow(`SELECT body_hash, completed, status_code, content_type, body, expires_at FROM idempotency_keys WHERE key = ?`, key).
+		Scan(&record.BodyHash, &record.Completed, &record.StatusCode, &record.ContentType, &body, &record.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 记录在查询前被释放，重新占用
+		return gdb.ClaimIdempotencyKey(key, bodyHash, lease)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query idempotency key: %w", err)
+	}
+	record.Body = body
+	return record, nil
+}
+
+// SaveIdempotencyResponse 保存第一次请求的响应，在 ttl 内返回给重复请求
+func (gdb *GroupsDB) SaveIdempotencyResponse(record IdempotencyRecord, ttl time.Duration) error {
+	query := `
+	INSERT INTO idempotency_keys (key, body_hash, completed, status_code, content_type, body, expires_at)
+	VALUES (?, ?, 1, ?, ?, ?, ?)
+	ON CONFLICT(key) DO UPDATE SET
+		body_hash = excluded.body_hash,
+		completed = 1,
+		status_code = excluded.status_code,
+		content_type = excluded.content_type,
+		body = excluded.body,
+		expires_at = excluded.expires_at`
+
+	if _, err := gdb.db.Exec(query, record.Key, record.BodyHash, record.StatusCode, record.ContentType,
+		record.Body, time.Now().Add(ttl)); err != nil {
+		return fmt.Errorf("failed to save idempotency response: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey 释放处理中的幂等键，请求失败后客户端重试时重新发往上游
+func (gdb *GroupsDB) ReleaseIdempotencyKey(key string) error {
+	if _, err := gdb.db.Exec(`DELETE FROM idempotency_keys WHERE key = ? AND completed = 0`, key); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestGroupsDB 在临时目录中创建数据库
+func newTestGroupsDB(t *testing.T) *GroupsDB {
+	t.Helper()
+	db, err := NewGroupsDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("创建数据库失败: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestIdempotencyKeyLifecycle 测试幂等键的占用、处理中、保存响应和释放
+func TestIdempotencyKeyLifecycle(t *testing.T) {
+	db := newTestGroupsDB(t)
+
+	record, err := db.ClaimIdempotencyKey("key-1", "hash-a", time.Minute)
+	if err != nil || record != nil {
+		t.Fatalf("第一次占用应成功，record=%+v err=%v", record, err)
+	}
+
+	record, err = db.ClaimIdempotencyKey("key-1", "hash-b", time.Minute)
+	if err != nil || record == nil {
+		t.Fatalf("重复占用应返回已有记录，record=%+v err=%v", record, err)
+	}
+	if record.Completed || record.BodyHash != "hash-a" {
+		t.Errorf("处理中的记录应保留第一次请求的哈希，得到 %+v", record)
+	}
+
+	if err := db.SaveIdempotencyResponse(IdempotencyRecord{
+		Key: "key-1", BodyHash: "hash-a", StatusCode: 200, ContentType: "application/json", Body: []byte(`{"ok":true}`),
+	}, time.Minute); err != nil {
+		t.Fatalf("保存响应失败: %v", err)
+	}
+
+	// 已保存的响应不会被释放
+	if err := db.ReleaseIdempotencyKey("key-1"); err != nil {
+		t.Fatalf("释放幂等键失败: %v", err)
+	}
+	record, err = db.ClaimIdempotencyKey("key-1", "hash-a", time.Minute)
+	if err != nil || record == nil || !record.Completed {
+		t.Fatalf("应返回已保存的响应，record=%+v err=%v", record, err)
+	}
+	if record.StatusCode != 200 || record.ContentType != "application/json" || string(record.Body) != `{"ok":true}` {
+		t.Errorf("保存的响应不一致: %+v", record)
+	}
+}
+
+// TestIdempotencyKeyRelease 测试释放处理中的幂等键以及租约过期后可以重新占用
+func TestIdempotencyKeyRelease(t *testing.T) {
+	db := newTestGroupsDB(t)
+
+	if _, err := db.ClaimIdempotencyKey("key-1", "hash-a", time.Minute); err != nil {
+		t.Fatalf("占用幂等键失败: %v", err)
+	}
+	if err := db.ReleaseIdempotencyKey("key-1"); err != nil {
+		t.Fatalf("释放幂等键失败: %v", err)
+	}
+	if record, err := db.ClaimIdempotencyKey("key-1", "hash-b", time.Minute); err != nil || record != nil {
+		t.Errorf("释放后应可以重新占用，record=%+v err=%v", record, err)
+	}
+
+	if _, err := db.ClaimIdempotencyKey("key-2", "hash-a", -time.Second); err != nil {
+		t.Fatalf("占用幂等键失败: %v", err)
+	}
+	if record, err := db.ClaimIdempotencyKey("key-2", "hash-a", time.Minute); err != nil || record != nil {
+		t.Errorf("租约过期后应可以重新占用，record=%+v err=%v", record, err)
+	}
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// APIKeyMetadata 上游API密钥的标签、负责人、过期时间和备注，便于运维识别密钥
+// 保存分组时按密钥保留，分组导入导出和模板不包含这些信息
+type APIKeyMetadata struct {
+	Label     string     `json:"label,omitempty"`      // 标签，日志和状态中代替掩码密钥显示
+	Owner     string     `json:"owner,omitempty"`      // 负责人
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // 过期时间，只用于提示，不会自动禁用密钥
+	Note      string     `json:"note,omitempty"`       // 备注
+}
+
+// IsZero 判断是否未设置任何信息
+func (m APIKeyMetadata) IsZero() bool {
+	return m.Label == "" && m.Owner == "" && m.ExpiresAt == nil && m.Note == ""
+}
+
+// rowQuerier *sql.DB 和 *sql.Tx 共有的查询方法
+type rowQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryAPIKeyMetadata 查询设置了信息的密钥，结果按分组ID和密钥（引用密钥为配置中的引用）索引
+func queryAPIKeyMetadata(q rowQuerier, where string, args ...interface{}) (map[string]map[string]APIKeyMetadata, error) {
+	rows, err := q.Query(`SELECT group_id, api_key, label, owner, expires_at, note FROM provider_api_keys `+where+
+		` AND (label != '' OR owner != '' OR expires_at IS NOT NULL OR note != '')`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API key metadata: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[string]map[string]APIKeyMetadata)
+	for rows.Next() {
+		var groupID, apiKey string
+		var meta APIKeyMetadata
+		if err := rows.Scan(&groupID, &apiKey, &meta.Label, &meta.Owner, &meta.ExpiresAt, &meta.Note); err != nil {
+			return nil, fmt.Errorf("failed to scan API key metadata: %w", err)
+		}
+		if result[groupID] == nil {
+			result[groupID] = make(map[string]APIKeyMetadata)
+		}
+		result[groupID][apiKey] = meta
+	}
+	return result, rows.Err()
+}
+
+// LoadAllAPIKeyMetadata 加载所有分组中设置了信息的密钥
+func (gdb *GroupsDB) LoadAllAPIKeyMetadata() (map[string]map[string]APIKeyMetadata, error) {
+	return queryAPIKeyMetadata(gdb.db, "WHERE 1 = 1")
+}
+
+// GetAPIKeyMetadata 获取分组中设置了信息的密钥
+func (gdb *GroupsDB) GetAPIKeyMetadata(groupID string) (map[string]APIKeyMetadata, error) {
+	result, err := queryAPIKeyMetadata(gdb.db, "WHERE group_id = ?", groupID)
+	if err != nil {
+		return nil, err
+	}
+	return result[groupID], nil
+}
+
+// UpdateAPIKeyMetadata 设置分组中密钥的信息，整体替换原有信息
+func (gdb *GroupsDB) UpdateAPIKeyMetadata(groupID, apiKey string, meta APIKeyMetadata) error {
+	result, err := gdb.db.Exec(`UPDATE provider_api_keys SET label = ?, owner = ?, expires_at = ?, note = ?
+	WHERE group_id = ? AND api_key = ?`, meta.Label, meta.Owner, meta.ExpiresAt, meta.Note, groupID, apiKey)
+	if err != nil {
+		return fmt.Errorf("failed to update API key metadata: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("API key not found in group: %s", groupID)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RouterFailureRecord 路由器分组失败状态记录
+type RouterFailureRecord struct {
+	GroupID      string
+	Score        float64   // 衰减前的失败计数
+	UpdatedAt    time.Time // Score 对应的时间点
+	BlockedUntil time.Time
+	LastError    string
+	LastFailure  time.Time
+}
+
+// createRouterFailuresTable 创建路由失败状态表
+func (gdb *GroupsDB) createRouterFailuresTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS router_failures (
+		group_id TEXT PRIMARY KEY,
+		score REAL NOT NULL DEFAULT 0,
+		updated_at DATETIME NOT NULL,
+		blocked_until DATETIME,
+		last_error TEXT NOT NULL DEFAULT '',
+		last_failure DATETIME
+	);`
+
+	if _, err := gdb.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create router_failures table: %w", err)
+	}
+	return nil
+}
+
+// LoadRouterFailures 加载所有分组的路由失败状态
+func (gdb *GroupsDB) LoadRouterFailures() ([]RouterFailureRecord, error) {
+	rows, err := gdb.db.Query(`SELECT group_id, score, updated_at, blocked_until, last_error, last_failure FROM router_failures`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query router failures: %w", err)
+	}
+	defer rows.Close()
+
+	var records []RouterFailureRecord
+	for rows.Next() {
+		var record RouterFailureRecord
+		var blockedUntil, lastFailure sql.NullTime
+		if err := rows.Scan(&record.GroupID, &record.Score, &record.UpdatedAt, &blockedUntil, &record.LastError, &lastFailure); err != nil {
+			return nil, fmt.Errorf("failed to scan router failure: %w", err)
+		}
+		record.BlockedUntil = blockedUntil.Time
+		record.LastFailure = lastFailure.Time
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// SaveRouterFailure 保存分组的路由失败状态
+func (gdb *GroupsDB) SaveRouterFailure(record RouterFailureRecord) error {
+	query := `
+	INSERT INTO router_failures (group_id, score, updated_at, blocked_until, last_error, last_failure)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(group_id) DO UPDATE SET
+		score = excluded.score,
+		updated_at = excluded.updated_at,
+		blocked_until = excluded.blocked_until,
+		last_error = excluded.last_error,
+		last_failure = excluded.last_failure`
+
+	if _, err := gdb.db.Exec(query, record.GroupID, record.Score, record.UpdatedAt,
+		nullableTime(record.BlockedUntil), record.LastError, nullableTime(record.LastFailure)); err != nil {
+		return fmt.Errorf("failed to save router failure: %w", err)
+	}
+	return nil
+}
+
+// DeleteRouterFailure 清除分组的路由失败状态
+func (gdb *GroupsDB) DeleteRouterFailure(groupID string) error {
+	if _, err := gdb.db.Exec(`DELETE FROM router_failures WHERE group_id = ?`, groupID); err != nil {
+		return fmt.Errorf("failed to delete router failure: %w", err)
+	}
+	return nil
+}
+
+// nullableTime 零值时间存储为NULL
+func nullableTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"turnsapi/internal/database"
+
+	"gopkg.in/yaml.v3"
+)
+
+// GroupTemplate 分组模板：常用提供商的分组配置（不含API密钥），从模板创建分组时只需填写分组ID和密钥
+type GroupTemplate struct {
+	ID          string     `yaml:"id"`
+	Name        string     `yaml:"name"`
+	Description string     `yaml:"description,omitempty"`
+	Builtin     bool       `yaml:"builtin"` // 内置模板不能修改和删除
+	Group       *UserGroup `yaml:"group"`
+}
+
+// builtinGroupTemplates 内置的常用提供商模板
+var builtinGroupTemplates = []*GroupTemplate{
+	{
+		ID:          "openai",
+		Name:        "OpenAI",
+		Description: "OpenAI 官方API",
+		Group: &UserGroup{
+			Name:             "OpenAI",
+			ProviderType:     "openai",
+			BaseURL:          "https://api.openai.com/v1",
+			Enabled:          true,
+			Timeout:          30 * time.Second,
+			MaxRetries:       3,
+			RotationStrategy: "round_robin",
+			Headers:          map[string]string{"Content-Type": "application/json"},
+		},
+	},
+	{
+		ID:          "openrouter",
+		Name:        "OpenRouter",
+		Description: "OpenRouter，HTTP-Referer 和 X-Title 用于在OpenRouter中标识应用",
+		Group: &UserGroup{
+			Name:             "OpenRouter",
+			ProviderType:     "openrouter",
+			BaseURL:          "https://openrouter.ai/api/v1",
+			Enabled:          true,
+			Timeout:          45 * time.Second,
+			MaxRetries:       3,
+			RotationStrategy: "round_robin",
+			Headers: map[string]string{
+				"Content-Type": "application/json",
+				"HTTP-Referer": "https://your-domain.com",
+				"X-Title":      "TurnsAPI",
+			},
+		},
+	},
+	{
+		ID:          "anthropic",
+		Name:        "Anthropic",
+		Description: "Anthropic 官方API",
+		Group: &UserGroup{
+			Name:             "Anthropic",
+			ProviderType:     "anthropic",
+			BaseURL:          "https://api.anthropic.com",
+			Enabled:          true,
+			Timeout:          90 * time.Second,
+			MaxRetries:       2,
+			RotationStrategy: "round_robin",
+			Headers: map[string]string{
+				"Content-Type":      "application/json",
+				"anthropic-version": "2023-06-01",
+			},
+		},
+	},
+	{
+		ID:          "gemini",
+		Name:        "Google Gemini",
+		Description: "Gemini API",
+		Group: &UserGroup{
+			Name:             "Google Gemini",
+			ProviderType:     "gemini",
+			BaseURL:          "https://generativelanguage.googleapis.com/v1beta",
+			Enabled:          true,
+			Timeout:          60 * time.Second,
+			MaxRetries:       2,
+			RotationStrategy: "round_robin",
+			Headers:          map[string]string{"Content-Type": "application/json"},
+		},
+	},
+	{
+		ID:          "ollama",
+		Name:        "Ollama",
+		Description: "本地Ollama（OpenAI兼容接口），不需要API密钥",
+		Group: &UserGroup{
+			Name:             "Ollama",
+			ProviderType:     "openai_compatible",
+			BaseURL:          "http://localhost:11434/v1",
+			Enabled:          true,
+			Timeout:          120 * time.Second,
+			MaxRetries:       1,
+			RotationStrategy: "round_robin",
+			Headers:          map[string]string{"Content-Type": "application/json"},
+		},
+	},
+}
+
+// clone 复制模板，修改副本不影响原模板
+func (t *GroupTemplate) clone() *GroupTemplate {
+	clone := *t
+	clone.Group = t.Group.Clone()
+	return &clone
+}
+
+// isBuiltinGroupTemplate 模板ID是否属于内置模板
+func isBuiltinGroupTemplate(templateID string) bool {
+	for _, template := range builtinGroupTemplates {
+		if template.ID == templateID {
+			return true
+		}
+	}
+	return false
+}
+
+// ListGroupTemplates 列出内置模板和保存的模板
+func (cm *ConfigManager) ListGroupTemplates() ([]*GroupTemplate, error) {
+	templates := make([]*GroupTemplate, 0, len(builtinGroupTemplates))
+	for _, template := range builtinGroupTemplates {
+		template = template.clone()
+		template.Builtin = true
+		templates = append(templates, template)
+	}
+
+	records, err := cm.groupsDB.ListGroupTemplates()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		template, err := groupTemplateFromRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+// GetGroupTemplate 获取分组模板，不存在时返回nil
+func (cm *ConfigManager) GetGroupTemplate(templateID string) (*GroupTemplate, error) {
+	for _, template := range builtinGroupTemplates {
+		if template.ID == templateID {
+			template = template.clone()
+			template.Builtin = true
+			return template, nil
+		}
+	}
+
+	record, err := cm.groupsDB.LoadGroupTemplate(templateID)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	return groupTemplateFromRecord(*record)
+}
+
+// SaveGroupTemplate 保存分组模板，API密钥不保存在模板中
+func (cm *ConfigManager) SaveGroupTemplate(template *GroupTemplate) error {
+	template.ID = strings.TrimSpace(template.ID)
+	if template.ID == "" {
+		return fmt.Errorf("template id is required")
+	}
+	if isBuiltinGroupTemplate(template.ID) {
+		return fmt.Errorf("template %s is built in and cannot be modified", template.ID)
+	}
+	if template.Group == nil {
+		return fmt.Errorf("template group is required")
+	}
+	if template.Name == "" {
+		template.Name = template.Group.Name
+	}
+
+	group := template.Group.Clone()
+	group.APIKeys = nil
+	group.APIKeyRefs = nil
+	group.UnresolvedAPIKeys = nil
+	config, err := yaml.Marshal(group)
+	if err != nil {
+		return fmt.Errorf("failed to marshal template group: %w", err)
+	}
+
+	return cm.groupsDB.SaveGroupTemplate(database.GroupTemplateRecord{
+		ID:          template.ID,
+		Name:        template.Name,
+		Description: template.Description,
+		Config:      string(config),
+	})
+}
+
+// DeleteGroupTemplate 删除保存的分组模板
+func (cm *ConfigManager) DeleteGroupTemplate(templateID string) error {
+	if isBuiltinGroupTemplate(templateID) {
+		return fmt.Errorf("template %s is built in and cannot be deleted", templateID)
+	}
+	return cm.groupsDB.DeleteGroupTemplate(templateID)
+}
+
+// groupTemplateFromRecord 解析数据库中保存的模板
+func groupTemplateFromRecord(record database.GroupTemplateRecord) (*GroupTemplate, error) {
+	group := &UserGroup{}
+	if err := yaml.Unmarshal([]byte(record.Config), group); err != nil {
+		return nil, fmt.Errorf("failed to parse group template %s: %w", record.ID, err)
+	}
+	return &GroupTemplate{
+		ID:          record.ID,
+		Name:        record.Name,
+		Description: record.Description,
+		Group:       group,
+	}, nil
+}
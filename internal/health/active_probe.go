@@ -0,0 +1,214 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/providers"
+)
+
+// probeTickInterval 检查哪些分组到了探测时间的间隔，各分组的探测间隔由其配置决定
+const probeTickInterval = 5 * time.Second
+
+// ProbeStatus 分组主动健康探测状态
+type ProbeStatus struct {
+	Healthy              bool       `json:"healthy"`
+	Model                string     `json:"model"`
+	ConsecutiveFailures  int        `json:"consecutive_failures"`
+	ConsecutiveSuccesses int        `json:"consecutive_successes"`
+	LastProbe            time.Time  `json:"last_probe"`
+	LastLatencyMs        int64      `json:"last_latency_ms"`
+	LastError            string     `json:"last_error,omitempty"`
+	UnhealthySince       *time.Time `json:"unhealthy_since,omitempty"`
+}
+
+// probeState 单个分组的探测状态
+type probeState struct {
+	status    ProbeStatus
+	nextProbe time.Time
+	running   bool
+}
+
+// activeProber 按分组配置定期发送探测请求，连续失败达到阈值时将分组从路由中排除
+type activeProber struct {
+	mu     sync.Mutex
+	states map[string]*probeState
+	once   sync.Once
+}
+
+// StartActiveProbing 启动主动健康探测，配置了 health_probe 的分组按各自的间隔探测
+func (hc *MultiProviderHealthChecker) StartActiveProbing() {
+	hc.prober.once.Do(func() {
+		go func() {
+			ticker := time.NewTicker(probeTickInterval)
+			defer ticker.Stop()
+
+			hc.runDueProbes(time.Now())
+			for {
+				select {
+				case <-hc.ctx.Done():
+					return
+				case now := <-ticker.C:
+					hc.runDueProbes(now)
+				}
+			}
+		}()
+	})
+}
+
+// runDueProbes 探测到期的分组，并清理不再探测的分组的状态
+func (hc *MultiProviderHealthChecker) runDueProbes(now time.Time) {
+	config := hc.config.Snapshot()
+
+	hc.prober.mu.Lock()
+	defer hc.prober.mu.Unlock()
+
+	for groupID := range hc.prober.states {
+		if group, exists := config.UserGroups[groupID]; !exists || !probeEnabled(group) {
+			delete(hc.prober.states, groupID)
+			hc.providerRouter.SetGroupHealthy(groupID, true, "")
+		}
+	}
+
+	for groupID, group := range config.UserGroups {
+		if !probeEnabled(group) {
+			continue
+		}
+		state, exists := hc.prober.states[groupID]
+		if !exists {
+			state = &probeState{status: ProbeStatus{Healthy: true}}
+			hc.prober.states[groupID] = state
+		}
+		if state.running || now.Before(state.nextProbe) {
+			continue
+		}
+		state.running = true
+		state.nextProbe = now.Add(group.HealthProbe.Interval())
+		go hc.probeGroup(groupID, group)
+	}
+}
+
+// probeEnabled 分组是否启用了主动健康探测
+func probeEnabled(group *internal.UserGroup) bool {
+	return group.Enabled && group.HealthProbe != nil && group.HealthProbe.Enabled
+}
+
+// probeGroup 探测单个分组并更新状态
+func (hc *MultiProviderHealthChecker) probeGroup(groupID string, group *internal.UserGroup) {
+	probe := group.HealthProbe
+	model := probe.Model
+	if model == "" {
+		model = group.TestModel()
+	}
+
+	start := time.Now()
+	err := hc.sendProbe(groupID, group, model)
+	latency := time.Since(start)
+
+	hc.prober.mu.Lock()
+	defer hc.prober.mu.Unlock()
+
+	state, exists := hc.prober.states[groupID]
+	if !exists {
+		return // 探测期间分组被删除或关闭了探测
+	}
+	state.running = false
+	status := &state.status
+	status.Model = model
+	status.LastProbe = start
+	status.LastLatencyMs = latency.Milliseconds()
+
+	unhealthyThreshold, healthyThreshold := probe.Thresholds()
+	if err != nil {
+		status.ConsecutiveFailures++
+		status.ConsecutiveSuccesses = 0
+		status.LastError = err.Error()
+		if status.Healthy && status.ConsecutiveFailures >= unhealthyThreshold {
+			status.Healthy = false
+			now := time.Now()
+			status.UnhealthySince = &now
+			hc.providerRouter.SetGroupHealthy(groupID, false, status.LastError)
+			log.Printf("警告: 分组 %s 连续 %d 次主动探测失败，暂停参与路由: %v", groupID, status.ConsecutiveFailures, err)
+		}
+		return
+	}
+
+	status.ConsecutiveSuccesses++
+	status.ConsecutiveFailures = 0
+	status.LastError = ""
+	if !status.Healthy && status.ConsecutiveSuccesses >= healthyThreshold {
+		status.Healthy = true
+		hc.providerRouter.SetGroupHealthy(groupID, true, "")
+		if status.UnhealthySince != nil {
+			log.Printf("分组 %s 主动探测恢复正常（不健康持续 %v），重新参与路由", groupID, time.Since(*status.UnhealthySince).Round(time.Second))
+		}
+		status.UnhealthySince = nil
+	}
+}
+
+// sendProbe 使用分组的下一个密钥发送一次极短的聊天请求
+func (hc *MultiProviderHealthChecker) sendProbe(groupID string, group *internal.UserGroup, model string) error {
+	apiKey, err := hc.keyManager.GetNextKeyForGroup(groupID)
+	if err != nil {
+		return fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	providerConfig, err := hc.providerRouter.CreateProviderConfig(groupID, group)
+	if err != nil {
+		return fmt.Errorf("failed to create provider config: %w", err)
+	}
+	hc.providerRouter.UpdateProviderConfig(providerConfig, apiKey)
+	providerConfig.MaxRetries = 1
+
+	// 不使用缓存的提供商实例，确保使用本次选中的密钥
+	provider, err := providers.NewDefaultProviderFactory().CreateProvider(providerConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create provider: %w", err)
+	}
+
+	maxTokens := group.HealthProbe.ProbeMaxTokens()
+	req := providers.NormalizeRequestForModel(group.ProviderType, &providers.ChatCompletionRequest{
+		Model:     hc.providerRouter.ResolveModelName(model, groupID),
+		Messages:  []providers.ChatMessage{{Role: "user", Content: group.HealthProbe.ProbePrompt()}},
+		MaxTokens: &maxTokens,
+	})
+
+	ctx, cancel := context.WithTimeout(hc.ctx, group.HealthProbe.Timeout())
+	defer cancel()
+	if _, err := provider.ChatCompletion(ctx, req); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetProbeStatus 获取分组的主动健康探测状态，未启用探测的分组返回false
+func (hc *MultiProviderHealthChecker) GetProbeStatus(groupID string) (*ProbeStatus, bool) {
+	hc.prober.mu.Lock()
+	defer hc.prober.mu.Unlock()
+
+	state, exists := hc.prober.states[groupID]
+	if !exists {
+		return nil, false
+	}
+	status := state.status
+	return &status, true
+}
+
+// withProbeStatus 复制健康状态并附上主动探测结果，探测判定为不健康时以探测结果为准
+func (hc *MultiProviderHealthChecker) withProbeStatus(status *ProviderHealthStatus) *ProviderHealthStatus {
+	probe, exists := hc.GetProbeStatus(status.GroupID)
+	if !exists {
+		return status
+	}
+	copied := *status
+	copied.Probe = probe
+	if !probe.Healthy {
+		copied.Healthy = false
+		copied.LastError = probe.LastError
+	}
+	return &copied
+}
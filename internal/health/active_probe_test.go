@@ -0,0 +1,132 @@
+package health
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/keymanager"
+	"turnsapi/internal/providers"
+	"turnsapi/internal/router"
+)
+
+// newProbeTestChecker 创建探测 upstream 的健康检查器，upstream 在 failing 为真时返回500
+func newProbeTestChecker(t *testing.T, failing *atomic.Bool, probe *internal.HealthProbe) (*MultiProviderHealthChecker, *internal.Config) {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if failing.Load() {
+			http.Error(w, `{"error":{"message":"upstream down"}}`, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      "chatcmpl-probe",
+			"object":  "chat.completion",
+			"model":   "gpt-4o",
+			"choices": []map[string]interface{}{{"index": 0, "message": map[string]string{"role": "assistant", "content": "pong"}, "finish_reason": "stop"}},
+		})
+	}))
+	t.Cleanup(upstream.Close)
+
+	cfg := &internal.Config{UserGroups: map[string]*internal.UserGroup{
+		"group_a": {
+			Name:         "group_a",
+			ProviderType: "openai",
+			BaseURL:      upstream.URL,
+			Enabled:      true,
+			Timeout:      5 * time.Second,
+			Models:       []string{"gpt-4o"},
+			APIKeys:      []string{"sk-group_a"},
+			HealthProbe:  probe,
+		},
+	}}
+	providerManager := providers.NewProviderManager(providers.NewDefaultProviderFactory())
+	hc := NewMultiProviderHealthChecker(cfg, keymanager.NewMultiGroupKeyManager(cfg), providerManager, router.NewProviderRouter(cfg, providerManager))
+	t.Cleanup(hc.cancel)
+	return hc, cfg
+}
+
+// probeOnce 同步执行一次到期的探测
+func probeOnce(hc *MultiProviderHealthChecker) {
+	hc.runDueProbes(time.Now())
+	hc.prober.mu.Lock()
+	state := hc.prober.states["group_a"]
+	state.nextProbe = time.Time{}
+	hc.prober.mu.Unlock()
+
+	for {
+		hc.prober.mu.Lock()
+		running := state.running
+		hc.prober.mu.Unlock()
+		if !running {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestActiveProbeThresholds 测试连续失败达到阈值后分组不参与路由，连续成功达到阈值后恢复
+func TestActiveProbeThresholds(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	hc, _ := newProbeTestChecker(t, &failing, &internal.HealthProbe{Enabled: true, TimeoutSeconds: 5, UnhealthyThreshold: 2, HealthyThreshold: 2})
+
+	probeOnce(hc)
+	status, exists := hc.GetProbeStatus("group_a")
+	if !exists || !status.Healthy || status.ConsecutiveFailures != 1 || status.LastError == "" {
+		t.Fatalf("一次失败未达到阈值时应仍然健康，得到 %+v", status)
+	}
+	if !hc.providerRouter.IsGroupHealthy("group_a") {
+		t.Fatal("未达到阈值时分组应继续参与路由")
+	}
+
+	probeOnce(hc)
+	status, _ = hc.GetProbeStatus("group_a")
+	if status.Healthy || status.UnhealthySince == nil {
+		t.Fatalf("连续2次失败后应标记为不健康，得到 %+v", status)
+	}
+	if hc.providerRouter.IsGroupHealthy("group_a") {
+		t.Fatal("不健康的分组不应参与路由")
+	}
+
+	failing.Store(false)
+	probeOnce(hc)
+	if status, _ = hc.GetProbeStatus("group_a"); status.Healthy || status.ConsecutiveSuccesses != 1 {
+		t.Fatalf("一次成功未达到恢复阈值时应仍然不健康，得到 %+v", status)
+	}
+	probeOnce(hc)
+	status, _ = hc.GetProbeStatus("group_a")
+	if !status.Healthy || status.LastError != "" || status.UnhealthySince != nil || status.Model != "gpt-4o" {
+		t.Fatalf("连续2次成功后应恢复健康，得到 %+v", status)
+	}
+	if !hc.providerRouter.IsGroupHealthy("group_a") {
+		t.Error("恢复后分组应重新参与路由")
+	}
+}
+
+// TestActiveProbeDisabledClearsState 测试关闭探测后清理状态并恢复分组路由
+func TestActiveProbeDisabledClearsState(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	hc, cfg := newProbeTestChecker(t, &failing, &internal.HealthProbe{Enabled: true, TimeoutSeconds: 5, UnhealthyThreshold: 1})
+
+	probeOnce(hc)
+	if hc.providerRouter.IsGroupHealthy("group_a") {
+		t.Fatal("达到阈值后分组不应参与路由")
+	}
+
+	cfg.UserGroups["group_a"].HealthProbe.Enabled = false
+	hc.runDueProbes(time.Now())
+	if _, exists := hc.GetProbeStatus("group_a"); exists {
+		t.Error("关闭探测后不应保留探测状态")
+	}
+	if !hc.providerRouter.IsGroupHealthy("group_a") {
+		t.Error("关闭探测后分组应恢复参与路由")
+	}
+}
@@ -31,6 +31,8 @@ type ProviderHealthStatus struct {
 	TotalKeys    int                    `json:"total_keys"`
 	ActiveKeys   int                    `json:"active_keys"`
 	KeyStatuses  map[string]interface{} `json:"key_statuses,omitempty"`
+	Skipped      bool                   `json:"skipped,omitempty"` // 分组配置为跳过健康检查
+	Probe        *ProbeStatus           `json:"probe,omitempty"`   // 主动健康探测状态，未启用探测时为空
 }
 
 // SystemHealthStatus 系统健康状态
@@ -53,7 +55,7 @@ type SystemHealthStatus struct {
 
 // MultiProviderHealthChecker 多提供商健康检查器
 type MultiProviderHealthChecker struct {
-	config          *internal.Config
+	config          internal.ConfigSource
 	keyManager      *keymanager.MultiGroupKeyManager
 	providerManager *providers.ProviderManager
 	providerRouter  *router.ProviderRouter
@@ -75,11 +77,14 @@ type MultiProviderHealthChecker struct {
 	checkTimeout time.Duration
 	ctx          context.Context
 	cancel       context.CancelFunc
+
+	// 主动健康探测
+	prober activeProber
 }
 
 // NewMultiProviderHealthChecker 创建多提供商健康检查器
 func NewMultiProviderHealthChecker(
-	config *internal.Config,
+	config internal.ConfigSource,
 	keyManager *keymanager.MultiGroupKeyManager,
 	providerManager *providers.ProviderManager,
 	providerRouter *router.ProviderRouter,
@@ -96,9 +101,9 @@ func NewMultiProviderHealthChecker(
 		checkTimeout:    10 * time.Second, // 默认10秒超时
 		ctx:             ctx,
 		cancel:          cancel,
+		prober:          activeProber{states: make(map[string]*probeState)},
 	}
 
-
 	return checker
 }
 
@@ -110,14 +115,15 @@ func (hc *MultiProviderHealthChecker) GetSystemHealth() *SystemHealthStatus {
 	// 移除自动触发逻辑，只返回缓存的状态
 	// 健康检查现在只在手动刷新或首次添加分组时执行
 
-	totalGroups := len(hc.config.UserGroups)
+	config := hc.config.Snapshot()
+	totalGroups := len(config.UserGroups)
 	enabledGroups := 0
 	disabledGroups := 0
 	totalKeys := 0
 	activeKeys := 0
 
 	// 统计分组状态
-	for _, group := range hc.config.UserGroups {
+	for _, group := range config.UserGroups {
 		if group.Enabled {
 			enabledGroups++
 		} else {
@@ -131,11 +137,11 @@ func (hc *MultiProviderHealthChecker) GetSystemHealth() *SystemHealthStatus {
 	// 统计密钥状态，只包含当前配置中的分组
 	for groupID, status := range hc.healthStatuses {
 		// 检查分组是否仍然存在于配置中
-		if _, exists := hc.config.UserGroups[groupID]; !exists {
+		if _, exists := config.UserGroups[groupID]; !exists {
 			continue // 跳过已删除的分组
 		}
 
-		currentGroupStatuses[groupID] = status
+		currentGroupStatuses[groupID] = hc.withProbeStatus(status)
 
 		if status.Enabled {
 			totalKeys += status.TotalKeys
@@ -173,12 +179,19 @@ func (hc *MultiProviderHealthChecker) GetProviderHealth(groupID string) (*Provid
 	defer hc.mutex.RUnlock()
 
 	status, exists := hc.healthStatuses[groupID]
-	return status, exists
+	if !exists {
+		// 尚未执行过手动健康检查时以主动探测结果为准
+		if _, probed := hc.GetProbeStatus(groupID); !probed {
+			return nil, false
+		}
+		status = &ProviderHealthStatus{GroupID: groupID, Enabled: true, Healthy: true}
+	}
+	return hc.withProbeStatus(status), true
 }
 
 // CheckProviderHealth 检查特定提供商的健康状态
 func (hc *MultiProviderHealthChecker) CheckProviderHealth(groupID string) *ProviderHealthStatus {
-	group, exists := hc.config.GetGroupByID(groupID)
+	group, exists := hc.config.Snapshot().GetGroupByID(groupID)
 	if !exists {
 		return &ProviderHealthStatus{
 			GroupID:   groupID,
@@ -224,6 +237,13 @@ func (hc *MultiProviderHealthChecker) CheckProviderHealth(groupID string) *Provi
 		return status
 	}
 
+	// 配置为跳过健康检查的分组不发送测试请求，视为健康
+	if group.SkipHealthCheck {
+		status.Healthy = true
+		status.Skipped = true
+		return status
+	}
+
 	// 执行实际的健康检查
 	err := hc.performProviderHealthCheck(groupID, group)
 
@@ -269,12 +289,19 @@ func (hc *MultiProviderHealthChecker) performProviderHealthCheck(groupID string,
 
 	// 创建提供商配置
 	providerConfig := &providers.ProviderConfig{
-		BaseURL:      group.BaseURL,
-		APIKey:       apiKey,
-		Timeout:      group.Timeout,
-		MaxRetries:   1, // 健康检查只尝试一次
-		Headers:      group.Headers,
-		ProviderType: group.ProviderType,
+		BaseURL:             group.BaseURL,
+		APIKey:              apiKey,
+		Timeout:             group.Timeout,
+		MaxRetries:          1, // 健康检查只尝试一次
+		Headers:             group.Headers,
+		ProviderType:        group.ProviderType,
+		ChatCompletionsPath: group.ChatCompletionsPath,
+		ModelsPath:          group.ModelsPath,
+		ProxyURL:            group.ProxyURL,
+		TLS:                 group.TLS.ProviderOptions(),
+		VertexAI:            group.VertexAI.ProviderOptions(),
+		Transport:           group.Transport.ProviderOptions(),
+		HealthCheckModel:    group.ProviderHealthCheckModel(),
 	}
 
 	// 获取提供商实例
@@ -312,10 +339,11 @@ func (hc *MultiProviderHealthChecker) PerformHealthCheck() {
 	defer hc.mutex.Unlock()
 
 	hc.lastSystemCheck = time.Now()
+	config := hc.config.Snapshot()
 
 	// 清理已删除分组的健康状态
 	for groupID := range hc.healthStatuses {
-		if _, exists := hc.config.UserGroups[groupID]; !exists {
+		if _, exists := config.UserGroups[groupID]; !exists {
 			delete(hc.healthStatuses, groupID)
 			log.Printf("清理已删除分组的健康状态: %s", groupID)
 		}
@@ -323,9 +351,9 @@ func (hc *MultiProviderHealthChecker) PerformHealthCheck() {
 
 	// 并发检查所有启用的分组
 	var wg sync.WaitGroup
-	statusChan := make(chan *ProviderHealthStatus, len(hc.config.UserGroups))
+	statusChan := make(chan *ProviderHealthStatus, len(config.UserGroups))
 
-	for groupID := range hc.config.UserGroups {
+	for groupID := range config.UserGroups {
 		wg.Add(1)
 		go func(gid string) {
 			defer wg.Done()
@@ -0,0 +1,179 @@
+// Package hooks 提供分组级别的请求/响应钩子
+//
+// 钩子在代码中注册，分组在配置的 hooks 中按名称启用，用于在不修改代理核心的情况下
+// 注入租户请求头、改写提示词或实现自定义计费。注册需要在加载配置之前完成，通常在 init 中调用 Register：
+//
+//	func init() {
+//		hooks.Register("tenant-headers", tenantHeaders{})
+//	}
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"turnsapi/internal/providers"
+)
+
+// Request 单次上游尝试的请求信息，同一次尝试的前置和后置钩子共享同一个实例
+type Request struct {
+	GroupID      string
+	ProviderType string
+	ProxyKeyID   string
+	RequestID    string
+	Body         *providers.ChatCompletionRequest // 发往上游的请求（已完成模型映射和系统提示词注入），前置钩子可以直接修改
+	Header       http.Header                      // 额外发往上游的请求头，覆盖分组配置的同名请求头
+	Metadata     map[string]string                // 在前置和后置钩子之间传递数据
+}
+
+// Response 单次上游尝试的结果
+type Response struct {
+	Stream      bool
+	TotalTokens int           // 上游报告的token总数，未报告时为0
+	Duration    time.Duration // 从发出请求到响应结束的耗时
+	Err         error         // 请求失败时的错误，成功时为nil
+}
+
+// PreRequestHook 在请求发往上游之前调用，返回错误时拒绝本次请求
+type PreRequestHook interface {
+	PreRequest(ctx context.Context, req *Request) error
+}
+
+// PostResponseHook 在上游响应结束（成功或失败）之后调用
+type PostResponseHook interface {
+	PostResponse(ctx context.Context, req *Request, resp *Response)
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]interface{})
+)
+
+// Register 注册钩子，hook 需要实现 PreRequestHook 和 PostResponseHook 中的至少一个
+// 名称为空、重复或钩子未实现任何接口时panic，与 database/sql.Register 相同
+func Register(name string, hook interface{}) {
+	if name == "" {
+		panic("hooks: Register called with empty name")
+	}
+	_, isPre := hook.(PreRequestHook)
+	_, isPost := hook.(PostResponseHook)
+	if !isPre && !isPost {
+		panic(fmt.Sprintf("hooks: %s implements neither PreRequestHook nor PostResponseHook", name))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("hooks: Register called twice for %s", name))
+	}
+	registry[name] = hook
+}
+
+// Registered 判断钩子是否已注册
+func Registered(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, exists := registry[name]
+	return exists
+}
+
+// Names 获取已注册的钩子名称，按名称排序
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Chain 分组启用的钩子，按配置顺序调用
+type Chain struct {
+	names []string
+	hooks []interface{}
+}
+
+// Resolve 按名称查找钩子，未注册的名称（配置加载时已校验，一般不会出现）记录警告后忽略
+// 没有可用钩子时返回nil
+func Resolve(names []string) *Chain {
+	if len(names) == 0 {
+		return nil
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+
+	chain := &Chain{}
+	for _, name := range names {
+		hook, exists := registry[name]
+		if !exists {
+			log.Printf("警告: 钩子 %s 未注册，已忽略", name)
+			continue
+		}
+		chain.names = append(chain.names, name)
+		chain.hooks = append(chain.hooks, hook)
+	}
+	if len(chain.hooks) == 0 {
+		return nil
+	}
+	return chain
+}
+
+// PreRequest 依次调用前置钩子，任一钩子返回错误或panic时停止并返回错误
+func (ch *Chain) PreRequest(ctx context.Context, req *Request) error {
+	if ch == nil {
+		return nil
+	}
+	for i, hook := range ch.hooks {
+		pre, ok := hook.(PreRequestHook)
+		if !ok {
+			continue
+		}
+		if err := callPreRequest(ctx, ch.names[i], pre, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PostResponse 依次调用后置钩子，钩子panic时记录日志并继续调用后续钩子
+func (ch *Chain) PostResponse(ctx context.Context, req *Request, resp *Response) {
+	if ch == nil {
+		return
+	}
+	for i, hook := range ch.hooks {
+		if post, ok := hook.(PostResponseHook); ok {
+			callPostResponse(ctx, ch.names[i], post, req, resp)
+		}
+	}
+}
+
+// callPreRequest 调用单个前置钩子，panic转换为错误
+func callPreRequest(ctx context.Context, name string, hook PreRequestHook, req *Request) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("钩子 %s 的前置处理发生panic: %v", name, r)
+			err = fmt.Errorf("hook %s panicked", name)
+		}
+	}()
+	if err := hook.PreRequest(ctx, req); err != nil {
+		return fmt.Errorf("hook %s: %w", name, err)
+	}
+	return nil
+}
+
+// callPostResponse 调用单个后置钩子，panic只记录日志
+func callPostResponse(ctx context.Context, name string, hook PostResponseHook, req *Request, resp *Response) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("钩子 %s 的后置处理发生panic: %v", name, r)
+		}
+	}()
+	hook.PostResponse(ctx, req, resp)
+}
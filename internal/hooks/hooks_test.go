@@ -0,0 +1,93 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type recordingHook struct {
+	calls *[]string
+	err   error
+}
+
+func (h recordingHook) PreRequest(ctx context.Context, req *Request) error {
+	*h.calls = append(*h.calls, "pre")
+	req.Metadata["seen"] = "yes"
+	return h.err
+}
+
+func (h recordingHook) PostResponse(ctx context.Context, req *Request, resp *Response) {
+	*h.calls = append(*h.calls, "post:"+req.Metadata["seen"])
+}
+
+type panicHook struct{}
+
+func (panicHook) PreRequest(ctx context.Context, req *Request) error {
+	panic("boom")
+}
+
+// registerForTest 注册测试用的钩子，测试结束后从注册表中移除
+func registerForTest(t *testing.T, name string, hook interface{}) {
+	t.Helper()
+	Register(name, hook)
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(registry, name)
+	})
+}
+
+// TestChain 测试钩子按配置顺序调用，前置钩子出错时停止并返回带钩子名称的错误
+func TestChain(t *testing.T) {
+	var calls []string
+	registerForTest(t, "test-recording", recordingHook{calls: &calls})
+	registerForTest(t, "test-rejecting", recordingHook{calls: &calls, err: errors.New("tenant suspended")})
+	registerForTest(t, "test-panic", panicHook{})
+
+	if !Registered("test-recording") || Registered("test-missing") {
+		t.Fatalf("Registered 结果不正确")
+	}
+
+	chain := Resolve([]string{"test-recording", "test-missing"})
+	req := &Request{Metadata: make(map[string]string)}
+	if err := chain.PreRequest(context.Background(), req); err != nil {
+		t.Fatalf("前置钩子不应返回错误: %v", err)
+	}
+	chain.PostResponse(context.Background(), req, &Response{})
+	if strings.Join(calls, ",") != "pre,post:yes" {
+		t.Errorf("钩子调用顺序不正确: %v", calls)
+	}
+
+	err := Resolve([]string{"test-rejecting", "test-recording"}).PreRequest(context.Background(), &Request{Metadata: make(map[string]string)})
+	if err == nil || !strings.Contains(err.Error(), "hook test-rejecting: tenant suspended") {
+		t.Errorf("拒绝请求的错误不正确: %v", err)
+	}
+
+	if err := Resolve([]string{"test-panic"}).PreRequest(context.Background(), &Request{}); err == nil {
+		t.Errorf("钩子panic应转换为错误")
+	}
+
+	if Resolve([]string{"test-missing"}) != nil || Resolve(nil) != nil {
+		t.Errorf("没有可用钩子时应返回nil")
+	}
+}
+
+// TestRegisterInvalid 测试注册未实现任何接口或重复的钩子时panic
+func TestRegisterInvalid(t *testing.T) {
+	assertPanics := func(name string, fn func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s 应该panic", name)
+			}
+		}()
+		fn()
+	}
+
+	assertPanics("未实现接口", func() { Register("test-invalid", struct{}{}) })
+	assertPanics("空名称", func() { Register("", panicHook{}) })
+	registerForTest(t, "test-duplicate", panicHook{})
+	assertPanics("重复注册", func() { Register("test-duplicate", panicHook{}) })
+}
@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"turnsapi/internal/database"
+)
+
+// 密钥信息字段的长度上限（字符数）
+const (
+	maxAPIKeyLabelLength = 64
+	maxAPIKeyOwnerLength = 64
+	maxAPIKeyNoteLength  = 500
+)
+
+// ValidateAPIKeyMetadata 检查密钥的标签、负责人和备注
+func ValidateAPIKeyMetadata(meta database.APIKeyMetadata) error {
+	if utf8.RuneCountInString(meta.Label) > maxAPIKeyLabelLength {
+		return fmt.Errorf("label must not exceed %d characters", maxAPIKeyLabelLength)
+	}
+	if strings.ContainsAny(meta.Label, "\r\n\t") {
+		return fmt.Errorf("label must not contain line breaks or tabs")
+	}
+	if utf8.RuneCountInString(meta.Owner) > maxAPIKeyOwnerLength {
+		return fmt.Errorf("owner must not exceed %d characters", maxAPIKeyOwnerLength)
+	}
+	if utf8.RuneCountInString(meta.Note) > maxAPIKeyNoteLength {
+		return fmt.Errorf("note must not exceed %d characters", maxAPIKeyNoteLength)
+	}
+	return nil
+}
+
+// ParseAPIKeyExpiry 解析密钥过期时间，支持 2006-01-02（当天结束时过期）和 RFC3339 格式，空字符串表示不过期
+func ParseAPIKeyExpiry(value string) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		expiresAt := date.AddDate(0, 0, 1).Add(-time.Second)
+		return &expiresAt, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expires_at %q: use YYYY-MM-DD or RFC3339", value)
+	}
+	return &expiresAt, nil
+}
+
+// KeyMetadata 获取密钥的标签等信息，key 为解析后的密钥
+func (g *UserGroup) KeyMetadata(key string) database.APIKeyMetadata {
+	return g.APIKeyMetadata[g.ConfiguredAPIKey(key)]
+}
+
+// APIKeyLabel 获取密钥的标签，未设置时返回空字符串
+func (g *UserGroup) APIKeyLabel(key string) string {
+	return g.KeyMetadata(key).Label
+}
+
+// APIKeyLabel 在所有分组中查找密钥的标签，用于日志中代替掩码密钥显示，未设置时返回空字符串
+// 已发布的快照使用发布时构建的索引，其余配置遍历所有分组
+func (c *Config) APIKeyLabel(key string) string {
+	if c == nil || key == "" {
+		return ""
+	}
+	if c.apiKeyLabels != nil {
+		return c.apiKeyLabels[key]
+	}
+	for _, group := range c.UserGroups {
+		if label := group.APIKeyLabel(key); label != "" {
+			return label
+		}
+	}
+	return ""
+}
+
+// buildAPIKeyLabels 构建所有分组中密钥到标签的索引
+func buildAPIKeyLabels(groups map[string]*UserGroup) map[string]string {
+	labels := make(map[string]string)
+	for _, group := range groups {
+		for _, key := range group.APIKeys {
+			if label := group.APIKeyLabel(key); label != "" {
+				if _, exists := labels[key]; !exists {
+					labels[key] = label
+				}
+			}
+		}
+	}
+	return labels
+}
+
+// loadAPIKeyMetadata 从数据库加载分组中密钥的标签等信息
+func (cm *ConfigManager) loadAPIKeyMetadata(groupID string, group *UserGroup) {
+	metadata, err := cm.groupsDB.GetAPIKeyMetadata(groupID)
+	if err != nil {
+		log.Printf("警告: 加载分组 %s 的密钥信息失败: %v", groupID, err)
+		return
+	}
+	group.APIKeyMetadata = metadata
+}
+
+// SetAPIKeyMetadata 设置分组中密钥的标签等信息，key 为解析后的密钥，返回更新后的分组配置
+func (cm *ConfigManager) SetAPIKeyMetadata(groupID, key string, meta database.APIKeyMetadata) (*UserGroup, error) {
+	if err := ValidateAPIKeyMetadata(meta); err != nil {
+		return nil, err
+	}
+
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	group, exists := cm.config.UserGroups[groupID]
+	if !exists {
+		return nil, fmt.Errorf("group not found: %s", groupID)
+	}
+	configuredKey := group.ConfiguredAPIKey(key)
+	if err := cm.groupsDB.UpdateAPIKeyMetadata(groupID, configuredKey, meta); err != nil {
+		return nil, err
+	}
+
+	updated := group.Clone()
+	if updated.APIKeyMetadata == nil {
+		updated.APIKeyMetadata = make(map[string]database.APIKeyMetadata)
+	}
+	if meta.IsZero() {
+		delete(updated.APIKeyMetadata, configuredKey)
+	} else {
+		updated.APIKeyMetadata[configuredKey] = meta
+	}
+	cm.config.UserGroups[groupID] = updated
+	cm.publishLocked()
+	return updated.Clone(), nil
+}
@@ -0,0 +1,62 @@
+package keymanager
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRateLimitCooldown 测试限流冷却时长的计算
+func TestRateLimitCooldown(t *testing.T) {
+	tests := []struct {
+		count      int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{count: 1, want: 2 * time.Second},
+		{count: 2, want: 4 * time.Second},
+		{count: 4, want: 16 * time.Second},
+		{count: 20, want: rateLimitCooldownMax},
+		{count: 3, retryAfter: 30 * time.Second, want: 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := rateLimitCooldown(tt.count, tt.retryAfter); got != tt.want {
+			t.Errorf("rateLimitCooldown(%d, %v) = %v, want %v", tt.count, tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+// TestGroupKeyManagerCooldown 测试被限流的密钥在冷却期内不参与选择，成功后重置连续限流次数
+func TestGroupKeyManagerCooldown(t *testing.T) {
+	gkm := NewGroupKeyManager("group1", "Test Group", []string{"key1", "key2"}, "round_robin")
+
+	until := gkm.Cooldown("key1", 0)
+	if time.Until(until) <= 0 {
+		t.Fatalf("密钥应进入冷却期，截止时间: %v", until)
+	}
+
+	for i := 0; i < 4; i++ {
+		key, err := gkm.GetNextKey()
+		if err != nil {
+			t.Fatalf("获取密钥失败: %v", err)
+		}
+		if key != "key2" {
+			t.Errorf("冷却中的密钥不应被选择，得到 %s", key)
+		}
+	}
+
+	gkm.Cooldown("key1", 0)
+	if count := gkm.GetKeyStatuses()["key1"].RateLimitCount; count != 2 {
+		t.Errorf("连续限流次数应为2，实际为 %d", count)
+	}
+
+	gkm.ReportSuccess("key1")
+	if count := gkm.GetKeyStatuses()["key1"].RateLimitCount; count != 0 {
+		t.Errorf("成功后连续限流次数应清零，实际为 %d", count)
+	}
+
+	keyless := NewKeylessGroupKeyManager("local", "Local")
+	if until := keyless.Cooldown("", time.Minute); !until.IsZero() {
+		t.Errorf("无需密钥的分组不应冷却，截止时间: %v", until)
+	}
+}
@@ -31,7 +31,13 @@ type KeyStatus struct {
 	LastErrorTime   time.Time  `json:"last_error_time,omitempty"`
 	ValidationError string     `json:"validation_error,omitempty"` // 验证错误信息
 	UpdatedAt       time.Time  `json:"updated_at"`                 // 状态更新时间
+	BackoffUntil    time.Time  `json:"backoff_until,omitempty"`    // 上游限流退避截止时间
+	RateLimitCount  int        `json:"rate_limit_count,omitempty"` // 连续被上游限流（429）的次数，成功后清零
 	AllowedModels   []string   `json:"allowed_models,omitempty"`
+	Label           string     `json:"label,omitempty"`      // 密钥标签，设置后作为密钥名称
+	Owner           string     `json:"owner,omitempty"`      // 密钥负责人
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"` // 密钥过期时间
+	Note            string     `json:"note,omitempty"`       // 备注
 }
 
 // KeyInfo API密钥信息
@@ -395,7 +401,7 @@ func (km *KeyManager) AddKeysInBatch(keys []string) (int, []string, error) {
 	}
 
 	// 检查输入列表内部的重复
-	keySet := make(map[string]int) // 记录每个密钥第一次出现的位置
+	keySet := make(map[string]int)               // 记录每个密钥第一次出现的位置
 	internalDuplicates := make(map[string][]int) // 记录内部重复的位置
 
 	for i, key := range keys {
@@ -810,12 +816,12 @@ func (km *KeyManager) updateConfigFile() error {
 // checkKeyDuplication 检查密钥是否重复（内部方法，调用时需要已获得锁）
 func (km *KeyManager) checkKeyDuplication(newKeys []string) []string {
 	var duplicates []string
-	
+
 	for _, newKey := range newKeys {
 		if strings.TrimSpace(newKey) == "" {
 			continue
 		}
-		
+
 		for _, existingKey := range km.keys {
 			if existingKey == newKey {
 				duplicates = append(duplicates, newKey)
@@ -823,7 +829,7 @@ func (km *KeyManager) checkKeyDuplication(newKeys []string) []string {
 			}
 		}
 	}
-	
+
 	return duplicates
 }
 
@@ -831,7 +837,6 @@ func (km *KeyManager) checkKeyDuplication(newKeys []string) []string {
 func (km *KeyManager) CheckKeyDuplication(newKeys []string) []string {
 	km.mutex.RLock()
 	defer km.mutex.RUnlock()
-	
+
 	return km.checkKeyDuplication(newKeys)
 }
-
@@ -21,6 +21,11 @@ type DuplicateKeyInfo struct {
 	DuplicateIndex int    `json:"duplicate_index"` // 重复索引位置（用于内部重复）
 }
 
+// RotationCursor 多实例共享的轮询游标，NextIndex 每次调用递增并返回分组的游标值
+type RotationCursor interface {
+	NextIndex(groupID string) (int64, error)
+}
+
 // GroupKeyManager 分组密钥管理器
 type GroupKeyManager struct {
 	groupID          string
@@ -30,6 +35,8 @@ type GroupKeyManager struct {
 	keyStatuses      map[string]*KeyStatus
 	rotationStrategy string
 	currentIndex     int
+	cursor           RotationCursor // 共享轮询游标，为空时使用本地索引
+	keyless          bool           // 无需密钥的分组（本地OpenAI兼容后端），只有一个空密钥，不轮换、不禁用、不退避
 	mutex            sync.RWMutex
 }
 
@@ -69,11 +76,67 @@ func NewGroupKeyManager(groupID, groupName string, keys []string, rotationStrate
 	return gkm
 }
 
+// NewKeylessGroupKeyManager 创建无需密钥的分组的密钥管理器，请求使用空密钥发送
+func NewKeylessGroupKeyManager(groupID, groupName string) *GroupKeyManager {
+	gkm := NewGroupKeyManager(groupID, groupName, []string{""}, "")
+	gkm.keyInfos[""].Name = fmt.Sprintf("%s-无密钥", groupName)
+	gkm.keyStatuses[""].Name = gkm.keyInfos[""].Name
+	gkm.keyless = true
+	return gkm
+}
+
+// newGroupKeyManagerForConfig 按分组配置创建密钥管理器，分组禁用或没有可用密钥时返回 nil
+func newGroupKeyManagerForConfig(groupID string, group *internal.UserGroup) *GroupKeyManager {
+	if !group.Enabled {
+		return nil
+	}
+	if group.IsKeyless() {
+		return NewKeylessGroupKeyManager(groupID, group.Name)
+	}
+	if len(group.APIKeys) == 0 {
+		return nil
+	}
+	gkm := NewGroupKeyManager(groupID, group.Name, group.APIKeys, group.RotationStrategy)
+	for _, key := range group.APIKeys {
+		if meta := group.KeyMetadata(key); !meta.IsZero() {
+			gkm.SetKeyMetadata(key, meta)
+		}
+	}
+	return gkm
+}
+
+// SetKeyMetadata 设置密钥的标签等信息，设置了标签时用标签作为密钥名称
+func (gkm *GroupKeyManager) SetKeyMetadata(apiKey string, meta database.APIKeyMetadata) {
+	gkm.mutex.Lock()
+	defer gkm.mutex.Unlock()
+
+	info, exists := gkm.keyInfos[apiKey]
+	if !exists || gkm.keyless {
+		return
+	}
+	info.Name = fmt.Sprintf("%s-Key-%s", gkm.groupName, getSafeKeySuffix(apiKey))
+	if meta.Label != "" {
+		info.Name = meta.Label
+	}
+	status := gkm.keyStatuses[apiKey]
+	status.Name = info.Name
+	status.Label = meta.Label
+	status.Owner = meta.Owner
+	status.ExpiresAt = meta.ExpiresAt
+	status.Note = meta.Note
+}
+
 // GetNextKey 获取下一个可用的API密钥
 func (gkm *GroupKeyManager) GetNextKey() (string, error) {
 	gkm.mutex.Lock()
 	defer gkm.mutex.Unlock()
 
+	if gkm.keyless {
+		gkm.keyStatuses[""].LastUsed = time.Now()
+		gkm.keyStatuses[""].UsageCount++
+		return "", nil
+	}
+
 	activeKeys := gkm.getActiveKeys()
 	if len(activeKeys) == 0 {
 		return "", fmt.Errorf("no active API keys available in group %s", gkm.groupID)
@@ -104,8 +167,9 @@ func (gkm *GroupKeyManager) GetNextKey() (string, error) {
 // getActiveKeys 获取所有活跃的密钥
 func (gkm *GroupKeyManager) getActiveKeys() []string {
 	var activeKeys []string
+	now := time.Now()
 	for _, key := range gkm.keys {
-		if status, exists := gkm.keyStatuses[key]; exists && status.IsActive {
+		if status, exists := gkm.keyStatuses[key]; exists && status.IsActive && !now.Before(status.BackoffUntil) {
 			activeKeys = append(activeKeys, key)
 		}
 	}
@@ -118,6 +182,15 @@ func (gkm *GroupKeyManager) roundRobinSelection(activeKeys []string) string {
 		return ""
 	}
 
+	// 多实例部署时使用共享游标，各实例轮流使用同一组密钥
+	if gkm.cursor != nil {
+		n, err := gkm.cursor.NextIndex(gkm.groupID)
+		if err == nil {
+			return activeKeys[int((n-1)%int64(len(activeKeys)))]
+		}
+		log.Printf("共享轮询游标不可用，分组 %s 使用本地轮询: %v", gkm.groupID, err)
+	}
+
 	// 找到当前索引对应的密钥在活跃密钥中的位置
 	currentKey := ""
 	if gkm.currentIndex < len(gkm.keys) {
@@ -184,6 +257,7 @@ func (gkm *GroupKeyManager) ReportSuccess(apiKey string) {
 
 	if status, exists := gkm.keyStatuses[apiKey]; exists {
 		status.LastUsed = time.Now()
+		status.RateLimitCount = 0
 		// 成功使用不增加错误计数，但可以重置连续错误状态
 		if status.ErrorCount > 0 {
 			log.Printf("密钥 %s (分组: %s) 恢复正常", gkm.maskKey(apiKey), gkm.groupID)
@@ -204,14 +278,69 @@ func (gkm *GroupKeyManager) ReportError(apiKey string, errorMsg string) {
 		log.Printf("密钥 %s (分组: %s) 发生错误: %s (错误次数: %d)",
 			gkm.maskKey(apiKey), gkm.groupID, errorMsg, status.ErrorCount)
 
-		// 如果错误次数过多，可以考虑暂时禁用密钥
-		if status.ErrorCount >= 15 {
+		// 如果错误次数过多，可以考虑暂时禁用密钥；无需密钥的分组没有其他密钥可换，不禁用
+		if status.ErrorCount >= 15 && !gkm.keyless {
 			status.IsActive = false
 			log.Printf("密钥 %s (分组: %s) 因错误过多被暂时禁用", gkm.maskKey(apiKey), gkm.groupID)
 		}
 	}
 }
 
+// SetBackoff 设置密钥的退避截止时间（上游限流、额度耗尽或认证失败），在此之前该密钥不参与选择
+func (gkm *GroupKeyManager) SetBackoff(apiKey string, until time.Time) {
+	gkm.mutex.Lock()
+	defer gkm.mutex.Unlock()
+
+	if gkm.keyless {
+		return
+	}
+	if status, exists := gkm.keyStatuses[apiKey]; exists {
+		if until.After(status.BackoffUntil) {
+			status.BackoffUntil = until
+		}
+		log.Printf("密钥 %s (分组: %s) 进入退避期，暂停使用至 %s",
+			gkm.maskKey(apiKey), gkm.groupID, status.BackoffUntil.Format("15:04:05"))
+	}
+}
+
+// 上游限流冷却时长：上游未给出 Retry-After 时按连续限流次数指数增长
+const (
+	rateLimitCooldownBase = 2 * time.Second
+	rateLimitCooldownMax  = 2 * time.Minute
+)
+
+// rateLimitCooldown 计算第 count 次连续限流的冷却时长，retryAfter 大于0时以上游给出的时间为准
+func rateLimitCooldown(count int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	cooldown := rateLimitCooldownBase
+	for i := 1; i < count && cooldown < rateLimitCooldownMax; i++ {
+		cooldown *= 2
+	}
+	return min(cooldown, rateLimitCooldownMax)
+}
+
+// Cooldown 密钥被上游限流（429）后进入冷却期，返回冷却截止时间
+// 冷却时长优先使用上游的 Retry-After，否则按连续限流次数指数退避；无需密钥的分组不冷却
+func (gkm *GroupKeyManager) Cooldown(apiKey string, retryAfter time.Duration) time.Time {
+	gkm.mutex.Lock()
+	defer gkm.mutex.Unlock()
+
+	status, exists := gkm.keyStatuses[apiKey]
+	if !exists || gkm.keyless {
+		return time.Time{}
+	}
+	status.RateLimitCount++
+	until := time.Now().Add(rateLimitCooldown(status.RateLimitCount, retryAfter))
+	if until.After(status.BackoffUntil) {
+		status.BackoffUntil = until
+	}
+	log.Printf("密钥 %s (分组: %s) 被上游限流（连续 %d 次），冷却至 %s",
+		gkm.maskKey(apiKey), gkm.groupID, status.RateLimitCount, status.BackoffUntil.Format("15:04:05"))
+	return status.BackoffUntil
+}
+
 // GetKeyStatuses 获取所有密钥状态
 func (gkm *GroupKeyManager) GetKeyStatuses() map[string]*KeyStatus {
 	gkm.mutex.RLock()
@@ -232,12 +361,17 @@ func (gkm *GroupKeyManager) GetGroupInfo() map[string]interface{} {
 	defer gkm.mutex.RUnlock()
 
 	activeCount := 0
+	coolingCount := 0
 	totalCount := len(gkm.keys)
 
+	now := time.Now()
 	for _, status := range gkm.keyStatuses {
 		if status.IsActive {
 			activeCount++
 		}
+		if now.Before(status.BackoffUntil) {
+			coolingCount++
+		}
 	}
 
 	return map[string]interface{}{
@@ -245,6 +379,7 @@ func (gkm *GroupKeyManager) GetGroupInfo() map[string]interface{} {
 		"group_name":        gkm.groupName,
 		"total_keys":        totalCount,
 		"active_keys":       activeCount,
+		"cooling_keys":      coolingCount,
 		"rotation_strategy": gkm.rotationStrategy,
 	}
 }
@@ -272,21 +407,22 @@ func randomInt(max int) int {
 
 // MultiGroupKeyManager 多分组密钥管理器
 type MultiGroupKeyManager struct {
-	config        *internal.Config
+	config        internal.ConfigSource
 	groupManagers map[string]*GroupKeyManager
 	database      *database.GroupsDB // 添加数据库连接
+	cursor        RotationCursor     // 共享轮询游标
 	mutex         sync.RWMutex
 	ctx           context.Context
 	cancel        context.CancelFunc
 }
 
 // NewMultiGroupKeyManager 创建多分组密钥管理器
-func NewMultiGroupKeyManager(config *internal.Config) *MultiGroupKeyManager {
+func NewMultiGroupKeyManager(config internal.ConfigSource) *MultiGroupKeyManager {
 	return NewMultiGroupKeyManagerWithDB(config, nil)
 }
 
 // NewMultiGroupKeyManagerWithDB 创建带数据库连接的多分组密钥管理器
-func NewMultiGroupKeyManagerWithDB(config *internal.Config, db *database.GroupsDB) *MultiGroupKeyManager {
+func NewMultiGroupKeyManagerWithDB(config internal.ConfigSource, db *database.GroupsDB) *MultiGroupKeyManager {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	mgkm := &MultiGroupKeyManager{
@@ -298,15 +434,13 @@ func NewMultiGroupKeyManagerWithDB(config *internal.Config, db *database.GroupsD
 	}
 
 	// 初始化所有分组的密钥管理器
-	for groupID, group := range config.UserGroups {
-		if group.Enabled && len(group.APIKeys) > 0 {
-			groupManager := NewGroupKeyManager(groupID, group.Name, group.APIKeys, group.RotationStrategy)
-			
+	for groupID, group := range config.Snapshot().UserGroups {
+		if groupManager := newGroupKeyManagerForConfig(groupID, group); groupManager != nil {
 			// 如果有数据库连接，从数据库加载密钥验证状态
 			if db != nil {
 				mgkm.loadKeyValidationStatusFromDB(groupID, groupManager)
 			}
-			
+
 			mgkm.groupManagers[groupID] = groupManager
 		}
 	}
@@ -314,6 +448,19 @@ func NewMultiGroupKeyManagerWithDB(config *internal.Config, db *database.GroupsD
 	return mgkm
 }
 
+// SetRotationCursor 设置多实例共享的轮询游标，对已有和之后创建的分组生效
+func (mgkm *MultiGroupKeyManager) SetRotationCursor(cursor RotationCursor) {
+	mgkm.mutex.Lock()
+	defer mgkm.mutex.Unlock()
+
+	mgkm.cursor = cursor
+	for _, groupManager := range mgkm.groupManagers {
+		groupManager.mutex.Lock()
+		groupManager.cursor = cursor
+		groupManager.mutex.Unlock()
+	}
+}
+
 // GetNextKeyForGroup 获取指定分组的下一个可用密钥
 func (mgkm *MultiGroupKeyManager) GetNextKeyForGroup(groupID string) (string, error) {
 	mgkm.mutex.RLock()
@@ -330,7 +477,7 @@ func (mgkm *MultiGroupKeyManager) GetNextKeyForGroup(groupID string) (string, er
 // GetNextKeyForModel 根据模型名称获取合适分组的下一个可用密钥
 func (mgkm *MultiGroupKeyManager) GetNextKeyForModel(modelName string) (string, string, error) {
 	// 查找支持该模型的分组
-	group, groupID := mgkm.config.GetGroupByModel(modelName)
+	group, groupID := mgkm.config.Snapshot().GetGroupByModel(modelName)
 	if group == nil {
 		return "", "", fmt.Errorf("no enabled group found for model %s", modelName)
 	}
@@ -365,6 +512,29 @@ func (mgkm *MultiGroupKeyManager) ReportError(groupID, apiKey string, errorMsg s
 	}
 }
 
+// SetKeyBackoff 设置指定分组密钥的限流退避截止时间
+func (mgkm *MultiGroupKeyManager) SetKeyBackoff(groupID, apiKey string, until time.Time) {
+	mgkm.mutex.RLock()
+	groupManager, exists := mgkm.groupManagers[groupID]
+	mgkm.mutex.RUnlock()
+
+	if exists {
+		groupManager.SetBackoff(apiKey, until)
+	}
+}
+
+// CooldownKey 指定分组的密钥被上游限流后进入冷却期，返回冷却截止时间，分组不存在时返回零值
+func (mgkm *MultiGroupKeyManager) CooldownKey(groupID, apiKey string, retryAfter time.Duration) time.Time {
+	mgkm.mutex.RLock()
+	groupManager, exists := mgkm.groupManagers[groupID]
+	mgkm.mutex.RUnlock()
+
+	if !exists {
+		return time.Time{}
+	}
+	return groupManager.Cooldown(apiKey, retryAfter)
+}
+
 // GetAllGroupStatuses 获取所有分组的状态
 func (mgkm *MultiGroupKeyManager) GetAllGroupStatuses() map[string]interface{} {
 	mgkm.mutex.RLock()
@@ -405,9 +575,9 @@ func (mgkm *MultiGroupKeyManager) UpdateGroupConfig(groupID string, group *inter
 		// 删除分组管理器
 		delete(mgkm.groupManagers, groupID)
 		log.Printf("删除分组 %s 的密钥管理器", groupID)
-	} else if group.Enabled && len(group.APIKeys) > 0 {
+	} else if groupManager := newGroupKeyManagerForConfig(groupID, mgkm.withKeyMetadata(groupID, group)); groupManager != nil {
 		// 创建或更新分组管理器
-		groupManager := NewGroupKeyManager(groupID, group.Name, group.APIKeys, group.RotationStrategy)
+		groupManager.cursor = mgkm.cursor
 		mgkm.groupManagers[groupID] = groupManager
 		log.Printf("更新分组 %s 的密钥管理器", groupID)
 	} else {
@@ -419,6 +589,31 @@ func (mgkm *MultiGroupKeyManager) UpdateGroupConfig(groupID string, group *inter
 	return nil
 }
 
+// withKeyMetadata 调用方传入的分组配置没有密钥信息时使用当前配置中该分组的密钥信息
+func (mgkm *MultiGroupKeyManager) withKeyMetadata(groupID string, group *internal.UserGroup) *internal.UserGroup {
+	if group.APIKeyMetadata != nil {
+		return group
+	}
+	current, exists := mgkm.config.Snapshot().UserGroups[groupID]
+	if !exists || current.APIKeyMetadata == nil {
+		return group
+	}
+	withMetadata := *group
+	withMetadata.APIKeyMetadata = current.APIKeyMetadata
+	return &withMetadata
+}
+
+// SetKeyMetadata 设置分组中密钥的标签等信息，不影响密钥的使用统计和状态
+func (mgkm *MultiGroupKeyManager) SetKeyMetadata(groupID, apiKey string, meta database.APIKeyMetadata) {
+	mgkm.mutex.RLock()
+	groupManager, exists := mgkm.groupManagers[groupID]
+	mgkm.mutex.RUnlock()
+
+	if exists {
+		groupManager.SetKeyMetadata(apiKey, meta)
+	}
+}
+
 // UpdateKeyStatus 实时更新密钥状态（基于实际请求结果）
 func (mgkm *MultiGroupKeyManager) UpdateKeyStatus(groupID, apiKey string, isSuccess bool, errorMsg string) {
 	mgkm.mutex.RLock()
@@ -453,17 +648,17 @@ func (mgkm *MultiGroupKeyManager) ForceSetKeyStatus(groupID, apiKey string, isVa
 		status.IsValid = &isValid
 		status.ValidationError = reason
 		status.UpdatedAt = time.Now()
-		
+
 		// 如果设置为有效，清除错误信息并激活密钥
 		if isValid {
 			status.IsActive = true
 			status.ErrorCount = 0
 			status.LastError = ""
 		}
-		
+
 		log.Printf("强制设置密钥状态: 分组=%s, 密钥=%s, 有效=%v, 原因=%s",
 			groupID, groupManager.maskKey(apiKey), isValid, reason)
-		
+
 		return nil
 	}
 
@@ -554,12 +749,12 @@ func (mgkm *MultiGroupKeyManager) GetKeyValidationSummary(groupID string) (map[s
 	}
 
 	return map[string]interface{}{
-		"group_id":      groupID,
-		"group_name":    groupManager.groupName,
-		"total_keys":    totalCount,
-		"valid_keys":    validCount,
-		"invalid_keys":  invalidCount,
-		"unknown_keys":  unknownCount,
+		"group_id":     groupID,
+		"group_name":   groupManager.groupName,
+		"total_keys":   totalCount,
+		"valid_keys":   validCount,
+		"invalid_keys": invalidCount,
+		"unknown_keys": unknownCount,
 	}, nil
 }
 
@@ -607,7 +802,7 @@ func (mgkm *MultiGroupKeyManager) CheckKeyDuplication(newKeys []string) map[stri
 		}
 
 		var foundInGroups []string
-		
+
 		// 检查所有分组中的密钥
 		for groupID, groupManager := range mgkm.groupManagers {
 			for _, existingKey := range groupManager.keys {
@@ -663,11 +858,11 @@ func (mgkm *MultiGroupKeyManager) GetAllKeysAcrossGroups() map[string][]string {
 func (mgkm *MultiGroupKeyManager) ValidateKeysForGroup(groupID string, newKeys []string) (validKeys []string, groupDuplicates []DuplicateKeyInfo, internalDuplicates []DuplicateKeyInfo) {
 	mgkm.mutex.RLock()
 	defer mgkm.mutex.RUnlock()
-	
+
 	validKeys = make([]string, 0)
 	groupDuplicates = make([]DuplicateKeyInfo, 0)
 	internalDuplicates = make([]DuplicateKeyInfo, 0)
-	
+
 	// 获取目标分组现有密钥
 	var existingKeys map[string]bool
 	if groupManager, exists := mgkm.groupManagers[groupID]; exists {
@@ -678,16 +873,16 @@ func (mgkm *MultiGroupKeyManager) ValidateKeysForGroup(groupID string, newKeys [
 	} else {
 		existingKeys = make(map[string]bool)
 	}
-	
+
 	// 用于跟踪输入列表中的重复
 	seenInInput := make(map[string]int) // key -> first occurrence index
-	
+
 	for i, key := range newKeys {
 		key = strings.TrimSpace(key)
 		if key == "" {
 			continue
 		}
-		
+
 		// 检查输入列表内部重复
 		if firstIndex, exists := seenInInput[key]; exists {
 			internalDuplicates = append(internalDuplicates, DuplicateKeyInfo{
@@ -699,7 +894,7 @@ func (mgkm *MultiGroupKeyManager) ValidateKeysForGroup(groupID string, newKeys [
 			continue
 		}
 		seenInInput[key] = i
-		
+
 		// 检查与分组内现有密钥的重复
 		if existingKeys[key] {
 			groupDuplicates = append(groupDuplicates, DuplicateKeyInfo{
@@ -710,11 +905,11 @@ func (mgkm *MultiGroupKeyManager) ValidateKeysForGroup(groupID string, newKeys [
 			})
 			continue
 		}
-		
+
 		// 密钥有效，添加到结果中
 		validKeys = append(validKeys, key)
 	}
-	
+
 	return validKeys, groupDuplicates, internalDuplicates
 }
 
@@ -744,19 +939,19 @@ func (mgkm *MultiGroupKeyManager) loadKeyValidationStatusFromDB(groupID string,
 					invalidCount++
 				}
 			}
-			
+
 			// 更新验证错误信息
 			if validationError, ok := status["validation_error"].(*string); ok && validationError != nil {
 				keyStatus.ValidationError = *validationError
 			}
-			
+
 			// 更新最后验证时间
 			if lastValidatedAt, ok := status["last_validated_at"].(*string); ok && lastValidatedAt != nil {
 				if parsedTime, err := time.Parse("2006-01-02 15:04:05", *lastValidatedAt); err == nil {
 					keyStatus.LastValidated = &parsedTime
 				}
 			}
-			
+
 			keyStatus.UpdatedAt = time.Now()
 		}
 	}
@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LegacyGroupID 兼容单提供商旧版本配置时生成的默认分组ID，旧版本的请求日志迁移后也归入该分组
+const LegacyGroupID = "openrouter_default"
+
+// legacyDefaultBaseURL 旧版本固定使用的OpenRouter地址
+const legacyDefaultBaseURL = "https://openrouter.ai/api/v1"
+
+// MigrateLegacyConfigFile 将单提供商旧版本的配置文件（顶层 openrouter 和 api_keys）改写为 user_groups 格式
+// 原文件备份为 <path>.legacy-<时间>.bak，其余配置项和注释保持不变；已是新格式时不做修改，返回false
+// 改写失败（如配置文件只读挂载）不影响启动，LoadConfig 仍会在内存中把旧配置转换为默认分组
+func MigrateLegacyConfigFile(configPath string) (bool, error) {
+	info, err := os.Stat(configPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat config file: %w", err)
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return false, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return false, nil
+	}
+	root := doc.Content[0]
+
+	openRouter := mappingValue(root, "openrouter")
+	apiKeys := mappingValue(root, "api_keys")
+	userGroups := mappingValue(root, "user_groups")
+	if openRouter == nil && apiKeys == nil {
+		return false, nil
+	}
+	if userGroups != nil && len(userGroups.Content) > 0 {
+		log.Printf("警告: 配置文件同时包含 user_groups 和旧版 openrouter/api_keys 配置，旧版配置项不会生效，请手动删除")
+		return false, nil
+	}
+
+	// 与 LoadConfig 的兼容规则一致：有密钥或自定义了地址才视为旧版配置
+	keys := mappingValue(apiKeys, "keys")
+	baseURL := mappingValue(openRouter, "base_url")
+	if (keys == nil || len(keys.Content) == 0) && (baseURL == nil || baseURL.Value == "") {
+		return false, nil
+	}
+
+	group := &yaml.Node{Kind: yaml.MappingNode}
+	appendMappingValue(group, "name", scalarNode("OpenRouter (默认)"))
+	appendMappingValue(group, "provider_type", scalarNode("openai"))
+	if baseURL == nil || baseURL.Value == "" {
+		baseURL = scalarNode(legacyDefaultBaseURL)
+	}
+	appendMappingValue(group, "base_url", baseURL)
+	appendMappingValue(group, "enabled", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+	for _, field := range []string{"timeout", "max_retries"} {
+		if value := mappingValue(openRouter, field); value != nil {
+			appendMappingValue(group, field, value)
+		}
+	}
+	if strategy := mappingValue(apiKeys, "rotation_strategy"); strategy != nil {
+		appendMappingValue(group, "rotation_strategy", strategy)
+	}
+	if keys == nil {
+		keys = &yaml.Node{Kind: yaml.SequenceNode}
+	}
+	appendMappingValue(group, "api_keys", keys)
+
+	groups := &yaml.Node{Kind: yaml.MappingNode}
+	appendMappingValue(groups, LegacyGroupID, group)
+
+	// user_groups 放在第一个旧配置项的位置，删除其余旧配置项
+	var content []*yaml.Node
+	replaced := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "openrouter", "api_keys", "user_groups":
+			if !replaced {
+				content = append(content, &yaml.Node{
+					Kind:        yaml.ScalarNode,
+					Value:       "user_groups",
+					HeadComment: "由旧版 openrouter/api_keys 配置自动迁移",
+				}, groups)
+				replaced = true
+			}
+		default:
+			content = append(content, key, value)
+		}
+	}
+	root.Content = content
+
+	var out strings.Builder
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return false, fmt.Errorf("failed to encode migrated config: %w", err)
+	}
+	encoder.Close()
+
+	backupPath := fmt.Sprintf("%s.legacy-%s.bak", configPath, time.Now().Format("20060102150405"))
+	if err := os.WriteFile(backupPath, data, info.Mode().Perm()); err != nil {
+		return false, fmt.Errorf("failed to back up legacy config: %w", err)
+	}
+	// 直接覆盖写入而不是重命名，配置文件可能是单独挂载进容器的
+	if err := os.WriteFile(configPath, []byte(out.String()), info.Mode().Perm()); err != nil {
+		return false, fmt.Errorf("failed to write migrated config: %w", err)
+	}
+	log.Printf("旧版配置已备份到 %s", backupPath)
+	return true, nil
+}
+
+// mappingValue 获取YAML映射节点中指定键的值，不存在时返回nil
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// appendMappingValue 在YAML映射节点末尾追加键值
+func appendMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	mapping.Content = append(mapping.Content, scalarNode(key), value)
+}
+
+// scalarNode 创建字符串标量节点
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
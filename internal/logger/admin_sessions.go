@@ -0,0 +1,141 @@
+package logger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrAdminSessionNotFound 登录会话不存在、已撤销或已过期
+var ErrAdminSessionNotFound = errors.New("admin session not found")
+
+// adminSessionColumns 登录会话查询的列
+const adminSessionColumns = `id, username, user_id, role, auth_method, two_factor, client_ip, user_agent, created_at, last_seen_at, expires_at`
+
+// InsertAdminSession 插入登录会话
+func (d *Database) InsertAdminSession(session *AdminSession) error {
+	query := `
+	INSERT INTO admin_sessions (` + adminSessionColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	if _, err := d.exec(query, session.ID, session.Username, session.UserID, session.Role, session.AuthMethod,
+		session.TwoFactor, session.ClientIP, session.UserAgent, session.CreatedAt, session.LastSeenAt, session.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to insert admin session: %w", err)
+	}
+	return nil
+}
+
+// GetAdminSession 根据ID获取未过期的登录会话
+func (d *Database) GetAdminSession(id string) (*AdminSession, error) {
+	session, err := scanAdminSession(d.queryRow(`SELECT `+adminSessionColumns+` FROM admin_sessions WHERE id = ? AND expires_at > ?`, id, time.Now()))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAdminSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get admin session: %w", err)
+	}
+	return session, nil
+}
+
+// GetAdminSessions 获取所有未过期的登录会话，按最近活动时间倒序
+func (d *Database) GetAdminSessions() ([]*AdminSession, error) {
+	rows, err := d.query(`SELECT `+adminSessionColumns+` FROM admin_sessions WHERE expires_at > ? ORDER BY last_seen_at DESC`, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query admin sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []*AdminSession{}
+	for rows.Next() {
+		session, err := scanAdminSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan admin session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchAdminSession 更新登录会话的最近活动时间、过期时间和两步验证状态，会话不存在时返回false
+func (d *Database) TouchAdminSession(session *AdminSession) (bool, error) {
+	result, err := d.exec(`UPDATE admin_sessions SET last_seen_at = ?, expires_at = ?, two_factor = ? WHERE id = ?`,
+		session.LastSeenAt, session.ExpiresAt, session.TwoFactor, session.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to update admin session: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// DeleteAdminSession 删除登录会话
+func (d *Database) DeleteAdminSession(id string) error {
+	if _, err := d.exec(`DELETE FROM admin_sessions WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete admin session: %w", err)
+	}
+	return nil
+}
+
+// DeleteAdminSessionsByUserID 删除用户除 exceptID 外的所有登录会话，返回删除数量
+func (d *Database) DeleteAdminSessionsByUserID(userID, exceptID string) (int64, error) {
+	result, err := d.exec(`DELETE FROM admin_sessions WHERE user_id = ? AND id <> ?`, userID, exceptID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete admin sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// DeleteExpiredAdminSessions 删除已过期的登录会话
+func (d *Database) DeleteExpiredAdminSessions() (int64, error) {
+	result, err := d.exec(`DELETE FROM admin_sessions WHERE expires_at <= ?`, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired admin sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// scanAdminSession 扫描登录会话行
+func scanAdminSession(row interface{ Scan(...interface{}) error }) (*AdminSession, error) {
+	session := &AdminSession{}
+	if err := row.Scan(&session.ID, &session.Username, &session.UserID, &session.Role, &session.AuthMethod,
+		&session.TwoFactor, &session.ClientIP, &session.UserAgent, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// InsertAdminSession 插入登录会话
+func (r *RequestLogger) InsertAdminSession(session *AdminSession) error {
+	return r.db.InsertAdminSession(session)
+}
+
+// GetAdminSession 根据ID获取未过期的登录会话
+func (r *RequestLogger) GetAdminSession(id string) (*AdminSession, error) {
+	return r.db.GetAdminSession(id)
+}
+
+// GetAdminSessions 获取所有未过期的登录会话
+func (r *RequestLogger) GetAdminSessions() ([]*AdminSession, error) {
+	return r.db.GetAdminSessions()
+}
+
+// TouchAdminSession 更新登录会话的活动时间
+func (r *RequestLogger) TouchAdminSession(session *AdminSession) (bool, error) {
+	return r.db.TouchAdminSession(session)
+}
+
+// DeleteAdminSession 删除登录会话
+func (r *RequestLogger) DeleteAdminSession(id string) error {
+	return r.db.DeleteAdminSession(id)
+}
+
+// DeleteAdminSessionsByUserID 删除用户除 exceptID 外的所有登录会话
+func (r *RequestLogger) DeleteAdminSessionsByUserID(userID, exceptID string) (int64, error) {
+	return r.db.DeleteAdminSessionsByUserID(userID, exceptID)
+}
+
+// DeleteExpiredAdminSessions 删除已过期的登录会话
+func (r *RequestLogger) DeleteExpiredAdminSessions() (int64, error) {
+	return r.db.DeleteExpiredAdminSessions()
+}
@@ -0,0 +1,175 @@
+package logger
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// adminUserColumns 管理用户查询的列
+const adminUserColumns = `id, username, password_hash, role, disabled, created_at, updated_at, last_login_at, totp_secret, totp_enabled, recovery_codes`
+
+// InsertAdminUser 插入管理用户
+func (d *Database) InsertAdminUser(user *AdminUser) error {
+	query := `
+	INSERT INTO admin_users (` + adminUserColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	if _, err := d.exec(query, user.ID, user.Username, user.PasswordHash, user.Role, user.Disabled,
+		user.CreatedAt, user.UpdatedAt, user.LastLoginAt, user.TOTPSecret, user.TOTPEnabled,
+		marshalModelPatterns(user.RecoveryCodes)); err != nil {
+		return fmt.Errorf("failed to insert admin user: %w", err)
+	}
+	return nil
+}
+
+// GetAdminUserByUsername 根据用户名获取管理用户
+func (d *Database) GetAdminUserByUsername(username string) (*AdminUser, error) {
+	user, err := scanAdminUser(d.queryRow(`SELECT `+adminUserColumns+` FROM admin_users WHERE username = ?`, username))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("admin user not found")
+		}
+		return nil, fmt.Errorf("failed to get admin user: %w", err)
+	}
+	return user, nil
+}
+
+// GetAdminUser 根据ID获取管理用户
+func (d *Database) GetAdminUser(id string) (*AdminUser, error) {
+	user, err := scanAdminUser(d.queryRow(`SELECT `+adminUserColumns+` FROM admin_users WHERE id = ?`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("admin user not found")
+		}
+		return nil, fmt.Errorf("failed to get admin user: %w", err)
+	}
+	return user, nil
+}
+
+// GetAllAdminUsers 获取所有管理用户
+func (d *Database) GetAllAdminUsers() ([]*AdminUser, error) {
+	rows, err := d.query(`SELECT ` + adminUserColumns + ` FROM admin_users ORDER BY created_at, username`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query admin users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []*AdminUser{}
+	for rows.Next() {
+		user, err := scanAdminUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan admin user: %w", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// UpdateAdminUser 更新管理用户的密码哈希、角色和禁用状态
+func (d *Database) UpdateAdminUser(user *AdminUser) error {
+	query := `
+	UPDATE admin_users SET password_hash = ?, role = ?, disabled = ?, updated_at = ?
+	WHERE id = ?
+	`
+
+	result, err := d.exec(query, user.PasswordHash, user.Role, user.Disabled, user.UpdatedAt, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update admin user: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("admin user not found")
+	}
+	return nil
+}
+
+// UpdateAdminUserLastLogin 更新管理用户最后登录时间
+func (d *Database) UpdateAdminUserLastLogin(id string) error {
+	if _, err := d.exec(`UPDATE admin_users SET last_login_at = ? WHERE id = ?`, time.Now(), id); err != nil {
+		return fmt.Errorf("failed to update admin user last login: %w", err)
+	}
+	return nil
+}
+
+// UpdateAdminUserTOTP 更新管理用户的两步验证密钥、启用状态和恢复码
+func (d *Database) UpdateAdminUserTOTP(user *AdminUser) error {
+	query := `
+	UPDATE admin_users SET totp_secret = ?, totp_enabled = ?, recovery_codes = ?, updated_at = ?
+	WHERE id = ?
+	`
+
+	result, err := d.exec(query, user.TOTPSecret, user.TOTPEnabled, marshalModelPatterns(user.RecoveryCodes), user.UpdatedAt, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update admin user two-factor settings: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("admin user not found")
+	}
+	return nil
+}
+
+// DeleteAdminUser 删除管理用户
+func (d *Database) DeleteAdminUser(id string) error {
+	result, err := d.exec(`DELETE FROM admin_users WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete admin user: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("admin user not found")
+	}
+	return nil
+}
+
+// scanAdminUser 扫描管理用户行
+func scanAdminUser(row interface{ Scan(...interface{}) error }) (*AdminUser, error) {
+	user := &AdminUser{}
+	var totpSecret, recoveryCodes sql.NullString
+	if err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.Disabled,
+		&user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt, &totpSecret, &user.TOTPEnabled, &recoveryCodes); err != nil {
+		return nil, err
+	}
+	user.TOTPSecret = totpSecret.String
+	user.RecoveryCodes = unmarshalModelPatterns(recoveryCodes)
+	return user, nil
+}
+
+// InsertAdminUser 插入管理用户
+func (r *RequestLogger) InsertAdminUser(user *AdminUser) error {
+	return r.db.InsertAdminUser(user)
+}
+
+// GetAdminUserByUsername 根据用户名获取管理用户
+func (r *RequestLogger) GetAdminUserByUsername(username string) (*AdminUser, error) {
+	return r.db.GetAdminUserByUsername(username)
+}
+
+// GetAdminUser 根据ID获取管理用户
+func (r *RequestLogger) GetAdminUser(id string) (*AdminUser, error) {
+	return r.db.GetAdminUser(id)
+}
+
+// GetAllAdminUsers 获取所有管理用户
+func (r *RequestLogger) GetAllAdminUsers() ([]*AdminUser, error) {
+	return r.db.GetAllAdminUsers()
+}
+
+// UpdateAdminUser 更新管理用户
+func (r *RequestLogger) UpdateAdminUser(user *AdminUser) error {
+	return r.db.UpdateAdminUser(user)
+}
+
+// UpdateAdminUserLastLogin 更新管理用户最后登录时间
+func (r *RequestLogger) UpdateAdminUserLastLogin(id string) error {
+	return r.db.UpdateAdminUserLastLogin(id)
+}
+
+// UpdateAdminUserTOTP 更新管理用户的两步验证设置
+func (r *RequestLogger) UpdateAdminUserTOTP(user *AdminUser) error {
+	return r.db.UpdateAdminUserTOTP(user)
+}
+
+// DeleteAdminUser 删除管理用户
+func (r *RequestLogger) DeleteAdminUser(id string) error {
+	return r.db.DeleteAdminUser(id)
+}
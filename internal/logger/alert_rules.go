@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"fmt"
+)
+
+// alertRuleColumns 告警规则查询的列
+const alertRuleColumns = `id, name, rule_type, provider_group, threshold, pattern,
+	window_seconds, min_requests, cooldown_seconds, enabled, created_at, updated_at`
+
+// InsertAlertRule 插入告警规则
+func (d *Database) InsertAlertRule(rule *AlertRule) error {
+	query := `
+	INSERT INTO alert_rules (` + alertRuleColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	if _, err := d.exec(query, rule.ID, rule.Name, rule.Type, rule.ProviderGroup, rule.Threshold, rule.Pattern,
+		rule.WindowSeconds, rule.MinRequests, rule.CooldownSeconds, rule.Enabled, rule.CreatedAt, rule.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to insert alert rule: %w", err)
+	}
+	return nil
+}
+
+// UpdateAlertRule 更新告警规则
+func (d *Database) UpdateAlertRule(rule *AlertRule) error {
+	query := `
+	UPDATE alert_rules SET name = ?, rule_type = ?, provider_group = ?, threshold = ?, pattern = ?,
+		window_seconds = ?, min_requests = ?, cooldown_seconds = ?, enabled = ?, updated_at = ?
+	WHERE id = ?
+	`
+
+	result, err := d.exec(query, rule.Name, rule.Type, rule.ProviderGroup, rule.Threshold, rule.Pattern,
+		rule.WindowSeconds, rule.MinRequests, rule.CooldownSeconds, rule.Enabled, rule.UpdatedAt, rule.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update alert rule: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("alert rule not found")
+	}
+	return nil
+}
+
+// GetAllAlertRules 获取所有告警规则
+func (d *Database) GetAllAlertRules() ([]*AlertRule, error) {
+	rows, err := d.query(`SELECT ` + alertRuleColumns + ` FROM alert_rules ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alert rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []*AlertRule{}
+	for rows.Next() {
+		rule := &AlertRule{}
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Type, &rule.ProviderGroup, &rule.Threshold, &rule.Pattern,
+			&rule.WindowSeconds, &rule.MinRequests, &rule.CooldownSeconds, &rule.Enabled,
+			&rule.CreatedAt, &rule.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan alert rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// DeleteAlertRule 删除告警规则
+func (d *Database) DeleteAlertRule(id string) error {
+	result, err := d.exec(`DELETE FROM alert_rules WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete alert rule: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("alert rule not found")
+	}
+	return nil
+}
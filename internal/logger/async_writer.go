@@ -0,0 +1,171 @@
+package logger
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 异步日志写入默认参数
+const (
+	defaultLogQueueSize     = 10000
+	defaultLogBatchSize     = 100
+	defaultLogFlushInterval = time.Second
+)
+
+// AsyncWriterOptions 异步日志写入选项，未设置的字段使用默认值
+type AsyncWriterOptions struct {
+	QueueSize     int           // 队列容量，队列满时丢弃最旧的日志
+	BatchSize     int           // 每个事务写入的最大日志条数
+	FlushInterval time.Duration // 未攒满一批时的最长等待时间
+}
+
+// LogQueueStats 异步日志队列统计
+type LogQueueStats struct {
+	Enabled  bool  `json:"enabled"`
+	Queued   int   `json:"queued"`   // 等待写入的日志条数
+	Capacity int   `json:"capacity"` // 队列容量
+	Written  int64 `json:"written"`  // 已写入数据库的日志条数
+	Dropped  int64 `json:"dropped"`  // 队列满时丢弃的日志条数
+	Failed   int64 `json:"failed"`   // 写入数据库失败的日志条数
+}
+
+// asyncWriter 异步批量写入请求日志，避免日志写入阻塞响应
+type asyncWriter struct {
+	db            *Database
+	prepare       func(*RequestLog) // 写入前计算派生字段，在后台goroutine中执行
+	queue         chan *RequestLog
+	batchSize     int
+	flushInterval time.Duration
+
+	written atomic.Int64
+	dropped atomic.Int64
+	failed  atomic.Int64
+
+	mu     sync.RWMutex // 保护closed，关闭后不再写入队列
+	closed bool
+	done   chan struct{}
+}
+
+// newAsyncWriter 创建异步写入器并启动后台写入任务
+func newAsyncWriter(db *Database, prepare func(*RequestLog), opts AsyncWriterOptions) *asyncWriter {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultLogQueueSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultLogBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultLogFlushInterval
+	}
+
+	w := &asyncWriter{
+		db:            db,
+		prepare:       prepare,
+		queue:         make(chan *RequestLog, opts.QueueSize),
+		batchSize:     opts.BatchSize,
+		flushInterval: opts.FlushInterval,
+		done:          make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// enqueue 将日志放入队列，队列满时丢弃最旧的日志；写入器已关闭时返回false
+func (w *asyncWriter) enqueue(entry *RequestLog) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return false
+	}
+
+	for {
+		select {
+		case w.queue <- entry:
+			return true
+		default:
+		}
+
+		select {
+		case <-w.queue:
+			if w.dropped.Add(1)%1000 == 1 {
+				log.Printf("请求日志队列已满，已丢弃 %d 条最旧的日志", w.dropped.Load())
+			}
+		default:
+		}
+	}
+}
+
+// run 后台写入任务，攒满一批或到达刷新间隔时在一个事务中写入
+func (w *asyncWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*RequestLog, 0, w.batchSize)
+	for {
+		select {
+		case entry, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= w.batchSize {
+				batch = w.flush(batch)
+			}
+		case <-ticker.C:
+			batch = w.flush(batch)
+		}
+	}
+}
+
+// flush 写入一批日志，返回清空后的切片供复用
+func (w *asyncWriter) flush(batch []*RequestLog) []*RequestLog {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	if w.prepare != nil {
+		for _, entry := range batch {
+			w.prepare(entry)
+		}
+	}
+
+	if err := w.db.InsertRequestLogs(batch); err != nil {
+		w.failed.Add(int64(len(batch)))
+		log.Printf("Failed to insert %d request logs: %v", len(batch), err)
+	} else {
+		w.written.Add(int64(len(batch)))
+	}
+	return batch[:0]
+}
+
+// close 停止接收新日志，并等待队列中的日志写入完成
+func (w *asyncWriter) close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	<-w.done
+}
+
+// stats 获取队列统计信息
+func (w *asyncWriter) stats() LogQueueStats {
+	return LogQueueStats{
+		Enabled:  true,
+		Queued:   len(w.queue),
+		Capacity: cap(w.queue),
+		Written:  w.written.Load(),
+		Dropped:  w.dropped.Load(),
+		Failed:   w.failed.Load(),
+	}
+}
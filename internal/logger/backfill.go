@@ -0,0 +1,294 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// backfillBatchSize 回填时每批处理的日志条数
+const backfillBatchSize = 500
+
+// BackfillOptions 历史日志派生字段回填选项
+type BackfillOptions struct {
+	StartTime *time.Time `json:"start_time"`
+	EndTime   *time.Time `json:"end_time"`
+	DryRun    bool       `json:"dry_run"` // 只统计需要更新的行，不写入数据库
+}
+
+// BackfillResult 回填结果统计
+type BackfillResult struct {
+	Scanned       int64            `json:"scanned"`        // 扫描的日志条数
+	Updated       int64            `json:"updated"`        // 派生字段发生变化的日志条数
+	FieldsUpdated map[string]int64 `json:"fields_updated"` // 各字段被更新的次数
+	DryRun        bool             `json:"dry_run"`
+	Duration      string           `json:"duration"`
+}
+
+// BackfillStatus 后台回填任务的状态和进度
+type BackfillStatus struct {
+	BackfillResult
+	Running    bool       `json:"running"`
+	Total      int64      `json:"total"` // 开始时时间范围内的日志条数，用于计算进度
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// ErrBackfillRunning 已有回填任务在运行
+var ErrBackfillRunning = errors.New("a backfill is already running")
+
+// BackfillDerivedFields 按当前的计算逻辑重新计算历史日志的派生字段
+// （token使用量、是否估算、工具调用信息、费用、错误分类和首字耗时），使统计口径在计算逻辑变更前后保持一致
+func (r *RequestLogger) BackfillDerivedFields(opts BackfillOptions) (*BackfillResult, error) {
+	return r.backfillDerivedFields(opts, nil)
+}
+
+// StartBackfill 在后台启动回填任务，通过 BackfillStatus 查询进度；已有任务在运行时返回 ErrBackfillRunning
+func (r *RequestLogger) StartBackfill(opts BackfillOptions) (*BackfillStatus, error) {
+	total, err := r.db.CountRequestLogsForBackfill(opts.StartTime, opts.EndTime)
+	if err != nil {
+		return nil, err
+	}
+
+	r.backfillMu.Lock()
+	if r.backfill != nil && r.backfill.Running {
+		r.backfillMu.Unlock()
+		return nil, ErrBackfillRunning
+	}
+	r.backfill = &BackfillStatus{
+		BackfillResult: BackfillResult{FieldsUpdated: make(map[string]int64), DryRun: opts.DryRun},
+		Running:        true,
+		Total:          total,
+		StartedAt:      time.Now(),
+	}
+	status := r.backfill.clone()
+	r.backfillMu.Unlock()
+
+	go func() {
+		result, err := r.backfillDerivedFields(opts, r.updateBackfillProgress)
+
+		r.backfillMu.Lock()
+		defer r.backfillMu.Unlock()
+		finishedAt := time.Now()
+		r.backfill.Running = false
+		r.backfill.FinishedAt = &finishedAt
+		if err != nil {
+			r.backfill.Error = err.Error()
+			log.Printf("历史日志回填失败: %v", err)
+			return
+		}
+		r.backfill.BackfillResult = *result
+	}()
+	return status, nil
+}
+
+// BackfillStatus 获取最近一次后台回填任务的状态，从未运行过时返回nil
+func (r *RequestLogger) BackfillStatus() *BackfillStatus {
+	r.backfillMu.Lock()
+	defer r.backfillMu.Unlock()
+	if r.backfill == nil {
+		return nil
+	}
+	return r.backfill.clone()
+}
+
+// updateBackfillProgress 每处理完一批日志后更新后台任务的进度
+func (r *RequestLogger) updateBackfillProgress(result *BackfillResult) {
+	r.backfillMu.Lock()
+	defer r.backfillMu.Unlock()
+	r.backfill.BackfillResult = *result
+	r.backfill.FieldsUpdated = make(map[string]int64, len(result.FieldsUpdated))
+	for field, count := range result.FieldsUpdated {
+		r.backfill.FieldsUpdated[field] = count
+	}
+}
+
+// clone 复制状态，避免调用方与后台任务共享字段计数
+func (s *BackfillStatus) clone() *BackfillStatus {
+	cloned := *s
+	cloned.FieldsUpdated = make(map[string]int64, len(s.FieldsUpdated))
+	for field, count := range s.FieldsUpdated {
+		cloned.FieldsUpdated[field] = count
+	}
+	return &cloned
+}
+
+// backfillDerivedFields 分批回填派生字段，progress不为空时每批处理完后调用
+func (r *RequestLogger) backfillDerivedFields(opts BackfillOptions, progress func(*BackfillResult)) (*BackfillResult, error) {
+	start := time.Now()
+	result := &BackfillResult{
+		FieldsUpdated: make(map[string]int64),
+		DryRun:        opts.DryRun,
+	}
+
+	var lastID int64
+	for {
+		rows, err := r.db.GetRequestLogsForBackfill(lastID, opts.StartTime, opts.EndTime, backfillBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		var changed []*RequestLog
+		for _, row := range rows {
+			lastID = row.ID
+			result.Scanned++
+
+			recomputed := *row
+			r.computeDerivedFields(&recomputed)
+
+			fields := diffDerivedFields(row, &recomputed)
+			if len(fields) == 0 {
+				continue
+			}
+			for _, field := range fields {
+				result.FieldsUpdated[field]++
+			}
+			changed = append(changed, &recomputed)
+		}
+
+		result.Updated += int64(len(changed))
+		if !opts.DryRun && len(changed) > 0 {
+			if err := r.db.UpdateRequestLogDerivedFields(changed); err != nil {
+				return nil, err
+			}
+		}
+		if progress != nil {
+			result.Duration = time.Since(start).Round(time.Millisecond).String()
+			progress(result)
+		}
+	}
+
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+	log.Printf("历史日志回填完成: 扫描 %d 条，更新 %d 条 (dry_run=%t)", result.Scanned, result.Updated, opts.DryRun)
+	return result, nil
+}
+
+// diffDerivedFields 返回重新计算后发生变化的派生字段名称
+func diffDerivedFields(old, recomputed *RequestLog) []string {
+	var fields []string
+	if old.TokensUsed != recomputed.TokensUsed {
+		fields = append(fields, "tokens_used")
+	}
+	if old.TokensEstimated != recomputed.TokensEstimated {
+		fields = append(fields, "tokens_estimated")
+	}
+	if old.HasToolCalls != recomputed.HasToolCalls || old.ToolCallsCount != recomputed.ToolCallsCount {
+		fields = append(fields, "tool_calls")
+	}
+	if old.ToolNames != recomputed.ToolNames {
+		fields = append(fields, "tool_names")
+	}
+	// 费用按微美元比较，忽略浮点数存取的精度差异
+	if math.Round(old.Cost*1e6) != math.Round(recomputed.Cost*1e6) {
+		fields = append(fields, "cost")
+	}
+	if old.ErrorClass != recomputed.ErrorClass {
+		fields = append(fields, "error_class")
+	}
+	if old.TTFTMs != recomputed.TTFTMs {
+		fields = append(fields, "ttft_ms")
+	}
+	return fields
+}
+
+// backfillConditions 回填的筛选条件
+func backfillConditions(startTime, endTime *time.Time) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if startTime != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *startTime)
+	}
+	if endTime != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, *endTime)
+	}
+	return conditions, args
+}
+
+// CountRequestLogsForBackfill 统计时间范围内需要回填的日志条数
+func (d *Database) CountRequestLogsForBackfill(startTime, endTime *time.Time) (int64, error) {
+	query := "SELECT COUNT(*) FROM request_logs"
+	conditions, args := backfillConditions(startTime, endTime)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var count int64
+	if err := d.queryRow(query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count request logs for backfill: %w", err)
+	}
+	return count, nil
+}
+
+// GetRequestLogsForBackfill 按ID升序分批获取需要回填的日志（包含请求和响应内容）
+func (d *Database) GetRequestLogsForBackfill(afterID int64, startTime, endTime *time.Time, limit int) ([]*RequestLog, error) {
+	conditions, args := backfillConditions(startTime, endTime)
+	conditions = append([]string{"id > ?"}, conditions...)
+	args = append([]interface{}{afterID}, args...)
+
+	query := `
+	SELECT id, model, request_body, COALESCE(response_body, ''), status_code, tokens_used, tokens_estimated,
+		   has_tool_calls, tool_calls_count, tool_names, COALESCE(error, ''), COALESCE(debug_trace, ''),
+		   cost, error_class, ttft_ms
+	FROM request_logs
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY id ASC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := d.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query request logs for backfill: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []*RequestLog
+	for rows.Next() {
+		log := &RequestLog{}
+		if err := rows.Scan(&log.ID, &log.Model, &log.RequestBody, &log.ResponseBody, &log.StatusCode,
+			&log.TokensUsed, &log.TokensEstimated, &log.HasToolCalls, &log.ToolCallsCount, &log.ToolNames,
+			&log.Error, &log.DebugTrace, &log.Cost, &log.ErrorClass, &log.TTFTMs); err != nil {
+			return nil, fmt.Errorf("failed to scan request log: %w", err)
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
+
+// UpdateRequestLogDerivedFields 在一个事务中批量更新日志的派生字段
+func (d *Database) UpdateRequestLogDerivedFields(logs []*RequestLog) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(d.dialect.rebind(`
+	UPDATE request_logs
+	SET tokens_used = ?, tokens_estimated = ?, has_tool_calls = ?, tool_calls_count = ?, tool_names = ?,
+		cost = ?, error_class = ?, ttft_ms = ?
+	WHERE id = ?`))
+	if err != nil {
+		return fmt.Errorf("failed to prepare backfill update: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, log := range logs {
+		if _, err := stmt.Exec(log.TokensUsed, log.TokensEstimated, log.HasToolCalls,
+			log.ToolCallsCount, log.ToolNames, log.Cost, log.ErrorClass, log.TTFTMs, log.ID); err != nil {
+			return fmt.Errorf("failed to update request log %d: %w", log.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit backfill update: %w", err)
+	}
+	return nil
+}
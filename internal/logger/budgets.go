@@ -0,0 +1,110 @@
+package logger
+
+import (
+	"fmt"
+	"time"
+)
+
+// budgetColumns 预算查询的列
+const budgetColumns = `id, scope, target, limit_usd, limit_tokens, enabled, created_at, updated_at`
+
+// BudgetUsage 一段时间内某个代理密钥、分组和模型组合的token用量，用于计算预算用量
+type BudgetUsage struct {
+	ProxyKeyID    string `json:"proxy_key_id"`
+	TenantID      string `json:"tenant_id"` // 代理密钥当前所属的租户，不属于任何租户时为空
+	ProviderGroup string `json:"provider_group"`
+	Model         string `json:"model"`
+	Tokens        int64  `json:"tokens"`
+}
+
+// InsertBudget 插入预算
+func (d *Database) InsertBudget(budget *Budget) error {
+	query := `
+	INSERT INTO budgets (` + budgetColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	if _, err := d.exec(query, budget.ID, budget.Scope, budget.Target, budget.LimitUSD, budget.LimitTokens,
+		budget.Enabled, budget.CreatedAt, budget.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to insert budget: %w", err)
+	}
+	return nil
+}
+
+// UpdateBudget 更新预算
+func (d *Database) UpdateBudget(budget *Budget) error {
+	query := `
+	UPDATE budgets SET scope = ?, target = ?, limit_usd = ?, limit_tokens = ?, enabled = ?, updated_at = ?
+	WHERE id = ?
+	`
+
+	result, err := d.exec(query, budget.Scope, budget.Target, budget.LimitUSD, budget.LimitTokens,
+		budget.Enabled, budget.UpdatedAt, budget.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update budget: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("budget not found")
+	}
+	return nil
+}
+
+// GetAllBudgets 获取所有预算
+func (d *Database) GetAllBudgets() ([]*Budget, error) {
+	rows, err := d.query(`SELECT ` + budgetColumns + ` FROM budgets ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query budgets: %w", err)
+	}
+	defer rows.Close()
+
+	budgets := []*Budget{}
+	for rows.Next() {
+		budget := &Budget{}
+		if err := rows.Scan(&budget.ID, &budget.Scope, &budget.Target, &budget.LimitUSD, &budget.LimitTokens,
+			&budget.Enabled, &budget.CreatedAt, &budget.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan budget: %w", err)
+		}
+		budgets = append(budgets, budget)
+	}
+	return budgets, nil
+}
+
+// DeleteBudget 删除预算
+func (d *Database) DeleteBudget(id string) error {
+	result, err := d.exec(`DELETE FROM budgets WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete budget: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("budget not found")
+	}
+	return nil
+}
+
+// GetBudgetUsageSince 按代理密钥、分组和模型统计指定时间之后的token用量，不包括影子流量
+// 租户按代理密钥当前所属的租户汇总
+func (d *Database) GetBudgetUsageSince(since time.Time) ([]*BudgetUsage, error) {
+	query := `
+	SELECT rl.proxy_key_id, COALESCE(pk.tenant_id, ''), rl.provider_group, rl.model, COALESCE(SUM(rl.tokens_used), 0)
+	FROM request_logs rl
+	LEFT JOIN proxy_keys pk ON pk.id = rl.proxy_key_id
+	WHERE rl.is_shadow = FALSE AND rl.created_at >= ?
+	GROUP BY rl.proxy_key_id, pk.tenant_id, rl.provider_group, rl.model
+	`
+
+	rows, err := d.query(query, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query budget usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := []*BudgetUsage{}
+	for rows.Next() {
+		u := &BudgetUsage{}
+		if err := rows.Scan(&u.ProxyKeyID, &u.TenantID, &u.ProviderGroup, &u.Model, &u.Tokens); err != nil {
+			return nil, fmt.Errorf("failed to scan budget usage: %w", err)
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
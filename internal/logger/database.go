@@ -8,36 +8,74 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"turnsapi/internal/sqlitedriver"
+)
+
+// 日志数据库连接池大小，重新建立连接后恢复同样的空闲连接数
+const (
+	maxOpenConns = 10
+	maxIdleConns = 5
 )
 
 // Database 数据库管理器
 type Database struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
+
+	// 同一进程内串行写入请求日志，链末尾保存在 request_log_chain 表中
+	chainMu sync.Mutex
+
+	// ftsEnabled SQLite支持FTS5时为true，日志搜索使用全文索引
+	ftsEnabled bool
 }
 
-// NewDatabase 创建新的数据库管理器
+// NewDatabase 创建新的SQLite数据库管理器
 func NewDatabase(dbPath string) (*Database, error) {
-	// 确保数据库目录存在
-	dir := filepath.Dir(dbPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	return NewDatabaseWithDriver(DriverSQLite, dbPath)
+}
+
+// NewDatabaseWithDriver 根据驱动创建数据库管理器
+// sqlite3 的 dsn 为数据库文件路径；postgres 和 mysql 的 dsn 为连接串，需使用对应构建标签编译驱动
+func NewDatabaseWithDriver(driver, dsn string) (*Database, error) {
+	dialect, err := newDialect(driver)
+	if err != nil {
+		return nil, err
 	}
 
-	// 打开数据库连接
-	db, err := sql.Open("sqlite3", dbPath)
+	var db *sql.DB
+	if dialect.driverName() == DriverSQLite {
+		// 确保数据库目录存在
+		dir := filepath.Dir(dsn)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+
+		// 打开数据库连接，驱动实现由构建标签决定（cgo或纯Go）
+		db, err = sqlitedriver.Open(dsn)
+	} else {
+		if err := checkDriverRegistered(dialect.driverName()); err != nil {
+			return nil, err
+		}
+		db, err = sql.Open(dialect.driverName(), dsn)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	// 设置连接池参数
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(5)
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
 	db.SetConnMaxLifetime(time.Hour)
 
-	database := &Database{db: db}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	database := &Database{db: db, dialect: dialect}
 
 	// 初始化数据库表
 	if err := database.initTables(); err != nil {
@@ -54,6 +92,59 @@ func NewDatabase(dbPath string) (*Database, error) {
 	return database, nil
 }
 
+// Driver 返回当前使用的数据库驱动名
+func (d *Database) Driver() string {
+	return d.dialect.driverName()
+}
+
+// exec 执行通用SQL，转换为当前数据库的语法
+func (d *Database) exec(query string, args ...interface{}) (sql.Result, error) {
+	return d.db.Exec(d.dialect.rebind(query), args...)
+}
+
+// query 执行通用查询，转换为当前数据库的语法
+func (d *Database) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return d.db.Query(d.dialect.rebind(query), args...)
+}
+
+// queryRow 执行返回单行的通用查询，转换为当前数据库的语法
+func (d *Database) queryRow(query string, args ...interface{}) *sql.Row {
+	return d.db.QueryRow(d.dialect.rebind(query), args...)
+}
+
+// sqlExecutor *sql.DB 和 *sql.Tx 的公共方法
+type sqlExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// insertReturningID 执行插入语句并返回自增ID，PostgreSQL不支持LastInsertId，改用RETURNING
+func (d *Database) insertReturningID(e sqlExecutor, query string, args ...interface{}) (int64, error) {
+	if suffix := d.dialect.returningID(); suffix != "" {
+		var id int64
+		err := e.QueryRow(d.dialect.rebind(strings.TrimSpace(query)+suffix), args...).Scan(&id)
+		return id, err
+	}
+	result, err := e.Exec(d.dialect.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// columnExists 检查表中是否存在指定列
+func (d *Database) columnExists(table, column string) (bool, error) {
+	return d.dialect.columnExists(d.db, table, column)
+}
+
+// ResetConnections 关闭连接池中的空闲连接并确认数据库可用，之后的查询使用新建立的连接
+// 用于数据库地址切换或SQLite文件被替换后不重启进程重新连接，正在执行的查询不受影响
+func (d *Database) ResetConnections() error {
+	d.db.SetMaxIdleConns(0)
+	d.db.SetMaxIdleConns(maxIdleConns)
+	return d.db.Ping()
+}
+
 // Close 关闭数据库连接
 func (d *Database) Close() error {
 	if d.db != nil {
@@ -64,59 +155,15 @@ func (d *Database) Close() error {
 
 // initTables 初始化数据库表
 func (d *Database) initTables() error {
-	createTableSQL := `
-	-- 代理服务API密钥表
-	CREATE TABLE IF NOT EXISTS proxy_keys (
-		id TEXT PRIMARY KEY,
-		name TEXT NOT NULL,
-		description TEXT,
-		key TEXT NOT NULL UNIQUE,
-		allowed_groups TEXT, -- JSON数组，存储允许访问的分组ID
-		group_selection_config TEXT, -- JSON对象，存储分组选择配置
-		is_active BOOLEAN NOT NULL DEFAULT 1,
-		usage_count INTEGER NOT NULL DEFAULT 0, -- 使用次数
-		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		last_used_at DATETIME
-	);
-
-	-- 请求日志表
-	CREATE TABLE IF NOT EXISTS request_logs (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		proxy_key_name TEXT NOT NULL,
-		proxy_key_id TEXT NOT NULL,
-		provider_group TEXT NOT NULL DEFAULT '',
-		openrouter_key TEXT NOT NULL,
-		model TEXT NOT NULL,
-		request_body TEXT NOT NULL,
-		response_body TEXT,
-		status_code INTEGER NOT NULL,
-		is_stream BOOLEAN NOT NULL DEFAULT 0,
-		duration INTEGER NOT NULL DEFAULT 0,
-		tokens_used INTEGER NOT NULL DEFAULT 0,
-		tokens_estimated BOOLEAN NOT NULL DEFAULT 0,
-		error TEXT,
-		client_ip TEXT NOT NULL DEFAULT '',
-		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (proxy_key_id) REFERENCES proxy_keys(id)
-	);
-
-	-- 索引
-	CREATE INDEX IF NOT EXISTS idx_proxy_keys_name ON proxy_keys(name);
-	CREATE INDEX IF NOT EXISTS idx_proxy_keys_key ON proxy_keys(key);
-	CREATE INDEX IF NOT EXISTS idx_proxy_keys_is_active ON proxy_keys(is_active);
-
-	CREATE INDEX IF NOT EXISTS idx_request_logs_proxy_key_id ON request_logs(proxy_key_id);
-	CREATE INDEX IF NOT EXISTS idx_request_logs_proxy_key_name ON request_logs(proxy_key_name);
-	CREATE INDEX IF NOT EXISTS idx_request_logs_provider_group ON request_logs(provider_group);
-	CREATE INDEX IF NOT EXISTS idx_request_logs_model ON request_logs(model);
-	CREATE INDEX IF NOT EXISTS idx_request_logs_created_at ON request_logs(created_at);
-	CREATE INDEX IF NOT EXISTS idx_request_logs_status_code ON request_logs(status_code);
-	`
+	// 旧版本的表缺少建索引用到的列，需在执行建表语句前补齐
+	if err := d.migrateLegacyRequestLogs(); err != nil {
+		return fmt.Errorf("failed to migrate legacy request_logs table: %w", err)
+	}
 
-	_, err := d.db.Exec(createTableSQL)
-	if err != nil {
-		return fmt.Errorf("failed to create tables: %w", err)
+	for _, stmt := range d.dialect.schema() {
+		if _, err := d.db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create tables: %w", err)
+		}
 	}
 
 	// 执行数据库迁移
@@ -129,58 +176,139 @@ func (d *Database) initTables() error {
 		return fmt.Errorf("failed to migrate proxy_keys table: %w", err)
 	}
 
-	log.Println("Database tables initialized successfully")
-	return nil
-}
+	// 迁移admin_users表
+	if err := d.migrateAdminUsersTable(); err != nil {
+		return fmt.Errorf("failed to migrate admin_users table: %w", err)
+	}
 
-// migrateProxyKeysTable 迁移proxy_keys表，添加usage_count字段
-func (d *Database) migrateProxyKeysTable() error {
-	// 检查usage_count字段是否存在
-	checkSQL := `PRAGMA table_info(proxy_keys)`
-	rows, err := d.db.Query(checkSQL)
-	if err != nil {
-		return fmt.Errorf("failed to check table info: %w", err)
+	// 请求日志哈希链末尾
+	if err := d.initChainHead(); err != nil {
+		return err
 	}
-	defer rows.Close()
 
-	hasUsageCount := false
-	for rows.Next() {
-		var cid int
-		var name, dataType string
-		var notNull, dfltValue, pk interface{}
+	// 请求体和响应体的全文索引
+	if err := d.initFullTextSearch(); err != nil {
+		return fmt.Errorf("failed to initialize full-text search: %w", err)
+	}
 
-		if err := rows.Scan(&cid, &name, &dataType, &notNull, &dfltValue, &pk); err != nil {
-			continue
-		}
+	log.Println("Database tables initialized successfully")
+	return nil
+}
 
-		if name == "usage_count" {
-			hasUsageCount = true
-			break
+// migrateProxyKeysTable 迁移proxy_keys表，添加usage_count、expires_at、max_usage_count、allowed_models、denied_models、allowed_ips、denied_ips、system_prompt、tenant_id字段
+func (d *Database) migrateProxyKeysTable() error {
+	columns := make(map[string]bool)
+	for _, column := range []string{"usage_count", "expires_at", "max_usage_count", "allowed_models", "denied_models", "allowed_ips", "denied_ips", "system_prompt", "tenant_id"} {
+		exists, err := d.columnExists("proxy_keys", column)
+		if err != nil {
+			return fmt.Errorf("failed to check %s column existence: %w", column, err)
 		}
+		columns[column] = exists
 	}
+	hasUsageCount := columns["usage_count"]
 
 	// 如果没有usage_count字段，则添加
 	if !hasUsageCount {
 		alterSQL := `ALTER TABLE proxy_keys ADD COLUMN usage_count INTEGER NOT NULL DEFAULT 0`
-		_, err := d.db.Exec(alterSQL)
+		_, err := d.exec(alterSQL)
 		if err != nil {
 			return fmt.Errorf("failed to add usage_count column: %w", err)
 		}
 		log.Println("Added usage_count column to proxy_keys table")
 	}
 
+	// 密钥过期时间和使用次数上限
+	if !columns["expires_at"] {
+		if _, err := d.exec(`ALTER TABLE proxy_keys ADD COLUMN expires_at DATETIME`); err != nil {
+			return fmt.Errorf("failed to add expires_at column: %w", err)
+		}
+		log.Println("Added expires_at column to proxy_keys table")
+	}
+	if !columns["max_usage_count"] {
+		if _, err := d.exec(`ALTER TABLE proxy_keys ADD COLUMN max_usage_count INTEGER NOT NULL DEFAULT 0`); err != nil {
+			return fmt.Errorf("failed to add max_usage_count column: %w", err)
+		}
+		log.Println("Added max_usage_count column to proxy_keys table")
+	}
+
+	// 模型和来源地址允许/禁止列表、系统提示词
+	for _, column := range []string{"allowed_models", "denied_models", "allowed_ips", "denied_ips", "system_prompt"} {
+		if columns[column] {
+			continue
+		}
+		if _, err := d.exec(`ALTER TABLE proxy_keys ADD COLUMN ` + column + ` TEXT`); err != nil {
+			return fmt.Errorf("failed to add %s column: %w", column, err)
+		}
+		log.Printf("Added %s column to proxy_keys table", column)
+	}
+
+	// 所属租户
+	if !columns["tenant_id"] {
+		alterSQL := `ALTER TABLE proxy_keys ADD COLUMN tenant_id TEXT`
+		if d.dialect.driverName() == DriverMySQL {
+			alterSQL = `ALTER TABLE proxy_keys ADD COLUMN tenant_id VARCHAR(64) NULL`
+		}
+		if _, err := d.exec(alterSQL); err != nil {
+			return fmt.Errorf("failed to add tenant_id column: %w", err)
+		}
+		log.Println("Added tenant_id column to proxy_keys table")
+	}
+
+	return nil
+}
+
+// migrateAdminUsersTable 迁移admin_users表，添加两步验证的totp_secret、totp_enabled、recovery_codes字段
+func (d *Database) migrateAdminUsersTable() error {
+	for _, column := range []struct{ name, definition string }{
+		{"totp_secret", "TEXT"},
+		{"totp_enabled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"recovery_codes", "TEXT"},
+	} {
+		exists, err := d.columnExists("admin_users", column.name)
+		if err != nil {
+			return fmt.Errorf("failed to check %s column existence: %w", column.name, err)
+		}
+		if exists {
+			continue
+		}
+		if _, err := d.exec(`ALTER TABLE admin_users ADD COLUMN ` + column.name + ` ` + column.definition); err != nil {
+			return fmt.Errorf("failed to add %s column: %w", column.name, err)
+		}
+		log.Printf("Added %s column to admin_users table", column.name)
+	}
 	return nil
 }
 
+// marshalModelPatterns 将模型匹配规则（或来源地址列表）序列化为JSON数组，空列表存储为NULL
+func marshalModelPatterns(patterns []string) interface{} {
+	if len(patterns) == 0 {
+		return nil
+	}
+	jsonBytes, err := json.Marshal(patterns)
+	if err != nil {
+		log.Printf("Failed to marshal model patterns: %v", err)
+		return nil
+	}
+	return string(jsonBytes)
+}
+
+// unmarshalModelPatterns 解析JSON数组形式的模型匹配规则（或来源地址列表）
+func unmarshalModelPatterns(value sql.NullString) []string {
+	if !value.Valid || value.String == "" {
+		return nil
+	}
+	var patterns []string
+	if err := json.Unmarshal([]byte(value.String), &patterns); err != nil {
+		log.Printf("Failed to unmarshal model patterns: %v", err)
+		return nil
+	}
+	return patterns
+}
+
 // migrateDatabase 执行数据库迁移
 func (d *Database) migrateDatabase() error {
 	// 检查proxy_keys表是否有allowed_groups列
-	var columnExists bool
-	err := d.db.QueryRow(`
-		SELECT COUNT(*) > 0
-		FROM pragma_table_info('proxy_keys')
-		WHERE name = 'allowed_groups'
-	`).Scan(&columnExists)
+	columnExists, err := d.columnExists("proxy_keys", "allowed_groups")
 
 	if err != nil {
 		return fmt.Errorf("failed to check column existence: %w", err)
@@ -189,19 +317,20 @@ func (d *Database) migrateDatabase() error {
 	// 如果列不存在，添加它
 	if !columnExists {
 		log.Println("Adding allowed_groups column to proxy_keys table...")
-		_, err = d.db.Exec(`ALTER TABLE proxy_keys ADD COLUMN allowed_groups TEXT`)
+		_, err = d.exec(`ALTER TABLE proxy_keys ADD COLUMN allowed_groups TEXT`)
 		if err != nil {
 			return fmt.Errorf("failed to add allowed_groups column: %w", err)
 		}
+		// 旧版本的密钥可以访问所有分组；读取密钥时按字符串扫描这两列，不能为NULL
+		_, err = d.exec(`UPDATE proxy_keys SET allowed_groups = '[]', description = COALESCE(description, '')`)
+		if err != nil {
+			return fmt.Errorf("failed to initialize allowed_groups for existing keys: %w", err)
+		}
 		log.Println("Successfully added allowed_groups column")
 	}
 
 	// 检查proxy_keys表是否有group_selection_config列
-	err = d.db.QueryRow(`
-		SELECT COUNT(*) > 0
-		FROM pragma_table_info('proxy_keys')
-		WHERE name = 'group_selection_config'
-	`).Scan(&columnExists)
+	columnExists, err = d.columnExists("proxy_keys", "group_selection_config")
 
 	if err != nil {
 		return fmt.Errorf("failed to check group_selection_config column existence: %w", err)
@@ -210,7 +339,7 @@ func (d *Database) migrateDatabase() error {
 	// 如果列不存在，添加它
 	if !columnExists {
 		log.Println("Adding group_selection_config column to proxy_keys table...")
-		_, err = d.db.Exec(`ALTER TABLE proxy_keys ADD COLUMN group_selection_config TEXT`)
+		_, err = d.exec(`ALTER TABLE proxy_keys ADD COLUMN group_selection_config TEXT`)
 		if err != nil {
 			return fmt.Errorf("failed to add group_selection_config column: %w", err)
 		}
@@ -218,11 +347,7 @@ func (d *Database) migrateDatabase() error {
 	}
 
 	// 检查request_logs表是否有client_ip列
-	err = d.db.QueryRow(`
-		SELECT COUNT(*) > 0
-		FROM pragma_table_info('request_logs')
-		WHERE name = 'client_ip'
-	`).Scan(&columnExists)
+	columnExists, err = d.columnExists("request_logs", "client_ip")
 
 	if err != nil {
 		return fmt.Errorf("failed to check client_ip column existence: %w", err)
@@ -231,7 +356,7 @@ func (d *Database) migrateDatabase() error {
 	// 如果列不存在，添加它
 	if !columnExists {
 		log.Println("Adding client_ip column to request_logs table...")
-		_, err = d.db.Exec(`ALTER TABLE request_logs ADD COLUMN client_ip TEXT NOT NULL DEFAULT ''`)
+		_, err = d.exec(`ALTER TABLE request_logs ADD COLUMN client_ip TEXT NOT NULL DEFAULT ''`)
 		if err != nil {
 			return fmt.Errorf("failed to add client_ip column: %w", err)
 		}
@@ -239,11 +364,7 @@ func (d *Database) migrateDatabase() error {
 	}
 
 	// 检查request_logs表是否有tokens_estimated列
-	err = d.db.QueryRow(`
-		SELECT COUNT(*) > 0
-		FROM pragma_table_info('request_logs')
-		WHERE name = 'tokens_estimated'
-	`).Scan(&columnExists)
+	columnExists, err = d.columnExists("request_logs", "tokens_estimated")
 
 	if err != nil {
 		return fmt.Errorf("failed to check tokens_estimated column existence: %w", err)
@@ -252,7 +373,7 @@ func (d *Database) migrateDatabase() error {
 	// 如果列不存在，添加它
 	if !columnExists {
 		log.Println("Adding tokens_estimated column to request_logs table...")
-		_, err = d.db.Exec(`ALTER TABLE request_logs ADD COLUMN tokens_estimated BOOLEAN NOT NULL DEFAULT 0`)
+		_, err = d.exec(`ALTER TABLE request_logs ADD COLUMN tokens_estimated BOOLEAN NOT NULL DEFAULT 0`)
 		if err != nil {
 			return fmt.Errorf("failed to add tokens_estimated column: %w", err)
 		}
@@ -262,11 +383,7 @@ func (d *Database) migrateDatabase() error {
 	// 检查request_logs表是否有工具调用相关字段
 	toolCallFields := []string{"has_tool_calls", "tool_calls_count", "tool_names"}
 	for _, field := range toolCallFields {
-		err = d.db.QueryRow(`
-			SELECT COUNT(*) > 0
-			FROM pragma_table_info('request_logs')
-			WHERE name = ?
-		`, field).Scan(&columnExists)
+		columnExists, err = d.columnExists("request_logs", field)
 
 		if err != nil {
 			return fmt.Errorf("failed to check %s column existence: %w", field, err)
@@ -285,7 +402,7 @@ func (d *Database) migrateDatabase() error {
 			}
 
 			log.Printf("Adding %s column to request_logs table...", field)
-			_, err = d.db.Exec(alterSQL)
+			_, err = d.exec(alterSQL)
 			if err != nil {
 				return fmt.Errorf("failed to add %s column: %w", field, err)
 			}
@@ -293,18 +410,222 @@ func (d *Database) migrateDatabase() error {
 		}
 	}
 
+	// 检查request_logs表是否有upstream_headers列
+	columnExists, err = d.columnExists("request_logs", "upstream_headers")
+	if err != nil {
+		return fmt.Errorf("failed to check upstream_headers column existence: %w", err)
+	}
+
+	// 如果列不存在，添加它（MySQL的TEXT列不支持默认值）
+	if !columnExists {
+		alterSQL := `ALTER TABLE request_logs ADD COLUMN upstream_headers TEXT NOT NULL DEFAULT ''`
+		if d.dialect.driverName() == DriverMySQL {
+			alterSQL = `ALTER TABLE request_logs ADD COLUMN upstream_headers VARCHAR(2048) NOT NULL DEFAULT ''`
+		}
+
+		log.Println("Adding upstream_headers column to request_logs table...")
+		if _, err = d.exec(alterSQL); err != nil {
+			return fmt.Errorf("failed to add upstream_headers column: %w", err)
+		}
+		log.Println("Successfully added upstream_headers column")
+	}
+
+	// 检查request_logs表是否有debug_trace列
+	columnExists, err = d.columnExists("request_logs", "debug_trace")
+	if err != nil {
+		return fmt.Errorf("failed to check debug_trace column existence: %w", err)
+	}
+
+	if !columnExists {
+		alterSQL := `ALTER TABLE request_logs ADD COLUMN debug_trace TEXT NOT NULL DEFAULT ''`
+		if d.dialect.driverName() == DriverMySQL {
+			alterSQL = `ALTER TABLE request_logs ADD COLUMN debug_trace MEDIUMTEXT`
+		}
+
+		log.Println("Adding debug_trace column to request_logs table...")
+		if _, err = d.exec(alterSQL); err != nil {
+			return fmt.Errorf("failed to add debug_trace column: %w", err)
+		}
+		log.Println("Successfully added debug_trace column")
+	}
+
+	// 检查request_logs表是否有影子流量的correlation_id和is_shadow列
+	columnExists, err = d.columnExists("request_logs", "correlation_id")
+	if err != nil {
+		return fmt.Errorf("failed to check correlation_id column existence: %w", err)
+	}
+
+	if !columnExists {
+		alterSQLs := []string{
+			`ALTER TABLE request_logs ADD COLUMN correlation_id TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE request_logs ADD COLUMN is_shadow BOOLEAN NOT NULL DEFAULT 0`,
+		}
+		if d.dialect.driverName() == DriverMySQL {
+			alterSQLs = []string{`ALTER TABLE request_logs ADD COLUMN correlation_id VARCHAR(64) NOT NULL DEFAULT '', ` +
+				`ADD COLUMN is_shadow BOOLEAN NOT NULL DEFAULT FALSE, ADD INDEX idx_request_logs_correlation_id (correlation_id)`}
+		} else if d.dialect.driverName() == DriverPostgres {
+			alterSQLs[1] = `ALTER TABLE request_logs ADD COLUMN is_shadow BOOLEAN NOT NULL DEFAULT FALSE`
+		}
+
+		log.Println("Adding correlation_id and is_shadow columns to request_logs table...")
+		for _, alterSQL := range alterSQLs {
+			if _, err = d.exec(alterSQL); err != nil {
+				return fmt.Errorf("failed to add shadow columns: %w", err)
+			}
+		}
+		log.Println("Successfully added correlation_id and is_shadow columns")
+	}
+	if d.dialect.driverName() != DriverMySQL {
+		if _, err = d.exec(`CREATE INDEX IF NOT EXISTS idx_request_logs_correlation_id ON request_logs(correlation_id)`); err != nil {
+			return fmt.Errorf("failed to create correlation_id index: %w", err)
+		}
+	}
+
+	// 检查request_logs表是否有A/B分流的split_name和split_arm列
+	columnExists, err = d.columnExists("request_logs", "split_name")
+	if err != nil {
+		return fmt.Errorf("failed to check split_name column existence: %w", err)
+	}
+
+	if !columnExists {
+		alterSQLs := []string{
+			`ALTER TABLE request_logs ADD COLUMN split_name TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE request_logs ADD COLUMN split_arm TEXT NOT NULL DEFAULT ''`,
+		}
+		if d.dialect.driverName() == DriverMySQL {
+			alterSQLs = []string{`ALTER TABLE request_logs ADD COLUMN split_name VARCHAR(128) NOT NULL DEFAULT '', ` +
+				`ADD COLUMN split_arm VARCHAR(128) NOT NULL DEFAULT '', ADD INDEX idx_request_logs_split_name (split_name)`}
+		}
+
+		log.Println("Adding split_name and split_arm columns to request_logs table...")
+		for _, alterSQL := range alterSQLs {
+			if _, err = d.exec(alterSQL); err != nil {
+				return fmt.Errorf("failed to add traffic split columns: %w", err)
+			}
+		}
+		log.Println("Successfully added split_name and split_arm columns")
+	}
+	if d.dialect.driverName() != DriverMySQL {
+		if _, err = d.exec(`CREATE INDEX IF NOT EXISTS idx_request_logs_split_name ON request_logs(split_name)`); err != nil {
+			return fmt.Errorf("failed to create split_name index: %w", err)
+		}
+	}
+
+	// 检查request_logs表是否有哈希链的prev_hash和row_hash列
+	columnExists, err = d.columnExists("request_logs", "row_hash")
+	if err != nil {
+		return fmt.Errorf("failed to check row_hash column existence: %w", err)
+	}
+
+	if !columnExists {
+		alterSQLs := []string{
+			`ALTER TABLE request_logs ADD COLUMN prev_hash TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE request_logs ADD COLUMN row_hash TEXT NOT NULL DEFAULT ''`,
+		}
+		if d.dialect.driverName() == DriverMySQL {
+			alterSQLs = []string{`ALTER TABLE request_logs ADD COLUMN prev_hash VARCHAR(64) NOT NULL DEFAULT '', ` +
+				`ADD COLUMN row_hash VARCHAR(64) NOT NULL DEFAULT ''`}
+		}
+
+		log.Println("Adding prev_hash and row_hash columns to request_logs table...")
+		for _, alterSQL := range alterSQLs {
+			if _, err = d.exec(alterSQL); err != nil {
+				return fmt.Errorf("failed to add hash chain columns: %w", err)
+			}
+		}
+		log.Println("Successfully added prev_hash and row_hash columns")
+	}
+
+	// 检查request_logs表是否有request_id列
+	columnExists, err = d.columnExists("request_logs", "request_id")
+	if err != nil {
+		return fmt.Errorf("failed to check request_id column existence: %w", err)
+	}
+
+	if !columnExists {
+		alterSQL := `ALTER TABLE request_logs ADD COLUMN request_id TEXT NOT NULL DEFAULT ''`
+		if d.dialect.driverName() == DriverMySQL {
+			alterSQL = `ALTER TABLE request_logs ADD COLUMN request_id VARCHAR(128) NOT NULL DEFAULT '', ` +
+				`ADD INDEX idx_request_logs_request_id (request_id)`
+		}
+
+		log.Println("Adding request_id column to request_logs table...")
+		if _, err = d.exec(alterSQL); err != nil {
+			return fmt.Errorf("failed to add request_id column: %w", err)
+		}
+		log.Println("Successfully added request_id column")
+	}
+	if d.dialect.driverName() != DriverMySQL {
+		if _, err = d.exec(`CREATE INDEX IF NOT EXISTS idx_request_logs_request_id ON request_logs(request_id)`); err != nil {
+			return fmt.Errorf("failed to create request_id index: %w", err)
+		}
+	}
+
+	// 检查request_logs表是否有费用、错误分类和首字耗时列
+	columnExists, err = d.columnExists("request_logs", "error_class")
+	if err != nil {
+		return fmt.Errorf("failed to check error_class column existence: %w", err)
+	}
+
+	if !columnExists {
+		alterSQLs := []string{
+			`ALTER TABLE request_logs ADD COLUMN cost REAL NOT NULL DEFAULT 0`,
+			`ALTER TABLE request_logs ADD COLUMN error_class TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE request_logs ADD COLUMN ttft_ms INTEGER NOT NULL DEFAULT 0`,
+		}
+		switch d.dialect.driverName() {
+		case DriverMySQL:
+			alterSQLs = []string{`ALTER TABLE request_logs ADD COLUMN cost DOUBLE NOT NULL DEFAULT 0, ` +
+				`ADD COLUMN error_class VARCHAR(32) NOT NULL DEFAULT '', ` +
+				`ADD COLUMN ttft_ms BIGINT NOT NULL DEFAULT 0`}
+		case DriverPostgres:
+			alterSQLs[0] = `ALTER TABLE request_logs ADD COLUMN cost DOUBLE PRECISION NOT NULL DEFAULT 0`
+			alterSQLs[2] = `ALTER TABLE request_logs ADD COLUMN ttft_ms BIGINT NOT NULL DEFAULT 0`
+		}
+
+		log.Println("Adding cost, error_class and ttft_ms columns to request_logs table...")
+		for _, alterSQL := range alterSQLs {
+			if _, err = d.exec(alterSQL); err != nil {
+				return fmt.Errorf("failed to add derived metric columns: %w", err)
+			}
+		}
+		log.Println("Successfully added cost, error_class and ttft_ms columns")
+	}
+
+	// 按时间范围筛选日志的复合索引，SQLite和PostgreSQL在建表语句中通过 IF NOT EXISTS 创建
+	if d.dialect.driverName() == DriverMySQL {
+		timeRangeIndexes := []struct{ name, columns string }{
+			{"idx_request_logs_key_created", "proxy_key_name, created_at"},
+			{"idx_request_logs_group_created", "provider_group, created_at"},
+			{"idx_request_logs_model_created", "model, created_at"},
+		}
+		for _, index := range timeRangeIndexes {
+			var count int
+			err = d.db.QueryRow(`
+			SELECT COUNT(*)
+			FROM information_schema.statistics
+			WHERE table_schema = DATABASE() AND table_name = 'request_logs' AND index_name = ?
+			`, index.name).Scan(&count)
+			if err != nil {
+				return fmt.Errorf("failed to check %s index existence: %w", index.name, err)
+			}
+			if count > 0 {
+				continue
+			}
+			log.Printf("Adding %s index to request_logs table...", index.name)
+			if _, err = d.exec(fmt.Sprintf(`ALTER TABLE request_logs ADD INDEX %s (%s)`, index.name, index.columns)); err != nil {
+				return fmt.Errorf("failed to create %s index: %w", index.name, err)
+			}
+		}
+	}
+
 	return nil
 }
 
 // migrate 执行数据库迁移
 func (d *Database) migrate() error {
 	// 检查是否需要添加provider_group字段
-	var columnExists bool
-	err := d.db.QueryRow(`
-		SELECT COUNT(*) > 0
-		FROM pragma_table_info('request_logs')
-		WHERE name = 'provider_group'
-	`).Scan(&columnExists)
+	columnExists, err := d.columnExists("request_logs", "provider_group")
 
 	if err != nil {
 		return fmt.Errorf("failed to check provider_group column: %w", err)
@@ -313,13 +634,13 @@ func (d *Database) migrate() error {
 	// 如果字段不存在，添加它
 	if !columnExists {
 		log.Printf("Adding provider_group column to request_logs table...")
-		_, err = d.db.Exec(`ALTER TABLE request_logs ADD COLUMN provider_group TEXT NOT NULL DEFAULT ''`)
+		_, err = d.exec(`ALTER TABLE request_logs ADD COLUMN provider_group TEXT NOT NULL DEFAULT ''`)
 		if err != nil {
 			return fmt.Errorf("failed to add provider_group column: %w", err)
 		}
 
 		// 添加索引
-		_, err = d.db.Exec(`CREATE INDEX IF NOT EXISTS idx_request_logs_provider_group ON request_logs(provider_group)`)
+		_, err = d.exec(`CREATE INDEX IF NOT EXISTS idx_request_logs_provider_group ON request_logs(provider_group)`)
 		if err != nil {
 			return fmt.Errorf("failed to create provider_group index: %w", err)
 		}
@@ -330,32 +651,128 @@ func (d *Database) migrate() error {
 	return nil
 }
 
-// InsertRequestLog 插入请求日志
+// migrateLegacyRequestLogs 升级单提供商旧版本（仅支持OpenRouter）创建的request_logs表
+// 旧表没有provider_group列，建表语句中的分组索引会因此失败；旧版本的请求都发往OpenRouter，归入兼容旧配置生成的默认分组
+func (d *Database) migrateLegacyRequestLogs() error {
+	// 任何版本的request_logs都有id列，据此判断表是否已存在
+	tableExists, err := d.columnExists("request_logs", "id")
+	if err != nil {
+		return fmt.Errorf("failed to check request_logs table existence: %w", err)
+	}
+	if !tableExists {
+		return nil
+	}
+
+	columnExists, err := d.columnExists("request_logs", "provider_group")
+	if err != nil {
+		return fmt.Errorf("failed to check provider_group column: %w", err)
+	}
+	if columnExists {
+		return nil
+	}
+
+	log.Println("Upgrading legacy single-provider request_logs table...")
+	alterSQL := `ALTER TABLE request_logs ADD COLUMN provider_group TEXT NOT NULL DEFAULT ''`
+	if d.dialect.driverName() == DriverMySQL {
+		alterSQL = `ALTER TABLE request_logs ADD COLUMN provider_group VARCHAR(255) NOT NULL DEFAULT ''`
+	}
+	if _, err := d.exec(alterSQL); err != nil {
+		return fmt.Errorf("failed to add provider_group column: %w", err)
+	}
+
+	// 旧版本的error和response_body可能为NULL，新版本的查询按字符串读取
+	result, err := d.exec(`UPDATE request_logs SET provider_group = ?, error = COALESCE(error, ''),
+		response_body = COALESCE(response_body, '')`, LegacyProviderGroup)
+	if err != nil {
+		return fmt.Errorf("failed to assign legacy logs to %s: %w", LegacyProviderGroup, err)
+	}
+	updated, _ := result.RowsAffected()
+	log.Printf("Assigned %d legacy request logs to provider group %s", updated, LegacyProviderGroup)
+	return nil
+}
+
+// InsertRequestLog 插入请求日志，并将其链接到请求日志的哈希链
 func (d *Database) InsertRequestLog(log *RequestLog) error {
+	d.chainMu.Lock()
+	defer d.chainMu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := d.chainLogsTx(tx, []*RequestLog{log}); err != nil {
+		return err
+	}
+
 	query := `
 	INSERT INTO request_logs (
 		proxy_key_name, proxy_key_id, provider_group, openrouter_key, model, request_body, response_body,
 		status_code, is_stream, duration, tokens_used, tokens_estimated, error, client_ip, created_at,
-		has_tool_calls, tool_calls_count, tool_names
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		has_tool_calls, tool_calls_count, tool_names, upstream_headers, debug_trace, correlation_id, is_shadow, split_name, split_arm, prev_hash, row_hash,
+		request_id, cost, error_class, ttft_ms
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	result, err := d.db.Exec(query,
+	id, err := d.insertReturningID(tx, query,
 		log.ProxyKeyName, log.ProxyKeyID, log.ProviderGroup, log.OpenRouterKey, log.Model,
 		log.RequestBody, log.ResponseBody, log.StatusCode, log.IsStream,
 		log.Duration, log.TokensUsed, log.TokensEstimated, log.Error, log.ClientIP, log.CreatedAt,
-		log.HasToolCalls, log.ToolCallsCount, log.ToolNames,
+		log.HasToolCalls, log.ToolCallsCount, log.ToolNames, log.UpstreamHeaders, log.DebugTrace, log.CorrelationID, log.IsShadow, log.SplitName, log.SplitArm, log.PrevHash, log.RowHash,
+		log.RequestID, log.Cost, log.ErrorClass, log.TTFTMs,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to insert request log: %w", err)
 	}
 
-	id, err := result.LastInsertId()
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit request log: %w", err)
+	}
+	log.ID = id
+	return nil
+}
+
+// InsertRequestLogs 在一个事务中批量插入请求日志，并将其依次链接到请求日志的哈希链
+func (d *Database) InsertRequestLogs(logs []*RequestLog) error {
+	d.chainMu.Lock()
+	defer d.chainMu.Unlock()
+
+	tx, err := d.db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to get last insert id: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	log.ID = id
+	if err := d.chainLogsTx(tx, logs); err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO request_logs (
+		proxy_key_name, proxy_key_id, provider_group, openrouter_key, model, request_body, response_body,
+		status_code, is_stream, duration, tokens_used, tokens_estimated, error, client_ip, created_at,
+		has_tool_calls, tool_calls_count, tool_names, upstream_headers, debug_trace, correlation_id, is_shadow, split_name, split_arm, prev_hash, row_hash,
+		request_id, cost, error_class, ttft_ms
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	for _, log := range logs {
+		id, err := d.insertReturningID(tx, query,
+			log.ProxyKeyName, log.ProxyKeyID, log.ProviderGroup, log.OpenRouterKey, log.Model,
+			log.RequestBody, log.ResponseBody, log.StatusCode, log.IsStream,
+			log.Duration, log.TokensUsed, log.TokensEstimated, log.Error, log.ClientIP, log.CreatedAt,
+			log.HasToolCalls, log.ToolCallsCount, log.ToolNames, log.UpstreamHeaders, log.DebugTrace, log.CorrelationID, log.IsShadow, log.SplitName, log.SplitArm, log.PrevHash, log.RowHash,
+			log.RequestID, log.Cost, log.ErrorClass, log.TTFTMs,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert request log: %w", err)
+		}
+		log.ID = id
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit request logs: %w", err)
+	}
 	return nil
 }
 
@@ -390,7 +807,7 @@ func (d *Database) GetRequestLogs(proxyKeyName, providerGroup string, limit, off
 	query += " ORDER BY created_at DESC LIMIT ? OFFSET ?"
 	args = append(args, limit, offset)
 
-	rows, err := d.db.Query(query, args...)
+	rows, err := d.query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query request logs: %w", err)
 	}
@@ -417,40 +834,7 @@ func (d *Database) GetRequestLogs(proxyKeyName, providerGroup string, limit, off
 // GetRequestLogsWithFilter 根据筛选条件获取请求日志列表
 func (d *Database) GetRequestLogsWithFilter(filter *LogFilter) ([]*RequestLogSummary, error) {
 	var query string
-	var args []interface{}
-	var conditions []string
-
-	// 构建WHERE条件
-	if filter.ProxyKeyName != "" {
-		conditions = append(conditions, "proxy_key_name = ?")
-		args = append(args, filter.ProxyKeyName)
-	}
-
-	if filter.ProviderGroup != "" {
-		conditions = append(conditions, "provider_group = ?")
-		args = append(args, filter.ProviderGroup)
-	}
-
-	if filter.Model != "" {
-		conditions = append(conditions, "model = ?")
-		args = append(args, filter.Model)
-	}
-
-	if filter.Status != "" {
-		if filter.Status == "200" {
-			conditions = append(conditions, "status_code = 200")
-		} else if filter.Status == "error" {
-			conditions = append(conditions, "status_code != 200")
-		}
-	}
-
-	if filter.Stream != "" {
-		if filter.Stream == "true" {
-			conditions = append(conditions, "is_stream = 1")
-		} else if filter.Stream == "false" {
-			conditions = append(conditions, "is_stream = 0")
-		}
-	}
+	conditions, args := logFilterConditions(filter)
 
 	// 构建查询语句
 	query = `
@@ -466,7 +850,7 @@ func (d *Database) GetRequestLogsWithFilter(filter *LogFilter) ([]*RequestLogSum
 	query += " ORDER BY created_at DESC LIMIT ? OFFSET ?"
 	args = append(args, filter.Limit, filter.Offset)
 
-	rows, err := d.db.Query(query, args...)
+	rows, err := d.query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query request logs with filter: %w", err)
 	}
@@ -493,40 +877,8 @@ func (d *Database) GetRequestLogsWithFilter(filter *LogFilter) ([]*RequestLogSum
 // GetRequestCountWithFilter 根据筛选条件获取请求总数
 func (d *Database) GetRequestCountWithFilter(filter *LogFilter) (int64, error) {
 	var query string
-	var args []interface{}
-	var conditions []string
-
-	// 构建WHERE条件（与GetRequestLogsWithFilter保持一致）
-	if filter.ProxyKeyName != "" {
-		conditions = append(conditions, "proxy_key_name = ?")
-		args = append(args, filter.ProxyKeyName)
-	}
-
-	if filter.ProviderGroup != "" {
-		conditions = append(conditions, "provider_group = ?")
-		args = append(args, filter.ProviderGroup)
-	}
-
-	if filter.Model != "" {
-		conditions = append(conditions, "model = ?")
-		args = append(args, filter.Model)
-	}
-
-	if filter.Status != "" {
-		if filter.Status == "200" {
-			conditions = append(conditions, "status_code = 200")
-		} else if filter.Status == "error" {
-			conditions = append(conditions, "status_code != 200")
-		}
-	}
-
-	if filter.Stream != "" {
-		if filter.Stream == "true" {
-			conditions = append(conditions, "is_stream = 1")
-		} else if filter.Stream == "false" {
-			conditions = append(conditions, "is_stream = 0")
-		}
-	}
+	// WHERE条件与GetRequestLogsWithFilter保持一致
+	conditions, args := logFilterConditions(filter)
 
 	// 构建查询语句
 	query = "SELECT COUNT(*) FROM request_logs"
@@ -535,7 +887,7 @@ func (d *Database) GetRequestCountWithFilter(filter *LogFilter) (int64, error) {
 	}
 
 	var count int64
-	err := d.db.QueryRow(query, args...).Scan(&count)
+	err := d.queryRow(query, args...).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get request count with filter: %w", err)
 	}
@@ -548,17 +900,19 @@ func (d *Database) GetRequestLogDetail(id int64) (*RequestLog, error) {
 	query := `
 	SELECT id, proxy_key_name, proxy_key_id, provider_group, openrouter_key, model, request_body, response_body,
 		   status_code, is_stream, duration, tokens_used, tokens_estimated, error, client_ip, created_at,
-		   has_tool_calls, tool_calls_count, tool_names
+		   has_tool_calls, tool_calls_count, tool_names, upstream_headers, COALESCE(debug_trace, ''), correlation_id, is_shadow, split_name, split_arm, prev_hash, row_hash,
+		   request_id, cost, error_class, ttft_ms
 	FROM request_logs
 	WHERE id = ?
 	`
 
 	log := &RequestLog{}
-	err := d.db.QueryRow(query, id).Scan(
+	err := d.queryRow(query, id).Scan(
 		&log.ID, &log.ProxyKeyName, &log.ProxyKeyID, &log.ProviderGroup, &log.OpenRouterKey, &log.Model,
 		&log.RequestBody, &log.ResponseBody, &log.StatusCode, &log.IsStream,
 		&log.Duration, &log.TokensUsed, &log.TokensEstimated, &log.Error, &log.ClientIP, &log.CreatedAt,
-		&log.HasToolCalls, &log.ToolCallsCount, &log.ToolNames,
+		&log.HasToolCalls, &log.ToolCallsCount, &log.ToolNames, &log.UpstreamHeaders, &log.DebugTrace, &log.CorrelationID, &log.IsShadow, &log.SplitName, &log.SplitArm, &log.PrevHash, &log.RowHash,
+		&log.RequestID, &log.Cost, &log.ErrorClass, &log.TTFTMs,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -570,6 +924,41 @@ func (d *Database) GetRequestLogDetail(id int64) (*RequestLog, error) {
 	return log, nil
 }
 
+// GetRequestLogsByRequestID 按请求ID查找请求日志，故障转移和影子请求可能产生多条，按时间顺序返回
+func (d *Database) GetRequestLogsByRequestID(requestID string) ([]*RequestLogSummary, error) {
+	query := `
+	SELECT id, proxy_key_name, proxy_key_id, provider_group, openrouter_key, model, status_code,
+		   is_stream, duration, tokens_used, tokens_estimated, COALESCE(error, ''), client_ip, created_at,
+		   has_tool_calls, tool_calls_count, tool_names
+	FROM request_logs
+	WHERE request_id = ?
+	ORDER BY id ASC`
+
+	rows, err := d.query(query, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query request logs by request id: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []*RequestLogSummary
+	for rows.Next() {
+		log := &RequestLogSummary{}
+		if err := rows.Scan(
+			&log.ID, &log.ProxyKeyName, &log.ProxyKeyID, &log.ProviderGroup, &log.OpenRouterKey,
+			&log.Model, &log.StatusCode, &log.IsStream, &log.Duration,
+			&log.TokensUsed, &log.TokensEstimated, &log.Error, &log.ClientIP, &log.CreatedAt,
+			&log.HasToolCalls, &log.ToolCallsCount, &log.ToolNames,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan request log: %w", err)
+		}
+		logs = append(logs, log)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read request logs: %w", err)
+	}
+	return logs, nil
+}
+
 // GetProxyKeyStats 获取代理密钥统计
 func (d *Database) GetProxyKeyStats() ([]*ProxyKeyStats, error) {
 	query := `
@@ -586,7 +975,7 @@ func (d *Database) GetProxyKeyStats() ([]*ProxyKeyStats, error) {
 	ORDER BY total_requests DESC
 	`
 
-	rows, err := d.db.Query(query)
+	rows, err := d.query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query proxy key stats: %w", err)
 	}
@@ -622,7 +1011,7 @@ func (d *Database) GetModelStats() ([]*ModelStats, error) {
 	ORDER BY total_requests DESC
 	`
 
-	rows, err := d.db.Query(query)
+	rows, err := d.query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query model stats: %w", err)
 	}
@@ -649,10 +1038,10 @@ func (d *Database) GetModelStatsWithFilter(filter *LogFilter) ([]*ModelStats, er
 		conds []string
 		args  []interface{}
 	)
-	
+
 	// 基础条件：只统计成功的请求
 	conds = append(conds, "status_code = 200")
-	
+
 	if filter != nil {
 		if filter.ProxyKeyName != "" {
 			conds = append(conds, "proxy_key_name = ?")
@@ -666,11 +1055,12 @@ func (d *Database) GetModelStatsWithFilter(filter *LogFilter) ([]*ModelStats, er
 			conds = append(conds, "model = ?")
 			args = append(args, filter.Model)
 		}
+		conds, args = appendProxyKeyIDsCondition(conds, args, filter.ProxyKeyIDs)
 		if filter.Stream != "" {
 			if filter.Stream == "true" {
-				conds = append(conds, "is_stream = 1")
+				conds = append(conds, "is_stream = TRUE")
 			} else if filter.Stream == "false" {
-				conds = append(conds, "is_stream = 0")
+				conds = append(conds, "is_stream = FALSE")
 			}
 		}
 		if filter.StartTime != nil {
@@ -682,7 +1072,7 @@ func (d *Database) GetModelStatsWithFilter(filter *LogFilter) ([]*ModelStats, er
 			args = append(args, filter.EndTime.Format("2006-01-02 15:04:05"))
 		}
 	}
-	
+
 	query := `
 	SELECT
 		model,
@@ -690,14 +1080,14 @@ func (d *Database) GetModelStatsWithFilter(filter *LogFilter) ([]*ModelStats, er
 		SUM(tokens_used) as total_tokens,
 		AVG(duration) as avg_duration
 	FROM request_logs`
-	
+
 	if len(conds) > 0 {
 		query += " WHERE " + strings.Join(conds, " AND ")
 	}
-	
+
 	query += " GROUP BY model ORDER BY total_requests DESC"
 
-	rows, err := d.db.Query(query, args...)
+	rows, err := d.query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query model stats with filter: %w", err)
 	}
@@ -730,7 +1120,7 @@ func (d *Database) GetTotalTokensStats() (*TotalTokensStats, error) {
 	`
 
 	stats := &TotalTokensStats{}
-	err := d.db.QueryRow(query).Scan(
+	err := d.queryRow(query).Scan(
 		&stats.TotalTokens, &stats.SuccessTokens, &stats.TotalRequests, &stats.SuccessRequests,
 	)
 	if err != nil {
@@ -764,7 +1154,7 @@ func (d *Database) GetRequestCount(proxyKeyName, providerGroup string) (int64, e
 	}
 
 	var count int64
-	err := d.db.QueryRow(query, args...).Scan(&count)
+	err := d.queryRow(query, args...).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get request count: %w", err)
 	}
@@ -785,13 +1175,16 @@ func (d *Database) InsertProxyKey(key *ProxyKey) error {
 	}
 
 	query := `
-	INSERT INTO proxy_keys (id, name, description, key, allowed_groups, group_selection_config, is_active, usage_count, created_at, updated_at)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	INSERT INTO proxy_keys (id, name, description, "key", allowed_groups, group_selection_config, is_active, usage_count, created_at, updated_at,
+		expires_at, max_usage_count, allowed_models, denied_models, allowed_ips, denied_ips, system_prompt, tenant_id)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := d.db.Exec(query,
+	_, err := d.exec(query,
 		key.ID, key.Name, key.Description, key.Key, allowedGroupsJSON, key.GroupSelectionConfig, key.IsActive, key.UsageCount,
-		key.CreatedAt, key.UpdatedAt,
+		key.CreatedAt, key.UpdatedAt, key.ExpiresAt, key.MaxUsageCount,
+		marshalModelPatterns(key.AllowedModels), marshalModelPatterns(key.DeniedModels),
+		marshalModelPatterns(key.AllowedIPs), marshalModelPatterns(key.DeniedIPs), key.SystemPrompt, nullableTenantID(key.TenantID),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to insert proxy key: %w", err)
@@ -803,17 +1196,19 @@ func (d *Database) InsertProxyKey(key *ProxyKey) error {
 // GetProxyKey 根据密钥获取代理密钥信息
 func (d *Database) GetProxyKey(keyValue string) (*ProxyKey, error) {
 	query := `
-	SELECT id, name, description, key, allowed_groups, group_selection_config, is_active, usage_count, created_at, updated_at, last_used_at
+	SELECT id, name, description, "key", allowed_groups, group_selection_config, is_active, usage_count, created_at, updated_at, last_used_at,
+		expires_at, max_usage_count, allowed_models, denied_models, allowed_ips, denied_ips, system_prompt, tenant_id
 	FROM proxy_keys
-	WHERE key = ? AND is_active = 1
+	WHERE "key" = ? AND is_active = TRUE
 	`
 
 	key := &ProxyKey{}
 	var allowedGroupsJSON string
-	var groupSelectionConfigJSON sql.NullString
-	err := d.db.QueryRow(query, keyValue).Scan(
+	var groupSelectionConfigJSON, allowedModelsJSON, deniedModelsJSON, allowedIPsJSON, deniedIPsJSON, systemPrompt, tenantID sql.NullString
+	err := d.queryRow(query, keyValue).Scan(
 		&key.ID, &key.Name, &key.Description, &key.Key, &allowedGroupsJSON, &groupSelectionConfigJSON, &key.IsActive,
 		&key.UsageCount, &key.CreatedAt, &key.UpdatedAt, &key.LastUsedAt,
+		&key.ExpiresAt, &key.MaxUsageCount, &allowedModelsJSON, &deniedModelsJSON, &allowedIPsJSON, &deniedIPsJSON, &systemPrompt, &tenantID,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -837,6 +1232,12 @@ func (d *Database) GetProxyKey(keyValue string) (*ProxyKey, error) {
 	} else {
 		key.GroupSelectionConfig = ""
 	}
+	key.AllowedModels = unmarshalModelPatterns(allowedModelsJSON)
+	key.DeniedModels = unmarshalModelPatterns(deniedModelsJSON)
+	key.AllowedIPs = unmarshalModelPatterns(allowedIPsJSON)
+	key.DeniedIPs = unmarshalModelPatterns(deniedIPsJSON)
+	key.SystemPrompt = systemPrompt.String
+	key.TenantID = tenantID.String
 
 	return key, nil
 }
@@ -844,12 +1245,13 @@ func (d *Database) GetProxyKey(keyValue string) (*ProxyKey, error) {
 // GetAllProxyKeys 获取所有代理密钥
 func (d *Database) GetAllProxyKeys() ([]*ProxyKey, error) {
 	query := `
-	SELECT id, name, description, key, allowed_groups, group_selection_config, is_active, usage_count, created_at, updated_at, last_used_at
+	SELECT id, name, description, "key", allowed_groups, group_selection_config, is_active, usage_count, created_at, updated_at, last_used_at,
+		expires_at, max_usage_count, allowed_models, denied_models, allowed_ips, denied_ips, system_prompt, tenant_id
 	FROM proxy_keys
 	ORDER BY created_at DESC
 	`
 
-	rows, err := d.db.Query(query)
+	rows, err := d.query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query proxy keys: %w", err)
 	}
@@ -859,10 +1261,11 @@ func (d *Database) GetAllProxyKeys() ([]*ProxyKey, error) {
 	for rows.Next() {
 		key := &ProxyKey{}
 		var allowedGroupsJSON string
-		var groupSelectionConfigJSON sql.NullString
+		var groupSelectionConfigJSON, allowedModelsJSON, deniedModelsJSON, allowedIPsJSON, deniedIPsJSON, systemPrompt, tenantID sql.NullString
 		if err := rows.Scan(
 			&key.ID, &key.Name, &key.Description, &key.Key, &allowedGroupsJSON, &groupSelectionConfigJSON, &key.IsActive,
 			&key.UsageCount, &key.CreatedAt, &key.UpdatedAt, &key.LastUsedAt,
+			&key.ExpiresAt, &key.MaxUsageCount, &allowedModelsJSON, &deniedModelsJSON, &allowedIPsJSON, &deniedIPsJSON, &systemPrompt, &tenantID,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan proxy key: %w", err)
 		}
@@ -882,6 +1285,13 @@ func (d *Database) GetAllProxyKeys() ([]*ProxyKey, error) {
 		} else {
 			key.GroupSelectionConfig = ""
 		}
+		key.AllowedModels = unmarshalModelPatterns(allowedModelsJSON)
+		key.DeniedModels = unmarshalModelPatterns(deniedModelsJSON)
+		key.AllowedIPs = unmarshalModelPatterns(allowedIPsJSON)
+		key.DeniedIPs = unmarshalModelPatterns(deniedIPsJSON)
+		key.SystemPrompt = systemPrompt.String
+		key.SystemPrompt = systemPrompt.String
+		key.TenantID = tenantID.String
 
 		keys = append(keys, key)
 	}
@@ -903,13 +1313,16 @@ func (d *Database) UpdateProxyKey(key *ProxyKey) error {
 
 	query := `
 	UPDATE proxy_keys
-	SET name = ?, description = ?, allowed_groups = ?, group_selection_config = ?, is_active = ?, usage_count = ?, updated_at = ?
+	SET name = ?, description = ?, allowed_groups = ?, group_selection_config = ?, is_active = ?, usage_count = ?, updated_at = ?,
+		expires_at = ?, max_usage_count = ?, allowed_models = ?, denied_models = ?, allowed_ips = ?, denied_ips = ?, system_prompt = ?, tenant_id = ?
 	WHERE id = ?
 	`
 
 	now := time.Now()
-	_, err := d.db.Exec(query,
-		key.Name, key.Description, allowedGroupsJSON, key.GroupSelectionConfig, key.IsActive, key.UsageCount, now, key.ID,
+	_, err := d.exec(query,
+		key.Name, key.Description, allowedGroupsJSON, key.GroupSelectionConfig, key.IsActive, key.UsageCount, now,
+		key.ExpiresAt, key.MaxUsageCount, marshalModelPatterns(key.AllowedModels), marshalModelPatterns(key.DeniedModels),
+		marshalModelPatterns(key.AllowedIPs), marshalModelPatterns(key.DeniedIPs), key.SystemPrompt, nullableTenantID(key.TenantID), key.ID,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to update proxy key: %w", err)
@@ -923,7 +1336,7 @@ func (d *Database) UpdateProxyKeyLastUsed(keyID string) error {
 	query := `UPDATE proxy_keys SET last_used_at = ?, updated_at = ? WHERE id = ?`
 
 	now := time.Now()
-	_, err := d.db.Exec(query, now, now, keyID)
+	_, err := d.exec(query, now, now, keyID)
 	if err != nil {
 		return fmt.Errorf("failed to update proxy key last used: %w", err)
 	}
@@ -936,7 +1349,7 @@ func (d *Database) UpdateProxyKeyUsage(keyID string) error {
 	query := `UPDATE proxy_keys SET usage_count = usage_count + 1, last_used_at = ?, updated_at = ? WHERE id = ?`
 
 	now := time.Now()
-	_, err := d.db.Exec(query, now, now, keyID)
+	_, err := d.exec(query, now, now, keyID)
 	if err != nil {
 		return fmt.Errorf("failed to update proxy key usage: %w", err)
 	}
@@ -948,7 +1361,7 @@ func (d *Database) UpdateProxyKeyUsage(keyID string) error {
 func (d *Database) DeleteProxyKey(keyID string) error {
 	query := `DELETE FROM proxy_keys WHERE id = ?`
 
-	_, err := d.db.Exec(query, keyID)
+	_, err := d.exec(query, keyID)
 	if err != nil {
 		return fmt.Errorf("failed to delete proxy key: %w", err)
 	}
@@ -956,18 +1369,197 @@ func (d *Database) DeleteProxyKey(keyID string) error {
 	return nil
 }
 
-// CleanupOldLogs 清理旧日志（保留指定天数的日志）
-func (d *Database) CleanupOldLogs(retentionDays int) error {
-	query := `DELETE FROM request_logs WHERE created_at < datetime('now', '-' || ? || ' days')`
+// InsertAdminToken 插入管理API令牌
+func (d *Database) InsertAdminToken(token *AdminToken) error {
+	scopesJSON, err := json.Marshal(token.Scopes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal admin token scopes: %w", err)
+	}
+
+	query := `
+	INSERT INTO admin_tokens (id, name, token_hash, token_prefix, scopes, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`
 
-	result, err := d.db.Exec(query, retentionDays)
+	if _, err := d.exec(query, token.ID, token.Name, token.TokenHash, token.TokenPrefix, string(scopesJSON), token.CreatedAt); err != nil {
+		return fmt.Errorf("failed to insert admin token: %w", err)
+	}
+	return nil
+}
+
+// GetAdminTokenByHash 根据令牌哈希获取管理API令牌
+func (d *Database) GetAdminTokenByHash(tokenHash string) (*AdminToken, error) {
+	query := `
+	SELECT id, name, token_hash, token_prefix, scopes, created_at, last_used_at
+	FROM admin_tokens
+	WHERE token_hash = ?
+	`
+
+	token, err := scanAdminToken(d.queryRow(query, tokenHash))
 	if err != nil {
-		return fmt.Errorf("failed to cleanup old logs: %w", err)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("admin token not found")
+		}
+		return nil, fmt.Errorf("failed to get admin token: %w", err)
 	}
+	return token, nil
+}
 
-	rowsAffected, err := result.RowsAffected()
+// GetAllAdminTokens 获取所有管理API令牌
+func (d *Database) GetAllAdminTokens() ([]*AdminToken, error) {
+	query := `
+	SELECT id, name, token_hash, token_prefix, scopes, created_at, last_used_at
+	FROM admin_tokens
+	ORDER BY created_at DESC
+	`
+
+	rows, err := d.query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query admin tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []*AdminToken{}
+	for rows.Next() {
+		token, err := scanAdminToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan admin token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// UpdateAdminTokenLastUsed 更新管理API令牌最后使用时间
+func (d *Database) UpdateAdminTokenLastUsed(id string) error {
+	if _, err := d.exec(`UPDATE admin_tokens SET last_used_at = ? WHERE id = ?`, time.Now(), id); err != nil {
+		return fmt.Errorf("failed to update admin token last used: %w", err)
+	}
+	return nil
+}
+
+// DeleteAdminToken 删除（吊销）管理API令牌
+func (d *Database) DeleteAdminToken(id string) error {
+	result, err := d.exec(`DELETE FROM admin_tokens WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete admin token: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("admin token not found")
+	}
+	return nil
+}
+
+// scanAdminToken 扫描管理API令牌行
+func scanAdminToken(row interface{ Scan(...interface{}) error }) (*AdminToken, error) {
+	token := &AdminToken{}
+	var scopesJSON string
+	if err := row.Scan(&token.ID, &token.Name, &token.TokenHash, &token.TokenPrefix, &scopesJSON,
+		&token.CreatedAt, &token.LastUsedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(scopesJSON), &token.Scopes); err != nil || token.Scopes == nil {
+		token.Scopes = []string{}
+	}
+	return token, nil
+}
+
+// InsertAuditLog 插入审计日志
+func (d *Database) InsertAuditLog(entry *AuditLog) error {
+	query := `
+	INSERT INTO audit_logs (actor, action, target, client_ip, "before", "after", created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	id, err := d.insertReturningID(d.db, query, entry.Actor, entry.Action, entry.Target, entry.ClientIP,
+		entry.Before, entry.After, entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit log: %w", err)
+	}
+	entry.ID = id
+	return nil
+}
+
+// buildAuditConditions 构建审计日志筛选条件
+func buildAuditConditions(filter *AuditFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		// 按前缀匹配，操作名中的 _ 和 % 按字面匹配
+		conditions = append(conditions, "action LIKE ? ESCAPE '!'")
+		args = append(args, escapeLikePattern(filter.Action)+"%")
+	}
+	if filter.Target != "" {
+		conditions = append(conditions, "target = ?")
+		args = append(args, filter.Target)
+	}
+	if filter.StartTime != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *filter.StartTime)
+	}
+	if filter.EndTime != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, *filter.EndTime)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// GetAuditLogs 根据筛选条件获取审计日志，按时间倒序
+func (d *Database) GetAuditLogs(filter *AuditFilter) ([]*AuditLog, error) {
+	where, args := buildAuditConditions(filter)
+	query := `
+	SELECT id, actor, action, target, client_ip, "before", "after", created_at
+	FROM audit_logs` + where + " ORDER BY created_at DESC, id DESC"
+
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := d.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit logs: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []*AuditLog{}
+	for rows.Next() {
+		entry := &AuditLog{}
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.Target, &entry.ClientIP,
+			&entry.Before, &entry.After, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit log: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// GetAuditLogCount 根据筛选条件获取审计日志总数
+func (d *Database) GetAuditLogCount(filter *AuditFilter) (int64, error) {
+	where, args := buildAuditConditions(filter)
+
+	var count int64
+	if err := d.queryRow("SELECT COUNT(*) FROM audit_logs"+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count audit logs: %w", err)
+	}
+	return count, nil
+}
+
+// CleanupOldLogs 清理旧日志（保留指定天数的日志）
+func (d *Database) CleanupOldLogs(retentionDays int) error {
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	rowsAffected, err := d.deleteChainedLogsWhere("retention", `created_at < ?`, cutoff)
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return fmt.Errorf("failed to cleanup old logs: %w", err)
 	}
 
 	log.Printf("Cleaned up %d old log records", rowsAffected)
@@ -988,16 +1580,9 @@ func (d *Database) DeleteRequestLogs(ids []int64) (int64, error) {
 		args[i] = id
 	}
 
-	query := fmt.Sprintf("DELETE FROM request_logs WHERE id IN (%s)", strings.Join(placeholders, ","))
-
-	result, err := d.db.Exec(query, args...)
-	if err != nil {
-		return 0, fmt.Errorf("failed to delete request logs: %w", err)
-	}
-
-	rowsAffected, err := result.RowsAffected()
+	rowsAffected, err := d.deleteChainedLogsWhere("manual", fmt.Sprintf("id IN (%s)", strings.Join(placeholders, ",")), args...)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+		return rowsAffected, fmt.Errorf("failed to delete request logs: %w", err)
 	}
 
 	return rowsAffected, nil
@@ -1007,7 +1592,7 @@ func (d *Database) DeleteRequestLogs(ids []int64) (int64, error) {
 func (d *Database) ClearAllRequestLogs() (int64, error) {
 	query := `DELETE FROM request_logs`
 
-	result, err := d.db.Exec(query)
+	result, err := d.exec(query)
 	if err != nil {
 		return 0, fmt.Errorf("failed to clear all request logs: %w", err)
 	}
@@ -1022,16 +1607,9 @@ func (d *Database) ClearAllRequestLogs() (int64, error) {
 
 // ClearErrorRequestLogs 清空错误请求日志（状态码不等于200的日志）
 func (d *Database) ClearErrorRequestLogs() (int64, error) {
-	query := `DELETE FROM request_logs WHERE status_code != 200`
-
-	result, err := d.db.Exec(query)
-	if err != nil {
-		return 0, fmt.Errorf("failed to clear error request logs: %w", err)
-	}
-
-	rowsAffected, err := result.RowsAffected()
+	rowsAffected, err := d.deleteChainedLogsWhere("manual", `status_code != 200`)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+		return rowsAffected, fmt.Errorf("failed to clear error request logs: %w", err)
 	}
 
 	return rowsAffected, nil
@@ -1058,7 +1636,7 @@ func (d *Database) GetAllRequestLogsForExport(proxyKeyName, providerGroup string
 	query = `
 	SELECT id, proxy_key_name, proxy_key_id, provider_group, openrouter_key, model, request_body, response_body,
 		   status_code, is_stream, duration, tokens_used, tokens_estimated, error, client_ip, created_at,
-		   has_tool_calls, tool_calls_count, tool_names
+		   has_tool_calls, tool_calls_count, tool_names, upstream_headers
 	FROM request_logs`
 
 	if len(conditions) > 0 {
@@ -1067,7 +1645,7 @@ func (d *Database) GetAllRequestLogsForExport(proxyKeyName, providerGroup string
 
 	query += " ORDER BY created_at DESC"
 
-	rows, err := d.db.Query(query, args...)
+	rows, err := d.query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query request logs for export: %w", err)
 	}
@@ -1080,7 +1658,7 @@ func (d *Database) GetAllRequestLogsForExport(proxyKeyName, providerGroup string
 			&log.ID, &log.ProxyKeyName, &log.ProxyKeyID, &log.ProviderGroup, &log.OpenRouterKey,
 			&log.Model, &log.RequestBody, &log.ResponseBody, &log.StatusCode, &log.IsStream,
 			&log.Duration, &log.TokensUsed, &log.TokensEstimated, &log.Error, &log.ClientIP, &log.CreatedAt,
-			&log.HasToolCalls, &log.ToolCallsCount, &log.ToolNames,
+			&log.HasToolCalls, &log.ToolCallsCount, &log.ToolNames, &log.UpstreamHeaders,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan request log: %w", err)
@@ -1094,46 +1672,15 @@ func (d *Database) GetAllRequestLogsForExport(proxyKeyName, providerGroup string
 // GetAllRequestLogsForExportWithFilter 根据筛选条件获取所有请求日志用于导出（包含完整信息）
 func (d *Database) GetAllRequestLogsForExportWithFilter(filter *LogFilter) ([]*RequestLog, error) {
 	var query string
-	var args []interface{}
-	var conditions []string
-
-	// 构建WHERE条件（与GetRequestLogsWithFilter保持一致）
-	if filter.ProxyKeyName != "" {
-		conditions = append(conditions, "proxy_key_name = ?")
-		args = append(args, filter.ProxyKeyName)
-	}
-
-	if filter.ProviderGroup != "" {
-		conditions = append(conditions, "provider_group = ?")
-		args = append(args, filter.ProviderGroup)
-	}
 
-	if filter.Model != "" {
-		conditions = append(conditions, "model = ?")
-		args = append(args, filter.Model)
-	}
-
-	if filter.Status != "" {
-		if filter.Status == "200" {
-			conditions = append(conditions, "status_code = 200")
-		} else if filter.Status == "error" {
-			conditions = append(conditions, "status_code != 200")
-		}
-	}
-
-	if filter.Stream != "" {
-		if filter.Stream == "true" {
-			conditions = append(conditions, "is_stream = 1")
-		} else if filter.Stream == "false" {
-			conditions = append(conditions, "is_stream = 0")
-		}
-	}
+	// 构建WHERE条件（与流式导出保持一致，包括时间范围）
+	conditions, args := logFilterConditions(filter)
 
 	// 构建查询语句
 	query = `
 	SELECT id, proxy_key_name, proxy_key_id, provider_group, openrouter_key, model, request_body, response_body,
 		   status_code, is_stream, duration, tokens_used, tokens_estimated, error, client_ip, created_at,
-		   has_tool_calls, tool_calls_count, tool_names
+		   has_tool_calls, tool_calls_count, tool_names, upstream_headers, is_shadow
 	FROM request_logs`
 
 	if len(conditions) > 0 {
@@ -1142,7 +1689,7 @@ func (d *Database) GetAllRequestLogsForExportWithFilter(filter *LogFilter) ([]*R
 
 	query += " ORDER BY created_at DESC"
 
-	rows, err := d.db.Query(query, args...)
+	rows, err := d.query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query request logs for export with filter: %w", err)
 	}
@@ -1155,7 +1702,7 @@ func (d *Database) GetAllRequestLogsForExportWithFilter(filter *LogFilter) ([]*R
 			&log.ID, &log.ProxyKeyName, &log.ProxyKeyID, &log.ProviderGroup, &log.OpenRouterKey,
 			&log.Model, &log.RequestBody, &log.ResponseBody, &log.StatusCode, &log.IsStream,
 			&log.Duration, &log.TokensUsed, &log.TokensEstimated, &log.Error, &log.ClientIP, &log.CreatedAt,
-			&log.HasToolCalls, &log.ToolCallsCount, &log.ToolNames,
+			&log.HasToolCalls, &log.ToolCallsCount, &log.ToolNames, &log.UpstreamHeaders, &log.IsShadow,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan request log: %w", err)
@@ -1166,216 +1713,219 @@ func (d *Database) GetAllRequestLogsForExportWithFilter(filter *LogFilter) ([]*R
 	return logs, nil
 }
 
- // GetStatusStats 基于筛选与时间范围的状态分布聚合
- func (d *Database) GetStatusStats(filter *LogFilter) (*StatusStats, error) {
- 	var (
- 		conds []string
- 		args  []interface{}
- 	)
- 	if filter != nil {
- 		if filter.ProxyKeyName != "" {
- 			conds = append(conds, "proxy_key_name = ?")
- 			args = append(args, filter.ProxyKeyName)
- 		}
- 		if filter.ProviderGroup != "" {
- 			conds = append(conds, "provider_group = ?")
- 			args = append(args, filter.ProviderGroup)
- 		}
- 		if filter.Model != "" {
- 			conds = append(conds, "model = ?")
- 			args = append(args, filter.Model)
- 		}
- 		if filter.Stream != "" {
- 			if filter.Stream == "true" {
- 				conds = append(conds, "is_stream = 1")
- 			} else if filter.Stream == "false" {
- 				conds = append(conds, "is_stream = 0")
- 			}
- 		}
- 		if filter.Status != "" {
- 			if filter.Status == "200" {
- 				conds = append(conds, "status_code = 200")
- 			} else if filter.Status == "error" {
- 				conds = append(conds, "status_code != 200")
- 			}
- 		}
- 		if filter.StartTime != nil {
- 			conds = append(conds, "created_at >= ?")
- 			args = append(args, filter.StartTime.Format("2006-01-02 15:04:05"))
- 		}
- 		if filter.EndTime != nil {
- 			conds = append(conds, "created_at <= ?")
- 			args = append(args, filter.EndTime.Format("2006-01-02 15:04:05"))
- 		}
- 	}
- 	query := `
+// GetStatusStats 基于筛选与时间范围的状态分布聚合
+func (d *Database) GetStatusStats(filter *LogFilter) (*StatusStats, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if filter != nil {
+		if filter.ProxyKeyName != "" {
+			conds = append(conds, "proxy_key_name = ?")
+			args = append(args, filter.ProxyKeyName)
+		}
+		if filter.ProviderGroup != "" {
+			conds = append(conds, "provider_group = ?")
+			args = append(args, filter.ProviderGroup)
+		}
+		if filter.Model != "" {
+			conds = append(conds, "model = ?")
+			args = append(args, filter.Model)
+		}
+		conds, args = appendProxyKeyIDsCondition(conds, args, filter.ProxyKeyIDs)
+		if filter.Stream != "" {
+			if filter.Stream == "true" {
+				conds = append(conds, "is_stream = TRUE")
+			} else if filter.Stream == "false" {
+				conds = append(conds, "is_stream = FALSE")
+			}
+		}
+		if filter.Status != "" {
+			if filter.Status == "200" {
+				conds = append(conds, "status_code = 200")
+			} else if filter.Status == "error" {
+				conds = append(conds, "status_code != 200")
+			}
+		}
+		if filter.StartTime != nil {
+			conds = append(conds, "created_at >= ?")
+			args = append(args, filter.StartTime.Format("2006-01-02 15:04:05"))
+		}
+		if filter.EndTime != nil {
+			conds = append(conds, "created_at <= ?")
+			args = append(args, filter.EndTime.Format("2006-01-02 15:04:05"))
+		}
+	}
+	query := `
  		SELECT
  			SUM(CASE WHEN status_code = 200 THEN 1 ELSE 0 END) AS success_count,
  			SUM(CASE WHEN status_code != 200 THEN 1 ELSE 0 END) AS error_count
  		FROM request_logs`
- 	if len(conds) > 0 {
- 		query += " WHERE " + strings.Join(conds, " AND ")
- 	}
- 	var res StatusStats
- 	if err := d.db.QueryRow(query, args...).Scan(&res.Success, &res.Error); err != nil {
- 		return nil, fmt.Errorf("failed to query status stats: %w", err)
- 	}
- 	return &res, nil
- }
- 
- // GetTokensTimeline 基于筛选与时间范围的tokens时间序列；≤24h按小时，否则按天
- func (d *Database) GetTokensTimeline(filter *LogFilter) ([]*TimelinePoint, error) {
- 	var (
- 		conds []string
- 		args  []interface{}
- 	)
- 	var start, end time.Time
- 	hasRange := false
- 	if filter != nil {
- 		if filter.ProxyKeyName != "" {
- 			conds = append(conds, "proxy_key_name = ?")
- 			args = append(args, filter.ProxyKeyName)
- 		}
- 		if filter.ProviderGroup != "" {
- 			conds = append(conds, "provider_group = ?")
- 			args = append(args, filter.ProviderGroup)
- 		}
- 		if filter.Model != "" {
- 			conds = append(conds, "model = ?")
- 			args = append(args, filter.Model)
- 		}
- 		if filter.Stream != "" {
- 			if filter.Stream == "true" {
- 				conds = append(conds, "is_stream = 1")
- 			} else if filter.Stream == "false" {
- 				conds = append(conds, "is_stream = 0")
- 			}
- 		}
- 		// 注意：不要用 Status 限制到 success-only，这里要返回 total 与 success 两条序列
- 		if filter.StartTime != nil {
- 			start = *filter.StartTime
- 			conds = append(conds, "created_at >= ?")
- 			args = append(args, start.Format("2006-01-02 15:04:05"))
- 			hasRange = true
- 		}
- 		if filter.EndTime != nil {
- 			end = *filter.EndTime
- 			conds = append(conds, "created_at <= ?")
- 			args = append(args, end.Format("2006-01-02 15:04:05"))
- 			hasRange = true
- 		}
- 	}
- 	// 自动选择粒度
- 	bucket := "%Y-%m-%d"
- 	if hasRange {
- 		if end.IsZero() {
- 			end = time.Now()
- 		}
- 		if start.IsZero() {
- 			// 默认取最近24h
- 			start = end.Add(-24 * time.Hour)
- 		}
- 		if end.Sub(start) <= 24*time.Hour {
- 			bucket = "%Y-%m-%d %H:00"
- 		}
- 	}
- 	query := fmt.Sprintf(`
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	var res StatusStats
+	if err := d.queryRow(query, args...).Scan(&res.Success, &res.Error); err != nil {
+		return nil, fmt.Errorf("failed to query status stats: %w", err)
+	}
+	return &res, nil
+}
+
+// GetTokensTimeline 基于筛选与时间范围的tokens时间序列；≤24h按小时，否则按天
+func (d *Database) GetTokensTimeline(filter *LogFilter) ([]*TimelinePoint, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	var start, end time.Time
+	hasRange := false
+	if filter != nil {
+		if filter.ProxyKeyName != "" {
+			conds = append(conds, "proxy_key_name = ?")
+			args = append(args, filter.ProxyKeyName)
+		}
+		if filter.ProviderGroup != "" {
+			conds = append(conds, "provider_group = ?")
+			args = append(args, filter.ProviderGroup)
+		}
+		if filter.Model != "" {
+			conds = append(conds, "model = ?")
+			args = append(args, filter.Model)
+		}
+		conds, args = appendProxyKeyIDsCondition(conds, args, filter.ProxyKeyIDs)
+		if filter.Stream != "" {
+			if filter.Stream == "true" {
+				conds = append(conds, "is_stream = TRUE")
+			} else if filter.Stream == "false" {
+				conds = append(conds, "is_stream = FALSE")
+			}
+		}
+		// 注意：不要用 Status 限制到 success-only，这里要返回 total 与 success 两条序列
+		if filter.StartTime != nil {
+			start = *filter.StartTime
+			conds = append(conds, "created_at >= ?")
+			args = append(args, start.Format("2006-01-02 15:04:05"))
+			hasRange = true
+		}
+		if filter.EndTime != nil {
+			end = *filter.EndTime
+			conds = append(conds, "created_at <= ?")
+			args = append(args, end.Format("2006-01-02 15:04:05"))
+			hasRange = true
+		}
+	}
+	// 自动选择粒度
+	hourly := false
+	if hasRange {
+		if end.IsZero() {
+			end = time.Now()
+		}
+		if start.IsZero() {
+			// 默认取最近24h
+			start = end.Add(-24 * time.Hour)
+		}
+		if end.Sub(start) <= 24*time.Hour {
+			hourly = true
+		}
+	}
+	query := `
  		SELECT
- 			strftime('%s', created_at) AS bucket_time,
+ 			` + d.dialect.dateBucket("created_at", hourly) + ` AS bucket_time,
  			SUM(tokens_used) AS total_tokens,
  			SUM(CASE WHEN status_code = 200 THEN tokens_used ELSE 0 END) AS success_tokens
- 		FROM request_logs`, bucket)
- 	if len(conds) > 0 {
- 		query += " WHERE " + strings.Join(conds, " AND ")
- 	}
- 	query += " GROUP BY bucket_time ORDER BY bucket_time ASC"
- 
- 	rows, err := d.db.Query(query, args...)
- 	if err != nil {
- 		return nil, fmt.Errorf("failed to query tokens timeline: %w", err)
- 	}
- 	defer rows.Close()
- 
- 	var out []*TimelinePoint
- 	for rows.Next() {
- 		var t TimelinePoint
- 		if err := rows.Scan(&t.Date, &t.Total, &t.Success); err != nil {
- 			return nil, fmt.Errorf("failed to scan timeline row: %w", err)
- 		}
- 		out = append(out, &t)
- 	}
- 	return out, nil
- }
- 
- // GetGroupTokensStats 基于筛选与时间范围的分组tokens聚合（按 total desc）
- func (d *Database) GetGroupTokensStats(filter *LogFilter) ([]*GroupTokensStat, error) {
- 	var (
- 		conds []string
- 		args  []interface{}
- 	)
- 	if filter != nil {
- 		if filter.ProxyKeyName != "" {
- 			conds = append(conds, "proxy_key_name = ?")
- 			args = append(args, filter.ProxyKeyName)
- 		}
- 		if filter.ProviderGroup != "" {
- 			conds = append(conds, "provider_group = ?")
- 			args = append(args, filter.ProviderGroup)
- 		}
- 		if filter.Model != "" {
- 			conds = append(conds, "model = ?")
- 			args = append(args, filter.Model)
- 		}
- 		if filter.Stream != "" {
- 			if filter.Stream == "true" {
- 				conds = append(conds, "is_stream = 1")
- 			} else if filter.Stream == "false" {
- 				conds = append(conds, "is_stream = 0")
- 			}
- 		}
- 		if filter.Status != "" {
- 			if filter.Status == "200" {
- 				conds = append(conds, "status_code = 200")
- 			} else if filter.Status == "error" {
- 				conds = append(conds, "status_code != 200")
- 			}
- 		}
- 		if filter.StartTime != nil {
- 			conds = append(conds, "created_at >= ?")
- 			args = append(args, filter.StartTime.Format("2006-01-02 15:04:05"))
- 		}
- 		if filter.EndTime != nil {
- 			conds = append(conds, "created_at <= ?")
- 			args = append(args, filter.EndTime.Format("2006-01-02 15:04:05"))
- 		}
- 	}
- 	query := `
+ 		FROM request_logs`
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " GROUP BY bucket_time ORDER BY bucket_time ASC"
+
+	rows, err := d.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tokens timeline: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*TimelinePoint
+	for rows.Next() {
+		var t TimelinePoint
+		if err := rows.Scan(&t.Date, &t.Total, &t.Success); err != nil {
+			return nil, fmt.Errorf("failed to scan timeline row: %w", err)
+		}
+		out = append(out, &t)
+	}
+	return out, nil
+}
+
+// GetGroupTokensStats 基于筛选与时间范围的分组tokens聚合（按 total desc）
+func (d *Database) GetGroupTokensStats(filter *LogFilter) ([]*GroupTokensStat, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if filter != nil {
+		if filter.ProxyKeyName != "" {
+			conds = append(conds, "proxy_key_name = ?")
+			args = append(args, filter.ProxyKeyName)
+		}
+		if filter.ProviderGroup != "" {
+			conds = append(conds, "provider_group = ?")
+			args = append(args, filter.ProviderGroup)
+		}
+		if filter.Model != "" {
+			conds = append(conds, "model = ?")
+			args = append(args, filter.Model)
+		}
+		conds, args = appendProxyKeyIDsCondition(conds, args, filter.ProxyKeyIDs)
+		if filter.Stream != "" {
+			if filter.Stream == "true" {
+				conds = append(conds, "is_stream = TRUE")
+			} else if filter.Stream == "false" {
+				conds = append(conds, "is_stream = FALSE")
+			}
+		}
+		if filter.Status != "" {
+			if filter.Status == "200" {
+				conds = append(conds, "status_code = 200")
+			} else if filter.Status == "error" {
+				conds = append(conds, "status_code != 200")
+			}
+		}
+		if filter.StartTime != nil {
+			conds = append(conds, "created_at >= ?")
+			args = append(args, filter.StartTime.Format("2006-01-02 15:04:05"))
+		}
+		if filter.EndTime != nil {
+			conds = append(conds, "created_at <= ?")
+			args = append(args, filter.EndTime.Format("2006-01-02 15:04:05"))
+		}
+	}
+	query := `
  		SELECT
  			COALESCE(provider_group, '') AS grp,
  			SUM(tokens_used) AS total_tokens,
  			SUM(CASE WHEN status_code = 200 THEN tokens_used ELSE 0 END) AS success_tokens
  		FROM request_logs`
- 	if len(conds) > 0 {
- 		query += " WHERE " + strings.Join(conds, " AND ")
- 	}
- 	query += " GROUP BY grp ORDER BY total_tokens DESC"
- 
- 	rows, err := d.db.Query(query, args...)
- 	if err != nil {
- 		return nil, fmt.Errorf("failed to query group tokens stats: %w", err)
- 	}
- 	defer rows.Close()
- 
- 	var out []*GroupTokensStat
- 	for rows.Next() {
- 		var g GroupTokensStat
- 		if err := rows.Scan(&g.Group, &g.Total, &g.Success); err != nil {
- 			return nil, fmt.Errorf("failed to scan group tokens stat: %w", err)
- 		}
- 		if g.Group == "" {
- 			g.Group = "-"
- 		}
- 		out = append(out, &g)
- 	}
- 	return out, nil
- }
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " GROUP BY grp ORDER BY total_tokens DESC"
+
+	rows, err := d.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group tokens stats: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*GroupTokensStat
+	for rows.Next() {
+		var g GroupTokensStat
+		if err := rows.Scan(&g.Group, &g.Total, &g.Success); err != nil {
+			return nil, fmt.Errorf("failed to scan group tokens stat: %w", err)
+		}
+		if g.Group == "" {
+			g.Group = "-"
+		}
+		out = append(out, &g)
+	}
+	return out, nil
+}
@@ -0,0 +1,738 @@
+package logger
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 支持的日志存储驱动
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+)
+
+// dialect 封装不同数据库之间的SQL差异
+// 查询统一使用 ? 占位符和双引号标识符编写，由 rebind 转换为目标数据库的语法
+type dialect interface {
+	// driverName 返回 database/sql 注册的驱动名
+	driverName() string
+	// rebind 将通用查询转换为目标数据库的占位符和标识符语法
+	rebind(query string) string
+	// schema 返回建表和建索引语句
+	schema() []string
+	// columnExists 检查表中是否存在指定列
+	columnExists(db *sql.DB, table, column string) (bool, error)
+	// returningID 返回插入语句获取自增ID的后缀，为空时使用 LastInsertId
+	returningID() string
+	// forUpdate 返回在事务中锁定查询到的行的后缀，SQLite同一时间只有一个写事务，为空
+	forUpdate() string
+	// dateBucket 返回按天或按小时对时间列分桶的表达式
+	dateBucket(column string, hourly bool) string
+	// databaseSize 返回数据实际占用的字节数和已分配的字节数
+	databaseSize(db *sql.DB) (used, allocated int64, err error)
+}
+
+// newDialect 根据驱动名创建方言
+func newDialect(driver string) (dialect, error) {
+	switch driver {
+	case "", DriverSQLite, "sqlite":
+		return sqliteDialect{}, nil
+	case DriverPostgres, "postgresql", "pgx":
+		return postgresDialect{}, nil
+	case DriverMySQL:
+		return mysqlDialect{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", driver)
+	}
+}
+
+// checkDriverRegistered 检查驱动是否已编译进二进制，PostgreSQL和MySQL驱动需通过构建标签启用
+func checkDriverRegistered(driver string) error {
+	drivers := sql.Drivers()
+	if i := sort.SearchStrings(drivers, driver); i < len(drivers) && drivers[i] == driver {
+		return nil
+	}
+	return fmt.Errorf("database driver %q is not compiled in, rebuild with -tags %s", driver, driver)
+}
+
+// sqliteDialect SQLite方言，默认的单实例存储
+type sqliteDialect struct{}
+
+func (sqliteDialect) driverName() string { return DriverSQLite }
+
+func (sqliteDialect) rebind(query string) string { return query }
+
+func (sqliteDialect) schema() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS proxy_keys (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			description TEXT,
+			"key" TEXT NOT NULL UNIQUE,
+			allowed_groups TEXT, -- JSON数组，存储允许访问的分组ID
+			group_selection_config TEXT, -- JSON对象，存储分组选择配置
+			is_active BOOLEAN NOT NULL DEFAULT 1,
+			usage_count INTEGER NOT NULL DEFAULT 0, -- 使用次数
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME,
+			expires_at DATETIME, -- 过期时间，NULL表示永不过期
+			max_usage_count INTEGER NOT NULL DEFAULT 0, -- 最大使用次数，0表示不限制
+			allowed_models TEXT, -- JSON数组，允许请求的模型（支持通配符）
+			denied_models TEXT, -- JSON数组，禁止请求的模型（支持通配符）
+			allowed_ips TEXT, -- JSON数组，允许的来源地址（IP或CIDR）
+			denied_ips TEXT, -- JSON数组，禁止的来源地址（IP或CIDR）
+			system_prompt TEXT, -- 注入到聊天请求的系统提示词
+			tenant_id TEXT -- 所属租户ID，NULL表示不属于任何租户
+		)`,
+		`CREATE TABLE IF NOT EXISTS admin_tokens (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			token_prefix TEXT NOT NULL DEFAULT '',
+			scopes TEXT NOT NULL DEFAULT '[]', -- JSON数组，存储权限范围
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS admin_users (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL, -- bcrypt哈希
+			role TEXT NOT NULL DEFAULT 'viewer', -- viewer、operator、admin
+			disabled BOOLEAN NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_login_at DATETIME,
+			totp_secret TEXT, -- 两步验证密钥（Base32）
+			totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+			recovery_codes TEXT -- 恢复码SHA-256哈希的JSON数组
+		)`,
+		`CREATE TABLE IF NOT EXISTS admin_sessions (
+			id TEXT PRIMARY KEY, -- 会话令牌的SHA-256哈希
+			username TEXT NOT NULL,
+			user_id TEXT NOT NULL DEFAULT '',
+			role TEXT NOT NULL,
+			auth_method TEXT NOT NULL DEFAULT 'password', -- password、oidc
+			two_factor BOOLEAN NOT NULL DEFAULT FALSE,
+			client_ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			last_seen_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_admin_sessions_username ON admin_sessions(username)`,
+		`CREATE INDEX IF NOT EXISTS idx_admin_sessions_user_id ON admin_sessions(user_id)`,
+		`CREATE TABLE IF NOT EXISTS proxy_key_shares (
+			id TEXT PRIMARY KEY, -- 链接令牌的SHA-256哈希
+			key_id TEXT NOT NULL,
+			ciphertext TEXT NOT NULL,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS alert_rules (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			rule_type TEXT NOT NULL, -- error_rate、latency_p95、error_match
+			provider_group TEXT NOT NULL DEFAULT '', -- 为空表示所有分组
+			threshold REAL NOT NULL DEFAULT 0,
+			pattern TEXT NOT NULL DEFAULT '',
+			window_seconds INTEGER NOT NULL DEFAULT 300,
+			min_requests INTEGER NOT NULL DEFAULT 0,
+			cooldown_seconds INTEGER NOT NULL DEFAULT 0,
+			enabled BOOLEAN NOT NULL DEFAULT 1,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS tenants (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			is_active BOOLEAN NOT NULL DEFAULT 1,
+			monthly_request_limit INTEGER NOT NULL DEFAULT 0, -- 每自然月请求数上限，0表示不限制
+			monthly_token_limit INTEGER NOT NULL DEFAULT 0, -- 每自然月token数上限，0表示不限制
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS budgets (
+			id TEXT PRIMARY KEY,
+			scope TEXT NOT NULL, -- tenant、proxy_key、group
+			target TEXT NOT NULL,
+			limit_usd REAL NOT NULL DEFAULT 0, -- 每个计费周期的费用上限（美元），0表示不限制
+			limit_tokens INTEGER NOT NULL DEFAULT 0, -- 每个计费周期的token数上限，0表示不限制
+			enabled BOOLEAN NOT NULL DEFAULT 1,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (scope, target)
+		)`,
+		`CREATE TABLE IF NOT EXISTS usage_reports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			period TEXT NOT NULL, -- daily、weekly
+			period_start DATETIME NOT NULL,
+			period_end DATETIME NOT NULL,
+			requests INTEGER NOT NULL DEFAULT 0,
+			errors INTEGER NOT NULL DEFAULT 0,
+			tokens INTEGER NOT NULL DEFAULT 0,
+			cost REAL NOT NULL DEFAULT 0, -- 按 model_pricing 估算的费用（美元）
+			details TEXT NOT NULL DEFAULT '{}', -- JSON，按分组、代理密钥和模型的明细
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (period, period_start)
+		)`,
+		`CREATE TABLE IF NOT EXISTS request_log_chain (
+			id INTEGER PRIMARY KEY, -- 只有一行，id 固定为1
+			head TEXT NOT NULL DEFAULT '' -- 哈希链末尾的 row_hash
+		)`,
+		`CREATE TABLE IF NOT EXISTS request_log_gaps (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			first_id INTEGER NOT NULL, -- 区段中第一条被删除日志的ID
+			last_id INTEGER NOT NULL,
+			deleted INTEGER NOT NULL, -- 区段中被删除的日志条数
+			start_hash TEXT NOT NULL, -- 区段第一条日志的 prev_hash
+			end_hash TEXT NOT NULL, -- 区段最后一条日志的 row_hash
+			reason TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS audit_logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			actor TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			client_ip TEXT NOT NULL DEFAULT '',
+			"before" TEXT NOT NULL DEFAULT '',
+			"after" TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS request_logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			proxy_key_name TEXT NOT NULL,
+			proxy_key_id TEXT NOT NULL,
+			provider_group TEXT NOT NULL DEFAULT '',
+			openrouter_key TEXT NOT NULL,
+			model TEXT NOT NULL,
+			request_body TEXT NOT NULL,
+			response_body TEXT,
+			status_code INTEGER NOT NULL,
+			is_stream BOOLEAN NOT NULL DEFAULT 0,
+			duration INTEGER NOT NULL DEFAULT 0,
+			tokens_used INTEGER NOT NULL DEFAULT 0,
+			tokens_estimated BOOLEAN NOT NULL DEFAULT 0,
+			error TEXT,
+			client_ip TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (proxy_key_id) REFERENCES proxy_keys(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_proxy_keys_name ON proxy_keys(name)`,
+		`CREATE INDEX IF NOT EXISTS idx_proxy_keys_key ON proxy_keys("key")`,
+		`CREATE INDEX IF NOT EXISTS idx_proxy_keys_is_active ON proxy_keys(is_active)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_proxy_key_id ON request_logs(proxy_key_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_proxy_key_name ON request_logs(proxy_key_name)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_provider_group ON request_logs(provider_group)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_model ON request_logs(model)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_created_at ON request_logs(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_status_code ON request_logs(status_code)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_key_created ON request_logs(proxy_key_name, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_group_created ON request_logs(provider_group, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_model_created ON request_logs(model, created_at)`,
+	}
+}
+
+func (sqliteDialect) columnExists(db *sql.DB, table, column string) (bool, error) {
+	var exists bool
+	err := db.QueryRow(`SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&exists)
+	return exists, err
+}
+
+func (sqliteDialect) returningID() string { return "" }
+
+func (sqliteDialect) forUpdate() string { return "" }
+
+func (sqliteDialect) dateBucket(column string, hourly bool) string {
+	if hourly {
+		return "strftime('%Y-%m-%d %H:00', " + column + ")"
+	}
+	return "strftime('%Y-%m-%d', " + column + ")"
+}
+
+// databaseSize SQLite删除的页面进入空闲列表供复用，文件不会缩小，实际占用不含空闲页
+func (sqliteDialect) databaseSize(db *sql.DB) (int64, int64, error) {
+	var pageCount, freelistCount, pageSize int64
+	if err := db.QueryRow(`SELECT page_count, freelist_count, page_size FROM pragma_page_count(), pragma_freelist_count(), pragma_page_size()`).
+		Scan(&pageCount, &freelistCount, &pageSize); err != nil {
+		return 0, 0, err
+	}
+	return (pageCount - freelistCount) * pageSize, pageCount * pageSize, nil
+}
+
+// postgresDialect PostgreSQL方言，用于多实例共享存储
+type postgresDialect struct{}
+
+func (postgresDialect) driverName() string { return DriverPostgres }
+
+// rebind 将 ? 占位符转换为 $1、$2...，跳过单引号字符串中的问号
+func (postgresDialect) rebind(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	inString := false
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'':
+			inString = !inString
+			b.WriteByte(ch)
+		case ch == '?' && !inString:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+func (postgresDialect) schema() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS proxy_keys (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			description TEXT,
+			"key" TEXT NOT NULL UNIQUE,
+			allowed_groups TEXT,
+			group_selection_config TEXT,
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			usage_count BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_used_at TIMESTAMPTZ,
+			expires_at TIMESTAMPTZ,
+			max_usage_count BIGINT NOT NULL DEFAULT 0,
+			allowed_models TEXT,
+			denied_models TEXT,
+			allowed_ips TEXT,
+			denied_ips TEXT,
+			system_prompt TEXT,
+			tenant_id TEXT
+		)`,
+		`CREATE TABLE IF NOT EXISTS admin_tokens (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			token_prefix TEXT NOT NULL DEFAULT '',
+			scopes TEXT NOT NULL DEFAULT '[]',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_used_at TIMESTAMPTZ
+		)`,
+		`CREATE TABLE IF NOT EXISTS admin_users (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'viewer',
+			disabled BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_login_at TIMESTAMPTZ,
+			totp_secret TEXT,
+			totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+			recovery_codes TEXT
+		)`,
+		`CREATE TABLE IF NOT EXISTS admin_sessions (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL,
+			user_id TEXT NOT NULL DEFAULT '',
+			role TEXT NOT NULL,
+			auth_method TEXT NOT NULL DEFAULT 'password',
+			two_factor BOOLEAN NOT NULL DEFAULT FALSE,
+			client_ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL,
+			last_seen_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_admin_sessions_username ON admin_sessions(username)`,
+		`CREATE INDEX IF NOT EXISTS idx_admin_sessions_user_id ON admin_sessions(user_id)`,
+		`CREATE TABLE IF NOT EXISTS proxy_key_shares (
+			id TEXT PRIMARY KEY,
+			key_id TEXT NOT NULL,
+			ciphertext TEXT NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS alert_rules (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			rule_type TEXT NOT NULL,
+			provider_group TEXT NOT NULL DEFAULT '',
+			threshold DOUBLE PRECISION NOT NULL DEFAULT 0,
+			pattern TEXT NOT NULL DEFAULT '',
+			window_seconds INTEGER NOT NULL DEFAULT 300,
+			min_requests INTEGER NOT NULL DEFAULT 0,
+			cooldown_seconds INTEGER NOT NULL DEFAULT 0,
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS tenants (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			monthly_request_limit BIGINT NOT NULL DEFAULT 0,
+			monthly_token_limit BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS budgets (
+			id TEXT PRIMARY KEY,
+			scope TEXT NOT NULL,
+			target TEXT NOT NULL,
+			limit_usd DOUBLE PRECISION NOT NULL DEFAULT 0,
+			limit_tokens BIGINT NOT NULL DEFAULT 0,
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (scope, target)
+		)`,
+		`CREATE TABLE IF NOT EXISTS usage_reports (
+			id BIGSERIAL PRIMARY KEY,
+			period TEXT NOT NULL,
+			period_start TIMESTAMPTZ NOT NULL,
+			period_end TIMESTAMPTZ NOT NULL,
+			requests BIGINT NOT NULL DEFAULT 0,
+			errors BIGINT NOT NULL DEFAULT 0,
+			tokens BIGINT NOT NULL DEFAULT 0,
+			cost DOUBLE PRECISION NOT NULL DEFAULT 0,
+			details TEXT NOT NULL DEFAULT '{}',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (period, period_start)
+		)`,
+		`CREATE TABLE IF NOT EXISTS request_log_chain (
+			id INTEGER PRIMARY KEY,
+			head TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE TABLE IF NOT EXISTS request_log_gaps (
+			id BIGSERIAL PRIMARY KEY,
+			first_id BIGINT NOT NULL,
+			last_id BIGINT NOT NULL,
+			deleted BIGINT NOT NULL,
+			start_hash TEXT NOT NULL,
+			end_hash TEXT NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS audit_logs (
+			id BIGSERIAL PRIMARY KEY,
+			actor TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			client_ip TEXT NOT NULL DEFAULT '',
+			"before" TEXT NOT NULL DEFAULT '',
+			"after" TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS request_logs (
+			id BIGSERIAL PRIMARY KEY,
+			proxy_key_name TEXT NOT NULL,
+			proxy_key_id TEXT NOT NULL,
+			provider_group TEXT NOT NULL DEFAULT '',
+			openrouter_key TEXT NOT NULL,
+			model TEXT NOT NULL,
+			request_body TEXT NOT NULL,
+			response_body TEXT,
+			status_code INTEGER NOT NULL,
+			is_stream BOOLEAN NOT NULL DEFAULT FALSE,
+			duration BIGINT NOT NULL DEFAULT 0,
+			tokens_used BIGINT NOT NULL DEFAULT 0,
+			tokens_estimated BOOLEAN NOT NULL DEFAULT FALSE,
+			error TEXT,
+			client_ip TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			has_tool_calls BOOLEAN NOT NULL DEFAULT FALSE,
+			tool_calls_count INTEGER NOT NULL DEFAULT 0,
+			tool_names TEXT NOT NULL DEFAULT '',
+			upstream_headers TEXT NOT NULL DEFAULT '',
+			debug_trace TEXT NOT NULL DEFAULT '',
+			correlation_id TEXT NOT NULL DEFAULT '',
+			is_shadow BOOLEAN NOT NULL DEFAULT FALSE,
+			split_name TEXT NOT NULL DEFAULT '',
+			split_arm TEXT NOT NULL DEFAULT '',
+			prev_hash TEXT NOT NULL DEFAULT '',
+			row_hash TEXT NOT NULL DEFAULT '',
+			request_id TEXT NOT NULL DEFAULT '',
+			cost DOUBLE PRECISION NOT NULL DEFAULT 0,
+			error_class TEXT NOT NULL DEFAULT '',
+			ttft_ms BIGINT NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_proxy_keys_name ON proxy_keys(name)`,
+		`CREATE INDEX IF NOT EXISTS idx_proxy_keys_is_active ON proxy_keys(is_active)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_proxy_key_id ON request_logs(proxy_key_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_proxy_key_name ON request_logs(proxy_key_name)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_provider_group ON request_logs(provider_group)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_model ON request_logs(model)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_created_at ON request_logs(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_status_code ON request_logs(status_code)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_key_created ON request_logs(proxy_key_name, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_group_created ON request_logs(provider_group, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_logs_model_created ON request_logs(model, created_at)`,
+	}
+}
+
+func (d postgresDialect) columnExists(db *sql.DB, table, column string) (bool, error) {
+	var exists bool
+	err := db.QueryRow(d.rebind(`
+		SELECT COUNT(*) > 0
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?
+	`), table, column).Scan(&exists)
+	return exists, err
+}
+
+func (postgresDialect) returningID() string { return " RETURNING id" }
+
+func (postgresDialect) forUpdate() string { return " FOR UPDATE" }
+
+func (postgresDialect) dateBucket(column string, hourly bool) string {
+	if hourly {
+		return "to_char(" + column + ", 'YYYY-MM-DD HH24:00')"
+	}
+	return "to_char(" + column + ", 'YYYY-MM-DD')"
+}
+
+func (postgresDialect) databaseSize(db *sql.DB) (int64, int64, error) {
+	var size int64
+	err := db.QueryRow(`SELECT pg_database_size(current_database())`).Scan(&size)
+	return size, size, err
+}
+
+// mysqlDialect MySQL方言，用于多实例共享存储，DSN需包含 parseTime=true
+type mysqlDialect struct{}
+
+func (mysqlDialect) driverName() string { return DriverMySQL }
+
+// rebind 将双引号标识符转换为反引号，查询中的字符串字面量只使用单引号
+func (mysqlDialect) rebind(query string) string {
+	return strings.ReplaceAll(query, `"`, "`")
+}
+
+func (mysqlDialect) schema() []string {
+	return []string{
+		"CREATE TABLE IF NOT EXISTS proxy_keys (" +
+			"id VARCHAR(64) PRIMARY KEY," +
+			"name VARCHAR(255) NOT NULL," +
+			"description TEXT," +
+			"`key` VARCHAR(255) NOT NULL UNIQUE," +
+			"allowed_groups TEXT," +
+			"group_selection_config TEXT," +
+			"is_active BOOLEAN NOT NULL DEFAULT TRUE," +
+			"usage_count BIGINT NOT NULL DEFAULT 0," +
+			"created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)," +
+			"updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)," +
+			"last_used_at DATETIME(6) NULL," +
+			"expires_at DATETIME(6) NULL," +
+			"max_usage_count BIGINT NOT NULL DEFAULT 0," +
+			"allowed_models TEXT," +
+			"denied_models TEXT," +
+			"allowed_ips TEXT," +
+			"denied_ips TEXT," +
+			"system_prompt TEXT," +
+			"tenant_id VARCHAR(64) NULL," +
+			"INDEX idx_proxy_keys_name (name)," +
+			"INDEX idx_proxy_keys_is_active (is_active)" +
+			") DEFAULT CHARSET=utf8mb4",
+		"CREATE TABLE IF NOT EXISTS admin_tokens (" +
+			"id VARCHAR(64) PRIMARY KEY," +
+			"name VARCHAR(255) NOT NULL," +
+			"token_hash VARCHAR(128) NOT NULL UNIQUE," +
+			"token_prefix VARCHAR(64) NOT NULL DEFAULT ''," +
+			"scopes VARCHAR(1024) NOT NULL DEFAULT '[]'," +
+			"created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)," +
+			"last_used_at DATETIME(6) NULL" +
+			") DEFAULT CHARSET=utf8mb4",
+		"CREATE TABLE IF NOT EXISTS admin_users (" +
+			"id VARCHAR(64) PRIMARY KEY," +
+			"username VARCHAR(255) NOT NULL UNIQUE," +
+			"password_hash VARCHAR(255) NOT NULL," +
+			"role VARCHAR(32) NOT NULL DEFAULT 'viewer'," +
+			"disabled BOOLEAN NOT NULL DEFAULT FALSE," +
+			"created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)," +
+			"updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)," +
+			"last_login_at DATETIME(6) NULL," +
+			"totp_secret TEXT NULL," +
+			"totp_enabled BOOLEAN NOT NULL DEFAULT FALSE," +
+			"recovery_codes TEXT NULL" +
+			") DEFAULT CHARSET=utf8mb4",
+		"CREATE TABLE IF NOT EXISTS admin_sessions (" +
+			"id VARCHAR(64) PRIMARY KEY," +
+			"username VARCHAR(255) NOT NULL," +
+			"user_id VARCHAR(64) NOT NULL DEFAULT ''," +
+			"role VARCHAR(32) NOT NULL," +
+			"auth_method VARCHAR(32) NOT NULL DEFAULT 'password'," +
+			"two_factor BOOLEAN NOT NULL DEFAULT FALSE," +
+			"client_ip VARCHAR(64) NOT NULL DEFAULT ''," +
+			"user_agent VARCHAR(512) NOT NULL DEFAULT ''," +
+			"created_at DATETIME(6) NOT NULL," +
+			"last_seen_at DATETIME(6) NOT NULL," +
+			"expires_at DATETIME(6) NOT NULL," +
+			"INDEX idx_admin_sessions_username (username)," +
+			"INDEX idx_admin_sessions_user_id (user_id)" +
+			") DEFAULT CHARSET=utf8mb4",
+		"CREATE TABLE IF NOT EXISTS proxy_key_shares (" +
+			"id VARCHAR(64) PRIMARY KEY," +
+			"key_id VARCHAR(64) NOT NULL," +
+			"ciphertext TEXT NOT NULL," +
+			"expires_at DATETIME(6) NOT NULL," +
+			"created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)" +
+			") DEFAULT CHARSET=utf8mb4",
+		"CREATE TABLE IF NOT EXISTS alert_rules (" +
+			"id VARCHAR(64) PRIMARY KEY," +
+			"name VARCHAR(255) NOT NULL," +
+			"rule_type VARCHAR(32) NOT NULL," +
+			"provider_group VARCHAR(255) NOT NULL DEFAULT ''," +
+			"threshold DOUBLE NOT NULL DEFAULT 0," +
+			"pattern VARCHAR(1024) NOT NULL DEFAULT ''," +
+			"window_seconds INT NOT NULL DEFAULT 300," +
+			"min_requests INT NOT NULL DEFAULT 0," +
+			"cooldown_seconds INT NOT NULL DEFAULT 0," +
+			"enabled BOOLEAN NOT NULL DEFAULT TRUE," +
+			"created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)," +
+			"updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)" +
+			") DEFAULT CHARSET=utf8mb4",
+		"CREATE TABLE IF NOT EXISTS tenants (" +
+			"id VARCHAR(64) PRIMARY KEY," +
+			"name VARCHAR(255) NOT NULL UNIQUE," +
+			"description VARCHAR(1024) NOT NULL DEFAULT ''," +
+			"is_active BOOLEAN NOT NULL DEFAULT TRUE," +
+			"monthly_request_limit BIGINT NOT NULL DEFAULT 0," +
+			"monthly_token_limit BIGINT NOT NULL DEFAULT 0," +
+			"created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)," +
+			"updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)" +
+			") DEFAULT CHARSET=utf8mb4",
+		"CREATE TABLE IF NOT EXISTS budgets (" +
+			"id VARCHAR(64) PRIMARY KEY," +
+			"scope VARCHAR(16) NOT NULL," +
+			"target VARCHAR(255) NOT NULL," +
+			"limit_usd DOUBLE NOT NULL DEFAULT 0," +
+			"limit_tokens BIGINT NOT NULL DEFAULT 0," +
+			"enabled BOOLEAN NOT NULL DEFAULT TRUE," +
+			"created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)," +
+			"updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)," +
+			"UNIQUE KEY uk_budgets_scope_target (scope, target)" +
+			") DEFAULT CHARSET=utf8mb4",
+		"CREATE TABLE IF NOT EXISTS usage_reports (" +
+			"id BIGINT AUTO_INCREMENT PRIMARY KEY," +
+			"period VARCHAR(16) NOT NULL," +
+			"period_start DATETIME(6) NOT NULL," +
+			"period_end DATETIME(6) NOT NULL," +
+			"requests BIGINT NOT NULL DEFAULT 0," +
+			"errors BIGINT NOT NULL DEFAULT 0," +
+			"tokens BIGINT NOT NULL DEFAULT 0," +
+			"cost DOUBLE NOT NULL DEFAULT 0," +
+			"details LONGTEXT NOT NULL," +
+			"created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)," +
+			"UNIQUE KEY uk_usage_reports_period (period, period_start)" +
+			") DEFAULT CHARSET=utf8mb4",
+		"CREATE TABLE IF NOT EXISTS request_log_chain (" +
+			"id INT PRIMARY KEY," +
+			"head VARCHAR(64) NOT NULL DEFAULT ''" +
+			") DEFAULT CHARSET=utf8mb4",
+		"CREATE TABLE IF NOT EXISTS request_log_gaps (" +
+			"id BIGINT AUTO_INCREMENT PRIMARY KEY," +
+			"first_id BIGINT NOT NULL," +
+			"last_id BIGINT NOT NULL," +
+			"deleted BIGINT NOT NULL," +
+			"start_hash VARCHAR(64) NOT NULL," +
+			"end_hash VARCHAR(64) NOT NULL," +
+			"reason VARCHAR(64) NOT NULL DEFAULT ''," +
+			"created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)" +
+			") DEFAULT CHARSET=utf8mb4",
+		"CREATE TABLE IF NOT EXISTS audit_logs (" +
+			"id BIGINT AUTO_INCREMENT PRIMARY KEY," +
+			"actor VARCHAR(255) NOT NULL DEFAULT ''," +
+			"action VARCHAR(128) NOT NULL," +
+			"target VARCHAR(255) NOT NULL DEFAULT ''," +
+			"client_ip VARCHAR(64) NOT NULL DEFAULT ''," +
+			"`before` MEDIUMTEXT," +
+			"`after` MEDIUMTEXT," +
+			"created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)" +
+			") DEFAULT CHARSET=utf8mb4",
+		"CREATE TABLE IF NOT EXISTS request_logs (" +
+			"id BIGINT AUTO_INCREMENT PRIMARY KEY," +
+			"proxy_key_name VARCHAR(255) NOT NULL," +
+			"proxy_key_id VARCHAR(64) NOT NULL," +
+			"provider_group VARCHAR(255) NOT NULL DEFAULT ''," +
+			"openrouter_key VARCHAR(255) NOT NULL," +
+			"model VARCHAR(255) NOT NULL," +
+			"request_body MEDIUMTEXT NOT NULL," +
+			"response_body MEDIUMTEXT," +
+			"status_code INT NOT NULL," +
+			"is_stream BOOLEAN NOT NULL DEFAULT FALSE," +
+			"duration BIGINT NOT NULL DEFAULT 0," +
+			"tokens_used BIGINT NOT NULL DEFAULT 0," +
+			"tokens_estimated BOOLEAN NOT NULL DEFAULT FALSE," +
+			"error TEXT," +
+			"client_ip VARCHAR(64) NOT NULL DEFAULT ''," +
+			"created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)," +
+			"has_tool_calls BOOLEAN NOT NULL DEFAULT FALSE," +
+			"tool_calls_count INT NOT NULL DEFAULT 0," +
+			"tool_names VARCHAR(1024) NOT NULL DEFAULT ''," +
+			"upstream_headers VARCHAR(2048) NOT NULL DEFAULT ''," +
+			"debug_trace MEDIUMTEXT," +
+			"correlation_id VARCHAR(64) NOT NULL DEFAULT ''," +
+			"is_shadow BOOLEAN NOT NULL DEFAULT FALSE," +
+			"split_name VARCHAR(128) NOT NULL DEFAULT ''," +
+			"split_arm VARCHAR(128) NOT NULL DEFAULT ''," +
+			"prev_hash VARCHAR(64) NOT NULL DEFAULT ''," +
+			"row_hash VARCHAR(64) NOT NULL DEFAULT ''," +
+			"request_id VARCHAR(128) NOT NULL DEFAULT ''," +
+			"cost DOUBLE NOT NULL DEFAULT 0," +
+			"error_class VARCHAR(32) NOT NULL DEFAULT ''," +
+			"ttft_ms BIGINT NOT NULL DEFAULT 0," +
+			"INDEX idx_request_logs_proxy_key_id (proxy_key_id)," +
+			"INDEX idx_request_logs_proxy_key_name (proxy_key_name)," +
+			"INDEX idx_request_logs_provider_group (provider_group)," +
+			"INDEX idx_request_logs_model (model)," +
+			"INDEX idx_request_logs_created_at (created_at)," +
+			"INDEX idx_request_logs_status_code (status_code)," +
+			"INDEX idx_request_logs_correlation_id (correlation_id)," +
+			"INDEX idx_request_logs_split_name (split_name)," +
+			"INDEX idx_request_logs_request_id (request_id)," +
+			"INDEX idx_request_logs_key_created (proxy_key_name, created_at)," +
+			"INDEX idx_request_logs_group_created (provider_group, created_at)," +
+			"INDEX idx_request_logs_model_created (model, created_at)" +
+			") DEFAULT CHARSET=utf8mb4",
+	}
+}
+
+func (mysqlDialect) columnExists(db *sql.DB, table, column string) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?
+	`, table, column).Scan(&count)
+	return count > 0, err
+}
+
+func (mysqlDialect) returningID() string { return "" }
+
+func (mysqlDialect) forUpdate() string { return " FOR UPDATE" }
+
+func (mysqlDialect) dateBucket(column string, hourly bool) string {
+	if hourly {
+		return "DATE_FORMAT(" + column + ", '%Y-%m-%d %H:00')"
+	}
+	return "DATE_FORMAT(" + column + ", '%Y-%m-%d')"
+}
+
+func (mysqlDialect) databaseSize(db *sql.DB) (int64, int64, error) {
+	var used, free int64
+	err := db.QueryRow(`
+		SELECT COALESCE(SUM(data_length + index_length), 0), COALESCE(SUM(data_free), 0)
+		FROM information_schema.tables
+		WHERE table_schema = DATABASE()
+	`).Scan(&used, &free)
+	return used, used + free, err
+}
@@ -0,0 +1,6 @@
+//go:build mysql
+
+package logger
+
+// MySQL驱动，使用 go build -tags mysql 编译
+import _ "github.com/go-sql-driver/mysql"
@@ -0,0 +1,6 @@
+//go:build postgres
+
+package logger
+
+// PostgreSQL驱动，使用 go build -tags postgres 编译
+import _ "github.com/lib/pq"
@@ -0,0 +1,227 @@
+package logger
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// FineTuneExportOptions 微调数据导出选项
+type FineTuneExportOptions struct {
+	StripSystem bool `json:"strip_system"` // 去除 system 和 developer 消息
+	Dedupe      bool `json:"dedupe"`       // 去除内容完全相同的样本
+	Limit       int  `json:"limit"`        // 最多导出的样本数，0为不限制
+}
+
+// FineTuneExample OpenAI对话微调格式的一条样本
+type FineTuneExample struct {
+	Messages []map[string]interface{} `json:"messages"`
+	Tools    []interface{}            `json:"tools,omitempty"`
+}
+
+// FineTuneExportResult 微调数据导出统计
+type FineTuneExportResult struct {
+	Examples   []FineTuneExample `json:"-"`
+	Scanned    int               `json:"scanned"`
+	Exported   int               `json:"exported"`
+	Duplicates int               `json:"duplicates"`
+	Skipped    int               `json:"skipped"` // 失败、无法解析或流式响应不完整的日志
+}
+
+// fineTuneMessageFields 微调格式中保留的消息字段
+var fineTuneMessageFields = []string{"role", "content", "name", "tool_calls", "tool_call_id"}
+
+// BuildFineTuneExamples 将成功的请求日志转换为OpenAI对话微调格式：请求中的消息加上模型的回复
+func BuildFineTuneExamples(logs []*RequestLog, opts FineTuneExportOptions) *FineTuneExportResult {
+	result := &FineTuneExportResult{}
+	seen := make(map[[32]byte]bool)
+
+	for _, l := range logs {
+		if opts.Limit > 0 && result.Exported >= opts.Limit {
+			break
+		}
+		result.Scanned++
+
+		example, ok := fineTuneExampleFromLog(l, opts.StripSystem)
+		if !ok {
+			result.Skipped++
+			continue
+		}
+		if opts.Dedupe {
+			data, err := json.Marshal(example)
+			if err != nil {
+				result.Skipped++
+				continue
+			}
+			sum := sha256.Sum256(data)
+			if seen[sum] {
+				result.Duplicates++
+				continue
+			}
+			seen[sum] = true
+		}
+
+		result.Examples = append(result.Examples, *example)
+		result.Exported++
+	}
+	return result
+}
+
+// WriteFineTuneJSONL 以每行一条样本的JSONL格式输出
+func WriteFineTuneJSONL(w io.Writer, examples []FineTuneExample) error {
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	for i := range examples {
+		if err := encoder.Encode(&examples[i]); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// fineTuneExampleFromLog 从一条日志构建微调样本，日志不成功或无法解析出回复时返回false
+func fineTuneExampleFromLog(l *RequestLog, stripSystem bool) (*FineTuneExample, bool) {
+	if l.StatusCode != 200 || l.IsShadow {
+		return nil, false
+	}
+
+	var request struct {
+		Messages []map[string]interface{} `json:"messages"`
+		Tools    []interface{}            `json:"tools"`
+	}
+	if err := json.Unmarshal([]byte(l.RequestBody), &request); err != nil || len(request.Messages) == 0 {
+		return nil, false
+	}
+
+	var reply map[string]interface{}
+	if l.IsStream {
+		reply = fineTuneReplyFromStream(l.ResponseBody)
+	} else {
+		reply = fineTuneReplyFromResponse(l.ResponseBody)
+	}
+	if reply == nil {
+		return nil, false
+	}
+
+	example := &FineTuneExample{Tools: request.Tools}
+	for _, message := range request.Messages {
+		role, _ := message["role"].(string)
+		if stripSystem && (role == "system" || role == "developer") {
+			continue
+		}
+		example.Messages = append(example.Messages, filterFineTuneMessage(message))
+	}
+	if len(example.Messages) == 0 {
+		return nil, false
+	}
+	example.Messages = append(example.Messages, reply)
+	return example, true
+}
+
+// filterFineTuneMessage 只保留微调格式支持的消息字段
+func filterFineTuneMessage(message map[string]interface{}) map[string]interface{} {
+	filtered := make(map[string]interface{}, len(fineTuneMessageFields))
+	for _, field := range fineTuneMessageFields {
+		if value, exists := message[field]; exists && value != nil {
+			filtered[field] = value
+		}
+	}
+	return filtered
+}
+
+// fineTuneReplyFromResponse 从非流式响应中提取助手回复，支持OpenAI、Anthropic原生和Gemini原生格式
+func fineTuneReplyFromResponse(body string) map[string]interface{} {
+	var response map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		return nil
+	}
+
+	// OpenAI格式
+	if choices, ok := response["choices"].([]interface{}); ok && len(choices) > 0 {
+		choice, _ := choices[0].(map[string]interface{})
+		message, _ := choice["message"].(map[string]interface{})
+		if message == nil {
+			return nil
+		}
+		reply := filterFineTuneMessage(message)
+		reply["role"] = "assistant"
+		return assistantReply(reply)
+	}
+
+	// Anthropic原生格式
+	if blocks, ok := response["content"].([]interface{}); ok {
+		var text strings.Builder
+		var toolCalls []interface{}
+		for _, raw := range blocks {
+			block, _ := raw.(map[string]interface{})
+			switch block["type"] {
+			case "text":
+				s, _ := block["text"].(string)
+				text.WriteString(s)
+			case "tool_use":
+				arguments, _ := json.Marshal(block["input"])
+				toolCalls = append(toolCalls, map[string]interface{}{
+					"id":       block["id"],
+					"type":     "function",
+					"function": map[string]interface{}{"name": block["name"], "arguments": string(arguments)},
+				})
+			}
+		}
+		reply := map[string]interface{}{"role": "assistant", "content": text.String()}
+		if len(toolCalls) > 0 {
+			reply["tool_calls"] = toolCalls
+		}
+		return assistantReply(reply)
+	}
+
+	// Gemini原生格式
+	if candidates, ok := response["candidates"].([]interface{}); ok && len(candidates) > 0 {
+		candidate, _ := candidates[0].(map[string]interface{})
+		content, _ := candidate["content"].(map[string]interface{})
+		parts, _ := content["parts"].([]interface{})
+		var text strings.Builder
+		for _, raw := range parts {
+			part, _ := raw.(map[string]interface{})
+			s, _ := part["text"].(string)
+			text.WriteString(s)
+		}
+		return assistantReply(map[string]interface{}{"role": "assistant", "content": text.String()})
+	}
+	return nil
+}
+
+// fineTuneReplyFromStream 由流式响应日志重组助手回复，日志不完整或流中出现错误时返回nil
+func fineTuneReplyFromStream(body string) map[string]interface{} {
+	transcript := ReconstructStream(body)
+	if transcript.Truncated || transcript.Error != "" || transcript.Chunks == 0 {
+		return nil
+	}
+
+	reply := map[string]interface{}{"role": "assistant", "content": transcript.Content}
+	if len(transcript.ToolCalls) > 0 {
+		toolCalls := make([]interface{}, 0, len(transcript.ToolCalls))
+		for _, call := range transcript.ToolCalls {
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":       call.ID,
+				"type":     "function",
+				"function": map[string]interface{}{"name": call.Name, "arguments": call.Arguments},
+			})
+		}
+		reply["tool_calls"] = toolCalls
+	}
+	return assistantReply(reply)
+}
+
+// assistantReply 检查回复是否有内容或工具调用，没有时返回nil
+func assistantReply(reply map[string]interface{}) map[string]interface{} {
+	if content, _ := reply["content"].(string); content != "" {
+		return reply
+	}
+	if calls, ok := reply["tool_calls"].([]interface{}); ok && len(calls) > 0 {
+		return reply
+	}
+	return nil
+}
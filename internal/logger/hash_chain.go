@@ -0,0 +1,316 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hashChainBatchSize 校验哈希链时每批读取的日志条数
+const hashChainBatchSize = 500
+
+// HashChainBreak 哈希链中第一处不一致
+type HashChainBreak struct {
+	ID       int64  `json:"id"`
+	Reason   string `json:"reason"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// HashChainReport 哈希链校验结果
+type HashChainReport struct {
+	Checked  int64           `json:"checked"`  // 校验过的日志条数
+	Unhashed int64           `json:"unhashed"` // 链开始之前没有哈希的历史日志条数
+	FirstID  int64           `json:"first_id"` // 链中第一条日志的ID，之前的日志已被清理时以其 prev_hash 为起点
+	Gaps     int64           `json:"gaps"`     // 按 request_log_gaps 中的记录跳过的已删除区段数
+	Head     string          `json:"head"`     // 链中最后一条日志的哈希
+	Break    *HashChainBreak `json:"break,omitempty"`
+}
+
+// OK 哈希链是否完整
+func (r *HashChainReport) OK() bool {
+	return r.Break == nil
+}
+
+// computeRowHash 计算日志行的哈希：上一行的哈希加上写入后不会变化的字段
+// token用量和工具调用等派生字段可能被回填更新，不参与计算；创建时间按秒计算以兼容各数据库的时间精度
+func computeRowHash(prevHash string, l *RequestLog) string {
+	h := sha256.New()
+	for _, field := range []string{
+		prevHash,
+		l.ProxyKeyName, l.ProxyKeyID, l.ProviderGroup, l.OpenRouterKey, l.Model,
+		l.RequestBody, l.ResponseBody,
+		strconv.Itoa(l.StatusCode), strconv.FormatBool(l.IsStream), strconv.FormatInt(l.Duration, 10),
+		l.Error, l.ClientIP, strconv.FormatInt(l.CreatedAt.Unix(), 10),
+		l.UpstreamHeaders, l.DebugTrace, l.CorrelationID, strconv.FormatBool(l.IsShadow), l.SplitName, l.SplitArm,
+	} {
+		writeHashField(h, field)
+	}
+	// 请求ID在哈希链之后加入，为空时不参与计算，已有日志的哈希保持不变
+	if l.RequestID != "" {
+		writeHashField(h, l.RequestID)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeHashField 以长度前缀写入字段，避免相邻字段拼接产生歧义
+func writeHashField(h hash.Hash, field string) {
+	h.Write([]byte(strconv.Itoa(len(field))))
+	h.Write([]byte{':'})
+	h.Write([]byte(field))
+}
+
+// initChainHead 创建保存哈希链末尾的记录，已有日志时以最后一条日志的哈希为起点，多个实例同时创建时以先写入的为准
+func (d *Database) initChainHead() error {
+	var count int
+	if err := d.queryRow(`SELECT COUNT(*) FROM request_log_chain WHERE id = 1`).Scan(&count); err != nil {
+		return fmt.Errorf("failed to query hash chain head: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	var head string
+	err := d.queryRow(`SELECT row_hash FROM request_logs ORDER BY id DESC LIMIT 1`).Scan(&head)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to load hash chain head: %w", err)
+	}
+	if _, err := d.exec(`INSERT INTO request_log_chain (id, head) VALUES (1, ?)`, head); err != nil {
+		if d.queryRow(`SELECT COUNT(*) FROM request_log_chain WHERE id = 1`).Scan(&count) == nil && count > 0 {
+			return nil
+		}
+		return fmt.Errorf("failed to create hash chain head: %w", err)
+	}
+	return nil
+}
+
+// chainLogsTx 在插入事务中锁定并读取链末尾，为待插入的日志依次填充 prev_hash 和 row_hash，再更新链末尾
+// 链末尾保存在数据库中并在事务提交前保持锁定，多个实例写入同一个数据库时依次链接；PostgreSQL和MySQL使用行锁，
+// SQLite先执行一次写入取得写锁，避免先读后写的事务在其他连接写入后失败。同一进程内由 chainMu 串行，调用方需持有 chainMu
+func (d *Database) chainLogsTx(tx *sql.Tx, logs []*RequestLog) error {
+	if d.dialect.forUpdate() == "" {
+		if _, err := tx.Exec(d.dialect.rebind(`UPDATE request_log_chain SET head = head WHERE id = 1`)); err != nil {
+			return fmt.Errorf("failed to lock hash chain head: %w", err)
+		}
+	}
+	var head string
+	if err := tx.QueryRow(d.dialect.rebind(`SELECT head FROM request_log_chain WHERE id = 1` + d.dialect.forUpdate())).Scan(&head); err != nil {
+		return fmt.Errorf("failed to lock hash chain head: %w", err)
+	}
+
+	for _, l := range logs {
+		l.PrevHash = head
+		l.RowHash = computeRowHash(head, l)
+		head = l.RowHash
+	}
+
+	if _, err := tx.Exec(d.dialect.rebind(`UPDATE request_log_chain SET head = ? WHERE id = 1`), head); err != nil {
+		return fmt.Errorf("failed to update hash chain head: %w", err)
+	}
+	return nil
+}
+
+// chainGap 被删除的一段连续日志，校验时从 startHash 直接接到 endHash
+type chainGap struct {
+	firstID, lastID int64
+	deleted         int64
+	startHash       string
+	endHash         string
+}
+
+// deleteChainedLogsWhere 按条件删除请求日志，返回删除数量
+// 每批在一个事务中读取待删除日志的哈希，把其中连续的日志合并为区段记录到 request_log_gaps 后再删除，
+// 删除链中间的日志（按代理密钥或分组的保留期、手动删除等）后校验哈希链时经区段接上
+func (d *Database) deleteChainedLogsWhere(reason, where string, args ...interface{}) (int64, error) {
+	var total int64
+	for {
+		deleted, err := d.deleteChainedLogsBatch(reason, where, args)
+		total += deleted
+		if err != nil || deleted < hashChainBatchSize {
+			return total, err
+		}
+	}
+}
+
+// deleteChainedLogsBatch 删除一批符合条件的日志并记录被删除的区段
+func (d *Database) deleteChainedLogsBatch(reason, where string, args []interface{}) (int64, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(d.dialect.rebind(`SELECT id, prev_hash, row_hash FROM request_logs WHERE `+where+` ORDER BY id ASC LIMIT ?`),
+		append(append([]interface{}(nil), args...), hashChainBatchSize)...)
+	if err != nil {
+		return 0, err
+	}
+	var ids []interface{}
+	var gaps []*chainGap
+	var current *chainGap
+	for rows.Next() {
+		var id int64
+		var prevHash, rowHash string
+		if err := rows.Scan(&id, &prevHash, &rowHash); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		// 链开始之前没有哈希的历史日志不需要记录
+		if rowHash == "" {
+			continue
+		}
+		if current != nil && prevHash == current.endHash {
+			current.lastID, current.endHash = id, rowHash
+			current.deleted++
+			continue
+		}
+		current = &chainGap{firstID: id, lastID: id, deleted: 1, startHash: prevHash, endHash: rowHash}
+		gaps = append(gaps, current)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	for _, gap := range gaps {
+		if _, err := tx.Exec(d.dialect.rebind(`INSERT INTO request_log_gaps (first_id, last_id, deleted, start_hash, end_hash, reason, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`), gap.firstID, gap.lastID, gap.deleted, gap.startHash, gap.endHash, reason, time.Now()); err != nil {
+			return 0, fmt.Errorf("failed to record deleted log range: %w", err)
+		}
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	result, err := tx.Exec(d.dialect.rebind(`DELETE FROM request_logs WHERE id IN (`+placeholders+`)`), ids...)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit log deletion: %w", err)
+	}
+	return deleted, nil
+}
+
+// loadChainGaps 读取已删除的区段，按区段起点的哈希索引
+func (d *Database) loadChainGaps() (map[string]string, error) {
+	rows, err := d.query(`SELECT start_hash, end_hash FROM request_log_gaps`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deleted log ranges: %w", err)
+	}
+	defer rows.Close()
+
+	gaps := make(map[string]string)
+	for rows.Next() {
+		var start, end string
+		if err := rows.Scan(&start, &end); err != nil {
+			return nil, fmt.Errorf("failed to scan deleted log range: %w", err)
+		}
+		gaps[start] = end
+	}
+	return gaps, rows.Err()
+}
+
+// VerifyHashChain 按ID顺序遍历请求日志，重新计算每行的哈希并检查与上一行的链接，报告第一处不一致
+// 链开始之前没有哈希的历史日志跳过；删除最早的日志不影响校验，删除链中间的日志时经 request_log_gaps 中记录的区段接上
+func (d *Database) VerifyHashChain() (*HashChainReport, error) {
+	gaps, err := d.loadChainGaps()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &HashChainReport{}
+	var lastID int64
+	var prevHash string
+	started := false
+
+	for {
+		rows, err := d.query(`
+		SELECT id, proxy_key_name, proxy_key_id, provider_group, openrouter_key, model, request_body, COALESCE(response_body, ''),
+			   status_code, is_stream, duration, COALESCE(error, ''), client_ip, created_at,
+			   upstream_headers, COALESCE(debug_trace, ''), correlation_id, is_shadow, split_name, split_arm, prev_hash, row_hash, request_id
+		FROM request_logs
+		WHERE id > ?
+		ORDER BY id ASC LIMIT ?`, lastID, hashChainBatchSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query request logs for hash chain: %w", err)
+		}
+
+		var batch []*RequestLog
+		for rows.Next() {
+			l := &RequestLog{}
+			if err := rows.Scan(&l.ID, &l.ProxyKeyName, &l.ProxyKeyID, &l.ProviderGroup, &l.OpenRouterKey, &l.Model,
+				&l.RequestBody, &l.ResponseBody, &l.StatusCode, &l.IsStream, &l.Duration, &l.Error, &l.ClientIP, &l.CreatedAt,
+				&l.UpstreamHeaders, &l.DebugTrace, &l.CorrelationID, &l.IsShadow, &l.SplitName, &l.SplitArm,
+				&l.PrevHash, &l.RowHash, &l.RequestID); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan request log for hash chain: %w", err)
+			}
+			batch = append(batch, l)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request logs for hash chain: %w", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, l := range batch {
+			lastID = l.ID
+			if !started {
+				if l.RowHash == "" {
+					report.Unhashed++
+					continue
+				}
+				started = true
+				report.FirstID = l.ID
+				prevHash = l.PrevHash
+			}
+
+			report.Checked++
+			if l.RowHash == "" {
+				report.Break = &HashChainBreak{ID: l.ID, Reason: "row has no hash"}
+				return report, nil
+			}
+			// 上一行之后的日志被删除时沿记录的区段接到被删除的最后一条日志
+			linked, skipped := prevHash, int64(0)
+			for l.PrevHash != linked && skipped < int64(len(gaps)) {
+				end, ok := gaps[linked]
+				if !ok {
+					break
+				}
+				linked = end
+				skipped++
+			}
+			if l.PrevHash != linked {
+				report.Break = &HashChainBreak{ID: l.ID, Reason: "prev_hash does not match the previous row (rows deleted, inserted or reordered)",
+					Expected: prevHash, Actual: l.PrevHash}
+				return report, nil
+			}
+			if expected := computeRowHash(l.PrevHash, l); expected != l.RowHash {
+				report.Break = &HashChainBreak{ID: l.ID, Reason: "row_hash does not match the row content (row altered)",
+					Expected: expected, Actual: l.RowHash}
+				return report, nil
+			}
+			prevHash = l.RowHash
+			report.Gaps += skipped
+			report.Head = l.RowHash
+		}
+	}
+	return report, nil
+}
+
+// VerifyHashChain 校验请求日志的哈希链
+func (r *RequestLogger) VerifyHashChain() (*HashChainReport, error) {
+	return r.db.VerifyHashChain()
+}
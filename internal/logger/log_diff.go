@@ -0,0 +1,243 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// 差异类型
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// maxLineDiffCells 逐行比较响应内容的最大计算量（两侧行数乘积），超出时整体替换
+const maxLineDiffCells = 4000000
+
+// LogDiff 两条请求日志之间的结构化差异
+type LogDiff struct {
+	A        int64        `json:"a"`
+	B        int64        `json:"b"`
+	Fields   []FieldDiff  `json:"fields"`  // 日志元数据差异：模型、分组、状态码等
+	Request  []FieldDiff  `json:"request"` // 请求参数差异，按JSON路径列出
+	Response ResponseDiff `json:"response"`
+}
+
+// FieldDiff 单个字段的差异
+type FieldDiff struct {
+	Path   string      `json:"path"`
+	Change string      `json:"change"` // added、removed 或 changed
+	A      interface{} `json:"a,omitempty"`
+	B      interface{} `json:"b,omitempty"`
+}
+
+// ResponseDiff 响应内容的差异
+type ResponseDiff struct {
+	Identical   bool              `json:"identical"`
+	A           *StreamTranscript `json:"a"`
+	B           *StreamTranscript `json:"b"`
+	Fields      []FieldDiff       `json:"fields"`       // 模型、结束原因、推理过程和工具调用的差异
+	ContentDiff []LineDiff        `json:"content_diff"` // 回复内容的逐行差异
+}
+
+// LineDiff 逐行差异中的一行
+type LineDiff struct {
+	Op   string `json:"op"` // equal、insert 或 delete
+	Text string `json:"text"`
+}
+
+// DiffRequestLogs 比较两条请求日志的元数据、请求参数和响应内容
+func DiffRequestLogs(a, b *RequestLog) *LogDiff {
+	diff := &LogDiff{
+		A: a.ID,
+		B: b.ID,
+		Fields: diffValues("", map[string]interface{}{
+			"model":          a.Model,
+			"provider_group": a.ProviderGroup,
+			"status_code":    a.StatusCode,
+			"is_stream":      a.IsStream,
+			"duration":       a.Duration,
+			"tokens_used":    a.TokensUsed,
+			"error":          a.Error,
+		}, map[string]interface{}{
+			"model":          b.Model,
+			"provider_group": b.ProviderGroup,
+			"status_code":    b.StatusCode,
+			"is_stream":      b.IsStream,
+			"duration":       b.Duration,
+			"tokens_used":    b.TokensUsed,
+			"error":          b.Error,
+		}),
+		Request: diffValues("", parseJSONOrRaw(a.RequestBody), parseJSONOrRaw(b.RequestBody)),
+	}
+
+	respA := ReconstructResponse(a.ResponseBody, a.IsStream)
+	respB := ReconstructResponse(b.ResponseBody, b.IsStream)
+	diff.Response = ResponseDiff{
+		A:           respA,
+		B:           respB,
+		Fields:      diffValues("", transcriptFields(respA), transcriptFields(respB)),
+		ContentDiff: diffLines(respA.Content, respB.Content),
+	}
+	diff.Response.Identical = len(diff.Response.Fields) == 0 && respA.Content == respB.Content
+	return diff
+}
+
+// transcriptFields 提取需要比较的响应字段
+func transcriptFields(t *StreamTranscript) interface{} {
+	data, _ := json.Marshal(map[string]interface{}{
+		"model":         t.Model,
+		"finish_reason": t.FinishReason,
+		"reasoning":     t.Reasoning,
+		"tool_calls":    t.ToolCalls,
+	})
+	var fields interface{}
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// parseJSONOrRaw 解析JSON，失败时返回原始字符串
+func parseJSONOrRaw(body string) interface{} {
+	var value interface{}
+	if err := json.Unmarshal([]byte(body), &value); err != nil {
+		return body
+	}
+	return value
+}
+
+// diffValues 递归比较两个JSON值，对象按键、数组按下标展开，只列出不同的叶子节点
+func diffValues(path string, a, b interface{}) []FieldDiff {
+	diffs := make([]FieldDiff, 0)
+
+	mapA, okA := a.(map[string]interface{})
+	mapB, okB := b.(map[string]interface{})
+	if okA && okB {
+		keys := make([]string, 0, len(mapA)+len(mapB))
+		for key := range mapA {
+			keys = append(keys, key)
+		}
+		for key := range mapB {
+			if _, exists := mapA[key]; !exists {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			valueA, inA := mapA[key]
+			valueB, inB := mapB[key]
+			switch {
+			case !inA:
+				diffs = append(diffs, FieldDiff{Path: childPath, Change: DiffAdded, B: valueB})
+			case !inB:
+				diffs = append(diffs, FieldDiff{Path: childPath, Change: DiffRemoved, A: valueA})
+			default:
+				diffs = append(diffs, diffValues(childPath, valueA, valueB)...)
+			}
+		}
+		return diffs
+	}
+
+	listA, okA := a.([]interface{})
+	listB, okB := b.([]interface{})
+	if okA && okB {
+		for i := 0; i < len(listA) || i < len(listB); i++ {
+			childPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(listA):
+				diffs = append(diffs, FieldDiff{Path: childPath, Change: DiffAdded, B: listB[i]})
+			case i >= len(listB):
+				diffs = append(diffs, FieldDiff{Path: childPath, Change: DiffRemoved, A: listA[i]})
+			default:
+				diffs = append(diffs, diffValues(childPath, listA[i], listB[i])...)
+			}
+		}
+		return diffs
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		diffs = append(diffs, FieldDiff{Path: path, Change: DiffChanged, A: a, B: b})
+	}
+	return diffs
+}
+
+// diffLines 按最长公共子序列逐行比较两段文本
+func diffLines(a, b string) []LineDiff {
+	if a == b {
+		if a == "" {
+			return []LineDiff{}
+		}
+		return []LineDiff{{Op: "equal", Text: a}}
+	}
+
+	linesA := splitLines(a)
+	linesB := splitLines(b)
+	n, m := len(linesA), len(linesB)
+	if n*m > maxLineDiffCells {
+		diffs := make([]LineDiff, 0, n+m)
+		for _, line := range linesA {
+			diffs = append(diffs, LineDiff{Op: "delete", Text: line})
+		}
+		for _, line := range linesB {
+			diffs = append(diffs, LineDiff{Op: "insert", Text: line})
+		}
+		return diffs
+	}
+
+	// lcs[i][j] 为 linesA[i:] 与 linesB[j:] 的最长公共子序列长度
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if linesA[i] == linesB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diffs := make([]LineDiff, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case linesA[i] == linesB[j]:
+			diffs = append(diffs, LineDiff{Op: "equal", Text: linesA[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diffs = append(diffs, LineDiff{Op: "delete", Text: linesA[i]})
+			i++
+		default:
+			diffs = append(diffs, LineDiff{Op: "insert", Text: linesB[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		diffs = append(diffs, LineDiff{Op: "delete", Text: linesA[i]})
+	}
+	for ; j < m; j++ {
+		diffs = append(diffs, LineDiff{Op: "insert", Text: linesB[j]})
+	}
+	return diffs
+}
+
+// splitLines 将文本按行拆分，空文本返回空列表
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
@@ -5,31 +5,102 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
+	"turnsapi/internal/providers"
+
 	"github.com/gin-gonic/gin"
 	"github.com/pkoukk/tiktoken-go"
 )
 
+const (
+	// statusClientClosedRequest 客户端在响应完成前断开时记录的状态码（与Nginx的499相同）
+	statusClientClosedRequest = 499
+	// errorClassClientClosed 客户端断开的请求的错误分类
+	errorClassClientClosed = "client_closed"
+)
+
 // RequestLogger 请求日志记录器
 type RequestLogger struct {
-	db *Database
+	db       *Database
+	writer   *asyncWriter                           // 异步写入器，为空时同步写入
+	observer func(entry RequestLog)                 // 日志观察者，收到每条日志的副本，不含派生字段
+	redactor *Redactor                              // 写入前掩码密钥和敏感信息
+	labeler  func(key string) string                // 查找上游密钥的标签，返回非空时日志中记录标签而不是掩码后的密钥
+	costFunc func(model string, tokens int) float64 // 按模型价格估算费用，为空时不计算费用
+
+	statsMu sync.Mutex
+	stats   *StorageStats // 缓存的存储用量
+
+	backfillMu sync.Mutex
+	backfill   *BackfillStatus // 最近一次后台回填任务的状态
 }
 
-// NewRequestLogger 创建新的请求日志记录器
+// NewRequestLogger 创建新的请求日志记录器，使用SQLite存储
 func NewRequestLogger(dbPath string) (*RequestLogger, error) {
-	db, err := NewDatabase(dbPath)
+	return NewRequestLoggerWithDriver(DriverSQLite, dbPath)
+}
+
+// NewRequestLoggerWithDriver 使用指定的存储驱动创建请求日志记录器
+func NewRequestLoggerWithDriver(driver, dsn string) (*RequestLogger, error) {
+	db, err := NewDatabaseWithDriver(driver, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create database: %w", err)
 	}
 
 	return &RequestLogger{
-		db: db,
+		db:       db,
+		redactor: &Redactor{rules: defaultRedactionRules},
 	}, nil
 }
 
-// Close 关闭日志记录器
+// StartAsyncWriter 启用异步批量写入，需在开始记录日志前调用
+func (r *RequestLogger) StartAsyncWriter(opts AsyncWriterOptions) {
+	if r.writer != nil {
+		return
+	}
+	r.writer = newAsyncWriter(r.db, r.computeDerivedFields, opts)
+}
+
+// SetRedactor 设置请求日志脱敏器，需在开始记录日志前调用
+func (r *RequestLogger) SetRedactor(redactor *Redactor) {
+	r.redactor = redactor
+}
+
+// SetObserver 设置请求日志观察者，需在开始记录日志前调用
+func (r *RequestLogger) SetObserver(observer func(entry RequestLog)) {
+	r.observer = observer
+}
+
+// SetKeyLabeler 设置上游密钥标签的查找函数，需在开始记录日志前调用
+func (r *RequestLogger) SetKeyLabeler(labeler func(key string) string) {
+	r.labeler = labeler
+}
+
+// SetCostFunc 设置按模型和token用量估算费用的函数，需在开始记录日志前调用
+func (r *RequestLogger) SetCostFunc(costFunc func(model string, tokens int) float64) {
+	r.costFunc = costFunc
+}
+
+// QueueStats 获取异步日志队列统计信息
+func (r *RequestLogger) QueueStats() LogQueueStats {
+	if r.writer == nil {
+		return LogQueueStats{}
+	}
+	return r.writer.stats()
+}
+
+// ResetConnections 重新建立数据库连接，队列中的日志在新连接上继续写入
+func (r *RequestLogger) ResetConnections() error {
+	return r.db.ResetConnections()
+}
+
+// Close 关闭日志记录器，关闭前写入队列中剩余的日志
 func (r *RequestLogger) Close() error {
+	if r.writer != nil {
+		r.writer.close()
+	}
 	return r.db.Close()
 }
 
@@ -38,42 +109,85 @@ func (r *RequestLogger) LogRequest(
 	proxyKeyName, proxyKeyID, providerGroup, openRouterKey, model, requestBody, responseBody, clientIP string,
 	statusCode int, isStream bool, duration time.Duration, err error,
 ) {
-	// 提取token使用量
-	tokensUsed := r.extractTokensUsed(responseBody)
-	tokensEstimated := false
+	r.LogRequestWithUpstreamHeaders(proxyKeyName, proxyKeyID, providerGroup, openRouterKey, model, requestBody, responseBody, clientIP,
+		statusCode, isStream, duration, err, nil)
+}
 
-	// 如果响应中没有token信息且请求成功，尝试基于请求和响应内容估算
-	if tokensUsed == 0 && statusCode == 200 {
-		estimatedTokens := r.estimateTokensFromRequestAndResponseWithModel(requestBody, responseBody, model)
-		if estimatedTokens > 0 {
-			tokensUsed = estimatedTokens
-			tokensEstimated = true
-			log.Printf("Using comprehensive token estimation for model %s: %d tokens (request + response)", model, tokensUsed)
-		}
-	}
+// LogRequestWithUpstreamHeaders 记录请求日志，同时记录上游返回的限流等响应头
+func (r *RequestLogger) LogRequestWithUpstreamHeaders(
+	proxyKeyName, proxyKeyID, providerGroup, openRouterKey, model, requestBody, responseBody, clientIP string,
+	statusCode int, isStream bool, duration time.Duration, err error, upstreamHeaders map[string]string,
+) {
+	r.LogRequestWithDebugTrace(proxyKeyName, proxyKeyID, providerGroup, openRouterKey, model, requestBody, responseBody, clientIP,
+		statusCode, isStream, duration, err, upstreamHeaders, "")
+}
 
-	// 提取工具调用信息
-	hasToolCalls, toolCallsCount, toolNames := r.extractToolCallInfo(requestBody, responseBody)
+// LogRequestWithDebugTrace 记录请求日志，同时记录上游响应头和请求级调试追踪
+func (r *RequestLogger) LogRequestWithDebugTrace(
+	proxyKeyName, proxyKeyID, providerGroup, openRouterKey, model, requestBody, responseBody, clientIP string,
+	statusCode int, isStream bool, duration time.Duration, err error, upstreamHeaders map[string]string, debugTrace string,
+) {
+	r.LogRequestWithCorrelation(proxyKeyName, proxyKeyID, providerGroup, openRouterKey, model, requestBody, responseBody, clientIP,
+		statusCode, isStream, duration, err, upstreamHeaders, debugTrace, "", false)
+}
 
+// LogRequestWithCorrelation 记录请求日志，同时记录影子流量的关联ID，原请求和影子请求使用相同的关联ID
+func (r *RequestLogger) LogRequestWithCorrelation(
+	proxyKeyName, proxyKeyID, providerGroup, openRouterKey, model, requestBody, responseBody, clientIP string,
+	statusCode int, isStream bool, duration time.Duration, err error, upstreamHeaders map[string]string, debugTrace string,
+	correlationID string, isShadow bool,
+) {
+	r.LogRequestWithSplit(proxyKeyName, proxyKeyID, providerGroup, openRouterKey, model, requestBody, responseBody, clientIP,
+		statusCode, isStream, duration, err, upstreamHeaders, debugTrace, correlationID, isShadow, "", "")
+}
+
+// LogRequestWithSplit 记录请求日志，同时记录请求所属的A/B分流名称和分配的分支
+func (r *RequestLogger) LogRequestWithSplit(
+	proxyKeyName, proxyKeyID, providerGroup, openRouterKey, model, requestBody, responseBody, clientIP string,
+	statusCode int, isStream bool, duration time.Duration, err error, upstreamHeaders map[string]string, debugTrace string,
+	correlationID string, isShadow bool, splitName, splitArm string,
+) {
+	r.LogRequestWithRequestID(proxyKeyName, proxyKeyID, providerGroup, openRouterKey, model, requestBody, responseBody, clientIP,
+		statusCode, isStream, duration, err, upstreamHeaders, debugTrace, correlationID, isShadow, splitName, splitArm, "")
+}
+
+// LogRequestWithRequestID 记录请求日志，同时记录返回给客户端的请求ID（X-Request-ID）
+func (r *RequestLogger) LogRequestWithRequestID(
+	proxyKeyName, proxyKeyID, providerGroup, openRouterKey, model, requestBody, responseBody, clientIP string,
+	statusCode int, isStream bool, duration time.Duration, err error, upstreamHeaders map[string]string, debugTrace string,
+	correlationID string, isShadow bool, splitName, splitArm, requestID string,
+) {
+	r.LogRequestWithTTFT(proxyKeyName, proxyKeyID, providerGroup, openRouterKey, model, requestBody, responseBody, clientIP,
+		statusCode, isStream, duration, err, upstreamHeaders, debugTrace, correlationID, isShadow, splitName, splitArm, requestID, 0)
+}
+
+// LogRequestWithTTFT 记录请求日志，同时记录流式请求收到第一个数据块的耗时
+func (r *RequestLogger) LogRequestWithTTFT(
+	proxyKeyName, proxyKeyID, providerGroup, openRouterKey, model, requestBody, responseBody, clientIP string,
+	statusCode int, isStream bool, duration time.Duration, err error, upstreamHeaders map[string]string, debugTrace string,
+	correlationID string, isShadow bool, splitName, splitArm, requestID string, ttft time.Duration,
+) {
 	// 创建日志记录
 	requestLog := &RequestLog{
-		ProxyKeyName:    proxyKeyName,
-		ProxyKeyID:      proxyKeyID,
-		ProviderGroup:   providerGroup,
-		OpenRouterKey:   r.maskAPIKey(openRouterKey),
-		Model:           model,
-		RequestBody:     requestBody,
-		ResponseBody:    responseBody,
-		StatusCode:      statusCode,
-		IsStream:        isStream,
-		Duration:        duration.Milliseconds(),
-		TokensUsed:      tokensUsed,
-		TokensEstimated: tokensEstimated,
-		ClientIP:        clientIP,
-		CreatedAt:       time.Now(),
-		HasToolCalls:    hasToolCalls,
-		ToolCallsCount:  toolCallsCount,
-		ToolNames:       toolNames,
+		ProxyKeyName:  proxyKeyName,
+		ProxyKeyID:    proxyKeyID,
+		ProviderGroup: providerGroup,
+		OpenRouterKey: r.maskAPIKey(openRouterKey),
+		Model:         model,
+		RequestBody:   requestBody,
+		ResponseBody:  responseBody,
+		StatusCode:    statusCode,
+		IsStream:      isStream,
+		Duration:      duration.Milliseconds(),
+		ClientIP:      clientIP,
+		DebugTrace:    debugTrace,
+		CorrelationID: correlationID,
+		IsShadow:      isShadow,
+		SplitName:     splitName,
+		SplitArm:      splitArm,
+		RequestID:     requestID,
+		TTFTMs:        ttft.Milliseconds(),
+		CreatedAt:     time.Now(),
 	}
 
 	// 如果有错误，记录错误信息
@@ -81,10 +195,103 @@ func (r *RequestLogger) LogRequest(
 		requestLog.Error = err.Error()
 	}
 
+	if len(upstreamHeaders) > 0 {
+		if data, marshalErr := json.Marshal(upstreamHeaders); marshalErr == nil {
+			requestLog.UpstreamHeaders = string(data)
+		}
+	}
+
+	// 写入数据库前掩码请求中的密钥和敏感信息
+	r.redactor.redactLog(requestLog, openRouterKey)
+
+	if r.observer != nil {
+		r.observer(*requestLog)
+	}
+
+	// 启用异步写入时由后台任务计算派生字段并批量写入
+	if r.writer != nil && r.writer.enqueue(requestLog) {
+		return
+	}
+
+	// 计算token使用量和工具调用信息等派生字段
+	r.computeDerivedFields(requestLog)
+
 	// 插入数据库
 	if insertErr := r.db.InsertRequestLog(requestLog); insertErr != nil {
-		log.Printf("Failed to insert request log: %v", insertErr)
+		log.Printf("Failed to insert request log (request_id=%s): %v", requestLog.RequestID, insertErr)
+	}
+}
+
+// computeDerivedFields 根据请求和响应内容计算派生字段，记录日志和回填历史数据共用
+func (r *RequestLogger) computeDerivedFields(requestLog *RequestLog) {
+	// 提取token使用量
+	requestLog.TokensUsed = r.extractTokensUsed(requestLog.ResponseBody)
+	requestLog.TokensEstimated = false
+
+	// 如果响应中没有token信息且请求成功，尝试基于请求和响应内容估算
+	if requestLog.TokensUsed == 0 && requestLog.StatusCode == 200 {
+		estimatedTokens := r.estimateTokensFromRequestAndResponseWithModel(requestLog.RequestBody, requestLog.ResponseBody, requestLog.Model)
+		if estimatedTokens > 0 {
+			requestLog.TokensUsed = estimatedTokens
+			requestLog.TokensEstimated = true
+			log.Printf("Using comprehensive token estimation for model %s: %d tokens (request + response)", requestLog.Model, requestLog.TokensUsed)
+		}
+	}
+
+	// 提取工具调用信息
+	requestLog.HasToolCalls, requestLog.ToolCallsCount, requestLog.ToolNames = r.extractToolCallInfo(requestLog.RequestBody, requestLog.ResponseBody)
+
+	// 按模型价格估算费用
+	requestLog.Cost = 0
+	if r.costFunc != nil && requestLog.TokensUsed > 0 {
+		requestLog.Cost = r.costFunc(requestLog.Model, requestLog.TokensUsed)
+	}
+
+	requestLog.ErrorClass = classifyLogError(requestLog)
+
+	// 调试追踪中记录了首个数据块的时间时以其为准，没有追踪的日志保留记录时的值
+	if ttft, ok := ttftFromDebugTrace(requestLog.DebugTrace); ok {
+		requestLog.TTFTMs = ttft
+	}
+}
+
+// classifyLogError 按状态码和错误信息对失败的请求归类，成功的请求返回空字符串
+func classifyLogError(requestLog *RequestLog) string {
+	if requestLog.StatusCode < 400 && requestLog.Error == "" {
+		return ""
+	}
+	if requestLog.StatusCode == statusClientClosedRequest {
+		return errorClassClientClosed
+	}
+	message := requestLog.Error
+	if requestLog.StatusCode >= 400 {
+		message += " " + requestLog.ResponseBody
+	}
+	return string(providers.ClassifyStatus(requestLog.StatusCode, message))
+}
+
+// ttftFromDebugTrace 从调试追踪的"收到第一个数据块"事件中读取首字耗时（距请求开始的毫秒数）
+func ttftFromDebugTrace(debugTrace string) (int64, bool) {
+	if debugTrace == "" {
+		return 0, false
+	}
+	var events []struct {
+		OffsetMs int64                  `json:"offset_ms"`
+		Stage    string                 `json:"stage"`
+		Fields   map[string]interface{} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(debugTrace), &events); err != nil {
+		return 0, false
+	}
+	for _, event := range events {
+		if event.Stage != "upstream" {
+			continue
+		}
+		if _, ok := event.Fields["first_chunk_ms"]; ok {
+			return event.OffsetMs, true
+		}
 	}
+	return 0, false
 }
 
 // GetRequestLogs 获取请求日志列表
@@ -107,6 +314,11 @@ func (r *RequestLogger) GetRequestLogDetail(id int64) (*RequestLog, error) {
 	return r.db.GetRequestLogDetail(id)
 }
 
+// GetRequestLogsByRequestID 按请求ID查找请求日志
+func (r *RequestLogger) GetRequestLogsByRequestID(requestID string) ([]*RequestLogSummary, error) {
+	return r.db.GetRequestLogsByRequestID(requestID)
+}
+
 // GetProxyKeyStats 获取代理密钥统计
 func (r *RequestLogger) GetProxyKeyStats() ([]*ProxyKeyStats, error) {
 	return r.db.GetProxyKeyStats()
@@ -182,6 +394,126 @@ func (r *RequestLogger) DeleteProxyKey(keyID string) error {
 	return r.db.DeleteProxyKey(keyID)
 }
 
+// InsertAdminToken 插入管理API令牌
+func (r *RequestLogger) InsertAdminToken(token *AdminToken) error {
+	return r.db.InsertAdminToken(token)
+}
+
+// GetAdminTokenByHash 根据令牌哈希获取管理API令牌
+func (r *RequestLogger) GetAdminTokenByHash(tokenHash string) (*AdminToken, error) {
+	return r.db.GetAdminTokenByHash(tokenHash)
+}
+
+// GetAllAdminTokens 获取所有管理API令牌
+func (r *RequestLogger) GetAllAdminTokens() ([]*AdminToken, error) {
+	return r.db.GetAllAdminTokens()
+}
+
+// UpdateAdminTokenLastUsed 更新管理API令牌最后使用时间
+func (r *RequestLogger) UpdateAdminTokenLastUsed(id string) error {
+	return r.db.UpdateAdminTokenLastUsed(id)
+}
+
+// DeleteAdminToken 删除（吊销）管理API令牌
+func (r *RequestLogger) DeleteAdminToken(id string) error {
+	return r.db.DeleteAdminToken(id)
+}
+
+// InsertAuditLog 插入审计日志
+func (r *RequestLogger) InsertAuditLog(entry *AuditLog) error {
+	return r.db.InsertAuditLog(entry)
+}
+
+// InsertAlertRule 插入告警规则
+func (r *RequestLogger) InsertAlertRule(rule *AlertRule) error {
+	return r.db.InsertAlertRule(rule)
+}
+
+// UpdateAlertRule 更新告警规则
+func (r *RequestLogger) UpdateAlertRule(rule *AlertRule) error {
+	return r.db.UpdateAlertRule(rule)
+}
+
+// GetAllAlertRules 获取所有告警规则
+func (r *RequestLogger) GetAllAlertRules() ([]*AlertRule, error) {
+	return r.db.GetAllAlertRules()
+}
+
+// DeleteAlertRule 删除告警规则
+func (r *RequestLogger) DeleteAlertRule(id string) error {
+	return r.db.DeleteAlertRule(id)
+}
+
+// InsertTenant 插入租户
+func (r *RequestLogger) InsertTenant(tenant *Tenant) error {
+	return r.db.InsertTenant(tenant)
+}
+
+// UpdateTenant 更新租户
+func (r *RequestLogger) UpdateTenant(tenant *Tenant) error {
+	return r.db.UpdateTenant(tenant)
+}
+
+// GetAllTenants 获取所有租户
+func (r *RequestLogger) GetAllTenants() ([]*Tenant, error) {
+	return r.db.GetAllTenants()
+}
+
+// DeleteTenant 删除租户
+func (r *RequestLogger) DeleteTenant(id string) error {
+	return r.db.DeleteTenant(id)
+}
+
+// GetTenantUsageSince 按租户统计指定时间之后的用量
+func (r *RequestLogger) GetTenantUsageSince(since time.Time) (map[string]*TenantUsage, error) {
+	return r.db.GetTenantUsageSince(since)
+}
+
+// GetProxyKeyStatsWithFilter 基于筛选与时间范围的代理密钥统计
+func (r *RequestLogger) GetProxyKeyStatsWithFilter(filter *LogFilter) ([]*ProxyKeyStats, error) {
+	return r.db.GetProxyKeyStatsWithFilter(filter)
+}
+
+// GetTotalTokensStatsWithFilter 基于筛选与时间范围的总token数统计
+func (r *RequestLogger) GetTotalTokensStatsWithFilter(filter *LogFilter) (*TotalTokensStats, error) {
+	return r.db.GetTotalTokensStatsWithFilter(filter)
+}
+
+// InsertBudget 插入预算
+func (r *RequestLogger) InsertBudget(budget *Budget) error {
+	return r.db.InsertBudget(budget)
+}
+
+// UpdateBudget 更新预算
+func (r *RequestLogger) UpdateBudget(budget *Budget) error {
+	return r.db.UpdateBudget(budget)
+}
+
+// GetAllBudgets 获取所有预算
+func (r *RequestLogger) GetAllBudgets() ([]*Budget, error) {
+	return r.db.GetAllBudgets()
+}
+
+// DeleteBudget 删除预算
+func (r *RequestLogger) DeleteBudget(id string) error {
+	return r.db.DeleteBudget(id)
+}
+
+// GetBudgetUsageSince 按代理密钥、分组和模型统计指定时间之后的token用量
+func (r *RequestLogger) GetBudgetUsageSince(since time.Time) ([]*BudgetUsage, error) {
+	return r.db.GetBudgetUsageSince(since)
+}
+
+// GetAuditLogs 根据筛选条件获取审计日志
+func (r *RequestLogger) GetAuditLogs(filter *AuditFilter) ([]*AuditLog, error) {
+	return r.db.GetAuditLogs(filter)
+}
+
+// GetAuditLogCount 根据筛选条件获取审计日志总数
+func (r *RequestLogger) GetAuditLogCount(filter *AuditFilter) (int64, error) {
+	return r.db.GetAuditLogCount(filter)
+}
+
 // CleanupOldLogs 清理旧日志
 func (r *RequestLogger) CleanupOldLogs(retentionDays int) error {
 	return r.db.CleanupOldLogs(retentionDays)
@@ -212,12 +544,14 @@ func (r *RequestLogger) GetAllRequestLogsForExportWithFilter(filter *LogFilter)
 	return r.db.GetAllRequestLogsForExportWithFilter(filter)
 }
 
-// maskAPIKey 遮蔽API密钥敏感信息
+// maskAPIKey 遮蔽API密钥敏感信息，只保留末尾4位用于关联
 func (r *RequestLogger) maskAPIKey(apiKey string) string {
-	if len(apiKey) <= 8 {
-		return strings.Repeat("*", len(apiKey))
+	if r.labeler != nil {
+		if label := r.labeler(apiKey); label != "" {
+			return label
+		}
 	}
-	return apiKey[:4] + strings.Repeat("*", len(apiKey)-8) + apiKey[len(apiKey)-4:]
+	return maskSecret(apiKey)
 }
 
 // extractTokensUsed 从响应中提取使用的token数量
@@ -6,48 +6,77 @@ import (
 
 // RequestLog 请求日志结构
 type RequestLog struct {
-	ID              int64     `json:"id" db:"id"`
-	ProxyKeyName    string    `json:"proxy_key_name" db:"proxy_key_name"` // 代理服务API密钥名称
-	ProxyKeyID      string    `json:"proxy_key_id" db:"proxy_key_id"`     // 代理服务API密钥ID
-	ProviderGroup   string    `json:"provider_group" db:"provider_group"` // 提供商分组
-	OpenRouterKey   string    `json:"openrouter_key" db:"openrouter_key"` // 使用的OpenRouter密钥（脱敏）
-	Model           string    `json:"model" db:"model"`
-	RequestBody     string    `json:"request_body" db:"request_body"`
-	ResponseBody    string    `json:"response_body" db:"response_body"`
-	StatusCode      int       `json:"status_code" db:"status_code"`
-	IsStream        bool      `json:"is_stream" db:"is_stream"`
-	Duration        int64     `json:"duration" db:"duration"` // 毫秒
-	TokensUsed      int       `json:"tokens_used" db:"tokens_used"`
-	TokensEstimated bool      `json:"tokens_estimated" db:"tokens_estimated"` // 是否使用了备用估算方法
-	Error           string    `json:"error" db:"error"`
-	ClientIP        string    `json:"client_ip" db:"client_ip"` // 客户端IP地址
+	ID              int64  `json:"id" db:"id"`
+	ProxyKeyName    string `json:"proxy_key_name" db:"proxy_key_name"` // 代理服务API密钥名称
+	ProxyKeyID      string `json:"proxy_key_id" db:"proxy_key_id"`     // 代理服务API密钥ID
+	ProviderGroup   string `json:"provider_group" db:"provider_group"` // 提供商分组
+	OpenRouterKey   string `json:"openrouter_key" db:"openrouter_key"` // 使用的OpenRouter密钥（脱敏）
+	Model           string `json:"model" db:"model"`
+	RequestBody     string `json:"request_body" db:"request_body"`
+	ResponseBody    string `json:"response_body" db:"response_body"`
+	StatusCode      int    `json:"status_code" db:"status_code"`
+	IsStream        bool   `json:"is_stream" db:"is_stream"`
+	Duration        int64  `json:"duration" db:"duration"` // 毫秒
+	TokensUsed      int    `json:"tokens_used" db:"tokens_used"`
+	TokensEstimated bool   `json:"tokens_estimated" db:"tokens_estimated"` // 是否使用了备用估算方法
+	Error           string `json:"error" db:"error"`
+	ClientIP        string `json:"client_ip" db:"client_ip"` // 客户端IP地址
 	// 工具调用相关字段
-	HasToolCalls    bool      `json:"has_tool_calls" db:"has_tool_calls"`       // 是否包含工具调用
-	ToolCallsCount  int       `json:"tool_calls_count" db:"tool_calls_count"`   // 工具调用数量
-	ToolNames       string    `json:"tool_names" db:"tool_names"`               // 工具名称列表（JSON数组字符串）
+	HasToolCalls    bool      `json:"has_tool_calls" db:"has_tool_calls"`           // 是否包含工具调用
+	ToolCallsCount  int       `json:"tool_calls_count" db:"tool_calls_count"`       // 工具调用数量
+	ToolNames       string    `json:"tool_names" db:"tool_names"`                   // 工具名称列表（JSON数组字符串）
+	UpstreamHeaders string    `json:"upstream_headers" db:"upstream_headers"`       // 记录的上游响应头（JSON对象字符串）
+	DebugTrace      string    `json:"debug_trace,omitempty" db:"debug_trace"`       // 请求级调试追踪（JSON数组字符串），只有开启调试的请求有值
+	CorrelationID   string    `json:"correlation_id,omitempty" db:"correlation_id"` // 影子流量的关联ID，原请求和影子请求相同
+	IsShadow        bool      `json:"is_shadow" db:"is_shadow"`                     // 是否为影子请求
+	SplitName       string    `json:"split_name,omitempty" db:"split_name"`         // 请求所属的A/B分流名称
+	SplitArm        string    `json:"split_arm,omitempty" db:"split_arm"`           // 分流分配的分支分组，故障转移时可能与 provider_group 不同
+	PrevHash        string    `json:"prev_hash,omitempty" db:"prev_hash"`           // 哈希链中上一条日志的哈希
+	RowHash         string    `json:"row_hash,omitempty" db:"row_hash"`             // 本条日志的哈希，由上一条日志的哈希和本条日志内容计算
+	RequestID       string    `json:"request_id,omitempty" db:"request_id"`         // 请求ID，与响应头 X-Request-ID 和应用日志中的 request_id 相同
+	Cost            float64   `json:"cost" db:"cost"`                               // 按 model_pricing 估算的费用（美元），未配置价格时为0
+	ErrorClass      string    `json:"error_class,omitempty" db:"error_class"`       // 失败请求的归一化错误分类（auth、quota、rate_limit等）
+	TTFTMs          int64     `json:"ttft_ms,omitempty" db:"ttft_ms"`               // 流式请求从开始到收到第一个数据块的毫秒数
 	CreatedAt       time.Time `json:"created_at" db:"created_at"`
 }
 
 // RequestLogSummary 请求日志摘要（用于列表显示）
 type RequestLogSummary struct {
-	ID              int64     `json:"id"`
-	ProxyKeyName    string    `json:"proxy_key_name"`
-	ProxyKeyID      string    `json:"proxy_key_id"`
-	ProviderGroup   string    `json:"provider_group"`
-	OpenRouterKey   string    `json:"openrouter_key"`
-	Model           string    `json:"model"`
-	StatusCode      int       `json:"status_code"`
-	IsStream        bool      `json:"is_stream"`
-	Duration        int64     `json:"duration"`
-	TokensUsed      int       `json:"tokens_used"`
-	TokensEstimated bool      `json:"tokens_estimated"`
-	Error           string    `json:"error"`
-	ClientIP        string    `json:"client_ip"`
+	ID              int64  `json:"id"`
+	ProxyKeyName    string `json:"proxy_key_name"`
+	ProxyKeyID      string `json:"proxy_key_id"`
+	ProviderGroup   string `json:"provider_group"`
+	OpenRouterKey   string `json:"openrouter_key"`
+	Model           string `json:"model"`
+	StatusCode      int    `json:"status_code"`
+	IsStream        bool   `json:"is_stream"`
+	Duration        int64  `json:"duration"`
+	TokensUsed      int    `json:"tokens_used"`
+	TokensEstimated bool   `json:"tokens_estimated"`
+	Error           string `json:"error"`
+	ClientIP        string `json:"client_ip"`
 	// 工具调用相关字段
-	HasToolCalls    bool      `json:"has_tool_calls"`
-	ToolCallsCount  int       `json:"tool_calls_count"`
-	ToolNames       string    `json:"tool_names"`
-	CreatedAt       time.Time `json:"created_at"`
+	HasToolCalls   bool      `json:"has_tool_calls"`
+	ToolCallsCount int       `json:"tool_calls_count"`
+	ToolNames      string    `json:"tool_names"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// LegacyProviderGroup 兼容单提供商旧版本配置时生成的默认分组ID，与 internal.LegacyGroupID 保持一致
+const LegacyProviderGroup = "openrouter_default"
+
+// LogSearchMatch 全文搜索命中的片段，Match为命中的原文，Before和After为前后的上下文
+type LogSearchMatch struct {
+	Field  string `json:"field"` // request_body、response_body 或 error
+	Before string `json:"before"`
+	Match  string `json:"match"`
+	After  string `json:"after"`
+}
+
+// LogSearchResult 全文搜索结果，附带每个命中字段的高亮片段
+type LogSearchResult struct {
+	RequestLogSummary
+	Matches []LogSearchMatch `json:"matches"`
 }
 
 // ProxyKey 代理服务API密钥结构
@@ -63,6 +92,145 @@ type ProxyKey struct {
 	CreatedAt            time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt            time.Time  `json:"updated_at" db:"updated_at"`
 	LastUsedAt           *time.Time `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt            *time.Time `json:"expires_at,omitempty" db:"expires_at"` // 过期时间，为空表示永不过期
+	MaxUsageCount        int64      `json:"max_usage_count" db:"max_usage_count"` // 最大使用次数，0表示不限制
+	AllowedModels        []string   `json:"allowed_models" db:"allowed_models"`   // 允许请求的模型，支持*和?通配符，为空表示不限制
+	DeniedModels         []string   `json:"denied_models" db:"denied_models"`     // 禁止请求的模型，优先于允许列表
+	AllowedIPs           []string   `json:"allowed_ips" db:"allowed_ips"`         // 允许的来源地址（IP或CIDR），为空表示不限制
+	DeniedIPs            []string   `json:"denied_ips" db:"denied_ips"`           // 禁止的来源地址，优先于允许列表
+	SystemPrompt         string     `json:"system_prompt" db:"system_prompt"`     // 注入到每个聊天请求的系统提示词，为空表示不注入
+	TenantID             string     `json:"tenant_id" db:"tenant_id"`             // 所属租户ID，为空表示不属于任何租户
+}
+
+// Tenant 租户，多个代理密钥归属同一租户，按租户统计用量和限制每月额度
+type Tenant struct {
+	ID                  string    `json:"id" db:"id"`
+	Name                string    `json:"name" db:"name"`
+	Description         string    `json:"description" db:"description"`
+	IsActive            bool      `json:"is_active" db:"is_active"`                         // 停用后租户下的所有代理密钥不可用
+	MonthlyRequestLimit int64     `json:"monthly_request_limit" db:"monthly_request_limit"` // 每自然月请求数上限，0表示不限制
+	MonthlyTokenLimit   int64     `json:"monthly_token_limit" db:"monthly_token_limit"`     // 每自然月token数上限，0表示不限制
+	CreatedAt           time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// AdminToken 管理API令牌，用于自动化脚本和CI以Bearer令牌调用 /admin 接口
+// 数据库只保存令牌的SHA-256哈希，明文仅在创建时返回一次
+type AdminToken struct {
+	ID          string     `json:"id" db:"id"`
+	Name        string     `json:"name" db:"name"`
+	TokenHash   string     `json:"-" db:"token_hash"`
+	TokenPrefix string     `json:"token_prefix" db:"token_prefix"` // 令牌前几位，便于识别
+	Scopes      []string   `json:"scopes" db:"scopes"`             // 权限范围：read、manage_groups、manage_keys、admin
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at" db:"last_used_at"`
+}
+
+// AdminUser 管理界面和管理API的登录用户
+type AdminUser struct {
+	ID           string     `json:"id" db:"id"`
+	Username     string     `json:"username" db:"username"`
+	PasswordHash string     `json:"-" db:"password_hash"` // bcrypt哈希
+	Role         string     `json:"role" db:"role"`       // viewer、operator、admin
+	Disabled     bool       `json:"disabled" db:"disabled"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+	LastLoginAt  *time.Time `json:"last_login_at" db:"last_login_at"`
+
+	// 两步验证（TOTP）：启用前的密钥也保存在 TOTPSecret 中，验证通过后 TOTPEnabled 才为true
+	TOTPSecret    string   `json:"-" db:"totp_secret"`
+	TOTPEnabled   bool     `json:"totp_enabled" db:"totp_enabled"`
+	RecoveryCodes []string `json:"-" db:"recovery_codes"` // 未使用恢复码的SHA-256哈希
+}
+
+// AdminSession 管理界面登录会话，数据库只保存会话令牌的SHA-256哈希
+type AdminSession struct {
+	ID         string    `json:"id" db:"id"` // 会话令牌的SHA-256哈希
+	Username   string    `json:"username" db:"username"`
+	UserID     string    `json:"user_id" db:"user_id"`
+	Role       string    `json:"role" db:"role"`
+	AuthMethod string    `json:"auth_method" db:"auth_method"` // password、oidc
+	TwoFactor  bool      `json:"two_factor" db:"two_factor"`
+	ClientIP   string    `json:"client_ip" db:"client_ip"`
+	UserAgent  string    `json:"user_agent" db:"user_agent"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at" db:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// ProxyKeyShare 代理密钥的一次性分享链接
+// 数据库只保存链接令牌的SHA-256哈希和用链接令牌加密的密钥，读取一次后删除
+type ProxyKeyShare struct {
+	ID         string    `json:"-" db:"id"` // 链接令牌的SHA-256哈希
+	KeyID      string    `json:"key_id" db:"key_id"`
+	Ciphertext string    `json:"-" db:"ciphertext"` // 用链接令牌派生的密钥加密的代理密钥（base64）
+	ExpiresAt  time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// 告警规则类型
+const (
+	AlertRuleErrorRate  = "error_rate"  // 窗口内失败请求百分比超过阈值
+	AlertRuleLatencyP95 = "latency_p95" // 窗口内请求耗时的P95（秒）超过阈值
+	AlertRuleErrorMatch = "error_match" // 窗口内错误信息匹配表达式的请求数达到阈值
+)
+
+// AlertRule 请求日志告警规则，由服务进程按滑动窗口持续评估
+type AlertRule struct {
+	ID              string    `json:"id" db:"id"`
+	Name            string    `json:"name" db:"name"`
+	Type            string    `json:"type" db:"rule_type"`                    // error_rate、latency_p95 或 error_match
+	ProviderGroup   string    `json:"provider_group" db:"provider_group"`     // 为空表示所有分组
+	Threshold       float64   `json:"threshold" db:"threshold"`               // error_rate 为百分比，latency_p95 为秒，error_match 为请求数
+	Pattern         string    `json:"pattern" db:"pattern"`                   // error_match 匹配错误信息的正则表达式
+	WindowSeconds   int       `json:"window_seconds" db:"window_seconds"`     // 滑动窗口长度
+	MinRequests     int       `json:"min_requests" db:"min_requests"`         // 窗口内请求数少于该值时不触发 error_rate 和 latency_p95
+	CooldownSeconds int       `json:"cooldown_seconds" db:"cooldown_seconds"` // 两次触发通知的最小间隔
+	Enabled         bool      `json:"enabled" db:"enabled"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// 预算范围
+const (
+	BudgetScopeTenant   = "tenant"    // 租户下所有代理密钥的用量
+	BudgetScopeProxyKey = "proxy_key" // 单个代理密钥的用量
+	BudgetScopeGroup    = "group"     // 提供商分组的用量
+)
+
+// Budget 每个计费周期的预算上限，用量超出后拒绝该范围内的请求
+type Budget struct {
+	ID          string    `json:"id" db:"id"`
+	Scope       string    `json:"scope" db:"scope"`               // tenant、proxy_key 或 group
+	Target      string    `json:"target" db:"target"`             // 租户ID、代理密钥ID或分组ID
+	LimitUSD    float64   `json:"limit_usd" db:"limit_usd"`       // 按模型价格估算的费用上限（美元），0表示不限制
+	LimitTokens int64     `json:"limit_tokens" db:"limit_tokens"` // token数上限，0表示不限制
+	Enabled     bool      `json:"enabled" db:"enabled"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// AuditLog 管理操作审计日志
+type AuditLog struct {
+	ID        int64     `json:"id" db:"id"`
+	Actor     string    `json:"actor" db:"actor"`   // 操作者：登录用户名或 token:<令牌名称>
+	Action    string    `json:"action" db:"action"` // 操作类型，如 group.update、proxy_key.delete
+	Target    string    `json:"target" db:"target"` // 操作对象，如分组ID或代理密钥ID
+	ClientIP  string    `json:"client_ip" db:"client_ip"`
+	Before    string    `json:"before,omitempty" db:"before"` // 变更前的字段（JSON），只包含发生变化的字段
+	After     string    `json:"after,omitempty" db:"after"`   // 变更后的字段（JSON），只包含发生变化的字段
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// AuditFilter 审计日志筛选条件
+type AuditFilter struct {
+	Actor     string     `json:"actor"`
+	Action    string     `json:"action"` // 支持前缀匹配，如 "group." 匹配所有分组操作
+	Target    string     `json:"target"`
+	StartTime *time.Time `json:"start_time"`
+	EndTime   *time.Time `json:"end_time"`
+	Limit     int        `json:"limit"` // 0表示不限制（用于导出）
+	Offset    int        `json:"offset"`
 }
 
 // ProxyKeyStats 代理密钥统计
@@ -96,6 +264,8 @@ type LogFilter struct {
 	// 新增时间范围筛选：包含起止时间（闭区间），为空则不限制
 	StartTime *time.Time `json:"start_time"`
 	EndTime   *time.Time `json:"end_time"`
+	// 只返回这些代理密钥的日志（用于按租户查询），nil表示不限制，空列表表示没有匹配的日志
+	ProxyKeyIDs []string `json:"proxy_key_ids,omitempty"`
 }
 
 // TotalTokensStats 总token数统计结构
@@ -114,9 +284,9 @@ type StatusStats struct {
 
 // TimelinePoint tokens 时间序列点
 type TimelinePoint struct {
-	Date    string `json:"date"`   // "YYYY-MM-DD" 或 "YYYY-MM-DD HH:00"
-	Total   int64  `json:"total"`  // 总 tokens
-	Success int64  `json:"success"`// 成功 tokens
+	Date    string `json:"date"`    // "YYYY-MM-DD" 或 "YYYY-MM-DD HH:00"
+	Total   int64  `json:"total"`   // 总 tokens
+	Success int64  `json:"success"` // 成功 tokens
 }
 
 // GroupTokensStat 分组 tokens 聚合
@@ -0,0 +1,92 @@
+package logger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrProxyKeyShareNotFound 分享链接不存在、已被查看或已过期
+var ErrProxyKeyShareNotFound = errors.New("proxy key share not found")
+
+// InsertProxyKeyShare 插入代理密钥分享链接，同时清理已过期的链接
+func (d *Database) InsertProxyKeyShare(share *ProxyKeyShare) error {
+	if _, err := d.exec(`DELETE FROM proxy_key_shares WHERE expires_at < ?`, time.Now()); err != nil {
+		return fmt.Errorf("failed to delete expired proxy key shares: %w", err)
+	}
+
+	query := `
+	INSERT INTO proxy_key_shares (id, key_id, ciphertext, expires_at, created_at)
+	VALUES (?, ?, ?, ?, ?)
+	`
+	if _, err := d.exec(query, share.ID, share.KeyID, share.Ciphertext, share.ExpiresAt, share.CreatedAt); err != nil {
+		return fmt.Errorf("failed to insert proxy key share: %w", err)
+	}
+	return nil
+}
+
+// GetProxyKeyShare 根据链接令牌哈希获取未过期的分享链接，不会使其失效
+func (d *Database) GetProxyKeyShare(id string) (*ProxyKeyShare, error) {
+	share := &ProxyKeyShare{}
+	err := d.queryRow(`
+	SELECT id, key_id, ciphertext, expires_at, created_at
+	FROM proxy_key_shares
+	WHERE id = ?
+	`, id).Scan(&share.ID, &share.KeyID, &share.Ciphertext, &share.ExpiresAt, &share.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrProxyKeyShareNotFound
+		}
+		return nil, fmt.Errorf("failed to get proxy key share: %w", err)
+	}
+	if !time.Now().Before(share.ExpiresAt) {
+		return nil, ErrProxyKeyShareNotFound
+	}
+	return share, nil
+}
+
+// ConsumeProxyKeyShare 读取并删除分享链接，并发读取同一链接时只有一次成功
+func (d *Database) ConsumeProxyKeyShare(id string) (*ProxyKeyShare, error) {
+	share, err := d.GetProxyKeyShare(id)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := d.exec(`DELETE FROM proxy_key_shares WHERE id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete proxy key share: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, ErrProxyKeyShareNotFound
+	}
+	return share, nil
+}
+
+// DeleteProxyKeySharesForKey 删除代理密钥的所有分享链接
+func (d *Database) DeleteProxyKeySharesForKey(keyID string) error {
+	if _, err := d.exec(`DELETE FROM proxy_key_shares WHERE key_id = ?`, keyID); err != nil {
+		return fmt.Errorf("failed to delete proxy key shares: %w", err)
+	}
+	return nil
+}
+
+// InsertProxyKeyShare 插入代理密钥分享链接
+func (r *RequestLogger) InsertProxyKeyShare(share *ProxyKeyShare) error {
+	return r.db.InsertProxyKeyShare(share)
+}
+
+// GetProxyKeyShare 获取未过期的代理密钥分享链接
+func (r *RequestLogger) GetProxyKeyShare(id string) (*ProxyKeyShare, error) {
+	return r.db.GetProxyKeyShare(id)
+}
+
+// ConsumeProxyKeyShare 读取并删除代理密钥分享链接
+func (r *RequestLogger) ConsumeProxyKeyShare(id string) (*ProxyKeyShare, error) {
+	return r.db.ConsumeProxyKeyShare(id)
+}
+
+// DeleteProxyKeySharesForKey 删除代理密钥的所有分享链接
+func (r *RequestLogger) DeleteProxyKeySharesForKey(keyID string) error {
+	return r.db.DeleteProxyKeySharesForKey(keyID)
+}
@@ -0,0 +1,123 @@
+package logger
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RedactionPattern 自定义脱敏规则，匹配的内容替换为只保留末尾几位的掩码
+type RedactionPattern struct {
+	Name  string
+	Regex string
+}
+
+// RedactionOptions 请求日志脱敏选项
+// API密钥和Authorization头部始终脱敏；Presets 启用内置的可选规则（email、credit_card），Patterns 为自定义正则
+type RedactionOptions struct {
+	Presets  []string
+	Patterns []RedactionPattern
+}
+
+// redactionRule 编译后的脱敏规则，group 为需要掩码的捕获组，0表示整个匹配
+type redactionRule struct {
+	name    string
+	pattern *regexp.Regexp
+	group   int
+}
+
+// defaultRedactionRules 始终启用的规则：常见的API密钥格式、Bearer令牌和JSON中的密钥字段
+var defaultRedactionRules = []redactionRule{
+	{name: "authorization", pattern: regexp.MustCompile(`(?i)"(?:authorization|proxy-authorization|api[_-]?key|x-api-key|x-goog-api-key|access[_-]?token|secret[_-]?key)"\s*:\s*"([^"\\]{8,})"`), group: 1},
+	{name: "bearer", pattern: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/=\-]{8,})`), group: 1},
+	{name: "api_key", pattern: regexp.MustCompile(`\b(?:sk-[A-Za-z0-9_\-]{16,}|AIza[0-9A-Za-z_\-]{35}|tapi-[0-9a-f]{16,})`)},
+}
+
+// redactionPresets 可选的内置规则
+var redactionPresets = map[string]redactionRule{
+	"email":       {name: "email", pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)},
+	"credit_card": {name: "credit_card", pattern: regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`)},
+}
+
+// Redactor 请求日志脱敏器，写入数据库前掩码密钥和敏感信息
+type Redactor struct {
+	rules []redactionRule
+}
+
+// NewRedactor 根据选项创建脱敏器，未知的内置规则或无效的正则返回错误
+func NewRedactor(opts RedactionOptions) (*Redactor, error) {
+	rules := append([]redactionRule(nil), defaultRedactionRules...)
+	for _, name := range opts.Presets {
+		rule, ok := redactionPresets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redaction preset: %s", name)
+		}
+		rules = append(rules, rule)
+	}
+	for i, p := range opts.Patterns {
+		pattern, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %d (%s): %w", i, p.Name, err)
+		}
+		rules = append(rules, redactionRule{name: p.Name, pattern: pattern})
+	}
+	return &Redactor{rules: rules}, nil
+}
+
+// maskSecret 掩码敏感内容，只保留末尾4位用于关联，过短的内容全部掩码
+func maskSecret(value string) string {
+	if len(value) < 12 {
+		return "****"
+	}
+	return "****" + value[len(value)-4:]
+}
+
+// Redact 按规则掩码文本中的敏感内容，secrets 为需要原样查找并掩码的值（如本次请求使用的上游密钥）
+func (r *Redactor) Redact(text string, secrets ...string) string {
+	if text == "" {
+		return text
+	}
+	for _, secret := range secrets {
+		if len(secret) >= 8 {
+			text = strings.ReplaceAll(text, secret, maskSecret(secret))
+		}
+	}
+	for _, rule := range r.rules {
+		text = rule.apply(text)
+	}
+	return text
+}
+
+// apply 替换规则的所有匹配
+func (rule redactionRule) apply(text string) string {
+	if rule.group == 0 {
+		return rule.pattern.ReplaceAllStringFunc(text, maskSecret)
+	}
+
+	matches := rule.pattern.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[2*rule.group], m[2*rule.group+1]
+		if start < 0 {
+			continue
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(maskSecret(text[start:end]))
+		last = end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// redactLog 掩码请求日志中写入数据库的文本字段
+func (r *Redactor) redactLog(entry *RequestLog, upstreamKey string) {
+	entry.RequestBody = r.Redact(entry.RequestBody, upstreamKey)
+	entry.ResponseBody = r.Redact(entry.ResponseBody, upstreamKey)
+	entry.Error = r.Redact(entry.Error, upstreamKey)
+	entry.DebugTrace = r.Redact(entry.DebugTrace, upstreamKey)
+	entry.UpstreamHeaders = r.Redact(entry.UpstreamHeaders, upstreamKey)
+}
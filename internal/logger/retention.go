@@ -0,0 +1,241 @@
+package logger
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxEvictionRounds 一次清理中按大小上限淘汰日志的最大轮数
+const maxEvictionRounds = 10
+
+// storageStatsTTL 存储用量统计的缓存时间，避免频繁统计大表的行数
+const storageStatsTTL = time.Minute
+
+// storageStatsTables 存储用量中统计行数的表
+var storageStatsTables = []string{"request_logs", "audit_logs", "proxy_keys", "usage_reports"}
+
+// RetentionPolicy 请求日志保留策略，按代理密钥、分组、全局的顺序取第一个匹配的保留天数
+type RetentionPolicy struct {
+	Days         int            // 全局保留天数，0或负数表示不按时间清理
+	GroupDays    map[string]int // 按分组覆盖保留天数，0表示永久保留
+	ProxyKeyDays map[string]int // 按代理密钥ID或名称覆盖保留天数，优先于分组，0表示永久保留
+	MaxSizeBytes int64          // 数据库大小上限，超过时删除最早的请求日志，0表示不限制（仅SQLite）
+	KeepSince    time.Time      // 不删除此时间之后的日志，预算和租户限额的本期用量从请求日志统计，零值表示不限制
+}
+
+// cutoff 返回保留天数对应的删除截止时间，不晚于 KeepSince
+func (p RetentionPolicy) cutoff(now time.Time, days int) time.Time {
+	cutoff := now.AddDate(0, 0, -days)
+	if !p.KeepSince.IsZero() && p.KeepSince.Before(cutoff) {
+		return p.KeepSince
+	}
+	return cutoff
+}
+
+// RetentionResult 一次保留策略清理的结果
+type RetentionResult struct {
+	Expired   int64 `json:"expired"`    // 超过保留天数删除的日志数
+	Evicted   int64 `json:"evicted"`    // 超过大小上限删除的最早日志数
+	SizeBytes int64 `json:"size_bytes"` // 清理后的数据库大小
+}
+
+// StorageStats 数据库存储用量
+type StorageStats struct {
+	SizeBytes      int64            `json:"size_bytes"`      // 数据实际占用的大小
+	AllocatedBytes int64            `json:"allocated_bytes"` // 数据库文件大小，SQLite删除的空间留在文件中供复用
+	Rows           map[string]int64 `json:"rows"`            // 各表行数
+	CheckedAt      time.Time        `json:"checked_at"`
+}
+
+// sortedPolicyKeys 按名称排序的覆盖项，保证清理顺序稳定
+func sortedPolicyKeys(overrides map[string]int) []string {
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// notInCondition 生成排除指定值的条件，values为空时返回空字符串
+func notInCondition(columns []string, values []string) (string, []interface{}) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(values)), ",")
+	var conditions []string
+	var args []interface{}
+	for _, column := range columns {
+		conditions = append(conditions, column+" NOT IN ("+placeholders+")")
+		for _, value := range values {
+			args = append(args, value)
+		}
+	}
+	return " AND " + strings.Join(conditions, " AND "), args
+}
+
+// ApplyRetention 按保留策略清理请求日志：先按代理密钥和分组的保留天数，再按全局保留天数，最后按大小上限删除最早的日志
+// 有覆盖项的代理密钥和分组不受更外层的保留天数影响；设置了 KeepSince 时都不删除此时间之后的日志
+func (d *Database) ApplyRetention(policy RetentionPolicy) (*RetentionResult, error) {
+	result := &RetentionResult{}
+	now := time.Now()
+
+	keys := sortedPolicyKeys(policy.ProxyKeyDays)
+	groups := sortedPolicyKeys(policy.GroupDays)
+	excludeKeys, excludeKeyArgs := notInCondition([]string{"proxy_key_id", "proxy_key_name"}, keys)
+	excludeGroups, excludeGroupArgs := notInCondition([]string{"provider_group"}, groups)
+
+	for _, key := range keys {
+		days := policy.ProxyKeyDays[key]
+		if days <= 0 {
+			continue
+		}
+		deleted, err := d.deleteChainedLogsWhere("retention", `(proxy_key_id = ? OR proxy_key_name = ?) AND created_at < ?`,
+			key, key, policy.cutoff(now, days))
+		if err != nil {
+			return result, fmt.Errorf("failed to cleanup logs of proxy key %s: %w", key, err)
+		}
+		result.Expired += deleted
+	}
+
+	for _, group := range groups {
+		days := policy.GroupDays[group]
+		if days <= 0 {
+			continue
+		}
+		args := append([]interface{}{group, policy.cutoff(now, days)}, excludeKeyArgs...)
+		deleted, err := d.deleteChainedLogsWhere("retention", `provider_group = ? AND created_at < ?`+excludeKeys, args...)
+		if err != nil {
+			return result, fmt.Errorf("failed to cleanup logs of group %s: %w", group, err)
+		}
+		result.Expired += deleted
+	}
+
+	if policy.Days > 0 {
+		args := append([]interface{}{policy.cutoff(now, policy.Days)}, excludeGroupArgs...)
+		args = append(args, excludeKeyArgs...)
+		deleted, err := d.deleteChainedLogsWhere("retention", `created_at < ?`+excludeGroups+excludeKeys, args...)
+		if err != nil {
+			return result, fmt.Errorf("failed to cleanup old logs: %w", err)
+		}
+		result.Expired += deleted
+	}
+
+	size, _, err := d.dialect.databaseSize(d.db)
+	if err != nil {
+		return result, fmt.Errorf("failed to get database size: %w", err)
+	}
+	if policy.MaxSizeBytes > 0 && size > policy.MaxSizeBytes {
+		if d.dialect.driverName() != DriverSQLite {
+			return result, fmt.Errorf("database size limit is only supported for sqlite3")
+		}
+		evicted, evictedSize, err := d.evictOldestLogs(policy.MaxSizeBytes, size, policy.KeepSince)
+		result.Evicted = evicted
+		size = evictedSize
+		if err != nil {
+			return result, err
+		}
+	}
+	result.SizeBytes = size
+	return result, nil
+}
+
+// evictOldestLogs 删除最早的请求日志直到数据库大小不超过上限，按日志占用的比例估算每轮删除的行数
+// SQLite删除后空闲页立即计入空闲列表，每轮删除后重新计算大小；keepSince 不为零值时不删除此时间之后的日志
+func (d *Database) evictOldestLogs(maxSize, size int64, keepSince time.Time) (int64, int64, error) {
+	where, args := "1 = 1", []interface{}{}
+	if !keepSince.IsZero() {
+		where, args = "created_at < ?", []interface{}{keepSince}
+	}
+
+	var evicted int64
+	for round := 0; round < maxEvictionRounds && size > maxSize; round++ {
+		var count int64
+		if err := d.queryRow(`SELECT COUNT(*) FROM request_logs WHERE `+where, args...).Scan(&count); err != nil {
+			return evicted, size, fmt.Errorf("failed to count request logs: %w", err)
+		}
+		if count == 0 {
+			log.Printf("数据库大小 %d 字节超过上限 %d 字节，但已没有可删除的请求日志（本计费周期的日志不删除）", size, maxSize)
+			break
+		}
+
+		// 多删除约5%，避免在上限附近反复小批量删除
+		batch := count*(size-maxSize)/size + count/20 + 1
+		if batch > count {
+			batch = count
+		}
+		var boundary int64
+		if err := d.queryRow(`SELECT id FROM request_logs WHERE `+where+` ORDER BY id ASC LIMIT 1 OFFSET ?`,
+			append(args, batch-1)...).Scan(&boundary); err != nil {
+			return evicted, size, fmt.Errorf("failed to find eviction boundary: %w", err)
+		}
+		deleted, err := d.deleteChainedLogsWhere("size_limit", `id <= ? AND `+where, append([]interface{}{boundary}, args...)...)
+		if err != nil {
+			return evicted, size, fmt.Errorf("failed to evict oldest logs: %w", err)
+		}
+		evicted += deleted
+
+		if size, _, err = d.dialect.databaseSize(d.db); err != nil {
+			return evicted, size, fmt.Errorf("failed to get database size: %w", err)
+		}
+	}
+	return evicted, size, nil
+}
+
+// StorageStats 统计数据库大小和各表行数
+func (d *Database) StorageStats() (*StorageStats, error) {
+	size, allocated, err := d.dialect.databaseSize(d.db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database size: %w", err)
+	}
+
+	stats := &StorageStats{
+		SizeBytes:      size,
+		AllocatedBytes: allocated,
+		Rows:           make(map[string]int64, len(storageStatsTables)),
+		CheckedAt:      time.Now(),
+	}
+	for _, table := range storageStatsTables {
+		var count int64
+		if err := d.queryRow(`SELECT COUNT(*) FROM ` + table).Scan(&count); err != nil {
+			return nil, fmt.Errorf("failed to count %s: %w", table, err)
+		}
+		stats.Rows[table] = count
+	}
+	return stats, nil
+}
+
+// ApplyRetention 按保留策略清理请求日志
+func (r *RequestLogger) ApplyRetention(policy RetentionPolicy) (*RetentionResult, error) {
+	result, err := r.db.ApplyRetention(policy)
+	if err == nil {
+		r.invalidateStorageStats()
+	}
+	return result, err
+}
+
+// StorageStats 获取数据库大小和各表行数，结果缓存一分钟
+func (r *RequestLogger) StorageStats() (*StorageStats, error) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+
+	if r.stats != nil && time.Since(r.stats.CheckedAt) < storageStatsTTL {
+		return r.stats, nil
+	}
+	stats, err := r.db.StorageStats()
+	if err != nil {
+		return nil, err
+	}
+	r.stats = stats
+	return stats, nil
+}
+
+// invalidateStorageStats 清理日志后使缓存的存储用量失效
+func (r *RequestLogger) invalidateStorageStats() {
+	r.statsMu.Lock()
+	r.stats = nil
+	r.statsMu.Unlock()
+}
@@ -0,0 +1,214 @@
+package logger
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// ftsMinQueryLength trigram索引能匹配的最短查询长度，更短的查询退回LIKE扫描
+	ftsMinQueryLength = 3
+	// searchSnippetContext 高亮片段中命中内容前后保留的字符数
+	searchSnippetContext = 80
+)
+
+// ftsTriggerNames 保持全文索引与request_logs同步的触发器
+var ftsTriggerNames = []string{"request_logs_fts_ai", "request_logs_fts_ad", "request_logs_fts_au"}
+
+// initFullTextSearch 为SQLite创建请求体、响应体和错误信息的FTS5全文索引（trigram分词，支持任意子串搜索）
+// 驱动未编译FTS5时（cgo驱动需 -tags sqlite_fts5）删除旧的同步触发器，搜索退回LIKE扫描
+func (d *Database) initFullTextSearch() error {
+	if d.dialect.driverName() != DriverSQLite {
+		return nil
+	}
+
+	var triggerCount int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = ?`,
+		ftsTriggerNames[0]).Scan(&triggerCount); err != nil {
+		return fmt.Errorf("failed to check full-text search triggers: %w", err)
+	}
+
+	_, err := d.db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS request_logs_fts USING fts5(
+		request_body, response_body, error,
+		content='request_logs', content_rowid='id', tokenize='trigram'
+	)`)
+	if err == nil {
+		// 表已存在时 IF NOT EXISTS 不会加载模块，查询一次确认当前驱动支持FTS5
+		var rowID int64
+		err = d.db.QueryRow(`SELECT rowid FROM request_logs_fts LIMIT 1`).Scan(&rowID)
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+	if err != nil {
+		// 由支持FTS5的版本创建的触发器会使日志写入失败
+		for _, name := range ftsTriggerNames {
+			if _, dropErr := d.db.Exec(`DROP TRIGGER IF EXISTS ` + name); dropErr != nil {
+				return fmt.Errorf("failed to drop full-text search trigger %s: %w", name, dropErr)
+			}
+		}
+		log.Printf("SQLite FTS5 not available (%v), log search falls back to LIKE scans", err)
+		return nil
+	}
+
+	if triggerCount == 0 {
+		triggers := []string{
+			`CREATE TRIGGER IF NOT EXISTS request_logs_fts_ai AFTER INSERT ON request_logs BEGIN
+				INSERT INTO request_logs_fts(rowid, request_body, response_body, error)
+				VALUES (new.id, new.request_body, new.response_body, new.error);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS request_logs_fts_ad AFTER DELETE ON request_logs BEGIN
+				INSERT INTO request_logs_fts(request_logs_fts, rowid, request_body, response_body, error)
+				VALUES ('delete', old.id, old.request_body, old.response_body, old.error);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS request_logs_fts_au AFTER UPDATE OF request_body, response_body, error ON request_logs BEGIN
+				INSERT INTO request_logs_fts(request_logs_fts, rowid, request_body, response_body, error)
+				VALUES ('delete', old.id, old.request_body, old.response_body, old.error);
+				INSERT INTO request_logs_fts(rowid, request_body, response_body, error)
+				VALUES (new.id, new.request_body, new.response_body, new.error);
+			END`,
+		}
+		for _, trigger := range triggers {
+			if _, err := d.db.Exec(trigger); err != nil {
+				return fmt.Errorf("failed to create full-text search trigger: %w", err)
+			}
+		}
+
+		// 首次启用或触发器曾被删除时，索引可能缺少已有日志，按request_logs重建
+		log.Println("Building full-text search index for request logs...")
+		if _, err := d.db.Exec(`INSERT INTO request_logs_fts(request_logs_fts) VALUES ('rebuild')`); err != nil {
+			return fmt.Errorf("failed to build full-text search index: %w", err)
+		}
+		log.Println("Successfully built full-text search index")
+	}
+
+	d.ftsEnabled = true
+	return nil
+}
+
+// escapeLikePattern 转义LIKE中的通配符，配合 ESCAPE '!' 使用
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// SearchRequestLogs 在请求体、响应体和错误信息中搜索包含query的日志，按ID倒序返回并附带高亮片段
+// SQLite启用FTS5时使用trigram全文索引；其他数据库或查询少于3个字符时使用LIKE扫描
+func (d *Database) SearchRequestLogs(query string, filter *LogFilter) ([]*LogSearchResult, error) {
+	conditions, args := logFilterConditions(filter)
+	if d.ftsEnabled && utf8.RuneCountInString(query) >= ftsMinQueryLength {
+		// 整个查询作为短语，trigram分词下即为子串匹配
+		conditions = append(conditions, "id IN (SELECT rowid FROM request_logs_fts WHERE request_logs_fts MATCH ?)")
+		args = append(args, `"`+strings.ReplaceAll(query, `"`, `""`)+`"`)
+	} else {
+		pattern := "%" + escapeLikePattern(strings.ToLower(query)) + "%"
+		conditions = append(conditions, "(LOWER(request_body) LIKE ? ESCAPE '!'"+
+			" OR LOWER(COALESCE(response_body, '')) LIKE ? ESCAPE '!'"+
+			" OR LOWER(COALESCE(error, '')) LIKE ? ESCAPE '!')")
+		args = append(args, pattern, pattern, pattern)
+	}
+
+	sqlQuery := `
+	SELECT id, proxy_key_name, proxy_key_id, provider_group, openrouter_key, model, status_code,
+		   is_stream, duration, tokens_used, tokens_estimated, COALESCE(error, ''), client_ip, created_at,
+		   has_tool_calls, tool_calls_count, tool_names, COALESCE(request_body, ''), COALESCE(response_body, '')
+	FROM request_logs WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY id DESC LIMIT ? OFFSET ?`
+	limit, offset := 50, 0
+	if filter != nil {
+		if filter.Limit > 0 {
+			limit = filter.Limit
+		}
+		offset = filter.Offset
+	}
+	args = append(args, limit, offset)
+
+	rows, err := d.query(sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search request logs: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*LogSearchResult
+	for rows.Next() {
+		result := &LogSearchResult{}
+		var requestBody, responseBody string
+		err := rows.Scan(
+			&result.ID, &result.ProxyKeyName, &result.ProxyKeyID, &result.ProviderGroup, &result.OpenRouterKey,
+			&result.Model, &result.StatusCode, &result.IsStream, &result.Duration,
+			&result.TokensUsed, &result.TokensEstimated, &result.Error, &result.ClientIP, &result.CreatedAt,
+			&result.HasToolCalls, &result.ToolCallsCount, &result.ToolNames, &requestBody, &responseBody,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan request log: %w", err)
+		}
+
+		result.Matches = []LogSearchMatch{}
+		for _, field := range []struct{ name, text string }{
+			{"request_body", requestBody}, {"response_body", responseBody}, {"error", result.Error},
+		} {
+			if match := highlightMatch(field.name, field.text, query); match != nil {
+				result.Matches = append(result.Matches, *match)
+			}
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate request logs: %w", err)
+	}
+
+	return results, nil
+}
+
+// highlightMatch 在text中不区分大小写地查找query的第一次出现，返回命中内容及前后各 searchSnippetContext 个字符
+func highlightMatch(field, text, query string) *LogSearchMatch {
+	// strings.Map 逐字符转换，转换前后的字符下标一一对应
+	original := []rune(text)
+	lowered := []rune(strings.Map(unicode.ToLower, text))
+	needle := []rune(strings.Map(unicode.ToLower, query))
+	if len(needle) == 0 {
+		return nil
+	}
+
+	for i := 0; i+len(needle) <= len(lowered); i++ {
+		if lowered[i] != needle[0] || string(lowered[i:i+len(needle)]) != string(needle) {
+			continue
+		}
+
+		end := i + len(needle)
+		start := max(i-searchSnippetContext, 0)
+		stop := min(end+searchSnippetContext, len(original))
+		match := &LogSearchMatch{
+			Field:  field,
+			Before: string(original[start:i]),
+			Match:  string(original[i:end]),
+			After:  string(original[end:stop]),
+		}
+		if start > 0 {
+			match.Before = "…" + match.Before
+		}
+		if stop < len(original) {
+			match.After += "…"
+		}
+		return match
+	}
+	return nil
+}
+
+// FullTextSearchEnabled 日志搜索是否使用FTS5全文索引
+func (d *Database) FullTextSearchEnabled() bool {
+	return d.ftsEnabled
+}
+
+// SearchRequestLogs 在请求体、响应体和错误信息中搜索日志
+func (r *RequestLogger) SearchRequestLogs(query string, filter *LogFilter) ([]*LogSearchResult, error) {
+	return r.db.SearchRequestLogs(query, filter)
+}
+
+// FullTextSearchEnabled 日志搜索是否使用FTS5全文索引
+func (r *RequestLogger) FullTextSearchEnabled() bool {
+	return r.db.FullTextSearchEnabled()
+}
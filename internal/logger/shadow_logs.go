@@ -0,0 +1,109 @@
+package logger
+
+import (
+	"fmt"
+	"time"
+)
+
+// ShadowLogSide 影子流量对比中一侧请求的日志摘要
+type ShadowLogSide struct {
+	LogID         int64  `json:"log_id"`
+	ProviderGroup string `json:"provider_group"`
+	Model         string `json:"model"`
+	StatusCode    int    `json:"status_code"`
+	Duration      int64  `json:"duration"` // 毫秒
+	TokensUsed    int    `json:"tokens_used"`
+	Error         string `json:"error,omitempty"`
+}
+
+// ShadowComparison 原请求与影子请求的日志对比
+type ShadowComparison struct {
+	CorrelationID string        `json:"correlation_id"`
+	Primary       ShadowLogSide `json:"primary"`
+	Shadow        ShadowLogSide `json:"shadow"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+// ShadowSummary 一组对比中原请求和影子请求的汇总
+type ShadowSummary struct {
+	Pairs              int     `json:"pairs"`
+	PrimarySuccessRate float64 `json:"primary_success_rate"` // 百分比
+	ShadowSuccessRate  float64 `json:"shadow_success_rate"`  // 百分比
+	PrimaryAvgDuration float64 `json:"primary_avg_duration"` // 毫秒
+	ShadowAvgDuration  float64 `json:"shadow_avg_duration"`  // 毫秒
+	PrimaryAvgTokens   float64 `json:"primary_avg_tokens"`
+	ShadowAvgTokens    float64 `json:"shadow_avg_tokens"`
+}
+
+// GetShadowComparisons 获取影子请求及其原请求的日志对比，primaryGroup为空时不限制原请求分组，按时间倒序
+func (d *Database) GetShadowComparisons(primaryGroup string, limit int) ([]*ShadowComparison, error) {
+	query := `
+	SELECT p.correlation_id, p.id, p.provider_group, p.model, p.status_code, p.duration, p.tokens_used, COALESCE(p.error, ''),
+		   s.id, s.provider_group, s.model, s.status_code, s.duration, s.tokens_used, COALESCE(s.error, ''), s.created_at
+	FROM request_logs s
+	JOIN request_logs p ON p.correlation_id = s.correlation_id AND p.is_shadow = FALSE
+	WHERE s.is_shadow = TRUE`
+	var args []interface{}
+	if primaryGroup != "" {
+		query += " AND p.provider_group = ?"
+		args = append(args, primaryGroup)
+	}
+	query += " ORDER BY s.created_at DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := d.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shadow comparisons: %w", err)
+	}
+	defer rows.Close()
+
+	comparisons := []*ShadowComparison{}
+	for rows.Next() {
+		c := &ShadowComparison{}
+		if err := rows.Scan(&c.CorrelationID,
+			&c.Primary.LogID, &c.Primary.ProviderGroup, &c.Primary.Model, &c.Primary.StatusCode,
+			&c.Primary.Duration, &c.Primary.TokensUsed, &c.Primary.Error,
+			&c.Shadow.LogID, &c.Shadow.ProviderGroup, &c.Shadow.Model, &c.Shadow.StatusCode,
+			&c.Shadow.Duration, &c.Shadow.TokensUsed, &c.Shadow.Error, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan shadow comparison: %w", err)
+		}
+		comparisons = append(comparisons, c)
+	}
+	return comparisons, rows.Err()
+}
+
+// GetShadowComparisons 获取影子请求及其原请求的日志对比
+func (r *RequestLogger) GetShadowComparisons(primaryGroup string, limit int) ([]*ShadowComparison, error) {
+	return r.db.GetShadowComparisons(primaryGroup, limit)
+}
+
+// SummarizeShadowComparisons 汇总原请求和影子请求的成功率、平均耗时和平均token数
+func SummarizeShadowComparisons(comparisons []*ShadowComparison) ShadowSummary {
+	summary := ShadowSummary{Pairs: len(comparisons)}
+	if len(comparisons) == 0 {
+		return summary
+	}
+
+	var primarySuccess, shadowSuccess int
+	for _, c := range comparisons {
+		if c.Primary.StatusCode == 200 {
+			primarySuccess++
+		}
+		if c.Shadow.StatusCode == 200 {
+			shadowSuccess++
+		}
+		summary.PrimaryAvgDuration += float64(c.Primary.Duration)
+		summary.ShadowAvgDuration += float64(c.Shadow.Duration)
+		summary.PrimaryAvgTokens += float64(c.Primary.TokensUsed)
+		summary.ShadowAvgTokens += float64(c.Shadow.TokensUsed)
+	}
+
+	n := float64(len(comparisons))
+	summary.PrimarySuccessRate = float64(primarySuccess) / n * 100
+	summary.ShadowSuccessRate = float64(shadowSuccess) / n * 100
+	summary.PrimaryAvgDuration /= n
+	summary.ShadowAvgDuration /= n
+	summary.PrimaryAvgTokens /= n
+	summary.ShadowAvgTokens /= n
+	return summary
+}
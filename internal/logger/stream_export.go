@@ -0,0 +1,161 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// exportBatchSize 流式导出时每批从数据库读取的日志条数
+const exportBatchSize = 500
+
+// logFilterConditions 根据筛选条件构建WHERE子句中的条件和参数
+func logFilterConditions(filter *LogFilter) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if filter == nil {
+		return conditions, args
+	}
+
+	if filter.ProxyKeyName != "" {
+		conditions = append(conditions, "proxy_key_name = ?")
+		args = append(args, filter.ProxyKeyName)
+	}
+	if filter.ProviderGroup != "" {
+		conditions = append(conditions, "provider_group = ?")
+		args = append(args, filter.ProviderGroup)
+	}
+	if filter.Model != "" {
+		conditions = append(conditions, "model = ?")
+		args = append(args, filter.Model)
+	}
+	conditions, args = appendProxyKeyIDsCondition(conditions, args, filter.ProxyKeyIDs)
+	if filter.Status == "200" {
+		conditions = append(conditions, "status_code = 200")
+	} else if filter.Status == "error" {
+		conditions = append(conditions, "status_code != 200")
+	}
+	if filter.Stream == "true" {
+		conditions = append(conditions, "is_stream = TRUE")
+	} else if filter.Stream == "false" {
+		conditions = append(conditions, "is_stream = FALSE")
+	}
+	if filter.StartTime != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.StartTime.Format("2006-01-02 15:04:05"))
+	}
+	if filter.EndTime != nil {
+		// SQLite中的时间带有小数秒和时区后缀，按下一秒取开区间才能包含结束时间所在的整秒
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.EndTime.Add(time.Second).Format("2006-01-02 15:04:05"))
+	}
+	return conditions, args
+}
+
+// StreamRequestLogsForExport 按ID倒序分批读取符合筛选条件的完整请求日志，逐条交给fn处理
+// 使用ID游标分页，内存占用与数据量无关；filter.Limit大于0时最多读取Limit条，fn返回错误时停止
+func (d *Database) StreamRequestLogsForExport(filter *LogFilter, fn func(*RequestLog) error) error {
+	baseConditions, baseArgs := logFilterConditions(filter)
+	remaining := 0
+	if filter != nil && filter.Limit > 0 {
+		remaining = filter.Limit
+	}
+
+	var cursor int64
+	for {
+		batchSize := exportBatchSize
+		if filter != nil && filter.Limit > 0 && remaining < batchSize {
+			batchSize = remaining
+		}
+
+		conditions := append([]string{}, baseConditions...)
+		args := append([]interface{}{}, baseArgs...)
+		if cursor > 0 {
+			conditions = append(conditions, "id < ?")
+			args = append(args, cursor)
+		}
+
+		query := `
+		SELECT id, proxy_key_name, proxy_key_id, provider_group, openrouter_key, model, request_body, COALESCE(response_body, ''),
+			   status_code, is_stream, duration, tokens_used, tokens_estimated, COALESCE(error, ''), client_ip, created_at,
+			   has_tool_calls, tool_calls_count, tool_names, upstream_headers, correlation_id, is_shadow, split_name, split_arm, request_id
+		FROM request_logs`
+		if len(conditions) > 0 {
+			query += " WHERE " + strings.Join(conditions, " AND ")
+		}
+		query += " ORDER BY id DESC LIMIT ?"
+		args = append(args, batchSize)
+
+		rows, err := d.query(query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to query request logs for streaming export: %w", err)
+		}
+
+		// 先读完整批再回调，避免写出响应较慢时长时间占用数据库连接
+		var batch []*RequestLog
+		for rows.Next() {
+			l := &RequestLog{}
+			if err := rows.Scan(
+				&l.ID, &l.ProxyKeyName, &l.ProxyKeyID, &l.ProviderGroup, &l.OpenRouterKey,
+				&l.Model, &l.RequestBody, &l.ResponseBody, &l.StatusCode, &l.IsStream,
+				&l.Duration, &l.TokensUsed, &l.TokensEstimated, &l.Error, &l.ClientIP, &l.CreatedAt,
+				&l.HasToolCalls, &l.ToolCallsCount, &l.ToolNames, &l.UpstreamHeaders,
+				&l.CorrelationID, &l.IsShadow, &l.SplitName, &l.SplitArm, &l.RequestID,
+			); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan request log for streaming export: %w", err)
+			}
+			batch = append(batch, l)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read request logs for streaming export: %w", err)
+		}
+
+		for _, l := range batch {
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+		if len(batch) < batchSize {
+			return nil
+		}
+		cursor = batch[len(batch)-1].ID
+		if filter != nil && filter.Limit > 0 {
+			remaining -= len(batch)
+			if remaining <= 0 {
+				return nil
+			}
+		}
+	}
+}
+
+// StreamRequestLogsForExport 分批流式读取符合筛选条件的完整请求日志
+func (r *RequestLogger) StreamRequestLogsForExport(filter *LogFilter, fn func(*RequestLog) error) error {
+	return r.db.StreamRequestLogsForExport(filter, fn)
+}
+
+// ExportCSVHeader 请求日志CSV导出的表头，只包含摘要字段
+var ExportCSVHeader = []string{
+	"ID", "代理密钥名称", "代理密钥ID", "提供商分组", "OpenRouter密钥", "模型",
+	"状态码", "是否流式", "响应时间(ms)", "Token使用量", "错误信息", "创建时间",
+}
+
+// ExportCSVRecord 返回请求日志在CSV导出中的一行，与 ExportCSVHeader 对应
+func ExportCSVRecord(l *RequestLog) []string {
+	return []string{
+		fmt.Sprintf("%d", l.ID),
+		l.ProxyKeyName,
+		l.ProxyKeyID,
+		l.ProviderGroup,
+		l.OpenRouterKey,
+		l.Model,
+		fmt.Sprintf("%d", l.StatusCode),
+		fmt.Sprintf("%t", l.IsStream),
+		fmt.Sprintf("%d", l.Duration),
+		fmt.Sprintf("%d", l.TokensUsed),
+		l.Error,
+		l.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
@@ -0,0 +1,358 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// StreamTruncatedMarker 流式响应日志中间部分被省略时写入的SSE注释行
+const StreamTruncatedMarker = ": turnsapi-truncated"
+
+// StreamTranscript 由流式响应日志中的SSE数据重组出的完整助手消息
+type StreamTranscript struct {
+	ID           string                 `json:"id,omitempty"`
+	Model        string                 `json:"model,omitempty"`
+	Format       string                 `json:"format"` // openai、anthropic 或 gemini
+	Content      string                 `json:"content"`
+	Reasoning    string                 `json:"reasoning,omitempty"`
+	ToolCalls    []TranscriptToolCall   `json:"tool_calls,omitempty"`
+	FinishReason string                 `json:"finish_reason,omitempty"`
+	Usage        map[string]interface{} `json:"usage,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+	Chunks       int                    `json:"chunks"`    // 解析的数据块数
+	Truncated    bool                   `json:"truncated"` // 日志只保存了部分数据块，内容不完整
+}
+
+// TranscriptToolCall 重组出的工具调用
+type TranscriptToolCall struct {
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// Text 以纯文本形式输出重组的消息
+func (t *StreamTranscript) Text() string {
+	var b strings.Builder
+	if t.Reasoning != "" {
+		b.WriteString("[reasoning]\n")
+		b.WriteString(t.Reasoning)
+		b.WriteString("\n\n")
+	}
+	b.WriteString(t.Content)
+	for _, call := range t.ToolCalls {
+		fmt.Fprintf(&b, "\n\n[tool_call] %s(%s)", call.Name, call.Arguments)
+	}
+	if t.Error != "" {
+		b.WriteString("\n\n[error] " + t.Error)
+	}
+	if t.Truncated {
+		b.WriteString("\n\n[truncated]")
+	}
+	return b.String()
+}
+
+// ReconstructStream 解析流式响应日志中的 data: 行，按OpenAI、Anthropic或Gemini格式拼接出完整的助手消息
+func ReconstructStream(body string) *StreamTranscript {
+	transcript := &StreamTranscript{Format: "openai"}
+
+	var events []string
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, StreamTruncatedMarker) {
+			transcript.Truncated = true
+			continue
+		}
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		events = append(events, strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+	}
+	events = dropRepeatedTail(events)
+
+	builder := newTranscriptBuilder(transcript)
+	for _, data := range events {
+		if data == "" || data == "[DONE]" {
+			continue
+		}
+		var chunk map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		transcript.Chunks++
+		builder.add(chunk)
+	}
+	return transcript
+}
+
+// ReconstructResponse 从请求日志的响应内容重组助手消息，流式响应解析SSE数据，普通响应按单个JSON对象解析
+func ReconstructResponse(body string, isStream bool) *StreamTranscript {
+	if isStream {
+		return ReconstructStream(body)
+	}
+
+	transcript := &StreamTranscript{Format: "openai"}
+	var response map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		return transcript
+	}
+	transcript.Chunks = 1
+	newTranscriptBuilder(transcript).add(response)
+	return transcript
+}
+
+// dropRepeatedTail 去掉旧版本日志在末尾重复追加的数据块：
+// 旧版本在前5000字节之后又追加了最后10个数据块，短响应的末尾因此会完整重复一遍
+func dropRepeatedTail(events []string) []string {
+	k := len(events) / 2
+	if k > 10 {
+		k = 10
+	}
+	if k == 0 || (len(events) < 20 && len(events) != 2*k) {
+		return events
+	}
+	n := len(events)
+	for i := 0; i < k; i++ {
+		if events[n-k+i] != events[n-2*k+i] {
+			return events
+		}
+	}
+	return events[:n-k]
+}
+
+// transcriptBuilder 逐块累积重组结果
+type transcriptBuilder struct {
+	transcript *StreamTranscript
+	toolIndex  map[int]int // 数据块中的工具调用序号 -> ToolCalls 下标
+	content    strings.Builder
+	reasoning  strings.Builder
+}
+
+// newTranscriptBuilder 创建重组结果累积器
+func newTranscriptBuilder(transcript *StreamTranscript) *transcriptBuilder {
+	return &transcriptBuilder{transcript: transcript, toolIndex: make(map[int]int)}
+}
+
+// add 按数据块格式累积内容
+func (b *transcriptBuilder) add(chunk map[string]interface{}) {
+	t := b.transcript
+	if errValue, ok := chunk["error"]; ok {
+		if errMap, ok := errValue.(map[string]interface{}); ok {
+			t.Error, _ = errMap["message"].(string)
+		} else {
+			t.Error = fmt.Sprint(errValue)
+		}
+	}
+
+	switch {
+	case chunk["choices"] != nil:
+		b.addOpenAI(chunk)
+	case chunk["candidates"] != nil || chunk["usageMetadata"] != nil:
+		b.addGemini(chunk)
+	case chunk["type"] != nil:
+		b.addAnthropic(chunk)
+	}
+	t.Content = b.content.String()
+	t.Reasoning = b.reasoning.String()
+}
+
+// addOpenAI 处理OpenAI格式的数据块
+func (b *transcriptBuilder) addOpenAI(chunk map[string]interface{}) {
+	t := b.transcript
+	t.Format = "openai"
+	if id, ok := chunk["id"].(string); ok && id != "" {
+		t.ID = id
+	}
+	if model, ok := chunk["model"].(string); ok && model != "" {
+		t.Model = model
+	}
+	if usage, ok := chunk["usage"].(map[string]interface{}); ok {
+		t.Usage = usage
+	}
+
+	choices, _ := chunk["choices"].([]interface{})
+	for _, choice := range choices {
+		choiceMap, ok := choice.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if reason, ok := choiceMap["finish_reason"].(string); ok && reason != "" {
+			t.FinishReason = reason
+		}
+		delta, ok := choiceMap["delta"].(map[string]interface{})
+		if !ok {
+			delta, ok = choiceMap["message"].(map[string]interface{})
+		}
+		if !ok {
+			continue
+		}
+		if content, ok := delta["content"].(string); ok {
+			b.content.WriteString(content)
+		}
+		for _, field := range []string{"reasoning_content", "reasoning"} {
+			if reasoning, ok := delta[field].(string); ok {
+				b.reasoning.WriteString(reasoning)
+			}
+		}
+
+		toolCalls, _ := delta["tool_calls"].([]interface{})
+		for i, toolCall := range toolCalls {
+			tcMap, ok := toolCall.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			index := i
+			if v, ok := tcMap["index"].(float64); ok {
+				index = int(v)
+			}
+			call := b.toolCall(index)
+			if id, ok := tcMap["id"].(string); ok && id != "" {
+				call.ID = id
+			}
+			if function, ok := tcMap["function"].(map[string]interface{}); ok {
+				if name, ok := function["name"].(string); ok && name != "" {
+					call.Name = name
+				}
+				if args, ok := function["arguments"].(string); ok {
+					call.Arguments += args
+				}
+			}
+		}
+	}
+}
+
+// addAnthropic 处理Anthropic原生格式的数据块
+func (b *transcriptBuilder) addAnthropic(chunk map[string]interface{}) {
+	t := b.transcript
+	t.Format = "anthropic"
+	index := 0
+	if v, ok := chunk["index"].(float64); ok {
+		index = int(v)
+	}
+
+	switch chunk["type"] {
+	case "message":
+		// 非流式响应
+		t.ID, _ = chunk["id"].(string)
+		t.Model, _ = chunk["model"].(string)
+		t.FinishReason, _ = chunk["stop_reason"].(string)
+		if usage, ok := chunk["usage"].(map[string]interface{}); ok {
+			t.Usage = usage
+		}
+		blocks, _ := chunk["content"].([]interface{})
+		for i, block := range blocks {
+			blockMap, ok := block.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch blockMap["type"] {
+			case "text":
+				text, _ := blockMap["text"].(string)
+				b.content.WriteString(text)
+			case "thinking":
+				thinking, _ := blockMap["thinking"].(string)
+				b.reasoning.WriteString(thinking)
+			case "tool_use":
+				call := b.toolCall(i)
+				call.ID, _ = blockMap["id"].(string)
+				call.Name, _ = blockMap["name"].(string)
+				input, _ := json.Marshal(blockMap["input"])
+				call.Arguments = string(input)
+			}
+		}
+	case "message_start":
+		if message, ok := chunk["message"].(map[string]interface{}); ok {
+			t.ID, _ = message["id"].(string)
+			t.Model, _ = message["model"].(string)
+			if usage, ok := message["usage"].(map[string]interface{}); ok {
+				t.Usage = usage
+			}
+		}
+	case "content_block_start":
+		block, _ := chunk["content_block"].(map[string]interface{})
+		if block["type"] == "tool_use" {
+			call := b.toolCall(index)
+			call.ID, _ = block["id"].(string)
+			call.Name, _ = block["name"].(string)
+		}
+	case "content_block_delta":
+		delta, _ := chunk["delta"].(map[string]interface{})
+		switch delta["type"] {
+		case "text_delta":
+			text, _ := delta["text"].(string)
+			b.content.WriteString(text)
+		case "thinking_delta":
+			thinking, _ := delta["thinking"].(string)
+			b.reasoning.WriteString(thinking)
+		case "input_json_delta":
+			partial, _ := delta["partial_json"].(string)
+			b.toolCall(index).Arguments += partial
+		}
+	case "message_delta":
+		if delta, ok := chunk["delta"].(map[string]interface{}); ok {
+			if reason, ok := delta["stop_reason"].(string); ok && reason != "" {
+				t.FinishReason = reason
+			}
+		}
+		if usage, ok := chunk["usage"].(map[string]interface{}); ok {
+			if t.Usage == nil {
+				t.Usage = make(map[string]interface{})
+			}
+			for key, value := range usage {
+				t.Usage[key] = value
+			}
+		}
+	}
+}
+
+// addGemini 处理Gemini原生格式的数据块
+func (b *transcriptBuilder) addGemini(chunk map[string]interface{}) {
+	t := b.transcript
+	t.Format = "gemini"
+	if model, ok := chunk["modelVersion"].(string); ok && model != "" {
+		t.Model = model
+	}
+	if usage, ok := chunk["usageMetadata"].(map[string]interface{}); ok {
+		t.Usage = usage
+	}
+
+	candidates, _ := chunk["candidates"].([]interface{})
+	if len(candidates) == 0 {
+		return
+	}
+	candidate, _ := candidates[0].(map[string]interface{})
+	if reason, ok := candidate["finishReason"].(string); ok && reason != "" {
+		t.FinishReason = reason
+	}
+	content, _ := candidate["content"].(map[string]interface{})
+	parts, _ := content["parts"].([]interface{})
+	for _, part := range parts {
+		partMap, ok := part.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		text, _ := partMap["text"].(string)
+		if thought, _ := partMap["thought"].(bool); thought {
+			b.reasoning.WriteString(text)
+		} else {
+			b.content.WriteString(text)
+		}
+		if call, ok := partMap["functionCall"].(map[string]interface{}); ok {
+			name, _ := call["name"].(string)
+			args, _ := json.Marshal(call["args"])
+			t.ToolCalls = append(t.ToolCalls, TranscriptToolCall{Name: name, Arguments: string(args)})
+		}
+	}
+}
+
+// toolCall 获取指定序号的工具调用，不存在时创建
+func (b *transcriptBuilder) toolCall(index int) *TranscriptToolCall {
+	t := b.transcript
+	pos, exists := b.toolIndex[index]
+	if !exists {
+		t.ToolCalls = append(t.ToolCalls, TranscriptToolCall{})
+		pos = len(t.ToolCalls) - 1
+		b.toolIndex[index] = pos
+	}
+	return &t.ToolCalls[pos]
+}
@@ -0,0 +1,196 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// tenantColumns 租户查询的列
+const tenantColumns = `id, name, description, is_active, monthly_request_limit, monthly_token_limit, created_at, updated_at`
+
+// TenantUsage 租户在一段时间内的用量
+type TenantUsage struct {
+	Requests int64 `json:"requests"`
+	Tokens   int64 `json:"tokens"`
+}
+
+// nullableTenantID 将空的租户ID存储为NULL
+func nullableTenantID(id string) interface{} {
+	if id == "" {
+		return nil
+	}
+	return id
+}
+
+// appendProxyKeyIDsCondition 添加按代理密钥ID筛选的条件，ids为nil时不限制，空列表时不匹配任何日志
+func appendProxyKeyIDsCondition(conditions []string, args []interface{}, ids []string) ([]string, []interface{}) {
+	if ids == nil {
+		return conditions, args
+	}
+	if len(ids) == 0 {
+		return append(conditions, "1 = 0"), args
+	}
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+	return append(conditions, "proxy_key_id IN ("+strings.Join(placeholders, ", ")+")"), args
+}
+
+// InsertTenant 插入租户
+func (d *Database) InsertTenant(tenant *Tenant) error {
+	query := `
+	INSERT INTO tenants (` + tenantColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	if _, err := d.exec(query, tenant.ID, tenant.Name, tenant.Description, tenant.IsActive,
+		tenant.MonthlyRequestLimit, tenant.MonthlyTokenLimit, tenant.CreatedAt, tenant.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to insert tenant: %w", err)
+	}
+	return nil
+}
+
+// UpdateTenant 更新租户
+func (d *Database) UpdateTenant(tenant *Tenant) error {
+	query := `
+	UPDATE tenants SET name = ?, description = ?, is_active = ?, monthly_request_limit = ?,
+		monthly_token_limit = ?, updated_at = ?
+	WHERE id = ?
+	`
+
+	result, err := d.exec(query, tenant.Name, tenant.Description, tenant.IsActive, tenant.MonthlyRequestLimit,
+		tenant.MonthlyTokenLimit, tenant.UpdatedAt, tenant.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update tenant: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("tenant not found")
+	}
+	return nil
+}
+
+// GetAllTenants 获取所有租户
+func (d *Database) GetAllTenants() ([]*Tenant, error) {
+	rows, err := d.query(`SELECT ` + tenantColumns + ` FROM tenants ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tenants: %w", err)
+	}
+	defer rows.Close()
+
+	tenants := []*Tenant{}
+	for rows.Next() {
+		tenant := &Tenant{}
+		if err := rows.Scan(&tenant.ID, &tenant.Name, &tenant.Description, &tenant.IsActive,
+			&tenant.MonthlyRequestLimit, &tenant.MonthlyTokenLimit, &tenant.CreatedAt, &tenant.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tenant: %w", err)
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, nil
+}
+
+// DeleteTenant 删除租户，原本属于该租户的代理密钥不再属于任何租户
+func (d *Database) DeleteTenant(id string) error {
+	result, err := d.exec(`DELETE FROM tenants WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete tenant: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("tenant not found")
+	}
+	if _, err := d.exec(`UPDATE proxy_keys SET tenant_id = NULL WHERE tenant_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to detach proxy keys from tenant: %w", err)
+	}
+	return nil
+}
+
+// GetTenantUsageSince 按租户统计指定时间之后的请求数和token数，不包括影子流量
+// 按代理密钥当前所属的租户汇总
+func (d *Database) GetTenantUsageSince(since time.Time) (map[string]*TenantUsage, error) {
+	query := `
+	SELECT pk.tenant_id, COUNT(*), COALESCE(SUM(rl.tokens_used), 0)
+	FROM request_logs rl
+	JOIN proxy_keys pk ON pk.id = rl.proxy_key_id
+	WHERE pk.tenant_id IS NOT NULL AND rl.is_shadow = FALSE AND rl.created_at >= ?
+	GROUP BY pk.tenant_id
+	`
+
+	rows, err := d.query(query, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tenant usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := make(map[string]*TenantUsage)
+	for rows.Next() {
+		var tenantID string
+		u := &TenantUsage{}
+		if err := rows.Scan(&tenantID, &u.Requests, &u.Tokens); err != nil {
+			return nil, fmt.Errorf("failed to scan tenant usage: %w", err)
+		}
+		usage[tenantID] = u
+	}
+	return usage, nil
+}
+
+// GetProxyKeyStatsWithFilter 基于筛选与时间范围的代理密钥统计
+func (d *Database) GetProxyKeyStatsWithFilter(filter *LogFilter) ([]*ProxyKeyStats, error) {
+	conditions, args := logFilterConditions(filter)
+	query := `
+	SELECT
+		proxy_key_name,
+		proxy_key_id,
+		COUNT(*) as total_requests,
+		SUM(CASE WHEN status_code = 200 THEN 1 ELSE 0 END) as success_requests,
+		SUM(CASE WHEN status_code != 200 THEN 1 ELSE 0 END) as error_requests,
+		COALESCE(SUM(tokens_used), 0) as total_tokens,
+		AVG(duration) as avg_duration
+	FROM request_logs`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " GROUP BY proxy_key_name, proxy_key_id ORDER BY total_requests DESC"
+
+	rows, err := d.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query proxy key stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []*ProxyKeyStats{}
+	for rows.Next() {
+		stat := &ProxyKeyStats{}
+		if err := rows.Scan(&stat.ProxyKeyName, &stat.ProxyKeyID, &stat.TotalRequests, &stat.SuccessRequests,
+			&stat.ErrorRequests, &stat.TotalTokens, &stat.AvgDuration); err != nil {
+			return nil, fmt.Errorf("failed to scan proxy key stats: %w", err)
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// GetTotalTokensStatsWithFilter 基于筛选与时间范围的总token数统计
+func (d *Database) GetTotalTokensStatsWithFilter(filter *LogFilter) (*TotalTokensStats, error) {
+	conditions, args := logFilterConditions(filter)
+	query := `
+	SELECT
+		COALESCE(SUM(tokens_used), 0) as total_tokens,
+		COALESCE(SUM(CASE WHEN status_code = 200 THEN tokens_used ELSE 0 END), 0) as success_tokens,
+		COUNT(*) as total_requests,
+		COALESCE(SUM(CASE WHEN status_code = 200 THEN 1 ELSE 0 END), 0) as success_requests
+	FROM request_logs`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	stats := &TotalTokensStats{}
+	if err := d.queryRow(query, args...).Scan(
+		&stats.TotalTokens, &stats.SuccessTokens, &stats.TotalRequests, &stats.SuccessRequests,
+	); err != nil {
+		return nil, fmt.Errorf("failed to query total tokens stats: %w", err)
+	}
+	return stats, nil
+}
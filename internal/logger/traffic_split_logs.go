@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"fmt"
+	"time"
+)
+
+// TrafficSplitArmStats A/B分流中一个分支的请求统计
+type TrafficSplitArmStats struct {
+	SplitName   string  `json:"split_name"`
+	Arm         string  `json:"arm"`      // 分配的分支分组
+	Attempts    int64   `json:"attempts"` // 分配到该分支的请求尝试数，包括故障转移的失败尝试
+	Successes   int64   `json:"successes"`
+	Fallbacks   int64   `json:"fallbacks"`    // 故障转移到其他分组后成功的请求数
+	SuccessRate float64 `json:"success_rate"` // 百分比
+	AvgDuration float64 `json:"avg_duration"` // 成功请求的平均耗时（毫秒）
+	AvgTokens   float64 `json:"avg_tokens"`   // 成功请求的平均token数
+	TotalTokens int64   `json:"total_tokens"`
+	ErrorCount  int64   `json:"error_count"`
+}
+
+// GetTrafficSplitStats 按分流名称和分支统计请求，splitName为空时统计全部分流，since为零值时不限制时间
+func (d *Database) GetTrafficSplitStats(splitName string, since time.Time) ([]*TrafficSplitArmStats, error) {
+	query := `
+	SELECT split_name, split_arm, COUNT(*),
+		   COALESCE(SUM(CASE WHEN status_code = 200 THEN 1 ELSE 0 END), 0),
+		   COALESCE(SUM(CASE WHEN status_code = 200 AND provider_group <> split_arm THEN 1 ELSE 0 END), 0),
+		   COALESCE(AVG(CASE WHEN status_code = 200 THEN duration END), 0),
+		   COALESCE(AVG(CASE WHEN status_code = 200 THEN tokens_used END), 0),
+		   COALESCE(SUM(tokens_used), 0)
+	FROM request_logs
+	WHERE split_name <> '' AND is_shadow = FALSE`
+	var args []interface{}
+	if splitName != "" {
+		query += " AND split_name = ?"
+		args = append(args, splitName)
+	}
+	if !since.IsZero() {
+		query += " AND created_at >= ?"
+		args = append(args, since)
+	}
+	query += " GROUP BY split_name, split_arm ORDER BY split_name, split_arm"
+
+	rows, err := d.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query traffic split stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []*TrafficSplitArmStats{}
+	for rows.Next() {
+		s := &TrafficSplitArmStats{}
+		if err := rows.Scan(&s.SplitName, &s.Arm, &s.Attempts, &s.Successes, &s.Fallbacks,
+			&s.AvgDuration, &s.AvgTokens, &s.TotalTokens); err != nil {
+			return nil, fmt.Errorf("failed to scan traffic split stats: %w", err)
+		}
+		s.ErrorCount = s.Attempts - s.Successes
+		if s.Attempts > 0 {
+			s.SuccessRate = float64(s.Successes) / float64(s.Attempts) * 100
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// GetTrafficSplitStats 按分流名称和分支统计请求
+func (r *RequestLogger) GetTrafficSplitStats(splitName string, since time.Time) ([]*TrafficSplitArmStats, error) {
+	return r.db.GetTrafficSplitStats(splitName, since)
+}
@@ -0,0 +1,190 @@
+package logger
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// 用量汇总报告周期
+const (
+	UsageReportDaily  = "daily"
+	UsageReportWeekly = "weekly"
+)
+
+// UsageBreakdown 时间段内某个分组、代理密钥和模型组合的请求统计
+type UsageBreakdown struct {
+	ProviderGroup string `json:"provider_group"`
+	ProxyKeyName  string `json:"proxy_key_name"`
+	Model         string `json:"model"`
+	Requests      int64  `json:"requests"`
+	Errors        int64  `json:"errors"`
+	Tokens        int64  `json:"tokens"`
+}
+
+// UsageReport 定时生成的用量汇总报告
+type UsageReport struct {
+	ID          int64           `json:"id"`
+	Period      string          `json:"period"` // daily 或 weekly
+	PeriodStart time.Time       `json:"period_start"`
+	PeriodEnd   time.Time       `json:"period_end"` // 不含
+	Requests    int64           `json:"requests"`
+	Errors      int64           `json:"errors"`
+	Tokens      int64           `json:"tokens"`
+	Cost        float64         `json:"cost"`    // 按 model_pricing 估算的费用（美元）
+	Details     json.RawMessage `json:"details"` // 按分组、代理密钥和模型的明细
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// usageReportColumns 用量报告查询的列
+const usageReportColumns = `id, period, period_start, period_end, requests, errors, tokens, cost, details, created_at`
+
+// AggregateUsage 按分组、代理密钥和模型统计 [start, end) 内的请求，不含影子请求
+func (d *Database) AggregateUsage(start, end time.Time) ([]*UsageBreakdown, error) {
+	query := `
+	SELECT provider_group, proxy_key_name, model, COUNT(*),
+		   COALESCE(SUM(CASE WHEN status_code = 200 THEN 0 ELSE 1 END), 0),
+		   COALESCE(SUM(tokens_used), 0)
+	FROM request_logs
+	WHERE created_at >= ? AND created_at < ? AND is_shadow = FALSE
+	GROUP BY provider_group, proxy_key_name, model
+	ORDER BY provider_group, proxy_key_name, model`
+
+	rows, err := d.query(query, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate usage: %w", err)
+	}
+	defer rows.Close()
+
+	breakdown := []*UsageBreakdown{}
+	for rows.Next() {
+		b := &UsageBreakdown{}
+		if err := rows.Scan(&b.ProviderGroup, &b.ProxyKeyName, &b.Model, &b.Requests, &b.Errors, &b.Tokens); err != nil {
+			return nil, fmt.Errorf("failed to scan usage breakdown: %w", err)
+		}
+		breakdown = append(breakdown, b)
+	}
+	return breakdown, rows.Err()
+}
+
+// SaveUsageReport 保存用量报告，同一周期和起始时间的报告已存在时替换
+func (d *Database) SaveUsageReport(report *UsageReport) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(d.dialect.rebind(`DELETE FROM usage_reports WHERE period = ? AND period_start = ?`),
+		report.Period, report.PeriodStart); err != nil {
+		return fmt.Errorf("failed to replace usage report: %w", err)
+	}
+
+	details := string(report.Details)
+	if details == "" {
+		details = "{}"
+	}
+	id, err := d.insertReturningID(tx, `
+	INSERT INTO usage_reports (period, period_start, period_end, requests, errors, tokens, cost, details, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		report.Period, report.PeriodStart, report.PeriodEnd, report.Requests, report.Errors, report.Tokens,
+		report.Cost, details, report.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert usage report: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit usage report: %w", err)
+	}
+	report.ID = id
+	return nil
+}
+
+// HasUsageReport 检查指定周期和起始时间的报告是否已生成
+func (d *Database) HasUsageReport(period string, start time.Time) (bool, error) {
+	var count int
+	err := d.queryRow(`SELECT COUNT(*) FROM usage_reports WHERE period = ? AND period_start = ?`, period, start).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check usage report: %w", err)
+	}
+	return count > 0, nil
+}
+
+// GetUsageReports 按起始时间倒序获取用量报告，period为空时返回所有周期
+func (d *Database) GetUsageReports(period string, limit int) ([]*UsageReport, error) {
+	query := `SELECT ` + usageReportColumns + ` FROM usage_reports`
+	var args []interface{}
+	if period != "" {
+		query += ` WHERE period = ?`
+		args = append(args, period)
+	}
+	query += ` ORDER BY period_start DESC, id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := d.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage reports: %w", err)
+	}
+	defer rows.Close()
+
+	reports := []*UsageReport{}
+	for rows.Next() {
+		report, err := scanUsageReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// GetUsageReport 按ID获取用量报告
+func (d *Database) GetUsageReport(id int64) (*UsageReport, error) {
+	report, err := scanUsageReport(d.queryRow(`SELECT `+usageReportColumns+` FROM usage_reports WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("usage report not found")
+	}
+	return report, err
+}
+
+// scanUsageReport 扫描一行用量报告
+func scanUsageReport(row interface {
+	Scan(dest ...interface{}) error
+}) (*UsageReport, error) {
+	report := &UsageReport{}
+	var details string
+	if err := row.Scan(&report.ID, &report.Period, &report.PeriodStart, &report.PeriodEnd, &report.Requests,
+		&report.Errors, &report.Tokens, &report.Cost, &details, &report.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan usage report: %w", err)
+	}
+	report.Details = json.RawMessage(details)
+	return report, nil
+}
+
+// AggregateUsage 按分组、代理密钥和模型统计时间段内的请求
+func (r *RequestLogger) AggregateUsage(start, end time.Time) ([]*UsageBreakdown, error) {
+	return r.db.AggregateUsage(start, end)
+}
+
+// SaveUsageReport 保存用量报告
+func (r *RequestLogger) SaveUsageReport(report *UsageReport) error {
+	return r.db.SaveUsageReport(report)
+}
+
+// HasUsageReport 检查报告是否已生成
+func (r *RequestLogger) HasUsageReport(period string, start time.Time) (bool, error) {
+	return r.db.HasUsageReport(period, start)
+}
+
+// GetUsageReports 获取用量报告列表
+func (r *RequestLogger) GetUsageReports(period string, limit int) ([]*UsageReport, error) {
+	return r.db.GetUsageReports(period, limit)
+}
+
+// GetUsageReport 按ID获取用量报告
+func (r *RequestLogger) GetUsageReport(id int64) (*UsageReport, error) {
+	return r.db.GetUsageReport(id)
+}
@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File 以追加方式写入的日志文件，Reopen 按原路径重新打开
+// 配合 logrotate 等外部工具轮转：移走旧文件后发送 SIGHUP，之后的日志写入新文件
+type File struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFile 打开日志文件，目录不存在时自动创建
+func OpenFile(path string) (*File, error) {
+	f := &File{path: path}
+	if err := f.Reopen(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Path 返回日志文件路径
+func (f *File) Path() string {
+	return f.path
+}
+
+// Write 写入日志，与 Reopen 并发调用时写入旧文件或新文件之一
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Write(p)
+}
+
+// Reopen 关闭当前文件并按原路径重新打开，打开失败时继续写入当前文件
+func (f *File) Reopen() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	f.mu.Lock()
+	previous := f.file
+	f.file = file
+	f.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// Close 关闭日志文件
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,172 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Options 应用日志设置
+type Options struct {
+	Level  string // debug、info、warn、error，默认 info
+	Format string // text 或 json，默认 text
+}
+
+// ParseLevel 解析日志级别名称，空字符串为 info
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level: %s", level)
+	}
+}
+
+// ValidateFormat 校验日志输出格式
+func ValidateFormat(format string) error {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "text", "json":
+		return nil
+	default:
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+}
+
+// Setup 初始化全局结构化日志，slog 默认日志和标准库 log 的输出都写入同一处理器并按级别过滤
+func Setup(opts Options, w io.Writer) error {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+	if err := ValidateFormat(opts.Format); err != nil {
+		return err
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if strings.EqualFold(strings.TrimSpace(opts.Format), "json") {
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	} else {
+		handler = slog.NewTextHandler(w, handlerOpts)
+	}
+	handler = contextHandler{handler}
+
+	slog.SetDefault(slog.New(handler))
+	// SetDefault 会把标准库 log 的输出固定为 info 级别，这里改为按消息内容推断级别
+	log.SetFlags(0)
+	log.SetOutput(&legacyWriter{handler: handler})
+	return nil
+}
+
+// requestIDKey 请求ID在 context 中的键
+type requestIDKey struct{}
+
+// WithRequestID 将请求ID写入 context，之后使用该 context 的结构化日志自动带上 request_id
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID 从 context 获取请求ID
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// contextHandler 为日志记录补充 context 中的请求ID
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle 实现 slog.Handler
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if requestID := RequestID(ctx); requestID != "" {
+		r.AddAttrs(slog.String("request_id", requestID))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs 实现 slog.Handler
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup 实现 slog.Handler
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// legacyWriter 将标准库 log 的输出转换为结构化日志记录
+type legacyWriter struct {
+	handler slog.Handler
+}
+
+// Write 实现 io.Writer，每次调用对应一条 log.Printf 输出
+func (w *legacyWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	level := inferLevel(msg)
+	if !w.handler.Enabled(context.Background(), level) {
+		return len(p), nil
+	}
+
+	r := slog.NewRecord(time.Now(), level, stripEmoji(msg), 0)
+	if err := w.handler.Handle(context.Background(), r); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// legacyLevelKeywords 按消息关键字推断标准库 log 输出的级别，按顺序匹配
+var legacyLevelKeywords = []struct {
+	level    slog.Level
+	keywords []string
+}{
+	{slog.LevelError, []string{"❌", "💥", "错误", "失败", "panic", "Failed", "failed", "Error", "error"}},
+	{slog.LevelWarn, []string{"⚠", "警告", "Warning", "warning"}},
+	{slog.LevelDebug, []string{"🔍"}},
+}
+
+// requestDebugPrefix 请求级调试（X-TurnsAPI-Debug）输出的前缀，这些是显式请求的追踪，不受日志级别过滤
+const requestDebugPrefix = "[DEBUG "
+
+// inferLevel 推断标准库 log 输出的级别，没有匹配的关键字时为 info
+func inferLevel(msg string) slog.Level {
+	if strings.HasPrefix(msg, requestDebugPrefix) {
+		return slog.LevelInfo
+	}
+	// 带有警告前缀的消息即使包含“失败”也只是警告
+	if strings.HasPrefix(msg, "警告") || strings.HasPrefix(msg, "⚠") || strings.HasPrefix(msg, "Warning") {
+		return slog.LevelWarn
+	}
+	for _, entry := range legacyLevelKeywords {
+		for _, keyword := range entry.keywords {
+			if strings.Contains(msg, keyword) {
+				return entry.level
+			}
+		}
+	}
+	return slog.LevelInfo
+}
+
+// stripEmoji 去除消息中的emoji和变体选择符
+func stripEmoji(msg string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 0x1F000 && r <= 0x1FAFF, r >= 0x2600 && r <= 0x27BF, r == 0xFE0F, r == 0x200D:
+			return -1
+		}
+		return r
+	}, msg))
+}
@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default 默认指标注册表，由 /metrics 端点输出
+var Default = NewRegistry()
+
+// Registry 指标注册表，按 Prometheus 文本格式输出
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// family 同名指标的所有序列
+type family struct {
+	name       string
+	help       string
+	kind       string // counter 或 gauge
+	labelNames []string
+
+	mu     sync.Mutex
+	series map[string]float64 // 标签值（以\xff连接） -> 值
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct {
+	f *family
+}
+
+// GaugeVec 带标签的仪表盘
+type GaugeVec struct {
+	f *family
+}
+
+// NewRegistry 创建指标注册表
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounterVec 注册计数器
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{f: r.register(name, help, "counter", labelNames)}
+}
+
+// NewGaugeVec 注册仪表盘
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{f: r.register(name, help, "gauge", labelNames)}
+}
+
+// register 注册指标，名称重复时panic，指标应在包初始化时注册
+func (r *Registry) register(name, help, kind string, labelNames []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, f := range r.families {
+		if f.name == name {
+			panic(fmt.Sprintf("metric %s already registered", name))
+		}
+	}
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		series:     make(map[string]float64),
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+// Inc 计数器加1
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add 计数器增加指定值
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	key := c.f.key(labelValues)
+	c.f.mu.Lock()
+	c.f.series[key] += delta
+	c.f.mu.Unlock()
+}
+
+// Set 设置仪表盘的值
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	key := g.f.key(labelValues)
+	g.f.mu.Lock()
+	g.f.series[key] = value
+	g.f.mu.Unlock()
+}
+
+// Delete 删除仪表盘序列，用于分组删除等场景
+func (g *GaugeVec) Delete(labelValues ...string) {
+	key := g.f.key(labelValues)
+	g.f.mu.Lock()
+	delete(g.f.series, key)
+	g.f.mu.Unlock()
+}
+
+// key 生成序列键，标签值数量与标签名不一致时panic
+func (f *family) key(labelValues []string) string {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// WriteText 按 Prometheus 文本格式输出所有指标
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	for _, f := range families {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind); err != nil {
+			return err
+		}
+
+		f.mu.Lock()
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		lines := make([]string, 0, len(keys))
+		for _, key := range keys {
+			lines = append(lines, f.name+f.formatLabels(key)+" "+strconv.FormatFloat(f.series[key], 'g', -1, 64))
+		}
+		f.mu.Unlock()
+
+		for _, line := range lines {
+			if _, err := io.WriteString(w, line+"\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// formatLabels 将序列键格式化为 {name="value",...}
+func (f *family) formatLabels(key string) string {
+	if len(f.labelNames) == 0 {
+		return ""
+	}
+	values := strings.Split(key, "\xff")
+	pairs := make([]string, len(f.labelNames))
+	for i, name := range f.labelNames {
+		pairs[i] = name + `="` + escapeLabelValue(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeLabelValue 转义标签值中的反斜杠、双引号和换行
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// escapeHelp 转义帮助文本中的反斜杠和换行
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// 模型名称解析来源
+const (
+	ModelSourceMapping = "mapping" // 别名精确映射
+	ModelSourceRewrite = "rewrite" // 正则重写规则
+)
+
+// ModelRewriteRule 模型名称正则重写规则，按配置顺序匹配，第一条匹配的规则生效
+type ModelRewriteRule struct {
+	Pattern     string `yaml:"pattern" json:"pattern"`         // 匹配请求模型名称的正则表达式，需要整体匹配时请使用 ^ 和 $
+	Replacement string `yaml:"replacement" json:"replacement"` // 重写后的完整模型名称，可使用 $1、${name} 引用捕获组
+}
+
+// ModelResolution 模型名称在分组中的解析结果
+type ModelResolution struct {
+	Model     string `json:"model"`
+	Source    string `json:"source,omitempty"` // mapping、rewrite，为空表示未改写
+	RuleIndex int    `json:"rule_index"`       // 命中的重写规则下标，未命中为-1
+}
+
+// compiledRewrite 编译后的重写规则正则，编译失败时记录错误避免重复编译
+type compiledRewrite struct {
+	re  *regexp.Regexp
+	err error
+}
+
+// modelRewriteCache 正则表达式编译缓存：pattern -> *compiledRewrite
+var modelRewriteCache sync.Map
+
+// compileModelRewrite 编译重写规则的正则表达式，结果按表达式缓存
+func compileModelRewrite(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := modelRewriteCache.Load(pattern); ok {
+		compiled := cached.(*compiledRewrite)
+		return compiled.re, compiled.err
+	}
+	re, err := regexp.Compile(pattern)
+	modelRewriteCache.Store(pattern, &compiledRewrite{re: re, err: err})
+	return re, err
+}
+
+// ValidateModelRewrites 校验重写规则的表达式和替换内容
+func ValidateModelRewrites(rules []ModelRewriteRule) error {
+	for i, rule := range rules {
+		if rule.Pattern == "" {
+			return fmt.Errorf("model_rewrites[%d]: pattern is required", i)
+		}
+		if rule.Replacement == "" {
+			return fmt.Errorf("model_rewrites[%d]: replacement is required", i)
+		}
+		if _, err := compileModelRewrite(rule.Pattern); err != nil {
+			return fmt.Errorf("model_rewrites[%d]: invalid pattern %q: %w", i, rule.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// RewriteModel 按顺序应用重写规则，返回重写后的模型名称和命中的规则下标，未命中时返回原名称和-1
+func (g *UserGroup) RewriteModel(modelName string) (string, int) {
+	for i, rule := range g.ModelRewrites {
+		re, err := compileModelRewrite(rule.Pattern)
+		if err != nil {
+			continue
+		}
+		match := re.FindStringSubmatchIndex(modelName)
+		if match == nil {
+			continue
+		}
+		return string(re.ExpandString(nil, rule.Replacement, modelName, match)), i
+	}
+	return modelName, -1
+}
+
+// ResolveModel 解析请求的模型名称：先查别名精确映射，再按顺序应用重写规则
+func (g *UserGroup) ResolveModel(modelName string) ModelResolution {
+	if actualModel, exists := g.ModelMappings[modelName]; exists {
+		return ModelResolution{Model: actualModel, Source: ModelSourceMapping, RuleIndex: -1}
+	}
+	if rewritten, index := g.RewriteModel(modelName); index >= 0 {
+		return ModelResolution{Model: rewritten, Source: ModelSourceRewrite, RuleIndex: index}
+	}
+	return ModelResolution{Model: modelName, RuleIndex: -1}
+}
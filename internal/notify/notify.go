@@ -0,0 +1,185 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"turnsapi/internal"
+)
+
+// 通知级别
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+const (
+	defaultWebhookTimeout  = 10 * time.Second
+	maxRecentNotifications = 100 // 保留的最近通知数
+)
+
+// Notification 一条通知
+type Notification struct {
+	Source   string            `json:"source"`          // 通知来源，如 alert
+	Event    string            `json:"event,omitempty"` // 事件类型，如 firing、resolved
+	Severity string            `json:"severity"`
+	Title    string            `json:"title"`
+	Message  string            `json:"message"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Time     time.Time         `json:"time"`
+}
+
+// Delivery 通知的发送结果
+type Delivery struct {
+	Webhook string `json:"webhook"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Record 已发送的通知及各渠道的发送结果
+type Record struct {
+	Notification
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+// Notifier 通知分发器，通知写入日志并异步发送到配置的Webhook
+type Notifier struct {
+	config internal.ConfigSource
+	client *http.Client
+
+	mu     sync.Mutex
+	recent []*Record
+}
+
+// NewNotifier 创建通知分发器，每次发送时读取最新的通知渠道配置
+func NewNotifier(config internal.ConfigSource) *Notifier {
+	return &Notifier{
+		config: config,
+		client: &http.Client{},
+	}
+}
+
+// Send 发送通知，Webhook请求在后台进行，不阻塞调用方
+func (n *Notifier) Send(notification Notification) {
+	if notification.Time.IsZero() {
+		notification.Time = time.Now()
+	}
+	log.Printf("[NOTIFY] [%s] %s: %s", notification.Severity, notification.Title, notification.Message)
+
+	targets := n.webhooksFor(notification.Source)
+	record := &Record{Notification: notification, Deliveries: make([]Delivery, 0, len(targets))}
+	n.mu.Lock()
+	n.recent = append(n.recent, record)
+	if len(n.recent) > maxRecentNotifications {
+		n.recent = n.recent[len(n.recent)-maxRecentNotifications:]
+	}
+	n.mu.Unlock()
+
+	for _, target := range targets {
+		go func(target internal.WebhookTarget) {
+			delivery := Delivery{Webhook: webhookName(target)}
+			if err := n.post(target, notification); err != nil {
+				delivery.Error = err.Error()
+				log.Printf("警告: 通知发送到 %s 失败: %v", delivery.Webhook, err)
+			}
+			n.mu.Lock()
+			record.Deliveries = append(record.Deliveries, delivery)
+			n.mu.Unlock()
+		}(target)
+	}
+}
+
+// Recent 获取最近发送的通知，按时间倒序
+func (n *Notifier) Recent() []Record {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	records := make([]Record, 0, len(n.recent))
+	for i := len(n.recent) - 1; i >= 0; i-- {
+		record := *n.recent[i]
+		record.Deliveries = append([]Delivery(nil), n.recent[i].Deliveries...)
+		records = append(records, record)
+	}
+	return records
+}
+
+// webhooksFor 获取接收指定来源通知的Webhook
+func (n *Notifier) webhooksFor(source string) []internal.WebhookTarget {
+	config := n.config.Snapshot()
+	if config == nil || config.GlobalSettings == nil || config.GlobalSettings.Notifications == nil {
+		return nil
+	}
+
+	var targets []internal.WebhookTarget
+	for _, target := range config.GlobalSettings.Notifications.Webhooks {
+		if target.URL == "" {
+			continue
+		}
+		if len(target.Sources) == 0 {
+			targets = append(targets, target)
+			continue
+		}
+		for _, s := range target.Sources {
+			if s == source {
+				targets = append(targets, target)
+				break
+			}
+		}
+	}
+	return targets
+}
+
+// post 以JSON POST发送通知
+func (n *Notifier) post(target internal.WebhookTarget, notification Notification) error {
+	payload := struct {
+		Notification
+		Text string `json:"text"` // 兼容Slack等只读取 text 字段的机器人
+	}{
+		Notification: notification,
+		Text:         fmt.Sprintf("[%s] %s\n%s", notification.Severity, notification.Title, notification.Message),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	timeout := target.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range target.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// webhookName Webhook的显示名称，未配置名称时使用地址
+func webhookName(target internal.WebhookTarget) string {
+	if target.Name != "" {
+		return target.Name
+	}
+	return target.URL
+}
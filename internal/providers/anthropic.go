@@ -12,6 +12,9 @@ import (
 	"time"
 )
 
+// DefaultAnthropicMaxTokens 请求和分组都未指定max_tokens时使用的默认值
+const DefaultAnthropicMaxTokens = 4096
+
 // AnthropicProvider Anthropic Claude提供商
 type AnthropicProvider struct {
 	*BaseProvider
@@ -19,13 +22,24 @@ type AnthropicProvider struct {
 
 // AnthropicRequest Anthropic API请求结构
 type AnthropicRequest struct {
-	Model         string                   `json:"model"`
-	MaxTokens     int                      `json:"max_tokens"`
-	Messages      []AnthropicMessage       `json:"messages"`
-	Temperature   *float64                 `json:"temperature,omitempty"`
-	TopP          *float64                 `json:"top_p,omitempty"`
-	StopSequences []string                 `json:"stop_sequences,omitempty"`
-	Stream        bool                     `json:"stream,omitempty"`
+	Model         string                 `json:"model"`
+	MaxTokens     int                    `json:"max_tokens"`
+	Messages      []AnthropicMessage     `json:"messages"`
+	Temperature   *float64               `json:"temperature,omitempty"`
+	TopP          *float64               `json:"top_p,omitempty"`
+	StopSequences []string               `json:"stop_sequences,omitempty"`
+	Stream        bool                   `json:"stream,omitempty"`
+	System        string                 `json:"system,omitempty"`
+	Tools         []AnthropicTool        `json:"tools,omitempty"`
+	ToolChoice    map[string]interface{} `json:"tool_choice,omitempty"`
+	Thinking      *AnthropicThinking     `json:"thinking,omitempty"`
+}
+
+// AnthropicTool Anthropic工具定义，用于以强制工具调用实现 json_schema 结构化输出
+type AnthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
 }
 
 // AnthropicMessage Anthropic消息结构
@@ -36,20 +50,22 @@ type AnthropicMessage struct {
 
 // AnthropicResponse Anthropic API响应结构
 type AnthropicResponse struct {
-	ID           string                 `json:"id"`
-	Type         string                 `json:"type"`
-	Role         string                 `json:"role"`
-	Content      []AnthropicContent     `json:"content"`
-	Model        string                 `json:"model"`
-	StopReason   string                 `json:"stop_reason"`
-	StopSequence string                 `json:"stop_sequence"`
-	Usage        AnthropicUsage         `json:"usage"`
+	ID           string             `json:"id"`
+	Type         string             `json:"type"`
+	Role         string             `json:"role"`
+	Content      []AnthropicContent `json:"content"`
+	Model        string             `json:"model"`
+	StopReason   string             `json:"stop_reason"`
+	StopSequence string             `json:"stop_sequence"`
+	Usage        AnthropicUsage     `json:"usage"`
 }
 
 // AnthropicContent Anthropic内容结构
 type AnthropicContent struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type     string          `json:"type"`
+	Text     string          `json:"text"`
+	Thinking string          `json:"thinking,omitempty"` // thinking 内容块的思考内容
+	Input    json.RawMessage `json:"input,omitempty"`    // tool_use 内容块的工具参数
 }
 
 // AnthropicUsage Anthropic使用统计
@@ -67,57 +83,67 @@ func NewAnthropicProvider(config *ProviderConfig) *AnthropicProvider {
 
 // ChatCompletion 发送聊天完成请求
 func (p *AnthropicProvider) ChatCompletion(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	// Anthropic不支持 n，多个选择通过并行发送多个请求实现
+	if n := choiceCount(req); n > 1 {
+		return parallelChatCompletion(ctx, req, n, p.ChatCompletion)
+	}
+
 	// 转换请求格式
 	anthropicReq, err := p.transformToAnthropicRequest(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to transform request: %w", err)
 	}
-	
-	endpoint := fmt.Sprintf("%s/v1/messages", p.Config.BaseURL)
-	
+
+	endpoint := p.Config.ChatCompletionsURL()
+
 	reqBody, err := json.Marshal(anthropicReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
-	
+
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	
+
 	// 设置Anthropic特定的头部
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("x-api-key", p.Config.APIKey)
 	httpReq.Header.Set("anthropic-version", "2023-06-01") // 使用默认版本
-	
+
 	// 设置自定义头部
 	for key, value := range p.Config.Headers {
 		if key != "x-api-key" { // 避免覆盖API key头
 			httpReq.Header.Set(key, value)
 		}
 	}
-	
+
 	resp, err := p.HTTPClient.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
-	
+
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, ParseUpstreamError(resp.StatusCode, resp.Header, body)
 	}
-	
+
 	var anthropicResp AnthropicResponse
 	if err := json.NewDecoder(resp.Body).Decode(&anthropicResp); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
-	
+
 	// 转换响应格式
-	return p.transformFromAnthropicResponse(&anthropicResp)
+	response, err := p.transformFromAnthropicResponse(&anthropicResp)
+	if err == nil && !p.includeReasoning(req) {
+		stripReasoningContent(response)
+	}
+	return response, err
 }
 
 // ChatCompletionStream 发送流式聊天完成请求
+// Anthropic不支持 n，请求多个选择时并行发送多个流式请求，各请求的事件按选择编号合并为一个流
 func (p *AnthropicProvider) ChatCompletionStream(ctx context.Context, req *ChatCompletionRequest) (<-chan StreamResponse, error) {
 	// 转换请求格式并设置stream为true
 	anthropicReq, err := p.transformToAnthropicRequest(req)
@@ -125,143 +151,190 @@ func (p *AnthropicProvider) ChatCompletionStream(ctx context.Context, req *ChatC
 		return nil, fmt.Errorf("failed to transform request: %w", err)
 	}
 	anthropicReq.Stream = true
-	
-	endpoint := fmt.Sprintf("%s/v1/messages", p.Config.BaseURL)
-	
+
 	reqBody, err := json.Marshal(anthropicReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
-	
+
+	// 任一请求失败或调用方取消时关闭所有上游连接
+	streamCtx, cancel := context.WithCancel(ctx)
+	n := choiceCount(req)
+	bodies := make([]io.ReadCloser, 0, n)
+	for i := 0; i < n; i++ {
+		body, err := p.openStream(streamCtx, reqBody)
+		if err != nil {
+			cancel()
+			for _, opened := range bodies {
+				opened.Close()
+			}
+			return nil, err
+		}
+		bodies = append(bodies, body)
+	}
+
+	events := make(chan anthropicChoiceEvent)
+	for choice, body := range bodies {
+		go readAnthropicEvents(streamCtx, choice, body, events)
+	}
+
+	streamChan := make(chan StreamResponse, 10)
+	transcoder := newChunkTranscoder(req)
+	structuredTool, _ := anthropicReq.ToolChoice["name"].(string)
+
+	go func() {
+		defer close(streamChan)
+		defer cancel()
+
+		states := make([]*anthropicStreamState, n)
+		for choice := range states {
+			states[choice] = newAnthropicStreamState(transcoder, choice, structuredTool, p.includeReasoning(req))
+		}
+		for finished := 0; finished < n; {
+			var received anthropicChoiceEvent
+			select {
+			case received = <-events:
+			case <-ctx.Done():
+				return
+			}
+
+			if received.err != nil {
+				streamChan <- StreamResponse{
+					Error: received.err,
+					Done:  true,
+				}
+				return
+			}
+			if received.event == nil {
+				// 上游连接结束
+				if !states[received.choice].stopped {
+					states[received.choice].stopped = true
+					finished++
+				}
+				continue
+			}
+
+			chunks, err := states[received.choice].handle(received.event)
+			if err != nil {
+				streamChan <- StreamResponse{
+					Error: err,
+					Done:  true,
+				}
+				return
+			}
+			for _, chunk := range chunks {
+				streamChan <- StreamResponse{
+					Data: chunk,
+					Done: false,
+				}
+			}
+			if states[received.choice].stopped {
+				finished++
+			}
+		}
+
+		// 用量为所有请求之和
+		var usage Usage
+		reasoningTokens := 0
+		for _, state := range states {
+			usage.PromptTokens += state.usage.InputTokens
+			usage.CompletionTokens += state.usage.OutputTokens
+			reasoningTokens += state.reasoningTokens()
+		}
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+		usage.setReasoningTokens(reasoningTokens)
+		transcoder.setUsage(usage)
+
+		chunks := transcoder.end()
+		for i, chunk := range chunks {
+			streamChan <- StreamResponse{
+				Data: chunk,
+				Done: i == len(chunks)-1,
+			}
+		}
+	}()
+
+	return streamChan, nil
+}
+
+// openStream 发送流式请求，返回SSE响应体
+func (p *AnthropicProvider) openStream(ctx context.Context, reqBody []byte) (io.ReadCloser, error) {
+	endpoint := p.Config.ChatCompletionsURL()
+
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	
+
 	// 设置头部
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("x-api-key", p.Config.APIKey)
 	httpReq.Header.Set("Accept", "text/event-stream")
 	httpReq.Header.Set("Cache-Control", "no-cache")
 	httpReq.Header.Set("anthropic-version", "2023-06-01") // 使用默认版本
-	
+
 	// 设置自定义头部
 	for key, value := range p.Config.Headers {
 		if key != "x-api-key" {
 			httpReq.Header.Set(key, value)
 		}
 	}
-	
+
 	resp, err := p.HTTPClient.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
-	
+
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, ParseUpstreamError(resp.StatusCode, resp.Header, body)
 	}
-	
-	streamChan := make(chan StreamResponse, 10)
-	
-	go func() {
-		defer close(streamChan)
-		defer resp.Body.Close()
-		
-		scanner := bufio.NewScanner(resp.Body)
-		for scanner.Scan() {
-			line := scanner.Text()
-			
-			// Anthropic使用Server-Sent Events格式
-			if strings.HasPrefix(line, "data: ") {
-				data := strings.TrimPrefix(line, "data: ")
-				
-				// 检查是否为结束标记
-				if data == "[DONE]" {
-					streamChan <- StreamResponse{
-						Data: []byte("data: [DONE]\n\n"),
-						Done: true,
-					}
-					return
-				}
-				
-				// 解析Anthropic流式数据并转换为OpenAI格式
-				var anthropicEvent map[string]interface{}
-				if err := json.Unmarshal([]byte(data), &anthropicEvent); err == nil {
-					// 转换为OpenAI格式的流式数据
-					if eventType, ok := anthropicEvent["type"].(string); ok {
-						switch eventType {
-						case "content_block_delta":
-							if delta, ok := anthropicEvent["delta"].(map[string]interface{}); ok {
-								if text, ok := delta["text"].(string); ok {
-									openaiData := map[string]interface{}{
-										"id":      fmt.Sprintf("chatcmpl-%d", time.Now().Unix()),
-										"object":  "chat.completion.chunk",
-										"created": time.Now().Unix(),
-										"model":   req.Model,
-										"choices": []map[string]interface{}{
-											{
-												"index": 0,
-												"delta": map[string]interface{}{
-													"content": text,
-												},
-												"finish_reason": nil,
-											},
-										},
-									}
-									
-									if jsonData, err := json.Marshal(openaiData); err == nil {
-										streamChan <- StreamResponse{
-											Data: []byte("data: " + string(jsonData) + "\n\n"),
-											Done: false,
-										}
-									}
-								}
-							}
-						case "message_stop":
-							// 发送结束标记
-							openaiData := map[string]interface{}{
-								"id":      fmt.Sprintf("chatcmpl-%d", time.Now().Unix()),
-								"object":  "chat.completion.chunk",
-								"created": time.Now().Unix(),
-								"model":   req.Model,
-								"choices": []map[string]interface{}{
-									{
-										"index":         0,
-										"delta":         map[string]interface{}{},
-										"finish_reason": "stop",
-									},
-								},
-							}
-							
-							if jsonData, err := json.Marshal(openaiData); err == nil {
-								streamChan <- StreamResponse{
-									Data: []byte("data: " + string(jsonData) + "\n\n"),
-									Done: false,
-								}
-							}
-							
-							streamChan <- StreamResponse{
-								Data: []byte("data: [DONE]\n\n"),
-								Done: true,
-							}
-							return
-						}
-					}
-				}
-			}
+	return resp.Body, nil
+}
+
+// anthropicChoiceEvent 一个选择的上游流式事件，event 为空表示上游连接已结束
+type anthropicChoiceEvent struct {
+	choice int
+	event  *anthropicStreamEvent
+	err    error
+}
+
+// readAnthropicEvents 读取一个上游流式响应的事件，读取结束后关闭响应体
+func readAnthropicEvents(ctx context.Context, choice int, body io.ReadCloser, events chan<- anthropicChoiceEvent) {
+	defer body.Close()
+
+	send := func(received anthropicChoiceEvent) bool {
+		select {
+		case events <- received:
+			return true
+		case <-ctx.Done():
+			return false
 		}
-		
-		if err := scanner.Err(); err != nil {
-			streamChan <- StreamResponse{
-				Error: err,
-				Done:  true,
-			}
+	}
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		// Anthropic使用Server-Sent Events格式
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
 		}
-	}()
-	
-	return streamChan, nil
+		data := strings.TrimPrefix(line, "data: ")
+		if data == "[DONE]" {
+			break
+		}
+
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			continue
+		}
+		if !send(anthropicChoiceEvent{choice: choice, event: &event}) {
+			return
+		}
+	}
+
+	send(anthropicChoiceEvent{choice: choice, err: scanner.Err()})
 }
 
 // ChatCompletionStreamNative 发送原生格式流式聊天完成请求
@@ -272,60 +345,60 @@ func (p *AnthropicProvider) ChatCompletionStreamNative(ctx context.Context, req
 		return nil, fmt.Errorf("failed to transform request: %w", err)
 	}
 	anthropicReq.Stream = true
-	
-	endpoint := fmt.Sprintf("%s/v1/messages", p.Config.BaseURL)
-	
+
+	endpoint := p.Config.ChatCompletionsURL()
+
 	reqBody, err := json.Marshal(anthropicReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
-	
+
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	
+
 	// 设置头部
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("x-api-key", p.Config.APIKey)
 	httpReq.Header.Set("Accept", "text/event-stream")
 	httpReq.Header.Set("Cache-Control", "no-cache")
 	httpReq.Header.Set("anthropic-version", "2023-06-01")
-	
+
 	// 设置自定义头部
 	for key, value := range p.Config.Headers {
 		if key != "x-api-key" {
 			httpReq.Header.Set(key, value)
 		}
 	}
-	
+
 	resp, err := p.HTTPClient.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
-	
+
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, ParseUpstreamError(resp.StatusCode, resp.Header, body)
 	}
-	
+
 	streamChan := make(chan StreamResponse, 10)
-	
+
 	go func() {
 		defer close(streamChan)
 		defer resp.Body.Close()
-		
+
 		scanner := bufio.NewScanner(resp.Body)
 		for scanner.Scan() {
 			line := scanner.Text()
-			
+
 			// 发送原始Anthropic SSE数据
 			streamChan <- StreamResponse{
 				Data: []byte(line + "\n"),
 				Done: false,
 			}
-			
+
 			// 检查是否结束
 			if strings.HasPrefix(line, "data: ") {
 				data := strings.TrimPrefix(line, "data: ")
@@ -335,7 +408,7 @@ func (p *AnthropicProvider) ChatCompletionStreamNative(ctx context.Context, req
 					}
 					return
 				}
-				
+
 				// 解析事件类型以检查是否结束
 				var event map[string]interface{}
 				if err := json.Unmarshal([]byte(data), &event); err == nil {
@@ -348,7 +421,7 @@ func (p *AnthropicProvider) ChatCompletionStreamNative(ctx context.Context, req
 				}
 			}
 		}
-		
+
 		if err := scanner.Err(); err != nil {
 			streamChan <- StreamResponse{
 				Error: err,
@@ -356,14 +429,14 @@ func (p *AnthropicProvider) ChatCompletionStreamNative(ctx context.Context, req
 			}
 		}
 	}()
-	
+
 	return streamChan, nil
 }
 
 // GetModels 获取可用模型列表
 func (p *AnthropicProvider) GetModels(ctx context.Context) (interface{}, error) {
 	// 使用Anthropic官方的模型列表API
-	endpoint := fmt.Sprintf("%s/v1/models", p.Config.BaseURL)
+	endpoint := p.Config.ModelsURL()
 
 	httpReq, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
@@ -388,7 +461,7 @@ func (p *AnthropicProvider) GetModels(ctx context.Context) (interface{}, error)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, ParseUpstreamError(resp.StatusCode, resp.Header, body)
 	}
 
 	// 解析Anthropic API响应
@@ -423,9 +496,9 @@ func (p *AnthropicProvider) GetModels(ctx context.Context) (interface{}, error)
 		}
 
 		data = append(data, map[string]interface{}{
-			"id":      model.ID,
-			"object":  "model",
-			"created": created,
+			"id":       model.ID,
+			"object":   "model",
+			"created":  created,
 			"owned_by": "anthropic",
 		})
 	}
@@ -434,10 +507,14 @@ func (p *AnthropicProvider) GetModels(ctx context.Context) (interface{}, error)
 	return models, nil
 }
 
-// HealthCheck 健康检查
+// HealthCheck 健康检查，配置了健康检查模型时发送一个最小的聊天请求确认模型可用，否则只检查模型列表接口
 func (p *AnthropicProvider) HealthCheck(ctx context.Context) error {
+	if p.Config.HealthCheckModel != "" {
+		return modelHealthCheck(ctx, p, p.Config.HealthCheckModel)
+	}
+
 	// 使用模型列表API进行健康检查，这是一个轻量级的操作
-	endpoint := fmt.Sprintf("%s/v1/models", p.Config.BaseURL)
+	endpoint := p.Config.ModelsURL()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
@@ -468,7 +545,7 @@ func (p *AnthropicProvider) HealthCheck(ctx context.Context) error {
 
 	// 其他状态码认为不健康
 	body, _ := io.ReadAll(resp.Body)
-	return fmt.Errorf("health check failed with status %d: %s", resp.StatusCode, string(body))
+	return ParseUpstreamError(resp.StatusCode, resp.Header, body)
 }
 
 // TransformRequest 转换请求为Anthropic格式
@@ -487,27 +564,58 @@ func (p *AnthropicProvider) TransformResponse(resp interface{}) (*ChatCompletion
 // transformToAnthropicRequest 将标准请求转换为Anthropic格式
 func (p *AnthropicProvider) transformToAnthropicRequest(req *ChatCompletionRequest) (*AnthropicRequest, error) {
 	messages := make([]AnthropicMessage, 0, len(req.Messages))
+	var systemParts []string
 
-	for _, msg := range req.Messages {
-		// 跳过system消息，Anthropic在messages中不支持system角色
-		if msg.Role == "system" {
+	for i, msg := range req.Messages {
+		content := p.extractTextContent(msg.Content)
+		switch msg.Role {
+		case "system", "developer":
+			// Anthropic在messages中不支持system角色，system消息合并到顶层的system参数
+			if text := strings.TrimSpace(content); text != "" {
+				systemParts = append(systemParts, text)
+			}
 			continue
+		case "user", "assistant":
+		default:
+			return nil, anthropicRequestError("unsupported_message_role", "messages[%d]: role '%s' is not supported by Anthropic groups", i, msg.Role)
+		}
+		if len(msg.ToolCalls) > 0 {
+			return nil, anthropicRequestError("unsupported_message_role", "messages[%d]: tool calls are not supported by Anthropic groups", i)
+		}
+		if strings.TrimSpace(content) == "" {
+			// 只有最后一条assistant消息（预填充）可以为空，为空时直接省略
+			if msg.Role == "assistant" && i == len(req.Messages)-1 {
+				continue
+			}
+			return nil, anthropicRequestError("empty_message", "messages[%d]: %s message has no text content", i, msg.Role)
 		}
 
-		// 提取文本内容
-		content := p.extractTextContent(msg.Content)
-
-		message := AnthropicMessage{
+		// 连续的同角色消息合并为一条，Anthropic要求user和assistant交替出现
+		if n := len(messages); n > 0 && messages[n-1].Role == msg.Role {
+			messages[n-1].Content += "\n\n" + content
+			continue
+		}
+		messages = append(messages, AnthropicMessage{
 			Role:    msg.Role,
 			Content: content,
-		}
-		messages = append(messages, message)
+		})
+	}
+	if len(messages) == 0 {
+		return nil, anthropicRequestError("empty_message", "messages must contain at least one user or assistant message")
 	}
 
-	// Anthropic要求必须有max_tokens
-	maxTokens := 4096 // 默认值
+	// Anthropic要求必须有max_tokens，请求未指定时使用分组的默认值
+	maxTokens := p.Config.DefaultMaxTokens
+	if maxTokens <= 0 {
+		maxTokens = DefaultAnthropicMaxTokens
+	}
 	if req.MaxTokens != nil {
 		maxTokens = *req.MaxTokens
+	} else if req.MaxCompletionTokens != nil {
+		maxTokens = *req.MaxCompletionTokens
+	}
+	if maxTokens <= 0 {
+		return nil, anthropicRequestError("invalid_max_tokens", "max_tokens must be a positive integer, got %d", maxTokens)
 	}
 
 	anthropicReq := &AnthropicRequest{
@@ -523,13 +631,49 @@ func (p *AnthropicProvider) transformToAnthropicRequest(req *ChatCompletionReque
 	if req.TopP != nil {
 		anthropicReq.TopP = req.TopP
 	}
-	if len(req.Stop) > 0 {
-		anthropicReq.StopSequences = req.Stop
+	// Anthropic拒绝只含空白的停止序列，这类停止序列在OpenAI中也不会生效，直接忽略
+	for _, stop := range req.Stop {
+		if strings.TrimSpace(stop) != "" {
+			anthropicReq.StopSequences = append(anthropicReq.StopSequences, stop)
+		}
 	}
 
+	// 结构化输出：对象类型的 json_schema 通过强制调用一个以该Schema为参数的工具实现，其他情况在system中要求输出JSON
+	if req.ResponseFormat.WantsJSON() {
+		if schema := req.ResponseFormat.Schema(); schema != nil && schema["type"] == "object" {
+			name := req.ResponseFormat.JSONSchema.Name
+			if name == "" {
+				name = "json_response"
+			}
+			anthropicReq.Tools = []AnthropicTool{{
+				Name:        name,
+				Description: req.ResponseFormat.JSONSchema.Description,
+				InputSchema: schema,
+			}}
+			anthropicReq.ToolChoice = map[string]interface{}{"type": "tool", "name": name}
+		} else {
+			systemParts = append(systemParts, req.ResponseFormat.JSONInstruction())
+		}
+	}
+	anthropicReq.System = strings.Join(systemParts, "\n\n")
+
+	// 推理强度映射为扩展思考预算
+	applyAnthropicThinking(anthropicReq, req)
+
 	return anthropicReq, nil
 }
 
+// anthropicRequestError 请求无法转换为Anthropic格式时返回的错误，归类为请求参数错误，不会发往上游也不会重试其他密钥
+func anthropicRequestError(code, format string, args ...interface{}) error {
+	return &ToolCallError{
+		Type:       "invalid_request_error",
+		Code:       code,
+		Message:    fmt.Sprintf(format, args...),
+		StatusCode: http.StatusBadRequest,
+		Category:   ErrorCategoryInvalidRequest,
+	}
+}
+
 // transformFromAnthropicResponse 将Anthropic响应转换为标准格式
 func (p *AnthropicProvider) transformFromAnthropicResponse(anthropicResp *AnthropicResponse) (*ChatCompletionResponse, error) {
 	response := &ChatCompletionResponse{
@@ -540,27 +684,35 @@ func (p *AnthropicProvider) transformFromAnthropicResponse(anthropicResp *Anthro
 		Choices: make([]ChatCompletionChoice, 1),
 	}
 
-	// 合并所有内容块的文本
-	var content strings.Builder
+	// 合并所有内容块的文本，结构化输出的工具参数即为JSON结果
+	var content, thinking strings.Builder
+	finishReason := anthropicFinishReason(anthropicResp.StopReason)
 	for _, contentBlock := range anthropicResp.Content {
 		if contentBlock.Type == "text" {
 			content.WriteString(contentBlock.Text)
+		} else if contentBlock.Type == "thinking" {
+			thinking.WriteString(contentBlock.Thinking)
+		} else if contentBlock.Type == "tool_use" && len(contentBlock.Input) > 0 {
+			content.Write(contentBlock.Input)
+			finishReason = "stop"
 		}
 	}
 
 	response.Choices[0] = ChatCompletionChoice{
 		Index: 0,
 		Message: ChatCompletionMessage{
-			Role:    "assistant",
-			Content: content.String(),
+			Role:             "assistant",
+			Content:          content.String(),
+			ReasoningContent: thinking.String(),
 		},
-		FinishReason: anthropicResp.StopReason,
+		FinishReason: finishReason,
 	}
 
 	// 设置使用统计
 	response.Usage.PromptTokens = anthropicResp.Usage.InputTokens
 	response.Usage.CompletionTokens = anthropicResp.Usage.OutputTokens
 	response.Usage.TotalTokens = anthropicResp.Usage.InputTokens + anthropicResp.Usage.OutputTokens
+	response.Usage.setReasoningTokens(anthropicReasoningTokens(thinking.String(), anthropicResp.Usage.OutputTokens))
 
 	return response, nil
 }
@@ -0,0 +1,179 @@
+package providers
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// 提示词token估算的固定开销，与OpenAI的计数规则一致
+const (
+	tokensPerMessage = 4  // 每条消息的角色和分隔符
+	tokensPerReply   = 3  // 回复的起始标记
+	tokensPerImage   = 85 // 低分辨率图片的token数，高分辨率图片按此估算会偏低
+)
+
+var (
+	promptEncodingOnce sync.Once
+	promptEncoding     *tiktoken.Tiktoken // 加载失败时为空，改用按字符估算
+)
+
+// countTextTokens 计算文本的token数，tiktoken编码不可用时按字符估算
+func countTextTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	promptEncodingOnce.Do(func() {
+		enc, err := tiktoken.GetEncoding("cl100k_base")
+		if err != nil {
+			log.Printf("加载tiktoken编码失败，上下文长度按字符估算: %v", err)
+			return
+		}
+		promptEncoding = enc
+	})
+	if promptEncoding != nil {
+		return len(promptEncoding.Encode(text, nil, nil))
+	}
+
+	// 中日韩字符约每字1个token，其余约每4个字符1个token
+	cjk, other := 0, 0
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// estimateMessageTokens 估算单条消息的token数
+func estimateMessageTokens(message ChatMessage) int {
+	tokens := tokensPerMessage + countTextTokens(message.Role)
+	switch content := message.Content.(type) {
+	case string:
+		tokens += countTextTokens(content)
+	case []interface{}:
+		for _, part := range content {
+			partMap, ok := part.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if text, ok := partMap["text"].(string); ok {
+				tokens += countTextTokens(text)
+			}
+			if partMap["type"] == "image_url" {
+				tokens += tokensPerImage
+			}
+		}
+	}
+	for _, call := range message.ToolCalls {
+		if call.Function != nil {
+			tokens += countTextTokens(call.Function.Name) + countTextTokens(call.Function.Arguments)
+		}
+	}
+	return tokens
+}
+
+// EstimatePromptTokens 估算请求的提示词token数，包括消息和工具定义
+func EstimatePromptTokens(req *ChatCompletionRequest) int {
+	tokens := tokensPerReply
+	for _, message := range req.Messages {
+		tokens += estimateMessageTokens(message)
+	}
+	if len(req.Tools) > 0 {
+		if data, err := json.Marshal(req.Tools); err == nil {
+			tokens += countTextTokens(string(data))
+		}
+	}
+	return tokens
+}
+
+// RequestedOutputTokens 请求中指定的最大输出token数，未指定时返回0
+func RequestedOutputTokens(req *ChatCompletionRequest) int {
+	if req.MaxCompletionTokens != nil {
+		return *req.MaxCompletionTokens
+	}
+	if req.MaxTokens != nil {
+		return *req.MaxTokens
+	}
+	return 0
+}
+
+// LookupContextWindow 查找模型的上下文窗口大小：先精确匹配，再按最长前缀匹配（如 "gpt-4o" 匹配 "gpt-4o-2024-08-06"），未配置时返回0
+func LookupContextWindow(windows map[string]int, model string) int {
+	if len(windows) == 0 {
+		return 0
+	}
+	name := strings.ToLower(model)
+	if window, ok := windows[name]; ok {
+		return window
+	}
+	if window, ok := windows[model]; ok {
+		return window
+	}
+	// 去掉 "openai/gpt-4o" 这类带厂商前缀的名称
+	if idx := strings.LastIndex(name, "/"); idx != -1 {
+		name = name[idx+1:]
+		if window, ok := windows[name]; ok {
+			return window
+		}
+	}
+
+	prefixes := make([]string, 0, len(windows))
+	for prefix := range windows {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, strings.ToLower(prefix)+"-") {
+			return windows[prefix]
+		}
+	}
+	return 0
+}
+
+// TruncateMessages 从最早的非system消息开始丢弃，直到提示词不超过 budget 个token
+// system消息和最后一条消息始终保留，丢弃带工具调用的assistant消息时一并丢弃紧随其后的工具结果
+// 返回截断后的消息列表（新切片）、丢弃的消息数和是否放得下
+func TruncateMessages(req *ChatCompletionRequest, budget int) ([]ChatMessage, int, bool) {
+	messages := req.Messages
+	total := EstimatePromptTokens(req)
+	if total <= budget {
+		return messages, 0, true
+	}
+
+	keep := make([]bool, len(messages))
+	for i := range keep {
+		keep[i] = true
+	}
+	dropped := 0
+	for i := 0; i < len(messages)-1 && total > budget; i++ {
+		if !keep[i] || messages[i].Role == "system" {
+			continue
+		}
+		keep[i] = false
+		total -= estimateMessageTokens(messages[i])
+		dropped++
+		// 工具结果不能脱离发起调用的assistant消息单独存在
+		for j := i + 1; j < len(messages)-1 && messages[j].Role == "tool"; j++ {
+			keep[j] = false
+			total -= estimateMessageTokens(messages[j])
+			dropped++
+			i = j
+		}
+	}
+
+	truncated := make([]ChatMessage, 0, len(messages)-dropped)
+	for i, message := range messages {
+		if keep[i] {
+			truncated = append(truncated, message)
+		}
+	}
+	return truncated, dropped, total <= budget
+}
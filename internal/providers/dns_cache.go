@@ -0,0 +1,82 @@
+package providers
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// dnsCache 缓存上游域名的解析结果，高并发时避免每个新连接都查询DNS
+// 缓存过期后重新解析，解析失败时继续使用过期的结果，避免DNS短暂故障导致上游不可用
+type dnsCache struct {
+	ttl      time.Duration
+	resolver *net.Resolver
+	mu       sync.Mutex
+	entries  map[string]dnsCacheEntry
+}
+
+// dnsCacheEntry 单个域名的解析结果
+type dnsCacheEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+func newDNSCache(ttl time.Duration, resolver *net.Resolver) *dnsCache {
+	return &dnsCache{
+		ttl:      ttl,
+		resolver: resolver,
+		entries:  make(map[string]dnsCacheEntry),
+	}
+}
+
+// lookup 返回域名的地址列表，缓存未过期时不查询DNS
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := c.resolver.LookupHost(ctx, host)
+	if err != nil {
+		if ok {
+			return entry.addrs, nil
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[host] = dnsCacheEntry{addrs: addrs, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return addrs, nil
+}
+
+// dialContext 返回使用缓存解析结果的 DialContext，依次尝试各个地址直到连接成功
+// 地址本身是IP时直接连接
+func (c *dnsCache) dialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, address)
+		}
+
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for _, addr := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, lastErr
+	}
+}
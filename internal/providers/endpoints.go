@@ -0,0 +1,64 @@
+package providers
+
+import (
+	"strings"
+)
+
+// endpointPaths 提供商接口路径
+type endpointPaths struct {
+	ChatCompletions string
+	Models          string
+}
+
+// defaultEndpointPaths 各提供商类型的默认接口路径（相对于BaseURL）
+var defaultEndpointPaths = map[string]endpointPaths{
+	"openai":                     {ChatCompletions: "/chat/completions", Models: "/models"},
+	"openrouter":                 {ChatCompletions: "/chat/completions", Models: "/models"},
+	"azure_openai":               {ChatCompletions: "/chat/completions", Models: "/models"},
+	OpenAICompatibleProviderType: {ChatCompletions: "/chat/completions", Models: "/models"},
+	"anthropic":                  {ChatCompletions: "/v1/messages", Models: "/v1/models"},
+	// Gemini的聊天和模型列表接口都由SDK发起，设置 models_path 时模型列表改为通过HTTP请求该路径
+	"gemini": {},
+}
+
+// DefaultChatCompletionsPath 获取提供商类型的默认聊天完成接口路径，未知类型按OpenAI兼容接口处理
+func DefaultChatCompletionsPath(providerType string) string {
+	if paths, ok := defaultEndpointPaths[providerType]; ok {
+		return paths.ChatCompletions
+	}
+	return defaultEndpointPaths["openai"].ChatCompletions
+}
+
+// DefaultModelsPath 获取提供商类型的默认模型列表接口路径，未知类型按OpenAI兼容接口处理
+func DefaultModelsPath(providerType string) string {
+	if paths, ok := defaultEndpointPaths[providerType]; ok {
+		return paths.Models
+	}
+	return defaultEndpointPaths["openai"].Models
+}
+
+// ChatCompletionsURL 获取聊天完成接口的完整URL，优先使用分组配置的路径覆盖
+func (c *ProviderConfig) ChatCompletionsURL() string {
+	path := c.ChatCompletionsPath
+	if path == "" {
+		path = DefaultChatCompletionsPath(c.ProviderType)
+	}
+	return joinEndpoint(c.BaseURL, path)
+}
+
+// ModelsURL 获取模型列表接口的完整URL，优先使用分组配置的路径覆盖
+func (c *ProviderConfig) ModelsURL() string {
+	path := c.ModelsPath
+	if path == "" {
+		path = DefaultModelsPath(c.ProviderType)
+	}
+	return joinEndpoint(c.BaseURL, path)
+}
+
+// joinEndpoint 拼接BaseURL和接口路径
+func joinEndpoint(baseURL, path string) string {
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return baseURL + path
+}
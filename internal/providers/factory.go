@@ -28,6 +28,9 @@ func (f *DefaultProviderFactory) CreateProvider(config *ProviderConfig) (Provide
 	case "azure_openai":
 		// Azure OpenAI使用OpenAI格式，但可能需要特殊处理
 		return NewOpenAIProvider(config), nil
+	case OpenAICompatibleProviderType:
+		// 本地或自建的OpenAI兼容后端（Ollama、LM Studio、vLLM等），可以不配置密钥
+		return NewOpenAIProvider(config), nil
 	default:
 		return nil, fmt.Errorf("unsupported provider type: %s", config.ProviderType)
 	}
@@ -35,7 +38,15 @@ func (f *DefaultProviderFactory) CreateProvider(config *ProviderConfig) (Provide
 
 // GetSupportedTypes 获取支持的提供商类型
 func (f *DefaultProviderFactory) GetSupportedTypes() []string {
-	return []string{"openai", "openrouter", "gemini", "anthropic", "azure_openai"}
+	return []string{"openai", "openrouter", "gemini", "anthropic", "azure_openai", OpenAICompatibleProviderType}
+}
+
+// OpenAICompatibleProviderType 本地或自建OpenAI兼容后端的提供商类型
+const OpenAICompatibleProviderType = "openai_compatible"
+
+// RequiresAPIKey 提供商类型是否必须配置API密钥
+func RequiresAPIKey(providerType string) bool {
+	return providerType != OpenAICompatibleProviderType
 }
 
 // ProviderManager 提供商管理器
@@ -119,23 +130,23 @@ func ValidateProviderConfig(config *ProviderConfig) error {
 	if config == nil {
 		return fmt.Errorf("provider config cannot be nil")
 	}
-	
+
 	if config.ProviderType == "" {
 		return fmt.Errorf("provider type cannot be empty")
 	}
-	
+
 	if config.BaseURL == "" {
 		return fmt.Errorf("base URL cannot be empty")
 	}
-	
-	if config.APIKey == "" {
+
+	if config.APIKey == "" && RequiresAPIKey(config.ProviderType) {
 		return fmt.Errorf("API key cannot be empty")
 	}
-	
+
 	// 验证提供商类型
 	factory := NewDefaultProviderFactory()
 	supportedTypes := factory.GetSupportedTypes()
-	
+
 	supported := false
 	for _, supportedType := range supportedTypes {
 		if config.ProviderType == supportedType {
@@ -143,11 +154,11 @@ func ValidateProviderConfig(config *ProviderConfig) error {
 			break
 		}
 	}
-	
+
 	if !supported {
 		return fmt.Errorf("unsupported provider type: %s, supported types: %v", config.ProviderType, supportedTypes)
 	}
-	
+
 	return nil
 }
 
@@ -155,7 +166,7 @@ func ValidateProviderConfig(config *ProviderConfig) error {
 func CreateProviderConfigFromUserGroup(groupID string, userGroup interface{}) (*ProviderConfig, error) {
 	// 这里需要根据实际的UserGroup结构来实现
 	// 由于我们在interface.go中没有导入internal包，这里使用interface{}
-	
+
 	// 这个函数将在实际使用时由调用方实现类型转换
 	return nil, fmt.Errorf("not implemented - should be implemented by caller with proper type conversion")
 }
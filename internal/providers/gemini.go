@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -71,51 +73,36 @@ func (qm *GeminiQuotaManager) RecordSuccess() {
 type GeminiProvider struct {
 	*BaseProvider
 	client       *genai.Client
+	httpClient   *http.Client // SDK使用的HTTP客户端，Vertex AI服务账号模式下带有OAuth认证
 	quotaManager *GeminiQuotaManager
 }
 
 // NewGeminiProvider 创建Gemini提供商
+// 使用分组的BaseURL和请求头，支持公共Gemini API、兼容的区域代理以及Vertex AI
 func NewGeminiProvider(config *ProviderConfig) *GeminiProvider {
-	// 创建官方Google AI Go SDK客户端
 	ctx := context.Background()
 
-	// 根据文档，Google AI Go SDK 的正确配置方式
-	clientConfig := &genai.ClientConfig{
-		APIKey: config.APIKey,
+	// 复用基础提供商的传输层以统一User-Agent和分阶段超时，总超时由请求上下文控制
+	base := NewBaseProvider(config)
+	provider := &GeminiProvider{
+		BaseProvider: base,
+		quotaManager: NewGeminiQuotaManager(),
 	}
 
-	// 设置 HTTP 选项，包括 API 版本
-	if config.BaseURL != "" {
-		// 对于 Gemini API，通常使用 v1beta 版本
-		clientConfig.HTTPOptions = genai.HTTPOptions{
-			APIVersion: "v1beta",
-		}
-
-		// 如果 BaseURL 不是默认的，可能需要特殊处理
-		// 但是 Google AI Go SDK 通常会自动处理 BaseURL
-		// 这里我们主要依赖 APIKey 和 APIVersion
-	} else {
-		// 使用默认配置
-		clientConfig.HTTPOptions = genai.HTTPOptions{
-			APIVersion: "v1beta",
-		}
+	clientConfig, err := geminiClientConfig(ctx, config, base.HTTPClient.Transport)
+	if err != nil {
+		// 创建失败时返回没有客户端的提供商，错误在实际调用时返回
+		log.Printf("警告: 创建Gemini客户端失败: %v", err)
+		return provider
 	}
-
 	client, err := genai.NewClient(ctx, clientConfig)
 	if err != nil {
-		// 如果创建失败，返回一个带有错误的提供商
-		// 错误将在实际调用时返回
-		return &GeminiProvider{
-			BaseProvider: NewBaseProvider(config),
-			client:       nil,
-			quotaManager: NewGeminiQuotaManager(),
-		}
-	}
-	return &GeminiProvider{
-		BaseProvider: NewBaseProvider(config),
-		client:       client,
-		quotaManager: NewGeminiQuotaManager(),
+		log.Printf("警告: 创建Gemini客户端失败: %v", err)
+		return provider
 	}
+	provider.client = client
+	provider.httpClient = clientConfig.HTTPClient
+	return provider
 }
 
 // ChatCompletion 发送聊天完成请求
@@ -160,11 +147,19 @@ func (p *GeminiProvider) ChatCompletion(ctx context.Context, req *ChatCompletion
 		genConfig.TopP = &topP
 	}
 
+	// 设置候选数，每个候选作为一个选择返回
+	if n := choiceCount(req); n > 1 {
+		genConfig.CandidateCount = int32(n)
+	}
+
 	// 设置停止序列
 	if len(req.Stop) > 0 {
 		genConfig.StopSequences = req.Stop
 	}
 
+	// 设置随机种子和重复惩罚
+	applyGeminiSamplingParams(genConfig, req)
+
 	// 转换工具定义为Gemini格式
 	if len(req.Tools) > 0 {
 		tools, err := p.convertToolsToGeminiFormat(req.Tools)
@@ -174,12 +169,12 @@ func (p *GeminiProvider) ChatCompletion(ctx context.Context, req *ChatCompletion
 		genConfig.Tools = tools
 	}
 
+	// 设置结构化输出格式
+	p.applyResponseFormat(genConfig, req)
+
 	// 启用思考模式 - 对于Gemini 2.5系列模型启用思考功能
-	// 但在转换为OpenAI格式时不包含思考内容
-	genConfig.ThinkingConfig = &genai.ThinkingConfig{
-		IncludeThoughts: false, // 转换为OpenAI格式时不包含思考内容
-		ThinkingBudget:  nil,   // 使用默认的动态思考预算
-	}
+	// 推理强度映射为思考预算，启用 include_reasoning 时思考内容作为 reasoning_content 返回
+	genConfig.ThinkingConfig = geminiThinkingConfig(req, p.includeReasoning(req))
 
 	// 调用官方SDK
 	result, err := p.client.Models.GenerateContent(ctx, req.Model, contents, genConfig)
@@ -241,11 +236,19 @@ func (p *GeminiProvider) ChatCompletionStream(ctx context.Context, req *ChatComp
 		genConfig.TopP = &topP
 	}
 
+	// 设置候选数，每个候选作为一个选择输出
+	if n := choiceCount(req); n > 1 {
+		genConfig.CandidateCount = int32(n)
+	}
+
 	// 设置停止序列
 	if len(req.Stop) > 0 {
 		genConfig.StopSequences = req.Stop
 	}
 
+	// 设置随机种子和重复惩罚
+	applyGeminiSamplingParams(genConfig, req)
+
 	// 转换工具定义为Gemini格式
 	if len(req.Tools) > 0 {
 		tools, err := p.convertToolsToGeminiFormat(req.Tools)
@@ -255,21 +258,28 @@ func (p *GeminiProvider) ChatCompletionStream(ctx context.Context, req *ChatComp
 		genConfig.Tools = tools
 	}
 
+	// 设置结构化输出格式
+	p.applyResponseFormat(genConfig, req)
+
 	// 启用思考模式 - 对于Gemini 2.5系列模型启用思考功能
-	// 但在转换为OpenAI格式时不包含思考内容
-	genConfig.ThinkingConfig = &genai.ThinkingConfig{
-		IncludeThoughts: false, // 转换为OpenAI格式时不包含思考内容
-		ThinkingBudget:  nil,   // 使用默认的动态思考预算
-	}
+	// 推理强度映射为思考预算，启用 include_reasoning 时思考内容作为 reasoning_content 返回
+	genConfig.ThinkingConfig = geminiThinkingConfig(req, p.includeReasoning(req))
 
 	streamChan := make(chan StreamResponse, 10)
 
 	go func() {
 		defer close(streamChan)
 
-		// 生成响应ID
-		responseID := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
-		created := time.Now().Unix()
+		// 每个候选对应一个选择，工具调用、结束原因和用量转换为OpenAI格式
+		transcoder := newChunkTranscoder(req)
+		send := func(data []byte, done bool) bool {
+			select {
+			case streamChan <- StreamResponse{Data: data, Done: done}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
 
 		// 使用官方SDK的真正流式功能
 		stream := p.client.Models.GenerateContentStream(ctx, req.Model, contents, genConfig)
@@ -292,38 +302,9 @@ func (p *GeminiProvider) ChatCompletionStream(ctx context.Context, req *ChatComp
 				continue
 			}
 
-			// 处理工具调用和文本内容
-			if len(chunk.Candidates) > 0 && len(chunk.Candidates[0].Content.Parts) > 0 {
-				for _, part := range chunk.Candidates[0].Content.Parts {
-					// 处理文本内容
-					if part.Text != "" && !part.Thought {
-						// 转义并创建OpenAI格式的流式数据
-						escapedContent := escapeJSONString(part.Text)
-						streamData := fmt.Sprintf("data: {\"id\":\"%s\",\"object\":\"chat.completion.chunk\",\"created\":%d,\"model\":\"%s\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"%s\"},\"finish_reason\":null}]}\n\n",
-							responseID, created, req.Model, escapedContent)
-
-						select {
-						case streamChan <- StreamResponse{
-							Data: []byte(streamData),
-							Done: false,
-						}:
-						case <-ctx.Done():
-							return
-						}
-					}
-					
-					// 处理工具调用
-					if part.FunctionCall != nil {
-						toolCallData := p.convertGeminiFunctionCallToOpenAI(part.FunctionCall, responseID, created, req.Model)
-						select {
-						case streamChan <- StreamResponse{
-							Data: []byte(toolCallData),
-							Done: false,
-						}:
-						case <-ctx.Done():
-							return
-						}
-					}
+			for _, data := range geminiStreamChunks(transcoder, chunk) {
+				if !send(data, false) {
+					return
 				}
 			}
 		}
@@ -331,17 +312,12 @@ func (p *GeminiProvider) ChatCompletionStream(ctx context.Context, req *ChatComp
 		// 记录成功请求
 		p.quotaManager.RecordSuccess()
 
-		// 发送结束标记
-		endData := fmt.Sprintf("data: {\"id\":\"%s\",\"object\":\"chat.completion.chunk\",\"created\":%d,\"model\":\"%s\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n",
-			responseID, created, req.Model)
-		streamChan <- StreamResponse{
-			Data: []byte(endData),
-			Done: false,
-		}
-
-		streamChan <- StreamResponse{
-			Data: []byte("data: [DONE]\n\n"),
-			Done: true,
+		// 发送每个选择的结束原因、用量和结束标记
+		chunks := transcoder.end()
+		for i, data := range chunks {
+			if !send(data, i == len(chunks)-1) {
+				return
+			}
 		}
 	}()
 
@@ -395,12 +371,15 @@ func (p *GeminiProvider) ChatCompletionStreamNative(ctx context.Context, req *Ch
 		genConfig.StopSequences = req.Stop
 	}
 
+	// 设置随机种子和重复惩罚
+	applyGeminiSamplingParams(genConfig, req)
+
+	// 设置结构化输出格式
+	p.applyResponseFormat(genConfig, req)
+
 	// 启用思考模式 - 对于Gemini 2.5系列模型启用思考功能
 	// 原生格式保留思考内容
-	genConfig.ThinkingConfig = &genai.ThinkingConfig{
-		IncludeThoughts: true,  // 原生格式包含思考内容
-		ThinkingBudget:  nil,   // 使用默认的动态思考预算
-	}
+	genConfig.ThinkingConfig = geminiThinkingConfig(req, true) // 原生格式包含思考内容
 
 	streamChan := make(chan StreamResponse, 10)
 
@@ -508,21 +487,56 @@ func (p *GeminiProvider) getDefaultModels() map[string]interface{} {
 	}
 }
 
-// fetchModelsFromAPI 从Google API获取模型列表
+// fetchModelsFromAPI 从Google API获取模型列表，分组设置了 models_path 时通过HTTP请求该路径，否则通过SDK获取
 func (p *GeminiProvider) fetchModelsFromAPI(ctx context.Context) ([]map[string]interface{}, error) {
-	// 使用HTTP客户端调用Google API
-	url := fmt.Sprintf("%s/v1beta/models?key=%s", p.Config.BaseURL, p.Config.APIKey)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if p.Config.ModelsPath != "" {
+		return p.fetchModelsFromPath(ctx)
+	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	var models []map[string]interface{}
+	for model, err := range p.client.Models.All(ctx) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch models: %w", err)
+		}
+		// 只包含支持generateContent的模型，Vertex AI的模型不返回支持的方法
+		if len(model.SupportedActions) > 0 && !slices.Contains(model.SupportedActions, "generateContent") {
+			continue
+		}
+		models = append(models, map[string]interface{}{
+			"id":       geminiModelID(model.Name),
+			"object":   "model",
+			"created":  time.Now().Unix(),
+			"owned_by": "google",
+		})
+	}
+	return models, nil
+}
+
+// geminiModelID 去掉模型名称的资源前缀，如 models/gemini-2.5-flash、publishers/google/models/gemini-2.5-flash
+func geminiModelID(name string) string {
+	if i := strings.LastIndex(name, "models/"); i >= 0 {
+		return name[i+len("models/"):]
+	}
+	return name
+}
+
+// fetchModelsFromPath 通过HTTP请求分组设置的模型列表路径，响应为Gemini API格式
+func (p *GeminiProvider) fetchModelsFromPath(ctx context.Context) ([]map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.Config.ModelsURL(), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	for key, value := range p.Config.Headers {
+		req.Header.Set(key, value)
+	}
+	if !isServiceAccountJSON(p.Config.APIKey) {
+		req.Header.Set("x-goog-api-key", p.Config.APIKey)
 	}
 
-	resp, err := client.Do(req)
+	resp, err := p.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch models: %w", err)
 	}
@@ -530,7 +544,7 @@ func (p *GeminiProvider) fetchModelsFromAPI(ctx context.Context) ([]map[string]i
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, ParseUpstreamError(resp.StatusCode, resp.Header, body)
 	}
 
 	var apiResponse struct {
@@ -565,14 +579,8 @@ func (p *GeminiProvider) fetchModelsFromAPI(ctx context.Context) ([]map[string]i
 		}
 
 		if supportsGeneration {
-			// 提取模型ID（去掉"models/"前缀）
-			modelID := model.Name
-			if strings.HasPrefix(modelID, "models/") {
-				modelID = strings.TrimPrefix(modelID, "models/")
-			}
-
 			models = append(models, map[string]interface{}{
-				"id":       modelID,
+				"id":       geminiModelID(model.Name),
 				"object":   "model",
 				"created":  time.Now().Unix(),
 				"owned_by": "google",
@@ -583,6 +591,9 @@ func (p *GeminiProvider) fetchModelsFromAPI(ctx context.Context) ([]map[string]i
 	return models, nil
 }
 
+// defaultGeminiHealthCheckModel 分组未配置健康检查模型时使用的默认模型
+const defaultGeminiHealthCheckModel = "gemini-2.5-flash"
+
 // HealthCheck 健康检查
 func (p *GeminiProvider) HealthCheck(ctx context.Context) error {
 	// 验证客户端
@@ -616,7 +627,11 @@ func (p *GeminiProvider) HealthCheck(ctx context.Context) error {
 		},
 	}
 
-	_, err := p.client.Models.GenerateContent(healthCtx, "gemini-1.5-flash", contents, genConfig)
+	model := p.Config.HealthCheckModel
+	if model == "" {
+		model = defaultGeminiHealthCheckModel
+	}
+	_, err := p.client.Models.GenerateContent(healthCtx, model, contents, genConfig)
 
 	if err != nil {
 		// 检查是否是配额限制错误
@@ -886,21 +901,34 @@ func (p *GeminiProvider) convertGenaiToOpenAIResponse(result *genai.GenerateCont
 	// 生成响应ID
 	responseID := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
 
-	// 提取文本内容，过滤掉思考内容
-	content := p.extractNonThoughtContent(result)
-	
-	// 提取工具调用
-	toolCalls := p.extractToolCalls(result)
+	// 每个候选对应一个选择，提取文本内容（过滤掉思考内容）、思考内容和工具调用
+	choices := make([]ChatCompletionChoice, 0, len(result.Candidates))
+	for i, candidate := range result.Candidates {
+		message := ChatCompletionMessage{
+			Role:             "assistant",
+			Content:          p.extractNonThoughtContent(candidate),
+			ReasoningContent: p.extractThoughtContent(candidate),
+		}
+		finishReason := geminiFinishReason(candidate.FinishReason)
 
-	// 构建OpenAI格式的响应
-	message := ChatCompletionMessage{
-		Role:    "assistant",
-		Content: content,
+		// 如果有工具调用，添加到消息中
+		if toolCalls := p.extractToolCalls(candidate); len(toolCalls) > 0 {
+			message.ToolCalls = toolCalls
+			if finishReason == "stop" {
+				finishReason = "tool_calls"
+			}
+		}
+		choices = append(choices, ChatCompletionChoice{
+			Index:        i,
+			Message:      message,
+			FinishReason: finishReason,
+		})
 	}
-	
-	// 如果有工具调用，添加到消息中
-	if len(toolCalls) > 0 {
-		message.ToolCalls = toolCalls
+	if len(choices) == 0 {
+		choices = append(choices, ChatCompletionChoice{
+			Message:      ChatCompletionMessage{Role: "assistant"},
+			FinishReason: "stop",
+		})
 	}
 
 	openaiResp := &ChatCompletionResponse{
@@ -908,13 +936,7 @@ func (p *GeminiProvider) convertGenaiToOpenAIResponse(result *genai.GenerateCont
 		Object:  "chat.completion",
 		Created: time.Now().Unix(),
 		Model:   model,
-		Choices: []ChatCompletionChoice{
-			{
-				Index:        0,
-				Message:      message,
-				FinishReason: "stop",
-			},
-		},
+		Choices: choices,
 		Usage: Usage{
 			PromptTokens:     0, // 官方SDK可能提供token计数，这里暂时设为0
 			CompletionTokens: 0,
@@ -924,34 +946,42 @@ func (p *GeminiProvider) convertGenaiToOpenAIResponse(result *genai.GenerateCont
 
 	// 如果有使用统计信息，更新token计数
 	if result.UsageMetadata != nil {
-		openaiResp.Usage.PromptTokens = int(result.UsageMetadata.PromptTokenCount)
-		openaiResp.Usage.CompletionTokens = int(result.UsageMetadata.CandidatesTokenCount)
-		openaiResp.Usage.TotalTokens = int(result.UsageMetadata.TotalTokenCount)
+		openaiResp.Usage = geminiUsage(result.UsageMetadata)
 	}
 
 	return openaiResp, nil
 }
 
-// extractNonThoughtContent 从Gemini响应中提取非思考内容
-func (p *GeminiProvider) extractNonThoughtContent(result *genai.GenerateContentResponse) string {
+// extractNonThoughtContent 从Gemini候选中提取非思考内容
+func (p *GeminiProvider) extractNonThoughtContent(candidate *genai.Candidate) string {
 	var content strings.Builder
-	
-	// 遍历所有候选响应
-	for _, candidate := range result.Candidates {
-		if candidate.Content != nil {
-			// 遍历内容部分，只提取非思考内容
-			for _, part := range candidate.Content.Parts {
-				// 只添加非思考的文本内容
-				if part.Text != "" && !part.Thought {
-					content.WriteString(part.Text)
-				}
+
+	if candidate.Content != nil {
+		// 遍历内容部分，只提取非思考内容
+		for _, part := range candidate.Content.Parts {
+			// 只添加非思考的文本内容
+			if part.Text != "" && !part.Thought {
+				content.WriteString(part.Text)
 			}
 		}
 	}
-	
+
 	return content.String()
 }
 
+// extractThoughtContent 从Gemini候选中提取思考内容，只有请求时设置了 IncludeThoughts 才会返回
+func (p *GeminiProvider) extractThoughtContent(candidate *genai.Candidate) string {
+	var thoughts strings.Builder
+	if candidate.Content != nil {
+		for _, part := range candidate.Content.Parts {
+			if part.Text != "" && part.Thought {
+				thoughts.WriteString(part.Text)
+			}
+		}
+	}
+	return thoughts.String()
+}
+
 // isQuotaExceededError 检查是否是配额超限错误
 func (p *GeminiProvider) isQuotaExceededError(err error) bool {
 	if err == nil {
@@ -993,23 +1023,6 @@ func (p *GeminiProvider) handleGeminiError(err error) error {
 	return fmt.Errorf("Gemini API error: %w", err)
 }
 
-// escapeJSONString 正确转义JSON字符串中的特殊字符
-func escapeJSONString(s string) string {
-	// 使用Go标准库的JSON编码来正确转义字符串
-	encoded, err := json.Marshal(s)
-	if err != nil {
-		// 如果JSON编码失败，手动转义关键字符
-		s = strings.ReplaceAll(s, "\\", "\\\\")
-		s = strings.ReplaceAll(s, "\"", "\\\"")
-		s = strings.ReplaceAll(s, "\n", "\\n")
-		s = strings.ReplaceAll(s, "\r", "\\r")
-		s = strings.ReplaceAll(s, "\t", "\\t")
-		return s
-	}
-	// 移除JSON编码添加的外层引号
-	return string(encoded[1 : len(encoded)-1])
-}
-
 // convertGeminiChunkToSSE 将Gemini原生响应块转换为SSE格式
 func (p *GeminiProvider) convertGeminiChunkToSSE(chunk *genai.GenerateContentResponse) ([]byte, error) {
 	// 构造Gemini原生响应格式
@@ -1086,7 +1099,7 @@ func (p *GeminiProvider) convertGeminiChunkToSSE(chunk *genai.GenerateContentRes
 // convertAssistantMessageWithToolCalls 转换包含工具调用的助手消息
 func (p *GeminiProvider) convertAssistantMessageWithToolCalls(msg ChatMessage) ([]*genai.Part, error) {
 	var parts []*genai.Part
-	
+
 	// 如果有文本内容，先添加文本部分
 	if msg.Content != nil {
 		textParts, err := p.convertMessageContentToParts(msg.Content)
@@ -1095,52 +1108,52 @@ func (p *GeminiProvider) convertAssistantMessageWithToolCalls(msg ChatMessage) (
 		}
 		parts = append(parts, textParts...)
 	}
-	
+
 	// 添加工具调用信息作为文本描述
 	// 注意：Gemini不直接支持OpenAI格式的工具调用，我们将其转换为文本描述
 	for _, toolCall := range msg.ToolCalls {
 		toolCallText := fmt.Sprintf("Tool call: %s(%s)", toolCall.Function.Name, toolCall.Function.Arguments)
 		parts = append(parts, genai.NewPartFromText(toolCallText))
 	}
-	
+
 	// 如果没有任何内容，添加一个空文本part
 	if len(parts) == 0 {
 		parts = append(parts, genai.NewPartFromText(""))
 	}
-	
+
 	return parts, nil
 }
 
 // convertToolMessageToParts 转换工具消息为Parts
 func (p *GeminiProvider) convertToolMessageToParts(msg ChatMessage) ([]*genai.Part, error) {
 	var parts []*genai.Part
-	
+
 	// 工具消息转换为用户消息，包含工具执行结果
 	toolResultText := fmt.Sprintf("Tool result for call %s: %v", msg.ToolCallID, msg.Content)
 	parts = append(parts, genai.NewPartFromText(toolResultText))
-	
+
 	return parts, nil
 }
 
 // convertToolsToGeminiFormat 转换OpenAI格式的工具定义为Gemini格式
 func (p *GeminiProvider) convertToolsToGeminiFormat(tools []Tool) ([]*genai.Tool, error) {
 	var geminiTools []*genai.Tool
-	
+
 	for _, tool := range tools {
 		if tool.Type != "function" {
 			continue // Gemini只支持函数工具
 		}
-		
+
 		if tool.Function == nil {
 			continue
 		}
-		
+
 		// 创建Gemini函数声明
 		funcDecl := &genai.FunctionDeclaration{
 			Name:        tool.Function.Name,
 			Description: tool.Function.Description,
 		}
-		
+
 		// 转换参数schema
 		if tool.Function.Parameters != nil {
 			// 将map[string]interface{}转换为genai.Schema
@@ -1150,70 +1163,71 @@ func (p *GeminiProvider) convertToolsToGeminiFormat(tools []Tool) ([]*genai.Tool
 			}
 			funcDecl.Parameters = schema
 		}
-		
+
 		// 创建Gemini工具
 		geminiTool := &genai.Tool{
 			FunctionDeclarations: []*genai.FunctionDeclaration{funcDecl},
 		}
-		
+
 		geminiTools = append(geminiTools, geminiTool)
 	}
-	
+
 	return geminiTools, nil
 }
 
-// convertGeminiFunctionCallToOpenAI 转换Gemini函数调用为OpenAI格式的流式数据
-func (p *GeminiProvider) convertGeminiFunctionCallToOpenAI(funcCall *genai.FunctionCall, responseID string, created int64, model string) string {
-	// 生成工具调用ID
-	toolCallID := fmt.Sprintf("call_%d", time.Now().UnixNano())
-	
-	// 转换参数为JSON字符串
-	argsBytes, _ := json.Marshal(funcCall.Args)
-	argsStr := string(argsBytes)
-	
-	// 创建OpenAI格式的工具调用流式数据
-	toolCallData := fmt.Sprintf(`data: {"id":"%s","object":"chat.completion.chunk","created":%d,"model":"%s","choices":[{"index":0,"delta":{"tool_calls":[{"id":"%s","type":"function","function":{"name":"%s","arguments":"%s"}}]},"finish_reason":null}]}
-
-`,
-		responseID, created, model, toolCallID, funcCall.Name, escapeJSONString(argsStr))
-	
-	return toolCallData
+// applyResponseFormat 将 response_format 转换为Gemini的 responseMimeType 和 responseJsonSchema
+// Gemini不支持函数调用与JSON输出同时使用，带工具的请求改为在系统指令中要求JSON输出
+func (p *GeminiProvider) applyResponseFormat(genConfig *genai.GenerateContentConfig, req *ChatCompletionRequest) {
+	if !req.ResponseFormat.WantsJSON() {
+		return
+	}
+	if len(req.Tools) > 0 {
+		genConfig.SystemInstruction = &genai.Content{
+			Parts: []*genai.Part{{Text: req.ResponseFormat.JSONInstruction()}},
+		}
+		return
+	}
+
+	genConfig.ResponseMIMEType = "application/json"
+	if schema := req.ResponseFormat.Schema(); schema != nil {
+		genConfig.ResponseJsonSchema = schema
+	}
 }
 
-// extractToolCalls 从Gemini响应中提取工具调用
-func (p *GeminiProvider) extractToolCalls(result *genai.GenerateContentResponse) []ToolCall {
+// extractToolCalls 从Gemini候选中提取工具调用
+func (p *GeminiProvider) extractToolCalls(candidate *genai.Candidate) []ToolCall {
 	var toolCalls []ToolCall
-	
-	// 遍历所有候选响应
-	for _, candidate := range result.Candidates {
-		if candidate.Content != nil {
-			// 遍历内容部分，查找函数调用
-			for _, part := range candidate.Content.Parts {
-				if part.FunctionCall != nil {
-					// 生成工具调用ID
-					toolCallID := fmt.Sprintf("call_%d", time.Now().UnixNano())
-					
-					// 转换参数为JSON字符串
-					argsBytes, err := json.Marshal(part.FunctionCall.Args)
-					if err != nil {
-						// 如果序列化失败，使用空对象
-						argsBytes = []byte("{}")
-					}
-					
-					toolCall := ToolCall{
-						ID:   toolCallID,
-						Type: "function",
-						Function: &FunctionCall{
-							Name:      part.FunctionCall.Name,
-							Arguments: string(argsBytes),
-						},
-					}
-					
-					toolCalls = append(toolCalls, toolCall)
+
+	if candidate.Content != nil {
+		// 遍历内容部分，查找函数调用
+		for i, part := range candidate.Content.Parts {
+			if part.FunctionCall != nil {
+				// 优先使用Gemini返回的调用ID，否则生成工具调用ID
+				toolCallID := part.FunctionCall.ID
+				if toolCallID == "" {
+					toolCallID = fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), i)
 				}
+
+				// 转换参数为JSON字符串
+				argsBytes, err := json.Marshal(part.FunctionCall.Args)
+				if err != nil || part.FunctionCall.Args == nil {
+					// 如果序列化失败，使用空对象
+					argsBytes = []byte("{}")
+				}
+
+				toolCall := ToolCall{
+					ID:   toolCallID,
+					Type: "function",
+					Function: &FunctionCall{
+						Name:      part.FunctionCall.Name,
+						Arguments: string(argsBytes),
+					},
+				}
+
+				toolCalls = append(toolCalls, toolCall)
 			}
 		}
 	}
-	
+
 	return toolCalls
 }
@@ -0,0 +1,128 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/auth/credentials"
+	"cloud.google.com/go/auth/httptransport"
+	"google.golang.org/genai"
+)
+
+// 默认的Vertex AI区域和OAuth作用域
+const (
+	DefaultVertexAILocation = "us-central1"
+
+	vertexAIScope = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// publicGeminiHost 公共Gemini API的主机名，Vertex AI模式下忽略该地址，使用区域默认地址
+const publicGeminiHost = "generativelanguage.googleapis.com"
+
+// geminiAPIVersionPattern BaseURL末尾的API版本段，如 v1、v1beta、v1beta1
+var geminiAPIVersionPattern = regexp.MustCompile(`^v\d+((alpha|beta)\d*)?$`)
+
+// VertexAIOptions Vertex AI认证模式的设置
+// 分组的密钥为服务账号JSON时使用OAuth认证，否则作为Vertex AI API密钥（快速模式）
+type VertexAIOptions struct {
+	Project  string // GCP项目ID，为空时使用服务账号中的 project_id
+	Location string // 区域，为空时使用 us-central1
+}
+
+// geminiEndpoint 把分组的BaseURL拆分为SDK使用的根地址和API版本，如 https://host/v1beta -> https://host、v1beta
+// BaseURL为空时都返回空字符串，使用SDK的默认值
+func geminiEndpoint(baseURL string) (string, string) {
+	baseURL = strings.TrimSpace(baseURL)
+	if baseURL == "" {
+		return "", ""
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return strings.TrimSuffix(baseURL, "/"), ""
+	}
+
+	path := strings.TrimSuffix(u.Path, "/")
+	version := ""
+	if i := strings.LastIndex(path, "/"); i >= 0 && geminiAPIVersionPattern.MatchString(path[i+1:]) {
+		version = path[i+1:]
+		path = path[:i]
+	}
+	u.Path = path
+	u.RawQuery, u.Fragment = "", ""
+	return u.String(), version
+}
+
+// isServiceAccountJSON 密钥是否为服务账号JSON
+func isServiceAccountJSON(apiKey string) bool {
+	return strings.HasPrefix(strings.TrimSpace(apiKey), "{")
+}
+
+// geminiClientConfig 创建Gemini SDK客户端设置：使用分组的BaseURL和请求头，请求经过统一的出站传输层
+// Vertex AI模式下服务账号JSON通过OAuth认证，令牌请求同样经过出站传输层
+func geminiClientConfig(ctx context.Context, config *ProviderConfig, transport http.RoundTripper) (*genai.ClientConfig, error) {
+	baseURL, apiVersion := geminiEndpoint(config.BaseURL)
+	clientConfig := &genai.ClientConfig{
+		HTTPClient: &http.Client{Transport: transport},
+		HTTPOptions: genai.HTTPOptions{
+			BaseURL:    baseURL,
+			APIVersion: apiVersion,
+		},
+	}
+	if len(config.Headers) > 0 {
+		clientConfig.HTTPOptions.Headers = make(http.Header, len(config.Headers))
+		for key, value := range config.Headers {
+			clientConfig.HTTPOptions.Headers.Set(key, value)
+		}
+	}
+
+	vertex := config.VertexAI
+	if vertex == nil {
+		clientConfig.Backend = genai.BackendGeminiAPI
+		clientConfig.APIKey = config.APIKey
+		if clientConfig.HTTPOptions.APIVersion == "" {
+			clientConfig.HTTPOptions.APIVersion = "v1beta"
+		}
+		return clientConfig, nil
+	}
+
+	clientConfig.Backend = genai.BackendVertexAI
+	if u, err := url.Parse(baseURL); err == nil && u.Host == publicGeminiHost {
+		clientConfig.HTTPOptions.BaseURL = ""
+		clientConfig.HTTPOptions.APIVersion = ""
+	}
+	if !isServiceAccountJSON(config.APIKey) {
+		clientConfig.APIKey = config.APIKey
+		return clientConfig, nil
+	}
+
+	creds, err := credentials.DetectDefault(&credentials.DetectOptions{
+		Scopes:          []string{vertexAIScope},
+		CredentialsJSON: []byte(config.APIKey),
+		Client:          &http.Client{Transport: transport},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid service account credentials: %w", err)
+	}
+	clientConfig.Project = vertex.Project
+	if clientConfig.Project == "" {
+		if clientConfig.Project, err = creds.ProjectID(ctx); err != nil || clientConfig.Project == "" {
+			return nil, fmt.Errorf("vertex_ai project is required when the service account has no project_id")
+		}
+	}
+	clientConfig.Location = vertex.Location
+	if clientConfig.Location == "" {
+		clientConfig.Location = DefaultVertexAILocation
+	}
+	clientConfig.HTTPClient, err = httptransport.NewClient(&httptransport.Options{
+		Credentials:      creds,
+		BaseRoundTripper: transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Vertex AI client: %w", err)
+	}
+	return clientConfig, nil
+}
@@ -5,14 +5,18 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
 // ToolCallError 工具调用相关的错误类型
 type ToolCallError struct {
-	Type    string `json:"type"`
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Type       string        `json:"type"`
+	Code       string        `json:"code"`
+	Message    string        `json:"message"`
+	RetryAfter time.Duration `json:"-"` // 上游限流时建议的等待时间
+	StatusCode int           `json:"-"` // 上游返回的HTTP状态码
+	Category   ErrorCategory `json:"-"` // 归一化的错误分类
 }
 
 func (e *ToolCallError) Error() string {
@@ -22,16 +26,16 @@ func (e *ToolCallError) Error() string {
 // ChatMessage 聊天消息结构，支持多模态内容和工具调用
 type ChatMessage struct {
 	Role       string      `json:"role"`
-	Content    interface{} `json:"content"` // 支持字符串或多模态内容数组
-	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`  // 工具调用（assistant消息）
+	Content    interface{} `json:"content"`                // 支持字符串或多模态内容数组
+	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`   // 工具调用（assistant消息）
 	ToolCallID string      `json:"tool_call_id,omitempty"` // 工具调用ID（tool消息）
 }
 
 // MessageContent 消息内容结构（用于多模态）
 type MessageContent struct {
-	Type     string            `json:"type"`     // "text" 或 "image_url"
-	Text     string            `json:"text,omitempty"`
-	ImageURL *MessageImageURL  `json:"image_url,omitempty"`
+	Type     string           `json:"type"` // "text" 或 "image_url"
+	Text     string           `json:"text,omitempty"`
+	ImageURL *MessageImageURL `json:"image_url,omitempty"`
 }
 
 // MessageImageURL 图像URL结构
@@ -42,7 +46,7 @@ type MessageImageURL struct {
 
 // Tool 工具定义结构
 type Tool struct {
-	Type     string    `json:"type"`               // "function"
+	Type     string    `json:"type"` // "function"
 	Function *Function `json:"function,omitempty"`
 }
 
@@ -59,8 +63,8 @@ type ToolChoice interface{}
 
 // ToolChoiceFunction 指定特定函数的工具选择
 type ToolChoiceFunction struct {
-	Type     string           `json:"type"`     // "function"
-	Function *ToolChoiceFunc  `json:"function"`
+	Type     string          `json:"type"` // "function"
+	Function *ToolChoiceFunc `json:"function"`
 }
 
 // ToolChoiceFunc 工具选择函数
@@ -71,7 +75,7 @@ type ToolChoiceFunc struct {
 // ToolCall 工具调用结构
 type ToolCall struct {
 	ID       string        `json:"id"`
-	Type     string        `json:"type"`               // "function"
+	Type     string        `json:"type"` // "function"
 	Function *FunctionCall `json:"function,omitempty"`
 }
 
@@ -83,16 +87,36 @@ type FunctionCall struct {
 
 // ChatCompletionRequest 聊天完成请求结构
 type ChatCompletionRequest struct {
-	Model             string        `json:"model"`
-	Messages          []ChatMessage `json:"messages"`
-	Temperature       *float64      `json:"temperature,omitempty"`
-	MaxTokens         *int          `json:"max_tokens,omitempty"`
-	Stream            bool          `json:"stream,omitempty"`
-	TopP              *float64      `json:"top_p,omitempty"`
-	Stop              []string      `json:"stop,omitempty"`
-	Tools             []Tool        `json:"tools,omitempty"`
-	ToolChoice        ToolChoice    `json:"tool_choice,omitempty"`
-	ParallelToolCalls *bool         `json:"parallel_tool_calls,omitempty"`
+	Model               string          `json:"model"`
+	Messages            []ChatMessage   `json:"messages"`
+	Temperature         *float64        `json:"temperature,omitempty"`
+	MaxTokens           *int            `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int            `json:"max_completion_tokens,omitempty"` // o系列模型使用
+	Stream              bool            `json:"stream,omitempty"`
+	StreamOptions       *StreamOptions  `json:"stream_options,omitempty"` // 流式请求选项，如 include_usage
+	N                   *int            `json:"n,omitempty"`              // 生成的选择数
+	TopP                *float64        `json:"top_p,omitempty"`
+	Stop                []string        `json:"stop,omitempty"`
+	Seed                *int            `json:"seed,omitempty"`              // 随机种子
+	FrequencyPenalty    *float64        `json:"frequency_penalty,omitempty"` // 频率惩罚
+	PresencePenalty     *float64        `json:"presence_penalty,omitempty"`  // 存在惩罚
+	Tools               []Tool          `json:"tools,omitempty"`
+	ToolChoice          ToolChoice      `json:"tool_choice,omitempty"`
+	ParallelToolCalls   *bool           `json:"parallel_tool_calls,omitempty"`
+	ResponseFormat      *ResponseFormat `json:"response_format,omitempty"`  // 结构化输出格式（JSON模式）
+	ReasoningEffort     string          `json:"reasoning_effort,omitempty"` // 推理强度（low、medium、high），Gemini和Anthropic映射为思考预算
+	// OpenRouter专用参数
+	Provider   map[string]interface{} `json:"provider,omitempty"`   // 提供商路由偏好
+	Transforms []string               `json:"transforms,omitempty"` // 消息变换（如 "middle-out"）
+	// TurnsAPI扩展参数，只在代理内部使用，不会发送到上游
+	TurnsAPI         *TurnsAPIOptions `json:"turnsapi,omitempty"`
+	IncludeReasoning *bool            `json:"-"` // 是否返回思考内容，由代理按 turnsapi.include_reasoning 设置，为空时使用分组设置
+}
+
+// TurnsAPIOptions 请求中的 turnsapi 扩展参数
+type TurnsAPIOptions struct {
+	Quality          string `json:"quality,omitempty"`           // 自动模型别名的质量等级：high、medium 或 low
+	IncludeReasoning *bool  `json:"include_reasoning,omitempty"` // 是否在响应中返回思考内容，覆盖分组的 include_reasoning
 }
 
 // ApplyRequestParams 应用请求参数覆盖
@@ -139,37 +163,122 @@ func (req *ChatCompletionRequest) ApplyRequestParams(params map[string]interface
 			req.Stop = stopSlice
 		}
 	}
+
+	// 应用随机种子
+	if seed, ok := params["seed"]; ok {
+		if seedFloat, ok := seed.(float64); ok {
+			seedInt := int(seedFloat)
+			req.Seed = &seedInt
+		} else if seedInt, ok := seed.(int); ok {
+			req.Seed = &seedInt
+		}
+	}
+
+	// 应用频率惩罚和存在惩罚
+	if penalty, ok := params["frequency_penalty"]; ok {
+		if penaltyFloat, ok := penalty.(float64); ok {
+			req.FrequencyPenalty = &penaltyFloat
+		}
+	}
+	if penalty, ok := params["presence_penalty"]; ok {
+		if penaltyFloat, ok := penalty.(float64); ok {
+			req.PresencePenalty = &penaltyFloat
+		}
+	}
+
+	// 应用OpenRouter提供商路由偏好
+	if provider, ok := params["provider"]; ok {
+		if providerMap, ok := stringKeyedValue(provider).(map[string]interface{}); ok {
+			req.Provider = providerMap
+		}
+	}
+
+	// 应用OpenRouter消息变换
+	if transforms, ok := params["transforms"]; ok {
+		if transformSlice, ok := transforms.([]interface{}); ok {
+			transformStrings := make([]string, 0, len(transformSlice))
+			for _, t := range transformSlice {
+				if str, ok := t.(string); ok {
+					transformStrings = append(transformStrings, str)
+				}
+			}
+			req.Transforms = transformStrings
+		} else if transformSlice, ok := transforms.([]string); ok {
+			req.Transforms = transformSlice
+		}
+	}
+}
+
+// PrependSystemMessage 在消息列表最前面插入一条system消息
+// 使用新的切片，不影响与原请求共享的消息列表
+func (req *ChatCompletionRequest) PrependSystemMessage(content string) {
+	messages := make([]ChatMessage, 0, len(req.Messages)+1)
+	messages = append(messages, ChatMessage{Role: "system", Content: content})
+	req.Messages = append(messages, req.Messages...)
+}
+
+// SystemPromptVars 系统提示词模板变量的取值
+type SystemPromptVars struct {
+	Date         string // 当前日期（2006-01-02）
+	ProxyKeyName string // 调用方的代理密钥名称
+	Group        string // 处理请求的分组ID
+	Model        string // 客户端请求的模型名称
+}
+
+// ExpandSystemPrompt 替换系统提示词中的模板变量
+// 支持 {{date}}、{{proxy_key_name}}、{{key_name}}、{{group}}、{{model}}，以及对应的单花括号写法
+func ExpandSystemPrompt(prompt string, vars SystemPromptVars) string {
+	return strings.NewReplacer(
+		"{{date}}", vars.Date,
+		"{{proxy_key_name}}", vars.ProxyKeyName,
+		"{{key_name}}", vars.ProxyKeyName,
+		"{{group}}", vars.Group,
+		"{{model}}", vars.Model,
+		"{date}", vars.Date,
+		"{proxy_key_name}", vars.ProxyKeyName,
+		"{key_name}", vars.ProxyKeyName,
+		"{group}", vars.Group,
+		"{model}", vars.Model,
+	).Replace(prompt)
 }
 
 // ChatCompletionChoice 聊天完成选择结构
 type ChatCompletionChoice struct {
-	Index        int                      `json:"index"`
-	Message      ChatCompletionMessage    `json:"message"`
-	FinishReason string                   `json:"finish_reason"`
+	Index        int                   `json:"index"`
+	Message      ChatCompletionMessage `json:"message"`
+	FinishReason string                `json:"finish_reason"`
 }
 
 // ChatCompletionMessage 聊天完成消息结构
 type ChatCompletionMessage struct {
-	Role      string     `json:"role"`
-	Content   string     `json:"content"`
-	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	Role             string     `json:"role"`
+	Content          string     `json:"content"`
+	ReasoningContent string     `json:"reasoning_content,omitempty"` // 模型的思考内容，分组未启用 include_reasoning 时去除
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
 }
 
 // Usage 使用情况统计
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens            int                      `json:"prompt_tokens"`
+	CompletionTokens        int                      `json:"completion_tokens"`
+	TotalTokens             int                      `json:"total_tokens"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"` // 输出token明细，推理模型返回思考token数
+}
+
+// CompletionTokensDetails 输出token明细，思考token包含在 completion_tokens 中
+type CompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
 }
 
 // ChatCompletionResponse 聊天完成响应结构
 type ChatCompletionResponse struct {
-	ID      string                  `json:"id"`
-	Object  string                  `json:"object"`
-	Created int64                   `json:"created"`
-	Model   string                  `json:"model"`
-	Choices []ChatCompletionChoice  `json:"choices"`
-	Usage   Usage                   `json:"usage"`
+	ID       string                 `json:"id"`
+	Object   string                 `json:"object"`
+	Created  int64                  `json:"created"`
+	Model    string                 `json:"model"`
+	Choices  []ChatCompletionChoice `json:"choices"`
+	Usage    Usage                  `json:"usage"`
+	Provider string                 `json:"provider,omitempty"` // OpenRouter实际使用的上游提供商
 }
 
 // StreamResponse 流式响应结构
@@ -181,13 +290,23 @@ type StreamResponse struct {
 
 // ProviderConfig 提供商配置
 type ProviderConfig struct {
-	BaseURL          string
-	APIKey           string
-	Timeout          time.Duration
-	MaxRetries       int
-	Headers          map[string]string
-	ProviderType     string
-	RequestParams    map[string]interface{} // JSON请求参数覆盖
+	BaseURL             string
+	APIKey              string
+	Timeout             time.Duration
+	MaxRetries          int
+	Headers             map[string]string
+	ProviderType        string
+	RequestParams       map[string]interface{}                                  // JSON请求参数覆盖
+	ChatCompletionsPath string                                                  // 聊天完成接口路径覆盖，为空时使用提供商默认路径
+	ModelsPath          string                                                  // 模型列表接口路径覆盖，为空时使用提供商默认路径
+	HealthCheckModel    string                                                  // 健康检查使用的模型；OpenAI和Anthropic为空时只检查模型列表接口，Gemini为空时使用默认模型
+	ProxyURL            string                                                  // 出站代理地址（http、https、socks5），为空时直连
+	TLS                 *TLSOptions                                             // 上游TLS设置（私有CA、客户端证书），为空时使用系统默认
+	Transport           *TransportOptions                                       // 分组的连接池设置，为空时使用全局设置
+	IncludeReasoning    bool                                                    // 是否在响应中返回模型的思考内容（reasoning_content）
+	DefaultMaxTokens    int                                                     // 请求未指定max_tokens时使用的默认值，0表示使用提供商默认值
+	VertexAI            *VertexAIOptions                                        // Gemini的Vertex AI认证模式，为空时使用Gemini API密钥
+	ResponseObserver    func(apiKey string, statusCode int, header http.Header) // 上游响应观察者，用于采集额度响应头
 }
 
 // Provider 提供商接口
@@ -237,10 +356,24 @@ type BaseProvider struct {
 
 // NewBaseProvider 创建基础提供商
 func NewBaseProvider(config *ProviderConfig) *BaseProvider {
+	// 请求的总超时由调用方的context控制，这里只作为兜底，不短于分组配置的超时
+	clientTimeout := 10 * time.Minute
+	if config.Timeout > clientTimeout {
+		clientTimeout = config.Timeout
+	}
 	return &BaseProvider{
 		Config: config,
 		HTTPClient: &http.Client{
-			Timeout: 10 * time.Minute, // 硬编码为10分钟超时
+			Timeout: clientTimeout,
+			Transport: &tracingTransport{
+				base: &responseObserverTransport{
+					base: &upstreamIdentityTransport{
+						base:         &stageTimeoutTransport{base: outboundTransport(config.ProxyURL, config.TLS, config.Transport)},
+						groupHeaders: config.Headers,
+					},
+					observe: config.ResponseObserver,
+				},
+			},
 		},
 	}
 }
@@ -253,22 +386,22 @@ func (bp *BaseProvider) GetProviderType() string {
 // CreateHTTPRequest 创建HTTP请求
 func (bp *BaseProvider) CreateHTTPRequest(ctx context.Context, endpoint string, body interface{}) (*http.Request, error) {
 	var bodyReader io.Reader
-	
+
 	if body != nil {
 		// 这里需要根据具体实现来序列化body
 		// 在具体的提供商实现中会重写这个方法
 	}
-	
+
 	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bodyReader)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	// 设置通用头部
 	for key, value := range bp.Config.Headers {
 		req.Header.Set(key, value)
 	}
-	
+
 	return req, nil
 }
 
@@ -278,6 +411,20 @@ func (bp *BaseProvider) HealthCheck(ctx context.Context) error {
 	return nil
 }
 
+// modelHealthCheck 使用健康检查模型发送一个最小的聊天请求，模型不可用或密钥无权访问时返回错误
+func modelHealthCheck(ctx context.Context, provider Provider, model string) error {
+	maxTokens := 1
+	req := NormalizeRequestForModel(provider.GetProviderType(), &ChatCompletionRequest{
+		Model:     model,
+		Messages:  []ChatMessage{{Role: "user", Content: "hi"}},
+		MaxTokens: &maxTokens,
+	})
+	if _, err := provider.ChatCompletion(ctx, req); err != nil {
+		return fmt.Errorf("health check with model %s failed: %w", model, err)
+	}
+	return nil
+}
+
 // ChatCompletionStreamNative 默认原生流式响应实现
 func (bp *BaseProvider) ChatCompletionStreamNative(ctx context.Context, req *ChatCompletionRequest) (<-chan StreamResponse, error) {
 	// 默认实现：调用标准流式响应
@@ -290,3 +437,28 @@ func (bp *BaseProvider) ChatCompletionStream(ctx context.Context, req *ChatCompl
 	// 默认实现，具体提供商需要重写
 	return nil, fmt.Errorf("streaming not implemented for this provider")
 }
+
+// stringKeyedValue 将YAML解析得到的 map[interface{}]interface{} 递归转换为可JSON序列化的 map[string]interface{}
+func stringKeyedValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprintf("%v", key)] = stringKeyedValue(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = stringKeyedValue(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = stringKeyedValue(item)
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,155 @@
+package providers
+
+import (
+	"strings"
+)
+
+// ModelRule 模型请求规范化规则
+type ModelRule struct {
+	ProviderTypes          []string // 适用的提供商类型，其他提供商有各自的参数约定，不做处理
+	Prefixes               []string // 匹配的模型名称前缀（如 "o1" 匹配 "o1"、"o1-mini"）
+	MergeSystemMessage     bool     // 是否将system消息合并到第一条user消息
+	DropParams             []string // 需要移除的不支持参数
+	UseMaxCompletionTokens bool     // 是否使用max_completion_tokens替代max_tokens
+}
+
+// modelCatalog 模型目录，定义需要特殊处理的模型及其规则
+var modelCatalog = []ModelRule{
+	{
+		// OpenAI o系列推理模型：不支持system消息和采样参数
+		ProviderTypes:          []string{"openai", "azure_openai"},
+		Prefixes:               []string{"o1", "o3", "o4"},
+		MergeSystemMessage:     true,
+		DropParams:             []string{"temperature", "top_p", "parallel_tool_calls"},
+		UseMaxCompletionTokens: true,
+	},
+}
+
+// FindModelRule 根据模型名称查找规范化规则，未匹配时返回nil
+func FindModelRule(model string) *ModelRule {
+	name := strings.ToLower(model)
+	// 去掉 "openai/o1-mini" 这类带厂商前缀的名称
+	if idx := strings.LastIndex(name, "/"); idx != -1 {
+		name = name[idx+1:]
+	}
+
+	for i := range modelCatalog {
+		for _, prefix := range modelCatalog[i].Prefixes {
+			if name == prefix || strings.HasPrefix(name, prefix+"-") {
+				return &modelCatalog[i]
+			}
+		}
+	}
+	return nil
+}
+
+// NormalizeRequestForModel 根据模型目录规范化发往指定提供商类型的请求
+// 不需要处理时返回原请求，否则返回处理后的副本，原请求保持不变
+func NormalizeRequestForModel(providerType string, req *ChatCompletionRequest) *ChatCompletionRequest {
+	rule := FindModelRule(req.Model)
+	if rule == nil || !rule.appliesTo(providerType) {
+		return req
+	}
+
+	normalized := *req
+
+	for _, param := range rule.DropParams {
+		switch param {
+		case "temperature":
+			normalized.Temperature = nil
+		case "top_p":
+			normalized.TopP = nil
+		case "stop":
+			normalized.Stop = nil
+		case "parallel_tool_calls":
+			normalized.ParallelToolCalls = nil
+		case "max_tokens":
+			normalized.MaxTokens = nil
+		}
+	}
+
+	if rule.UseMaxCompletionTokens && normalized.MaxTokens != nil {
+		if normalized.MaxCompletionTokens == nil {
+			normalized.MaxCompletionTokens = normalized.MaxTokens
+		}
+		normalized.MaxTokens = nil
+	}
+
+	if rule.MergeSystemMessage {
+		normalized.Messages = mergeSystemMessages(req.Messages)
+	}
+
+	return &normalized
+}
+
+// appliesTo 规则是否适用于该提供商类型，未限定提供商类型时适用于所有提供商
+func (r *ModelRule) appliesTo(providerType string) bool {
+	if len(r.ProviderTypes) == 0 {
+		return true
+	}
+	for _, t := range r.ProviderTypes {
+		if t == providerType {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeSystemMessages 将所有system消息合并到第一条user消息之前
+func mergeSystemMessages(messages []ChatMessage) []ChatMessage {
+	var systemParts []string
+	result := make([]ChatMessage, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role == "system" || msg.Role == "developer" {
+			if text := extractMessageText(msg.Content); text != "" {
+				systemParts = append(systemParts, text)
+			}
+			continue
+		}
+		result = append(result, msg)
+	}
+
+	if len(systemParts) == 0 {
+		return result
+	}
+	systemText := strings.Join(systemParts, "\n\n")
+
+	for i, msg := range result {
+		if msg.Role != "user" {
+			continue
+		}
+		switch content := msg.Content.(type) {
+		case string:
+			result[i].Content = systemText + "\n\n" + content
+		case []interface{}:
+			merged := make([]interface{}, 0, len(content)+1)
+			merged = append(merged, map[string]interface{}{"type": "text", "text": systemText})
+			result[i].Content = append(merged, content...)
+		default:
+			result[i].Content = systemText
+		}
+		return result
+	}
+
+	// 没有user消息时，将system内容作为第一条user消息
+	return append([]ChatMessage{{Role: "user", Content: systemText}}, result...)
+}
+
+// extractMessageText 提取消息中的文本内容
+func extractMessageText(content interface{}) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []interface{}:
+		var parts []string
+		for _, item := range c {
+			if itemMap, ok := item.(map[string]interface{}); ok {
+				if text, ok := itemMap["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
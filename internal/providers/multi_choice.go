@@ -0,0 +1,91 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// maxChoices 单个请求最多生成的选择数，与OpenAI的上限一致
+const maxChoices = 128
+
+// FansOutChoices 提供商是否不支持 n，需要为每个选择单独发送一个上游请求
+func FansOutChoices(providerType string) bool {
+	return providerType == "anthropic"
+}
+
+// ChoiceCount 请求的选择数（n），未指定或小于1时为1，不超过128
+func ChoiceCount(req *ChatCompletionRequest) int {
+	return choiceCount(req)
+}
+
+// choiceCount 请求的选择数（n），未指定或小于1时为1
+func choiceCount(req *ChatCompletionRequest) int {
+	if req.N == nil || *req.N < 1 {
+		return 1
+	}
+	if *req.N > maxChoices {
+		return maxChoices
+	}
+	return *req.N
+}
+
+// parallelChatCompletion 为不支持 n 的上游并行发送 n 个单选择请求并合并为一个响应
+// 选择按请求顺序编号，用量为所有请求之和；任一请求失败时返回该错误
+func parallelChatCompletion(ctx context.Context, req *ChatCompletionRequest, n int,
+	complete func(context.Context, *ChatCompletionRequest) (*ChatCompletionResponse, error)) (*ChatCompletionResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	single := *req
+	single.N = nil
+
+	responses := make([]*ChatCompletionResponse, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i], errs[i] = complete(ctx, &single)
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 优先返回导致取消的错误，而不是其他请求因取消产生的错误
+	var canceledErr error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+		if canceledErr == nil {
+			canceledErr = err
+		}
+	}
+	if canceledErr != nil {
+		return nil, canceledErr
+	}
+
+	merged := *responses[0]
+	merged.Choices = make([]ChatCompletionChoice, 0, n)
+	merged.Usage = Usage{}
+	reasoningTokens := 0
+	for _, response := range responses {
+		for _, choice := range response.Choices {
+			choice.Index = len(merged.Choices)
+			merged.Choices = append(merged.Choices, choice)
+		}
+		merged.Usage.PromptTokens += response.Usage.PromptTokens
+		merged.Usage.CompletionTokens += response.Usage.CompletionTokens
+		merged.Usage.TotalTokens += response.Usage.TotalTokens
+		reasoningTokens += response.Usage.reasoningTokens()
+	}
+	merged.Usage.setReasoningTokens(reasoningTokens)
+	return &merged, nil
+}
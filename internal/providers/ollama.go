@@ -0,0 +1,93 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ollamaTagsPath Ollama 原生的本地模型列表接口
+const ollamaTagsPath = "/api/tags"
+
+// ollamaTagsURL 根据BaseURL（通常为 http://host:11434/v1）得到 Ollama 原生模型列表接口的地址
+func ollamaTagsURL(baseURL string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+	u.Path = ollamaTagsPath
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// getOllamaModels 通过 /api/tags 获取 Ollama 的本地模型列表，并转换为OpenAI格式
+func (p *OpenAIProvider) getOllamaModels(ctx context.Context) (interface{}, error) {
+	endpoint, err := ollamaTagsURL(p.Config.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setAuthorization(httpReq)
+
+	resp, err := p.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, p.handleAPIError(resp.StatusCode, body)
+	}
+
+	var models interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return normalizeOllamaModels(models), nil
+}
+
+// normalizeOllamaModels 将 Ollama /api/tags 的响应（{"models":[{"name":...}]}）转换为OpenAI模型列表格式，其他格式原样返回
+func normalizeOllamaModels(models interface{}) interface{} {
+	response, ok := models.(map[string]interface{})
+	if !ok {
+		return models
+	}
+	if _, hasData := response["data"]; hasData {
+		return models
+	}
+	tags, ok := response["models"].([]interface{})
+	if !ok {
+		return models
+	}
+
+	data := make([]interface{}, 0, len(tags))
+	for _, tag := range tags {
+		tagMap, ok := tag.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := tagMap["name"].(string)
+		if name == "" {
+			name, _ = tagMap["model"].(string)
+		}
+		if name == "" {
+			continue
+		}
+		data = append(data, map[string]interface{}{
+			"id":       name,
+			"object":   "model",
+			"owned_by": "ollama",
+		})
+	}
+	return map[string]interface{}{
+		"object": "list",
+		"data":   data,
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // OpenAIProvider OpenAI格式提供商
@@ -29,47 +30,52 @@ func (p *OpenAIProvider) ChatCompletion(ctx context.Context, req *ChatCompletion
 	if err := p.validateToolCallRequest(req); err != nil {
 		return nil, fmt.Errorf("tool call validation failed: %w", err)
 	}
-	
+
 	// OpenAI格式不需要转换，直接使用
-	endpoint := fmt.Sprintf("%s/chat/completions", p.Config.BaseURL)
-	
+	endpoint := p.Config.ChatCompletionsURL()
+
 	reqBody, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
-	
+
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	
+
 	// 设置头部
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+p.Config.APIKey)
-	
+	p.setAuthorization(httpReq)
+
 	// 设置自定义头部
 	for key, value := range p.Config.Headers {
 		if key != "Authorization" { // 避免覆盖Authorization头
 			httpReq.Header.Set(key, value)
 		}
 	}
-	
+
 	resp, err := p.HTTPClient.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
-	
+
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, p.handleAPIError(resp.StatusCode, body)
+		return nil, p.handleAPIErrorWithHeaders(resp.StatusCode, resp.Header, body)
 	}
-	
+
 	var response ChatCompletionResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
-	
+
+	// 未启用 include_reasoning 时去除思考内容
+	if !p.includeReasoning(req) {
+		stripReasoningContent(&response)
+	}
+
 	return &response, nil
 }
 
@@ -79,62 +85,76 @@ func (p *OpenAIProvider) ChatCompletionStream(ctx context.Context, req *ChatComp
 	if err := p.validateToolCallRequest(req); err != nil {
 		return nil, fmt.Errorf("tool call validation failed: %w", err)
 	}
-	
+
 	// 确保设置stream为true
 	req.Stream = true
-	
-	endpoint := fmt.Sprintf("%s/chat/completions", p.Config.BaseURL)
-	
+
+	endpoint := p.Config.ChatCompletionsURL()
+
 	reqBody, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
-	
+
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	
+
 	// 设置头部
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+p.Config.APIKey)
+	p.setAuthorization(httpReq)
 	httpReq.Header.Set("Accept", "text/event-stream")
 	httpReq.Header.Set("Cache-Control", "no-cache")
-	
+
 	// 设置自定义头部
 	for key, value := range p.Config.Headers {
 		if key != "Authorization" {
 			httpReq.Header.Set(key, value)
 		}
 	}
-	
+
 	resp, err := p.HTTPClient.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
-	
+
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, p.handleAPIError(resp.StatusCode, body)
+		return nil, p.handleAPIErrorWithHeaders(resp.StatusCode, resp.Header, body)
 	}
-	
+
 	streamChan := make(chan StreamResponse, 10)
-	
+	includeReasoning := p.includeReasoning(req)
+	usage := newOpenAIStreamUsage(req)
+
 	go func() {
 		defer close(streamChan)
 		defer resp.Body.Close()
-		
+
 		scanner := bufio.NewScanner(resp.Body)
 		for scanner.Scan() {
 			line := scanner.Text()
-			
+			if !includeReasoning {
+				// 未启用 include_reasoning 时去除思考内容
+				line = stripReasoningLine(line)
+			}
+			usage.observe(line)
+
+			// 请求了 include_usage 而上游没有报告用量时，在 [DONE] 之前补发估算的用量
+			if strings.Contains(line, "[DONE]") {
+				if chunk := usage.chunk(); chunk != nil {
+					streamChan <- StreamResponse{Data: chunk}
+				}
+			}
+
 			// 发送原始数据行
 			streamChan <- StreamResponse{
 				Data: []byte(line + "\n"),
 				Done: false,
 			}
-			
+
 			// 检查是否结束
 			if strings.Contains(line, "[DONE]") {
 				streamChan <- StreamResponse{
@@ -143,7 +163,7 @@ func (p *OpenAIProvider) ChatCompletionStream(ctx context.Context, req *ChatComp
 				return
 			}
 		}
-		
+
 		if err := scanner.Err(); err != nil {
 			streamChan <- StreamResponse{
 				Error: err,
@@ -151,7 +171,7 @@ func (p *OpenAIProvider) ChatCompletionStream(ctx context.Context, req *ChatComp
 			}
 		}
 	}()
-	
+
 	return streamChan, nil
 }
 
@@ -163,45 +183,60 @@ func (p *OpenAIProvider) ChatCompletionStreamNative(ctx context.Context, req *Ch
 
 // GetModels 获取可用模型列表
 func (p *OpenAIProvider) GetModels(ctx context.Context) (interface{}, error) {
-	endpoint := fmt.Sprintf("%s/models", p.Config.BaseURL)
-	
+	endpoint := p.Config.ModelsURL()
+
 	httpReq, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	
-	httpReq.Header.Set("Authorization", "Bearer "+p.Config.APIKey)
+
+	p.setAuthorization(httpReq)
 	httpReq.Header.Set("Content-Type", "application/json")
-	
+
 	resp, err := p.HTTPClient.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
-	
+
+	if resp.StatusCode == http.StatusNotFound && p.Config.ProviderType == OpenAICompatibleProviderType && p.Config.ModelsPath == "" {
+		// 旧版本Ollama没有OpenAI兼容的模型列表接口，改用 /api/tags
+		return p.getOllamaModels(ctx)
+	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		return nil, p.handleAPIError(resp.StatusCode, body)
 	}
-	
+
 	var models interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
-	
-	return models, nil
+
+	return normalizeOllamaModels(models), nil
 }
 
-// HealthCheck 健康检查
+// setAuthorization 设置密钥请求头，无需密钥的本地后端不发送
+func (p *OpenAIProvider) setAuthorization(req *http.Request) {
+	if p.Config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Config.APIKey)
+	}
+}
+
+// HealthCheck 健康检查，配置了健康检查模型时发送一个最小的聊天请求确认模型可用，否则只检查模型列表接口
 func (p *OpenAIProvider) HealthCheck(ctx context.Context) error {
+	if p.Config.HealthCheckModel != "" {
+		return modelHealthCheck(ctx, p, p.Config.HealthCheckModel)
+	}
+
 	// 创建一个简单的健康检查请求，只检查连接性
-	req, err := http.NewRequestWithContext(ctx, "GET", p.Config.BaseURL+"/models", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", p.Config.ModelsURL(), nil)
 	if err != nil {
 		return fmt.Errorf("failed to create health check request: %w", err)
 	}
 
 	// 添加认证头
-	req.Header.Set("Authorization", "Bearer "+p.Config.APIKey)
+	p.setAuthorization(req)
 	req.Header.Set("Content-Type", "application/json")
 
 	// 添加自定义头
@@ -242,7 +277,7 @@ func (p *OpenAIProvider) TransformResponse(resp interface{}) (*ChatCompletionRes
 // CreateHTTPRequest 创建HTTP请求
 func (p *OpenAIProvider) CreateHTTPRequest(ctx context.Context, endpoint string, body interface{}) (*http.Request, error) {
 	var bodyReader io.Reader
-	
+
 	if body != nil {
 		jsonBody, err := json.Marshal(body)
 		if err != nil {
@@ -250,21 +285,21 @@ func (p *OpenAIProvider) CreateHTTPRequest(ctx context.Context, endpoint string,
 		}
 		bodyReader = bytes.NewBuffer(jsonBody)
 	}
-	
+
 	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bodyReader)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+p.Config.APIKey)
-	
+	p.setAuthorization(req)
+
 	for key, value := range p.Config.Headers {
 		if key != "Authorization" {
 			req.Header.Set(key, value)
 		}
 	}
-	
+
 	return req, nil
 }
 
@@ -283,12 +318,12 @@ func (p *OpenAIProvider) validateToolCallRequest(req *ChatCompletionRequest) err
 	if err := p.validateMessageSequence(req.Messages); err != nil {
 		return err
 	}
-	
+
 	// 如果没有工具定义，无需验证工具相关参数
 	if len(req.Tools) == 0 {
 		return nil
 	}
-	
+
 	// 验证工具数量限制
 	if len(req.Tools) > 12800 {
 		return &ToolCallError{
@@ -297,7 +332,7 @@ func (p *OpenAIProvider) validateToolCallRequest(req *ChatCompletionRequest) err
 			Message: fmt.Sprintf("too many tools provided: %d, maximum allowed is 12800", len(req.Tools)),
 		}
 	}
-	
+
 	// 验证工具定义
 	toolNames := make(map[string]bool)
 	for i, tool := range req.Tools {
@@ -308,7 +343,7 @@ func (p *OpenAIProvider) validateToolCallRequest(req *ChatCompletionRequest) err
 				Message: fmt.Sprintf("tool[%d]: unsupported tool type '%s', only 'function' is supported", i, tool.Type),
 			}
 		}
-		
+
 		if tool.Function == nil {
 			return &ToolCallError{
 				Type:    "validation_error",
@@ -316,7 +351,7 @@ func (p *OpenAIProvider) validateToolCallRequest(req *ChatCompletionRequest) err
 				Message: fmt.Sprintf("tool[%d]: function definition is required", i),
 			}
 		}
-		
+
 		if tool.Function.Name == "" {
 			return &ToolCallError{
 				Type:    "validation_error",
@@ -324,7 +359,7 @@ func (p *OpenAIProvider) validateToolCallRequest(req *ChatCompletionRequest) err
 				Message: fmt.Sprintf("tool[%d]: function name is required", i),
 			}
 		}
-		
+
 		// 验证函数名称格式
 		if !isValidFunctionName(tool.Function.Name) {
 			return &ToolCallError{
@@ -333,7 +368,7 @@ func (p *OpenAIProvider) validateToolCallRequest(req *ChatCompletionRequest) err
 				Message: fmt.Sprintf("tool[%d]: function name '%s' is invalid, must contain only letters, numbers, underscores, and hyphens, and be 1-64 characters long", i, tool.Function.Name),
 			}
 		}
-		
+
 		// 检查函数名称重复
 		if toolNames[tool.Function.Name] {
 			return &ToolCallError{
@@ -343,22 +378,22 @@ func (p *OpenAIProvider) validateToolCallRequest(req *ChatCompletionRequest) err
 			}
 		}
 		toolNames[tool.Function.Name] = true
-		
+
 		// 函数描述长度不设限制，允许用户自由定义
-		
+
 		// 验证参数schema
 		if err := p.validateFunctionParameters(tool.Function, i); err != nil {
 			return err
 		}
 	}
-	
+
 	// 验证tool_choice参数
 	if req.ToolChoice != nil {
 		if err := p.validateToolChoice(req.ToolChoice, toolNames); err != nil {
 			return err
 		}
 	}
-	
+
 	// 验证parallel_tool_calls参数
 	if req.ParallelToolCalls != nil && len(req.Tools) == 1 {
 		// 如果只有一个工具，parallel_tool_calls应该为false或nil
@@ -370,7 +405,7 @@ func (p *OpenAIProvider) validateToolCallRequest(req *ChatCompletionRequest) err
 			}
 		}
 	}
-	
+
 	return nil
 }
 
@@ -379,7 +414,7 @@ func (p *OpenAIProvider) validateFunctionParameters(function *Function, toolInde
 	if function.Parameters == nil {
 		return nil
 	}
-	
+
 	// 验证parameters是否为有效的JSON Schema
 	parametersBytes, err := json.Marshal(function.Parameters)
 	if err != nil {
@@ -389,7 +424,7 @@ func (p *OpenAIProvider) validateFunctionParameters(function *Function, toolInde
 			Message: fmt.Sprintf("tool[%d]: function parameters must be valid JSON: %v", toolIndex, err),
 		}
 	}
-	
+
 	// 验证参数schema大小
 	if len(parametersBytes) > 100*1024 { // 100KB limit
 		return &ToolCallError{
@@ -398,7 +433,7 @@ func (p *OpenAIProvider) validateFunctionParameters(function *Function, toolInde
 			Message: fmt.Sprintf("tool[%d]: function parameters schema is too large (%d bytes), maximum allowed is 100KB", toolIndex, len(parametersBytes)),
 		}
 	}
-	
+
 	return nil
 }
 
@@ -431,7 +466,7 @@ func (p *OpenAIProvider) validateToolChoice(toolChoice interface{}, availableToo
 				Message: "tool_choice object must have type 'function'",
 			}
 		}
-		
+
 		if function, ok := choice["function"].(map[string]interface{}); ok {
 			if name, ok := function["name"].(string); !ok || name == "" {
 				return &ToolCallError{
@@ -488,7 +523,7 @@ func (p *OpenAIProvider) validateToolChoice(toolChoice interface{}, availableToo
 			Message: fmt.Sprintf("invalid tool_choice type: %T", choice),
 		}
 	}
-	
+
 	return nil
 }
 
@@ -497,18 +532,42 @@ func isValidFunctionName(name string) bool {
 	if len(name) == 0 || len(name) > 64 {
 		return false
 	}
-	
+
 	for _, r := range name {
 		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-') {
 			return false
 		}
 	}
-	
+
 	return true
 }
 
-// handleAPIError 处理API错误响应
+// handleAPIErrorWithHeaders 处理API错误响应，并从限流响应头中提取重试等待时间
+func (p *OpenAIProvider) handleAPIErrorWithHeaders(statusCode int, header http.Header, body []byte) error {
+	err := p.handleAPIError(statusCode, body)
+	if toolErr, ok := err.(*ToolCallError); ok {
+		parsed := ParseUpstreamError(statusCode, header, body)
+		toolErr.StatusCode = statusCode
+		toolErr.Category = parsed.Category
+		toolErr.RetryAfter = parsed.RetryAfter
+		if toolErr.RetryAfter == 0 && statusCode == http.StatusTooManyRequests {
+			toolErr.RetryAfter = parseRetryAfter(header, time.Now())
+		}
+	}
+	return err
+}
+
+// handleAPIError 处理API错误响应，返回的错误带有上游状态码
 func (p *OpenAIProvider) handleAPIError(statusCode int, body []byte) error {
+	err := p.parseAPIError(statusCode, body)
+	if toolErr, ok := err.(*ToolCallError); ok {
+		toolErr.StatusCode = statusCode
+	}
+	return err
+}
+
+// parseAPIError 将API错误响应解析为ToolCallError
+func (p *OpenAIProvider) parseAPIError(statusCode int, body []byte) error {
 	// 尝试解析OpenAI错误格式
 	var apiError struct {
 		Error struct {
@@ -517,14 +576,14 @@ func (p *OpenAIProvider) handleAPIError(statusCode int, body []byte) error {
 			Code    string `json:"code"`
 		} `json:"error"`
 	}
-	
+
 	if err := json.Unmarshal(body, &apiError); err == nil && apiError.Error.Message != "" {
 		// 根据错误类型返回相应的ToolCallError
 		switch apiError.Error.Type {
 		case "invalid_request_error":
 			// 检查是否是工具调用相关的错误
 			if strings.Contains(apiError.Error.Message, "tool") ||
-			   strings.Contains(apiError.Error.Message, "function") {
+				strings.Contains(apiError.Error.Message, "function") {
 				return &ToolCallError{
 					Type:    "tool_call_error",
 					Code:    apiError.Error.Code,
@@ -536,6 +595,18 @@ func (p *OpenAIProvider) handleAPIError(statusCode int, body []byte) error {
 				Code:    apiError.Error.Code,
 				Message: apiError.Error.Message,
 			}
+		case "authentication_error":
+			return &ToolCallError{
+				Type:    "authentication_error",
+				Code:    "unauthorized",
+				Message: apiError.Error.Message,
+			}
+		case "permission_error":
+			return &ToolCallError{
+				Type:    "permission_error",
+				Code:    "forbidden",
+				Message: apiError.Error.Message,
+			}
 		case "rate_limit_exceeded":
 			return &ToolCallError{
 				Type:    "rate_limit_error",
@@ -562,7 +633,7 @@ func (p *OpenAIProvider) handleAPIError(statusCode int, body []byte) error {
 			}
 		}
 	}
-	
+
 	// 如果无法解析错误格式，根据状态码返回通用错误
 	switch statusCode {
 	case 400:
@@ -623,7 +694,7 @@ func (p *OpenAIProvider) validateMessageSequence(messages []ChatMessage) error {
 					Message: "messages with role \"tool\" must be a response to a preceding message with \"tool_calls\"",
 				}
 			}
-			
+
 			// 向前查找最近的assistant消息
 			var assistantMsg *ChatMessage
 			for j := i - 1; j >= 0; j-- {
@@ -635,7 +706,7 @@ func (p *OpenAIProvider) validateMessageSequence(messages []ChatMessage) error {
 					break
 				}
 			}
-			
+
 			// 检查是否找到了assistant消息且包含tool_calls
 			if assistantMsg == nil || len(assistantMsg.ToolCalls) == 0 {
 				return &ToolCallError{
@@ -644,7 +715,7 @@ func (p *OpenAIProvider) validateMessageSequence(messages []ChatMessage) error {
 					Message: "messages with role \"tool\" must be a response to a preceding message with \"tool_calls\"",
 				}
 			}
-			
+
 			// 验证tool消息必须有tool_call_id
 			if msg.ToolCallID == "" {
 				return &ToolCallError{
@@ -653,7 +724,7 @@ func (p *OpenAIProvider) validateMessageSequence(messages []ChatMessage) error {
 					Message: "messages with role \"tool\" must have a \"tool_call_id\"",
 				}
 			}
-			
+
 			// 验证tool_call_id是否对应前面的tool_calls
 			validToolCallID := false
 			for _, toolCall := range assistantMsg.ToolCalls {
@@ -669,7 +740,7 @@ func (p *OpenAIProvider) validateMessageSequence(messages []ChatMessage) error {
 					Message: fmt.Sprintf("tool_call_id \"%s\" does not match any tool_calls in the preceding assistant message", msg.ToolCallID),
 				}
 			}
-			
+
 		case "assistant":
 			// 如果assistant消息包含tool_calls，验证其格式
 			if len(msg.ToolCalls) > 0 {
@@ -681,7 +752,7 @@ func (p *OpenAIProvider) validateMessageSequence(messages []ChatMessage) error {
 							Message: fmt.Sprintf("tool_calls[%d] must have an \"id\"", j),
 						}
 					}
-					
+
 					if toolCall.Type != "function" {
 						return &ToolCallError{
 							Type:    "validation_error",
@@ -689,7 +760,7 @@ func (p *OpenAIProvider) validateMessageSequence(messages []ChatMessage) error {
 							Message: fmt.Sprintf("tool_calls[%d] type must be \"function\"", j),
 						}
 					}
-					
+
 					if toolCall.Function == nil {
 						return &ToolCallError{
 							Type:    "validation_error",
@@ -697,7 +768,7 @@ func (p *OpenAIProvider) validateMessageSequence(messages []ChatMessage) error {
 							Message: fmt.Sprintf("tool_calls[%d] must have a \"function\"", j),
 						}
 					}
-					
+
 					if toolCall.Function.Name == "" {
 						return &ToolCallError{
 							Type:    "validation_error",
@@ -709,6 +780,6 @@ func (p *OpenAIProvider) validateMessageSequence(messages []ChatMessage) error {
 			}
 		}
 	}
-	
+
 	return nil
 }
@@ -0,0 +1,174 @@
+package providers
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// outboundTransports 按出站代理、TLS和连接池设置复用的 Transport，避免每次创建提供商实例都建立新的连接池
+var outboundTransports sync.Map // string -> *cachedTransport
+
+// TransportOptions 上游连接池设置，零值字段使用全局设置或默认值
+type TransportOptions struct {
+	MaxIdleConns        int           // 所有上游主机共保留的空闲连接数
+	MaxIdleConnsPerHost int           // 每个上游主机保留的空闲连接数
+	MaxConnsPerHost     int           // 每个上游主机的最大连接数（含使用中的连接），0表示不限制
+	IdleConnTimeout     time.Duration // 空闲连接保留时间
+	DisableHTTP2        bool          // 只使用HTTP/1.1，用于HTTP/2实现有问题的上游
+	DNSCacheTTL         time.Duration // 上游域名解析结果的缓存时间，0表示不缓存
+}
+
+// defaultTransportOptions 内置的连接池设置
+// net/http 默认每个主机只保留2个空闲连接，高并发时多余的连接用完即关闭，之后的请求需要重新建立连接和TLS握手
+var defaultTransportOptions = TransportOptions{
+	MaxIdleConns:        512,
+	MaxIdleConnsPerHost: 64,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// transportDefaults 全局连接池设置，为空时使用内置默认值
+var transportDefaults atomic.Pointer[TransportOptions]
+
+// SetTransportDefaults 设置所有分组共用的连接池设置，零值字段使用内置默认值，只影响之后创建的提供商实例
+func SetTransportDefaults(opts *TransportOptions) {
+	transportDefaults.Store(opts)
+}
+
+// EffectiveTransportOptions 合并内置默认值、全局设置和分组设置，分组设置优先
+func EffectiveTransportOptions(group *TransportOptions) TransportOptions {
+	opts := defaultTransportOptions
+	opts.merge(transportDefaults.Load())
+	opts.merge(group)
+	return opts
+}
+
+// merge 用 override 中设置了的字段覆盖当前值，任一层关闭HTTP/2即关闭
+func (o *TransportOptions) merge(override *TransportOptions) {
+	if override == nil {
+		return
+	}
+	if override.MaxIdleConns > 0 {
+		o.MaxIdleConns = override.MaxIdleConns
+	}
+	if override.MaxIdleConnsPerHost > 0 {
+		o.MaxIdleConnsPerHost = override.MaxIdleConnsPerHost
+	}
+	if override.MaxConnsPerHost > 0 {
+		o.MaxConnsPerHost = override.MaxConnsPerHost
+	}
+	if override.IdleConnTimeout > 0 {
+		o.IdleConnTimeout = override.IdleConnTimeout
+	}
+	if override.DNSCacheTTL > 0 {
+		o.DNSCacheTTL = override.DNSCacheTTL
+	}
+	o.DisableHTTP2 = o.DisableHTTP2 || override.DisableHTTP2
+}
+
+// cachedTransport 缓存的 Transport 及创建时证书文件的状态
+type cachedTransport struct {
+	stamp     string
+	transport http.RoundTripper
+}
+
+// ParseProxyURL 解析分组的出站代理地址，支持 http、https、socks5 和 socks5h
+func ParseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy_url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy_url scheme must be http, https, socks5 or socks5h, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy_url must include a host")
+	}
+	return u, nil
+}
+
+// outboundTransport 返回发往上游使用的基础 Transport，按配置经出站代理转发并使用分组的TLS和连接池设置
+// 设置相同的分组共用同一个 Transport 及其连接池
+func outboundTransport(proxyURL string, tlsOptions *TLSOptions, transportOptions *TransportOptions) http.RoundTripper {
+	opts := EffectiveTransportOptions(transportOptions)
+	key := fmt.Sprintf("%s|%+v", proxyURL, opts)
+	if !tlsOptions.IsZero() {
+		key = fmt.Sprintf("%s|%+v", key, *tlsOptions)
+	}
+	stamp := tlsFilesStamp(tlsOptions)
+	if cached, ok := outboundTransports.Load(key); ok && cached.(*cachedTransport).stamp == stamp {
+		return cached.(*cachedTransport).transport
+	}
+
+	transport, err := newOutboundTransport(proxyURL, tlsOptions, opts)
+	if err != nil {
+		// 配置加载和分组保存时已校验，这里不应出现；不能静默直连或跳过证书设置，否则请求会以错误的方式发出
+		log.Printf("警告: 创建上游连接失败: %v", err)
+		return &failingTransport{err: err}
+	}
+	if previous, loaded := outboundTransports.Swap(key, &cachedTransport{stamp: stamp, transport: transport}); loaded {
+		// 证书文件已更新，旧连接池不再使用
+		if old, ok := previous.(*cachedTransport).transport.(*http.Transport); ok {
+			old.CloseIdleConnections()
+		}
+	}
+	return transport
+}
+
+// newOutboundTransport 基于默认 Transport 创建带出站代理、TLS和连接池设置的 Transport
+func newOutboundTransport(proxyURL string, tlsOptions *TLSOptions, opts TransportOptions) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = opts.MaxIdleConns
+	transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = opts.MaxConnsPerHost
+	transport.IdleConnTimeout = opts.IdleConnTimeout
+	if opts.DisableHTTP2 {
+		// 非空的 TLSNextProto 使 Transport 不再协商 h2
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	if opts.DNSCacheTTL > 0 {
+		// 与默认 Transport 相同的连接超时和TCP保活设置
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		transport.DialContext = newDNSCache(opts.DNSCacheTTL, net.DefaultResolver).dialContext(dialer)
+	}
+	if proxyURL != "" {
+		u, err := ParseProxyURL(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	tlsConfig, err := LoadTLSConfig(tlsOptions)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		if tlsConfig.InsecureSkipVerify {
+			log.Printf("警告: 上游连接已关闭TLS证书校验（insecure_skip_verify），仅应在测试环境中使用")
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return transport, nil
+}
+
+// failingTransport 出站代理或TLS配置无效时拒绝发送请求
+type failingTransport struct {
+	err error
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, t.err
+}
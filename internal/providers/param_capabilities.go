@@ -0,0 +1,100 @@
+package providers
+
+import (
+	"sort"
+
+	"google.golang.org/genai"
+)
+
+// ParamCapabilities 提供商类型对可选采样参数的支持情况
+type ParamCapabilities struct {
+	Seed             bool // 是否支持 seed
+	FrequencyPenalty bool // 是否支持 frequency_penalty
+	PresencePenalty  bool // 是否支持 presence_penalty
+	Stop             bool // 是否支持 stop
+	MaxStopSequences int  // 停止序列数量上限，超出部分被去除，0表示不限制
+}
+
+// paramCapabilities 各提供商类型的参数支持矩阵，未列出的提供商类型不做处理
+// OpenAI最多支持4个停止序列，Gemini最多5个；Anthropic不支持 seed 和重复惩罚；
+// OpenRouter和自建OpenAI兼容后端由上游自行处理
+var paramCapabilities = map[string]ParamCapabilities{
+	"openai":                     {Seed: true, FrequencyPenalty: true, PresencePenalty: true, Stop: true, MaxStopSequences: 4},
+	"azure_openai":               {Seed: true, FrequencyPenalty: true, PresencePenalty: true, Stop: true, MaxStopSequences: 4},
+	"openrouter":                 {Seed: true, FrequencyPenalty: true, PresencePenalty: true, Stop: true},
+	OpenAICompatibleProviderType: {Seed: true, FrequencyPenalty: true, PresencePenalty: true, Stop: true},
+	"gemini":                     {Seed: true, FrequencyPenalty: true, PresencePenalty: true, Stop: true, MaxStopSequences: 5},
+	"anthropic":                  {Stop: true},
+}
+
+// LookupParamCapabilities 查找提供商类型的参数支持情况
+func LookupParamCapabilities(providerType string) (ParamCapabilities, bool) {
+	capabilities, ok := paramCapabilities[providerType]
+	return capabilities, ok
+}
+
+// DropUnsupportedParams 按参数支持矩阵处理请求：去除提供商类型不支持的参数，停止序列超出上限时只保留前面的部分
+// 返回处理后的请求和被去除（或截断）的参数名（按名称排序）；不需要处理时返回原请求，否则返回副本，原请求保持不变
+// OpenRouter专用的 provider 和 transforms 参数只发给OpenRouter分组，其他提供商类型（包括未列出的类型）一律去除
+func DropUnsupportedParams(providerType string, req *ChatCompletionRequest) (*ChatCompletionRequest, []string) {
+	normalized := *req
+	var dropped []string
+	if providerType != "openrouter" {
+		if normalized.Provider != nil {
+			normalized.Provider = nil
+			dropped = append(dropped, "provider")
+		}
+		if normalized.Transforms != nil {
+			normalized.Transforms = nil
+			dropped = append(dropped, "transforms")
+		}
+	}
+
+	capabilities, ok := LookupParamCapabilities(providerType)
+	if !ok {
+		capabilities = ParamCapabilities{Seed: true, FrequencyPenalty: true, PresencePenalty: true, Stop: true}
+	}
+	if normalized.Seed != nil && !capabilities.Seed {
+		normalized.Seed = nil
+		dropped = append(dropped, "seed")
+	}
+	if normalized.FrequencyPenalty != nil && !capabilities.FrequencyPenalty {
+		normalized.FrequencyPenalty = nil
+		dropped = append(dropped, "frequency_penalty")
+	}
+	if normalized.PresencePenalty != nil && !capabilities.PresencePenalty {
+		normalized.PresencePenalty = nil
+		dropped = append(dropped, "presence_penalty")
+	}
+	if len(normalized.Stop) > 0 {
+		if !capabilities.Stop {
+			normalized.Stop = nil
+			dropped = append(dropped, "stop")
+		} else if capabilities.MaxStopSequences > 0 && len(normalized.Stop) > capabilities.MaxStopSequences {
+			normalized.Stop = normalized.Stop[:capabilities.MaxStopSequences]
+			dropped = append(dropped, "stop")
+		}
+	}
+
+	if len(dropped) == 0 {
+		return req, nil
+	}
+	sort.Strings(dropped)
+	return &normalized, dropped
+}
+
+// applyGeminiSamplingParams 设置Gemini的随机种子和重复惩罚
+func applyGeminiSamplingParams(genConfig *genai.GenerateContentConfig, req *ChatCompletionRequest) {
+	if req.Seed != nil {
+		seed := int32(*req.Seed)
+		genConfig.Seed = &seed
+	}
+	if req.FrequencyPenalty != nil {
+		penalty := float32(*req.FrequencyPenalty)
+		genConfig.FrequencyPenalty = &penalty
+	}
+	if req.PresencePenalty != nil {
+		penalty := float32(*req.PresencePenalty)
+		genConfig.PresencePenalty = &penalty
+	}
+}
@@ -0,0 +1,59 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// PassthroughProvider 支持将客户端请求原样转发到上游的提供商（如批处理和文件接口）
+type PassthroughProvider interface {
+	// Forward 以分组的BaseURL、密钥和请求头转发请求，path 相对于BaseURL
+	Forward(ctx context.Context, method, path string, header http.Header, body io.Reader) (*http.Response, error)
+}
+
+// batchProviderTypes 支持OpenAI Batches和Files接口的提供商类型
+var batchProviderTypes = map[string]bool{
+	"openai":                     true,
+	"azure_openai":               true,
+	OpenAICompatibleProviderType: true, // vLLM等自建后端
+}
+
+// SupportsBatches 提供商类型是否支持OpenAI Batches和Files接口
+func SupportsBatches(providerType string) bool {
+	return batchProviderTypes[providerType]
+}
+
+// passthroughRequestHeaders 从客户端请求中转发到上游的请求头，认证头由分组密钥替换
+var passthroughRequestHeaders = []string{"Content-Type", "Accept", "OpenAI-Beta"}
+
+// Forward 转发请求到上游，调用方负责关闭响应体
+func (p *OpenAIProvider) Forward(ctx context.Context, method, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, method, joinEndpoint(p.Config.BaseURL, path), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for _, name := range passthroughRequestHeaders {
+		if value := header.Get(name); value != "" {
+			httpReq.Header.Set(name, value)
+		}
+	}
+	if length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && body != nil {
+		// 文件上传按原始长度发送，避免上游拒绝分块传输的请求体
+		httpReq.ContentLength = length
+	}
+	p.setAuthorization(httpReq)
+	for key, value := range p.Config.Headers {
+		if key != "Authorization" {
+			httpReq.Header.Set(key, value)
+		}
+	}
+
+	resp, err := p.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	return resp, nil
+}
@@ -1,8 +1,30 @@
 package providers
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"turnsapi/internal/tracing"
+
+	"google.golang.org/genai"
 )
 
 func TestProviderFactory(t *testing.T) {
@@ -10,7 +32,7 @@ func TestProviderFactory(t *testing.T) {
 
 	// Test supported types
 	supportedTypes := factory.GetSupportedTypes()
-	expectedTypes := []string{"openai", "openrouter", "gemini", "anthropic", "azure_openai"}
+	expectedTypes := []string{"openai", "openrouter", "gemini", "anthropic", "azure_openai", "openai_compatible"}
 
 	if len(supportedTypes) != len(expectedTypes) {
 		t.Errorf("Expected %d supported types, got %d", len(expectedTypes), len(supportedTypes))
@@ -157,6 +179,14 @@ func TestValidateProviderConfig(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "keyless local backend",
+			config: &ProviderConfig{
+				BaseURL:      "http://localhost:11434/v1",
+				ProviderType: "openai_compatible",
+			},
+			expectError: false,
+		},
 		{
 			name: "unsupported provider type",
 			config: &ProviderConfig{
@@ -593,13 +623,13 @@ func TestToolCallValidation(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			err := provider.validateToolCallRequest(tt.request)
-			
+
 			if tt.expectError {
 				if err == nil {
 					t.Error("Expected error but got nil")
 					return
 				}
-				
+
 				if toolCallErr, ok := err.(*ToolCallError); ok {
 					if toolCallErr.Code != tt.errorCode {
 						t.Errorf("Expected error code '%s', got '%s'", tt.errorCode, toolCallErr.Code)
@@ -860,7 +890,7 @@ func TestToolCallErrorHandling(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			err := provider.handleAPIError(tt.statusCode, tt.body)
-			
+
 			if err == nil {
 				t.Error("Expected error but got nil")
 				return
@@ -1123,13 +1153,13 @@ func TestValidateMessageSequence(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			err := provider.validateMessageSequence(tt.messages)
-			
+
 			if tt.expectError {
 				if err == nil {
 					t.Errorf("expected error but got none")
 					return
 				}
-				
+
 				if toolErr, ok := err.(*ToolCallError); ok {
 					if toolErr.Code != tt.errorCode {
 						t.Errorf("expected error code %s, got %s", tt.errorCode, toolErr.Code)
@@ -1207,14 +1237,1522 @@ func TestParallelToolCallsValidation(t *testing.T) {
 			}
 
 			err := provider.validateToolCallRequest(req)
-			
+
 			if tt.expectError && err == nil {
 				t.Error("Expected error but got nil")
 			}
-			
+
 			if !tt.expectError && err != nil {
 				t.Errorf("Expected no error but got: %v", err)
 			}
 		})
 	}
 }
+
+// TestNormalizeRequestForModel 测试o系列模型的请求规范化
+func TestNormalizeRequestForModel(t *testing.T) {
+	temperature := 0.7
+	maxTokens := 512
+	req := &ChatCompletionRequest{
+		Model: "o1-mini",
+		Messages: []ChatMessage{
+			{Role: "system", Content: "You are helpful."},
+			{Role: "user", Content: "Hello"},
+		},
+		Temperature: &temperature,
+		MaxTokens:   &maxTokens,
+	}
+
+	normalized := NormalizeRequestForModel("openai", req)
+	if normalized == req {
+		t.Fatal("Expected a normalized copy for o-series model")
+	}
+	if normalized.Temperature != nil {
+		t.Error("Expected temperature to be dropped")
+	}
+	if normalized.MaxTokens != nil || normalized.MaxCompletionTokens == nil || *normalized.MaxCompletionTokens != 512 {
+		t.Error("Expected max_tokens to be moved to max_completion_tokens")
+	}
+	if len(normalized.Messages) != 1 || normalized.Messages[0].Role != "user" {
+		t.Fatalf("Expected system message to be merged, got %+v", normalized.Messages)
+	}
+	if normalized.Messages[0].Content != "You are helpful.\n\nHello" {
+		t.Errorf("Unexpected merged content: %v", normalized.Messages[0].Content)
+	}
+	if len(req.Messages) != 2 || req.Temperature == nil {
+		t.Error("Expected original request to remain unchanged")
+	}
+
+	// 非o系列模型不做处理
+	gptReq := &ChatCompletionRequest{Model: "gpt-4o", Temperature: &temperature}
+	if NormalizeRequestForModel("openai", gptReq) != gptReq {
+		t.Error("Expected non o-series request to be returned as-is")
+	}
+
+	// 只处理OpenAI和Azure OpenAI，其他提供商有各自的参数约定
+	if NormalizeRequestForModel("azure_openai", req) == req {
+		t.Error("Expected o-series request to Azure OpenAI to be normalized")
+	}
+	for _, providerType := range []string{"openrouter", "anthropic", "gemini", OpenAICompatibleProviderType} {
+		if NormalizeRequestForModel(providerType, req) != req {
+			t.Errorf("Expected o-series request to %s to be returned as-is", providerType)
+		}
+	}
+	if FindModelRule("openai/o3-mini") == nil {
+		t.Error("Expected vendor-prefixed o3 model to match")
+	}
+	if FindModelRule("omni-moderation") != nil {
+		t.Error("Expected unrelated model not to match")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	header := http.Header{}
+	header.Set("Retry-After", "30")
+	if got := parseRetryAfter(header, now); got != 30*time.Second {
+		t.Errorf("Expected 30s from Retry-After, got %v", got)
+	}
+
+	header = http.Header{}
+	header.Set("X-RateLimit-Remaining", "0")
+	header.Set("X-RateLimit-Reset", "1700000012000")
+	if got := parseRetryAfter(header, now); got != 12*time.Second {
+		t.Errorf("Expected 12s from OpenRouter reset header, got %v", got)
+	}
+
+	header.Set("X-RateLimit-Remaining", "5")
+	if got := parseRetryAfter(header, now); got != 0 {
+		t.Errorf("Expected no backoff while quota remains, got %v", got)
+	}
+
+	err := &ToolCallError{Type: "rate_limit_error", RetryAfter: 5 * time.Second}
+	if got := RetryAfterFromError(err); got != 5*time.Second {
+		t.Errorf("Expected 5s from error, got %v", got)
+	}
+}
+
+func TestParseQuotaUsage(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	header := http.Header{}
+	header.Set("x-ratelimit-limit-requests", "100")
+	header.Set("x-ratelimit-remaining-requests", "50")
+	header.Set("x-ratelimit-limit-tokens", "10000")
+	header.Set("x-ratelimit-remaining-tokens", "1000")
+	header.Set("x-ratelimit-reset-tokens", "6m0s")
+	usage, ok := ParseQuotaUsage(header, now)
+	if !ok {
+		t.Fatal("Expected quota usage from OpenAI headers")
+	}
+	if usage.Dimension != "tokens" || usage.Percent() != 90 || usage.ResetAfter != 6*time.Minute {
+		t.Errorf("Expected tokens at 90%% resetting in 6m, got %s at %v%% resetting in %v", usage.Dimension, usage.Percent(), usage.ResetAfter)
+	}
+
+	header = http.Header{}
+	header.Set("anthropic-ratelimit-requests-limit", "50")
+	header.Set("anthropic-ratelimit-requests-remaining", "10")
+	header.Set("anthropic-ratelimit-requests-reset", now.Add(30*time.Second).Format(time.RFC3339))
+	usage, ok = ParseQuotaUsage(header, now)
+	if !ok || usage.Percent() != 80 || usage.ResetAfter != 30*time.Second {
+		t.Errorf("Expected 80%% resetting in 30s from Anthropic headers, got %v%% resetting in %v", usage.Percent(), usage.ResetAfter)
+	}
+
+	if _, ok := ParseQuotaUsage(http.Header{}, now); ok {
+		t.Error("Expected no quota usage without rate limit headers")
+	}
+}
+
+func TestProviderEndpointURLs(t *testing.T) {
+	config := &ProviderConfig{BaseURL: "https://api.example.com/v1", ProviderType: "openai"}
+	if got := config.ChatCompletionsURL(); got != "https://api.example.com/v1/chat/completions" {
+		t.Errorf("Unexpected default chat URL: %s", got)
+	}
+
+	config.ChatCompletionsPath = "v2/chat/completions"
+	config.ModelsPath = "/v2/models"
+	if got := config.ChatCompletionsURL(); got != "https://api.example.com/v1/v2/chat/completions" {
+		t.Errorf("Unexpected overridden chat URL: %s", got)
+	}
+	if got := config.ModelsURL(); got != "https://api.example.com/v1/v2/models" {
+		t.Errorf("Unexpected overridden models URL: %s", got)
+	}
+
+	anthropic := &ProviderConfig{BaseURL: "https://api.anthropic.com", ProviderType: "anthropic"}
+	if got := anthropic.ChatCompletionsURL(); got != "https://api.anthropic.com/v1/messages" {
+		t.Errorf("Unexpected anthropic chat URL: %s", got)
+	}
+}
+
+func TestUpstreamErrorClassification(t *testing.T) {
+	err := &ToolCallError{Type: "api_error", Message: "server overloaded", StatusCode: 503}
+	if got := StatusCodeFromError(err); got != 503 {
+		t.Errorf("Expected status 503 from ToolCallError, got %d", got)
+	}
+	if IsContextLengthError(err) {
+		t.Error("Expected 503 not to be a context length error")
+	}
+
+	wrapped := fmt.Errorf("attempt failed: %w", ParseUpstreamError(429, nil, []byte("rate limited")))
+	if got := StatusCodeFromError(wrapped); got != 429 {
+		t.Errorf("Expected status 429 from wrapped upstream error, got %d", got)
+	}
+
+	// 状态码只从带类型的错误中读取，错误信息文本中的 "status 500" 不被当作状态码
+	plain := fmt.Errorf("upstream said: status 500 is not what we expected")
+	if got := StatusCodeFromError(plain); got != 0 {
+		t.Errorf("Expected no status parsed from plain error text, got %d", got)
+	}
+
+	contextErr := &ToolCallError{Type: "invalid_request_error", Code: "context_length_exceeded", StatusCode: 400}
+	if !IsContextLengthError(contextErr) {
+		t.Error("Expected context_length_exceeded to be detected")
+	}
+	if !IsContextLengthError(fmt.Errorf("prompt is too long: 210000 tokens > 200000 maximum")) {
+		t.Error("Expected anthropic prompt length error to be detected")
+	}
+}
+
+func TestParseUpstreamErrorCategories(t *testing.T) {
+	tests := []struct {
+		name           string
+		statusCode     int
+		body           string
+		expectCategory ErrorCategory
+		expectMessage  string
+	}{
+		{
+			name:           "OpenAI invalid key",
+			statusCode:     401,
+			body:           `{"error": {"message": "Incorrect API key provided", "type": "invalid_request_error", "code": "invalid_api_key"}}`,
+			expectCategory: ErrorCategoryAuth,
+			expectMessage:  "Incorrect API key provided",
+		},
+		{
+			name:           "OpenAI insufficient quota",
+			statusCode:     429,
+			body:           `{"error": {"message": "You exceeded your current quota", "type": "insufficient_quota", "code": "insufficient_quota"}}`,
+			expectCategory: ErrorCategoryQuota,
+		},
+		{
+			name:           "OpenAI context length",
+			statusCode:     400,
+			body:           `{"error": {"message": "This model's maximum context length is 8192 tokens", "type": "invalid_request_error", "code": "context_length_exceeded"}}`,
+			expectCategory: ErrorCategoryContextLength,
+		},
+		{
+			name:           "Azure content filter",
+			statusCode:     400,
+			body:           `{"error": {"message": "The response was filtered", "type": null, "code": "content_filter"}}`,
+			expectCategory: ErrorCategoryContentFilter,
+		},
+		{
+			name:           "OpenRouter numeric code",
+			statusCode:     402,
+			body:           `{"error": {"message": "Insufficient credits", "code": 402}}`,
+			expectCategory: ErrorCategoryQuota,
+		},
+		{
+			name:           "Anthropic overloaded",
+			statusCode:     529,
+			body:           `{"type": "error", "error": {"type": "overloaded_error", "message": "Overloaded"}}`,
+			expectCategory: ErrorCategoryServer,
+			expectMessage:  "Overloaded",
+		},
+		{
+			name:           "Anthropic authentication",
+			statusCode:     401,
+			body:           `{"type": "error", "error": {"type": "authentication_error", "message": "invalid x-api-key"}}`,
+			expectCategory: ErrorCategoryAuth,
+		},
+		{
+			name:           "Gemini invalid argument",
+			statusCode:     400,
+			body:           `{"error": {"code": 400, "message": "Invalid JSON payload received", "status": "INVALID_ARGUMENT"}}`,
+			expectCategory: ErrorCategoryInvalidRequest,
+		},
+		{
+			name:           "Gemini rate limit",
+			statusCode:     429,
+			body:           `{"error": {"code": 429, "message": "Resource has been exhausted", "status": "RESOURCE_EXHAUSTED"}}`,
+			expectCategory: ErrorCategoryRateLimit,
+		},
+		{
+			name:           "OpenAI model not found",
+			statusCode:     404,
+			body:           `{"error": {"message": "The model 'gpt-5' does not exist", "type": "invalid_request_error", "code": "model_not_found"}}`,
+			expectCategory: ErrorCategoryInvalidRequest,
+		},
+		{
+			name:           "Upstream request timeout",
+			statusCode:     408,
+			body:           `Request Timeout`,
+			expectCategory: ErrorCategoryServer,
+		},
+		{
+			name:           "Plain text gateway error",
+			statusCode:     502,
+			body:           `Bad Gateway`,
+			expectCategory: ErrorCategoryServer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ParseUpstreamError(tt.statusCode, nil, []byte(tt.body))
+			if err.Category != tt.expectCategory {
+				t.Errorf("Expected category %s, got %s", tt.expectCategory, err.Category)
+			}
+			if err.StatusCode != tt.statusCode {
+				t.Errorf("Expected status %d, got %d", tt.statusCode, err.StatusCode)
+			}
+			if tt.expectMessage != "" && err.Message != tt.expectMessage {
+				t.Errorf("Expected message %q, got %q", tt.expectMessage, err.Message)
+			}
+			if ClassifyError(err) != tt.expectCategory {
+				t.Errorf("ClassifyError disagrees with parsed category")
+			}
+		})
+	}
+
+	if got := ClassifyError(fmt.Errorf("failed to send request: connection refused")); got != ErrorCategoryUnknown {
+		t.Errorf("Expected network error to be unknown, got %s", got)
+	}
+}
+
+// TestIsInvalidKeyError 测试只有401或明确的无效密钥错误才会将密钥标记为无效，无权访问模型的403不会
+func TestIsInvalidKeyError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		want       bool
+	}{
+		{
+			name:       "OpenAI invalid key",
+			statusCode: 401,
+			body:       `{"error": {"message": "Incorrect API key provided", "type": "invalid_request_error", "code": "invalid_api_key"}}`,
+			want:       true,
+		},
+		{
+			name:       "Anthropic authentication",
+			statusCode: 401,
+			body:       `{"type": "error", "error": {"type": "authentication_error", "message": "invalid x-api-key"}}`,
+			want:       true,
+		},
+		{
+			name:       "Gemini invalid key",
+			statusCode: 400,
+			body:       `{"error": {"code": 400, "message": "API key not valid. Please pass a valid API key.", "status": "INVALID_ARGUMENT"}}`,
+			want:       true,
+		},
+		{
+			name:       "OpenAI model permission",
+			statusCode: 403,
+			body:       `{"error": {"message": "You are not allowed to access model gpt-4o", "type": "invalid_request_error", "code": "model_not_allowed"}}`,
+			want:       false,
+		},
+		{
+			name:       "Anthropic permission error",
+			statusCode: 403,
+			body:       `{"type": "error", "error": {"type": "permission_error", "message": "Your API key does not have permission to use the specified resource."}}`,
+			want:       false,
+		},
+		{
+			name:       "Rate limit is not auth",
+			statusCode: 429,
+			body:       `{"error": {"message": "Rate limit reached", "type": "requests", "code": "rate_limit_exceeded"}}`,
+			want:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ParseUpstreamError(tt.statusCode, nil, []byte(tt.body))
+			if got := IsInvalidKeyError(err); got != tt.want {
+				t.Errorf("IsInvalidKeyError() = %v, want %v (category %s)", got, tt.want, err.Category)
+			}
+		})
+	}
+}
+
+// TestPrependSystemMessage 测试注入系统提示词不影响原请求的消息列表
+func TestPrependSystemMessage(t *testing.T) {
+	original := &ChatCompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []ChatMessage{{Role: "user", Content: "Hello"}},
+	}
+
+	vars := SystemPromptVars{Date: "2024-01-01", ProxyKeyName: "support-bot", Group: "openai", Model: "gpt-4o"}
+	upstream := *original
+	upstream.PrependSystemMessage(ExpandSystemPrompt("Today is {{date}}, caller {{proxy_key_name}}, model {{model}}", vars))
+	upstream.PrependSystemMessage(ExpandSystemPrompt("Group {group} for {key_name} on {date} using {model}, {unknown} kept", vars))
+
+	want := []string{
+		"Group openai for support-bot on 2024-01-01 using gpt-4o, {unknown} kept",
+		"Today is 2024-01-01, caller support-bot, model gpt-4o",
+	}
+	if len(upstream.Messages) != 3 {
+		t.Fatalf("Expected two system messages to be prepended, got %+v", upstream.Messages)
+	}
+	for i, content := range want {
+		if upstream.Messages[i].Role != "system" || upstream.Messages[i].Content != content {
+			t.Errorf("Expected system message %d to be %q, got %+v", i, content, upstream.Messages[i])
+		}
+	}
+	if len(original.Messages) != 1 || original.Messages[0].Role != "user" {
+		t.Errorf("Expected original messages to remain unchanged, got %+v", original.Messages)
+	}
+
+	if got := ExpandSystemPrompt("Hi {{key_name}}", SystemPromptVars{}); got != "Hi " {
+		t.Errorf("Expected missing variables to expand to empty strings, got %q", got)
+	}
+}
+
+// TestValidateJSONOutput 测试结构化输出的JSON提取和Schema校验
+func TestValidateJSONOutput(t *testing.T) {
+	schemaFormat := &ResponseFormat{
+		Type: ResponseFormatJSONSchema,
+		JSONSchema: &JSONSchemaFormat{
+			Name: "person",
+			Schema: map[string]interface{}{
+				"type":                 "object",
+				"required":             []interface{}{"name", "age"},
+				"additionalProperties": false,
+				"properties": map[string]interface{}{
+					"name": map[string]interface{}{"type": "string"},
+					"age":  map[string]interface{}{"type": "integer"},
+					"tags": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+				},
+			},
+		},
+	}
+	objectFormat := &ResponseFormat{Type: ResponseFormatJSONObject}
+
+	tests := []struct {
+		name      string
+		content   string
+		format    *ResponseFormat
+		expect    string
+		expectErr bool
+	}{
+		{"plain object", `{"a":1}`, objectFormat, `{"a":1}`, false},
+		{"fenced object", "```json\n{\"a\":1}\n```", objectFormat, `{"a":1}`, false},
+		{"surrounding text", "Here you go: {\"a\":1} hope it helps", objectFormat, `{"a":1}`, false},
+		{"array for json_object", `[1,2]`, objectFormat, "", true},
+		{"invalid json", `{"a":`, objectFormat, "", true},
+		{"schema ok", `{"name":"Ann","age":30,"tags":["x"]}`, schemaFormat, `{"name":"Ann","age":30,"tags":["x"]}`, false},
+		{"schema missing required", `{"name":"Ann"}`, schemaFormat, "", true},
+		{"schema wrong type", `{"name":"Ann","age":30.5}`, schemaFormat, "", true},
+		{"schema extra property", `{"name":"Ann","age":30,"extra":true}`, schemaFormat, "", true},
+		{"schema wrong item type", `{"name":"Ann","age":30,"tags":[1]}`, schemaFormat, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleaned, err := ValidateJSONOutput(tt.content, tt.format)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected validation error for %q", tt.content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if cleaned != tt.expect {
+				t.Errorf("Expected %q, got %q", tt.expect, cleaned)
+			}
+		})
+	}
+}
+
+// TestAnthropicResponseFormat 测试 response_format 转换为Anthropic的强制工具调用和system指令
+func TestAnthropicResponseFormat(t *testing.T) {
+	provider := NewAnthropicProvider(&ProviderConfig{ProviderType: "anthropic", APIKey: "test", BaseURL: "https://api.anthropic.com/v1"})
+
+	req := &ChatCompletionRequest{
+		Model: "claude-3-5-sonnet",
+		Messages: []ChatMessage{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "Who are you?"},
+		},
+		ResponseFormat: &ResponseFormat{
+			Type: ResponseFormatJSONSchema,
+			JSONSchema: &JSONSchemaFormat{
+				Name:   "identity",
+				Schema: map[string]interface{}{"type": "object", "properties": map[string]interface{}{"name": map[string]interface{}{"type": "string"}}},
+			},
+		},
+	}
+	anthropicReq, err := provider.transformToAnthropicRequest(req)
+	if err != nil {
+		t.Fatalf("Failed to transform request: %v", err)
+	}
+	if len(anthropicReq.Tools) != 1 || anthropicReq.Tools[0].Name != "identity" || anthropicReq.ToolChoice["name"] != "identity" {
+		t.Errorf("Expected forced identity tool, got tools=%+v choice=%v", anthropicReq.Tools, anthropicReq.ToolChoice)
+	}
+	if anthropicReq.System != "Be brief." || len(anthropicReq.Messages) != 1 {
+		t.Errorf("Expected system message in system parameter, got system=%q messages=%+v", anthropicReq.System, anthropicReq.Messages)
+	}
+
+	resp, err := provider.transformFromAnthropicResponse(&AnthropicResponse{
+		Content:    []AnthropicContent{{Type: "tool_use", Input: []byte(`{"name":"Claude"}`)}},
+		StopReason: "tool_use",
+	})
+	if err != nil {
+		t.Fatalf("Failed to transform response: %v", err)
+	}
+	if resp.Choices[0].Message.Content != `{"name":"Claude"}` || resp.Choices[0].FinishReason != "stop" {
+		t.Errorf("Expected tool input as content, got %+v", resp.Choices[0])
+	}
+
+	req.ResponseFormat = &ResponseFormat{Type: ResponseFormatJSONObject}
+	anthropicReq, err = provider.transformToAnthropicRequest(req)
+	if err != nil {
+		t.Fatalf("Failed to transform request: %v", err)
+	}
+	if len(anthropicReq.Tools) != 0 || !strings.Contains(anthropicReq.System, "valid JSON") {
+		t.Errorf("Expected JSON instruction in system parameter, got %q", anthropicReq.System)
+	}
+}
+
+func TestAnthropicRequestConversion(t *testing.T) {
+	provider := NewAnthropicProvider(&ProviderConfig{ProviderType: "anthropic", APIKey: "test", DefaultMaxTokens: 2048})
+
+	// system和developer消息合并到system参数，连续的同角色消息合并，空白停止序列被忽略
+	req := &ChatCompletionRequest{
+		Model: "claude-sonnet-4",
+		Messages: []ChatMessage{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "Hi"},
+			{Role: "developer", Content: "Answer in English."},
+			{Role: "user", Content: "How are you?"},
+			{Role: "assistant", Content: ""},
+		},
+		Stop: []string{"END", " "},
+	}
+	anthropicReq, err := provider.transformToAnthropicRequest(req)
+	if err != nil {
+		t.Fatalf("Failed to transform request: %v", err)
+	}
+	if anthropicReq.System != "Be brief.\n\nAnswer in English." {
+		t.Errorf("Expected merged system prompt, got %q", anthropicReq.System)
+	}
+	if len(anthropicReq.Messages) != 1 || anthropicReq.Messages[0].Content != "Hi\n\nHow are you?" {
+		t.Errorf("Expected a single merged user message, got %+v", anthropicReq.Messages)
+	}
+	if anthropicReq.MaxTokens != 2048 || len(anthropicReq.StopSequences) != 1 || anthropicReq.StopSequences[0] != "END" {
+		t.Errorf("Expected group default max_tokens and stop sequence END, got %d %v", anthropicReq.MaxTokens, anthropicReq.StopSequences)
+	}
+
+	maxCompletionTokens := 300
+	req.MaxCompletionTokens = &maxCompletionTokens
+	if anthropicReq, _ := provider.transformToAnthropicRequest(req); anthropicReq.MaxTokens != 300 {
+		t.Errorf("Expected max_completion_tokens to be used, got %d", anthropicReq.MaxTokens)
+	}
+	if anthropicReq, _ := NewAnthropicProvider(&ProviderConfig{ProviderType: "anthropic", APIKey: "test"}).transformToAnthropicRequest(&ChatCompletionRequest{
+		Messages: []ChatMessage{{Role: "user", Content: "Hi"}}}); anthropicReq.MaxTokens != DefaultAnthropicMaxTokens {
+		t.Errorf("Expected default max_tokens %d, got %d", DefaultAnthropicMaxTokens, anthropicReq.MaxTokens)
+	}
+
+	// 无法转换的请求返回请求参数错误，不发往上游
+	zero := 0
+	invalid := map[string]*ChatCompletionRequest{
+		"invalid_max_tokens":       {Messages: []ChatMessage{{Role: "user", Content: "Hi"}}, MaxTokens: &zero},
+		"empty_message":            {Messages: []ChatMessage{{Role: "system", Content: "Be brief."}}},
+		"unsupported_message_role": {Messages: []ChatMessage{{Role: "user", Content: "Hi"}, {Role: "tool", Content: "42", ToolCallID: "call_1"}}},
+	}
+	for code, invalidReq := range invalid {
+		_, err := provider.transformToAnthropicRequest(invalidReq)
+		var toolErr *ToolCallError
+		if !errors.As(err, &toolErr) || toolErr.Code != code || ClassifyError(err) != ErrorCategoryInvalidRequest {
+			t.Errorf("Expected %s invalid request error, got %v", code, err)
+		}
+	}
+}
+
+func TestAnthropicStreamTranscoding(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":12,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Checking"}}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`,
+		`{"type":"message_stop"}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprintf(w, "event: x\ndata: %s\n\n", event)
+		}
+	}))
+	defer server.Close()
+
+	provider := NewAnthropicProvider(&ProviderConfig{ProviderType: "anthropic", APIKey: "test", BaseURL: server.URL})
+	stream, err := provider.ChatCompletionStream(context.Background(), &ChatCompletionRequest{
+		Model:         "claude-3-5-sonnet",
+		Messages:      []ChatMessage{{Role: "user", Content: "Weather in Paris?"}},
+		Stream:        true,
+		StreamOptions: &StreamOptions{IncludeUsage: true},
+	})
+	if err != nil {
+		t.Fatalf("ChatCompletionStream() error = %v", err)
+	}
+
+	var chunks []ChatCompletionChunk
+	done := false
+	for response := range stream {
+		if response.Error != nil {
+			t.Fatalf("Unexpected stream error: %v", response.Error)
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(string(response.Data), "data: "))
+		if data == "[DONE]" {
+			done = response.Done
+			continue
+		}
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("Invalid chunk %q: %v", data, err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if !done || len(chunks) != 6 {
+		t.Fatalf("Expected 6 chunks followed by [DONE], got %d (done=%v)", len(chunks), done)
+	}
+
+	first := chunks[0].Choices[0].Delta
+	if first.Role != "assistant" || first.Content == nil || *first.Content != "Checking" {
+		t.Errorf("Expected first chunk with role and text, got %+v", first)
+	}
+	call := chunks[1].Choices[0].Delta.ToolCalls
+	if len(call) != 1 || call[0].Index != 0 || call[0].ID != "toolu_1" || call[0].Type != "function" || call[0].Function.Name != "get_weather" {
+		t.Errorf("Expected tool call start, got %+v", call)
+	}
+	arguments := ""
+	for _, chunk := range chunks[2:4] {
+		arguments += chunk.Choices[0].Delta.ToolCalls[0].Function.Arguments
+	}
+	if arguments != `{"city":"Paris"}` {
+		t.Errorf("Expected streamed arguments, got %q", arguments)
+	}
+	if reason := chunks[4].Choices[0].FinishReason; reason == nil || *reason != "tool_calls" {
+		t.Errorf("Expected finish_reason tool_calls, got %v", reason)
+	}
+	if usage := chunks[5].Usage; len(chunks[5].Choices) != 0 || usage == nil || usage.PromptTokens != 12 || usage.CompletionTokens != 20 || usage.TotalTokens != 32 {
+		t.Errorf("Expected usage chunk without choices, got %+v", chunks[5])
+	}
+}
+
+func TestGeminiStreamChunks(t *testing.T) {
+	transcoder := newChunkTranscoder(&ChatCompletionRequest{Model: "gemini-2.5-flash"})
+	var chunks [][]byte
+	chunks = append(chunks, geminiStreamChunks(transcoder, &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{
+			{Index: 0, Content: &genai.Content{Parts: []*genai.Part{{Text: "thinking", Thought: true}, {Text: "Hello"}}}},
+			{Index: 1, Content: &genai.Content{Parts: []*genai.Part{{FunctionCall: &genai.FunctionCall{Name: "lookup", Args: map[string]any{"q": "x"}}}}}},
+		},
+	})...)
+	chunks = append(chunks, geminiStreamChunks(transcoder, &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{
+			{Index: 0, FinishReason: genai.FinishReasonMaxTokens},
+			{Index: 1, FinishReason: genai.FinishReasonStop},
+		},
+		UsageMetadata: &genai.GenerateContentResponseUsageMetadata{PromptTokenCount: 5, CandidatesTokenCount: 7, TotalTokenCount: 12},
+	})...)
+	chunks = append(chunks, transcoder.end()...)
+
+	// 思考内容作为 reasoning_content 输出；每个选择各自带有 role 和结束原因；未请求 include_usage 时不发送用量
+	if len(chunks) != 6 || string(chunks[5]) != "data: [DONE]\n\n" {
+		t.Fatalf("Expected 5 chunks followed by [DONE], got %d", len(chunks))
+	}
+	var parsed []ChatCompletionChunk
+	for _, data := range chunks[:5] {
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(strings.TrimSpace(string(data)), "data: ")), &chunk); err != nil {
+			t.Fatalf("Invalid chunk %q: %v", data, err)
+		}
+		parsed = append(parsed, chunk)
+	}
+	if delta := parsed[0].Choices[0].Delta; parsed[0].Choices[0].Index != 0 || delta.Role != "assistant" || delta.Content != nil ||
+		delta.ReasoningContent == nil || *delta.ReasoningContent != "thinking" {
+		t.Errorf("Expected reasoning for choice 0, got %+v", parsed[0].Choices[0])
+	}
+	if delta := parsed[1].Choices[0].Delta; parsed[1].Choices[0].Index != 0 || delta.Role != "" || *delta.Content != "Hello" {
+		t.Errorf("Expected text for choice 0, got %+v", parsed[1].Choices[0])
+	}
+	if choice := parsed[2].Choices[0]; choice.Index != 1 || choice.Delta.Role != "assistant" || len(choice.Delta.ToolCalls) != 1 ||
+		choice.Delta.ToolCalls[0].Function.Arguments != `{"q":"x"}` || choice.Delta.ToolCalls[0].ID == "" {
+		t.Errorf("Expected tool call for choice 1, got %+v", choice)
+	}
+	if reason := parsed[3].Choices[0].FinishReason; parsed[3].Choices[0].Index != 0 || *reason != "length" {
+		t.Errorf("Expected finish_reason length for choice 0, got %v", *reason)
+	}
+	if reason := parsed[4].Choices[0].FinishReason; parsed[4].Choices[0].Index != 1 || *reason != "tool_calls" {
+		t.Errorf("Expected finish_reason tool_calls for choice 1, got %v", *reason)
+	}
+}
+
+func TestMultipleChoices(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		var body AnthropicRequest
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":10}}}\n\n")
+			fmt.Fprintf(w, "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"answer %d\"}}\n\n", n)
+			fmt.Fprintf(w, "data: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":5}}\n\n")
+			fmt.Fprintf(w, "data: {\"type\":\"message_stop\"}\n\n")
+			return
+		}
+		fmt.Fprintf(w, `{"id":"msg","content":[{"type":"text","text":"answer %d"}],"stop_reason":"max_tokens","usage":{"input_tokens":10,"output_tokens":5}}`, n)
+	}))
+	defer server.Close()
+
+	provider := NewAnthropicProvider(&ProviderConfig{ProviderType: "anthropic", APIKey: "test", BaseURL: server.URL})
+	n := 3
+	req := &ChatCompletionRequest{Model: "claude-3-5-sonnet", Messages: []ChatMessage{{Role: "user", Content: "Hi"}}, N: &n}
+
+	// Anthropic并行发送 n 个请求，选择按顺序编号，用量为所有请求之和
+	resp, err := provider.ChatCompletion(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+	if atomic.LoadInt32(&requests) != 3 || len(resp.Choices) != 3 {
+		t.Fatalf("Expected 3 upstream requests and 3 choices, got %d requests and %d choices", requests, len(resp.Choices))
+	}
+	for i, choice := range resp.Choices {
+		if choice.Index != i || !strings.HasPrefix(choice.Message.Content, "answer") || choice.FinishReason != "length" {
+			t.Errorf("Unexpected choice %d: %+v", i, choice)
+		}
+	}
+	if resp.Usage.PromptTokens != 30 || resp.Usage.TotalTokens != 45 {
+		t.Errorf("Expected summed usage, got %+v", resp.Usage)
+	}
+
+	n = 2
+	req.Stream = true
+	req.StreamOptions = &StreamOptions{IncludeUsage: true}
+	stream, err := provider.ChatCompletionStream(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ChatCompletionStream() error = %v", err)
+	}
+	contents := map[int]int{}
+	finished := map[int]string{}
+	var usage *Usage
+	for response := range stream {
+		if response.Error != nil {
+			t.Fatalf("Unexpected stream error: %v", response.Error)
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(string(response.Data), "data: "))
+		if data == "[DONE]" {
+			continue
+		}
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("Invalid chunk %q: %v", data, err)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != nil {
+				contents[choice.Index]++
+			}
+			if choice.FinishReason != nil {
+				finished[choice.Index] = *choice.FinishReason
+			}
+		}
+	}
+	if contents[0] != 1 || contents[1] != 1 || finished[0] != "stop" || finished[1] != "stop" {
+		t.Errorf("Expected one text chunk and finish_reason per choice, got contents=%v finished=%v", contents, finished)
+	}
+	if usage == nil || usage.PromptTokens != 20 || usage.CompletionTokens != 10 {
+		t.Errorf("Expected summed stream usage, got %+v", usage)
+	}
+
+	// Gemini每个候选对应一个选择
+	gemini := &GeminiProvider{}
+	geminiResp, err := gemini.convertGenaiToOpenAIResponse(&genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{
+			{Content: &genai.Content{Parts: []*genai.Part{{Text: "first"}}}, FinishReason: genai.FinishReasonStop},
+			{Index: 1, Content: &genai.Content{Parts: []*genai.Part{{FunctionCall: &genai.FunctionCall{ID: "fc_1", Name: "lookup"}}}}, FinishReason: genai.FinishReasonStop},
+		},
+	}, "gemini-2.5-flash")
+	if err != nil {
+		t.Fatalf("convertGenaiToOpenAIResponse() error = %v", err)
+	}
+	if len(geminiResp.Choices) != 2 || geminiResp.Choices[0].Message.Content != "first" || geminiResp.Choices[1].Index != 1 ||
+		geminiResp.Choices[1].FinishReason != "tool_calls" || geminiResp.Choices[1].Message.ToolCalls[0].ID != "fc_1" {
+		t.Errorf("Expected one choice per candidate, got %+v", geminiResp.Choices)
+	}
+}
+
+func TestStageTimeouts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-headers" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// 响应头之后的输出可以超过首字节超时
+		time.Sleep(150 * time.Millisecond)
+		w.Write([]byte("done"))
+	}))
+	defer server.Close()
+
+	client := NewBaseProvider(&ProviderConfig{}).HTTPClient
+	ctx := WithStageTimeouts(context.Background(), time.Second, 100*time.Millisecond)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/slow-headers", nil)
+	start := time.Now()
+	_, err := client.Do(req)
+	if !errors.Is(err, ErrFirstByteTimeout) || !IsStageTimeout(err) {
+		t.Fatalf("Expected first byte timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("Expected to fail fast, took %v", elapsed)
+	}
+
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/slow-body", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, got %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "done" {
+		t.Errorf("Expected full body after first byte, got %q (err: %v)", body, err)
+	}
+
+	if IsStageTimeout(context.DeadlineExceeded) {
+		t.Error("Total timeout should not be reported as a stage timeout")
+	}
+}
+
+func TestUpstreamIdentity(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	SetUpstreamIdentity("TurnsAPI/2.0 (+ops@example.com)", map[string]string{"http-referer": "https://example.com", "X-Title": "Example"})
+	defer SetUpstreamIdentity("", nil)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("User-Agent", "google-genai-sdk/1.0 gl-go/1.23")
+	if _, err := NewBaseProvider(&ProviderConfig{}).HTTPClient.Do(req); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if got := received.Get("User-Agent"); got != "TurnsAPI/2.0 (+ops@example.com)" {
+		t.Errorf("Expected configured user agent to replace the SDK one, got %q", got)
+	}
+	if received.Get("HTTP-Referer") != "https://example.com" || received.Get("X-Title") != "Example" {
+		t.Errorf("Expected attribution headers, got %v", received)
+	}
+	if req.Header.Get("User-Agent") != "google-genai-sdk/1.0 gl-go/1.23" {
+		t.Error("Expected the caller's request to be left unchanged")
+	}
+
+	// 分组 headers 中的同名请求头优先
+	groupHeaders := map[string]string{"user-agent": "GroupAgent/1.0", "X-Title": "Group"}
+	req, _ = http.NewRequest(http.MethodGet, server.URL, nil)
+	if _, err := NewBaseProvider(&ProviderConfig{Headers: groupHeaders}).HTTPClient.Do(req); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if received.Get("User-Agent") != "GroupAgent/1.0" || received.Get("X-Title") != "Group" || received.Get("HTTP-Referer") != "https://example.com" {
+		t.Errorf("Expected group headers to override the global identity, got %v", received)
+	}
+
+	SetUpstreamIdentity("", nil)
+	req, _ = http.NewRequest(http.MethodGet, server.URL, nil)
+	if _, err := NewBaseProvider(&ProviderConfig{}).HTTPClient.Do(req); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if received.Get("User-Agent") != DefaultUserAgent || received.Get("X-Title") != "" {
+		t.Errorf("Expected default user agent without attribution headers, got %v", received)
+	}
+}
+
+func TestTracingPropagation(t *testing.T) {
+	var exported []byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exported, _ = io.ReadAll(r.Body)
+	}))
+	defer collector.Close()
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	shutdown := tracing.Init(tracing.Config{Endpoint: collector.URL})
+
+	// 沿用客户端传入的追踪ID
+	incoming := http.Header{}
+	incoming.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, span := tracing.Start(tracing.Extract(context.Background(), incoming), "POST /v1/chat/completions", tracing.KindServer)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/v1/models?key=secret", nil)
+	if _, err := NewBaseProvider(&ProviderConfig{}).HTTPClient.Do(req); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	span.End()
+
+	traceparent := received.Get("traceparent")
+	if !strings.HasPrefix(traceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || !strings.HasSuffix(traceparent, "-01") {
+		t.Errorf("Expected upstream traceparent in the incoming trace, got %q", traceparent)
+	}
+	if strings.Contains(traceparent, "-00f067aa0ba902b7-") {
+		t.Error("Expected the upstream parent to be the client span, not the caller")
+	}
+	if req.Header.Get("traceparent") != "" {
+		t.Error("Expected the caller's request to be left unchanged")
+	}
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	body := string(exported)
+	for _, want := range []string{`"traceId":"4bf92f3577b34da6a3ce929d0e0e4736"`, `"parentSpanId":"00f067aa0ba902b7"`, `"name":"HTTP GET"`, `"stringValue":"/v1/models"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected exported spans to contain %s, got %s", want, body)
+		}
+	}
+	if strings.Contains(body, "secret") {
+		t.Error("Expected query parameters to be left out of spans")
+	}
+
+	// 关闭后不再创建span，也不传递追踪上下文
+	req, _ = http.NewRequest(http.MethodGet, server.URL, nil)
+	if _, err := NewBaseProvider(&ProviderConfig{}).HTTPClient.Do(req); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if received.Get("traceparent") != "" {
+		t.Errorf("Expected no traceparent when tracing is disabled, got %q", received.Get("traceparent"))
+	}
+}
+
+func TestOutboundProxy(t *testing.T) {
+	var proxiedURL string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 经HTTP代理转发的请求使用绝对URL
+		proxiedURL = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	provider := NewBaseProvider(&ProviderConfig{ProxyURL: proxy.URL})
+	resp, err := provider.HTTPClient.Get("http://upstream.invalid/v1/models")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if proxiedURL != "http://upstream.invalid/v1/models" {
+		t.Errorf("Expected request to go through the proxy, got %q", proxiedURL)
+	}
+
+	// 同一代理地址复用 Transport
+	if outboundTransport(proxy.URL, nil, nil) != outboundTransport(proxy.URL, nil, nil) {
+		t.Error("Expected transports to be shared per proxy URL")
+	}
+
+	for _, invalid := range []string{"ftp://proxy:21", "socks5://", "://bad"} {
+		if _, err := ParseProxyURL(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+	resp, err = NewBaseProvider(&ProviderConfig{ProxyURL: "ftp://proxy:21"}).HTTPClient.Get(proxy.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Error("Expected an invalid proxy URL to fail instead of connecting directly")
+	}
+}
+
+func TestUpstreamTLS(t *testing.T) {
+	var peerCertificates int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peerCertificates = len(r.TLS.PeerCertificates)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	// 使用测试服务器自签名的证书作为私有CA和客户端证书
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	keyDER, err := x509.MarshalPKCS8PrivateKey(server.TLS.Certificates[0].PrivateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	if resp, err := NewBaseProvider(&ProviderConfig{}).HTTPClient.Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("Expected an untrusted certificate to be rejected")
+	}
+
+	config := &ProviderConfig{TLS: &TLSOptions{CAFile: certFile, CertFile: certFile, KeyFile: keyFile}}
+	resp, err := NewBaseProvider(config).HTTPClient.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request with private CA failed: %v", err)
+	}
+	resp.Body.Close()
+	if peerCertificates != 1 {
+		t.Errorf("Expected the client certificate to be sent, got %d certificates", peerCertificates)
+	}
+
+	resp, err = NewBaseProvider(&ProviderConfig{TLS: &TLSOptions{InsecureSkipVerify: true}}).HTTPClient.Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Error("Expected the server to reject requests without a client certificate")
+	}
+
+	for name, opts := range map[string]*TLSOptions{
+		"cert without key": {CertFile: certFile},
+		"missing CA":       {CAFile: filepath.Join(dir, "missing.pem")},
+		"CA without PEM":   {CAFile: keyFile},
+	} {
+		if _, err := LoadTLSConfig(opts); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestUpstreamTransport(t *testing.T) {
+	SetTransportDefaults(&TransportOptions{MaxIdleConnsPerHost: 128, IdleConnTimeout: time.Minute})
+	defer SetTransportDefaults(nil)
+
+	// 分组设置覆盖全局设置，未设置的字段使用全局设置或内置默认值
+	opts := EffectiveTransportOptions(&TransportOptions{MaxConnsPerHost: 256, DisableHTTP2: true})
+	expected := TransportOptions{MaxIdleConns: 512, MaxIdleConnsPerHost: 128, MaxConnsPerHost: 256, IdleConnTimeout: time.Minute, DisableHTTP2: true}
+	if opts != expected {
+		t.Errorf("Expected merged options %+v, got %+v", expected, opts)
+	}
+	if outboundTransport("", nil, nil) == outboundTransport("", nil, &TransportOptions{DisableHTTP2: true}) {
+		t.Error("Expected groups with different pool settings to use separate transports")
+	}
+
+	var protocol string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocol = r.Proto
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	for _, disableHTTP2 := range []bool{false, true} {
+		transport, err := newOutboundTransport("", &TLSOptions{InsecureSkipVerify: true}, EffectiveTransportOptions(&TransportOptions{DisableHTTP2: disableHTTP2}))
+		if err != nil {
+			t.Fatalf("Failed to create transport: %v", err)
+		}
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		transport.CloseIdleConnections()
+		if want := map[bool]string{false: "HTTP/2.0", true: "HTTP/1.1"}[disableHTTP2]; protocol != want {
+			t.Errorf("Expected %s with disable_http2=%v, got %s", want, disableHTTP2, protocol)
+		}
+	}
+
+	// 缓存的解析结果过期前不再查询DNS，地址为IP时直接连接
+	cache := newDNSCache(time.Minute, net.DefaultResolver)
+	cache.entries["upstream.invalid"] = dnsCacheEntry{addrs: []string{"127.0.0.1"}, expires: time.Now().Add(time.Minute)}
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	_, port, _ := net.SplitHostPort(plain.Listener.Addr().String())
+	client := &http.Client{Transport: &http.Transport{DialContext: cache.dialContext(&net.Dialer{Timeout: time.Second})}}
+	for _, target := range []string{"http://upstream.invalid:" + port, plain.URL} {
+		resp, err := client.Get(target)
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", target, err)
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestOpenAICompatibleKeyless(t *testing.T) {
+	var authorization []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = append(authorization, r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/api/tags":
+			fmt.Fprint(w, `{"models":[{"name":"llama3:latest","model":"llama3:latest"},{"name":"qwen2.5:7b"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewDefaultProviderFactory().CreateProvider(&ProviderConfig{
+		BaseURL:      server.URL + "/v1",
+		ProviderType: "openai_compatible",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	// 旧版本Ollama没有 /v1/models，回退到 /api/tags 并转换为OpenAI格式
+	models, err := provider.GetModels(context.Background())
+	if err != nil {
+		t.Fatalf("GetModels failed: %v", err)
+	}
+	data, _ := models.(map[string]interface{})["data"].([]interface{})
+	if len(data) != 2 || data[0].(map[string]interface{})["id"] != "llama3:latest" {
+		t.Errorf("Expected Ollama tags converted to OpenAI models, got %v", models)
+	}
+	for _, header := range authorization {
+		if header != "" {
+			t.Errorf("Expected no Authorization header without an API key, got %q", header)
+		}
+	}
+	if RequiresAPIKey("openai_compatible") || !RequiresAPIKey("openai") {
+		t.Error("Expected only openai_compatible to allow empty API keys")
+	}
+}
+
+func TestOpenAIForwardUsesGroupKey(t *testing.T) {
+	var gotPath, gotAuth, gotContentType, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth, gotContentType = r.URL.RequestURI(), r.Header.Get("Authorization"), r.Header.Get("Content-Type")
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		fmt.Fprint(w, `{"id":"batch_1","object":"batch"}`)
+	}))
+	defer server.Close()
+
+	provider := NewOpenAIProvider(&ProviderConfig{BaseURL: server.URL + "/v1", APIKey: "sk-group", ProviderType: "openai"})
+	header := http.Header{}
+	header.Set("Authorization", "Bearer client-proxy-key")
+	header.Set("Content-Type", "application/json")
+	resp, err := provider.Forward(context.Background(), http.MethodPost, "/batches?limit=1", header, strings.NewReader(`{"input_file_id":"file-1"}`))
+	if err != nil {
+		t.Fatalf("Forward failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if gotPath != "/v1/batches?limit=1" {
+		t.Errorf("Expected path /v1/batches?limit=1, got %s", gotPath)
+	}
+	if gotAuth != "Bearer sk-group" {
+		t.Errorf("Expected the group key to replace the client key, got %q", gotAuth)
+	}
+	if gotContentType != "application/json" || gotBody != `{"input_file_id":"file-1"}` {
+		t.Errorf("Expected the body to be forwarded unchanged, got %q %q", gotContentType, gotBody)
+	}
+	if !SupportsBatches("openai_compatible") || SupportsBatches("anthropic") {
+		t.Error("Expected batches to be supported only by OpenAI-style groups")
+	}
+}
+
+func TestContextWindowTruncation(t *testing.T) {
+	windows := map[string]int{"gpt-4o": 128000, "gpt-4o-mini": 64000, "claude": 200000}
+	for model, expected := range map[string]int{
+		"gpt-4o":                 128000,
+		"gpt-4o-2024-08-06":      128000,
+		"gpt-4o-mini-2024-07-18": 64000,
+		"openai/gpt-4o-mini":     64000,
+		"claude-sonnet-4":        200000,
+		"o1":                     0,
+	} {
+		if window := LookupContextWindow(windows, model); window != expected {
+			t.Errorf("%s: expected window %d, got %d", model, expected, window)
+		}
+	}
+
+	long := strings.Repeat("lorem ipsum dolor sit amet ", 200)
+	calls := []ToolCall{{ID: "call_1", Type: "function", Function: &FunctionCall{Name: "lookup", Arguments: "{}"}}}
+	req := &ChatCompletionRequest{Messages: []ChatMessage{
+		{Role: "system", Content: "You are helpful."},
+		{Role: "user", Content: long},
+		{Role: "assistant", Content: "", ToolCalls: calls},
+		{Role: "tool", ToolCallID: "call_1", Content: long},
+		{Role: "user", Content: "Summarize."},
+	}}
+	total := EstimatePromptTokens(req)
+
+	messages, dropped, fits := TruncateMessages(req, total-1)
+	if !fits || dropped != 1 || len(messages) != 4 || messages[1].Role != "assistant" {
+		t.Errorf("Expected only the oldest user message to be dropped, got dropped=%d fits=%v", dropped, fits)
+	}
+
+	// 丢弃发起工具调用的assistant消息时，工具结果一并丢弃
+	budget := EstimatePromptTokens(&ChatCompletionRequest{Messages: []ChatMessage{req.Messages[0], req.Messages[4]}})
+	messages, dropped, fits = TruncateMessages(req, budget)
+	if !fits || dropped != 3 || len(messages) != 2 || messages[0].Role != "system" || messages[1].Content != "Summarize." {
+		t.Errorf("Expected system and last messages to remain, got %d messages (dropped=%d fits=%v)", len(messages), dropped, fits)
+	}
+
+	if _, _, fits = TruncateMessages(req, 1); fits {
+		t.Error("Expected a budget smaller than the kept messages not to fit")
+	}
+	if len(req.Messages) != 5 {
+		t.Error("Expected the original messages to be left unchanged")
+	}
+}
+
+func TestReasoning(t *testing.T) {
+	// 推理强度映射为Gemini思考预算，未指定时使用动态预算
+	config := geminiThinkingConfig(&ChatCompletionRequest{ReasoningEffort: "High"}, true)
+	if config.ThinkingBudget == nil || *config.ThinkingBudget != 24576 || !config.IncludeThoughts {
+		t.Errorf("Expected thinking budget 24576 with thoughts, got %+v", config)
+	}
+	if config := geminiThinkingConfig(&ChatCompletionRequest{}, false); config.ThinkingBudget != nil || config.IncludeThoughts {
+		t.Errorf("Expected dynamic thinking budget without thoughts, got %+v", config)
+	}
+
+	// Gemini的思考token计入 completion_tokens 并单独列出
+	usage := geminiUsage(&genai.GenerateContentResponseUsageMetadata{PromptTokenCount: 5, CandidatesTokenCount: 7, ThoughtsTokenCount: 20, TotalTokenCount: 32})
+	if usage.CompletionTokens != 27 || usage.TotalTokens != 32 || usage.CompletionTokensDetails == nil || usage.CompletionTokensDetails.ReasoningTokens != 20 {
+		t.Errorf("Expected 20 reasoning tokens in 27 completion tokens, got %+v", usage)
+	}
+
+	// Anthropic启用扩展思考，思考预算追加到 max_tokens，并去除 temperature
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body AnthropicRequest
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Thinking == nil || body.Thinking.Type != "enabled" || body.Thinking.BudgetTokens != 1024 || body.MaxTokens != 1124 || body.Temperature != nil {
+			t.Errorf("Expected thinking with budget 1024 and max_tokens 1124, got %+v", body)
+		}
+		fmt.Fprint(w, `{"id":"msg","content":[{"type":"thinking","thinking":"Let me think about it"},{"type":"text","text":"42"}],"stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":50}}`)
+	}))
+	defer server.Close()
+
+	maxTokens, temperature := 100, 0.5
+	req := &ChatCompletionRequest{Model: "claude-sonnet-4", Messages: []ChatMessage{{Role: "user", Content: "Hi"}},
+		MaxTokens: &maxTokens, Temperature: &temperature, ReasoningEffort: "low"}
+	for _, include := range []bool{true, false} {
+		provider := NewAnthropicProvider(&ProviderConfig{ProviderType: "anthropic", APIKey: "test", BaseURL: server.URL, IncludeReasoning: include})
+		response, err := provider.ChatCompletion(context.Background(), req)
+		if err != nil {
+			t.Fatalf("ChatCompletion failed: %v", err)
+		}
+		message := response.Choices[0].Message
+		if message.Content != "42" || (message.ReasoningContent != "") != include {
+			t.Errorf("Expected reasoning_content only when included (%v), got %+v", include, message)
+		}
+		if details := response.Usage.CompletionTokensDetails; details == nil || details.ReasoningTokens <= 0 || details.ReasoningTokens > 50 {
+			t.Errorf("Expected estimated reasoning tokens, got %+v", response.Usage)
+		}
+	}
+
+	// 请求的 include_reasoning 覆盖分组设置
+	include := true
+	override := *req
+	override.IncludeReasoning = &include
+	provider := NewAnthropicProvider(&ProviderConfig{ProviderType: "anthropic", APIKey: "test", BaseURL: server.URL})
+	if response, err := provider.ChatCompletion(context.Background(), &override); err != nil || response.Choices[0].Message.ReasoningContent == "" {
+		t.Errorf("Expected per-request include_reasoning to override the group setting, got %+v, %v", response, err)
+	}
+
+	// 以强制工具调用实现的结构化输出不启用思考
+	structured := *req
+	structured.ResponseFormat = &ResponseFormat{Type: "json_schema", JSONSchema: &JSONSchemaFormat{Name: "answer", Schema: map[string]interface{}{"type": "object"}}}
+	anthropicReq, _ := NewAnthropicProvider(&ProviderConfig{ProviderType: "anthropic", APIKey: "test"}).transformToAnthropicRequest(&structured)
+	if anthropicReq.Thinking != nil || anthropicReq.MaxTokens != 100 {
+		t.Errorf("Expected no thinking with forced tool choice, got %+v", anthropicReq)
+	}
+
+	// OpenAI格式流式数据行去除思考内容，其余内容不变
+	line := stripReasoningLine(`data: {"id":"1","choices":[{"index":0,"delta":{"content":"Hi","reasoning_content":"hmm"}}]}`)
+	if strings.Contains(line, "reasoning") || !strings.Contains(line, `"content":"Hi"`) {
+		t.Errorf("Expected reasoning_content to be stripped, got %s", line)
+	}
+	if line := `data: {"choices":[{"delta":{"content":"Hi"}}]}`; stripReasoningLine(line) != line {
+		t.Error("Expected lines without reasoning to be left unchanged")
+	}
+}
+
+func TestGeminiEndpoint(t *testing.T) {
+	tests := []struct {
+		baseURL, root, version string
+	}{
+		{"", "", ""},
+		{"https://generativelanguage.googleapis.com/v1beta", "https://generativelanguage.googleapis.com", "v1beta"},
+		{"https://proxy.example.com/gemini/v1/", "https://proxy.example.com/gemini", "v1"},
+		{"https://proxy.example.com/gemini", "https://proxy.example.com/gemini", ""},
+	}
+	for _, tt := range tests {
+		if root, version := geminiEndpoint(tt.baseURL); root != tt.root || version != tt.version {
+			t.Errorf("geminiEndpoint(%q) = %q, %q, expected %q, %q", tt.baseURL, root, version, tt.root, tt.version)
+		}
+	}
+}
+
+func TestGeminiBaseURL(t *testing.T) {
+	// 聊天请求发往分组的BaseURL，携带API密钥和分组请求头
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/gemini/v1beta/models/gemini-2.5-flash:generateContent" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("x-goog-api-key") != "test-key" || r.Header.Get("X-Tenant") != "acme" {
+			t.Errorf("Expected API key and group headers, got %v", r.Header)
+		}
+		fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"Hi"}]},"finishReason":"STOP"}]}`)
+	}))
+	defer server.Close()
+
+	provider := NewGeminiProvider(&ProviderConfig{ProviderType: "gemini", APIKey: "test-key", BaseURL: server.URL + "/gemini/v1beta",
+		Headers: map[string]string{"X-Tenant": "acme"}})
+	response, err := provider.ChatCompletion(context.Background(), &ChatCompletionRequest{Model: "gemini-2.5-flash", Messages: []ChatMessage{{Role: "user", Content: "Hi"}}})
+	if err != nil {
+		t.Fatalf("ChatCompletion failed: %v", err)
+	}
+	if response.Choices[0].Message.Content != "Hi" {
+		t.Errorf("Expected content Hi, got %+v", response.Choices[0].Message)
+	}
+}
+
+func TestGeminiVertexAI(t *testing.T) {
+	var tokenRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokenRequests.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"access_token":"vertex-token","token_type":"Bearer","expires_in":3600}`)
+			return
+		}
+		if r.URL.Path != "/v1beta1/projects/sa-project/locations/europe-west4/publishers/google/models/gemini-2.5-flash:generateContent" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer vertex-token" {
+			t.Errorf("Expected OAuth token, got %q", r.Header.Get("Authorization"))
+		}
+		fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"Hi"}]},"finishReason":"STOP"}]}`)
+	}))
+	defer server.Close()
+
+	// 服务账号JSON通过OAuth认证，未设置项目时使用服务账号中的 project_id
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	serviceAccount, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "sa-project",
+		"private_key_id": "1",
+		"private_key":    string(keyPEM),
+		"client_email":   "proxy@sa-project.iam.gserviceaccount.com",
+		"token_uri":      server.URL + "/token",
+	})
+	provider := NewGeminiProvider(&ProviderConfig{ProviderType: "gemini", APIKey: string(serviceAccount), BaseURL: server.URL,
+		VertexAI: &VertexAIOptions{Location: "europe-west4"}})
+	response, err := provider.ChatCompletion(context.Background(), &ChatCompletionRequest{Model: "gemini-2.5-flash", Messages: []ChatMessage{{Role: "user", Content: "Hi"}}})
+	if err != nil {
+		t.Fatalf("ChatCompletion failed: %v", err)
+	}
+	if response.Choices[0].Message.Content != "Hi" || tokenRequests.Load() != 1 {
+		t.Errorf("Expected content Hi after one token request, got %+v, %d token requests", response.Choices[0].Message, tokenRequests.Load())
+	}
+
+	// 非JSON密钥作为Vertex AI API密钥，公共Gemini地址替换为Vertex AI默认地址
+	config, err := geminiClientConfig(context.Background(), &ProviderConfig{APIKey: "express-key", BaseURL: "https://generativelanguage.googleapis.com/v1beta",
+		VertexAI: &VertexAIOptions{}}, http.DefaultTransport)
+	if err != nil {
+		t.Fatalf("geminiClientConfig failed: %v", err)
+	}
+	if config.Backend != genai.BackendVertexAI || config.APIKey != "express-key" || config.HTTPOptions.BaseURL != "" {
+		t.Errorf("Expected Vertex AI express mode with default endpoint, got %+v", config)
+	}
+}
+
+func TestDropUnsupportedParams(t *testing.T) {
+	seed, penalty := 7, 0.5
+	req := &ChatCompletionRequest{Model: "test", Seed: &seed, FrequencyPenalty: &penalty, PresencePenalty: &penalty,
+		Stop: []string{"a", "b", "c", "d", "e", "f"}}
+
+	// Anthropic不支持seed和重复惩罚，停止序列不限制数量
+	normalized, dropped := DropUnsupportedParams("anthropic", req)
+	if strings.Join(dropped, ",") != "frequency_penalty,presence_penalty,seed" {
+		t.Errorf("Expected penalties and seed to be dropped, got %v", dropped)
+	}
+	if normalized.Seed != nil || normalized.FrequencyPenalty != nil || len(normalized.Stop) != 6 || req.Seed == nil {
+		t.Errorf("Expected a normalized copy without unsupported params, got %+v", normalized)
+	}
+
+	// OpenAI最多支持4个停止序列
+	normalized, dropped = DropUnsupportedParams("openai", req)
+	if strings.Join(dropped, ",") != "stop" || len(normalized.Stop) != 4 || normalized.Seed == nil {
+		t.Errorf("Expected stop to be truncated to 4 sequences, got %v %+v", dropped, normalized)
+	}
+
+	// 全部支持或未知的提供商类型返回原请求
+	req.Stop = req.Stop[:2]
+	for _, providerType := range []string{"openrouter", "unknown"} {
+		if normalized, dropped := DropUnsupportedParams(providerType, req); normalized != req || dropped != nil {
+			t.Errorf("Expected %s request to be left unchanged, got %v", providerType, dropped)
+		}
+	}
+
+	// Gemini映射seed和重复惩罚
+	genConfig := &genai.GenerateContentConfig{}
+	applyGeminiSamplingParams(genConfig, req)
+	if genConfig.Seed == nil || *genConfig.Seed != 7 || genConfig.FrequencyPenalty == nil || genConfig.PresencePenalty == nil || *genConfig.PresencePenalty != 0.5 {
+		t.Errorf("Expected seed and penalties in Gemini config, got %+v", genConfig)
+	}
+}
+
+func TestStreamIncludeUsage(t *testing.T) {
+	// 上游忽略 stream_options 时在 [DONE] 之前补发估算的用量
+	reportUsage := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if options, _ := body["stream_options"].(map[string]interface{}); options["include_usage"] != true {
+			t.Errorf("Expected stream_options to be forwarded, got %v", body["stream_options"])
+		}
+		fmt.Fprint(w, "data: {\"id\":\"chatcmpl-1\",\"created\":1,\"model\":\"llama3\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hello there\"}}]}\n\n")
+		if reportUsage {
+			fmt.Fprint(w, "data: {\"id\":\"chatcmpl-1\",\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":2,\"total_tokens\":5}}\n\n")
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	req := &ChatCompletionRequest{Model: "llama3", Messages: []ChatMessage{{Role: "user", Content: "Hi"}}, StreamOptions: &StreamOptions{IncludeUsage: true}}
+	readStream := func() string {
+		provider := NewOpenAIProvider(&ProviderConfig{ProviderType: OpenAICompatibleProviderType, BaseURL: server.URL})
+		stream, err := provider.ChatCompletionStream(context.Background(), req)
+		if err != nil {
+			t.Fatalf("ChatCompletionStream failed: %v", err)
+		}
+		var output strings.Builder
+		for chunk := range stream {
+			output.Write(chunk.Data)
+		}
+		return output.String()
+	}
+
+	output := readStream()
+	usageAt, doneAt := strings.Index(output, `"usage":{"prompt_tokens"`), strings.Index(output, "[DONE]")
+	if usageAt < 0 || usageAt > doneAt || !strings.Contains(output, `"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"llama3","choices":[]`) {
+		t.Errorf("Expected an estimated usage chunk before [DONE], got %s", output)
+	}
+	reportUsage = true
+	if output := readStream(); strings.Count(output, `"usage"`) != 1 || !strings.Contains(output, `"total_tokens":5`) {
+		t.Errorf("Expected only the upstream usage chunk, got %s", output)
+	}
+
+	// 转换的流式响应在上游没有报告用量时同样估算
+	transcoder := newChunkTranscoder(req)
+	transcoder.content(0, "Hello there")
+	chunks := transcoder.end()
+	var usageChunk ChatCompletionChunk
+	if err := json.Unmarshal(bytes.TrimPrefix(chunks[len(chunks)-2], []byte("data: ")), &usageChunk); err != nil {
+		t.Fatalf("Failed to decode usage chunk: %v", err)
+	}
+	if usage := usageChunk.Usage; usage == nil || usage.PromptTokens == 0 || usage.CompletionTokens == 0 || usage.TotalTokens != usage.PromptTokens+usage.CompletionTokens {
+		t.Errorf("Expected estimated usage, got %+v", usageChunk.Usage)
+	}
+}
+
+func TestHealthCheckModel(t *testing.T) {
+	var requests []string
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/models":
+			fmt.Fprint(w, `{"object":"list","data":[]}`)
+		case "/v1/chat/completions":
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o-mini",`+
+				`"choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"length"}]}`)
+		case "/v1/messages":
+			fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-5-haiku-latest",`+
+				`"content":[{"type":"text","text":"hi"}],"stop_reason":"max_tokens","usage":{"input_tokens":1,"output_tokens":1}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		providerType string
+		model        string
+		wantRequest  string
+	}{
+		{"openai without model checks models endpoint", "openai", "", "GET /v1/models"},
+		{"openai with model sends chat request", "openai", "gpt-4o-mini", "POST /v1/chat/completions"},
+		{"anthropic without model checks models endpoint", "anthropic", "", "GET /v1/models"},
+		{"anthropic with model sends messages request", "anthropic", "claude-3-5-haiku-latest", "POST /v1/messages"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests, bodies = nil, nil
+			// Anthropic的默认接口路径包含 /v1
+			baseURL := server.URL + "/v1"
+			if tt.providerType == "anthropic" {
+				baseURL = server.URL
+			}
+			provider, err := NewDefaultProviderFactory().CreateProvider(&ProviderConfig{
+				BaseURL:          baseURL,
+				APIKey:           "test-key",
+				Timeout:          5 * time.Second,
+				ProviderType:     tt.providerType,
+				HealthCheckModel: tt.model,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create provider: %v", err)
+			}
+			if err := provider.HealthCheck(context.Background()); err != nil {
+				t.Fatalf("HealthCheck failed: %v", err)
+			}
+			if len(requests) != 1 || requests[0] != tt.wantRequest {
+				t.Fatalf("Expected a single %q request, got %v", tt.wantRequest, requests)
+			}
+			if tt.model != "" {
+				if bodies[0]["model"] != tt.model || bodies[0]["max_tokens"] != float64(1) {
+					t.Errorf("Expected a minimal request for %s, got %v", tt.model, bodies[0])
+				}
+			}
+		})
+	}
+}
+
+func TestOpenRouterParamsOnlyForOpenRouter(t *testing.T) {
+	req := &ChatCompletionRequest{Model: "openai/gpt-4o"}
+	req.ApplyRequestParams(map[string]interface{}{
+		"provider":   map[interface{}]interface{}{"order": []interface{}{"OpenAI"}, "allow_fallbacks": false},
+		"transforms": []interface{}{"middle-out"},
+	})
+	if req.Provider["allow_fallbacks"] != false || len(req.Transforms) != 1 || req.Transforms[0] != "middle-out" {
+		t.Fatalf("Expected OpenRouter params from request_params, got provider=%v transforms=%v", req.Provider, req.Transforms)
+	}
+
+	if normalized, dropped := DropUnsupportedParams("openrouter", req); normalized != req || dropped != nil {
+		t.Errorf("Expected OpenRouter request to keep routing params, dropped %v", dropped)
+	}
+	for _, providerType := range []string{"openai", "azure_openai", "anthropic", "gemini", "unknown"} {
+		normalized, dropped := DropUnsupportedParams(providerType, req)
+		if strings.Join(dropped, ",") != "provider,transforms" || normalized.Provider != nil || normalized.Transforms != nil {
+			t.Errorf("Expected %s request to drop OpenRouter params, got %v %+v", providerType, dropped, normalized)
+		}
+	}
+	if req.Provider == nil || req.Transforms == nil {
+		t.Error("Expected the original request to be left unchanged")
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	if !strings.Contains(string(body), `"provider":{"allow_fallbacks":false,"order":["OpenAI"]}`) {
+		t.Errorf("Expected YAML provider map to be JSON encodable, got %s", body)
+	}
+}
@@ -0,0 +1,101 @@
+package providers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QuotaUsage 从上游响应头解析出的额度使用情况，取各维度中使用比例最高的一项
+type QuotaUsage struct {
+	Dimension  string        // 额度维度：requests、tokens 等
+	Limit      int64         // 额度上限
+	Remaining  int64         // 剩余额度
+	ResetAfter time.Duration // 额度重置前的等待时间，未知时为0
+}
+
+// Percent 已使用的额度百分比
+func (u QuotaUsage) Percent() float64 {
+	if u.Limit <= 0 {
+		return 0
+	}
+	used := float64(u.Limit-u.Remaining) / float64(u.Limit) * 100
+	if used < 0 {
+		return 0
+	}
+	return used
+}
+
+// quotaHeaderSet 一组额度响应头
+type quotaHeaderSet struct {
+	dimension string
+	limit     string
+	remaining string
+	reset     string
+}
+
+// quotaHeaderSets 支持的额度响应头：OpenAI、Anthropic 和 OpenRouter
+var quotaHeaderSets = []quotaHeaderSet{
+	{"requests", "X-Ratelimit-Limit-Requests", "X-Ratelimit-Remaining-Requests", "X-Ratelimit-Reset-Requests"},
+	{"tokens", "X-Ratelimit-Limit-Tokens", "X-Ratelimit-Remaining-Tokens", "X-Ratelimit-Reset-Tokens"},
+	{"requests", "Anthropic-Ratelimit-Requests-Limit", "Anthropic-Ratelimit-Requests-Remaining", "Anthropic-Ratelimit-Requests-Reset"},
+	{"tokens", "Anthropic-Ratelimit-Tokens-Limit", "Anthropic-Ratelimit-Tokens-Remaining", "Anthropic-Ratelimit-Tokens-Reset"},
+	{"input_tokens", "Anthropic-Ratelimit-Input-Tokens-Limit", "Anthropic-Ratelimit-Input-Tokens-Remaining", "Anthropic-Ratelimit-Input-Tokens-Reset"},
+	{"output_tokens", "Anthropic-Ratelimit-Output-Tokens-Limit", "Anthropic-Ratelimit-Output-Tokens-Remaining", "Anthropic-Ratelimit-Output-Tokens-Reset"},
+	{"requests", "X-Ratelimit-Limit", "X-Ratelimit-Remaining", "X-Ratelimit-Reset"},
+}
+
+// ParseQuotaUsage 解析上游响应头中的额度信息，没有额度头部时返回false
+func ParseQuotaUsage(header http.Header, now time.Time) (QuotaUsage, bool) {
+	var (
+		best  QuotaUsage
+		found bool
+	)
+	for _, set := range quotaHeaderSets {
+		limit, err := strconv.ParseInt(strings.TrimSpace(header.Get(set.limit)), 10, 64)
+		if err != nil || limit <= 0 {
+			continue
+		}
+		remaining, err := strconv.ParseInt(strings.TrimSpace(header.Get(set.remaining)), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		usage := QuotaUsage{
+			Dimension:  set.dimension,
+			Limit:      limit,
+			Remaining:  remaining,
+			ResetAfter: parseQuotaReset(header.Get(set.reset), now),
+		}
+		if !found || usage.Percent() > best.Percent() {
+			best = usage
+			found = true
+		}
+	}
+	return best, found
+}
+
+// parseQuotaReset 解析额度重置时间，支持时长字符串（OpenAI）、RFC3339时间（Anthropic）和秒/毫秒时间戳（OpenRouter）
+func parseQuotaReset(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d
+	}
+	if at, err := time.Parse(time.RFC3339, value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	if reset, err := strconv.ParseInt(value, 10, 64); err == nil {
+		at := time.Unix(reset, 0)
+		if reset > 1e12 {
+			at = time.UnixMilli(reset)
+		}
+		if at.After(now) {
+			return at.Sub(now)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,64 @@
+package providers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryAfterFromError 从上游错误中提取建议的重试等待时间，没有时返回0
+func RetryAfterFromError(err error) time.Duration {
+	var toolErr *ToolCallError
+	if errors.As(err, &toolErr) {
+		return toolErr.RetryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter 解析上游限流响应头，返回建议的等待时间
+// 支持标准 Retry-After 以及 OpenRouter/OpenAI 的 X-RateLimit-* 头部
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if header == nil {
+		return 0
+	}
+
+	// 标准Retry-After：秒数或HTTP日期
+	if value := strings.TrimSpace(header.Get("Retry-After")); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		if at, err := http.ParseTime(value); err == nil && at.After(now) {
+			return at.Sub(now)
+		}
+	}
+
+	// OpenRouter：X-RateLimit-Reset 为毫秒时间戳，仅在剩余额度耗尽时生效
+	if remaining := header.Get("X-RateLimit-Remaining"); remaining == "" || remaining == "0" {
+		if value := strings.TrimSpace(header.Get("X-RateLimit-Reset")); value != "" {
+			if reset, err := strconv.ParseInt(value, 10, 64); err == nil {
+				var at time.Time
+				if reset > 1e12 {
+					at = time.UnixMilli(reset)
+				} else {
+					at = time.Unix(reset, 0)
+				}
+				if at.After(now) {
+					return at.Sub(now)
+				}
+			}
+		}
+	}
+
+	// OpenAI：x-ratelimit-reset-requests / x-ratelimit-reset-tokens 为时长字符串（如 "6m0s"）
+	var wait time.Duration
+	for _, name := range []string{"X-Ratelimit-Reset-Requests", "X-Ratelimit-Reset-Tokens"} {
+		if value := strings.TrimSpace(header.Get(name)); value != "" {
+			if d, err := time.ParseDuration(value); err == nil && d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
@@ -0,0 +1,133 @@
+package providers
+
+import (
+	"encoding/json"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// reasoningBudgets 推理强度对应的思考预算（token），取值在Gemini 2.5系列和Anthropic支持的范围内
+var reasoningBudgets = map[string]int{
+	"low":    1024,
+	"medium": 8192,
+	"high":   24576,
+}
+
+// reasoningBudget 请求的推理强度对应的思考预算，未指定或不支持的强度返回false
+func reasoningBudget(req *ChatCompletionRequest) (int, bool) {
+	budget, ok := reasoningBudgets[strings.ToLower(strings.TrimSpace(req.ReasoningEffort))]
+	return budget, ok
+}
+
+// includeReasoning 是否在响应中返回思考内容：请求的 turnsapi.include_reasoning 优先，未指定时使用分组设置
+func (bp *BaseProvider) includeReasoning(req *ChatCompletionRequest) bool {
+	if req.IncludeReasoning != nil {
+		return *req.IncludeReasoning
+	}
+	return bp.Config.IncludeReasoning
+}
+
+// geminiThinkingConfig Gemini的思考设置：按推理强度设置思考预算，未指定时使用默认的动态预算
+// includeThoughts 为true时返回思考内容的摘要
+func geminiThinkingConfig(req *ChatCompletionRequest, includeThoughts bool) *genai.ThinkingConfig {
+	config := &genai.ThinkingConfig{IncludeThoughts: includeThoughts}
+	if budget, ok := reasoningBudget(req); ok {
+		thinkingBudget := int32(budget)
+		config.ThinkingBudget = &thinkingBudget
+	}
+	return config
+}
+
+// geminiUsage 转换Gemini的用量，思考token计入 completion_tokens 并单独列出
+func geminiUsage(metadata *genai.GenerateContentResponseUsageMetadata) Usage {
+	usage := Usage{
+		PromptTokens:     int(metadata.PromptTokenCount),
+		CompletionTokens: int(metadata.CandidatesTokenCount + metadata.ThoughtsTokenCount),
+		TotalTokens:      int(metadata.TotalTokenCount),
+	}
+	usage.setReasoningTokens(int(metadata.ThoughtsTokenCount))
+	return usage
+}
+
+// setReasoningTokens 设置思考token数，为0时不输出明细
+func (u *Usage) setReasoningTokens(tokens int) {
+	if tokens > 0 {
+		u.CompletionTokensDetails = &CompletionTokensDetails{ReasoningTokens: tokens}
+	} else {
+		u.CompletionTokensDetails = nil
+	}
+}
+
+// reasoningTokens 思考token数
+func (u Usage) reasoningTokens() int {
+	if u.CompletionTokensDetails == nil {
+		return 0
+	}
+	return u.CompletionTokensDetails.ReasoningTokens
+}
+
+// AnthropicThinking Anthropic扩展思考参数
+type AnthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+// applyAnthropicThinking 按推理强度为Anthropic请求启用扩展思考
+// 思考预算计入 max_tokens，因此在请求的输出长度之上追加预算；扩展思考不支持修改 temperature、top_p 和强制工具调用，
+// 以强制工具调用实现的结构化输出不启用思考
+func applyAnthropicThinking(anthropicReq *AnthropicRequest, req *ChatCompletionRequest) {
+	budget, ok := reasoningBudget(req)
+	if !ok || anthropicReq.ToolChoice != nil {
+		return
+	}
+	anthropicReq.Thinking = &AnthropicThinking{Type: "enabled", BudgetTokens: budget}
+	anthropicReq.MaxTokens += budget
+	anthropicReq.Temperature = nil
+	anthropicReq.TopP = nil
+}
+
+// anthropicReasoningTokens Anthropic不单独返回思考token数，按思考内容估算，不超过输出token数
+func anthropicReasoningTokens(thinking string, outputTokens int) int {
+	if thinking == "" {
+		return 0
+	}
+	tokens := countTextTokens(thinking)
+	if outputTokens > 0 && tokens > outputTokens {
+		tokens = outputTokens
+	}
+	return tokens
+}
+
+// stripReasoningContent 去除响应中所有选择的思考内容
+func stripReasoningContent(response *ChatCompletionResponse) {
+	for i := range response.Choices {
+		response.Choices[i].Message.ReasoningContent = ""
+	}
+}
+
+// stripReasoningLine 去除OpenAI格式SSE数据行中的思考内容（reasoning_content，以及OpenRouter的 reasoning 和 reasoning_details）
+// 不含思考内容或无法解析的数据行原样返回
+func stripReasoningLine(line string) string {
+	data, ok := strings.CutPrefix(line, "data: ")
+	if !ok || !strings.Contains(data, `"reasoning`) {
+		return line
+	}
+	var chunk map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return line
+	}
+	choices, _ := chunk["choices"].([]interface{})
+	for _, choice := range choices {
+		choiceMap, _ := choice.(map[string]interface{})
+		delta, _ := choiceMap["delta"].(map[string]interface{})
+		for _, field := range []string{"reasoning_content", "reasoning", "reasoning_details"} {
+			delete(delta, field)
+		}
+	}
+	stripped, err := json.Marshal(chunk)
+	if err != nil {
+		return line
+	}
+	return "data: " + string(stripped)
+}
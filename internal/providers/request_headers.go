@@ -0,0 +1,56 @@
+package providers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// requestHeadersKey 上下文中单次请求额外发往上游的请求头的键
+type requestHeadersKey struct{}
+
+// reservedRequestHeaders 不能通过单次请求额外设置的请求头，由提供商按协议设置
+var reservedRequestHeaders = map[string]bool{
+	"authorization":  true,
+	"x-api-key":      true,
+	"x-goog-api-key": true,
+	"content-type":   true,
+	"content-length": true,
+	"host":           true,
+}
+
+// WithRequestHeaders 在上下文中设置本次上游请求额外发送的请求头（如钩子注入的租户信息），覆盖分组配置的同名请求头
+// 多次调用时合并，后设置的同名请求头优先；认证相关请求头和 Content-Type 不能覆盖，会被忽略
+func WithRequestHeaders(ctx context.Context, header http.Header) context.Context {
+	if len(header) == 0 {
+		return ctx
+	}
+	merged := header.Clone()
+	if existing, ok := ctx.Value(requestHeadersKey{}).(http.Header); ok {
+		for name, values := range existing {
+			if _, exists := merged[name]; !exists {
+				merged[name] = values
+			}
+		}
+	}
+	return context.WithValue(ctx, requestHeadersKey{}, merged)
+}
+
+// applyRequestHeaders 将上下文中的额外请求头写入上游请求
+func applyRequestHeaders(req *http.Request) {
+	header, ok := req.Context().Value(requestHeadersKey{}).(http.Header)
+	if !ok {
+		return
+	}
+	for name, values := range header {
+		if reservedRequestHeaders[strings.ToLower(name)] {
+			log.Printf("警告: 忽略不能额外设置的上游请求头 %s", name)
+			continue
+		}
+		req.Header.Del(name)
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// 结构化输出格式类型
+const (
+	ResponseFormatText       = "text"
+	ResponseFormatJSONObject = "json_object"
+	ResponseFormatJSONSchema = "json_schema"
+)
+
+// ResponseFormat OpenAI的 response_format 参数
+type ResponseFormat struct {
+	Type       string            `json:"type"` // text、json_object 或 json_schema
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// JSONSchemaFormat json_schema 格式的定义
+type JSONSchemaFormat struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Schema      map[string]interface{} `json:"schema,omitempty"`
+	Strict      *bool                  `json:"strict,omitempty"`
+}
+
+// WantsJSON 是否要求模型输出JSON
+func (f *ResponseFormat) WantsJSON() bool {
+	return f != nil && (f.Type == ResponseFormatJSONObject || f.Type == ResponseFormatJSONSchema)
+}
+
+// Schema 获取 json_schema 格式的JSON Schema，其他格式返回nil
+func (f *ResponseFormat) Schema() map[string]interface{} {
+	if f == nil || f.Type != ResponseFormatJSONSchema || f.JSONSchema == nil {
+		return nil
+	}
+	return f.JSONSchema.Schema
+}
+
+// JSONInstruction 不支持原生JSON模式时注入的提示词，要求模型只输出JSON
+func (f *ResponseFormat) JSONInstruction() string {
+	instruction := "Respond only with valid JSON. Do not wrap the JSON in markdown code fences and do not add any text before or after it."
+	if schema := f.Schema(); schema != nil {
+		if data, err := json.Marshal(schema); err == nil {
+			instruction += "\nThe JSON must conform to this JSON Schema:\n" + string(data)
+		}
+	} else {
+		instruction += " The top-level value must be a JSON object."
+	}
+	return instruction
+}
+
+// ExtractJSON 从模型输出中提取JSON文本，去除Markdown代码块和JSON前后的说明文字
+func ExtractJSON(content string) string {
+	content = strings.TrimSpace(content)
+
+	// 去除 ```json ... ``` 代码块
+	if start := strings.Index(content, "```"); start >= 0 {
+		body := content[start+3:]
+		if newline := strings.Index(body, "\n"); newline >= 0 {
+			body = body[newline+1:]
+		}
+		if end := strings.Index(body, "```"); end >= 0 {
+			body = body[:end]
+		}
+		return strings.TrimSpace(body)
+	}
+
+	// 截取第一个 { 或 [ 到最后一个对应的 } 或 ]
+	start := strings.IndexAny(content, "{[")
+	if start < 0 {
+		return content
+	}
+	closing := "}"
+	if content[start] == '[' {
+		closing = "]"
+	}
+	if end := strings.LastIndex(content, closing); end > start {
+		return content[start : end+1]
+	}
+	return content
+}
+
+// ValidateJSONOutput 校验模型输出是否为符合 response_format 要求的JSON
+// 输出被Markdown代码块或说明文字包裹时返回提取出的JSON；json_schema 格式按Schema中常用的约束校验
+func ValidateJSONOutput(content string, format *ResponseFormat) (string, error) {
+	candidate := strings.TrimSpace(content)
+	var value interface{}
+	if err := json.Unmarshal([]byte(candidate), &value); err != nil {
+		candidate = ExtractJSON(content)
+		if extractErr := json.Unmarshal([]byte(candidate), &value); extractErr != nil {
+			return content, fmt.Errorf("response is not valid JSON: %w", err)
+		}
+	}
+
+	if schema := format.Schema(); schema != nil {
+		if err := validateJSONSchema(value, schema, "$"); err != nil {
+			return candidate, err
+		}
+	} else if _, ok := value.(map[string]interface{}); !ok {
+		return candidate, fmt.Errorf("response must be a JSON object")
+	}
+	return candidate, nil
+}
+
+// validateJSONSchema 按 type、enum、required、properties、additionalProperties 和 items 约束校验JSON值
+func validateJSONSchema(value interface{}, schema map[string]interface{}, path string) error {
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if jsonValueHasType(value, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s", path, strings.Join(types, " or "))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok && len(enum) > 0 {
+		found := false
+		for _, allowed := range enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of the allowed enum values", path)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if key, ok := name.(string); ok {
+					if _, exists := v[key]; !exists {
+						return fmt.Errorf("%s: missing required property %q", path, key)
+					}
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for key, child := range v {
+			propertySchema, defined := properties[key].(map[string]interface{})
+			if !defined {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					return fmt.Errorf("%s: unexpected property %q", path, key)
+				}
+				continue
+			}
+			if err := validateJSONSchema(child, propertySchema, path+"."+key); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, child := range v {
+				if err := validateJSONSchema(child, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// schemaTypes 读取Schema的 type，支持字符串或字符串数组
+func schemaTypes(raw interface{}) []string {
+	switch t := raw.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// jsonValueHasType 检查解析后的JSON值是否属于Schema类型
+func jsonValueHasType(value interface{}, schemaType string) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
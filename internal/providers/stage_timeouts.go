@@ -0,0 +1,114 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// 分阶段超时错误，可通过 errors.Is 判断
+var (
+	ErrConnectTimeout   = errors.New("upstream connect timeout")
+	ErrFirstByteTimeout = errors.New("upstream first byte timeout")
+)
+
+// IsStageTimeout 判断错误是否为连接或首字节超时，此时上游尚未开始处理请求，可立即换用其他分组
+func IsStageTimeout(err error) bool {
+	return errors.Is(err, ErrConnectTimeout) || errors.Is(err, ErrFirstByteTimeout)
+}
+
+// stageTimeoutsKey 上下文中分阶段超时的键
+type stageTimeoutsKey struct{}
+
+// stageTimeouts 单次请求的连接和首字节超时
+type stageTimeouts struct {
+	connect   time.Duration
+	firstByte time.Duration
+}
+
+// WithStageTimeouts 在上下文中设置本次上游请求的连接超时和首字节超时，为0的阶段不限制
+// 总超时仍由上下文的截止时间控制
+func WithStageTimeouts(ctx context.Context, connect, firstByte time.Duration) context.Context {
+	if connect <= 0 && firstByte <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, stageTimeoutsKey{}, stageTimeouts{connect: connect, firstByte: firstByte})
+}
+
+// stageTimeoutTransport 按上下文中的分阶段超时限制建立连接和等待响应头的时间
+type stageTimeoutTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *stageTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timeouts, ok := req.Context().Value(stageTimeoutsKey{}).(stageTimeouts)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+	var connectTimer, firstByteTimer *time.Timer
+	if timeouts.connect > 0 {
+		connectTimer = time.AfterFunc(timeouts.connect, func() {
+			cancel(fmt.Errorf("%w after %v", ErrConnectTimeout, timeouts.connect))
+		})
+	}
+	if timeouts.firstByte > 0 {
+		firstByteTimer = time.AfterFunc(timeouts.firstByte, func() {
+			cancel(fmt.Errorf("%w after %v", ErrFirstByteTimeout, timeouts.firstByte))
+		})
+	}
+	stop := func(timer *time.Timer) {
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn:              func(httptrace.GotConnInfo) { stop(connectTimer) },
+		GotFirstResponseByte: func() { stop(firstByteTimer) },
+	})
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	stop(connectTimer)
+	stop(firstByteTimer)
+
+	cause := context.Cause(ctx)
+	if !IsStageTimeout(cause) {
+		cause = nil
+	}
+	if err != nil {
+		cancel(nil)
+		if cause != nil {
+			return nil, fmt.Errorf("%w: %v", cause, err)
+		}
+		return nil, err
+	}
+	if cause != nil {
+		// 计时器在响应头到达的同时触发，响应体已无法读取
+		resp.Body.Close()
+		cancel(nil)
+		return nil, cause
+	}
+
+	// 响应体读取完毕或关闭时释放上下文，总超时由上层上下文控制
+	resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: func() { cancel(nil) }}
+	return resp, nil
+}
+
+// cancelOnCloseBody 关闭响应体时取消请求上下文
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+// Close 关闭响应体并取消请求上下文
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
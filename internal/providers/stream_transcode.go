@@ -0,0 +1,389 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// StreamOptions 流式请求选项
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"` // 结束前额外发送一个 choices 为空、带有 usage 的数据块
+}
+
+// ChatCompletionChunk OpenAI格式的流式响应块
+type ChatCompletionChunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+	Usage   *Usage        `json:"usage,omitempty"`
+}
+
+// ChunkChoice 流式响应块中的一个选择
+type ChunkChoice struct {
+	Index        int        `json:"index"`
+	Delta        ChunkDelta `json:"delta"`
+	FinishReason *string    `json:"finish_reason"`
+}
+
+// ChunkDelta 流式响应块的增量内容
+type ChunkDelta struct {
+	Role             string          `json:"role,omitempty"`
+	Content          *string         `json:"content,omitempty"`
+	ReasoningContent *string         `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+// ToolCallDelta 工具调用增量，同一工具调用的 id、type 和名称只在第一个增量中出现，参数分多次追加
+type ToolCallDelta struct {
+	Index    int                `json:"index"`
+	ID       string             `json:"id,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Function *FunctionCallDelta `json:"function,omitempty"`
+}
+
+// FunctionCallDelta 函数调用增量
+type FunctionCallDelta struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// chunkTranscoder 把原生流式事件转换为OpenAI格式的流式响应块
+// 每个选择的第一个数据块带有 role，工具调用按出现顺序在选择内编号，结束原因按选择分别记录
+type chunkTranscoder struct {
+	id           string
+	created      int64
+	model        string
+	includeUsage bool
+	choices      int                   // 请求的选择数，没有输出内容的选择也会发送结束原因
+	estimator    *streamUsageEstimator // 上游没有报告用量时估算用量，未请求 include_usage 时为空
+
+	started   map[int]bool   // 已发送过数据块的选择
+	toolCalls map[int]int    // 选择 -> 已开始的工具调用数
+	finish    map[int]string // 选择 -> 结束原因
+	usage     *Usage
+}
+
+// newChunkTranscoder 创建流式响应转换器
+func newChunkTranscoder(req *ChatCompletionRequest) *chunkTranscoder {
+	t := &chunkTranscoder{
+		id:           fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
+		created:      time.Now().Unix(),
+		model:        req.Model,
+		includeUsage: includeUsage(req),
+		choices:      choiceCount(req),
+		started:      make(map[int]bool),
+		toolCalls:    make(map[int]int),
+		finish:       make(map[int]string),
+	}
+	if t.includeUsage {
+		t.estimator = newStreamUsageEstimator(req)
+	}
+	return t
+}
+
+// addOutput 记录输出的内容，用于估算用量
+func (t *chunkTranscoder) addOutput(text string) {
+	if t.estimator != nil {
+		t.estimator.add(text)
+	}
+}
+
+// chunk 编码一个选择的增量，选择的第一个数据块带有 role
+func (t *chunkTranscoder) chunk(index int, delta ChunkDelta, finishReason *string) []byte {
+	if !t.started[index] {
+		t.started[index] = true
+		delta.Role = "assistant"
+	}
+	return t.encode(ChatCompletionChunk{
+		ID:      t.id,
+		Object:  "chat.completion.chunk",
+		Created: t.created,
+		Model:   t.model,
+		Choices: []ChunkChoice{{Index: index, Delta: delta, FinishReason: finishReason}},
+	})
+}
+
+// encode 编码为SSE数据行
+func (t *chunkTranscoder) encode(chunk ChatCompletionChunk) []byte {
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return nil
+	}
+	return []byte("data: " + string(data) + "\n\n")
+}
+
+// content 文本增量
+func (t *chunkTranscoder) content(index int, text string) []byte {
+	t.addOutput(text)
+	return t.chunk(index, ChunkDelta{Content: &text}, nil)
+}
+
+// reasoning 思考内容增量
+func (t *chunkTranscoder) reasoning(index int, text string) []byte {
+	t.addOutput(text)
+	return t.chunk(index, ChunkDelta{ReasoningContent: &text}, nil)
+}
+
+// startToolCall 开始一个工具调用，返回工具调用在选择内的编号和数据块
+func (t *chunkTranscoder) startToolCall(index int, id, name, arguments string) (int, []byte) {
+	toolIndex := t.toolCalls[index]
+	t.toolCalls[index]++
+	t.addOutput(name + arguments)
+	if id == "" {
+		id = fmt.Sprintf("call_%d_%d_%d", time.Now().UnixNano(), index, toolIndex)
+	}
+	return toolIndex, t.chunk(index, ChunkDelta{ToolCalls: []ToolCallDelta{{
+		Index:    toolIndex,
+		ID:       id,
+		Type:     "function",
+		Function: &FunctionCallDelta{Name: name, Arguments: arguments},
+	}}}, nil)
+}
+
+// toolCallArguments 工具调用参数增量
+func (t *chunkTranscoder) toolCallArguments(index, toolIndex int, arguments string) []byte {
+	t.addOutput(arguments)
+	return t.chunk(index, ChunkDelta{ToolCalls: []ToolCallDelta{{
+		Index:    toolIndex,
+		Function: &FunctionCallDelta{Arguments: arguments},
+	}}}, nil)
+}
+
+// setFinishReason 记录选择的结束原因，发出过工具调用的选择以 tool_calls 结束
+func (t *chunkTranscoder) setFinishReason(index int, reason string) {
+	if reason == "stop" && t.toolCalls[index] > 0 {
+		reason = "tool_calls"
+	}
+	t.finish[index] = reason
+}
+
+// setUsage 记录用量，上游分多次报告时以最后一次为准
+func (t *chunkTranscoder) setUsage(usage Usage) {
+	t.usage = &usage
+}
+
+// end 按选择编号顺序发送每个选择的结束原因，请求了 include_usage 时再发送用量（上游没有报告时为估算值），最后发送 [DONE]
+func (t *chunkTranscoder) end() [][]byte {
+	indexes := make([]int, 0, t.choices)
+	for index := 0; index < t.choices; index++ {
+		indexes = append(indexes, index)
+	}
+	for index := range t.started {
+		if index >= t.choices {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+
+	var chunks [][]byte
+	for _, index := range indexes {
+		reason, ok := t.finish[index]
+		if !ok {
+			reason = "stop"
+			if t.toolCalls[index] > 0 {
+				reason = "tool_calls"
+			}
+		}
+		chunks = append(chunks, t.chunk(index, ChunkDelta{}, &reason))
+	}
+	if t.includeUsage {
+		// 上游没有报告用量时（如流被提前结束）按请求和已输出的内容估算
+		usage := t.estimator.usage()
+		if t.usage != nil {
+			usage = *t.usage
+		}
+		chunks = append(chunks, t.encode(ChatCompletionChunk{
+			ID:      t.id,
+			Object:  "chat.completion.chunk",
+			Created: t.created,
+			Model:   t.model,
+			Choices: []ChunkChoice{},
+			Usage:   &usage,
+		}))
+	}
+	return append(chunks, []byte("data: [DONE]\n\n"))
+}
+
+// anthropicFinishReason 转换Anthropic的 stop_reason
+func anthropicFinishReason(reason string) string {
+	switch reason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
+
+// geminiFinishReason 转换Gemini的 finishReason
+func geminiFinishReason(reason genai.FinishReason) string {
+	switch reason {
+	case genai.FinishReasonMaxTokens:
+		return "length"
+	case genai.FinishReasonSafety, genai.FinishReasonRecitation, genai.FinishReasonBlocklist,
+		genai.FinishReasonProhibitedContent, genai.FinishReasonSPII, genai.FinishReasonImageSafety:
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
+
+// anthropicStreamEvent Anthropic流式事件
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message *struct {
+		Usage AnthropicUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock *struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+	Delta *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *AnthropicUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicStreamState 一个Anthropic流式请求的转换状态，请求的所有内容都属于同一个选择
+type anthropicStreamState struct {
+	transcoder       *chunkTranscoder
+	choice           int
+	structuredTool   string          // 实现结构化输出的工具，其参数作为文本内容输出
+	includeReasoning bool            // 是否输出思考内容
+	thinking         strings.Builder // 思考内容，用于估算思考token数
+	blocks           map[int]int     // 工具调用内容块 -> 工具调用编号
+	usage            AnthropicUsage
+	stopped          bool // 已收到 message_stop 或上游连接已结束
+}
+
+// newAnthropicStreamState 创建Anthropic流式请求的转换状态，多个选择共用同一个转换器
+func newAnthropicStreamState(transcoder *chunkTranscoder, choice int, structuredTool string, includeReasoning bool) *anthropicStreamState {
+	return &anthropicStreamState{
+		transcoder:       transcoder,
+		choice:           choice,
+		structuredTool:   structuredTool,
+		includeReasoning: includeReasoning,
+		blocks:           make(map[int]int),
+	}
+}
+
+// handle 转换一个事件，返回要发送的数据块；收到 message_stop 后 stopped 为true
+func (s *anthropicStreamState) handle(event *anthropicStreamEvent) ([][]byte, error) {
+	t := s.transcoder
+	var chunks [][]byte
+	switch event.Type {
+	case "message_start":
+		if event.Message != nil {
+			s.usage = event.Message.Usage
+		}
+	case "content_block_start":
+		if event.ContentBlock == nil {
+			break
+		}
+		if event.ContentBlock.Type == "tool_use" && event.ContentBlock.Name != s.structuredTool {
+			toolIndex, chunk := t.startToolCall(s.choice, event.ContentBlock.ID, event.ContentBlock.Name, "")
+			s.blocks[event.Index] = toolIndex
+			chunks = append(chunks, chunk)
+		}
+	case "content_block_delta":
+		if event.Delta == nil {
+			break
+		}
+		switch event.Delta.Type {
+		case "text_delta":
+			chunks = append(chunks, t.content(s.choice, event.Delta.Text))
+		case "thinking_delta":
+			s.thinking.WriteString(event.Delta.Thinking)
+			if s.includeReasoning {
+				chunks = append(chunks, t.reasoning(s.choice, event.Delta.Thinking))
+			}
+		case "input_json_delta":
+			if toolIndex, ok := s.blocks[event.Index]; ok {
+				chunks = append(chunks, t.toolCallArguments(s.choice, toolIndex, event.Delta.PartialJSON))
+			} else if event.Delta.PartialJSON != "" {
+				chunks = append(chunks, t.content(s.choice, event.Delta.PartialJSON))
+			}
+		}
+	case "message_delta":
+		if event.Delta != nil && event.Delta.StopReason != "" {
+			reason := anthropicFinishReason(event.Delta.StopReason)
+			if reason == "tool_calls" && len(s.blocks) == 0 {
+				// 结构化输出的工具调用按正常结束处理
+				reason = "stop"
+			}
+			t.setFinishReason(s.choice, reason)
+		}
+		if event.Usage != nil {
+			if event.Usage.InputTokens > 0 {
+				s.usage.InputTokens = event.Usage.InputTokens
+			}
+			s.usage.OutputTokens = event.Usage.OutputTokens
+		}
+	case "message_stop":
+		s.stopped = true
+	case "error":
+		message := "unknown error"
+		if event.Error != nil {
+			message = event.Error.Type + ": " + event.Error.Message
+		}
+		return nil, fmt.Errorf("anthropic stream error: %s", message)
+	}
+	return chunks, nil
+}
+
+// reasoningTokens 按思考内容估算的思考token数
+func (s *anthropicStreamState) reasoningTokens() int {
+	return anthropicReasoningTokens(s.thinking.String(), s.usage.OutputTokens)
+}
+
+// geminiStreamChunks 转换Gemini的一个流式响应块，每个候选对应一个选择，思考内容作为 reasoning_content 输出
+func geminiStreamChunks(t *chunkTranscoder, chunk *genai.GenerateContentResponse) [][]byte {
+	var chunks [][]byte
+	for _, candidate := range chunk.Candidates {
+		index := int(candidate.Index)
+		if candidate.Content != nil {
+			for _, part := range candidate.Content.Parts {
+				if part.Text != "" && part.Thought {
+					chunks = append(chunks, t.reasoning(index, part.Text))
+				} else if part.Text != "" {
+					chunks = append(chunks, t.content(index, part.Text))
+				}
+				if part.FunctionCall != nil {
+					args, err := json.Marshal(part.FunctionCall.Args)
+					if err != nil || part.FunctionCall.Args == nil {
+						args = []byte("{}")
+					}
+					_, data := t.startToolCall(index, part.FunctionCall.ID, part.FunctionCall.Name, string(args))
+					chunks = append(chunks, data)
+				}
+			}
+		}
+		if candidate.FinishReason != "" && candidate.FinishReason != genai.FinishReasonUnspecified {
+			t.setFinishReason(index, geminiFinishReason(candidate.FinishReason))
+		}
+	}
+	if chunk.UsageMetadata != nil {
+		t.setUsage(geminiUsage(chunk.UsageMetadata))
+	}
+	return chunks
+}
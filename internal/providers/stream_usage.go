@@ -0,0 +1,126 @@
+package providers
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// includeUsage 流式请求是否请求了用量数据块（stream_options.include_usage）
+func includeUsage(req *ChatCompletionRequest) bool {
+	return req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+}
+
+// streamUsageEstimator 上游没有报告用量时，按请求和已输出的内容估算流式响应的用量
+type streamUsageEstimator struct {
+	promptTokens int
+	output       strings.Builder
+}
+
+// newStreamUsageEstimator 创建用量估算器，提示词token数在创建时估算
+func newStreamUsageEstimator(req *ChatCompletionRequest) *streamUsageEstimator {
+	return &streamUsageEstimator{promptTokens: EstimatePromptTokens(req)}
+}
+
+// add 记录输出的内容（文本、思考内容和工具调用）
+func (e *streamUsageEstimator) add(text string) {
+	e.output.WriteString(text)
+}
+
+// usage 估算的用量
+func (e *streamUsageEstimator) usage() Usage {
+	completionTokens := countTextTokens(e.output.String())
+	return Usage{
+		PromptTokens:     e.promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      e.promptTokens + completionTokens,
+	}
+}
+
+// openAIStreamUsage 跟踪OpenAI格式流式响应的用量
+// 部分OpenAI兼容后端忽略 stream_options，上游没有报告用量时在 [DONE] 之前补发估算的用量数据块
+type openAIStreamUsage struct {
+	estimator *streamUsageEstimator
+	reported  bool
+
+	id      string
+	created int64
+	model   string
+}
+
+// newOpenAIStreamUsage 请求了 include_usage 时创建用量跟踪，否则返回nil
+func newOpenAIStreamUsage(req *ChatCompletionRequest) *openAIStreamUsage {
+	if !includeUsage(req) {
+		return nil
+	}
+	return &openAIStreamUsage{estimator: newStreamUsageEstimator(req), model: req.Model}
+}
+
+// observe 解析SSE数据行，记录上游报告的用量和输出的内容
+func (u *openAIStreamUsage) observe(line string) {
+	if u == nil || u.reported {
+		return
+	}
+	data, ok := strings.CutPrefix(line, "data: ")
+	if !ok || data == "[DONE]" {
+		return
+	}
+	var chunk struct {
+		ID      string `json:"id"`
+		Created int64  `json:"created"`
+		Model   string `json:"model"`
+		Choices []struct {
+			Delta struct {
+				Content          string `json:"content"`
+				ReasoningContent string `json:"reasoning_content"`
+				ToolCalls        []struct {
+					Function struct {
+						Name      string `json:"name"`
+						Arguments string `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"delta"`
+		} `json:"choices"`
+		Usage *Usage `json:"usage"`
+	}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return
+	}
+	if chunk.Usage != nil {
+		u.reported = true
+		return
+	}
+	if chunk.ID != "" {
+		u.id, u.created = chunk.ID, chunk.Created
+	}
+	if chunk.Model != "" {
+		u.model = chunk.Model
+	}
+	for _, choice := range chunk.Choices {
+		u.estimator.add(choice.Delta.Content)
+		u.estimator.add(choice.Delta.ReasoningContent)
+		for _, toolCall := range choice.Delta.ToolCalls {
+			u.estimator.add(toolCall.Function.Name)
+			u.estimator.add(toolCall.Function.Arguments)
+		}
+	}
+}
+
+// chunk 上游没有报告用量时返回估算的用量数据块，否则返回nil
+func (u *openAIStreamUsage) chunk() []byte {
+	if u == nil || u.reported {
+		return nil
+	}
+	usage := u.estimator.usage()
+	data, err := json.Marshal(ChatCompletionChunk{
+		ID:      u.id,
+		Object:  "chat.completion.chunk",
+		Created: u.created,
+		Model:   u.model,
+		Choices: []ChunkChoice{},
+		Usage:   &usage,
+	})
+	if err != nil {
+		return nil
+	}
+	return []byte("data: " + string(data) + "\n\n")
+}
@@ -0,0 +1,76 @@
+package providers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSOptions 发往上游的TLS设置，用于私有CA签发证书或要求客户端证书（mTLS）的自建后端
+type TLSOptions struct {
+	CAFile             string // 额外信任的CA证书（PEM），与系统根证书一起使用
+	CertFile           string // 客户端证书（PEM）
+	KeyFile            string // 客户端证书私钥（PEM）
+	InsecureSkipVerify bool   // 不校验上游证书，仅用于测试环境
+}
+
+// IsZero 是否未设置任何TLS选项
+func (o *TLSOptions) IsZero() bool {
+	return o == nil || *o == TLSOptions{}
+}
+
+// LoadTLSConfig 读取证书文件并生成 tls.Config，未设置任何选项时返回 nil
+func LoadTLSConfig(opts *TLSOptions) (*tls.Config, error) {
+	if opts.IsZero() {
+		return nil, nil
+	}
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, fmt.Errorf("tls.cert_file and tls.key_file must be set together")
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+	if opts.CAFile != "" {
+		data, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls.ca_file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates found in tls.ca_file %s", opts.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls.cert_file/tls.key_file: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// tlsFilesStamp 证书文件的修改时间，文件被替换（如证书轮换）后据此重新加载
+func tlsFilesStamp(opts *TLSOptions) string {
+	if opts.IsZero() {
+		return ""
+	}
+	var stamp strings.Builder
+	for _, path := range []string{opts.CAFile, opts.CertFile, opts.KeyFile} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&stamp, "%d/%d;", info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return stamp.String()
+}
@@ -0,0 +1,40 @@
+package providers
+
+import (
+	"net/http"
+
+	"turnsapi/internal/tracing"
+)
+
+// tracingTransport 为每个上游HTTP请求创建客户端span，并通过 traceparent 请求头向上游传递追踪上下文
+// span只记录主机和路径，不记录查询参数（Gemini 的密钥在查询参数中）
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tracing.Start(req.Context(), "HTTP "+req.Method, tracing.KindClient)
+	if span == nil {
+		return t.base.RoundTrip(req)
+	}
+	defer span.End()
+
+	// RoundTripper 不能修改调用方的请求
+	req = req.Clone(ctx)
+	tracing.Inject(ctx, req.Header)
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Host)
+	span.SetAttribute("url.path", req.URL.Path)
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		span.SetError(resp.Status)
+	}
+	return resp, nil
+}
@@ -0,0 +1,298 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// ErrorCategory 上游错误的归一化分类
+type ErrorCategory string
+
+const (
+	ErrorCategoryAuth           ErrorCategory = "auth"            // 密钥无效、未授权或无权限
+	ErrorCategoryQuota          ErrorCategory = "quota"           // 额度或余额耗尽
+	ErrorCategoryRateLimit      ErrorCategory = "rate_limit"      // 请求频率超限
+	ErrorCategoryContentFilter  ErrorCategory = "content_filter"  // 内容被安全策略拦截
+	ErrorCategoryContextLength  ErrorCategory = "context_length"  // 上下文长度超限
+	ErrorCategoryInvalidRequest ErrorCategory = "invalid_request" // 其他请求参数错误
+	ErrorCategoryServer         ErrorCategory = "server"          // 上游服务端错误或过载
+	ErrorCategoryUnknown        ErrorCategory = "unknown"         // 无法识别（如网络错误）
+)
+
+// KeySpecific 判断错误是否由当前密钥引起，换用其他密钥可能成功
+func (c ErrorCategory) KeySpecific() bool {
+	switch c {
+	case ErrorCategoryAuth, ErrorCategoryQuota, ErrorCategoryRateLimit:
+		return true
+	}
+	return false
+}
+
+// RequestSpecific 判断错误是否由请求本身引起，任何密钥或分组重试都不会成功
+func (c ErrorCategory) RequestSpecific() bool {
+	switch c {
+	case ErrorCategoryContentFilter, ErrorCategoryContextLength, ErrorCategoryInvalidRequest:
+		return true
+	}
+	return false
+}
+
+// 各分类错误信息中的特征文本（小写）
+var (
+	contextLengthMarkers = []string{
+		"context_length_exceeded",
+		"context length",
+		"context window",
+		"prompt is too long",
+		"too many tokens",
+		"maximum number of tokens",
+	}
+	contentFilterMarkers = []string{
+		"content_filter",
+		"content_policy_violation",
+		"content management policy",
+		"responsible ai",
+		"safety settings",
+		"blocked due to safety",
+		"flagged by moderation",
+	}
+	quotaMarkers = []string{
+		"insufficient_quota",
+		"exceeded your current quota",
+		"quota exceeded",
+		"billing",
+		"insufficient credits",
+		"credit balance is too low",
+	}
+	authMarkers = []string{
+		"invalid_api_key",
+		"invalid api key",
+		"incorrect api key",
+		"api key not valid",
+		"api key not found",
+		"unauthorized",
+		"authentication",
+		"permission denied",
+		"account deactivated",
+	}
+	rateLimitMarkers = []string{
+		"rate limit",
+		"rate_limit",
+		"too many requests",
+	}
+	serverMarkers = []string{
+		"overloaded",
+		"server error",
+		"server_error",
+		"service unavailable",
+	}
+)
+
+// upstreamErrorBody 兼容 OpenAI、Anthropic、Gemini 三种错误响应格式
+//
+//	OpenAI:    {"error": {"message": "...", "type": "...", "code": "..."}}
+//	Anthropic: {"type": "error", "error": {"type": "...", "message": "..."}}
+//	Gemini:    {"error": {"code": 400, "message": "...", "status": "INVALID_ARGUMENT"}}
+type upstreamErrorBody struct {
+	Error json.RawMessage `json:"error"`
+}
+
+type upstreamErrorDetail struct {
+	Message string          `json:"message"`
+	Type    string          `json:"type"`
+	Code    json.RawMessage `json:"code"`
+	Status  string          `json:"status"`
+}
+
+// ParseUpstreamError 将上游非2xx响应解析为带分类的ToolCallError
+func ParseUpstreamError(statusCode int, header http.Header, body []byte) *ToolCallError {
+	toolErr := &ToolCallError{
+		Type:       "api_error",
+		Message:    fmt.Sprintf("API request failed with status %d: %s", statusCode, string(body)),
+		StatusCode: statusCode,
+	}
+
+	var detail upstreamErrorDetail
+	var envelope upstreamErrorBody
+	if err := json.Unmarshal(body, &envelope); err == nil && len(envelope.Error) > 0 {
+		if json.Unmarshal(envelope.Error, &detail) != nil {
+			// 部分兼容接口直接返回 {"error": "..."}
+			var message string
+			if json.Unmarshal(envelope.Error, &message) == nil {
+				detail.Message = message
+			}
+		}
+	}
+
+	code := rawCodeString(detail.Code)
+	if detail.Message != "" {
+		toolErr.Message = detail.Message
+	}
+	if detail.Type != "" {
+		toolErr.Type = detail.Type
+	} else if detail.Status != "" {
+		toolErr.Type = strings.ToLower(detail.Status)
+	}
+	toolErr.Code = code
+
+	toolErr.Category = classify(statusCode, strings.Join([]string{detail.Type, code, detail.Status}, " "), toolErr.Message)
+	if toolErr.Category == ErrorCategoryRateLimit || toolErr.Category == ErrorCategoryQuota {
+		toolErr.RetryAfter = parseRetryAfter(header, time.Now())
+	}
+	return toolErr
+}
+
+// rawCodeString 错误码可能是字符串、数字或null
+func rawCodeString(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var code string
+	if json.Unmarshal(raw, &code) == nil {
+		return code
+	}
+	var number json.Number
+	if json.Unmarshal(raw, &number) == nil {
+		return number.String()
+	}
+	return ""
+}
+
+// ClassifyError 将上游错误归类，供重试、密钥隔离和客户端错误响应使用
+func ClassifyError(err error) ErrorCategory {
+	if err == nil {
+		return ErrorCategoryUnknown
+	}
+
+	var toolErr *ToolCallError
+	if errors.As(err, &toolErr) {
+		if toolErr.Category != "" {
+			return toolErr.Category
+		}
+		return classify(toolErr.StatusCode, toolErr.Type+" "+toolErr.Code, toolErr.Message)
+	}
+
+	var geminiErr genai.APIError
+	if errors.As(err, &geminiErr) {
+		return classify(geminiErr.Code, geminiErr.Status, geminiErr.Message)
+	}
+
+	return classify(StatusCodeFromError(err), "", err.Error())
+}
+
+// ClassifyStatus 根据状态码和错误信息分类，用于只保存了文本的错误（如请求日志）
+func ClassifyStatus(statusCode int, message string) ErrorCategory {
+	return classify(statusCode, "", message)
+}
+
+// classify 根据状态码、错误类型/错误码和错误信息进行分类
+// 请求本身的问题优先于状态码判断，因为不同提供商对同一类错误使用的状态码并不一致
+// 401/403/429 之外的4xx视为请求本身的问题，408 是上游处理超时，与请求内容无关，按服务端错误处理
+func classify(statusCode int, kind, message string) ErrorCategory {
+	text := strings.ToLower(kind + " " + message)
+
+	switch {
+	case containsAny(text, contextLengthMarkers):
+		return ErrorCategoryContextLength
+	case containsAny(text, contentFilterMarkers):
+		return ErrorCategoryContentFilter
+	case containsAny(text, quotaMarkers) || statusCode == http.StatusPaymentRequired:
+		return ErrorCategoryQuota
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden ||
+		strings.Contains(text, "authentication_error") || strings.Contains(text, "permission_error") ||
+		strings.Contains(text, "unauthenticated") || containsAny(text, authMarkers):
+		return ErrorCategoryAuth
+	case statusCode == http.StatusTooManyRequests || strings.Contains(text, "resource_exhausted") ||
+		containsAny(text, rateLimitMarkers):
+		return ErrorCategoryRateLimit
+	case statusCode >= 500 || statusCode == http.StatusRequestTimeout || containsAny(text, serverMarkers):
+		return ErrorCategoryServer
+	case statusCode >= 400:
+		return ErrorCategoryInvalidRequest
+	}
+	return ErrorCategoryUnknown
+}
+
+// invalidKeyMarkers 明确表示密钥本身无效（而非无权访问某个模型或资源）的错误特征（小写）
+var invalidKeyMarkers = []string{
+	"invalid_api_key",
+	"invalid api key",
+	"incorrect api key",
+	"api key not valid",
+	"api_key_invalid",
+	"api key not found",
+	"api key expired",
+	"invalid x-api-key",
+	"account deactivated",
+}
+
+// IsInvalidKeyError 判断认证类错误是否说明密钥本身无效，需要将密钥标记为无效
+// 只有401或明确的无效密钥错误码才算；403和permission_error通常只是密钥无权访问请求的模型或资源，换其他模型仍可使用
+func IsInvalidKeyError(err error) bool {
+	if err == nil || ClassifyError(err) != ErrorCategoryAuth {
+		return false
+	}
+	statusCode := StatusCodeFromError(err)
+	if statusCode == http.StatusUnauthorized {
+		return true
+	}
+
+	text := strings.ToLower(err.Error())
+	var toolErr *ToolCallError
+	if errors.As(err, &toolErr) {
+		text = strings.ToLower(toolErr.Type + " " + toolErr.Code + " " + toolErr.Message)
+	}
+	var geminiErr genai.APIError
+	if errors.As(err, &geminiErr) {
+		text = strings.ToLower(geminiErr.Status + " " + geminiErr.Message)
+		for _, detail := range geminiErr.Details {
+			if reason, ok := detail["reason"].(string); ok {
+				text += " " + strings.ToLower(reason)
+			}
+		}
+	}
+	if statusCode != http.StatusForbidden && (strings.Contains(text, "authentication_error") || strings.Contains(text, "unauthenticated")) {
+		return true
+	}
+	return containsAny(text, invalidKeyMarkers)
+}
+
+// containsAny 判断文本是否包含任一特征
+func containsAny(text string, markers []string) bool {
+	for _, marker := range markers {
+		if strings.Contains(text, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusCodeFromError 从带状态码的上游错误（ToolCallError、Gemini APIError）中提取HTTP状态码，无法确定时返回0
+// 不解析错误信息文本，上游响应体中出现的 "status 500" 之类的内容不会被误认为状态码
+func StatusCodeFromError(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var toolErr *ToolCallError
+	if errors.As(err, &toolErr) && toolErr.StatusCode > 0 {
+		return toolErr.StatusCode
+	}
+
+	var geminiErr genai.APIError
+	if errors.As(err, &geminiErr) && geminiErr.Code > 0 {
+		return geminiErr.Code
+	}
+	return 0
+}
+
+// IsContextLengthError 判断是否为上下文长度超限错误，此类错误换密钥重试也无法成功
+func IsContextLengthError(err error) bool {
+	return err != nil && ClassifyError(err) == ErrorCategoryContextLength
+}
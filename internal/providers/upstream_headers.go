@@ -0,0 +1,72 @@
+package providers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// upstreamHeadersKey 请求上下文中保存上游响应头捕获器的键
+type upstreamHeadersKey struct{}
+
+// UpstreamHeaders 捕获单次请求收到的上游响应头，多次发送时保留最后一次
+type UpstreamHeaders struct {
+	mu     sync.Mutex
+	header http.Header
+}
+
+// WithUpstreamHeaders 返回带上游响应头捕获器的上下文，提供商使用该上下文发送请求后可从捕获器读取响应头
+func WithUpstreamHeaders(ctx context.Context) (context.Context, *UpstreamHeaders) {
+	captured := &UpstreamHeaders{}
+	return context.WithValue(ctx, upstreamHeadersKey{}, captured), captured
+}
+
+// Header 获取捕获的上游响应头，尚未收到响应时返回nil
+func (u *UpstreamHeaders) Header() http.Header {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.header
+}
+
+// set 保存上游响应头的副本
+func (u *UpstreamHeaders) set(header http.Header) {
+	u.mu.Lock()
+	u.header = header.Clone()
+	u.mu.Unlock()
+}
+
+// responseObserverTransport 在响应返回时捕获响应头并通知观察者，用于透传上游响应头和采集额度信息
+type responseObserverTransport struct {
+	base    http.RoundTripper
+	observe func(apiKey string, statusCode int, header http.Header)
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *responseObserverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if captured, ok := req.Context().Value(upstreamHeadersKey{}).(*UpstreamHeaders); ok {
+		captured.set(resp.Header)
+	}
+	if t.observe != nil {
+		t.observe(requestAPIKey(req), resp.StatusCode, resp.Header)
+	}
+	return resp, nil
+}
+
+// requestAPIKey 从请求中取出使用的API密钥，兼容各提供商的认证方式
+func requestAPIKey(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key := req.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	if key := req.Header.Get("X-Goog-Api-Key"); key != "" {
+		return key
+	}
+	return req.URL.Query().Get("key")
+}
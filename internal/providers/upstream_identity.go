@@ -0,0 +1,72 @@
+package providers
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// DefaultUserAgent 未配置上游身份标识时使用的 User-Agent
+const DefaultUserAgent = "TurnsAPI"
+
+// UpstreamIdentity 发往上游的身份标识：统一的 User-Agent 和归属请求头
+type UpstreamIdentity struct {
+	UserAgent string
+	Headers   map[string]string
+}
+
+// upstreamIdentity 当前生效的全局身份标识
+var upstreamIdentity atomic.Pointer[UpstreamIdentity]
+
+// SetUpstreamIdentity 设置所有上游请求使用的 User-Agent 和归属请求头，userAgent为空时使用 DefaultUserAgent
+func SetUpstreamIdentity(userAgent string, headers map[string]string) {
+	identity := &UpstreamIdentity{UserAgent: userAgent, Headers: make(map[string]string, len(headers))}
+	for name, value := range headers {
+		identity.Headers[http.CanonicalHeaderKey(name)] = value
+	}
+	upstreamIdentity.Store(identity)
+}
+
+// currentUpstreamIdentity 获取当前的全局身份标识
+func currentUpstreamIdentity() *UpstreamIdentity {
+	if identity := upstreamIdentity.Load(); identity != nil {
+		return identity
+	}
+	return &UpstreamIdentity{}
+}
+
+// upstreamIdentityTransport 为上游请求设置统一的 User-Agent 和归属请求头
+// 分组 headers 中配置的同名请求头优先，SDK 自带的 User-Agent 会被替换，避免泄露依赖库版本
+type upstreamIdentityTransport struct {
+	base         http.RoundTripper
+	groupHeaders map[string]string
+}
+
+// identityHeaders 计算本分组发往上游的身份请求头
+func (t *upstreamIdentityTransport) identityHeaders() map[string]string {
+	identity := currentUpstreamIdentity()
+	headers := make(map[string]string, len(identity.Headers)+1)
+	headers["User-Agent"] = identity.UserAgent
+	if headers["User-Agent"] == "" {
+		headers["User-Agent"] = DefaultUserAgent
+	}
+	for name, value := range identity.Headers {
+		headers[name] = value
+	}
+	for name, value := range t.groupHeaders {
+		if _, exists := headers[http.CanonicalHeaderKey(name)]; exists {
+			headers[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+	return headers
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *upstreamIdentityTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTripper 不能修改调用方的请求
+	req = req.Clone(req.Context())
+	for name, value := range t.identityHeaders() {
+		req.Header.Set(name, value)
+	}
+	applyRequestHeaders(req)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/providers"
+	"turnsapi/internal/proxykey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 自动模型别名参数
+const (
+	AutoModelQualityHeader = "X-TurnsAPI-Quality"    // 客户端无法添加 turnsapi 扩展参数时声明质量等级的请求头
+	AutoModelHeader        = "X-TurnsAPI-Auto-Model" // 返回给客户端的实际选择的模型
+
+	modelLatencyAlpha = 0.2 // 模型延迟指数移动平均的权重
+)
+
+// AutoModelCandidateStatus 自动模型候选的价格、延迟和可用状态
+type AutoModelCandidateStatus struct {
+	Model        string   `json:"model"`
+	Tier         string   `json:"tier"`
+	InputPer1M   *float64 `json:"input_per_1m,omitempty"`  // 未配置价格时为空
+	OutputPer1M  *float64 `json:"output_per_1m,omitempty"` // 未配置价格时为空
+	LatencyMs    *int64   `json:"latency_ms,omitempty"`    // 成功请求耗时的指数移动平均，未测量时为空
+	Samples      int64    `json:"samples"`
+	Groups       []string `json:"groups"`                // 支持该模型的分组
+	Unavailable  string   `json:"unavailable,omitempty"` // 不可选的原因
+	blendedPrice float64
+}
+
+// modelLatency 单个模型的延迟统计
+type modelLatency struct {
+	ewmaMs  float64
+	samples int64
+}
+
+// modelLatencyTracker 按模型统计成功请求的耗时，供自动模型按速度选择
+type modelLatencyTracker struct {
+	mu     sync.RWMutex
+	models map[string]*modelLatency
+}
+
+// newModelLatencyTracker 创建模型延迟统计
+func newModelLatencyTracker() *modelLatencyTracker {
+	return &modelLatencyTracker{models: make(map[string]*modelLatency)}
+}
+
+// observe 记录一次成功请求的耗时
+func (t *modelLatencyTracker) observe(model string, duration time.Duration) {
+	ms := float64(duration.Milliseconds())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats, exists := t.models[model]
+	if !exists {
+		t.models[model] = &modelLatency{ewmaMs: ms, samples: 1}
+		return
+	}
+	stats.ewmaMs = modelLatencyAlpha*ms + (1-modelLatencyAlpha)*stats.ewmaMs
+	stats.samples++
+}
+
+// get 获取模型的平均耗时，未测量时返回false
+func (t *modelLatencyTracker) get(model string) (float64, int64, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	stats, exists := t.models[model]
+	if !exists {
+		return 0, 0, false
+	}
+	return stats.ewmaMs, stats.samples, true
+}
+
+// autoModelSettings 读取自动模型设置，未启用时返回nil
+func autoModelSettings(config *internal.Config) *internal.AutoModelSettings {
+	if config == nil || config.GlobalSettings == nil || config.GlobalSettings.AutoModel == nil ||
+		len(config.GlobalSettings.AutoModel.Candidates) == 0 {
+		return nil
+	}
+	return config.GlobalSettings.AutoModel
+}
+
+// autoModelQuality 读取请求声明的质量等级：优先使用 turnsapi 扩展参数，其次是请求头，最后是默认等级
+func autoModelQuality(c *gin.Context, req *providers.ChatCompletionRequest, settings *internal.AutoModelSettings) string {
+	if req.TurnsAPI != nil && req.TurnsAPI.Quality != "" {
+		return strings.ToLower(req.TurnsAPI.Quality)
+	}
+	if quality := c.GetHeader(AutoModelQualityHeader); quality != "" {
+		return strings.ToLower(quality)
+	}
+	return settings.DefaultTier
+}
+
+// rankAutoModelCandidates 计算满足质量等级的候选模型并按策略排序，可用的候选排在前面
+// cheapest 按价格排序，未配置价格的排在最后；fastest 按测量的延迟排序，未测量的按价格排在已测量的之后
+func (p *MultiProviderProxy) rankAutoModelCandidates(config *internal.Config, settings *internal.AutoModelSettings, tier string, allowedGroups, allowedModels, deniedModels []string) []AutoModelCandidateStatus {
+	minRank := internal.AutoModelTierRank(tier)
+	statuses := make([]AutoModelCandidateStatus, 0, len(settings.Candidates))
+	for _, candidate := range settings.Candidates {
+		if internal.AutoModelTierRank(candidate.Tier) < minRank {
+			continue
+		}
+
+		status := AutoModelCandidateStatus{Model: candidate.Model, Tier: candidate.Tier, blendedPrice: math.Inf(1)}
+		if price, ok := config.GlobalSettings.ModelPricing[candidate.Model]; ok {
+			input, output := price.InputPer1M, price.OutputPer1M
+			status.InputPer1M, status.OutputPer1M = &input, &output
+			status.blendedPrice = input + output
+		}
+		if ewma, samples, ok := p.modelLatency.get(candidate.Model); ok {
+			latency := int64(ewma)
+			status.LatencyMs = &latency
+			status.Samples = samples
+		}
+
+		status.Groups = p.providerRouter.GetGroupsForModel(candidate.Model, allowedGroups)
+		if !proxykey.ModelAllowed(allowedModels, deniedModels, candidate.Model) {
+			status.Unavailable = "model not allowed for this API key"
+		} else if len(status.Groups) == 0 {
+			status.Unavailable = "no enabled group serves this model"
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.SliceStable(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		if (a.Unavailable == "") != (b.Unavailable == "") {
+			return a.Unavailable == ""
+		}
+		if settings.Strategy == internal.AutoModelStrategyFastest {
+			if (a.LatencyMs != nil) != (b.LatencyMs != nil) {
+				return a.LatencyMs != nil
+			}
+			if a.LatencyMs != nil && *a.LatencyMs != *b.LatencyMs {
+				return *a.LatencyMs < *b.LatencyMs
+			}
+			return a.blendedPrice < b.blendedPrice
+		}
+		if a.blendedPrice != b.blendedPrice {
+			return a.blendedPrice < b.blendedPrice
+		}
+		if a.LatencyMs != nil && b.LatencyMs != nil {
+			return *a.LatencyMs < *b.LatencyMs
+		}
+		return a.LatencyMs != nil
+	})
+	return statuses
+}
+
+// resolveAutoModel 请求自动模型别名时选择实际模型，返回选择的模型，不是别名时返回空字符串
+func (p *MultiProviderProxy) resolveAutoModel(c *gin.Context, req *providers.ChatCompletionRequest, allowedGroups, allowedModels, deniedModels []string) (string, error) {
+	config := p.config.Snapshot()
+	settings := autoModelSettings(config)
+	if settings == nil || req.Model != settings.Alias {
+		return "", nil
+	}
+
+	tier := autoModelQuality(c, req, settings)
+	if internal.AutoModelTierRank(tier) == 0 {
+		return "", fmt.Errorf("unknown quality tier %q, expected high, medium or low", tier)
+	}
+
+	ranked := p.rankAutoModelCandidates(config, settings, tier, allowedGroups, allowedModels, deniedModels)
+	if len(ranked) == 0 || ranked[0].Unavailable != "" {
+		return "", fmt.Errorf("no available model meets quality tier %q", tier)
+	}
+	return ranked[0].Model, nil
+}
+
+// GetAutoModelStatus 获取自动模型设置和各质量等级的候选排序，未启用时返回nil
+func (p *MultiProviderProxy) GetAutoModelStatus() map[string]interface{} {
+	config := p.config.Snapshot()
+	settings := autoModelSettings(config)
+	if settings == nil {
+		return nil
+	}
+
+	tiers := make(map[string][]AutoModelCandidateStatus)
+	for _, tier := range []string{internal.AutoModelTierHigh, internal.AutoModelTierMedium, internal.AutoModelTierLow} {
+		tiers[tier] = p.rankAutoModelCandidates(config, settings, tier, nil, nil, nil)
+	}
+	return map[string]interface{}{
+		"alias":        settings.Alias,
+		"strategy":     settings.Strategy,
+		"default_tier": settings.DefaultTier,
+		"tiers":        tiers,
+	}
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/keymanager"
+	"turnsapi/internal/providers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAutoModelTestProxy 创建启用自动模型别名的代理，gpt-4o、gpt-4o-mini 和 claude-3-5-haiku 各由一个分组提供
+func newAutoModelTestProxy(t *testing.T, strategy string) *MultiProviderProxy {
+	t.Helper()
+	groups := map[string]*internal.UserGroup{}
+	for groupID, model := range map[string]string{"group_4o": "gpt-4o", "group_mini": "gpt-4o-mini", "group_haiku": "claude-3-5-haiku"} {
+		group := newTestGroup(groupID, nil)
+		group.Models = []string{model}
+		groups[groupID] = group
+	}
+	cfg := &internal.Config{
+		UserGroups: groups,
+		GlobalSettings: &internal.GlobalSettings{
+			ModelPricing: map[string]internal.ModelPrice{
+				"gpt-4o":           {InputPer1M: 2.5, OutputPer1M: 10},
+				"gpt-4o-mini":      {InputPer1M: 0.15, OutputPer1M: 0.6},
+				"claude-3-5-haiku": {InputPer1M: 0.8, OutputPer1M: 4},
+			},
+			AutoModel: &internal.AutoModelSettings{
+				Alias:       "auto",
+				Strategy:    strategy,
+				DefaultTier: internal.AutoModelTierMedium,
+				Candidates: []internal.AutoModelCandidate{
+					{Model: "gpt-4o", Tier: internal.AutoModelTierHigh},
+					{Model: "claude-3-5-haiku", Tier: internal.AutoModelTierMedium},
+					{Model: "gpt-4o-mini", Tier: internal.AutoModelTierLow},
+					{Model: "o3-mini", Tier: internal.AutoModelTierHigh},
+				},
+			},
+		},
+	}
+	return NewMultiProviderProxy(cfg, keymanager.NewMultiGroupKeyManager(cfg), nil)
+}
+
+// resolveAutoModelFor 以指定的质量等级请求头解析自动模型别名
+func resolveAutoModelFor(p *MultiProviderProxy, model, quality string, deniedModels []string) (string, error) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	if quality != "" {
+		c.Request.Header.Set(AutoModelQualityHeader, quality)
+	}
+	return p.resolveAutoModel(c, &providers.ChatCompletionRequest{Model: model}, nil, nil, deniedModels)
+}
+
+// TestResolveAutoModelCheapest 测试按质量等级选择最便宜的可用模型，高等级模型满足低等级请求
+func TestResolveAutoModelCheapest(t *testing.T) {
+	p := newAutoModelTestProxy(t, internal.AutoModelStrategyCheapest)
+
+	tests := []struct {
+		quality string
+		denied  []string
+		want    string
+	}{
+		{"low", nil, "gpt-4o-mini"},
+		{"", nil, "claude-3-5-haiku"}, // 默认等级 medium
+		{"medium", []string{"claude-3-5-haiku"}, "gpt-4o"},
+		{"HIGH", nil, "gpt-4o"}, // 没有分组提供 o3-mini，不可选
+	}
+	for _, tt := range tests {
+		got, err := resolveAutoModelFor(p, "auto", tt.quality, tt.denied)
+		if err != nil || got != tt.want {
+			t.Errorf("质量等级 %q（禁止 %v）应选择 %s，得到 %q err=%v", tt.quality, tt.denied, tt.want, got, err)
+		}
+	}
+
+	if got, err := resolveAutoModelFor(p, "gpt-4o", "low", nil); got != "" || err != nil {
+		t.Errorf("不是自动模型别名时不应选择模型，得到 %q err=%v", got, err)
+	}
+	if _, err := resolveAutoModelFor(p, "auto", "ultra", nil); err == nil {
+		t.Error("未知的质量等级应返回错误")
+	}
+	if _, err := resolveAutoModelFor(p, "auto", "high", []string{"gpt-4o"}); err == nil {
+		t.Error("没有满足质量等级的可用模型时应返回错误")
+	}
+}
+
+// TestResolveAutoModelFastest 测试按测量的延迟选择最快的模型，未测量的模型排在已测量的之后
+func TestResolveAutoModelFastest(t *testing.T) {
+	p := newAutoModelTestProxy(t, internal.AutoModelStrategyFastest)
+
+	p.modelLatency.observe("gpt-4o", 300*time.Millisecond)
+	p.modelLatency.observe("claude-3-5-haiku", 900*time.Millisecond)
+	if got, err := resolveAutoModelFor(p, "auto", "low", nil); err != nil || got != "gpt-4o" {
+		t.Errorf("应选择已测量的最快模型 gpt-4o，得到 %q err=%v", got, err)
+	}
+
+	// 延迟按指数移动平均更新
+	for i := 0; i < 20; i++ {
+		p.modelLatency.observe("gpt-4o", 2*time.Second)
+	}
+	if got, err := resolveAutoModelFor(p, "auto", "low", nil); err != nil || got != "claude-3-5-haiku" {
+		t.Errorf("gpt-4o 变慢后应选择 claude-3-5-haiku，得到 %q err=%v", got, err)
+	}
+	if _, samples, _ := p.modelLatency.get("gpt-4o"); samples != 21 {
+		t.Errorf("应记录21个延迟样本，实际为 %d", samples)
+	}
+}
@@ -0,0 +1,428 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"turnsapi/internal/database"
+	"turnsapi/internal/logger"
+	"turnsapi/internal/providers"
+	"turnsapi/internal/proxykey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 批处理对象类型
+const (
+	batchObjectBatch = "batch"
+	batchObjectFile  = "file"
+)
+
+// maxBatchCreateBody 创建批处理任务的请求体上限，任务本身只引用已上传的文件
+const maxBatchCreateBody = 1 << 20
+
+// batchTarget 批处理请求发往的分组和密钥
+type batchTarget struct {
+	groupID string
+	apiKey  string
+}
+
+// HandleFileUpload 上传批处理输入文件：选择支持批处理的分组和密钥，并记录文件归属
+func (p *MultiProviderProxy) HandleFileUpload(c *gin.Context) {
+	if !p.checkBatchKeyBudget(c) {
+		return
+	}
+	target, ok := p.selectBatchTarget(c)
+	if !ok {
+		return
+	}
+	p.forwardBatchRequest(c, target, "/files", c.Request.Body, batchObjectFile)
+}
+
+// HandleBatchCreate 创建批处理任务，路由到上传输入文件时使用的分组和密钥
+// 与聊天请求一样检查代理密钥和分组的预算；代理密钥限制了模型时检查输入文件每一行请求的模型
+func (p *MultiProviderProxy) HandleBatchCreate(c *gin.Context) {
+	if !p.checkBatchKeyBudget(c) {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBatchCreateBody))
+	if err != nil {
+		respondBatchError(c, http.StatusBadRequest, "Failed to read request body", "invalid_request_error")
+		return
+	}
+	var req struct {
+		InputFileID string `json:"input_file_id"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.InputFileID == "" {
+		respondBatchError(c, http.StatusBadRequest, "input_file_id is required", "invalid_request_error")
+		return
+	}
+
+	// 输入文件只能被上传时的密钥访问；不是经由本代理上传的文件按新请求选择分组
+	owner, ok := p.lookupBatchObject(c, req.InputFileID)
+	if !ok {
+		return
+	}
+	var target *batchTarget
+	if owner != nil {
+		target = &batchTarget{groupID: owner.GroupID, apiKey: owner.APIKey}
+	} else if target, ok = p.selectBatchTarget(c); !ok {
+		return
+	}
+	if p.groupBudgetExceeded(target.groupID) {
+		p.respondBudgetExceeded(c, nil)
+		return
+	}
+	if !p.checkBatchInputModels(c, target, req.InputFileID) {
+		return
+	}
+	c.Request.Header.Set("Content-Length", fmt.Sprint(len(body)))
+	p.forwardBatchRequest(c, target, "/batches", bytes.NewReader(body), batchObjectBatch)
+}
+
+// HandleBatchList 列出批处理任务，使用代理密钥最近创建任务时的分组和密钥
+func (p *MultiProviderProxy) HandleBatchList(c *gin.Context) {
+	p.handleBatchListing(c, batchObjectBatch, "/batches")
+}
+
+// HandleFileList 列出文件，使用代理密钥最近上传文件时的分组和密钥
+func (p *MultiProviderProxy) HandleFileList(c *gin.Context) {
+	p.handleBatchListing(c, batchObjectFile, "/files")
+}
+
+// HandleBatchObject 查询、取消批处理任务或查询、下载、删除文件，路由到创建对象时的分组和密钥
+func (p *MultiProviderProxy) HandleBatchObject(c *gin.Context) {
+	objectID := c.Param("id")
+	owner, ok := p.lookupBatchObject(c, objectID)
+	if !ok {
+		return
+	}
+	if owner == nil {
+		respondBatchError(c, http.StatusNotFound, fmt.Sprintf("No such object: '%s'", objectID), "invalid_request_error")
+		return
+	}
+
+	// 路由注册为 /batches/:id 或 /files/:id 及其子路径，上游路径与客户端路径去掉 /v1 前缀后相同
+	path := strings.TrimPrefix(c.Request.URL.Path, "/v1")
+	if c.Request.URL.RawQuery != "" {
+		path += "?" + c.Request.URL.RawQuery
+	}
+
+	var body io.Reader
+	if c.Request.Method == http.MethodPost {
+		body = c.Request.Body
+	}
+	target := &batchTarget{groupID: owner.GroupID, apiKey: owner.APIKey}
+	if c.Request.Method == http.MethodDelete && owner.ObjectType == batchObjectFile {
+		if p.forwardBatchRequest(c, target, path, body, "") && p.database != nil {
+			if err := p.database.DeleteBatchObject(objectID); err != nil {
+				log.Printf("删除批处理对象 %s 的归属记录失败: %v", objectID, err)
+			}
+		}
+		return
+	}
+	p.forwardBatchRequest(c, target, path, body, "")
+}
+
+// handleBatchListing 列表请求没有对象ID，沿用代理密钥最近一次创建同类对象的分组和密钥
+func (p *MultiProviderProxy) handleBatchListing(c *gin.Context, objectType, path string) {
+	var target *batchTarget
+	if p.database != nil && c.GetHeader("X-Provider-Group") == "" {
+		_, proxyKeyID := p.getProxyKeyInfo(c)
+		latest, err := p.database.LatestBatchObject(proxyKeyID, objectType)
+		if err != nil {
+			log.Printf("查询批处理对象归属失败: %v", err)
+		} else if latest != nil {
+			target = &batchTarget{groupID: latest.GroupID, apiKey: latest.APIKey}
+		}
+	}
+	if target == nil {
+		var ok bool
+		if target, ok = p.selectBatchTarget(c); !ok {
+			return
+		}
+	}
+	if c.Request.URL.RawQuery != "" {
+		path += "?" + c.Request.URL.RawQuery
+	}
+	p.forwardBatchRequest(c, target, path, nil, "")
+}
+
+// selectBatchTarget 为新建的批处理对象选择分组和密钥，失败时已返回错误响应
+func (p *MultiProviderProxy) selectBatchTarget(c *gin.Context) (*batchTarget, bool) {
+	var allowedGroups []string
+	if proxyKey := batchProxyKey(c); proxyKey != nil {
+		allowedGroups = proxyKey.AllowedGroups
+	}
+
+	candidates := p.providerRouter.GetBatchGroups(allowedGroups)
+	if providerGroup := c.GetHeader("X-Provider-Group"); providerGroup != "" {
+		found := false
+		for _, groupID := range candidates {
+			if groupID == providerGroup {
+				found = true
+				break
+			}
+		}
+		if !found {
+			respondBatchError(c, http.StatusBadRequest,
+				fmt.Sprintf("Provider group '%s' is not available or does not support batches", providerGroup), "invalid_request_error")
+			return nil, false
+		}
+		candidates = []string{providerGroup}
+	}
+
+	for _, groupID := range candidates {
+		if p.groupBudgetExceeded(groupID) {
+			continue
+		}
+		if !p.allowRPM(groupID) {
+			continue
+		}
+		apiKey, err := p.keyManager.GetNextKeyForGroup(groupID)
+		if err != nil {
+			log.Printf("分组 %s 没有可用于批处理的密钥: %v", groupID, err)
+			continue
+		}
+		return &batchTarget{groupID: groupID, apiKey: apiKey}, true
+	}
+
+	respondBatchError(c, http.StatusServiceUnavailable, "No provider group supporting batches is available", "service_unavailable")
+	return nil, false
+}
+
+// checkBatchKeyBudget 检查代理密钥或其租户是否已超出预算，超出时已返回错误响应
+func (p *MultiProviderProxy) checkBatchKeyBudget(c *gin.Context) bool {
+	if budget, exceeded := p.keyBudgetExceeded(batchProxyKey(c)); exceeded {
+		p.respondBudgetExceeded(c, budget)
+		return false
+	}
+	return true
+}
+
+// batchProxyKey 获取请求的代理密钥，未通过代理密钥认证时返回nil
+func batchProxyKey(c *gin.Context) *logger.ProxyKey {
+	if keyInfo, exists := c.Get("key_info"); exists {
+		if proxyKey, ok := keyInfo.(*logger.ProxyKey); ok {
+			return proxyKey
+		}
+	}
+	return nil
+}
+
+// checkBatchInputModels 代理密钥限制了模型时，下载输入文件并检查每一行请求的模型，不允许的模型或无法解析的行拒绝创建任务
+// 检查失败时已返回错误响应
+func (p *MultiProviderProxy) checkBatchInputModels(c *gin.Context, target *batchTarget, fileID string) bool {
+	proxyKey := batchProxyKey(c)
+	if proxyKey == nil || (len(proxyKey.AllowedModels) == 0 && len(proxyKey.DeniedModels) == 0) {
+		return true
+	}
+
+	forwarder, ok := p.batchForwarder(c, target)
+	if !ok {
+		return false
+	}
+	_, _, totalTimeout := p.upstreamTimeoutsForGroup(target.groupID)
+	ctx, cancel := context.WithTimeout(withTraceContext(c.Request.Context(), c), totalTimeout)
+	defer cancel()
+
+	resp, err := forwarder.Forward(ctx, http.MethodGet, "/files/"+url.PathEscape(fileID)+"/content", http.Header{}, nil)
+	if err != nil {
+		log.Printf("下载批处理输入文件 %s 失败: %v", fileID, err)
+		respondBatchError(c, http.StatusBadGateway, "Failed to read the batch input file from the upstream provider", "upstream_error")
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respondBatchError(c, http.StatusBadGateway,
+			fmt.Sprintf("Failed to read the batch input file from the upstream provider (status %d)", resp.StatusCode), "upstream_error")
+		return false
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for lineNumber := 1; ; lineNumber++ {
+		line, readErr := reader.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			var request struct {
+				Body struct {
+					Model string `json:"model"`
+				} `json:"body"`
+			}
+			if err := json.Unmarshal(trimmed, &request); err != nil || request.Body.Model == "" {
+				respondBatchError(c, http.StatusBadRequest,
+					fmt.Sprintf("Line %d of the batch input file has no model", lineNumber), "invalid_request_error")
+				return false
+			}
+			if !proxykey.ModelAllowed(proxyKey.AllowedModels, proxyKey.DeniedModels, request.Body.Model) {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error": gin.H{
+						"message": fmt.Sprintf("Model '%s' on line %d of the batch input file is not allowed for this API key", request.Body.Model, lineNumber),
+						"type":    "permission_error",
+						"code":    "model_not_allowed",
+					},
+				})
+				return false
+			}
+		}
+		if readErr == io.EOF {
+			return true
+		}
+		if readErr != nil {
+			log.Printf("读取批处理输入文件 %s 失败: %v", fileID, readErr)
+			respondBatchError(c, http.StatusBadGateway, "Failed to read the batch input file from the upstream provider", "upstream_error")
+			return false
+		}
+	}
+}
+
+// lookupBatchObject 查询对象归属，只返回同一代理密钥创建的对象，未找到时返回 nil
+// 查询出错时已返回错误响应，第二个返回值为 false
+func (p *MultiProviderProxy) lookupBatchObject(c *gin.Context, objectID string) (*database.BatchObjectRecord, bool) {
+	if p.database == nil {
+		respondBatchError(c, http.StatusServiceUnavailable, "Batch routing requires the database", "service_unavailable")
+		return nil, false
+	}
+	owner, err := p.database.GetBatchObject(objectID)
+	if err != nil {
+		log.Printf("查询批处理对象 %s 的归属失败: %v", objectID, err)
+		respondBatchError(c, http.StatusInternalServerError, "Failed to look up batch object", "internal_error")
+		return nil, false
+	}
+	if owner == nil {
+		return nil, true
+	}
+	if _, proxyKeyID := p.getProxyKeyInfo(c); owner.ProxyKeyID != proxyKeyID {
+		return nil, true
+	}
+	return owner, true
+}
+
+// forwardBatchRequest 将请求转发到目标分组，记录响应中新建对象的归属，返回上游是否成功
+// objectType 非空时响应中的 id 记为该类型的新对象
+func (p *MultiProviderProxy) forwardBatchRequest(c *gin.Context, target *batchTarget, path string, body io.Reader, objectType string) bool {
+	forwarder, ok := p.batchForwarder(c, target)
+	if !ok {
+		return false
+	}
+
+	_, _, totalTimeout := p.upstreamTimeoutsForGroup(target.groupID)
+	ctx, cancel := context.WithTimeout(withTraceContext(c.Request.Context(), c), totalTimeout)
+	defer cancel()
+
+	resp, err := forwarder.Forward(ctx, c.Request.Method, path, c.Request.Header, body)
+	if err != nil {
+		log.Printf("批处理请求转发到分组 %s 失败: %v", target.groupID, err)
+		p.reportUpstreamError(target.groupID, target.apiKey, err)
+		respondBatchError(c, http.StatusBadGateway, "Failed to reach the upstream provider", "upstream_error")
+		return false
+	}
+	defer resp.Body.Close()
+
+	c.Header("X-TurnsAPI-Group", target.groupID)
+	success := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		p.keyManager.ReportError(target.groupID, target.apiKey, fmt.Sprintf("批处理请求返回 %d", resp.StatusCode))
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if !success || (contentType != "" && !strings.HasPrefix(contentType, "application/json")) {
+		// 文件内容等非JSON响应直接流式返回
+		c.DataFromReader(resp.StatusCode, resp.ContentLength, contentType, resp.Body, nil)
+		return success
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		respondBatchError(c, http.StatusBadGateway, "Failed to read upstream response", "upstream_error")
+		return false
+	}
+	p.recordBatchObjects(c, target, respBody, objectType)
+	c.Data(resp.StatusCode, "application/json", respBody)
+	return true
+}
+
+// batchForwarder 创建发往目标分组和密钥的透传提供商，分组不可用或不支持批处理时已返回错误响应
+func (p *MultiProviderProxy) batchForwarder(c *gin.Context, target *batchTarget) (providers.PassthroughProvider, bool) {
+	group, exists := p.config.Snapshot().UserGroups[target.groupID]
+	if !exists || !group.Enabled {
+		respondBatchError(c, http.StatusServiceUnavailable, fmt.Sprintf("Provider group '%s' is no longer available", target.groupID), "service_unavailable")
+		return nil, false
+	}
+	config, err := p.providerRouter.CreateProviderConfig(target.groupID, group)
+	if err != nil {
+		respondBatchError(c, http.StatusServiceUnavailable, err.Error(), "service_unavailable")
+		return nil, false
+	}
+	config.APIKey = target.apiKey
+	provider, err := providers.NewDefaultProviderFactory().CreateProvider(config)
+	if err != nil {
+		respondBatchError(c, http.StatusServiceUnavailable, err.Error(), "service_unavailable")
+		return nil, false
+	}
+	forwarder, ok := provider.(providers.PassthroughProvider)
+	if !ok || !providers.SupportsBatches(group.ProviderType) {
+		respondBatchError(c, http.StatusBadRequest, fmt.Sprintf("Provider group '%s' does not support batches", target.groupID), "invalid_request_error")
+		return nil, false
+	}
+	return forwarder, true
+}
+
+// recordBatchObjects 记录响应中的对象归属；批处理任务的输出和错误文件与任务属于同一个密钥
+func (p *MultiProviderProxy) recordBatchObjects(c *gin.Context, target *batchTarget, respBody []byte, objectType string) {
+	if p.database == nil {
+		return
+	}
+	var object struct {
+		ID           string `json:"id"`
+		Object       string `json:"object"`
+		OutputFileID string `json:"output_file_id"`
+		ErrorFileID  string `json:"error_file_id"`
+	}
+	if err := json.Unmarshal(respBody, &object); err != nil {
+		return
+	}
+
+	_, proxyKeyID := p.getProxyKeyInfo(c)
+	record := func(objectID, objectType string) {
+		if objectID == "" {
+			return
+		}
+		err := p.database.SaveBatchObject(database.BatchObjectRecord{
+			ObjectID:   objectID,
+			ObjectType: objectType,
+			GroupID:    target.groupID,
+			APIKey:     target.apiKey,
+			ProxyKeyID: proxyKeyID,
+			CreatedAt:  time.Now(),
+		})
+		if err != nil {
+			log.Printf("记录批处理对象 %s 的归属失败: %v", objectID, err)
+		}
+	}
+	if objectType != "" {
+		record(object.ID, objectType)
+	}
+	if object.Object == batchObjectBatch {
+		record(object.OutputFileID, batchObjectFile)
+		record(object.ErrorFileID, batchObjectFile)
+	}
+}
+
+// respondBatchError 以OpenAI错误格式返回批处理接口的错误
+func respondBatchError(c *gin.Context, status int, message, errorType string) {
+	c.JSON(status, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    errorType,
+		},
+	})
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/database"
+	"turnsapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestBatchCreateChecksInputModels 测试代理密钥限制了模型时，输入文件中包含不允许的模型的批处理任务被拒绝
+func TestBatchCreateChecksInputModels(t *testing.T) {
+	var created atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/files/file-1/content":
+			w.Write([]byte(`{"custom_id":"1","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}` + "\n" +
+				`{"custom_id":"2","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini"}}` + "\n"))
+		case r.Method == http.MethodPost && r.URL.Path == "/batches":
+			created.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"batch_1","object":"batch"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	group := newTestGroup("group_a", nil)
+	group.BaseURL = upstream.URL
+	p := newTestProxy(t, map[string]*internal.UserGroup{"group_a": group})
+
+	db, err := database.NewGroupsDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("创建数据库失败: %v", err)
+	}
+	defer db.Close()
+	p.database = db
+	if err := db.SaveBatchObject(database.BatchObjectRecord{
+		ObjectID: "file-1", ObjectType: batchObjectFile, GroupID: "group_a", APIKey: "sk-group_a", ProxyKeyID: "pk1", CreatedAt: time.Now(),
+	}); err != nil {
+		t.Fatalf("保存文件归属失败: %v", err)
+	}
+
+	createBatch := func(allowedModels []string) *httptest.ResponseRecorder {
+		gin.SetMode(gin.TestMode)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/batches",
+			strings.NewReader(`{"input_file_id":"file-1","endpoint":"/v1/chat/completions","completion_window":"24h"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Set("key_info", &logger.ProxyKey{ID: "pk1", Name: "test", AllowedModels: allowedModels})
+		p.HandleBatchCreate(c)
+		return w
+	}
+
+	w := createBatch([]string{"gpt-4o"})
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "gpt-4o-mini") {
+		t.Fatalf("包含不允许的模型时应返回403，status=%d body=%s", w.Code, w.Body.String())
+	}
+	if created.Load() != 0 {
+		t.Fatal("被拒绝的批处理任务不应发往上游")
+	}
+
+	w = createBatch([]string{"gpt-4o*"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("所有模型都允许时应创建任务，status=%d body=%s", w.Code, w.Body.String())
+	}
+	if created.Load() != 1 {
+		t.Errorf("批处理任务应发往上游一次，实际 %d 次", created.Load())
+	}
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/http"
+
+	"turnsapi/internal/budgets"
+	"turnsapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetBudgetEngine 设置预算引擎，设置后拒绝已超出预算的代理密钥、租户和分组的请求
+func (p *MultiProviderProxy) SetBudgetEngine(engine *budgets.Engine) {
+	p.budgets = engine
+}
+
+// keyBudgetExceeded 返回代理密钥或其所属租户已超出的预算
+func (p *MultiProviderProxy) keyBudgetExceeded(key *logger.ProxyKey) (*logger.Budget, bool) {
+	if p.budgets == nil || key == nil {
+		return nil, false
+	}
+	if budget, exceeded := p.budgets.Exceeded(logger.BudgetScopeProxyKey, key.ID); exceeded {
+		return budget, true
+	}
+	return p.budgets.Exceeded(logger.BudgetScopeTenant, key.TenantID)
+}
+
+// groupBudgetExceeded 分组是否已超出预算
+func (p *MultiProviderProxy) groupBudgetExceeded(groupID string) bool {
+	if p.budgets == nil {
+		return false
+	}
+	_, exceeded := p.budgets.Exceeded(logger.BudgetScopeGroup, groupID)
+	return exceeded
+}
+
+// respondBudgetExceeded 返回预算已用完的403响应，budget 为空表示所有候选分组都已超出预算
+func (p *MultiProviderProxy) respondBudgetExceeded(c *gin.Context, budget *logger.Budget) {
+	message := "The budget of all available provider groups has been exceeded for the current billing period"
+	if budget != nil {
+		switch budget.Scope {
+		case logger.BudgetScopeTenant:
+			message = "The budget of this API key's tenant has been exceeded for the current billing period"
+		default:
+			message = "The budget of this API key has been exceeded for the current billing period"
+		}
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    "permission_error",
+			"code":    "budget_exceeded",
+		},
+	})
+}
@@ -0,0 +1,18 @@
+package proxy
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusClientClosedRequest 客户端在响应完成前断开时记录的状态码（与Nginx的499相同）
+const statusClientClosedRequest = 499
+
+// errClientDisconnected 客户端在响应完成前断开连接，上游请求已随之取消，不计入密钥和分组的失败
+var errClientDisconnected = errors.New("client_disconnected")
+
+// clientDisconnected 客户端是否已断开连接
+func clientDisconnected(c *gin.Context) bool {
+	return c.Request.Context().Err() != nil
+}
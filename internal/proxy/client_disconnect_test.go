@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/keymanager"
+	"turnsapi/internal/logger"
+)
+
+// waitForRequestLog 等待分组的第一条请求日志写入
+func waitForRequestLog(t *testing.T, requestLogger *logger.RequestLogger, groupID string) *logger.RequestLogSummary {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		logs, err := requestLogger.GetRequestLogs("", groupID, 10, 0)
+		if err != nil {
+			t.Fatalf("查询请求日志失败: %v", err)
+		}
+		if len(logs) > 0 {
+			return logs[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("分组 %s 应记录请求日志", groupID)
+	return nil
+}
+
+// TestClientDisconnectCancelsStream 测试客户端中途断开时取消上游流式请求，按499记录且不重试、不计入分组失败
+func TestClientDisconnectCancelsStream(t *testing.T) {
+	cancelled := make(chan struct{})
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"content":"hi"}}]}`+"\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+
+	group := newTestGroup("group_1", nil)
+	group.BaseURL = upstream.URL
+	group.APIKeys = []string{"sk-first", "sk-second"}
+	cfg := &internal.Config{UserGroups: map[string]*internal.UserGroup{"group_1": group}}
+	p := NewMultiProviderProxy(cfg, keymanager.NewMultiGroupKeyManager(cfg), nil)
+	requestLogger, err := logger.NewRequestLogger(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("创建请求日志失败: %v", err)
+	}
+	defer requestLogger.Close()
+	p.requestLogger = requestLogger
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start := time.Now()
+	w := serveStreamRequest(p, ctx, nil)
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("客户端断开后应立即结束，耗时 %v", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("客户端断开后应取消上游请求")
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("客户端断开后不应换用其他密钥重试，上游收到 %d 个请求", calls.Load())
+	}
+	if body := w.Body.String(); body == "" || strings.Contains(body, "all_providers_failed") {
+		t.Errorf("应已转发部分数据且不返回错误，body=%s", body)
+	}
+	if log := waitForRequestLog(t, requestLogger, "group_1"); log.StatusCode != statusClientClosedRequest {
+		t.Errorf("应以499记录，得到 %d", log.StatusCode)
+	}
+	for _, state := range p.providerRouter.GetFailureStates() {
+		if state.FailureScore > 0 {
+			t.Errorf("客户端断开不应计入分组失败: %+v", state)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"turnsapi/internal"
+	"turnsapi/internal/providers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TruncatedMessagesHeader 按截断策略丢弃的消息数
+const TruncatedMessagesHeader = "X-TurnsAPI-Truncated-Messages"
+
+// enforceContextLimit 发送前估算提示词token数，超出模型上下文窗口时按策略截断或返回400
+// 返回 false 时已写入错误响应
+func (p *MultiProviderProxy) enforceContextLimit(c *gin.Context, req *providers.ChatCompletionRequest) bool {
+	config := p.config.Snapshot()
+	if config.GlobalSettings == nil || config.GlobalSettings.ContextLimits == nil {
+		return true
+	}
+	settings := config.GlobalSettings.ContextLimits
+
+	window := providers.LookupContextWindow(settings.Models, req.Model)
+	if window == 0 {
+		return true
+	}
+	outputTokens := providers.RequestedOutputTokens(req)
+	if outputTokens == 0 {
+		outputTokens = settings.OutputReserve
+	}
+	budget := window - outputTokens
+	promptTokens := providers.EstimatePromptTokens(req)
+	if promptTokens <= budget {
+		return true
+	}
+
+	trace := requestDebugFrom(c)
+	if settings.Policy == internal.ContextPolicyTruncate && budget > 0 {
+		messages, dropped, fits := providers.TruncateMessages(req, budget)
+		if fits {
+			log.Printf("模型 %s 的提示词约 %d token，超出上下文窗口 %d（预留输出 %d），已丢弃最早的 %d 条消息",
+				req.Model, promptTokens, window, outputTokens, dropped)
+			trace.add("request", map[string]interface{}{
+				"prompt_tokens":  promptTokens,
+				"context_window": window,
+				"dropped":        dropped,
+			}, "提示词超出上下文窗口，已截断")
+			req.Messages = messages
+			c.Header(TruncatedMessagesHeader, strconv.Itoa(dropped))
+			return true
+		}
+	}
+
+	trace.add("request", map[string]interface{}{
+		"prompt_tokens":  promptTokens,
+		"context_window": window,
+		"output_tokens":  outputTokens,
+	}, "提示词超出上下文窗口")
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error": gin.H{
+			"message": fmt.Sprintf("This model's maximum context length is %d tokens. However, you requested about %d tokens (%d in the messages, %d in the completion). Please reduce the length of the messages or completion.",
+				window, promptTokens+outputTokens, promptTokens, outputTokens),
+			"type":  "invalid_request_error",
+			"param": "messages",
+			"code":  "context_length_exceeded",
+		},
+	})
+	return false
+}
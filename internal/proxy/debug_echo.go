@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"turnsapi/internal/logger"
+	"turnsapi/internal/providers"
+	"turnsapi/internal/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleDebugEcho 返回代理将要发送到上游的请求内容（经过路由、参数覆盖、模型映射和规范化），不实际调用提供商
+func (p *MultiProviderProxy) HandleDebugEcho(c *gin.Context) {
+	var req providers.ChatCompletionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"message": "Invalid request format",
+				"type":    "invalid_request_error",
+				"code":    "invalid_json",
+			},
+		})
+		return
+	}
+
+	if req.Model == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"message": "Model is required",
+				"type":    "invalid_request_error",
+				"code":    "missing_model",
+			},
+		})
+		return
+	}
+
+	// 获取代理密钥权限
+	var allowedGroups, allowedModels, deniedModels []string
+	var proxyKeyID string
+	if keyInfo, exists := c.Get("key_info"); exists {
+		if proxyKey, ok := keyInfo.(*logger.ProxyKey); ok {
+			allowedGroups = proxyKey.AllowedGroups
+			allowedModels, deniedModels = proxyKey.AllowedModels, proxyKey.DeniedModels
+			proxyKeyID = proxyKey.ID
+		}
+	}
+
+	// 与正式请求相同地解析自动模型别名
+	autoModel, err := p.resolveAutoModel(c, &req, allowedGroups, allowedModels, deniedModels)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"message": err.Error(),
+				"type":    "invalid_request_error",
+				"code":    "auto_model_unavailable",
+			},
+		})
+		return
+	}
+	clientModel := req.Model
+	if autoModel != "" {
+		req.Model = autoModel
+	}
+
+	// 与正式请求使用相同的候选分组计算
+	candidateGroups := p.providerRouter.GetGroupsForModel(req.Model, allowedGroups)
+	if providerGroup := c.GetHeader("X-Provider-Group"); providerGroup != "" {
+		filtered := make([]string, 0, 1)
+		for _, groupID := range candidateGroups {
+			if groupID == providerGroup {
+				filtered = append(filtered, groupID)
+			}
+		}
+		candidateGroups = filtered
+	}
+
+	if len(candidateGroups) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"message": "No provider group available for model '" + req.Model + "'",
+				"type":    "invalid_request_error",
+				"code":    "no_route",
+			},
+		})
+		return
+	}
+
+	// 分组参数覆盖、系统提示词和模型映射按分组生效，逐个候选分组预览实际发送的请求
+	previews := make([]gin.H, 0, len(candidateGroups))
+	var first *debugEchoPreview
+	for _, groupID := range candidateGroups {
+		preview, err := p.previewUpstreamRequest(c, &req, groupID, allowedGroups, proxyKeyID)
+		if err != nil {
+			previews = append(previews, gin.H{"group_id": groupID, "error": err.Error()})
+			continue
+		}
+		if first == nil {
+			first = preview
+		}
+		previews = append(previews, preview.summary())
+	}
+	if first == nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": gin.H{
+				"message": "No candidate group could build an upstream request",
+				"type":    "routing_error",
+				"code":    "route_failed",
+			},
+			"candidates": previews,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"client_model":     clientModel,
+		"auto_model":       autoModel,
+		"upstream_model":   first.upstreamModel,
+		"group_id":         first.routeResult.GroupID,
+		"group_name":       first.routeResult.Group.Name,
+		"provider_type":    first.routeResult.ProviderConfig.ProviderType,
+		"base_url":         first.routeResult.ProviderConfig.BaseURL,
+		"candidate_groups": candidateGroups,
+		"headers":          maskSensitiveHeaders(first.routeResult.ProviderConfig.Headers),
+		"request":          first.body,
+		"candidates":       previews,
+	})
+}
+
+// debugEchoPreview 单个候选分组将要发送到上游的请求
+type debugEchoPreview struct {
+	routeResult   *router.RouteResult
+	upstreamModel string
+	body          interface{}
+}
+
+// summary 返回候选分组预览的响应内容
+func (v *debugEchoPreview) summary() gin.H {
+	return gin.H{
+		"group_id":       v.routeResult.GroupID,
+		"provider_type":  v.routeResult.ProviderConfig.ProviderType,
+		"upstream_model": v.upstreamModel,
+		"request":        v.body,
+	}
+}
+
+// previewUpstreamRequest 路由到指定分组并构建该分组的上游请求，不修改客户端请求
+func (p *MultiProviderProxy) previewUpstreamRequest(c *gin.Context, req *providers.ChatCompletionRequest, groupID string, allowedGroups []string, proxyKeyID string) (*debugEchoPreview, error) {
+	routeResult, err := p.providerRouter.RouteWithRetry(&router.RouteRequest{
+		Model:         req.Model,
+		ProviderGroup: groupID,
+		AllowedGroups: allowedGroups,
+		ProxyKeyID:    proxyKeyID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	upstreamReq := p.buildUpstreamRequest(c, req, routeResult)
+	body, err := routeResult.Provider.TransformRequest(upstreamReq)
+	if err != nil {
+		return nil, err
+	}
+	return &debugEchoPreview{routeResult: routeResult, upstreamModel: upstreamReq.Model, body: body}, nil
+}
+
+// maskSensitiveHeaders 遮蔽头部中的认证信息
+func maskSensitiveHeaders(headers map[string]string) map[string]string {
+	masked := make(map[string]string, len(headers))
+	for key, value := range headers {
+		lowerKey := strings.ToLower(key)
+		if lowerKey == "authorization" || strings.Contains(lowerKey, "api-key") || strings.Contains(lowerKey, "api_key") {
+			masked[key] = "****"
+			continue
+		}
+		masked[key] = value
+	}
+	return masked
+}
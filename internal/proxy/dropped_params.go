@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"log"
+	"strings"
+
+	"turnsapi/internal/providers"
+	"turnsapi/internal/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DroppedParamsHeader 因分组的提供商类型不支持而去除的请求参数，逗号分隔
+const DroppedParamsHeader = "X-TurnsAPI-Dropped-Params"
+
+// dropUnsupportedParams 按参数支持矩阵去除分组的提供商类型不支持的参数，并通过响应头告知客户端
+// 故障转移到其他分组时按当前尝试的分组重新设置响应头
+func (p *MultiProviderProxy) dropUnsupportedParams(c *gin.Context, req *providers.ChatCompletionRequest, routeResult *router.RouteResult) *providers.ChatCompletionRequest {
+	upstreamReq, dropped := providers.DropUnsupportedParams(routeResult.ProviderConfig.ProviderType, req)
+	if c == nil {
+		return upstreamReq
+	}
+	if len(dropped) == 0 {
+		c.Writer.Header().Del(DroppedParamsHeader)
+		return upstreamReq
+	}
+
+	log.Printf("分组 %s（%s）不支持参数 %s，已从请求中去除", routeResult.GroupID, routeResult.ProviderConfig.ProviderType, strings.Join(dropped, ", "))
+	requestDebugFrom(c).add("request", map[string]interface{}{
+		"group":   routeResult.GroupID,
+		"dropped": dropped,
+	}, "去除提供商不支持的参数")
+	c.Header(DroppedParamsHeader, strings.Join(dropped, ","))
+	return upstreamReq
+}
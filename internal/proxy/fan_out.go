@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"turnsapi/internal"
+	"turnsapi/internal/providers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxFanOutChoices 不支持 n 的提供商最多为一个请求并行发送的上游请求数
+func (p *MultiProviderProxy) maxFanOutChoices() int {
+	if settings := p.config.Snapshot().GlobalSettings; settings != nil && settings.MaxFanOutChoices > 0 {
+		return settings.MaxFanOutChoices
+	}
+	return internal.DefaultMaxFanOutChoices
+}
+
+// upstreamRequests 分组处理该请求需要发送的上游请求数：不支持 n 的提供商为每个选择发送一个请求，其他为1
+func (p *MultiProviderProxy) upstreamRequests(groupID string, req *providers.ChatCompletionRequest) int {
+	group, exists := p.config.Snapshot().UserGroups[groupID]
+	if !exists || group == nil || !providers.FansOutChoices(group.ProviderType) {
+		return 1
+	}
+	return providers.ChoiceCount(req)
+}
+
+// limitFanOut 排除需要为 n 个选择发送超过 max_fanout_choices 个上游请求的分组
+// 这些请求只占用一个并发槽位和一次RPM额度，不限制时一个客户端请求可以发出上百个上游请求；全部分组被排除时返回400
+func (p *MultiProviderProxy) limitFanOut(c *gin.Context, req *providers.ChatCompletionRequest, candidateGroups []string) ([]string, bool) {
+	maxChoices := p.maxFanOutChoices()
+	if providers.ChoiceCount(req) <= maxChoices {
+		return candidateGroups, true
+	}
+
+	allowed := make([]string, 0, len(candidateGroups))
+	for _, groupID := range candidateGroups {
+		if p.upstreamRequests(groupID, req) > maxChoices {
+			log.Printf("分组 %s 不支持 n，请求的 %d 个选择超过上限 %d，跳过", groupID, providers.ChoiceCount(req), maxChoices)
+			requestDebugFrom(c).add("route", map[string]interface{}{"group": groupID, "n": providers.ChoiceCount(req)}, "分组需要为每个选择单独发送请求，n 超过上限，跳过")
+			continue
+		}
+		allowed = append(allowed, groupID)
+	}
+	if len(allowed) == 0 {
+		respondError(c, http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"message": fmt.Sprintf("n must not exceed %d for providers that do not support multiple choices", maxChoices),
+				"type":    "invalid_request_error",
+				"param":   "n",
+				"code":    "too_many_choices",
+			},
+		})
+		return nil, false
+	}
+	return allowed, true
+}
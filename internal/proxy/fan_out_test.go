@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"turnsapi/internal"
+	"turnsapi/internal/providers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestLimitFanOut 测试不支持 n 的分组在 n 超过上限时被排除，全部被排除时返回400，TPM按上游请求数预估
+func TestLimitFanOut(t *testing.T) {
+	anthropic := newTestGroup("group_anthropic", nil)
+	anthropic.ProviderType = "anthropic"
+	p := newTestProxy(t, map[string]*internal.UserGroup{
+		"group_openai":    newTestGroup("group_openai", nil),
+		"group_anthropic": anthropic,
+	})
+
+	choices := func(n int) *providers.ChatCompletionRequest {
+		maxTokens := 100
+		return &providers.ChatCompletionRequest{
+			Model:     "gpt-4o",
+			Messages:  []providers.ChatMessage{{Role: "user", Content: "hello"}},
+			N:         &n,
+			MaxTokens: &maxTokens,
+		}
+	}
+	limitFanOut := func(req *providers.ChatCompletionRequest, groups ...string) ([]string, bool, *httptest.ResponseRecorder) {
+		gin.SetMode(gin.TestMode)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		allowed, ok := p.limitFanOut(c, req, groups)
+		return allowed, ok, w
+	}
+
+	if requests := p.upstreamRequests("group_anthropic", choices(3)); requests != 3 {
+		t.Errorf("Anthropic分组应为每个选择发送一个请求，得到 %d", requests)
+	}
+	if requests := p.upstreamRequests("group_openai", choices(3)); requests != 1 {
+		t.Errorf("支持 n 的分组只发送一个请求，得到 %d", requests)
+	}
+	limit := internal.ModelLimit{TPM: 100000}
+	if single, fanned := estimateRequestTokens(choices(1), limit, 1), estimateRequestTokens(choices(3), limit, 3); fanned != 3*single {
+		t.Errorf("TPM预估应按上游请求数计算，单个 %d，三个 %d", single, fanned)
+	}
+
+	if allowed, ok, _ := limitFanOut(choices(internal.DefaultMaxFanOutChoices), "group_anthropic"); !ok || len(allowed) != 1 {
+		t.Errorf("n 不超过上限时不应排除分组，得到 %v", allowed)
+	}
+	if allowed, ok, _ := limitFanOut(choices(10), "group_openai", "group_anthropic"); !ok || len(allowed) != 1 || allowed[0] != "group_openai" {
+		t.Errorf("n 超过上限时只应排除不支持 n 的分组，得到 %v", allowed)
+	}
+
+	_, ok, w := limitFanOut(choices(10), "group_anthropic")
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if ok || w.Code != http.StatusBadRequest || body.Error.Code != "too_many_choices" {
+		t.Errorf("所有分组都被排除时应返回400，status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// 上限可以通过全局设置调整
+	p.config.Snapshot().GlobalSettings = &internal.GlobalSettings{MaxFanOutChoices: 16}
+	if _, ok, _ := limitFanOut(choices(10), "group_anthropic"); !ok {
+		t.Error("提高上限后应允许请求")
+	}
+}
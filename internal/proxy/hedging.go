@@ -0,0 +1,267 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http/httptest"
+	"time"
+
+	"turnsapi/internal/logger"
+	"turnsapi/internal/metrics"
+	"turnsapi/internal/providers"
+	"turnsapi/internal/proxykey"
+	"turnsapi/internal/ratelimit"
+	"turnsapi/internal/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 并行对冲参数
+const (
+	HedgeHeader = "X-TurnsAPI-Hedge" // 对冲请求胜出的分组
+
+	hedgeContextKey = "hedge_attempt"
+)
+
+// errHedgeCancelled 另一个对冲请求已先成功，该请求被取消，不计入密钥和分组的失败
+var errHedgeCancelled = errors.New("hedge_cancelled")
+
+// hedgedRequestsTotal 并行对冲的请求数，按结果统计（primary、secondary 表示胜出的分组，failed 表示都失败）
+var hedgedRequestsTotal = metrics.Default.NewCounterVec(
+	"turnsapi_hedged_requests_total",
+	"Number of requests dispatched to two provider groups in parallel, by result (primary, secondary, failed)",
+	"result")
+
+// hedgeAttempt 对冲中发往一个分组的请求，使用独立的上下文处理并把响应写入缓冲区，胜出后再返回给客户端
+type hedgeAttempt struct {
+	groupID     string
+	apiKey      string
+	release     func()
+	reservation *ratelimit.ModelReservation
+	routeResult *router.RouteResult
+
+	ctx      *gin.Context
+	recorder *httptest.ResponseRecorder
+	cancel   context.CancelCauseFunc
+	err      error
+}
+
+// hedgeEnabled 请求是否使用并行对冲：只对冲非流式请求，代理密钥和模型都需要在对冲设置中
+func (p *MultiProviderProxy) hedgeEnabled(c *gin.Context, req *providers.ChatCompletionRequest) bool {
+	if req.Stream {
+		return false
+	}
+	config := p.config.Snapshot()
+	if config == nil || config.GlobalSettings == nil {
+		return false
+	}
+	settings := config.GlobalSettings.Hedging
+	if settings == nil || !settings.Enabled || !proxykey.ModelAllowed(settings.Models, nil, req.Model) {
+		return false
+	}
+
+	proxyKeyName, proxyKeyID := p.getProxyKeyInfo(c)
+	for _, key := range settings.ProxyKeys {
+		if key != "" && (key == proxyKeyID || key == proxyKeyName) {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareHedge 依次为可用分组的第一个密钥占用并发槽位和模型额度，选出前两个可以发送的分组，选定后占用两个分组的RPM额度
+// 不足两个时释放已占用的容量并返回nil，由调用方按正常顺序轮换重试
+func (p *MultiProviderProxy) prepareHedge(c *gin.Context, req *providers.ChatCompletionRequest, routeReq *router.RouteRequest,
+	availableGroups []string, groupKeys map[string][]string) []*hedgeAttempt {
+	var attempts []*hedgeAttempt
+	for _, groupID := range availableGroups {
+		if len(attempts) == 2 {
+			break
+		}
+		keys := groupKeys[groupID]
+		if len(keys) == 0 {
+			continue
+		}
+		apiKey := keys[0]
+
+		routeResult, err := p.providerRouter.RouteWithRetry(&router.RouteRequest{
+			Model:         req.Model,
+			ProviderGroup: groupID,
+			AllowedGroups: routeReq.AllowedGroups,
+			ProxyKeyID:    routeReq.ProxyKeyID,
+		})
+		if err != nil {
+			log.Printf("对冲分组 %s 路由失败: %v，跳过该分组", groupID, err)
+			continue
+		}
+		if !p.rpmAvailable(groupID) {
+			continue
+		}
+		release, acquired := p.acquireConcurrency(groupID, apiKey)
+		if !acquired {
+			continue
+		}
+		reservation, reserved := p.reserveModelLimit(groupID, req)
+		if !reserved {
+			release()
+			continue
+		}
+		attempts = append(attempts, &hedgeAttempt{
+			groupID:     groupID,
+			apiKey:      apiKey,
+			release:     release,
+			reservation: reservation,
+			routeResult: routeResult,
+		})
+	}
+
+	// 确定发送对冲请求后才占用两个分组的RPM额度
+	if len(attempts) == 2 && p.allowRPM(attempts[0].groupID) && p.allowRPM(attempts[1].groupID) {
+		return attempts
+	}
+	for _, attempt := range attempts {
+		attempt.release()
+		attempt.reservation.Commit(0)
+	}
+	return nil
+}
+
+// runHedge 同时发送对冲请求，第一个成功的响应返回给客户端并取消其余请求
+// 返回胜出的请求；全部失败时返回nil和最后一个失败的请求
+func (p *MultiProviderProxy) runHedge(c *gin.Context, req *providers.ChatCompletionRequest, attempts []*hedgeAttempt,
+	startTime time.Time) (*hedgeAttempt, *hedgeAttempt) {
+	groups := make([]string, len(attempts))
+	for i, attempt := range attempts {
+		groups[i] = attempt.groupID
+	}
+	log.Printf("并行对冲：同时发送到分组 %v", groups)
+	requestDebugFrom(c).add("hedge", map[string]interface{}{"groups": groups}, "并行对冲")
+
+	results := make(chan *hedgeAttempt, len(attempts))
+	for i, attempt := range attempts {
+		attempt.ctx, attempt.recorder, attempt.cancel = newHedgeContext(c)
+		go p.runHedgeAttempt(attempt, req, i+1, startTime, results)
+	}
+
+	var failed *hedgeAttempt
+	for range attempts {
+		attempt := <-results
+		if attempt.err != nil {
+			failed = attempt
+			continue
+		}
+
+		for _, other := range attempts {
+			if other != attempt {
+				other.cancel(errHedgeCancelled)
+			}
+		}
+		result := "secondary"
+		if attempt == attempts[0] {
+			result = "primary"
+		}
+		hedgedRequestsTotal.Inc(result)
+		log.Printf("并行对冲：分组 %s 先返回成功响应，取消其余请求", attempt.groupID)
+
+		header := c.Writer.Header()
+		for name, values := range attempt.recorder.Header() {
+			header[name] = values
+		}
+		header.Set(HedgeHeader, attempt.groupID)
+		c.Status(attempt.recorder.Code)
+		c.Writer.Write(attempt.recorder.Body.Bytes())
+		return attempt, nil
+	}
+
+	hedgedRequestsTotal.Inc("failed")
+	log.Printf("并行对冲：分组 %v 全部失败", groups)
+	return nil, failed
+}
+
+// runHedgeAttempt 在独立的上下文中发送一个对冲请求，完成后释放容量并更新密钥和分组状态
+func (p *MultiProviderProxy) runHedgeAttempt(attempt *hedgeAttempt, req *providers.ChatCompletionRequest, index int,
+	startTime time.Time, results chan<- *hedgeAttempt) {
+	c := attempt.ctx
+	p.providerRouter.UpdateProviderConfig(attempt.routeResult.ProviderConfig, attempt.apiKey)
+
+	attemptStart := time.Now()
+	attemptSpan := startAttemptSpan(c, attempt.groupID, p.maskKey(attempt.apiKey), index)
+	err := p.handleNonStreamingRequest(c, req, attempt.routeResult, attempt.apiKey, startTime)
+	endAttemptSpan(c, attemptSpan, err)
+	attempt.release()
+	p.commitModelLimit(c, attempt.reservation, err)
+	p.runPostResponseHooks(c, err)
+	attempt.err = err
+
+	switch {
+	case err == nil:
+		p.keyManager.ReportSuccess(attempt.groupID, attempt.apiKey)
+		p.providerRouter.RecordGroupSuccess(attempt.groupID)
+		p.modelLatency.observe(req.Model, time.Since(attemptStart))
+		p.updateKeyStatusInDatabase(attempt.groupID, attempt.apiKey, true, "")
+	case errors.Is(err, errHedgeCancelled):
+	default:
+		category := providers.ClassifyError(err)
+		log.Printf("对冲请求失败：分组 %s 密钥 %s（错误分类: %s）", attempt.groupID, p.maskKey(attempt.apiKey), category)
+		requestDebugFrom(c).add("hedge", map[string]interface{}{
+			"group":       attempt.groupID,
+			"key":         p.maskKey(attempt.apiKey),
+			"category":    string(category),
+			"status_code": providers.StatusCodeFromError(err),
+			"error":       err.Error(),
+		}, "对冲请求失败")
+		if !category.RequestSpecific() {
+			p.updateKeyStatusInDatabase(attempt.groupID, attempt.apiKey, false, err.Error())
+			p.providerRouter.RecordGroupFailure(attempt.groupID, err)
+		}
+	}
+	results <- attempt
+}
+
+// newHedgeContext 为对冲请求创建独立的上下文：复制请求和上下文中的值，响应写入缓冲区
+// 上游请求不随客户端断开而取消（与普通非流式请求一致），只在另一个对冲请求胜出时取消
+func newHedgeContext(c *gin.Context) (*gin.Context, *httptest.ResponseRecorder, context.CancelCauseFunc) {
+	recorder := httptest.NewRecorder()
+	hc, _ := gin.CreateTestContext(recorder)
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(c.Request.Context()))
+	hc.Request = c.Request.WithContext(ctx)
+	hc.Params = c.Params
+	hc.Keys = make(map[string]any, len(c.Keys)+1)
+	for key, value := range c.Keys {
+		hc.Keys[key] = value
+	}
+	hc.Keys[hedgeContextKey] = true
+	return hc, recorder, cancel
+}
+
+// upstreamBaseContext 非流式上游请求的基础context，对冲请求使用可被取消的上下文，其余请求不随客户端断开而取消
+func upstreamBaseContext(c *gin.Context) context.Context {
+	if c.GetBool(hedgeContextKey) {
+		return c.Request.Context()
+	}
+	return context.Background()
+}
+
+// hedgeCancelled 对冲请求是否因另一个请求先成功而被取消
+func hedgeCancelled(c *gin.Context) bool {
+	return c.GetBool(hedgeContextKey) && errors.Is(context.Cause(c.Request.Context()), errHedgeCancelled)
+}
+
+// logHedgeCancelled 记录被取消的对冲请求，上游通常已按输入计费，因此按估算的输入token数记录用量
+func (p *MultiProviderProxy) logHedgeCancelled(c *gin.Context, req *providers.ChatCompletionRequest, routeResult *router.RouteResult,
+	apiKey string, startTime time.Time) {
+	if p.requestLogger == nil {
+		return
+	}
+	tokens := providers.EstimatePromptTokens(req)
+	respBody, _ := json.Marshal(map[string]interface{}{
+		"usage": providers.Usage{PromptTokens: tokens, TotalTokens: tokens},
+	})
+	proxyKeyName, proxyKeyID := p.getProxyKeyInfo(c)
+	reqBody, _ := json.Marshal(req)
+	clientIP := logger.GetClientIP(c)
+	splitName, splitArm := trafficSplitLogFields(c)
+	p.requestLogger.LogRequestWithRequestID(proxyKeyName, proxyKeyID, routeResult.GroupID, apiKey, req.Model, string(reqBody), string(respBody), clientIP, statusClientClosedRequest, false, time.Since(startTime), errHedgeCancelled, nil, requestDebugFrom(c).json(), "", false, splitName, splitArm, c.GetString("request_id"))
+}
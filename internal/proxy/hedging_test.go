@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/keymanager"
+	"turnsapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+const hedgeTestResponse = `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o",` +
+	`"choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],` +
+	`"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`
+
+// newHedgeTestProxy 创建对 hedge-key 密钥的 gpt-4o 请求启用并行对冲的代理
+func newHedgeTestProxy(t *testing.T, groups map[string]*internal.UserGroup) *MultiProviderProxy {
+	t.Helper()
+	cfg := &internal.Config{
+		UserGroups: groups,
+		GlobalSettings: &internal.GlobalSettings{
+			Hedging: &internal.HedgingSettings{Enabled: true, ProxyKeys: []string{"hedge-key"}, Models: []string{"gpt-4o"}},
+		},
+	}
+	return NewMultiProviderProxy(cfg, keymanager.NewMultiGroupKeyManager(cfg), nil)
+}
+
+// serveHedgeRequest 以 hedge-key 密钥发送一个非流式请求
+func serveHedgeRequest(p *MultiProviderProxy) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hello hedging"}]}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("proxy_key_name", "hedge-key")
+	c.Set("proxy_key_id", "pk-hedge")
+	p.HandleChatCompletion(c)
+	return w
+}
+
+// TestHedgeWinnerCancelsLoser 测试先成功的分组胜出，另一个请求被取消，按估算的输入token以499记录且不计入分组失败
+func TestHedgeWinnerCancelsLoser(t *testing.T) {
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(hedgeTestResponse))
+	}))
+	defer fast.Close()
+
+	cancelled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 读完请求体后服务端才能感知客户端断开
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+			w.Write([]byte(hedgeTestResponse))
+		}
+	}))
+	defer slow.Close()
+
+	groupFast := newTestGroup("group_fast", nil)
+	groupFast.BaseURL = fast.URL
+	groupSlow := newTestGroup("group_slow", nil)
+	groupSlow.BaseURL = slow.URL
+	p := newHedgeTestProxy(t, map[string]*internal.UserGroup{"group_fast": groupFast, "group_slow": groupSlow})
+
+	requestLogger, err := logger.NewRequestLogger(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("创建请求日志失败: %v", err)
+	}
+	defer requestLogger.Close()
+	p.requestLogger = requestLogger
+
+	w := serveHedgeRequest(p)
+	if w.Code != http.StatusOK {
+		t.Fatalf("请求应成功，status=%d body=%s", w.Code, w.Body.String())
+	}
+	if winner := w.Header().Get(HedgeHeader); winner != "group_fast" {
+		t.Errorf("应由 group_fast 胜出，%s=%q", HedgeHeader, winner)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("胜出后应取消另一个分组的上游请求")
+	}
+
+	// 被取消的请求在后台记录日志
+	var cancelledLog *logger.RequestLogSummary
+	deadline := time.Now().Add(3 * time.Second)
+	for cancelledLog == nil && time.Now().Before(deadline) {
+		logs, err := requestLogger.GetRequestLogs("", "group_slow", 10, 0)
+		if err != nil {
+			t.Fatalf("查询请求日志失败: %v", err)
+		}
+		if len(logs) > 0 {
+			cancelledLog = logs[0]
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cancelledLog == nil {
+		t.Fatal("被取消的对冲请求应记录日志")
+	}
+	if cancelledLog.StatusCode != statusClientClosedRequest || cancelledLog.TokensUsed <= 0 {
+		t.Errorf("被取消的请求应以499和估算的输入token记录，status=%d tokens=%d", cancelledLog.StatusCode, cancelledLog.TokensUsed)
+	}
+
+	for _, state := range p.providerRouter.GetFailureStates() {
+		if state.GroupID == "group_slow" && state.FailureScore > 0 {
+			t.Errorf("被取消的请求不应计入分组失败: %+v", state)
+		}
+	}
+}
+
+// TestHedgeFallsBackWithOneAvailableGroup 测试可发送的分组不足两个时不对冲，释放已占用的容量后按正常顺序处理
+func TestHedgeFallsBackWithOneAvailableGroup(t *testing.T) {
+	var served []string
+	var mu sync.Mutex
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		served = append(served, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(hedgeTestResponse))
+	}))
+	defer upstream.Close()
+
+	groups := map[string]*internal.UserGroup{}
+	for _, groupID := range []string{"group_a", "group_b"} {
+		group := newTestGroup(groupID, nil)
+		group.BaseURL = upstream.URL
+		group.MaxConcurrent = 1
+		group.RPMLimit = 10
+		groups[groupID] = group
+	}
+	p := newHedgeTestProxy(t, groups)
+
+	// 占满 group_a 的并发槽位，只剩 group_b 可以发送
+	release, acquired := p.acquireConcurrency("group_a", "sk-group_a")
+	if !acquired {
+		t.Fatal("占用 group_a 的并发槽位失败")
+	}
+	defer release()
+
+	w := serveHedgeRequest(p)
+	if w.Code != http.StatusOK {
+		t.Fatalf("请求应由 group_b 处理，status=%d body=%s", w.Code, w.Body.String())
+	}
+	if hedged := w.Header().Get(HedgeHeader); hedged != "" {
+		t.Errorf("只有一个可用分组时不应对冲，%s=%q", HedgeHeader, hedged)
+	}
+	if len(served) != 1 || served[0] != "Bearer sk-group_b" {
+		t.Errorf("只有 group_b 应收到请求，得到 %v", served)
+	}
+	if current := p.groupConcurrency.Current("group_b"); current != 0 {
+		t.Errorf("放弃对冲后应释放 group_b 的并发槽位，当前为 %d", current)
+	}
+	total := 0
+	for groupID := range groups {
+		current, _, _ := p.rpmLimiter.GetStats(groupID)
+		total += current
+	}
+	if total != 1 {
+		t.Errorf("放弃对冲时不应占用RPM额度，共占用 %d", total)
+	}
+}
+
+// TestHedgeBothFailedRetriesNextKeys 测试两个对冲请求都失败后继续重试时跳过已使用的密钥
+func TestHedgeBothFailedRetriesNextKeys(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":{"message":"upstream overloaded","type":"server_error"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(hedgeTestResponse))
+	}))
+	defer upstream.Close()
+
+	groups := map[string]*internal.UserGroup{}
+	for _, groupID := range []string{"group_a", "group_b"} {
+		group := newTestGroup(groupID, nil)
+		group.BaseURL = upstream.URL
+		group.APIKeys = []string{"sk-" + groupID + "-1", "sk-" + groupID + "-2"}
+		group.RetryPolicy = &internal.RetryPolicy{MaxAttempts: 4, InitialBackoffMs: 1, MaxBackoffMs: 1}
+		groups[groupID] = group
+	}
+	p := newHedgeTestProxy(t, groups)
+
+	requestLogger, err := logger.NewRequestLogger(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("创建请求日志失败: %v", err)
+	}
+	defer requestLogger.Close()
+	p.requestLogger = requestLogger
+
+	w := serveHedgeRequest(p)
+	if w.Code != http.StatusOK {
+		t.Fatalf("对冲失败后重试应成功，status=%d body=%s", w.Code, w.Body.String())
+	}
+	if hedged := w.Header().Get(HedgeHeader); hedged != "" {
+		t.Errorf("重试成功的响应不应带有对冲头部，%s=%q", HedgeHeader, hedged)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Fatalf("应发送两个对冲请求和一次重试，得到 %d 次上游请求", got)
+	}
+
+	// 日志中的密钥按掩码记录，末尾的分组名和序号可以区分不同的密钥
+	var logs []*logger.RequestLogSummary
+	deadline := time.Now().Add(3 * time.Second)
+	for len(logs) < 3 && time.Now().Before(deadline) {
+		if logs, err = requestLogger.GetRequestLogs("", "", 10, 0); err != nil {
+			t.Fatalf("查询请求日志失败: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(logs) != 3 {
+		t.Fatalf("应记录三条请求日志，得到 %d 条", len(logs))
+	}
+	failedKeys := map[string]bool{}
+	failedGroups := map[string]bool{}
+	var retryKey string
+	for _, entry := range logs {
+		if entry.StatusCode == http.StatusOK {
+			retryKey = entry.OpenRouterKey
+			continue
+		}
+		failedKeys[entry.OpenRouterKey] = true
+		failedGroups[entry.ProviderGroup] = true
+	}
+	if !failedGroups["group_a"] || !failedGroups["group_b"] {
+		t.Errorf("对冲请求应分别发往两个分组并失败，失败的分组为 %v", failedGroups)
+	}
+	if retryKey == "" || failedKeys[retryKey] {
+		t.Errorf("重试不应再使用对冲时失败的密钥，重试密钥 %q，失败的密钥 %v", retryKey, failedKeys)
+	}
+}
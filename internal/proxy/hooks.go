@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"turnsapi/internal/hooks"
+	"turnsapi/internal/providers"
+	"turnsapi/internal/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hookAttemptKey 上下文中本次上游尝试的钩子状态，尝试结束后调用后置钩子
+const hookAttemptKey = "hook_attempt"
+
+// hookAttempt 单次上游尝试的钩子和请求信息
+type hookAttempt struct {
+	chain   *hooks.Chain
+	request *hooks.Request
+	stream  bool
+	start   time.Time
+}
+
+// runPreRequestHooks 调用分组启用的前置钩子，钩子可以修改发往上游的请求和添加请求头
+// 返回带有钩子请求头的上下文；钩子拒绝请求时返回请求类错误，不计入密钥和分组的失败，也不再重试
+func (p *MultiProviderProxy) runPreRequestHooks(ctx context.Context, c *gin.Context, routeResult *router.RouteResult, upstreamReq *providers.ChatCompletionRequest) (context.Context, error) {
+	group, exists := p.config.Snapshot().UserGroups[routeResult.GroupID]
+	if !exists || group == nil {
+		return ctx, nil
+	}
+	chain := hooks.Resolve(group.Hooks)
+	if chain == nil {
+		return ctx, nil
+	}
+
+	// 复制消息列表，钩子改写提示词时不影响客户端原始请求（用于日志和其他分组的重试）
+	upstreamReq.Messages = append([]providers.ChatMessage(nil), upstreamReq.Messages...)
+	_, proxyKeyID := p.getProxyKeyInfo(c)
+	request := &hooks.Request{
+		GroupID:      routeResult.GroupID,
+		ProviderType: group.ProviderType,
+		ProxyKeyID:   proxyKeyID,
+		RequestID:    c.GetString("request_id"),
+		Body:         upstreamReq,
+		Header:       make(http.Header),
+		Metadata:     make(map[string]string),
+	}
+	c.Set(hookAttemptKey, &hookAttempt{chain: chain, request: request, stream: upstreamReq.Stream, start: time.Now()})
+
+	if err := chain.PreRequest(ctx, request); err != nil {
+		return ctx, &providers.ToolCallError{
+			Type:       "invalid_request_error",
+			Code:       "hook_rejected",
+			Message:    err.Error(),
+			StatusCode: http.StatusBadRequest,
+			Category:   providers.ErrorCategoryInvalidRequest,
+		}
+	}
+	return providers.WithRequestHeaders(ctx, request.Header), nil
+}
+
+// runPostResponseHooks 上游尝试结束后调用后置钩子，没有调用过前置钩子时不做处理
+// 使用不随客户端断开而取消的上下文，计费等处理在客户端断开后仍然执行
+func (p *MultiProviderProxy) runPostResponseHooks(c *gin.Context, err error) {
+	value, exists := c.Get(hookAttemptKey)
+	if !exists {
+		return
+	}
+	attempt, ok := value.(*hookAttempt)
+	if !ok || attempt == nil {
+		return
+	}
+	c.Set(hookAttemptKey, (*hookAttempt)(nil))
+
+	response := &hooks.Response{
+		Stream:   attempt.stream,
+		Duration: time.Since(attempt.start),
+		Err:      err,
+	}
+	if err == nil {
+		response.TotalTokens = c.GetInt(upstreamUsageTokensKey)
+	}
+	attempt.chain.PostResponse(withTraceContext(context.Background(), c), attempt.request, response)
+}
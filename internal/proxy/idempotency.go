@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/database"
+	"turnsapi/internal/providers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 幂等请求参数
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"     // 客户端提供幂等键的请求头
+	IdempotentReplayedHeader  = "Idempotent-Replayed" // 返回保存的响应时设置为 true
+	defaultIdempotencyTTL     = time.Hour
+	defaultIdempotencyMaxBody = 1 << 20
+	idempotencyLease          = 10 * time.Minute // 处理中的记录的有效期，实例在处理中退出时到期后可重新请求
+	maxIdempotencyKeyLength   = 255
+)
+
+// IdempotencyStore 幂等请求记录的存储，默认为数据库，启用Redis共享状态时为Redis
+type IdempotencyStore interface {
+	ClaimIdempotencyKey(key, bodyHash string, lease time.Duration) (*database.IdempotencyRecord, error)
+	SaveIdempotencyResponse(record database.IdempotencyRecord, ttl time.Duration) error
+	ReleaseIdempotencyKey(key string) error
+}
+
+// idempotencySettings 读取幂等请求设置，未启用时返回nil
+func idempotencySettings(config *internal.Config) *internal.IdempotencySettings {
+	if config == nil || config.GlobalSettings == nil || config.GlobalSettings.Idempotency == nil ||
+		!config.GlobalSettings.Idempotency.Enabled {
+		return nil
+	}
+	return config.GlobalSettings.Idempotency
+}
+
+// idempotencyRecorder 记录写入客户端的响应体，超出上限后停止记录
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+// Write 写入响应并记录
+func (w *idempotencyRecorder) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString 写入响应并记录
+func (w *idempotencyRecorder) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// record 记录响应体，超出上限时丢弃已记录的内容
+func (w *idempotencyRecorder) record(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(data) > w.limit {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
+
+// idempotentRequest 占用了幂等键的请求，请求结束后保存或释放
+type idempotentRequest struct {
+	store    IdempotencyStore
+	key      string
+	bodyHash string
+	ttl      time.Duration
+	recorder *idempotencyRecorder
+}
+
+// beginIdempotentRequest 处理带有 Idempotency-Key 的请求
+// 时间窗口内已有相同请求的响应时直接返回，返回 handled 为true；第一次请求时占用幂等键并记录响应，请求结束后调用 finish
+// 幂等键按代理密钥区分，不同调用方使用相同的键互不影响
+func (p *MultiProviderProxy) beginIdempotentRequest(c *gin.Context, req *providers.ChatCompletionRequest, proxyKeyID string) (*idempotentRequest, bool) {
+	settings := idempotencySettings(p.config.Snapshot())
+	idempotencyKey := c.GetHeader(IdempotencyKeyHeader)
+	if settings == nil || idempotencyKey == "" || p.idempotencyStore == nil {
+		return nil, false
+	}
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		respondIdempotencyError(c, http.StatusBadRequest, "invalid_idempotency_key", "Idempotency-Key must not exceed 255 characters")
+		return nil, true
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, false
+	}
+	keyHash := sha256.Sum256([]byte(proxyKeyID + "\x00" + idempotencyKey))
+	bodyHash := sha256.Sum256(body)
+	request := &idempotentRequest{
+		store:    p.idempotencyStore,
+		key:      hex.EncodeToString(keyHash[:]),
+		bodyHash: hex.EncodeToString(bodyHash[:]),
+		ttl:      settings.TTL,
+	}
+	if request.ttl <= 0 {
+		request.ttl = defaultIdempotencyTTL
+	}
+
+	record, err := request.store.ClaimIdempotencyKey(request.key, request.bodyHash, idempotencyLease)
+	if err != nil {
+		// 存储不可用时按普通请求处理
+		log.Printf("警告: 幂等键存储不可用，按普通请求处理: %v", err)
+		return nil, false
+	}
+	if record != nil {
+		switch {
+		case record.BodyHash != request.bodyHash:
+			respondIdempotencyError(c, http.StatusUnprocessableEntity, "idempotency_key_reused",
+				"Idempotency-Key has already been used with a different request body")
+		case !record.Completed:
+			respondIdempotencyError(c, http.StatusConflict, "idempotency_request_in_progress",
+				"A request with this Idempotency-Key is still being processed, retry later")
+		default:
+			log.Printf("幂等键重复请求，返回保存的响应（状态码 %d）", record.StatusCode)
+			c.Header(IdempotentReplayedHeader, "true")
+			c.Data(record.StatusCode, record.ContentType, record.Body)
+		}
+		return nil, true
+	}
+
+	maxBody := settings.MaxBodyBytes
+	if maxBody <= 0 {
+		maxBody = defaultIdempotencyMaxBody
+	}
+	request.recorder = &idempotencyRecorder{ResponseWriter: c.Writer, limit: maxBody}
+	c.Writer = request.recorder
+	return request, false
+}
+
+// finish 请求成功时保存响应，失败、客户端断开或响应过大时释放幂等键，客户端重试时重新发往上游
+// success 为请求处理的结果，流式响应在发送200后仍可能失败，只看状态码会保存不完整的响应
+func (r *idempotentRequest) finish(c *gin.Context, success bool) {
+	if r == nil {
+		return
+	}
+	status := c.Writer.Status()
+	if success && status >= 200 && status < 300 && !r.recorder.overflow && !clientDisconnected(c) {
+		err := r.store.SaveIdempotencyResponse(database.IdempotencyRecord{
+			Key:         r.key,
+			BodyHash:    r.bodyHash,
+			StatusCode:  status,
+			ContentType: c.Writer.Header().Get("Content-Type"),
+			Body:        r.recorder.body.Bytes(),
+		}, r.ttl)
+		if err == nil {
+			return
+		}
+		log.Printf("警告: 保存幂等请求的响应失败: %v", err)
+	}
+	if err := r.store.ReleaseIdempotencyKey(r.key); err != nil {
+		log.Printf("警告: 释放幂等键失败: %v", err)
+	}
+}
+
+// respondIdempotencyError 返回幂等键相关的错误
+func respondIdempotencyError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    "invalid_request_error",
+			"code":    code,
+		},
+	})
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/database"
+	"turnsapi/internal/providers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memoryIdempotencyStore 内存中的幂等记录存储，语义与数据库存储一致
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]database.IdempotencyRecord
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{records: make(map[string]database.IdempotencyRecord)}
+}
+
+func (s *memoryIdempotencyStore) ClaimIdempotencyKey(key, bodyHash string, lease time.Duration) (*database.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, exists := s.records[key]; exists && time.Now().Before(record.ExpiresAt) {
+		return &record, nil
+	}
+	s.records[key] = database.IdempotencyRecord{Key: key, BodyHash: bodyHash, ExpiresAt: time.Now().Add(lease)}
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) SaveIdempotencyResponse(record database.IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record.Completed = true
+	record.ExpiresAt = time.Now().Add(ttl)
+	s.records[record.Key] = record
+	return nil
+}
+
+func (s *memoryIdempotencyStore) ReleaseIdempotencyKey(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, exists := s.records[key]; exists && !record.Completed {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+// newIdempotencyTestProxy 创建启用幂等键的代理
+func newIdempotencyTestProxy(maxBody int) (*MultiProviderProxy, *memoryIdempotencyStore) {
+	store := newMemoryIdempotencyStore()
+	cfg := &internal.Config{
+		GlobalSettings: &internal.GlobalSettings{
+			Idempotency: &internal.IdempotencySettings{Enabled: true, TTL: time.Minute, MaxBodyBytes: maxBody},
+		},
+	}
+	return &MultiProviderProxy{config: cfg, idempotencyStore: store}, store
+}
+
+// idempotentCall 模拟一次带幂等键的请求，handler 写入响应并返回处理是否成功，请求被直接处理时不调用 handler
+func idempotentCall(p *MultiProviderProxy, key, content string, handler func(c *gin.Context) bool) (*httptest.ResponseRecorder, bool) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	c.Request.Header.Set(IdempotencyKeyHeader, key)
+
+	req := &providers.ChatCompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []providers.ChatMessage{{Role: "user", Content: content}},
+	}
+	idempotent, handled := p.beginIdempotentRequest(c, req, "proxy-key-1")
+	if handled {
+		return w, true
+	}
+	success := handler(c)
+	idempotent.finish(c, success)
+	return w, false
+}
+
+// respondOK 返回成功响应
+func respondOK(body string) func(c *gin.Context) bool {
+	return func(c *gin.Context) bool {
+		c.Data(http.StatusOK, "application/json", []byte(body))
+		return true
+	}
+}
+
+// TestIdempotencyReplay 测试相同的键和请求体返回保存的响应，不同的请求体返回422
+func TestIdempotencyReplay(t *testing.T) {
+	p, _ := newIdempotencyTestProxy(0)
+
+	if w, handled := idempotentCall(p, "key-1", "hello", respondOK(`{"id":"first"}`)); handled || w.Code != http.StatusOK {
+		t.Fatalf("第一次请求应发往上游，handled=%v status=%d", handled, w.Code)
+	}
+
+	w, handled := idempotentCall(p, "key-1", "hello", func(c *gin.Context) bool {
+		t.Fatal("重复请求不应发往上游")
+		return false
+	})
+	if !handled || w.Code != http.StatusOK || w.Body.String() != `{"id":"first"}` {
+		t.Fatalf("重复请求应返回保存的响应，handled=%v status=%d body=%s", handled, w.Code, w.Body.String())
+	}
+	if w.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("返回保存的响应时应设置 %s", IdempotentReplayedHeader)
+	}
+
+	w, handled = idempotentCall(p, "key-1", "different", respondOK(`{}`))
+	if !handled || w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "idempotency_key_reused") {
+		t.Errorf("相同的键用于不同请求体应返回422，status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// 不同的幂等键互不影响
+	if w, handled := idempotentCall(p, "key-2", "hello", respondOK(`{"id":"other"}`)); handled || w.Body.String() != `{"id":"other"}` {
+		t.Errorf("不同的键应发往上游，handled=%v body=%s", handled, w.Body.String())
+	}
+}
+
+// TestIdempotencyInProgress 测试第一次请求处理中时重复请求返回409
+func TestIdempotencyInProgress(t *testing.T) {
+	p, _ := newIdempotencyTestProxy(0)
+
+	idempotentCall(p, "key-1", "hello", func(c *gin.Context) bool {
+		w, handled := idempotentCall(p, "key-1", "hello", respondOK(`{}`))
+		if !handled || w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "idempotency_request_in_progress") {
+			t.Errorf("处理中的重复请求应返回409，status=%d body=%s", w.Code, w.Body.String())
+		}
+		c.Data(http.StatusOK, "application/json", []byte(`{}`))
+		return true
+	})
+}
+
+// TestIdempotencyReleaseOnFailure 测试请求失败时释放幂等键，即使流式响应已经发送了200
+func TestIdempotencyReleaseOnFailure(t *testing.T) {
+	p, store := newIdempotencyTestProxy(0)
+
+	idempotentCall(p, "key-1", "hello", func(c *gin.Context) bool {
+		c.Status(http.StatusOK)
+		c.Writer.WriteString("data: {\"partial\":true}\n\n")
+		return false
+	})
+	if len(store.records) != 0 {
+		t.Fatalf("失败的请求应释放幂等键，剩余记录: %d", len(store.records))
+	}
+
+	if w, handled := idempotentCall(p, "key-1", "hello", respondOK(`{"id":"retry"}`)); handled || w.Body.String() != `{"id":"retry"}` {
+		t.Errorf("释放后重试应重新发往上游，handled=%v body=%s", handled, w.Body.String())
+	}
+}
+
+// TestIdempotencyOversizedBody 测试响应超过大小上限时不保存并释放幂等键
+func TestIdempotencyOversizedBody(t *testing.T) {
+	p, store := newIdempotencyTestProxy(8)
+
+	w, _ := idempotentCall(p, "key-1", "hello", respondOK(`{"id":"too-large"}`))
+	if w.Body.String() != `{"id":"too-large"}` {
+		t.Errorf("超出上限的响应仍应完整返回给客户端，得到 %s", w.Body.String())
+	}
+	if len(store.records) != 0 {
+		t.Errorf("超出上限的响应不应保存，剩余记录: %d", len(store.records))
+	}
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"log"
+	"sync"
+
+	"turnsapi/internal/metrics"
+)
+
+// 密钥池耗尽指标，用于按耗尽事件而不是单个请求失败告警
+var (
+	keyPoolExhaustedTotal = metrics.Default.NewCounterVec(
+		"turnsapi_key_pool_exhausted_total",
+		"Number of requests that found no usable API key in a provider group",
+		"group", "model")
+	keyPoolExhausted = metrics.Default.NewGaugeVec(
+		"turnsapi_key_pool_exhausted",
+		"Whether all API keys of a provider group are currently unusable (1) or not (0)",
+		"group")
+)
+
+// keyPoolTracker 跟踪各分组密钥池的耗尽状态，只在状态变化时输出事件日志
+type keyPoolTracker struct {
+	mu        sync.Mutex
+	exhausted map[string]bool
+}
+
+// newKeyPoolTracker 创建密钥池状态跟踪器
+func newKeyPoolTracker() *keyPoolTracker {
+	return &keyPoolTracker{exhausted: make(map[string]bool)}
+}
+
+// recordExhausted 记录分组没有可用密钥
+func (t *keyPoolTracker) recordExhausted(groupID, model string, totalKeys int) {
+	keyPoolExhaustedTotal.Inc(groupID, model)
+	keyPoolExhausted.Set(1, groupID)
+
+	t.mu.Lock()
+	wasExhausted := t.exhausted[groupID]
+	t.exhausted[groupID] = true
+	t.mu.Unlock()
+
+	if !wasExhausted {
+		log.Printf("[KEY_POOL_EXHAUSTED] 分组 %s 的密钥已全部不可用（请求模型: %s，总密钥数: %d）", groupID, model, totalKeys)
+	}
+}
+
+// recordAvailable 记录分组有可用密钥
+func (t *keyPoolTracker) recordAvailable(groupID string) {
+	t.mu.Lock()
+	wasExhausted := t.exhausted[groupID]
+	delete(t.exhausted, groupID)
+	t.mu.Unlock()
+
+	keyPoolExhausted.Set(0, groupID)
+	if wasExhausted {
+		log.Printf("[KEY_POOL_RECOVERED] 分组 %s 已恢复可用密钥", groupID)
+	}
+}
+
+// forget 移除分组的状态，用于分组删除
+func (t *keyPoolTracker) forget(groupID string) {
+	t.mu.Lock()
+	delete(t.exhausted, groupID)
+	t.mu.Unlock()
+
+	keyPoolExhausted.Delete(groupID)
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"turnsapi/internal/metrics"
+)
+
+// metricsText 获取默认指标注册表的文本输出
+func metricsText(t *testing.T) string {
+	t.Helper()
+	var b strings.Builder
+	if err := metrics.Default.WriteText(&b); err != nil {
+		t.Fatalf("输出指标失败: %v", err)
+	}
+	return b.String()
+}
+
+// metricValue 获取指标输出中某个序列的值，没有该序列时返回0
+func metricValue(t *testing.T, series string) float64 {
+	t.Helper()
+	for _, line := range strings.Split(metricsText(t), "\n") {
+		if value, found := strings.CutPrefix(line, series+" "); found {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("解析指标 %s 失败: %v", series, err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+// TestKeyPoolTracker 测试密钥池耗尽和恢复时更新计数器和状态指标，删除分组时移除状态
+func TestKeyPoolTracker(t *testing.T) {
+	tracker := newKeyPoolTracker()
+	const exhaustedTotal = `turnsapi_key_pool_exhausted_total{group="exhaust_group",model="gpt-4o"}`
+	before := metricValue(t, exhaustedTotal)
+
+	tracker.recordExhausted("exhaust_group", "gpt-4o", 2)
+	tracker.recordExhausted("exhaust_group", "gpt-4o", 2)
+	if got := metricValue(t, exhaustedTotal) - before; got != 2 {
+		t.Errorf("每次耗尽都应计数，计数增加了 %v", got)
+	}
+	if text := metricsText(t); !strings.Contains(text, `turnsapi_key_pool_exhausted{group="exhaust_group"} 1`) {
+		t.Errorf("耗尽时状态指标应为1，指标输出:\n%s", text)
+	}
+	if !tracker.exhausted["exhaust_group"] {
+		t.Error("应记录分组处于耗尽状态")
+	}
+
+	tracker.recordAvailable("exhaust_group")
+	if text := metricsText(t); !strings.Contains(text, `turnsapi_key_pool_exhausted{group="exhaust_group"} 0`) {
+		t.Errorf("恢复后状态指标应为0，指标输出:\n%s", text)
+	}
+	if tracker.exhausted["exhaust_group"] {
+		t.Error("恢复后不应再处于耗尽状态")
+	}
+
+	tracker.recordExhausted("exhaust_group", "gpt-4o", 2)
+	tracker.forget("exhaust_group")
+	if text := metricsText(t); strings.Contains(text, `turnsapi_key_pool_exhausted{group="exhaust_group"}`) {
+		t.Errorf("删除分组后应移除状态指标，指标输出:\n%s", text)
+	}
+	if len(tracker.exhausted) != 0 {
+		t.Errorf("删除分组后不应保留状态，得到 %v", tracker.exhausted)
+	}
+}
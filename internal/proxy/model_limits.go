@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"turnsapi/internal"
+	"turnsapi/internal/providers"
+	"turnsapi/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// upstreamUsageTokensKey 上下文中上游响应报告的token总数，用于按实际用量更新模型的TPM窗口
+const upstreamUsageTokensKey = "upstream_usage_tokens"
+
+// modelLimitFor 获取分组对请求模型设置的RPM/TPM限制，按映射后发送到上游的模型名查找
+func (p *MultiProviderProxy) modelLimitFor(groupID string, req *providers.ChatCompletionRequest) (string, internal.ModelLimit, bool) {
+	group, exists := p.config.Snapshot().UserGroups[groupID]
+	if !exists || group == nil || len(group.ModelLimits) == 0 {
+		return "", internal.ModelLimit{}, false
+	}
+	model := p.providerRouter.ResolveModelName(req.Model, groupID)
+	limit, exists := group.ModelLimits[model]
+	if !exists || (limit.RPM <= 0 && limit.TPM <= 0) {
+		return "", internal.ModelLimit{}, false
+	}
+	return model, limit, true
+}
+
+// estimateRequestTokens 预计请求使用的token数：估算的提示词token加上请求的最大输出token
+// 不支持 n 的提供商为每个选择单独发送请求，按上游请求数 requests 计算
+func estimateRequestTokens(req *providers.ChatCompletionRequest, limit internal.ModelLimit, requests int) int {
+	if limit.TPM <= 0 {
+		return 0
+	}
+	return (providers.EstimatePromptTokens(req) + providers.RequestedOutputTokens(req)) * requests
+}
+
+// modelLimitAvailable 检查分组是否还能向该模型发出请求，不占用额度
+func (p *MultiProviderProxy) modelLimitAvailable(groupID string, req *providers.ChatCompletionRequest) bool {
+	model, limit, limited := p.modelLimitFor(groupID, req)
+	if !limited {
+		return true
+	}
+	return p.modelLimiter.Available(groupID, model, limit.RPM, limit.TPM, estimateRequestTokens(req, limit, p.upstreamRequests(groupID, req)))
+}
+
+// reserveModelLimit 占用分组对该模型的一次请求和预计的token数，未设置限制时返回nil
+func (p *MultiProviderProxy) reserveModelLimit(groupID string, req *providers.ChatCompletionRequest) (*ratelimit.ModelReservation, bool) {
+	model, limit, limited := p.modelLimitFor(groupID, req)
+	if !limited {
+		return nil, true
+	}
+	return p.modelLimiter.Reserve(groupID, model, limit.RPM, limit.TPM, estimateRequestTokens(req, limit, p.upstreamRequests(groupID, req)))
+}
+
+// commitModelLimit 请求结束后更新预占的token：成功且上游报告了用量时按实际用量计算，失败时释放
+// 上游没有报告用量（如流式请求未开启 include_usage）时保留预计值
+func (p *MultiProviderProxy) commitModelLimit(c *gin.Context, reservation *ratelimit.ModelReservation, err error) {
+	if reservation == nil {
+		return
+	}
+	if err != nil {
+		reservation.Commit(0)
+		return
+	}
+	if tokens := c.GetInt(upstreamUsageTokensKey); tokens > 0 {
+		reservation.Commit(tokens)
+	}
+}
+
+// streamUsageTokens 从流式响应的最后几个数据块中读取上游报告的token总数，没有用量信息时返回0
+func streamUsageTokens(chunks [][]byte) int {
+	for i := len(chunks) - 1; i >= 0; i-- {
+		for _, line := range bytes.Split(chunks[i], []byte("\n")) {
+			data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+			if !ok {
+				continue
+			}
+			var chunk struct {
+				Usage *providers.Usage `json:"usage"`
+			}
+			if json.Unmarshal(bytes.TrimSpace(data), &chunk) == nil && chunk.Usage != nil && chunk.Usage.TotalTokens > 0 {
+				return chunk.Usage.TotalTokens
+			}
+		}
+	}
+	return 0
+}
+
+// respondModelRateLimited 返回所有候选分组都超出模型RPM/TPM限制的429响应
+func (p *MultiProviderProxy) respondModelRateLimited(c *gin.Context, model string) {
+	c.Header("Retry-After", "1")
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": gin.H{
+			"message": "Rate limit exceeded for model " + model + " in the available provider groups",
+			"type":    "rate_limit_error",
+			"code":    "model_rate_limit_exceeded",
+		},
+	})
+}
+
+// GetModelLimitStats 获取分组内各模型当前窗口的用量
+func (mp *MultiProviderProxy) GetModelLimitStats(groupID string) map[string]ratelimit.ModelUsage {
+	return mp.modelLimiter.Usage(groupID)
+}
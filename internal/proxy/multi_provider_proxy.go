@@ -3,13 +3,17 @@ package proxy
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"turnsapi/internal"
+	"turnsapi/internal/budgets"
 	"turnsapi/internal/database"
 	"turnsapi/internal/keymanager"
 	"turnsapi/internal/logger"
@@ -23,7 +27,7 @@ import (
 
 // MultiProviderProxy 多提供商代理
 type MultiProviderProxy struct {
-	config          *internal.Config
+	config          internal.ConfigSource
 	keyManager      *keymanager.MultiGroupKeyManager
 	proxyKeyManager *proxykey.Manager
 	providerManager *providers.ProviderManager
@@ -31,11 +35,23 @@ type MultiProviderProxy struct {
 	requestLogger   *logger.RequestLogger
 	rpmLimiter      *ratelimit.RPMLimiter
 	database        *database.GroupsDB
+
+	groupConcurrency *ratelimit.ConcurrencyLimiter // 分组并发请求数
+	keyConcurrency   *ratelimit.ConcurrencyLimiter // 密钥并发请求数
+	requestQueue     *ratelimit.PriorityQueue      // 分组容量已满时排队等待的请求
+	modelLimiter     *ratelimit.ModelLimiter       // 分组内按模型的RPM/TPM
+	keyPools         *keyPoolTracker               // 分组密钥池耗尽状态
+	quota            *quotaTracker                 // 提供商额度使用比例和预警
+	stickySessions   *stickySessionStore           // 会话与分组、密钥的粘滞绑定
+	modelLatency     *modelLatencyTracker          // 各模型成功请求的平均耗时
+	scripts          *scriptCache                  // 分组编译后的Lua脚本
+	budgets          *budgets.Engine               // 预算引擎，未设置时不检查预算
+	idempotencyStore IdempotencyStore              // 幂等请求记录，未设置时忽略 Idempotency-Key
 }
 
 // NewMultiProviderProxy 创建多提供商代理
 func NewMultiProviderProxy(
-	config *internal.Config,
+	config internal.ConfigSource,
 	keyManager *keymanager.MultiGroupKeyManager,
 	requestLogger *logger.RequestLogger,
 ) *MultiProviderProxy {
@@ -45,11 +61,13 @@ func NewMultiProviderProxy(
 
 	// 创建提供商路由器
 	providerRouter := router.NewProviderRouter(config, providerManager)
+	quota := newQuotaTracker(config)
+	providerRouter.SetResponseObserver(quota.recordUpstream)
 
 	// 创建RPM限制器并初始化分组限制
 	rpmLimiter := ratelimit.NewRPMLimiter()
-	if config.UserGroups != nil {
-		for groupID, group := range config.UserGroups {
+	if snapshot := config.Snapshot(); snapshot.UserGroups != nil {
+		for groupID, group := range snapshot.UserGroups {
 			if group.RPMLimit > 0 {
 				rpmLimiter.SetLimit(groupID, group.RPMLimit)
 			}
@@ -63,12 +81,22 @@ func NewMultiProviderProxy(
 		providerRouter:  providerRouter,
 		requestLogger:   requestLogger,
 		rpmLimiter:      rpmLimiter,
+
+		groupConcurrency: ratelimit.NewConcurrencyLimiter(),
+		keyConcurrency:   ratelimit.NewConcurrencyLimiter(),
+		requestQueue:     ratelimit.NewPriorityQueue(),
+		keyPools:         newKeyPoolTracker(),
+		quota:            quota,
+		modelLimiter:     ratelimit.NewModelLimiter(),
+		stickySessions:   newStickySessionStore(),
+		modelLatency:     newModelLatencyTracker(),
+		scripts:          newScriptCache(),
 	}
 }
 
 // NewMultiProviderProxyWithProxyKey 创建带代理密钥管理器的多提供商代理
 func NewMultiProviderProxyWithProxyKey(
-	config *internal.Config,
+	config internal.ConfigSource,
 	keyManager *keymanager.MultiGroupKeyManager,
 	proxyKeyManager *proxykey.Manager,
 	requestLogger *logger.RequestLogger,
@@ -76,24 +104,35 @@ func NewMultiProviderProxyWithProxyKey(
 	factory := providers.NewDefaultProviderFactory()
 	providerManager := providers.NewProviderManager(factory)
 	providerRouter := router.NewProviderRouterWithProxyKey(config, providerManager, proxyKeyManager)
+	quota := newQuotaTracker(config)
+	providerRouter.SetResponseObserver(quota.recordUpstream)
 
 	// 创建RPM限制器
 	rpmLimiter := ratelimit.NewRPMLimiter()
 
-	// 为每个分组设置RPM限制
-	for groupID, group := range config.UserGroups {
+	// 为每个分组设置RPM限制（请求时按最新配置快照更新）
+	snapshot := config.Snapshot()
+	for groupID, group := range snapshot.UserGroups {
 		if group.RPMLimit > 0 {
 			rpmLimiter.SetLimit(groupID, group.RPMLimit)
 		}
 	}
 
 	// 初始化数据库连接
-	database, err := database.NewGroupsDB(config.Database.Path)
+	database, err := database.NewGroupsDB(snapshot.Database.Path)
 	if err != nil {
 		log.Printf("Failed to initialize database for proxy: %v", err)
 	}
 
-	return &MultiProviderProxy{
+	// 启用持久化时从数据库恢复分组失败状态
+	if database != nil && snapshot.GlobalSettings != nil &&
+		snapshot.GlobalSettings.RouterFailures != nil && snapshot.GlobalSettings.RouterFailures.Persist {
+		if err := providerRouter.SetFailureStore(database); err != nil {
+			log.Printf("Failed to load router failure states: %v", err)
+		}
+	}
+
+	proxy := &MultiProviderProxy{
 		config:          config,
 		keyManager:      keyManager,
 		proxyKeyManager: proxyKeyManager,
@@ -102,7 +141,21 @@ func NewMultiProviderProxyWithProxyKey(
 		requestLogger:   requestLogger,
 		rpmLimiter:      rpmLimiter,
 		database:        database,
+
+		groupConcurrency: ratelimit.NewConcurrencyLimiter(),
+		keyConcurrency:   ratelimit.NewConcurrencyLimiter(),
+		requestQueue:     ratelimit.NewPriorityQueue(),
+		keyPools:         newKeyPoolTracker(),
+		quota:            quota,
+		modelLimiter:     ratelimit.NewModelLimiter(),
+		stickySessions:   newStickySessionStore(),
+		modelLatency:     newModelLatencyTracker(),
+		scripts:          newScriptCache(),
+	}
+	if database != nil {
+		proxy.idempotencyStore = database
 	}
+	return proxy
 }
 
 // RemoveProvider 从提供商管理器中移除分组
@@ -110,6 +163,15 @@ func (mp *MultiProviderProxy) RemoveProvider(groupID string) {
 	mp.providerManager.RemoveProvider(groupID)
 	// 同时移除RPM限制
 	mp.rpmLimiter.RemoveLimit(groupID)
+	mp.keyPools.forget(groupID)
+	mp.quota.forget(groupID)
+	mp.modelLimiter.Forget(groupID)
+	mp.stickySessions.clear(groupID)
+}
+
+// ResetProvider 丢弃分组缓存的提供商实例，下次请求时按最新配置重建
+func (mp *MultiProviderProxy) ResetProvider(groupID string) {
+	mp.providerManager.RemoveProvider(groupID)
 }
 
 // UpdateRPMLimit 更新分组的RPM限制
@@ -117,11 +179,51 @@ func (mp *MultiProviderProxy) UpdateRPMLimit(groupID string, limit int) {
 	mp.rpmLimiter.SetLimit(groupID, limit)
 }
 
+// GetConcurrencyStats 获取分组当前并发数及各密钥的并发数
+func (mp *MultiProviderProxy) GetConcurrencyStats(groupID string, apiKeys []string) (int, map[string]int) {
+	keyStats := make(map[string]int, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		keyStats[apiKey] = mp.keyConcurrency.Current(keyConcurrencyID(groupID, apiKey))
+	}
+	return mp.groupConcurrency.Current(groupID), keyStats
+}
+
 // GetRPMStats 获取RPM统计信息
 func (mp *MultiProviderProxy) GetRPMStats() map[string]map[string]int {
 	return mp.rpmLimiter.GetAllStats()
 }
 
+// GetQuotaUsage 获取各密钥和分组的额度使用情况及最近的预警事件
+func (mp *MultiProviderProxy) GetQuotaUsage() ([]QuotaStatus, []QuotaEvent) {
+	return mp.quota.snapshot()
+}
+
+// ResetRPMWindow 清空分组当前的RPM窗口和按模型的RPM/TPM窗口，用于误限流后手动恢复
+func (mp *MultiProviderProxy) ResetRPMWindow(groupID string) bool {
+	mp.modelLimiter.Forget(groupID)
+	return mp.rpmLimiter.ResetWindow(groupID)
+}
+
+// GetStickySessions 获取当前的会话粘滞绑定
+func (mp *MultiProviderProxy) GetStickySessions() []StickySession {
+	return mp.stickySessions.snapshot(time.Now())
+}
+
+// ClearStickySessions 删除会话粘滞绑定，groupID为空时删除全部，返回删除的数量
+func (mp *MultiProviderProxy) ClearStickySessions(groupID string) int {
+	return mp.stickySessions.clear(groupID)
+}
+
+// EnableSharedState 启用多实例共享的RPM窗口、分组失败状态和幂等请求记录
+func (mp *MultiProviderProxy) EnableSharedState(rpmStore ratelimit.SharedWindowStore, failureStore router.SharedFailureStore, idempotencyStore IdempotencyStore, syncInterval time.Duration) error {
+	if err := mp.providerRouter.SetSharedFailureStore(failureStore, syncInterval); err != nil {
+		return fmt.Errorf("failed to load shared router failure states: %w", err)
+	}
+	mp.rpmLimiter.SetSharedStore(rpmStore)
+	mp.idempotencyStore = idempotencyStore
+	return nil
+}
+
 // shouldUseNativeResponse 检查是否应该使用原生响应格式
 func (p *MultiProviderProxy) shouldUseNativeResponse(groupID string, c *gin.Context) bool {
 	// 检查是否强制使用原生响应
@@ -132,11 +234,7 @@ func (p *MultiProviderProxy) shouldUseNativeResponse(groupID string, c *gin.Cont
 	}
 
 	// 检查分组配置
-	if p.config.UserGroups == nil {
-		return false
-	}
-
-	group, exists := p.config.UserGroups[groupID]
+	group, exists := p.config.Snapshot().UserGroups[groupID]
 	if !exists {
 		return false
 	}
@@ -152,7 +250,7 @@ func (p *MultiProviderProxy) getNativeResponse(provider providers.Provider, stan
 		return p.convertToGeminiNativeResponse(standardResponse)
 	case "anthropic":
 		return p.convertToAnthropicNativeResponse(standardResponse)
-	case "openai", "azure_openai":
+	case "openai", "azure_openai", "openrouter", "openai_compatible":
 		// OpenAI格式本身就是标准格式，直接返回
 		return standardResponse, nil
 	default:
@@ -269,16 +367,83 @@ func (p *MultiProviderProxy) HandleChatCompletion(c *gin.Context) {
 		return
 	}
 
+	// 客户端指定的超时，超过上限时使用上限
+	if err := applyClientTimeout(c, p.config.Snapshot()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"message": err.Error(),
+				"type":    "invalid_request_error",
+				"code":    "invalid_timeout",
+			},
+		})
+		return
+	}
+
 	// 获取代理密钥信息以检查权限
 	var allowedGroups []string
 	var proxyKeyID string
+	var requestKey *logger.ProxyKey
 	if keyInfo, exists := c.Get("key_info"); exists {
 		if proxyKey, ok := keyInfo.(*logger.ProxyKey); ok {
 			allowedGroups = proxyKey.AllowedGroups
 			proxyKeyID = proxyKey.ID
+			requestKey = proxyKey
 		}
 	}
 
+	// 幂等键：时间窗口内相同的请求直接返回第一次请求的响应
+	idempotent, handled := p.beginIdempotentRequest(c, &req, proxyKeyID)
+	if handled {
+		return
+	}
+	// 只有上游处理成功时才保存响应；流式请求失败时状态码可能已是200，不能按状态码判断
+	succeeded := false
+	defer func() { idempotent.finish(c, succeeded) }()
+
+	// 自动模型别名：从满足质量等级且代理密钥可用的候选模型中选择实际模型
+	var allowedModels, deniedModels []string
+	if requestKey != nil {
+		allowedModels, deniedModels = requestKey.AllowedModels, requestKey.DeniedModels
+	}
+	autoModel, err := p.resolveAutoModel(c, &req, allowedGroups, allowedModels, deniedModels)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"message": fmt.Sprintf("Cannot resolve model '%s': %v", req.Model, err),
+				"type":    "invalid_request_error",
+				"code":    "auto_model_unavailable",
+			},
+		})
+		return
+	}
+	if autoModel != "" {
+		log.Printf("自动模型 %s 选择模型 %s", req.Model, autoModel)
+		req.Model = autoModel
+		c.Header(AutoModelHeader, autoModel)
+	}
+
+	// A/B分流：虚拟模型名或指定代理密钥的请求按权重分配到分支分组
+	splitAssignment := p.assignTrafficSplit(c, &req, proxyKeyID, allowedGroups)
+
+	// 检查代理密钥的模型允许/禁止列表
+	if requestKey != nil && !proxykey.ModelAllowed(allowedModels, deniedModels, req.Model) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": gin.H{
+				"message": fmt.Sprintf("Model '%s' is not allowed for this API key", req.Model),
+				"type":    "permission_error",
+				"code":    "model_not_allowed",
+			},
+		})
+		return
+	}
+
+	// 代理密钥或其所属租户已超出本计费周期的预算
+	if budget, exceeded := p.keyBudgetExceeded(requestKey); exceeded {
+		log.Printf("代理密钥 %s 超出预算 %s（%s %s），拒绝请求", proxyKeyID, budget.ID, budget.Scope, budget.Target)
+		p.respondBudgetExceeded(c, budget)
+		return
+	}
+
 	// 路由到合适的提供商
 	routeReq := &router.RouteRequest{
 		Model:         req.Model,
@@ -298,11 +463,42 @@ func (p *MultiProviderProxy) HandleChatCompletion(c *gin.Context) {
 		}
 	}
 
+	// 请求级调试：被允许的代理密钥可通过请求头只为本次请求记录路由、密钥选择和上游耗时细节
+	if requestDebugEnabled(c, p.config.Snapshot(), requestKey) {
+		trace := startRequestDebug(c, startTime)
+		trace.add("request", map[string]interface{}{
+			"model":          req.Model,
+			"auto_model":     autoModel != "",
+			"stream":         req.Stream,
+			"messages":       len(req.Messages),
+			"provider_group": routeReq.ProviderGroup,
+			"allowed_groups": allowedGroups,
+		}, "收到请求")
+		if splitAssignment != nil {
+			trace.add("split", map[string]interface{}{
+				"split":  splitAssignment.Name,
+				"arm":    splitAssignment.Group,
+				"strict": splitAssignment.Strict,
+			}, "A/B分流分配到分支 %s", splitAssignment.Group)
+		}
+	}
+
+	// 提示词超出模型上下文窗口时按策略截断，或直接返回400而不是等上游报错
+	if !p.enforceContextLimit(c, &req) {
+		return
+	}
+
 	// 使用智能路由重试机制
 	success := p.handleRequestWithRetry(c, &req, routeReq, startTime)
-	if !success {
+	succeeded = success
+	if !success && clientDisconnected(c) {
+		// 客户端已断开，不再返回错误，访问日志按499记录
+		if !c.Writer.Written() {
+			c.Status(statusClientClosedRequest)
+		}
+	} else if !success && (!c.Writer.Written() || streamHeartbeatOnly(c)) {
 		// 如果所有重试都失败了，返回错误
-		c.JSON(http.StatusBadGateway, gin.H{
+		respondError(c, http.StatusBadGateway, gin.H{
 			"error": gin.H{
 				"message": "All provider groups failed to process the request",
 				"type":    "service_unavailable",
@@ -324,7 +520,7 @@ func (p *MultiProviderProxy) handleRequestWithRetry(
 }
 
 // handleRequestWithSmartFailover 实现智能故障转移机制
-// 新策略：优先在分组间轮换重试，最后再在分组内重试，最多重试3个密钥即停止
+// 新策略：优先在分组间轮换重试，最后再在分组内重试，尝试次数由分组的重试策略决定
 func (p *MultiProviderProxy) handleRequestWithSmartFailover(
 	c *gin.Context,
 	req *providers.ChatCompletionRequest,
@@ -332,19 +528,41 @@ func (p *MultiProviderProxy) handleRequestWithSmartFailover(
 	startTime time.Time,
 ) bool {
 	// 获取支持该模型的所有分组
+	routeSpan := startTraceSpan(c, "route")
+	routeSpan.SetAttribute("gen_ai.request.model", req.Model)
 	candidateGroups := p.providerRouter.GetGroupsForModel(req.Model, routeReq.AllowedGroups)
+	if assignment := trafficSplitFrom(c); assignment != nil {
+		candidateGroups = applyTrafficSplit(candidateGroups, assignment)
+		if len(candidateGroups) == 0 {
+			log.Printf("分流 %s 的严格分支 %s 不支持模型 %s", assignment.Name, assignment.Group, req.Model)
+		}
+	}
+	if len(candidateGroups) > 0 {
+		var allowed bool
+		if candidateGroups, allowed = p.limitFanOut(c, req, candidateGroups); !allowed {
+			routeSpan.SetError("too many choices")
+			routeSpan.End()
+			return false
+		}
+	}
+	routeSpan.SetAttribute("turnsapi.candidate_groups", strings.Join(candidateGroups, ","))
 	if len(candidateGroups) == 0 {
 		log.Printf("没有可用分组支持模型 %s", req.Model)
+		routeSpan.SetError("no group supports the model")
+		routeSpan.End()
 		return false
 	}
+	routeSpan.End()
 
 	log.Printf("开始分组间轮换重试，支持模型 %s 的分组: %v", req.Model, candidateGroups)
+	requestDebugFrom(c).add("route", map[string]interface{}{"candidates": candidateGroups}, "支持模型 %s 的候选分组（按失败计数排序）", req.Model)
 
-	// 使用新的分组间轮换重试策略，最多重试3个密钥
-	return p.tryGroupRotationWithLimit(c, req, routeReq, candidateGroups, startTime, 3)
+	// 使用分组间轮换重试策略，尝试次数取候选分组重试策略中的最大值
+	return p.tryGroupRotationWithLimit(c, req, routeReq, candidateGroups, startTime, p.maxAttemptsForGroups(candidateGroups))
 }
 
 // tryGroupRotationWithLimit 分组间轮换重试，最多重试指定数量的密钥
+// 启用排队时，所有分组的并发槽位都占用失败会排队等待容量恢复后重新选择
 func (p *MultiProviderProxy) tryGroupRotationWithLimit(
 	c *gin.Context,
 	req *providers.ChatCompletionRequest,
@@ -353,50 +571,138 @@ func (p *MultiProviderProxy) tryGroupRotationWithLimit(
 	startTime time.Time,
 	maxRetries int,
 ) bool {
+	queued := p.newQueuedRequest(c)
+	for {
+		served := p.rotateGroups(c, req, routeReq, candidateGroups, startTime, maxRetries, queued)
+		if served || queued == nil || !queued.concurrencySaturated {
+			return served
+		}
+		queued.concurrencySaturated = false
+		if !p.waitForCapacity(c, queued, candidateGroups) {
+			p.leaveQueue(c, queued, false)
+			p.respondConcurrencyLimited(c)
+			return false
+		}
+	}
+}
+
+// rotateGroups 选择可用分组和密钥并轮换发送请求
+// 并发槽位只在发送请求时通过TryAcquire占用，选择分组时不预先检查，避免检查和占用之间的竞争
+func (p *MultiProviderProxy) rotateGroups(
+	c *gin.Context,
+	req *providers.ChatCompletionRequest,
+	routeReq *router.RouteRequest,
+	candidateGroups []string,
+	startTime time.Time,
+	maxRetries int,
+	queued *queuedRequest,
+) bool {
+	trace := requestDebugFrom(c)
+
 	// 为每个分组准备密钥列表
-	groupKeys := make(map[string][]string)
-	totalAvailableKeys := 0
+	keySpan := startTraceSpan(c, "select_keys")
+	var groupKeys map[string][]string
+	var totalAvailableKeys int
+	var concurrencySaturated, rpmLimited, modelLimited, budgetExceeded bool
+
+	// 所有候选分组的RPM已满或有优先的请求在排队且启用了排队时，等待容量恢复后重新选择
+	for {
+		groupKeys = make(map[string][]string)
+		totalAvailableKeys = 0
+		concurrencySaturated, rpmLimited, modelLimited, budgetExceeded = false, false, false, false
+
+		for _, groupID := range candidateGroups {
+			// 检查分组预算
+			if p.groupBudgetExceeded(groupID) {
+				log.Printf("分组 %s 超出预算，跳过", groupID)
+				trace.add("keys", map[string]interface{}{"group": groupID}, "分组超出预算，跳过")
+				budgetExceeded = true
+				continue
+			}
 
-	for _, groupID := range candidateGroups {
-		// 检查RPM限制
-		if !p.rpmLimiter.Allow(groupID) {
-			log.Printf("分组 %s 超出RPM限制，跳过", groupID)
-			continue
-		}
+			// 排在前面的请求正在等待该分组时让出容量
+			if p.queuedAhead(queued, groupID) {
+				log.Printf("分组 %s 有优先的请求在排队，跳过", groupID)
+				trace.add("keys", map[string]interface{}{"group": groupID}, "分组有优先的请求在排队，跳过")
+				concurrencySaturated = true
+				continue
+			}
 
-		// 获取分组的所有可用密钥状态
-		groupStatus, exists := p.keyManager.GetGroupStatus(groupID)
-		if !exists {
-			log.Printf("分组 %s 不存在或未启用，跳过", groupID)
-			continue
-		}
+			// 检查RPM限制，只检查不占用，实际发送请求时才占用额度
+			if !p.rpmAvailable(groupID) {
+				log.Printf("分组 %s 超出RPM限制，跳过", groupID)
+				trace.add("keys", map[string]interface{}{"group": groupID}, "分组超出RPM限制，跳过")
+				rpmLimited = true
+				continue
+			}
 
-		groupInfo := groupStatus.(map[string]interface{})
-		keyStatuses, ok := groupInfo["key_statuses"].(map[string]*keymanager.KeyStatus)
-		if !ok {
-			log.Printf("无法获取分组 %s 的密钥状态，跳过", groupID)
-			continue
-		}
+			// 检查分组对该模型的RPM/TPM限制
+			if !p.modelLimitAvailable(groupID, req) {
+				log.Printf("分组 %s 超出模型 %s 的速率限制，跳过", groupID, req.Model)
+				trace.add("keys", map[string]interface{}{"group": groupID, "model": req.Model}, "分组超出模型速率限制，跳过")
+				modelLimited = true
+				continue
+			}
 
-		// 按优先级排序密钥：活跃且有效的密钥优先
-		sortedKeys := p.sortKeysByPriority(keyStatuses)
-		if len(sortedKeys) > 0 {
-			groupKeys[groupID] = sortedKeys
-			totalAvailableKeys += len(sortedKeys)
+			// 获取分组的所有可用密钥状态
+			groupStatus, exists := p.keyManager.GetGroupStatus(groupID)
+			if !exists {
+				log.Printf("分组 %s 不存在或未启用，跳过", groupID)
+				continue
+			}
 
-			// 显示密钥详细信息
-			keyDetails := make([]string, len(sortedKeys))
-			for i, key := range sortedKeys {
-				keyDetails[i] = p.maskKey(key)
+			groupInfo := groupStatus.(map[string]interface{})
+			keyStatuses, ok := groupInfo["key_statuses"].(map[string]*keymanager.KeyStatus)
+			if !ok {
+				log.Printf("无法获取分组 %s 的密钥状态，跳过", groupID)
+				continue
 			}
-			log.Printf("分组 %s 有 %d 个可用密钥: [%s]", groupID, len(sortedKeys), strings.Join(keyDetails, ", "))
-		} else {
-			log.Printf("分组 %s 没有可用密钥，跳过（总密钥数: %d）", groupID, len(keyStatuses))
+
+			// 按优先级排序密钥：活跃且有效的密钥优先
+			sortedKeys := p.sortKeysByPriority(keyStatuses)
+			if len(sortedKeys) > 0 {
+				p.keyPools.recordAvailable(groupID)
+				groupKeys[groupID] = sortedKeys
+				totalAvailableKeys += len(sortedKeys)
+
+				// 显示密钥详细信息
+				keyDetails := make([]string, len(sortedKeys))
+				for i, key := range sortedKeys {
+					keyDetails[i] = p.maskKey(key)
+				}
+				log.Printf("分组 %s 有 %d 个可用密钥: [%s]", groupID, len(sortedKeys), strings.Join(keyDetails, ", "))
+				trace.add("keys", map[string]interface{}{"group": groupID, "total": len(keyStatuses), "available": keyDetails}, "分组可用密钥（按优先级排序）")
+			} else {
+				log.Printf("分组 %s 没有可用密钥，跳过（总密钥数: %d）", groupID, len(keyStatuses))
+				trace.add("keys", map[string]interface{}{"group": groupID, "total": len(keyStatuses)}, "分组没有可用密钥，跳过")
+				p.keyPools.recordExhausted(groupID, req.Model, len(keyStatuses))
+			}
+		}
+
+		if len(groupKeys) > 0 || !(concurrencySaturated || rpmLimited) || !p.waitForCapacity(c, queued, candidateGroups) {
+			break
 		}
 	}
+	p.leaveQueue(c, queued, len(groupKeys) > 0)
+
+	keySpan.SetAttribute("turnsapi.available_groups", len(groupKeys))
+	keySpan.SetAttribute("turnsapi.available_keys", totalAvailableKeys)
+	if len(groupKeys) == 0 {
+		keySpan.SetError("no available keys")
+	}
+	keySpan.End()
 
 	if len(groupKeys) == 0 {
 		log.Printf("没有可用的分组和密钥")
+		if concurrencySaturated {
+			p.respondConcurrencyLimited(c)
+		} else if rpmLimited {
+			p.respondRPMLimited(c)
+		} else if modelLimited {
+			p.respondModelRateLimited(c, req.Model)
+		} else if budgetExceeded {
+			p.respondBudgetExceeded(c, nil)
+		}
 		return false
 	}
 
@@ -408,12 +714,61 @@ func (p *MultiProviderProxy) tryGroupRotationWithLimit(
 		}
 	}
 
+	// 会话粘滞：优先尝试会话已绑定的分组和密钥，不可用时按正常顺序故障转移并重新绑定
+	var stickyKey, stickySource string
+	stickySettings := stickySessionSettings(p.config.Snapshot())
+	if stickySettings != nil {
+		stickyKey, stickySource = stickySessionKey(c, stickySettings, req, routeReq.ProxyKeyID)
+	}
+	if stickyKey != "" {
+		stickyStatus := "miss"
+		if groupID, apiKey, ok := p.stickySessions.lookup(stickyKey, time.Now()); ok {
+			var hit bool
+			if availableGroups, hit = preferStickySession(availableGroups, groupKeys, groupID, apiKey); hit {
+				stickyStatus = "hit"
+				log.Printf("会话 %s 命中粘滞绑定：分组 %s 密钥 %s", stickyKey, groupID, p.maskKey(apiKey))
+			}
+		}
+		c.Header("X-TurnsAPI-Sticky-Session", stickyStatus)
+		trace.add("sticky", map[string]interface{}{"session": stickyKey, "source": stickySource, "status": stickyStatus}, "会话粘滞绑定查询")
+	}
+
 	log.Printf("开始分组间轮换重试，候选分组: %v，可用分组: %v，总可用密钥: %d，最多重试 %d 个密钥",
 		candidateGroups, availableGroups, totalAvailableKeys, maxRetries)
 
 	// 分组间轮换重试逻辑
 	retryCount := 0
 	keyIndex := 0
+	var lastErr error
+
+	// 并行对冲：同时发送到前两个可用分组，先成功的响应返回给客户端；都失败时按重试策略继续尝试其余密钥
+	if p.hedgeEnabled(c, req) {
+		if attempts := p.prepareHedge(c, req, routeReq, availableGroups, groupKeys); attempts != nil {
+			winner, failed := p.runHedge(c, req, attempts, startTime)
+			if winner != nil {
+				if stickyKey != "" {
+					p.stickySessions.bind(stickyKey, stickySource, winner.groupID, winner.apiKey, req.Model, stickySessionTTL(stickySettings), time.Now())
+				}
+				return true
+			}
+
+			retryCount = len(attempts)
+			lastErr = failed.err
+			policy := p.retryPolicyForGroup(failed.groupID)
+			delay := policy.backoff(retryCount, failed.err)
+			if !policy.isRetryable(failed.err) || retryCount >= maxRetries || time.Since(startTime)+delay > policy.maxTotalTime {
+				p.respondUpstreamError(c, failed.err)
+				return false
+			}
+			if !waitForRetry(c, delay) {
+				log.Printf("客户端已断开，停止重试")
+				return false
+			}
+			for _, attempt := range attempts {
+				groupKeys[attempt.groupID] = groupKeys[attempt.groupID][1:]
+			}
+		}
+	}
 
 	for retryCount < maxRetries {
 		// 检查当前轮次是否还有可用密钥
@@ -439,55 +794,146 @@ func (p *MultiProviderProxy) tryGroupRotationWithLimit(
 			}
 
 			apiKey := keys[keyIndex]
-			retryCount++
-
-			log.Printf("轮换重试第 %d/%d 次：尝试分组 %s 的第 %d 个密钥: %s",
-				retryCount, maxRetries, groupID, keyIndex+1, p.maskKey(apiKey))
 
-			// 为该分组创建路由请求
-			groupRouteReq := &router.RouteRequest{
+			// 获取该分组的路由结果，路由失败时跳过且不计入重试次数，也不占用任何额度
+			routeResult, err := p.providerRouter.RouteWithRetry(&router.RouteRequest{
 				Model:         req.Model,
 				ProviderGroup: groupID,
 				AllowedGroups: routeReq.AllowedGroups,
 				ProxyKeyID:    routeReq.ProxyKeyID,
-			}
-
-			// 获取该分组的路由结果
-			routeResult, err := p.providerRouter.RouteWithRetry(groupRouteReq)
+			})
 			if err != nil {
 				log.Printf("分组 %s 路由失败: %v，跳过该分组", groupID, err)
+				trace.add("attempt", map[string]interface{}{"group": groupID, "error": err.Error()}, "分组路由失败，跳过")
 				continue
 			}
 
+			// 占用分组和密钥的并发槽位，已满时跳过且不计入重试次数
+			release, acquired := p.acquireConcurrency(groupID, apiKey)
+			if !acquired {
+				log.Printf("分组 %s 密钥 %s 并发请求数已达上限，跳过", groupID, p.maskKey(apiKey))
+				concurrencySaturated = true
+				continue
+			}
+
+			// 占用分组的RPM额度，只在实际发送请求的分组上占用，已满时跳过且不计入重试次数
+			if !p.allowRPM(groupID) {
+				release()
+				log.Printf("分组 %s 超出RPM限制，跳过", groupID)
+				rpmLimited = true
+				continue
+			}
+
+			// 占用模型的RPM/TPM额度，已满时跳过且不计入重试次数
+			reservation, reserved := p.reserveModelLimit(groupID, req)
+			if !reserved {
+				release()
+				log.Printf("分组 %s 超出模型 %s 的速率限制，跳过", groupID, req.Model)
+				modelLimited = true
+				continue
+			}
+
+			retryCount++
+
+			log.Printf("轮换重试第 %d/%d 次：尝试分组 %s 的第 %d 个密钥: %s",
+				retryCount, maxRetries, groupID, keyIndex+1, p.maskKey(apiKey))
+			trace.add("attempt", map[string]interface{}{
+				"attempt":      retryCount,
+				"max_attempts": maxRetries,
+				"group":        groupID,
+				"key":          p.maskKey(apiKey),
+				"key_index":    keyIndex + 1,
+			}, "尝试分组 %s 的第 %d 个密钥", groupID, keyIndex+1)
+
 			// 更新提供商配置中的API密钥
 			p.providerRouter.UpdateProviderConfig(routeResult.ProviderConfig, apiKey)
 
+			// 影子流量按比例抽样，原请求成功后再发送到目标分组
+			shadow := p.prepareShadow(c, req, groupID)
+
 			// 尝试处理请求
-			var success bool
+			attemptStart := time.Now()
+			attemptSpan := startAttemptSpan(c, groupID, p.maskKey(apiKey), retryCount)
 			if req.Stream {
-				success = p.handleStreamingRequest(c, req, routeResult, apiKey, startTime)
+				err = p.handleStreamingRequest(c, req, routeResult, apiKey, startTime)
 			} else {
-				success = p.handleNonStreamingRequest(c, req, routeResult, apiKey, startTime)
+				err = p.handleNonStreamingRequest(c, req, routeResult, apiKey, startTime)
 			}
+			endAttemptSpan(c, attemptSpan, err)
+			release()
+			p.commitModelLimit(c, reservation, err)
+			p.runPostResponseHooks(c, err)
 
-			if success {
+			if err == nil {
 				log.Printf("分组间轮换重试成功：分组 %s 密钥 %s", groupID, p.maskKey(apiKey))
 				// 报告成功使用
 				p.keyManager.ReportSuccess(groupID, apiKey)
+				p.providerRouter.RecordGroupSuccess(groupID)
+				p.modelLatency.observe(req.Model, time.Since(attemptStart))
+				if shadow != nil {
+					p.dispatchShadow(c, shadow)
+				}
+				if stickyKey != "" {
+					p.stickySessions.bind(stickyKey, stickySource, groupID, apiKey, req.Model, stickySessionTTL(stickySettings), time.Now())
+				}
 				// 实时更新数据库状态
 				p.updateKeyStatusInDatabase(groupID, apiKey, true, "")
 				return true
-			} else {
-				log.Printf("分组间轮换重试失败：分组 %s 密钥 %s", groupID, p.maskKey(apiKey))
-				// 报告使用失败
-				p.keyManager.ReportError(groupID, apiKey, "请求失败")
+			}
+
+			// 客户端已断开，不是密钥或分组的问题，也不再重试
+			if errors.Is(err, errClientDisconnected) {
+				log.Printf("客户端已断开，停止重试")
+				return false
+			}
+
+			category := providers.ClassifyError(err)
+			log.Printf("分组间轮换重试失败：分组 %s 密钥 %s（错误分类: %s）", groupID, p.maskKey(apiKey), category)
+			trace.add("attempt", map[string]interface{}{
+				"group":       groupID,
+				"key":         p.maskKey(apiKey),
+				"category":    string(category),
+				"status_code": providers.StatusCodeFromError(err),
+				"error":       err.Error(),
+			}, "请求失败")
+			if !category.RequestSpecific() {
 				// 实时更新数据库状态
-				p.updateKeyStatusInDatabase(groupID, apiKey, false, "请求失败")
+				p.updateKeyStatusInDatabase(groupID, apiKey, false, err.Error())
+				// 请求本身的问题不影响分组的路由优先级
+				p.providerRouter.RecordGroupFailure(groupID, err)
+			}
+			lastErr = err
+
+			// 响应已开始写入（如流式输出中途失败）时无法再重试，只写入了心跳时仍可以重试
+			if c.Writer.Written() && !streamHeartbeatOnly(c) {
+				return false
+			}
+
+			// 根据分组的重试策略决定是否继续
+			policy := p.retryPolicyForGroup(groupID)
+			if !policy.isRetryable(err) {
+				log.Printf("分组 %s 返回不可重试的错误（分类: %s，状态码: %d），停止重试: %v",
+					groupID, category, providers.StatusCodeFromError(err), err)
+				p.respondUpstreamError(c, err)
+				return false
 			}
 
 			// 如果已达到最大重试次数，停止
 			if retryCount >= maxRetries {
 				log.Printf("已达到最大重试次数 %d，停止重试", maxRetries)
+				p.respondUpstreamError(c, err)
+				return false
+			}
+
+			delay := policy.backoff(retryCount, err)
+			trace.add("retry", map[string]interface{}{"delay_ms": delay.Milliseconds()}, "等待后重试")
+			if time.Since(startTime)+delay > policy.maxTotalTime {
+				log.Printf("重试总时间将超出预算 %v，停止重试", policy.maxTotalTime)
+				p.respondUpstreamError(c, err)
+				return false
+			}
+			if !waitForRetry(c, delay) {
+				log.Printf("客户端已断开，停止重试")
 				return false
 			}
 		}
@@ -512,10 +958,210 @@ func (p *MultiProviderProxy) tryGroupRotationWithLimit(
 		}
 	}
 
+	if retryCount == 0 && concurrencySaturated {
+		// 启用排队时由调用方排队等待后重新选择
+		if queued != nil && lastErr == nil {
+			queued.concurrencySaturated = true
+			return false
+		}
+		p.respondConcurrencyLimited(c)
+		return false
+	}
+	if retryCount == 0 && rpmLimited {
+		p.respondRPMLimited(c)
+		return false
+	}
+	if retryCount == 0 && modelLimited {
+		p.respondModelRateLimited(c, req.Model)
+		return false
+	}
+
 	log.Printf("分组间轮换重试完成，共尝试 %d 次，全部失败", retryCount)
+	if lastErr != nil {
+		p.respondUpstreamError(c, lastErr)
+	}
 	return false
 }
 
+// waitForRetry 在重试前等待指定时间，客户端断开时返回false
+func waitForRetry(c *gin.Context, delay time.Duration) bool {
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
+
+// respondUpstreamError 所有尝试结束后按错误分类返回最后一次上游错误
+// 请求本身的问题（上下文超长、内容拦截、参数错误）透传上游错误信息；密钥相关和服务端错误不暴露上游细节
+func (p *MultiProviderProxy) respondUpstreamError(c *gin.Context, err error) {
+	// 流式请求预先设置了SSE响应头，错误响应使用JSON
+	c.Writer.Header().Del("Content-Type")
+
+	statusCode := providers.StatusCodeFromError(err)
+	category := providers.ClassifyError(err)
+
+	var status int
+	var errType, code, message string
+	var timeoutErr *requestTimeoutError
+	switch {
+	case errors.As(err, &timeoutErr):
+		status, errType, code = http.StatusGatewayTimeout, "timeout_error", "upstream_timeout"
+		message = fmt.Sprintf("Upstream request did not complete within %v", timeoutErr.timeout)
+	case providers.IsStageTimeout(err):
+		status, errType, code = http.StatusGatewayTimeout, "timeout_error", "upstream_timeout"
+		message = "Upstream did not respond in time"
+	}
+	if status != 0 {
+		respondError(c, status, gin.H{
+			"error": gin.H{
+				"message":  message,
+				"type":     errType,
+				"code":     code,
+				"category": string(category),
+			},
+		})
+		return
+	}
+
+	switch category {
+	case providers.ErrorCategoryContextLength:
+		status, errType, code, message = http.StatusBadRequest, "invalid_request_error", "context_length_exceeded", err.Error()
+	case providers.ErrorCategoryContentFilter:
+		status, errType, code, message = http.StatusBadRequest, "invalid_request_error", "content_filter", err.Error()
+	case providers.ErrorCategoryInvalidRequest:
+		status, errType, code, message = http.StatusBadRequest, "invalid_request_error", "upstream_rejected", err.Error()
+		if statusCode >= 400 && statusCode < 500 {
+			status = statusCode
+		}
+	case providers.ErrorCategoryRateLimit, providers.ErrorCategoryQuota:
+		status, errType = http.StatusTooManyRequests, "rate_limit_error"
+		code, message = "upstream_rate_limited", "Upstream rate limit exceeded"
+		if category == providers.ErrorCategoryQuota {
+			code, message = "upstream_quota_exceeded", "Upstream quota exhausted for all available keys"
+		}
+		if retryAfter := providers.RetryAfterFromError(err); retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		}
+	case providers.ErrorCategoryAuth:
+		status, errType, code, message = http.StatusBadGateway, "upstream_error", "upstream_auth_failed", "Upstream rejected all available API keys"
+	default:
+		status, errType, code, message = http.StatusBadGateway, "connection_error", "upstream_error", "Failed to connect to provider"
+	}
+
+	respondError(c, status, gin.H{
+		"error": gin.H{
+			"message":  message,
+			"type":     errType,
+			"code":     code,
+			"category": string(category),
+		},
+	})
+}
+
+// allowRPM 按当前配置快照中的RPM限制和突发容量检查分组是否允许请求
+func (p *MultiProviderProxy) allowRPM(groupID string) bool {
+	limit, burst := 0, 0
+	if group, exists := p.config.Snapshot().UserGroups[groupID]; exists && group != nil {
+		limit, burst = group.RPMLimit, group.RPMBurst
+	}
+	if !p.rpmLimiter.AllowWithLimit(groupID, limit, burst) {
+		return false
+	}
+	if limit > 0 {
+		if current, _, exists := p.rpmLimiter.GetStats(groupID); exists {
+			p.quota.recordRPM(groupID, current, limit)
+		}
+	}
+	return true
+}
+
+// rpmAvailable 检查分组当前是否还有RPM额度，不占用额度，用于选择候选分组
+func (p *MultiProviderProxy) rpmAvailable(groupID string) bool {
+	if group, exists := p.config.Snapshot().UserGroups[groupID]; !exists || group == nil || group.RPMLimit <= 0 {
+		return true
+	}
+	remaining, _, exists := p.rpmLimiter.GetRemaining(groupID)
+	return !exists || remaining > 0
+}
+
+// setRPMHeaders 返回处理请求的分组的RPM限制和还可以立即发出的请求数，分组未设置限制时不返回
+// 需要在透传上游响应头之后调用，否则会被清除
+func (p *MultiProviderProxy) setRPMHeaders(c *gin.Context, groupID string) {
+	remaining, limit, exists := p.rpmLimiter.GetRemaining(groupID)
+	if !exists {
+		return
+	}
+	c.Header("X-TurnsAPI-RPM-Limit", strconv.Itoa(limit))
+	c.Header("X-TurnsAPI-RPM-Remaining", strconv.Itoa(remaining))
+}
+
+// getConcurrencyLimits 获取分组及单个密钥的最大并发数，0表示无限制
+func (p *MultiProviderProxy) getConcurrencyLimits(groupID string) (int, int) {
+	group, exists := p.config.Snapshot().UserGroups[groupID]
+	if !exists || group == nil {
+		return 0, 0
+	}
+	return group.MaxConcurrent, group.MaxConcurrentPerKey
+}
+
+// acquireConcurrency 占用分组和密钥的并发槽位，成功时返回释放函数
+func (p *MultiProviderProxy) acquireConcurrency(groupID, apiKey string) (func(), bool) {
+	maxConcurrent, maxPerKey := p.getConcurrencyLimits(groupID)
+	if !p.groupConcurrency.TryAcquire(groupID, maxConcurrent) {
+		return nil, false
+	}
+
+	keyID := keyConcurrencyID(groupID, apiKey)
+	if !p.keyConcurrency.TryAcquire(keyID, maxPerKey) {
+		p.groupConcurrency.Release(groupID)
+		return nil, false
+	}
+
+	return func() {
+		p.keyConcurrency.Release(keyID)
+		p.groupConcurrency.Release(groupID)
+		p.requestQueue.Signal(groupID)
+	}, true
+}
+
+// respondConcurrencyLimited 返回并发超限的429响应
+func (p *MultiProviderProxy) respondConcurrencyLimited(c *gin.Context) {
+	c.Header("Retry-After", "1")
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": gin.H{
+			"message": "Too many concurrent requests for the available provider groups",
+			"type":    "rate_limit_error",
+			"code":    "concurrency_limit_exceeded",
+		},
+	})
+}
+
+// respondRPMLimited 返回所有候选分组都超出RPM限制的429响应
+func (p *MultiProviderProxy) respondRPMLimited(c *gin.Context) {
+	c.Header("Retry-After", "1")
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": gin.H{
+			"message": "Rate limit exceeded for the available provider groups",
+			"type":    "rate_limit_error",
+			"code":    "rpm_limit_exceeded",
+		},
+	})
+}
+
+// keyConcurrencyID 生成密钥并发计数的标识
+func keyConcurrencyID(groupID, apiKey string) string {
+	return groupID + "/" + apiKey
+}
+
 // tryGroupWithAllKeys 在指定分组内尝试所有可用密钥（保留原函数用于其他地方调用）
 func (p *MultiProviderProxy) tryGroupWithAllKeys(
 	c *gin.Context,
@@ -524,7 +1170,8 @@ func (p *MultiProviderProxy) tryGroupWithAllKeys(
 	startTime time.Time,
 ) bool {
 	// 检查RPM限制
-	if !p.rpmLimiter.Allow(routeResult.GroupID) {
+	if !p.allowRPM(routeResult.GroupID) {
+		p.setRPMHeaders(c, routeResult.GroupID)
 		c.JSON(http.StatusTooManyRequests, gin.H{
 			"error": gin.H{
 				"message": "Rate limit exceeded for the selected provider group",
@@ -562,26 +1209,37 @@ func (p *MultiProviderProxy) tryGroupWithAllKeys(
 		p.providerRouter.UpdateProviderConfig(routeResult.ProviderConfig, apiKey)
 
 		// 尝试处理请求
-		var success bool
+		var err error
 		if req.Stream {
-			success = p.handleStreamingRequest(c, req, routeResult, apiKey, startTime)
+			err = p.handleStreamingRequest(c, req, routeResult, apiKey, startTime)
 		} else {
-			success = p.handleNonStreamingRequest(c, req, routeResult, apiKey, startTime)
+			err = p.handleNonStreamingRequest(c, req, routeResult, apiKey, startTime)
+		}
+		p.runPostResponseHooks(c, err)
+		if errors.Is(err, errClientDisconnected) {
+			log.Printf("客户端已断开，停止尝试分组 %s 的其他密钥", routeResult.GroupID)
+			return false
 		}
 
-		if success {
+		if err == nil {
 			log.Printf("分组 %s 密钥 %s 请求成功", routeResult.GroupID, p.maskKey(apiKey))
 			// 报告成功使用
 			p.keyManager.ReportSuccess(routeResult.GroupID, apiKey)
 			// 实时更新数据库状态
 			p.updateKeyStatusInDatabase(routeResult.GroupID, apiKey, true, "")
 			return true
+		} else if category := providers.ClassifyError(err); category.RequestSpecific() {
+			// 请求本身的问题换密钥也不会成功，直接返回且不计入密钥错误
+			log.Printf("分组 %s 请求被上游拒绝（%s），停止尝试其他密钥", routeResult.GroupID, category)
+			if !c.Writer.Written() || streamHeartbeatOnly(c) {
+				p.respondUpstreamError(c, err)
+			}
+			return false
 		} else {
+			// 密钥错误已在处理请求时按分类上报
 			log.Printf("分组 %s 密钥 %s 请求失败，尝试下一个", routeResult.GroupID, p.maskKey(apiKey))
-			// 报告使用失败
-			p.keyManager.ReportError(routeResult.GroupID, apiKey, "请求失败")
 			// 实时更新数据库状态
-			p.updateKeyStatusInDatabase(routeResult.GroupID, apiKey, false, "请求失败")
+			p.updateKeyStatusInDatabase(routeResult.GroupID, apiKey, false, err.Error())
 		}
 	}
 
@@ -589,7 +1247,6 @@ func (p *MultiProviderProxy) tryGroupWithAllKeys(
 	return false
 }
 
-
 // sortKeysByPriority 按优先级排序密钥
 func (p *MultiProviderProxy) sortKeysByPriority(keyStatuses map[string]*keymanager.KeyStatus) []string {
 	type keyPriority struct {
@@ -600,10 +1257,14 @@ func (p *MultiProviderProxy) sortKeysByPriority(keyStatuses map[string]*keymanag
 
 	var priorities []keyPriority
 
+	now := time.Now()
 	for key, status := range keyStatuses {
 		if !status.IsActive {
 			continue // 跳过非活跃密钥
 		}
+		if now.Before(status.BackoffUntil) {
+			continue // 跳过仍在上游限流退避期内的密钥
+		}
 
 		priority := 0
 
@@ -657,8 +1318,69 @@ func (p *MultiProviderProxy) updateKeyStatusInDatabase(groupID, apiKey string, i
 	}()
 }
 
-// maskKey 掩码显示密钥
+// 密钥隔离时长
+const (
+	authFailureQuarantine = 30 * time.Minute // 认证失败的密钥暂停使用时长
+	quotaExhaustedBackoff = 5 * time.Minute  // 额度耗尽且上游未给出重置时间时的退避时长
+)
+
+// reportUpstreamError 根据上游错误分类处理密钥状态
+// 请求本身的问题不计入密钥错误；密钥无效（401或明确的无效密钥错误码）时标记密钥无效并隔离，
+// 其他认证类错误（如403无权访问模型）只隔离不标记无效；额度耗尽让密钥进入退避期，限流让密钥进入冷却期
+func (p *MultiProviderProxy) reportUpstreamError(groupID, apiKey string, err error) {
+	category := providers.ClassifyError(err)
+	if category.RequestSpecific() {
+		log.Printf("分组 %s 请求被上游拒绝（%s），不计入密钥 %s 的错误: %v", groupID, category, p.maskKey(apiKey), err)
+		return
+	}
+
+	p.keyManager.ReportError(groupID, apiKey, err.Error())
+
+	switch category {
+	case providers.ErrorCategoryAuth:
+		if !providers.IsInvalidKeyError(err) {
+			log.Printf("分组 %s 密钥 %s 无权限访问（%v），暂停使用 %v", groupID, p.maskKey(apiKey), err, authFailureQuarantine)
+			p.keyManager.SetKeyBackoff(groupID, apiKey, time.Now().Add(authFailureQuarantine))
+			return
+		}
+		reason := fmt.Sprintf("上游认证失败: %v", err)
+		if setErr := p.keyManager.ForceSetKeyStatus(groupID, apiKey, false, reason); setErr != nil {
+			log.Printf("标记密钥 %s 无效失败: %v", p.maskKey(apiKey), setErr)
+		}
+		p.keyManager.SetKeyBackoff(groupID, apiKey, time.Now().Add(authFailureQuarantine))
+		if p.database != nil {
+			go func() {
+				if dbErr := p.database.UpdateAPIKeyValidation(groupID, apiKey, false, reason); dbErr != nil {
+					log.Printf("Failed to update key validation in database: %v", dbErr)
+				}
+			}()
+		}
+	case providers.ErrorCategoryQuota:
+		backoff := providers.RetryAfterFromError(err)
+		if backoff < quotaExhaustedBackoff {
+			backoff = quotaExhaustedBackoff
+		}
+		p.keyManager.SetKeyBackoff(groupID, apiKey, time.Now().Add(backoff))
+	case providers.ErrorCategoryRateLimit:
+		p.keyManager.CooldownKey(groupID, apiKey, providers.RetryAfterFromError(err))
+	}
+}
+
+// maskKey 掩码显示密钥，密钥设置了标签时显示标签
 func (p *MultiProviderProxy) maskKey(key string) string {
+	return keyDisplayName(p.config.Snapshot(), key)
+}
+
+// keyDisplayName 日志和状态中显示的密钥名称，设置了标签时为标签，否则为掩码后的密钥
+func keyDisplayName(config *internal.Config, key string) string {
+	if label := config.APIKeyLabel(key); label != "" {
+		return label
+	}
+	return maskAPIKey(key)
+}
+
+// maskAPIKey 掩码显示密钥，保留首尾各4位
+func maskAPIKey(key string) string {
 	if len(key) <= 8 {
 		return "****"
 	}
@@ -673,7 +1395,7 @@ func (p *MultiProviderProxy) handleNonStreamingRequestWithRetry(
 	apiKey string,
 	startTime time.Time,
 ) bool {
-	return p.handleNonStreamingRequest(c, req, routeResult, apiKey, startTime)
+	return p.handleNonStreamingRequest(c, req, routeResult, apiKey, startTime) == nil
 }
 
 // handleNonStreamingRequest 处理非流式请求
@@ -683,47 +1405,77 @@ func (p *MultiProviderProxy) handleNonStreamingRequest(
 	routeResult *router.RouteResult,
 	apiKey string,
 	startTime time.Time,
-) bool {
-	// 创建带有长超时的context，避免请求超时
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
+) error {
+	// 按分组的分阶段超时创建context：总超时允许长时间生成，连接和首字节超时快速失败
+	connectTimeout, firstByteTimeout, totalTimeout := p.upstreamTimeoutsForGroup(routeResult.GroupID)
+	totalTimeout = requestTotalTimeout(c, totalTimeout)
+	ctx, cancel := context.WithTimeout(withTraceContext(upstreamBaseContext(c), c), totalTimeout)
 	defer cancel()
+	ctx = providers.WithStageTimeouts(ctx, connectTimeout, firstByteTimeout)
 
-	// 应用分组的请求参数覆盖
-	req.ApplyRequestParams(routeResult.ProviderConfig.RequestParams)
+	// 构建发送到上游的请求
+	upstreamReq := p.buildUpstreamRequest(c, req, routeResult)
+	ctx, err := p.runPreRequestHooks(ctx, c, routeResult, upstreamReq)
+	if err != nil {
+		log.Printf("分组 %s 的钩子拒绝请求: %v", routeResult.GroupID, err)
+		return err
+	}
+	ctx, err = p.runRequestScript(ctx, c, routeResult, upstreamReq)
+	if err != nil {
+		log.Printf("分组 %s 的脚本拒绝请求: %v", routeResult.GroupID, err)
+		return err
+	}
 
-	// 应用模型名称映射
-	originalModel := req.Model
-	req.Model = p.providerRouter.ResolveModelName(req.Model, routeResult.GroupID)
+	// 发送请求到提供商，同时捕获上游响应头
+	trace := requestDebugFrom(c)
+	trace.add("upstream", map[string]interface{}{"group": routeResult.GroupID, "upstream_model": upstreamReq.Model}, "发送请求到上游")
+	upstreamStart := time.Now()
+	ctx, captured := providers.WithUpstreamHeaders(ctx)
+	response, err := routeResult.Provider.ChatCompletion(ctx, upstreamReq)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = &requestTimeoutError{timeout: totalTimeout, err: err}
+	}
+	trace.add("upstream", map[string]interface{}{
+		"upstream_ms": time.Since(upstreamStart).Milliseconds(),
+		"overhead_ms": upstreamStart.Sub(startTime).Milliseconds(),
+		"success":     err == nil,
+	}, "上游返回")
 
-	// 发送请求到提供商
-	response, err := routeResult.Provider.ChatCompletion(ctx, req)
+	// 另一个对冲请求已先成功，该请求被取消
+	if err != nil && hedgeCancelled(c) {
+		p.logHedgeCancelled(c, req, routeResult, apiKey, startTime)
+		return errHedgeCancelled
+	}
 
-	// 恢复原始模型名称用于日志记录
-	req.Model = originalModel
 	if err != nil {
 		log.Printf("Provider request failed: %v", err)
-		p.keyManager.ReportError(routeResult.GroupID, apiKey, err.Error())
+		p.reportUpstreamError(routeResult.GroupID, apiKey, err)
+		upstreamHeaders := p.captureUpstreamHeaders(c, captured, "")
 
 		// 记录错误日志
 		if p.requestLogger != nil {
 			proxyKeyName, proxyKeyID := p.getProxyKeyInfo(c)
 			reqBody, _ := json.Marshal(req)
 			clientIP := logger.GetClientIP(c)
-			p.requestLogger.LogRequest(proxyKeyName, proxyKeyID, routeResult.GroupID, apiKey, req.Model, string(reqBody), "", clientIP, 502, false, time.Since(startTime), err)
+			splitName, splitArm := trafficSplitLogFields(c)
+			logSpan := startTraceSpan(c, "request_log")
+			p.requestLogger.LogRequestWithRequestID(proxyKeyName, proxyKeyID, routeResult.GroupID, apiKey, req.Model, string(reqBody), "", clientIP, 502, false, time.Since(startTime), err, upstreamHeaders, trace.json(), "", false, splitName, splitArm, c.GetString("request_id"))
+			logSpan.End()
 		}
 
-		c.JSON(http.StatusBadGateway, gin.H{
-			"error": gin.H{
-				"message": "Failed to connect to provider",
-				"type":    "connection_error",
-				"code":    "upstream_error",
-			},
-		})
-		return false
+		// 错误响应由调用方根据重试策略统一返回
+		return err
 	}
 
 	// 报告成功
 	p.keyManager.ReportSuccess(routeResult.GroupID, apiKey)
+	upstreamHeaders := p.captureUpstreamHeaders(c, captured, response.Provider)
+	p.setRPMHeaders(c, routeResult.GroupID)
+
+	// 校验结构化输出（response_format）
+	response = p.enforceResponseFormat(ctx, c, upstreamReq, routeResult, response)
+	response = p.runResponseScript(ctx, c, routeResult, response)
+	c.Set(upstreamUsageTokensKey, response.Usage.TotalTokens)
 
 	// 检查是否需要返回原生响应格式
 	var finalResponse interface{} = response
@@ -744,12 +1496,37 @@ func (p *MultiProviderProxy) handleNonStreamingRequest(
 		reqBody, _ := json.Marshal(req)
 		respBody, _ := json.Marshal(finalResponse)
 		clientIP := logger.GetClientIP(c)
-		p.requestLogger.LogRequest(proxyKeyName, proxyKeyID, routeResult.GroupID, apiKey, req.Model, string(reqBody), string(respBody), clientIP, 200, false, time.Since(startTime), nil)
+		splitName, splitArm := trafficSplitLogFields(c)
+		logSpan := startTraceSpan(c, "request_log")
+		p.requestLogger.LogRequestWithRequestID(proxyKeyName, proxyKeyID, routeResult.GroupID, apiKey, req.Model, string(reqBody), string(respBody), clientIP, 200, false, time.Since(startTime), nil, upstreamHeaders, trace.json(), shadowCorrelationFrom(c), false, splitName, splitArm, c.GetString("request_id"))
+		logSpan.End()
 	}
 
 	// 返回响应
 	c.JSON(http.StatusOK, finalResponse)
-	return true
+	return nil
+}
+
+// buildUpstreamRequest 构建实际发送到上游的请求：应用分组参数覆盖、系统提示词注入、模型名称映射、模型规范化和参数支持矩阵
+// 每次尝试基于客户端请求的副本构建，客户端请求保持不变，用于日志记录以及重试和故障转移到其他分组
+func (p *MultiProviderProxy) buildUpstreamRequest(c *gin.Context, req *providers.ChatCompletionRequest, routeResult *router.RouteResult) *providers.ChatCompletionRequest {
+	mapped := *req
+
+	// 应用分组的请求参数覆盖
+	mapped.ApplyRequestParams(routeResult.ProviderConfig.RequestParams)
+
+	// 应用模型名称映射
+	mapped.Model = p.providerRouter.ResolveModelName(req.Model, routeResult.GroupID)
+	if req.TurnsAPI != nil {
+		mapped.IncludeReasoning = req.TurnsAPI.IncludeReasoning
+	}
+	mapped.TurnsAPI = nil
+
+	// 注入分组配置的系统提示词
+	p.injectSystemPrompt(c, &mapped, req.Model, routeResult)
+
+	// 按模型目录规范化请求（如OpenAI o系列模型不支持system消息和temperature），再去除提供商类型不支持的参数
+	return p.dropUnsupportedParams(c, providers.NormalizeRequestForModel(routeResult.ProviderConfig.ProviderType, &mapped), routeResult)
 }
 
 // handleStreamingRequestWithRetry 处理流式请求（支持重试）
@@ -760,7 +1537,7 @@ func (p *MultiProviderProxy) handleStreamingRequestWithRetry(
 	apiKey string,
 	startTime time.Time,
 ) bool {
-	return p.handleStreamingRequest(c, req, routeResult, apiKey, startTime)
+	return p.handleStreamingRequest(c, req, routeResult, apiKey, startTime) == nil
 }
 
 // handleStreamingRequest 处理流式请求
@@ -770,87 +1547,157 @@ func (p *MultiProviderProxy) handleStreamingRequest(
 	routeResult *router.RouteResult,
 	apiKey string,
 	startTime time.Time,
-) bool {
-	// 创建带有长超时的context，避免流式请求超时
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
+) error {
+	// 按分组的分阶段超时创建context：总超时允许长时间输出，连接和首字节超时快速失败
+	// 基于客户端请求的context，客户端断开时取消上游请求，不再消耗上游token
+	connectTimeout, firstByteTimeout, totalTimeout := p.upstreamTimeoutsForGroup(routeResult.GroupID)
+	totalTimeout = requestTotalTimeout(c, totalTimeout)
+	ctx, cancel := context.WithTimeout(withTraceContext(c.Request.Context(), c), totalTimeout)
 	defer cancel()
+	ctx = providers.WithStageTimeouts(ctx, connectTimeout, firstByteTimeout)
 
-	// 应用分组的请求参数覆盖
-	req.ApplyRequestParams(routeResult.ProviderConfig.RequestParams)
-
-	// 应用模型名称映射
-	originalModel := req.Model
-	req.Model = p.providerRouter.ResolveModelName(req.Model, routeResult.GroupID)
+	// 构建发送到上游的请求
+	upstreamReq := p.buildUpstreamRequest(c, req, routeResult)
+	ctx, err := p.runPreRequestHooks(ctx, c, routeResult, upstreamReq)
+	if err != nil {
+		log.Printf("分组 %s 的钩子拒绝请求: %v", routeResult.GroupID, err)
+		return err
+	}
+	ctx, err = p.runRequestScript(ctx, c, routeResult, upstreamReq)
+	if err != nil {
+		log.Printf("分组 %s 的脚本拒绝请求: %v", routeResult.GroupID, err)
+		return err
+	}
 
-	// 设置流式响应头
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Header("Access-Control-Allow-Origin", "*")
+	// 获取响应写入器；流式响应头在收到上游第一个数据块后才提交，之前失败时仍可以换用其他密钥或分组
+	w := c.Writer
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Printf("Streaming not supported")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"message": "Streaming not supported",
+				"type":    "internal_error",
+			},
+		})
+		return fmt.Errorf("streaming not supported")
+	}
 
 	// 根据配置选择流式响应类型
-	var streamChan <-chan providers.StreamResponse
-	var err error
-
-	if p.shouldUseNativeResponse(routeResult.GroupID, c) {
-		// 使用原生格式流式响应
-		streamChan, err = routeResult.Provider.ChatCompletionStreamNative(ctx, req)
-	} else {
+	trace := requestDebugFrom(c)
+	trace.add("upstream", map[string]interface{}{"group": routeResult.GroupID, "upstream_model": upstreamReq.Model}, "发送流式请求到上游")
+	upstreamStart := time.Now()
+	ctx, captured := providers.WithUpstreamHeaders(ctx)
+	useNative := p.shouldUseNativeResponse(routeResult.GroupID, c)
+
+	// 等待上游响应头和第一个数据块期间按间隔发送心跳
+	heartbeatInterval := streamHeartbeatInterval(p.config.Snapshot())
+	streamChan, err := openStreamWithHeartbeat(c, flusher, heartbeatInterval, func() (<-chan providers.StreamResponse, error) {
+		if useNative {
+			// 使用原生格式流式响应
+			return routeResult.Provider.ChatCompletionStreamNative(ctx, upstreamReq)
+		}
 		// 使用标准格式流式响应
-		streamChan, err = routeResult.Provider.ChatCompletionStream(ctx, req)
-	}
+		return routeResult.Provider.ChatCompletionStream(ctx, upstreamReq)
+	})
+
+	trace.add("upstream", map[string]interface{}{
+		"headers_ms":  time.Since(upstreamStart).Milliseconds(),
+		"overhead_ms": upstreamStart.Sub(startTime).Milliseconds(),
+		"success":     err == nil,
+	}, "上游返回流式响应头")
 
-	// 恢复原始模型名称用于日志记录
-	req.Model = originalModel
+	if err != nil && clientDisconnected(c) {
+		// 客户端在上游返回响应前断开，上游请求已取消
+		log.Printf("客户端已断开，取消分组 %s 的上游流式请求", routeResult.GroupID)
+		if p.requestLogger != nil {
+			proxyKeyName, proxyKeyID := p.getProxyKeyInfo(c)
+			reqBody, _ := json.Marshal(req)
+			clientIP := logger.GetClientIP(c)
+			splitName, splitArm := trafficSplitLogFields(c)
+			p.requestLogger.LogRequestWithRequestID(proxyKeyName, proxyKeyID, routeResult.GroupID, apiKey, req.Model, string(reqBody), "", clientIP, statusClientClosedRequest, true, time.Since(startTime), errClientDisconnected, nil, trace.json(), "", false, splitName, splitArm, c.GetString("request_id"))
+		}
+		return errClientDisconnected
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = &requestTimeoutError{timeout: totalTimeout, err: err}
+	}
 	if err != nil {
 		log.Printf("Provider streaming request failed: %v", err)
-		p.keyManager.ReportError(routeResult.GroupID, apiKey, err.Error())
+		p.reportUpstreamError(routeResult.GroupID, apiKey, err)
+		upstreamHeaders := p.captureUpstreamHeaders(c, captured, "")
 
 		// 记录错误日志
 		if p.requestLogger != nil {
 			proxyKeyName, proxyKeyID := p.getProxyKeyInfo(c)
 			reqBody, _ := json.Marshal(req)
 			clientIP := logger.GetClientIP(c)
-			p.requestLogger.LogRequest(proxyKeyName, proxyKeyID, routeResult.GroupID, apiKey, req.Model, string(reqBody), "", clientIP, 502, true, time.Since(startTime), err)
+			splitName, splitArm := trafficSplitLogFields(c)
+			logSpan := startTraceSpan(c, "request_log")
+			p.requestLogger.LogRequestWithRequestID(proxyKeyName, proxyKeyID, routeResult.GroupID, apiKey, req.Model, string(reqBody), "", clientIP, 502, true, time.Since(startTime), err, upstreamHeaders, trace.json(), "", false, splitName, splitArm, c.GetString("request_id"))
+			logSpan.End()
 		}
 
-		c.JSON(http.StatusBadGateway, gin.H{
-			"error": gin.H{
-				"message": "Failed to connect to provider",
-				"type":    "connection_error",
-				"code":    "upstream_error",
-			},
-		})
-		return false
+		// 错误响应由调用方根据重试策略统一返回
+		return err
 	}
 
-	// 获取响应写入器
-	w := c.Writer
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		log.Printf("Streaming not supported")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{
-				"message": "Streaming not supported",
-				"type":    "internal_error",
-			},
-		})
-		return false
-	}
+	// 上游已返回响应头，在提交流式响应头之前透传给客户端（已发送心跳时响应头已提交，只记录到日志）
+	upstreamHeaders := p.captureUpstreamHeaders(c, captured, "")
+	p.setRPMHeaders(c, routeResult.GroupID)
 
 	// 处理流式数据
 	hasData := false
+	var streamErr error
 	responseBuffer := make([]byte, 0, 1024)
 	lastChunks := make([][]byte, 0, 10) // 保存最后10个chunk用于token提取
+	bufferedChunks, totalChunks := 0, 0 // 已写入responseBuffer的chunk数和收到的chunk总数
+	var ttft time.Duration              // 首字耗时，从请求开始到收到第一个数据块
+
+	var heartbeat <-chan time.Time
+	if heartbeatInterval > 0 {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+receive:
+	for {
+		var streamResp providers.StreamResponse
+		select {
+		case resp, ok := <-streamChan:
+			if !ok {
+				break receive
+			}
+			streamResp = resp
+		case <-heartbeat:
+			writeStreamHeartbeat(c, flusher)
+			continue
+		}
 
-	for streamResp := range streamChan {
 		if streamResp.Error != nil {
-			log.Printf("Stream error: %v", streamResp.Error)
-			p.keyManager.ReportError(routeResult.GroupID, apiKey, streamResp.Error.Error())
+			if clientDisconnected(c) {
+				// 客户端断开导致上游读取被取消，不是上游的错误
+				break
+			}
+			streamErr = streamResp.Error
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				streamErr = &requestTimeoutError{timeout: totalTimeout, err: streamErr}
+			}
+			log.Printf("Stream error: %v", streamErr)
+			p.reportUpstreamError(routeResult.GroupID, apiKey, streamErr)
 			break
 		}
 
 		if len(streamResp.Data) > 0 {
+			if !hasData {
+				ttft = time.Since(startTime)
+				trace.add("upstream", map[string]interface{}{"first_chunk_ms": time.Since(upstreamStart).Milliseconds()}, "收到第一个数据块")
+				// 开始输出后不再发送心跳，响应中途失败时不能再重试
+				heartbeat = nil
+				c.Set(streamHeartbeatOnlyKey, false)
+				startStreamResponse(c)
+			}
 			hasData = true
 			w.Write(streamResp.Data)
 			flusher.Flush()
@@ -858,7 +1705,9 @@ func (p *MultiProviderProxy) handleStreamingRequest(
 			// 收集响应数据用于日志记录
 			if len(responseBuffer) < 5000 { // 减少前面内容的记录
 				responseBuffer = append(responseBuffer, streamResp.Data...)
+				bufferedChunks++
 			}
+			totalChunks++
 
 			// 保存最后的chunk，用于token提取
 			lastChunks = append(lastChunks, streamResp.Data)
@@ -872,28 +1721,63 @@ func (p *MultiProviderProxy) handleStreamingRequest(
 		}
 	}
 
-	// 将最后的chunk添加到响应缓冲区，确保包含token信息
-	for _, chunk := range lastChunks {
-		responseBuffer = append(responseBuffer, chunk...)
+	// 将尚未记录的最后几个chunk添加到响应缓冲区，确保包含token信息；中间省略的部分以SSE注释标记
+	tailStart := totalChunks - len(lastChunks)
+	if tailStart > bufferedChunks {
+		responseBuffer = append(responseBuffer, logger.StreamTruncatedMarker+"\n\n"...)
+	}
+	for i, chunk := range lastChunks {
+		if tailStart+i >= bufferedChunks {
+			responseBuffer = append(responseBuffer, chunk...)
+		}
 	}
 
 	duration := time.Since(startTime)
+	trace.add("upstream", map[string]interface{}{
+		"chunks":      totalChunks,
+		"upstream_ms": time.Since(upstreamStart).Milliseconds(),
+		"total_ms":    duration.Milliseconds(),
+	}, "流式响应结束")
+
+	// 客户端中途断开：上游请求已随之取消，按已收到的部分响应记录用量
+	if clientDisconnected(c) {
+		log.Printf("客户端已断开，取消分组 %s 的上游流式请求（已转发 %d 个数据块）", routeResult.GroupID, totalChunks)
+		if p.requestLogger != nil {
+			proxyKeyName, proxyKeyID := p.getProxyKeyInfo(c)
+			reqBody, _ := json.Marshal(req)
+			clientIP := logger.GetClientIP(c)
+			splitName, splitArm := trafficSplitLogFields(c)
+			logSpan := startTraceSpan(c, "request_log")
+			p.requestLogger.LogRequestWithTTFT(proxyKeyName, proxyKeyID, routeResult.GroupID, apiKey, req.Model, string(reqBody), string(responseBuffer), clientIP, statusClientClosedRequest, true, duration, errClientDisconnected, upstreamHeaders, trace.json(), shadowCorrelationFrom(c), false, splitName, splitArm, c.GetString("request_id"), ttft)
+			logSpan.End()
+		}
+		return errClientDisconnected
+	}
 
 	// 如果接收到数据，报告成功
 	if hasData {
 		p.keyManager.ReportSuccess(routeResult.GroupID, apiKey)
+		c.Set(upstreamUsageTokensKey, streamUsageTokens(lastChunks))
 
 		// 记录成功日志
 		if p.requestLogger != nil {
 			proxyKeyName, proxyKeyID := p.getProxyKeyInfo(c)
 			reqBody, _ := json.Marshal(req)
 			clientIP := logger.GetClientIP(c)
-			p.requestLogger.LogRequest(proxyKeyName, proxyKeyID, routeResult.GroupID, apiKey, req.Model, string(reqBody), string(responseBuffer), clientIP, 200, true, duration, nil)
+			splitName, splitArm := trafficSplitLogFields(c)
+			logSpan := startTraceSpan(c, "request_log")
+			p.requestLogger.LogRequestWithTTFT(proxyKeyName, proxyKeyID, routeResult.GroupID, apiKey, req.Model, string(reqBody), string(responseBuffer), clientIP, 200, true, duration, nil, upstreamHeaders, trace.json(), shadowCorrelationFrom(c), false, splitName, splitArm, c.GetString("request_id"), ttft)
+			logSpan.End()
 		}
-		return true
+		return nil
 	}
 
-	return false
+	if streamErr != nil {
+		return streamErr
+	}
+	noDataErr := fmt.Errorf("upstream stream returned no data")
+	p.reportUpstreamError(routeResult.GroupID, apiKey, noDataErr)
+	return noDataErr
 }
 
 // getProxyKeyInfo 获取代理密钥信息
@@ -916,12 +1800,12 @@ func (p *MultiProviderProxy) getProxyKeyInfo(c *gin.Context) (string, string) {
 			// 设置到上下文中以便后续使用
 			c.Set("proxy_key_name", proxyKey.Name)
 			c.Set("proxy_key_id", proxyKey.ID)
-			
+
 			// 更新代理密钥使用次数
 			if p.proxyKeyManager != nil {
 				p.proxyKeyManager.UpdateUsage(proxyKey.Key)
 			}
-			
+
 			return proxyKey.Name, proxyKey.ID
 		}
 	}
@@ -994,13 +1878,20 @@ func (p *MultiProviderProxy) handleGroupModels(c *gin.Context, groupID string) {
 
 	// 创建提供商配置
 	providerConfig := &providers.ProviderConfig{
-		BaseURL:       group.BaseURL,
-		APIKey:        apiKey,
-		Timeout:       group.Timeout,
-		MaxRetries:    group.MaxRetries,
-		Headers:       group.Headers,
-		ProviderType:  group.ProviderType,
-		RequestParams: group.RequestParams,
+		BaseURL:             group.BaseURL,
+		APIKey:              apiKey,
+		Timeout:             group.Timeout,
+		MaxRetries:          group.MaxRetries,
+		Headers:             group.Headers,
+		ProviderType:        group.ProviderType,
+		RequestParams:       group.RequestParams,
+		ChatCompletionsPath: group.ChatCompletionsPath,
+		ModelsPath:          group.ModelsPath,
+		ProxyURL:            group.ProxyURL,
+		TLS:                 group.TLS.ProviderOptions(),
+		VertexAI:            group.VertexAI.ProviderOptions(),
+		Transport:           group.Transport.ProviderOptions(),
+		DefaultMaxTokens:    group.DefaultMaxTokens,
 	}
 
 	// 获取提供商实例
@@ -1079,13 +1970,20 @@ func (p *MultiProviderProxy) handleAllModels(c *gin.Context) {
 
 		// 创建提供商配置
 		providerConfig := &providers.ProviderConfig{
-			BaseURL:       group.BaseURL,
-			APIKey:        apiKey,
-			Timeout:       group.Timeout,
-			MaxRetries:    group.MaxRetries,
-			Headers:       group.Headers,
-			ProviderType:  group.ProviderType,
-			RequestParams: group.RequestParams,
+			BaseURL:             group.BaseURL,
+			APIKey:              apiKey,
+			Timeout:             group.Timeout,
+			MaxRetries:          group.MaxRetries,
+			Headers:             group.Headers,
+			ProviderType:        group.ProviderType,
+			RequestParams:       group.RequestParams,
+			ChatCompletionsPath: group.ChatCompletionsPath,
+			ModelsPath:          group.ModelsPath,
+			ProxyURL:            group.ProxyURL,
+			TLS:                 group.TLS.ProviderOptions(),
+			VertexAI:            group.VertexAI.ProviderOptions(),
+			Transport:           group.Transport.ProviderOptions(),
+			DefaultMaxTokens:    group.DefaultMaxTokens,
 		}
 
 		// 获取提供商实例
@@ -1141,7 +2039,7 @@ func (p *MultiProviderProxy) StandardizeModelsResponse(rawModels interface{}, pr
 // standardizeModelsResponse 标准化不同提供商的模型响应格式
 func (p *MultiProviderProxy) standardizeModelsResponse(rawModels interface{}, providerType string) interface{} {
 	switch providerType {
-	case "openai", "azure_openai":
+	case "openai", "azure_openai", "openrouter", "openai_compatible":
 		// OpenAI格式已经是标准格式
 		return rawModels
 
@@ -1276,39 +2174,17 @@ func (p *MultiProviderProxy) standardizeGeminiModels(rawModels interface{}) inte
 }
 
 // standardizeAnthropicModels 标准化Anthropic模型响应
+// 提供商已将官方模型列表接口的响应转换为OpenAI格式，无法识别时返回空列表
 func (p *MultiProviderProxy) standardizeAnthropicModels(rawModels interface{}) interface{} {
-	// Anthropic通常不提供模型列表API，返回预定义的模型
-	predefinedModels := []map[string]interface{}{
-		{
-			"id":       "claude-3-sonnet-20240229",
-			"object":   "model",
-			"owned_by": "anthropic",
-		},
-		{
-			"id":       "claude-3-opus-20240229",
-			"object":   "model",
-			"owned_by": "anthropic",
-		},
-		{
-			"id":       "claude-3-haiku-20240307",
-			"object":   "model",
-			"owned_by": "anthropic",
-		},
-		{
-			"id":       "claude-2.1",
-			"object":   "model",
-			"owned_by": "anthropic",
-		},
-		{
-			"id":       "claude-2.0",
-			"object":   "model",
-			"owned_by": "anthropic",
-		},
+	if modelsMap, ok := rawModels.(map[string]interface{}); ok {
+		if _, exists := modelsMap["data"]; exists {
+			return modelsMap
+		}
 	}
 
 	return map[string]interface{}{
 		"object": "list",
-		"data":   predefinedModels,
+		"data":   []interface{}{},
 	}
 }
 
@@ -1322,7 +2198,7 @@ func (p *MultiProviderProxy) getProviderGroup(c *gin.Context, model string) stri
 	}
 
 	// 如果上下文中没有，尝试根据模型推断分组
-	if group, groupID := p.config.GetGroupByModel(model); group != nil {
+	if group, groupID := p.config.Snapshot().GetGroupByModel(model); group != nil {
 		return groupID
 	}
 
@@ -1332,7 +2208,7 @@ func (p *MultiProviderProxy) getProviderGroup(c *gin.Context, model string) stri
 
 // addModelAliases 为模型列表添加别名信息
 func (p *MultiProviderProxy) addModelAliases(models []map[string]interface{}, groupID string) []map[string]interface{} {
-	group, exists := p.config.UserGroups[groupID]
+	group, exists := p.config.Snapshot().UserGroups[groupID]
 	if !exists || len(group.ModelMappings) == 0 {
 		return models
 	}
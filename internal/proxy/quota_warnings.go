@@ -0,0 +1,259 @@
+package proxy
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/metrics"
+	"turnsapi/internal/providers"
+)
+
+// 额度预警参数
+const (
+	QuotaLevelOK       = "ok"
+	QuotaLevelWarning  = "warning"
+	QuotaLevelCritical = "critical"
+
+	defaultQuotaWarningPercent  = 80
+	defaultQuotaCriticalPercent = 95
+
+	quotaUsageTTL  = time.Minute // 没有重置时间的额度记录的有效期
+	maxQuotaEvents = 100         // 保留的最近预警事件数
+)
+
+// 额度使用比例指标，按分组取所有密钥和RPM计数中的最大值
+var quotaUsagePercent = metrics.Default.NewGaugeVec(
+	"turnsapi_quota_usage_percent",
+	"Highest percent of vendor quota or group RPM limit consumed in a provider group",
+	"group")
+
+// QuotaStatus 单个密钥或分组的额度使用情况
+type QuotaStatus struct {
+	GroupID   string     `json:"group_id"`
+	Key       string     `json:"key,omitempty"` // 密钥标签或掩码后的密钥，分组RPM计数为空
+	Source    string     `json:"source"`        // upstream：上游额度响应头；rpm：分组RPM限制
+	Dimension string     `json:"dimension"`
+	Percent   float64    `json:"percent"`
+	Limit     int64      `json:"limit"`
+	Remaining int64      `json:"remaining"`
+	Level     string     `json:"level"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ResetAt   *time.Time `json:"reset_at,omitempty"`
+}
+
+// QuotaEvent 额度使用比例升至预警阈值的事件
+type QuotaEvent struct {
+	ID        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	GroupID   string    `json:"group_id"`
+	Key       string    `json:"key,omitempty"`
+	Source    string    `json:"source"`
+	Dimension string    `json:"dimension"`
+	Percent   float64   `json:"percent"`
+	Level     string    `json:"level"`
+}
+
+// quotaTracker 跟踪各密钥和分组的额度使用比例，升至预警阈值时记录事件
+type quotaTracker struct {
+	config internal.ConfigSource
+
+	mu     sync.Mutex
+	usage  map[string]*QuotaStatus
+	events []QuotaEvent
+	nextID int64
+}
+
+// newQuotaTracker 创建额度跟踪器
+func newQuotaTracker(config internal.ConfigSource) *quotaTracker {
+	return &quotaTracker{
+		config: config,
+		usage:  make(map[string]*QuotaStatus),
+	}
+}
+
+// QuotaThresholds 读取额度预警阈值（百分比）
+func QuotaThresholds(config *internal.Config) (warning, critical float64) {
+	warning, critical = defaultQuotaWarningPercent, defaultQuotaCriticalPercent
+	if config == nil || config.GlobalSettings == nil || config.GlobalSettings.QuotaWarnings == nil {
+		return warning, critical
+	}
+	if settings := config.GlobalSettings.QuotaWarnings; settings.WarningPercent > 0 {
+		warning = settings.WarningPercent
+	}
+	if settings := config.GlobalSettings.QuotaWarnings; settings.CriticalPercent > 0 {
+		critical = settings.CriticalPercent
+	}
+	if critical < warning {
+		critical = warning
+	}
+	return warning, critical
+}
+
+// recordUpstream 根据上游响应头记录密钥的额度使用情况
+func (t *quotaTracker) recordUpstream(groupID, apiKey string, statusCode int, header http.Header) {
+	now := time.Now()
+	usage, ok := providers.ParseQuotaUsage(header, now)
+	if !ok {
+		return
+	}
+
+	status := &QuotaStatus{
+		GroupID:   groupID,
+		Key:       keyDisplayName(t.config.Snapshot(), apiKey),
+		Source:    "upstream",
+		Dimension: usage.Dimension,
+		Percent:   usage.Percent(),
+		Limit:     usage.Limit,
+		Remaining: usage.Remaining,
+		UpdatedAt: now,
+	}
+	if usage.ResetAfter > 0 {
+		resetAt := now.Add(usage.ResetAfter)
+		status.ResetAt = &resetAt
+	}
+	t.update("upstream/"+groupID+"/"+apiKey, status)
+}
+
+// recordRPM 根据分组RPM计数记录分组的额度使用情况
+func (t *quotaTracker) recordRPM(groupID string, current, limit int) {
+	if limit <= 0 {
+		return
+	}
+	remaining := limit - current
+	if remaining < 0 {
+		remaining = 0
+	}
+	t.update("rpm/"+groupID, &QuotaStatus{
+		GroupID:   groupID,
+		Source:    "rpm",
+		Dimension: "requests",
+		Percent:   float64(current) / float64(limit) * 100,
+		Limit:     int64(limit),
+		Remaining: int64(remaining),
+		UpdatedAt: time.Now(),
+	})
+}
+
+// update 保存额度使用情况，预警级别升高时记录事件
+func (t *quotaTracker) update(id string, status *QuotaStatus) {
+	warning, critical := QuotaThresholds(t.config.Snapshot())
+	status.Level = quotaLevel(status.Percent, warning, critical)
+
+	t.mu.Lock()
+	previous := QuotaLevelOK
+	if old, exists := t.usage[id]; exists && !old.expired(status.UpdatedAt) {
+		previous = old.Level
+	}
+	t.usage[id] = status
+
+	var event *QuotaEvent
+	if quotaLevelRank(status.Level) > quotaLevelRank(previous) {
+		t.nextID++
+		t.events = append(t.events, QuotaEvent{
+			ID:        t.nextID,
+			Time:      status.UpdatedAt,
+			GroupID:   status.GroupID,
+			Key:       status.Key,
+			Source:    status.Source,
+			Dimension: status.Dimension,
+			Percent:   status.Percent,
+			Level:     status.Level,
+		})
+		if len(t.events) > maxQuotaEvents {
+			t.events = t.events[len(t.events)-maxQuotaEvents:]
+		}
+		event = &t.events[len(t.events)-1]
+	}
+	groupPercent := t.groupPercentLocked(status.GroupID, status.UpdatedAt)
+	t.mu.Unlock()
+
+	quotaUsagePercent.Set(groupPercent, status.GroupID)
+	if event != nil {
+		log.Printf("[QUOTA_WARNING] 分组 %s 的额度已使用 %.1f%%（级别: %s，来源: %s，维度: %s，密钥: %s）",
+			event.GroupID, event.Percent, event.Level, event.Source, event.Dimension, event.Key)
+	}
+}
+
+// groupPercentLocked 计算分组未过期记录中的最大使用比例，调用方需持有锁
+func (t *quotaTracker) groupPercentLocked(groupID string, now time.Time) float64 {
+	max := 0.0
+	for _, status := range t.usage {
+		if status.GroupID == groupID && !status.expired(now) && status.Percent > max {
+			max = status.Percent
+		}
+	}
+	return max
+}
+
+// snapshot 获取未过期的额度使用情况（按使用比例降序）和最近的预警事件
+func (t *quotaTracker) snapshot() ([]QuotaStatus, []QuotaEvent) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	statuses := make([]QuotaStatus, 0, len(t.usage))
+	for id, status := range t.usage {
+		if status.expired(now) {
+			delete(t.usage, id)
+			continue
+		}
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Percent > statuses[j].Percent
+	})
+
+	events := make([]QuotaEvent, len(t.events))
+	copy(events, t.events)
+	return statuses, events
+}
+
+// forget 移除分组的额度记录，用于分组删除
+func (t *quotaTracker) forget(groupID string) {
+	t.mu.Lock()
+	for id, status := range t.usage {
+		if status.GroupID == groupID {
+			delete(t.usage, id)
+		}
+	}
+	t.mu.Unlock()
+
+	quotaUsagePercent.Delete(groupID)
+}
+
+// expired 判断额度记录是否已失效：已过重置时间，或没有重置时间且超过有效期
+func (s *QuotaStatus) expired(now time.Time) bool {
+	if s.ResetAt != nil {
+		return now.After(*s.ResetAt)
+	}
+	return now.Sub(s.UpdatedAt) > quotaUsageTTL
+}
+
+// quotaLevel 根据使用比例计算预警级别
+func quotaLevel(percent, warning, critical float64) string {
+	switch {
+	case percent >= critical:
+		return QuotaLevelCritical
+	case percent >= warning:
+		return QuotaLevelWarning
+	default:
+		return QuotaLevelOK
+	}
+}
+
+// quotaLevelRank 预警级别的严重程度
+func quotaLevelRank(level string) int {
+	switch level {
+	case QuotaLevelCritical:
+		return 2
+	case QuotaLevelWarning:
+		return 1
+	default:
+		return 0
+	}
+}
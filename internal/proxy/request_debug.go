@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求级调试参数
+const (
+	RequestDebugHeader   = "X-TurnsAPI-Debug"    // 客户端开启请求级调试的请求头
+	RequestDebugIDHeader = "X-TurnsAPI-Debug-ID" // 返回给客户端的调试ID，与服务日志中的 [DEBUG <id>] 对应
+
+	requestDebugContextKey = "request_debug"
+	maxDebugEvents         = 200 // 单个请求记录的调试事件上限
+)
+
+// DebugEvent 请求处理过程中的一条调试事件
+type DebugEvent struct {
+	OffsetMs int64                  `json:"offset_ms"` // 距请求开始的毫秒数
+	Stage    string                 `json:"stage"`     // request、route、keys、sticky、attempt、upstream、retry
+	Message  string                 `json:"message"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+}
+
+// requestDebugTrace 单个请求的调试追踪，事件同时输出到服务日志并记录到请求日志详情
+type requestDebugTrace struct {
+	id    string
+	start time.Time
+
+	mu      sync.Mutex
+	events  []DebugEvent
+	dropped int
+}
+
+// requestDebugEnabled 检查请求是否开启调试且代理密钥被允许使用请求级调试
+func requestDebugEnabled(c *gin.Context, config *internal.Config, proxyKey *logger.ProxyKey) bool {
+	switch strings.ToLower(strings.TrimSpace(c.GetHeader(RequestDebugHeader))) {
+	case "1", "true", "on", "yes":
+	default:
+		return false
+	}
+
+	if config == nil || config.Debug == nil {
+		return false
+	}
+	for _, allowed := range config.Debug.RequestDebugKeys {
+		if allowed == "*" || (proxyKey != nil && (allowed == proxyKey.ID || allowed == proxyKey.Name)) {
+			return true
+		}
+	}
+
+	keyName := ""
+	if proxyKey != nil {
+		keyName = proxyKey.Name
+	}
+	log.Printf("代理密钥 %q 未被允许使用请求级调试，忽略 %s 请求头", keyName, RequestDebugHeader)
+	return false
+}
+
+// startRequestDebug 为请求创建调试追踪并保存到上下文
+func startRequestDebug(c *gin.Context, start time.Time) *requestDebugTrace {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return nil
+	}
+	trace := &requestDebugTrace{id: hex.EncodeToString(id), start: start}
+	c.Set(requestDebugContextKey, trace)
+	c.Header(RequestDebugIDHeader, trace.id)
+	return trace
+}
+
+// requestDebugFrom 获取请求的调试追踪，未开启调试时返回nil，nil追踪的方法不做任何事
+func requestDebugFrom(c *gin.Context) *requestDebugTrace {
+	if value, exists := c.Get(requestDebugContextKey); exists {
+		if trace, ok := value.(*requestDebugTrace); ok {
+			return trace
+		}
+	}
+	return nil
+}
+
+// add 记录一条调试事件
+func (t *requestDebugTrace) add(stage string, fields map[string]interface{}, format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	offset := time.Since(t.start).Milliseconds()
+	if len(fields) > 0 {
+		log.Printf("[DEBUG %s] +%dms [%s] %s %v", t.id, offset, stage, message, fields)
+	} else {
+		log.Printf("[DEBUG %s] +%dms [%s] %s", t.id, offset, stage, message)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.events) >= maxDebugEvents {
+		t.dropped++
+		return
+	}
+	t.events = append(t.events, DebugEvent{OffsetMs: offset, Stage: stage, Message: message, Fields: fields})
+}
+
+// json 序列化目前为止的调试事件，用于写入请求日志
+func (t *requestDebugTrace) json() string {
+	if t == nil {
+		return ""
+	}
+
+	t.mu.Lock()
+	events := append([]DebugEvent(nil), t.events...)
+	if t.dropped > 0 {
+		events = append(events, DebugEvent{
+			OffsetMs: time.Since(t.start).Milliseconds(),
+			Stage:    "trace",
+			Message:  fmt.Sprintf("%d events dropped", t.dropped),
+		})
+	}
+	t.mu.Unlock()
+
+	data, err := json.Marshal(events)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
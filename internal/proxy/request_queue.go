@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/metrics"
+	"turnsapi/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// queuePollInterval 排在分组最前的请求检查RPM额度是否恢复的间隔
+const queuePollInterval = 100 * time.Millisecond
+
+// QueueWaitHeader 请求因分组容量已满排队等待的时间（毫秒），未排队时不返回
+const QueueWaitHeader = "X-TurnsAPI-Queue-Wait-Ms"
+
+// 请求排队指标
+var (
+	requestQueueTotal = metrics.Default.NewCounterVec(
+		"turnsapi_request_queue_total",
+		"Number of requests queued because all candidate provider groups were saturated, by result (served, timeout, full)",
+		"result")
+	requestQueueLength = metrics.Default.NewGaugeVec(
+		"turnsapi_request_queue_length",
+		"Number of requests currently waiting for provider group capacity")
+)
+
+// queuedRequest 请求的排队状态，第一次等待时加入队列，选出可用分组后离开
+type queuedRequest struct {
+	settings *internal.RequestQueueSettings
+	priority int
+	ticket   *ratelimit.QueueTicket
+	joinedAt time.Time
+	// concurrencySaturated 发送请求时所有分组的并发槽位都占用失败，需要排队后重新选择
+	concurrencySaturated bool
+}
+
+// newQueuedRequest 按配置创建请求的排队状态，未启用排队时返回nil
+func (p *MultiProviderProxy) newQueuedRequest(c *gin.Context) *queuedRequest {
+	config := p.config.Snapshot()
+	if config == nil || config.GlobalSettings == nil {
+		return nil
+	}
+	settings := config.GlobalSettings.RequestQueue
+	if settings == nil || !settings.Enabled {
+		return nil
+	}
+
+	priority := settings.DefaultPriority
+	proxyKeyName, proxyKeyID := p.getProxyKeyInfo(c)
+	if value, ok := settings.KeyPriorities[proxyKeyID]; ok && proxyKeyID != "" {
+		priority = value
+	} else if value, ok := settings.KeyPriorities[proxyKeyName]; ok && proxyKeyName != "" {
+		priority = value
+	}
+	return &queuedRequest{settings: settings, priority: priority}
+}
+
+// queuedAhead 是否有排在请求前面的请求在等待该分组，此时请求让出分组的容量
+func (p *MultiProviderProxy) queuedAhead(q *queuedRequest, groupID string) bool {
+	if q == nil {
+		return false
+	}
+	return p.requestQueue.Ahead(groupID, q.ticket, q.priority)
+}
+
+// waitForCapacity 所有候选分组的并发数或RPM已满时排队等待容量恢复
+// 返回false表示未启用排队、队列已满、等待超时或客户端已断开
+func (p *MultiProviderProxy) waitForCapacity(c *gin.Context, q *queuedRequest, candidateGroups []string) bool {
+	if q == nil {
+		return false
+	}
+	if q.ticket == nil {
+		ticket, ok := p.requestQueue.Join(q.priority, candidateGroups, q.settings.MaxSize)
+		if !ok {
+			log.Printf("请求队列已满（%d），不再排队", q.settings.MaxSize)
+			requestQueueTotal.Inc("full")
+			return false
+		}
+		requestQueueLength.Set(float64(p.requestQueue.Len()))
+		q.ticket = ticket
+		q.joinedAt = time.Now()
+		log.Printf("候选分组 %v 容量已满，请求以优先级 %d 排队等待", candidateGroups, q.priority)
+	}
+
+	ctx, cancel := context.WithDeadline(c.Request.Context(), q.joinedAt.Add(q.settings.MaxWait))
+	defer cancel()
+	if !p.requestQueue.Wait(ctx, q.ticket, queuePollInterval) {
+		log.Printf("请求排队 %v 后仍没有可用容量", time.Since(q.joinedAt).Round(time.Millisecond))
+		requestQueueTotal.Inc("timeout")
+		return false
+	}
+	return true
+}
+
+// leaveQueue 离开队列，排过队的请求在响应头中返回排队时间
+func (p *MultiProviderProxy) leaveQueue(c *gin.Context, q *queuedRequest, served bool) {
+	if q == nil || q.ticket == nil {
+		return
+	}
+	p.requestQueue.Leave(q.ticket)
+	requestQueueLength.Set(float64(p.requestQueue.Len()))
+	if served {
+		requestQueueTotal.Inc("served")
+	}
+	waited := time.Since(q.joinedAt)
+	c.Header(QueueWaitHeader, strconv.FormatInt(waited.Milliseconds(), 10))
+	requestDebugFrom(c).add("queue", map[string]interface{}{"priority": q.priority, "wait_ms": waited.Milliseconds()}, "分组容量已满，排队等待")
+	q.ticket = nil
+}
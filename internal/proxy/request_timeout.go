@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"turnsapi/internal"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestTimeoutHeader 客户端指定本次请求超时的请求头，值为秒数（如 "120"）或带单位的时长（如 "2m"）
+const requestTimeoutHeader = "X-Request-Timeout"
+
+// requestTimeoutKey 上下文中客户端指定的超时
+const requestTimeoutKey = "request_timeout"
+
+// requestTimeoutError 单次上游请求超过总超时
+type requestTimeoutError struct {
+	timeout time.Duration
+	err     error
+}
+
+func (e *requestTimeoutError) Error() string {
+	return fmt.Sprintf("upstream request timed out after %v: %v", e.timeout, e.err)
+}
+
+func (e *requestTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// parseRequestTimeout 解析请求头中的超时，不带单位时按秒计算
+func parseRequestTimeout(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0, fmt.Errorf("%s must be positive", requestTimeoutHeader)
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", requestTimeoutHeader, value)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("%s must be positive", requestTimeoutHeader)
+	}
+	return timeout, nil
+}
+
+// applyClientTimeout 读取客户端指定的超时并保存到上下文，超过上限时使用上限
+// 值无效时返回错误；上限为负数时忽略该请求头
+func applyClientTimeout(c *gin.Context, config *internal.Config) error {
+	value := c.GetHeader(requestTimeoutHeader)
+	if value == "" {
+		return nil
+	}
+	maxTimeout := internal.DefaultMaxRequestTimeout
+	if config.GlobalSettings != nil && config.GlobalSettings.MaxRequestTimeout != 0 {
+		maxTimeout = config.GlobalSettings.MaxRequestTimeout
+	}
+	if maxTimeout < 0 {
+		return nil
+	}
+
+	timeout, err := parseRequestTimeout(value)
+	if err != nil {
+		return err
+	}
+	if timeout > maxTimeout {
+		timeout = maxTimeout
+	}
+	c.Set(requestTimeoutKey, timeout)
+	return nil
+}
+
+// requestTotalTimeout 返回本次请求的总超时，客户端指定了超时时优先使用
+func requestTotalTimeout(c *gin.Context, groupTimeout time.Duration) time.Duration {
+	if timeout := c.GetDuration(requestTimeoutKey); timeout > 0 {
+		return timeout
+	}
+	return groupTimeout
+}
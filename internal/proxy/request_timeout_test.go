@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestParseRequestTimeout 测试超时请求头支持秒数和带单位的时长，拒绝非正数和无效值
+func TestParseRequestTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"120", 120 * time.Second, false},
+		{" 0.5 ", 500 * time.Millisecond, false},
+		{"2m", 2 * time.Minute, false},
+		{"0", 0, true},
+		{"-1s", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRequestTimeout(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseRequestTimeout(%q) = %v, %v，期望 %v（错误: %t）", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestRequestTimeout 测试客户端指定的超时生效且不超过上限，超时后返回504，无效的请求头返回400
+func TestRequestTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(hedgeTestResponse))
+		}
+	}))
+	defer upstream.Close()
+
+	serve := func(p *MultiProviderProxy, timeout string) (*httptest.ResponseRecorder, time.Duration) {
+		gin.SetMode(gin.TestMode)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+			strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hello"}]}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.Header.Set(requestTimeoutHeader, timeout)
+		start := time.Now()
+		p.HandleChatCompletion(c)
+		return w, time.Since(start)
+	}
+	errorCode := func(w *httptest.ResponseRecorder) string {
+		var body struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return body.Error.Code
+	}
+
+	p := newStreamTestProxy(t, nil, upstream)
+	w, elapsed := serve(p, "0.2")
+	if w.Code != http.StatusGatewayTimeout || errorCode(w) != "upstream_timeout" {
+		t.Fatalf("超时后应返回504，status=%d body=%s", w.Code, w.Body.String())
+	}
+	if elapsed > 3*time.Second {
+		t.Errorf("应按客户端指定的超时结束，耗时 %v", elapsed)
+	}
+
+	// 客户端指定的超时超过上限时使用上限
+	p = newStreamTestProxy(t, &internal.GlobalSettings{MaxRequestTimeout: 200 * time.Millisecond}, upstream)
+	w, elapsed = serve(p, "60")
+	if w.Code != http.StatusGatewayTimeout || elapsed > 3*time.Second {
+		t.Errorf("超时应不超过上限，status=%d 耗时 %v", w.Code, elapsed)
+	}
+
+	if w, _ = serve(p, "soon"); w.Code != http.StatusBadRequest || errorCode(w) != "invalid_timeout" {
+		t.Errorf("无效的超时请求头应返回400，status=%d body=%s", w.Code, w.Body.String())
+	}
+}
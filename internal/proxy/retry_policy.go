@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"math/rand"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/providers"
+)
+
+// 重试策略默认值
+const (
+	defaultMaxAttempts      = 3
+	defaultInitialBackoffMs = 200
+	defaultMaxBackoffMs     = 5000
+	defaultMaxTotalTimeMs   = 30000
+)
+
+// defaultRetryableStatusCodes 默认可重试的上游HTTP状态码
+var defaultRetryableStatusCodes = []int{408, 429, 500, 502, 503, 504}
+
+// retryPolicy 已填充默认值的重试策略
+type retryPolicy struct {
+	maxAttempts       int
+	retryableCodes    map[int]bool
+	initialBackoff    time.Duration
+	maxBackoff        time.Duration
+	maxTotalTime      time.Duration
+	respectRetryAfter bool
+}
+
+// resolveRetryPolicy 将分组配置的重试策略与默认值合并
+func resolveRetryPolicy(config *internal.RetryPolicy) *retryPolicy {
+	policy := &retryPolicy{
+		maxAttempts:       defaultMaxAttempts,
+		initialBackoff:    defaultInitialBackoffMs * time.Millisecond,
+		maxBackoff:        defaultMaxBackoffMs * time.Millisecond,
+		maxTotalTime:      defaultMaxTotalTimeMs * time.Millisecond,
+		respectRetryAfter: true,
+	}
+
+	codes := defaultRetryableStatusCodes
+	if config != nil {
+		if config.MaxAttempts > 0 {
+			policy.maxAttempts = config.MaxAttempts
+		}
+		if len(config.RetryableStatusCodes) > 0 {
+			codes = config.RetryableStatusCodes
+		}
+		if config.InitialBackoffMs > 0 {
+			policy.initialBackoff = time.Duration(config.InitialBackoffMs) * time.Millisecond
+		}
+		if config.MaxBackoffMs > 0 {
+			policy.maxBackoff = time.Duration(config.MaxBackoffMs) * time.Millisecond
+		}
+		if config.MaxTotalTimeMs > 0 {
+			policy.maxTotalTime = time.Duration(config.MaxTotalTimeMs) * time.Millisecond
+		}
+		if config.RespectRetryAfter != nil {
+			policy.respectRetryAfter = *config.RespectRetryAfter
+		}
+	}
+
+	policy.retryableCodes = make(map[int]bool, len(codes))
+	for _, code := range codes {
+		policy.retryableCodes[code] = true
+	}
+	return policy
+}
+
+// isRetryable 判断上游错误是否值得换密钥/分组重试
+// 上下文超长、内容拦截等请求本身的问题重试也不会成功；认证失败和额度耗尽只与当前密钥有关，换密钥重试；
+// 其余按状态码判断，无法识别状态码的错误（如网络错误）视为可重试
+func (rp *retryPolicy) isRetryable(err error) bool {
+	switch category := providers.ClassifyError(err); {
+	case category.RequestSpecific():
+		return false
+	case category == providers.ErrorCategoryAuth || category == providers.ErrorCategoryQuota:
+		return true
+	}
+	statusCode := providers.StatusCodeFromError(err)
+	if statusCode == 0 {
+		return true
+	}
+	return rp.retryableCodes[statusCode]
+}
+
+// backoff 计算第attempt次失败后的等待时间：指数退避加随机抖动，并遵循上游Retry-After
+// 认证失败和额度耗尽的密钥已被隔离，换用其他密钥无需等待；连接或首字节超时说明上游不可达，立即故障转移
+func (rp *retryPolicy) backoff(attempt int, err error) time.Duration {
+	if category := providers.ClassifyError(err); category == providers.ErrorCategoryAuth || category == providers.ErrorCategoryQuota {
+		return 0
+	}
+	if providers.IsStageTimeout(err) {
+		return 0
+	}
+
+	delay := rp.initialBackoff
+	for i := 1; i < attempt && delay < rp.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > rp.maxBackoff {
+		delay = rp.maxBackoff
+	}
+	if delay > 0 {
+		// 在 [delay/2, delay] 区间内抖动，避免重试请求同时到达上游
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+
+	if rp.respectRetryAfter {
+		if retryAfter := providers.RetryAfterFromError(err); retryAfter > delay {
+			delay = retryAfter
+		}
+	}
+	return delay
+}
+
+// retryPolicyForGroup 获取分组的重试策略
+func (p *MultiProviderProxy) retryPolicyForGroup(groupID string) *retryPolicy {
+	if group, exists := p.config.Snapshot().UserGroups[groupID]; exists && group != nil {
+		return resolveRetryPolicy(group.RetryPolicy)
+	}
+	return resolveRetryPolicy(nil)
+}
+
+// upstreamTimeoutsForGroup 获取分组上游请求的连接、首字节和总超时
+// 总超时依次取分组的 timeouts.total_ms、分组的 timeout 和全局 default_timeout，都未设置时为300秒
+func (p *MultiProviderProxy) upstreamTimeoutsForGroup(groupID string) (connect, firstByte, total time.Duration) {
+	config := p.config.Snapshot()
+	total = internal.DefaultRequestTimeout
+	if config.GlobalSettings != nil && config.GlobalSettings.DefaultTimeout > 0 {
+		total = config.GlobalSettings.DefaultTimeout
+	}
+	group, exists := config.UserGroups[groupID]
+	if !exists || group == nil {
+		return 0, 0, total
+	}
+	if group.Timeout > 0 {
+		total = group.Timeout
+	}
+	if group.Timeouts == nil {
+		return 0, 0, total
+	}
+	if group.Timeouts.TotalMs > 0 {
+		total = time.Duration(group.Timeouts.TotalMs) * time.Millisecond
+	}
+	return time.Duration(group.Timeouts.ConnectMs) * time.Millisecond,
+		time.Duration(group.Timeouts.FirstByteMs) * time.Millisecond, total
+}
+
+// maxAttemptsForGroups 获取候选分组中最大的尝试次数，作为本次请求的重试预算
+func (p *MultiProviderProxy) maxAttemptsForGroups(groupIDs []string) int {
+	maxAttempts := 0
+	for _, groupID := range groupIDs {
+		if attempts := p.retryPolicyForGroup(groupID).maxAttempts; attempts > maxAttempts {
+			maxAttempts = attempts
+		}
+	}
+	if maxAttempts == 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	return maxAttempts
+}
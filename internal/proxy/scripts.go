@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"turnsapi/internal"
+	"turnsapi/internal/providers"
+	"turnsapi/internal/router"
+	"turnsapi/internal/scripting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cachedScript 分组编译后的脚本及其来源指纹
+type cachedScript struct {
+	fingerprint string
+	script      *scripting.Script
+	failed      string // 最近一次加载失败的来源指纹，同一版本只记录一次警告
+}
+
+// scriptCache 按分组缓存编译后的Lua脚本，脚本内容或文件修改后重新编译
+type scriptCache struct {
+	mu      sync.Mutex
+	scripts map[string]*cachedScript
+}
+
+// newScriptCache 创建脚本缓存
+func newScriptCache() *scriptCache {
+	return &scriptCache{scripts: make(map[string]*cachedScript)}
+}
+
+// get 获取分组的脚本，未配置脚本时返回nil
+// 脚本文件修改后无法读取或编译时继续使用上一次编译成功的脚本
+func (sc *scriptCache) get(groupID string, settings *internal.ScriptSettings) (*scripting.Script, error) {
+	if settings.IsZero() {
+		return nil, nil
+	}
+	fingerprint, err := scriptFingerprint(settings)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	cached := sc.scripts[groupID]
+	if err == nil && cached != nil && (cached.fingerprint == fingerprint || cached.failed == fingerprint) {
+		return cached.script, nil
+	}
+
+	var script *scripting.Script
+	if err == nil {
+		var source string
+		if source, err = settings.LoadSource(); err == nil {
+			script, err = scripting.Compile(groupID, source, settings.Timeout())
+		}
+	}
+	if err != nil {
+		if cached != nil {
+			if fingerprint != "" {
+				cached.failed = fingerprint
+			}
+			log.Printf("警告: 分组 %s 的脚本加载失败，继续使用上一版本: %v", groupID, err)
+			return cached.script, nil
+		}
+		return nil, err
+	}
+	sc.scripts[groupID] = &cachedScript{fingerprint: fingerprint, script: script}
+	return script, nil
+}
+
+// scriptFingerprint 计算脚本来源指纹：内联脚本使用内容，脚本文件使用路径、大小和修改时间
+func scriptFingerprint(settings *internal.ScriptSettings) (string, error) {
+	timeout := strconv.Itoa(settings.TimeoutMs)
+	if settings.File == "" {
+		return timeout + "\x00" + settings.Source, nil
+	}
+	info, err := os.Stat(settings.File)
+	if err != nil {
+		return "", fmt.Errorf("failed to read script file: %w", err)
+	}
+	return fmt.Sprintf("%s\x00%s\x00%d\x00%d", timeout, settings.File, info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// groupScript 获取请求所在分组的脚本和传给脚本的请求信息
+func (p *MultiProviderProxy) groupScript(c *gin.Context, routeResult *router.RouteResult) (*scripting.Script, scripting.Info, error) {
+	group, exists := p.config.Snapshot().UserGroups[routeResult.GroupID]
+	if !exists || group == nil || group.Script.IsZero() {
+		return nil, scripting.Info{}, nil
+	}
+	script, err := p.scripts.get(routeResult.GroupID, group.Script)
+	if err != nil {
+		return nil, scripting.Info{}, err
+	}
+	_, proxyKeyID := p.getProxyKeyInfo(c)
+	return script, scripting.Info{
+		GroupID:      routeResult.GroupID,
+		ProviderType: group.ProviderType,
+		ProxyKeyID:   proxyKeyID,
+		RequestID:    c.GetString("request_id"),
+	}, nil
+}
+
+// runRequestScript 调用分组脚本的 on_request 转换发往上游的请求，返回带有脚本请求头的上下文
+// 脚本出错或拒绝请求时返回请求类错误，不计入密钥和分组的失败，也不再重试
+func (p *MultiProviderProxy) runRequestScript(ctx context.Context, c *gin.Context, routeResult *router.RouteResult, upstreamReq *providers.ChatCompletionRequest) (context.Context, error) {
+	script, info, err := p.groupScript(c, routeResult)
+	if err == nil && script.HasRequest() {
+		var header http.Header
+		if header, err = script.TransformRequest(ctx, upstreamReq, info); err == nil {
+			return providers.WithRequestHeaders(ctx, header), nil
+		}
+	}
+	if err != nil {
+		return ctx, &providers.ToolCallError{
+			Type:       "invalid_request_error",
+			Code:       "script_rejected",
+			Message:    err.Error(),
+			StatusCode: http.StatusBadRequest,
+			Category:   providers.ErrorCategoryInvalidRequest,
+		}
+	}
+	return ctx, nil
+}
+
+// runResponseScript 调用分组脚本的 on_response 转换非流式响应，脚本出错时记录警告并返回原始响应
+func (p *MultiProviderProxy) runResponseScript(ctx context.Context, c *gin.Context, routeResult *router.RouteResult, response *providers.ChatCompletionResponse) *providers.ChatCompletionResponse {
+	script, info, err := p.groupScript(c, routeResult)
+	if err != nil || !script.HasResponse() {
+		return response
+	}
+	transformed := *response
+	if err := script.TransformResponse(ctx, &transformed, info); err != nil {
+		log.Printf("警告: 分组 %s 的响应脚本执行失败，返回原始响应: %v", routeResult.GroupID, err)
+		return response
+	}
+	return &transformed
+}
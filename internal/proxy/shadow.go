@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"math/big"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/logger"
+	"turnsapi/internal/providers"
+	"turnsapi/internal/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 影子流量参数
+const (
+	shadowCorrelationContextKey = "shadow_correlation_id"
+	defaultShadowTimeout        = 300 * time.Second
+)
+
+// pendingShadow 已抽中的影子请求，原请求成功后才会发送
+type pendingShadow struct {
+	config        internal.ShadowConfig
+	sourceGroup   string
+	correlationID string
+	req           providers.ChatCompletionRequest
+}
+
+// prepareShadow 检查分组是否配置影子流量并按比例抽样，抽中时复制请求并在上下文中记录关联ID
+// 复制发生在处理原请求之前，影子请求不受原分组参数覆盖和模型映射的影响
+func (p *MultiProviderProxy) prepareShadow(c *gin.Context, req *providers.ChatCompletionRequest, groupID string) *pendingShadow {
+	group, exists := p.config.Snapshot().UserGroups[groupID]
+	if !exists || group == nil || group.Shadow == nil || !shadowSampled(group.Shadow.SamplePercent) {
+		c.Set(shadowCorrelationContextKey, "")
+		return nil
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil
+	}
+	shadow := &pendingShadow{
+		config:        *group.Shadow,
+		sourceGroup:   groupID,
+		correlationID: hex.EncodeToString(id),
+		req:           *req,
+	}
+	shadow.req.Stream = false
+	shadow.req.StreamOptions = nil
+	c.Set(shadowCorrelationContextKey, shadow.correlationID)
+	return shadow
+}
+
+// shadowSampled 按百分比抽样
+func shadowSampled(percent float64) bool {
+	if percent >= 100 {
+		return true
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(10000))
+	if err != nil {
+		return false
+	}
+	return float64(n.Int64()) < percent*100
+}
+
+// shadowCorrelationFrom 获取当前尝试的影子流量关联ID，未抽中时返回空字符串
+func shadowCorrelationFrom(c *gin.Context) string {
+	return c.GetString(shadowCorrelationContextKey)
+}
+
+// dispatchShadow 原请求成功后在后台发送影子请求，影子请求的响应只记录到请求日志
+func (p *MultiProviderProxy) dispatchShadow(c *gin.Context, shadow *pendingShadow) {
+	proxyKeyName, proxyKeyID := p.getProxyKeyInfo(c)
+	clientIP := logger.GetClientIP(c)
+	go p.runShadow(shadow, proxyKeyName, proxyKeyID, clientIP)
+}
+
+// runShadow 发送影子请求到目标分组，目标分组限流、并发已满或没有可用密钥时放弃，不影响正式流量
+func (p *MultiProviderProxy) runShadow(shadow *pendingShadow, proxyKeyName, proxyKeyID, clientIP string) {
+	target := shadow.config.TargetGroup
+	req := shadow.req
+	if shadow.config.Model != "" {
+		req.Model = shadow.config.Model
+	}
+
+	group, exists := p.config.Snapshot().UserGroups[target]
+	if !exists || group == nil || !group.Enabled {
+		log.Printf("影子流量目标分组 %s 不存在或未启用，跳过（来源分组 %s）", target, shadow.sourceGroup)
+		return
+	}
+	if requests := p.upstreamRequests(target, &req); requests > p.maxFanOutChoices() {
+		log.Printf("影子流量目标分组 %s 不支持 n，请求的 %d 个选择超过上限，跳过", target, requests)
+		return
+	}
+	if !p.allowRPM(target) {
+		log.Printf("影子流量目标分组 %s 已达RPM限制，跳过", target)
+		return
+	}
+
+	apiKey, err := p.keyManager.GetNextKeyForGroup(target)
+	if err != nil {
+		log.Printf("影子流量目标分组 %s 没有可用密钥，跳过: %v", target, err)
+		return
+	}
+	release, acquired := p.acquireConcurrency(target, apiKey)
+	if !acquired {
+		log.Printf("影子流量目标分组 %s 并发请求数已达上限，跳过", target)
+		return
+	}
+	defer release()
+
+	routeResult, err := p.providerRouter.RouteWithRetry(&router.RouteRequest{Model: req.Model, ProviderGroup: target})
+	if err != nil {
+		log.Printf("影子流量目标分组 %s 路由失败，跳过: %v", target, err)
+		return
+	}
+	p.providerRouter.UpdateProviderConfig(routeResult.ProviderConfig, apiKey)
+
+	timeout := group.Timeout
+	if timeout <= 0 {
+		timeout = defaultShadowTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	startTime := time.Now()
+	upstreamReq := p.buildUpstreamRequest(nil, &req, routeResult)
+	response, err := routeResult.Provider.ChatCompletion(ctx, upstreamReq)
+	duration := time.Since(startTime)
+
+	statusCode := 200
+	respBody := ""
+	if err != nil {
+		statusCode = 502
+		if code := providers.StatusCodeFromError(err); code > 0 {
+			statusCode = code
+		}
+		p.reportUpstreamError(target, apiKey, err)
+		log.Printf("影子请求失败：分组 %s 关联ID %s: %v", target, shadow.correlationID, err)
+	} else {
+		p.keyManager.ReportSuccess(target, apiKey)
+		if data, marshalErr := json.Marshal(response); marshalErr == nil {
+			respBody = string(data)
+		}
+	}
+
+	if p.requestLogger != nil {
+		reqBody, _ := json.Marshal(req)
+		p.requestLogger.LogRequestWithCorrelation(proxyKeyName, proxyKeyID, target, apiKey, req.Model, string(reqBody), respBody, clientIP,
+			statusCode, false, duration, err, nil, "", shadow.correlationID, true)
+	}
+}
@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/providers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 会话粘滞路由参数
+const (
+	defaultStickySessionTTL    = 30 * time.Minute
+	defaultStickySessionHeader = "X-Conversation-ID"
+
+	stickySessionSweepInterval = time.Minute
+	maxStickySessions          = 100000 // 内存中保留的会话绑定上限
+
+	StickySourceHeader       = "header"        // 会话ID来自请求头
+	StickySourceFirstMessage = "first_message" // 会话标识为第一条用户消息的哈希
+)
+
+// StickySession 会话与分组、密钥的绑定
+type StickySession struct {
+	Key       string    `json:"key"`    // 会话标识的哈希
+	Source    string    `json:"source"` // header 或 first_message
+	GroupID   string    `json:"group_id"`
+	APIKey    string    `json:"api_key"` // 掩码后的密钥
+	Model     string    `json:"model"`
+	Hits      int64     `json:"hits"` // 命中绑定的请求数
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	apiKey string
+}
+
+// stickySessionStore 会话绑定存储，只保存在当前实例内存中
+type stickySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]*StickySession
+	lastSweep time.Time
+}
+
+// newStickySessionStore 创建会话绑定存储
+func newStickySessionStore() *stickySessionStore {
+	return &stickySessionStore{sessions: make(map[string]*StickySession)}
+}
+
+// stickySessionSettings 读取会话粘滞设置，未启用时返回nil
+func stickySessionSettings(config *internal.Config) *internal.StickySessionSettings {
+	if config == nil || config.GlobalSettings == nil || config.GlobalSettings.StickySessions == nil ||
+		!config.GlobalSettings.StickySessions.Enabled {
+		return nil
+	}
+	return config.GlobalSettings.StickySessions
+}
+
+// stickySessionTTL 会话绑定的有效期
+func stickySessionTTL(settings *internal.StickySessionSettings) time.Duration {
+	if settings.TTL > 0 {
+		return settings.TTL
+	}
+	return defaultStickySessionTTL
+}
+
+// stickySessionKey 计算请求的会话标识：优先使用客户端提供的会话ID，否则使用第一条用户消息的哈希
+// 标识中包含代理密钥和模型，不同调用方或模型的相同会话ID互不影响
+func stickySessionKey(c *gin.Context, settings *internal.StickySessionSettings, req *providers.ChatCompletionRequest, proxyKeyID string) (string, string) {
+	header := settings.Header
+	if header == "" {
+		header = defaultStickySessionHeader
+	}
+
+	var source, conversation string
+	if id := c.GetHeader(header); id != "" {
+		source, conversation = StickySourceHeader, id
+	} else if settings.HashFirstMessage == nil || *settings.HashFirstMessage {
+		for _, message := range req.Messages {
+			if message.Role != "user" {
+				continue
+			}
+			if text, ok := message.Content.(string); ok {
+				conversation = text
+			} else if data, err := json.Marshal(message.Content); err == nil {
+				conversation = string(data)
+			}
+			source = StickySourceFirstMessage
+			break
+		}
+	}
+	if conversation == "" {
+		return "", ""
+	}
+
+	sum := sha256.Sum256([]byte(proxyKeyID + "\x00" + req.Model + "\x00" + source + "\x00" + conversation))
+	return hex.EncodeToString(sum[:16]), source
+}
+
+// lookup 获取未过期的会话绑定并记录命中
+func (s *stickySessionStore) lookup(key string, now time.Time) (groupID, apiKey string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[key]
+	if !exists {
+		return "", "", false
+	}
+	if now.After(session.ExpiresAt) {
+		delete(s.sessions, key)
+		return "", "", false
+	}
+	session.Hits++
+	return session.GroupID, session.apiKey, true
+}
+
+// bind 绑定会话到请求成功的分组和密钥，并刷新有效期
+func (s *stickySessionStore) bind(key, source, groupID, apiKey, model string, ttl time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > stickySessionSweepInterval {
+		s.sweepLocked(now)
+	}
+
+	session, exists := s.sessions[key]
+	if !exists {
+		if len(s.sessions) >= maxStickySessions {
+			log.Printf("警告: 会话绑定数已达上限 %d，不再绑定新会话", maxStickySessions)
+			return
+		}
+		session = &StickySession{Key: key, Source: source, CreatedAt: now}
+		s.sessions[key] = session
+	}
+	if session.GroupID != "" && (session.GroupID != groupID || session.apiKey != apiKey) {
+		log.Printf("会话 %s 由分组 %s 密钥 %s 转移到分组 %s 密钥 %s",
+			key, session.GroupID, session.APIKey, groupID, maskAPIKey(apiKey))
+	}
+	session.GroupID = groupID
+	session.apiKey = apiKey
+	session.APIKey = maskAPIKey(apiKey)
+	session.Model = model
+	session.ExpiresAt = now.Add(ttl)
+}
+
+// sweepLocked 删除过期的会话绑定，调用方需持有锁
+func (s *stickySessionStore) sweepLocked(now time.Time) {
+	for key, session := range s.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(s.sessions, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+// snapshot 获取未过期的会话绑定，按最近过期时间倒序
+func (s *stickySessionStore) snapshot(now time.Time) []StickySession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweepLocked(now)
+	sessions := make([]StickySession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, *session)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ExpiresAt.After(sessions[j].ExpiresAt) })
+	return sessions
+}
+
+// clear 删除会话绑定，groupID为空时删除全部，返回删除的数量
+func (s *stickySessionStore) clear(groupID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for key, session := range s.sessions {
+		if groupID == "" || session.GroupID == groupID {
+			delete(s.sessions, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// preferStickySession 将会话绑定的分组和密钥移到最前面，返回是否命中
+func preferStickySession(groups []string, groupKeys map[string][]string, groupID, apiKey string) ([]string, bool) {
+	keys, exists := groupKeys[groupID]
+	if !exists {
+		return groups, false
+	}
+
+	ordered := make([]string, 0, len(groups))
+	ordered = append(ordered, groupID)
+	for _, id := range groups {
+		if id != groupID {
+			ordered = append(ordered, id)
+		}
+	}
+
+	for i, key := range keys {
+		if key == apiKey {
+			reordered := make([]string, 0, len(keys))
+			reordered = append(reordered, key)
+			reordered = append(reordered, keys[:i]...)
+			reordered = append(reordered, keys[i+1:]...)
+			groupKeys[groupID] = reordered
+			break
+		}
+	}
+	return ordered, true
+}
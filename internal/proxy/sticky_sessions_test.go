@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/providers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestStickySessionKey 测试会话标识优先使用请求头，否则使用第一条用户消息，并按代理密钥和模型隔离
+func TestStickySessionKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	settings := &internal.StickySessionSettings{Enabled: true}
+	newContext := func(conversationID string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		if conversationID != "" {
+			c.Request.Header.Set(defaultStickySessionHeader, conversationID)
+		}
+		return c
+	}
+	req := &providers.ChatCompletionRequest{
+		Model: "gpt-4o",
+		Messages: []providers.ChatMessage{
+			{Role: "system", Content: "You are helpful."},
+			{Role: "user", Content: "first question"},
+		},
+	}
+
+	headerKey, source := stickySessionKey(newContext("conv-1"), settings, req, "pk-1")
+	if headerKey == "" || source != StickySourceHeader {
+		t.Fatalf("提供会话ID时应使用请求头，key=%q source=%q", headerKey, source)
+	}
+	messageKey, source := stickySessionKey(newContext(""), settings, req, "pk-1")
+	if messageKey == "" || source != StickySourceFirstMessage {
+		t.Fatalf("未提供会话ID时应使用第一条用户消息，key=%q source=%q", messageKey, source)
+	}
+
+	// 后续轮次追加消息后第一条用户消息不变，会话标识相同
+	followUp := *req
+	followUp.Messages = append(append([]providers.ChatMessage(nil), req.Messages...),
+		providers.ChatMessage{Role: "assistant", Content: "answer"},
+		providers.ChatMessage{Role: "user", Content: "second question"})
+	if key, _ := stickySessionKey(newContext(""), settings, &followUp, "pk-1"); key != messageKey {
+		t.Error("同一会话的后续请求应得到相同的会话标识")
+	}
+
+	if key, _ := stickySessionKey(newContext("conv-1"), settings, req, "pk-2"); key == headerKey {
+		t.Error("不同代理密钥的相同会话ID不应共用绑定")
+	}
+	otherModel := *req
+	otherModel.Model = "gpt-4o-mini"
+	if key, _ := stickySessionKey(newContext("conv-1"), settings, &otherModel, "pk-1"); key == headerKey {
+		t.Error("不同模型的相同会话ID不应共用绑定")
+	}
+
+	disabled := false
+	settings.HashFirstMessage = &disabled
+	if key, _ := stickySessionKey(newContext(""), settings, req, "pk-1"); key != "" {
+		t.Errorf("关闭消息哈希且未提供会话ID时不应绑定，得到 %q", key)
+	}
+}
+
+// TestStickySessionStore 测试绑定、命中计数、过期和按分组清除
+func TestStickySessionStore(t *testing.T) {
+	store := newStickySessionStore()
+	now := time.Now()
+
+	store.bind("conv-a", StickySourceHeader, "group_a", "sk-a", "gpt-4o", time.Minute, now)
+	store.bind("conv-b", StickySourceHeader, "group_b", "sk-b", "gpt-4o", time.Minute, now)
+
+	groupID, apiKey, ok := store.lookup("conv-a", now.Add(30*time.Second))
+	if !ok || groupID != "group_a" || apiKey != "sk-a" {
+		t.Fatalf("应命中绑定，group=%q key=%q ok=%t", groupID, apiKey, ok)
+	}
+	if _, _, ok := store.lookup("conv-a", now.Add(2*time.Minute)); ok {
+		t.Error("过期的绑定不应命中")
+	}
+
+	// 故障转移后重新绑定到新的分组并刷新有效期
+	store.bind("conv-b", StickySourceHeader, "group_a", "sk-a2", "gpt-4o", time.Minute, now.Add(50*time.Second))
+	if groupID, apiKey, ok := store.lookup("conv-b", now.Add(90*time.Second)); !ok || groupID != "group_a" || apiKey != "sk-a2" {
+		t.Errorf("重新绑定后应使用新的分组和密钥，group=%q key=%q ok=%t", groupID, apiKey, ok)
+	}
+
+	sessions := store.snapshot(now.Add(90 * time.Second))
+	if len(sessions) != 1 || sessions[0].Hits != 1 || sessions[0].APIKey == "sk-a2" {
+		t.Errorf("快照应只包含未过期的绑定，记录命中次数且密钥已掩码，得到 %+v", sessions)
+	}
+
+	store.bind("conv-c", StickySourceHeader, "group_c", "sk-c", "gpt-4o", time.Minute, now)
+	if removed := store.clear("group_a"); removed != 1 {
+		t.Errorf("应清除 group_a 的1个绑定，实际清除 %d 个", removed)
+	}
+	if removed := store.clear(""); removed != 1 {
+		t.Errorf("应清除剩余的1个绑定，实际清除 %d 个", removed)
+	}
+}
+
+// TestPreferStickySession 测试命中时绑定的分组排在最前，绑定的密钥排在该分组的最前
+func TestPreferStickySession(t *testing.T) {
+	groupKeys := map[string][]string{
+		"group_a": {"sk-a1", "sk-a2"},
+		"group_b": {"sk-b1", "sk-b2", "sk-b3"},
+	}
+
+	groups, hit := preferStickySession([]string{"group_a", "group_b"}, groupKeys, "group_b", "sk-b3")
+	if !hit {
+		t.Fatal("绑定的分组可用时应命中")
+	}
+	if !reflect.DeepEqual(groups, []string{"group_b", "group_a"}) {
+		t.Errorf("绑定的分组应排在最前，得到 %v", groups)
+	}
+	if !reflect.DeepEqual(groupKeys["group_b"], []string{"sk-b3", "sk-b1", "sk-b2"}) {
+		t.Errorf("绑定的密钥应排在最前，得到 %v", groupKeys["group_b"])
+	}
+
+	if groups, hit := preferStickySession([]string{"group_a", "group_b"}, groupKeys, "group_c", "sk-c1"); hit || !reflect.DeepEqual(groups, []string{"group_a", "group_b"}) {
+		t.Errorf("绑定的分组不可用时不应改变顺序，得到 %v hit=%t", groups, hit)
+	}
+}
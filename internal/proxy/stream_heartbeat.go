@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/providers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamHeartbeatOnlyKey 上下文中标记流式响应只写入了心跳、尚未写入上游数据
+const streamHeartbeatOnlyKey = "stream_heartbeat_only"
+
+// streamHeartbeatInterval 读取流式响应心跳间隔，未启用时返回0
+func streamHeartbeatInterval(config *internal.Config) time.Duration {
+	if config == nil || config.GlobalSettings == nil || config.GlobalSettings.StreamHeartbeat == nil ||
+		!config.GlobalSettings.StreamHeartbeat.Enabled {
+		return 0
+	}
+	return config.GlobalSettings.StreamHeartbeat.Interval
+}
+
+// writeStreamHeartbeat 向客户端写入一条SSE注释行，客户端按SSE规范忽略注释
+// 第一次写入时提交响应头，之后仍可以重试其他密钥或分组，错误以SSE事件返回
+func writeStreamHeartbeat(c *gin.Context, flusher http.Flusher) {
+	if !c.Writer.Written() {
+		c.Set(streamHeartbeatOnlyKey, true)
+		startStreamResponse(c)
+	}
+	c.Writer.WriteString(": ping\n\n")
+	flusher.Flush()
+}
+
+// streamHeartbeatOnly 响应是否只写入了心跳，此时尚未向客户端返回任何上游数据
+func streamHeartbeatOnly(c *gin.Context) bool {
+	return c.GetBool(streamHeartbeatOnlyKey)
+}
+
+// openStreamWithHeartbeat 建立上游流式连接，等待上游响应头期间按间隔发送心跳
+// 只在当前goroutine写入响应，避免与之后的数据写入并发
+func openStreamWithHeartbeat(c *gin.Context, flusher http.Flusher, interval time.Duration, open func() (<-chan providers.StreamResponse, error)) (<-chan providers.StreamResponse, error) {
+	if interval <= 0 {
+		return open()
+	}
+
+	type openResult struct {
+		stream <-chan providers.StreamResponse
+		err    error
+	}
+	result := make(chan openResult, 1)
+	go func() {
+		stream, err := open()
+		result <- openResult{stream: stream, err: err}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case r := <-result:
+			return r.stream, r.err
+		case <-ticker.C:
+			writeStreamHeartbeat(c, flusher)
+		}
+	}
+}
+
+// respondError 返回错误响应；流式响应已发送心跳时响应头已提交，错误以SSE数据事件返回
+func respondError(c *gin.Context, status int, body gin.H) {
+	if !streamHeartbeatOnly(c) {
+		c.JSON(status, body)
+		return
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return
+	}
+	c.Writer.WriteString("data: " + string(data) + "\n\n")
+	c.Writer.Flush()
+	c.Set(streamHeartbeatOnlyKey, false)
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/keymanager"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newStreamTestUpstream 创建等待 delay 后返回流式响应的上游，status 不为200时返回错误
+func newStreamTestUpstream(t *testing.T, delay time.Duration, status int) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(delay):
+		}
+		if status != http.StatusOK {
+			http.Error(w, `{"error":{"message":"upstream failed"}}`, status)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"content":"hi"}}]}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// newStreamTestProxy 创建指定全局设置的代理，分组 group_1、group_2 ... 依次指向给定的上游
+func newStreamTestProxy(t *testing.T, settings *internal.GlobalSettings, upstreams ...*httptest.Server) *MultiProviderProxy {
+	t.Helper()
+	groups := make(map[string]*internal.UserGroup, len(upstreams))
+	for i, upstream := range upstreams {
+		groupID := fmt.Sprintf("group_%d", i+1)
+		group := newTestGroup(groupID, nil)
+		group.BaseURL = upstream.URL
+		groups[groupID] = group
+	}
+	cfg := &internal.Config{UserGroups: groups, GlobalSettings: settings}
+	return NewMultiProviderProxy(cfg, keymanager.NewMultiGroupKeyManager(cfg), nil)
+}
+
+// serveStreamRequest 发送一个流式请求，ctx 为客户端连接的上下文，header 为附加的请求头
+func serveStreamRequest(p *MultiProviderProxy, ctx context.Context, header map[string]string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hello"}]}`)).WithContext(ctx)
+	c.Request.Header.Set("Content-Type", "application/json")
+	for name, value := range header {
+		c.Request.Header.Set(name, value)
+	}
+	p.HandleChatCompletion(c)
+	return w
+}
+
+// TestStreamHeartbeat 测试等待上游响应期间发送SSE注释心跳，之后正常转发上游数据
+func TestStreamHeartbeat(t *testing.T) {
+	settings := &internal.GlobalSettings{StreamHeartbeat: &internal.StreamHeartbeatSettings{Enabled: true, Interval: 20 * time.Millisecond}}
+	p := newStreamTestProxy(t, settings, newStreamTestUpstream(t, 150*time.Millisecond, http.StatusOK))
+
+	w := serveStreamRequest(p, context.Background(), nil)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("应返回流式响应，status=%d content-type=%q body=%s", w.Code, w.Header().Get("Content-Type"), body)
+	}
+	ping, data := strings.Index(body, ": ping\n\n"), strings.Index(body, "data: ")
+	if ping < 0 || data < ping {
+		t.Errorf("心跳应在上游数据之前发送，body=%s", body)
+	}
+	if !strings.Contains(body, `"content":"hi"`) || !strings.Contains(body, "data: [DONE]") {
+		t.Errorf("应转发上游数据，body=%s", body)
+	}
+}
+
+// TestStreamHeartbeatError 测试发送心跳后全部尝试失败时，错误以SSE数据事件返回
+func TestStreamHeartbeatError(t *testing.T) {
+	settings := &internal.GlobalSettings{StreamHeartbeat: &internal.StreamHeartbeatSettings{Enabled: true, Interval: 20 * time.Millisecond}}
+	p := newStreamTestProxy(t, settings, newStreamTestUpstream(t, 100*time.Millisecond, http.StatusBadRequest))
+
+	w := serveStreamRequest(p, context.Background(), nil)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.HasPrefix(body, ": ping\n\n") {
+		t.Fatalf("响应头应随第一次心跳提交，status=%d body=%s", w.Code, body)
+	}
+	if !strings.Contains(body, "data: {") || !strings.Contains(body, `"error"`) {
+		t.Errorf("错误应以SSE数据事件返回，body=%s", body)
+	}
+
+	// 未启用心跳时不写入注释
+	p = newStreamTestProxy(t, nil, newStreamTestUpstream(t, 50*time.Millisecond, http.StatusOK))
+	if body := serveStreamRequest(p, context.Background(), nil).Body.String(); strings.Contains(body, ": ping") {
+		t.Errorf("未启用心跳时不应发送心跳，body=%s", body)
+	}
+}
@@ -0,0 +1,20 @@
+package proxy
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// startStreamResponse 提交流式响应头，在写入上游第一个数据块或第一次心跳前调用
+// 在此之前失败的尝试没有向客户端写入任何内容，可以透明地换用下一个密钥或分组重试，
+// 全部失败时也能以普通JSON返回错误
+func startStreamResponse(c *gin.Context) {
+	if c.Writer.Written() {
+		return
+	}
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("Access-Control-Allow-Origin", "*")
+	c.Writer.WriteHeaderNow()
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/keymanager"
+)
+
+// TestStreamRetryBeforeFirstChunk 测试收到第一个数据块前失败时透明地重试，响应中不包含失败尝试的内容
+func TestStreamRetryBeforeFirstChunk(t *testing.T) {
+	// 第一次请求失败，之后的请求返回流式响应
+	var calls atomic.Int32
+	working := newStreamTestUpstream(t, 0, http.StatusOK)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			io.Copy(io.Discard, r.Body)
+			http.Error(w, `{"error":{"message":"upstream failed"}}`, http.StatusInternalServerError)
+			return
+		}
+		working.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+
+	group := newTestGroup("group_1", nil)
+	group.BaseURL = upstream.URL
+	group.APIKeys = []string{"sk-first", "sk-second"}
+	cfg := &internal.Config{UserGroups: map[string]*internal.UserGroup{"group_1": group}}
+	p := NewMultiProviderProxy(cfg, keymanager.NewMultiGroupKeyManager(cfg), nil)
+	w := serveStreamRequest(p, context.Background(), nil)
+	if calls.Load() != 2 {
+		t.Fatalf("第一次请求失败后应重试，上游收到 %d 个请求", calls.Load())
+	}
+	body := w.Body.String()
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("应返回流式响应，status=%d content-type=%q body=%s", w.Code, w.Header().Get("Content-Type"), body)
+	}
+	if !strings.HasPrefix(body, "data: {") || strings.Contains(body, `"error"`) || !strings.Contains(body, "data: [DONE]") {
+		t.Errorf("响应应只包含成功请求的数据，body=%s", body)
+	}
+}
+
+// TestStreamAllFailedReturnsJSON 测试全部尝试在第一个数据块前失败时以普通JSON返回错误状态码
+func TestStreamAllFailedReturnsJSON(t *testing.T) {
+	p := newStreamTestProxy(t, nil, newStreamTestUpstream(t, 10*time.Millisecond, http.StatusInternalServerError))
+	w := serveStreamRequest(p, context.Background(), nil)
+	if w.Code < 500 || strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("应以JSON返回错误状态码，status=%d content-type=%q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Message == "" {
+		t.Errorf("响应体应为JSON错误，err=%v body=%s", err, w.Body.String())
+	}
+}
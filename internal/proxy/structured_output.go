@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"turnsapi/internal/providers"
+	"turnsapi/internal/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StructuredOutputHeader 返回给客户端的结构化输出校验结果：true、repaired 或 false
+const StructuredOutputHeader = "X-TurnsAPI-JSON-Valid"
+
+// structuredOutputRepairPrompt 要求模型修正无效JSON的提示词
+const structuredOutputRepairPrompt = "Your previous reply did not satisfy the required JSON format (%v). " +
+	"Reply again with only the corrected JSON, without markdown code fences or any other text."
+
+// enforceResponseFormat 校验要求JSON输出的非流式响应，去除代码块等包裹；JSON无效且开启修复时请求模型修正一次
+func (p *MultiProviderProxy) enforceResponseFormat(
+	ctx context.Context,
+	c *gin.Context,
+	upstreamReq *providers.ChatCompletionRequest,
+	routeResult *router.RouteResult,
+	response *providers.ChatCompletionResponse,
+) *providers.ChatCompletionResponse {
+	format := upstreamReq.ResponseFormat
+	if !format.WantsJSON() || len(response.Choices) == 0 || len(response.Choices[0].Message.ToolCalls) > 0 {
+		return response
+	}
+
+	content := response.Choices[0].Message.Content
+	cleaned, err := providers.ValidateJSONOutput(content, format)
+	if err == nil {
+		response.Choices[0].Message.Content = cleaned
+		c.Header(StructuredOutputHeader, "true")
+		return response
+	}
+
+	log.Printf("分组 %s 返回的结构化输出无效: %v", routeResult.GroupID, err)
+	trace := requestDebugFrom(c)
+	trace.add("upstream", map[string]interface{}{"group": routeResult.GroupID, "error": err.Error()}, "结构化输出无效")
+
+	config := p.config.Snapshot()
+	if config.GlobalSettings == nil || config.GlobalSettings.StructuredOutput == nil || !config.GlobalSettings.StructuredOutput.RepairRetry {
+		c.Header(StructuredOutputHeader, "false")
+		return response
+	}
+
+	// 附上无效的回复和校验错误，要求模型修正
+	repairReq := *upstreamReq
+	repairReq.Messages = make([]providers.ChatMessage, 0, len(upstreamReq.Messages)+2)
+	repairReq.Messages = append(repairReq.Messages, upstreamReq.Messages...)
+	repairReq.Messages = append(repairReq.Messages,
+		providers.ChatMessage{Role: "assistant", Content: content},
+		providers.ChatMessage{Role: "user", Content: fmt.Sprintf(structuredOutputRepairPrompt, err)},
+	)
+
+	repaired, repairErr := routeResult.Provider.ChatCompletion(ctx, &repairReq)
+	if repairErr != nil {
+		log.Printf("分组 %s 结构化输出修复请求失败: %v", routeResult.GroupID, repairErr)
+		c.Header(StructuredOutputHeader, "false")
+		return response
+	}
+	if len(repaired.Choices) > 0 {
+		if cleaned, err := providers.ValidateJSONOutput(repaired.Choices[0].Message.Content, format); err == nil {
+			repaired.Choices[0].Message.Content = cleaned
+			// 用量包含修复前后两次请求
+			repaired.Usage.PromptTokens += response.Usage.PromptTokens
+			repaired.Usage.CompletionTokens += response.Usage.CompletionTokens
+			repaired.Usage.TotalTokens += response.Usage.TotalTokens
+			trace.add("upstream", map[string]interface{}{"group": routeResult.GroupID}, "结构化输出已修复")
+			c.Header(StructuredOutputHeader, "repaired")
+			return repaired
+		} else {
+			log.Printf("分组 %s 修复后的结构化输出仍然无效: %v", routeResult.GroupID, err)
+		}
+	}
+
+	c.Header(StructuredOutputHeader, "false")
+	return response
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"strings"
+	"time"
+
+	"turnsapi/internal/logger"
+	"turnsapi/internal/providers"
+	"turnsapi/internal/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+// injectSystemPrompt 将分组和代理密钥配置的系统提示词解析模板变量后插入到消息列表最前面
+// 分组提示词在前，代理密钥提示词在后，均位于客户端消息之前
+func (p *MultiProviderProxy) injectSystemPrompt(c *gin.Context, req *providers.ChatCompletionRequest, clientModel string, routeResult *router.RouteResult) {
+	groupPrompt := ""
+	if routeResult.Group != nil {
+		groupPrompt = routeResult.Group.SystemPrompt
+	}
+	keyPrompt := ""
+	proxyKeyName := ""
+	if c != nil {
+		proxyKeyName = c.GetString("proxy_key_name")
+		if keyInfo, exists := c.Get("key_info"); exists {
+			if proxyKey, ok := keyInfo.(*logger.ProxyKey); ok {
+				keyPrompt = proxyKey.SystemPrompt
+			}
+		}
+	}
+	if strings.TrimSpace(groupPrompt) == "" && strings.TrimSpace(keyPrompt) == "" {
+		return
+	}
+
+	vars := providers.SystemPromptVars{
+		Date:         time.Now().Format("2006-01-02"),
+		ProxyKeyName: proxyKeyName,
+		Group:        routeResult.GroupID,
+		Model:        clientModel,
+	}
+	// 后插入的消息位于最前面，因此先插入代理密钥提示词
+	if strings.TrimSpace(keyPrompt) != "" {
+		req.PrependSystemMessage(providers.ExpandSystemPrompt(keyPrompt, vars))
+	}
+	if strings.TrimSpace(groupPrompt) != "" {
+		req.PrependSystemMessage(providers.ExpandSystemPrompt(groupPrompt, vars))
+	}
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"context"
+
+	"turnsapi/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// attemptSpanContextKey 当前上游尝试的span在gin上下文中的键
+const attemptSpanContextKey = "trace_attempt_span"
+
+// startTraceSpan 以请求的span为父span创建子span，未启用链路追踪时返回 nil
+func startTraceSpan(c *gin.Context, name string) *tracing.Span {
+	_, span := tracing.Start(c.Request.Context(), name, tracing.KindInternal)
+	return span
+}
+
+// startAttemptSpan 为一次分组/密钥尝试创建span，之后的上游调用作为它的子span
+func startAttemptSpan(c *gin.Context, groupID, maskedKey string, attempt int) *tracing.Span {
+	span := startTraceSpan(c, "attempt "+groupID)
+	span.SetAttribute("turnsapi.group", groupID)
+	span.SetAttribute("turnsapi.api_key", maskedKey)
+	span.SetAttribute("turnsapi.attempt", attempt)
+	c.Set(attemptSpanContextKey, span)
+	return span
+}
+
+// endAttemptSpan 结束尝试span并记录失败原因
+func endAttemptSpan(c *gin.Context, span *tracing.Span, err error) {
+	span.RecordError(err)
+	span.End()
+	c.Set(attemptSpanContextKey, (*tracing.Span)(nil))
+}
+
+// withTraceContext 上游调用使用独立的 context（不随客户端断开而取消），这里将当前尝试或请求的span带过去，
+// 使上游HTTP调用成为其子span并通过 traceparent 传递给上游
+func withTraceContext(ctx context.Context, c *gin.Context) context.Context {
+	if value, exists := c.Get(attemptSpanContextKey); exists {
+		if span, ok := value.(*tracing.Span); ok && span != nil {
+			return tracing.ContextWithSpan(ctx, span)
+		}
+	}
+	return tracing.ContextWithSpan(ctx, tracing.SpanFromContext(c.Request.Context()))
+}
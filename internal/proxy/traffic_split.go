@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"log"
+	"math/rand"
+
+	"turnsapi/internal"
+	"turnsapi/internal/providers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A/B分流参数
+const (
+	TrafficSplitHeader = "X-TurnsAPI-Split" // 返回给客户端的分流名称和分配的分支分组
+
+	defaultTrafficSplitHashHeader = "X-Conversation-ID"
+	trafficSplitContextKey        = "traffic_split"
+)
+
+// trafficSplitAssignment 请求被分配到的分流分支
+type trafficSplitAssignment struct {
+	Name   string
+	Group  string
+	Strict bool
+}
+
+// trafficSplitMatches 检查请求是否属于该分流：同时配置模型和代理密钥时两者都需满足
+func trafficSplitMatches(split *internal.TrafficSplit, model, proxyKeyID string) bool {
+	if split.Model != "" && split.Model != model {
+		return false
+	}
+	if len(split.ProxyKeys) > 0 {
+		for _, id := range split.ProxyKeys {
+			if id == proxyKeyID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// trafficSplitHashKey 确定性分流的哈希输入：优先使用请求头中的会话或用户标识，否则使用代理密钥ID
+func trafficSplitHashKey(c *gin.Context, split *internal.TrafficSplit, proxyKeyID string) string {
+	header := split.HashHeader
+	if header == "" {
+		header = defaultTrafficSplitHashHeader
+	}
+	if value := c.GetHeader(header); value != "" {
+		return value
+	}
+	return proxyKeyID
+}
+
+// pickTrafficSplitArm 按权重选择分支，hashKey不为空时按哈希确定性选择
+func pickTrafficSplitArm(split *internal.TrafficSplit, arms []internal.TrafficSplitArm, hashKey string) internal.TrafficSplitArm {
+	total := 0
+	for _, arm := range arms {
+		total += arm.Weight
+	}
+
+	var n int
+	if hashKey != "" {
+		sum := sha256.Sum256([]byte(split.Name + "\x00" + hashKey))
+		n = int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	} else {
+		n = rand.Intn(total)
+	}
+
+	for _, arm := range arms {
+		if n < arm.Weight {
+			return arm
+		}
+		n -= arm.Weight
+	}
+	return arms[len(arms)-1]
+}
+
+// assignTrafficSplit 为匹配分流配置的请求分配分支，分支指定了模型时替换请求的模型
+// 只在启用且代理密钥允许访问的分组之间分配，没有匹配的分流或可用分支时返回nil
+func (p *MultiProviderProxy) assignTrafficSplit(c *gin.Context, req *providers.ChatCompletionRequest, proxyKeyID string, allowedGroups []string) *trafficSplitAssignment {
+	config := p.config.Snapshot()
+	if config.GlobalSettings == nil {
+		return nil
+	}
+
+	for i := range config.GlobalSettings.TrafficSplits {
+		split := &config.GlobalSettings.TrafficSplits[i]
+		if !trafficSplitMatches(split, req.Model, proxyKeyID) {
+			continue
+		}
+
+		arms := make([]internal.TrafficSplitArm, 0, len(split.Arms))
+		for _, arm := range split.Arms {
+			group, exists := config.UserGroups[arm.Group]
+			if !exists || group == nil || !group.Enabled || !groupAllowed(allowedGroups, arm.Group) {
+				continue
+			}
+			arms = append(arms, arm)
+		}
+		if len(arms) == 0 {
+			log.Printf("分流 %s 没有可用的分支分组，按正常路由处理", split.Name)
+			return nil
+		}
+
+		hashKey := ""
+		if split.Deterministic {
+			hashKey = trafficSplitHashKey(c, split, proxyKeyID)
+		}
+		arm := pickTrafficSplitArm(split, arms, hashKey)
+		if arm.Model != "" {
+			req.Model = arm.Model
+		}
+
+		assignment := &trafficSplitAssignment{Name: split.Name, Group: arm.Group, Strict: split.Strict}
+		c.Set(trafficSplitContextKey, assignment)
+		c.Header(TrafficSplitHeader, split.Name+"/"+arm.Group)
+		log.Printf("分流 %s 分配到分支 %s（模型 %s）", split.Name, arm.Group, req.Model)
+		return assignment
+	}
+	return nil
+}
+
+// groupAllowed 检查分组是否在代理密钥允许的分组中，未限制时允许所有分组
+func groupAllowed(allowedGroups []string, groupID string) bool {
+	if len(allowedGroups) == 0 {
+		return true
+	}
+	for _, id := range allowedGroups {
+		if id == groupID {
+			return true
+		}
+	}
+	return false
+}
+
+// trafficSplitFrom 获取请求分配的分流分支，未分流时返回nil
+func trafficSplitFrom(c *gin.Context) *trafficSplitAssignment {
+	if value, exists := c.Get(trafficSplitContextKey); exists {
+		if assignment, ok := value.(*trafficSplitAssignment); ok {
+			return assignment
+		}
+	}
+	return nil
+}
+
+// trafficSplitLogFields 请求日志中记录的分流名称和分支分组
+func trafficSplitLogFields(c *gin.Context) (string, string) {
+	if assignment := trafficSplitFrom(c); assignment != nil {
+		return assignment.Name, assignment.Group
+	}
+	return "", ""
+}
+
+// applyTrafficSplit 将分配的分支分组移到候选分组最前面；严格模式下只保留分支分组，不支持该模型时返回空列表
+func applyTrafficSplit(candidateGroups []string, assignment *trafficSplitAssignment) []string {
+	found := false
+	for _, id := range candidateGroups {
+		if id == assignment.Group {
+			found = true
+			break
+		}
+	}
+	if assignment.Strict {
+		if found {
+			return []string{assignment.Group}
+		}
+		return nil
+	}
+	if !found {
+		return candidateGroups
+	}
+
+	ordered := make([]string, 0, len(candidateGroups))
+	ordered = append(ordered, assignment.Group)
+	for _, id := range candidateGroups {
+		if id != assignment.Group {
+			ordered = append(ordered, id)
+		}
+	}
+	return ordered
+}
+
+// GetTrafficSplits 获取A/B分流配置
+func (p *MultiProviderProxy) GetTrafficSplits() []internal.TrafficSplit {
+	config := p.config.Snapshot()
+	if config.GlobalSettings == nil {
+		return []internal.TrafficSplit{}
+	}
+	splits := make([]internal.TrafficSplit, len(config.GlobalSettings.TrafficSplits))
+	copy(splits, config.GlobalSettings.TrafficSplits)
+	return splits
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"turnsapi/internal/providers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// upstreamHeaderPrefix 透传给客户端的上游响应头前缀
+const upstreamHeaderPrefix = "X-TurnsAPI-"
+
+// defaultUpstreamHeaders 默认透传的上游响应头：限流额度、剩余量、重置时间和重试等待时间
+var defaultUpstreamHeaders = []string{
+	"retry-after",
+	"x-ratelimit-limit-requests",
+	"x-ratelimit-limit-tokens",
+	"x-ratelimit-remaining-requests",
+	"x-ratelimit-remaining-tokens",
+	"x-ratelimit-reset-requests",
+	"x-ratelimit-reset-tokens",
+	"x-ratelimit-limit",
+	"x-ratelimit-remaining",
+	"x-ratelimit-reset",
+	"anthropic-ratelimit-requests-remaining",
+	"anthropic-ratelimit-requests-reset",
+	"anthropic-ratelimit-tokens-remaining",
+	"anthropic-ratelimit-tokens-reset",
+}
+
+// upstreamHeaderSettings 读取需要透传的上游响应头列表以及是否返回给客户端
+func (p *MultiProviderProxy) upstreamHeaderSettings() ([]string, bool) {
+	config := p.config.Snapshot()
+	if config.GlobalSettings == nil || config.GlobalSettings.UpstreamHeaders == nil {
+		return defaultUpstreamHeaders, true
+	}
+	settings := config.GlobalSettings.UpstreamHeaders
+
+	names := defaultUpstreamHeaders
+	if len(settings.Headers) > 0 {
+		names = settings.Headers
+	}
+	passthrough := true
+	if settings.Passthrough != nil {
+		passthrough = *settings.Passthrough
+	}
+	return names, passthrough
+}
+
+// captureUpstreamHeaders 选出需要记录的上游响应头，按配置以 X-TurnsAPI-* 头部返回给客户端
+// provider 为OpenRouter响应体中的实际上游提供商，非空时以 X-TurnsAPI-Provider 返回
+func (p *MultiProviderProxy) captureUpstreamHeaders(c *gin.Context, captured *providers.UpstreamHeaders, provider string) map[string]string {
+	names, passthrough := p.upstreamHeaderSettings()
+
+	selected := make(map[string]string)
+	if header := captured.Header(); header != nil {
+		for _, name := range names {
+			if value := header.Get(name); value != "" {
+				selected[strings.ToLower(name)] = value
+			}
+		}
+	}
+	if provider != "" {
+		selected["provider"] = provider
+	}
+
+	if passthrough {
+		// 清除之前失败尝试设置的头部，避免与本次响应混在一起；排队、分流等由本服务设置的头部保留
+		header := c.Writer.Header()
+		for _, name := range names {
+			delete(header, clientHeaderName(name))
+		}
+		delete(header, clientHeaderName("provider"))
+		for name, value := range selected {
+			header[clientHeaderName(name)] = []string{value}
+		}
+	}
+	return selected
+}
+
+// clientHeaderName 生成返回给客户端的头部名称，如 x-ratelimit-remaining -> X-TurnsAPI-Ratelimit-Remaining
+func clientHeaderName(name string) string {
+	canonical := http.CanonicalHeaderKey(name)
+	return upstreamHeaderPrefix + strings.TrimPrefix(canonical, "X-")
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/keymanager"
+	"turnsapi/internal/providers"
+	"turnsapi/internal/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestProxy 创建使用指定分组的代理，不记录日志
+func newTestProxy(t *testing.T, groups map[string]*internal.UserGroup) *MultiProviderProxy {
+	t.Helper()
+	cfg := &internal.Config{UserGroups: groups}
+	return NewMultiProviderProxy(cfg, keymanager.NewMultiGroupKeyManager(cfg), nil)
+}
+
+// newTestGroup 创建用于测试的OpenAI分组
+func newTestGroup(name string, params map[string]interface{}) *internal.UserGroup {
+	return &internal.UserGroup{
+		Name:          name,
+		ProviderType:  "openai",
+		BaseURL:       "https://api.openai.com/v1",
+		Enabled:       true,
+		Timeout:       30 * time.Second,
+		Models:        []string{"gpt-4o"},
+		APIKeys:       []string{"sk-" + name},
+		RequestParams: params,
+	}
+}
+
+// TestBuildUpstreamRequestDoesNotLeakParams 测试分组参数覆盖只作用于该分组的上游请求，故障转移到其他分组时不会继承
+func TestBuildUpstreamRequestDoesNotLeakParams(t *testing.T) {
+	p := newTestProxy(t, map[string]*internal.UserGroup{
+		"group_a": newTestGroup("group_a", map[string]interface{}{"temperature": 0.2, "max_tokens": 100}),
+		"group_b": newTestGroup("group_b", nil),
+	})
+
+	req := &providers.ChatCompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []providers.ChatMessage{{Role: "user", Content: "hello"}},
+	}
+
+	route := func(groupID string) *router.RouteResult {
+		result, err := p.providerRouter.RouteWithRetry(&router.RouteRequest{Model: req.Model, ProviderGroup: groupID})
+		if err != nil {
+			t.Fatalf("路由到分组 %s 失败: %v", groupID, err)
+		}
+		return result
+	}
+
+	upstreamA := p.buildUpstreamRequest(nil, req, route("group_a"))
+	if upstreamA.Temperature == nil || *upstreamA.Temperature != 0.2 {
+		t.Errorf("分组A的请求应使用 temperature=0.2，得到 %v", upstreamA.Temperature)
+	}
+	if upstreamA.MaxTokens == nil || *upstreamA.MaxTokens != 100 {
+		t.Errorf("分组A的请求应使用 max_tokens=100，得到 %v", upstreamA.MaxTokens)
+	}
+
+	if req.Temperature != nil || req.MaxTokens != nil {
+		t.Fatalf("客户端请求不应被分组参数修改: temperature=%v max_tokens=%v", req.Temperature, req.MaxTokens)
+	}
+
+	upstreamB := p.buildUpstreamRequest(nil, req, route("group_b"))
+	if upstreamB.Temperature != nil {
+		t.Errorf("分组B不应继承分组A的 temperature，得到 %v", *upstreamB.Temperature)
+	}
+	if upstreamB.MaxTokens != nil {
+		t.Errorf("分组B不应继承分组A的 max_tokens，得到 %v", *upstreamB.MaxTokens)
+	}
+}
+
+// TestRPMReservedOnlyForDispatchedGroup 测试只有实际发送请求的分组占用RPM额度，其他候选分组不受影响
+func TestRPMReservedOnlyForDispatchedGroup(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o",` +
+			`"choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],` +
+			`"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`))
+	}))
+	defer upstream.Close()
+
+	groups := map[string]*internal.UserGroup{}
+	for _, groupID := range []string{"group_a", "group_b", "group_c"} {
+		group := newTestGroup(groupID, nil)
+		group.BaseURL = upstream.URL
+		group.RPMLimit = 10
+		groups[groupID] = group
+	}
+	p := newTestProxy(t, groups)
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hello"}]}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	p.HandleChatCompletion(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("请求应成功，status=%d body=%s", w.Code, w.Body.String())
+	}
+	total := 0
+	for groupID := range groups {
+		current, _, _ := p.rpmLimiter.GetStats(groupID)
+		total += current
+	}
+	if total != 1 {
+		t.Errorf("只有发送请求的分组应占用RPM额度，共占用 %d", total)
+	}
+}
+
+// TestSaturatedGroupSkippedAtDispatch 测试并发槽位已满的分组在发送请求时跳过，由其他分组处理且不计入重试次数
+func TestSaturatedGroupSkippedAtDispatch(t *testing.T) {
+	var served []string
+	var mu sync.Mutex
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		served = append(served, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o",` +
+			`"choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],` +
+			`"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`))
+	}))
+	defer upstream.Close()
+
+	groups := map[string]*internal.UserGroup{}
+	for _, groupID := range []string{"group_a", "group_b"} {
+		group := newTestGroup(groupID, nil)
+		group.BaseURL = upstream.URL
+		group.MaxConcurrent = 1
+		groups[groupID] = group
+	}
+	p := newTestProxy(t, groups)
+
+	// 占满 group_a 的并发槽位
+	release, acquired := p.acquireConcurrency("group_a", "sk-group_a")
+	if !acquired {
+		t.Fatal("占用 group_a 的并发槽位失败")
+	}
+	defer release()
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hello"}]}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	p.HandleChatCompletion(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("请求应由 group_b 处理，status=%d body=%s", w.Code, w.Body.String())
+	}
+	if len(served) != 1 || served[0] != "Bearer sk-group_b" {
+		t.Errorf("只有 group_b 应收到请求，得到 %v", served)
+	}
+	if current := p.groupConcurrency.Current("group_b"); current != 0 {
+		t.Errorf("请求完成后应释放 group_b 的并发槽位，当前为 %d", current)
+	}
+}
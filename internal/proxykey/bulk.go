@@ -0,0 +1,370 @@
+package proxykey
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxImportKeys 单次批量导入的最大密钥数量
+const MaxImportKeys = 5000
+
+// csvListSeparator CSV中列表字段（分组、模型、来源地址）的分隔符
+const csvListSeparator = ";"
+
+// csvColumns 导出CSV的列，导入时 id、usage_count、created_at 只作参考，会被忽略
+var csvColumns = []string{
+	"id", "name", "description", "key", "allowed_groups", "group_selection_config", "is_active",
+	"expires_at", "max_usage_count", "usage_count", "allowed_models", "denied_models",
+	"allowed_ips", "denied_ips", "system_prompt", "created_at",
+}
+
+// csvIgnoredColumns 导入时忽略的只读列
+var csvIgnoredColumns = map[string]bool{"id": true, "usage_count": true, "created_at": true}
+
+// ErrInvalidImport 批量导入中存在无效的密钥，所有密钥均未导入
+var ErrInvalidImport = errors.New("invalid keys in import, nothing was imported")
+
+// KeySpec 批量导入的单个代理密钥，字段名与导出的JSON一致
+type KeySpec struct {
+	Name                 string                `json:"name"`
+	Description          string                `json:"description"`
+	Key                  string                `json:"key"` // 为空时生成新密钥，可以是明文或导出的 sha256: 哈希
+	AllowedGroups        []string              `json:"allowed_groups"`
+	GroupSelectionConfig *GroupSelectionConfig `json:"group_selection_config"`
+	IsActive             *bool                 `json:"is_active"` // 为空表示启用
+	ExpiresAt            *time.Time            `json:"expires_at"`
+	MaxUsageCount        int64                 `json:"max_usage_count"`
+	AllowedModels        []string              `json:"allowed_models"`
+	DeniedModels         []string              `json:"denied_models"`
+	AllowedIPs           []string              `json:"allowed_ips"`
+	DeniedIPs            []string              `json:"denied_ips"`
+	SystemPrompt         string                `json:"system_prompt"`
+}
+
+// ImportResult 批量导入中单个密钥的结果
+type ImportResult struct {
+	Row   int    `json:"row"` // 从1开始的序号，CSV不含表头行
+	Name  string `json:"name"`
+	ID    string `json:"id,omitempty"`
+	Key   string `json:"key,omitempty"` // 新生成的明文密钥，只在导入响应中返回一次；导入时提供了密钥则为空
+	Error string `json:"error,omitempty"`
+}
+
+// storedKeyHash 密钥的哈希形式，用于比较明文密钥和密封密钥是否重复
+func storedKeyHash(key string) string {
+	if strings.HasPrefix(key, sealedKeyPrefix) {
+		return key
+	}
+	return HashProxyKey(key)
+}
+
+// toKey 校验并转换为代理密钥，未设置ID和创建时间
+func (spec *KeySpec) toKey() (*ProxyKey, error) {
+	name := strings.TrimSpace(spec.Name)
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	keyStr := strings.TrimSpace(spec.Key)
+	if strings.HasPrefix(keyStr, sealedKeyPrefix) {
+		if digest, err := hex.DecodeString(strings.TrimPrefix(keyStr, sealedKeyPrefix)); err != nil || len(digest) != 32 {
+			return nil, fmt.Errorf("invalid sealed key, expected sha256: followed by 64 hex characters")
+		}
+	} else if keyStr != "" && (len(keyStr) < 16 || strings.ContainsAny(keyStr, " \t\r\n")) {
+		return nil, fmt.Errorf("key must be at least 16 characters without whitespace")
+	}
+
+	if spec.MaxUsageCount < 0 {
+		return nil, fmt.Errorf("max_usage_count must not be negative")
+	}
+	allowedIPs, err := normalizeIPRules(spec.AllowedIPs)
+	if err != nil {
+		return nil, fmt.Errorf("allowed_ips: %w", err)
+	}
+	deniedIPs, err := normalizeIPRules(spec.DeniedIPs)
+	if err != nil {
+		return nil, fmt.Errorf("denied_ips: %w", err)
+	}
+
+	isActive := true
+	if spec.IsActive != nil {
+		isActive = *spec.IsActive
+	}
+	allowedGroups := normalizeModelPatterns(spec.AllowedGroups)
+	if allowedGroups == nil {
+		allowedGroups = []string{}
+	}
+
+	return &ProxyKey{
+		Key:                  keyStr,
+		Name:                 name,
+		Description:          strings.TrimSpace(spec.Description),
+		AllowedGroups:        allowedGroups,
+		GroupSelectionConfig: spec.GroupSelectionConfig,
+		IsActive:             isActive,
+		ExpiresAt:            spec.ExpiresAt,
+		MaxUsageCount:        spec.MaxUsageCount,
+		AllowedModels:        normalizeModelPatterns(spec.AllowedModels),
+		DeniedModels:         normalizeModelPatterns(spec.DeniedModels),
+		AllowedIPs:           allowedIPs,
+		DeniedIPs:            deniedIPs,
+		SystemPrompt:         strings.TrimSpace(spec.SystemPrompt),
+	}, nil
+}
+
+// ValidateImport 校验批量导入的密钥，返回无效的行；提供的密钥不能与已有密钥或同批其他密钥重复
+func (m *Manager) ValidateImport(specs []KeySpec) []ImportResult {
+	_, invalid := m.prepareImport(specs)
+	return invalid
+}
+
+// prepareImport 校验并转换批量导入的密钥
+func (m *Manager) prepareImport(specs []KeySpec) ([]*ProxyKey, []ImportResult) {
+	m.mu.RLock()
+	existing := make(map[string]bool, len(m.keys)+len(specs))
+	for _, key := range m.keys {
+		existing[storedKeyHash(key.Key)] = true
+	}
+	m.mu.RUnlock()
+
+	keys := make([]*ProxyKey, len(specs))
+	var invalid []ImportResult
+	for i := range specs {
+		key, err := specs[i].toKey()
+		if err == nil {
+			err = m.ValidateGroupSelection(key.GroupSelectionConfig, key.AllowedGroups)
+		}
+		if err == nil && key.Key != "" {
+			hash := storedKeyHash(key.Key)
+			if existing[hash] {
+				err = fmt.Errorf("key already exists")
+			}
+			existing[hash] = true
+		}
+		if err != nil {
+			invalid = append(invalid, ImportResult{Row: i + 1, Name: specs[i].Name, Error: err.Error()})
+			continue
+		}
+		keys[i] = key
+	}
+	return keys, invalid
+}
+
+// ImportKeys 批量导入代理密钥：先校验全部密钥，存在无效项时返回出错的行和 ErrInvalidImport，不导入任何密钥；
+// 校验通过后逐个保存，单个密钥保存失败不影响其他密钥。sealed为true时只保存密钥的哈希
+func (m *Manager) ImportKeys(specs []KeySpec, sealed bool) ([]ImportResult, error) {
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no keys to import")
+	}
+	if len(specs) > MaxImportKeys {
+		return nil, fmt.Errorf("too many keys, at most %d per import", MaxImportKeys)
+	}
+
+	keys, invalid := m.prepareImport(specs)
+	if len(invalid) > 0 {
+		return invalid, ErrInvalidImport
+	}
+
+	results := make([]ImportResult, len(keys))
+	now := time.Now()
+	for i, key := range keys {
+		results[i] = ImportResult{Row: i + 1, Name: key.Name}
+
+		var plaintext string
+		if key.Key == "" {
+			generated, err := newKeyString()
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			key.Key, plaintext = generated, generated
+		}
+		if sealed && !key.Sealed() {
+			key.Key = HashProxyKey(key.Key)
+		}
+		key.ID = generateID()
+		key.CreatedAt = now
+
+		m.mu.Lock()
+		err := m.addKeyLocked(key)
+		m.mu.Unlock()
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].ID = key.ID
+		results[i].Key = plaintext
+	}
+	return results, nil
+}
+
+// ParseKeysJSON 解析JSON格式的批量导入数据，支持密钥数组或导出格式 {"keys": [...]}
+func ParseKeysJSON(data []byte) ([]KeySpec, error) {
+	var specs []KeySpec
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &specs); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return specs, nil
+	}
+
+	var document struct {
+		Keys []KeySpec `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return document.Keys, nil
+}
+
+// ParseKeysCSV 解析CSV格式的批量导入数据，第一行为表头，列名与导出的CSV一致，只有 name 列是必需的
+// 列表字段用分号分隔，is_active 为空表示启用，expires_at 使用RFC3339格式
+func ParseKeysCSV(r io.Reader) ([]KeySpec, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("empty CSV")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+
+	known := make(map[string]bool, len(csvColumns))
+	for _, column := range csvColumns {
+		known[column] = true
+	}
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		if i == 0 {
+			column = strings.TrimPrefix(column, "\ufeff") // 电子表格软件导出的BOM
+		}
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !known[column] {
+			return nil, fmt.Errorf("unknown CSV column %q", column)
+		}
+		columns[column] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, fmt.Errorf("CSV column name is required")
+	}
+
+	var specs []KeySpec
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		spec, err := parseCSVRecord(record, columns)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// parseCSVRecord 解析CSV的一行
+func parseCSVRecord(record []string, columns map[string]int) (KeySpec, error) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && !csvIgnoredColumns[name] {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	spec := KeySpec{
+		Name:          field("name"),
+		Description:   field("description"),
+		Key:           field("key"),
+		AllowedGroups: splitCSVList(field("allowed_groups")),
+		AllowedModels: splitCSVList(field("allowed_models")),
+		DeniedModels:  splitCSVList(field("denied_models")),
+		AllowedIPs:    splitCSVList(field("allowed_ips")),
+		DeniedIPs:     splitCSVList(field("denied_ips")),
+		SystemPrompt:  field("system_prompt"),
+	}
+	if value := field("group_selection_config"); value != "" {
+		spec.GroupSelectionConfig = &GroupSelectionConfig{}
+		if err := json.Unmarshal([]byte(value), spec.GroupSelectionConfig); err != nil {
+			return spec, fmt.Errorf("invalid group_selection_config: %w", err)
+		}
+	}
+	if value := field("is_active"); value != "" {
+		isActive, err := strconv.ParseBool(value)
+		if err != nil {
+			return spec, fmt.Errorf("invalid is_active %q", value)
+		}
+		spec.IsActive = &isActive
+	}
+	if value := field("expires_at"); value != "" {
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return spec, fmt.Errorf("invalid expires_at %q, expected RFC3339 format", value)
+		}
+		spec.ExpiresAt = &expiresAt
+	}
+	if value := field("max_usage_count"); value != "" {
+		maxUsageCount, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return spec, fmt.Errorf("invalid max_usage_count %q", value)
+		}
+		spec.MaxUsageCount = maxUsageCount
+	}
+	return spec, nil
+}
+
+// splitCSVList 拆分CSV中分号分隔的列表
+func splitCSVList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, csvListSeparator)
+}
+
+// WriteKeysCSV 以CSV格式导出代理密钥，可直接用于批量导入
+func WriteKeysCSV(w io.Writer, keys []*ProxyKey) error {
+	writer := csv.NewWriter(w)
+	writer.Write(csvColumns)
+	for _, key := range keys {
+		var groupSelectionConfig, expiresAt string
+		if key.GroupSelectionConfig != nil {
+			if configBytes, err := json.Marshal(key.GroupSelectionConfig); err == nil {
+				groupSelectionConfig = string(configBytes)
+			}
+		}
+		if key.ExpiresAt != nil {
+			expiresAt = key.ExpiresAt.Format(time.RFC3339)
+		}
+		writer.Write([]string{
+			key.ID,
+			key.Name,
+			key.Description,
+			key.Key,
+			strings.Join(key.AllowedGroups, csvListSeparator),
+			groupSelectionConfig,
+			strconv.FormatBool(key.IsActive),
+			expiresAt,
+			strconv.FormatInt(key.MaxUsageCount, 10),
+			strconv.FormatInt(key.UsageCount, 10),
+			strings.Join(key.AllowedModels, csvListSeparator),
+			strings.Join(key.DeniedModels, csvListSeparator),
+			strings.Join(key.AllowedIPs, csvListSeparator),
+			strings.Join(key.DeniedIPs, csvListSeparator),
+			key.SystemPrompt,
+			key.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,83 @@
+package proxykey
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestImportKeys(t *testing.T) {
+	manager := NewManager()
+	existing, err := manager.GenerateKey("existing", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	csvData := "name,key,allowed_groups,is_active,max_usage_count,allowed_ips\n" +
+		"tenant-a,,group1;group2,,100,10.0.0.0/8\n" +
+		"tenant-b,tenant-b-secret-0001,,false,,\n"
+	specs, err := ParseKeysCSV(strings.NewReader(csvData))
+	if err != nil {
+		t.Fatalf("ParseKeysCSV() error = %v", err)
+	}
+
+	results, err := manager.ImportKeys(specs, false)
+	if err != nil {
+		t.Fatalf("ImportKeys() error = %v", err)
+	}
+	if len(results) != 2 || results[0].Error != "" || results[1].Error != "" {
+		t.Fatalf("ImportKeys() results = %+v", results)
+	}
+	// 只返回新生成的明文密钥
+	if results[0].Key == "" || results[1].Key != "" {
+		t.Errorf("ImportKeys() keys = %q, %q, want generated key only for the first row", results[0].Key, results[1].Key)
+	}
+	if _, ok := manager.ValidateKeyForGroup(results[0].Key, "group2"); !ok {
+		t.Error("Imported key should be valid for group2")
+	}
+	if _, ok := manager.ValidateKey("tenant-b-secret-0001"); ok {
+		t.Error("Inactive imported key should not validate")
+	}
+
+	// 任何一行无效时不导入任何密钥
+	before := len(manager.GetAllKeys())
+	invalid, err := manager.ImportKeys([]KeySpec{
+		{Name: "ok"},
+		{Name: "duplicate", Key: existing.Key},
+		{Name: "bad-ip", AllowedIPs: []string{"not-an-ip"}},
+	}, false)
+	if !errors.Is(err, ErrInvalidImport) {
+		t.Fatalf("ImportKeys() error = %v, want ErrInvalidImport", err)
+	}
+	if len(invalid) != 2 || invalid[0].Row != 2 || invalid[1].Row != 3 {
+		t.Errorf("ImportKeys() invalid rows = %+v", invalid)
+	}
+	if after := len(manager.GetAllKeys()); after != before {
+		t.Errorf("Invalid import created keys: %d -> %d", before, after)
+	}
+
+	// 导出的CSV可以导入到另一个实例，密封的密钥保留哈希
+	sealedResults, err := manager.ImportKeys([]KeySpec{{Name: "sealed"}}, true)
+	if err != nil {
+		t.Fatalf("ImportKeys(sealed) error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteKeysCSV(&buf, manager.GetAllKeys()); err != nil {
+		t.Fatalf("WriteKeysCSV() error = %v", err)
+	}
+	exported, err := ParseKeysCSV(&buf)
+	if err != nil {
+		t.Fatalf("ParseKeysCSV(exported) error = %v", err)
+	}
+	restored := NewManager()
+	if _, err := restored.ImportKeys(exported, false); err != nil {
+		t.Fatalf("ImportKeys(exported) error = %v", err)
+	}
+	if _, ok := restored.ValidateKey(sealedResults[0].Key); !ok {
+		t.Error("Sealed key should validate after export and import")
+	}
+	if _, ok := restored.ValidateKeyForGroup(results[0].Key, "group3"); ok {
+		t.Error("Restored key should keep its allowed groups")
+	}
+}
@@ -0,0 +1,144 @@
+package proxykey
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"turnsapi/internal/logger"
+)
+
+// KeyLimits 代理密钥的有效期和使用次数限制
+type KeyLimits struct {
+	ExpiresAt     *time.Time `json:"expires_at"`      // 过期时间，为空表示永不过期
+	MaxUsageCount int64      `json:"max_usage_count"` // 最大使用次数，0表示不限制
+}
+
+// SetKeyLimits 设置代理密钥的有效期和使用次数限制
+func (m *Manager) SetKeyLimits(id string, limits KeyLimits) error {
+	if limits.MaxUsageCount < 0 {
+		return fmt.Errorf("max_usage_count must not be negative")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, exists := m.keys[id]
+	if !exists {
+		return fmt.Errorf("key not found")
+	}
+
+	key.ExpiresAt = limits.ExpiresAt
+	key.MaxUsageCount = limits.MaxUsageCount
+	return m.persistKeyLocked(key)
+}
+
+// KeyInactiveReason 返回密钥不可用的原因（expired、exhausted、disabled，或所属租户的 tenant_disabled、tenant_quota_exceeded），
+// 密钥不存在或可用时返回空字符串
+func (m *Manager) KeyInactiveReason(keyStr string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.keys {
+		if key.matches(keyStr) {
+			return m.keyInactiveReasonLocked(key, time.Now())
+		}
+	}
+	return ""
+}
+
+// DeactivateExpiredKeys 将已过期或使用次数已用完但仍处于启用状态的密钥置为禁用，返回处理的密钥数量
+// 租户停用或额度用完不会禁用密钥，租户恢复后密钥自动可用
+func (m *Manager) DeactivateExpiredKeys() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	deactivated := 0
+	for _, key := range m.keys {
+		if !key.IsActive {
+			continue
+		}
+		reason := key.InactiveReason(now)
+		if reason == "" {
+			continue
+		}
+
+		key.IsActive = false
+		if err := m.persistKeyLocked(key); err != nil {
+			log.Printf("Failed to deactivate proxy key %s: %v", key.ID, err)
+			continue
+		}
+		log.Printf("代理密钥 %s (%s) 已自动禁用，原因: %s", key.Name, key.ID, reason)
+		deactivated++
+	}
+	return deactivated
+}
+
+// StartExpirationCheck 启动后台任务，定期禁用过期或用完的密钥，并从请求日志刷新租户本月用量
+func (m *Manager) StartExpirationCheck(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		m.DeactivateExpiredKeys()
+		m.RefreshTenantUsage()
+		for {
+			select {
+			case <-ticker.C:
+				m.DeactivateExpiredKeys()
+				m.RefreshTenantUsage()
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Close 停止后台任务
+func (m *Manager) Close() {
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+	})
+}
+
+// persistKeyLocked 将密钥的当前状态保存到数据库，调用方需持有写锁
+func (m *Manager) persistKeyLocked(key *ProxyKey) error {
+	if m.requestLogger == nil {
+		return nil
+	}
+
+	var groupSelectionConfigJSON string
+	if key.GroupSelectionConfig != nil {
+		if configBytes, err := json.Marshal(key.GroupSelectionConfig); err == nil {
+			groupSelectionConfigJSON = string(configBytes)
+		}
+	}
+
+	dbKey := &logger.ProxyKey{
+		ID:                   key.ID,
+		Name:                 key.Name,
+		Description:          key.Description,
+		Key:                  key.Key,
+		AllowedGroups:        key.AllowedGroups,
+		GroupSelectionConfig: groupSelectionConfigJSON,
+		IsActive:             key.IsActive,
+		UsageCount:           key.UsageCount,
+		CreatedAt:            key.CreatedAt,
+		UpdatedAt:            time.Now(),
+		ExpiresAt:            key.ExpiresAt,
+		MaxUsageCount:        key.MaxUsageCount,
+		AllowedModels:        key.AllowedModels,
+		DeniedModels:         key.DeniedModels,
+		AllowedIPs:           key.AllowedIPs,
+		DeniedIPs:            key.DeniedIPs,
+		SystemPrompt:         key.SystemPrompt,
+		TenantID:             key.TenantID,
+	}
+
+	if err := m.requestLogger.UpdateProxyKey(dbKey); err != nil {
+		return fmt.Errorf("failed to update proxy key in database: %w", err)
+	}
+	return nil
+}
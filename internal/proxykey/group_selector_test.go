@@ -1,7 +1,13 @@
 package proxykey
 
 import (
+	"errors"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"turnsapi/internal/logger"
 )
 
 func TestGroupSelector_RoundRobin(t *testing.T) {
@@ -9,9 +15,9 @@ func TestGroupSelector_RoundRobin(t *testing.T) {
 	config := &GroupSelectionConfig{
 		Strategy: GroupSelectionRoundRobin,
 	}
-	
+
 	selector := NewGroupSelector(allowedGroups, config)
-	
+
 	// 测试轮询选择
 	expected := []string{"group1", "group2", "group3", "group1", "group2", "group3"}
 	for i, expectedGroup := range expected {
@@ -34,13 +40,13 @@ func TestGroupSelector_Weighted(t *testing.T) {
 			{GroupID: "group2", Weight: 1},
 		},
 	}
-	
+
 	selector := NewGroupSelector(allowedGroups, config)
-	
+
 	// 测试权重选择 - 进行多次选择并统计分布
 	selections := make(map[string]int)
 	totalSelections := 1000
-	
+
 	for i := 0; i < totalSelections; i++ {
 		selectedGroup, err := selector.SelectGroup()
 		if err != nil {
@@ -48,20 +54,20 @@ func TestGroupSelector_Weighted(t *testing.T) {
 		}
 		selections[selectedGroup]++
 	}
-	
+
 	// 验证权重比例大致正确（允许一定误差）
 	group1Ratio := float64(selections["group1"]) / float64(totalSelections)
 	group2Ratio := float64(selections["group2"]) / float64(totalSelections)
-	
+
 	expectedGroup1Ratio := 0.75 // 3/(3+1)
 	expectedGroup2Ratio := 0.25 // 1/(3+1)
-	
+
 	tolerance := 0.1 // 10%的误差容忍度
-	
+
 	if abs(group1Ratio-expectedGroup1Ratio) > tolerance {
 		t.Errorf("Group1 ratio = %v, want approximately %v", group1Ratio, expectedGroup1Ratio)
 	}
-	
+
 	if abs(group2Ratio-expectedGroup2Ratio) > tolerance {
 		t.Errorf("Group2 ratio = %v, want approximately %v", group2Ratio, expectedGroup2Ratio)
 	}
@@ -72,9 +78,9 @@ func TestGroupSelector_SingleGroup(t *testing.T) {
 	config := &GroupSelectionConfig{
 		Strategy: GroupSelectionRoundRobin,
 	}
-	
+
 	selector := NewGroupSelector(allowedGroups, config)
-	
+
 	// 单个分组应该总是返回该分组
 	for i := 0; i < 5; i++ {
 		selectedGroup, err := selector.SelectGroup()
@@ -92,9 +98,9 @@ func TestGroupSelector_EmptyGroups(t *testing.T) {
 	config := &GroupSelectionConfig{
 		Strategy: GroupSelectionRoundRobin,
 	}
-	
+
 	selector := NewGroupSelector(allowedGroups, config)
-	
+
 	// 空分组列表应该返回错误
 	_, err := selector.SelectGroup()
 	if err == nil {
@@ -107,26 +113,26 @@ func TestGroupSelector_Random(t *testing.T) {
 	config := &GroupSelectionConfig{
 		Strategy: GroupSelectionRandom,
 	}
-	
+
 	selector := NewGroupSelector(allowedGroups, config)
-	
+
 	// 测试随机选择 - 验证所有分组都能被选中
 	selections := make(map[string]bool)
 	maxAttempts := 100
-	
+
 	for i := 0; i < maxAttempts; i++ {
 		selectedGroup, err := selector.SelectGroup()
 		if err != nil {
 			t.Fatalf("SelectGroup() error = %v", err)
 		}
 		selections[selectedGroup] = true
-		
+
 		// 如果所有分组都被选中过，测试通过
 		if len(selections) == len(allowedGroups) {
 			break
 		}
 	}
-	
+
 	if len(selections) != len(allowedGroups) {
 		t.Errorf("Random selection didn't select all groups after %d attempts. Selected: %v", maxAttempts, selections)
 	}
@@ -137,9 +143,9 @@ func TestGroupSelector_Failover(t *testing.T) {
 	config := &GroupSelectionConfig{
 		Strategy: GroupSelectionFailover,
 	}
-	
+
 	selector := NewGroupSelector(allowedGroups, config)
-	
+
 	// 故障转移策略应该总是选择第一个分组
 	for i := 0; i < 5; i++ {
 		selectedGroup, err := selector.SelectGroup()
@@ -157,16 +163,16 @@ func TestGroupSelector_UpdateConfig(t *testing.T) {
 	config := &GroupSelectionConfig{
 		Strategy: GroupSelectionRoundRobin,
 	}
-	
+
 	selector := NewGroupSelector(allowedGroups, config)
-	
+
 	// 初始轮询测试
 	group1, _ := selector.SelectGroup()
 	group2, _ := selector.SelectGroup()
 	if group1 != "group1" || group2 != "group2" {
 		t.Errorf("Initial round robin failed: got %v, %v", group1, group2)
 	}
-	
+
 	// 更新为权重策略
 	newConfig := &GroupSelectionConfig{
 		Strategy: GroupSelectionWeighted,
@@ -175,16 +181,16 @@ func TestGroupSelector_UpdateConfig(t *testing.T) {
 			{GroupID: "group2", Weight: 0}, // 权重为0，应该被设为默认权重1
 		},
 	}
-	
+
 	selector.UpdateConfig(newConfig)
-	
+
 	// 验证配置更新生效
 	selections := make(map[string]int)
 	for i := 0; i < 100; i++ {
 		selectedGroup, _ := selector.SelectGroup()
 		selections[selectedGroup]++
 	}
-	
+
 	// 由于两个分组权重相同，应该大致均匀分布
 	if selections["group1"] == 0 || selections["group2"] == 0 {
 		t.Errorf("After config update, both groups should be selected. Got: %v", selections)
@@ -198,3 +204,365 @@ func abs(x float64) float64 {
 	}
 	return x
 }
+
+func TestManager_KeyLimits(t *testing.T) {
+	manager := NewManager()
+	defer manager.Close()
+
+	expired, err := manager.GenerateKey("expired", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	if err := manager.SetKeyLimits(expired.ID, KeyLimits{ExpiresAt: &past}); err != nil {
+		t.Fatalf("SetKeyLimits() error = %v", err)
+	}
+
+	limited, err := manager.GenerateKey("limited", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	if err := manager.SetKeyLimits(limited.ID, KeyLimits{MaxUsageCount: 1}); err != nil {
+		t.Fatalf("SetKeyLimits() error = %v", err)
+	}
+
+	if _, ok := manager.ValidateKey(expired.Key); ok {
+		t.Error("ValidateKey() accepted an expired key")
+	}
+	if reason := manager.KeyInactiveReason(expired.Key); reason != KeyInactiveExpired {
+		t.Errorf("KeyInactiveReason() = %q, want %q", reason, KeyInactiveExpired)
+	}
+	if _, ok := manager.ValidateKey(limited.Key); !ok {
+		t.Error("ValidateKey() rejected a key with remaining uses")
+	}
+
+	manager.UpdateUsage(limited.Key)
+	if reason := manager.KeyInactiveReason(limited.Key); reason != KeyInactiveExhausted {
+		t.Errorf("KeyInactiveReason() = %q, want %q", reason, KeyInactiveExhausted)
+	}
+
+	if n := manager.DeactivateExpiredKeys(); n != 2 {
+		t.Errorf("DeactivateExpiredKeys() = %d, want 2", n)
+	}
+	if n := manager.DeactivateExpiredKeys(); n != 0 {
+		t.Errorf("DeactivateExpiredKeys() second run = %d, want 0", n)
+	}
+}
+
+func TestManager_GenerateKeyWithSettings(t *testing.T) {
+	manager := NewManager()
+	defer manager.Close()
+
+	invalid := []KeySettings{
+		{Limits: KeyLimits{MaxUsageCount: -1}},
+		{IPRules: IPRules{AllowedIPs: []string{"not-an-ip"}}},
+		{TenantID: "missing"},
+	}
+	for _, settings := range invalid {
+		if _, err := manager.GenerateKeyWithConfig("invalid", "", nil, nil, settings); err == nil {
+			t.Errorf("GenerateKeyWithConfig(%+v) accepted invalid settings", settings)
+		}
+	}
+	if keys := manager.GetAllKeys(); len(keys) != 0 {
+		t.Fatalf("GenerateKeyWithConfig() left %d keys after rejected settings, want 0", len(keys))
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	key, err := manager.GenerateKeyWithConfig("limited", "", nil, nil, KeySettings{
+		Limits:       KeyLimits{ExpiresAt: &expiresAt, MaxUsageCount: 5},
+		ModelRules:   ModelRules{AllowedModels: []string{" gpt-4o* "}},
+		IPRules:      IPRules{AllowedIPs: []string{"10.0.0.0/8"}},
+		SystemPrompt: " be brief ",
+	})
+	if err != nil {
+		t.Fatalf("GenerateKeyWithConfig() error = %v", err)
+	}
+	if key.ExpiresAt == nil || !key.ExpiresAt.Equal(expiresAt) || key.MaxUsageCount != 5 {
+		t.Errorf("GenerateKeyWithConfig() limits = %v, %d", key.ExpiresAt, key.MaxUsageCount)
+	}
+	if len(key.AllowedModels) != 1 || key.AllowedModels[0] != "gpt-4o*" || len(key.AllowedIPs) != 1 || key.SystemPrompt != "be brief" {
+		t.Errorf("GenerateKeyWithConfig() settings = %v, %v, %q", key.AllowedModels, key.AllowedIPs, key.SystemPrompt)
+	}
+}
+
+func TestManager_ResolveKey(t *testing.T) {
+	manager := NewManager()
+	defer manager.Close()
+
+	unique, err := manager.GenerateKey("unique", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	first, err := manager.GenerateKey("shared", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	second, err := manager.GenerateKey("shared", "", nil)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	resolved := func(idOrName string) string {
+		keyInfo, ok := manager.ResolveKey(idOrName)
+		if !ok {
+			return ""
+		}
+		return keyInfo.(*logger.ProxyKey).ID
+	}
+
+	if got := resolved(unique.ID); got != unique.ID {
+		t.Errorf("ResolveKey(id) = %q, want %q", got, unique.ID)
+	}
+	if got := resolved("unique"); got != unique.ID {
+		t.Errorf("ResolveKey(name) = %q, want %q", got, unique.ID)
+	}
+	if got := resolved("missing"); got != "" {
+		t.Errorf("ResolveKey(missing) = %q, want no key", got)
+	}
+
+	// 同名的多个启用密钥无法确定映射，按ID仍可解析
+	if got := resolved("shared"); got != "" {
+		t.Errorf("ResolveKey(duplicate name) = %q, want no key", got)
+	}
+	if got := resolved(second.ID); got != second.ID {
+		t.Errorf("ResolveKey(id) = %q, want %q", got, second.ID)
+	}
+
+	// 禁用其中一个后名称只对应一个启用的密钥
+	if err := manager.SetKeyActive(first.ID, false); err != nil {
+		t.Fatalf("SetKeyActive() error = %v", err)
+	}
+	if got := resolved("shared"); got != second.ID {
+		t.Errorf("ResolveKey(name) after disabling duplicate = %q, want %q", got, second.ID)
+	}
+	if got := resolved(first.ID); got != "" {
+		t.Errorf("ResolveKey(disabled id) = %q, want no key", got)
+	}
+}
+
+func TestModelAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		model   string
+		want    bool
+	}{
+		{"no rules", nil, nil, "gpt-4o", true},
+		{"glob allowed", []string{"gpt-4*"}, nil, "gpt-4o-mini", true},
+		{"not in allowlist", []string{"gpt-4*"}, nil, "claude-3-haiku", false},
+		{"case insensitive", []string{"GPT-4*"}, nil, "gpt-4o", true},
+		{"deny wins", []string{"gpt-4*"}, []string{"gpt-4-32k*"}, "gpt-4-32k-0613", false},
+		{"denied only", nil, []string{"o1*"}, "o1-preview", false},
+		{"single char wildcard", []string{"gpt-?o"}, nil, "gpt-4o", true},
+		{"exact match", []string{"openai/gpt-4o"}, nil, "openai/gpt-4o", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ModelAllowed(tt.allowed, tt.denied, tt.model); got != tt.want {
+				t.Errorf("ModelAllowed(%v, %v, %q) = %v, want %v", tt.allowed, tt.denied, tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_ModelRulesPrecompiled(t *testing.T) {
+	manager := NewManager()
+	defer manager.Close()
+
+	key, err := manager.GenerateKeyWithConfig("rules", "", nil, nil, KeySettings{
+		ModelRules: ModelRules{AllowedModels: []string{"precompiled-allow-*"}, DeniedModels: []string{"precompiled-deny-?"}},
+	})
+	if err != nil {
+		t.Fatalf("GenerateKeyWithConfig() error = %v", err)
+	}
+	for _, pattern := range []string{"precompiled-allow-*", "precompiled-deny-?"} {
+		if _, ok := modelPatternCache.Load(pattern); !ok {
+			t.Errorf("pattern %q was not compiled when the key was created", pattern)
+		}
+	}
+
+	if err := manager.SetKeyModelRules(key.ID, ModelRules{AllowedModels: []string{"precompiled-update-*"}}); err != nil {
+		t.Fatalf("SetKeyModelRules() error = %v", err)
+	}
+	if _, ok := modelPatternCache.Load("precompiled-update-*"); !ok {
+		t.Error("pattern was not compiled when the rules were updated")
+	}
+	if !ModelAllowed([]string{"precompiled-update-*"}, nil, "PRECOMPILED-UPDATE-x") {
+		t.Error("ModelAllowed() rejected a model matching the cached pattern")
+	}
+}
+
+func TestManager_SealedKeyShareLink(t *testing.T) {
+	requestLogger, err := logger.NewRequestLogger(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create request logger: %v", err)
+	}
+	defer requestLogger.Close()
+	m := NewManagerWithDB(requestLogger)
+
+	key, plaintext, err := m.GenerateSealedKey("teammate", "", nil, nil, KeySettings{})
+	if err != nil {
+		t.Fatalf("Failed to generate sealed key: %v", err)
+	}
+	if !key.Sealed() || strings.Contains(key.Key, plaintext) {
+		t.Fatalf("Expected only the key hash to be stored, got %q", key.Key)
+	}
+	if _, ok := m.ValidateKey(plaintext); !ok {
+		t.Error("Expected sealed key to authenticate with its plaintext")
+	}
+	if _, ok := m.ValidateKey(key.Key); ok {
+		t.Error("Expected the stored hash not to authenticate")
+	}
+
+	token, _, err := m.CreateShareLink(key.ID, plaintext, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create share link: %v", err)
+	}
+	if shared, _, err := m.ShareLinkKey(token); err != nil || shared.ID != key.ID {
+		t.Fatalf("Expected share link to resolve to the key without consuming it, got %v", err)
+	}
+	redeemed, value, err := m.RedeemShareLink(token)
+	if err != nil || redeemed.ID != key.ID || value != plaintext {
+		t.Fatalf("Expected share link to return the plaintext key, got %q (err: %v)", value, err)
+	}
+	if _, _, err := m.RedeemShareLink(token); !errors.Is(err, ErrShareLinkNotFound) {
+		t.Errorf("Expected share link to be single use, got %v", err)
+	}
+
+	if _, _, err := m.CreateShareLink(key.ID, "tapi-wrong", time.Minute); err == nil {
+		t.Error("Expected share link creation to require the key's plaintext")
+	}
+	if _, _, err := m.CreateShareLink(key.ID, plaintext, MaxShareLinkTTL+time.Minute); err == nil {
+		t.Error("Expected share link ttl above the maximum to be rejected")
+	}
+}
+
+func TestManager_SetKeyIPRules(t *testing.T) {
+	requestLogger, err := logger.NewRequestLogger(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create request logger: %v", err)
+	}
+	defer requestLogger.Close()
+	m := NewManagerWithDB(requestLogger)
+
+	key, err := m.GenerateKey("office", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	if err := m.SetKeyIPRules(key.ID, IPRules{AllowedIPs: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("Expected invalid CIDR to be rejected")
+	}
+	if err := m.SetKeyIPRules(key.ID, IPRules{DeniedIPs: []string{"not-an-ip"}}); err == nil {
+		t.Error("Expected invalid IP address to be rejected")
+	}
+
+	rules := IPRules{
+		AllowedIPs: []string{" 10.0.0.0/8", "192.168.1.10", "10.0.0.0/8", ""},
+		DeniedIPs:  []string{"10.1.2.3"},
+	}
+	if err := m.SetKeyIPRules(key.ID, rules); err != nil {
+		t.Fatalf("Failed to set ip rules: %v", err)
+	}
+
+	stored, err := requestLogger.GetProxyKey(key.Key)
+	if err != nil {
+		t.Fatalf("Failed to load stored key: %v", err)
+	}
+	if len(stored.AllowedIPs) != 2 || stored.AllowedIPs[0] != "10.0.0.0/8" || stored.AllowedIPs[1] != "192.168.1.10" {
+		t.Errorf("Expected normalized allowed ips to be persisted, got %v", stored.AllowedIPs)
+	}
+	if len(stored.DeniedIPs) != 1 || stored.DeniedIPs[0] != "10.1.2.3" {
+		t.Errorf("Expected denied ips to be persisted, got %v", stored.DeniedIPs)
+	}
+
+	reloaded := NewManagerWithDB(requestLogger).GetAllKeys()
+	if len(reloaded) != 1 || len(reloaded[0].AllowedIPs) != 2 || len(reloaded[0].DeniedIPs) != 1 {
+		t.Errorf("Expected ip rules to survive reload, got %+v", reloaded)
+	}
+}
+
+// TestManager_SetKeySystemPrompt 测试代理密钥系统提示词的持久化和重新加载
+func TestManager_SetKeySystemPrompt(t *testing.T) {
+	requestLogger, err := logger.NewRequestLogger(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create request logger: %v", err)
+	}
+	defer requestLogger.Close()
+	m := NewManagerWithDB(requestLogger)
+
+	key, err := m.GenerateKey("support", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	if err := m.SetKeySystemPrompt(key.ID, "  You are {key_name}'s assistant.\n"); err != nil {
+		t.Fatalf("Failed to set system prompt: %v", err)
+	}
+	keyInfo, ok := m.ValidateKey(key.Key)
+	if !ok || keyInfo.(*logger.ProxyKey).SystemPrompt != "You are {key_name}'s assistant." {
+		t.Errorf("Expected trimmed system prompt on validated key, got %+v", keyInfo)
+	}
+
+	reloaded := NewManagerWithDB(requestLogger).GetAllKeys()
+	if len(reloaded) != 1 || reloaded[0].SystemPrompt != "You are {key_name}'s assistant." {
+		t.Errorf("Expected system prompt to survive reload, got %+v", reloaded)
+	}
+
+	if err := m.SetKeySystemPrompt("missing", "x"); err == nil {
+		t.Error("Expected unknown key to be rejected")
+	}
+}
+
+// TestManager_ValidateGroupSelection 测试分组权重校验和路由时实际使用的策略
+func TestManager_ValidateGroupSelection(t *testing.T) {
+	m := NewManager()
+	groups := []string{"group1", "group2"}
+
+	valid := &GroupSelectionConfig{
+		Strategy:     GroupSelectionWeighted,
+		GroupWeights: []GroupWeight{{GroupID: "group1", Weight: 3}, {GroupID: "group2", Weight: 1}},
+	}
+	if err := m.ValidateGroupSelection(valid, groups); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+
+	invalid := []*GroupSelectionConfig{
+		{Strategy: "fastest"},
+		{Strategy: GroupSelectionWeighted, GroupWeights: []GroupWeight{{GroupID: "group3", Weight: 1}}},
+		{Strategy: GroupSelectionWeighted, GroupWeights: []GroupWeight{{GroupID: "group1", Weight: 0}}},
+		{Strategy: GroupSelectionWeighted, GroupWeights: []GroupWeight{{GroupID: "group1", Weight: 1}, {GroupID: "group1", Weight: 2}}},
+	}
+	for _, config := range invalid {
+		if err := m.ValidateGroupSelection(config, groups); err == nil {
+			t.Errorf("Expected config %+v to be rejected", config)
+		}
+	}
+
+	// 非权重策略忽略权重，空策略按轮询处理
+	failover := &GroupSelectionConfig{Strategy: GroupSelectionFailover, GroupWeights: []GroupWeight{{GroupID: "group3", Weight: 1}}}
+	if err := m.ValidateGroupSelection(failover, groups); err != nil || failover.GroupWeights != nil {
+		t.Errorf("Expected weights to be dropped for failover, got %+v (err: %v)", failover, err)
+	}
+	empty := &GroupSelectionConfig{}
+	if err := m.ValidateGroupSelection(empty, groups); err != nil || empty.Strategy != GroupSelectionRoundRobin {
+		t.Errorf("Expected empty strategy to default to round_robin, got %+v (err: %v)", empty, err)
+	}
+
+	weighted, err := m.GenerateKeyWithConfig("weighted", "", groups, valid, KeySettings{})
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	single, err := m.GenerateKey("single", "", []string{"group1"})
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if strategy := m.EffectiveStrategy(weighted.ID); strategy != "weighted" {
+		t.Errorf("Expected weighted strategy, got %s", strategy)
+	}
+	if strategy := m.EffectiveStrategy(single.ID); strategy != EffectiveStrategySingle {
+		t.Errorf("Expected single strategy, got %s", strategy)
+	}
+}
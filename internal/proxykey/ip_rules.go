@@ -0,0 +1,70 @@
+package proxykey
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// IPRules 代理密钥的来源地址允许/禁止列表，每项为IP或CIDR（如 10.0.0.0/8）
+type IPRules struct {
+	AllowedIPs []string `json:"allowed_ips"` // 为空表示允许所有来源
+	DeniedIPs  []string `json:"denied_ips"`  // 优先于允许列表
+}
+
+// normalizeIPRules 去除空白和重复项并校验格式
+func normalizeIPRules(entries []string) ([]string, error) {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || seen[entry] {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", entry)
+			}
+		} else if net.ParseIP(entry) == nil {
+			return nil, fmt.Errorf("invalid IP address %q", entry)
+		}
+		seen[entry] = true
+		normalized = append(normalized, entry)
+	}
+	return normalized, nil
+}
+
+// ValidateIPRules 校验来源地址允许/禁止列表的格式
+func ValidateIPRules(rules IPRules) error {
+	if _, err := normalizeIPRules(rules.AllowedIPs); err != nil {
+		return fmt.Errorf("allowed_ips: %w", err)
+	}
+	if _, err := normalizeIPRules(rules.DeniedIPs); err != nil {
+		return fmt.Errorf("denied_ips: %w", err)
+	}
+	return nil
+}
+
+// SetKeyIPRules 设置代理密钥的来源地址允许/禁止列表
+func (m *Manager) SetKeyIPRules(id string, rules IPRules) error {
+	allowed, err := normalizeIPRules(rules.AllowedIPs)
+	if err != nil {
+		return fmt.Errorf("allowed_ips: %w", err)
+	}
+	denied, err := normalizeIPRules(rules.DeniedIPs)
+	if err != nil {
+		return fmt.Errorf("denied_ips: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, exists := m.keys[id]
+	if !exists {
+		return fmt.Errorf("key not found")
+	}
+
+	key.AllowedIPs = allowed
+	key.DeniedIPs = denied
+	return m.persistKeyLocked(key)
+}
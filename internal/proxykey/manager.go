@@ -2,10 +2,12 @@ package proxykey
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -46,6 +48,62 @@ type ProxyKey struct {
 	LastUsed             time.Time             `json:"last_used"`
 	UsageCount           int64                 `json:"usage_count"`
 	IsActive             bool                  `json:"is_active"`
+	ExpiresAt            *time.Time            `json:"expires_at,omitempty"` // 过期时间，为空表示永不过期
+	MaxUsageCount        int64                 `json:"max_usage_count"`      // 最大使用次数，0表示不限制
+	AllowedModels        []string              `json:"allowed_models"`       // 允许请求的模型，支持通配符，为空表示不限制
+	DeniedModels         []string              `json:"denied_models"`        // 禁止请求的模型，优先于允许列表
+	AllowedIPs           []string              `json:"allowed_ips"`          // 允许的来源地址（IP或CIDR），为空表示不限制
+	DeniedIPs            []string              `json:"denied_ips"`           // 禁止的来源地址，优先于允许列表
+	SystemPrompt         string                `json:"system_prompt"`        // 注入到每个聊天请求的系统提示词，为空表示不注入
+	TenantID             string                `json:"tenant_id"`            // 所属租户ID，为空表示不属于任何租户
+}
+
+// 密钥不可用原因，认证失败时返回不同的错误码
+const (
+	KeyInactiveExpired   = "expired"   // 已过期
+	KeyInactiveExhausted = "exhausted" // 使用次数已用完
+	KeyInactiveDisabled  = "disabled"  // 被手动禁用
+)
+
+// InactiveReason 返回密钥当前不可用的原因，可用时返回空字符串
+// 过期和用完优先于手动禁用，因为后台任务会将过期或用完的密钥自动置为禁用
+func (k *ProxyKey) InactiveReason(now time.Time) string {
+	switch {
+	case k.ExpiresAt != nil && !now.Before(*k.ExpiresAt):
+		return KeyInactiveExpired
+	case k.MaxUsageCount > 0 && k.UsageCount >= k.MaxUsageCount:
+		return KeyInactiveExhausted
+	case !k.IsActive:
+		return KeyInactiveDisabled
+	}
+	return ""
+}
+
+// usable 判断密钥当前是否可用
+func (k *ProxyKey) usable(now time.Time) bool {
+	return k.InactiveReason(now) == ""
+}
+
+// sealedKeyPrefix 只保存哈希的代理密钥在 Key 字段中的前缀
+const sealedKeyPrefix = "sha256:"
+
+// HashProxyKey 计算代理密钥的哈希，密封的密钥只保存哈希值
+func HashProxyKey(keyStr string) string {
+	sum := sha256.Sum256([]byte(keyStr))
+	return sealedKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// Sealed 密钥是否只保存了哈希，明文无法再次获取
+func (k *ProxyKey) Sealed() bool {
+	return strings.HasPrefix(k.Key, sealedKeyPrefix)
+}
+
+// matches 检查客户端提供的明文密钥是否与该密钥匹配，密封的密钥按哈希比较，哈希值本身不能用于认证
+func (k *ProxyKey) matches(keyStr string) bool {
+	if k.Sealed() {
+		return !strings.HasPrefix(keyStr, sealedKeyPrefix) && HashProxyKey(keyStr) == k.Key
+	}
+	return k.Key == keyStr
 }
 
 // ConfigProvider 配置提供者接口
@@ -57,9 +115,13 @@ type ConfigProvider interface {
 type Manager struct {
 	keys           map[string]*ProxyKey
 	groupSelectors map[string]*GroupSelector // 每个代理密钥的分组选择器
+	tenants        map[string]*Tenant        // 租户，多个密钥可以属于同一租户
+	usageMonth     string                    // 租户用量所属的月份（YYYY-MM），跨月后用量重新计算
 	requestLogger  *logger.RequestLogger
 	configProvider ConfigProvider // 配置提供者，用于获取启用的分组
 	mu             sync.RWMutex
+	stopCh         chan struct{}
+	stopOnce       sync.Once
 }
 
 // NewManager 创建新的代理密钥管理器
@@ -67,6 +129,8 @@ func NewManager() *Manager {
 	return &Manager{
 		keys:           make(map[string]*ProxyKey),
 		groupSelectors: make(map[string]*GroupSelector),
+		tenants:        make(map[string]*Tenant),
+		stopCh:         make(chan struct{}),
 	}
 }
 
@@ -80,8 +144,10 @@ func NewManagerWithConfig(requestLogger *logger.RequestLogger, configProvider Co
 	m := &Manager{
 		keys:           make(map[string]*ProxyKey),
 		groupSelectors: make(map[string]*GroupSelector),
+		tenants:        make(map[string]*Tenant),
 		requestLogger:  requestLogger,
 		configProvider: configProvider,
+		stopCh:         make(chan struct{}),
 	}
 
 	// 从数据库加载现有密钥
@@ -91,6 +157,9 @@ func NewManagerWithConfig(requestLogger *logger.RequestLogger, configProvider Co
 	} else {
 		log.Println("Database loading process completed")
 	}
+	if err := m.loadTenantsFromDB(); err != nil {
+		log.Printf("ERROR: Failed to load tenants from database: %v", err)
+	}
 
 	return m
 }
@@ -130,6 +199,14 @@ func (m *Manager) loadKeysFromDB() error {
 			CreatedAt:     dbKey.CreatedAt,
 			IsActive:      dbKey.IsActive,
 			UsageCount:    dbKey.UsageCount, // 添加使用次数字段
+			ExpiresAt:     dbKey.ExpiresAt,
+			MaxUsageCount: dbKey.MaxUsageCount,
+			AllowedModels: dbKey.AllowedModels,
+			DeniedModels:  dbKey.DeniedModels,
+			AllowedIPs:    dbKey.AllowedIPs,
+			DeniedIPs:     dbKey.DeniedIPs,
+			SystemPrompt:  dbKey.SystemPrompt,
+			TenantID:      dbKey.TenantID,
 		}
 
 		// 解析分组选择配置
@@ -148,6 +225,7 @@ func (m *Manager) loadKeysFromDB() error {
 		}
 
 		m.keys[key.ID] = key
+		precompileModelPatterns(key.AllowedModels, key.DeniedModels)
 		log.Printf("Loaded proxy key: %s (%s)", key.Name, key.ID)
 
 		// 初始化分组选择器（如果需要）
@@ -180,29 +258,105 @@ func (m *Manager) loadKeysFromDB() error {
 	return nil
 }
 
+// KeySettings 生成代理密钥时一并保存的限制和附加设置，与密钥在同一次写入中生效
+type KeySettings struct {
+	Limits       KeyLimits
+	ModelRules   ModelRules
+	IPRules      IPRules
+	SystemPrompt string
+	TenantID     string
+}
+
 // GenerateKey 生成新的代理API密钥
 func (m *Manager) GenerateKey(name, description string, allowedGroups []string) (*ProxyKey, error) {
-	return m.GenerateKeyWithConfig(name, description, allowedGroups, nil)
+	return m.GenerateKeyWithConfig(name, description, allowedGroups, nil, KeySettings{})
+}
+
+// GenerateKeyWithConfig 生成带分组选择配置和限制设置的代理API密钥
+func (m *Manager) GenerateKeyWithConfig(name, description string, allowedGroups []string, groupSelectionConfig *GroupSelectionConfig, settings KeySettings) (*ProxyKey, error) {
+	key, _, err := m.generateKey(name, description, allowedGroups, groupSelectionConfig, settings, false)
+	return key, err
 }
 
-// GenerateKeyWithConfig 生成带分组选择配置的代理API密钥
-func (m *Manager) GenerateKeyWithConfig(name, description string, allowedGroups []string, groupSelectionConfig *GroupSelectionConfig) (*ProxyKey, error) {
+// GenerateSealedKey 生成只保存哈希的代理API密钥，返回仅此一次可见的明文密钥
+func (m *Manager) GenerateSealedKey(name, description string, allowedGroups []string, groupSelectionConfig *GroupSelectionConfig, settings KeySettings) (*ProxyKey, string, error) {
+	return m.generateKey(name, description, allowedGroups, groupSelectionConfig, settings, true)
+}
+
+// generateKey 生成代理API密钥，sealed为true时只保存密钥的哈希
+// 限制设置无效时不创建密钥，避免留下没有限制的密钥
+func (m *Manager) generateKey(name, description string, allowedGroups []string, groupSelectionConfig *GroupSelectionConfig, settings KeySettings, sealed bool) (*ProxyKey, string, error) {
+	if settings.Limits.MaxUsageCount < 0 {
+		return nil, "", fmt.Errorf("max_usage_count must not be negative")
+	}
+	allowedIPs, err := normalizeIPRules(settings.IPRules.AllowedIPs)
+	if err != nil {
+		return nil, "", fmt.Errorf("allowed_ips: %w", err)
+	}
+	deniedIPs, err := normalizeIPRules(settings.IPRules.DeniedIPs)
+	if err != nil {
+		return nil, "", fmt.Errorf("denied_ips: %w", err)
+	}
+	tenantID := strings.TrimSpace(settings.TenantID)
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// 生成随机密钥
+	if tenantID != "" {
+		if _, exists := m.tenants[tenantID]; !exists {
+			return nil, "", fmt.Errorf("tenant %s not found", tenantID)
+		}
+	}
+
+	keyStr, err := newKeyString()
+	if err != nil {
+		return nil, "", err
+	}
+	storedKey := keyStr
+	if sealed {
+		storedKey = HashProxyKey(keyStr)
+	}
+
+	key := &ProxyKey{
+		ID:                   generateID(),
+		Key:                  storedKey,
+		Name:                 name,
+		Description:          description,
+		AllowedGroups:        allowedGroups,
+		GroupSelectionConfig: groupSelectionConfig,
+		CreatedAt:            time.Now(),
+		IsActive:             true,
+		ExpiresAt:            settings.Limits.ExpiresAt,
+		MaxUsageCount:        settings.Limits.MaxUsageCount,
+		AllowedModels:        normalizeModelPatterns(settings.ModelRules.AllowedModels),
+		DeniedModels:         normalizeModelPatterns(settings.ModelRules.DeniedModels),
+		AllowedIPs:           allowedIPs,
+		DeniedIPs:            deniedIPs,
+		SystemPrompt:         strings.TrimSpace(settings.SystemPrompt),
+		TenantID:             tenantID,
+	}
+	if err := m.addKeyLocked(key); err != nil {
+		return nil, "", err
+	}
+	return key, keyStr, nil
+}
+
+// newKeyString 生成随机的明文代理密钥
+func newKeyString() (string, error) {
 	keyBytes := make([]byte, 32)
 	if _, err := rand.Read(keyBytes); err != nil {
-		return nil, fmt.Errorf("failed to generate random key: %w", err)
+		return "", fmt.Errorf("failed to generate random key: %w", err)
 	}
+	return "tapi-" + hex.EncodeToString(keyBytes), nil
+}
 
-	keyStr := "tapi-" + hex.EncodeToString(keyBytes)
-	id := generateID()
-	now := time.Now()
+// addKeyLocked 保存新密钥，预编译模型规则并初始化分组选择器，调用方需持有写锁
+func (m *Manager) addKeyLocked(key *ProxyKey) error {
+	precompileModelPatterns(key.AllowedModels, key.DeniedModels)
 
 	// 确定是否需要分组选择配置
 	needsGroupSelection := false
-	if len(allowedGroups) == 0 {
+	if len(key.AllowedGroups) == 0 {
 		// 空分组列表表示可以访问所有分组
 		if m.configProvider != nil {
 			enabledGroups := m.configProvider.GetEnabledGroups()
@@ -210,62 +364,60 @@ func (m *Manager) GenerateKeyWithConfig(name, description string, allowedGroups
 				needsGroupSelection = true
 			}
 		}
-	} else if len(allowedGroups) > 1 {
+	} else if len(key.AllowedGroups) > 1 {
 		// 多个指定分组
 		needsGroupSelection = true
 	}
 
 	// 如果需要分组选择但没有指定配置，使用默认的轮询策略
-	if needsGroupSelection && groupSelectionConfig == nil {
-		groupSelectionConfig = &GroupSelectionConfig{
+	if needsGroupSelection && key.GroupSelectionConfig == nil {
+		key.GroupSelectionConfig = &GroupSelectionConfig{
 			Strategy: GroupSelectionRoundRobin,
 		}
 	}
 
-	key := &ProxyKey{
-		ID:                   id,
-		Key:                  keyStr,
-		Name:                 name,
-		Description:          description,
-		AllowedGroups:        allowedGroups,
-		GroupSelectionConfig: groupSelectionConfig,
-		CreatedAt:            now,
-		IsActive:             true,
-	}
-
 	// 保存到数据库
 	if m.requestLogger != nil {
 		// 序列化分组选择配置
 		var groupSelectionConfigJSON string
-		if groupSelectionConfig != nil {
-			if configBytes, err := json.Marshal(groupSelectionConfig); err == nil {
+		if key.GroupSelectionConfig != nil {
+			if configBytes, err := json.Marshal(key.GroupSelectionConfig); err == nil {
 				groupSelectionConfigJSON = string(configBytes)
 			}
 		}
 
 		dbKey := &logger.ProxyKey{
-			ID:                   id,
-			Name:                 name,
-			Description:          description,
-			Key:                  keyStr,
-			AllowedGroups:        allowedGroups,
+			ID:                   key.ID,
+			Name:                 key.Name,
+			Description:          key.Description,
+			Key:                  key.Key,
+			AllowedGroups:        key.AllowedGroups,
 			GroupSelectionConfig: groupSelectionConfigJSON,
-			IsActive:             true,
-			CreatedAt:            now,
-			UpdatedAt:            now,
+			IsActive:             key.IsActive,
+			UsageCount:           key.UsageCount,
+			CreatedAt:            key.CreatedAt,
+			UpdatedAt:            key.CreatedAt,
+			ExpiresAt:            key.ExpiresAt,
+			MaxUsageCount:        key.MaxUsageCount,
+			AllowedModels:        key.AllowedModels,
+			DeniedModels:         key.DeniedModels,
+			AllowedIPs:           key.AllowedIPs,
+			DeniedIPs:            key.DeniedIPs,
+			SystemPrompt:         key.SystemPrompt,
+			TenantID:             key.TenantID,
 		}
 
 		if err := m.requestLogger.InsertProxyKey(dbKey); err != nil {
-			return nil, fmt.Errorf("failed to save proxy key to database: %w", err)
+			return fmt.Errorf("failed to save proxy key to database: %w", err)
 		}
 	}
 
-	m.keys[id] = key
+	m.keys[key.ID] = key
 
 	// 初始化分组选择器（如果需要）
 	if needsGroupSelection {
 		var selectorGroups []string
-		if len(allowedGroups) == 0 {
+		if len(key.AllowedGroups) == 0 {
 			// 空分组列表，使用所有启用的分组
 			if m.configProvider != nil {
 				enabledGroups := m.configProvider.GetEnabledGroups()
@@ -275,14 +427,14 @@ func (m *Manager) GenerateKeyWithConfig(name, description string, allowedGroups
 			}
 		} else {
 			// 使用指定的分组
-			selectorGroups = allowedGroups
+			selectorGroups = key.AllowedGroups
 		}
 
 		if len(selectorGroups) > 1 {
-			m.groupSelectors[id] = NewGroupSelector(selectorGroups, groupSelectionConfig)
+			m.groupSelectors[key.ID] = NewGroupSelector(selectorGroups, key.GroupSelectionConfig)
 		}
 	}
-	return key, nil
+	return nil
 }
 
 // ValidateKey 验证代理API密钥
@@ -290,35 +442,76 @@ func (m *Manager) ValidateKey(keyStr string) (interface{}, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	now := time.Now()
 	for _, key := range m.keys {
-		if key.Key == keyStr && key.IsActive {
-			// 返回logger.ProxyKey类型以便认证中间件使用
-			dbKey := &logger.ProxyKey{
-				ID:            key.ID,
-				Name:          key.Name,
-				Description:   key.Description,
-				Key:           key.Key,
-				AllowedGroups: key.AllowedGroups,
-				IsActive:      key.IsActive,
-				CreatedAt:     key.CreatedAt,
-				UpdatedAt:     key.CreatedAt,
-			}
-			if !key.LastUsed.IsZero() {
-				dbKey.LastUsedAt = &key.LastUsed
-			}
-			return dbKey, true
+		if key.matches(keyStr) && m.keyUsableLocked(key, now) {
+			return authKey(key), true
 		}
 	}
 	return nil, false
 }
 
+// authKey 转换为logger.ProxyKey类型以便认证中间件使用
+func authKey(key *ProxyKey) *logger.ProxyKey {
+	dbKey := &logger.ProxyKey{
+		ID:            key.ID,
+		Name:          key.Name,
+		Description:   key.Description,
+		Key:           key.Key,
+		AllowedGroups: key.AllowedGroups,
+		IsActive:      key.IsActive,
+		CreatedAt:     key.CreatedAt,
+		UpdatedAt:     key.CreatedAt,
+		AllowedModels: key.AllowedModels,
+		DeniedModels:  key.DeniedModels,
+		AllowedIPs:    key.AllowedIPs,
+		DeniedIPs:     key.DeniedIPs,
+		SystemPrompt:  key.SystemPrompt,
+		TenantID:      key.TenantID,
+	}
+	if !key.LastUsed.IsZero() {
+		dbKey.LastUsedAt = &key.LastUsed
+	}
+	return dbKey
+}
+
+// ResolveKey 按ID或名称查找启用的代理密钥，供可信头部认证将网关身份映射到代理密钥
+// ID优先匹配；多个启用的密钥同名时无法确定映射的密钥，按不存在处理，需改用密钥ID
+func (m *Manager) ResolveKey(idOrName string) (interface{}, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var byName []*ProxyKey
+	now := time.Now()
+	for _, key := range m.keys {
+		if !m.keyUsableLocked(key, now) {
+			continue
+		}
+		if key.ID == idOrName {
+			// 按密钥对象返回，密封的密钥没有明文可供校验
+			return authKey(key), true
+		}
+		if key.Name == idOrName {
+			byName = append(byName, key)
+		}
+	}
+
+	if len(byName) > 1 {
+		log.Printf("代理密钥名称 %s 对应 %d 个启用的密钥，请在身份映射中使用密钥ID", idOrName, len(byName))
+	}
+	if len(byName) != 1 {
+		return nil, false
+	}
+	return authKey(byName[0]), true
+}
+
 // ValidateKeyForGroup 验证代理API密钥是否可以访问指定分组
 func (m *Manager) ValidateKeyForGroup(keyStr, groupID string) (interface{}, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	now := time.Now()
 	for _, key := range m.keys {
-		if key.Key == keyStr && key.IsActive {
+		if key.matches(keyStr) && m.keyUsableLocked(key, now) {
 			// 检查分组访问权限
 			if len(key.AllowedGroups) > 0 {
 				hasAccess := false
@@ -334,35 +527,22 @@ func (m *Manager) ValidateKeyForGroup(keyStr, groupID string) (interface{}, bool
 			}
 			// 如果AllowedGroups为空，表示可以访问所有分组
 
-			// 返回logger.ProxyKey类型以便认证中间件使用
-			dbKey := &logger.ProxyKey{
-				ID:            key.ID,
-				Name:          key.Name,
-				Description:   key.Description,
-				Key:           key.Key,
-				AllowedGroups: key.AllowedGroups,
-				IsActive:      key.IsActive,
-				CreatedAt:     key.CreatedAt,
-				UpdatedAt:     key.CreatedAt,
-			}
-			if !key.LastUsed.IsZero() {
-				dbKey.LastUsedAt = &key.LastUsed
-			}
-			return dbKey, true
+			return authKey(key), true
 		}
 	}
 	return nil, false
 }
 
-// UpdateUsage 更新密钥使用统计
+// UpdateUsage 更新密钥使用统计，keyStr可以是明文密钥或认证返回的密钥记录中的 Key
 func (m *Manager) UpdateUsage(keyStr string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	for _, key := range m.keys {
-		if key.Key == keyStr {
+		if key.Key == keyStr || key.matches(keyStr) {
 			key.LastUsed = time.Now()
 			key.UsageCount++
+			m.countTenantRequestLocked(key, key.LastUsed)
 
 			// 更新数据库中的使用次数和最后使用时间
 			if m.requestLogger != nil {
@@ -406,6 +586,9 @@ func (m *Manager) DeleteKey(id string) error {
 		if err := m.requestLogger.DeleteProxyKey(id); err != nil {
 			return fmt.Errorf("failed to delete proxy key from database: %w", err)
 		}
+		if err := m.requestLogger.DeleteProxyKeySharesForKey(id); err != nil {
+			log.Printf("Failed to delete share links of proxy key %s: %v", id, err)
+		}
 	}
 
 	delete(m.keys, id)
@@ -461,8 +644,17 @@ func (m *Manager) UpdateKeyWithConfig(id string, name, description string, isAct
 			AllowedGroups:        allowedGroups,
 			GroupSelectionConfig: groupSelectionConfigJSON,
 			IsActive:             isActive,
+			UsageCount:           key.UsageCount,
 			CreatedAt:            key.CreatedAt,
 			UpdatedAt:            time.Now(),
+			ExpiresAt:            key.ExpiresAt,
+			MaxUsageCount:        key.MaxUsageCount,
+			AllowedModels:        key.AllowedModels,
+			DeniedModels:         key.DeniedModels,
+			AllowedIPs:           key.AllowedIPs,
+			DeniedIPs:            key.DeniedIPs,
+			SystemPrompt:         key.SystemPrompt,
+			TenantID:             key.TenantID,
 		}
 
 		if err := m.requestLogger.UpdateProxyKey(dbKey); err != nil {
@@ -509,6 +701,20 @@ func (m *Manager) UpdateKeyWithConfig(id string, name, description string, isAct
 	return nil
 }
 
+// SetKeyActive 启用或禁用代理密钥，已过期或用完的密钥启用后仍不可用
+func (m *Manager) SetKeyActive(id string, active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, exists := m.keys[id]
+	if !exists {
+		return fmt.Errorf("key not found")
+	}
+
+	key.IsActive = active
+	return m.persistKeyLocked(key)
+}
+
 // SelectGroupForKey 为指定的代理密钥选择分组
 func (m *Manager) SelectGroupForKey(keyID string) (string, error) {
 	m.mu.RLock()
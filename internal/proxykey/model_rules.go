@@ -0,0 +1,99 @@
+package proxykey
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ModelRules 代理密钥的模型允许/禁止列表，支持 * 和 ? 通配符（如 gpt-4*）
+type ModelRules struct {
+	AllowedModels []string `json:"allowed_models"` // 为空表示允许所有模型
+	DeniedModels  []string `json:"denied_models"`  // 优先于允许列表
+}
+
+// ModelAllowed 判断模型是否允许被请求：命中禁止列表时拒绝，允许列表非空时必须命中其中一项
+func ModelAllowed(allowed, denied []string, model string) bool {
+	for _, pattern := range denied {
+		if matchModelPattern(pattern, model) {
+			return false
+		}
+	}
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, pattern := range allowed {
+		if matchModelPattern(pattern, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchModelPattern 按通配符匹配模型名称，不区分大小写，* 可以匹配 / 以支持 openai/gpt-4o 这类名称
+func matchModelPattern(pattern, model string) bool {
+	if !strings.ContainsAny(pattern, "*?") {
+		return strings.EqualFold(pattern, model)
+	}
+	return compileModelPattern(pattern).MatchString(model)
+}
+
+// modelPatternCache 通配符规则编译后的正则缓存：pattern -> *regexp.Regexp
+var modelPatternCache sync.Map
+
+// compileModelPattern 将通配符规则编译为正则，结果按规则缓存
+func compileModelPattern(pattern string) *regexp.Regexp {
+	if cached, ok := modelPatternCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp)
+	}
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	// 转义后只包含字面量和 .*、.，总能编译成功
+	re := regexp.MustCompile("(?i)^" + expr + "$")
+	modelPatternCache.Store(pattern, re)
+	return re
+}
+
+// precompileModelPatterns 在加载密钥或修改规则时预先编译通配符规则，请求时只需查缓存
+func precompileModelPatterns(patternLists ...[]string) {
+	for _, patterns := range patternLists {
+		for _, pattern := range patterns {
+			if strings.ContainsAny(pattern, "*?") {
+				compileModelPattern(pattern)
+			}
+		}
+	}
+}
+
+// normalizeModelPatterns 去除空白和重复的匹配规则
+func normalizeModelPatterns(patterns []string) []string {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" || seen[pattern] {
+			continue
+		}
+		seen[pattern] = true
+		normalized = append(normalized, pattern)
+	}
+	return normalized
+}
+
+// SetKeyModelRules 设置代理密钥的模型允许/禁止列表
+func (m *Manager) SetKeyModelRules(id string, rules ModelRules) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, exists := m.keys[id]
+	if !exists {
+		return fmt.Errorf("key not found")
+	}
+
+	key.AllowedModels = normalizeModelPatterns(rules.AllowedModels)
+	key.DeniedModels = normalizeModelPatterns(rules.DeniedModels)
+	precompileModelPatterns(key.AllowedModels, key.DeniedModels)
+	return m.persistKeyLocked(key)
+}
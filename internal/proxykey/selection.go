@@ -0,0 +1,89 @@
+package proxykey
+
+import "fmt"
+
+// EffectiveStrategySingle 密钥只能访问一个分组时的实际策略，不需要分组选择
+const EffectiveStrategySingle = "single"
+
+// validStrategy 判断分组选择策略是否有效
+func validStrategy(strategy GroupSelectionStrategy) bool {
+	switch strategy {
+	case GroupSelectionRoundRobin, GroupSelectionWeighted, GroupSelectionRandom, GroupSelectionFailover:
+		return true
+	}
+	return false
+}
+
+// validateGroupSelection 校验分组选择配置：策略有效，权重为正数且只能引用 groups 中的分组，groups 为nil时不检查分组
+// 策略为空时按轮询处理，非权重策略忽略权重配置
+func validateGroupSelection(config *GroupSelectionConfig, groups []string) error {
+	if config == nil {
+		return nil
+	}
+	if config.Strategy == "" {
+		config.Strategy = GroupSelectionRoundRobin
+	}
+	if !validStrategy(config.Strategy) {
+		return fmt.Errorf("invalid group selection strategy %q, expected round_robin, weighted, random or failover", config.Strategy)
+	}
+	if config.Strategy != GroupSelectionWeighted {
+		config.GroupWeights = nil
+		return nil
+	}
+
+	var allowed map[string]bool
+	if groups != nil {
+		allowed = make(map[string]bool, len(groups))
+		for _, groupID := range groups {
+			allowed[groupID] = true
+		}
+	}
+	seen := make(map[string]bool, len(config.GroupWeights))
+	for _, weight := range config.GroupWeights {
+		switch {
+		case weight.GroupID == "":
+			return fmt.Errorf("group weight is missing group_id")
+		case seen[weight.GroupID]:
+			return fmt.Errorf("duplicate weight for group %q", weight.GroupID)
+		case allowed != nil && !allowed[weight.GroupID]:
+			return fmt.Errorf("weight for group %q which the key is not allowed to access", weight.GroupID)
+		case weight.Weight <= 0:
+			return fmt.Errorf("weight for group %q must be positive", weight.GroupID)
+		}
+		seen[weight.GroupID] = true
+	}
+	return nil
+}
+
+// ValidateGroupSelection 按密钥允许的分组校验分组选择配置，允许的分组为空时按所有启用的分组校验
+// 校验通过时会规范化配置：策略为空时设为轮询，非权重策略清除权重
+func (m *Manager) ValidateGroupSelection(config *GroupSelectionConfig, allowedGroups []string) error {
+	groups := allowedGroups
+	if len(groups) == 0 {
+		groups = nil
+		if m.configProvider != nil {
+			groups = []string{}
+			for groupID := range m.configProvider.GetEnabledGroups() {
+				groups = append(groups, groupID)
+			}
+		}
+	}
+	return validateGroupSelection(config, groups)
+}
+
+// EffectiveStrategy 返回路由时实际使用的分组选择策略，只有一个可用分组时返回 single
+func (m *Manager) EffectiveStrategy(keyID string) string {
+	m.mu.RLock()
+	selector, exists := m.groupSelectors[keyID]
+	m.mu.RUnlock()
+	if !exists {
+		return EffectiveStrategySingle
+	}
+
+	selector.mutex.RLock()
+	defer selector.mutex.RUnlock()
+	if selector.config == nil || !validStrategy(selector.config.Strategy) {
+		return string(GroupSelectionRoundRobin)
+	}
+	return string(selector.config.Strategy)
+}
@@ -0,0 +1,152 @@
+package proxykey
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"turnsapi/internal/logger"
+)
+
+// 一次性分享链接的有效期
+const (
+	DefaultShareLinkTTL = time.Hour
+	MaxShareLinkTTL     = 7 * 24 * time.Hour
+)
+
+// shareTokenPrefix 分享链接令牌前缀
+const shareTokenPrefix = "tshr_"
+
+// ErrShareLinkNotFound 分享链接不存在、已被查看或已过期
+var ErrShareLinkNotFound = logger.ErrProxyKeyShareNotFound
+
+// shareID 分享链接令牌的哈希，数据库中只保存哈希
+func shareID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// shareCipher 由分享链接令牌派生加密密钥，没有令牌无法解密数据库中的密钥
+func shareCipher(token string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte("turnsapi-proxy-key-share\x00" + token))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// CreateShareLink 为代理密钥创建一次性分享链接，返回链接令牌和过期时间
+// 明文密钥用链接令牌加密保存，数据库中不保存令牌本身
+func (m *Manager) CreateShareLink(keyID, plaintext string, ttl time.Duration) (string, time.Time, error) {
+	if m.requestLogger == nil {
+		return "", time.Time{}, fmt.Errorf("share links require database storage")
+	}
+	if ttl <= 0 {
+		ttl = DefaultShareLinkTTL
+	}
+	if ttl > MaxShareLinkTTL {
+		return "", time.Time{}, fmt.Errorf("share link ttl must not exceed %v", MaxShareLinkTTL)
+	}
+
+	m.mu.RLock()
+	key, exists := m.keys[keyID]
+	valid := exists && key.matches(plaintext)
+	m.mu.RUnlock()
+	if !valid {
+		return "", time.Time{}, fmt.Errorf("key not found")
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate share token: %w", err)
+	}
+	token := shareTokenPrefix + hex.EncodeToString(tokenBytes)
+	id := shareID(token)
+
+	aead, err := shareCipher(token)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create share cipher: %w", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate share nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(id))
+
+	now := time.Now()
+	share := &logger.ProxyKeyShare{
+		ID:         id,
+		KeyID:      keyID,
+		Ciphertext: base64.StdEncoding.EncodeToString(sealed),
+		ExpiresAt:  now.Add(ttl),
+		CreatedAt:  now,
+	}
+	if err := m.requestLogger.InsertProxyKeyShare(share); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, share.ExpiresAt, nil
+}
+
+// ShareLinkKey 查看分享链接对应的代理密钥和过期时间，不会使链接失效
+func (m *Manager) ShareLinkKey(token string) (*ProxyKey, time.Time, error) {
+	if m.requestLogger == nil || !strings.HasPrefix(token, shareTokenPrefix) {
+		return nil, time.Time{}, ErrShareLinkNotFound
+	}
+	share, err := m.requestLogger.GetProxyKeyShare(shareID(token))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	m.mu.RLock()
+	key, exists := m.keys[share.KeyID]
+	m.mu.RUnlock()
+	if !exists {
+		return nil, time.Time{}, ErrShareLinkNotFound
+	}
+	return key, share.ExpiresAt, nil
+}
+
+// RedeemShareLink 读取分享链接中的明文密钥，链接随即失效
+func (m *Manager) RedeemShareLink(token string) (*ProxyKey, string, error) {
+	if m.requestLogger == nil || !strings.HasPrefix(token, shareTokenPrefix) {
+		return nil, "", ErrShareLinkNotFound
+	}
+	id := shareID(token)
+	share, err := m.requestLogger.ConsumeProxyKeyShare(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(share.Ciphertext)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode share: %w", err)
+	}
+	aead, err := shareCipher(token)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create share cipher: %w", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, "", errors.New("share ciphertext is truncated")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decrypt share: %w", err)
+	}
+
+	m.mu.RLock()
+	key, exists := m.keys[share.KeyID]
+	m.mu.RUnlock()
+	if !exists {
+		// 密钥在链接查看前已被删除
+		return nil, "", ErrShareLinkNotFound
+	}
+	return key, string(plaintext), nil
+}
@@ -0,0 +1,20 @@
+package proxykey
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SetKeySystemPrompt 设置注入到代理密钥每个聊天请求的系统提示词，空字符串表示不注入
+func (m *Manager) SetKeySystemPrompt(id string, prompt string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, exists := m.keys[id]
+	if !exists {
+		return fmt.Errorf("key not found")
+	}
+
+	key.SystemPrompt = strings.TrimSpace(prompt)
+	return m.persistKeyLocked(key)
+}
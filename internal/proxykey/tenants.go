@@ -0,0 +1,354 @@
+package proxykey
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"turnsapi/internal/logger"
+)
+
+// 租户导致密钥不可用的原因
+const (
+	KeyInactiveTenantDisabled = "tenant_disabled"       // 所属租户被停用
+	KeyInactiveTenantQuota    = "tenant_quota_exceeded" // 所属租户本月额度已用完
+)
+
+// Tenant 租户，多个代理密钥可以属于同一租户，按租户统计用量并限制每月额度
+type Tenant struct {
+	ID                  string    `json:"id"`
+	Name                string    `json:"name"`
+	Description         string    `json:"description"`
+	IsActive            bool      `json:"is_active"`
+	MonthlyRequestLimit int64     `json:"monthly_request_limit"` // 每自然月请求数上限，0表示不限制
+	MonthlyTokenLimit   int64     `json:"monthly_token_limit"`   // 每自然月token数上限，0表示不限制
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+	MonthRequests       int64     `json:"month_requests"` // 本月请求数
+	MonthTokens         int64     `json:"month_tokens"`   // 本月token数，定期从请求日志刷新
+	KeyCount            int       `json:"key_count"`      // 属于该租户的代理密钥数量
+}
+
+// TenantSettings 创建或更新租户的设置
+type TenantSettings struct {
+	Name                string `json:"name"`
+	Description         string `json:"description"`
+	IsActive            bool   `json:"is_active"`
+	MonthlyRequestLimit int64  `json:"monthly_request_limit"`
+	MonthlyTokenLimit   int64  `json:"monthly_token_limit"`
+}
+
+// InactiveReason 返回租户导致其密钥不可用的原因，租户可用时返回空字符串
+func (t *Tenant) InactiveReason() string {
+	switch {
+	case !t.IsActive:
+		return KeyInactiveTenantDisabled
+	case t.MonthlyRequestLimit > 0 && t.MonthRequests >= t.MonthlyRequestLimit:
+		return KeyInactiveTenantQuota
+	case t.MonthlyTokenLimit > 0 && t.MonthTokens >= t.MonthlyTokenLimit:
+		return KeyInactiveTenantQuota
+	}
+	return ""
+}
+
+// keyInactiveReasonLocked 返回密钥不可用的原因，密钥本身可用时检查所属租户，调用方需持有锁
+func (m *Manager) keyInactiveReasonLocked(key *ProxyKey, now time.Time) string {
+	if reason := key.InactiveReason(now); reason != "" {
+		return reason
+	}
+	if tenant, exists := m.tenants[key.TenantID]; exists {
+		return tenant.InactiveReason()
+	}
+	return ""
+}
+
+// keyUsableLocked 判断密钥及其所属租户当前是否可用，调用方需持有锁
+func (m *Manager) keyUsableLocked(key *ProxyKey, now time.Time) bool {
+	return m.keyInactiveReasonLocked(key, now) == ""
+}
+
+// loadTenantsFromDB 从数据库加载租户并计算本月用量
+func (m *Manager) loadTenantsFromDB() error {
+	if m.requestLogger == nil {
+		return nil
+	}
+	dbTenants, err := m.requestLogger.GetAllTenants()
+	if err != nil {
+		return fmt.Errorf("failed to get tenants from database: %w", err)
+	}
+
+	m.mu.Lock()
+	for _, dbTenant := range dbTenants {
+		m.tenants[dbTenant.ID] = &Tenant{
+			ID:                  dbTenant.ID,
+			Name:                dbTenant.Name,
+			Description:         dbTenant.Description,
+			IsActive:            dbTenant.IsActive,
+			MonthlyRequestLimit: dbTenant.MonthlyRequestLimit,
+			MonthlyTokenLimit:   dbTenant.MonthlyTokenLimit,
+			CreatedAt:           dbTenant.CreatedAt,
+			UpdatedAt:           dbTenant.UpdatedAt,
+		}
+	}
+	m.mu.Unlock()
+
+	if len(dbTenants) > 0 {
+		log.Printf("Loaded %d tenants from database", len(dbTenants))
+		m.RefreshTenantUsage()
+	}
+	return nil
+}
+
+// validateTenantSettingsLocked 校验租户设置，名称不能为空且不能与其他租户重复，调用方需持有锁
+func (m *Manager) validateTenantSettingsLocked(id string, settings *TenantSettings) error {
+	settings.Name = strings.TrimSpace(settings.Name)
+	settings.Description = strings.TrimSpace(settings.Description)
+	if settings.Name == "" {
+		return fmt.Errorf("tenant name is required")
+	}
+	if settings.MonthlyRequestLimit < 0 || settings.MonthlyTokenLimit < 0 {
+		return fmt.Errorf("tenant limits must not be negative")
+	}
+	for _, tenant := range m.tenants {
+		if tenant.ID != id && tenant.Name == settings.Name {
+			return fmt.Errorf("tenant %s already exists", settings.Name)
+		}
+	}
+	return nil
+}
+
+// CreateTenant 创建租户
+func (m *Manager) CreateTenant(settings TenantSettings) (*Tenant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.validateTenantSettingsLocked("", &settings); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tenant := &Tenant{
+		ID:        generateID(),
+		CreatedAt: now,
+	}
+	tenant.apply(settings, now)
+	if err := m.persistTenantLocked(tenant, true); err != nil {
+		return nil, err
+	}
+	m.tenants[tenant.ID] = tenant
+	return m.tenantViewLocked(tenant), nil
+}
+
+// UpdateTenant 更新租户的名称、说明、启用状态和每月额度
+func (m *Manager) UpdateTenant(id string, settings TenantSettings) (*Tenant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tenant, exists := m.tenants[id]
+	if !exists {
+		return nil, fmt.Errorf("tenant not found")
+	}
+	if err := m.validateTenantSettingsLocked(id, &settings); err != nil {
+		return nil, err
+	}
+	updated := *tenant
+	updated.apply(settings, time.Now())
+	if err := m.persistTenantLocked(&updated, false); err != nil {
+		return nil, err
+	}
+	*tenant = updated
+	return m.tenantViewLocked(tenant), nil
+}
+
+// apply 应用租户设置
+func (t *Tenant) apply(settings TenantSettings, now time.Time) {
+	t.Name = settings.Name
+	t.Description = settings.Description
+	t.IsActive = settings.IsActive
+	t.MonthlyRequestLimit = settings.MonthlyRequestLimit
+	t.MonthlyTokenLimit = settings.MonthlyTokenLimit
+	t.UpdatedAt = now
+}
+
+// persistTenantLocked 将租户保存到数据库，调用方需持有写锁
+func (m *Manager) persistTenantLocked(tenant *Tenant, create bool) error {
+	if m.requestLogger == nil {
+		return nil
+	}
+	dbTenant := &logger.Tenant{
+		ID:                  tenant.ID,
+		Name:                tenant.Name,
+		Description:         tenant.Description,
+		IsActive:            tenant.IsActive,
+		MonthlyRequestLimit: tenant.MonthlyRequestLimit,
+		MonthlyTokenLimit:   tenant.MonthlyTokenLimit,
+		CreatedAt:           tenant.CreatedAt,
+		UpdatedAt:           tenant.UpdatedAt,
+	}
+	if create {
+		return m.requestLogger.InsertTenant(dbTenant)
+	}
+	return m.requestLogger.UpdateTenant(dbTenant)
+}
+
+// DeleteTenant 删除租户，属于该租户的代理密钥保留并不再属于任何租户
+func (m *Manager) DeleteTenant(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.tenants[id]; !exists {
+		return fmt.Errorf("tenant not found")
+	}
+	if m.requestLogger != nil {
+		if err := m.requestLogger.DeleteTenant(id); err != nil {
+			return err
+		}
+	}
+	delete(m.tenants, id)
+	for _, key := range m.keys {
+		if key.TenantID == id {
+			key.TenantID = ""
+		}
+	}
+	return nil
+}
+
+// GetTenant 获取租户及其本月用量
+func (m *Manager) GetTenant(id string) (*Tenant, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tenant, exists := m.tenants[id]
+	if !exists {
+		return nil, false
+	}
+	return m.tenantViewLocked(tenant), true
+}
+
+// GetAllTenants 获取所有租户及其本月用量，按名称排序
+func (m *Manager) GetAllTenants() []*Tenant {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tenants := make([]*Tenant, 0, len(m.tenants))
+	for _, tenant := range m.tenants {
+		tenants = append(tenants, m.tenantViewLocked(tenant))
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Name < tenants[j].Name })
+	return tenants
+}
+
+// tenantViewLocked 返回租户的副本并统计密钥数量，调用方需持有锁
+func (m *Manager) tenantViewLocked(tenant *Tenant) *Tenant {
+	view := *tenant
+	view.KeyCount = 0
+	for _, key := range m.keys {
+		if key.TenantID == tenant.ID {
+			view.KeyCount++
+		}
+	}
+	return &view
+}
+
+// SetKeyTenant 设置代理密钥所属的租户，空字符串表示不属于任何租户
+func (m *Manager) SetKeyTenant(keyID, tenantID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, exists := m.keys[keyID]
+	if !exists {
+		return fmt.Errorf("key not found")
+	}
+	tenantID = strings.TrimSpace(tenantID)
+	if tenantID != "" {
+		if _, exists := m.tenants[tenantID]; !exists {
+			return fmt.Errorf("tenant %s not found", tenantID)
+		}
+	}
+	if key.TenantID == tenantID {
+		return nil
+	}
+
+	previous := key.TenantID
+	key.TenantID = tenantID
+	if err := m.persistKeyLocked(key); err != nil {
+		key.TenantID = previous
+		return err
+	}
+	return nil
+}
+
+// TenantExists 租户是否存在
+func (m *Manager) TenantExists(id string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, exists := m.tenants[id]
+	return exists
+}
+
+// TenantKeyIDs 返回属于租户的代理密钥ID，用于按租户筛选日志和统计；租户没有密钥时返回空列表
+func (m *Manager) TenantKeyIDs(tenantID string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := []string{}
+	for _, key := range m.keys {
+		if key.TenantID == tenantID {
+			ids = append(ids, key.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// countTenantRequestLocked 密钥被使用时增加所属租户的本月请求数，调用方需持有写锁
+func (m *Manager) countTenantRequestLocked(key *ProxyKey, now time.Time) {
+	tenant, exists := m.tenants[key.TenantID]
+	if !exists {
+		return
+	}
+	m.resetTenantUsageIfNewMonthLocked(now)
+	tenant.MonthRequests++
+}
+
+// resetTenantUsageIfNewMonthLocked 进入新的月份时清零所有租户的用量，调用方需持有写锁
+func (m *Manager) resetTenantUsageIfNewMonthLocked(now time.Time) {
+	month := now.Format("2006-01")
+	if m.usageMonth == month {
+		return
+	}
+	m.usageMonth = month
+	for _, tenant := range m.tenants {
+		tenant.MonthRequests = 0
+		tenant.MonthTokens = 0
+	}
+}
+
+// RefreshTenantUsage 从请求日志重新计算所有租户的本月请求数和token数
+func (m *Manager) RefreshTenantUsage() {
+	now := time.Now()
+	if m.requestLogger == nil {
+		m.mu.Lock()
+		m.resetTenantUsageIfNewMonthLocked(now)
+		m.mu.Unlock()
+		return
+	}
+
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	usage, err := m.requestLogger.GetTenantUsageSince(monthStart)
+	if err != nil {
+		log.Printf("Failed to refresh tenant usage: %v", err)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usageMonth = now.Format("2006-01")
+	for id, tenant := range m.tenants {
+		tenant.MonthRequests, tenant.MonthTokens = 0, 0
+		if u, exists := usage[id]; exists {
+			tenant.MonthRequests, tenant.MonthTokens = u.Requests, u.Tokens
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package proxykey
+
+import "testing"
+
+func TestManager_Tenants(t *testing.T) {
+	manager := NewManager()
+	defer manager.Close()
+
+	tenant, err := manager.CreateTenant(TenantSettings{Name: "team-a", IsActive: true, MonthlyRequestLimit: 2})
+	if err != nil {
+		t.Fatalf("CreateTenant() error = %v", err)
+	}
+	if _, err := manager.CreateTenant(TenantSettings{Name: " team-a "}); err == nil {
+		t.Error("CreateTenant() accepted a duplicate name")
+	}
+
+	first, _ := manager.GenerateKey("first", "", nil)
+	second, _ := manager.GenerateKey("second", "", nil)
+	other, _ := manager.GenerateKey("other", "", nil)
+	for _, key := range []*ProxyKey{first, second} {
+		if err := manager.SetKeyTenant(key.ID, tenant.ID); err != nil {
+			t.Fatalf("SetKeyTenant() error = %v", err)
+		}
+	}
+	if err := manager.SetKeyTenant(other.ID, "missing"); err == nil {
+		t.Error("SetKeyTenant() accepted an unknown tenant")
+	}
+	if ids := manager.TenantKeyIDs(tenant.ID); len(ids) != 2 {
+		t.Errorf("TenantKeyIDs() = %v, want 2 keys", ids)
+	}
+
+	// 额度按租户内所有密钥累计
+	manager.UpdateUsage(first.Key)
+	manager.UpdateUsage(second.Key)
+	if _, ok := manager.ValidateKey(first.Key); ok {
+		t.Error("ValidateKey() accepted a key whose tenant quota is used up")
+	}
+	if reason := manager.KeyInactiveReason(second.Key); reason != KeyInactiveTenantQuota {
+		t.Errorf("KeyInactiveReason() = %q, want %q", reason, KeyInactiveTenantQuota)
+	}
+	if _, ok := manager.ValidateKey(other.Key); !ok {
+		t.Error("ValidateKey() rejected a key outside the tenant")
+	}
+	// 租户额度用完不会禁用密钥本身
+	if n := manager.DeactivateExpiredKeys(); n != 0 {
+		t.Errorf("DeactivateExpiredKeys() = %d, want 0", n)
+	}
+
+	if _, err := manager.UpdateTenant(tenant.ID, TenantSettings{Name: "team-a", IsActive: false}); err != nil {
+		t.Fatalf("UpdateTenant() error = %v", err)
+	}
+	if reason := manager.KeyInactiveReason(first.Key); reason != KeyInactiveTenantDisabled {
+		t.Errorf("KeyInactiveReason() = %q, want %q", reason, KeyInactiveTenantDisabled)
+	}
+
+	if err := manager.DeleteTenant(tenant.ID); err != nil {
+		t.Fatalf("DeleteTenant() error = %v", err)
+	}
+	if _, ok := manager.ValidateKey(first.Key); !ok {
+		t.Error("ValidateKey() rejected a key after its tenant was deleted")
+	}
+	if ids := manager.TenantKeyIDs(tenant.ID); len(ids) != 0 {
+		t.Errorf("TenantKeyIDs() after delete = %v, want none", ids)
+	}
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"sync"
+)
+
+// ConcurrencyLimiter 并发请求数限制器
+// 限制值在获取时传入，配置变更无需同步即可生效；未设置限制时仍统计当前并发数
+type ConcurrencyLimiter struct {
+	mu      sync.Mutex
+	current map[string]int
+}
+
+// NewConcurrencyLimiter 创建新的并发限制器
+func NewConcurrencyLimiter() *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		current: make(map[string]int),
+	}
+}
+
+// TryAcquire 尝试占用一个并发槽位，limit<=0表示不限制
+func (l *ConcurrencyLimiter) TryAcquire(id string, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limit > 0 && l.current[id] >= limit {
+		return false
+	}
+	l.current[id]++
+	return true
+}
+
+// Release 释放一个并发槽位
+func (l *ConcurrencyLimiter) Release(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.current[id] <= 1 {
+		delete(l.current, id)
+		return
+	}
+	l.current[id]--
+}
+
+// Current 获取当前并发数
+func (l *ConcurrencyLimiter) Current(id string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.current[id]
+}
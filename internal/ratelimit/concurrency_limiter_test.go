@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestConcurrencyLimiterLimit 测试达到上限后拒绝占用，释放后可以再次占用
+func TestConcurrencyLimiterLimit(t *testing.T) {
+	l := NewConcurrencyLimiter()
+
+	if !l.TryAcquire("group1", 2) || !l.TryAcquire("group1", 2) {
+		t.Fatal("未达到上限时应允许占用")
+	}
+	if l.TryAcquire("group1", 2) {
+		t.Fatal("达到上限时应拒绝占用")
+	}
+	if current := l.Current("group1"); current != 2 {
+		t.Errorf("当前并发数应为2，实际为 %d", current)
+	}
+
+	l.Release("group1")
+	if !l.TryAcquire("group1", 2) {
+		t.Fatal("释放后应允许再次占用")
+	}
+
+	l.Release("group1")
+	l.Release("group1")
+	l.Release("group1")
+	if current := l.Current("group1"); current != 0 {
+		t.Errorf("多余的释放不应使并发数小于0，实际为 %d", current)
+	}
+}
+
+// TestConcurrencyLimiterUnlimited 测试未设置限制时不拒绝，但仍统计当前并发数
+func TestConcurrencyLimiterUnlimited(t *testing.T) {
+	l := NewConcurrencyLimiter()
+
+	for i := 0; i < 10; i++ {
+		if !l.TryAcquire("group1", 0) {
+			t.Fatal("未设置限制时不应拒绝占用")
+		}
+	}
+	if current := l.Current("group1"); current != 10 {
+		t.Errorf("当前并发数应为10，实际为 %d", current)
+	}
+}
+
+// TestConcurrencyLimiterPerKey 测试不同ID的槽位互相独立，单个密钥达到上限不影响其他密钥
+func TestConcurrencyLimiterPerKey(t *testing.T) {
+	l := NewConcurrencyLimiter()
+
+	if !l.TryAcquire("group1:key1", 1) {
+		t.Fatal("key1 第一次占用应成功")
+	}
+	if l.TryAcquire("group1:key1", 1) {
+		t.Fatal("key1 达到上限时应拒绝占用")
+	}
+	if !l.TryAcquire("group1:key2", 1) {
+		t.Fatal("key1 达到上限不应影响 key2")
+	}
+
+	l.Release("group1:key1")
+	if !l.TryAcquire("group1:key1", 1) {
+		t.Fatal("key1 释放后应允许再次占用")
+	}
+}
+
+// TestConcurrencyLimiterConcurrentAcquire 测试并发占用时成功的次数不超过上限
+func TestConcurrencyLimiterConcurrentAcquire(t *testing.T) {
+	l := NewConcurrencyLimiter()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	acquired := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.TryAcquire("group1", 5) {
+				mu.Lock()
+				acquired++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if acquired != 5 {
+		t.Errorf("并发占用成功的次数应为5，实际为 %d", acquired)
+	}
+}
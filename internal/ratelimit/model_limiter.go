@@ -0,0 +1,181 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// modelWindowDuration 按模型限流的统计窗口
+const modelWindowDuration = time.Minute
+
+// ModelLimiter 分组内按模型的RPM/TPM限制器
+// 按（分组, 模型）分别统计一分钟滑动窗口内的请求数和token数，限制值在检查时传入，配置变更无需同步即可生效
+type ModelLimiter struct {
+	mu      sync.Mutex
+	windows map[modelKey]*modelWindow
+}
+
+// modelKey 模型窗口的标识
+type modelKey struct {
+	groupID string
+	model   string
+}
+
+// modelWindow 单个模型的请求和token记录
+type modelWindow struct {
+	requests []time.Time
+	tokens   []*tokenEntry
+}
+
+// tokenEntry 一次请求占用的token数，请求结束后按实际用量更新
+type tokenEntry struct {
+	at     time.Time
+	tokens int
+}
+
+// ModelReservation 一次请求在模型窗口中预占的token
+type ModelReservation struct {
+	limiter *ModelLimiter
+	entry   *tokenEntry
+}
+
+// ModelUsage 模型当前窗口内的用量
+type ModelUsage struct {
+	Requests int `json:"requests"`
+	Tokens   int `json:"tokens"`
+}
+
+// NewModelLimiter 创建按模型的限制器
+func NewModelLimiter() *ModelLimiter {
+	return &ModelLimiter{
+		windows: make(map[modelKey]*modelWindow),
+	}
+}
+
+// Available 检查模型窗口是否还能容纳一个预计使用 tokens 个token的请求，不占用额度
+func (l *ModelLimiter) Available(groupID, model string, rpm, tpm, tokens int) bool {
+	if rpm <= 0 && tpm <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	window := l.windows[modelKey{groupID: groupID, model: model}]
+	if window == nil {
+		return true
+	}
+	window.pruneLocked(time.Now())
+	return window.allowsLocked(rpm, tpm, tokens)
+}
+
+// Reserve 检查并占用一次请求和预计的token数，超出限制时返回false
+// 窗口内没有token记录时总是允许，避免单个请求的预计用量超过TPM限制后永远无法发出
+func (l *ModelLimiter) Reserve(groupID, model string, rpm, tpm, tokens int) (*ModelReservation, bool) {
+	if rpm <= 0 && tpm <= 0 {
+		return nil, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := modelKey{groupID: groupID, model: model}
+	window := l.windows[key]
+	if window == nil {
+		window = &modelWindow{}
+		l.windows[key] = window
+	}
+	now := time.Now()
+	window.pruneLocked(now)
+	if !window.allowsLocked(rpm, tpm, tokens) {
+		return nil, false
+	}
+
+	window.requests = append(window.requests, now)
+	entry := &tokenEntry{at: now, tokens: tokens}
+	window.tokens = append(window.tokens, entry)
+	return &ModelReservation{limiter: l, entry: entry}, true
+}
+
+// Commit 按实际用量更新预占的token数，请求失败时传入0释放；nil表示未设置限制
+func (r *ModelReservation) Commit(tokens int) {
+	if r == nil {
+		return
+	}
+	r.limiter.mu.Lock()
+	defer r.limiter.mu.Unlock()
+	r.entry.tokens = max(tokens, 0)
+}
+
+// Usage 获取分组内各模型当前窗口的用量，没有记录的模型不返回
+func (l *ModelLimiter) Usage(groupID string) map[string]ModelUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	usage := make(map[string]ModelUsage)
+	for key, window := range l.windows {
+		if key.groupID != groupID {
+			continue
+		}
+		window.pruneLocked(now)
+		if len(window.requests) == 0 && len(window.tokens) == 0 {
+			delete(l.windows, key)
+			continue
+		}
+		usage[key.model] = ModelUsage{Requests: len(window.requests), Tokens: window.tokenSumLocked()}
+	}
+	return usage
+}
+
+// Forget 删除分组的所有模型窗口
+func (l *ModelLimiter) Forget(groupID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range l.windows {
+		if key.groupID == groupID {
+			delete(l.windows, key)
+		}
+	}
+}
+
+// pruneLocked 清理一分钟之前的记录
+func (w *modelWindow) pruneLocked(now time.Time) {
+	cutoff := now.Add(-modelWindowDuration)
+	requests := w.requests[:0]
+	for _, at := range w.requests {
+		if at.After(cutoff) {
+			requests = append(requests, at)
+		}
+	}
+	w.requests = requests
+
+	tokens := w.tokens[:0]
+	for _, entry := range w.tokens {
+		if entry.at.After(cutoff) {
+			tokens = append(tokens, entry)
+		}
+	}
+	w.tokens = tokens
+}
+
+// tokenSumLocked 窗口内的token总数
+func (w *modelWindow) tokenSumLocked() int {
+	total := 0
+	for _, entry := range w.tokens {
+		total += entry.tokens
+	}
+	return total
+}
+
+// allowsLocked 窗口是否还能容纳一个请求
+func (w *modelWindow) allowsLocked(rpm, tpm, tokens int) bool {
+	if rpm > 0 && len(w.requests) >= rpm {
+		return false
+	}
+	if tpm > 0 {
+		used := w.tokenSumLocked()
+		if used > 0 && used+tokens > tpm {
+			return false
+		}
+	}
+	return true
+}
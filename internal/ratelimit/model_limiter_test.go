@@ -0,0 +1,74 @@
+package ratelimit
+
+import "testing"
+
+// TestModelLimiterRPM 测试按（分组, 模型）分别限制请求数，窗口过期后恢复额度
+func TestModelLimiterRPM(t *testing.T) {
+	l := NewModelLimiter()
+	for i := 0; i < 2; i++ {
+		if _, ok := l.Reserve("group1", "gpt-4o", 2, 0, 100); !ok {
+			t.Fatalf("第 %d 个请求应被允许", i+1)
+		}
+	}
+	if l.Available("group1", "gpt-4o", 2, 0, 100) {
+		t.Error("达到RPM限制后 Available 应返回false")
+	}
+	if _, ok := l.Reserve("group1", "gpt-4o", 2, 0, 100); ok {
+		t.Fatal("超过RPM限制的请求应被拒绝")
+	}
+	if _, ok := l.Reserve("group1", "gpt-4o-mini", 2, 0, 100); !ok {
+		t.Error("同一分组的其他模型应单独计数")
+	}
+	if _, ok := l.Reserve("group2", "gpt-4o", 2, 0, 100); !ok {
+		t.Error("其他分组的相同模型应单独计数")
+	}
+	if reservation, ok := l.Reserve("group1", "gpt-4o", 0, 0, 100); !ok || reservation != nil {
+		t.Error("未设置限制时应总是允许且不记录")
+	}
+
+	// 一分钟前的记录不再计入
+	window := l.windows[modelKey{groupID: "group1", model: "gpt-4o"}]
+	for i := range window.requests {
+		window.requests[i] = window.requests[i].Add(-modelWindowDuration)
+		window.tokens[i].at = window.tokens[i].at.Add(-modelWindowDuration)
+	}
+	if _, ok := l.Reserve("group1", "gpt-4o", 2, 0, 100); !ok {
+		t.Error("窗口过期后应恢复额度")
+	}
+}
+
+// TestModelLimiterTPM 测试按预计token数预占额度，提交实际用量后释放多占的额度
+func TestModelLimiterTPM(t *testing.T) {
+	l := NewModelLimiter()
+
+	// 窗口为空时即使预计用量超过TPM限制也允许
+	first, ok := l.Reserve("group1", "gpt-4o", 0, 1000, 1500)
+	if !ok {
+		t.Fatal("窗口为空时应允许请求")
+	}
+	if _, ok := l.Reserve("group1", "gpt-4o", 0, 1000, 10); ok {
+		t.Fatal("超过TPM限制的请求应被拒绝")
+	}
+
+	first.Commit(600)
+	if !l.Available("group1", "gpt-4o", 0, 1000, 400) || l.Available("group1", "gpt-4o", 0, 1000, 401) {
+		t.Error("提交实际用量后应按600个token计算剩余额度")
+	}
+	second, ok := l.Reserve("group1", "gpt-4o", 0, 1000, 400)
+	if !ok {
+		t.Fatal("剩余额度足够时应允许请求")
+	}
+	second.Commit(0) // 请求失败时释放预占的token
+
+	usage := l.Usage("group1")
+	if got := usage["gpt-4o"]; got.Requests != 2 || got.Tokens != 600 {
+		t.Errorf("用量应为2个请求、600个token，得到 %+v", got)
+	}
+
+	l.Forget("group1")
+	if usage := l.Usage("group1"); len(usage) != 0 {
+		t.Errorf("删除分组后不应有用量记录，得到 %v", usage)
+	}
+	var none *ModelReservation
+	none.Commit(100) // 未设置限制时预占为nil，提交不应出错
+}
@@ -0,0 +1,152 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// PriorityQueue 分组并发数或RPM已满时等待容量的请求队列
+// 请求按优先级从高到低、同一优先级按到达顺序排列；分组释放并发槽位时唤醒等待该分组且排在最前的请求，
+// RPM额度随时间恢复，因此每个分组排在最前的请求还会定期自行检查
+type PriorityQueue struct {
+	mu      sync.Mutex
+	waiters []*QueueTicket
+	seq     uint64
+}
+
+// QueueTicket 请求在队列中的位置，离开队列前一直保留，被唤醒后仍无法获得容量时不会失去原来的位置
+type QueueTicket struct {
+	priority int
+	seq      uint64
+	groups   map[string]bool // 请求可以使用的候选分组
+	ready    chan struct{}
+}
+
+// NewPriorityQueue 创建请求队列
+func NewPriorityQueue() *PriorityQueue {
+	return &PriorityQueue{}
+}
+
+// Join 以指定优先级加入队列，队列中已有 maxSize 个请求时返回false，maxSize<=0表示不限制
+func (q *PriorityQueue) Join(priority int, groups []string, maxSize int) (*QueueTicket, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if maxSize > 0 && len(q.waiters) >= maxSize {
+		return nil, false
+	}
+	q.seq++
+	ticket := &QueueTicket{
+		priority: priority,
+		seq:      q.seq,
+		groups:   make(map[string]bool, len(groups)),
+		ready:    make(chan struct{}, 1),
+	}
+	for _, group := range groups {
+		ticket.groups[group] = true
+	}
+
+	// 插入到所有优先级不低于该请求的等待者之后
+	i := len(q.waiters)
+	for i > 0 && q.waiters[i-1].priority < priority {
+		i--
+	}
+	q.waiters = append(q.waiters, nil)
+	copy(q.waiters[i+1:], q.waiters[i:])
+	q.waiters[i] = ticket
+	return ticket, true
+}
+
+// Leave 离开队列
+func (q *PriorityQueue) Leave(ticket *QueueTicket) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, waiter := range q.waiters {
+		if waiter == ticket {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Wait 等待被唤醒后返回true，由调用方重新检查容量；ctx结束时返回false
+// 请求在任一候选分组排在最前时每隔 poll 自行返回一次
+func (q *PriorityQueue) Wait(ctx context.Context, ticket *QueueTicket, poll time.Duration) bool {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticket.ready:
+			return true
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			if q.leads(ticket) {
+				return true
+			}
+		}
+	}
+}
+
+// leads 请求是否在任一候选分组的等待者中排在最前
+func (q *PriorityQueue) leads(ticket *QueueTicket) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for group := range ticket.groups {
+		if q.firstLocked(group) == ticket {
+			return true
+		}
+	}
+	return false
+}
+
+// firstLocked 返回等待分组的第一个请求，调用方需持有锁
+func (q *PriorityQueue) firstLocked(group string) *QueueTicket {
+	for _, waiter := range q.waiters {
+		if waiter.groups[group] {
+			return waiter
+		}
+	}
+	return nil
+}
+
+// Signal 分组释放容量时唤醒等待该分组且排在最前的请求
+func (q *PriorityQueue) Signal(group string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if first := q.firstLocked(group); first != nil {
+		select {
+		case first.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Ahead 是否有排在请求前面的其他请求在等待该分组
+// ticket 为空表示尚未排队的新请求，它排在所有优先级不低于 priority 的等待者之后
+func (q *PriorityQueue) Ahead(group string, ticket *QueueTicket, priority int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, waiter := range q.waiters {
+		if waiter == ticket || (ticket == nil && waiter.priority < priority) {
+			return false
+		}
+		if waiter.groups[group] {
+			return true
+		}
+	}
+	return false
+}
+
+// Len 当前排队的请求数
+func (q *PriorityQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiters)
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestPriorityQueueOrder 测试高优先级请求排在低优先级之前，同一优先级按到达顺序，队列满时拒绝加入
+func TestPriorityQueueOrder(t *testing.T) {
+	q := NewPriorityQueue()
+	batch, _ := q.Join(0, []string{"group1"}, 3)
+	interactive, _ := q.Join(10, []string{"group1"}, 3)
+	later, _ := q.Join(10, []string{"group1"}, 3)
+	if _, ok := q.Join(20, []string{"group1"}, 3); ok {
+		t.Fatal("队列已满时应拒绝加入")
+	}
+
+	if !q.leads(interactive) || q.leads(later) || q.leads(batch) {
+		t.Error("高优先级且先到达的请求应排在最前")
+	}
+	if !q.Ahead("group1", batch, 0) || q.Ahead("group1", interactive, 10) {
+		t.Error("batch 前面有等待 group1 的请求，interactive 前面没有")
+	}
+	if !q.Ahead("group1", nil, 10) || q.Ahead("group1", nil, 20) {
+		t.Error("新请求只排在优先级不低于它的等待者之后")
+	}
+	if q.Ahead("group2", nil, 0) {
+		t.Error("没有请求等待 group2 时新请求前面不应有等待者")
+	}
+
+	q.Leave(interactive)
+	if !q.leads(later) || q.Len() != 2 {
+		t.Errorf("离开队列后下一个请求应排在最前，队列长度 %d", q.Len())
+	}
+}
+
+// TestPriorityQueueWait 测试释放容量时只唤醒排在最前的请求，排在最前的请求定期自行返回，ctx结束时停止等待
+func TestPriorityQueueWait(t *testing.T) {
+	q := NewPriorityQueue()
+	first, _ := q.Join(5, []string{"group1"}, 0)
+	second, _ := q.Join(1, []string{"group1"}, 0)
+
+	q.Signal("group1")
+	if !q.Wait(context.Background(), first, time.Hour) {
+		t.Fatal("释放容量后排在最前的请求应被唤醒")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if q.Wait(ctx, second, 10*time.Millisecond) {
+		t.Fatal("未排在最前的请求不应被唤醒")
+	}
+
+	q.Leave(first)
+	start := time.Now()
+	if !q.Wait(context.Background(), second, 10*time.Millisecond) {
+		t.Fatal("排在最前的请求应定期自行返回")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("应在轮询间隔后返回，耗时 %v", elapsed)
+	}
+}
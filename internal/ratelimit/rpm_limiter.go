@@ -1,21 +1,36 @@
 package ratelimit
 
 import (
+	"log"
+	"math"
 	"sync"
 	"time"
 )
 
+// SharedWindowStore 多实例共享的请求窗口存储，用于多个实例共同遵守同一RPM限制
+type SharedWindowStore interface {
+	AllowRequest(groupID string, limit int, window time.Duration) (bool, error)
+	CountRequests(groupID string, window time.Duration) (int, error)
+	ResetRequests(groupID string) error
+}
+
 // RPMLimiter RPM（每分钟请求数）限制器
+// 按滑动窗口保证任意一分钟内的请求数不超过限制；设置突发容量时再叠加令牌桶，
+// 令牌按 limit/60 每秒匀速补充，避免一分钟的额度在瞬间被用完
 type RPMLimiter struct {
 	mu       sync.RWMutex
 	limiters map[string]*groupLimiter
+	shared   SharedWindowStore // 共享窗口存储，为空时只统计本实例的请求
 }
 
 // groupLimiter 分组限制器
 type groupLimiter struct {
-	limit     int           // 每分钟请求数限制
-	requests  []time.Time   // 请求时间戳列表
-	mu        sync.Mutex    // 保护requests切片
+	limit      int         // 每分钟请求数限制
+	burst      int         // 令牌桶容量（突发请求数），0表示不平滑
+	tokens     float64     // 当前令牌数
+	lastRefill time.Time   // 上次补充令牌的时间
+	requests   []time.Time // 请求时间戳列表
+	mu         sync.Mutex  // 保护以上字段
 }
 
 // NewRPMLimiter 创建新的RPM限制器
@@ -25,17 +40,31 @@ func NewRPMLimiter() *RPMLimiter {
 	}
 }
 
+// SetSharedStore 设置共享窗口存储，之后按所有实例的请求总数限流，存储不可用时退回本地统计
+func (r *RPMLimiter) SetSharedStore(store SharedWindowStore) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shared = store
+}
+
+// sharedStore 获取共享窗口存储
+func (r *RPMLimiter) sharedStore() SharedWindowStore {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.shared
+}
+
 // SetLimit 设置分组的RPM限制
 func (r *RPMLimiter) SetLimit(groupID string, limit int) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	
+
 	if limit <= 0 {
 		// 如果限制为0或负数，删除限制器
 		delete(r.limiters, groupID)
 		return
 	}
-	
+
 	r.limiters[groupID] = &groupLimiter{
 		limit:    limit,
 		requests: make([]time.Time, 0),
@@ -47,23 +76,156 @@ func (r *RPMLimiter) Allow(groupID string) bool {
 	r.mu.RLock()
 	limiter, exists := r.limiters[groupID]
 	r.mu.RUnlock()
-	
+
 	if !exists {
 		// 没有设置限制，允许请求
 		return true
 	}
-	
+
+	return r.allow(groupID, limiter)
+}
+
+// AllowWithLimit 按给定的限制和突发容量检查是否允许请求，与已记录的不同时更新设置并保留请求记录
+// 用于按当前配置快照中的RPM限制进行检查，配置变更后无需重新初始化限制器
+func (r *RPMLimiter) AllowWithLimit(groupID string, limit, burst int) bool {
+	r.mu.Lock()
+	limiter, exists := r.limiters[groupID]
+	if limit <= 0 {
+		if exists {
+			delete(r.limiters, groupID)
+		}
+		r.mu.Unlock()
+		return true
+	}
+	if !exists {
+		limiter = &groupLimiter{
+			limit:    limit,
+			requests: make([]time.Time, 0),
+		}
+		r.limiters[groupID] = limiter
+	}
+	r.mu.Unlock()
+
+	limiter.mu.Lock()
+	limiter.limit = limit
+	limiter.setBurstLocked(burst)
+	limiter.mu.Unlock()
+
+	return r.allow(groupID, limiter)
+}
+
+// allow 优先按共享窗口检查，共享存储出错时使用本地窗口
+// 令牌桶只在本实例内平滑，共享窗口拒绝时归还已取出的令牌
+func (r *RPMLimiter) allow(groupID string, limiter *groupLimiter) bool {
+	if shared := r.sharedStore(); shared != nil {
+		limiter.mu.Lock()
+		limit := limiter.limit
+		if !limiter.takeTokenLocked(time.Now()) {
+			limiter.mu.Unlock()
+			return false
+		}
+		limiter.mu.Unlock()
+
+		allowed, err := shared.AllowRequest(groupID, limit, time.Minute)
+		if err == nil && allowed {
+			return true
+		}
+		limiter.mu.Lock()
+		limiter.returnTokenLocked()
+		limiter.mu.Unlock()
+		if err == nil {
+			return false
+		}
+		log.Printf("共享RPM窗口不可用，分组 %s 使用本地限流: %v", groupID, err)
+	}
 	return limiter.allow()
 }
 
+// currentCount 获取分组当前窗口内的请求数，设置了共享存储时返回所有实例的请求总数
+func (r *RPMLimiter) currentCount(groupID string, limiter *groupLimiter) int {
+	if shared := r.sharedStore(); shared != nil {
+		if count, err := shared.CountRequests(groupID, time.Minute); err == nil {
+			return count
+		}
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	oneMinuteAgo := time.Now().Add(-time.Minute)
+	current := 0
+	for _, reqTime := range limiter.requests {
+		if reqTime.After(oneMinuteAgo) {
+			current++
+		}
+	}
+	return current
+}
+
+// remaining 获取分组还可以立即发出的请求数，取窗口剩余额度和可用令牌数中较小的值
+func (r *RPMLimiter) remaining(groupID string, limiter *groupLimiter) int {
+	current := r.currentCount(groupID, limiter)
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	remaining := limiter.limit - current
+	if limiter.burst > 0 {
+		limiter.refillLocked(time.Now())
+		remaining = min(remaining, int(limiter.tokens))
+	}
+	return max(remaining, 0)
+}
+
+// setBurstLocked 更新令牌桶容量，容量变化时令牌补满；容量不小于限制时令牌桶不起作用，不启用
+func (g *groupLimiter) setBurstLocked(burst int) {
+	if burst < 0 || burst >= g.limit {
+		burst = 0
+	}
+	if burst == g.burst {
+		return
+	}
+	g.burst = burst
+	g.tokens = float64(burst)
+	g.lastRefill = time.Now()
+}
+
+// refillLocked 按经过的时间补充令牌，每秒补充 limit/60 个，不超过容量
+func (g *groupLimiter) refillLocked(now time.Time) {
+	if elapsed := now.Sub(g.lastRefill).Seconds(); elapsed > 0 {
+		g.tokens = math.Min(float64(g.burst), g.tokens+elapsed*float64(g.limit)/60)
+	}
+	g.lastRefill = now
+}
+
+// takeTokenLocked 取出一个令牌，未启用令牌桶时总是成功
+func (g *groupLimiter) takeTokenLocked(now time.Time) bool {
+	if g.burst <= 0 {
+		return true
+	}
+	g.refillLocked(now)
+	if g.tokens < 1 {
+		return false
+	}
+	g.tokens--
+	return true
+}
+
+// returnTokenLocked 归还取出的令牌
+func (g *groupLimiter) returnTokenLocked() {
+	if g.burst > 0 {
+		g.tokens = math.Min(float64(g.burst), g.tokens+1)
+	}
+}
+
 // allow 检查分组限制器是否允许请求
 func (g *groupLimiter) allow() bool {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	
+
 	now := time.Now()
 	oneMinuteAgo := now.Add(-time.Minute)
-	
+
 	// 清理一分钟前的请求记录
 	validRequests := make([]time.Time, 0, len(g.requests))
 	for _, reqTime := range g.requests {
@@ -72,42 +234,78 @@ func (g *groupLimiter) allow() bool {
 		}
 	}
 	g.requests = validRequests
-	
+
 	// 检查是否超过限制
 	if len(g.requests) >= g.limit {
 		return false
 	}
-	
+
+	// 检查突发容量
+	if !g.takeTokenLocked(now) {
+		return false
+	}
+
 	// 记录当前请求
 	g.requests = append(g.requests, now)
 	return true
 }
 
+// GetRemaining 获取分组还可以立即发出的请求数和RPM限制，分组未设置限制时exists为false
+func (r *RPMLimiter) GetRemaining(groupID string) (remaining int, limit int, exists bool) {
+	r.mu.RLock()
+	limiter, exists := r.limiters[groupID]
+	r.mu.RUnlock()
+
+	if !exists {
+		return 0, 0, false
+	}
+
+	limiter.mu.Lock()
+	limit = limiter.limit
+	limiter.mu.Unlock()
+
+	return r.remaining(groupID, limiter), limit, true
+}
+
 // GetStats 获取分组的统计信息
 func (r *RPMLimiter) GetStats(groupID string) (current int, limit int, exists bool) {
 	r.mu.RLock()
 	limiter, exists := r.limiters[groupID]
 	r.mu.RUnlock()
-	
+
 	if !exists {
 		return 0, 0, false
 	}
-	
+
 	limiter.mu.Lock()
-	defer limiter.mu.Unlock()
-	
-	now := time.Now()
-	oneMinuteAgo := now.Add(-time.Minute)
-	
-	// 计算当前一分钟内的请求数
-	current = 0
-	for _, reqTime := range limiter.requests {
-		if reqTime.After(oneMinuteAgo) {
-			current++
+	limit = limiter.limit
+	limiter.mu.Unlock()
+
+	return r.currentCount(groupID, limiter), limit, true
+}
+
+// ResetWindow 清空分组当前窗口内的请求记录并保留限制设置，分组未设置限制时返回false
+func (r *RPMLimiter) ResetWindow(groupID string) bool {
+	r.mu.RLock()
+	limiter, exists := r.limiters[groupID]
+	r.mu.RUnlock()
+
+	if !exists {
+		return false
+	}
+
+	limiter.mu.Lock()
+	limiter.requests = make([]time.Time, 0)
+	limiter.tokens = float64(limiter.burst)
+	limiter.lastRefill = time.Now()
+	limiter.mu.Unlock()
+
+	if shared := r.sharedStore(); shared != nil {
+		if err := shared.ResetRequests(groupID); err != nil {
+			log.Printf("清空分组 %s 的共享RPM窗口失败: %v", groupID, err)
 		}
 	}
-	
-	return current, limiter.limit, true
+	return true
 }
 
 // RemoveLimit 移除分组的RPM限制
@@ -121,10 +319,10 @@ func (r *RPMLimiter) RemoveLimit(groupID string) {
 func (r *RPMLimiter) UpdateLimits(limits map[string]int) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	
+
 	// 清空现有限制器
 	r.limiters = make(map[string]*groupLimiter)
-	
+
 	// 设置新的限制
 	for groupID, limit := range limits {
 		if limit > 0 {
@@ -139,30 +337,25 @@ func (r *RPMLimiter) UpdateLimits(limits map[string]int) {
 // GetAllStats 获取所有分组的统计信息
 func (r *RPMLimiter) GetAllStats() map[string]map[string]int {
 	r.mu.RLock()
-	defer r.mu.RUnlock()
-	
-	stats := make(map[string]map[string]int)
-	now := time.Now()
-	oneMinuteAgo := now.Add(-time.Minute)
-	
+	limiters := make(map[string]*groupLimiter, len(r.limiters))
 	for groupID, limiter := range r.limiters {
+		limiters[groupID] = limiter
+	}
+	r.mu.RUnlock()
+
+	stats := make(map[string]map[string]int)
+	for groupID, limiter := range limiters {
 		limiter.mu.Lock()
-		
-		// 计算当前一分钟内的请求数
-		current := 0
-		for _, reqTime := range limiter.requests {
-			if reqTime.After(oneMinuteAgo) {
-				current++
-			}
-		}
-		
+		limit, burst := limiter.limit, limiter.burst
+		limiter.mu.Unlock()
+
 		stats[groupID] = map[string]int{
-			"current": current,
-			"limit":   limiter.limit,
+			"current":   r.currentCount(groupID, limiter),
+			"limit":     limit,
+			"burst":     burst,
+			"remaining": r.remaining(groupID, limiter),
 		}
-		
-		limiter.mu.Unlock()
 	}
-	
+
 	return stats
 }
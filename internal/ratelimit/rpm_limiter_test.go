@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeWindowStore 记录清空调用的共享窗口存储
+type fakeWindowStore struct {
+	count int
+	reset []string
+}
+
+func (s *fakeWindowStore) AllowRequest(groupID string, limit int, window time.Duration) (bool, error) {
+	if s.count >= limit {
+		return false, nil
+	}
+	s.count++
+	return true, nil
+}
+
+func (s *fakeWindowStore) CountRequests(groupID string, window time.Duration) (int, error) {
+	return s.count, nil
+}
+
+func (s *fakeWindowStore) ResetRequests(groupID string) error {
+	s.count = 0
+	s.reset = append(s.reset, groupID)
+	return nil
+}
+
+// TestRPMLimiterStatsAndReset 测试统计信息反映当前窗口，清空窗口后保留限制并恢复额度
+func TestRPMLimiterStatsAndReset(t *testing.T) {
+	r := NewRPMLimiter()
+	r.SetLimit("group1", 3)
+
+	for i := 0; i < 3; i++ {
+		if !r.Allow("group1") {
+			t.Fatalf("第 %d 个请求应被允许", i+1)
+		}
+	}
+	if r.Allow("group1") {
+		t.Fatal("超过限制的请求应被拒绝")
+	}
+
+	stats := r.GetAllStats()["group1"]
+	if stats["current"] != 3 || stats["limit"] != 3 || stats["remaining"] != 0 {
+		t.Errorf("统计信息不一致: %v", stats)
+	}
+
+	if !r.ResetWindow("group1") {
+		t.Fatal("清空已设置限制的分组应返回true")
+	}
+	if current, limit, exists := r.GetStats("group1"); !exists || current != 0 || limit != 3 {
+		t.Errorf("清空后应保留限制并清零请求数，current=%d limit=%d exists=%t", current, limit, exists)
+	}
+	if !r.Allow("group1") {
+		t.Error("清空后应允许请求")
+	}
+
+	if r.ResetWindow("unknown") {
+		t.Error("清空未设置限制的分组应返回false")
+	}
+}
+
+// TestRPMLimiterResetSharedWindow 测试设置共享存储时统计所有实例的请求，清空时同时清空共享窗口
+func TestRPMLimiterResetSharedWindow(t *testing.T) {
+	store := &fakeWindowStore{count: 2}
+	r := NewRPMLimiter()
+	r.SetSharedStore(store)
+	r.SetLimit("group1", 3)
+
+	if current, _, _ := r.GetStats("group1"); current != 2 {
+		t.Errorf("应返回共享窗口中的请求数，实际为 %d", current)
+	}
+	if !r.Allow("group1") || r.Allow("group1") {
+		t.Fatal("共享窗口剩余一个额度，应只允许一个请求")
+	}
+
+	r.ResetWindow("group1")
+	if len(store.reset) != 1 || store.reset[0] != "group1" || store.count != 0 {
+		t.Errorf("应清空共享窗口，reset=%v count=%d", store.reset, store.count)
+	}
+}
+
+// TestRPMLimiterBurst 测试突发容量用完后拒绝请求并按 limit/60 每秒补充，共享窗口拒绝时归还令牌
+func TestRPMLimiterBurst(t *testing.T) {
+	r := NewRPMLimiter()
+	for i := 0; i < 3; i++ {
+		if !r.AllowWithLimit("group1", 60, 3) {
+			t.Fatalf("第 %d 个请求应被允许", i+1)
+		}
+	}
+	if r.AllowWithLimit("group1", 60, 3) {
+		t.Fatal("突发容量用完后应拒绝请求")
+	}
+	if remaining, limit, exists := r.GetRemaining("group1"); !exists || remaining != 0 || limit != 60 {
+		t.Errorf("突发容量用完后剩余应为0，remaining=%d limit=%d exists=%t", remaining, limit, exists)
+	}
+
+	// 每秒补充1个令牌，不超过突发容量
+	limiter := r.limiters["group1"]
+	limiter.mu.Lock()
+	limiter.lastRefill = limiter.lastRefill.Add(-2 * time.Second)
+	limiter.mu.Unlock()
+	if remaining, _, _ := r.GetRemaining("group1"); remaining != 2 {
+		t.Errorf("2秒后应补充2个令牌，得到 %d", remaining)
+	}
+	limiter.mu.Lock()
+	limiter.lastRefill = limiter.lastRefill.Add(-time.Minute)
+	limiter.mu.Unlock()
+	if remaining, _, _ := r.GetRemaining("group1"); remaining != 3 {
+		t.Errorf("令牌应补满到突发容量3，得到 %d", remaining)
+	}
+
+	// 突发容量不小于限制时不启用令牌桶，只按窗口限制
+	for i := 0; i < 5; i++ {
+		if !r.AllowWithLimit("group2", 5, 5) {
+			t.Fatalf("未启用令牌桶时第 %d 个请求应被允许", i+1)
+		}
+	}
+	if r.AllowWithLimit("group2", 5, 5) {
+		t.Error("超过窗口限制的请求应被拒绝")
+	}
+
+	shared := &fakeWindowStore{count: 10}
+	r.SetSharedStore(shared)
+	if r.AllowWithLimit("group3", 10, 2) {
+		t.Fatal("共享窗口已满时应拒绝请求")
+	}
+	shared.count = 0
+	if !r.AllowWithLimit("group3", 10, 2) || !r.AllowWithLimit("group3", 10, 2) {
+		t.Error("共享窗口拒绝时应归还令牌，之后可以用满突发容量")
+	}
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// bucketIdleTTL 令牌桶空闲多久后被清理，补满所需时间更长时以补满时间为准，清理后重新创建的结果相同
+const bucketIdleTTL = 10 * time.Minute
+
+// TokenBucketLimiter 按标识（客户端IP、代理密钥等）独立计数的令牌桶限制器
+// 每个标识的桶容量为 burst，按 requestsPerMinute 匀速补充令牌
+type TokenBucketLimiter struct {
+	mu          sync.Mutex
+	rate        float64 // 每秒补充的令牌数
+	burst       int
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+// tokenBucket 单个标识的令牌桶
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// TokenBucketResult 一次检查的结果，用于生成 RateLimit-* 响应头
+type TokenBucketResult struct {
+	Allowed    bool
+	Limit      int           // 桶容量
+	Remaining  int           // 剩余令牌数
+	Reset      time.Duration // 令牌补满所需时间
+	RetryAfter time.Duration // 被拒绝时到下一个令牌可用的时间
+}
+
+// NewTokenBucketLimiter 创建令牌桶限制器，burst<=0时等于 requestsPerMinute
+func NewTokenBucketLimiter(requestsPerMinute, burst int) *TokenBucketLimiter {
+	if burst <= 0 {
+		burst = requestsPerMinute
+	}
+	return &TokenBucketLimiter{
+		rate:    float64(requestsPerMinute) / 60,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Allow 检查标识是否还有可用令牌，允许时消耗一个令牌
+func (l *TokenBucketLimiter) Allow(id string) TokenBucketResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.cleanupLocked(now)
+
+	bucket, exists := l.buckets[id]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(l.burst), lastSeen: now}
+		l.buckets[id] = bucket
+	} else {
+		elapsed := now.Sub(bucket.lastSeen).Seconds()
+		bucket.tokens = math.Min(float64(l.burst), bucket.tokens+elapsed*l.rate)
+		bucket.lastSeen = now
+	}
+
+	result := TokenBucketResult{Limit: l.burst}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = l.durationFor(1 - bucket.tokens)
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = l.durationFor(float64(l.burst) - bucket.tokens)
+	return result
+}
+
+// durationFor 补充指定数量令牌所需的时间
+func (l *TokenBucketLimiter) durationFor(tokens float64) time.Duration {
+	if tokens <= 0 || l.rate <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// cleanupLocked 定期清理长时间未使用的令牌桶，避免大量客户端IP占用内存
+func (l *TokenBucketLimiter) cleanupLocked(now time.Time) {
+	if now.Sub(l.lastCleanup) < bucketIdleTTL {
+		return
+	}
+	l.lastCleanup = now
+	idle := bucketIdleTTL
+	if full := l.durationFor(float64(l.burst)); full > idle {
+		idle = full
+	}
+	for id, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) >= idle {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+// Size 当前跟踪的标识数量
+func (l *TokenBucketLimiter) Size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// Burst 桶容量
+func (l *TokenBucketLimiter) Burst() int {
+	return l.burst
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTokenBucketLimiter 测试突发容量用完后拒绝，按速率补充令牌，不同标识互相独立
+func TestTokenBucketLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := NewTokenBucketLimiter(60, 3) // 每秒补充一个令牌
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		result := l.Allow("1.2.3.4")
+		if !result.Allowed || result.Remaining != 2-i || result.Limit != 3 {
+			t.Fatalf("第 %d 个请求应被允许，得到 %+v", i+1, result)
+		}
+	}
+	result := l.Allow("1.2.3.4")
+	if result.Allowed {
+		t.Fatal("突发容量用完后应拒绝请求")
+	}
+	if result.RetryAfter != time.Second || result.Reset != 3*time.Second {
+		t.Errorf("应在1秒后重试、3秒后补满，得到 %+v", result)
+	}
+
+	if !l.Allow("5.6.7.8").Allowed {
+		t.Error("不同标识的令牌桶应互相独立")
+	}
+
+	now = now.Add(1500 * time.Millisecond)
+	if result := l.Allow("1.2.3.4"); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("1.5秒后应补充一个令牌，得到 %+v", result)
+	}
+
+	// 长时间空闲后令牌不超过容量
+	now = now.Add(time.Hour)
+	if result := l.Allow("1.2.3.4"); !result.Allowed || result.Remaining != 2 {
+		t.Errorf("空闲后令牌应补满到容量，得到 %+v", result)
+	}
+}
+
+// TestTokenBucketLimiterCleanup 测试清理长时间未使用的令牌桶，未指定突发容量时等于每分钟请求数
+func TestTokenBucketLimiterCleanup(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := NewTokenBucketLimiter(120, 0)
+	l.now = func() time.Time { return now }
+	if l.Burst() != 120 {
+		t.Errorf("未指定突发容量时应等于每分钟请求数，得到 %d", l.Burst())
+	}
+
+	l.Allow("1.2.3.4")
+	now = now.Add(bucketIdleTTL - time.Minute)
+	l.Allow("5.6.7.8")
+	if l.Size() != 2 {
+		t.Fatalf("应跟踪2个标识，实际为 %d", l.Size())
+	}
+
+	now = now.Add(2 * time.Minute)
+	l.Allow("5.6.7.8")
+	if l.Size() != 1 {
+		t.Errorf("应清理空闲超过 %s 的令牌桶，剩余 %d 个", bucketIdleTTL, l.Size())
+	}
+}
@@ -0,0 +1,253 @@
+package redisstore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 连接默认参数
+const (
+	defaultDialTimeout = 3 * time.Second
+	defaultIOTimeout   = 3 * time.Second
+	maxIdleConns       = 16
+)
+
+// Error Redis服务端返回的错误
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// Client 最小化的Redis客户端，只实现共享状态需要的RESP2命令调用
+type Client struct {
+	addr        string
+	password    string
+	db          int
+	dialTimeout time.Duration
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// conn 单个Redis连接
+type conn struct {
+	netConn net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+}
+
+// NewClient 创建Redis客户端，连接在首次使用时建立
+func NewClient(addr, password string, db int, dialTimeout time.Duration) *Client {
+	if dialTimeout <= 0 {
+		dialTimeout = defaultDialTimeout
+	}
+	return &Client{
+		addr:        addr,
+		password:    password,
+		db:          db,
+		dialTimeout: dialTimeout,
+	}
+}
+
+// Do 执行Redis命令，返回值为 string、int64、[]interface{}、nil 或 Error
+func (c *Client) Do(args ...interface{}) (interface{}, error) {
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := cn.do(args...)
+	if err != nil {
+		if _, ok := err.(Error); !ok {
+			// 网络或协议错误，连接状态未知，直接丢弃
+			cn.netConn.Close()
+			return nil, err
+		}
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Close 关闭所有空闲连接，之后的命令返回错误
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for _, cn := range c.idle {
+		cn.netConn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// get 获取空闲连接，没有时新建连接
+func (c *Client) get() (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("redis client is closed")
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	return c.dial()
+}
+
+// put 归还连接，空闲连接过多或客户端已关闭时关闭连接
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || len(c.idle) >= maxIdleConns {
+		cn.netConn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+// dial 建立连接并完成认证和选库
+func (c *Client) dial() (*conn, error) {
+	netConn, err := net.DialTimeout("tcp", c.addr, c.dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis %s: %w", c.addr, err)
+	}
+	cn := &conn{
+		netConn: netConn,
+		r:       bufio.NewReader(netConn),
+		w:       bufio.NewWriter(netConn),
+	}
+
+	if c.password != "" {
+		if _, err := cn.do("AUTH", c.password); err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("failed to authenticate to redis: %w", err)
+		}
+	}
+	if c.db != 0 {
+		if _, err := cn.do("SELECT", c.db); err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("failed to select redis db %d: %w", c.db, err)
+		}
+	}
+	return cn, nil
+}
+
+// do 发送命令并读取响应
+func (cn *conn) do(args ...interface{}) (interface{}, error) {
+	cn.netConn.SetDeadline(time.Now().Add(defaultIOTimeout))
+
+	if err := cn.writeCommand(args); err != nil {
+		return nil, err
+	}
+	return cn.readReply()
+}
+
+// writeCommand 按RESP数组格式写入命令
+func (cn *conn) writeCommand(args []interface{}) error {
+	cn.w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		s := formatArg(arg)
+		cn.w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n")
+		cn.w.WriteString(s)
+		cn.w.WriteString("\r\n")
+	}
+	return cn.w.Flush()
+}
+
+// formatArg 将命令参数格式化为字符串
+func formatArg(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// readReply 读取一个RESP响应
+func (cn *conn) readReply() (interface{}, error) {
+	line, err := cn.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := cn.readReply()
+			if err != nil {
+				if _, ok := err.(Error); !ok {
+					return nil, err
+				}
+				item = err
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+// readLine 读取以CRLF结尾的一行，不含CRLF
+func (cn *conn) readLine() (string, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: malformed line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
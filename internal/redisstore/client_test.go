@@ -0,0 +1,187 @@
+package redisstore
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startRawRedis 在本地端口启动服务端，handler 返回每条命令的RESP原始响应，测试结束时关闭
+// 返回服务端地址和已接受的连接数
+func startRawRedis(t *testing.T, handler func(args []string) string) (string, *atomic.Int32) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	var conns atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go serveRaw(conn, handler)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String(), &conns
+}
+
+// serveRaw 处理一个连接上的命令
+func serveRaw(conn net.Conn, handler func(args []string) string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		conn.Write([]byte(handler(args)))
+	}
+}
+
+// readCommand 读取RESP数组格式的命令
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// TestClientReplies 测试解析各类RESP2响应，服务端错误不丢弃连接，协议错误丢弃连接
+func TestClientReplies(t *testing.T) {
+	addr, conns := startRawRedis(t, func(args []string) string {
+		switch args[0] {
+		case "STATUS":
+			return "+OK\r\n"
+		case "INT":
+			return ":-42\r\n"
+		case "BULK":
+			return "$12\r\nhello\r\nworld\r\n"
+		case "EMPTY":
+			return "$0\r\n\r\n"
+		case "NIL":
+			return "$-1\r\n"
+		case "NILARRAY":
+			return "*-1\r\n"
+		case "ARRAY":
+			return "*4\r\n:1\r\n$3\r\nabc\r\n$-1\r\n*2\r\n-ERR inner\r\n*0\r\n"
+		case "ECHO":
+			value := strings.Join(args[1:], " ")
+			return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+		case "BAD":
+			return "?\r\n"
+		}
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	})
+	client := NewClient(addr, "", 0, time.Second)
+	defer client.Close()
+
+	tests := []struct {
+		args []interface{}
+		want interface{}
+	}{
+		{[]interface{}{"STATUS"}, "OK"},
+		{[]interface{}{"INT"}, int64(-42)},
+		{[]interface{}{"BULK"}, "hello\r\nworld"},
+		{[]interface{}{"EMPTY"}, ""},
+		{[]interface{}{"NIL"}, nil},
+		{[]interface{}{"NILARRAY"}, nil},
+		{[]interface{}{"ARRAY"}, []interface{}{int64(1), "abc", nil, []interface{}{Error("ERR inner"), []interface{}{}}}},
+		{[]interface{}{"ECHO", "s", []byte("b"), 7, int64(8), 1.5, true}, "s b 7 8 1.5 true"},
+	}
+	for _, tt := range tests {
+		reply, err := client.Do(tt.args...)
+		if err != nil || !reflect.DeepEqual(reply, tt.want) {
+			t.Errorf("%v 应返回 %#v，得到 %#v err=%v", tt.args[0], tt.want, reply, err)
+		}
+	}
+
+	reply, err := client.Do("UNKNOWN")
+	var redisErr Error
+	if !errors.As(err, &redisErr) || reply != nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("错误响应应返回 Error，reply=%#v err=%v", reply, err)
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("服务端错误后应继续使用同一连接，建立了 %d 个连接", n)
+	}
+
+	if _, err := client.Do("BAD"); err == nil || errors.As(err, &redisErr) {
+		t.Errorf("无法解析的响应应返回协议错误，得到 %v", err)
+	}
+	if _, err := client.Do("STATUS"); err != nil || conns.Load() != 2 {
+		t.Errorf("协议错误后应丢弃连接并重新连接，连接数 %d err=%v", conns.Load(), err)
+	}
+
+	client.Close()
+	if _, err := client.Do("STATUS"); err == nil {
+		t.Error("客户端关闭后命令应返回错误")
+	}
+}
+
+// TestClientAuthSelect 测试新连接先认证再选库，认证失败时返回错误
+func TestClientAuthSelect(t *testing.T) {
+	var mu sync.Mutex
+	var commands []string
+	addr, _ := startRawRedis(t, func(args []string) string {
+		mu.Lock()
+		commands = append(commands, strings.Join(args, " "))
+		mu.Unlock()
+		if args[0] == "AUTH" && args[1] != "secret" {
+			return "-WRONGPASS invalid username-password pair\r\n"
+		}
+		return "+OK\r\n"
+	})
+
+	client := NewClient(addr, "secret", 3, time.Second)
+	defer client.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := client.Do("PING"); err != nil {
+			t.Fatalf("PING失败: %v", err)
+		}
+	}
+	mu.Lock()
+	got := strings.Join(commands, ", ")
+	mu.Unlock()
+	if got != "AUTH secret, SELECT 3, PING, PING" {
+		t.Errorf("新连接应只认证和选库一次，收到命令 %s", got)
+	}
+
+	wrong := NewClient(addr, "wrong", 0, time.Second)
+	defer wrong.Close()
+	var redisErr Error
+	if _, err := wrong.Do("PING"); !errors.As(err, &redisErr) || !strings.Contains(err.Error(), "authenticate") {
+		t.Errorf("认证失败时应返回包含服务端错误的错误，得到 %v", err)
+	}
+}
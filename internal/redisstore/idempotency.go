@@ -0,0 +1,75 @@
+package redisstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"turnsapi/internal/database"
+)
+
+// ClaimIdempotencyKey 占用幂等键，记录在 lease 内表示请求处理中
+// 已存在未过期的记录时不占用并返回该记录，占用成功时返回nil
+func (s *Store) ClaimIdempotencyKey(key, bodyHash string, lease time.Duration) (*database.IdempotencyRecord, error) {
+	data, err := json.Marshal(database.IdempotencyRecord{Key: key, BodyHash: bodyHash, ExpiresAt: time.Now().Add(lease)})
+	if err != nil {
+		return nil, err
+	}
+	reply, err := s.client.Do("SET", s.idempotencyKey(key), data, "NX", "PX", lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	if reply != nil {
+		return nil, nil
+	}
+
+	reply, err = s.client.Do("GET", s.idempotencyKey(key))
+	if err != nil {
+		return nil, err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		// 记录在查询前过期或被释放，重新占用
+		return s.ClaimIdempotencyKey(key, bodyHash, lease)
+	}
+	var record database.IdempotencyRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return nil, fmt.Errorf("redis: invalid idempotency record: %w", err)
+	}
+	return &record, nil
+}
+
+// SaveIdempotencyResponse 保存第一次请求的响应，在 ttl 内返回给重复请求
+func (s *Store) SaveIdempotencyResponse(record database.IdempotencyRecord, ttl time.Duration) error {
+	record.Completed = true
+	record.ExpiresAt = time.Now().Add(ttl)
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do("SET", s.idempotencyKey(record.Key), data, "PX", ttl.Milliseconds())
+	return err
+}
+
+// idempotencyCompletedMarker 已保存响应的记录JSON中的标记，释放脚本据此保留已完成的记录
+const idempotencyCompletedMarker = `"Completed":true`
+
+// releaseIdempotencyScript 只删除处理中的记录，已保存的响应保留到过期
+const releaseIdempotencyScript = `
+local value = redis.call('GET', KEYS[1])
+if value and not string.find(value, '` + idempotencyCompletedMarker + `', 1, true) then
+	redis.call('DEL', KEYS[1])
+end
+return 1
+`
+
+// ReleaseIdempotencyKey 释放处理中的幂等键，请求失败后客户端重试时重新发往上游
+func (s *Store) ReleaseIdempotencyKey(key string) error {
+	_, err := s.client.Do("EVAL", releaseIdempotencyScript, 1, s.idempotencyKey(key))
+	return err
+}
+
+// idempotencyKey 幂等请求记录的键
+func (s *Store) idempotencyKey(key string) string {
+	return s.prefix + "idempotency:" + key
+}
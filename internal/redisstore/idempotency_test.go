@@ -0,0 +1,46 @@
+package redisstore
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"turnsapi/internal/database"
+)
+
+// TestReleaseIdempotencyScript 测试释放脚本只删除处理中的记录，保留已保存的响应
+func TestReleaseIdempotencyScript(t *testing.T) {
+	server, store := startFakeRedis(t)
+
+	if record, err := store.ClaimIdempotencyKey("pending", "hash", time.Minute); err != nil || record != nil {
+		t.Fatalf("第一次占用应成功，record=%+v err=%v", record, err)
+	}
+	if err := store.ReleaseIdempotencyKey("pending"); err != nil {
+		t.Fatalf("释放幂等键失败: %v", err)
+	}
+	if server.exists("test:idempotency:pending") {
+		t.Error("处理中的记录应被释放")
+	}
+
+	if _, err := store.ClaimIdempotencyKey("done", "hash", time.Minute); err != nil {
+		t.Fatalf("占用幂等键失败: %v", err)
+	}
+	if err := store.SaveIdempotencyResponse(database.IdempotencyRecord{Key: "done", BodyHash: "hash", StatusCode: 200, Body: []byte("{}")}, time.Minute); err != nil {
+		t.Fatalf("保存响应失败: %v", err)
+	}
+	if err := store.ReleaseIdempotencyKey("done"); err != nil {
+		t.Fatalf("释放幂等键失败: %v", err)
+	}
+	record, err := store.ClaimIdempotencyKey("done", "hash", time.Minute)
+	if err != nil || record == nil || !record.Completed || string(record.Body) != "{}" {
+		t.Fatalf("已保存的响应不应被释放，record=%+v err=%v", record, err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for _, script := range server.scripts {
+		if !strings.Contains(script, "string.find(value, '"+idempotencyCompletedMarker+"', 1, true)") {
+			t.Errorf("释放脚本应按完成标记判断记录状态: %s", script)
+		}
+	}
+}
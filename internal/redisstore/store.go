@@ -0,0 +1,301 @@
+package redisstore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"turnsapi/internal/database"
+)
+
+// defaultKeyPrefix 默认的键前缀，多套部署共用同一Redis时应设置不同前缀
+const defaultKeyPrefix = "turnsapi:"
+
+// Store 基于Redis的多实例共享状态：密钥轮询游标、RPM窗口和分组失败状态
+type Store struct {
+	client     *Client
+	prefix     string
+	instanceID string
+	seq        atomic.Int64
+}
+
+// NewStore 创建共享状态存储
+func NewStore(client *Client, keyPrefix string) *Store {
+	if keyPrefix == "" {
+		keyPrefix = defaultKeyPrefix
+	}
+	return &Store{
+		client:     client,
+		prefix:     keyPrefix,
+		instanceID: newInstanceID(),
+	}
+}
+
+// newInstanceID 生成实例标识，用于区分不同实例写入RPM窗口的请求记录
+func newInstanceID() string {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Ping 检查Redis连接
+func (s *Store) Ping() error {
+	_, err := s.client.Do("PING")
+	return err
+}
+
+// Close 关闭Redis连接
+func (s *Store) Close() error {
+	return s.client.Close()
+}
+
+// NextIndex 递增并返回分组的轮询游标，所有实例共享同一游标
+func (s *Store) NextIndex(groupID string) (int64, error) {
+	reply, err := s.client.Do("INCR", s.prefix+"rotation:"+groupID)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected INCR reply %T", reply)
+	}
+	return n, nil
+}
+
+// allowRequestScript 滑动窗口限流：清理窗口外的记录，未达到限制时记录本次请求
+const allowRequestScript = `
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', tonumber(ARGV[1]) - tonumber(ARGV[2]))
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`
+
+// AllowRequest 按所有实例的请求总数检查分组是否超出限制，允许时记录本次请求
+func (s *Store) AllowRequest(groupID string, limit int, window time.Duration) (bool, error) {
+	now := time.Now().UnixMilli()
+	member := fmt.Sprintf("%d-%s-%d", now, s.instanceID, s.seq.Add(1))
+	reply, err := s.client.Do("EVAL", allowRequestScript, 1, s.rpmKey(groupID),
+		now, window.Milliseconds(), limit, member)
+	if err != nil {
+		return false, err
+	}
+	allowed, ok := reply.(int64)
+	if !ok {
+		return false, fmt.Errorf("redis: unexpected EVAL reply %T", reply)
+	}
+	return allowed == 1, nil
+}
+
+// CountRequests 获取分组当前窗口内所有实例的请求数
+func (s *Store) CountRequests(groupID string, window time.Duration) (int, error) {
+	since := time.Now().Add(-window).UnixMilli()
+	reply, err := s.client.Do("ZCOUNT", s.rpmKey(groupID), "("+strconv.FormatInt(since, 10), "+inf")
+	if err != nil {
+		return 0, err
+	}
+	count, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected ZCOUNT reply %T", reply)
+	}
+	return int(count), nil
+}
+
+// ResetRequests 清空分组的请求窗口
+func (s *Store) ResetRequests(groupID string) error {
+	_, err := s.client.Do("DEL", s.rpmKey(groupID))
+	return err
+}
+
+// rpmKey 分组RPM窗口的键
+func (s *Store) rpmKey(groupID string) string {
+	return s.prefix + "rpm:" + groupID
+}
+
+// recordFailureScript 按半衰期衰减失败计数后加1，达到阈值且未处于屏蔽期时屏蔽分组
+const recordFailureScript = `
+local now = tonumber(ARGV[1])
+local halfLife = tonumber(ARGV[2])
+local score = tonumber(redis.call('HGET', KEYS[1], 'score') or '0')
+local updated = tonumber(redis.call('HGET', KEYS[1], 'updated_at') or '0')
+local blocked = tonumber(redis.call('HGET', KEYS[1], 'blocked_until') or '0')
+if score > 0 and now > updated then
+	score = score * math.pow(0.5, (now - updated) / halfLife)
+end
+score = score + 1
+if score >= tonumber(ARGV[3]) and now >= blocked then
+	blocked = now + tonumber(ARGV[4])
+end
+redis.call('HSET', KEYS[1], 'score', tostring(score), 'updated_at', now, 'blocked_until', blocked,
+	'last_failure', now, 'last_error', ARGV[5])
+redis.call('SADD', KEYS[2], ARGV[6])
+return {tostring(score), tostring(blocked)}
+`
+
+// recordSuccessScript 解除屏蔽并将衰减后的失败计数减半，计数可忽略时删除记录
+const recordSuccessScript = `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return {}
+end
+local now = tonumber(ARGV[1])
+local score = tonumber(redis.call('HGET', KEYS[1], 'score') or '0')
+local updated = tonumber(redis.call('HGET', KEYS[1], 'updated_at') or '0')
+if score > 0 and now > updated then
+	score = score * math.pow(0.5, (now - updated) / tonumber(ARGV[2]))
+end
+score = score / 2
+if score < 0.01 then
+	redis.call('DEL', KEYS[1])
+	redis.call('SREM', KEYS[2], ARGV[3])
+	return {}
+end
+redis.call('HSET', KEYS[1], 'score', tostring(score), 'updated_at', now, 'blocked_until', 0)
+return {tostring(score), redis.call('HGET', KEYS[1], 'last_failure') or '0', redis.call('HGET', KEYS[1], 'last_error') or ''}
+`
+
+// RecordRouterFailure 原子地记录分组失败并返回更新后的状态
+func (s *Store) RecordRouterFailure(groupID, errMsg string, now time.Time, halfLife time.Duration, threshold float64, blockDuration time.Duration) (*database.RouterFailureRecord, error) {
+	reply, err := s.client.Do("EVAL", recordFailureScript, 2, s.failureKey(groupID), s.failureSetKey(),
+		now.UnixMilli(), halfLife.Milliseconds(), threshold, blockDuration.Milliseconds(), errMsg, groupID)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return nil, fmt.Errorf("redis: unexpected EVAL reply %v", reply)
+	}
+	return &database.RouterFailureRecord{
+		GroupID:      groupID,
+		Score:        parseFloat(values[0]),
+		UpdatedAt:    now,
+		BlockedUntil: parseMillis(values[1]),
+		LastError:    errMsg,
+		LastFailure:  now,
+	}, nil
+}
+
+// RecordRouterSuccess 原子地记录分组成功，记录不存在或已移除时返回nil
+func (s *Store) RecordRouterSuccess(groupID string, now time.Time, halfLife time.Duration) (*database.RouterFailureRecord, error) {
+	reply, err := s.client.Do("EVAL", recordSuccessScript, 2, s.failureKey(groupID), s.failureSetKey(),
+		now.UnixMilli(), halfLife.Milliseconds(), groupID)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected EVAL reply %v", reply)
+	}
+	if len(values) != 3 {
+		return nil, nil
+	}
+	lastError, _ := values[2].(string)
+	return &database.RouterFailureRecord{
+		GroupID:     groupID,
+		Score:       parseFloat(values[0]),
+		UpdatedAt:   now,
+		LastError:   lastError,
+		LastFailure: parseMillis(values[1]),
+	}, nil
+}
+
+// LoadRouterFailures 加载所有分组的失败状态
+func (s *Store) LoadRouterFailures() ([]database.RouterFailureRecord, error) {
+	reply, err := s.client.Do("SMEMBERS", s.failureSetKey())
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+
+	records := make([]database.RouterFailureRecord, 0, len(members))
+	for _, member := range members {
+		groupID, _ := member.(string)
+		reply, err := s.client.Do("HGETALL", s.failureKey(groupID))
+		if err != nil {
+			return nil, err
+		}
+		fields, _ := reply.([]interface{})
+		if len(fields) == 0 {
+			continue
+		}
+		values := make(map[string]interface{}, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			name, _ := fields[i].(string)
+			values[name] = fields[i+1]
+		}
+		lastError, _ := values["last_error"].(string)
+		records = append(records, database.RouterFailureRecord{
+			GroupID:      groupID,
+			Score:        parseFloat(values["score"]),
+			UpdatedAt:    parseMillis(values["updated_at"]),
+			BlockedUntil: parseMillis(values["blocked_until"]),
+			LastError:    lastError,
+			LastFailure:  parseMillis(values["last_failure"]),
+		})
+	}
+	return records, nil
+}
+
+// SaveRouterFailure 保存分组的失败状态
+func (s *Store) SaveRouterFailure(record database.RouterFailureRecord) error {
+	if _, err := s.client.Do("HSET", s.failureKey(record.GroupID),
+		"score", record.Score,
+		"updated_at", record.UpdatedAt.UnixMilli(),
+		"blocked_until", unixMillis(record.BlockedUntil),
+		"last_failure", unixMillis(record.LastFailure),
+		"last_error", record.LastError,
+	); err != nil {
+		return err
+	}
+	_, err := s.client.Do("SADD", s.failureSetKey(), record.GroupID)
+	return err
+}
+
+// DeleteRouterFailure 清除分组的失败状态
+func (s *Store) DeleteRouterFailure(groupID string) error {
+	if _, err := s.client.Do("DEL", s.failureKey(groupID)); err != nil {
+		return err
+	}
+	_, err := s.client.Do("SREM", s.failureSetKey(), groupID)
+	return err
+}
+
+// failureKey 分组失败状态的键
+func (s *Store) failureKey(groupID string) string {
+	return s.prefix + "failure:" + groupID
+}
+
+// failureSetKey 记录有失败状态的分组集合的键
+func (s *Store) failureSetKey() string {
+	return s.prefix + "failures"
+}
+
+// parseFloat 解析Redis返回的数字字符串
+func parseFloat(v interface{}) float64 {
+	s, _ := v.(string)
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// parseMillis 将毫秒时间戳解析为时间，0表示零值
+func parseMillis(v interface{}) time.Time {
+	ms := int64(parseFloat(v))
+	if ms <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// unixMillis 将时间转换为毫秒时间戳，零值为0
+func unixMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
@@ -0,0 +1,502 @@
+package redisstore
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// fakeRedis 只支持共享状态所需命令的Redis服务端，EVAL 使用Lua解释器执行脚本
+type fakeRedis struct {
+	mu      sync.Mutex
+	values  map[string]string
+	hashes  map[string]map[string]string
+	sets    map[string]map[string]bool
+	zsets   map[string]map[string]float64
+	ttls    map[string]int64
+	scripts []string
+}
+
+// fakeStatus 状态响应，如 +OK
+type fakeStatus string
+
+// startFakeRedis 在本地端口启动服务端，测试结束时关闭
+func startFakeRedis(t *testing.T) (*fakeRedis, *Store) {
+	t.Helper()
+	server := &fakeRedis{
+		values: make(map[string]string),
+		hashes: make(map[string]map[string]string),
+		sets:   make(map[string]map[string]bool),
+		zsets:  make(map[string]map[string]float64),
+		ttls:   make(map[string]int64),
+	}
+	addr, _ := startRawRedis(t, server.handle)
+	client := NewClient(addr, "", 0, time.Second)
+	t.Cleanup(func() { client.Close() })
+	return server, NewStore(client, "test:")
+}
+
+// handle 执行命令并返回RESP格式的响应
+func (f *fakeRedis) handle(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return encodeReply(f.exec(args))
+}
+
+// exec 执行一条命令，返回值类型与 Client.Do 相同，状态响应为 fakeStatus
+func (f *fakeRedis) exec(args []string) interface{} {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return fakeStatus("PONG")
+	case "SET":
+		key, value := args[1], args[2]
+		if len(args) > 3 && strings.ToUpper(args[3]) == "NX" && f.exists(key) {
+			return nil
+		}
+		f.values[key] = value
+		return fakeStatus("OK")
+	case "GET":
+		value, exists := f.values[args[1]]
+		if !exists {
+			return nil
+		}
+		return value
+	case "INCR":
+		n, err := strconv.ParseInt(f.values[args[1]], 10, 64)
+		if _, exists := f.values[args[1]]; exists && err != nil {
+			return Error("ERR value is not an integer or out of range")
+		}
+		f.values[args[1]] = strconv.FormatInt(n+1, 10)
+		return n + 1
+	case "DEL":
+		var deleted int64
+		for _, key := range args[1:] {
+			if f.exists(key) {
+				deleted++
+			}
+			delete(f.values, key)
+			delete(f.hashes, key)
+			delete(f.sets, key)
+			delete(f.zsets, key)
+			delete(f.ttls, key)
+		}
+		return deleted
+	case "EXISTS":
+		var count int64
+		for _, key := range args[1:] {
+			if f.exists(key) {
+				count++
+			}
+		}
+		return count
+	case "PEXPIRE":
+		ms, _ := strconv.ParseInt(args[2], 10, 64)
+		if !f.exists(args[1]) {
+			return int64(0)
+		}
+		f.ttls[args[1]] = ms
+		return int64(1)
+	case "HSET":
+		hash := f.hashes[args[1]]
+		if hash == nil {
+			hash = make(map[string]string)
+			f.hashes[args[1]] = hash
+		}
+		var added int64
+		for i := 2; i+1 < len(args); i += 2 {
+			if _, exists := hash[args[i]]; !exists {
+				added++
+			}
+			hash[args[i]] = args[i+1]
+		}
+		return added
+	case "HGET":
+		value, exists := f.hashes[args[1]][args[2]]
+		if !exists {
+			return nil
+		}
+		return value
+	case "HGETALL":
+		hash := f.hashes[args[1]]
+		fields := make([]string, 0, len(hash))
+		for field := range hash {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		reply := []interface{}{}
+		for _, field := range fields {
+			reply = append(reply, field, hash[field])
+		}
+		return reply
+	case "SADD":
+		set := f.sets[args[1]]
+		if set == nil {
+			set = make(map[string]bool)
+			f.sets[args[1]] = set
+		}
+		var added int64
+		for _, member := range args[2:] {
+			if !set[member] {
+				added++
+			}
+			set[member] = true
+		}
+		return added
+	case "SREM":
+		var removed int64
+		for _, member := range args[2:] {
+			if f.sets[args[1]][member] {
+				removed++
+				delete(f.sets[args[1]], member)
+			}
+		}
+		if len(f.sets[args[1]]) == 0 {
+			delete(f.sets, args[1])
+		}
+		return removed
+	case "SMEMBERS":
+		members := make([]string, 0, len(f.sets[args[1]]))
+		for member := range f.sets[args[1]] {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		reply := []interface{}{}
+		for _, member := range members {
+			reply = append(reply, member)
+		}
+		return reply
+	case "ZADD":
+		score, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return Error("ERR value is not a valid float")
+		}
+		zset := f.zsets[args[1]]
+		if zset == nil {
+			zset = make(map[string]float64)
+			f.zsets[args[1]] = zset
+		}
+		_, exists := zset[args[3]]
+		zset[args[3]] = score
+		if exists {
+			return int64(0)
+		}
+		return int64(1)
+	case "ZCARD":
+		return int64(len(f.zsets[args[1]]))
+	case "ZCOUNT", "ZREMRANGEBYSCORE":
+		var count int64
+		for member, score := range f.zsets[args[1]] {
+			if scoreAbove(score, args[2]) && scoreBelow(score, args[3]) {
+				count++
+				if strings.ToUpper(args[0]) == "ZREMRANGEBYSCORE" {
+					delete(f.zsets[args[1]], member)
+				}
+			}
+		}
+		return count
+	case "EVAL":
+		return f.eval(args)
+	}
+	return Error("ERR unknown command '" + args[0] + "'")
+}
+
+// exists 判断键是否存在，调用方持有锁
+func (f *fakeRedis) exists(key string) bool {
+	_, value := f.values[key]
+	return value || len(f.hashes[key]) > 0 || len(f.sets[key]) > 0 || len(f.zsets[key]) > 0
+}
+
+// eval 在独立的Lua状态中执行脚本，redis.call 直接调用 exec
+func (f *fakeRedis) eval(args []string) interface{} {
+	f.scripts = append(f.scripts, args[1])
+	numKeys, err := strconv.Atoi(args[2])
+	if err != nil || numKeys > len(args)-3 {
+		return Error("ERR Number of keys can't be greater than number of args")
+	}
+
+	L := lua.NewState()
+	defer L.Close()
+	L.SetGlobal("KEYS", stringTable(L, args[3:3+numKeys]))
+	L.SetGlobal("ARGV", stringTable(L, args[3+numKeys:]))
+	redis := L.NewTable()
+	L.SetField(redis, "call", L.NewFunction(func(L *lua.LState) int {
+		callArgs := make([]string, L.GetTop())
+		for i := range callArgs {
+			callArgs[i] = lua.LVAsString(L.Get(i + 1))
+		}
+		reply := f.exec(callArgs)
+		if err, ok := reply.(Error); ok {
+			L.RaiseError("%s", string(err))
+		}
+		L.Push(toLua(L, reply))
+		return 1
+	}))
+	L.SetGlobal("redis", redis)
+
+	fn, err := L.LoadString(args[1])
+	if err != nil {
+		return Error("ERR Error compiling script " + err.Error())
+	}
+	L.Push(fn)
+	if err := L.PCall(0, 1, nil); err != nil {
+		return Error("ERR Error running script " + err.Error())
+	}
+	return fromLua(L.Get(-1))
+}
+
+// shiftScores 将有序集合中所有成员的分数（毫秒时间戳）提前 d
+func (f *fakeRedis) shiftScores(key string, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for member, score := range f.zsets[key] {
+		f.zsets[key][member] = score - float64(d.Milliseconds())
+	}
+}
+
+// stringTable 创建字符串数组表
+func stringTable(L *lua.LState, values []string) *lua.LTable {
+	table := L.NewTable()
+	for _, value := range values {
+		table.Append(lua.LString(value))
+	}
+	return table
+}
+
+// toLua 按Redis的规则将命令响应转换为Lua值，nil 转换为 false
+func toLua(L *lua.LState, reply interface{}) lua.LValue {
+	switch v := reply.(type) {
+	case int64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case fakeStatus:
+		table := L.NewTable()
+		L.SetField(table, "ok", lua.LString(v))
+		return table
+	case []interface{}:
+		table := L.NewTable()
+		for _, item := range v {
+			table.Append(toLua(L, item))
+		}
+		return table
+	}
+	return lua.LFalse
+}
+
+// fromLua 按Redis的规则将脚本返回值转换为响应：数字截断为整数，数组在第一个nil处结束
+func fromLua(value lua.LValue) interface{} {
+	switch v := value.(type) {
+	case lua.LNumber:
+		return int64(v)
+	case lua.LString:
+		return string(v)
+	case lua.LBool:
+		if v {
+			return int64(1)
+		}
+	case *lua.LTable:
+		if err, ok := v.RawGetString("err").(lua.LString); ok {
+			return Error(err)
+		}
+		if status, ok := v.RawGetString("ok").(lua.LString); ok {
+			return fakeStatus(status)
+		}
+		items := []interface{}{}
+		for i := 1; v.RawGetInt(i) != lua.LNil; i++ {
+			items = append(items, fromLua(v.RawGetInt(i)))
+		}
+		return items
+	}
+	return nil
+}
+
+// encodeReply 将响应编码为RESP格式
+func encodeReply(reply interface{}) string {
+	switch v := reply.(type) {
+	case fakeStatus:
+		return "+" + string(v) + "\r\n"
+	case Error:
+		return "-" + string(v) + "\r\n"
+	case int64:
+		return ":" + strconv.FormatInt(v, 10) + "\r\n"
+	case string:
+		return "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+	case []interface{}:
+		var b strings.Builder
+		b.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			b.WriteString(encodeReply(item))
+		}
+		return b.String()
+	}
+	return "$-1\r\n"
+}
+
+// scoreAbove 判断分数是否满足下界，支持 -inf 和 ( 开区间
+func scoreAbove(score float64, bound string) bool {
+	if strings.HasPrefix(bound, "(") {
+		return score > parseBound(bound[1:])
+	}
+	return score >= parseBound(bound)
+}
+
+// scoreBelow 判断分数是否满足上界，支持 +inf 和 ( 开区间
+func scoreBelow(score float64, bound string) bool {
+	if strings.HasPrefix(bound, "(") {
+		return score < parseBound(bound[1:])
+	}
+	return score <= parseBound(bound)
+}
+
+// parseBound 解析分数边界
+func parseBound(bound string) float64 {
+	switch bound {
+	case "-inf":
+		return math.Inf(-1)
+	case "+inf", "inf":
+		return math.Inf(1)
+	}
+	value, _ := strconv.ParseFloat(bound, 64)
+	return value
+}
+
+// TestNextIndex 测试轮询游标按分组递增，键中的值不是整数时返回服务端错误
+func TestNextIndex(t *testing.T) {
+	server, store := startFakeRedis(t)
+
+	for want := int64(1); want <= 3; want++ {
+		if n, err := store.NextIndex("group1"); err != nil || n != want {
+			t.Fatalf("游标应为 %d，得到 %d err=%v", want, n, err)
+		}
+	}
+	if n, err := store.NextIndex("group2"); err != nil || n != 1 {
+		t.Errorf("其他分组应单独计数，得到 %d err=%v", n, err)
+	}
+
+	server.mu.Lock()
+	server.values["test:rotation:broken"] = "abc"
+	server.mu.Unlock()
+	var redisErr Error
+	if _, err := store.NextIndex("broken"); !errors.As(err, &redisErr) {
+		t.Errorf("INCR失败时应返回服务端错误，得到 %v", err)
+	}
+}
+
+// TestAllowRequestScript 测试滑动窗口按所有实例的请求总数限流，窗口外的记录被清理后恢复额度
+func TestAllowRequestScript(t *testing.T) {
+	server, store := startFakeRedis(t)
+	other := NewStore(store.client, "test:") // 共享同一Redis的另一个实例
+
+	for i, s := range []*Store{store, other} {
+		if allowed, err := s.AllowRequest("group1", 2, time.Minute); err != nil || !allowed {
+			t.Fatalf("第 %d 个请求应被允许，err=%v", i+1, err)
+		}
+	}
+	if allowed, err := store.AllowRequest("group1", 2, time.Minute); err != nil || allowed {
+		t.Fatalf("所有实例的请求数达到限制后应拒绝，allowed=%v err=%v", allowed, err)
+	}
+	if allowed, _ := store.AllowRequest("group2", 2, time.Minute); !allowed {
+		t.Error("其他分组应单独计数")
+	}
+	if count, err := store.CountRequests("group1", time.Minute); err != nil || count != 2 {
+		t.Errorf("窗口内应有2个请求（被拒绝的不记录），得到 %d err=%v", count, err)
+	}
+	server.mu.Lock()
+	ttl := server.ttls["test:rpm:group1"]
+	server.mu.Unlock()
+	if ttl != time.Minute.Milliseconds() {
+		t.Errorf("窗口键应按窗口长度过期，得到 %dms", ttl)
+	}
+
+	// 一个窗口之前的记录不再计入，并在下次请求时被清理
+	server.shiftScores("test:rpm:group1", time.Minute)
+	if count, _ := store.CountRequests("group1", time.Minute); count != 0 {
+		t.Errorf("窗口外的请求不应计入，得到 %d", count)
+	}
+	if allowed, err := store.AllowRequest("group1", 2, time.Minute); err != nil || !allowed {
+		t.Fatalf("窗口过期后应恢复额度，err=%v", err)
+	}
+	server.mu.Lock()
+	remaining := len(server.zsets["test:rpm:group1"])
+	server.mu.Unlock()
+	if remaining != 1 {
+		t.Errorf("窗口外的记录应被清理，剩余 %d 条", remaining)
+	}
+
+	if err := store.ResetRequests("group1"); err != nil {
+		t.Fatalf("清空请求窗口失败: %v", err)
+	}
+	if count, _ := store.CountRequests("group1", time.Minute); count != 0 {
+		t.Errorf("清空后不应有请求，得到 %d", count)
+	}
+}
+
+// TestRouterFailureScripts 测试失败计数按半衰期衰减，达到阈值时屏蔽且屏蔽期内不延长，成功后解除屏蔽并减半计数
+func TestRouterFailureScripts(t *testing.T) {
+	_, store := startFakeRedis(t)
+	start := time.UnixMilli(1_700_000_000_000)
+	halfLife, threshold, block := time.Minute, 3.0, 5*time.Minute
+
+	fail := func(at time.Duration, wantScore float64, wantBlocked time.Time) {
+		t.Helper()
+		record, err := store.RecordRouterFailure("group1", "upstream error", start.Add(at), halfLife, threshold, block)
+		if err != nil {
+			t.Fatalf("记录失败出错: %v", err)
+		}
+		if math.Abs(record.Score-wantScore) > 1e-9 || !record.BlockedUntil.Equal(wantBlocked) {
+			t.Errorf("%v 时失败计数应为 %v、屏蔽至 %v，得到 %v、%v", at, wantScore, wantBlocked, record.Score, record.BlockedUntil)
+		}
+	}
+	fail(0, 1, time.Time{})
+	fail(0, 2, time.Time{})
+	fail(time.Minute, 2, time.Time{}) // 经过一个半衰期：2*0.5+1
+	blockedUntil := start.Add(time.Minute + block)
+	fail(time.Minute, 3, blockedUntil)
+	fail(2*time.Minute, 2.5, blockedUntil)
+	fail(2*time.Minute, 3.5, blockedUntil) // 屏蔽期内达到阈值不延长屏蔽
+
+	records, err := store.LoadRouterFailures()
+	if err != nil || len(records) != 1 {
+		t.Fatalf("应加载1条失败状态，得到 %+v err=%v", records, err)
+	}
+	if r := records[0]; r.GroupID != "group1" || r.Score != 3.5 || !r.BlockedUntil.Equal(blockedUntil) ||
+		!r.LastFailure.Equal(start.Add(2*time.Minute)) || r.LastError != "upstream error" {
+		t.Errorf("加载的失败状态不正确: %+v", r)
+	}
+
+	record, err := store.RecordRouterSuccess("group1", start.Add(3*time.Minute), halfLife)
+	if err != nil || record == nil {
+		t.Fatalf("记录成功出错，record=%+v err=%v", record, err)
+	}
+	if math.Abs(record.Score-0.875) > 1e-9 || !record.BlockedUntil.IsZero() ||
+		!record.LastFailure.Equal(start.Add(2*time.Minute)) || record.LastError != "upstream error" {
+		t.Errorf("成功后应解除屏蔽并将衰减后的计数减半，得到 %+v", record)
+	}
+	if records, _ := store.LoadRouterFailures(); len(records) != 1 || !records[0].BlockedUntil.IsZero() {
+		t.Errorf("成功后加载的状态不应处于屏蔽期，得到 %+v", records)
+	}
+
+	// 计数可忽略时删除记录
+	for i := 0; i < 10 && record != nil; i++ {
+		record, err = store.RecordRouterSuccess("group1", start.Add(3*time.Minute), halfLife)
+		if err != nil {
+			t.Fatalf("记录成功出错: %v", err)
+		}
+	}
+	if record != nil {
+		t.Errorf("计数可忽略时应删除记录，得到 %+v", record)
+	}
+	if records, err := store.LoadRouterFailures(); err != nil || len(records) != 0 {
+		t.Errorf("删除后不应加载到失败状态，得到 %+v err=%v", records, err)
+	}
+	if record, err := store.RecordRouterSuccess("group2", start, halfLife); err != nil || record != nil {
+		t.Errorf("没有失败状态的分组成功时应返回nil，得到 %+v err=%v", record, err)
+	}
+}
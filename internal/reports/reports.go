@@ -0,0 +1,328 @@
+package reports
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/logger"
+	"turnsapi/internal/notify"
+)
+
+const (
+	checkInterval = 10 * time.Minute // 检查是否有到期报告的间隔
+	defaultTopN   = 5
+)
+
+// Store 用量报告的数据来源和持久化存储
+type Store interface {
+	AggregateUsage(start, end time.Time) ([]*logger.UsageBreakdown, error)
+	SaveUsageReport(report *logger.UsageReport) error
+	HasUsageReport(period string, start time.Time) (bool, error)
+}
+
+// Entry 报告中一个分组、代理密钥或模型的统计
+type Entry struct {
+	Name      string  `json:"name"`
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"`
+	ErrorRate float64 `json:"error_rate"` // 百分比
+	Tokens    int64   `json:"tokens"`
+	Cost      float64 `json:"cost"`
+}
+
+// Details 报告明细，各列表按请求数倒序
+type Details struct {
+	ErrorRate      float64  `json:"error_rate"` // 百分比
+	Groups         []Entry  `json:"groups"`
+	ProxyKeys      []Entry  `json:"proxy_keys"`
+	Models         []Entry  `json:"models"`
+	UnpricedModels []string `json:"unpriced_models,omitempty"` // 未配置价格、未计入费用的模型
+}
+
+// Generator 用量报告生成器，按配置定时生成日报和周报，到期未生成的报告在启动后补齐
+type Generator struct {
+	config   internal.ConfigSource
+	store    Store
+	notifier *notify.Notifier
+
+	mu       sync.Mutex // 避免定时任务和手动生成同时写入同一份报告
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewGenerator 创建用量报告生成器
+func NewGenerator(config internal.ConfigSource, store Store, notifier *notify.Notifier) *Generator {
+	return &Generator{
+		config:   config,
+		store:    store,
+		notifier: notifier,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start 启动后台定时生成
+func (g *Generator) Start() {
+	go g.run()
+}
+
+// Close 停止后台定时生成
+func (g *Generator) Close() {
+	g.stopOnce.Do(func() { close(g.stopCh) })
+}
+
+// run 定期检查并生成到期的报告
+func (g *Generator) run() {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	g.GenerateDue(time.Now())
+	for {
+		select {
+		case <-g.stopCh:
+			return
+		case <-ticker.C:
+			g.GenerateDue(time.Now())
+		}
+	}
+}
+
+// settings 读取用量报告设置
+func (g *Generator) settings() *internal.UsageReportSettings {
+	config := g.config.Snapshot()
+	if config == nil || config.GlobalSettings == nil {
+		return nil
+	}
+	return config.GlobalSettings.UsageReports
+}
+
+// GenerateDue 为开启的周期生成上一个已结束但尚未生成的报告，开启通知时发送摘要
+func (g *Generator) GenerateDue(now time.Time) {
+	settings := g.settings()
+	if settings == nil {
+		return
+	}
+
+	var periods []string
+	if settings.Daily {
+		periods = append(periods, logger.UsageReportDaily)
+	}
+	if settings.Weekly {
+		periods = append(periods, logger.UsageReportWeekly)
+	}
+	for _, period := range periods {
+		current, _, _ := PeriodBounds(period, now)
+		start, _, _ := PeriodBounds(period, current.Add(-time.Nanosecond))
+		exists, err := g.store.HasUsageReport(period, start)
+		if err != nil {
+			log.Printf("警告: 检查用量报告失败: %v", err)
+			continue
+		}
+		if exists {
+			continue
+		}
+
+		report, details, err := g.generate(period, start, now)
+		if err != nil {
+			log.Printf("警告: 生成用量%s失败: %v", periodName(period), err)
+			continue
+		}
+		log.Printf("已生成用量%s: %s，%d 个请求", periodName(period), periodLabel(report), report.Requests)
+		if settings.Notify && g.notifier != nil {
+			g.notifier.Send(summaryNotification(report, details, settings.TopN))
+		}
+	}
+}
+
+// Generate 生成包含 at 的已结束周期的报告并保存，同一周期的报告已存在时替换
+func (g *Generator) Generate(period string, at time.Time) (*logger.UsageReport, error) {
+	report, _, err := g.generate(period, at, time.Now())
+	return report, err
+}
+
+// generate 统计周期内的请求并保存报告
+func (g *Generator) generate(period string, at, now time.Time) (*logger.UsageReport, *Details, error) {
+	start, end, err := PeriodBounds(period, at)
+	if err != nil {
+		return nil, nil, err
+	}
+	if end.After(now) {
+		return nil, nil, fmt.Errorf("period %s has not ended yet", start.Format("2006-01-02"))
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	breakdown, err := g.store.AggregateUsage(start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	var pricing map[string]internal.ModelPrice
+	if config := g.config.Snapshot(); config != nil && config.GlobalSettings != nil {
+		pricing = config.GlobalSettings.ModelPricing
+	}
+	report, details := Summarize(breakdown, pricing)
+	report.Period = period
+	report.PeriodStart = start
+	report.PeriodEnd = end
+	report.CreatedAt = now
+
+	if err := g.store.SaveUsageReport(report); err != nil {
+		return nil, nil, err
+	}
+	return report, details, nil
+}
+
+// PeriodBounds 返回包含 t 的日或周（周一开始）的起止时间，按 t 所在时区划分
+func PeriodBounds(period string, t time.Time) (time.Time, time.Time, error) {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch period {
+	case logger.UsageReportDaily:
+		return day, day.AddDate(0, 0, 1), nil
+	case logger.UsageReportWeekly:
+		start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7), nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("unsupported report period: %s", period)
+	}
+}
+
+// Summarize 汇总请求统计，按 model_pricing 估算费用
+// 请求日志只记录总token数，费用按输入和输出各占一半估算
+func Summarize(breakdown []*logger.UsageBreakdown, pricing map[string]internal.ModelPrice) (*logger.UsageReport, *Details) {
+	report := &logger.UsageReport{}
+	groups := make(map[string]*Entry)
+	keys := make(map[string]*Entry)
+	models := make(map[string]*Entry)
+	unpriced := make(map[string]bool)
+
+	for _, b := range breakdown {
+		cost := 0.0
+		if price, ok := pricing[b.Model]; ok {
+			half := int(b.Tokens / 2)
+			cost = price.Cost(half, int(b.Tokens)-half)
+		} else if b.Tokens > 0 {
+			unpriced[b.Model] = true
+		}
+
+		report.Requests += b.Requests
+		report.Errors += b.Errors
+		report.Tokens += b.Tokens
+		report.Cost += cost
+		for _, target := range []struct {
+			entries map[string]*Entry
+			name    string
+		}{{groups, b.ProviderGroup}, {keys, b.ProxyKeyName}, {models, b.Model}} {
+			entry, ok := target.entries[target.name]
+			if !ok {
+				entry = &Entry{Name: target.name}
+				target.entries[target.name] = entry
+			}
+			entry.Requests += b.Requests
+			entry.Errors += b.Errors
+			entry.Tokens += b.Tokens
+			entry.Cost += cost
+		}
+	}
+
+	details := &Details{
+		ErrorRate: errorRate(report.Errors, report.Requests),
+		Groups:    sortedEntries(groups),
+		ProxyKeys: sortedEntries(keys),
+		Models:    sortedEntries(models),
+	}
+	for model := range unpriced {
+		details.UnpricedModels = append(details.UnpricedModels, model)
+	}
+	sort.Strings(details.UnpricedModels)
+
+	report.Details, _ = json.Marshal(details)
+	return report, details
+}
+
+// sortedEntries 计算错误率并按请求数倒序排列
+func sortedEntries(entries map[string]*Entry) []Entry {
+	sorted := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		entry.ErrorRate = errorRate(entry.Errors, entry.Requests)
+		sorted = append(sorted, *entry)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Requests != sorted[j].Requests {
+			return sorted[i].Requests > sorted[j].Requests
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// errorRate 错误请求百分比
+func errorRate(errors, requests int64) float64 {
+	if requests == 0 {
+		return 0
+	}
+	return float64(errors) / float64(requests) * 100
+}
+
+// periodName 报告周期的显示名称
+func periodName(period string) string {
+	if period == logger.UsageReportWeekly {
+		return "周报"
+	}
+	return "日报"
+}
+
+// periodLabel 报告覆盖的日期范围
+func periodLabel(report *logger.UsageReport) string {
+	start := report.PeriodStart.Format("2006-01-02")
+	if report.Period == logger.UsageReportDaily {
+		return start
+	}
+	return start + " ~ " + report.PeriodEnd.AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+// summaryNotification 生成报告的通知摘要，列出请求数最多的分组和代理密钥
+func summaryNotification(report *logger.UsageReport, details *Details, topN int) notify.Notification {
+	if topN <= 0 {
+		topN = defaultTopN
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "请求 %d，错误率 %.1f%%，token %d，估算费用 $%.2f",
+		report.Requests, details.ErrorRate, report.Tokens, report.Cost)
+	for _, section := range []struct {
+		title   string
+		entries []Entry
+	}{{"分组", details.Groups}, {"代理密钥", details.ProxyKeys}} {
+		if len(section.entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s:", section.title)
+		for i, entry := range section.entries {
+			if i == topN {
+				fmt.Fprintf(&b, "\n  ……另有 %d 个", len(section.entries)-topN)
+				break
+			}
+			fmt.Fprintf(&b, "\n  %s: 请求 %d，错误率 %.1f%%，token %d，$%.2f",
+				entry.Name, entry.Requests, entry.ErrorRate, entry.Tokens, entry.Cost)
+		}
+	}
+
+	return notify.Notification{
+		Source:   "report",
+		Event:    report.Period,
+		Severity: notify.SeverityInfo,
+		Title:    fmt.Sprintf("TurnsAPI 用量%s %s", periodName(report.Period), periodLabel(report)),
+		Message:  b.String(),
+		Labels: map[string]string{
+			"period":       report.Period,
+			"period_start": report.PeriodStart.Format("2006-01-02"),
+		},
+		Time: report.CreatedAt,
+	}
+}
@@ -0,0 +1,92 @@
+package reports
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/logger"
+)
+
+// TestGenerateUsageReport 测试按周期汇总请求日志、估算费用并替换已有报告
+func TestGenerateUsageReport(t *testing.T) {
+	requestLogger, err := logger.NewRequestLogger(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create request logger: %v", err)
+	}
+	defer requestLogger.Close()
+
+	requestLogger.LogRequest("alice", "key-1", "openai", "sk-test-12345678", "gpt-4o", `{"model":"gpt-4o"}`,
+		`{"usage":{"total_tokens":1000}}`, "127.0.0.1", 200, false, time.Second, nil)
+	requestLogger.LogRequest("alice", "key-1", "openai", "sk-test-12345678", "gpt-4o", `{"model":"gpt-4o"}`,
+		"", "127.0.0.1", 502, false, time.Second, fmt.Errorf("upstream error"))
+	requestLogger.LogRequest("bob", "key-2", "anthropic", "sk-test-87654321", "claude", `{"model":"claude"}`,
+		`{"usage":{"total_tokens":500}}`, "127.0.0.1", 200, false, time.Second, nil)
+
+	config := &internal.Config{GlobalSettings: &internal.GlobalSettings{
+		ModelPricing: map[string]internal.ModelPrice{"gpt-4o": {InputPer1M: 2, OutputPer1M: 8}},
+	}}
+	generator := NewGenerator(config, requestLogger, nil)
+
+	if _, err := generator.Generate(logger.UsageReportDaily, time.Now()); err == nil {
+		t.Error("Expected the current period to be rejected")
+	}
+
+	// 以明天为当前时间生成今天的日报
+	now := time.Now()
+	report, details, err := generator.generate(logger.UsageReportDaily, now, now.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("Failed to generate report: %v", err)
+	}
+	if report.Requests != 3 || report.Errors != 1 {
+		t.Errorf("Expected 3 requests with 1 error, got %+v", report)
+	}
+	// gpt-4o 共1000个token，按输入输出各500估算：(500*2 + 500*8) / 1e6
+	if report.Tokens != 1500 || report.Cost != 0.005 {
+		t.Errorf("Expected 1500 tokens costing $0.005, got %d tokens costing %v", report.Tokens, report.Cost)
+	}
+	if len(details.Groups) != 2 || details.Groups[0].Name != "openai" || details.Groups[0].ErrorRate != 50 {
+		t.Errorf("Unexpected group breakdown: %+v", details.Groups)
+	}
+	if len(details.UnpricedModels) != 1 || details.UnpricedModels[0] != "claude" {
+		t.Errorf("Expected claude to be reported as unpriced, got %v", details.UnpricedModels)
+	}
+
+	// 重新生成同一周期时替换原报告
+	if _, _, err := generator.generate(logger.UsageReportDaily, now, now.AddDate(0, 0, 1)); err != nil {
+		t.Fatalf("Failed to regenerate report: %v", err)
+	}
+	stored, err := requestLogger.GetUsageReports(logger.UsageReportDaily, 10)
+	if err != nil {
+		t.Fatalf("Failed to list reports: %v", err)
+	}
+	if len(stored) != 1 || stored[0].Requests != 3 {
+		t.Fatalf("Expected a single stored daily report, got %+v", stored)
+	}
+	var storedDetails Details
+	if err := json.Unmarshal(stored[0].Details, &storedDetails); err != nil || len(storedDetails.ProxyKeys) != 2 {
+		t.Errorf("Expected proxy key breakdown in stored details, got %s (err: %v)", stored[0].Details, err)
+	}
+	start, _, _ := PeriodBounds(logger.UsageReportDaily, now)
+	if exists, err := requestLogger.HasUsageReport(logger.UsageReportDaily, start); err != nil || !exists {
+		t.Errorf("Expected report for %s to exist (err: %v)", start.Format("2006-01-02"), err)
+	}
+}
+
+// TestPeriodBounds 测试周报从周一开始
+func TestPeriodBounds(t *testing.T) {
+	sunday := time.Date(2024, 3, 10, 15, 0, 0, 0, time.Local)
+	start, end, err := PeriodBounds(logger.UsageReportWeekly, sunday)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if start.Format("2006-01-02") != "2024-03-04" || end.Format("2006-01-02") != "2024-03-11" {
+		t.Errorf("Expected week 2024-03-04 ~ 2024-03-11, got %s ~ %s", start, end)
+	}
+	if _, _, err := PeriodBounds("monthly", sunday); err == nil {
+		t.Error("Expected unsupported period to be rejected")
+	}
+}
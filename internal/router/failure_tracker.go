@@ -0,0 +1,416 @@
+package router
+
+import (
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"turnsapi/internal"
+	"turnsapi/internal/database"
+)
+
+// 失败跟踪默认参数
+const (
+	defaultFailureHalfLife       = 10 * time.Minute
+	defaultFailureBlockThreshold = 3.0
+	defaultFailureBlockDuration  = 5 * time.Minute
+	failureScoreBucket           = 0.5 // 排序时失败计数的分档宽度，同一档内保持配置的优先级顺序
+)
+
+// FailureStore 路由失败状态持久化接口
+type FailureStore interface {
+	LoadRouterFailures() ([]database.RouterFailureRecord, error)
+	SaveRouterFailure(record database.RouterFailureRecord) error
+	DeleteRouterFailure(groupID string) error
+}
+
+// SharedFailureStore 多实例共享的失败状态存储，失败计数在存储端原子更新
+type SharedFailureStore interface {
+	FailureStore
+	RecordRouterFailure(groupID, errMsg string, now time.Time, halfLife time.Duration, threshold float64, blockDuration time.Duration) (*database.RouterFailureRecord, error)
+	// RecordRouterSuccess 记录不存在或失败计数衰减到可忽略而被删除时返回nil
+	RecordRouterSuccess(groupID string, now time.Time, halfLife time.Duration) (*database.RouterFailureRecord, error)
+}
+
+// FailureState 分组当前的失败状态，供管理接口展示
+type FailureState struct {
+	GroupID      string     `json:"group_id"`
+	FailureScore float64    `json:"failure_score"` // 衰减后的失败计数
+	Blocked      bool       `json:"blocked"`
+	BlockedUntil *time.Time `json:"blocked_until,omitempty"`
+	LastFailure  *time.Time `json:"last_failure,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// failureEntry 单个分组的失败记录
+type failureEntry struct {
+	score        float64
+	updatedAt    time.Time
+	blockedUntil time.Time
+	lastFailure  time.Time
+	lastError    string
+}
+
+// persistOp 待写入存储的失败状态，record 为nil时删除
+type persistOp struct {
+	store  FailureStore
+	record *database.RouterFailureRecord
+}
+
+// FailureTracker 分组失败跟踪器
+// 失败计数按半衰期指数衰减，衰减后仍达到阈值时在一段时间内屏蔽分组
+type FailureTracker struct {
+	mu             sync.Mutex
+	entries        map[string]*failureEntry
+	halfLife       time.Duration
+	blockThreshold float64
+	blockDuration  time.Duration
+	store          FailureStore
+	shared         SharedFailureStore // 共享存储，设置后失败状态在所有实例间同步
+	now            func() time.Time
+
+	// 持久化由单个后台协程按分组合并后串行写入，保证同一分组的写入按发生顺序落地
+	pending     map[string]persistOp // 分组 -> 最新的待写入状态
+	persistWake chan struct{}
+	persistOnce sync.Once
+	writeMu     sync.Mutex
+}
+
+// NewFailureTracker 创建分组失败跟踪器，settings为空时使用默认参数
+func NewFailureTracker(settings *internal.RouterFailureSettings) *FailureTracker {
+	ft := &FailureTracker{
+		entries:        make(map[string]*failureEntry),
+		halfLife:       defaultFailureHalfLife,
+		blockThreshold: defaultFailureBlockThreshold,
+		blockDuration:  defaultFailureBlockDuration,
+		now:            time.Now,
+		persistWake:    make(chan struct{}, 1),
+	}
+	if settings != nil {
+		if settings.HalfLife > 0 {
+			ft.halfLife = settings.HalfLife
+		}
+		if settings.BlockThreshold > 0 {
+			ft.blockThreshold = settings.BlockThreshold
+		}
+		if settings.BlockDuration > 0 {
+			ft.blockDuration = settings.BlockDuration
+		}
+	}
+	return ft
+}
+
+// SetStore 设置持久化存储并加载已保存的失败状态
+func (ft *FailureTracker) SetStore(store FailureStore) error {
+	records, err := store.LoadRouterFailures()
+	if err != nil {
+		return err
+	}
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	ft.store = store
+	for _, record := range records {
+		ft.entries[record.GroupID] = entryOf(&record)
+	}
+	if len(records) > 0 {
+		log.Printf("已加载 %d 个分组的路由失败状态", len(records))
+	}
+	return nil
+}
+
+// SetSharedStore 设置多实例共享的失败状态存储，并按间隔从存储同步其他实例记录的状态
+// 共享存储不可用时退回本地跟踪
+func (ft *FailureTracker) SetSharedStore(store SharedFailureStore, syncInterval time.Duration) error {
+	if err := ft.syncShared(store); err != nil {
+		return err
+	}
+
+	ft.mu.Lock()
+	ft.shared = store
+	ft.store = nil
+	ft.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(syncInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := ft.syncShared(store); err != nil {
+				log.Printf("同步共享路由失败状态失败: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// syncShared 用共享存储中的状态替换本地状态
+func (ft *FailureTracker) syncShared(store SharedFailureStore) error {
+	records, err := store.LoadRouterFailures()
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string]*failureEntry, len(records))
+	for _, record := range records {
+		entries[record.GroupID] = entryOf(&record)
+	}
+
+	ft.mu.Lock()
+	ft.entries = entries
+	ft.mu.Unlock()
+	return nil
+}
+
+// sharedStore 获取共享存储
+func (ft *FailureTracker) sharedStore() SharedFailureStore {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.shared
+}
+
+// entryOf 将持久化记录转换为失败记录
+func entryOf(record *database.RouterFailureRecord) *failureEntry {
+	return &failureEntry{
+		score:        record.Score,
+		updatedAt:    record.UpdatedAt,
+		blockedUntil: record.BlockedUntil,
+		lastFailure:  record.LastFailure,
+		lastError:    record.LastError,
+	}
+}
+
+// decayedScore 计算衰减到指定时间的失败计数
+func (ft *FailureTracker) decayedScore(entry *failureEntry, now time.Time) float64 {
+	elapsed := now.Sub(entry.updatedAt)
+	if elapsed <= 0 || entry.score == 0 {
+		return entry.score
+	}
+	return entry.score * math.Pow(0.5, float64(elapsed)/float64(ft.halfLife))
+}
+
+// RecordFailure 记录分组请求失败，衰减后的失败计数达到阈值时屏蔽分组
+func (ft *FailureTracker) RecordFailure(groupID, errMsg string) {
+	if shared := ft.sharedStore(); shared != nil {
+		record, err := shared.RecordRouterFailure(groupID, errMsg, ft.now(), ft.halfLife, ft.blockThreshold, ft.blockDuration)
+		if err == nil {
+			ft.mu.Lock()
+			previous, exists := ft.entries[groupID]
+			if record.BlockedUntil.After(record.UpdatedAt) && (!exists || !previous.blockedUntil.Equal(record.BlockedUntil)) {
+				log.Printf("分组 %s 失败计数 %.2f 达到阈值，屏蔽至 %s", groupID, record.Score, record.BlockedUntil.Format("15:04:05"))
+			}
+			ft.entries[groupID] = entryOf(record)
+			ft.mu.Unlock()
+			return
+		}
+		log.Printf("共享路由失败状态不可用，分组 %s 使用本地状态: %v", groupID, err)
+	}
+
+	ft.mu.Lock()
+	now := ft.now()
+	entry, exists := ft.entries[groupID]
+	if !exists {
+		entry = &failureEntry{}
+		ft.entries[groupID] = entry
+	}
+
+	entry.score = ft.decayedScore(entry, now) + 1
+	entry.updatedAt = now
+	entry.lastFailure = now
+	entry.lastError = errMsg
+	if entry.score >= ft.blockThreshold && !now.Before(entry.blockedUntil) {
+		entry.blockedUntil = now.Add(ft.blockDuration)
+		log.Printf("分组 %s 失败计数 %.2f 达到阈值，屏蔽至 %s", groupID, entry.score, entry.blockedUntil.Format("15:04:05"))
+	}
+	ft.persistLocked(groupID, entry)
+	ft.mu.Unlock()
+}
+
+// RecordSuccess 记录分组请求成功，解除屏蔽并将失败计数减半
+func (ft *FailureTracker) RecordSuccess(groupID string) {
+	if shared := ft.sharedStore(); shared != nil {
+		record, err := shared.RecordRouterSuccess(groupID, ft.now(), ft.halfLife)
+		if err == nil {
+			ft.mu.Lock()
+			if record == nil {
+				delete(ft.entries, groupID)
+			} else {
+				ft.entries[groupID] = entryOf(record)
+			}
+			ft.mu.Unlock()
+			return
+		}
+		log.Printf("共享路由失败状态不可用，分组 %s 使用本地状态: %v", groupID, err)
+	}
+
+	ft.mu.Lock()
+	entry, exists := ft.entries[groupID]
+	if !exists {
+		ft.mu.Unlock()
+		return
+	}
+
+	now := ft.now()
+	entry.score = ft.decayedScore(entry, now) / 2
+	entry.updatedAt = now
+	entry.blockedUntil = time.Time{}
+
+	// 失败计数衰减到可忽略时移除记录
+	if entry.score < 0.01 {
+		delete(ft.entries, groupID)
+		ft.removeLocked(groupID)
+		ft.mu.Unlock()
+		return
+	}
+	ft.persistLocked(groupID, entry)
+	ft.mu.Unlock()
+}
+
+// IsBlocked 判断分组当前是否被屏蔽
+func (ft *FailureTracker) IsBlocked(groupID string) bool {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	entry, exists := ft.entries[groupID]
+	return exists && ft.now().Before(entry.blockedUntil)
+}
+
+// Reset 清除分组的失败状态
+func (ft *FailureTracker) Reset(groupID string) {
+	ft.mu.Lock()
+	delete(ft.entries, groupID)
+	ft.removeLocked(groupID)
+	ft.mu.Unlock()
+}
+
+// Sort 按失败状态排序分组：未屏蔽的分组在前并按失败计数升序，屏蔽的分组放到最后作为兜底
+// 失败计数按 failureScoreBucket 分档比较，同一档内保持配置的优先级顺序
+func (ft *FailureTracker) Sort(groups []string) []string {
+	ft.mu.Lock()
+	now := ft.now()
+	buckets := make(map[string]int, len(groups))
+	blocked := make(map[string]bool, len(groups))
+	for _, groupID := range groups {
+		if entry, exists := ft.entries[groupID]; exists {
+			buckets[groupID] = int(math.Floor(ft.decayedScore(entry, now) / failureScoreBucket))
+			blocked[groupID] = now.Before(entry.blockedUntil)
+		}
+	}
+	ft.mu.Unlock()
+
+	sorted := make([]string, len(groups))
+	copy(sorted, groups)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if blocked[sorted[i]] != blocked[sorted[j]] {
+			return !blocked[sorted[i]]
+		}
+		return buckets[sorted[i]] < buckets[sorted[j]]
+	})
+	return sorted
+}
+
+// States 获取所有分组的当前失败状态
+func (ft *FailureTracker) States() []FailureState {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	now := ft.now()
+	states := make([]FailureState, 0, len(ft.entries))
+	for groupID, entry := range ft.entries {
+		state := FailureState{
+			GroupID:      groupID,
+			FailureScore: math.Round(ft.decayedScore(entry, now)*100) / 100,
+			Blocked:      now.Before(entry.blockedUntil),
+			LastError:    entry.lastError,
+		}
+		if state.Blocked {
+			blockedUntil := entry.blockedUntil
+			state.BlockedUntil = &blockedUntil
+		}
+		if !entry.lastFailure.IsZero() {
+			lastFailure := entry.lastFailure
+			state.LastFailure = &lastFailure
+		}
+		states = append(states, state)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].GroupID < states[j].GroupID
+	})
+	return states
+}
+
+// persistLocked 将分组的失败状态加入待写入队列，调用方需持有锁
+// 在计算状态的同一临界区内入队，保证后计算的状态不会被先计算的状态覆盖
+func (ft *FailureTracker) persistLocked(groupID string, entry *failureEntry) {
+	if ft.store == nil {
+		return
+	}
+	ft.enqueueLocked(groupID, persistOp{
+		store: ft.store,
+		record: &database.RouterFailureRecord{
+			GroupID:      groupID,
+			Score:        entry.score,
+			UpdatedAt:    entry.updatedAt,
+			BlockedUntil: entry.blockedUntil,
+			LastError:    entry.lastError,
+			LastFailure:  entry.lastFailure,
+		},
+	})
+}
+
+// removeLocked 将删除持久化或共享的失败状态加入待写入队列，调用方需持有锁
+func (ft *FailureTracker) removeLocked(groupID string) {
+	var store FailureStore = ft.store
+	if ft.shared != nil {
+		store = ft.shared
+	}
+	if store == nil {
+		return
+	}
+	ft.enqueueLocked(groupID, persistOp{store: store})
+}
+
+// enqueueLocked 记录分组最新的待写入状态并唤醒写入协程，调用方需持有锁
+func (ft *FailureTracker) enqueueLocked(groupID string, op persistOp) {
+	if ft.pending == nil {
+		ft.pending = make(map[string]persistOp)
+	}
+	ft.pending[groupID] = op
+	ft.persistOnce.Do(func() {
+		go func() {
+			for range ft.persistWake {
+				ft.flushPending()
+			}
+		}()
+	})
+	select {
+	case ft.persistWake <- struct{}{}:
+	default:
+	}
+}
+
+// flushPending 串行写入所有待写入的失败状态
+func (ft *FailureTracker) flushPending() {
+	ft.writeMu.Lock()
+	defer ft.writeMu.Unlock()
+
+	ft.mu.Lock()
+	pending := ft.pending
+	ft.pending = nil
+	ft.mu.Unlock()
+
+	for groupID, op := range pending {
+		if op.record == nil {
+			if err := op.store.DeleteRouterFailure(groupID); err != nil {
+				log.Printf("删除分组 %s 的路由失败状态失败: %v", groupID, err)
+			}
+			continue
+		}
+		if err := op.store.SaveRouterFailure(*op.record); err != nil {
+			log.Printf("保存分组 %s 的路由失败状态失败: %v", groupID, err)
+		}
+	}
+}
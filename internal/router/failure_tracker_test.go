@@ -0,0 +1,175 @@
+package router
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"turnsapi/internal"
+	"turnsapi/internal/database"
+	"turnsapi/internal/providers"
+)
+
+// memoryFailureStore 内存中的失败状态存储
+type memoryFailureStore struct {
+	mu      sync.Mutex
+	records map[string]database.RouterFailureRecord
+}
+
+func newMemoryFailureStore() *memoryFailureStore {
+	return &memoryFailureStore{records: make(map[string]database.RouterFailureRecord)}
+}
+
+func (s *memoryFailureStore) LoadRouterFailures() ([]database.RouterFailureRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]database.RouterFailureRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *memoryFailureStore) SaveRouterFailure(record database.RouterFailureRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.GroupID] = record
+	return nil
+}
+
+func (s *memoryFailureStore) DeleteRouterFailure(groupID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, groupID)
+	return nil
+}
+
+// newTestFailureTracker 创建使用可控时钟的失败跟踪器
+func newTestFailureTracker() (*FailureTracker, *time.Time) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ft := NewFailureTracker(&internal.RouterFailureSettings{
+		HalfLife:       10 * time.Minute,
+		BlockThreshold: 3,
+		BlockDuration:  5 * time.Minute,
+	})
+	ft.now = func() time.Time { return now }
+	return ft, &now
+}
+
+// TestFailureTrackerBlockAndDecay 测试失败计数达到阈值后屏蔽分组，屏蔽到期和成功后解除
+func TestFailureTrackerBlockAndDecay(t *testing.T) {
+	ft, now := newTestFailureTracker()
+
+	ft.RecordFailure("group1", "boom")
+	ft.RecordFailure("group1", "boom")
+	if ft.IsBlocked("group1") {
+		t.Fatal("失败计数未达到阈值时不应屏蔽")
+	}
+	ft.RecordFailure("group1", "boom")
+	if !ft.IsBlocked("group1") {
+		t.Fatal("失败计数达到阈值时应屏蔽")
+	}
+
+	*now = now.Add(6 * time.Minute)
+	if ft.IsBlocked("group1") {
+		t.Error("屏蔽时间到期后应解除屏蔽")
+	}
+
+	// 经过一个半衰期失败计数减半
+	*now = now.Add(4 * time.Minute)
+	if score := ft.States()[0].FailureScore; score != 1.5 {
+		t.Errorf("经过一个半衰期后失败计数应为1.5，实际为 %v", score)
+	}
+
+	ft.RecordFailure("group1", "boom")
+	ft.RecordFailure("group1", "boom")
+	if !ft.IsBlocked("group1") {
+		t.Fatal("衰减后的失败计数再次达到阈值时应屏蔽")
+	}
+	ft.RecordSuccess("group1")
+	if ft.IsBlocked("group1") {
+		t.Error("成功后应解除屏蔽")
+	}
+}
+
+// TestFailureTrackerSort 测试按分档后的失败计数排序，同一档内保持原顺序，屏蔽的分组排在最后
+func TestFailureTrackerSort(t *testing.T) {
+	ft, _ := newTestFailureTracker()
+
+	// 失败计数: a=0, b=0.4, c=0.8, d=1.1, e 屏蔽
+	ft.entries = map[string]*failureEntry{
+		"b": {score: 0.4, updatedAt: ft.now()},
+		"c": {score: 0.8, updatedAt: ft.now()},
+		"d": {score: 1.1, updatedAt: ft.now()},
+		"e": {score: 0.1, updatedAt: ft.now(), blockedUntil: ft.now().Add(time.Minute)},
+	}
+
+	got := ft.Sort([]string{"e", "d", "c", "b", "a"})
+	want := []string{"b", "a", "c", "d", "e"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Sort() = %v, want %v", got, want)
+	}
+
+	// 排序结果与输入顺序无关（除同一档内的相对顺序）
+	got = ft.Sort([]string{"a", "b", "c", "d", "e"})
+	want = []string{"a", "b", "c", "d", "e"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Sort() = %v, want %v", got, want)
+	}
+}
+
+// TestFailureTrackerPersistOrder 测试持久化写入按发生顺序落地，最终保存的是最新状态
+func TestFailureTrackerPersistOrder(t *testing.T) {
+	ft, now := newTestFailureTracker()
+	store := newMemoryFailureStore()
+	if err := ft.SetStore(store); err != nil {
+		t.Fatalf("设置存储失败: %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		ft.RecordFailure("group1", "boom")
+		*now = now.Add(time.Second)
+	}
+	ft.RecordSuccess("group1")
+	ft.RecordFailure("group2", "boom")
+	ft.Reset("group2")
+	ft.flushPending()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	record, exists := store.records["group1"]
+	if !exists {
+		t.Fatal("group1 的失败状态应被保存")
+	}
+	if !record.BlockedUntil.IsZero() {
+		t.Errorf("保存的应是成功后解除屏蔽的最新状态，得到 %+v", record)
+	}
+	if state := ft.States()[0]; state.FailureScore != math.Round(record.Score*100)/100 {
+		t.Errorf("保存的失败计数 %v 与内存中的 %v 不一致", record.Score, state.FailureScore)
+	}
+	if _, exists := store.records["group2"]; exists {
+		t.Error("重置后的分组不应保留失败状态")
+	}
+}
+
+// TestRecordGroupFailureTruncatesByRune 测试错误信息按字符截断，不截断多字节字符
+func TestRecordGroupFailureTruncatesByRune(t *testing.T) {
+	pr := NewProviderRouter(&internal.Config{}, providers.NewProviderManager(providers.NewDefaultProviderFactory()))
+
+	pr.RecordGroupFailure("group1", errors.New("x"+strings.Repeat("错", 300)))
+	states := pr.GetFailureStates()
+	if len(states) != 1 {
+		t.Fatalf("应有一个分组的失败状态，得到 %d", len(states))
+	}
+	if !utf8.ValidString(states[0].LastError) {
+		t.Errorf("截断后的错误信息不是有效的UTF-8: %q", states[0].LastError)
+	}
+	if count := utf8.RuneCountInString(states[0].LastError); count != 200 {
+		t.Errorf("错误信息应截断为200个字符，实际为 %d", count)
+	}
+}
@@ -2,8 +2,11 @@ package router
 
 import (
 	"fmt"
+	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"turnsapi/internal"
 	"turnsapi/internal/providers"
@@ -12,55 +15,65 @@ import (
 
 // ProviderRouter 提供商路由器
 type ProviderRouter struct {
-	config          *internal.Config
+	config          internal.ConfigSource
 	providerManager *providers.ProviderManager
 	proxyKeyManager *proxykey.Manager
+	failureTracker  *FailureTracker
 	mutex           sync.RWMutex
+
+	// unhealthyGroups 主动健康探测判定为不健康的分组 -> 最近一次探测错误，恢复前不参与路由
+	unhealthyGroups map[string]string
+
+	// responseObserver 上游响应观察者，创建提供商配置时按分组绑定
+	responseObserver func(groupID, apiKey string, statusCode int, header http.Header)
 }
 
 // NewProviderRouter 创建提供商路由器
-func NewProviderRouter(config *internal.Config, providerManager *providers.ProviderManager) *ProviderRouter {
+func NewProviderRouter(config internal.ConfigSource, providerManager *providers.ProviderManager) *ProviderRouter {
 	return &ProviderRouter{
 		config:          config,
 		providerManager: providerManager,
+		failureTracker:  NewFailureTracker(routerFailureSettings(config.Snapshot())),
 	}
 }
 
 // NewProviderRouterWithProxyKey 创建带代理密钥管理器的提供商路由器
-func NewProviderRouterWithProxyKey(config *internal.Config, providerManager *providers.ProviderManager, proxyKeyManager *proxykey.Manager) *ProviderRouter {
+func NewProviderRouterWithProxyKey(config internal.ConfigSource, providerManager *providers.ProviderManager, proxyKeyManager *proxykey.Manager) *ProviderRouter {
 	return &ProviderRouter{
 		config:          config,
 		providerManager: providerManager,
 		proxyKeyManager: proxyKeyManager,
+		failureTracker:  NewFailureTracker(routerFailureSettings(config.Snapshot())),
 	}
 }
 
 // RouteRequest 路由请求结构
 type RouteRequest struct {
 	Model             string   `json:"model"`
-	ProviderGroup     string   `json:"provider_group,omitempty"`     // 可选的显式提供商分组
-	AllowedGroups     []string `json:"allowed_groups,omitempty"`     // 代理密钥允许访问的分组
-	ProxyKeyID        string   `json:"proxy_key_id,omitempty"`       // 代理密钥ID，用于分组选择
+	ProviderGroup     string   `json:"provider_group,omitempty"`      // 可选的显式提供商分组
+	AllowedGroups     []string `json:"allowed_groups,omitempty"`      // 代理密钥允许访问的分组
+	ProxyKeyID        string   `json:"proxy_key_id,omitempty"`        // 代理密钥ID，用于分组选择
 	ForceProviderType string   `json:"force_provider_type,omitempty"` // 强制指定提供商类型
 }
 
 // RouteResult 路由结果
 type RouteResult struct {
-	GroupID      string
-	Group        *internal.UserGroup
-	Provider     providers.Provider
+	GroupID        string
+	Group          *internal.UserGroup
+	Provider       providers.Provider
 	ProviderConfig *providers.ProviderConfig
 }
 
 // Route 根据请求路由到合适的提供商
 func (pr *ProviderRouter) Route(req *RouteRequest) (*RouteResult, error) {
+	config := pr.config.Snapshot()
 	var group *internal.UserGroup
 	var groupID string
 
 	// 1. 如果显式指定了提供商分组，优先使用
 	if req.ProviderGroup != "" {
 		var exists bool
-		group, exists = pr.config.GetGroupByID(req.ProviderGroup)
+		group, exists = config.GetGroupByID(req.ProviderGroup)
 		if !exists {
 			return nil, fmt.Errorf("specified provider group '%s' not found", req.ProviderGroup)
 		}
@@ -84,7 +97,7 @@ func (pr *ProviderRouter) Route(req *RouteRequest) (*RouteResult, error) {
 			}
 
 			// 验证选择的分组是否存在且启用
-			selectedGroup, exists := pr.config.GetGroupByID(selectedGroupID)
+			selectedGroup, exists := config.GetGroupByID(selectedGroupID)
 			if !exists {
 				return nil, fmt.Errorf("selected group '%s' not found", selectedGroupID)
 			}
@@ -96,7 +109,7 @@ func (pr *ProviderRouter) Route(req *RouteRequest) (*RouteResult, error) {
 			groupID = selectedGroupID
 		} else {
 			// 传统的模型匹配路由
-			group, groupID = pr.routeByModelWithPermissions(req.Model, req.AllowedGroups)
+			group, groupID = pr.routeByModelWithPermissions(config, req.Model, req.AllowedGroups)
 			if group == nil {
 				return nil, fmt.Errorf("no suitable provider group found for model '%s' with current permissions", req.Model)
 			}
@@ -125,12 +138,14 @@ func (pr *ProviderRouter) Route(req *RouteRequest) (*RouteResult, error) {
 
 // routeByModel 根据模型名称路由
 func (pr *ProviderRouter) routeByModel(modelName string) (*internal.UserGroup, string) {
+	config := pr.config.Snapshot()
+
 	// 1. 首先检查是否有分组明确支持该模型
-	for groupID, group := range pr.config.UserGroups {
+	for groupID, group := range config.UserGroups {
 		if !group.Enabled {
 			continue
 		}
-		
+
 		// 如果分组指定了模型列表，检查是否包含该模型
 		if len(group.Models) > 0 {
 			for _, model := range group.Models {
@@ -142,11 +157,11 @@ func (pr *ProviderRouter) routeByModel(modelName string) (*internal.UserGroup, s
 	}
 
 	// 2. 如果没有明确支持，尝试基于模型名称的模式匹配
-	return pr.routeByModelPattern(modelName)
+	return pr.routeByModelPattern(config, modelName)
 }
 
 // routeByModelPattern 根据模型名称模式路由
-func (pr *ProviderRouter) routeByModelPattern(modelName string) (*internal.UserGroup, string) {
+func (pr *ProviderRouter) routeByModelPattern(config *internal.Config, modelName string) (*internal.UserGroup, string) {
 	modelLower := strings.ToLower(modelName)
 
 	// 定义模型名称模式到提供商类型的映射
@@ -170,11 +185,11 @@ func (pr *ProviderRouter) routeByModelPattern(modelName string) (*internal.UserG
 
 	if targetProviderType == "" {
 		// 如果没有匹配的模式，返回第一个启用的分组
-		return pr.getFirstEnabledGroup()
+		return pr.getFirstEnabledGroup(config)
 	}
 
 	// 查找匹配提供商类型的分组
-	for groupID, group := range pr.config.UserGroups {
+	for groupID, group := range config.UserGroups {
 		if group.Enabled && group.ProviderType == targetProviderType {
 			// 如果分组没有指定模型列表，或者模型列表为空，则认为支持所有该类型的模型
 			if len(group.Models) == 0 {
@@ -184,12 +199,12 @@ func (pr *ProviderRouter) routeByModelPattern(modelName string) (*internal.UserG
 	}
 
 	// 如果没有找到匹配的分组，返回第一个启用的分组
-	return pr.getFirstEnabledGroup()
+	return pr.getFirstEnabledGroup(config)
 }
 
 // getFirstEnabledGroup 获取第一个启用的分组
-func (pr *ProviderRouter) getFirstEnabledGroup() (*internal.UserGroup, string) {
-	for groupID, group := range pr.config.UserGroups {
+func (pr *ProviderRouter) getFirstEnabledGroup(config *internal.Config) (*internal.UserGroup, string) {
+	for groupID, group := range config.UserGroups {
 		if group.Enabled {
 			return group, groupID
 		}
@@ -199,21 +214,35 @@ func (pr *ProviderRouter) getFirstEnabledGroup() (*internal.UserGroup, string) {
 
 // createProviderConfig 创建提供商配置
 func (pr *ProviderRouter) createProviderConfig(groupID string, group *internal.UserGroup) (*providers.ProviderConfig, error) {
-	if len(group.APIKeys) == 0 {
+	if len(group.APIKeys) == 0 && !group.IsKeyless() {
 		return nil, fmt.Errorf("no API keys configured for group '%s'", groupID)
 	}
 
 	// 这里暂时使用第一个API密钥，实际使用时会通过KeyManager获取
-	apiKey := group.APIKeys[0]
+	apiKey := group.FirstAPIKey()
 
 	config := &providers.ProviderConfig{
-		BaseURL:       group.BaseURL,
-		APIKey:        apiKey,
-		Timeout:       group.Timeout,
-		MaxRetries:    group.MaxRetries,
-		Headers:       make(map[string]string),
-		ProviderType:  group.ProviderType,
-		RequestParams: make(map[string]interface{}),
+		BaseURL:             group.BaseURL,
+		APIKey:              apiKey,
+		Timeout:             group.Timeout,
+		MaxRetries:          group.MaxRetries,
+		Headers:             make(map[string]string),
+		ProviderType:        group.ProviderType,
+		RequestParams:       make(map[string]interface{}),
+		ChatCompletionsPath: group.ChatCompletionsPath,
+		ModelsPath:          group.ModelsPath,
+		ProxyURL:            group.ProxyURL,
+		TLS:                 group.TLS.ProviderOptions(),
+		VertexAI:            group.VertexAI.ProviderOptions(),
+		Transport:           group.Transport.ProviderOptions(),
+		HealthCheckModel:    group.ProviderHealthCheckModel(),
+		IncludeReasoning:    group.IncludeReasoning,
+		DefaultMaxTokens:    group.DefaultMaxTokens,
+	}
+	if observer := pr.responseObserver; observer != nil {
+		config.ResponseObserver = func(apiKey string, statusCode int, header http.Header) {
+			observer(groupID, apiKey, statusCode, header)
+		}
 	}
 
 	// 复制头部信息
@@ -231,20 +260,25 @@ func (pr *ProviderRouter) createProviderConfig(groupID string, group *internal.U
 
 // GetGroupsForModel 获取支持特定模型的所有分组（按优先级排序，仅限于允许的分组范围内）
 func (pr *ProviderRouter) GetGroupsForModel(modelName string, allowedGroups []string) []string {
+	return pr.groupsForModel(pr.config.Snapshot(), modelName, allowedGroups)
+}
+
+// groupsForModel 在指定配置快照中查找支持特定模型的分组
+func (pr *ProviderRouter) groupsForModel(config *internal.Config, modelName string, allowedGroups []string) []string {
 	pr.mutex.RLock()
 	defer pr.mutex.RUnlock()
 
 	var candidateGroups []string
 
 	// 获取有权限访问的分组列表
-	accessibleGroups := pr.getAccessibleGroups(allowedGroups)
+	accessibleGroups := pr.getAccessibleGroups(config, allowedGroups)
 	if len(accessibleGroups) == 0 {
 		return candidateGroups // 返回空列表
 	}
 
 	// 1. 首先检查明确支持该模型的分组（仅在允许的分组范围内）
 	for _, groupID := range accessibleGroups {
-		group := pr.config.UserGroups[groupID]
+		group := config.UserGroups[groupID]
 		if !group.Enabled {
 			continue
 		}
@@ -270,6 +304,12 @@ func (pr *ProviderRouter) GetGroupsForModel(modelName string, allowedGroups []st
 			}
 		}
 
+		// 检查是否有重写规则匹配该模型
+		if !modelSupported {
+			_, ruleIndex := group.RewriteModel(modelName)
+			modelSupported = ruleIndex >= 0
+		}
+
 		if modelSupported {
 			candidateGroups = append(candidateGroups, groupID)
 		}
@@ -280,7 +320,7 @@ func (pr *ProviderRouter) GetGroupsForModel(modelName string, allowedGroups []st
 		targetProviderType := pr.inferProviderTypeFromModel(modelName)
 		if targetProviderType != "" {
 			for _, groupID := range accessibleGroups {
-				group := pr.config.UserGroups[groupID]
+				group := config.UserGroups[groupID]
 				if !group.Enabled {
 					continue
 				}
@@ -295,17 +335,79 @@ func (pr *ProviderRouter) GetGroupsForModel(modelName string, allowedGroups []st
 		}
 	}
 
-	// 3. 按失败次数排序（失败次数少的优先）
+	// 3. 排除主动健康探测判定为不健康的分组
+	candidateGroups = pr.excludeUnhealthyGroups(candidateGroups)
+
+	// 4. 按失败次数排序（失败次数少的优先）
 	return pr.sortGroupsByFailureCount(modelName, candidateGroups)
 }
 
+// GetBatchGroups 获取支持批处理接口且有权限访问的分组（排除不健康的分组，按失败次数排序）
+func (pr *ProviderRouter) GetBatchGroups(allowedGroups []string) []string {
+	config := pr.config.Snapshot()
+	pr.mutex.RLock()
+	defer pr.mutex.RUnlock()
+
+	var candidateGroups []string
+	for _, groupID := range pr.getAccessibleGroups(config, allowedGroups) {
+		if providers.SupportsBatches(config.UserGroups[groupID].ProviderType) {
+			candidateGroups = append(candidateGroups, groupID)
+		}
+	}
+	sort.Strings(candidateGroups)
+	candidateGroups = pr.excludeUnhealthyGroups(candidateGroups)
+	return pr.sortGroupsByFailureCount("", candidateGroups)
+}
+
+// excludeUnhealthyGroups 排除主动健康探测判定为不健康的分组，调用方需持有读锁
+// 所有候选分组都不健康时保留原列表，探测本身出问题时不至于拒绝全部请求
+func (pr *ProviderRouter) excludeUnhealthyGroups(groups []string) []string {
+	if len(pr.unhealthyGroups) == 0 {
+		return groups
+	}
+	healthy := make([]string, 0, len(groups))
+	for _, groupID := range groups {
+		if _, unhealthy := pr.unhealthyGroups[groupID]; !unhealthy {
+			healthy = append(healthy, groupID)
+		}
+	}
+	if len(healthy) == 0 {
+		return groups
+	}
+	return healthy
+}
+
+// SetGroupHealthy 设置分组的主动健康探测结果，不健康的分组在恢复前不参与路由
+func (pr *ProviderRouter) SetGroupHealthy(groupID string, healthy bool, lastError string) {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+
+	if healthy {
+		delete(pr.unhealthyGroups, groupID)
+		return
+	}
+	if pr.unhealthyGroups == nil {
+		pr.unhealthyGroups = make(map[string]string)
+	}
+	pr.unhealthyGroups[groupID] = lastError
+}
+
+// IsGroupHealthy 分组是否未被主动健康探测判定为不健康
+func (pr *ProviderRouter) IsGroupHealthy(groupID string) bool {
+	pr.mutex.RLock()
+	defer pr.mutex.RUnlock()
+
+	_, unhealthy := pr.unhealthyGroups[groupID]
+	return !unhealthy
+}
+
 // getAccessibleGroups 获取有权限访问的分组列表
-func (pr *ProviderRouter) getAccessibleGroups(allowedGroups []string) []string {
+func (pr *ProviderRouter) getAccessibleGroups(config *internal.Config, allowedGroups []string) []string {
 	var accessibleGroups []string
 
 	// 如果allowedGroups为空或nil，表示可以访问所有分组
 	if len(allowedGroups) == 0 {
-		for groupID, group := range pr.config.UserGroups {
+		for groupID, group := range config.UserGroups {
 			if group.Enabled {
 				accessibleGroups = append(accessibleGroups, groupID)
 			}
@@ -315,7 +417,7 @@ func (pr *ProviderRouter) getAccessibleGroups(allowedGroups []string) []string {
 
 	// 否则只返回允许访问的分组
 	for _, groupID := range allowedGroups {
-		if group, exists := pr.config.UserGroups[groupID]; exists && group.Enabled {
+		if group, exists := config.UserGroups[groupID]; exists && group.Enabled {
 			accessibleGroups = append(accessibleGroups, groupID)
 		}
 	}
@@ -323,21 +425,18 @@ func (pr *ProviderRouter) getAccessibleGroups(allowedGroups []string) []string {
 	return accessibleGroups
 }
 
-// ResolveModelName 解析模型名称，将别名转换为实际的模型名称
+// ResolveModelName 解析模型名称，将别名或匹配重写规则的名称转换为实际的模型名称
 func (pr *ProviderRouter) ResolveModelName(modelName, groupID string) string {
-	if group, exists := pr.config.UserGroups[groupID]; exists {
-		// 检查是否有模型映射
-		if actualModel, hasMapped := group.ModelMappings[modelName]; hasMapped {
-			return actualModel
-		}
+	if group, exists := pr.config.Snapshot().UserGroups[groupID]; exists {
+		return group.ResolveModel(modelName).Model
 	}
-	// 如果没有映射，返回原始模型名称
+	// 分组不存在时返回原始模型名称
 	return modelName
 }
 
 // GetModelAliases 获取分组中所有模型的别名列表（用于前端显示）
 func (pr *ProviderRouter) GetModelAliases(groupID string) []string {
-	if group, exists := pr.config.UserGroups[groupID]; exists {
+	if group, exists := pr.config.Snapshot().UserGroups[groupID]; exists {
 		var aliases []string
 
 		// 添加原始模型名称
@@ -363,10 +462,132 @@ func (pr *ProviderRouter) GetModelAliases(groupID string) []string {
 	return []string{}
 }
 
-// sortGroupsByFailureCount 按失败次数对分组进行排序（已移除失败跟踪，现在只返回原始顺序）
+// ModelRouteGroup 试运行中单个分组对请求模型的处理结果
+type ModelRouteGroup struct {
+	GroupID       string `json:"group_id"`
+	GroupName     string `json:"group_name"`
+	Enabled       bool   `json:"enabled"`
+	Accessible    bool   `json:"accessible"`          // 是否在允许访问的分组范围内
+	Candidate     bool   `json:"candidate"`           // 是否为路由候选分组
+	Unhealthy     bool   `json:"unhealthy,omitempty"` // 主动健康探测判定为不健康，暂不参与路由
+	UpstreamModel string `json:"upstream_model"`
+	Source        string `json:"source,omitempty"`  // mapping：别名映射；rewrite：正则重写
+	RuleIndex     int    `json:"rule_index"`        // 命中的重写规则下标，未命中为-1
+	Pattern       string `json:"pattern,omitempty"` // 命中的重写规则表达式
+}
+
+// ModelRoutePreview 模型名称重写和路由的试运行结果
+type ModelRoutePreview struct {
+	Model           string            `json:"model"`
+	CandidateGroups []string          `json:"candidate_groups"` // 按路由优先级排序
+	RoutedGroup     string            `json:"routed_group,omitempty"`
+	UpstreamModel   string            `json:"upstream_model,omitempty"`
+	Groups          []ModelRouteGroup `json:"groups"`
+}
+
+// PreviewModelRoute 在指定配置快照中试运行模型名称的解析和路由，不创建提供商实例也不发送请求
+func (pr *ProviderRouter) PreviewModelRoute(config *internal.Config, modelName string, allowedGroups []string) *ModelRoutePreview {
+	preview := &ModelRoutePreview{
+		Model:           modelName,
+		CandidateGroups: pr.groupsForModel(config, modelName, allowedGroups),
+		Groups:          make([]ModelRouteGroup, 0, len(config.UserGroups)),
+	}
+	if preview.CandidateGroups == nil {
+		preview.CandidateGroups = []string{}
+	}
+
+	candidates := make(map[string]bool, len(preview.CandidateGroups))
+	for _, groupID := range preview.CandidateGroups {
+		candidates[groupID] = true
+		// 与 RouteWithRetry 一致，跳过无法创建提供商配置的分组
+		if group := config.UserGroups[groupID]; preview.RoutedGroup == "" && len(group.APIKeys) > 0 {
+			preview.RoutedGroup = groupID
+			preview.UpstreamModel = group.ResolveModel(modelName).Model
+		}
+	}
+
+	groupIDs := make([]string, 0, len(config.UserGroups))
+	for groupID := range config.UserGroups {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Strings(groupIDs)
+
+	for _, groupID := range groupIDs {
+		group := config.UserGroups[groupID]
+		resolution := group.ResolveModel(modelName)
+		result := ModelRouteGroup{
+			GroupID:       groupID,
+			GroupName:     group.Name,
+			Enabled:       group.Enabled,
+			Accessible:    pr.hasGroupAccess(allowedGroups, groupID),
+			Candidate:     candidates[groupID],
+			Unhealthy:     !pr.IsGroupHealthy(groupID),
+			UpstreamModel: resolution.Model,
+			Source:        resolution.Source,
+			RuleIndex:     resolution.RuleIndex,
+		}
+		if resolution.RuleIndex >= 0 {
+			result.Pattern = group.ModelRewrites[resolution.RuleIndex].Pattern
+		}
+		preview.Groups = append(preview.Groups, result)
+	}
+	return preview
+}
+
+// sortGroupsByFailureCount 按衰减后的失败次数对分组进行排序，被屏蔽的分组排在最后
 func (pr *ProviderRouter) sortGroupsByFailureCount(modelName string, groups []string) []string {
-	// 不再进行失败次数排序，直接返回原始分组顺序
-	return groups
+	return pr.failureTracker.Sort(groups)
+}
+
+// routerFailureSettings 读取路由失败跟踪配置
+func routerFailureSettings(config *internal.Config) *internal.RouterFailureSettings {
+	if config == nil || config.GlobalSettings == nil {
+		return nil
+	}
+	return config.GlobalSettings.RouterFailures
+}
+
+// SetFailureStore 设置路由失败状态的持久化存储
+func (pr *ProviderRouter) SetFailureStore(store FailureStore) error {
+	return pr.failureTracker.SetStore(store)
+}
+
+// SetResponseObserver 设置上游响应观察者，需在处理请求前调用
+func (pr *ProviderRouter) SetResponseObserver(observer func(groupID, apiKey string, statusCode int, header http.Header)) {
+	pr.responseObserver = observer
+}
+
+// SetSharedFailureStore 设置多实例共享的路由失败状态存储
+func (pr *ProviderRouter) SetSharedFailureStore(store SharedFailureStore, syncInterval time.Duration) error {
+	return pr.failureTracker.SetSharedStore(store, syncInterval)
+}
+
+// RecordGroupFailure 记录分组请求失败
+func (pr *ProviderRouter) RecordGroupFailure(groupID string, err error) {
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+		// 按字符截断，避免截断多字节字符
+		if runes := []rune(errMsg); len(runes) > 200 {
+			errMsg = string(runes[:200])
+		}
+	}
+	pr.failureTracker.RecordFailure(groupID, errMsg)
+}
+
+// RecordGroupSuccess 记录分组请求成功
+func (pr *ProviderRouter) RecordGroupSuccess(groupID string) {
+	pr.failureTracker.RecordSuccess(groupID)
+}
+
+// GetFailureStates 获取各分组当前的失败和屏蔽状态
+func (pr *ProviderRouter) GetFailureStates() []FailureState {
+	return pr.failureTracker.States()
+}
+
+// ResetGroupFailures 清除分组的失败状态，使其立即恢复参与路由
+func (pr *ProviderRouter) ResetGroupFailures(groupID string) {
+	pr.failureTracker.Reset(groupID)
 }
 
 // RouteWithRetry 智能路由，支持失败重试
@@ -376,9 +597,12 @@ func (pr *ProviderRouter) RouteWithRetry(req *RouteRequest) (*RouteResult, error
 		return pr.routeByForceProviderType(req)
 	}
 
+	// 同一次路由使用同一个配置快照
+	config := pr.config.Snapshot()
+
 	// 如果显式指定了提供商分组，直接使用
 	if req.ProviderGroup != "" {
-		group, exists := pr.config.UserGroups[req.ProviderGroup]
+		group, exists := config.UserGroups[req.ProviderGroup]
 		if !exists {
 			return nil, fmt.Errorf("specified provider group '%s' not found", req.ProviderGroup)
 		}
@@ -407,14 +631,14 @@ func (pr *ProviderRouter) RouteWithRetry(req *RouteRequest) (*RouteResult, error
 	}
 
 	// 获取支持该模型的所有分组（按优先级排序）
-	candidateGroups := pr.GetGroupsForModel(req.Model, req.AllowedGroups)
+	candidateGroups := pr.groupsForModel(config, req.Model, req.AllowedGroups)
 	if len(candidateGroups) == 0 {
 		return nil, fmt.Errorf("no suitable provider group found for model '%s' with current permissions", req.Model)
 	}
 
 	// 尝试每个候选分组
 	for _, groupID := range candidateGroups {
-		group := pr.config.UserGroups[groupID]
+		group := config.UserGroups[groupID]
 
 		// 创建提供商配置
 		providerConfig, err := pr.createProviderConfig(groupID, group)
@@ -439,16 +663,14 @@ func (pr *ProviderRouter) RouteWithRetry(req *RouteRequest) (*RouteResult, error
 	return nil, fmt.Errorf("no suitable provider group found for model '%s'", req.Model)
 }
 
-
-
 // GetAvailableGroups 获取所有可用的分组
 func (pr *ProviderRouter) GetAvailableGroups() map[string]*internal.UserGroup {
-	return pr.config.GetEnabledGroups()
+	return pr.config.Snapshot().GetEnabledGroups()
 }
 
 // GetGroupInfo 获取分组信息
 func (pr *ProviderRouter) GetGroupInfo(groupID string) (*internal.UserGroup, bool) {
-	return pr.config.GetGroupByID(groupID)
+	return pr.config.Snapshot().GetGroupByID(groupID)
 }
 
 // ValidateModel 验证模型是否被任何分组支持
@@ -475,9 +697,9 @@ func (pr *ProviderRouter) hasGroupAccess(allowedGroups []string, groupID string)
 }
 
 // routeByModelWithPermissions 根据模型名称和权限路由
-func (pr *ProviderRouter) routeByModelWithPermissions(modelName string, allowedGroups []string) (*internal.UserGroup, string) {
+func (pr *ProviderRouter) routeByModelWithPermissions(config *internal.Config, modelName string, allowedGroups []string) (*internal.UserGroup, string) {
 	// 首先尝试精确匹配模型
-	for groupID, group := range pr.config.UserGroups {
+	for groupID, group := range config.UserGroups {
 		if !group.Enabled {
 			continue
 		}
@@ -499,11 +721,11 @@ func (pr *ProviderRouter) routeByModelWithPermissions(modelName string, allowedG
 	targetProviderType := pr.inferProviderTypeFromModel(modelName)
 	if targetProviderType == "" {
 		// 如果无法推断，返回第一个有权限的启用分组
-		return pr.getFirstEnabledGroupWithPermissions(allowedGroups)
+		return pr.getFirstEnabledGroupWithPermissions(config, allowedGroups)
 	}
 
 	// 查找匹配提供商类型的分组
-	for groupID, group := range pr.config.UserGroups {
+	for groupID, group := range config.UserGroups {
 		if !group.Enabled {
 			continue
 		}
@@ -522,12 +744,12 @@ func (pr *ProviderRouter) routeByModelWithPermissions(modelName string, allowedG
 	}
 
 	// 如果没有找到匹配的分组，返回第一个有权限的启用分组
-	return pr.getFirstEnabledGroupWithPermissions(allowedGroups)
+	return pr.getFirstEnabledGroupWithPermissions(config, allowedGroups)
 }
 
 // getFirstEnabledGroupWithPermissions 获取第一个有权限的启用分组
-func (pr *ProviderRouter) getFirstEnabledGroupWithPermissions(allowedGroups []string) (*internal.UserGroup, string) {
-	for groupID, group := range pr.config.UserGroups {
+func (pr *ProviderRouter) getFirstEnabledGroupWithPermissions(config *internal.Config, allowedGroups []string) (*internal.UserGroup, string) {
+	for groupID, group := range config.UserGroups {
 		if group.Enabled && pr.hasGroupAccess(allowedGroups, groupID) {
 			return group, groupID
 		}
@@ -562,12 +784,12 @@ func (pr *ProviderRouter) inferProviderTypeFromModel(modelName string) string {
 // GetSupportedModels 获取所有支持的模型列表
 func (pr *ProviderRouter) GetSupportedModels() []string {
 	modelSet := make(map[string]bool)
-	
-	for _, group := range pr.config.UserGroups {
+
+	for _, group := range pr.config.Snapshot().UserGroups {
 		if !group.Enabled {
 			continue
 		}
-		
+
 		// 如果分组指定了模型列表，添加这些模型
 		if len(group.Models) > 0 {
 			for _, model := range group.Models {
@@ -575,23 +797,23 @@ func (pr *ProviderRouter) GetSupportedModels() []string {
 			}
 		}
 	}
-	
+
 	// 转换为切片
 	models := make([]string, 0, len(modelSet))
 	for model := range modelSet {
 		models = append(models, model)
 	}
-	
+
 	return models
 }
 
 // GetProviderTypeForGroup 获取分组的提供商类型
 func (pr *ProviderRouter) GetProviderTypeForGroup(groupID string) (string, error) {
-	group, exists := pr.config.GetGroupByID(groupID)
+	group, exists := pr.config.Snapshot().GetGroupByID(groupID)
 	if !exists {
 		return "", fmt.Errorf("group '%s' not found", groupID)
 	}
-	
+
 	return group.ProviderType, nil
 }
 
@@ -613,14 +835,15 @@ func (pr *ProviderRouter) GetProviderManager() *providers.ProviderManager {
 // routeByForceProviderType 根据强制指定的提供商类型路由
 func (pr *ProviderRouter) routeByForceProviderType(req *RouteRequest) (*RouteResult, error) {
 	// 获取有权限访问的分组列表
-	accessibleGroups := pr.getAccessibleGroups(req.AllowedGroups)
+	config := pr.config.Snapshot()
+	accessibleGroups := pr.getAccessibleGroups(config, req.AllowedGroups)
 	if len(accessibleGroups) == 0 {
 		return nil, fmt.Errorf("no accessible groups for current permissions")
 	}
 
 	// 查找匹配指定提供商类型的分组
 	for _, groupID := range accessibleGroups {
-		group := pr.config.UserGroups[groupID]
+		group := config.UserGroups[groupID]
 		if !group.Enabled {
 			continue
 		}
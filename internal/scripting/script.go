@@ -0,0 +1,294 @@
+// Package scripting 使用Lua脚本在不重新编译的情况下转换分组的请求和响应
+//
+// 脚本定义全局函数 on_request(req, ctx) 和/或 on_response(resp, ctx)。req 和 resp 是按JSON结构转换的表，
+// 函数返回修改后的表（返回nil表示不修改），调用 error() 拒绝请求。ctx 包含分组和请求信息，
+// on_request 可以向 ctx.headers 写入额外发往上游的请求头。
+//
+// 脚本在沙箱中执行：只提供 base（不含文件加载和模块加载）、string、table 和 math 库，
+// 每次调用使用独立的Lua状态并受执行时间、栈大小、调用深度和 string.rep 结果长度限制。
+package scripting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"turnsapi/internal/providers"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// 脚本执行限制
+const (
+	DefaultTimeout = 50 * time.Millisecond // 单次调用默认的执行时间上限
+	MaxTimeout     = 5 * time.Second       // 可配置的执行时间上限
+
+	callStackSize   = 200       // 最大调用深度
+	registrySize    = 1024 * 4  // 初始数据栈大小
+	registryMaxSize = 1024 * 64 // 数据栈可以增长到的上限
+	maxStringRep    = 1 << 20   // string.rep 生成的字符串长度上限（字节）
+	maxValueDepth   = 64        // 返回值的最大嵌套层数
+)
+
+// 脚本入口函数名
+const (
+	requestFunction  = "on_request"
+	responseFunction = "on_response"
+)
+
+// removedGlobals 沙箱中移除的全局函数：文件和模块加载、动态编译、环境修改和调试输出
+var removedGlobals = []string{
+	"dofile", "loadfile", "load", "loadstring", "require", "module",
+	"collectgarbage", "getfenv", "setfenv", "newproxy", "_printregs", "print",
+}
+
+// Script 编译后的脚本，可以并发调用
+type Script struct {
+	name        string
+	proto       *lua.FunctionProto
+	timeout     time.Duration
+	hasRequest  bool
+	hasResponse bool
+}
+
+// Info 传给脚本的请求信息
+type Info struct {
+	GroupID      string
+	ProviderType string
+	ProxyKeyID   string
+	RequestID    string
+}
+
+// Compile 编译脚本并检查入口函数，timeout 为0时使用 DefaultTimeout
+// 脚本至少需要定义 on_request 和 on_response 中的一个
+func Compile(name, source string, timeout time.Duration) (*Script, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if timeout > MaxTimeout {
+		return nil, fmt.Errorf("script timeout must not exceed %v", MaxTimeout)
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse script: %w", err)
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile script: %w", err)
+	}
+
+	script := &Script{name: name, proto: proto, timeout: timeout}
+	L, cancel, err := script.load(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	defer L.Close()
+
+	script.hasRequest = L.GetGlobal(requestFunction).Type() == lua.LTFunction
+	script.hasResponse = L.GetGlobal(responseFunction).Type() == lua.LTFunction
+	if !script.hasRequest && !script.hasResponse {
+		return nil, fmt.Errorf("script must define %s or %s", requestFunction, responseFunction)
+	}
+	return script, nil
+}
+
+// HasRequest 脚本是否定义了 on_request
+func (s *Script) HasRequest() bool {
+	return s != nil && s.hasRequest
+}
+
+// HasResponse 脚本是否定义了 on_response
+func (s *Script) HasResponse() bool {
+	return s != nil && s.hasResponse
+}
+
+// TransformRequest 调用 on_request 转换发往上游的请求，返回脚本写入 ctx.headers 的请求头
+// 脚本不能修改请求的 stream 参数
+func (s *Script) TransformRequest(ctx context.Context, req *providers.ChatCompletionRequest, info Info) (http.Header, error) {
+	if !s.HasRequest() {
+		return nil, nil
+	}
+	L, cancel, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	defer L.Close()
+
+	value, err := toLuaJSON(L, req)
+	if err != nil {
+		return nil, err
+	}
+	scriptCtx := s.contextTable(L, info)
+	result, err := s.call(L, requestFunction, value, scriptCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	if result != lua.LNil {
+		var transformed providers.ChatCompletionRequest
+		if err := fromLuaJSON(L, result, &transformed); err != nil {
+			return nil, fmt.Errorf("script %s returned an invalid request: %w", s.name, err)
+		}
+		transformed.Stream = req.Stream
+		*req = transformed
+	}
+	return headersFromTable(scriptCtx.RawGetString("headers")), nil
+}
+
+// TransformResponse 调用 on_response 转换上游返回的非流式响应
+func (s *Script) TransformResponse(ctx context.Context, resp *providers.ChatCompletionResponse, info Info) error {
+	if !s.HasResponse() {
+		return nil
+	}
+	L, cancel, err := s.load(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer L.Close()
+
+	value, err := toLuaJSON(L, resp)
+	if err != nil {
+		return err
+	}
+	result, err := s.call(L, responseFunction, value, s.contextTable(L, info))
+	if err != nil || result == lua.LNil {
+		return err
+	}
+
+	var transformed providers.ChatCompletionResponse
+	if err := fromLuaJSON(L, result, &transformed); err != nil {
+		return fmt.Errorf("script %s returned an invalid response: %w", s.name, err)
+	}
+	*resp = transformed
+	return nil
+}
+
+// load 创建沙箱Lua状态并执行脚本顶层代码，返回的cancel需要在关闭状态后调用
+func (s *Script) load(ctx context.Context) (*lua.LState, context.CancelFunc, error) {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:        true,
+		CallStackSize:       callStackSize,
+		RegistrySize:        registrySize,
+		RegistryMaxSize:     registryMaxSize,
+		MinimizeStackMemory: true,
+	})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range removedGlobals {
+		L.SetGlobal(name, lua.LNil)
+	}
+	if stringLib, ok := L.GetGlobal(lua.StringLibName).(*lua.LTable); ok {
+		stringLib.RawSetString("rep", L.NewFunction(limitedStringRep))
+	}
+	L.SetGlobal("log", L.NewFunction(s.scriptLog))
+
+	runCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	L.SetContext(runCtx)
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		L.Close()
+		cancel()
+		return nil, nil, s.wrapError(runCtx, err)
+	}
+	return L, cancel, nil
+}
+
+// call 调用脚本的入口函数，返回第一个返回值
+func (s *Script) call(L *lua.LState, function string, args ...lua.LValue) (lua.LValue, error) {
+	err := L.CallByParam(lua.P{Fn: L.GetGlobal(function), NRet: 1, Protect: true}, args...)
+	if err != nil {
+		return lua.LNil, s.wrapError(L.Context(), err)
+	}
+	result := L.Get(-1)
+	L.Pop(1)
+	if result != lua.LNil && result.Type() != lua.LTTable {
+		return lua.LNil, fmt.Errorf("script %s: %s must return a table or nil, got %s", s.name, function, result.Type())
+	}
+	return result, nil
+}
+
+// contextTable 创建传给入口函数的 ctx 表
+func (s *Script) contextTable(L *lua.LState, info Info) *lua.LTable {
+	table := L.NewTable()
+	table.RawSetString("group_id", lua.LString(info.GroupID))
+	table.RawSetString("provider_type", lua.LString(info.ProviderType))
+	table.RawSetString("proxy_key_id", lua.LString(info.ProxyKeyID))
+	table.RawSetString("request_id", lua.LString(info.RequestID))
+	table.RawSetString("headers", L.NewTable())
+	return table
+}
+
+// wrapError 将Lua错误转换为带脚本名称的错误，超时单独说明
+func (s *Script) wrapError(ctx context.Context, err error) error {
+	if ctx != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("script %s exceeded the time limit of %v", s.name, s.timeout)
+	}
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) && apiErr.Object != nil {
+		return fmt.Errorf("script %s: %s", s.name, apiErr.Object.String())
+	}
+	return fmt.Errorf("script %s: %w", s.name, err)
+}
+
+// scriptLog 脚本中的 log(...)，输出到服务日志
+func (s *Script) scriptLog(L *lua.LState) int {
+	parts := make([]string, 0, L.GetTop())
+	for i := 1; i <= L.GetTop(); i++ {
+		parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+	}
+	log.Printf("[script %s] %s", s.name, strings.Join(parts, " "))
+	return 0
+}
+
+// limitedStringRep 限制结果长度的 string.rep，避免脚本一次分配大量内存
+func limitedStringRep(L *lua.LState) int {
+	str := L.CheckString(1)
+	n := L.CheckInt(2)
+	if n <= 0 || str == "" {
+		L.Push(lua.LString(""))
+		return 1
+	}
+	if len(str) > maxStringRep/n {
+		L.RaiseError("string.rep result exceeds %d bytes", maxStringRep)
+		return 0
+	}
+	L.Push(lua.LString(strings.Repeat(str, n)))
+	return 1
+}
+
+// headersFromTable 读取脚本写入 ctx.headers 的请求头，只接受字符串值
+func headersFromTable(value lua.LValue) http.Header {
+	table, ok := value.(*lua.LTable)
+	if !ok {
+		return nil
+	}
+	header := make(http.Header)
+	table.ForEach(func(key, item lua.LValue) {
+		name, nameOK := key.(lua.LString)
+		text, textOK := item.(lua.LString)
+		if nameOK && textOK && name != "" {
+			header.Set(string(name), string(text))
+		}
+	})
+	return header
+}
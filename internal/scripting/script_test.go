@@ -0,0 +1,130 @@
+package scripting
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"turnsapi/internal/providers"
+)
+
+// TestTransformRequest 测试脚本修改请求、添加请求头，且不能修改 stream 参数
+func TestTransformRequest(t *testing.T) {
+	script, err := Compile("test", `
+function on_request(req, ctx)
+  req.model = "rewritten-" .. req.model
+  req.stream = false
+  table.insert(req.messages, 1, {role = "system", content = "group " .. ctx.group_id})
+  ctx.headers["X-Tenant"] = ctx.proxy_key_id
+  return req
+end`, 0)
+	if err != nil {
+		t.Fatalf("编译脚本失败: %v", err)
+	}
+	if !script.HasRequest() || script.HasResponse() {
+		t.Fatalf("入口函数检测不正确")
+	}
+
+	req := &providers.ChatCompletionRequest{
+		Model:    "gpt-4o",
+		Stream:   true,
+		Messages: []providers.ChatMessage{{Role: "user", Content: "hi"}},
+	}
+	header, err := script.TransformRequest(context.Background(), req, Info{GroupID: "openai", ProxyKeyID: "key-1"})
+	if err != nil {
+		t.Fatalf("执行脚本失败: %v", err)
+	}
+	if req.Model != "rewritten-gpt-4o" || !req.Stream {
+		t.Errorf("请求转换不正确: model=%s stream=%v", req.Model, req.Stream)
+	}
+	if len(req.Messages) != 2 || req.Messages[0].Role != "system" || req.Messages[0].Content != "group openai" {
+		t.Errorf("消息转换不正确: %+v", req.Messages)
+	}
+	if header.Get("X-Tenant") != "key-1" {
+		t.Errorf("请求头不正确: %v", header)
+	}
+}
+
+// TestTransformRejects 测试脚本调用 error() 拒绝请求，返回nil时不修改请求
+func TestTransformRejects(t *testing.T) {
+	script, err := Compile("test", `
+function on_request(req, ctx)
+  if req.model == "blocked" then error("model not allowed", 0) end
+  return nil
+end`, 0)
+	if err != nil {
+		t.Fatalf("编译脚本失败: %v", err)
+	}
+
+	req := &providers.ChatCompletionRequest{Model: "gpt-4o"}
+	if _, err := script.TransformRequest(context.Background(), req, Info{}); err != nil || req.Model != "gpt-4o" {
+		t.Errorf("返回nil时不应修改请求: model=%s err=%v", req.Model, err)
+	}
+	_, err = script.TransformRequest(context.Background(), &providers.ChatCompletionRequest{Model: "blocked"}, Info{})
+	if err == nil || !strings.Contains(err.Error(), "model not allowed") {
+		t.Errorf("拒绝请求的错误不正确: %v", err)
+	}
+}
+
+// TestSandboxLimits 测试沙箱移除文件和模块加载函数，并限制执行时间和 string.rep 长度
+func TestSandboxLimits(t *testing.T) {
+	if _, err := Compile("test", `function helper() end`, 0); err == nil {
+		t.Errorf("未定义入口函数的脚本应编译失败")
+	}
+	if _, err := Compile("test", `function on_request(`, 0); err == nil {
+		t.Errorf("语法错误的脚本应编译失败")
+	}
+
+	cases := map[string]string{
+		"dofile":     `function on_request(req) dofile("/etc/passwd") end`,
+		"require":    `function on_request(req) require("os") end`,
+		"os":         `function on_request(req) os.exit(1) end`,
+		"string.rep": `function on_request(req) local s = string.rep("x", 1024 * 1024 * 1024) end`,
+	}
+	for name, source := range cases {
+		script, err := Compile("test", source, 0)
+		if err != nil {
+			t.Fatalf("%s: 编译脚本失败: %v", name, err)
+		}
+		if _, err := script.TransformRequest(context.Background(), &providers.ChatCompletionRequest{}, Info{}); err == nil {
+			t.Errorf("%s: 沙箱中应执行失败", name)
+		}
+	}
+
+	script, err := Compile("test", `function on_request(req) while true do end end`, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("编译脚本失败: %v", err)
+	}
+	start := time.Now()
+	_, err = script.TransformRequest(context.Background(), &providers.ChatCompletionRequest{}, Info{})
+	if err == nil || !strings.Contains(err.Error(), "time limit") {
+		t.Errorf("死循环应超时: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("超时后未及时停止: %v", elapsed)
+	}
+}
+
+// TestTransformResponse 测试脚本修改非流式响应
+func TestTransformResponse(t *testing.T) {
+	script, err := Compile("test", `
+function on_response(resp, ctx)
+  resp.choices[1].message.content = string.upper(resp.choices[1].message.content)
+  return resp
+end`, 0)
+	if err != nil {
+		t.Fatalf("编译脚本失败: %v", err)
+	}
+
+	resp := &providers.ChatCompletionResponse{
+		ID:      "resp-1",
+		Choices: []providers.ChatCompletionChoice{{Message: providers.ChatCompletionMessage{Role: "assistant", Content: "hello"}}},
+	}
+	if err := script.TransformResponse(context.Background(), resp, Info{}); err != nil {
+		t.Fatalf("执行脚本失败: %v", err)
+	}
+	if resp.ID != "resp-1" || resp.Choices[0].Message.Content != "HELLO" {
+		t.Errorf("响应转换不正确: %+v", resp)
+	}
+}
@@ -0,0 +1,139 @@
+package scripting
+
+import (
+	"encoding/json"
+	"fmt"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// jsonArrayMarker 元表中标记空表原本是JSON数组的字段，转换回JSON时保留为 []
+const jsonArrayMarker = "__jsonarray"
+
+// toLuaValue 将JSON解码后的值转换为Lua值，对象和数组都转换为表，数组下标从1开始
+func toLuaValue(L *lua.LState, value interface{}) lua.LValue {
+	switch v := value.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []interface{}:
+		table := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			table.Append(toLuaValue(L, item))
+		}
+		if len(v) == 0 {
+			meta := L.NewTable()
+			meta.RawSetString(jsonArrayMarker, lua.LTrue)
+			L.SetMetatable(table, meta)
+		}
+		return table
+	case map[string]interface{}:
+		table := L.CreateTable(0, len(v))
+		for key, item := range v {
+			table.RawSetString(key, toLuaValue(L, item))
+		}
+		return table
+	}
+	return lua.LNil
+}
+
+// fromLuaValue 将Lua值转换为可以编码为JSON的值
+// 键为 1..n 连续整数的表转换为数组，其他表转换为对象；空表转换为空对象，原本是空数组的保持为空数组
+func fromLuaValue(L *lua.LState, value lua.LValue, depth int) (interface{}, error) {
+	if depth > maxValueDepth {
+		return nil, fmt.Errorf("value nested too deeply")
+	}
+	switch v := value.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LNumber:
+		return float64(v), nil
+	case lua.LString:
+		return string(v), nil
+	case *lua.LTable:
+		return fromLuaTable(L, v, depth)
+	}
+	return nil, fmt.Errorf("unsupported value of type %s", value.Type())
+}
+
+// fromLuaTable 将Lua表转换为JSON数组或对象
+func fromLuaTable(L *lua.LState, table *lua.LTable, depth int) (interface{}, error) {
+	length := table.MaxN()
+	count := 0
+	table.ForEach(func(lua.LValue, lua.LValue) { count++ })
+
+	if count == 0 {
+		if meta, ok := L.GetMetatable(table).(*lua.LTable); ok && meta.RawGetString(jsonArrayMarker) == lua.LTrue {
+			return []interface{}{}, nil
+		}
+		return map[string]interface{}{}, nil
+	}
+
+	if length > 0 && length == count {
+		items := make([]interface{}, 0, length)
+		for i := 1; i <= length; i++ {
+			item, err := fromLuaValue(L, table.RawGetInt(i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+
+	object := make(map[string]interface{}, count)
+	var err error
+	table.ForEach(func(key, item lua.LValue) {
+		if err != nil {
+			return
+		}
+		var name string
+		switch k := key.(type) {
+		case lua.LString:
+			name = string(k)
+		case lua.LNumber:
+			name = k.String()
+		default:
+			err = fmt.Errorf("unsupported table key of type %s", key.Type())
+			return
+		}
+		object[name], err = fromLuaValue(L, item, depth+1)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// toLuaJSON 将结构体按JSON编码后转换为Lua表
+func toLuaJSON(L *lua.LState, value interface{}) (lua.LValue, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return lua.LNil, err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return lua.LNil, err
+	}
+	return toLuaValue(L, decoded), nil
+}
+
+// fromLuaJSON 将脚本返回的Lua表转换回结构体
+func fromLuaJSON(L *lua.LState, value lua.LValue, target interface{}) error {
+	decoded, err := fromLuaValue(L, value, 0)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(decoded)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// secretFilePrefix 从文件读取密钥的引用前缀，如 file:/run/secrets/openai_key
+const secretFilePrefix = "file:"
+
+// envRefPattern 环境变量引用，整个值为 ${ENV_VAR}
+var envRefPattern = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// IsSecretRef 判断API密钥是否为环境变量或文件引用
+func IsSecretRef(value string) bool {
+	value = strings.TrimSpace(value)
+	return envRefPattern.MatchString(value) || strings.HasPrefix(value, secretFilePrefix)
+}
+
+// ResolveSecretRef 解析API密钥引用：${ENV_VAR} 读取环境变量，file:/path 读取文件内容（去除首尾空白），其他值原样返回
+func ResolveSecretRef(value string) (string, error) {
+	ref := strings.TrimSpace(value)
+	if match := envRefPattern.FindStringSubmatch(ref); match != nil {
+		resolved, ok := os.LookupEnv(match[1])
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", match[1])
+		}
+		resolved = strings.TrimSpace(resolved)
+		if resolved == "" {
+			return "", fmt.Errorf("environment variable %s is empty", match[1])
+		}
+		return resolved, nil
+	}
+
+	if strings.HasPrefix(ref, secretFilePrefix) {
+		path := strings.TrimPrefix(ref, secretFilePrefix)
+		if path == "" {
+			return "", fmt.Errorf("secret file path is empty")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file %s: %w", path, err)
+		}
+		resolved := strings.TrimSpace(string(data))
+		if resolved == "" {
+			return "", fmt.Errorf("secret file %s is empty", path)
+		}
+		return resolved, nil
+	}
+
+	return value, nil
+}
+
+// ResolveAPIKeys 解析分组API密钥中的环境变量和文件引用
+// APIKeys 替换为实际密钥，引用记录在 APIKeyRefs 中，保存时通过 ConfiguredAPIKeys 写回引用，明文密钥不会落盘
+// 无法解析的引用从 APIKeys 中移除并记录在 UnresolvedAPIKeys 中，其余密钥照常解析，返回的错误包含所有解析失败的引用
+func (g *UserGroup) ResolveAPIKeys() error {
+	keys := make([]string, 0, len(g.APIKeys)+len(g.UnresolvedAPIKeys))
+	keys = append(keys, g.APIKeys...)
+	keys = append(keys, g.UnresolvedAPIKeys...)
+
+	resolvedKeys := make([]string, 0, len(keys))
+	refs := make(map[string]string)
+	var unresolved []string
+	var errs []error
+	for _, key := range keys {
+		if !IsSecretRef(key) {
+			resolvedKeys = append(resolvedKeys, key)
+			// 已解析过的密钥保留原来的引用
+			if ref, ok := g.APIKeyRefs[key]; ok {
+				refs[key] = ref
+			}
+			continue
+		}
+
+		resolved, err := ResolveSecretRef(key)
+		if err != nil {
+			unresolved = append(unresolved, key)
+			errs = append(errs, err)
+			continue
+		}
+		resolvedKeys = append(resolvedKeys, resolved)
+		refs[resolved] = key
+	}
+
+	g.APIKeys = resolvedKeys
+	g.APIKeyRefs = nil
+	if len(refs) > 0 {
+		g.APIKeyRefs = refs
+	}
+	g.UnresolvedAPIKeys = unresolved
+	return errors.Join(errs...)
+}
+
+// ConfiguredAPIKeys 返回配置中的API密钥，通过引用解析的密钥还原为引用，用于保存、导出和编辑
+func (g *UserGroup) ConfiguredAPIKeys() []string {
+	if len(g.APIKeyRefs) == 0 && len(g.UnresolvedAPIKeys) == 0 {
+		return g.APIKeys
+	}
+	keys := make([]string, 0, len(g.APIKeys)+len(g.UnresolvedAPIKeys))
+	for _, key := range g.APIKeys {
+		if ref, ok := g.APIKeyRefs[key]; ok {
+			key = ref
+		}
+		keys = append(keys, key)
+	}
+	return append(keys, g.UnresolvedAPIKeys...)
+}
+
+// ConfiguredAPIKey 将解析后的密钥还原为配置中的引用，不是引用时原样返回
+func (g *UserGroup) ConfiguredAPIKey(key string) string {
+	if ref, ok := g.APIKeyRefs[key]; ok {
+		return ref
+	}
+	return key
+}
+
+// ValidateSecretRefs 检查API密钥中的引用能否解析，用于保存分组前的校验
+func ValidateSecretRefs(keys []string) error {
+	var errs []error
+	for _, key := range keys {
+		if !IsSecretRef(key) {
+			continue
+		}
+		if _, err := ResolveSecretRef(key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SecretRefSettings 通过管理接口写入API密钥引用时的限制，只能在配置文件中设置
+// 配置文件中的引用不受限制；管理接口只允许管理员写入指向以下目录中文件或以下环境变量的引用
+type SecretRefSettings struct {
+	AllowedDirs []string `yaml:"allowed_dirs"` // 允许 file: 引用的密钥目录
+	AllowedEnv  []string `yaml:"allowed_env"`  // 允许 ${VAR} 引用的环境变量名
+}
+
+// ErrSecretRefForbidden 调用方不是管理员时写入新的API密钥引用
+var ErrSecretRefForbidden = errors.New("only admins can set API key references")
+
+// SecretRefPolicy 通过管理接口写入API密钥引用的权限
+type SecretRefPolicy struct {
+	Admin    bool               // 调用方是否为管理员
+	Settings *SecretRefSettings // 允许的目录和环境变量，为空时不允许写入新的引用
+}
+
+// Check 检查 keys 中新增的引用（previous 中已有的引用除外）是否允许写入且能够解析
+// 错误信息不包含读取失败的原因，避免通过接口探测文件是否存在；policy 为 nil 时（命令行导入等本地操作）只检查能否解析
+func (p *SecretRefPolicy) Check(keys, previous []string) error {
+	if p == nil {
+		return ValidateSecretRefs(keys)
+	}
+
+	var errs []error
+	for _, key := range keys {
+		if !IsSecretRef(key) || slices.Contains(previous, key) {
+			continue
+		}
+		if !p.Admin {
+			return ErrSecretRefForbidden
+		}
+		if !p.Settings.allows(key) {
+			errs = append(errs, fmt.Errorf("API key reference %s is not allowed", key))
+			continue
+		}
+		if _, err := ResolveSecretRef(key); err != nil {
+			errs = append(errs, fmt.Errorf("API key reference %s cannot be resolved", key))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// allows 判断引用是否指向允许的环境变量或密钥目录中的文件，符号链接按实际路径判断
+func (s *SecretRefSettings) allows(ref string) bool {
+	if s == nil {
+		return false
+	}
+	ref = strings.TrimSpace(ref)
+	if match := envRefPattern.FindStringSubmatch(ref); match != nil {
+		return slices.Contains(s.AllowedEnv, match[1])
+	}
+
+	path := strings.TrimPrefix(ref, secretFilePrefix)
+	if !filepath.IsAbs(path) {
+		return false
+	}
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	for _, dir := range s.AllowedDirs {
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		dir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(dir, path); err == nil && rel != "." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && rel != ".." {
+			return true
+		}
+	}
+	return false
+}
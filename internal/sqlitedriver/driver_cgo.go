@@ -0,0 +1,14 @@
+//go:build !sqlite_purego
+
+package sqlitedriver
+
+import _ "github.com/mattn/go-sqlite3"
+
+const (
+	// DriverName database/sql 注册的驱动名
+	DriverName = "sqlite3"
+	// Implementation 驱动实现，用于日志和状态展示
+	Implementation = "mattn/go-sqlite3 (cgo)"
+
+	dsnParams = "?_journal_mode=WAL&_busy_timeout=5000"
+)
@@ -0,0 +1,16 @@
+//go:build sqlite_purego
+
+package sqlitedriver
+
+// 纯Go实现的SQLite驱动，使用 go build -tags sqlite_purego 编译，无需cgo
+import _ "modernc.org/sqlite"
+
+const (
+	// DriverName database/sql 注册的驱动名
+	DriverName = "sqlite"
+	// Implementation 驱动实现，用于日志和状态展示
+	Implementation = "modernc.org/sqlite (pure Go)"
+
+	// _time_format=sqlite 使时间按SQLite格式写入，与cgo驱动写入的数据保持一致
+	dsnParams = "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+)
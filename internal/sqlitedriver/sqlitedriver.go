@@ -0,0 +1,33 @@
+// Package sqlitedriver 选择编译进二进制的SQLite驱动
+//
+// 默认使用基于cgo的 github.com/mattn/go-sqlite3；使用 -tags sqlite_purego 编译时改用纯Go实现的
+// modernc.org/sqlite，可在 CGO_ENABLED=0 下交叉编译出静态二进制（如ARM网关设备）。
+// 两种驱动读写的数据库文件格式相同，可以互相切换。
+package sqlitedriver
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path/filepath"
+)
+
+// Open 打开SQLite数据库文件，启用WAL使读写可以并发进行，并设置忙等待超时避免写入冲突时直接失败
+func Open(path string) (*sql.DB, error) {
+	db, err := sql.Open(DriverName, path+dsnParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database with %s driver: %w", Implementation, err)
+	}
+	return db, nil
+}
+
+// OpenReadOnly 以只读方式打开SQLite数据库文件，不修改日志模式，用于校验备份等不应写入的场景
+// 路径按URI转义，文件名中的 ?、# 和 % 不会被当作URI参数
+func OpenReadOnly(path string) (*sql.DB, error) {
+	dsn := "file:" + (&url.URL{Path: filepath.ToSlash(path)}).EscapedPath() + "?mode=ro"
+	db, err := sql.Open(DriverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database with %s driver: %w", Implementation, err)
+	}
+	return db, nil
+}
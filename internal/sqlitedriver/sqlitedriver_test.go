@@ -0,0 +1,80 @@
+package sqlitedriver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestOpen 测试打开的数据库启用WAL和忙等待超时，时间值可以原样读回
+func TestOpen(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	defer db.Close()
+
+	var journalMode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		t.Fatalf("查询日志模式失败: %v", err)
+	}
+	if strings.ToLower(journalMode) != "wal" {
+		t.Errorf("日志模式应为WAL，实际为 %s", journalMode)
+	}
+	var busyTimeout int
+	if err := db.QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout); err != nil {
+		t.Fatalf("查询忙等待超时失败: %v", err)
+	}
+	if busyTimeout != 5000 {
+		t.Errorf("忙等待超时应为5000毫秒，实际为 %d", busyTimeout)
+	}
+
+	if _, err := db.Exec("CREATE TABLE events (created_at DATETIME)"); err != nil {
+		t.Fatalf("创建表失败: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	if _, err := db.Exec("INSERT INTO events (created_at) VALUES (?)", now); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	var createdAt time.Time
+	if err := db.QueryRow("SELECT created_at FROM events").Scan(&createdAt); err != nil {
+		t.Fatalf("读取失败: %v", err)
+	}
+	if !createdAt.Equal(now) {
+		t.Errorf("读回的时间 %v 与写入的 %v 不一致", createdAt, now)
+	}
+}
+
+// TestOpenReadOnly 测试只读打开时可以查询但不能写入，文件名中的特殊字符不会被当作URI参数
+func TestOpenReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(filepath.Join(dir, "source.db"))
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE items (name TEXT); INSERT INTO items VALUES ('a')"); err != nil {
+		t.Fatalf("初始化数据失败: %v", err)
+	}
+	// 关闭时合并WAL，之后只需要重命名数据库文件本身
+	db.Close()
+	path := filepath.Join(dir, "backup?#%.db")
+	if err := os.Rename(filepath.Join(dir, "source.db"), path); err != nil {
+		t.Fatalf("重命名数据库文件失败: %v", err)
+	}
+
+	ro, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("只读打开失败: %v", err)
+	}
+	defer ro.Close()
+
+	var count int
+	if err := ro.QueryRow("SELECT COUNT(*) FROM items").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("只读连接应能查询原有数据，count=%d err=%v", count, err)
+	}
+	if _, err := ro.Exec("INSERT INTO items VALUES ('b')"); err == nil {
+		t.Error("只读连接不应允许写入")
+	}
+}
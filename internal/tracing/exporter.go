@@ -0,0 +1,270 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// exporterQueueSize 待导出span队列容量，队列满时丢弃新span，避免拖慢请求
+const exporterQueueSize = 4096
+
+// exporter 批量将span以 OTLP/HTTP JSON 格式发送到收集器
+type exporter struct {
+	endpoint       string
+	headers        map[string]string
+	serviceName    string
+	serviceVersion string
+	batchSize      int
+	client         *http.Client
+
+	queue    chan *Span
+	done     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+
+	mu          sync.Mutex
+	dropped     int
+	lastFailure time.Time
+}
+
+// newExporter 创建导出器并启动后台发送协程
+func newExporter(cfg Config) *exporter {
+	e := &exporter{
+		endpoint:       tracesEndpoint(cfg.Endpoint),
+		headers:        cfg.Headers,
+		serviceName:    cfg.ServiceName,
+		serviceVersion: cfg.ServiceVersion,
+		batchSize:      cfg.BatchSize,
+		client:         &http.Client{Timeout: 10 * time.Second},
+		queue:          make(chan *Span, exporterQueueSize),
+		done:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}
+	go e.run(cfg.FlushInterval)
+	return e
+}
+
+// tracesEndpoint 只配置了收集器地址时补全 OTLP/HTTP 的默认路径 /v1/traces
+func tracesEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return u.String()
+}
+
+// enqueue 将结束的span放入导出队列
+func (e *exporter) enqueue(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+		e.mu.Lock()
+		e.dropped++
+		e.mu.Unlock()
+	}
+}
+
+// run 按批量大小或定时发送队列中的span
+func (e *exporter) run(interval time.Duration) {
+	defer close(e.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, e.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		e.send(batch)
+		batch = make([]*Span, 0, e.batchSize)
+	}
+
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= e.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.done:
+			for {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// shutdown 停止导出器并发送剩余的span
+func (e *exporter) shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() { close(e.done) })
+	select {
+	case <-e.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// send 发送一批span，失败只记录日志（每分钟最多一次），不重试
+func (e *exporter) send(batch []*Span) {
+	body, err := json.Marshal(e.buildPayload(batch))
+	if err != nil {
+		log.Printf("链路追踪数据序列化失败: %v", err)
+		return
+	}
+
+	if err := e.post(body); err != nil {
+		e.mu.Lock()
+		shouldLog := time.Since(e.lastFailure) >= time.Minute
+		if shouldLog {
+			e.lastFailure = time.Now()
+		}
+		dropped := e.dropped
+		e.dropped = 0
+		e.mu.Unlock()
+		if shouldLog {
+			log.Printf("链路追踪导出失败（%d 个span，队列已满丢弃 %d 个）: %v", len(batch), dropped, err)
+		}
+	}
+}
+
+// post 将序列化后的数据发送到收集器
+func (e *exporter) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create export request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send spans: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// otlpKeyValue OTLP属性
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// otlpSpan OTLP span，JSON编码中 traceId/spanId 使用十六进制字符串
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+// otlpStatus OTLP span状态，code 2 表示错误
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// buildPayload 构造 ExportTraceServiceRequest
+func (e *exporter) buildPayload(batch []*Span) map[string]interface{} {
+	resourceAttributes := []otlpKeyValue{keyValue("service.name", e.serviceName)}
+	if e.serviceVersion != "" {
+		resourceAttributes = append(resourceAttributes, keyValue("service.version", e.serviceVersion))
+	}
+
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, span := range batch {
+		spans = append(spans, span.toOTLP())
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{"attributes": resourceAttributes},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "turnsapi"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+}
+
+// toOTLP 转换为OTLP格式
+func (s *Span) toOTLP() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := otlpSpan{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parentSpanID != [8]byte{} {
+		out.ParentSpanID = hex.EncodeToString(s.parentSpanID[:])
+	}
+	for _, attr := range s.attributes {
+		out.Attributes = append(out.Attributes, keyValue(attr.key, attr.value))
+	}
+	if s.statusError {
+		out.Status = &otlpStatus{Code: 2, Message: s.statusMessage}
+	}
+	return out
+}
+
+// keyValue 按值类型生成OTLP属性
+func keyValue(key string, value interface{}) otlpKeyValue {
+	var v map[string]interface{}
+	switch val := value.(type) {
+	case string:
+		v = map[string]interface{}{"stringValue": val}
+	case bool:
+		v = map[string]interface{}{"boolValue": val}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(int64(val), 10)}
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(val, 10)}
+	case float64:
+		v = map[string]interface{}{"doubleValue": val}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(val)}
+	}
+	return otlpKeyValue{Key: key, Value: v}
+}
@@ -0,0 +1,292 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SpanKind span类型，取值与OTLP一致
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// traceparentHeader W3C Trace Context 请求头
+const traceparentHeader = "traceparent"
+
+// Config 链路追踪设置
+type Config struct {
+	Endpoint       string            // OTLP/HTTP 接收地址，如 http://otel-collector:4318/v1/traces
+	Headers        map[string]string // 导出请求附带的请求头，如认证令牌
+	ServiceName    string
+	ServiceVersion string
+	SampleRatio    float64 // 没有上游采样决定时的采样比例，0~1
+	BatchSize      int
+	FlushInterval  time.Duration
+}
+
+// Tracer 创建span并将结束的span交给导出器
+type Tracer struct {
+	sampleRatio float64
+	exporter    *exporter
+}
+
+// globalTracer 当前生效的追踪器，未启用时为空，所有span操作都是空操作
+var globalTracer atomic.Pointer[Tracer]
+
+// Init 启用链路追踪，返回的函数在退出前调用以导出剩余的span
+func Init(cfg Config) func(context.Context) error {
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "turnsapi"
+	}
+	if cfg.SampleRatio <= 0 || cfg.SampleRatio > 1 {
+		cfg.SampleRatio = 1
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 512
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+
+	tracer := &Tracer{
+		sampleRatio: cfg.SampleRatio,
+		exporter:    newExporter(cfg),
+	}
+	globalTracer.Store(tracer)
+	return func(ctx context.Context) error {
+		globalTracer.CompareAndSwap(tracer, nil)
+		return tracer.exporter.shutdown(ctx)
+	}
+}
+
+// Enabled 是否启用了链路追踪
+func Enabled() bool {
+	return globalTracer.Load() != nil
+}
+
+// Span 一段被追踪的操作，nil span 的所有方法都是空操作
+type Span struct {
+	tracer       *Tracer
+	traceID      [16]byte
+	spanID       [8]byte
+	parentSpanID [8]byte
+	sampled      bool
+	name         string
+	kind         SpanKind
+	start        time.Time
+
+	mu            sync.Mutex
+	end           time.Time
+	ended         bool
+	attributes    []attribute
+	statusError   bool
+	statusMessage string
+}
+
+// attribute span属性
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// spanContextKey span在 context 中的键
+type spanContextKey struct{}
+
+// remoteParent 从传入请求头解析出的上游span
+type remoteParent struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+// remoteParentKey 上游span在 context 中的键
+type remoteParentKey struct{}
+
+// SpanFromContext 获取 context 中当前的span
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// ContextWithSpan 将span写入 context，用于把请求的span传递到不继承请求 context 的上游调用
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// Extract 从传入请求的 traceparent 请求头中恢复上游的追踪上下文
+func Extract(ctx context.Context, header http.Header) context.Context {
+	parent, ok := parseTraceparent(header.Get(traceparentHeader))
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteParentKey{}, parent)
+}
+
+// Inject 将 context 中的追踪上下文写入 traceparent 请求头
+func Inject(ctx context.Context, header http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	flags := "00"
+	if span.sampled {
+		flags = "01"
+	}
+	header.Set(traceparentHeader, fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(span.traceID[:]), hex.EncodeToString(span.spanID[:]), flags))
+}
+
+// Start 创建子span，context 中没有span时创建新的追踪（或继承 Extract 恢复的上游追踪）
+// 未启用链路追踪时返回原 context 和 nil span
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	tracer := globalTracer.Load()
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	span := &Span{tracer: tracer, name: name, kind: kind, start: time.Now()}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.traceID = parent.traceID
+		span.parentSpanID = parent.spanID
+		span.sampled = parent.sampled
+	} else if remote, ok := ctx.Value(remoteParentKey{}).(remoteParent); ok {
+		span.traceID = remote.traceID
+		span.parentSpanID = remote.spanID
+		span.sampled = remote.sampled
+	} else {
+		randomBytes(span.traceID[:])
+		span.sampled = tracer.sample()
+	}
+	randomBytes(span.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// sample 按采样比例决定新追踪是否导出
+func (t *Tracer) sample() bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return false
+	}
+	return float64(n.Int64()) < t.sampleRatio*1_000_000
+}
+
+// SetAttribute 设置span属性，值支持字符串、整数、浮点数和布尔值
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.attributes {
+		if s.attributes[i].key == key {
+			s.attributes[i].value = value
+			return
+		}
+	}
+	s.attributes = append(s.attributes, attribute{key: key, value: value})
+}
+
+// RecordError 将span标记为失败并记录错误信息
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.SetError(err.Error())
+}
+
+// SetError 将span标记为失败
+func (s *Span) SetError(message string) {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statusError = true
+	s.statusMessage = message
+}
+
+// End 结束span并交给导出器，重复调用无效
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.sampled {
+		s.tracer.exporter.enqueue(s)
+	}
+}
+
+// TraceID 追踪ID（十六进制），nil span 返回空字符串
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// parseTraceparent 解析 W3C traceparent：version-traceid-spanid-flags
+func parseTraceparent(value string) (remoteParent, bool) {
+	var parent remoteParent
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return parent, false
+	}
+	if _, err := hex.Decode(parent.traceID[:], []byte(parts[1])); err != nil || parent.traceID == [16]byte{} {
+		return parent, false
+	}
+	if _, err := hex.Decode(parent.spanID[:], []byte(parts[2])); err != nil || parent.spanID == [8]byte{} {
+		return parent, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return parent, false
+	}
+	parent.sampled = flags[0]&0x01 == 0x01
+	return parent, true
+}
+
+// randomBytes 填充随机字节，保证结果不全为0
+func randomBytes(b []byte) {
+	for {
+		if _, err := rand.Read(b); err != nil {
+			// 随机数不可用时退回时间戳，ID只需在追踪内唯一
+			now := time.Now().UnixNano()
+			for i := range b {
+				b[i] = byte(now >> (8 * (i % 8)))
+			}
+		}
+		for _, v := range b {
+			if v != 0 {
+				return
+			}
+		}
+	}
+}